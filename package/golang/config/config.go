@@ -16,6 +16,9 @@ type Config struct {
 	Monitoring MonitoringConfig `json:"monitoring"`
 	Quotas     QuotaConfig      `json:"quotas"`
 	Policies   PolicyConfig     `json:"policies"`
+	Storage    StorageConfig    `json:"storage"`
+	Events     EventsConfig     `json:"events"`
+	Scheduling SchedulingConfig `json:"scheduling"`
 }
 
 // DatabaseConfig defines database connection settings
@@ -47,6 +50,64 @@ type RedisConfig struct {
 	WriteTimeout time.Duration `json:"write_timeout"`
 }
 
+// StorageConfig defines where raw messages and attachments are stored.
+// Mailboxes are kept in Postgres or, with the maildir backend, as Maildir++
+// directories under MaildirPath. Tenants listed in EncryptedTenants, or every tenant when the list is
+// empty, are encrypted at rest with keys derived from
+// SecurityConfig.EncryptionKey.
+type StorageConfig struct {
+	Backend          string          `json:"backend"` // filesystem or s3
+	Path             string          `json:"path"`    // root directory of the filesystem backend
+	SpoolDir         string          `json:"spool_dir"`
+	S3               S3StorageConfig `json:"s3"`
+	EncryptAtRest    bool            `json:"encrypt_at_rest"`
+	EncryptedTenants []string        `json:"encrypted_tenants"`
+	GCInterval       time.Duration   `json:"gc_interval"`
+	GCGracePeriod    time.Duration   `json:"gc_grace_period"`
+	Mailbox          string          `json:"mailbox"` // postgres or maildir
+	MaildirPath      string          `json:"maildir_path"`
+}
+
+// EventsConfig defines how the outbox relay delivers domain events.
+// Deliveries are retried with exponential backoff from RetryDelay up to
+// MaxRetryDelay and dead-lettered after MaxAttempts.
+type EventsConfig struct {
+	PollInterval  time.Duration `json:"poll_interval"`
+	BatchSize     int           `json:"batch_size"`
+	MaxAttempts   int           `json:"max_attempts"`
+	RetryDelay    time.Duration `json:"retry_delay"`
+	MaxRetryDelay time.Duration `json:"max_retry_delay"`
+	Lease         time.Duration `json:"lease"`
+	Retention     time.Duration `json:"retention"` // delivered entries are removed after this, 0 keeps them
+}
+
+// SchedulingConfig defines how scheduled sends are dispatched. A send
+// is leased to one dispatcher for Lease while it is sent, retried from
+// RetryDelay up to MaxRetryDelay and failed after MaxAttempts.
+type SchedulingConfig struct {
+	PollInterval  time.Duration `json:"poll_interval"`
+	BatchSize     int           `json:"batch_size"`
+	MaxAttempts   int           `json:"max_attempts"`
+	RetryDelay    time.Duration `json:"retry_delay"`
+	MaxRetryDelay time.Duration `json:"max_retry_delay"`
+	Lease         time.Duration `json:"lease"`
+	MaxUndoWindow time.Duration `json:"max_undo_window"`
+	MaxDelay      time.Duration `json:"max_delay"` // how far ahead a send may be scheduled, 0 for no limit
+	Retention     time.Duration `json:"retention"` // completed sends are removed after this, 0 keeps them
+}
+
+// S3StorageConfig defines an S3-compatible bucket, such as MinIO
+type S3StorageConfig struct {
+	Endpoint  string        `json:"endpoint"`
+	Region    string        `json:"region"`
+	Bucket    string        `json:"bucket"`
+	AccessKey string        `json:"access_key"`
+	SecretKey string        `json:"secret_key"`
+	Prefix    string        `json:"prefix"`
+	PathStyle bool          `json:"path_style"`
+	Timeout   time.Duration `json:"timeout"`
+}
+
 // SMTPConfig defines SMTP server settings
 type SMTPConfig struct {
 	Host        string        `json:"host"`
@@ -83,14 +144,78 @@ type SecurityConfig struct {
 
 // RoutingConfig defines routing and delivery settings
 type RoutingConfig struct {
-	MaxHops         int           `json:"max_hops"`
-	MaxMessageSize  int64         `json:"max_message_size"`
-	DeliveryTimeout time.Duration `json:"delivery_timeout"`
-	RetryAttempts   int           `json:"retry_attempts"`
-	RetryDelay      time.Duration `json:"retry_delay"`
-	EnableSPF       bool          `json:"enable_spf"`
-	EnableDKIM      bool          `json:"enable_dkim"`
-	EnableDMARC     bool          `json:"enable_dmarc"`
+	MaxHops         int            `json:"max_hops"`
+	MaxMessageSize  int64          `json:"max_message_size"`
+	DeliveryTimeout time.Duration  `json:"delivery_timeout"`
+	RetryAttempts   int            `json:"retry_attempts"`
+	RetryDelay      time.Duration  `json:"retry_delay"`
+	EnableSPF       bool           `json:"enable_spf"`
+	EnableDKIM      bool           `json:"enable_dkim"`
+	EnableDMARC     bool           `json:"enable_dmarc"`
+	DKIM            DKIMConfig     `json:"dkim"`
+	ARC             ARCConfig      `json:"arc"`
+	Outbound        OutboundConfig `json:"outbound"`
+}
+
+// DKIMConfig defines DKIM key generation and rotation. Private keys are
+// encrypted with SecurityConfig.EncryptionKey.
+type DKIMConfig struct {
+	Algorithm          string        `json:"algorithm"` // rsa or ed25519
+	KeyBits            int           `json:"key_bits"`
+	SelectorPrefix     string        `json:"selector_prefix"`
+	RotationInterval   time.Duration `json:"rotation_interval"`
+	GracePeriod        time.Duration `json:"grace_period"`
+	PropagationTimeout time.Duration `json:"propagation_timeout"`
+	CheckInterval      time.Duration `json:"check_interval"`
+}
+
+// ARCConfig defines ARC sealing of forwarded and list traffic and the
+// sealers trusted to override a DMARC failure on inbound mail
+type ARCConfig struct {
+	AuthServID     string   `json:"authserv_id"`     // defaults to the sealing domain
+	TrustedSealers []string `json:"trusted_sealers"` // sealing domains, subdomains included
+	SignedHeaders  []string `json:"signed_headers"`  // empty uses the SDK default set
+}
+
+// OutboundConfig defines outbound delivery settings. The default limits
+// apply to destinations without a destination policy; retries use the
+// routing RetryAttempts and RetryDelay. Each instance leases up to MaxHeld
+// queued jobs for Lease while it delivers them.
+type OutboundConfig struct {
+	HeloName                        string            `json:"helo_name"`
+	Port                            int               `json:"port"`
+	CommandTimeout                  time.Duration     `json:"command_timeout"`
+	IdleTimeout                     time.Duration     `json:"idle_timeout"`
+	MXCacheTTL                      time.Duration     `json:"mx_cache_ttl"`
+	MaxRetryDelay                   time.Duration     `json:"max_retry_delay"`
+	Lease                           time.Duration     `json:"lease"`
+	MaxHeld                         int               `json:"max_held"`
+	DefaultMaxConnections           int               `json:"default_max_connections"`
+	DefaultMaxMessagesPerConnection int               `json:"default_max_messages_per_connection"`
+	DefaultMaxMessagesPerMinute     int               `json:"default_max_messages_per_minute"`
+	DefaultBackoff                  time.Duration     `json:"default_backoff"`
+	DefaultMaxBackoff               time.Duration     `json:"default_max_backoff"`
+	WarmupSchedule                  []WarmupStep      `json:"warmup_schedule"` // empty uses the SDK default ramp
+	TLS                             OutboundTLSConfig `json:"tls"`
+}
+
+// OutboundTLSConfig defines MTA-STS and DANE enforcement for outbound
+// delivery. DANE needs DNSServers pointing at a DNSSEC-validating resolver.
+type OutboundTLSConfig struct {
+	EnableMTASTS       bool          `json:"enable_mta_sts"`
+	EnableDANE         bool          `json:"enable_dane"`
+	DNSServers         []string      `json:"dns_servers"`
+	DNSTimeout         time.Duration `json:"dns_timeout"`
+	PolicyFetchTimeout time.Duration `json:"policy_fetch_timeout"`
+	ReportOrganization string        `json:"report_organization"`
+	ReportContact      string        `json:"report_contact"`
+}
+
+// WarmupStep caps the daily volume of a warming IP address from Day until
+// the next step. A DailyLimit of 0 ends the warm-up.
+type WarmupStep struct {
+	Day        int   `json:"day"`
+	DailyLimit int64 `json:"daily_limit"`
 }
 
 // MonitoringConfig defines monitoring settings
@@ -110,16 +235,80 @@ type QuotaConfig struct {
 	DefaultDomainMaxUsers  int           `json:"default_domain_max_users"`
 	EnableQuotaEnforcement bool          `json:"enable_quota_enforcement"`
 	QuotaCheckInterval     time.Duration `json:"quota_check_interval"`
+	Sending                SendingConfig `json:"sending"`
+}
+
+// SendingConfig defines outbound rate limit and sender reputation settings
+type SendingConfig struct {
+	EnableRateLimits        bool              `json:"enable_rate_limits"`
+	Limits                  []RateLimitConfig `json:"limits"` // empty uses the SDK defaults
+	ReputationWindow        time.Duration     `json:"reputation_window"`
+	MinReputationSample     int64             `json:"min_reputation_sample"`
+	MaxBounceRate           float64           `json:"max_bounce_rate"`
+	MaxUnknownRecipientRate float64           `json:"max_unknown_recipient_rate"`
+	SuspendAfterViolations  int64             `json:"suspend_after_violations"`
+	ViolationWindow         time.Duration     `json:"violation_window"`
+	SuspensionDuration      time.Duration     `json:"suspension_duration"`
+	PruneInterval           time.Duration     `json:"prune_interval"` // how often expired counters are removed, 0 is hourly
+	AdminRecipients         []string          `json:"admin_recipients"`
+	AlertFrom               string            `json:"alert_from"`
+}
+
+// RateLimitConfig defines one sliding window limit. Scope is one of
+// ACCOUNT, DOMAIN, TENANT or IP.
+type RateLimitConfig struct {
+	Scope         string        `json:"scope"`
+	Window        time.Duration `json:"window"`
+	MaxMessages   int64         `json:"max_messages"`
+	MaxRecipients int64         `json:"max_recipients"`
 }
 
 // PolicyConfig defines policy settings
 type PolicyConfig struct {
-	EnableSpamFilter    bool     `json:"enable_spam_filter"`
-	EnableVirusScan     bool     `json:"enable_virus_scan"`
-	SpamThreshold       float64  `json:"spam_threshold"`
-	AllowedFileTypes    []string `json:"allowed_file_types"`
-	MaxAttachmentSize   int64    `json:"max_attachment_size"`
-	EnableContentFilter bool     `json:"enable_content_filter"`
+	EnableSpamFilter    bool             `json:"enable_spam_filter"`
+	EnableVirusScan     bool             `json:"enable_virus_scan"`
+	SpamThreshold       float64          `json:"spam_threshold"`
+	AllowedFileTypes    []string         `json:"allowed_file_types"`
+	AllowedMimeTypes    []string         `json:"allowed_mime_types"` // detected types, "image/*" wildcards; empty allows all
+	MaxAttachmentSize   int64            `json:"max_attachment_size"`
+	EnableContentFilter bool             `json:"enable_content_filter"`
+	Antivirus           ClamdConfig      `json:"antivirus"`
+	Quarantine          QuarantineConfig `json:"quarantine"`
+	Attachments         AttachmentConfig `json:"attachments"`
+}
+
+// ClamdConfig defines the clamd-compatible virus scanner settings
+type ClamdConfig struct {
+	Network     string        `json:"network"` // tcp or unix
+	Address     string        `json:"address"`
+	Timeout     time.Duration `json:"timeout"`
+	ChunkSize   int           `json:"chunk_size"`
+	MaxScanSize int64         `json:"max_scan_size"`
+	FailOpen    bool          `json:"fail_open"`
+}
+
+// QuarantineConfig defines quarantine retention and digest settings
+type QuarantineConfig struct {
+	Retention      time.Duration `json:"retention"`
+	DigestInterval time.Duration `json:"digest_interval"`
+	TokenSecret    string        `json:"token_secret"`
+	TokenTTL       time.Duration `json:"token_ttl"`
+	ReleaseURL     string        `json:"release_url"`
+	DigestFrom     string        `json:"digest_from"`
+}
+
+// AttachmentConfig defines attachment inspection settings. Actions take
+// policy action names such as BLOCK, QUARANTINE or TAG.
+type AttachmentConfig struct {
+	BlockedExtensions  []string `json:"blocked_extensions"` // empty uses the SDK default list
+	MaxArchiveDepth    int      `json:"max_archive_depth"`
+	MaxArchiveSize     int64    `json:"max_archive_size"`
+	MaxArchiveEntries  int      `json:"max_archive_entries"`
+	BlockedAction      string   `json:"blocked_action"`
+	MismatchAction     string   `json:"mismatch_action"`
+	EncryptedAction    string   `json:"encrypted_action"`
+	MacroAction        string   `json:"macro_action"`
+	ArchiveLimitAction string   `json:"archive_limit_action"`
 }
 
 // DefaultConfig returns a default configuration
@@ -177,6 +366,39 @@ func DefaultConfig() *Config {
 			EnableSPF:       true,
 			EnableDKIM:      true,
 			EnableDMARC:     true,
+			DKIM: DKIMConfig{
+				Algorithm:          "rsa",
+				KeyBits:            2048,
+				SelectorPrefix:     "mail",
+				RotationInterval:   90 * 24 * time.Hour,
+				GracePeriod:        7 * 24 * time.Hour,
+				PropagationTimeout: 72 * time.Hour,
+				CheckInterval:      15 * time.Minute,
+			},
+			ARC: ARCConfig{
+				TrustedSealers: []string{},
+			},
+			Outbound: OutboundConfig{
+				HeloName:                        "localhost",
+				Port:                            25,
+				CommandTimeout:                  5 * time.Minute,
+				IdleTimeout:                     30 * time.Second,
+				MXCacheTTL:                      5 * time.Minute,
+				MaxRetryDelay:                   4 * time.Hour,
+				Lease:                           10 * time.Minute,
+				MaxHeld:                         1000,
+				DefaultMaxConnections:           5,
+				DefaultMaxMessagesPerConnection: 100,
+				DefaultBackoff:                  1 * time.Minute,
+				DefaultMaxBackoff:               30 * time.Minute,
+				TLS: OutboundTLSConfig{
+					EnableMTASTS:       true,
+					EnableDANE:         false,
+					DNSServers:         []string{"127.0.0.1:53"},
+					DNSTimeout:         5 * time.Second,
+					PolicyFetchTimeout: 60 * time.Second,
+				},
+			},
 		},
 		Monitoring: MonitoringConfig{
 			EnableMetrics:       true,
@@ -191,6 +413,16 @@ func DefaultConfig() *Config {
 			DefaultDomainMaxUsers:  50,
 			EnableQuotaEnforcement: true,
 			QuotaCheckInterval:     1 * time.Hour,
+			Sending: SendingConfig{
+				EnableRateLimits:        true,
+				ReputationWindow:        24 * time.Hour,
+				MinReputationSample:     50,
+				MaxBounceRate:           0.10,
+				MaxUnknownRecipientRate: 0.05,
+				SuspendAfterViolations:  20,
+				ViolationWindow:         1 * time.Hour,
+				AlertFrom:               "postmaster@localhost",
+			},
 		},
 		Policies: PolicyConfig{
 			EnableSpamFilter:    true,
@@ -199,6 +431,66 @@ func DefaultConfig() *Config {
 			AllowedFileTypes:    []string{".pdf", ".doc", ".docx", ".txt", ".jpg", ".png"},
 			MaxAttachmentSize:   10 * 1024 * 1024, // 10MB
 			EnableContentFilter: true,
+			Antivirus: ClamdConfig{
+				Network:     "tcp",
+				Address:     "localhost:3310",
+				Timeout:     30 * time.Second,
+				ChunkSize:   64 * 1024,
+				MaxScanSize: 25 * 1024 * 1024, // 25MB
+				FailOpen:    false,
+			},
+			Quarantine: QuarantineConfig{
+				Retention:      30 * 24 * time.Hour,
+				DigestInterval: 24 * time.Hour,
+				TokenTTL:       7 * 24 * time.Hour,
+				ReleaseURL:     "http://localhost:8080/api/v1/quarantine/release",
+				DigestFrom:     "quarantine@localhost",
+			},
+			Attachments: AttachmentConfig{
+				MaxArchiveDepth:    3,
+				MaxArchiveSize:     100 * 1024 * 1024, // 100MB
+				MaxArchiveEntries:  1000,
+				BlockedAction:      "BLOCK",
+				MismatchAction:     "QUARANTINE",
+				EncryptedAction:    "QUARANTINE",
+				MacroAction:        "QUARANTINE",
+				ArchiveLimitAction: "QUARANTINE",
+			},
+		},
+		Storage: StorageConfig{
+			Backend:          "filesystem",
+			Path:             "./data/blobs",
+			EncryptAtRest:    false,
+			EncryptedTenants: []string{},
+			GCInterval:       1 * time.Hour,
+			GCGracePeriod:    24 * time.Hour,
+			Mailbox:          "postgres",
+			MaildirPath:      "./data/maildir",
+			S3: S3StorageConfig{
+				Region:    "us-east-1",
+				PathStyle: true,
+				Timeout:   5 * time.Minute,
+			},
+		},
+		Events: EventsConfig{
+			PollInterval:  1 * time.Second,
+			BatchSize:     100,
+			MaxAttempts:   10,
+			RetryDelay:    1 * time.Second,
+			MaxRetryDelay: 1 * time.Hour,
+			Lease:         1 * time.Minute,
+			Retention:     7 * 24 * time.Hour,
+		},
+		Scheduling: SchedulingConfig{
+			PollInterval:  1 * time.Second,
+			BatchSize:     100,
+			MaxAttempts:   5,
+			RetryDelay:    30 * time.Second,
+			MaxRetryDelay: 15 * time.Minute,
+			Lease:         1 * time.Minute,
+			MaxUndoWindow: 30 * time.Second,
+			MaxDelay:      365 * 24 * time.Hour,
+			Retention:     30 * 24 * time.Hour,
 		},
 	}
 }
@@ -238,6 +530,30 @@ func (c *Config) Validate() error {
 	if c.Security.PasswordMinLength < 6 {
 		return fmt.Errorf("password minimum length must be at least 6")
 	}
+	if c.Storage.Backend != "filesystem" && c.Storage.Backend != "s3" {
+		return fmt.Errorf("storage backend must be filesystem or s3")
+	}
+	if c.Storage.Mailbox != "postgres" && c.Storage.Mailbox != "maildir" {
+		return fmt.Errorf("mailbox backend must be postgres or maildir")
+	}
+	if c.Storage.Mailbox == "maildir" && c.Storage.MaildirPath == "" {
+		return fmt.Errorf("maildir path is required for the maildir mailbox backend")
+	}
+	if c.Storage.EncryptAtRest && c.Security.EncryptionKey == "" {
+		return fmt.Errorf("encryption key is required to encrypt storage at rest")
+	}
+	if c.Events.MaxAttempts < 0 {
+		return fmt.Errorf("events max attempts must not be negative")
+	}
+	if c.Events.Lease < 0 {
+		return fmt.Errorf("events lease must not be negative")
+	}
+	if c.Scheduling.MaxAttempts < 0 {
+		return fmt.Errorf("scheduling max attempts must not be negative")
+	}
+	if c.Scheduling.Lease < 0 || c.Scheduling.MaxUndoWindow < 0 || c.Scheduling.MaxDelay < 0 {
+		return fmt.Errorf("scheduling lease, undo window and delay must not be negative")
+	}
 	return nil
 }
 
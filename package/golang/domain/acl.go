@@ -0,0 +1,241 @@
+package domain
+
+import (
+	"strings"
+	"time"
+)
+
+// Right is an access right on a folder, as defined by the IMAP ACL
+// extension (RFC 4314)
+type Right byte
+
+const (
+	RightLookup         Right = 'l' // see the folder in listings
+	RightRead           Right = 'r' // open the folder and read its messages
+	RightSeen           Right = 's' // keep the seen flag
+	RightWrite          Right = 'w' // set other flags and labels
+	RightInsert         Right = 'i' // add messages, by append, copy or move
+	RightPost           Right = 'p' // send mail to the folder
+	RightCreate         Right = 'k' // create subfolders
+	RightDeleteFolder   Right = 'x' // delete or rename the folder
+	RightDeleteMessages Right = 't' // mark messages deleted, move them out
+	RightExpunge        Right = 'e' // remove deleted messages
+	RightAdmin          Right = 'a' // change the rights of the folder
+)
+
+// Rights is a set of rights in the order of AllRights, such as "lrs"
+type Rights string
+
+// AllRights holds every right; the owner of an account holds them on all
+// its folders
+const AllRights Rights = "lrswipkxtea"
+
+var rightNames = map[Right]string{
+	RightLookup:         "lookup",
+	RightRead:           "read",
+	RightSeen:           "seen",
+	RightWrite:          "write",
+	RightInsert:         "insert",
+	RightPost:           "post",
+	RightCreate:         "create",
+	RightDeleteFolder:   "delete",
+	RightDeleteMessages: "delete_messages",
+	RightExpunge:        "expunge",
+	RightAdmin:          "admin",
+}
+
+// ParseRights parses the rights of an IMAP SETACL command. The obsolete
+// rights of RFC 2086 are accepted: "c" grants create and delete, "d"
+// grants the three delete rights.
+func ParseRights(value string) (Rights, bool) {
+	rights := []Right{}
+	for i := 0; i < len(value); i++ {
+		switch r := Right(value[i]); {
+		case r == 'c':
+			rights = append(rights, RightCreate, RightDeleteFolder)
+		case r == 'd':
+			rights = append(rights, RightDeleteFolder, RightDeleteMessages, RightExpunge)
+		case strings.IndexByte(string(AllRights), byte(r)) >= 0:
+			rights = append(rights, r)
+		default:
+			return "", false
+		}
+	}
+	return NewRights(rights...), true
+}
+
+// ParseRightNames parses rights given by name, such as "read"
+func ParseRightNames(names []string) (Rights, bool) {
+	rights := []Right{}
+	for _, name := range names {
+		found := false
+		for right, rightName := range rightNames {
+			if rightName == name {
+				rights = append(rights, right)
+				found = true
+			}
+		}
+		if !found {
+			return "", false
+		}
+	}
+	return NewRights(rights...), true
+}
+
+// NewRights returns the set of the given rights
+func NewRights(rights ...Right) Rights {
+	var b strings.Builder
+	for i := 0; i < len(AllRights); i++ {
+		for _, right := range rights {
+			if right == Right(AllRights[i]) {
+				b.WriteByte(AllRights[i])
+				break
+			}
+		}
+	}
+	return Rights(b.String())
+}
+
+// Has reports whether every given right is in the set
+func (r Rights) Has(rights ...Right) bool {
+	for _, right := range rights {
+		if strings.IndexByte(string(r), byte(right)) < 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// Union returns the rights in either set
+func (r Rights) Union(other Rights) Rights {
+	return NewRights(append(r.rights(), other.rights()...)...)
+}
+
+// Without returns the rights of the set that are not in other
+func (r Rights) Without(other Rights) Rights {
+	rights := []Right{}
+	for _, right := range r.rights() {
+		if !other.Has(right) {
+			rights = append(rights, right)
+		}
+	}
+	return NewRights(rights...)
+}
+
+// Names returns the names of the rights, such as "lookup" and "read"
+func (r Rights) Names() []string {
+	names := make([]string, 0, len(r))
+	for _, right := range r.rights() {
+		names = append(names, rightNames[right])
+	}
+	return names
+}
+
+func (r Rights) rights() []Right {
+	rights := make([]Right, 0, len(r))
+	for i := 0; i < len(r); i++ {
+		rights = append(rights, Right(r[i]))
+	}
+	return rights
+}
+
+// MailboxRights are the rights of a user on a JMAP Mailbox, its myRights
+// property (RFC 8621)
+type MailboxRights struct {
+	MayReadItems   bool `json:"mayReadItems"`
+	MayAddItems    bool `json:"mayAddItems"`
+	MayRemoveItems bool `json:"mayRemoveItems"`
+	MaySetSeen     bool `json:"maySetSeen"`
+	MaySetKeywords bool `json:"maySetKeywords"`
+	MayCreateChild bool `json:"mayCreateChild"`
+	MayRename      bool `json:"mayRename"`
+	MayDelete      bool `json:"mayDelete"`
+	MaySubmit      bool `json:"maySubmit"`
+}
+
+// Mailbox maps the rights to JMAP, as RFC 8621 maps them from IMAP
+func (r Rights) Mailbox() MailboxRights {
+	return MailboxRights{
+		MayReadItems:   r.Has(RightRead),
+		MayAddItems:    r.Has(RightInsert),
+		MayRemoveItems: r.Has(RightDeleteMessages, RightExpunge),
+		MaySetSeen:     r.Has(RightSeen),
+		MaySetKeywords: r.Has(RightWrite),
+		MayCreateChild: r.Has(RightCreate),
+		MayRename:      r.Has(RightDeleteFolder),
+		MayDelete:      r.Has(RightDeleteFolder),
+		MaySubmit:      r.Has(RightPost),
+	}
+}
+
+// ACLSubjectType defines who a folder ACL entry grants rights to
+type ACLSubjectType string
+
+const (
+	ACLSubjectUser   ACLSubjectType = "USER"
+	ACLSubjectGroup  ACLSubjectType = "GROUP"
+	ACLSubjectAnyone ACLSubjectType = "ANYONE" // every authenticated user
+)
+
+// FolderACL is an entry of the access control list of a folder. Rights
+// granted to a user, to the groups of the user and to anyone add up.
+type FolderACL struct {
+	ID          string
+	FolderID    string
+	AccountID   string
+	SubjectType ACLSubjectType
+	SubjectID   string // user or group ID, empty for anyone
+	Rights      Rights
+	GrantedBy   string // user ID
+	CreatedAt   time.Time
+	UpdatedAt   time.Time
+}
+
+// Group is a named set of users of a domain, such as a support team, that
+// folder rights can be granted to
+type Group struct {
+	ID          string
+	DomainID    string
+	Name        string // lower case, unique in the domain
+	Description string
+	CreatedAt   time.Time
+	UpdatedAt   time.Time
+}
+
+// GroupMember is the membership of a user in a group
+type GroupMember struct {
+	GroupID string
+	UserID  string
+	AddedAt time.Time
+}
+
+// ACLAuditAction defines a change recorded in the access control audit log
+type ACLAuditAction string
+
+const (
+	ACLAuditRightsSet      ACLAuditAction = "RIGHTS_SET"
+	ACLAuditRightsDeleted  ACLAuditAction = "RIGHTS_DELETED"
+	ACLAuditMemberAdded    ACLAuditAction = "MEMBER_ADDED"
+	ACLAuditMemberRemoved  ACLAuditAction = "MEMBER_REMOVED"
+	ACLAuditGroupCreated   ACLAuditAction = "GROUP_CREATED"
+	ACLAuditGroupDeleted   ACLAuditAction = "GROUP_DELETED"
+	ACLAuditMailboxCreated ACLAuditAction = "SHARED_MAILBOX_CREATED"
+)
+
+// ACLAuditEntry is an audit record of a change to who may access what:
+// folder rights, group membership and shared mailboxes. Entries outlive
+// the objects they name.
+type ACLAuditEntry struct {
+	ID          string
+	DomainID    string
+	AccountID   string // empty for group changes
+	FolderID    string // empty unless folder rights changed
+	GroupID     string // empty unless a group changed
+	Action      ACLAuditAction
+	SubjectType ACLSubjectType // who gained or lost access
+	SubjectID   string
+	OldRights   Rights
+	NewRights   Rights
+	ActorID     string // user ID
+	CreatedAt   time.Time
+}
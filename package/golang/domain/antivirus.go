@@ -0,0 +1,30 @@
+package domain
+
+import (
+	"time"
+)
+
+// VirusVerdict represents the outcome of scanning a message for malware
+type VirusVerdict struct {
+	MessageID string
+	AccountID string
+	Infected  bool
+	Skipped   bool
+	Findings  []VirusFinding
+	Action    PolicyAction
+	PolicyID  *string
+	Error     *string
+	ScannedAt time.Time
+}
+
+// VirusFinding represents a single infected part of a message
+type VirusFinding struct {
+	Target    string // "message" or the attachment filename
+	Signature string
+}
+
+// ScanResult represents the scanner response for a single stream
+type ScanResult struct {
+	Infected  bool
+	Signature string
+}
@@ -0,0 +1,37 @@
+package domain
+
+// ARCChainStatus is the chain validation status of RFC 8617 (the cv= value)
+type ARCChainStatus string
+
+const (
+	ARCChainNone ARCChainStatus = "none"
+	ARCChainPass ARCChainStatus = "pass"
+	ARCChainFail ARCChainStatus = "fail"
+)
+
+// ARCMaxInstances is the highest ARC set instance allowed on a message
+const ARCMaxInstances = 50
+
+// ARCHop is one ARC set of a validated chain
+type ARCHop struct {
+	Instance              int
+	Sealer                string // d= of the ARC-Seal
+	Selector              string // s= of the ARC-Seal
+	AuthenticationResults string // payload of the ARC-Authentication-Results header
+}
+
+// ARCValidation is the outcome of validating the ARC chain of a message.
+// Hops are ordered from the first (oldest) to the latest instance.
+type ARCValidation struct {
+	Status ARCChainStatus
+	Hops   []ARCHop
+	Reason string // why the chain failed, empty otherwise
+}
+
+// Latest returns the most recent hop, or nil when the message has no chain
+func (v *ARCValidation) Latest() *ARCHop {
+	if v == nil || len(v.Hops) == 0 {
+		return nil
+	}
+	return &v.Hops[len(v.Hops)-1]
+}
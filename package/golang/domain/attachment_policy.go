@@ -0,0 +1,32 @@
+package domain
+
+import (
+	"time"
+)
+
+// MessageDirection defines whether a message enters or leaves the system
+type MessageDirection string
+
+const (
+	DirectionInbound  MessageDirection = "INBOUND"
+	DirectionOutbound MessageDirection = "OUTBOUND"
+)
+
+// PolicyHit represents a single policy violation found in a message
+type PolicyHit struct {
+	Type        PolicyType
+	Rule        string
+	Target      string // attachment filename, or "archive/entry" for archive members
+	Description string
+	Action      PolicyAction
+}
+
+// AttachmentVerdict represents the outcome of applying attachment policies
+type AttachmentVerdict struct {
+	MessageID string
+	AccountID string
+	Direction MessageDirection
+	Hits      []PolicyHit
+	Action    PolicyAction // strongest action among the hits
+	CheckedAt time.Time
+}
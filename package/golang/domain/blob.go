@@ -0,0 +1,18 @@
+package domain
+
+import "time"
+
+// Blob is a stored object addressed by the SHA-256 of its content. Identical
+// content is stored once and shared through a reference count; objects of
+// tenants encrypted at rest are only shared within the tenant.
+type Blob struct {
+	ID         string // SHA-256 of the content, scoped to the tenant when encrypted
+	SHA256     string // hex digest of the plaintext
+	TenantID   string // empty for shared blobs
+	StorageKey string // backend object key, unique per stored copy
+	Size       int64  // plaintext size
+	Encrypted  bool
+	RefCount   int
+	CreatedAt  time.Time
+	UpdatedAt  time.Time // last reference change, used by garbage collection
+}
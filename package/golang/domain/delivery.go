@@ -0,0 +1,77 @@
+package domain
+
+import (
+	"strings"
+	"time"
+)
+
+// DestinationPolicy throttles outbound delivery to the mail servers whose
+// MX host names match MXPattern
+type DestinationPolicy struct {
+	ID                       string
+	Name                     string
+	MXPattern                string // exact host name or wildcard such as *.google.com
+	MaxConnections           int
+	MaxMessagesPerConnection int
+	MaxMessagesPerMinute     int // 0 means unlimited
+	Backoff                  time.Duration
+	MaxBackoff               time.Duration
+	IsActive                 bool
+	CreatedAt                time.Time
+	UpdatedAt                time.Time
+}
+
+// MatchesHost reports whether an MX host name matches the policy pattern. A
+// leading "*." matches any number of labels below the given domain.
+func (p *DestinationPolicy) MatchesHost(host string) bool {
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	pattern := strings.ToLower(strings.TrimSuffix(p.MXPattern, "."))
+	if strings.HasPrefix(pattern, "*.") {
+		return strings.HasSuffix(host, pattern[1:])
+	}
+	return host == pattern
+}
+
+// DeliveryJob is a message queued for delivery to the recipients of one
+// destination domain. LockedUntil is set while a delivery instance holds
+// the job.
+type DeliveryJob struct {
+	ID            string
+	MessageID     string
+	AccountID     string
+	DomainID      string
+	TenantID      string
+	Stream        string
+	From          string
+	Recipients    []string
+	Domain        string
+	Data          []byte
+	Attempts      int
+	LastError     string
+	QueuedAt      time.Time
+	NextAttemptAt time.Time
+	LockedUntil   *time.Time
+}
+
+// DeferralReason aggregates temporary failures reported by a destination
+type DeferralReason struct {
+	Code     int // SMTP reply code, 0 for connection and DNS failures
+	Message  string
+	Count    int
+	LastSeen time.Time
+}
+
+// DestinationQueueStatus is a live snapshot of the outbound queue for one
+// destination
+type DestinationQueueStatus struct {
+	Destination     string
+	PolicyID        string
+	PolicyName      string
+	Queued          int
+	Deferred        int
+	Active          int
+	OpenConnections int
+	SentLastMinute  int
+	BackoffUntil    *time.Time
+	DeferralReasons []DeferralReason
+}
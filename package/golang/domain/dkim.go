@@ -0,0 +1,96 @@
+package domain
+
+import (
+	"strings"
+	"time"
+)
+
+// DKIMAlgorithm is the key type of a DKIM key
+type DKIMAlgorithm string
+
+const (
+	DKIMAlgorithmRSA     DKIMAlgorithm = "rsa"     // rsa-sha256
+	DKIMAlgorithmEd25519 DKIMAlgorithm = "ed25519" // ed25519-sha256 (RFC 8463)
+)
+
+// DKIMKeyState tracks a key through rotation. A pending key is published but
+// not yet seen in DNS; only the active key signs; a retiring key stays
+// published so signatures made before the switch still verify.
+type DKIMKeyState string
+
+const (
+	DKIMKeyPending  DKIMKeyState = "PENDING"
+	DKIMKeyActive   DKIMKeyState = "ACTIVE"
+	DKIMKeyRetiring DKIMKeyState = "RETIRING"
+	DKIMKeyRetired  DKIMKeyState = "RETIRED"
+	DKIMKeyFailed   DKIMKeyState = "FAILED" // never propagated in time
+)
+
+// DKIMKey is a signing key of a domain under one selector. The private key
+// is only kept encrypted and is erased once the key is retired.
+type DKIMKey struct {
+	ID                  string
+	Domain              string
+	Selector            string
+	Algorithm           DKIMAlgorithm
+	KeyBits             int    // RSA modulus size, 0 for Ed25519
+	PublicKey           string // base64 value of the p= tag
+	EncryptedPrivateKey []byte // PKCS#8 DER sealed by the key encrypter
+	State               DKIMKeyState
+	CreatedAt           time.Time
+	PublishedAt         *time.Time
+	VerifiedAt          *time.Time // first time the record was seen in DNS
+	ActivatedAt         *time.Time
+	RetireAfter         *time.Time // end of the overlap once superseded
+	RetiredAt           *time.Time
+	UpdatedAt           time.Time
+}
+
+// RecordName returns the owner name of the key's TXT record
+func (k *DKIMKey) RecordName() string {
+	return k.Selector + "._domainkey." + strings.TrimSuffix(k.Domain, ".")
+}
+
+// RecordValue returns the TXT record value publishing the key
+func (k *DKIMKey) RecordValue() string {
+	return "v=DKIM1; k=" + string(k.Algorithm) + "; p=" + k.PublicKey
+}
+
+// IsPublished reports whether the key's record must be present in DNS
+func (k *DKIMKey) IsPublished() bool {
+	return k.State == DKIMKeyPending || k.State == DKIMKeyActive || k.State == DKIMKeyRetiring
+}
+
+// DKIMRotationAction is a step recorded in the rotation history
+type DKIMRotationAction string
+
+const (
+	DKIMActionGenerated   DKIMRotationAction = "GENERATED"
+	DKIMActionPublished   DKIMRotationAction = "PUBLISHED"
+	DKIMActionVerified    DKIMRotationAction = "VERIFIED"
+	DKIMActionActivated   DKIMRotationAction = "ACTIVATED"
+	DKIMActionSuperseded  DKIMRotationAction = "SUPERSEDED"
+	DKIMActionRetired     DKIMRotationAction = "RETIRED"
+	DKIMActionUnpublished DKIMRotationAction = "UNPUBLISHED"
+	DKIMActionFailed      DKIMRotationAction = "FAILED"
+)
+
+// DKIMRotationEntry is an audit record of a rotation step
+type DKIMRotationEntry struct {
+	ID       string
+	Domain   string
+	KeyID    string
+	Selector string
+	Action   DKIMRotationAction
+	Actor    string // user ID, or "system" for scheduled rotations
+	Detail   string
+	At       time.Time
+}
+
+// DKIMSigningKey is the decrypted active key of a domain
+type DKIMSigningKey struct {
+	Domain     string
+	Selector   string
+	Algorithm  DKIMAlgorithm
+	PrivateKey interface{} // *rsa.PrivateKey or ed25519.PrivateKey
+}
@@ -33,6 +33,17 @@ func NewBaseEvent(id, aggregateID, eventType string, data interface{}) *BaseEven
 	}
 }
 
+// RestoreBaseEvent rebuilds an event read back from storage
+func RestoreBaseEvent(id, aggregateID, eventType string, occurredAt time.Time, data interface{}) *BaseEvent {
+	return &BaseEvent{
+		id:          id,
+		aggregateID: aggregateID,
+		eventType:   eventType,
+		occurredAt:  occurredAt,
+		data:        data,
+	}
+}
+
 func (e *BaseEvent) ID() string            { return e.id }
 func (e *BaseEvent) AggregateID() string   { return e.aggregateID }
 func (e *BaseEvent) EventType() string     { return e.eventType }
@@ -41,16 +52,23 @@ func (e *BaseEvent) Data() interface{}     { return e.data }
 
 // Event types
 const (
-	EventTypeUserCreated     = "USER_CREATED"
-	EventTypeUserUpdated     = "USER_UPDATED"
-	EventTypeUserDeleted     = "USER_DELETED"
-	EventTypeDomainCreated   = "DOMAIN_CREATED"
-	EventTypeDomainUpdated   = "DOMAIN_UPDATED"
-	EventTypeDomainDeleted   = "DOMAIN_DELETED"
-	EventTypeMessageReceived = "MESSAGE_RECEIVED"
-	EventTypeMessageSent     = "MESSAGE_SENT"
-	EventTypeQuotaExceeded   = "QUOTA_EXCEEDED"
-	EventTypePolicyTriggered = "POLICY_TRIGGERED"
+	EventTypeUserCreated        = "USER_CREATED"
+	EventTypeUserUpdated        = "USER_UPDATED"
+	EventTypeUserDeleted        = "USER_DELETED"
+	EventTypeDomainCreated      = "DOMAIN_CREATED"
+	EventTypeDomainUpdated      = "DOMAIN_UPDATED"
+	EventTypeDomainDeleted      = "DOMAIN_DELETED"
+	EventTypeMessageReceived    = "MESSAGE_RECEIVED"
+	EventTypeMessageSent        = "MESSAGE_SENT"
+	EventTypeQuotaExceeded      = "QUOTA_EXCEEDED"
+	EventTypePolicyTriggered    = "POLICY_TRIGGERED"
+	EventTypeMessageQuarantined = "MESSAGE_QUARANTINED"
+	EventTypeMessageReleased    = "MESSAGE_RELEASED"
+	EventTypeMessageDelivered   = "MESSAGE_DELIVERED"
+	EventTypeMessageDeferred    = "MESSAGE_DEFERRED"
+	EventTypeMessageBounced     = "MESSAGE_BOUNCED"
+	EventTypeDKIMKeyActivated   = "DKIM_KEY_ACTIVATED"
+	EventTypeDKIMKeyRetired     = "DKIM_KEY_RETIRED"
 )
 
 // EventPublisher defines the contract for publishing events
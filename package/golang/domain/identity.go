@@ -0,0 +1,70 @@
+package domain
+
+import (
+	"net/mail"
+	"time"
+)
+
+// IdentityStatus defines whether an account may send as an identity
+type IdentityStatus string
+
+const (
+	IdentityStatusPending  IdentityStatus = "PENDING" // waits for a domain administrator
+	IdentityStatusApproved IdentityStatus = "APPROVED"
+	IdentityStatusRejected IdentityStatus = "REJECTED"
+)
+
+// Identity is an address an account sends as, with the display name,
+// reply-to address and signature used for it. Addresses other than the
+// account's own, such as aliases, are approved by an administrator of the
+// domain of the address.
+type Identity struct {
+	ID            string
+	AccountID     string
+	DomainID      string // domain of the address
+	Email         string // lower case
+	Name          string
+	ReplyTo       string
+	SignatureText string
+	SignatureHTML string
+	IsDefault     bool
+	Status        IdentityStatus
+	ReviewedBy    *string // user who approved or rejected the identity
+	ReviewedAt    *time.Time
+	CreatedAt     time.Time
+	UpdatedAt     time.Time
+}
+
+// IsApproved reports whether the identity may be sent as
+func (i *Identity) IsApproved() bool {
+	return i.Status == IdentityStatusApproved
+}
+
+// Address formats the identity address with its display name
+func (i *Identity) Address() string {
+	return (&mail.Address{Name: i.Name, Address: i.Email}).String()
+}
+
+// DelegationPermission defines how a delegate sends for a mailbox
+type DelegationPermission string
+
+const (
+	// DelegationSendAs sends with the mailbox in From, as if the mailbox
+	// sent the message itself
+	DelegationSendAs DelegationPermission = "SEND_AS"
+	// DelegationSendOnBehalf sends with the mailbox in From and the
+	// delegate in Sender
+	DelegationSendOnBehalf DelegationPermission = "SEND_ON_BEHALF"
+)
+
+// SenderDelegation lets a delegate account send as, or on behalf of, the
+// addresses of another mailbox, such as a shared mailbox
+type SenderDelegation struct {
+	ID                string
+	AccountID         string // mailbox sent for
+	DelegateAccountID string
+	Permission        DelegationPermission
+	GrantedBy         string // user ID
+	CreatedAt         time.Time
+	UpdatedAt         time.Time
+}
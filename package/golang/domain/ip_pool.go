@@ -0,0 +1,90 @@
+package domain
+
+import (
+	"sort"
+	"time"
+)
+
+// IPPool is a named set of local source addresses used for outbound delivery
+type IPPool struct {
+	ID          string
+	Name        string
+	Description string
+	Addresses   []PoolAddress
+	IsDefault   bool
+	CreatedAt   time.Time
+	UpdatedAt   time.Time
+}
+
+// PoolAddress is a source address with the EHLO name announced from it.
+// Addresses with a warm-up start date follow the warm-up schedule.
+type PoolAddress struct {
+	IP              string
+	HeloName        string
+	IsActive        bool
+	WarmupStartedAt *time.Time
+}
+
+// PoolScope defines what an IP pool assignment applies to
+type PoolScope string
+
+const (
+	PoolScopeTenant PoolScope = "TENANT"
+	PoolScopeDomain PoolScope = "DOMAIN"
+	PoolScopeStream PoolScope = "STREAM"
+)
+
+// PoolAssignment binds a tenant, sender domain or message stream to a pool
+type PoolAssignment struct {
+	Scope     PoolScope
+	Key       string
+	PoolID    string
+	CreatedAt time.Time
+}
+
+// WarmupStep caps the daily volume of a warming address from Day (1 being
+// the first day) until the next step. A DailyLimit of 0 ends the warm-up.
+type WarmupStep struct {
+	Day        int
+	DailyLimit int64
+}
+
+// SourceAddress is the source address and EHLO name chosen for a delivery
+type SourceAddress struct {
+	PoolID   string
+	IP       string
+	HeloName string
+}
+
+// WarmupDay returns the day of warm-up the address is in at the given
+// time, starting at 1, or 0 when the address is not warming up
+func (a *PoolAddress) WarmupDay(at time.Time) int {
+	if a.WarmupStartedAt == nil {
+		return 0
+	}
+	if at.Before(*a.WarmupStartedAt) {
+		return 1
+	}
+	return int(at.Sub(*a.WarmupStartedAt)/(24*time.Hour)) + 1
+}
+
+// DailyLimit returns the daily volume cap of the address under the
+// schedule at the given time, or 0 when it is unlimited
+func (a *PoolAddress) DailyLimit(schedule []WarmupStep, at time.Time) int64 {
+	day := a.WarmupDay(at)
+	if day == 0 || len(schedule) == 0 {
+		return 0
+	}
+
+	steps := append([]WarmupStep(nil), schedule...)
+	sort.Slice(steps, func(i, j int) bool { return steps[i].Day < steps[j].Day })
+
+	limit := steps[0].DailyLimit
+	for _, step := range steps {
+		if step.Day > day {
+			break
+		}
+		limit = step.DailyLimit
+	}
+	return limit
+}
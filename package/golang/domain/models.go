@@ -51,9 +51,8 @@ type Domain struct {
 	MaxUsers        int
 	MaxEmailsPerDay int
 	MaxStorageMB    int
-	DKIMSelector    *string
+	DKIMSelector    *string // selector of the active DKIM key, managed by DKIMService
 	DKIMPublicKey   *string
-	DKIMPrivateKey  *string
 	SPFRecord       *string
 	DMARCRecord     *string
 	CreatedAt       time.Time
@@ -84,7 +83,7 @@ const (
 // EmailAccount represents an email account
 type EmailAccount struct {
 	ID           string
-	UserID       string
+	UserID       string // empty for shared mailboxes
 	DomainID     string
 	Email        string
 	DisplayName  *string
@@ -98,6 +97,12 @@ type EmailAccount struct {
 	LastLoginAt  *time.Time
 }
 
+// IsShared reports whether the account is a shared mailbox, which no user
+// owns and which users reach through folder rights
+func (a *EmailAccount) IsShared() bool {
+	return a.UserID == ""
+}
+
 // EmailAlias represents an email alias
 type EmailAlias struct {
 	ID        string
@@ -124,6 +129,7 @@ type DNSRecord struct {
 type Message struct {
 	ID          string
 	AccountID   string
+	ThreadID    string
 	From        string
 	To          []string
 	Cc          []string
@@ -132,17 +138,48 @@ type Message struct {
 	BodyText    *string
 	BodyHTML    *string
 	Attachments []Attachment
+	Headers     map[string]string
+	FolderID    string
 	Size        int64
 	IsRead      bool
 	IsDraft     bool
 	IsSent      bool
 	IsDeleted   bool
+	IsStarred   bool
+	IsFlagged   bool
+	Labels      []string
 	ReceivedAt  time.Time
 	SentAt      *time.Time
 	CreatedAt   time.Time
 	UpdatedAt   time.Time
 }
 
+// Folder represents a mailbox folder owned by an email account
+type Folder struct {
+	ID           string
+	AccountID    string
+	ParentID     *string
+	Name         string
+	Path         string
+	Type         FolderType
+	IsSubscribed bool
+	CreatedAt    time.Time
+	UpdatedAt    time.Time
+}
+
+// FolderType defines the role of a folder within a mailbox
+type FolderType string
+
+const (
+	FolderTypeInbox   FolderType = "INBOX"
+	FolderTypeSent    FolderType = "SENT"
+	FolderTypeDrafts  FolderType = "DRAFTS"
+	FolderTypeTrash   FolderType = "TRASH"
+	FolderTypeSpam    FolderType = "SPAM"
+	FolderTypeArchive FolderType = "ARCHIVE"
+	FolderTypeCustom  FolderType = "CUSTOM"
+)
+
 // Attachment represents a message attachment
 type Attachment struct {
 	ID          string
@@ -150,7 +187,9 @@ type Attachment struct {
 	Filename    string
 	ContentType string
 	Size        int64
-	Content     []byte
+	Content     []byte // empty once the content is in the blob store
+	BlobID      string
+	Checksum    string // hex SHA-256 of the content
 }
 
 // Quota represents storage and sending limits
@@ -0,0 +1,37 @@
+package domain
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// OutboxStatus is the delivery state of an outbox entry
+type OutboxStatus string
+
+const (
+	OutboxStatusPending   OutboxStatus = "PENDING"
+	OutboxStatusDelivered OutboxStatus = "DELIVERED"
+	OutboxStatusDead      OutboxStatus = "DEAD" // gave up after the last attempt
+)
+
+// OutboxEntry is an event written in the transaction of the state change
+// that raised it and delivered to the event handlers afterwards
+type OutboxEntry struct {
+	ID            string // event ID
+	AggregateID   string
+	EventType     string
+	Payload       []byte // JSON encoded event data
+	OccurredAt    time.Time
+	Status        OutboxStatus
+	Attempts      int
+	NextAttemptAt time.Time // also the lease expiry of a claimed entry
+	LastError     string
+	CreatedAt     time.Time
+	DeliveredAt   *time.Time
+}
+
+// Event returns the event of the entry. Its data is the JSON payload as a
+// json.RawMessage.
+func (e *OutboxEntry) Event() Event {
+	return RestoreBaseEvent(e.ID, e.AggregateID, e.EventType, e.OccurredAt, json.RawMessage(e.Payload))
+}
@@ -0,0 +1,84 @@
+package domain
+
+import (
+	"time"
+)
+
+// QuarantineEntry represents a message held back from delivery for review
+type QuarantineEntry struct {
+	ID           string
+	MessageID    string
+	AccountID    string
+	DomainID     string
+	Sender       string
+	Recipients   []string
+	Subject      string
+	Reason       QuarantineReason
+	Details      string
+	PolicyID     *string
+	PolicyName   *string
+	SpamVerdict  *SpamVerdict
+	VirusVerdict *VirusVerdict
+	Message      Message
+	RawMessage   []byte
+	Size         int64
+	Status       QuarantineStatus
+	CreatedAt    time.Time
+	ExpiresAt    time.Time
+	ReleasedAt   *time.Time
+	ReleasedBy   *string
+	DigestSentAt *time.Time
+}
+
+// QuarantineReason defines why a message was quarantined
+type QuarantineReason string
+
+const (
+	QuarantineReasonSpam       QuarantineReason = "SPAM"
+	QuarantineReasonVirus      QuarantineReason = "VIRUS"
+	QuarantineReasonPolicy     QuarantineReason = "POLICY"
+	QuarantineReasonAttachment QuarantineReason = "ATTACHMENT"
+)
+
+// QuarantineStatus defines the review state of a quarantined message
+type QuarantineStatus string
+
+const (
+	QuarantineStatusHeld     QuarantineStatus = "HELD"
+	QuarantineStatusReleased QuarantineStatus = "RELEASED"
+	QuarantineStatusDeleted  QuarantineStatus = "DELETED"
+)
+
+// QuarantinePreview is a sanitised, read-only view of a quarantined message
+type QuarantinePreview struct {
+	Entry       *QuarantineEntry
+	Headers     map[string]string
+	BodyText    string
+	BodyHTML    string
+	Attachments []AttachmentInfo
+}
+
+// AttachmentInfo describes an attachment without its content
+type AttachmentInfo struct {
+	Filename    string
+	ContentType string
+	Size        int64
+}
+
+// QuarantineDigest summarises the messages quarantined for one account
+type QuarantineDigest struct {
+	AccountID   string
+	Recipient   string
+	Items       []QuarantineDigestItem
+	GeneratedAt time.Time
+}
+
+// QuarantineDigestItem is a digest line with its one-click release link
+type QuarantineDigestItem struct {
+	EntryID    string
+	Sender     string
+	Subject    string
+	Reason     QuarantineReason
+	ReceivedAt time.Time
+	ReleaseURL string
+}
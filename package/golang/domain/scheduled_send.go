@@ -0,0 +1,48 @@
+package domain
+
+import (
+	"time"
+)
+
+// ScheduledSendKind defines why the sending of a draft is held
+type ScheduledSendKind string
+
+const (
+	ScheduledSendKindScheduled ScheduledSendKind = "SCHEDULED" // send later
+	ScheduledSendKindUndo      ScheduledSendKind = "UNDO"      // undo window after send
+)
+
+// ScheduledSendStatus defines the state of a scheduled send
+type ScheduledSendStatus string
+
+const (
+	ScheduledSendStatusPending   ScheduledSendStatus = "PENDING"
+	ScheduledSendStatusSent      ScheduledSendStatus = "SENT"
+	ScheduledSendStatusCancelled ScheduledSendStatus = "CANCELLED"
+	ScheduledSendStatusFailed    ScheduledSendStatus = "FAILED"
+)
+
+// ScheduledSend holds a draft until it is due to be sent. A pending send
+// whose LockedUntil is after now is being dispatched or edited and cannot
+// be claimed or cancelled until the lease expires.
+type ScheduledSend struct {
+	ID            string
+	AccountID     string
+	DraftID       string
+	Kind          ScheduledSendKind
+	Status        ScheduledSendStatus
+	SendAt        time.Time
+	NextAttemptAt time.Time // SendAt, or the next retry after a failed attempt
+	LockedUntil   *time.Time
+	Attempts      int
+	LastError     string
+	SentMessageID *string
+	CreatedAt     time.Time
+	UpdatedAt     time.Time
+	CompletedAt   *time.Time
+}
+
+// IsLocked reports whether a dispatcher or an edit holds the send at now
+func (s *ScheduledSend) IsLocked(now time.Time) bool {
+	return s.LockedUntil != nil && s.LockedUntil.After(now)
+}
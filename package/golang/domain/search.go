@@ -0,0 +1,465 @@
+package domain
+
+import (
+	"fmt"
+	"html"
+	"regexp"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+// SearchRecencyHalfLife is the age at which a search hit ranks at half of
+// its relevance, so recent mail wins between equally relevant messages
+const SearchRecencyHalfLife = 30 * 24 * time.Hour
+
+// maxSearchFieldSize caps the indexed text of one field, in bytes
+const maxSearchFieldSize = 256 * 1024
+
+// SearchField restricts a search phrase to one part of a message
+type SearchField string
+
+const (
+	SearchFieldAny     SearchField = ""
+	SearchFieldFrom    SearchField = "from"
+	SearchFieldTo      SearchField = "to" // To and Cc recipients
+	SearchFieldSubject SearchField = "subject"
+)
+
+// searchWeights are the relevance of a match per field, the default
+// ts_rank weights of the A to D labels Postgres gives them
+var searchWeights = map[SearchField]float64{
+	SearchFieldSubject: 1.0,
+	SearchFieldFrom:    0.4,
+	SearchFieldTo:      0.2,
+	SearchFieldAny:     0.1, // body and attachments
+}
+
+// SearchPhrase is a sequence of lowercase words that must appear in order
+type SearchPhrase struct {
+	Field SearchField
+	Words []string
+}
+
+// SearchQuery is a parsed message search. A message matches when every
+// clause has a matching phrase, no excluded phrase matches and it meets
+// every operator.
+type SearchQuery struct {
+	Clauses        [][]SearchPhrase // alternatives joined by OR
+	Excluded       []SearchPhrase
+	HasAttachment  *bool
+	IsRead         *bool
+	IsStarred      *bool
+	IsFlagged      *bool
+	Labels         []string
+	ExcludedLabels []string
+	After          *time.Time // received at or after
+	Before         *time.Time // received before
+}
+
+// ParseSearchQuery parses the search syntax of the mail clients: words,
+// "quoted phrases", OR, -exclusions and the operators from:, to:,
+// subject:, has:attachment, is:read, is:unread, is:starred, is:flagged,
+// label:, before: and after:, whose dates are YYYY-MM-DD in UTC. Unknown
+// operators are searched as text.
+func ParseSearchQuery(query string) (*SearchQuery, error) {
+	q := &SearchQuery{}
+	orNext := false
+	for _, token := range scanSearchTokens(query) {
+		if !token.quoted && !token.negated && token.operator == "" && strings.EqualFold(token.value, "or") && len(q.Clauses) > 0 {
+			orNext = true
+			continue
+		}
+
+		var phrase *SearchPhrase
+		switch token.operator {
+		case "", "from", "to", "subject":
+			words := SearchWords(token.value)
+			if len(words) == 0 {
+				orNext = false
+				continue
+			}
+			phrase = &SearchPhrase{Field: SearchField(token.operator), Words: words}
+		default:
+			if err := q.applyOperator(token); err != nil {
+				return nil, err
+			}
+			orNext = false
+			continue
+		}
+
+		switch {
+		case token.negated:
+			q.Excluded = append(q.Excluded, *phrase)
+		case orNext:
+			last := len(q.Clauses) - 1
+			q.Clauses[last] = append(q.Clauses[last], *phrase)
+		default:
+			q.Clauses = append(q.Clauses, []SearchPhrase{*phrase})
+		}
+		orNext = false
+	}
+	return q, nil
+}
+
+func (q *SearchQuery) applyOperator(token searchToken) error {
+	value := strings.ToLower(token.value)
+	flag := func(target **bool, set bool) {
+		if token.negated {
+			set = !set
+		}
+		*target = &set
+	}
+	switch token.operator {
+	case "has":
+		if value != "attachment" && value != "attachments" {
+			return fmt.Errorf("unsupported search operator has:%s", token.value)
+		}
+		flag(&q.HasAttachment, true)
+	case "is":
+		switch value {
+		case "read":
+			flag(&q.IsRead, true)
+		case "unread":
+			flag(&q.IsRead, false)
+		case "starred":
+			flag(&q.IsStarred, true)
+		case "flagged":
+			flag(&q.IsFlagged, true)
+		default:
+			return fmt.Errorf("unsupported search operator is:%s", token.value)
+		}
+	case "label":
+		label := strings.TrimSpace(token.value)
+		if label == "" {
+			return nil
+		}
+		if token.negated {
+			q.ExcludedLabels = append(q.ExcludedLabels, label)
+		} else {
+			q.Labels = append(q.Labels, label)
+		}
+	case "before", "after":
+		if token.negated {
+			return fmt.Errorf("search operator %s: cannot be excluded", token.operator)
+		}
+		date, err := parseSearchDate(token.value)
+		if err != nil {
+			return fmt.Errorf("invalid date %q for search operator %s:", token.value, token.operator)
+		}
+		if token.operator == "before" {
+			q.Before = &date
+		} else {
+			q.After = &date
+		}
+	}
+	return nil
+}
+
+func parseSearchDate(value string) (time.Time, error) {
+	for _, layout := range []string{"2006-01-02", "2006/01/02"} {
+		if date, err := time.Parse(layout, value); err == nil {
+			return date, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid date %q", value)
+}
+
+// HasText reports whether the query searches text, not only operators
+func (q *SearchQuery) HasText() bool {
+	return len(q.Clauses) > 0 || len(q.Excluded) > 0
+}
+
+// searchOperators are the operators ParseSearchQuery understands
+var searchOperators = map[string]bool{
+	"from": true, "to": true, "subject": true, "has": true, "is": true,
+	"label": true, "before": true, "after": true,
+}
+
+type searchToken struct {
+	negated  bool
+	operator string
+	value    string
+	quoted   bool
+}
+
+// scanSearchTokens splits a query on spaces, keeping quoted phrases whole,
+// including the quoted value of an operator such as subject:"two words"
+func scanSearchTokens(query string) []searchToken {
+	tokens := []searchToken{}
+	runes := []rune(query)
+	for i := 0; i < len(runes); {
+		if unicode.IsSpace(runes[i]) {
+			i++
+			continue
+		}
+		token := searchToken{}
+		if runes[i] == '-' && i+1 < len(runes) && !unicode.IsSpace(runes[i+1]) {
+			token.negated = true
+			i++
+		}
+		if colon := operatorEnd(runes, i); colon > i {
+			token.operator = strings.ToLower(string(runes[i:colon]))
+			i = colon + 1
+		}
+		if i < len(runes) && runes[i] == '"' {
+			end := i + 1
+			for end < len(runes) && runes[end] != '"' {
+				end++
+			}
+			token.value = string(runes[i+1 : end])
+			token.quoted = true
+			i = end + 1
+		} else {
+			end := i
+			for end < len(runes) && !unicode.IsSpace(runes[end]) {
+				end++
+			}
+			token.value = string(runes[i:end])
+			i = end
+		}
+		tokens = append(tokens, token)
+	}
+	return tokens
+}
+
+// operatorEnd returns the position of the colon ending a known operator
+// at start, or -1
+func operatorEnd(runes []rune, start int) int {
+	for i := start; i < len(runes) && unicode.IsLetter(runes[i]); i++ {
+		if i+1 < len(runes) && runes[i+1] == ':' && searchOperators[strings.ToLower(string(runes[start:i+1]))] {
+			return i + 1
+		}
+	}
+	return -1
+}
+
+// SearchWords lowercases text and splits it into words of letters and
+// digits, the tokens every search backend indexes
+func SearchWords(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// SearchDocument is the indexed text of a message
+type SearchDocument struct {
+	MessageID   string
+	AccountID   string
+	Subject     string
+	From        string
+	To          string // To and Cc recipients
+	Body        string // text body, or the HTML body without markup
+	Attachments string // filenames and extracted text of attachments
+	ReceivedAt  time.Time
+}
+
+// NewSearchDocument returns the search document of a message with the
+// attachment filenames; attachment text is added by the indexer
+func NewSearchDocument(message *Message) *SearchDocument {
+	doc := &SearchDocument{
+		MessageID:  message.ID,
+		AccountID:  message.AccountID,
+		Subject:    message.Subject,
+		From:       message.From,
+		To:         strings.Join(append(append([]string{}, message.To...), message.Cc...), " "),
+		ReceivedAt: message.ReceivedAt,
+	}
+	switch {
+	case message.BodyText != nil && strings.TrimSpace(*message.BodyText) != "":
+		doc.Body = *message.BodyText
+	case message.BodyHTML != nil:
+		doc.Body = HTMLText(*message.BodyHTML)
+	}
+	filenames := make([]string, 0, len(message.Attachments))
+	for _, attachment := range message.Attachments {
+		filenames = append(filenames, attachment.Filename)
+	}
+	doc.Attachments = strings.Join(filenames, " ")
+	return doc
+}
+
+// SearchText is a search document split into words per field, the form
+// the query matches against
+type SearchText struct {
+	Subject []string
+	From    []string
+	To      []string
+	Body    []string // body then attachments
+}
+
+// Text splits the document into words, capping the size of every field
+func (d *SearchDocument) Text() *SearchText {
+	return &SearchText{
+		Subject: SearchWords(capSearchField(d.Subject)),
+		From:    SearchWords(capSearchField(d.From)),
+		To:      SearchWords(capSearchField(d.To)),
+		Body:    append(SearchWords(capSearchField(d.Body)), SearchWords(capSearchField(d.Attachments))...),
+	}
+}
+
+func capSearchField(text string) string {
+	if len(text) <= maxSearchFieldSize {
+		return text
+	}
+	// Cut at a rune boundary
+	cut := maxSearchFieldSize
+	for cut > 0 && !utf8.RuneStart(text[cut]) {
+		cut--
+	}
+	return text[:cut]
+}
+
+func (t *SearchText) fields(field SearchField) map[SearchField][]string {
+	if field != SearchFieldAny {
+		return map[SearchField][]string{field: t.field(field)}
+	}
+	return map[SearchField][]string{
+		SearchFieldSubject: t.Subject,
+		SearchFieldFrom:    t.From,
+		SearchFieldTo:      t.To,
+		SearchFieldAny:     t.Body,
+	}
+}
+
+func (t *SearchText) field(field SearchField) []string {
+	switch field {
+	case SearchFieldSubject:
+		return t.Subject
+	case SearchFieldFrom:
+		return t.From
+	case SearchFieldTo:
+		return t.To
+	}
+	return t.Body
+}
+
+// MatchesText reports whether the text satisfies the phrases of the query
+func (q *SearchQuery) MatchesText(text *SearchText) bool {
+	for _, clause := range q.Clauses {
+		matched := false
+		for _, phrase := range clause {
+			if phraseCount(text, phrase) > 0 {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return false
+		}
+	}
+	for _, phrase := range q.Excluded {
+		if phraseCount(text, phrase) > 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// MatchesMessage reports whether a message meets the operators of the
+// query. Its text is checked by MatchesText.
+func (q *SearchQuery) MatchesMessage(message *Message) bool {
+	if q.HasAttachment != nil && (len(message.Attachments) > 0) != *q.HasAttachment {
+		return false
+	}
+	if q.IsRead != nil && message.IsRead != *q.IsRead {
+		return false
+	}
+	if q.IsStarred != nil && message.IsStarred != *q.IsStarred {
+		return false
+	}
+	if q.IsFlagged != nil && message.IsFlagged != *q.IsFlagged {
+		return false
+	}
+	for _, label := range q.Labels {
+		if !hasLabel(message.Labels, label) {
+			return false
+		}
+	}
+	for _, label := range q.ExcludedLabels {
+		if hasLabel(message.Labels, label) {
+			return false
+		}
+	}
+	if q.After != nil && message.ReceivedAt.Before(*q.After) {
+		return false
+	}
+	if q.Before != nil && !message.ReceivedAt.Before(*q.Before) {
+		return false
+	}
+	return true
+}
+
+// Relevance weighs the occurrences of the matched phrases by field. A
+// query without text is equally relevant to every message.
+func (q *SearchQuery) Relevance(text *SearchText) float64 {
+	if len(q.Clauses) == 0 {
+		return 1
+	}
+	relevance := 0.0
+	for _, clause := range q.Clauses {
+		for _, phrase := range clause {
+			for field, words := range text.fields(phrase.Field) {
+				relevance += searchWeights[field] * float64(countPhrase(words, phrase.Words))
+			}
+		}
+	}
+	return relevance
+}
+
+// SearchScore ranks a hit by relevance, decayed by the age of the message
+func SearchScore(relevance float64, receivedAt, now time.Time) float64 {
+	age := now.Sub(receivedAt)
+	if age < 0 {
+		age = 0
+	}
+	return relevance / (1 + float64(age)/float64(SearchRecencyHalfLife))
+}
+
+func phraseCount(text *SearchText, phrase SearchPhrase) int {
+	count := 0
+	for _, words := range text.fields(phrase.Field) {
+		count += countPhrase(words, phrase.Words)
+	}
+	return count
+}
+
+func countPhrase(words, phrase []string) int {
+	count := 0
+	for i := 0; i+len(phrase) <= len(words); i++ {
+		matched := true
+		for j, word := range phrase {
+			if words[i+j] != word {
+				matched = false
+				break
+			}
+		}
+		if matched {
+			count++
+		}
+	}
+	return count
+}
+
+func hasLabel(labels []string, label string) bool {
+	for _, l := range labels {
+		if l == label {
+			return true
+		}
+	}
+	return false
+}
+
+var (
+	htmlHiddenPattern = regexp.MustCompile(`(?is)<(script|style|head)\b.*?</(script|style|head)\s*>`)
+	htmlTagPattern    = regexp.MustCompile(`(?s)<[^>]*>`)
+)
+
+// HTMLText returns the text of an HTML body without markup, scripts and
+// styles
+func HTMLText(body string) string {
+	text := htmlHiddenPattern.ReplaceAllString(body, " ")
+	text = htmlTagPattern.ReplaceAllString(text, " ")
+	return strings.Join(strings.Fields(html.UnescapeString(text)), " ")
+}
@@ -0,0 +1,73 @@
+package domain
+
+import (
+	"time"
+)
+
+// RateScope defines the entity a sending limit applies to
+type RateScope string
+
+const (
+	RateScopeAccount RateScope = "ACCOUNT"
+	RateScopeDomain  RateScope = "DOMAIN"
+	RateScopeTenant  RateScope = "TENANT"
+	RateScopeIP      RateScope = "IP"
+)
+
+// RateLimit defines a sliding-window sending limit for a scope. A zero
+// maximum disables that dimension.
+type RateLimit struct {
+	Scope         RateScope
+	Window        time.Duration
+	MaxMessages   int64
+	MaxRecipients int64
+}
+
+// RateUsage represents the current usage of a sending limit
+type RateUsage struct {
+	Limit      RateLimit
+	Key        string
+	Messages   int64
+	Recipients int64
+}
+
+// DeliveryOutcome defines the result of delivering to one recipient
+type DeliveryOutcome string
+
+const (
+	DeliveryOutcomeDelivered        DeliveryOutcome = "DELIVERED"
+	DeliveryOutcomeBounced          DeliveryOutcome = "BOUNCED"
+	DeliveryOutcomeUnknownRecipient DeliveryOutcome = "UNKNOWN_RECIPIENT"
+)
+
+// SendingStats represents the sending reputation of a scope over a window
+type SendingStats struct {
+	Scope                RateScope
+	Key                  string
+	Window               time.Duration
+	Delivered            int64
+	Bounced              int64
+	UnknownRecipients    int64
+	BounceRate           float64
+	UnknownRecipientRate float64
+}
+
+// SendingSuspension represents a block on sending for a scope
+type SendingSuspension struct {
+	ID          string
+	Scope       RateScope
+	Key         string
+	Reason      string
+	SuspendedAt time.Time
+	ExpiresAt   *time.Time // nil until lifted by an administrator
+	LiftedAt    *time.Time
+	LiftedBy    *string
+}
+
+// IsActive reports whether the suspension still blocks sending at t
+func (s *SendingSuspension) IsActive(t time.Time) bool {
+	if s.LiftedAt != nil {
+		return false
+	}
+	return s.ExpiresAt == nil || t.Before(*s.ExpiresAt)
+}
@@ -0,0 +1,74 @@
+package domain
+
+import (
+	"time"
+)
+
+// SpamClass identifies the class a message was trained as
+type SpamClass string
+
+const (
+	SpamClassSpam SpamClass = "SPAM"
+	SpamClassHam  SpamClass = "HAM"
+)
+
+// SpamVerdict represents the outcome of classifying a message
+type SpamVerdict struct {
+	MessageID        string
+	AccountID        string
+	Score            float64
+	Threshold        float64
+	HeuristicScore   float64
+	BayesProbability *float64
+	IsSpam           bool
+	Rules            []SpamRuleHit
+	ClassifiedAt     time.Time
+}
+
+// SpamRuleHit represents a heuristic rule that matched a message
+type SpamRuleHit struct {
+	Name        string
+	Score       float64
+	Description string
+}
+
+// SpamToken holds per-account training counts for a single token
+type SpamToken struct {
+	AccountID string
+	Token     string
+	SpamCount int
+	HamCount  int
+	UpdatedAt time.Time
+}
+
+// SpamTotals holds the number of messages trained per class for an account
+type SpamTotals struct {
+	AccountID    string
+	SpamMessages int
+	HamMessages  int
+	UpdatedAt    time.Time
+}
+
+// AuthenticationResults holds the sender authentication outcome for a message
+type AuthenticationResults struct {
+	SPF   AuthResult
+	DKIM  AuthResult
+	DMARC AuthResult
+	ARC   AuthResult
+	// DMARCOverride names the trusted ARC sealer that vouched for a message
+	// failing DMARC, empty when no override applies
+	DMARCOverride string
+}
+
+// AuthResult defines authentication check outcomes
+type AuthResult string
+
+const (
+	AuthResultNone      AuthResult = "none"
+	AuthResultPass      AuthResult = "pass"
+	AuthResultFail      AuthResult = "fail"
+	AuthResultSoftFail  AuthResult = "softfail"
+	AuthResultNeutral   AuthResult = "neutral"
+	AuthResultTempError AuthResult = "temperror"
+	AuthResultPermError AuthResult = "permerror"
+)
@@ -0,0 +1,281 @@
+package domain
+
+import (
+	"net/mail"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ThreadSubjectWindow bounds the subject fallback: a message only joins a
+// thread by subject when the thread had a message within this window
+const ThreadSubjectWindow = 30 * 24 * time.Hour
+
+// Limits of the thread metadata
+const (
+	maxThreadReferences   = 100 // most recent References kept per message
+	maxThreadParticipants = 50
+)
+
+// Thread is a conversation: messages of an account linked by their
+// Message-ID, In-Reply-To and References headers, or by subject. It keeps
+// the aggregates of its messages, which Summarize computes.
+type Thread struct {
+	ID             string
+	AccountID      string
+	Subject        string // of the earliest message
+	BaseSubject    string // Subject without reply and forward markers, lowercase
+	MessageCount   int
+	UnreadCount    int
+	HasAttachments bool
+	IsStarred      bool
+	Participants   []string // senders and recipients, in order of appearance
+	LastMessageAt  time.Time
+	CreatedAt      time.Time
+	UpdatedAt      time.Time
+}
+
+// ThreadSubjectMode selects when a message that refers to no known message
+// joins a thread with the same base subject
+type ThreadSubjectMode string
+
+const (
+	// ThreadSubjectOff threads by headers only
+	ThreadSubjectOff ThreadSubjectMode = "off"
+	// ThreadSubjectReplies lets replies and forwards join by subject, for
+	// clients that drop the References header
+	ThreadSubjectReplies ThreadSubjectMode = "replies"
+	// ThreadSubjectAlways lets every message join by subject
+	ThreadSubjectAlways ThreadSubjectMode = "always"
+)
+
+// DefaultThreadSubjectMode applies to accounts without thread settings
+const DefaultThreadSubjectMode = ThreadSubjectReplies
+
+// Valid reports whether the mode is known
+func (m ThreadSubjectMode) Valid() bool {
+	switch m {
+	case ThreadSubjectOff, ThreadSubjectReplies, ThreadSubjectAlways:
+		return true
+	}
+	return false
+}
+
+// ThreadSettings are the threading options of an account
+type ThreadSettings struct {
+	AccountID   string
+	SubjectMode ThreadSubjectMode
+	UpdatedAt   time.Time
+}
+
+// Summarize recomputes the aggregates of a thread from its messages
+func (t *Thread) Summarize(messages []*Message) {
+	sorted := append([]*Message{}, messages...)
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].ReceivedAt.Before(sorted[j].ReceivedAt) })
+
+	t.MessageCount = len(sorted)
+	t.UnreadCount = 0
+	t.HasAttachments = false
+	t.IsStarred = false
+	t.Participants = []string{}
+	t.LastMessageAt = time.Time{}
+	if len(sorted) > 0 {
+		t.Subject = sorted[0].Subject
+		t.BaseSubject = BaseSubject(sorted[0].Subject)
+	}
+
+	seen := make(map[string]bool)
+	for _, message := range sorted {
+		if !message.IsRead {
+			t.UnreadCount++
+		}
+		if len(message.Attachments) > 0 {
+			t.HasAttachments = true
+		}
+		if message.IsStarred {
+			t.IsStarred = true
+		}
+		if message.ReceivedAt.After(t.LastMessageAt) {
+			t.LastMessageAt = message.ReceivedAt
+		}
+		addresses := append(append([]string{message.From}, message.To...), message.Cc...)
+		for _, address := range addresses {
+			key := participantKey(address)
+			if key == "" || seen[key] || len(t.Participants) >= maxThreadParticipants {
+				continue
+			}
+			seen[key] = true
+			t.Participants = append(t.Participants, strings.TrimSpace(address))
+		}
+	}
+}
+
+// participantKey identifies an address regardless of its display name
+func participantKey(address string) string {
+	if parsed, err := mail.ParseAddress(address); err == nil {
+		return strings.ToLower(parsed.Address)
+	}
+	return strings.ToLower(strings.TrimSpace(address))
+}
+
+// ThreadHeaders returns the Message-ID of a message and the Message-IDs it
+// refers to, oldest first: its References followed by In-Reply-To when
+// References does not already hold it
+func ThreadHeaders(message *Message) (id string, references []string) {
+	if ids := ParseMessageIDs(headerValue(message.Headers, "Message-ID")); len(ids) > 0 {
+		id = ids[0]
+	}
+	candidates := ParseMessageIDs(headerValue(message.Headers, "References"))
+	if replyTo := ParseMessageIDs(headerValue(message.Headers, "In-Reply-To")); len(replyTo) > 0 {
+		candidates = append(candidates, replyTo[0])
+	}
+
+	seen := map[string]bool{id: true}
+	for _, reference := range candidates {
+		if seen[reference] {
+			continue
+		}
+		seen[reference] = true
+		references = append(references, reference)
+	}
+	if len(references) > maxThreadReferences {
+		references = references[len(references)-maxThreadReferences:]
+	}
+	return id, references
+}
+
+// ParseMessageIDs returns the message IDs of a Message-ID, In-Reply-To or
+// References header without their angle brackets. A header without
+// brackets is taken as a single ID when it has no spaces, as some mailers
+// write it that way.
+func ParseMessageIDs(value string) []string {
+	ids := []string{}
+	rest := value
+	for {
+		start := strings.IndexByte(rest, '<')
+		if start < 0 {
+			break
+		}
+		end := strings.IndexByte(rest[start:], '>')
+		if end < 0 {
+			break
+		}
+		id := strings.TrimSpace(rest[start+1 : start+end])
+		if id != "" && !strings.ContainsAny(id, " \t\r\n<") {
+			ids = append(ids, id)
+		}
+		rest = rest[start+end+1:]
+	}
+	if len(ids) == 0 {
+		value = strings.TrimSpace(value)
+		if value != "" && !strings.ContainsAny(value, " \t\r\n<>") {
+			ids = append(ids, value)
+		}
+	}
+	return ids
+}
+
+// Header returns a header of the message regardless of the case of its name
+func (m *Message) Header(name string) string {
+	return headerValue(m.Headers, name)
+}
+
+// headerValue returns a header regardless of the case of its name
+func headerValue(headers map[string]string, name string) string {
+	if value, ok := headers[name]; ok {
+		return value
+	}
+	for key, value := range headers {
+		if strings.EqualFold(key, name) {
+			return value
+		}
+	}
+	return ""
+}
+
+// threadSubjectPrefixes are the reply and forward markers removed from
+// subjects: those of RFC 5256 and common localized ones
+var threadSubjectPrefixes = []string{"re", "fwd", "fw", "aw", "wg", "sv", "vs", "antw", "tr"}
+
+// BaseSubject returns the subject used to group messages, lowercase and
+// without the reply and forward markers of RFC 5256, such as "Re:",
+// "Fwd:", "[list]" tags and a "(fwd)" trailer
+func BaseSubject(subject string) string {
+	base, _ := baseSubject(subject)
+	return base
+}
+
+// IsReplySubject reports whether a subject marks a reply or a forward
+func IsReplySubject(subject string) bool {
+	_, reply := baseSubject(subject)
+	return reply
+}
+
+func baseSubject(subject string) (string, bool) {
+	s := strings.Join(strings.Fields(strings.ToLower(subject)), " ")
+	reply := false
+	for {
+		before := s
+		for strings.HasSuffix(s, "(fwd)") {
+			s = strings.TrimSpace(strings.TrimSuffix(s, "(fwd)"))
+			reply = true
+		}
+		for {
+			if rest, ok := trimSubjectLeader(s); ok {
+				s, reply = rest, true
+				continue
+			}
+			// A leading tag is dropped unless it is the whole subject
+			if rest, ok := trimSubjectBlob(s); ok && rest != "" {
+				s = rest
+				continue
+			}
+			break
+		}
+		if strings.HasPrefix(s, "[fwd:") && strings.HasSuffix(s, "]") {
+			s = strings.TrimSpace(s[len("[fwd:") : len(s)-1])
+			reply = true
+		}
+		if s == before {
+			return s, reply
+		}
+	}
+}
+
+// trimSubjectLeader removes a leading "re:", "fwd:" or "re[2]:" marker,
+// after any tags
+func trimSubjectLeader(subject string) (string, bool) {
+	s := subject
+	for {
+		rest, ok := trimSubjectBlob(s)
+		if !ok {
+			break
+		}
+		s = rest
+	}
+	for _, prefix := range threadSubjectPrefixes {
+		if !strings.HasPrefix(s, prefix) {
+			continue
+		}
+		rest := strings.TrimLeft(s[len(prefix):], " ")
+		if blob, ok := trimSubjectBlob(rest); ok {
+			rest = blob
+		}
+		if strings.HasPrefix(rest, ":") {
+			return strings.TrimSpace(rest[1:]), true
+		}
+	}
+	return subject, false
+}
+
+// trimSubjectBlob removes a leading "[...]" tag
+func trimSubjectBlob(s string) (string, bool) {
+	if !strings.HasPrefix(s, "[") {
+		return s, false
+	}
+	end := strings.IndexAny(s[1:], "[]")
+	if end < 0 || s[1+end] != ']' {
+		return s, false
+	}
+	return strings.TrimSpace(s[end+2:]), true
+}
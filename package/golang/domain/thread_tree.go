@@ -0,0 +1,321 @@
+package domain
+
+import (
+	"net/mail"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ThreadNode is a message in a thread tree. Message is nil for a message
+// that is referred to but not among the threaded messages; such nodes only
+// remain when they join several threads.
+type ThreadNode struct {
+	Message  *Message
+	Children []*ThreadNode
+
+	parent *ThreadNode
+	order  int // position of the message in the threaded set
+}
+
+// BuildThreadTree threads messages with the REFERENCES algorithm of
+// RFC 5256, the one behind the IMAP THREAD=REFERENCES extension: messages
+// are linked by their Message-ID, In-Reply-To and References headers,
+// unknown parents are pruned, threads with the same base subject are
+// gathered, and siblings are sorted by sent date. The roots are returned
+// in date order.
+func BuildThreadTree(messages []*Message) []*ThreadNode {
+	byID := make(map[string]*ThreadNode)
+	container := func(id string) *ThreadNode {
+		node := byID[id]
+		if node == nil {
+			node = &ThreadNode{order: -1}
+			byID[id] = node
+		}
+		return node
+	}
+
+	nodes := []*ThreadNode{}
+	for i, message := range messages {
+		id, references := ThreadHeaders(message)
+		var node *ThreadNode
+		if existing := byID[id]; id != "" && existing != nil && existing.Message == nil {
+			node = existing
+		} else {
+			// Messages without an ID, or repeating one, get a container of their own
+			node = &ThreadNode{}
+			if id != "" && byID[id] == nil {
+				byID[id] = node
+			}
+		}
+		node.Message, node.order = message, i
+		nodes = append(nodes, node)
+
+		// Link the references to each other, keeping the links already made
+		var previous *ThreadNode
+		for _, reference := range references {
+			current := container(reference)
+			if previous != nil && current.parent == nil && current != previous && !previous.descendsFrom(current) {
+				previous.adopt(current)
+			}
+			previous = current
+		}
+		// The last reference is the parent, even over an earlier link
+		if node.parent != nil {
+			node.parent.disown(node)
+		}
+		if previous != nil && previous != node && !previous.descendsFrom(node) {
+			previous.adopt(node)
+		}
+	}
+
+	roots := []*ThreadNode{}
+	seen := make(map[*ThreadNode]bool)
+	for _, node := range append(nodes, containers(byID)...) {
+		if node.parent == nil && !seen[node] {
+			seen[node] = true
+			roots = append(roots, node)
+		}
+	}
+	roots = pruneThreadNodes(roots, true)
+	sortThreadNodes(roots)
+	roots = gatherThreadSubjects(roots)
+	for _, root := range roots {
+		root.sortChildren()
+	}
+	sortThreadNodes(roots)
+	return roots
+}
+
+// FormatThreadResponse writes thread trees as the data of an IMAP THREAD
+// response, such as "(2)(3 6 (4 23)(44 7 96))". number gives the sequence
+// number or UID of a message.
+func FormatThreadResponse(roots []*ThreadNode, number func(*Message) uint32) string {
+	var b strings.Builder
+	for _, root := range roots {
+		b.WriteString("(")
+		writeThreadNode(&b, root, number)
+		b.WriteString(")")
+	}
+	return b.String()
+}
+
+func writeThreadNode(b *strings.Builder, node *ThreadNode, number func(*Message) uint32) {
+	// A chain of single replies is written as a list of numbers
+	written := false
+	for node.Message != nil {
+		if written {
+			b.WriteString(" ")
+		}
+		b.WriteString(strconv.FormatUint(uint64(number(node.Message)), 10))
+		written = true
+		if len(node.Children) != 1 {
+			break
+		}
+		node = node.Children[0]
+	}
+	if len(node.Children) == 0 {
+		return
+	}
+	if written {
+		b.WriteString(" ")
+	}
+	if len(node.Children) == 1 {
+		writeThreadNode(b, node.Children[0], number)
+		return
+	}
+	for _, child := range node.Children {
+		b.WriteString("(")
+		writeThreadNode(b, child, number)
+		b.WriteString(")")
+	}
+}
+
+// containers returns the nodes created for referenced IDs, in a stable
+// order
+func containers(byID map[string]*ThreadNode) []*ThreadNode {
+	ids := make([]string, 0, len(byID))
+	for id, node := range byID {
+		if node.Message == nil {
+			ids = append(ids, id)
+		}
+	}
+	sort.Strings(ids)
+	nodes := make([]*ThreadNode, 0, len(ids))
+	for _, id := range ids {
+		nodes = append(nodes, byID[id])
+	}
+	return nodes
+}
+
+func (n *ThreadNode) adopt(child *ThreadNode) {
+	child.parent = n
+	n.Children = append(n.Children, child)
+}
+
+func (n *ThreadNode) disown(child *ThreadNode) {
+	for i, c := range n.Children {
+		if c == child {
+			n.Children = append(n.Children[:i], n.Children[i+1:]...)
+			break
+		}
+	}
+	child.parent = nil
+}
+
+// descendsFrom reports whether ancestor is n or one of its ancestors
+func (n *ThreadNode) descendsFrom(ancestor *ThreadNode) bool {
+	for node := n; node != nil; node = node.parent {
+		if node == ancestor {
+			return true
+		}
+	}
+	return false
+}
+
+// pruneThreadNodes removes the nodes without a message that have no
+// children and splices in the children of the others. Children are not
+// promoted to the root, unless there is only one.
+func pruneThreadNodes(nodes []*ThreadNode, atRoot bool) []*ThreadNode {
+	pruned := []*ThreadNode{}
+	for _, node := range nodes {
+		node.Children = pruneThreadNodes(node.Children, false)
+		for _, child := range node.Children {
+			child.parent = node
+		}
+		switch {
+		case node.Message != nil:
+			pruned = append(pruned, node)
+		case len(node.Children) == 0:
+		case !atRoot || len(node.Children) == 1:
+			for _, child := range node.Children {
+				child.parent = nil
+				pruned = append(pruned, child)
+			}
+		default:
+			pruned = append(pruned, node)
+		}
+	}
+	return pruned
+}
+
+// gatherThreadSubjects joins the roots with the same base subject, in the
+// way of RFC 5256: a reply is filed under the message it replies to, and
+// other messages are grouped under a node without a message
+func gatherThreadSubjects(roots []*ThreadNode) []*ThreadNode {
+	table := make(map[string]*ThreadNode)
+	for _, root := range roots {
+		subject := root.subject()
+		if subject == "" {
+			continue
+		}
+		old := table[subject]
+		if old == nil ||
+			(root.Message == nil && old.Message != nil) ||
+			(old.Message != nil && root.Message != nil && IsReplySubject(old.Message.Subject) &&
+				!IsReplySubject(root.Message.Subject)) {
+			table[subject] = root
+		}
+	}
+
+	gathered := []*ThreadNode{}
+	for _, root := range roots {
+		subject := root.subject()
+		other := table[subject]
+		if subject == "" || other == nil || other == root {
+			gathered = append(gathered, root)
+			continue
+		}
+		switch {
+		case root.Message == nil && other.Message == nil:
+			for _, child := range root.Children {
+				other.adopt(child)
+			}
+		case other.Message == nil:
+			other.adopt(root)
+		case root.Message == nil:
+			// Not reached: a root without a message takes the subject over
+			// one with a message
+			gathered = append(gathered, root)
+		case IsReplySubject(root.Message.Subject) && !IsReplySubject(other.Message.Subject):
+			other.adopt(root)
+		default:
+			group := &ThreadNode{order: other.order}
+			for i, g := range gathered {
+				if g == other {
+					gathered[i] = group
+				}
+			}
+			group.adopt(other)
+			group.adopt(root)
+			table[subject] = group
+		}
+	}
+	return gathered
+}
+
+// subject returns the base subject of a root, that of its first child when
+// it has no message
+func (n *ThreadNode) subject() string {
+	node := n
+	if node.Message == nil && len(node.Children) > 0 {
+		node = node.Children[0]
+	}
+	if node.Message == nil {
+		return ""
+	}
+	return BaseSubject(node.Message.Subject)
+}
+
+func (n *ThreadNode) sortChildren() {
+	for _, child := range n.Children {
+		child.sortChildren()
+	}
+	sortThreadNodes(n.Children)
+}
+
+// sortThreadNodes orders nodes by sent date, then by position; a node
+// without a message takes the date of its first child
+func sortThreadNodes(nodes []*ThreadNode) {
+	for _, node := range nodes {
+		if node.Message == nil {
+			sortThreadNodes(node.Children)
+		}
+	}
+	sort.SliceStable(nodes, func(i, j int) bool {
+		a, b := nodes[i].first(), nodes[j].first()
+		if a == nil || b == nil {
+			return b == nil && a != nil
+		}
+		if da, db := ThreadDate(a.Message), ThreadDate(b.Message); !da.Equal(db) {
+			return da.Before(db)
+		}
+		return a.order < b.order
+	})
+}
+
+// first returns the node that dates n: n itself, or its first child when
+// it has no message
+func (n *ThreadNode) first() *ThreadNode {
+	node := n
+	for node.Message == nil {
+		if len(node.Children) == 0 {
+			return nil
+		}
+		node = node.Children[0]
+	}
+	return node
+}
+
+// ThreadDate is the sent date threads are sorted by: the Date header, or
+// when it is missing or invalid the time the message was sent or received
+func ThreadDate(message *Message) time.Time {
+	if date, err := mail.ParseDate(headerValue(message.Headers, "Date")); err == nil {
+		return date
+	}
+	if message.SentAt != nil {
+		return *message.SentAt
+	}
+	return message.ReceivedAt
+}
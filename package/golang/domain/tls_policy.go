@@ -0,0 +1,162 @@
+package domain
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// MTASTSMode defines how an MTA-STS policy is applied (RFC 8461)
+type MTASTSMode string
+
+const (
+	MTASTSModeEnforce MTASTSMode = "enforce"
+	MTASTSModeTesting MTASTSMode = "testing"
+	MTASTSModeNone    MTASTSMode = "none"
+)
+
+// MTASTSPolicy is a cached MTA-STS policy of a recipient domain
+type MTASTSPolicy struct {
+	Domain    string
+	ID        string // id from the _mta-sts TXT record
+	Mode      MTASTSMode
+	MX        []string // host names or wildcards such as *.example.com
+	MaxAge    time.Duration
+	Text      string // policy body as fetched
+	FetchedAt time.Time
+	ExpiresAt time.Time
+}
+
+// MatchesMX reports whether an MX host is allowed by the policy. A
+// wildcard matches exactly one leftmost label (RFC 8461 section 4.1).
+func (p *MTASTSPolicy) MatchesMX(host string) bool {
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	for _, pattern := range p.MX {
+		pattern = strings.ToLower(strings.TrimSuffix(pattern, "."))
+		if strings.HasPrefix(pattern, "*.") {
+			dot := strings.Index(host, ".")
+			if dot > 0 && host[dot:] == pattern[1:] {
+				return true
+			}
+			continue
+		}
+		if host == pattern {
+			return true
+		}
+	}
+	return false
+}
+
+// PolicyStrings returns the policy lines as reported in TLS-RPT
+func (p *MTASTSPolicy) PolicyStrings() []string {
+	lines := []string{}
+	for _, line := range strings.Split(strings.ReplaceAll(p.Text, "\r\n", "\n"), "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			lines = append(lines, line)
+		}
+	}
+	return lines
+}
+
+// TLSARecord is a DANE TLSA record (RFC 6698)
+type TLSARecord struct {
+	Usage        uint8 // 2 DANE-TA, 3 DANE-EE; 0 and 1 are unusable for SMTP
+	Selector     uint8 // 0 full certificate, 1 SubjectPublicKeyInfo
+	MatchingType uint8 // 0 exact, 1 SHA-256, 2 SHA-512
+	Data         []byte
+}
+
+// String returns the record in presentation format
+func (r TLSARecord) String() string {
+	return fmt.Sprintf("%d %d %d %x", r.Usage, r.Selector, r.MatchingType, r.Data)
+}
+
+// TLSPolicyType identifies the policy a TLS session was evaluated against (RFC 8460)
+type TLSPolicyType string
+
+const (
+	TLSPolicyTypeSTS      TLSPolicyType = "sts"
+	TLSPolicyTypeTLSA     TLSPolicyType = "tlsa"
+	TLSPolicyTypeNoPolicy TLSPolicyType = "no-policy-found"
+)
+
+// TLSResultType is a TLS-RPT failure result type (RFC 8460 section 4.3)
+type TLSResultType string
+
+const (
+	TLSResultStartTLSNotSupported    TLSResultType = "starttls-not-supported"
+	TLSResultCertificateHostMismatch TLSResultType = "certificate-host-mismatch"
+	TLSResultCertificateExpired      TLSResultType = "certificate-expired"
+	TLSResultCertificateNotTrusted   TLSResultType = "certificate-not-trusted"
+	TLSResultValidationFailure       TLSResultType = "validation-failure"
+	TLSResultTLSAInvalid             TLSResultType = "tlsa-invalid"
+	TLSResultDNSSECInvalid           TLSResultType = "dnssec-invalid"
+	TLSResultDANERequired            TLSResultType = "dane-required"
+	TLSResultSTSPolicyFetchError     TLSResultType = "sts-policy-fetch-error"
+	TLSResultSTSPolicyInvalid        TLSResultType = "sts-policy-invalid"
+	TLSResultSTSWebPKIInvalid        TLSResultType = "sts-webpki-invalid"
+)
+
+// TLSResult is the outcome of one outbound TLS session attempt. An empty
+// ResultType means the session succeeded.
+type TLSResult struct {
+	Domain        string
+	PolicyType    TLSPolicyType
+	PolicyStrings []string
+	MXHost        string
+	ResultType    TLSResultType
+	At            time.Time
+}
+
+// TLSResultSummary aggregates TLS results per policy, result type and MX host
+type TLSResultSummary struct {
+	Domain        string
+	PolicyType    TLSPolicyType
+	PolicyStrings []string
+	MXHost        string
+	ResultType    TLSResultType
+	Count         int64
+}
+
+// TLSReport is an aggregate SMTP TLS report in the RFC 8460 JSON format
+type TLSReport struct {
+	OrganizationName string            `json:"organization-name"`
+	DateRange        TLSReportRange    `json:"date-range"`
+	ContactInfo      string            `json:"contact-info"`
+	ReportID         string            `json:"report-id"`
+	Policies         []TLSReportPolicy `json:"policies"`
+}
+
+// TLSReportRange is the period covered by a TLS report
+type TLSReportRange struct {
+	StartDatetime time.Time `json:"start-datetime"`
+	EndDatetime   time.Time `json:"end-datetime"`
+}
+
+// TLSReportPolicy groups the sessions evaluated against one policy
+type TLSReportPolicy struct {
+	Policy         TLSReportPolicyInfo      `json:"policy"`
+	Summary        TLSReportSummary         `json:"summary"`
+	FailureDetails []TLSReportFailureDetail `json:"failure-details,omitempty"`
+}
+
+// TLSReportPolicyInfo describes the evaluated policy
+type TLSReportPolicyInfo struct {
+	PolicyType   TLSPolicyType `json:"policy-type"`
+	PolicyString []string      `json:"policy-string,omitempty"`
+	PolicyDomain string        `json:"policy-domain"`
+	MXHost       string        `json:"mx-host,omitempty"`
+}
+
+// TLSReportSummary counts successful and failed sessions
+type TLSReportSummary struct {
+	TotalSuccessfulSessionCount int64 `json:"total-successful-session-count"`
+	TotalFailureSessionCount    int64 `json:"total-failure-session-count"`
+}
+
+// TLSReportFailureDetail counts failed sessions per result type and MX host
+type TLSReportFailureDetail struct {
+	ResultType          TLSResultType `json:"result-type"`
+	ReceivingMXHostname string        `json:"receiving-mx-hostname,omitempty"`
+	FailedSessionCount  int64         `json:"failed-session-count"`
+}
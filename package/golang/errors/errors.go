@@ -35,19 +35,71 @@ const (
 	ErrCodeInvalidRecipients ErrorCode = "INVALID_RECIPIENTS"
 	ErrCodeMessageRejected   ErrorCode = "MESSAGE_REJECTED"
 
+	// Folder errors
+	ErrCodeFolderNotFound      ErrorCode = "FOLDER_NOT_FOUND"
+	ErrCodeFolderAlreadyExists ErrorCode = "FOLDER_ALREADY_EXISTS"
+
+	// Thread errors
+	ErrCodeThreadNotFound ErrorCode = "THREAD_NOT_FOUND"
+
+	// Draft errors
+	ErrCodeDraftNotFound ErrorCode = "DRAFT_NOT_FOUND"
+	ErrCodeDraftConflict ErrorCode = "DRAFT_CONFLICT"
+
+	// Scheduled send errors
+	ErrCodeScheduledSendNotFound ErrorCode = "SCHEDULED_SEND_NOT_FOUND"
+	ErrCodeScheduledSendLocked   ErrorCode = "SCHEDULED_SEND_LOCKED"
+	ErrCodeScheduledSendClosed   ErrorCode = "SCHEDULED_SEND_CLOSED"
+
+	// Identity errors
+	ErrCodeIdentityNotFound      ErrorCode = "IDENTITY_NOT_FOUND"
+	ErrCodeIdentityAlreadyExists ErrorCode = "IDENTITY_ALREADY_EXISTS"
+	ErrCodeDelegationNotFound    ErrorCode = "DELEGATION_NOT_FOUND"
+	ErrCodeSenderNotAllowed      ErrorCode = "SENDER_NOT_ALLOWED"
+
+	// Access control errors
+	ErrCodeGroupNotFound      ErrorCode = "GROUP_NOT_FOUND"
+	ErrCodeGroupAlreadyExists ErrorCode = "GROUP_ALREADY_EXISTS"
+	ErrCodeInvalidRights      ErrorCode = "INVALID_RIGHTS"
+	ErrCodeMissingRights      ErrorCode = "MISSING_RIGHTS"
+
+	// Quarantine errors
+	ErrCodeQuarantineNotFound ErrorCode = "QUARANTINE_NOT_FOUND"
+	ErrCodeInvalidToken       ErrorCode = "INVALID_TOKEN"
+
 	// Quota errors
 	ErrCodeQuotaExceeded        ErrorCode = "QUOTA_EXCEEDED"
 	ErrCodeStorageQuotaExceeded ErrorCode = "STORAGE_QUOTA_EXCEEDED"
 	ErrCodeDailyQuotaExceeded   ErrorCode = "DAILY_QUOTA_EXCEEDED"
+	ErrCodeRateLimitExceeded    ErrorCode = "RATE_LIMIT_EXCEEDED"
+	ErrCodeSendingSuspended     ErrorCode = "SENDING_SUSPENDED"
+	ErrCodeSuspensionNotFound   ErrorCode = "SUSPENSION_NOT_FOUND"
 
 	// Policy errors
 	ErrCodePolicyViolation ErrorCode = "POLICY_VIOLATION"
 	ErrCodePolicyNotFound  ErrorCode = "POLICY_NOT_FOUND"
+	ErrCodeVirusDetected   ErrorCode = "VIRUS_DETECTED"
+	ErrCodeScanFailed      ErrorCode = "SCAN_FAILED"
 
 	// Routing errors
-	ErrCodeRoutingFailed  ErrorCode = "ROUTING_FAILED"
-	ErrCodeDeliveryFailed ErrorCode = "DELIVERY_FAILED"
-	ErrCodeRelayDenied    ErrorCode = "RELAY_DENIED"
+	ErrCodeRoutingFailed             ErrorCode = "ROUTING_FAILED"
+	ErrCodeDeliveryFailed            ErrorCode = "DELIVERY_FAILED"
+	ErrCodeRelayDenied               ErrorCode = "RELAY_DENIED"
+	ErrCodeDestinationPolicyNotFound ErrorCode = "DESTINATION_POLICY_NOT_FOUND"
+	ErrCodeDestinationNotFound       ErrorCode = "DESTINATION_NOT_FOUND"
+	ErrCodeIPPoolNotFound            ErrorCode = "IP_POOL_NOT_FOUND"
+	ErrCodeIPPoolExhausted           ErrorCode = "IP_POOL_EXHAUSTED"
+
+	// DKIM errors
+	ErrCodeDKIMKeyNotFound        ErrorCode = "DKIM_KEY_NOT_FOUND"
+	ErrCodeDKIMRotationInProgress ErrorCode = "DKIM_ROTATION_IN_PROGRESS"
+
+	// Storage errors
+	ErrCodeBlobNotFound ErrorCode = "BLOB_NOT_FOUND"
+	ErrCodeInvalidRange ErrorCode = "INVALID_RANGE"
+
+	// Event errors
+	ErrCodeDeadLetterNotFound ErrorCode = "DEAD_LETTER_NOT_FOUND"
 
 	// System errors
 	ErrCodeInternalError   ErrorCode = "INTERNAL_ERROR"
@@ -138,12 +190,105 @@ func MessageNotFound(id string) *Error {
 	return NewError(ErrCodeMessageNotFound, "Message not found").WithDetail("message_id", id)
 }
 
+func FolderNotFound(id string) *Error {
+	return NewError(ErrCodeFolderNotFound, "Folder not found").WithDetail("folder_id", id)
+}
+
+func FolderAlreadyExists(path string) *Error {
+	return NewError(ErrCodeFolderAlreadyExists, "Folder already exists").WithDetail("path", path)
+}
+
+func ThreadNotFound(id string) *Error {
+	return NewError(ErrCodeThreadNotFound, "Thread not found").WithDetail("thread_id", id)
+}
+
+func DraftNotFound(id string) *Error {
+	return NewError(ErrCodeDraftNotFound, "Draft not found").WithDetail("draft_id", id)
+}
+
+func DraftConflict(id string) *Error {
+	return NewError(ErrCodeDraftConflict, "Draft was changed by another session").WithDetail("draft_id", id)
+}
+
+func ScheduledSendNotFound(id string) *Error {
+	return NewError(ErrCodeScheduledSendNotFound, "Scheduled send not found").WithDetail("scheduled_send_id", id)
+}
+
+func ScheduledSendLocked(id string) *Error {
+	return NewError(ErrCodeScheduledSendLocked, "Message is being sent or edited").WithDetail("scheduled_send_id", id)
+}
+
+func ScheduledSendClosed(id string, status string) *Error {
+	return NewError(ErrCodeScheduledSendClosed, "Scheduled send is no longer pending").
+		WithDetail("scheduled_send_id", id).
+		WithDetail("status", status)
+}
+
+func IdentityNotFound(id string) *Error {
+	return NewError(ErrCodeIdentityNotFound, "Identity not found").WithDetail("identity_id", id)
+}
+
+func IdentityAlreadyExists(email string) *Error {
+	return NewError(ErrCodeIdentityAlreadyExists, "Identity already exists").WithDetail("email", email)
+}
+
+func DelegationNotFound(id string) *Error {
+	return NewError(ErrCodeDelegationNotFound, "Delegation not found").WithDetail("delegation_id", id)
+}
+
+func SenderNotAllowed(email string) *Error {
+	return NewError(ErrCodeSenderNotAllowed, "Account may not send as this address").WithDetail("email", email)
+}
+
+func GroupNotFound(id string) *Error {
+	return NewError(ErrCodeGroupNotFound, "Group not found").WithDetail("group_id", id)
+}
+
+func GroupAlreadyExists(name string) *Error {
+	return NewError(ErrCodeGroupAlreadyExists, "Group already exists").WithDetail("name", name)
+}
+
+func InvalidRights(rights string) *Error {
+	return NewError(ErrCodeInvalidRights, "Invalid access rights").WithDetail("rights", rights)
+}
+
+func MissingRights(folderID, rights string) *Error {
+	return NewError(ErrCodeMissingRights, "Missing rights on the folder").
+		WithDetail("folder_id", folderID).
+		WithDetail("rights", rights)
+}
+
+func QuarantineNotFound(id string) *Error {
+	return NewError(ErrCodeQuarantineNotFound, "Quarantined message not found").WithDetail("quarantine_id", id)
+}
+
+func InvalidToken(reason string) *Error {
+	return NewError(ErrCodeInvalidToken, "Invalid or expired token").WithDetail("reason", reason)
+}
+
 func QuotaExceeded(resource string, limit int) *Error {
 	return NewError(ErrCodeQuotaExceeded, "Quota exceeded").
 		WithDetail("resource", resource).
 		WithDetail("limit", limit)
 }
 
+func RateLimitExceeded(scope string, window string, limit int64) *Error {
+	return NewError(ErrCodeRateLimitExceeded, "Sending rate limit exceeded").
+		WithDetail("scope", scope).
+		WithDetail("window", window).
+		WithDetail("limit", limit)
+}
+
+func SendingSuspended(scope string, reason string) *Error {
+	return NewError(ErrCodeSendingSuspended, "Sending suspended").
+		WithDetail("scope", scope).
+		WithDetail("reason", reason)
+}
+
+func SuspensionNotFound(id string) *Error {
+	return NewError(ErrCodeSuspensionNotFound, "Suspension not found").WithDetail("suspension_id", id)
+}
+
 func MessageRejected(reason string) *Error {
 	return NewError(ErrCodeMessageRejected, "Message rejected").WithDetail("reason", reason)
 }
@@ -154,12 +299,66 @@ func PolicyViolation(policy string, reason string) *Error {
 		WithDetail("reason", reason)
 }
 
+func VirusDetected(signature string) *Error {
+	return NewError(ErrCodeVirusDetected, "Virus detected").WithDetail("signature", signature)
+}
+
+func ScanFailed(cause error) *Error {
+	return NewErrorWithCause(ErrCodeScanFailed, "Virus scan failed", cause)
+}
+
 func RoutingFailed(destination string, reason string) *Error {
 	return NewError(ErrCodeRoutingFailed, "Routing failed").
 		WithDetail("destination", destination).
 		WithDetail("reason", reason)
 }
 
+func DeliveryFailed(destination string, reason string) *Error {
+	return NewError(ErrCodeDeliveryFailed, "Delivery failed").
+		WithDetail("destination", destination).
+		WithDetail("reason", reason)
+}
+
+func DestinationPolicyNotFound(id string) *Error {
+	return NewError(ErrCodeDestinationPolicyNotFound, "Destination policy not found").WithDetail("policy_id", id)
+}
+
+func DestinationNotFound(destination string) *Error {
+	return NewError(ErrCodeDestinationNotFound, "Destination not found").WithDetail("destination", destination)
+}
+
+func IPPoolNotFound(id string) *Error {
+	return NewError(ErrCodeIPPoolNotFound, "IP pool not found").WithDetail("pool_id", id)
+}
+
+func IPPoolExhausted(pool string) *Error {
+	return NewError(ErrCodeIPPoolExhausted, "No source address with remaining daily volume").WithDetail("pool", pool)
+}
+
+func DKIMKeyNotFound(id string) *Error {
+	return NewError(ErrCodeDKIMKeyNotFound, "DKIM key not found").WithDetail("key_id", id)
+}
+
+func DKIMRotationInProgress(domainName string, selector string) *Error {
+	return NewError(ErrCodeDKIMRotationInProgress, "A DKIM rotation is already in progress").
+		WithDetail("domain", domainName).
+		WithDetail("selector", selector)
+}
+
+func BlobNotFound(id string) *Error {
+	return NewError(ErrCodeBlobNotFound, "Blob not found").WithDetail("blob_id", id)
+}
+
+func InvalidRange(offset int64, size int64) *Error {
+	return NewError(ErrCodeInvalidRange, "Requested range is not satisfiable").
+		WithDetail("offset", offset).
+		WithDetail("size", size)
+}
+
+func DeadLetterNotFound(id string) *Error {
+	return NewError(ErrCodeDeadLetterNotFound, "Dead letter not found").WithDetail("event_id", id)
+}
+
 func InternalError(cause error) *Error {
 	return NewErrorWithCause(ErrCodeInternalError, "Internal error occurred", cause)
 }
@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS sending_suspensions;
+DROP TABLE IF EXISTS rate_counters;
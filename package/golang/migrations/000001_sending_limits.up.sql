@@ -0,0 +1,23 @@
+CREATE TABLE IF NOT EXISTS rate_counters (
+    key    TEXT        NOT NULL,
+    bucket TIMESTAMPTZ NOT NULL,
+    count  BIGINT      NOT NULL DEFAULT 0,
+    PRIMARY KEY (key, bucket)
+);
+
+CREATE INDEX IF NOT EXISTS rate_counters_bucket_idx ON rate_counters (bucket);
+
+CREATE TABLE IF NOT EXISTS sending_suspensions (
+    id           UUID        PRIMARY KEY,
+    scope        TEXT        NOT NULL,
+    key          TEXT        NOT NULL,
+    reason       TEXT        NOT NULL,
+    suspended_at TIMESTAMPTZ NOT NULL,
+    expires_at   TIMESTAMPTZ,
+    lifted_at    TIMESTAMPTZ,
+    lifted_by    TEXT
+);
+
+CREATE INDEX IF NOT EXISTS sending_suspensions_active_idx
+    ON sending_suspensions (scope, key)
+    WHERE lifted_at IS NULL;
@@ -0,0 +1,13 @@
+CREATE TABLE IF NOT EXISTS destination_policies (
+    id                          UUID        PRIMARY KEY,
+    name                        TEXT        NOT NULL,
+    mx_pattern                  TEXT        NOT NULL UNIQUE,
+    max_connections             INTEGER     NOT NULL,
+    max_messages_per_connection INTEGER     NOT NULL DEFAULT 0,
+    max_messages_per_minute     INTEGER     NOT NULL DEFAULT 0,
+    backoff_ms                  BIGINT      NOT NULL DEFAULT 0,
+    max_backoff_ms              BIGINT      NOT NULL DEFAULT 0,
+    is_active                   BOOLEAN     NOT NULL DEFAULT TRUE,
+    created_at                  TIMESTAMPTZ NOT NULL,
+    updated_at                  TIMESTAMPTZ NOT NULL
+);
@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS ip_pool_assignments;
+DROP TABLE IF EXISTS ip_pool_addresses;
+DROP TABLE IF EXISTS ip_pools;
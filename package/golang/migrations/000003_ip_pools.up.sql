@@ -0,0 +1,30 @@
+CREATE TABLE IF NOT EXISTS ip_pools (
+    id          UUID        PRIMARY KEY,
+    name        TEXT        NOT NULL UNIQUE,
+    description TEXT        NOT NULL DEFAULT '',
+    is_default  BOOLEAN     NOT NULL DEFAULT FALSE,
+    created_at  TIMESTAMPTZ NOT NULL,
+    updated_at  TIMESTAMPTZ NOT NULL
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS ip_pools_default_idx ON ip_pools (is_default) WHERE is_default;
+
+CREATE TABLE IF NOT EXISTS ip_pool_addresses (
+    pool_id           UUID        NOT NULL REFERENCES ip_pools (id) ON DELETE CASCADE,
+    ip                TEXT        NOT NULL UNIQUE,
+    helo_name         TEXT        NOT NULL,
+    is_active         BOOLEAN     NOT NULL DEFAULT TRUE,
+    warmup_started_at TIMESTAMPTZ,
+    position          INTEGER     NOT NULL,
+    PRIMARY KEY (pool_id, ip)
+);
+
+CREATE TABLE IF NOT EXISTS ip_pool_assignments (
+    scope      TEXT        NOT NULL,
+    key        TEXT        NOT NULL,
+    pool_id    UUID        NOT NULL REFERENCES ip_pools (id) ON DELETE CASCADE,
+    created_at TIMESTAMPTZ NOT NULL,
+    PRIMARY KEY (scope, key)
+);
+
+CREATE INDEX IF NOT EXISTS ip_pool_assignments_pool_idx ON ip_pool_assignments (pool_id);
@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS tls_results;
+DROP TABLE IF EXISTS mta_sts_policies;
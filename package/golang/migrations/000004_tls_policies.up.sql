@@ -0,0 +1,25 @@
+CREATE TABLE IF NOT EXISTS mta_sts_policies (
+    domain          TEXT        PRIMARY KEY,
+    policy_id       TEXT        NOT NULL,
+    mode            TEXT        NOT NULL,
+    mx              TEXT[]      NOT NULL DEFAULT '{}',
+    max_age_seconds BIGINT      NOT NULL,
+    policy_text     TEXT        NOT NULL,
+    fetched_at      TIMESTAMPTZ NOT NULL,
+    expires_at      TIMESTAMPTZ NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_mta_sts_policies_expires_at ON mta_sts_policies (expires_at);
+
+CREATE TABLE IF NOT EXISTS tls_results (
+    day           DATE   NOT NULL,
+    domain        TEXT   NOT NULL,
+    policy_type   TEXT   NOT NULL,
+    policy_string TEXT   NOT NULL DEFAULT '',
+    mx_host       TEXT   NOT NULL DEFAULT '',
+    result_type   TEXT   NOT NULL DEFAULT '',
+    session_count BIGINT NOT NULL DEFAULT 0,
+    PRIMARY KEY (day, domain, policy_type, policy_string, mx_host, result_type)
+);
+
+CREATE INDEX IF NOT EXISTS idx_tls_results_domain_day ON tls_results (domain, day);
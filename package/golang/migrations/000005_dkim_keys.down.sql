@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS dkim_rotation_log;
+DROP TABLE IF EXISTS dkim_keys;
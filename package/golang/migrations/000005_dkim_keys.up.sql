@@ -0,0 +1,36 @@
+CREATE TABLE IF NOT EXISTS dkim_keys (
+    id                    UUID        PRIMARY KEY,
+    domain                TEXT        NOT NULL,
+    selector              TEXT        NOT NULL,
+    algorithm             TEXT        NOT NULL,
+    key_bits              INTEGER     NOT NULL DEFAULT 0,
+    public_key            TEXT        NOT NULL,
+    encrypted_private_key BYTEA,
+    state                 TEXT        NOT NULL,
+    created_at            TIMESTAMPTZ NOT NULL,
+    published_at          TIMESTAMPTZ,
+    verified_at           TIMESTAMPTZ,
+    activated_at          TIMESTAMPTZ,
+    retire_after          TIMESTAMPTZ,
+    retired_at            TIMESTAMPTZ,
+    updated_at            TIMESTAMPTZ NOT NULL,
+    UNIQUE (domain, selector)
+);
+
+CREATE INDEX IF NOT EXISTS idx_dkim_keys_state ON dkim_keys (state);
+
+-- At most one signing key per domain
+CREATE UNIQUE INDEX IF NOT EXISTS idx_dkim_keys_active ON dkim_keys (domain) WHERE state = 'ACTIVE';
+
+CREATE TABLE IF NOT EXISTS dkim_rotation_log (
+    id       UUID        PRIMARY KEY,
+    domain   TEXT        NOT NULL,
+    key_id   UUID        NOT NULL,
+    selector TEXT        NOT NULL,
+    action   TEXT        NOT NULL,
+    actor    TEXT        NOT NULL,
+    detail   TEXT        NOT NULL DEFAULT '',
+    at       TIMESTAMPTZ NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_dkim_rotation_log_domain_at ON dkim_rotation_log (domain, at DESC);
@@ -0,0 +1,14 @@
+CREATE TABLE IF NOT EXISTS blobs (
+    id          TEXT        PRIMARY KEY,
+    sha256      TEXT        NOT NULL,
+    tenant_id   TEXT        NOT NULL DEFAULT '',
+    storage_key TEXT        NOT NULL,
+    size        BIGINT      NOT NULL,
+    encrypted   BOOLEAN     NOT NULL DEFAULT FALSE,
+    ref_count   INTEGER     NOT NULL DEFAULT 0,
+    created_at  TIMESTAMPTZ NOT NULL,
+    updated_at  TIMESTAMPTZ NOT NULL
+);
+
+-- Garbage collection scans unreferenced blobs by age
+CREATE INDEX IF NOT EXISTS idx_blobs_unreferenced ON blobs (updated_at) WHERE ref_count = 0;
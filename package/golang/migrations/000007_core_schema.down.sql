@@ -0,0 +1,15 @@
+DROP TABLE IF EXISTS quarantine_entries;
+DROP TABLE IF EXISTS spam_message_classes;
+DROP TABLE IF EXISTS spam_totals;
+DROP TABLE IF EXISTS spam_tokens;
+DROP TABLE IF EXISTS policies;
+DROP TABLE IF EXISTS quotas;
+DROP TABLE IF EXISTS attachments;
+DROP TABLE IF EXISTS messages;
+DROP TABLE IF EXISTS folders;
+DROP TABLE IF EXISTS dns_records;
+DROP TABLE IF EXISTS email_aliases;
+DROP TABLE IF EXISTS email_accounts;
+DROP TABLE IF EXISTS domain_members;
+DROP TABLE IF EXISTS domains;
+DROP TABLE IF EXISTS users;
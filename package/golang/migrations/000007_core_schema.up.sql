@@ -0,0 +1,264 @@
+-- Trigram indexes serve the substring filters on messages
+CREATE EXTENSION IF NOT EXISTS pg_trgm;
+
+CREATE TABLE IF NOT EXISTS users (
+    id                  UUID        PRIMARY KEY,
+    username            TEXT        NOT NULL UNIQUE,
+    email               TEXT        NOT NULL UNIQUE,
+    password_hash       TEXT        NOT NULL,
+    first_name          TEXT,
+    last_name           TEXT,
+    display_name        TEXT,
+    role                TEXT        NOT NULL,
+    is_active           BOOLEAN     NOT NULL DEFAULT TRUE,
+    is_verified         BOOLEAN     NOT NULL DEFAULT FALSE,
+    two_factor_enabled  BOOLEAN     NOT NULL DEFAULT FALSE,
+    two_factor_secret   TEXT,
+    created_at          TIMESTAMPTZ NOT NULL,
+    updated_at          TIMESTAMPTZ NOT NULL,
+    last_login_at       TIMESTAMPTZ,
+    password_changed_at TIMESTAMPTZ NOT NULL,
+    timezone            TEXT        NOT NULL DEFAULT 'UTC',
+    locale              TEXT        NOT NULL DEFAULT 'en',
+    theme               TEXT        NOT NULL DEFAULT 'light',
+    max_emails_per_day  INTEGER     NOT NULL DEFAULT 0,
+    max_storage_mb      INTEGER     NOT NULL DEFAULT 0
+);
+
+CREATE INDEX IF NOT EXISTS users_created_idx ON users (created_at DESC);
+
+CREATE TABLE IF NOT EXISTS domains (
+    id                 UUID        PRIMARY KEY,
+    name               TEXT        NOT NULL UNIQUE,
+    display_name       TEXT,
+    description        TEXT,
+    is_active          BOOLEAN     NOT NULL DEFAULT TRUE,
+    is_verified        BOOLEAN     NOT NULL DEFAULT FALSE,
+    max_users          INTEGER     NOT NULL DEFAULT 0,
+    max_emails_per_day INTEGER     NOT NULL DEFAULT 0,
+    max_storage_mb     INTEGER     NOT NULL DEFAULT 0,
+    dkim_selector      TEXT,
+    dkim_public_key    TEXT,
+    spf_record         TEXT,
+    dmarc_record       TEXT,
+    created_at         TIMESTAMPTZ NOT NULL,
+    updated_at         TIMESTAMPTZ NOT NULL,
+    verified_at        TIMESTAMPTZ,
+    owner_id           UUID        NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS domains_owner_idx ON domains (owner_id);
+
+CREATE TABLE IF NOT EXISTS domain_members (
+    id        UUID        PRIMARY KEY,
+    user_id   UUID        NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    domain_id UUID        NOT NULL REFERENCES domains (id) ON DELETE CASCADE,
+    role      TEXT        NOT NULL,
+    joined_at TIMESTAMPTZ NOT NULL,
+    UNIQUE (domain_id, user_id)
+);
+
+CREATE INDEX IF NOT EXISTS domain_members_user_idx ON domain_members (user_id);
+
+CREATE TABLE IF NOT EXISTS email_accounts (
+    id            UUID        PRIMARY KEY,
+    user_id       UUID        NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    domain_id     UUID        NOT NULL REFERENCES domains (id) ON DELETE CASCADE,
+    email         TEXT        NOT NULL UNIQUE,
+    display_name  TEXT,
+    password_hash TEXT        NOT NULL,
+    is_active     BOOLEAN     NOT NULL DEFAULT TRUE,
+    is_verified   BOOLEAN     NOT NULL DEFAULT FALSE,
+    quota_mb      INTEGER     NOT NULL DEFAULT 0,
+    used_mb       INTEGER     NOT NULL DEFAULT 0,
+    created_at    TIMESTAMPTZ NOT NULL,
+    updated_at    TIMESTAMPTZ NOT NULL,
+    last_login_at TIMESTAMPTZ
+);
+
+CREATE INDEX IF NOT EXISTS email_accounts_user_idx ON email_accounts (user_id);
+CREATE INDEX IF NOT EXISTS email_accounts_domain_idx ON email_accounts (domain_id);
+
+CREATE TABLE IF NOT EXISTS email_aliases (
+    id         UUID        PRIMARY KEY,
+    domain_id  UUID        NOT NULL REFERENCES domains (id) ON DELETE CASCADE,
+    alias      TEXT        NOT NULL UNIQUE,
+    dest_email TEXT        NOT NULL,
+    is_active  BOOLEAN     NOT NULL DEFAULT TRUE,
+    created_at TIMESTAMPTZ NOT NULL,
+    updated_at TIMESTAMPTZ NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS email_aliases_domain_idx ON email_aliases (domain_id);
+
+CREATE TABLE IF NOT EXISTS dns_records (
+    id        UUID    PRIMARY KEY,
+    domain_id UUID    NOT NULL REFERENCES domains (id) ON DELETE CASCADE,
+    type      TEXT    NOT NULL,
+    name      TEXT,
+    value     TEXT,
+    priority  INTEGER,
+    ttl       INTEGER
+);
+
+CREATE INDEX IF NOT EXISTS dns_records_domain_idx ON dns_records (domain_id);
+
+CREATE TABLE IF NOT EXISTS folders (
+    id            UUID        PRIMARY KEY,
+    account_id    UUID        NOT NULL REFERENCES email_accounts (id) ON DELETE CASCADE,
+    parent_id     UUID        REFERENCES folders (id) ON DELETE CASCADE,
+    name          TEXT        NOT NULL,
+    path          TEXT        NOT NULL,
+    type          TEXT        NOT NULL,
+    is_subscribed BOOLEAN     NOT NULL DEFAULT TRUE,
+    created_at    TIMESTAMPTZ NOT NULL,
+    updated_at    TIMESTAMPTZ NOT NULL,
+    UNIQUE (account_id, path)
+);
+
+CREATE INDEX IF NOT EXISTS folders_account_type_idx ON folders (account_id, type);
+
+CREATE TABLE IF NOT EXISTS messages (
+    id            UUID        PRIMARY KEY,
+    account_id    UUID        NOT NULL REFERENCES email_accounts (id) ON DELETE CASCADE,
+    folder_id     UUID,
+    from_address  TEXT        NOT NULL DEFAULT '',
+    to_addresses  TEXT[]      NOT NULL DEFAULT '{}',
+    cc_addresses  TEXT[]      NOT NULL DEFAULT '{}',
+    bcc_addresses TEXT[]      NOT NULL DEFAULT '{}',
+    -- The To addresses joined, for the recipient filter
+    recipients    TEXT        NOT NULL DEFAULT '',
+    subject       TEXT        NOT NULL DEFAULT '',
+    body_text     TEXT,
+    body_html     TEXT,
+    headers       JSONB       NOT NULL DEFAULT '{}',
+    size          BIGINT      NOT NULL DEFAULT 0,
+    is_read       BOOLEAN     NOT NULL DEFAULT FALSE,
+    is_draft      BOOLEAN     NOT NULL DEFAULT FALSE,
+    is_sent       BOOLEAN     NOT NULL DEFAULT FALSE,
+    is_deleted    BOOLEAN     NOT NULL DEFAULT FALSE,
+    received_at   TIMESTAMPTZ NOT NULL,
+    sent_at       TIMESTAMPTZ,
+    created_at    TIMESTAMPTZ NOT NULL,
+    updated_at    TIMESTAMPTZ NOT NULL,
+    search_vector TSVECTOR GENERATED ALWAYS AS (
+        setweight(to_tsvector('simple'::regconfig, subject), 'A') ||
+        setweight(to_tsvector('simple'::regconfig, from_address || ' ' || recipients), 'B') ||
+        setweight(to_tsvector('simple'::regconfig, COALESCE(body_text, '')), 'C')
+    ) STORED
+);
+
+-- Listing is per account, newest first; the partial indexes serve the
+-- unread and trash views
+CREATE INDEX IF NOT EXISTS messages_account_received_idx ON messages (account_id, received_at DESC);
+CREATE INDEX IF NOT EXISTS messages_folder_received_idx ON messages (folder_id, received_at DESC);
+CREATE INDEX IF NOT EXISTS messages_unread_idx ON messages (account_id, received_at DESC)
+    WHERE NOT is_read AND NOT is_deleted;
+CREATE INDEX IF NOT EXISTS messages_deleted_idx ON messages (account_id, received_at DESC)
+    WHERE is_deleted;
+CREATE INDEX IF NOT EXISTS messages_from_trgm_idx ON messages USING GIN (from_address gin_trgm_ops);
+CREATE INDEX IF NOT EXISTS messages_recipients_trgm_idx ON messages USING GIN (recipients gin_trgm_ops);
+CREATE INDEX IF NOT EXISTS messages_subject_trgm_idx ON messages USING GIN (subject gin_trgm_ops);
+CREATE INDEX IF NOT EXISTS messages_search_idx ON messages USING GIN (search_vector);
+
+-- Attachments are written before their message, so they have no foreign key
+CREATE TABLE IF NOT EXISTS attachments (
+    id           UUID   PRIMARY KEY,
+    message_id   UUID   NOT NULL,
+    filename     TEXT   NOT NULL,
+    content_type TEXT   NOT NULL,
+    size         BIGINT NOT NULL DEFAULT 0,
+    content      BYTEA,
+    blob_id      TEXT   NOT NULL DEFAULT '',
+    checksum     TEXT   NOT NULL DEFAULT ''
+);
+
+CREATE INDEX IF NOT EXISTS attachments_message_idx ON attachments (message_id);
+CREATE INDEX IF NOT EXISTS attachments_blob_idx ON attachments (blob_id) WHERE blob_id <> '';
+
+-- User quotas have a user; domain quotas only a domain
+CREATE TABLE IF NOT EXISTS quotas (
+    id                 BIGSERIAL   PRIMARY KEY,
+    user_id            UUID,
+    domain_id          UUID,
+    max_storage_mb     INTEGER     NOT NULL DEFAULT 0,
+    used_storage_mb    INTEGER     NOT NULL DEFAULT 0,
+    max_emails_per_day INTEGER     NOT NULL DEFAULT 0,
+    sent_emails_today  INTEGER     NOT NULL DEFAULT 0,
+    reset_at           TIMESTAMPTZ NOT NULL
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS quotas_user_idx ON quotas (user_id) WHERE user_id IS NOT NULL;
+CREATE UNIQUE INDEX IF NOT EXISTS quotas_domain_idx ON quotas (domain_id) WHERE user_id IS NULL;
+
+CREATE TABLE IF NOT EXISTS policies (
+    id         UUID        PRIMARY KEY,
+    domain_id  UUID,
+    user_id    UUID,
+    name       TEXT        NOT NULL,
+    type       TEXT        NOT NULL,
+    rule       TEXT        NOT NULL DEFAULT '',
+    action     TEXT        NOT NULL,
+    is_active  BOOLEAN     NOT NULL DEFAULT TRUE,
+    priority   INTEGER     NOT NULL DEFAULT 0,
+    created_at TIMESTAMPTZ NOT NULL,
+    updated_at TIMESTAMPTZ NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS policies_active_idx ON policies (type, priority DESC) WHERE is_active;
+CREATE INDEX IF NOT EXISTS policies_domain_idx ON policies (domain_id);
+CREATE INDEX IF NOT EXISTS policies_user_idx ON policies (user_id);
+
+CREATE TABLE IF NOT EXISTS spam_tokens (
+    account_id TEXT        NOT NULL,
+    token      TEXT        NOT NULL,
+    spam_count INTEGER     NOT NULL DEFAULT 0,
+    ham_count  INTEGER     NOT NULL DEFAULT 0,
+    updated_at TIMESTAMPTZ NOT NULL,
+    PRIMARY KEY (account_id, token)
+);
+
+CREATE TABLE IF NOT EXISTS spam_totals (
+    account_id    TEXT        PRIMARY KEY,
+    spam_messages INTEGER     NOT NULL DEFAULT 0,
+    ham_messages  INTEGER     NOT NULL DEFAULT 0,
+    updated_at    TIMESTAMPTZ NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS spam_message_classes (
+    account_id TEXT NOT NULL,
+    message_id TEXT NOT NULL,
+    class      TEXT NOT NULL,
+    PRIMARY KEY (account_id, message_id)
+);
+
+CREATE TABLE IF NOT EXISTS quarantine_entries (
+    id             UUID        PRIMARY KEY,
+    message_id     TEXT        NOT NULL,
+    account_id     TEXT        NOT NULL,
+    domain_id      TEXT        NOT NULL DEFAULT '',
+    sender         TEXT        NOT NULL DEFAULT '',
+    recipients     TEXT[]      NOT NULL DEFAULT '{}',
+    subject        TEXT        NOT NULL DEFAULT '',
+    reason         TEXT        NOT NULL,
+    details        TEXT        NOT NULL DEFAULT '',
+    policy_id      TEXT,
+    policy_name    TEXT,
+    spam_verdict   JSONB,
+    virus_verdict  JSONB,
+    message        JSONB       NOT NULL,
+    raw_message    BYTEA,
+    size           BIGINT      NOT NULL DEFAULT 0,
+    status         TEXT        NOT NULL,
+    created_at     TIMESTAMPTZ NOT NULL,
+    expires_at     TIMESTAMPTZ NOT NULL,
+    released_at    TIMESTAMPTZ,
+    released_by    TEXT,
+    digest_sent_at TIMESTAMPTZ
+);
+
+CREATE INDEX IF NOT EXISTS quarantine_account_created_idx ON quarantine_entries (account_id, created_at DESC);
+CREATE INDEX IF NOT EXISTS quarantine_domain_created_idx ON quarantine_entries (domain_id, created_at DESC);
+CREATE INDEX IF NOT EXISTS quarantine_expires_idx ON quarantine_entries (expires_at);
+CREATE INDEX IF NOT EXISTS quarantine_digest_idx ON quarantine_entries (account_id)
+    WHERE status = 'HELD' AND digest_sent_at IS NULL;
@@ -0,0 +1,27 @@
+-- Append-only event log read by the event store to rebuild projections
+CREATE TABLE IF NOT EXISTS events (
+    seq          BIGSERIAL   PRIMARY KEY,
+    id           TEXT        NOT NULL UNIQUE,
+    aggregate_id TEXT        NOT NULL,
+    event_type   TEXT        NOT NULL,
+    payload      JSONB       NOT NULL,
+    occurred_at  TIMESTAMPTZ NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS events_aggregate_idx ON events (aggregate_id, occurred_at DESC, seq DESC);
+CREATE INDEX IF NOT EXISTS events_type_idx ON events (event_type, occurred_at DESC, seq DESC);
+
+-- Delivery state of each event, written in the transaction that raised it
+CREATE TABLE IF NOT EXISTS event_outbox (
+    event_id        TEXT        PRIMARY KEY REFERENCES events (id) ON DELETE CASCADE,
+    status          TEXT        NOT NULL DEFAULT 'PENDING',
+    attempts        INTEGER     NOT NULL DEFAULT 0,
+    next_attempt_at TIMESTAMPTZ NOT NULL,
+    last_error      TEXT        NOT NULL DEFAULT '',
+    created_at      TIMESTAMPTZ NOT NULL,
+    delivered_at    TIMESTAMPTZ
+);
+
+-- The relay polls pending entries by due time
+CREATE INDEX IF NOT EXISTS event_outbox_due_idx ON event_outbox (next_attempt_at) WHERE status = 'PENDING';
+CREATE INDEX IF NOT EXISTS event_outbox_status_idx ON event_outbox (status, created_at DESC);
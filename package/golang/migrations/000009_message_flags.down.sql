@@ -0,0 +1,7 @@
+DROP INDEX IF EXISTS messages_labels_idx;
+DROP INDEX IF EXISTS messages_starred_idx;
+
+ALTER TABLE messages
+    DROP COLUMN IF EXISTS labels,
+    DROP COLUMN IF EXISTS is_flagged,
+    DROP COLUMN IF EXISTS is_starred;
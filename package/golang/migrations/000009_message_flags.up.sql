@@ -0,0 +1,9 @@
+-- Starred and flagged messages and free-form labels
+ALTER TABLE messages
+    ADD COLUMN IF NOT EXISTS is_starred BOOLEAN NOT NULL DEFAULT FALSE,
+    ADD COLUMN IF NOT EXISTS is_flagged BOOLEAN NOT NULL DEFAULT FALSE,
+    ADD COLUMN IF NOT EXISTS labels     TEXT[]  NOT NULL DEFAULT '{}';
+
+CREATE INDEX IF NOT EXISTS messages_starred_idx ON messages (account_id, received_at DESC)
+    WHERE is_starred;
+CREATE INDEX IF NOT EXISTS messages_labels_idx ON messages USING GIN (labels);
@@ -0,0 +1,8 @@
+ALTER TABLE messages ADD COLUMN IF NOT EXISTS search_vector TSVECTOR GENERATED ALWAYS AS (
+    setweight(to_tsvector('simple'::regconfig, subject), 'A') ||
+    setweight(to_tsvector('simple'::regconfig, from_address || ' ' || recipients), 'B') ||
+    setweight(to_tsvector('simple'::regconfig, COALESCE(body_text, '')), 'C')
+) STORED;
+CREATE INDEX IF NOT EXISTS messages_search_idx ON messages USING GIN (search_vector);
+
+DROP TABLE IF EXISTS message_search;
@@ -0,0 +1,30 @@
+-- Full-text search documents, written by the application from the words
+-- it tokenizes so that queries and documents agree. The weights mark the
+-- subject (A), sender (B), recipients (C) and body with attachments (D).
+CREATE TABLE IF NOT EXISTS message_search (
+    message_id UUID        PRIMARY KEY REFERENCES messages (id) ON DELETE CASCADE,
+    document   TSVECTOR    NOT NULL,
+    indexed_at TIMESTAMPTZ NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS message_search_document_idx ON message_search USING GIN (document);
+
+-- Approximate documents for the stored messages; reindexing the accounts
+-- rebuilds them exactly and adds the text of their attachments
+INSERT INTO message_search (message_id, document, indexed_at)
+SELECT m.id,
+    setweight(to_tsvector('simple'::regconfig, regexp_replace(lower(m.subject), '[^[:alnum:]]+', ' ', 'g')), 'A') ||
+    setweight(to_tsvector('simple'::regconfig, regexp_replace(lower(m.from_address), '[^[:alnum:]]+', ' ', 'g')), 'B') ||
+    setweight(to_tsvector('simple'::regconfig, regexp_replace(
+        lower(array_to_string(m.to_addresses || m.cc_addresses, ' ')), '[^[:alnum:]]+', ' ', 'g')), 'C') ||
+    setweight(to_tsvector('simple'::regconfig, regexp_replace(
+        lower(COALESCE(NULLIF(btrim(m.body_text), ''), regexp_replace(m.body_html, '<[^>]*>', ' ', 'g'), '') || ' ' ||
+            COALESCE((SELECT string_agg(a.filename, ' ') FROM attachments a WHERE a.message_id = m.id), '')),
+        '[^[:alnum:]]+', ' ', 'g')), 'D'),
+    now()
+FROM messages m
+ON CONFLICT (message_id) DO NOTHING;
+
+-- The generated vector is replaced by the documents
+DROP INDEX IF EXISTS messages_search_idx;
+ALTER TABLE messages DROP COLUMN IF EXISTS search_vector;
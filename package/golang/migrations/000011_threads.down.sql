@@ -0,0 +1,6 @@
+DROP INDEX IF EXISTS messages_thread_idx;
+ALTER TABLE messages DROP COLUMN IF EXISTS thread_id;
+
+DROP TABLE IF EXISTS thread_settings;
+DROP TABLE IF EXISTS thread_message_ids;
+DROP TABLE IF EXISTS threads;
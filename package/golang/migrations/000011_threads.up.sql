@@ -0,0 +1,39 @@
+-- Conversation threads with the aggregates of their messages
+CREATE TABLE IF NOT EXISTS threads (
+    id              UUID        PRIMARY KEY,
+    account_id      UUID        NOT NULL REFERENCES email_accounts (id) ON DELETE CASCADE,
+    subject         TEXT        NOT NULL DEFAULT '',
+    base_subject    TEXT        NOT NULL DEFAULT '',
+    message_count   INTEGER     NOT NULL DEFAULT 0,
+    unread_count    INTEGER     NOT NULL DEFAULT 0,
+    has_attachments BOOLEAN     NOT NULL DEFAULT FALSE,
+    is_starred      BOOLEAN     NOT NULL DEFAULT FALSE,
+    participants    TEXT[]      NOT NULL DEFAULT '{}',
+    last_message_at TIMESTAMPTZ NOT NULL,
+    created_at      TIMESTAMPTZ NOT NULL,
+    updated_at      TIMESTAMPTZ NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS threads_account_last_idx ON threads (account_id, last_message_at DESC);
+CREATE INDEX IF NOT EXISTS threads_subject_idx ON threads (account_id, base_subject, last_message_at DESC);
+
+-- The Message-IDs that lead to a thread: those of its messages and those
+-- they refer to, so that a late parent finds the thread of its replies
+CREATE TABLE IF NOT EXISTS thread_message_ids (
+    account_id UUID NOT NULL REFERENCES email_accounts (id) ON DELETE CASCADE,
+    message_id TEXT NOT NULL,
+    thread_id  UUID NOT NULL REFERENCES threads (id) ON DELETE CASCADE,
+    PRIMARY KEY (account_id, message_id)
+);
+
+CREATE INDEX IF NOT EXISTS thread_message_ids_thread_idx ON thread_message_ids (thread_id);
+
+CREATE TABLE IF NOT EXISTS thread_settings (
+    account_id   UUID        PRIMARY KEY REFERENCES email_accounts (id) ON DELETE CASCADE,
+    subject_mode TEXT        NOT NULL,
+    updated_at   TIMESTAMPTZ NOT NULL
+);
+
+-- Messages may be stored outside Postgres, so the thread is not a foreign key
+ALTER TABLE messages ADD COLUMN IF NOT EXISTS thread_id UUID;
+CREATE INDEX IF NOT EXISTS messages_thread_idx ON messages (thread_id, received_at DESC);
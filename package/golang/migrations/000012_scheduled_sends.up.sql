@@ -0,0 +1,26 @@
+-- Drafts held until they are due to be sent: send later and undo send.
+-- Drafts may be stored outside Postgres, so the draft is not a foreign key.
+CREATE TABLE IF NOT EXISTS scheduled_sends (
+    id              UUID        PRIMARY KEY,
+    account_id      UUID        NOT NULL REFERENCES email_accounts (id) ON DELETE CASCADE,
+    draft_id        UUID        NOT NULL,
+    kind            TEXT        NOT NULL,
+    status          TEXT        NOT NULL DEFAULT 'PENDING',
+    send_at         TIMESTAMPTZ NOT NULL,
+    next_attempt_at TIMESTAMPTZ NOT NULL,
+    locked_until    TIMESTAMPTZ,
+    attempts        INTEGER     NOT NULL DEFAULT 0,
+    last_error      TEXT        NOT NULL DEFAULT '',
+    sent_message_id UUID,
+    created_at      TIMESTAMPTZ NOT NULL,
+    updated_at      TIMESTAMPTZ NOT NULL,
+    completed_at    TIMESTAMPTZ
+);
+
+CREATE INDEX IF NOT EXISTS scheduled_sends_due_idx ON scheduled_sends (next_attempt_at)
+    WHERE status = 'PENDING';
+CREATE INDEX IF NOT EXISTS scheduled_sends_account_idx ON scheduled_sends (account_id, send_at);
+CREATE UNIQUE INDEX IF NOT EXISTS scheduled_sends_draft_idx ON scheduled_sends (draft_id)
+    WHERE status = 'PENDING';
+CREATE INDEX IF NOT EXISTS scheduled_sends_completed_idx ON scheduled_sends (completed_at)
+    WHERE status <> 'PENDING';
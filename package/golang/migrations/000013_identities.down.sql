@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS sender_delegations;
+DROP TABLE IF EXISTS identities;
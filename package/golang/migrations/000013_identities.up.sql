@@ -0,0 +1,36 @@
+-- Addresses an account sends as, and delegations letting an account send
+-- as, or on behalf of, another mailbox
+CREATE TABLE IF NOT EXISTS identities (
+    id             UUID        PRIMARY KEY,
+    account_id     UUID        NOT NULL REFERENCES email_accounts (id) ON DELETE CASCADE,
+    domain_id      UUID        NOT NULL REFERENCES domains (id) ON DELETE CASCADE,
+    email          TEXT        NOT NULL,
+    name           TEXT        NOT NULL DEFAULT '',
+    reply_to       TEXT        NOT NULL DEFAULT '',
+    signature_text TEXT        NOT NULL DEFAULT '',
+    signature_html TEXT        NOT NULL DEFAULT '',
+    is_default     BOOLEAN     NOT NULL DEFAULT FALSE,
+    status         TEXT        NOT NULL DEFAULT 'PENDING',
+    reviewed_by    UUID,
+    reviewed_at    TIMESTAMPTZ,
+    created_at     TIMESTAMPTZ NOT NULL,
+    updated_at     TIMESTAMPTZ NOT NULL,
+    UNIQUE (account_id, email)
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS identities_default_idx ON identities (account_id) WHERE is_default;
+CREATE INDEX IF NOT EXISTS identities_domain_idx ON identities (domain_id, status, created_at);
+
+CREATE TABLE IF NOT EXISTS sender_delegations (
+    id                  UUID        PRIMARY KEY,
+    account_id          UUID        NOT NULL REFERENCES email_accounts (id) ON DELETE CASCADE,
+    delegate_account_id UUID        NOT NULL REFERENCES email_accounts (id) ON DELETE CASCADE,
+    permission          TEXT        NOT NULL,
+    granted_by          UUID        NOT NULL,
+    created_at          TIMESTAMPTZ NOT NULL,
+    updated_at          TIMESTAMPTZ NOT NULL,
+    UNIQUE (account_id, delegate_account_id),
+    CHECK (account_id <> delegate_account_id)
+);
+
+CREATE INDEX IF NOT EXISTS sender_delegations_delegate_idx ON sender_delegations (delegate_account_id);
@@ -0,0 +1,6 @@
+DROP TABLE IF EXISTS acl_audit_log;
+DROP TABLE IF EXISTS folder_acls;
+DROP TABLE IF EXISTS user_group_members;
+DROP TABLE IF EXISTS user_groups;
+DELETE FROM email_accounts WHERE user_id IS NULL;
+ALTER TABLE email_accounts ALTER COLUMN user_id SET NOT NULL;
@@ -0,0 +1,63 @@
+-- Shared mailboxes are email accounts no user owns
+ALTER TABLE email_accounts ALTER COLUMN user_id DROP NOT NULL;
+
+CREATE TABLE IF NOT EXISTS user_groups (
+    id          UUID        PRIMARY KEY,
+    domain_id   UUID        NOT NULL REFERENCES domains (id) ON DELETE CASCADE,
+    name        TEXT        NOT NULL,
+    description TEXT        NOT NULL DEFAULT '',
+    created_at  TIMESTAMPTZ NOT NULL,
+    updated_at  TIMESTAMPTZ NOT NULL,
+    UNIQUE (domain_id, name)
+);
+
+CREATE TABLE IF NOT EXISTS user_group_members (
+    group_id UUID        NOT NULL REFERENCES user_groups (id) ON DELETE CASCADE,
+    user_id  UUID        NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    added_at TIMESTAMPTZ NOT NULL,
+    PRIMARY KEY (group_id, user_id)
+);
+
+CREATE INDEX IF NOT EXISTS user_group_members_user_idx ON user_group_members (user_id);
+
+-- Access control lists of folders (IMAP ACL, RFC 4314). An entry grants
+-- rights to a user, a group, or anyone when both are NULL.
+CREATE TABLE IF NOT EXISTS folder_acls (
+    id           UUID        PRIMARY KEY,
+    folder_id    UUID        NOT NULL REFERENCES folders (id) ON DELETE CASCADE,
+    account_id   UUID        NOT NULL REFERENCES email_accounts (id) ON DELETE CASCADE,
+    subject_type TEXT        NOT NULL,
+    user_id      UUID        REFERENCES users (id) ON DELETE CASCADE,
+    group_id     UUID        REFERENCES user_groups (id) ON DELETE CASCADE,
+    rights       TEXT        NOT NULL,
+    granted_by   UUID        NOT NULL,
+    created_at   TIMESTAMPTZ NOT NULL,
+    updated_at   TIMESTAMPTZ NOT NULL,
+    CHECK ((subject_type = 'USER') = (user_id IS NOT NULL)),
+    CHECK ((subject_type = 'GROUP') = (group_id IS NOT NULL))
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS folder_acls_subject_idx
+    ON folder_acls (folder_id, subject_type, COALESCE(user_id::text, group_id::text, ''));
+CREATE INDEX IF NOT EXISTS folder_acls_account_idx ON folder_acls (account_id);
+CREATE INDEX IF NOT EXISTS folder_acls_user_idx ON folder_acls (user_id) WHERE user_id IS NOT NULL;
+CREATE INDEX IF NOT EXISTS folder_acls_group_idx ON folder_acls (group_id) WHERE group_id IS NOT NULL;
+
+-- Audit log of access changes; entries outlive the objects they name
+CREATE TABLE IF NOT EXISTS acl_audit_log (
+    id           UUID        PRIMARY KEY,
+    domain_id    TEXT        NOT NULL,
+    account_id   TEXT        NOT NULL DEFAULT '',
+    folder_id    TEXT        NOT NULL DEFAULT '',
+    group_id     TEXT        NOT NULL DEFAULT '',
+    action       TEXT        NOT NULL,
+    subject_type TEXT        NOT NULL DEFAULT '',
+    subject_id   TEXT        NOT NULL DEFAULT '',
+    old_rights   TEXT        NOT NULL DEFAULT '',
+    new_rights   TEXT        NOT NULL DEFAULT '',
+    actor_id     TEXT        NOT NULL,
+    created_at   TIMESTAMPTZ NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS acl_audit_log_domain_idx ON acl_audit_log (domain_id, created_at DESC);
+CREATE INDEX IF NOT EXISTS acl_audit_log_account_idx ON acl_audit_log (account_id, created_at DESC);
@@ -0,0 +1,27 @@
+-- Outbound queue: one job per message and recipient domain. A job is leased
+-- to the delivery instance holding it in memory; the lease is renewed while
+-- the job waits and expires when the instance stops.
+-- Messages may be stored outside Postgres, so the message is not a foreign key.
+CREATE TABLE IF NOT EXISTS delivery_jobs (
+    id              UUID        PRIMARY KEY,
+    message_id      TEXT        NOT NULL DEFAULT '',
+    account_id      TEXT        NOT NULL DEFAULT '',
+    domain_id       TEXT        NOT NULL DEFAULT '',
+    tenant_id       TEXT        NOT NULL DEFAULT '',
+    stream          TEXT        NOT NULL DEFAULT '',
+    mail_from       TEXT        NOT NULL,
+    recipients      TEXT[]      NOT NULL,
+    domain          TEXT        NOT NULL,
+    data            BYTEA       NOT NULL,
+    attempts        INTEGER     NOT NULL DEFAULT 0,
+    last_error      TEXT        NOT NULL DEFAULT '',
+    queued_at       TIMESTAMPTZ NOT NULL,
+    next_attempt_at TIMESTAMPTZ NOT NULL,
+    locked_by       TEXT,
+    locked_until    TIMESTAMPTZ
+);
+
+CREATE INDEX IF NOT EXISTS delivery_jobs_due_idx ON delivery_jobs (next_attempt_at);
+CREATE INDEX IF NOT EXISTS delivery_jobs_owner_idx ON delivery_jobs (locked_by) WHERE locked_by IS NOT NULL;
+CREATE UNIQUE INDEX IF NOT EXISTS delivery_jobs_message_domain_idx ON delivery_jobs (message_id, domain)
+    WHERE message_id <> '';
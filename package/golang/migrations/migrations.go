@@ -0,0 +1,10 @@
+// Package migrations embeds the versioned SQL migrations of the SDK schema.
+// Files are named NNNNNN_name.up.sql and NNNNNN_name.down.sql.
+package migrations
+
+import "embed"
+
+// FS holds the up and down migrations
+//
+//go:embed *.sql
+var FS embed.FS
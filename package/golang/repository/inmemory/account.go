@@ -0,0 +1,901 @@
+package inmemory
+
+import (
+	"context"
+	"sort"
+
+	"github.com/skygenesisenterprise/aether-mailer/package/golang/domain"
+	"github.com/skygenesisenterprise/aether-mailer/package/golang/repository"
+)
+
+// UserRepository stores users in memory
+type UserRepository struct {
+	store *Store
+}
+
+// NewUserRepository creates a user repository on the given store
+func NewUserRepository(store *Store) *UserRepository {
+	return &UserRepository{store: store}
+}
+
+// Create inserts a user
+func (r *UserRepository) Create(ctx context.Context, user *domain.User) error {
+	s := r.store
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.users[user.ID]; ok {
+		return conflict("user %s already exists", user.ID)
+	}
+	if err := s.checkUserUnique(user); err != nil {
+		return err
+	}
+	s.users[user.ID] = copyUser(user)
+	return nil
+}
+
+// GetByID returns a user, or nil when it does not exist
+func (r *UserRepository) GetByID(ctx context.Context, id string) (*domain.User, error) {
+	s := r.store
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if user, ok := s.users[id]; ok {
+		return copyUser(user), nil
+	}
+	return nil, nil
+}
+
+// GetByEmail returns the user with an email address, or nil
+func (r *UserRepository) GetByEmail(ctx context.Context, email string) (*domain.User, error) {
+	return r.find(func(user *domain.User) bool { return user.Email == email }), nil
+}
+
+// GetByUsername returns the user with a username, or nil
+func (r *UserRepository) GetByUsername(ctx context.Context, username string) (*domain.User, error) {
+	return r.find(func(user *domain.User) bool { return user.Username == username }), nil
+}
+
+// Update saves a user
+func (r *UserRepository) Update(ctx context.Context, user *domain.User) error {
+	s := r.store
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	existing, ok := s.users[user.ID]
+	if !ok {
+		return nil
+	}
+	if err := s.checkUserUnique(user); err != nil {
+		return err
+	}
+	updated := copyUser(user)
+	updated.CreatedAt = existing.CreatedAt
+	s.users[user.ID] = updated
+	return nil
+}
+
+// Delete removes a user with its memberships, accounts and the folder
+// rights granted to it
+func (r *UserRepository) Delete(ctx context.Context, id string) error {
+	s := r.store
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.users[id]; !ok {
+		return nil
+	}
+	delete(s.users, id)
+	for memberID, member := range s.domainMembers {
+		if member.UserID == id {
+			delete(s.domainMembers, memberID)
+		}
+	}
+	for key := range s.groupMembers {
+		if key.userID == id {
+			delete(s.groupMembers, key)
+		}
+	}
+	for aclID, acl := range s.folderACLs {
+		if acl.SubjectType == domain.ACLSubjectUser && acl.SubjectID == id {
+			delete(s.folderACLs, aclID)
+		}
+	}
+	for accountID, account := range s.emailAccounts {
+		if account.UserID == id {
+			s.deleteAccountLocked(accountID)
+		}
+	}
+	return nil
+}
+
+// List returns the users matching a filter, newest first
+func (r *UserRepository) List(ctx context.Context, filter repository.UserFilter) ([]*domain.User, error) {
+	s := r.store
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	users := []*domain.User{}
+	for _, user := range s.users {
+		if s.userMatches(user, filter) {
+			users = append(users, copyUser(user))
+		}
+	}
+	sort.Slice(users, func(i, j int) bool {
+		if !users[i].CreatedAt.Equal(users[j].CreatedAt) {
+			return users[i].CreatedAt.After(users[j].CreatedAt)
+		}
+		return users[i].ID < users[j].ID
+	})
+	return page(users, filter.Limit, filter.Offset), nil
+}
+
+// Count returns the number of users matching a filter
+func (r *UserRepository) Count(ctx context.Context, filter repository.UserFilter) (int, error) {
+	s := r.store
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	count := 0
+	for _, user := range s.users {
+		if s.userMatches(user, filter) {
+			count++
+		}
+	}
+	return count, nil
+}
+
+func (r *UserRepository) find(match func(*domain.User) bool) *domain.User {
+	s := r.store
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, user := range s.users {
+		if match(user) {
+			return copyUser(user)
+		}
+	}
+	return nil
+}
+
+func (s *Store) checkUserUnique(user *domain.User) error {
+	for _, other := range s.users {
+		if other.ID == user.ID {
+			continue
+		}
+		if other.Email == user.Email {
+			return conflict("user email %s already exists", user.Email)
+		}
+		if other.Username == user.Username {
+			return conflict("username %s already exists", user.Username)
+		}
+	}
+	return nil
+}
+
+func (s *Store) userMatches(user *domain.User, filter repository.UserFilter) bool {
+	if filter.Role != nil && user.Role != *filter.Role {
+		return false
+	}
+	if filter.IsActive != nil && user.IsActive != *filter.IsActive {
+		return false
+	}
+	if filter.IsVerified != nil && user.IsVerified != *filter.IsVerified {
+		return false
+	}
+	if filter.DomainID != nil {
+		for _, member := range s.domainMembers {
+			if member.UserID == user.ID && member.DomainID == *filter.DomainID {
+				return true
+			}
+		}
+		return false
+	}
+	return true
+}
+
+func copyUser(user *domain.User) *domain.User {
+	c := *user
+	c.FirstName = copyString(user.FirstName)
+	c.LastName = copyString(user.LastName)
+	c.DisplayName = copyString(user.DisplayName)
+	c.TwoFactorSecret = copyString(user.TwoFactorSecret)
+	c.LastLoginAt = copyTime(user.LastLoginAt)
+	return &c
+}
+
+// DomainRepository stores mail domains in memory
+type DomainRepository struct {
+	store *Store
+}
+
+// NewDomainRepository creates a domain repository on the given store
+func NewDomainRepository(store *Store) *DomainRepository {
+	return &DomainRepository{store: store}
+}
+
+// Create inserts a domain
+func (r *DomainRepository) Create(ctx context.Context, d *domain.Domain) error {
+	s := r.store
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.domains[d.ID]; ok {
+		return conflict("domain %s already exists", d.ID)
+	}
+	if err := s.checkDomainUnique(d); err != nil {
+		return err
+	}
+	s.domains[d.ID] = copyDomain(d)
+	return nil
+}
+
+// GetByID returns a domain, or nil when it does not exist
+func (r *DomainRepository) GetByID(ctx context.Context, id string) (*domain.Domain, error) {
+	s := r.store
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if d, ok := s.domains[id]; ok {
+		return copyDomain(d), nil
+	}
+	return nil, nil
+}
+
+// GetByName returns a domain by name, or nil when it does not exist
+func (r *DomainRepository) GetByName(ctx context.Context, name string) (*domain.Domain, error) {
+	s := r.store
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, d := range s.domains {
+		if d.Name == name {
+			return copyDomain(d), nil
+		}
+	}
+	return nil, nil
+}
+
+// Update saves a domain
+func (r *DomainRepository) Update(ctx context.Context, d *domain.Domain) error {
+	s := r.store
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	existing, ok := s.domains[d.ID]
+	if !ok {
+		return nil
+	}
+	if err := s.checkDomainUnique(d); err != nil {
+		return err
+	}
+	updated := copyDomain(d)
+	updated.CreatedAt = existing.CreatedAt
+	s.domains[d.ID] = updated
+	return nil
+}
+
+// Delete removes a domain with its members, accounts, aliases, DNS records,
+// groups and the identities in it
+func (r *DomainRepository) Delete(ctx context.Context, id string) error {
+	s := r.store
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.domains[id]; !ok {
+		return nil
+	}
+	delete(s.domains, id)
+	for memberID, member := range s.domainMembers {
+		if member.DomainID == id {
+			delete(s.domainMembers, memberID)
+		}
+	}
+	for accountID, account := range s.emailAccounts {
+		if account.DomainID == id {
+			s.deleteAccountLocked(accountID)
+		}
+	}
+	for aliasID, alias := range s.emailAliases {
+		if alias.DomainID == id {
+			delete(s.emailAliases, aliasID)
+		}
+	}
+	for recordID, record := range s.dnsRecords {
+		if record.DomainID == id {
+			delete(s.dnsRecords, recordID)
+		}
+	}
+	for identityID, identity := range s.identities {
+		if identity.DomainID == id {
+			delete(s.identities, identityID)
+		}
+	}
+	for groupID, group := range s.groups {
+		if group.DomainID == id {
+			s.deleteGroupLocked(groupID)
+		}
+	}
+	return nil
+}
+
+// List returns the domains matching a filter ordered by name
+func (r *DomainRepository) List(ctx context.Context, filter repository.DomainFilter) ([]*domain.Domain, error) {
+	s := r.store
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	domains := []*domain.Domain{}
+	for _, d := range s.domains {
+		if domainMatches(d, filter) {
+			domains = append(domains, copyDomain(d))
+		}
+	}
+	sort.Slice(domains, func(i, j int) bool { return domains[i].Name < domains[j].Name })
+	return page(domains, filter.Limit, filter.Offset), nil
+}
+
+// Count returns the number of domains matching a filter
+func (r *DomainRepository) Count(ctx context.Context, filter repository.DomainFilter) (int, error) {
+	s := r.store
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	count := 0
+	for _, d := range s.domains {
+		if domainMatches(d, filter) {
+			count++
+		}
+	}
+	return count, nil
+}
+
+func (s *Store) checkDomainUnique(d *domain.Domain) error {
+	for _, other := range s.domains {
+		if other.ID != d.ID && other.Name == d.Name {
+			return conflict("domain %s already exists", d.Name)
+		}
+	}
+	return nil
+}
+
+func domainMatches(d *domain.Domain, filter repository.DomainFilter) bool {
+	if filter.OwnerID != nil && d.OwnerID != *filter.OwnerID {
+		return false
+	}
+	if filter.IsActive != nil && d.IsActive != *filter.IsActive {
+		return false
+	}
+	if filter.IsVerified != nil && d.IsVerified != *filter.IsVerified {
+		return false
+	}
+	return true
+}
+
+func copyDomain(d *domain.Domain) *domain.Domain {
+	c := *d
+	c.DisplayName = copyString(d.DisplayName)
+	c.Description = copyString(d.Description)
+	c.DKIMSelector = copyString(d.DKIMSelector)
+	c.DKIMPublicKey = copyString(d.DKIMPublicKey)
+	c.SPFRecord = copyString(d.SPFRecord)
+	c.DMARCRecord = copyString(d.DMARCRecord)
+	c.VerifiedAt = copyTime(d.VerifiedAt)
+	return &c
+}
+
+// DomainMemberRepository stores domain memberships in memory
+type DomainMemberRepository struct {
+	store *Store
+}
+
+// NewDomainMemberRepository creates a domain member repository on the given store
+func NewDomainMemberRepository(store *Store) *DomainMemberRepository {
+	return &DomainMemberRepository{store: store}
+}
+
+// Create inserts a membership of an existing user in an existing domain
+func (r *DomainMemberRepository) Create(ctx context.Context, member *domain.DomainMember) error {
+	s := r.store
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.domainMembers[member.ID]; ok {
+		return conflict("domain member %s already exists", member.ID)
+	}
+	if _, ok := s.users[member.UserID]; !ok {
+		return conflict("user %s does not exist", member.UserID)
+	}
+	if _, ok := s.domains[member.DomainID]; !ok {
+		return conflict("domain %s does not exist", member.DomainID)
+	}
+	for _, other := range s.domainMembers {
+		if other.UserID == member.UserID && other.DomainID == member.DomainID {
+			return conflict("user %s is already a member of domain %s", member.UserID, member.DomainID)
+		}
+	}
+	c := *member
+	s.domainMembers[member.ID] = &c
+	return nil
+}
+
+// GetByID returns a membership, or nil when it does not exist
+func (r *DomainMemberRepository) GetByID(ctx context.Context, id string) (*domain.DomainMember, error) {
+	s := r.store
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if member, ok := s.domainMembers[id]; ok {
+		c := *member
+		return &c, nil
+	}
+	return nil, nil
+}
+
+// GetByUserAndDomain returns the membership of a user in a domain, or nil
+func (r *DomainMemberRepository) GetByUserAndDomain(ctx context.Context, userID, domainID string) (*domain.DomainMember, error) {
+	members := r.list(func(member *domain.DomainMember) bool {
+		return member.UserID == userID && member.DomainID == domainID
+	})
+	if len(members) == 0 {
+		return nil, nil
+	}
+	return members[0], nil
+}
+
+// Update saves the role of a membership
+func (r *DomainMemberRepository) Update(ctx context.Context, member *domain.DomainMember) error {
+	s := r.store
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if existing, ok := s.domainMembers[member.ID]; ok {
+		existing.Role = member.Role
+	}
+	return nil
+}
+
+// Delete removes a membership
+func (r *DomainMemberRepository) Delete(ctx context.Context, id string) error {
+	s := r.store
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.domainMembers, id)
+	return nil
+}
+
+// ListByDomain returns the members of a domain in joining order
+func (r *DomainMemberRepository) ListByDomain(ctx context.Context, domainID string) ([]*domain.DomainMember, error) {
+	return r.list(func(member *domain.DomainMember) bool { return member.DomainID == domainID }), nil
+}
+
+// ListByUser returns the memberships of a user in joining order
+func (r *DomainMemberRepository) ListByUser(ctx context.Context, userID string) ([]*domain.DomainMember, error) {
+	return r.list(func(member *domain.DomainMember) bool { return member.UserID == userID }), nil
+}
+
+func (r *DomainMemberRepository) list(match func(*domain.DomainMember) bool) []*domain.DomainMember {
+	s := r.store
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	members := []*domain.DomainMember{}
+	for _, member := range s.domainMembers {
+		if match(member) {
+			c := *member
+			members = append(members, &c)
+		}
+	}
+	sort.Slice(members, func(i, j int) bool {
+		if !members[i].JoinedAt.Equal(members[j].JoinedAt) {
+			return members[i].JoinedAt.Before(members[j].JoinedAt)
+		}
+		return members[i].ID < members[j].ID
+	})
+	return members
+}
+
+// EmailAccountRepository stores email accounts in memory
+type EmailAccountRepository struct {
+	store *Store
+}
+
+// NewEmailAccountRepository creates an email account repository on the given store
+func NewEmailAccountRepository(store *Store) *EmailAccountRepository {
+	return &EmailAccountRepository{store: store}
+}
+
+// Create inserts an account of an existing user in an existing domain
+func (r *EmailAccountRepository) Create(ctx context.Context, account *domain.EmailAccount) error {
+	s := r.store
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.emailAccounts[account.ID]; ok {
+		return conflict("email account %s already exists", account.ID)
+	}
+	if err := s.checkEmailAccount(account); err != nil {
+		return err
+	}
+	s.emailAccounts[account.ID] = copyEmailAccount(account)
+	return nil
+}
+
+// GetByID returns an account, or nil when it does not exist
+func (r *EmailAccountRepository) GetByID(ctx context.Context, id string) (*domain.EmailAccount, error) {
+	s := r.store
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if account, ok := s.emailAccounts[id]; ok {
+		return copyEmailAccount(account), nil
+	}
+	return nil, nil
+}
+
+// GetByEmail returns the account of an address, or nil
+func (r *EmailAccountRepository) GetByEmail(ctx context.Context, email string) (*domain.EmailAccount, error) {
+	s := r.store
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, account := range s.emailAccounts {
+		if account.Email == email {
+			return copyEmailAccount(account), nil
+		}
+	}
+	return nil, nil
+}
+
+// Update saves an account
+func (r *EmailAccountRepository) Update(ctx context.Context, account *domain.EmailAccount) error {
+	s := r.store
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	existing, ok := s.emailAccounts[account.ID]
+	if !ok {
+		return nil
+	}
+	if err := s.checkEmailAccount(account); err != nil {
+		return err
+	}
+	updated := copyEmailAccount(account)
+	updated.CreatedAt = existing.CreatedAt
+	s.emailAccounts[account.ID] = updated
+	return nil
+}
+
+// Delete removes an account with its folders and messages
+func (r *EmailAccountRepository) Delete(ctx context.Context, id string) error {
+	s := r.store
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.deleteAccountLocked(id)
+	return nil
+}
+
+// List returns the accounts matching a filter ordered by address
+func (r *EmailAccountRepository) List(ctx context.Context, filter repository.EmailAccountFilter) ([]*domain.EmailAccount, error) {
+	s := r.store
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	accounts := []*domain.EmailAccount{}
+	for _, account := range s.emailAccounts {
+		if emailAccountMatches(account, filter) {
+			accounts = append(accounts, copyEmailAccount(account))
+		}
+	}
+	sort.Slice(accounts, func(i, j int) bool { return accounts[i].Email < accounts[j].Email })
+	return page(accounts, filter.Limit, filter.Offset), nil
+}
+
+// Count returns the number of accounts matching a filter
+func (r *EmailAccountRepository) Count(ctx context.Context, filter repository.EmailAccountFilter) (int, error) {
+	s := r.store
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	count := 0
+	for _, account := range s.emailAccounts {
+		if emailAccountMatches(account, filter) {
+			count++
+		}
+	}
+	return count, nil
+}
+
+func (s *Store) checkEmailAccount(account *domain.EmailAccount) error {
+	if _, ok := s.users[account.UserID]; !ok && !account.IsShared() {
+		return conflict("user %s does not exist", account.UserID)
+	}
+	if _, ok := s.domains[account.DomainID]; !ok {
+		return conflict("domain %s does not exist", account.DomainID)
+	}
+	for _, other := range s.emailAccounts {
+		if other.ID != account.ID && other.Email == account.Email {
+			return conflict("email account %s already exists", account.Email)
+		}
+	}
+	return nil
+}
+
+// deleteAccountLocked removes an account with its folders, messages, threads,
+// scheduled sends, identities, delegations and folder rights; attachments
+// are kept as they have no foreign key
+func (s *Store) deleteAccountLocked(id string) {
+	delete(s.emailAccounts, id)
+	for folderID, folder := range s.folders {
+		if folder.AccountID == id {
+			delete(s.folders, folderID)
+		}
+	}
+	for messageID, message := range s.messages {
+		if message.AccountID == id {
+			delete(s.messages, messageID)
+		}
+	}
+	for threadID, thread := range s.threads {
+		if thread.AccountID == id {
+			s.deleteThreadLocked(threadID)
+		}
+	}
+	delete(s.threadSettings, id)
+	for sendID, send := range s.scheduledSends {
+		if send.AccountID == id {
+			delete(s.scheduledSends, sendID)
+		}
+	}
+	for identityID, identity := range s.identities {
+		if identity.AccountID == id {
+			delete(s.identities, identityID)
+		}
+	}
+	for delegationID, delegation := range s.senderDelegations {
+		if delegation.AccountID == id || delegation.DelegateAccountID == id {
+			delete(s.senderDelegations, delegationID)
+		}
+	}
+	for aclID, acl := range s.folderACLs {
+		if acl.AccountID == id {
+			delete(s.folderACLs, aclID)
+		}
+	}
+}
+
+func emailAccountMatches(account *domain.EmailAccount, filter repository.EmailAccountFilter) bool {
+	if filter.UserID != nil && account.UserID != *filter.UserID {
+		return false
+	}
+	if filter.DomainID != nil && account.DomainID != *filter.DomainID {
+		return false
+	}
+	if filter.IsActive != nil && account.IsActive != *filter.IsActive {
+		return false
+	}
+	if filter.IsVerified != nil && account.IsVerified != *filter.IsVerified {
+		return false
+	}
+	if filter.IsShared != nil && account.IsShared() != *filter.IsShared {
+		return false
+	}
+	return true
+}
+
+func copyEmailAccount(account *domain.EmailAccount) *domain.EmailAccount {
+	c := *account
+	c.DisplayName = copyString(account.DisplayName)
+	c.LastLoginAt = copyTime(account.LastLoginAt)
+	return &c
+}
+
+// EmailAliasRepository stores email aliases in memory
+type EmailAliasRepository struct {
+	store *Store
+}
+
+// NewEmailAliasRepository creates an email alias repository on the given store
+func NewEmailAliasRepository(store *Store) *EmailAliasRepository {
+	return &EmailAliasRepository{store: store}
+}
+
+// Create inserts an alias of an existing domain
+func (r *EmailAliasRepository) Create(ctx context.Context, alias *domain.EmailAlias) error {
+	s := r.store
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.emailAliases[alias.ID]; ok {
+		return conflict("email alias %s already exists", alias.ID)
+	}
+	if _, ok := s.domains[alias.DomainID]; !ok {
+		return conflict("domain %s does not exist", alias.DomainID)
+	}
+	if err := s.checkAliasUnique(alias); err != nil {
+		return err
+	}
+	c := *alias
+	s.emailAliases[alias.ID] = &c
+	return nil
+}
+
+// GetByID returns an alias, or nil when it does not exist
+func (r *EmailAliasRepository) GetByID(ctx context.Context, id string) (*domain.EmailAlias, error) {
+	s := r.store
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if alias, ok := s.emailAliases[id]; ok {
+		c := *alias
+		return &c, nil
+	}
+	return nil, nil
+}
+
+// GetByAlias returns the alias of an address, or nil
+func (r *EmailAliasRepository) GetByAlias(ctx context.Context, address string) (*domain.EmailAlias, error) {
+	s := r.store
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, alias := range s.emailAliases {
+		if alias.Alias == address {
+			c := *alias
+			return &c, nil
+		}
+	}
+	return nil, nil
+}
+
+// GetByDomainID returns the aliases of a domain ordered by address
+func (r *EmailAliasRepository) GetByDomainID(ctx context.Context, domainID string) ([]*domain.EmailAlias, error) {
+	s := r.store
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	aliases := []*domain.EmailAlias{}
+	for _, alias := range s.emailAliases {
+		if alias.DomainID == domainID {
+			c := *alias
+			aliases = append(aliases, &c)
+		}
+	}
+	sort.Slice(aliases, func(i, j int) bool { return aliases[i].Alias < aliases[j].Alias })
+	return aliases, nil
+}
+
+// Update saves an alias; it stays in its domain
+func (r *EmailAliasRepository) Update(ctx context.Context, alias *domain.EmailAlias) error {
+	s := r.store
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	existing, ok := s.emailAliases[alias.ID]
+	if !ok {
+		return nil
+	}
+	if err := s.checkAliasUnique(alias); err != nil {
+		return err
+	}
+	existing.Alias = alias.Alias
+	existing.DestEmail = alias.DestEmail
+	existing.IsActive = alias.IsActive
+	existing.UpdatedAt = alias.UpdatedAt
+	return nil
+}
+
+// Delete removes an alias
+func (r *EmailAliasRepository) Delete(ctx context.Context, id string) error {
+	s := r.store
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.emailAliases, id)
+	return nil
+}
+
+func (s *Store) checkAliasUnique(alias *domain.EmailAlias) error {
+	for _, other := range s.emailAliases {
+		if other.ID != alias.ID && other.Alias == alias.Alias {
+			return conflict("email alias %s already exists", alias.Alias)
+		}
+	}
+	return nil
+}
+
+// DNSRecordRepository stores the expected DNS records of domains in memory
+type DNSRecordRepository struct {
+	store *Store
+}
+
+// NewDNSRecordRepository creates a DNS record repository on the given store
+func NewDNSRecordRepository(store *Store) *DNSRecordRepository {
+	return &DNSRecordRepository{store: store}
+}
+
+// Create inserts a record of an existing domain
+func (r *DNSRecordRepository) Create(ctx context.Context, record *domain.DNSRecord) error {
+	s := r.store
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.dnsRecords[record.ID]; ok {
+		return conflict("DNS record %s already exists", record.ID)
+	}
+	if _, ok := s.domains[record.DomainID]; !ok {
+		return conflict("domain %s does not exist", record.DomainID)
+	}
+	s.dnsRecords[record.ID] = copyDNSRecord(record)
+	return nil
+}
+
+// GetByID returns a record, or nil when it does not exist
+func (r *DNSRecordRepository) GetByID(ctx context.Context, id string) (*domain.DNSRecord, error) {
+	s := r.store
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if record, ok := s.dnsRecords[id]; ok {
+		return copyDNSRecord(record), nil
+	}
+	return nil, nil
+}
+
+// GetByDomainID returns the records of a domain ordered by type and name
+func (r *DNSRecordRepository) GetByDomainID(ctx context.Context, domainID string) ([]*domain.DNSRecord, error) {
+	s := r.store
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	records := []*domain.DNSRecord{}
+	for _, record := range s.dnsRecords {
+		if record.DomainID == domainID {
+			records = append(records, copyDNSRecord(record))
+		}
+	}
+	sort.Slice(records, func(i, j int) bool {
+		if records[i].Type != records[j].Type {
+			return records[i].Type < records[j].Type
+		}
+		return compareOptional(records[i].Name, records[j].Name) < 0
+	})
+	return records, nil
+}
+
+// Update saves a record; it stays in its domain
+func (r *DNSRecordRepository) Update(ctx context.Context, record *domain.DNSRecord) error {
+	s := r.store
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	existing, ok := s.dnsRecords[record.ID]
+	if !ok {
+		return nil
+	}
+	updated := copyDNSRecord(record)
+	updated.DomainID = existing.DomainID
+	s.dnsRecords[record.ID] = updated
+	return nil
+}
+
+// Delete removes a record
+func (r *DNSRecordRepository) Delete(ctx context.Context, id string) error {
+	s := r.store
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.dnsRecords, id)
+	return nil
+}
+
+func copyDNSRecord(record *domain.DNSRecord) *domain.DNSRecord {
+	c := *record
+	c.Name = copyString(record.Name)
+	c.Value = copyString(record.Value)
+	c.Priority = copyInt(record.Priority)
+	c.TTL = copyInt(record.TTL)
+	return &c
+}
@@ -0,0 +1,395 @@
+package inmemory
+
+import (
+	"context"
+	"sort"
+
+	"github.com/skygenesisenterprise/aether-mailer/package/golang/domain"
+	"github.com/skygenesisenterprise/aether-mailer/package/golang/repository"
+)
+
+// GroupRepository keeps the user groups of domains in memory
+type GroupRepository struct {
+	store *Store
+}
+
+// NewGroupRepository creates a group repository on the given store
+func NewGroupRepository(store *Store) *GroupRepository {
+	return &GroupRepository{store: store}
+}
+
+// Create inserts a group of an existing domain; names are unique in a
+// domain
+func (r *GroupRepository) Create(ctx context.Context, group *domain.Group) error {
+	s := r.store
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.groups[group.ID]; ok {
+		return conflict("group %s already exists", group.ID)
+	}
+	if _, ok := s.domains[group.DomainID]; !ok {
+		return conflict("domain %s does not exist", group.DomainID)
+	}
+	for _, existing := range s.groups {
+		if existing.DomainID == group.DomainID && existing.Name == group.Name {
+			return conflict("group %s already exists in domain %s", group.Name, group.DomainID)
+		}
+	}
+	c := *group
+	s.groups[group.ID] = &c
+	return nil
+}
+
+// GetByID returns a group, or nil when it does not exist
+func (r *GroupRepository) GetByID(ctx context.Context, id string) (*domain.Group, error) {
+	s := r.store
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if group, ok := s.groups[id]; ok {
+		c := *group
+		return &c, nil
+	}
+	return nil, nil
+}
+
+// GetByName returns the group of a domain with a name, or nil
+func (r *GroupRepository) GetByName(ctx context.Context, domainID, name string) (*domain.Group, error) {
+	s := r.store
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, group := range s.groups {
+		if group.DomainID == domainID && group.Name == name {
+			c := *group
+			return &c, nil
+		}
+	}
+	return nil, nil
+}
+
+// Delete removes a group with its members and the folder rights granted to
+// it
+func (r *GroupRepository) Delete(ctx context.Context, id string) error {
+	s := r.store
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.deleteGroupLocked(id)
+	return nil
+}
+
+// ListByDomain returns the groups of a domain ordered by name
+func (r *GroupRepository) ListByDomain(ctx context.Context, domainID string) ([]*domain.Group, error) {
+	return r.list(func(group *domain.Group) bool { return group.DomainID == domainID }), nil
+}
+
+// ListByUser returns the groups a user is a member of, ordered by name
+func (r *GroupRepository) ListByUser(ctx context.Context, userID string) ([]*domain.Group, error) {
+	s := r.store
+	return r.list(func(group *domain.Group) bool {
+		_, ok := s.groupMembers[groupMemberKey{groupID: group.ID, userID: userID}]
+		return ok
+	}), nil
+}
+
+// AddMember adds an existing user to an existing group; adding a member
+// again does nothing
+func (r *GroupRepository) AddMember(ctx context.Context, member *domain.GroupMember) error {
+	s := r.store
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.groups[member.GroupID]; !ok {
+		return conflict("group %s does not exist", member.GroupID)
+	}
+	if _, ok := s.users[member.UserID]; !ok {
+		return conflict("user %s does not exist", member.UserID)
+	}
+	key := groupMemberKey{groupID: member.GroupID, userID: member.UserID}
+	if _, ok := s.groupMembers[key]; !ok {
+		c := *member
+		s.groupMembers[key] = &c
+	}
+	return nil
+}
+
+// RemoveMember removes a user from a group
+func (r *GroupRepository) RemoveMember(ctx context.Context, groupID, userID string) error {
+	s := r.store
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.groupMembers, groupMemberKey{groupID: groupID, userID: userID})
+	return nil
+}
+
+// ListMembers returns the members of a group in the order they were added
+func (r *GroupRepository) ListMembers(ctx context.Context, groupID string) ([]*domain.GroupMember, error) {
+	s := r.store
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	members := []*domain.GroupMember{}
+	for key, member := range s.groupMembers {
+		if key.groupID == groupID {
+			c := *member
+			members = append(members, &c)
+		}
+	}
+	sort.Slice(members, func(i, j int) bool {
+		if !members[i].AddedAt.Equal(members[j].AddedAt) {
+			return members[i].AddedAt.Before(members[j].AddedAt)
+		}
+		return members[i].UserID < members[j].UserID
+	})
+	return members, nil
+}
+
+func (r *GroupRepository) list(match func(*domain.Group) bool) []*domain.Group {
+	s := r.store
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	groups := []*domain.Group{}
+	for _, group := range s.groups {
+		if match(group) {
+			c := *group
+			groups = append(groups, &c)
+		}
+	}
+	sort.Slice(groups, func(i, j int) bool {
+		if groups[i].Name != groups[j].Name {
+			return groups[i].Name < groups[j].Name
+		}
+		return groups[i].ID < groups[j].ID
+	})
+	return groups
+}
+
+func (s *Store) deleteGroupLocked(id string) {
+	delete(s.groups, id)
+	for key := range s.groupMembers {
+		if key.groupID == id {
+			delete(s.groupMembers, key)
+		}
+	}
+	for aclID, acl := range s.folderACLs {
+		if acl.SubjectType == domain.ACLSubjectGroup && acl.SubjectID == id {
+			delete(s.folderACLs, aclID)
+		}
+	}
+}
+
+// FolderACLRepository keeps the access control lists of folders in memory
+type FolderACLRepository struct {
+	store *Store
+}
+
+// NewFolderACLRepository creates a folder ACL repository on the given store
+func NewFolderACLRepository(store *Store) *FolderACLRepository {
+	return &FolderACLRepository{store: store}
+}
+
+// Create inserts an entry of an existing folder for an existing user or
+// group; a folder has at most one entry per subject
+func (r *FolderACLRepository) Create(ctx context.Context, acl *domain.FolderACL) error {
+	s := r.store
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.folderACLs[acl.ID]; ok {
+		return conflict("folder ACL %s already exists", acl.ID)
+	}
+	if _, ok := s.folders[acl.FolderID]; !ok {
+		return conflict("folder %s does not exist", acl.FolderID)
+	}
+	if _, ok := s.emailAccounts[acl.AccountID]; !ok {
+		return conflict("email account %s does not exist", acl.AccountID)
+	}
+	switch acl.SubjectType {
+	case domain.ACLSubjectUser:
+		if _, ok := s.users[acl.SubjectID]; !ok {
+			return conflict("user %s does not exist", acl.SubjectID)
+		}
+	case domain.ACLSubjectGroup:
+		if _, ok := s.groups[acl.SubjectID]; !ok {
+			return conflict("group %s does not exist", acl.SubjectID)
+		}
+	case domain.ACLSubjectAnyone:
+		if acl.SubjectID != "" {
+			return conflict("folder ACL for anyone has subject %s", acl.SubjectID)
+		}
+	default:
+		return conflict("folder ACL subject type %s is invalid", acl.SubjectType)
+	}
+	for _, existing := range s.folderACLs {
+		if existing.FolderID == acl.FolderID && existing.SubjectType == acl.SubjectType &&
+			existing.SubjectID == acl.SubjectID {
+			return conflict("folder %s already has an entry for %s %s", acl.FolderID, acl.SubjectType, acl.SubjectID)
+		}
+	}
+	c := *acl
+	s.folderACLs[acl.ID] = &c
+	return nil
+}
+
+// Get returns the entry of a folder for a subject, or nil
+func (r *FolderACLRepository) Get(ctx context.Context, folderID string, subjectType domain.ACLSubjectType, subjectID string) (*domain.FolderACL, error) {
+	s := r.store
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, acl := range s.folderACLs {
+		if acl.FolderID == folderID && acl.SubjectType == subjectType && acl.SubjectID == subjectID {
+			c := *acl
+			return &c, nil
+		}
+	}
+	return nil, nil
+}
+
+// Update saves the rights of an entry
+func (r *FolderACLRepository) Update(ctx context.Context, acl *domain.FolderACL) error {
+	s := r.store
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if existing, ok := s.folderACLs[acl.ID]; ok {
+		existing.Rights = acl.Rights
+		existing.GrantedBy = acl.GrantedBy
+		existing.UpdatedAt = acl.UpdatedAt
+	}
+	return nil
+}
+
+// Delete removes an entry
+func (r *FolderACLRepository) Delete(ctx context.Context, id string) error {
+	s := r.store
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.folderACLs, id)
+	return nil
+}
+
+// ListByFolder returns the entries of a folder, oldest first
+func (r *FolderACLRepository) ListByFolder(ctx context.Context, folderID string) ([]*domain.FolderACL, error) {
+	return r.list(func(acl *domain.FolderACL) bool { return acl.FolderID == folderID }), nil
+}
+
+// ListByAccount returns the entries of the folders of an account, oldest
+// first
+func (r *FolderACLRepository) ListByAccount(ctx context.Context, accountID string) ([]*domain.FolderACL, error) {
+	return r.list(func(acl *domain.FolderACL) bool { return acl.AccountID == accountID }), nil
+}
+
+// ListBySubjects returns the entries granting rights to a user, to any of
+// the given groups or to anyone, oldest first
+func (r *FolderACLRepository) ListBySubjects(ctx context.Context, userID string, groupIDs []string) ([]*domain.FolderACL, error) {
+	groups := make(map[string]bool, len(groupIDs))
+	for _, groupID := range groupIDs {
+		groups[groupID] = true
+	}
+	return r.list(func(acl *domain.FolderACL) bool {
+		switch acl.SubjectType {
+		case domain.ACLSubjectUser:
+			return acl.SubjectID == userID
+		case domain.ACLSubjectGroup:
+			return groups[acl.SubjectID]
+		}
+		return acl.SubjectType == domain.ACLSubjectAnyone
+	}), nil
+}
+
+func (r *FolderACLRepository) list(match func(*domain.FolderACL) bool) []*domain.FolderACL {
+	s := r.store
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	acls := []*domain.FolderACL{}
+	for _, acl := range s.folderACLs {
+		if match(acl) {
+			c := *acl
+			acls = append(acls, &c)
+		}
+	}
+	sort.Slice(acls, func(i, j int) bool {
+		if !acls[i].CreatedAt.Equal(acls[j].CreatedAt) {
+			return acls[i].CreatedAt.Before(acls[j].CreatedAt)
+		}
+		return acls[i].ID < acls[j].ID
+	})
+	return acls
+}
+
+// ACLAuditLogRepository keeps the access control audit log in memory.
+// Entries are append-only.
+type ACLAuditLogRepository struct {
+	store *Store
+}
+
+// NewACLAuditLogRepository creates an audit log repository on the given
+// store
+func NewACLAuditLogRepository(store *Store) *ACLAuditLogRepository {
+	return &ACLAuditLogRepository{store: store}
+}
+
+// Record appends an entry
+func (r *ACLAuditLogRepository) Record(ctx context.Context, entry *domain.ACLAuditEntry) error {
+	s := r.store
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	c := *entry
+	s.aclAudit = append(s.aclAudit, &c)
+	return nil
+}
+
+// List returns the entries matching a filter, newest first
+func (r *ACLAuditLogRepository) List(ctx context.Context, filter repository.ACLAuditFilter) ([]*domain.ACLAuditEntry, error) {
+	s := r.store
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	entries := []*domain.ACLAuditEntry{}
+	for _, entry := range s.aclAudit {
+		if aclAuditMatches(entry, filter) {
+			c := *entry
+			entries = append(entries, &c)
+		}
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		if !entries[i].CreatedAt.Equal(entries[j].CreatedAt) {
+			return entries[i].CreatedAt.After(entries[j].CreatedAt)
+		}
+		return entries[i].ID < entries[j].ID
+	})
+	return page(entries, filter.Limit, filter.Offset), nil
+}
+
+// Count returns the number of entries matching a filter
+func (r *ACLAuditLogRepository) Count(ctx context.Context, filter repository.ACLAuditFilter) (int, error) {
+	s := r.store
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	count := 0
+	for _, entry := range s.aclAudit {
+		if aclAuditMatches(entry, filter) {
+			count++
+		}
+	}
+	return count, nil
+}
+
+func aclAuditMatches(entry *domain.ACLAuditEntry, filter repository.ACLAuditFilter) bool {
+	if filter.DomainID != "" && entry.DomainID != filter.DomainID {
+		return false
+	}
+	if filter.AccountID != "" && entry.AccountID != filter.AccountID {
+		return false
+	}
+	return true
+}
@@ -0,0 +1,95 @@
+package inmemory
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"github.com/skygenesisenterprise/aether-mailer/package/golang/domain"
+)
+
+// BlobRepository stores blob references in memory
+type BlobRepository struct {
+	store *Store
+}
+
+// NewBlobRepository creates a blob repository on the given store
+func NewBlobRepository(store *Store) *BlobRepository {
+	return &BlobRepository{store: store}
+}
+
+// Create inserts a blob and reports false when another writer stored the
+// same content first
+func (r *BlobRepository) Create(ctx context.Context, blob *domain.Blob) (bool, error) {
+	s := r.store
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.blobs[blob.ID]; ok {
+		return false, nil
+	}
+	c := *blob
+	s.blobs[blob.ID] = &c
+	return true, nil
+}
+
+// GetByID returns a blob, or nil when it does not exist
+func (r *BlobRepository) GetByID(ctx context.Context, id string) (*domain.Blob, error) {
+	s := r.store
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if blob, ok := s.blobs[id]; ok {
+		c := *blob
+		return &c, nil
+	}
+	return nil, nil
+}
+
+// AddRef changes the reference count, never below zero, and reports false
+// when the blob does not exist
+func (r *BlobRepository) AddRef(ctx context.Context, id string, delta int) (bool, error) {
+	s := r.store
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	blob, ok := s.blobs[id]
+	if !ok {
+		return false, nil
+	}
+	blob.RefCount = nonNegative(blob.RefCount + delta)
+	blob.UpdatedAt = time.Now()
+	return true, nil
+}
+
+// ListCollectable returns unreferenced blobs untouched since before
+func (r *BlobRepository) ListCollectable(ctx context.Context, before time.Time, limit int) ([]*domain.Blob, error) {
+	s := r.store
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	blobs := []*domain.Blob{}
+	for _, blob := range s.blobs {
+		if blob.RefCount == 0 && blob.UpdatedAt.Before(before) {
+			c := *blob
+			blobs = append(blobs, &c)
+		}
+	}
+	sort.Slice(blobs, func(i, j int) bool { return blobs[i].UpdatedAt.Before(blobs[j].UpdatedAt) })
+	return page(blobs, limit, 0), nil
+}
+
+// DeleteUnreferenced deletes a blob only if it is still unreferenced and
+// untouched since before
+func (r *BlobRepository) DeleteUnreferenced(ctx context.Context, id string, before time.Time) (bool, error) {
+	s := r.store
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	blob, ok := s.blobs[id]
+	if !ok || blob.RefCount != 0 || !blob.UpdatedAt.Before(before) {
+		return false, nil
+	}
+	delete(s.blobs, id)
+	return true, nil
+}
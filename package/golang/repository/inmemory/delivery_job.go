@@ -0,0 +1,149 @@
+package inmemory
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"github.com/skygenesisenterprise/aether-mailer/package/golang/domain"
+)
+
+// DeliveryJobRepository keeps the outbound queue in memory
+type DeliveryJobRepository struct {
+	store *Store
+}
+
+// deliveryJob is a queued job with the instance holding its lease
+type deliveryJob struct {
+	job   *domain.DeliveryJob
+	owner string
+}
+
+// NewDeliveryJobRepository creates a delivery job repository on the given
+// store
+func NewDeliveryJobRepository(store *Store) *DeliveryJobRepository {
+	return &DeliveryJobRepository{store: store}
+}
+
+// Create queues a job and reports false when the message already has a job
+// for the same recipient domain
+func (r *DeliveryJobRepository) Create(ctx context.Context, job *domain.DeliveryJob) (bool, error) {
+	s := r.store
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.deliveryJobs[job.ID]; ok {
+		return false, conflict("delivery job %s already exists", job.ID)
+	}
+	if job.MessageID != "" {
+		for _, existing := range s.deliveryJobs {
+			if existing.job.MessageID == job.MessageID && existing.job.Domain == job.Domain {
+				return false, nil
+			}
+		}
+	}
+	s.deliveryJobs[job.ID] = &deliveryJob{job: copyDeliveryJob(job)}
+	return true, nil
+}
+
+// Claim leases up to limit due jobs that are not leased, soonest first
+func (r *DeliveryJobRepository) Claim(ctx context.Context, owner string, now time.Time, limit int, lease time.Duration) ([]*domain.DeliveryJob, error) {
+	s := r.store
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	due := []*deliveryJob{}
+	for _, queued := range s.deliveryJobs {
+		job := queued.job
+		if !job.NextAttemptAt.After(now) && (job.LockedUntil == nil || !job.LockedUntil.After(now)) {
+			due = append(due, queued)
+		}
+	}
+	sort.Slice(due, func(i, j int) bool {
+		a, b := due[i].job, due[j].job
+		if !a.NextAttemptAt.Equal(b.NextAttemptAt) {
+			return a.NextAttemptAt.Before(b.NextAttemptAt)
+		}
+		if !a.QueuedAt.Equal(b.QueuedAt) {
+			return a.QueuedAt.Before(b.QueuedAt)
+		}
+		return a.ID < b.ID
+	})
+	due = page(due, limit, 0)
+
+	claimed := make([]*domain.DeliveryJob, 0, len(due))
+	lockedUntil := now.Add(lease)
+	for _, queued := range due {
+		queued.owner = owner
+		queued.job.LockedUntil = &lockedUntil
+		claimed = append(claimed, copyDeliveryJob(queued.job))
+	}
+	return claimed, nil
+}
+
+// Extend renews the leases owner still holds on the given jobs
+func (r *DeliveryJobRepository) Extend(ctx context.Context, ids []string, owner string, now time.Time, lease time.Duration) error {
+	s := r.store
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, id := range ids {
+		queued, ok := s.deliveryJobs[id]
+		if ok && queued.owner == owner && queued.job.LockedUntil != nil {
+			lockedUntil := now.Add(lease)
+			queued.job.LockedUntil = &lockedUntil
+		}
+	}
+	return nil
+}
+
+// Reschedule stores the state of a job leased by owner after an attempt
+// and releases its lease
+func (r *DeliveryJobRepository) Reschedule(ctx context.Context, job *domain.DeliveryJob, owner string) (bool, error) {
+	s := r.store
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	queued, ok := s.deliveryJobs[job.ID]
+	if !ok || queued.owner != owner || queued.job.LockedUntil == nil {
+		return false, nil
+	}
+	queued.job.Recipients = copyStrings(job.Recipients)
+	queued.job.Attempts = job.Attempts
+	queued.job.LastError = job.LastError
+	queued.job.NextAttemptAt = job.NextAttemptAt
+	queued.job.LockedUntil = nil
+	queued.owner = ""
+	return true, nil
+}
+
+// Complete removes a job leased by owner
+func (r *DeliveryJobRepository) Complete(ctx context.Context, id, owner string) (bool, error) {
+	s := r.store
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	queued, ok := s.deliveryJobs[id]
+	if !ok || queued.owner != owner || queued.job.LockedUntil == nil {
+		return false, nil
+	}
+	delete(s.deliveryJobs, id)
+	return true, nil
+}
+
+// Count returns the number of queued jobs
+func (r *DeliveryJobRepository) Count(ctx context.Context) (int, error) {
+	s := r.store
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return len(s.deliveryJobs), nil
+}
+
+func copyDeliveryJob(job *domain.DeliveryJob) *domain.DeliveryJob {
+	c := *job
+	c.Recipients = copyStrings(job.Recipients)
+	c.Data = append([]byte(nil), job.Data...)
+	c.LockedUntil = copyTime(job.LockedUntil)
+	return &c
+}
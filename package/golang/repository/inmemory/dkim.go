@@ -0,0 +1,178 @@
+package inmemory
+
+import (
+	"context"
+	"sort"
+
+	"github.com/skygenesisenterprise/aether-mailer/package/golang/domain"
+)
+
+// DKIMKeyRepository stores DKIM keys in memory. A domain has at most one
+// active key and selectors are unique per domain.
+type DKIMKeyRepository struct {
+	store *Store
+}
+
+// NewDKIMKeyRepository creates a DKIM key repository on the given store
+func NewDKIMKeyRepository(store *Store) *DKIMKeyRepository {
+	return &DKIMKeyRepository{store: store}
+}
+
+// Create inserts a key
+func (r *DKIMKeyRepository) Create(ctx context.Context, key *domain.DKIMKey) error {
+	s := r.store
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.dkimKeys[key.ID]; ok {
+		return conflict("DKIM key %s already exists", key.ID)
+	}
+	if err := s.checkDKIMKey(key); err != nil {
+		return err
+	}
+	s.dkimKeys[key.ID] = copyDKIMKey(key)
+	return nil
+}
+
+// GetByID returns a key, or nil when it does not exist
+func (r *DKIMKeyRepository) GetByID(ctx context.Context, id string) (*domain.DKIMKey, error) {
+	return r.find(func(key *domain.DKIMKey) bool { return key.ID == id }), nil
+}
+
+// GetBySelector returns the key of a domain under a selector, or nil
+func (r *DKIMKeyRepository) GetBySelector(ctx context.Context, domainName, selector string) (*domain.DKIMKey, error) {
+	return r.find(func(key *domain.DKIMKey) bool { return key.Domain == domainName && key.Selector == selector }), nil
+}
+
+// GetActive returns the signing key of a domain, or nil when it has none
+func (r *DKIMKeyRepository) GetActive(ctx context.Context, domainName string) (*domain.DKIMKey, error) {
+	return r.find(func(key *domain.DKIMKey) bool {
+		return key.Domain == domainName && key.State == domain.DKIMKeyActive
+	}), nil
+}
+
+// Update saves the state of a key
+func (r *DKIMKeyRepository) Update(ctx context.Context, key *domain.DKIMKey) error {
+	s := r.store
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	existing, ok := s.dkimKeys[key.ID]
+	if !ok {
+		return nil
+	}
+	probe := *existing
+	probe.State = key.State
+	if err := s.checkDKIMKey(&probe); err != nil {
+		return err
+	}
+	existing.EncryptedPrivateKey = copyBytes(key.EncryptedPrivateKey)
+	existing.State = key.State
+	existing.PublishedAt = copyTime(key.PublishedAt)
+	existing.VerifiedAt = copyTime(key.VerifiedAt)
+	existing.ActivatedAt = copyTime(key.ActivatedAt)
+	existing.RetireAfter = copyTime(key.RetireAfter)
+	existing.RetiredAt = copyTime(key.RetiredAt)
+	existing.UpdatedAt = key.UpdatedAt
+	return nil
+}
+
+// ListByDomain returns the keys of a domain, newest first
+func (r *DKIMKeyRepository) ListByDomain(ctx context.Context, domainName string) ([]*domain.DKIMKey, error) {
+	keys := r.list(func(key *domain.DKIMKey) bool { return key.Domain == domainName })
+	sort.Slice(keys, func(i, j int) bool { return keys[i].CreatedAt.After(keys[j].CreatedAt) })
+	return keys, nil
+}
+
+// ListByState returns every key in a state, oldest first
+func (r *DKIMKeyRepository) ListByState(ctx context.Context, state domain.DKIMKeyState) ([]*domain.DKIMKey, error) {
+	keys := r.list(func(key *domain.DKIMKey) bool { return key.State == state })
+	sort.Slice(keys, func(i, j int) bool { return keys[i].CreatedAt.Before(keys[j].CreatedAt) })
+	return keys, nil
+}
+
+func (r *DKIMKeyRepository) find(match func(*domain.DKIMKey) bool) *domain.DKIMKey {
+	keys := r.list(match)
+	if len(keys) == 0 {
+		return nil
+	}
+	return keys[0]
+}
+
+func (r *DKIMKeyRepository) list(match func(*domain.DKIMKey) bool) []*domain.DKIMKey {
+	s := r.store
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	keys := []*domain.DKIMKey{}
+	for _, key := range s.dkimKeys {
+		if match(key) {
+			keys = append(keys, copyDKIMKey(key))
+		}
+	}
+	return keys
+}
+
+func (s *Store) checkDKIMKey(key *domain.DKIMKey) error {
+	for _, other := range s.dkimKeys {
+		if other.ID == key.ID || other.Domain != key.Domain {
+			continue
+		}
+		if other.Selector == key.Selector {
+			return conflict("DKIM selector %s of %s already exists", key.Selector, key.Domain)
+		}
+		if other.State == domain.DKIMKeyActive && key.State == domain.DKIMKeyActive {
+			return conflict("%s already has an active DKIM key", key.Domain)
+		}
+	}
+	return nil
+}
+
+func copyDKIMKey(key *domain.DKIMKey) *domain.DKIMKey {
+	c := *key
+	c.EncryptedPrivateKey = copyBytes(key.EncryptedPrivateKey)
+	c.PublishedAt = copyTime(key.PublishedAt)
+	c.VerifiedAt = copyTime(key.VerifiedAt)
+	c.ActivatedAt = copyTime(key.ActivatedAt)
+	c.RetireAfter = copyTime(key.RetireAfter)
+	c.RetiredAt = copyTime(key.RetiredAt)
+	return &c
+}
+
+// DKIMRotationLogRepository stores the DKIM rotation audit log in memory
+type DKIMRotationLogRepository struct {
+	store *Store
+}
+
+// NewDKIMRotationLogRepository creates a rotation log repository on the given store
+func NewDKIMRotationLogRepository(store *Store) *DKIMRotationLogRepository {
+	return &DKIMRotationLogRepository{store: store}
+}
+
+// Record appends an entry
+func (r *DKIMRotationLogRepository) Record(ctx context.Context, entry *domain.DKIMRotationEntry) error {
+	s := r.store
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	c := *entry
+	s.dkimLog = append(s.dkimLog, &c)
+	return nil
+}
+
+// ListByDomain returns the latest entries of a domain, newest first
+func (r *DKIMRotationLogRepository) ListByDomain(ctx context.Context, domainName string, limit int) ([]*domain.DKIMRotationEntry, error) {
+	s := r.store
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	entries := []*domain.DKIMRotationEntry{}
+	for _, entry := range s.dkimLog {
+		if entry.Domain == domainName {
+			c := *entry
+			entries = append(entries, &c)
+		}
+	}
+	sort.SliceStable(entries, func(i, j int) bool { return entries[i].At.After(entries[j].At) })
+	return page(entries, limit, 0), nil
+}
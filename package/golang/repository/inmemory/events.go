@@ -0,0 +1,157 @@
+package inmemory
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/skygenesisenterprise/aether-mailer/package/golang/domain"
+)
+
+// EventPublisher records published events and hands them to the subscribed
+// handlers synchronously. It is meant for tests and single-process setups.
+type EventPublisher struct {
+	mu       sync.RWMutex
+	store    domain.EventStore
+	handlers []domain.EventHandler
+	events   []domain.Event
+}
+
+// NewEventPublisher creates a publisher that also saves every event to the
+// given store when it is not nil
+func NewEventPublisher(store domain.EventStore) *EventPublisher {
+	return &EventPublisher{store: store}
+}
+
+// Subscribe registers a handler for the event types it can handle
+func (p *EventPublisher) Subscribe(handler domain.EventHandler) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.handlers = append(p.handlers, handler)
+}
+
+// Publish records an event, saves it and runs the matching handlers. The
+// first handler error is returned after every handler has run.
+func (p *EventPublisher) Publish(ctx context.Context, event domain.Event) error {
+	p.mu.Lock()
+	p.events = append(p.events, event)
+	handlers := make([]domain.EventHandler, len(p.handlers))
+	copy(handlers, p.handlers)
+	p.mu.Unlock()
+
+	if p.store != nil {
+		if err := p.store.Save(ctx, event); err != nil {
+			return fmt.Errorf("failed to save event %s: %w", event.ID(), err)
+		}
+	}
+
+	var firstErr error
+	for _, handler := range handlers {
+		if !handler.CanHandle(event.EventType()) {
+			continue
+		}
+		if err := handler.Handle(ctx, event); err != nil && firstErr == nil {
+			firstErr = fmt.Errorf("failed to handle event %s: %w", event.ID(), err)
+		}
+	}
+	return firstErr
+}
+
+// PublishBatch publishes events in order and stops at the first error
+func (p *EventPublisher) PublishBatch(ctx context.Context, events []domain.Event) error {
+	for _, event := range events {
+		if err := p.Publish(ctx, event); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Events returns the published events in publication order
+func (p *EventPublisher) Events() []domain.Event {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	events := make([]domain.Event, len(p.events))
+	copy(events, p.events)
+	return events
+}
+
+// EventsOfType returns the published events of one type in publication order
+func (p *EventPublisher) EventsOfType(eventType string) []domain.Event {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	events := []domain.Event{}
+	for _, event := range p.events {
+		if event.EventType() == eventType {
+			events = append(events, event)
+		}
+	}
+	return events
+}
+
+// Reset forgets the published events
+func (p *EventPublisher) Reset() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.events = nil
+}
+
+// EventStore keeps events in memory
+type EventStore struct {
+	mu     sync.RWMutex
+	events []domain.Event
+}
+
+// NewEventStore creates an empty event store
+func NewEventStore() *EventStore {
+	return &EventStore{}
+}
+
+// Save appends an event. An event already saved is skipped.
+func (s *EventStore) Save(ctx context.Context, event domain.Event) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, saved := range s.events {
+		if saved.ID() == event.ID() {
+			return nil
+		}
+	}
+	s.events = append(s.events, event)
+	return nil
+}
+
+// GetByAggregateID returns the events of an aggregate, newest first. A limit
+// of zero or less returns every event.
+func (s *EventStore) GetByAggregateID(ctx context.Context, aggregateID string, limit int) ([]domain.Event, error) {
+	return s.list(func(event domain.Event) bool { return event.AggregateID() == aggregateID }, limit), nil
+}
+
+// GetByEventType returns the events of a type, newest first. A limit of zero
+// or less returns every event.
+func (s *EventStore) GetByEventType(ctx context.Context, eventType string, limit int) ([]domain.Event, error) {
+	return s.list(func(event domain.Event) bool { return event.EventType() == eventType }, limit), nil
+}
+
+func (s *EventStore) list(match func(domain.Event) bool, limit int) []domain.Event {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	events := []domain.Event{}
+	for i := len(s.events) - 1; i >= 0; i-- {
+		if match(s.events[i]) {
+			events = append(events, s.events[i])
+		}
+	}
+	// Events saved out of order still come back newest first; ties keep
+	// the reverse save order
+	sort.SliceStable(events, func(i, j int) bool {
+		return events[i].OccurredAt().After(events[j].OccurredAt())
+	})
+	return page(events, limit, 0)
+}
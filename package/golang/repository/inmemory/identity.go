@@ -0,0 +1,304 @@
+package inmemory
+
+import (
+	"context"
+	"sort"
+
+	"github.com/skygenesisenterprise/aether-mailer/package/golang/domain"
+	"github.com/skygenesisenterprise/aether-mailer/package/golang/repository"
+)
+
+// IdentityRepository keeps the addresses accounts send as in memory
+type IdentityRepository struct {
+	store *Store
+}
+
+// NewIdentityRepository creates an identity repository on the given store
+func NewIdentityRepository(store *Store) *IdentityRepository {
+	return &IdentityRepository{store: store}
+}
+
+// Create inserts an identity of an existing account in an existing domain.
+// An account has an address at most once and at most one default identity.
+func (r *IdentityRepository) Create(ctx context.Context, identity *domain.Identity) error {
+	s := r.store
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.identities[identity.ID]; ok {
+		return conflict("identity %s already exists", identity.ID)
+	}
+	if _, ok := s.emailAccounts[identity.AccountID]; !ok {
+		return conflict("email account %s does not exist", identity.AccountID)
+	}
+	if _, ok := s.domains[identity.DomainID]; !ok {
+		return conflict("domain %s does not exist", identity.DomainID)
+	}
+	for _, existing := range s.identities {
+		if existing.AccountID != identity.AccountID {
+			continue
+		}
+		if existing.Email == identity.Email {
+			return conflict("identity %s already exists for account %s", identity.Email, identity.AccountID)
+		}
+		if existing.IsDefault && identity.IsDefault {
+			return conflict("account %s already has a default identity", identity.AccountID)
+		}
+	}
+	s.identities[identity.ID] = copyIdentity(identity)
+	return nil
+}
+
+// GetByID returns an identity, or nil when it does not exist
+func (r *IdentityRepository) GetByID(ctx context.Context, id string) (*domain.Identity, error) {
+	s := r.store
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if identity, ok := s.identities[id]; ok {
+		return copyIdentity(identity), nil
+	}
+	return nil, nil
+}
+
+// GetByEmail returns the identity of an account for an address, or nil
+func (r *IdentityRepository) GetByEmail(ctx context.Context, accountID, email string) (*domain.Identity, error) {
+	s := r.store
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, identity := range s.identities {
+		if identity.AccountID == accountID && identity.Email == email {
+			return copyIdentity(identity), nil
+		}
+	}
+	return nil, nil
+}
+
+// Update saves everything but the account, address and default flag
+func (r *IdentityRepository) Update(ctx context.Context, identity *domain.Identity) error {
+	s := r.store
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	existing, ok := s.identities[identity.ID]
+	if !ok {
+		return nil
+	}
+	if _, ok := s.domains[identity.DomainID]; !ok {
+		return conflict("domain %s does not exist", identity.DomainID)
+	}
+	updated := copyIdentity(identity)
+	updated.AccountID = existing.AccountID
+	updated.Email = existing.Email
+	updated.IsDefault = existing.IsDefault
+	updated.CreatedAt = existing.CreatedAt
+	s.identities[identity.ID] = updated
+	return nil
+}
+
+// SetDefault makes an identity the default of its account, or clears the
+// default when id is empty
+func (r *IdentityRepository) SetDefault(ctx context.Context, accountID, id string) error {
+	s := r.store
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, identity := range s.identities {
+		if identity.AccountID == accountID {
+			identity.IsDefault = identity.ID == id
+		}
+	}
+	return nil
+}
+
+// Delete removes an identity
+func (r *IdentityRepository) Delete(ctx context.Context, id string) error {
+	s := r.store
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.identities, id)
+	return nil
+}
+
+// List returns the identities matching a filter, oldest first
+func (r *IdentityRepository) List(ctx context.Context, filter repository.IdentityFilter) ([]*domain.Identity, error) {
+	s := r.store
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	identities := []*domain.Identity{}
+	for _, identity := range s.identities {
+		if identityMatches(identity, filter) {
+			identities = append(identities, copyIdentity(identity))
+		}
+	}
+	sort.Slice(identities, func(i, j int) bool {
+		if !identities[i].CreatedAt.Equal(identities[j].CreatedAt) {
+			return identities[i].CreatedAt.Before(identities[j].CreatedAt)
+		}
+		return identities[i].ID < identities[j].ID
+	})
+	return page(identities, filter.Limit, filter.Offset), nil
+}
+
+// Count returns the number of identities matching a filter
+func (r *IdentityRepository) Count(ctx context.Context, filter repository.IdentityFilter) (int, error) {
+	s := r.store
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	count := 0
+	for _, identity := range s.identities {
+		if identityMatches(identity, filter) {
+			count++
+		}
+	}
+	return count, nil
+}
+
+func identityMatches(identity *domain.Identity, filter repository.IdentityFilter) bool {
+	if filter.AccountID != "" && identity.AccountID != filter.AccountID {
+		return false
+	}
+	if filter.DomainID != "" && identity.DomainID != filter.DomainID {
+		return false
+	}
+	if filter.Status != nil && identity.Status != *filter.Status {
+		return false
+	}
+	return true
+}
+
+func copyIdentity(identity *domain.Identity) *domain.Identity {
+	c := *identity
+	c.ReviewedBy = copyString(identity.ReviewedBy)
+	c.ReviewedAt = copyTime(identity.ReviewedAt)
+	return &c
+}
+
+// SenderDelegationRepository keeps send-as and send-on-behalf delegations
+// in memory
+type SenderDelegationRepository struct {
+	store *Store
+}
+
+// NewSenderDelegationRepository creates a delegation repository on the
+// given store
+func NewSenderDelegationRepository(store *Store) *SenderDelegationRepository {
+	return &SenderDelegationRepository{store: store}
+}
+
+// Create inserts a delegation between two existing accounts. A mailbox
+// delegates to an account at most once.
+func (r *SenderDelegationRepository) Create(ctx context.Context, delegation *domain.SenderDelegation) error {
+	s := r.store
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.senderDelegations[delegation.ID]; ok {
+		return conflict("delegation %s already exists", delegation.ID)
+	}
+	if delegation.AccountID == delegation.DelegateAccountID {
+		return conflict("email account %s cannot delegate to itself", delegation.AccountID)
+	}
+	for _, accountID := range []string{delegation.AccountID, delegation.DelegateAccountID} {
+		if _, ok := s.emailAccounts[accountID]; !ok {
+			return conflict("email account %s does not exist", accountID)
+		}
+	}
+	for _, existing := range s.senderDelegations {
+		if existing.AccountID == delegation.AccountID && existing.DelegateAccountID == delegation.DelegateAccountID {
+			return conflict("email account %s already delegates to %s", delegation.AccountID, delegation.DelegateAccountID)
+		}
+	}
+	c := *delegation
+	s.senderDelegations[delegation.ID] = &c
+	return nil
+}
+
+// GetByID returns a delegation, or nil when it does not exist
+func (r *SenderDelegationRepository) GetByID(ctx context.Context, id string) (*domain.SenderDelegation, error) {
+	s := r.store
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if delegation, ok := s.senderDelegations[id]; ok {
+		c := *delegation
+		return &c, nil
+	}
+	return nil, nil
+}
+
+// Get returns the delegation of a mailbox to an account, or nil
+func (r *SenderDelegationRepository) Get(ctx context.Context, accountID, delegateAccountID string) (*domain.SenderDelegation, error) {
+	s := r.store
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, delegation := range s.senderDelegations {
+		if delegation.AccountID == accountID && delegation.DelegateAccountID == delegateAccountID {
+			c := *delegation
+			return &c, nil
+		}
+	}
+	return nil, nil
+}
+
+// Update saves the permission of a delegation
+func (r *SenderDelegationRepository) Update(ctx context.Context, delegation *domain.SenderDelegation) error {
+	s := r.store
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if existing, ok := s.senderDelegations[delegation.ID]; ok {
+		existing.Permission = delegation.Permission
+		existing.GrantedBy = delegation.GrantedBy
+		existing.UpdatedAt = delegation.UpdatedAt
+	}
+	return nil
+}
+
+// Delete removes a delegation
+func (r *SenderDelegationRepository) Delete(ctx context.Context, id string) error {
+	s := r.store
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.senderDelegations, id)
+	return nil
+}
+
+// ListByAccount returns the delegations of a mailbox, oldest first
+func (r *SenderDelegationRepository) ListByAccount(ctx context.Context, accountID string) ([]*domain.SenderDelegation, error) {
+	return r.list(func(delegation *domain.SenderDelegation) bool { return delegation.AccountID == accountID }), nil
+}
+
+// ListByDelegate returns the delegations to an account, oldest first
+func (r *SenderDelegationRepository) ListByDelegate(ctx context.Context, delegateAccountID string) ([]*domain.SenderDelegation, error) {
+	return r.list(func(delegation *domain.SenderDelegation) bool {
+		return delegation.DelegateAccountID == delegateAccountID
+	}), nil
+}
+
+func (r *SenderDelegationRepository) list(match func(*domain.SenderDelegation) bool) []*domain.SenderDelegation {
+	s := r.store
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	delegations := []*domain.SenderDelegation{}
+	for _, delegation := range s.senderDelegations {
+		if match(delegation) {
+			c := *delegation
+			delegations = append(delegations, &c)
+		}
+	}
+	sort.Slice(delegations, func(i, j int) bool {
+		if !delegations[i].CreatedAt.Equal(delegations[j].CreatedAt) {
+			return delegations[i].CreatedAt.Before(delegations[j].CreatedAt)
+		}
+		return delegations[i].ID < delegations[j].ID
+	})
+	return delegations
+}
@@ -0,0 +1,51 @@
+package inmemory
+
+import (
+	"testing"
+
+	"github.com/skygenesisenterprise/aether-mailer/package/golang/repository/repotest"
+)
+
+func TestConformance(t *testing.T) {
+	repotest.Run(t, func(t *testing.T) *repotest.Repositories {
+		store := NewStore()
+		events := NewEventStore()
+		messages := NewMessageRepository(store)
+		return &repotest.Repositories{
+			Users:               NewUserRepository(store),
+			Domains:             NewDomainRepository(store),
+			DomainMembers:       NewDomainMemberRepository(store),
+			EmailAccounts:       NewEmailAccountRepository(store),
+			EmailAliases:        NewEmailAliasRepository(store),
+			DNSRecords:          NewDNSRecordRepository(store),
+			Folders:             NewFolderRepository(store),
+			Messages:            messages,
+			Attachments:         NewAttachmentRepository(store),
+			Quotas:              NewQuotaRepository(store),
+			Policies:            NewPolicyRepository(store),
+			Spam:                NewSpamRepository(store),
+			Quarantine:          NewQuarantineRepository(store),
+			RateCounters:        NewRateCounterStore(store),
+			Suspensions:         NewSendingSuspensionRepository(store),
+			DestinationPolicies: NewDestinationPolicyRepository(store),
+			DeliveryJobs:        NewDeliveryJobRepository(store),
+			IPPools:             NewIPPoolRepository(store),
+			PoolAssignments:     NewPoolAssignmentRepository(store),
+			MTASTSPolicies:      NewMTASTSPolicyRepository(store),
+			TLSResults:          NewTLSResultRepository(store),
+			DKIMKeys:            NewDKIMKeyRepository(store),
+			DKIMRotationLog:     NewDKIMRotationLogRepository(store),
+			Blobs:               NewBlobRepository(store),
+			SearchIndex:         NewSearchIndex(messages),
+			Threads:             NewThreadRepository(store),
+			ScheduledSends:      NewScheduledSendRepository(store),
+			Identities:          NewIdentityRepository(store),
+			SenderDelegations:   NewSenderDelegationRepository(store),
+			Groups:              NewGroupRepository(store),
+			FolderACLs:          NewFolderACLRepository(store),
+			ACLAuditLog:         NewACLAuditLogRepository(store),
+			Events:              events,
+			Outbox:              NewOutboxRepository(store, events),
+		}
+	})
+}
@@ -0,0 +1,499 @@
+package inmemory
+
+import (
+	"context"
+	"sort"
+	"strings"
+
+	"github.com/skygenesisenterprise/aether-mailer/package/golang/domain"
+	"github.com/skygenesisenterprise/aether-mailer/package/golang/repository"
+)
+
+// FolderRepository stores mailbox folders in memory
+type FolderRepository struct {
+	store *Store
+}
+
+// NewFolderRepository creates a folder repository on the given store
+func NewFolderRepository(store *Store) *FolderRepository {
+	return &FolderRepository{store: store}
+}
+
+// Create inserts a folder of an existing account
+func (r *FolderRepository) Create(ctx context.Context, folder *domain.Folder) error {
+	s := r.store
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.folders[folder.ID]; ok {
+		return conflict("folder %s already exists", folder.ID)
+	}
+	if _, ok := s.emailAccounts[folder.AccountID]; !ok {
+		return conflict("email account %s does not exist", folder.AccountID)
+	}
+	if err := s.checkFolder(folder.ID, folder.AccountID, folder); err != nil {
+		return err
+	}
+	s.folders[folder.ID] = copyFolder(folder)
+	return nil
+}
+
+// GetByID returns a folder, or nil when it does not exist
+func (r *FolderRepository) GetByID(ctx context.Context, id string) (*domain.Folder, error) {
+	s := r.store
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if folder, ok := s.folders[id]; ok {
+		return copyFolder(folder), nil
+	}
+	return nil, nil
+}
+
+// GetByType returns the first folder of a type in an account, or nil
+func (r *FolderRepository) GetByType(ctx context.Context, accountID string, folderType domain.FolderType) (*domain.Folder, error) {
+	s := r.store
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var first *domain.Folder
+	for _, folder := range s.folders {
+		if folder.AccountID != accountID || folder.Type != folderType {
+			continue
+		}
+		if first == nil || folder.CreatedAt.Before(first.CreatedAt) {
+			first = folder
+		}
+	}
+	if first == nil {
+		return nil, nil
+	}
+	return copyFolder(first), nil
+}
+
+// ListByAccount returns the folders of an account ordered by path
+func (r *FolderRepository) ListByAccount(ctx context.Context, accountID string) ([]*domain.Folder, error) {
+	s := r.store
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	folders := []*domain.Folder{}
+	for _, folder := range s.folders {
+		if folder.AccountID == accountID {
+			folders = append(folders, copyFolder(folder))
+		}
+	}
+	sort.Slice(folders, func(i, j int) bool { return folders[i].Path < folders[j].Path })
+	return folders, nil
+}
+
+// Update saves a folder; it stays in its account
+func (r *FolderRepository) Update(ctx context.Context, folder *domain.Folder) error {
+	s := r.store
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	existing, ok := s.folders[folder.ID]
+	if !ok {
+		return nil
+	}
+	if err := s.checkFolder(folder.ID, existing.AccountID, folder); err != nil {
+		return err
+	}
+	updated := copyFolder(folder)
+	updated.AccountID = existing.AccountID
+	updated.CreatedAt = existing.CreatedAt
+	s.folders[folder.ID] = updated
+	return nil
+}
+
+// Delete removes a folder with its subfolders
+func (r *FolderRepository) Delete(ctx context.Context, id string) error {
+	s := r.store
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.deleteFolderLocked(id)
+	return nil
+}
+
+func (s *Store) checkFolder(id, accountID string, folder *domain.Folder) error {
+	if folder.ParentID != nil {
+		if _, ok := s.folders[*folder.ParentID]; !ok {
+			return conflict("parent folder %s does not exist", *folder.ParentID)
+		}
+	}
+	for _, other := range s.folders {
+		if other.ID != id && other.AccountID == accountID && other.Path == folder.Path {
+			return conflict("folder %s already exists", folder.Path)
+		}
+	}
+	return nil
+}
+
+func (s *Store) deleteFolderLocked(id string) {
+	if _, ok := s.folders[id]; !ok {
+		return
+	}
+	delete(s.folders, id)
+	for aclID, acl := range s.folderACLs {
+		if acl.FolderID == id {
+			delete(s.folderACLs, aclID)
+		}
+	}
+	for childID, child := range s.folders {
+		if child.ParentID != nil && *child.ParentID == id {
+			s.deleteFolderLocked(childID)
+		}
+	}
+}
+
+func copyFolder(folder *domain.Folder) *domain.Folder {
+	c := *folder
+	c.ParentID = copyString(folder.ParentID)
+	return &c
+}
+
+// MessageRepository stores messages in memory. Search matches whole words
+// of the subject, addresses, body and attachment filenames, like Postgres.
+type MessageRepository struct {
+	store *Store
+}
+
+// NewMessageRepository creates a message repository on the given store
+func NewMessageRepository(store *Store) *MessageRepository {
+	return &MessageRepository{store: store}
+}
+
+// Create inserts a message of an existing account with the attachments not
+// stored yet
+func (r *MessageRepository) Create(ctx context.Context, message *domain.Message) error {
+	s := r.store
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.messages[message.ID]; ok {
+		return conflict("message %s already exists", message.ID)
+	}
+	if _, ok := s.emailAccounts[message.AccountID]; !ok {
+		return conflict("email account %s does not exist", message.AccountID)
+	}
+	stored := copyMessage(message)
+	stored.Attachments = nil
+	s.messages[message.ID] = stored
+
+	for i := range message.Attachments {
+		if _, ok := s.attachments[message.Attachments[i].ID]; ok {
+			continue
+		}
+		attachment := copyAttachment(&message.Attachments[i], true)
+		attachment.MessageID = message.ID
+		s.attachments[attachment.ID] = attachment
+	}
+	return nil
+}
+
+// GetByID returns a message with its attachments, or nil when it does not exist
+func (r *MessageRepository) GetByID(ctx context.Context, id string) (*domain.Message, error) {
+	s := r.store
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	message, ok := s.messages[id]
+	if !ok {
+		return nil, nil
+	}
+	return s.withAttachments(message, true), nil
+}
+
+// Update saves a message; its attachments are immutable
+func (r *MessageRepository) Update(ctx context.Context, message *domain.Message) error {
+	s := r.store
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	existing, ok := s.messages[message.ID]
+	if !ok {
+		return nil
+	}
+	updated := copyMessage(message)
+	updated.Attachments = nil
+	updated.AccountID = existing.AccountID
+	updated.CreatedAt = existing.CreatedAt
+	s.messages[message.ID] = updated
+	return nil
+}
+
+// Delete removes a message with its attachments
+func (r *MessageRepository) Delete(ctx context.Context, id string) error {
+	s := r.store
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for attachmentID, attachment := range s.attachments {
+		if attachment.MessageID == id {
+			delete(s.attachments, attachmentID)
+		}
+	}
+	delete(s.messages, id)
+	return nil
+}
+
+// ListByAccount returns the messages of an account matching a filter,
+// newest first
+func (r *MessageRepository) ListByAccount(ctx context.Context, accountID string, filter repository.MessageFilter) ([]*domain.Message, error) {
+	messages := r.list(func(message *domain.Message) bool {
+		return message.AccountID == accountID && messageMatches(message, filter) &&
+			r.store.attachmentsMatch(message.ID, filter.HasAttachments)
+	})
+	return page(messages, filter.Limit, filter.Offset), nil
+}
+
+// CountByAccount returns the number of messages of an account matching a filter
+func (r *MessageRepository) CountByAccount(ctx context.Context, accountID string, filter repository.MessageFilter) (int, error) {
+	s := r.store
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	count := 0
+	for _, message := range s.messages {
+		if message.AccountID == accountID && messageMatches(message, filter) &&
+			s.attachmentsMatch(message.ID, filter.HasAttachments) {
+			count++
+		}
+	}
+	return count, nil
+}
+
+// Search returns the messages of an account matching a search query,
+// newest first. An empty query matches every message.
+func (r *MessageRepository) Search(ctx context.Context, query repository.MessageSearchQuery) ([]*domain.Message, error) {
+	search, err := domain.ParseSearchQuery(query.Query)
+	if err != nil {
+		return nil, err
+	}
+	messages := r.list(func(message *domain.Message) bool {
+		if message.AccountID != query.AccountID {
+			return false
+		}
+		if query.DateFrom != nil && message.ReceivedAt.Before(*query.DateFrom) {
+			return false
+		}
+		if query.DateTo != nil && message.ReceivedAt.After(*query.DateTo) {
+			return false
+		}
+		full := r.store.withAttachments(message, false)
+		return search.MatchesMessage(full) && search.MatchesText(domain.NewSearchDocument(full).Text())
+	})
+	return page(messages, query.Limit, query.Offset), nil
+}
+
+// list returns the matching messages, newest first, with the metadata of
+// their attachments
+func (r *MessageRepository) list(match func(*domain.Message) bool) []*domain.Message {
+	s := r.store
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	messages := []*domain.Message{}
+	for _, message := range s.messages {
+		if match(message) {
+			messages = append(messages, s.withAttachments(message, false))
+		}
+	}
+	sort.Slice(messages, func(i, j int) bool {
+		if !messages[i].ReceivedAt.Equal(messages[j].ReceivedAt) {
+			return messages[i].ReceivedAt.After(messages[j].ReceivedAt)
+		}
+		return messages[i].ID < messages[j].ID
+	})
+	return messages
+}
+
+// withAttachments copies a message with its attachments, with or without
+// their content
+func (s *Store) withAttachments(message *domain.Message, content bool) *domain.Message {
+	c := copyMessage(message)
+	for _, attachment := range s.attachmentsOf(message.ID, content) {
+		c.Attachments = append(c.Attachments, *attachment)
+	}
+	return c
+}
+
+func (s *Store) attachmentsOf(messageID string, content bool) []*domain.Attachment {
+	attachments := []*domain.Attachment{}
+	for _, attachment := range s.attachments {
+		if attachment.MessageID == messageID {
+			attachments = append(attachments, copyAttachment(attachment, content))
+		}
+	}
+	sort.Slice(attachments, func(i, j int) bool {
+		if attachments[i].Filename != attachments[j].Filename {
+			return attachments[i].Filename < attachments[j].Filename
+		}
+		return attachments[i].ID < attachments[j].ID
+	})
+	return attachments
+}
+
+// attachmentsMatch reports whether a message has attachments when
+// hasAttachments requires it or has none when it excludes them
+func (s *Store) attachmentsMatch(messageID string, hasAttachments *bool) bool {
+	if hasAttachments == nil {
+		return true
+	}
+	for _, attachment := range s.attachments {
+		if attachment.MessageID == messageID {
+			return *hasAttachments
+		}
+	}
+	return !*hasAttachments
+}
+
+func messageMatches(message *domain.Message, filter repository.MessageFilter) bool {
+	if filter.FolderID != nil && message.FolderID != *filter.FolderID {
+		return false
+	}
+	if filter.ThreadID != nil && message.ThreadID != *filter.ThreadID {
+		return false
+	}
+	if filter.IsRead != nil && message.IsRead != *filter.IsRead {
+		return false
+	}
+	if filter.IsDraft != nil && message.IsDraft != *filter.IsDraft {
+		return false
+	}
+	if filter.IsSent != nil && message.IsSent != *filter.IsSent {
+		return false
+	}
+	if filter.IsDeleted != nil && message.IsDeleted != *filter.IsDeleted {
+		return false
+	}
+	if filter.IsStarred != nil && message.IsStarred != *filter.IsStarred {
+		return false
+	}
+	if filter.IsFlagged != nil && message.IsFlagged != *filter.IsFlagged {
+		return false
+	}
+	for _, label := range filter.Labels {
+		if !containsString(message.Labels, label) {
+			return false
+		}
+	}
+	if filter.From != nil && !containsFold(message.From, *filter.From) {
+		return false
+	}
+	if filter.To != nil && !containsFold(strings.Join(message.To, ", "), *filter.To) {
+		return false
+	}
+	if filter.Subject != nil && !containsFold(message.Subject, *filter.Subject) {
+		return false
+	}
+	if filter.DateFrom != nil && message.ReceivedAt.Before(*filter.DateFrom) {
+		return false
+	}
+	if filter.DateTo != nil && message.ReceivedAt.After(*filter.DateTo) {
+		return false
+	}
+	return true
+}
+
+func containsFold(s, substr string) bool {
+	return strings.Contains(strings.ToLower(s), strings.ToLower(substr))
+}
+
+func copyMessage(message *domain.Message) *domain.Message {
+	c := *message
+	c.To = copyStrings(message.To)
+	c.Cc = copyStrings(message.Cc)
+	c.Bcc = copyStrings(message.Bcc)
+	c.Labels = copyStrings(message.Labels)
+	// Postgres returns empty arrays and objects rather than NULL
+	if c.To == nil {
+		c.To = []string{}
+	}
+	if c.Cc == nil {
+		c.Cc = []string{}
+	}
+	if c.Bcc == nil {
+		c.Bcc = []string{}
+	}
+	if c.Labels == nil {
+		c.Labels = []string{}
+	}
+	c.Headers = make(map[string]string, len(message.Headers))
+	for name, value := range message.Headers {
+		c.Headers[name] = value
+	}
+	c.BodyText = copyString(message.BodyText)
+	c.BodyHTML = copyString(message.BodyHTML)
+	c.SentAt = copyTime(message.SentAt)
+	c.Attachments = []domain.Attachment{}
+	for i := range message.Attachments {
+		c.Attachments = append(c.Attachments, *copyAttachment(&message.Attachments[i], true))
+	}
+	return &c
+}
+
+// AttachmentRepository stores message attachments in memory
+type AttachmentRepository struct {
+	store *Store
+}
+
+// NewAttachmentRepository creates an attachment repository on the given store
+func NewAttachmentRepository(store *Store) *AttachmentRepository {
+	return &AttachmentRepository{store: store}
+}
+
+// Create inserts an attachment
+func (r *AttachmentRepository) Create(ctx context.Context, attachment *domain.Attachment) error {
+	s := r.store
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.attachments[attachment.ID]; ok {
+		return conflict("attachment %s already exists", attachment.ID)
+	}
+	s.attachments[attachment.ID] = copyAttachment(attachment, true)
+	return nil
+}
+
+// GetByID returns an attachment with its content, or nil when it does not exist
+func (r *AttachmentRepository) GetByID(ctx context.Context, id string) (*domain.Attachment, error) {
+	s := r.store
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if attachment, ok := s.attachments[id]; ok {
+		return copyAttachment(attachment, true), nil
+	}
+	return nil, nil
+}
+
+// GetByMessageID returns the attachments of a message with their content
+func (r *AttachmentRepository) GetByMessageID(ctx context.Context, messageID string) ([]*domain.Attachment, error) {
+	s := r.store
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.attachmentsOf(messageID, true), nil
+}
+
+// Delete removes an attachment
+func (r *AttachmentRepository) Delete(ctx context.Context, id string) error {
+	s := r.store
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.attachments, id)
+	return nil
+}
+
+func copyAttachment(attachment *domain.Attachment, content bool) *domain.Attachment {
+	c := *attachment
+	c.Content = nil
+	if content {
+		c.Content = copyBytes(attachment.Content)
+	}
+	return &c
+}
@@ -0,0 +1,192 @@
+package inmemory
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"github.com/skygenesisenterprise/aether-mailer/package/golang/domain"
+)
+
+// Transactor runs work directly. The store has no rollback, so writes made
+// before fn fails are kept.
+type Transactor struct{}
+
+// NewTransactor creates a transactor
+func NewTransactor() *Transactor {
+	return &Transactor{}
+}
+
+// WithinTransaction runs fn with ctx
+func (t *Transactor) WithinTransaction(ctx context.Context, fn func(ctx context.Context) error) error {
+	return fn(ctx)
+}
+
+// OutboxRepository keeps the event outbox in memory
+type OutboxRepository struct {
+	store  *Store
+	events domain.EventStore
+}
+
+// NewOutboxRepository creates an outbox repository that also saves added
+// events to the given event store when it is not nil
+func NewOutboxRepository(store *Store, events domain.EventStore) *OutboxRepository {
+	return &OutboxRepository{store: store, events: events}
+}
+
+// Add queues the entries for delivery. Entries already added are skipped.
+func (r *OutboxRepository) Add(ctx context.Context, entries []*domain.OutboxEntry) error {
+	added := []*domain.OutboxEntry{}
+	r.store.mu.Lock()
+	for _, entry := range entries {
+		if _, ok := r.store.outbox[entry.ID]; ok {
+			continue
+		}
+		r.store.outbox[entry.ID] = copyOutboxEntry(entry)
+		added = append(added, entry)
+	}
+	r.store.mu.Unlock()
+
+	if r.events != nil {
+		for _, entry := range added {
+			if err := r.events.Save(ctx, copyOutboxEntry(entry).Event()); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Claim leases up to limit pending entries due at now, oldest first
+func (r *OutboxRepository) Claim(ctx context.Context, now time.Time, limit int, lease time.Duration) ([]*domain.OutboxEntry, error) {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+
+	due := []*domain.OutboxEntry{}
+	for _, entry := range r.store.outbox {
+		if entry.Status == domain.OutboxStatusPending && !entry.NextAttemptAt.After(now) {
+			due = append(due, entry)
+		}
+	}
+	sort.Slice(due, func(i, j int) bool {
+		if !due[i].NextAttemptAt.Equal(due[j].NextAttemptAt) {
+			return due[i].NextAttemptAt.Before(due[j].NextAttemptAt)
+		}
+		return due[i].CreatedAt.Before(due[j].CreatedAt)
+	})
+	due = page(due, limit, 0)
+
+	claimed := make([]*domain.OutboxEntry, 0, len(due))
+	for _, entry := range due {
+		entry.Attempts++
+		entry.NextAttemptAt = now.Add(lease)
+		claimed = append(claimed, copyOutboxEntry(entry))
+	}
+	sort.Slice(claimed, func(i, j int) bool {
+		if !claimed[i].CreatedAt.Equal(claimed[j].CreatedAt) {
+			return claimed[i].CreatedAt.Before(claimed[j].CreatedAt)
+		}
+		return claimed[i].ID < claimed[j].ID
+	})
+	return claimed, nil
+}
+
+// MarkDelivered records the delivery of an entry
+func (r *OutboxRepository) MarkDelivered(ctx context.Context, id string, at time.Time) error {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+
+	if entry, ok := r.store.outbox[id]; ok {
+		entry.Status = domain.OutboxStatusDelivered
+		entry.DeliveredAt = &at
+		entry.LastError = ""
+	}
+	return nil
+}
+
+// MarkFailed records a failed attempt and when to try again
+func (r *OutboxRepository) MarkFailed(ctx context.Context, id string, lastError string, nextAttemptAt time.Time) error {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+
+	if entry, ok := r.store.outbox[id]; ok && entry.Status == domain.OutboxStatusPending {
+		entry.LastError = lastError
+		entry.NextAttemptAt = nextAttemptAt
+	}
+	return nil
+}
+
+// MarkDead moves an entry to the dead letters
+func (r *OutboxRepository) MarkDead(ctx context.Context, id string, lastError string) error {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+
+	if entry, ok := r.store.outbox[id]; ok && entry.Status == domain.OutboxStatusPending {
+		entry.Status = domain.OutboxStatusDead
+		entry.LastError = lastError
+	}
+	return nil
+}
+
+// ListDead returns the dead letters, newest first
+func (r *OutboxRepository) ListDead(ctx context.Context, limit, offset int) ([]*domain.OutboxEntry, error) {
+	r.store.mu.RLock()
+	defer r.store.mu.RUnlock()
+
+	dead := []*domain.OutboxEntry{}
+	for _, entry := range r.store.outbox {
+		if entry.Status == domain.OutboxStatusDead {
+			dead = append(dead, entry)
+		}
+	}
+	sort.Slice(dead, func(i, j int) bool {
+		if !dead[i].CreatedAt.Equal(dead[j].CreatedAt) {
+			return dead[i].CreatedAt.After(dead[j].CreatedAt)
+		}
+		return dead[i].ID < dead[j].ID
+	})
+
+	entries := []*domain.OutboxEntry{}
+	for _, entry := range page(dead, limit, offset) {
+		entries = append(entries, copyOutboxEntry(entry))
+	}
+	return entries, nil
+}
+
+// Requeue returns a dead letter to the pending entries with a fresh attempt
+// count and reports false when no dead letter has the ID
+func (r *OutboxRepository) Requeue(ctx context.Context, id string, at time.Time) (bool, error) {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+
+	entry, ok := r.store.outbox[id]
+	if !ok || entry.Status != domain.OutboxStatusDead {
+		return false, nil
+	}
+	entry.Status = domain.OutboxStatusPending
+	entry.Attempts = 0
+	entry.NextAttemptAt = at
+	return true, nil
+}
+
+// DeleteDelivered removes the entries delivered before a time
+func (r *OutboxRepository) DeleteDelivered(ctx context.Context, before time.Time) (int, error) {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+
+	deleted := 0
+	for id, entry := range r.store.outbox {
+		if entry.Status == domain.OutboxStatusDelivered && entry.DeliveredAt != nil && entry.DeliveredAt.Before(before) {
+			delete(r.store.outbox, id)
+			deleted++
+		}
+	}
+	return deleted, nil
+}
+
+func copyOutboxEntry(entry *domain.OutboxEntry) *domain.OutboxEntry {
+	c := *entry
+	c.Payload = copyBytes(entry.Payload)
+	c.DeliveredAt = copyTime(entry.DeliveredAt)
+	return &c
+}
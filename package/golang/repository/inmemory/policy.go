@@ -0,0 +1,567 @@
+package inmemory
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"github.com/skygenesisenterprise/aether-mailer/package/golang/domain"
+	"github.com/skygenesisenterprise/aether-mailer/package/golang/repository"
+)
+
+// QuotaRepository stores user and domain quotas in memory. A domain quota
+// is a quota without a user.
+type QuotaRepository struct {
+	store *Store
+}
+
+// NewQuotaRepository creates a quota repository on the given store
+func NewQuotaRepository(store *Store) *QuotaRepository {
+	return &QuotaRepository{store: store}
+}
+
+// Create inserts a quota
+func (r *QuotaRepository) Create(ctx context.Context, quota *domain.Quota) error {
+	s := r.store
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.findQuota(quota) != nil {
+		if quota.UserID != "" {
+			return conflict("quota of user %s already exists", quota.UserID)
+		}
+		return conflict("quota of domain %s already exists", stringOrEmpty(quota.DomainID))
+	}
+	s.quotas = append(s.quotas, copyQuota(quota))
+	return nil
+}
+
+// GetByUserID returns the quota of a user, or nil when it has none
+func (r *QuotaRepository) GetByUserID(ctx context.Context, userID string) (*domain.Quota, error) {
+	s := r.store
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if userID == "" {
+		return nil, nil
+	}
+	if quota := s.findQuota(&domain.Quota{UserID: userID}); quota != nil {
+		return copyQuota(quota), nil
+	}
+	return nil, nil
+}
+
+// GetByDomainID returns the quota of a domain, or nil when it has none
+func (r *QuotaRepository) GetByDomainID(ctx context.Context, domainID string) (*domain.Quota, error) {
+	s := r.store
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if quota := s.findQuota(&domain.Quota{DomainID: &domainID}); quota != nil {
+		return copyQuota(quota), nil
+	}
+	return nil, nil
+}
+
+// Update saves a quota, found by its user or, for a domain quota, its domain
+func (r *QuotaRepository) Update(ctx context.Context, quota *domain.Quota) error {
+	s := r.store
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	existing := s.findQuota(quota)
+	if existing == nil {
+		return nil
+	}
+	if quota.UserID != "" {
+		existing.DomainID = copyString(quota.DomainID)
+	}
+	existing.MaxStorageMB = quota.MaxStorageMB
+	existing.UsedStorageMB = quota.UsedStorageMB
+	existing.MaxEmailsPerDay = quota.MaxEmailsPerDay
+	existing.SentEmailsToday = quota.SentEmailsToday
+	existing.ResetAt = quota.ResetAt
+	return nil
+}
+
+// ResetDailyCounters clears the sending counter of a user, or of every
+// quota when userID is empty, and moves the reset time to the next midnight
+func (r *QuotaRepository) ResetDailyCounters(ctx context.Context, userID string) error {
+	s := r.store
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	next := time.Date(now.Year(), now.Month(), now.Day()+1, 0, 0, 0, 0, now.Location())
+	for _, quota := range s.quotas {
+		if userID == "" || quota.UserID == userID {
+			quota.SentEmailsToday = 0
+			quota.ResetAt = next
+		}
+	}
+	return nil
+}
+
+// findQuota returns the stored quota with the user of the given quota or,
+// when it has none, the domain quota of its domain
+func (s *Store) findQuota(quota *domain.Quota) *domain.Quota {
+	for _, existing := range s.quotas {
+		if quota.UserID != "" {
+			if existing.UserID == quota.UserID {
+				return existing
+			}
+			continue
+		}
+		if existing.UserID == "" && quota.DomainID != nil && existing.DomainID != nil &&
+			*existing.DomainID == *quota.DomainID {
+			return existing
+		}
+	}
+	return nil
+}
+
+func copyQuota(quota *domain.Quota) *domain.Quota {
+	c := *quota
+	c.DomainID = copyString(quota.DomainID)
+	return &c
+}
+
+func stringOrEmpty(s *string) string {
+	if s == nil {
+		return ""
+	}
+	return *s
+}
+
+// PolicyRepository stores mail policies in memory
+type PolicyRepository struct {
+	store *Store
+}
+
+// NewPolicyRepository creates a policy repository on the given store
+func NewPolicyRepository(store *Store) *PolicyRepository {
+	return &PolicyRepository{store: store}
+}
+
+// Create inserts a policy
+func (r *PolicyRepository) Create(ctx context.Context, policy *domain.Policy) error {
+	s := r.store
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.policies[policy.ID]; ok {
+		return conflict("policy %s already exists", policy.ID)
+	}
+	s.policies[policy.ID] = copyPolicy(policy)
+	return nil
+}
+
+// GetByID returns a policy, or nil when it does not exist
+func (r *PolicyRepository) GetByID(ctx context.Context, id string) (*domain.Policy, error) {
+	s := r.store
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if policy, ok := s.policies[id]; ok {
+		return copyPolicy(policy), nil
+	}
+	return nil, nil
+}
+
+// Update saves a policy
+func (r *PolicyRepository) Update(ctx context.Context, policy *domain.Policy) error {
+	s := r.store
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	existing, ok := s.policies[policy.ID]
+	if !ok {
+		return nil
+	}
+	updated := copyPolicy(policy)
+	updated.CreatedAt = existing.CreatedAt
+	s.policies[policy.ID] = updated
+	return nil
+}
+
+// Delete removes a policy
+func (r *PolicyRepository) Delete(ctx context.Context, id string) error {
+	s := r.store
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.policies, id)
+	return nil
+}
+
+// List returns the policies matching a filter, newest first
+func (r *PolicyRepository) List(ctx context.Context, filter repository.PolicyFilter) ([]*domain.Policy, error) {
+	policies := r.list(filter, false)
+	sort.Slice(policies, func(i, j int) bool {
+		if !policies[i].CreatedAt.Equal(policies[j].CreatedAt) {
+			return policies[i].CreatedAt.After(policies[j].CreatedAt)
+		}
+		return policies[i].ID < policies[j].ID
+	})
+	return page(policies, filter.Limit, filter.Offset), nil
+}
+
+// GetActivePolicies returns the active policies matching a filter, highest
+// priority first
+func (r *PolicyRepository) GetActivePolicies(ctx context.Context, filter repository.PolicyFilter) ([]*domain.Policy, error) {
+	policies := r.list(filter, true)
+	sort.Slice(policies, func(i, j int) bool {
+		if policies[i].Priority != policies[j].Priority {
+			return policies[i].Priority > policies[j].Priority
+		}
+		if !policies[i].CreatedAt.Equal(policies[j].CreatedAt) {
+			return policies[i].CreatedAt.Before(policies[j].CreatedAt)
+		}
+		return policies[i].ID < policies[j].ID
+	})
+	return page(policies, filter.Limit, filter.Offset), nil
+}
+
+func (r *PolicyRepository) list(filter repository.PolicyFilter, activeOnly bool) []*domain.Policy {
+	s := r.store
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	policies := []*domain.Policy{}
+	for _, policy := range s.policies {
+		if activeOnly && !policy.IsActive {
+			continue
+		}
+		if filter.DomainID != nil && (policy.DomainID == nil || *policy.DomainID != *filter.DomainID) {
+			continue
+		}
+		if filter.UserID != nil && (policy.UserID == nil || *policy.UserID != *filter.UserID) {
+			continue
+		}
+		if filter.Type != nil && policy.Type != *filter.Type {
+			continue
+		}
+		if filter.IsActive != nil && policy.IsActive != *filter.IsActive {
+			continue
+		}
+		policies = append(policies, copyPolicy(policy))
+	}
+	return policies
+}
+
+func copyPolicy(policy *domain.Policy) *domain.Policy {
+	c := *policy
+	c.DomainID = copyString(policy.DomainID)
+	c.UserID = copyString(policy.UserID)
+	return &c
+}
+
+// SpamRepository stores per-account spam training data in memory. Counts
+// never drop below zero.
+type SpamRepository struct {
+	store *Store
+}
+
+// NewSpamRepository creates a spam repository on the given store
+func NewSpamRepository(store *Store) *SpamRepository {
+	return &SpamRepository{store: store}
+}
+
+// GetTokens returns the counts of the given tokens that have been trained
+func (r *SpamRepository) GetTokens(ctx context.Context, accountID string, tokens []string) (map[string]*domain.SpamToken, error) {
+	s := r.store
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	result := make(map[string]*domain.SpamToken)
+	for _, token := range tokens {
+		if stored, ok := s.spamTokens[spamTokenKey{accountID, token}]; ok {
+			c := *stored
+			result[token] = &c
+		}
+	}
+	return result, nil
+}
+
+// IncrementTokens adds the deltas to the counts of every token
+func (r *SpamRepository) IncrementTokens(ctx context.Context, accountID string, tokens []string, spamDelta, hamDelta int) error {
+	s := r.store
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	seen := make(map[string]bool, len(tokens))
+	for _, token := range tokens {
+		if seen[token] {
+			continue
+		}
+		seen[token] = true
+
+		key := spamTokenKey{accountID, token}
+		stored, ok := s.spamTokens[key]
+		if !ok {
+			stored = &domain.SpamToken{AccountID: accountID, Token: token}
+			s.spamTokens[key] = stored
+		}
+		stored.SpamCount = nonNegative(stored.SpamCount + spamDelta)
+		stored.HamCount = nonNegative(stored.HamCount + hamDelta)
+		stored.UpdatedAt = now
+	}
+	return nil
+}
+
+// GetTotals returns the training totals of an account, or nil when it has
+// not been trained
+func (r *SpamRepository) GetTotals(ctx context.Context, accountID string) (*domain.SpamTotals, error) {
+	s := r.store
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if totals, ok := s.spamTotals[accountID]; ok {
+		c := *totals
+		return &c, nil
+	}
+	return nil, nil
+}
+
+// IncrementTotals adds the deltas to the training totals of an account
+func (r *SpamRepository) IncrementTotals(ctx context.Context, accountID string, spamDelta, hamDelta int) error {
+	s := r.store
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	totals, ok := s.spamTotals[accountID]
+	if !ok {
+		totals = &domain.SpamTotals{AccountID: accountID}
+		s.spamTotals[accountID] = totals
+	}
+	totals.SpamMessages = nonNegative(totals.SpamMessages + spamDelta)
+	totals.HamMessages = nonNegative(totals.HamMessages + hamDelta)
+	totals.UpdatedAt = time.Now()
+	return nil
+}
+
+// GetMessageClass returns the class a message was trained as, or nil
+func (r *SpamRepository) GetMessageClass(ctx context.Context, accountID, messageID string) (*domain.SpamClass, error) {
+	s := r.store
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if class, ok := s.spamClasses[spamTokenKey{accountID, messageID}]; ok {
+		return &class, nil
+	}
+	return nil, nil
+}
+
+// SetMessageClass records the class a message was trained as, or forgets
+// it when class is nil
+func (r *SpamRepository) SetMessageClass(ctx context.Context, accountID, messageID string, class *domain.SpamClass) error {
+	s := r.store
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key := spamTokenKey{accountID, messageID}
+	if class == nil {
+		delete(s.spamClasses, key)
+	} else {
+		s.spamClasses[key] = *class
+	}
+	return nil
+}
+
+func nonNegative(n int) int {
+	if n < 0 {
+		return 0
+	}
+	return n
+}
+
+// QuarantineRepository stores quarantined messages in memory
+type QuarantineRepository struct {
+	store *Store
+}
+
+// NewQuarantineRepository creates a quarantine repository on the given store
+func NewQuarantineRepository(store *Store) *QuarantineRepository {
+	return &QuarantineRepository{store: store}
+}
+
+// Create inserts an entry
+func (r *QuarantineRepository) Create(ctx context.Context, entry *domain.QuarantineEntry) error {
+	s := r.store
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.quarantine[entry.ID]; ok {
+		return conflict("quarantine entry %s already exists", entry.ID)
+	}
+	s.quarantine[entry.ID] = copyQuarantineEntry(entry)
+	return nil
+}
+
+// GetByID returns an entry, or nil when it does not exist
+func (r *QuarantineRepository) GetByID(ctx context.Context, id string) (*domain.QuarantineEntry, error) {
+	s := r.store
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if entry, ok := s.quarantine[id]; ok {
+		return copyQuarantineEntry(entry), nil
+	}
+	return nil, nil
+}
+
+// Update saves the review state of an entry
+func (r *QuarantineRepository) Update(ctx context.Context, entry *domain.QuarantineEntry) error {
+	s := r.store
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	existing, ok := s.quarantine[entry.ID]
+	if !ok {
+		return nil
+	}
+	existing.Status = entry.Status
+	existing.ExpiresAt = entry.ExpiresAt
+	existing.ReleasedAt = copyTime(entry.ReleasedAt)
+	existing.ReleasedBy = copyString(entry.ReleasedBy)
+	existing.DigestSentAt = copyTime(entry.DigestSentAt)
+	return nil
+}
+
+// Release marks a held entry released and reports false when it is no
+// longer held
+func (r *QuarantineRepository) Release(ctx context.Context, id, releasedBy string, at time.Time) (bool, error) {
+	s := r.store
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	existing, ok := s.quarantine[id]
+	if !ok || existing.Status != domain.QuarantineStatusHeld {
+		return false, nil
+	}
+	existing.Status = domain.QuarantineStatusReleased
+	existing.ReleasedAt = &at
+	existing.ReleasedBy = &releasedBy
+	return true, nil
+}
+
+// Delete removes an entry
+func (r *QuarantineRepository) Delete(ctx context.Context, id string) error {
+	s := r.store
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.quarantine, id)
+	return nil
+}
+
+// List returns the entries matching a filter, newest first
+func (r *QuarantineRepository) List(ctx context.Context, filter repository.QuarantineFilter) ([]*domain.QuarantineEntry, error) {
+	s := r.store
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	entries := []*domain.QuarantineEntry{}
+	for _, entry := range s.quarantine {
+		if quarantineMatches(entry, filter) {
+			entries = append(entries, copyQuarantineEntry(entry))
+		}
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		if !entries[i].CreatedAt.Equal(entries[j].CreatedAt) {
+			return entries[i].CreatedAt.After(entries[j].CreatedAt)
+		}
+		return entries[i].ID < entries[j].ID
+	})
+	return page(entries, filter.Limit, filter.Offset), nil
+}
+
+// Count returns the number of entries matching a filter
+func (r *QuarantineRepository) Count(ctx context.Context, filter repository.QuarantineFilter) (int, error) {
+	s := r.store
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	count := 0
+	for _, entry := range s.quarantine {
+		if quarantineMatches(entry, filter) {
+			count++
+		}
+	}
+	return count, nil
+}
+
+// DeleteExpired removes the entries that expired before the given time and
+// returns how many were removed
+func (r *QuarantineRepository) DeleteExpired(ctx context.Context, before time.Time) (int, error) {
+	s := r.store
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	count := 0
+	for id, entry := range s.quarantine {
+		if entry.ExpiresAt.Before(before) {
+			delete(s.quarantine, id)
+			count++
+		}
+	}
+	return count, nil
+}
+
+func quarantineMatches(entry *domain.QuarantineEntry, filter repository.QuarantineFilter) bool {
+	if len(filter.AccountIDs) > 0 {
+		found := false
+		for _, accountID := range filter.AccountIDs {
+			if entry.AccountID == accountID {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	if filter.DomainID != nil && entry.DomainID != *filter.DomainID {
+		return false
+	}
+	if filter.Reason != nil && entry.Reason != *filter.Reason {
+		return false
+	}
+	if filter.Status != nil && entry.Status != *filter.Status {
+		return false
+	}
+	if filter.CreatedAfter != nil && !entry.CreatedAt.After(*filter.CreatedAfter) {
+		return false
+	}
+	if filter.DigestPending != nil && (entry.DigestSentAt == nil) != *filter.DigestPending {
+		return false
+	}
+	return true
+}
+
+func copyQuarantineEntry(entry *domain.QuarantineEntry) *domain.QuarantineEntry {
+	c := *entry
+	c.Recipients = copyStrings(entry.Recipients)
+	if c.Recipients == nil {
+		c.Recipients = []string{}
+	}
+	c.PolicyID = copyString(entry.PolicyID)
+	c.PolicyName = copyString(entry.PolicyName)
+	if entry.SpamVerdict != nil {
+		verdict := *entry.SpamVerdict
+		verdict.Rules = append([]domain.SpamRuleHit(nil), entry.SpamVerdict.Rules...)
+		c.SpamVerdict = &verdict
+	}
+	if entry.VirusVerdict != nil {
+		verdict := *entry.VirusVerdict
+		verdict.Findings = append([]domain.VirusFinding(nil), entry.VirusVerdict.Findings...)
+		c.VirusVerdict = &verdict
+	}
+	c.Message = *copyMessage(&entry.Message)
+	c.RawMessage = copyBytes(entry.RawMessage)
+	c.ReleasedAt = copyTime(entry.ReleasedAt)
+	c.ReleasedBy = copyString(entry.ReleasedBy)
+	c.DigestSentAt = copyTime(entry.DigestSentAt)
+	return &c
+}
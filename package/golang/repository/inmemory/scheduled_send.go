@@ -0,0 +1,269 @@
+package inmemory
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"github.com/skygenesisenterprise/aether-mailer/package/golang/domain"
+	"github.com/skygenesisenterprise/aether-mailer/package/golang/repository"
+)
+
+// ScheduledSendRepository keeps the drafts held until they are due to be
+// sent in memory
+type ScheduledSendRepository struct {
+	store *Store
+}
+
+// NewScheduledSendRepository creates a scheduled send repository on the
+// given store
+func NewScheduledSendRepository(store *Store) *ScheduledSendRepository {
+	return &ScheduledSendRepository{store: store}
+}
+
+// Create inserts a scheduled send of an existing account. A draft has at
+// most one pending send.
+func (r *ScheduledSendRepository) Create(ctx context.Context, send *domain.ScheduledSend) error {
+	s := r.store
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.scheduledSends[send.ID]; ok {
+		return conflict("scheduled send %s already exists", send.ID)
+	}
+	if _, ok := s.emailAccounts[send.AccountID]; !ok {
+		return conflict("email account %s does not exist", send.AccountID)
+	}
+	if send.Status == domain.ScheduledSendStatusPending {
+		for _, existing := range s.scheduledSends {
+			if existing.DraftID == send.DraftID && existing.Status == domain.ScheduledSendStatusPending {
+				return conflict("draft %s already has a pending send", send.DraftID)
+			}
+		}
+	}
+	s.scheduledSends[send.ID] = copyScheduledSend(send)
+	return nil
+}
+
+// GetByID returns a scheduled send, or nil when it does not exist
+func (r *ScheduledSendRepository) GetByID(ctx context.Context, id string) (*domain.ScheduledSend, error) {
+	s := r.store
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if send, ok := s.scheduledSends[id]; ok {
+		return copyScheduledSend(send), nil
+	}
+	return nil, nil
+}
+
+// GetPendingByDraftID returns the pending send of a draft, or nil
+func (r *ScheduledSendRepository) GetPendingByDraftID(ctx context.Context, draftID string) (*domain.ScheduledSend, error) {
+	s := r.store
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, send := range s.scheduledSends {
+		if send.DraftID == draftID && send.Status == domain.ScheduledSendStatusPending {
+			return copyScheduledSend(send), nil
+		}
+	}
+	return nil, nil
+}
+
+// List returns the scheduled sends matching a filter, soonest first
+func (r *ScheduledSendRepository) List(ctx context.Context, filter repository.ScheduledSendFilter) ([]*domain.ScheduledSend, error) {
+	s := r.store
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	sends := []*domain.ScheduledSend{}
+	for _, send := range s.scheduledSends {
+		if scheduledSendMatches(send, filter) {
+			sends = append(sends, copyScheduledSend(send))
+		}
+	}
+	sort.Slice(sends, func(i, j int) bool {
+		if !sends[i].SendAt.Equal(sends[j].SendAt) {
+			return sends[i].SendAt.Before(sends[j].SendAt)
+		}
+		if !sends[i].CreatedAt.Equal(sends[j].CreatedAt) {
+			return sends[i].CreatedAt.Before(sends[j].CreatedAt)
+		}
+		return sends[i].ID < sends[j].ID
+	})
+	return page(sends, filter.Limit, filter.Offset), nil
+}
+
+// Count returns the number of scheduled sends matching a filter
+func (r *ScheduledSendRepository) Count(ctx context.Context, filter repository.ScheduledSendFilter) (int, error) {
+	s := r.store
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	count := 0
+	for _, send := range s.scheduledSends {
+		if scheduledSendMatches(send, filter) {
+			count++
+		}
+	}
+	return count, nil
+}
+
+// Claim leases up to limit pending sends due at now, soonest first
+func (r *ScheduledSendRepository) Claim(ctx context.Context, now time.Time, limit int, lease time.Duration) ([]*domain.ScheduledSend, error) {
+	s := r.store
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	due := []*domain.ScheduledSend{}
+	for _, send := range s.scheduledSends {
+		if send.Status == domain.ScheduledSendStatusPending && !send.NextAttemptAt.After(now) && !send.IsLocked(now) {
+			due = append(due, send)
+		}
+	}
+	sort.Slice(due, func(i, j int) bool {
+		if !due[i].NextAttemptAt.Equal(due[j].NextAttemptAt) {
+			return due[i].NextAttemptAt.Before(due[j].NextAttemptAt)
+		}
+		if !due[i].CreatedAt.Equal(due[j].CreatedAt) {
+			return due[i].CreatedAt.Before(due[j].CreatedAt)
+		}
+		return due[i].ID < due[j].ID
+	})
+	due = page(due, limit, 0)
+
+	claimed := make([]*domain.ScheduledSend, 0, len(due))
+	lockedUntil := now.Add(lease)
+	for _, send := range due {
+		send.Attempts++
+		send.LockedUntil = &lockedUntil
+		claimed = append(claimed, copyScheduledSend(send))
+	}
+	return claimed, nil
+}
+
+// Lock leases a pending send that is not leased and reports false otherwise
+func (r *ScheduledSendRepository) Lock(ctx context.Context, id string, now time.Time, lease time.Duration) (bool, error) {
+	s := r.store
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	send, ok := s.scheduledSends[id]
+	if !ok || send.Status != domain.ScheduledSendStatusPending || send.IsLocked(now) {
+		return false, nil
+	}
+	lockedUntil := now.Add(lease)
+	send.LockedUntil = &lockedUntil
+	return true, nil
+}
+
+// Reschedule sets when a pending send is due and releases its lease
+func (r *ScheduledSendRepository) Reschedule(ctx context.Context, id string, sendAt, at time.Time) error {
+	s := r.store
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if send, ok := s.scheduledSends[id]; ok && send.Status == domain.ScheduledSendStatusPending {
+		send.SendAt = sendAt
+		send.NextAttemptAt = sendAt
+		send.LockedUntil = nil
+		send.LastError = ""
+		send.UpdatedAt = at
+	}
+	return nil
+}
+
+// MarkFailed records a failed attempt of a claimed send, releases the lease
+// and sets when to try again
+func (r *ScheduledSendRepository) MarkFailed(ctx context.Context, claim *domain.ScheduledSend, lastError string, nextAttemptAt time.Time) (bool, error) {
+	s := r.store
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	send, ok := s.scheduledSends[claim.ID]
+	if !ok || !s.holdsClaim(send, claim) {
+		return false, nil
+	}
+	send.LastError = lastError
+	send.NextAttemptAt = nextAttemptAt
+	send.LockedUntil = nil
+	return true, nil
+}
+
+// Complete ends a claimed send
+func (r *ScheduledSendRepository) Complete(ctx context.Context, claim *domain.ScheduledSend, status domain.ScheduledSendStatus, sentMessageID *string, lastError string, at time.Time) (bool, error) {
+	s := r.store
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	send, ok := s.scheduledSends[claim.ID]
+	if !ok || !s.holdsClaim(send, claim) {
+		return false, nil
+	}
+	send.Status = status
+	send.SentMessageID = copyString(sentMessageID)
+	send.LastError = lastError
+	send.LockedUntil = nil
+	send.UpdatedAt = at
+	send.CompletedAt = &at
+	return true, nil
+}
+
+// holdsClaim reports whether a pending send is still leased by the claim
+// that returned claim. The caller must hold s.mu.
+func (s *Store) holdsClaim(send, claim *domain.ScheduledSend) bool {
+	return send.Status == domain.ScheduledSendStatusPending && send.Attempts == claim.Attempts &&
+		send.LockedUntil != nil && claim.LockedUntil != nil && send.LockedUntil.Equal(*claim.LockedUntil)
+}
+
+// Cancel cancels a pending send that is not leased and reports false
+// otherwise
+func (r *ScheduledSendRepository) Cancel(ctx context.Context, id string, now time.Time) (bool, error) {
+	s := r.store
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	send, ok := s.scheduledSends[id]
+	if !ok || send.Status != domain.ScheduledSendStatusPending || send.IsLocked(now) {
+		return false, nil
+	}
+	send.Status = domain.ScheduledSendStatusCancelled
+	send.UpdatedAt = now
+	send.CompletedAt = &now
+	return true, nil
+}
+
+// DeleteCompleted removes the sends completed before a time
+func (r *ScheduledSendRepository) DeleteCompleted(ctx context.Context, before time.Time) (int, error) {
+	s := r.store
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	deleted := 0
+	for id, send := range s.scheduledSends {
+		if send.Status != domain.ScheduledSendStatusPending && send.CompletedAt != nil && send.CompletedAt.Before(before) {
+			delete(s.scheduledSends, id)
+			deleted++
+		}
+	}
+	return deleted, nil
+}
+
+func scheduledSendMatches(send *domain.ScheduledSend, filter repository.ScheduledSendFilter) bool {
+	if filter.AccountID != "" && send.AccountID != filter.AccountID {
+		return false
+	}
+	if filter.Status != nil && send.Status != *filter.Status {
+		return false
+	}
+	return true
+}
+
+func copyScheduledSend(send *domain.ScheduledSend) *domain.ScheduledSend {
+	c := *send
+	c.LockedUntil = copyTime(send.LockedUntil)
+	c.SentMessageID = copyString(send.SentMessageID)
+	c.CompletedAt = copyTime(send.CompletedAt)
+	return &c
+}
@@ -0,0 +1,144 @@
+package inmemory
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/skygenesisenterprise/aether-mailer/package/golang/domain"
+	"github.com/skygenesisenterprise/aether-mailer/package/golang/repository"
+)
+
+// SearchIndex is an embedded full-text index held in memory. It works with
+// any message repository, which it reads to check the operators of a query
+// and to drop hits for deleted messages. The index is lost on restart and
+// rebuilt by reindexing the accounts.
+type SearchIndex struct {
+	messages repository.MessageRepository
+
+	mu        sync.RWMutex
+	entries   map[string]*searchEntry
+	byAccount map[string]map[string]struct{}
+}
+
+type searchEntry struct {
+	accountID string
+	text      *domain.SearchText
+}
+
+// NewSearchIndex creates an empty index of the messages of a repository
+func NewSearchIndex(messages repository.MessageRepository) *SearchIndex {
+	return &SearchIndex{
+		messages:  messages,
+		entries:   make(map[string]*searchEntry),
+		byAccount: make(map[string]map[string]struct{}),
+	}
+}
+
+// Index adds a document or replaces the document of the same message
+func (x *SearchIndex) Index(ctx context.Context, document *domain.SearchDocument) error {
+	entry := &searchEntry{accountID: document.AccountID, text: document.Text()}
+
+	x.mu.Lock()
+	defer x.mu.Unlock()
+
+	x.remove(document.MessageID)
+	x.entries[document.MessageID] = entry
+	if x.byAccount[entry.accountID] == nil {
+		x.byAccount[entry.accountID] = make(map[string]struct{})
+	}
+	x.byAccount[entry.accountID][document.MessageID] = struct{}{}
+	return nil
+}
+
+// Remove removes the document of a message
+func (x *SearchIndex) Remove(ctx context.Context, messageID string) error {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+
+	x.remove(messageID)
+	return nil
+}
+
+// RemoveAccount removes the documents of every message of an account
+func (x *SearchIndex) RemoveAccount(ctx context.Context, accountID string) error {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+
+	for messageID := range x.byAccount[accountID] {
+		delete(x.entries, messageID)
+	}
+	delete(x.byAccount, accountID)
+	return nil
+}
+
+func (x *SearchIndex) remove(messageID string) {
+	entry := x.entries[messageID]
+	if entry == nil {
+		return
+	}
+	delete(x.entries, messageID)
+	delete(x.byAccount[entry.accountID], messageID)
+}
+
+// Search returns the hits of an account, best first, and their total
+func (x *SearchIndex) Search(ctx context.Context, query repository.SearchIndexQuery) ([]repository.SearchHit, int, error) {
+	type candidate struct {
+		id        string
+		relevance float64
+	}
+
+	search := query.Query
+	if search == nil {
+		search = &domain.SearchQuery{}
+	}
+
+	// Match the text under the lock, then read the messages without it
+	x.mu.RLock()
+	candidates := []candidate{}
+	for messageID := range x.byAccount[query.AccountID] {
+		entry := x.entries[messageID]
+		if search.MatchesText(entry.text) {
+			candidates = append(candidates, candidate{messageID, search.Relevance(entry.text)})
+		}
+	}
+	x.mu.RUnlock()
+
+	now := time.Now()
+	hits := []repository.SearchHit{}
+	received := make(map[string]time.Time)
+	for _, c := range candidates {
+		message, err := x.messages.GetByID(ctx, c.id)
+		if err != nil {
+			return nil, 0, err
+		}
+		if message == nil {
+			// The message was deleted since it was indexed
+			if err := x.Remove(ctx, c.id); err != nil {
+				return nil, 0, err
+			}
+			continue
+		}
+		if message.AccountID != query.AccountID || !search.MatchesMessage(message) {
+			continue
+		}
+		if query.FolderID != nil && message.FolderID != *query.FolderID {
+			continue
+		}
+		hits = append(hits, repository.SearchHit{MessageID: c.id, Score: domain.SearchScore(c.relevance, message.ReceivedAt, now)})
+		received[c.id] = message.ReceivedAt
+	}
+
+	sort.Slice(hits, func(i, j int) bool {
+		a, b := hits[i], hits[j]
+		if a.Score != b.Score {
+			return a.Score > b.Score
+		}
+		if !received[a.MessageID].Equal(received[b.MessageID]) {
+			return received[a.MessageID].After(received[b.MessageID])
+		}
+		return a.MessageID < b.MessageID
+	})
+	return page(hits, query.Limit, query.Offset), len(hits), nil
+}
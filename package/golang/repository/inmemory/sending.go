@@ -0,0 +1,503 @@
+package inmemory
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"github.com/skygenesisenterprise/aether-mailer/package/golang/domain"
+)
+
+// RateCounterStore keeps sliding-window counters in memory, grouped in
+// buckets of one sixtieth of the window like the Postgres store
+type RateCounterStore struct {
+	store *Store
+}
+
+// NewRateCounterStore creates a counter store on the given store
+func NewRateCounterStore(store *Store) *RateCounterStore {
+	return &RateCounterStore{store: store}
+}
+
+// Add records amount in the bucket containing at and returns the window total
+func (c *RateCounterStore) Add(ctx context.Context, key string, amount int64, at time.Time, window time.Duration) (int64, error) {
+	s := c.store
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	bucket := at.Truncate(bucketSize(window))
+	s.rateCounters[rateBucketKey{key, bucket}] += amount
+
+	// Like the Postgres store, the current bucket counts even when at lies
+	// before the newest bucket
+	var total int64
+	for k, count := range s.rateCounters {
+		if k.key == key && (k.bucket.Equal(bucket) || k.bucket.After(at.Add(-window))) {
+			total += count
+		}
+	}
+	return total, nil
+}
+
+// Sum returns the window total ending at the given time
+func (c *RateCounterStore) Sum(ctx context.Context, key string, at time.Time, window time.Duration) (int64, error) {
+	s := c.store
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var total int64
+	for k, count := range s.rateCounters {
+		if k.key == key && k.bucket.After(at.Add(-window)) && !k.bucket.After(at) {
+			total += count
+		}
+	}
+	return total, nil
+}
+
+// Prune deletes buckets older than before
+func (c *RateCounterStore) Prune(ctx context.Context, before time.Time) error {
+	s := c.store
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for k := range s.rateCounters {
+		if k.bucket.Before(before) {
+			delete(s.rateCounters, k)
+		}
+	}
+	return nil
+}
+
+func bucketSize(window time.Duration) time.Duration {
+	size := window / 60
+	if size < time.Second {
+		size = time.Second
+	}
+	return size
+}
+
+// SendingSuspensionRepository stores sending suspensions in memory
+type SendingSuspensionRepository struct {
+	store *Store
+}
+
+// NewSendingSuspensionRepository creates a suspension repository on the given store
+func NewSendingSuspensionRepository(store *Store) *SendingSuspensionRepository {
+	return &SendingSuspensionRepository{store: store}
+}
+
+// Create inserts a suspension
+func (r *SendingSuspensionRepository) Create(ctx context.Context, suspension *domain.SendingSuspension) error {
+	s := r.store
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.suspensions[suspension.ID]; ok {
+		return conflict("sending suspension %s already exists", suspension.ID)
+	}
+	s.suspensions[suspension.ID] = copySuspension(suspension)
+	return nil
+}
+
+// GetByID returns a suspension, or nil when it does not exist
+func (r *SendingSuspensionRepository) GetByID(ctx context.Context, id string) (*domain.SendingSuspension, error) {
+	s := r.store
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if suspension, ok := s.suspensions[id]; ok {
+		return copySuspension(suspension), nil
+	}
+	return nil, nil
+}
+
+// GetActive returns the suspension in force for a scope, or nil
+func (r *SendingSuspensionRepository) GetActive(ctx context.Context, scope domain.RateScope, key string, at time.Time) (*domain.SendingSuspension, error) {
+	active := r.listActive(at, func(suspension *domain.SendingSuspension) bool {
+		return suspension.Scope == scope && suspension.Key == key
+	})
+	if len(active) == 0 {
+		return nil, nil
+	}
+	return active[0], nil
+}
+
+// Update saves a suspension
+func (r *SendingSuspensionRepository) Update(ctx context.Context, suspension *domain.SendingSuspension) error {
+	s := r.store
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	existing, ok := s.suspensions[suspension.ID]
+	if !ok {
+		return nil
+	}
+	existing.Reason = suspension.Reason
+	existing.ExpiresAt = copyTime(suspension.ExpiresAt)
+	existing.LiftedAt = copyTime(suspension.LiftedAt)
+	existing.LiftedBy = copyString(suspension.LiftedBy)
+	return nil
+}
+
+// ListActive lists the suspensions in force at the given time
+func (r *SendingSuspensionRepository) ListActive(ctx context.Context, at time.Time) ([]*domain.SendingSuspension, error) {
+	return r.listActive(at, func(*domain.SendingSuspension) bool { return true }), nil
+}
+
+// listActive returns the matching suspensions in force, newest first
+func (r *SendingSuspensionRepository) listActive(at time.Time, match func(*domain.SendingSuspension) bool) []*domain.SendingSuspension {
+	s := r.store
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	suspensions := []*domain.SendingSuspension{}
+	for _, suspension := range s.suspensions {
+		if match(suspension) && suspension.LiftedAt == nil &&
+			(suspension.ExpiresAt == nil || suspension.ExpiresAt.After(at)) {
+			suspensions = append(suspensions, copySuspension(suspension))
+		}
+	}
+	sort.Slice(suspensions, func(i, j int) bool {
+		if !suspensions[i].SuspendedAt.Equal(suspensions[j].SuspendedAt) {
+			return suspensions[i].SuspendedAt.After(suspensions[j].SuspendedAt)
+		}
+		return suspensions[i].ID < suspensions[j].ID
+	})
+	return suspensions
+}
+
+func copySuspension(suspension *domain.SendingSuspension) *domain.SendingSuspension {
+	c := *suspension
+	c.ExpiresAt = copyTime(suspension.ExpiresAt)
+	c.LiftedAt = copyTime(suspension.LiftedAt)
+	c.LiftedBy = copyString(suspension.LiftedBy)
+	return &c
+}
+
+// DestinationPolicyRepository stores destination policies in memory.
+// Backoffs are kept to the millisecond, as in Postgres.
+type DestinationPolicyRepository struct {
+	store *Store
+}
+
+// NewDestinationPolicyRepository creates a destination policy repository on the given store
+func NewDestinationPolicyRepository(store *Store) *DestinationPolicyRepository {
+	return &DestinationPolicyRepository{store: store}
+}
+
+// Create inserts a destination policy
+func (r *DestinationPolicyRepository) Create(ctx context.Context, policy *domain.DestinationPolicy) error {
+	s := r.store
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.destinations[policy.ID]; ok {
+		return conflict("destination policy %s already exists", policy.ID)
+	}
+	if err := s.checkDestinationUnique(policy); err != nil {
+		return err
+	}
+	s.destinations[policy.ID] = copyDestinationPolicy(policy)
+	return nil
+}
+
+// GetByID returns a destination policy, or nil when it does not exist
+func (r *DestinationPolicyRepository) GetByID(ctx context.Context, id string) (*domain.DestinationPolicy, error) {
+	s := r.store
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if policy, ok := s.destinations[id]; ok {
+		return copyDestinationPolicy(policy), nil
+	}
+	return nil, nil
+}
+
+// Update saves a destination policy
+func (r *DestinationPolicyRepository) Update(ctx context.Context, policy *domain.DestinationPolicy) error {
+	s := r.store
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	existing, ok := s.destinations[policy.ID]
+	if !ok {
+		return nil
+	}
+	if err := s.checkDestinationUnique(policy); err != nil {
+		return err
+	}
+	updated := copyDestinationPolicy(policy)
+	updated.CreatedAt = existing.CreatedAt
+	s.destinations[policy.ID] = updated
+	return nil
+}
+
+// Delete removes a destination policy
+func (r *DestinationPolicyRepository) Delete(ctx context.Context, id string) error {
+	s := r.store
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.destinations, id)
+	return nil
+}
+
+// List returns every destination policy ordered by name
+func (r *DestinationPolicyRepository) List(ctx context.Context) ([]*domain.DestinationPolicy, error) {
+	s := r.store
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	policies := []*domain.DestinationPolicy{}
+	for _, policy := range s.destinations {
+		policies = append(policies, copyDestinationPolicy(policy))
+	}
+	sort.Slice(policies, func(i, j int) bool {
+		if policies[i].Name != policies[j].Name {
+			return policies[i].Name < policies[j].Name
+		}
+		return policies[i].ID < policies[j].ID
+	})
+	return policies, nil
+}
+
+func (s *Store) checkDestinationUnique(policy *domain.DestinationPolicy) error {
+	for _, other := range s.destinations {
+		if other.ID != policy.ID && other.MXPattern == policy.MXPattern {
+			return conflict("destination policy for %s already exists", policy.MXPattern)
+		}
+	}
+	return nil
+}
+
+func copyDestinationPolicy(policy *domain.DestinationPolicy) *domain.DestinationPolicy {
+	c := *policy
+	c.Backoff = policy.Backoff.Truncate(time.Millisecond)
+	c.MaxBackoff = policy.MaxBackoff.Truncate(time.Millisecond)
+	return &c
+}
+
+// IPPoolRepository stores IP pools and their addresses in memory
+type IPPoolRepository struct {
+	store *Store
+}
+
+// NewIPPoolRepository creates an IP pool repository on the given store
+func NewIPPoolRepository(store *Store) *IPPoolRepository {
+	return &IPPoolRepository{store: store}
+}
+
+// Create inserts a pool with its addresses
+func (r *IPPoolRepository) Create(ctx context.Context, ipPool *domain.IPPool) error {
+	s := r.store
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.ipPools[ipPool.ID]; ok {
+		return conflict("IP pool %s already exists", ipPool.ID)
+	}
+	if err := s.checkIPPool(ipPool); err != nil {
+		return err
+	}
+	s.ipPools[ipPool.ID] = copyIPPool(ipPool)
+	return nil
+}
+
+// GetByID returns a pool, or nil when it does not exist
+func (r *IPPoolRepository) GetByID(ctx context.Context, id string) (*domain.IPPool, error) {
+	return r.find(func(ipPool *domain.IPPool) bool { return ipPool.ID == id }), nil
+}
+
+// GetByName returns a pool by name, or nil when it does not exist
+func (r *IPPoolRepository) GetByName(ctx context.Context, name string) (*domain.IPPool, error) {
+	return r.find(func(ipPool *domain.IPPool) bool { return ipPool.Name == name }), nil
+}
+
+// GetDefault returns the default pool, or nil when none is marked default
+func (r *IPPoolRepository) GetDefault(ctx context.Context) (*domain.IPPool, error) {
+	return r.find(func(ipPool *domain.IPPool) bool { return ipPool.IsDefault }), nil
+}
+
+// Update saves a pool and replaces its addresses
+func (r *IPPoolRepository) Update(ctx context.Context, ipPool *domain.IPPool) error {
+	s := r.store
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	existing, ok := s.ipPools[ipPool.ID]
+	if !ok {
+		return nil
+	}
+	if err := s.checkIPPool(ipPool); err != nil {
+		return err
+	}
+	updated := copyIPPool(ipPool)
+	updated.CreatedAt = existing.CreatedAt
+	s.ipPools[ipPool.ID] = updated
+	return nil
+}
+
+// Delete removes a pool with its addresses and assignments
+func (r *IPPoolRepository) Delete(ctx context.Context, id string) error {
+	s := r.store
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.ipPools, id)
+	for key, assignment := range s.assignments {
+		if assignment.PoolID == id {
+			delete(s.assignments, key)
+		}
+	}
+	return nil
+}
+
+// List returns every pool ordered by name
+func (r *IPPoolRepository) List(ctx context.Context) ([]*domain.IPPool, error) {
+	s := r.store
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	pools := []*domain.IPPool{}
+	for _, ipPool := range s.ipPools {
+		pools = append(pools, copyIPPool(ipPool))
+	}
+	sort.Slice(pools, func(i, j int) bool { return pools[i].Name < pools[j].Name })
+	return pools, nil
+}
+
+func (r *IPPoolRepository) find(match func(*domain.IPPool) bool) *domain.IPPool {
+	s := r.store
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, ipPool := range s.ipPools {
+		if match(ipPool) {
+			return copyIPPool(ipPool)
+		}
+	}
+	return nil
+}
+
+// checkIPPool enforces unique pool names, a single default pool and
+// addresses belonging to one pool
+func (s *Store) checkIPPool(ipPool *domain.IPPool) error {
+	seen := make(map[string]bool)
+	for _, addr := range ipPool.Addresses {
+		if seen[addr.IP] {
+			return conflict("address %s is listed twice", addr.IP)
+		}
+		seen[addr.IP] = true
+	}
+	for _, other := range s.ipPools {
+		if other.ID == ipPool.ID {
+			continue
+		}
+		if other.Name == ipPool.Name {
+			return conflict("IP pool %s already exists", ipPool.Name)
+		}
+		if other.IsDefault && ipPool.IsDefault {
+			return conflict("IP pool %s is already the default", other.Name)
+		}
+		for _, addr := range other.Addresses {
+			if seen[addr.IP] {
+				return conflict("address %s already belongs to IP pool %s", addr.IP, other.Name)
+			}
+		}
+	}
+	return nil
+}
+
+func copyIPPool(ipPool *domain.IPPool) *domain.IPPool {
+	c := *ipPool
+	c.Addresses = []domain.PoolAddress{}
+	for _, addr := range ipPool.Addresses {
+		addr.WarmupStartedAt = copyTime(addr.WarmupStartedAt)
+		c.Addresses = append(c.Addresses, addr)
+	}
+	return &c
+}
+
+// PoolAssignmentRepository stores IP pool assignments in memory
+type PoolAssignmentRepository struct {
+	store *Store
+}
+
+// NewPoolAssignmentRepository creates an assignment repository on the given store
+func NewPoolAssignmentRepository(store *Store) *PoolAssignmentRepository {
+	return &PoolAssignmentRepository{store: store}
+}
+
+// Upsert creates or replaces the assignment of a scope and key to an
+// existing pool
+func (r *PoolAssignmentRepository) Upsert(ctx context.Context, assignment *domain.PoolAssignment) error {
+	s := r.store
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.ipPools[assignment.PoolID]; !ok {
+		return conflict("IP pool %s does not exist", assignment.PoolID)
+	}
+	c := *assignment
+	s.assignments[poolAssignmentKey{assignment.Scope, assignment.Key}] = &c
+	return nil
+}
+
+// Get returns the assignment of a scope and key, or nil
+func (r *PoolAssignmentRepository) Get(ctx context.Context, scope domain.PoolScope, key string) (*domain.PoolAssignment, error) {
+	s := r.store
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if assignment, ok := s.assignments[poolAssignmentKey{scope, key}]; ok {
+		c := *assignment
+		return &c, nil
+	}
+	return nil, nil
+}
+
+// Delete removes the assignment of a scope and key
+func (r *PoolAssignmentRepository) Delete(ctx context.Context, scope domain.PoolScope, key string) error {
+	s := r.store
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.assignments, poolAssignmentKey{scope, key})
+	return nil
+}
+
+// DeleteByPool removes every assignment to a pool
+func (r *PoolAssignmentRepository) DeleteByPool(ctx context.Context, poolID string) error {
+	s := r.store
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for key, assignment := range s.assignments {
+		if assignment.PoolID == poolID {
+			delete(s.assignments, key)
+		}
+	}
+	return nil
+}
+
+// ListByPool lists the assignments to a pool
+func (r *PoolAssignmentRepository) ListByPool(ctx context.Context, poolID string) ([]*domain.PoolAssignment, error) {
+	s := r.store
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	assignments := []*domain.PoolAssignment{}
+	for _, assignment := range s.assignments {
+		if assignment.PoolID == poolID {
+			c := *assignment
+			assignments = append(assignments, &c)
+		}
+	}
+	sort.Slice(assignments, func(i, j int) bool {
+		if assignments[i].Scope != assignments[j].Scope {
+			return assignments[i].Scope < assignments[j].Scope
+		}
+		return assignments[i].Key < assignments[j].Key
+	})
+	return assignments, nil
+}
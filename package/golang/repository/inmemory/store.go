@@ -0,0 +1,226 @@
+// Package inmemory implements the SDK repositories, event publisher and
+// event store in memory. It is meant for tests and tools embedding the
+// services without a database, and mirrors the Postgres implementation:
+// the same ordering, filtering and pagination, the same unique
+// constraints, and the same cascading deletes.
+//
+// Repositories are created on a Store, which plays the part of the
+// database. Values are copied on the way in and out, so callers never
+// share memory with the store.
+package inmemory
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/skygenesisenterprise/aether-mailer/package/golang/domain"
+)
+
+// ErrConflict is returned when a write would violate a unique constraint
+// or reference a missing row
+var ErrConflict = errors.New("inmemory: constraint violation")
+
+// Store holds the data of every repository created on it
+type Store struct {
+	mu sync.RWMutex
+
+	users             map[string]*domain.User
+	domains           map[string]*domain.Domain
+	domainMembers     map[string]*domain.DomainMember
+	emailAccounts     map[string]*domain.EmailAccount
+	emailAliases      map[string]*domain.EmailAlias
+	dnsRecords        map[string]*domain.DNSRecord
+	folders           map[string]*domain.Folder
+	messages          map[string]*domain.Message
+	attachments       map[string]*domain.Attachment
+	quotas            []*domain.Quota
+	policies          map[string]*domain.Policy
+	spamTokens        map[spamTokenKey]*domain.SpamToken
+	spamTotals        map[string]*domain.SpamTotals
+	spamClasses       map[spamTokenKey]domain.SpamClass
+	quarantine        map[string]*domain.QuarantineEntry
+	rateCounters      map[rateBucketKey]int64
+	suspensions       map[string]*domain.SendingSuspension
+	destinations      map[string]*domain.DestinationPolicy
+	deliveryJobs      map[string]*deliveryJob
+	ipPools           map[string]*domain.IPPool
+	assignments       map[poolAssignmentKey]*domain.PoolAssignment
+	mtaSTSPolicies    map[string]*domain.MTASTSPolicy
+	tlsResults        map[tlsResultKey]int64
+	dkimKeys          map[string]*domain.DKIMKey
+	dkimLog           []*domain.DKIMRotationEntry
+	blobs             map[string]*domain.Blob
+	outbox            map[string]*domain.OutboxEntry
+	threads           map[string]*domain.Thread
+	threadLinks       map[threadLinkKey]string // Message-ID to thread ID
+	threadSettings    map[string]*domain.ThreadSettings
+	scheduledSends    map[string]*domain.ScheduledSend
+	identities        map[string]*domain.Identity
+	senderDelegations map[string]*domain.SenderDelegation
+	groups            map[string]*domain.Group
+	groupMembers      map[groupMemberKey]*domain.GroupMember
+	folderACLs        map[string]*domain.FolderACL
+	aclAudit          []*domain.ACLAuditEntry
+}
+
+type spamTokenKey struct {
+	accountID string
+	token     string
+}
+
+type groupMemberKey struct {
+	groupID string
+	userID  string
+}
+
+type threadLinkKey struct {
+	accountID string
+	messageID string
+}
+
+type rateBucketKey struct {
+	key    string
+	bucket time.Time
+}
+
+type poolAssignmentKey struct {
+	scope domain.PoolScope
+	key   string
+}
+
+type tlsResultKey struct {
+	day          time.Time
+	domain       string
+	policyType   domain.TLSPolicyType
+	policyString string
+	mxHost       string
+	resultType   domain.TLSResultType
+}
+
+// NewStore creates an empty store
+func NewStore() *Store {
+	return &Store{
+		users:             make(map[string]*domain.User),
+		domains:           make(map[string]*domain.Domain),
+		domainMembers:     make(map[string]*domain.DomainMember),
+		emailAccounts:     make(map[string]*domain.EmailAccount),
+		emailAliases:      make(map[string]*domain.EmailAlias),
+		dnsRecords:        make(map[string]*domain.DNSRecord),
+		folders:           make(map[string]*domain.Folder),
+		messages:          make(map[string]*domain.Message),
+		attachments:       make(map[string]*domain.Attachment),
+		policies:          make(map[string]*domain.Policy),
+		spamTokens:        make(map[spamTokenKey]*domain.SpamToken),
+		spamTotals:        make(map[string]*domain.SpamTotals),
+		spamClasses:       make(map[spamTokenKey]domain.SpamClass),
+		quarantine:        make(map[string]*domain.QuarantineEntry),
+		rateCounters:      make(map[rateBucketKey]int64),
+		suspensions:       make(map[string]*domain.SendingSuspension),
+		destinations:      make(map[string]*domain.DestinationPolicy),
+		deliveryJobs:      make(map[string]*deliveryJob),
+		ipPools:           make(map[string]*domain.IPPool),
+		assignments:       make(map[poolAssignmentKey]*domain.PoolAssignment),
+		mtaSTSPolicies:    make(map[string]*domain.MTASTSPolicy),
+		tlsResults:        make(map[tlsResultKey]int64),
+		dkimKeys:          make(map[string]*domain.DKIMKey),
+		blobs:             make(map[string]*domain.Blob),
+		outbox:            make(map[string]*domain.OutboxEntry),
+		threads:           make(map[string]*domain.Thread),
+		threadLinks:       make(map[threadLinkKey]string),
+		threadSettings:    make(map[string]*domain.ThreadSettings),
+		scheduledSends:    make(map[string]*domain.ScheduledSend),
+		identities:        make(map[string]*domain.Identity),
+		senderDelegations: make(map[string]*domain.SenderDelegation),
+		groups:            make(map[string]*domain.Group),
+		groupMembers:      make(map[groupMemberKey]*domain.GroupMember),
+		folderACLs:        make(map[string]*domain.FolderACL),
+	}
+}
+
+// Helper functions
+
+func conflict(format string, args ...interface{}) error {
+	return fmt.Errorf("%w: %s", ErrConflict, fmt.Sprintf(format, args...))
+}
+
+// page applies a limit and offset the way LIMIT and OFFSET do; a limit of
+// zero or less returns everything after the offset
+func page[T any](items []T, limit, offset int) []T {
+	if offset > 0 {
+		if offset >= len(items) {
+			return items[:0]
+		}
+		items = items[offset:]
+	}
+	if limit > 0 && limit < len(items) {
+		items = items[:limit]
+	}
+	return items
+}
+
+func copyStrings(s []string) []string {
+	if s == nil {
+		return nil
+	}
+	return append([]string{}, s...)
+}
+
+func containsString(s []string, value string) bool {
+	for _, item := range s {
+		if item == value {
+			return true
+		}
+	}
+	return false
+}
+
+func copyBytes(b []byte) []byte {
+	if b == nil {
+		return nil
+	}
+	return append([]byte{}, b...)
+}
+
+// compareOptional orders nil after every value, as Postgres orders NULL
+// last in ascending order
+func compareOptional(a, b *string) int {
+	switch {
+	case a == nil && b == nil:
+		return 0
+	case a == nil:
+		return 1
+	case b == nil:
+		return -1
+	case *a < *b:
+		return -1
+	case *a > *b:
+		return 1
+	}
+	return 0
+}
+
+func copyString(s *string) *string {
+	if s == nil {
+		return nil
+	}
+	v := *s
+	return &v
+}
+
+func copyInt(i *int) *int {
+	if i == nil {
+		return nil
+	}
+	v := *i
+	return &v
+}
+
+func copyTime(t *time.Time) *time.Time {
+	if t == nil {
+		return nil
+	}
+	v := *t
+	return &v
+}
@@ -0,0 +1,253 @@
+package inmemory
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"github.com/skygenesisenterprise/aether-mailer/package/golang/domain"
+	"github.com/skygenesisenterprise/aether-mailer/package/golang/repository"
+)
+
+// ThreadRepository stores conversation threads, the Message-IDs that lead
+// to them and the thread settings of accounts in memory
+type ThreadRepository struct {
+	store *Store
+}
+
+// NewThreadRepository creates a thread repository on the given store
+func NewThreadRepository(store *Store) *ThreadRepository {
+	return &ThreadRepository{store: store}
+}
+
+// Create inserts a thread of an existing account
+func (r *ThreadRepository) Create(ctx context.Context, thread *domain.Thread) error {
+	s := r.store
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.threads[thread.ID]; ok {
+		return conflict("thread %s already exists", thread.ID)
+	}
+	if _, ok := s.emailAccounts[thread.AccountID]; !ok {
+		return conflict("email account %s does not exist", thread.AccountID)
+	}
+	s.threads[thread.ID] = copyThread(thread)
+	return nil
+}
+
+// GetByID returns a thread, or nil when it does not exist
+func (r *ThreadRepository) GetByID(ctx context.Context, id string) (*domain.Thread, error) {
+	s := r.store
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if thread, ok := s.threads[id]; ok {
+		return copyThread(thread), nil
+	}
+	return nil, nil
+}
+
+// Update saves a thread; it stays in its account
+func (r *ThreadRepository) Update(ctx context.Context, thread *domain.Thread) error {
+	s := r.store
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	existing, ok := s.threads[thread.ID]
+	if !ok {
+		return nil
+	}
+	updated := copyThread(thread)
+	updated.AccountID = existing.AccountID
+	updated.CreatedAt = existing.CreatedAt
+	s.threads[thread.ID] = updated
+	return nil
+}
+
+// Delete removes a thread with its Message-IDs
+func (r *ThreadRepository) Delete(ctx context.Context, id string) error {
+	s := r.store
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.deleteThreadLocked(id)
+	return nil
+}
+
+// ListByAccount returns the threads of an account matching a filter,
+// latest message first
+func (r *ThreadRepository) ListByAccount(ctx context.Context, accountID string, filter repository.ThreadFilter) ([]*domain.Thread, error) {
+	s := r.store
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	threads := []*domain.Thread{}
+	for _, thread := range s.threads {
+		if thread.AccountID == accountID && threadMatches(thread, filter) {
+			threads = append(threads, copyThread(thread))
+		}
+	}
+	sortThreads(threads)
+	return page(threads, filter.Limit, filter.Offset), nil
+}
+
+// CountByAccount returns the number of threads of an account matching a filter
+func (r *ThreadRepository) CountByAccount(ctx context.Context, accountID string, filter repository.ThreadFilter) (int, error) {
+	s := r.store
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	count := 0
+	for _, thread := range s.threads {
+		if thread.AccountID == accountID && threadMatches(thread, filter) {
+			count++
+		}
+	}
+	return count, nil
+}
+
+// FindByMessageIDs returns the threads any of the Message-IDs lead to,
+// oldest first
+func (r *ThreadRepository) FindByMessageIDs(ctx context.Context, accountID string, messageIDs []string) ([]*domain.Thread, error) {
+	s := r.store
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	threads := []*domain.Thread{}
+	seen := make(map[string]bool)
+	for _, messageID := range messageIDs {
+		threadID, ok := s.threadLinks[threadLinkKey{accountID, messageID}]
+		if !ok || seen[threadID] {
+			continue
+		}
+		seen[threadID] = true
+		if thread, ok := s.threads[threadID]; ok {
+			threads = append(threads, copyThread(thread))
+		}
+	}
+	sort.Slice(threads, func(i, j int) bool {
+		if !threads[i].CreatedAt.Equal(threads[j].CreatedAt) {
+			return threads[i].CreatedAt.Before(threads[j].CreatedAt)
+		}
+		return threads[i].ID < threads[j].ID
+	})
+	return threads, nil
+}
+
+// FindBySubject returns the thread with a base subject that had the latest
+// message at or after since, or nil
+func (r *ThreadRepository) FindBySubject(ctx context.Context, accountID, baseSubject string, since time.Time) (*domain.Thread, error) {
+	s := r.store
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	threads := []*domain.Thread{}
+	for _, thread := range s.threads {
+		if thread.AccountID == accountID && thread.BaseSubject == baseSubject && !thread.LastMessageAt.Before(since) {
+			threads = append(threads, thread)
+		}
+	}
+	if len(threads) == 0 {
+		return nil, nil
+	}
+	sortThreads(threads)
+	return copyThread(threads[0]), nil
+}
+
+// LinkMessageIDs makes Message-IDs lead to a thread
+func (r *ThreadRepository) LinkMessageIDs(ctx context.Context, accountID, threadID string, messageIDs []string) error {
+	s := r.store
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.threads[threadID]; !ok {
+		return conflict("thread %s does not exist", threadID)
+	}
+	for _, messageID := range messageIDs {
+		s.threadLinks[threadLinkKey{accountID, messageID}] = threadID
+	}
+	return nil
+}
+
+// Merge moves the Message-IDs of a thread to another and deletes it
+func (r *ThreadRepository) Merge(ctx context.Context, fromID, intoID string) error {
+	s := r.store
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if fromID == intoID {
+		return nil
+	}
+	if _, ok := s.threads[intoID]; !ok {
+		return conflict("thread %s does not exist", intoID)
+	}
+	for key, threadID := range s.threadLinks {
+		if threadID == fromID {
+			s.threadLinks[key] = intoID
+		}
+	}
+	delete(s.threads, fromID)
+	return nil
+}
+
+// GetSettings returns the thread settings of an account, or nil when it
+// has none
+func (r *ThreadRepository) GetSettings(ctx context.Context, accountID string) (*domain.ThreadSettings, error) {
+	s := r.store
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if settings, ok := s.threadSettings[accountID]; ok {
+		c := *settings
+		return &c, nil
+	}
+	return nil, nil
+}
+
+// SaveSettings creates or replaces the thread settings of an existing account
+func (r *ThreadRepository) SaveSettings(ctx context.Context, settings *domain.ThreadSettings) error {
+	s := r.store
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.emailAccounts[settings.AccountID]; !ok {
+		return conflict("email account %s does not exist", settings.AccountID)
+	}
+	c := *settings
+	s.threadSettings[settings.AccountID] = &c
+	return nil
+}
+
+func (s *Store) deleteThreadLocked(id string) {
+	delete(s.threads, id)
+	for key, threadID := range s.threadLinks {
+		if threadID == id {
+			delete(s.threadLinks, key)
+		}
+	}
+}
+
+func threadMatches(thread *domain.Thread, filter repository.ThreadFilter) bool {
+	return filter.IsUnread == nil || (thread.UnreadCount > 0) == *filter.IsUnread
+}
+
+// sortThreads orders threads by their latest message, newest first
+func sortThreads(threads []*domain.Thread) {
+	sort.Slice(threads, func(i, j int) bool {
+		if !threads[i].LastMessageAt.Equal(threads[j].LastMessageAt) {
+			return threads[i].LastMessageAt.After(threads[j].LastMessageAt)
+		}
+		return threads[i].ID < threads[j].ID
+	})
+}
+
+func copyThread(thread *domain.Thread) *domain.Thread {
+	c := *thread
+	c.Participants = copyStrings(thread.Participants)
+	// Postgres returns empty arrays rather than NULL
+	if c.Participants == nil {
+		c.Participants = []string{}
+	}
+	return &c
+}
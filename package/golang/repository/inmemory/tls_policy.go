@@ -0,0 +1,176 @@
+package inmemory
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/skygenesisenterprise/aether-mailer/package/golang/domain"
+)
+
+// MTASTSPolicyRepository stores the MTA-STS policy cache in memory
+type MTASTSPolicyRepository struct {
+	store *Store
+}
+
+// NewMTASTSPolicyRepository creates an MTA-STS policy repository on the given store
+func NewMTASTSPolicyRepository(store *Store) *MTASTSPolicyRepository {
+	return &MTASTSPolicyRepository{store: store}
+}
+
+// Get returns the cached policy of a domain, or nil when none is cached
+func (r *MTASTSPolicyRepository) Get(ctx context.Context, domainName string) (*domain.MTASTSPolicy, error) {
+	s := r.store
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if policy, ok := s.mtaSTSPolicies[domainName]; ok {
+		return copyMTASTSPolicy(policy), nil
+	}
+	return nil, nil
+}
+
+// Save inserts or replaces the cached policy of a domain. The maximum age
+// is kept to the second, as in Postgres.
+func (r *MTASTSPolicyRepository) Save(ctx context.Context, policy *domain.MTASTSPolicy) error {
+	s := r.store
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	c := copyMTASTSPolicy(policy)
+	c.MaxAge = policy.MaxAge.Truncate(time.Second)
+	s.mtaSTSPolicies[policy.Domain] = c
+	return nil
+}
+
+// DeleteExpired removes policies that expired before the given time
+func (r *MTASTSPolicyRepository) DeleteExpired(ctx context.Context, before time.Time) (int, error) {
+	s := r.store
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	count := 0
+	for name, policy := range s.mtaSTSPolicies {
+		if policy.ExpiresAt.Before(before) {
+			delete(s.mtaSTSPolicies, name)
+			count++
+		}
+	}
+	return count, nil
+}
+
+func copyMTASTSPolicy(policy *domain.MTASTSPolicy) *domain.MTASTSPolicy {
+	c := *policy
+	c.MX = copyStrings(policy.MX)
+	if c.MX == nil {
+		c.MX = []string{}
+	}
+	return &c
+}
+
+// TLSResultRepository aggregates TLS-RPT session results in memory, one
+// counter per UTC day, domain, policy, MX host and result type
+type TLSResultRepository struct {
+	store *Store
+}
+
+// NewTLSResultRepository creates a TLS result repository on the given store
+func NewTLSResultRepository(store *Store) *TLSResultRepository {
+	return &TLSResultRepository{store: store}
+}
+
+// Record counts one session result
+func (r *TLSResultRepository) Record(ctx context.Context, result *domain.TLSResult) error {
+	s := r.store
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.tlsResults[tlsResultKey{
+		day:          result.At.UTC().Truncate(24 * time.Hour),
+		domain:       result.Domain,
+		policyType:   result.PolicyType,
+		policyString: strings.Join(result.PolicyStrings, "\n"),
+		mxHost:       result.MXHost,
+		resultType:   result.ResultType,
+	}]++
+	return nil
+}
+
+// Summarize returns the counters of a domain for the days overlapping
+// [from, to)
+func (r *TLSResultRepository) Summarize(ctx context.Context, domainName string, from, to time.Time) ([]*domain.TLSResultSummary, error) {
+	s := r.store
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	type group struct {
+		policyType   domain.TLSPolicyType
+		policyString string
+		mxHost       string
+		resultType   domain.TLSResultType
+	}
+	counts := make(map[group]int64)
+	for key, count := range s.tlsResults {
+		if key.domain == domainName && inDays(key.day, from, to) {
+			counts[group{key.policyType, key.policyString, key.mxHost, key.resultType}] += count
+		}
+	}
+
+	groups := make([]group, 0, len(counts))
+	for g := range counts {
+		groups = append(groups, g)
+	}
+	sort.Slice(groups, func(i, j int) bool {
+		a, b := groups[i], groups[j]
+		if a.policyType != b.policyType {
+			return a.policyType < b.policyType
+		}
+		if a.policyString != b.policyString {
+			return a.policyString < b.policyString
+		}
+		if a.resultType != b.resultType {
+			return a.resultType < b.resultType
+		}
+		return a.mxHost < b.mxHost
+	})
+
+	summaries := []*domain.TLSResultSummary{}
+	for _, g := range groups {
+		summary := &domain.TLSResultSummary{
+			Domain:     domainName,
+			PolicyType: g.policyType,
+			MXHost:     g.mxHost,
+			ResultType: g.resultType,
+			Count:      counts[g],
+		}
+		if g.policyString != "" {
+			summary.PolicyStrings = strings.Split(g.policyString, "\n")
+		}
+		summaries = append(summaries, summary)
+	}
+	return summaries, nil
+}
+
+// ListDomains returns the domains with results for the days overlapping
+// [from, to)
+func (r *TLSResultRepository) ListDomains(ctx context.Context, from, to time.Time) ([]string, error) {
+	s := r.store
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	seen := make(map[string]bool)
+	domains := []string{}
+	for key := range s.tlsResults {
+		if !seen[key.domain] && inDays(key.day, from, to) {
+			seen[key.domain] = true
+			domains = append(domains, key.domain)
+		}
+	}
+	sort.Strings(domains)
+	return domains, nil
+}
+
+func inDays(day, from, to time.Time) bool {
+	return !day.Before(from.UTC().Truncate(24*time.Hour)) && day.Before(to.UTC())
+}
@@ -77,6 +77,7 @@ type EmailAccountFilter struct {
 	DomainID   *string
 	IsActive   *bool
 	IsVerified *bool
+	IsShared   *bool
 	Limit      int
 	Offset     int
 }
@@ -94,29 +95,218 @@ type MessageRepository interface {
 
 // MessageFilter defines filtering options for message queries
 type MessageFilter struct {
-	IsRead    *bool
-	IsDraft   *bool
-	IsSent    *bool
-	IsDeleted *bool
-	From      *string
-	To        *string
-	Subject   *string
-	DateFrom  *time.Time
-	DateTo    *time.Time
-	Limit     int
-	Offset    int
+	FolderID       *string
+	ThreadID       *string
+	IsRead         *bool
+	IsDraft        *bool
+	IsSent         *bool
+	IsDeleted      *bool
+	IsStarred      *bool
+	IsFlagged      *bool
+	HasAttachments *bool
+	Labels         []string // messages must carry every label
+	From           *string
+	To             *string
+	Subject        *string
+	DateFrom       *time.Time
+	DateTo         *time.Time
+	Limit          int
+	Offset         int
 }
 
 // MessageSearchQuery defines search parameters for messages
 type MessageSearchQuery struct {
 	AccountID string
-	Query     string
+	Query     string // syntax of domain.ParseSearchQuery
 	DateFrom  *time.Time
 	DateTo    *time.Time
 	Limit     int
 	Offset    int
 }
 
+// SearchIndex defines the contract for the full-text index of messages.
+// Only the text of a message is indexed: operators such as is:unread are
+// checked against the message itself, and hits for deleted messages are
+// never returned.
+type SearchIndex interface {
+	Index(ctx context.Context, document *domain.SearchDocument) error
+	Remove(ctx context.Context, messageID string) error
+	RemoveAccount(ctx context.Context, accountID string) error
+	Search(ctx context.Context, query SearchIndexQuery) ([]SearchHit, int, error)
+}
+
+// SearchIndexQuery defines a ranked search of the index
+type SearchIndexQuery struct {
+	AccountID string
+	Query     *domain.SearchQuery
+	FolderID  *string
+	Limit     int
+	Offset    int
+}
+
+// SearchHit is a message found by the index with its ranking score,
+// relevance decayed by age
+type SearchHit struct {
+	MessageID string
+	Score     float64
+}
+
+// ThreadRepository defines the contract for conversation threads. Messages
+// record their thread in Message.ThreadID; a thread keeps the aggregates of
+// its messages and the Message-IDs that lead to it, both those of its
+// messages and those they refer to.
+type ThreadRepository interface {
+	Create(ctx context.Context, thread *domain.Thread) error
+	GetByID(ctx context.Context, id string) (*domain.Thread, error)
+	Update(ctx context.Context, thread *domain.Thread) error
+	Delete(ctx context.Context, id string) error
+	ListByAccount(ctx context.Context, accountID string, filter ThreadFilter) ([]*domain.Thread, error)
+	CountByAccount(ctx context.Context, accountID string, filter ThreadFilter) (int, error)
+	// FindByMessageIDs returns the threads any of the Message-IDs lead to,
+	// oldest first
+	FindByMessageIDs(ctx context.Context, accountID string, messageIDs []string) ([]*domain.Thread, error)
+	// FindBySubject returns the thread with a base subject that had the
+	// latest message at or after since, or nil
+	FindBySubject(ctx context.Context, accountID, baseSubject string, since time.Time) (*domain.Thread, error)
+	// LinkMessageIDs makes Message-IDs lead to a thread, replacing the
+	// threads they led to
+	LinkMessageIDs(ctx context.Context, accountID, threadID string, messageIDs []string) error
+	// Merge moves the Message-IDs of a thread to another and deletes it
+	Merge(ctx context.Context, fromID, intoID string) error
+	GetSettings(ctx context.Context, accountID string) (*domain.ThreadSettings, error)
+	SaveSettings(ctx context.Context, settings *domain.ThreadSettings) error
+}
+
+// ThreadFilter defines filtering options for thread queries, which list
+// threads by their latest message, newest first
+type ThreadFilter struct {
+	IsUnread *bool // threads with unread messages
+	Limit    int
+	Offset   int
+}
+
+// ScheduledSendRepository defines the contract for drafts held until they
+// are due to be sent. Claim leases due sends to one dispatcher by setting
+// LockedUntil to now+lease and counting the attempt; Lock leases a pending
+// send for an edit. Both skip sends leased by someone else, so that a send
+// is dispatched once and never while it is edited or cancelled. MarkFailed
+// and Complete take the claimed send and change it only while that claim
+// still holds, so a dispatcher whose lease expired cannot finish a send
+// another dispatcher has claimed since.
+type ScheduledSendRepository interface {
+	Create(ctx context.Context, send *domain.ScheduledSend) error
+	GetByID(ctx context.Context, id string) (*domain.ScheduledSend, error)
+	GetPendingByDraftID(ctx context.Context, draftID string) (*domain.ScheduledSend, error)
+	List(ctx context.Context, filter ScheduledSendFilter) ([]*domain.ScheduledSend, error)
+	Count(ctx context.Context, filter ScheduledSendFilter) (int, error)
+	Claim(ctx context.Context, now time.Time, limit int, lease time.Duration) ([]*domain.ScheduledSend, error)
+	Lock(ctx context.Context, id string, now time.Time, lease time.Duration) (bool, error)
+	// Reschedule sets when a pending send is due and releases its lease
+	Reschedule(ctx context.Context, id string, sendAt, at time.Time) error
+	// MarkFailed records a failed attempt of a claimed send, releases its
+	// lease and sets when to try again. It reports false when the claim no
+	// longer holds.
+	MarkFailed(ctx context.Context, claim *domain.ScheduledSend, lastError string, nextAttemptAt time.Time) (bool, error)
+	// Complete ends a claimed send as sent, cancelled or failed. It reports
+	// false when the claim no longer holds.
+	Complete(ctx context.Context, claim *domain.ScheduledSend, status domain.ScheduledSendStatus, sentMessageID *string, lastError string, at time.Time) (bool, error)
+	// Cancel cancels a pending send that is not leased and reports false
+	// otherwise
+	Cancel(ctx context.Context, id string, now time.Time) (bool, error)
+	DeleteCompleted(ctx context.Context, before time.Time) (int, error)
+}
+
+// ScheduledSendFilter defines filtering options for scheduled send
+// queries, which list sends by when they are due, soonest first
+type ScheduledSendFilter struct {
+	AccountID string
+	Status    *domain.ScheduledSendStatus
+	Limit     int
+	Offset    int
+}
+
+// IdentityRepository defines the contract for sender identity data access
+type IdentityRepository interface {
+	Create(ctx context.Context, identity *domain.Identity) error
+	GetByID(ctx context.Context, id string) (*domain.Identity, error)
+	GetByEmail(ctx context.Context, accountID, email string) (*domain.Identity, error)
+	// Update saves everything but the account, address and default flag
+	Update(ctx context.Context, identity *domain.Identity) error
+	// SetDefault makes an identity the default of its account, or clears
+	// the default when id is empty
+	SetDefault(ctx context.Context, accountID, id string) error
+	Delete(ctx context.Context, id string) error
+	List(ctx context.Context, filter IdentityFilter) ([]*domain.Identity, error)
+	Count(ctx context.Context, filter IdentityFilter) (int, error)
+}
+
+// IdentityFilter defines filtering options for identity queries
+type IdentityFilter struct {
+	AccountID string
+	DomainID  string
+	Status    *domain.IdentityStatus
+	Limit     int
+	Offset    int
+}
+
+// SenderDelegationRepository defines the contract for send-as and
+// send-on-behalf delegation data access
+type SenderDelegationRepository interface {
+	Create(ctx context.Context, delegation *domain.SenderDelegation) error
+	GetByID(ctx context.Context, id string) (*domain.SenderDelegation, error)
+	Get(ctx context.Context, accountID, delegateAccountID string) (*domain.SenderDelegation, error)
+	Update(ctx context.Context, delegation *domain.SenderDelegation) error
+	Delete(ctx context.Context, id string) error
+	ListByAccount(ctx context.Context, accountID string) ([]*domain.SenderDelegation, error)
+	ListByDelegate(ctx context.Context, delegateAccountID string) ([]*domain.SenderDelegation, error)
+}
+
+// GroupRepository defines the contract for the user groups of domains and
+// their members
+type GroupRepository interface {
+	Create(ctx context.Context, group *domain.Group) error
+	GetByID(ctx context.Context, id string) (*domain.Group, error)
+	GetByName(ctx context.Context, domainID, name string) (*domain.Group, error)
+	Delete(ctx context.Context, id string) error
+	ListByDomain(ctx context.Context, domainID string) ([]*domain.Group, error)
+	// ListByUser returns the groups a user is a member of
+	ListByUser(ctx context.Context, userID string) ([]*domain.Group, error)
+	AddMember(ctx context.Context, member *domain.GroupMember) error
+	RemoveMember(ctx context.Context, groupID, userID string) error
+	ListMembers(ctx context.Context, groupID string) ([]*domain.GroupMember, error)
+}
+
+// FolderACLRepository defines the contract for the access control lists of
+// folders. A folder has at most one entry per subject.
+type FolderACLRepository interface {
+	Create(ctx context.Context, acl *domain.FolderACL) error
+	Get(ctx context.Context, folderID string, subjectType domain.ACLSubjectType, subjectID string) (*domain.FolderACL, error)
+	Update(ctx context.Context, acl *domain.FolderACL) error
+	Delete(ctx context.Context, id string) error
+	ListByFolder(ctx context.Context, folderID string) ([]*domain.FolderACL, error)
+	ListByAccount(ctx context.Context, accountID string) ([]*domain.FolderACL, error)
+	// ListBySubjects returns the entries granting rights to a user, to any
+	// of the given groups or to anyone
+	ListBySubjects(ctx context.Context, userID string, groupIDs []string) ([]*domain.FolderACL, error)
+}
+
+// ACLAuditLogRepository defines the contract for the access control audit
+// log. Entries are append-only.
+type ACLAuditLogRepository interface {
+	Record(ctx context.Context, entry *domain.ACLAuditEntry) error
+	List(ctx context.Context, filter ACLAuditFilter) ([]*domain.ACLAuditEntry, error)
+	Count(ctx context.Context, filter ACLAuditFilter) (int, error)
+}
+
+// ACLAuditFilter defines filtering options for audit log queries, which
+// return the newest entries first
+type ACLAuditFilter struct {
+	DomainID  string
+	AccountID string
+	Limit     int
+	Offset    int
+}
+
 // AttachmentRepository defines the contract for attachment data access
 type AttachmentRepository interface {
 	Create(ctx context.Context, attachment *domain.Attachment) error
@@ -172,3 +362,184 @@ type EmailAliasRepository interface {
 	Update(ctx context.Context, alias *domain.EmailAlias) error
 	Delete(ctx context.Context, id string) error
 }
+
+// FolderRepository defines the contract for mailbox folder data access
+type FolderRepository interface {
+	Create(ctx context.Context, folder *domain.Folder) error
+	GetByID(ctx context.Context, id string) (*domain.Folder, error)
+	GetByType(ctx context.Context, accountID string, folderType domain.FolderType) (*domain.Folder, error)
+	ListByAccount(ctx context.Context, accountID string) ([]*domain.Folder, error)
+	Update(ctx context.Context, folder *domain.Folder) error
+	Delete(ctx context.Context, id string) error
+}
+
+// SpamRepository defines the contract for per-account spam training data access
+type SpamRepository interface {
+	GetTokens(ctx context.Context, accountID string, tokens []string) (map[string]*domain.SpamToken, error)
+	IncrementTokens(ctx context.Context, accountID string, tokens []string, spamDelta, hamDelta int) error
+	GetTotals(ctx context.Context, accountID string) (*domain.SpamTotals, error)
+	IncrementTotals(ctx context.Context, accountID string, spamDelta, hamDelta int) error
+	GetMessageClass(ctx context.Context, accountID, messageID string) (*domain.SpamClass, error)
+	SetMessageClass(ctx context.Context, accountID, messageID string, class *domain.SpamClass) error
+}
+
+// QuarantineRepository defines the contract for quarantine data access
+type QuarantineRepository interface {
+	Create(ctx context.Context, entry *domain.QuarantineEntry) error
+	GetByID(ctx context.Context, id string) (*domain.QuarantineEntry, error)
+	Update(ctx context.Context, entry *domain.QuarantineEntry) error
+	// Release marks a held entry released and reports false when it is no
+	// longer held, so that concurrent releases deliver the message once
+	Release(ctx context.Context, id, releasedBy string, at time.Time) (bool, error)
+	Delete(ctx context.Context, id string) error
+	List(ctx context.Context, filter QuarantineFilter) ([]*domain.QuarantineEntry, error)
+	Count(ctx context.Context, filter QuarantineFilter) (int, error)
+	DeleteExpired(ctx context.Context, before time.Time) (int, error)
+}
+
+// QuarantineFilter defines filtering options for quarantine queries
+type QuarantineFilter struct {
+	AccountIDs    []string
+	DomainID      *string
+	Reason        *domain.QuarantineReason
+	Status        *domain.QuarantineStatus
+	CreatedAfter  *time.Time
+	DigestPending *bool
+	Limit         int
+	Offset        int
+}
+
+// RateCounterStore defines the contract for sliding-window counters shared
+// by every instance
+type RateCounterStore interface {
+	// Add records amount at the given time and returns the window total including it
+	Add(ctx context.Context, key string, amount int64, at time.Time, window time.Duration) (int64, error)
+	Sum(ctx context.Context, key string, at time.Time, window time.Duration) (int64, error)
+	Prune(ctx context.Context, before time.Time) error
+}
+
+// SendingSuspensionRepository defines the contract for sending suspension data access
+type SendingSuspensionRepository interface {
+	Create(ctx context.Context, suspension *domain.SendingSuspension) error
+	GetByID(ctx context.Context, id string) (*domain.SendingSuspension, error)
+	GetActive(ctx context.Context, scope domain.RateScope, key string, at time.Time) (*domain.SendingSuspension, error)
+	Update(ctx context.Context, suspension *domain.SendingSuspension) error
+	ListActive(ctx context.Context, at time.Time) ([]*domain.SendingSuspension, error)
+}
+
+// DestinationPolicyRepository defines the contract for destination policy data access
+type DestinationPolicyRepository interface {
+	Create(ctx context.Context, policy *domain.DestinationPolicy) error
+	GetByID(ctx context.Context, id string) (*domain.DestinationPolicy, error)
+	Update(ctx context.Context, policy *domain.DestinationPolicy) error
+	Delete(ctx context.Context, id string) error
+	List(ctx context.Context) ([]*domain.DestinationPolicy, error)
+}
+
+// DeliveryJobRepository defines the contract for the durable outbound queue.
+// Claimed jobs are leased to the delivery instance named by owner; updates
+// by an instance whose lease was taken over change nothing and report
+// false.
+type DeliveryJobRepository interface {
+	// Create queues a job and reports false when the message already has a
+	// job for the same recipient domain
+	Create(ctx context.Context, job *domain.DeliveryJob) (bool, error)
+	// Claim leases up to limit due jobs that are not leased, soonest first
+	Claim(ctx context.Context, owner string, now time.Time, limit int, lease time.Duration) ([]*domain.DeliveryJob, error)
+	// Extend renews the leases owner still holds on the given jobs
+	Extend(ctx context.Context, ids []string, owner string, now time.Time, lease time.Duration) error
+	// Reschedule stores the recipients still to deliver, the attempts, the
+	// last error and the next attempt of a job leased by owner, and
+	// releases its lease
+	Reschedule(ctx context.Context, job *domain.DeliveryJob, owner string) (bool, error)
+	// Complete removes a job leased by owner once every recipient has a
+	// final outcome
+	Complete(ctx context.Context, id, owner string) (bool, error)
+	Count(ctx context.Context) (int, error)
+}
+
+// IPPoolRepository defines the contract for IP pool data access. Pools are
+// stored and returned with their addresses.
+type IPPoolRepository interface {
+	Create(ctx context.Context, pool *domain.IPPool) error
+	GetByID(ctx context.Context, id string) (*domain.IPPool, error)
+	GetByName(ctx context.Context, name string) (*domain.IPPool, error)
+	GetDefault(ctx context.Context) (*domain.IPPool, error)
+	Update(ctx context.Context, pool *domain.IPPool) error
+	Delete(ctx context.Context, id string) error
+	List(ctx context.Context) ([]*domain.IPPool, error)
+}
+
+// PoolAssignmentRepository defines the contract for IP pool assignment data access
+type PoolAssignmentRepository interface {
+	Upsert(ctx context.Context, assignment *domain.PoolAssignment) error
+	Get(ctx context.Context, scope domain.PoolScope, key string) (*domain.PoolAssignment, error)
+	Delete(ctx context.Context, scope domain.PoolScope, key string) error
+	DeleteByPool(ctx context.Context, poolID string) error
+	ListByPool(ctx context.Context, poolID string) ([]*domain.PoolAssignment, error)
+}
+
+// MTASTSPolicyRepository defines the contract for the persistent MTA-STS
+// policy cache
+type MTASTSPolicyRepository interface {
+	Get(ctx context.Context, domainName string) (*domain.MTASTSPolicy, error)
+	Save(ctx context.Context, policy *domain.MTASTSPolicy) error
+	DeleteExpired(ctx context.Context, before time.Time) (int, error)
+}
+
+// TLSResultRepository defines the contract for TLS-RPT session statistics
+type TLSResultRepository interface {
+	Record(ctx context.Context, result *domain.TLSResult) error
+	Summarize(ctx context.Context, domainName string, from, to time.Time) ([]*domain.TLSResultSummary, error)
+	ListDomains(ctx context.Context, from, to time.Time) ([]string, error)
+}
+
+// DKIMKeyRepository defines the contract for DKIM key data access
+type DKIMKeyRepository interface {
+	Create(ctx context.Context, key *domain.DKIMKey) error
+	GetByID(ctx context.Context, id string) (*domain.DKIMKey, error)
+	GetBySelector(ctx context.Context, domainName, selector string) (*domain.DKIMKey, error)
+	GetActive(ctx context.Context, domainName string) (*domain.DKIMKey, error)
+	Update(ctx context.Context, key *domain.DKIMKey) error
+	ListByDomain(ctx context.Context, domainName string) ([]*domain.DKIMKey, error)
+	ListByState(ctx context.Context, state domain.DKIMKeyState) ([]*domain.DKIMKey, error)
+}
+
+// BlobRepository defines the contract for blob reference data access.
+// AddRef and DeleteUnreferenced report whether a row was affected so a
+// blob being collected is never revived.
+type BlobRepository interface {
+	Create(ctx context.Context, blob *domain.Blob) (bool, error)
+	GetByID(ctx context.Context, id string) (*domain.Blob, error)
+	AddRef(ctx context.Context, id string, delta int) (bool, error)
+	ListCollectable(ctx context.Context, before time.Time, limit int) ([]*domain.Blob, error)
+	DeleteUnreferenced(ctx context.Context, id string, before time.Time) (bool, error)
+}
+
+// DKIMRotationLogRepository defines the contract for the DKIM rotation audit log
+type DKIMRotationLogRepository interface {
+	Record(ctx context.Context, entry *domain.DKIMRotationEntry) error
+	ListByDomain(ctx context.Context, domainName string, limit int) ([]*domain.DKIMRotationEntry, error)
+}
+
+// Transactor runs work atomically. Repositories of the same backend called
+// with the context handed to fn take part in the transaction.
+type Transactor interface {
+	WithinTransaction(ctx context.Context, fn func(ctx context.Context) error) error
+}
+
+// OutboxRepository defines the contract for the transactional event outbox.
+// Add also appends the events to the event log read by the EventStore.
+// Claim leases due entries to one relay by pushing NextAttemptAt to
+// now+lease and counting the attempt, so an entry whose relay dies is
+// claimed again once the lease expires.
+type OutboxRepository interface {
+	Add(ctx context.Context, entries []*domain.OutboxEntry) error
+	Claim(ctx context.Context, now time.Time, limit int, lease time.Duration) ([]*domain.OutboxEntry, error)
+	MarkDelivered(ctx context.Context, id string, at time.Time) error
+	MarkFailed(ctx context.Context, id string, lastError string, nextAttemptAt time.Time) error
+	MarkDead(ctx context.Context, id string, lastError string) error
+	ListDead(ctx context.Context, limit, offset int) ([]*domain.OutboxEntry, error)
+	Requeue(ctx context.Context, id string, at time.Time) (bool, error)
+	DeleteDelivered(ctx context.Context, before time.Time) (int, error)
+}
@@ -0,0 +1,261 @@
+package maildir
+
+import (
+	"bufio"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// indexFile is the sidecar index kept in every maildir folder. It maps the
+// files of the folder to message IDs and IMAP UIDs and keeps the metadata a
+// message file cannot carry. The first line holds the format version, the
+// UIDVALIDITY and the next UID:
+//
+//	1 V1700000000 N3
+//	1 <id> <received> <created> <updated> <sent-at> <size> <attrs> <basename>
+//	2 ...
+//
+// Times are Unix nanoseconds, 0 for an unset time. Attrs is "-" or a comma
+// separated list of "sent", "starred", "t:<thread ID>" and "l:<label>" with
+// the label query escaped.
+const (
+	indexFile    = "aether-uidlist"
+	indexVersion = "1"
+)
+
+type indexEntry struct {
+	UID      uint32
+	ID       string
+	Received time.Time
+	Created  time.Time
+	Updated  time.Time
+	SentAt   *time.Time
+	Size     int64
+	Sent     bool
+	Starred  bool
+	ThreadID string
+	Labels   []string
+	Basename string
+}
+
+type folderIndex struct {
+	Validity uint32
+	NextUID  uint32
+	Entries  []*indexEntry // ordered by UID
+}
+
+// readIndex loads the index of a folder, or starts a new one
+func readIndex(dir string) (*folderIndex, error) {
+	file, err := os.Open(filepath.Join(dir, indexFile))
+	if os.IsNotExist(err) {
+		return &folderIndex{Validity: uint32(time.Now().Unix()), NextUID: 1}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	if !scanner.Scan() {
+		return nil, fmt.Errorf("maildir: empty index in %s", dir)
+	}
+	idx := &folderIndex{}
+	header := strings.Fields(scanner.Text())
+	if len(header) != 3 || header[0] != indexVersion {
+		return nil, fmt.Errorf("maildir: unsupported index in %s", dir)
+	}
+	for _, field := range header[1:] {
+		value, err := strconv.ParseUint(field[1:], 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("maildir: invalid index header in %s", dir)
+		}
+		switch field[0] {
+		case 'V':
+			idx.Validity = uint32(value)
+		case 'N':
+			idx.NextUID = uint32(value)
+		}
+	}
+
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == "" {
+			continue
+		}
+		fields := strings.SplitN(line, " ", 9)
+		if len(fields) != 9 {
+			return nil, fmt.Errorf("maildir: invalid index line in %s: %q", dir, line)
+		}
+		entry, err := parseIndexEntry(fields)
+		if err != nil {
+			return nil, fmt.Errorf("maildir: invalid index line in %s: %w", dir, err)
+		}
+		idx.Entries = append(idx.Entries, entry)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	sort.Slice(idx.Entries, func(i, j int) bool { return idx.Entries[i].UID < idx.Entries[j].UID })
+	return idx, nil
+}
+
+// write replaces the index atomically
+func (idx *folderIndex) write(dir string) error {
+	var buf strings.Builder
+	fmt.Fprintf(&buf, "%s V%d N%d\n", indexVersion, idx.Validity, idx.NextUID)
+	for _, entry := range idx.Entries {
+		attrs := formatAttrs(entry)
+		var sentAt time.Time
+		if entry.SentAt != nil {
+			sentAt = *entry.SentAt
+		}
+		fmt.Fprintf(&buf, "%d %s %d %d %d %d %d %s %s\n", entry.UID, entry.ID, unixNano(entry.Received),
+			unixNano(entry.Created), unixNano(entry.Updated), unixNano(sentAt), entry.Size, attrs, entry.Basename)
+	}
+
+	tmp, err := os.CreateTemp(dir, "."+indexFile+"-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.WriteString(buf.String()); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), filepath.Join(dir, indexFile))
+}
+
+// byID returns the entry of a message, or nil
+func (idx *folderIndex) byID(id string) *indexEntry {
+	for _, entry := range idx.Entries {
+		if entry.ID == id {
+			return entry
+		}
+	}
+	return nil
+}
+
+// add assigns the next UID to an entry
+func (idx *folderIndex) add(entry *indexEntry) {
+	entry.UID = idx.NextUID
+	idx.NextUID++
+	idx.Entries = append(idx.Entries, entry)
+}
+
+// remove drops the entry of a message
+func (idx *folderIndex) remove(id string) {
+	for i, entry := range idx.Entries {
+		if entry.ID == id {
+			idx.Entries = append(idx.Entries[:i], idx.Entries[i+1:]...)
+			return
+		}
+	}
+}
+
+func parseIndexEntry(fields []string) (*indexEntry, error) {
+	uid, err := strconv.ParseUint(fields[0], 10, 32)
+	if err != nil {
+		return nil, err
+	}
+	times := make([]time.Time, 4)
+	for i := range times {
+		nanos, err := strconv.ParseInt(fields[2+i], 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		if nanos != 0 {
+			times[i] = time.Unix(0, nanos)
+		}
+	}
+	size, err := strconv.ParseInt(fields[6], 10, 64)
+	if err != nil {
+		return nil, err
+	}
+
+	entry := &indexEntry{
+		UID:      uint32(uid),
+		ID:       fields[1],
+		Received: times[0],
+		Created:  times[1],
+		Updated:  times[2],
+		Size:     size,
+		Basename: fields[8],
+	}
+	if err := parseAttrs(entry, fields[7]); err != nil {
+		return nil, err
+	}
+	if !times[3].IsZero() {
+		entry.SentAt = &times[3]
+	}
+	return entry, nil
+}
+
+func formatAttrs(entry *indexEntry) string {
+	attrs := []string{}
+	if entry.Sent {
+		attrs = append(attrs, "sent")
+	}
+	if entry.Starred {
+		attrs = append(attrs, "starred")
+	}
+	if entry.ThreadID != "" {
+		attrs = append(attrs, "t:"+url.QueryEscape(entry.ThreadID))
+	}
+	for _, label := range entry.Labels {
+		attrs = append(attrs, "l:"+url.QueryEscape(label))
+	}
+	if len(attrs) == 0 {
+		return "-"
+	}
+	return strings.Join(attrs, ",")
+}
+
+// parseAttrs reads the attrs field; unknown attributes are ignored
+func parseAttrs(entry *indexEntry, field string) error {
+	entry.Labels = []string{}
+	if field == "-" {
+		return nil
+	}
+	for _, attr := range strings.Split(field, ",") {
+		switch {
+		case attr == "sent":
+			entry.Sent = true
+		case attr == "starred":
+			entry.Starred = true
+		case strings.HasPrefix(attr, "t:"):
+			threadID, err := url.QueryUnescape(attr[2:])
+			if err != nil {
+				return err
+			}
+			entry.ThreadID = threadID
+		case strings.HasPrefix(attr, "l:"):
+			label, err := url.QueryUnescape(attr[2:])
+			if err != nil {
+				return err
+			}
+			entry.Labels = append(entry.Labels, label)
+		}
+	}
+	return nil
+}
+
+func unixNano(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+	return t.UnixNano()
+}
@@ -0,0 +1,808 @@
+// Package maildir stores mailboxes as Maildir++ directories that Dovecot,
+// mutt and ordinary backup tools understand. Each account is a maildir
+// under the root directory, named after the account ID; its INBOX is the
+// account directory itself and other folders are ".Name.Sub" directories.
+// Read, draft, flagged and deleted states are the S, D, F and T filename
+// flags, and a sidecar index in each folder keeps message IDs, IMAP UIDs
+// and the metadata a message file cannot carry, such as labels.
+//
+// Files delivered or moved by other tools are picked up the next time the
+// folder is read. A repository assumes it is the only writer of the index
+// files, so at most one process should use the same root.
+package maildir
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/google/uuid"
+	"github.com/skygenesisenterprise/aether-mailer/package/golang/domain"
+	"github.com/skygenesisenterprise/aether-mailer/package/golang/repository"
+)
+
+// MessageRepository stores messages in Maildir++ directories. Folders are
+// resolved through the folder repository, which stays the source of truth
+// for folder names and types.
+type MessageRepository struct {
+	root    string
+	folders repository.FolderRepository
+
+	mu      sync.Mutex
+	located map[string]string // message ID to folder directory
+	scanned bool
+}
+
+// NewMessageRepository creates a Maildir message repository under root
+func NewMessageRepository(root string, folders repository.FolderRepository) (*MessageRepository, error) {
+	if err := os.MkdirAll(root, 0o700); err != nil {
+		return nil, err
+	}
+	abs, err := filepath.Abs(root)
+	if err != nil {
+		return nil, err
+	}
+	return &MessageRepository{
+		root:    abs,
+		folders: folders,
+		located: make(map[string]string),
+	}, nil
+}
+
+// Create delivers a message into its folder
+func (r *MessageRepository) Create(ctx context.Context, message *domain.Message) error {
+	dir, err := r.folderDir(ctx, message.AccountID, message.FolderID)
+	if err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if err := r.scanAll(); err != nil {
+		return err
+	}
+	if _, exists := r.located[message.ID]; exists {
+		return fmt.Errorf("maildir: message %s already exists", message.ID)
+	}
+	idx, _, err := r.sync(dir)
+	if err != nil {
+		return err
+	}
+
+	basename, err := deliver(dir, message, "")
+	if err != nil {
+		return err
+	}
+	idx.add(newIndexEntry(message, basename))
+	if err := idx.write(dir); err != nil {
+		return err
+	}
+	r.located[message.ID] = dir
+	return nil
+}
+
+// GetByID returns a message, or nil when it does not exist
+func (r *MessageRepository) GetByID(ctx context.Context, id string) (*domain.Message, error) {
+	r.mu.Lock()
+	dir, entry, file, err := r.find(id)
+	r.mu.Unlock()
+	if err != nil || entry == nil {
+		return nil, err
+	}
+
+	accountID, folderIDs, err := r.accountFolders(ctx, dir)
+	if err != nil {
+		return nil, err
+	}
+	return r.load(accountID, folderIDs[dir], dir, entry, file)
+}
+
+// Update saves a message. Changed states only rename the file; a changed
+// folder or content writes a new file, which gets a new UID as IMAP
+// requires.
+func (r *MessageRepository) Update(ctx context.Context, message *domain.Message) error {
+	target, err := r.folderDir(ctx, message.AccountID, message.FolderID)
+	if err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	dir, entry, file, err := r.find(message.ID)
+	if err != nil || entry == nil {
+		return err
+	}
+	idx, _, err := r.sync(dir)
+	if err != nil {
+		return err
+	}
+	entry = idx.byID(message.ID)
+	if entry == nil {
+		return nil
+	}
+
+	_, flags := splitFilename(filepath.Base(file))
+	flags = messageFlags(message, flags)
+	data, err := encodeMessage(message)
+	if err != nil {
+		return err
+	}
+	current, err := os.ReadFile(file)
+	if err != nil {
+		return err
+	}
+
+	if target == dir && string(data) == string(current) {
+		renamed := filepath.Join(dir, "cur", joinFilename(entry.Basename, flags))
+		if flags == "" && filepath.Base(filepath.Dir(file)) == "new" {
+			renamed = file
+		}
+		if renamed != file {
+			if err := os.Rename(file, renamed); err != nil {
+				return err
+			}
+		}
+		updateIndexEntry(entry, message)
+		return idx.write(dir)
+	}
+
+	// Replace the file; the message gets a new UID in its folder
+	targetIdx := idx
+	if target != dir {
+		if targetIdx, _, err = r.sync(target); err != nil {
+			return err
+		}
+	}
+	basename, err := deliver(target, message, flags)
+	if err != nil {
+		return err
+	}
+	replaced := newIndexEntry(message, basename)
+	replaced.Received = entry.Received
+	replaced.Created = entry.Created
+	idx.remove(message.ID)
+	targetIdx.add(replaced)
+	if err := targetIdx.write(target); err != nil {
+		return err
+	}
+	if target != dir {
+		if err := idx.write(dir); err != nil {
+			return err
+		}
+	}
+	r.located[message.ID] = target
+	return os.Remove(file)
+}
+
+// Delete removes a message
+func (r *MessageRepository) Delete(ctx context.Context, id string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	dir, entry, file, err := r.find(id)
+	if err != nil || entry == nil {
+		return err
+	}
+	if err := os.Remove(file); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	// Syncing drops the entry of the removed file
+	_, _, err = r.sync(dir)
+	return err
+}
+
+// ListByAccount returns the messages of an account, newest first
+func (r *MessageRepository) ListByAccount(ctx context.Context, accountID string, filter repository.MessageFilter) ([]*domain.Message, error) {
+	messages, err := r.listAccount(ctx, accountID, filter)
+	if err != nil {
+		return nil, err
+	}
+	return page(messages, filter.Offset, filter.Limit), nil
+}
+
+// CountByAccount counts the messages of an account matching a filter
+func (r *MessageRepository) CountByAccount(ctx context.Context, accountID string, filter repository.MessageFilter) (int, error) {
+	messages, err := r.listAccount(ctx, accountID, filter)
+	if err != nil {
+		return 0, err
+	}
+	return len(messages), nil
+}
+
+// Search returns the messages of an account matching a search query,
+// newest first. Words are matched whole in the addresses, subject, body
+// and attachment filenames, as in the other backends.
+func (r *MessageRepository) Search(ctx context.Context, query repository.MessageSearchQuery) ([]*domain.Message, error) {
+	search, err := domain.ParseSearchQuery(query.Query)
+	if err != nil {
+		return nil, err
+	}
+	messages, err := r.listAccount(ctx, query.AccountID, repository.MessageFilter{
+		DateFrom: query.DateFrom,
+		DateTo:   query.DateTo,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	matches := []*domain.Message{}
+	for _, message := range messages {
+		if search.MatchesMessage(message) && search.MatchesText(domain.NewSearchDocument(message).Text()) {
+			matches = append(matches, message)
+		}
+	}
+	return page(matches, query.Offset, query.Limit), nil
+}
+
+// UID returns the IMAP UID and UIDVALIDITY of a message, or zeros when it
+// does not exist
+func (r *MessageRepository) UID(ctx context.Context, id string) (uid, validity uint32, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	dir, entry, _, err := r.find(id)
+	if err != nil || entry == nil {
+		return 0, 0, err
+	}
+	idx, _, err := r.sync(dir)
+	if err != nil {
+		return 0, 0, err
+	}
+	return entry.UID, idx.Validity, nil
+}
+
+// RenameFolder moves the maildir of a folder and of its subfolders to a new
+// path. It is called before the folder repository is updated, as folders
+// are found by path; a folder without a maildir yet is left alone.
+func (r *MessageRepository) RenameFolder(ctx context.Context, accountID, oldPath, newPath string) error {
+	if !validAccountID(accountID) {
+		return fmt.Errorf("maildir: invalid account ID %q", accountID)
+	}
+	accountDir := filepath.Join(r.root, accountID)
+	oldName, newName := folderDirName(oldPath), folderDirName(newPath)
+	if oldName == "." || newName == "." {
+		return fmt.Errorf("maildir: cannot rename the INBOX of account %s", accountID)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	dirs, err := maildirs(accountDir)
+	if err != nil {
+		return err
+	}
+	for _, dir := range dirs {
+		name := filepath.Base(dir)
+		if dir == accountDir || (name != oldName && !strings.HasPrefix(name, oldName+".")) {
+			continue
+		}
+		target := filepath.Join(accountDir, newName+strings.TrimPrefix(name, oldName))
+		if _, err := os.Stat(target); err == nil {
+			return fmt.Errorf("maildir: folder %s already exists", target)
+		}
+		if err := os.Rename(dir, target); err != nil {
+			return err
+		}
+	}
+	// Rescan so the cached locations follow the moved directories
+	r.scanned = false
+	return nil
+}
+
+// listAccount loads the messages of an account matching a filter, newest
+// first
+func (r *MessageRepository) listAccount(ctx context.Context, accountID string, filter repository.MessageFilter) ([]*domain.Message, error) {
+	if !validAccountID(accountID) {
+		return nil, fmt.Errorf("maildir: invalid account ID %q", accountID)
+	}
+	accountDir := filepath.Join(r.root, accountID)
+	_, folderIDs, err := r.accountFolders(ctx, accountDir)
+	if err != nil {
+		return nil, err
+	}
+	dirs, err := maildirs(accountDir)
+	if err != nil {
+		return nil, err
+	}
+
+	type located struct {
+		dir   string
+		entry *indexEntry
+		file  string
+	}
+	candidates := []located{}
+
+	r.mu.Lock()
+	if err := r.scanAll(); err != nil {
+		r.mu.Unlock()
+		return nil, err
+	}
+	for _, dir := range dirs {
+		idx, files, err := r.sync(dir)
+		if err != nil {
+			r.mu.Unlock()
+			return nil, err
+		}
+		for _, entry := range idx.Entries {
+			candidates = append(candidates, located{dir: dir, entry: entry, file: files[entry.Basename]})
+		}
+	}
+	r.mu.Unlock()
+
+	messages := []*domain.Message{}
+	for _, candidate := range candidates {
+		_, flags := splitFilename(filepath.Base(candidate.file))
+		if filter.FolderID != nil && folderIDs[candidate.dir] != *filter.FolderID {
+			continue
+		}
+		if !matchesIndexFilter(candidate.entry, flags, filter) {
+			continue
+		}
+		message, err := r.load(accountID, folderIDs[candidate.dir], candidate.dir, candidate.entry, candidate.file)
+		if err != nil {
+			if os.IsNotExist(err) {
+				// Moved by another client since the folder was read
+				continue
+			}
+			return nil, err
+		}
+		if matchesContentFilter(message, filter) {
+			messages = append(messages, message)
+		}
+	}
+
+	sort.SliceStable(messages, func(i, j int) bool {
+		return messages[i].ReceivedAt.After(messages[j].ReceivedAt)
+	})
+	return messages, nil
+}
+
+// load reads a message file and completes it from the index
+func (r *MessageRepository) load(accountID, folderID, dir string, entry *indexEntry, file string) (*domain.Message, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	message, err := decodeMessage(data)
+	if err != nil {
+		return nil, fmt.Errorf("maildir: reading %s: %w", file, err)
+	}
+
+	_, flags := splitFilename(filepath.Base(file))
+	message.ID = entry.ID
+	message.AccountID = accountID
+	message.FolderID = folderID
+	message.Size = entry.Size
+	message.IsRead = hasFlag(flags, flagSeen)
+	message.IsDraft = hasFlag(flags, flagDraft)
+	message.IsDeleted = hasFlag(flags, flagTrashed)
+	message.IsFlagged = hasFlag(flags, flagFlagged)
+	message.IsSent = entry.Sent
+	message.IsStarred = entry.Starred
+	message.ThreadID = entry.ThreadID
+	message.Labels = append([]string{}, entry.Labels...)
+	message.SentAt = entry.SentAt
+	message.ReceivedAt = entry.Received
+	message.CreatedAt = entry.Created
+	message.UpdatedAt = entry.Updated
+	for i := range message.Attachments {
+		message.Attachments[i].MessageID = entry.ID
+	}
+	return message, nil
+}
+
+// find locates a message; the whole tree is scanned once to learn the
+// messages written before the repository was opened, and again when a
+// message was moved by another client
+func (r *MessageRepository) find(id string) (dir string, entry *indexEntry, file string, err error) {
+	if err := r.scanAll(); err != nil {
+		return "", nil, "", err
+	}
+	for attempt := 0; attempt < 2; attempt++ {
+		dir, ok := r.located[id]
+		if !ok {
+			return "", nil, "", nil
+		}
+		idx, files, err := r.sync(dir)
+		if err != nil {
+			return "", nil, "", err
+		}
+		if entry := idx.byID(id); entry != nil {
+			return dir, entry, files[entry.Basename], nil
+		}
+		r.scanned = false
+		if err := r.scanAll(); err != nil {
+			return "", nil, "", err
+		}
+	}
+	return "", nil, "", nil
+}
+
+// scanAll indexes every folder of every account
+func (r *MessageRepository) scanAll() error {
+	if r.scanned {
+		return nil
+	}
+	accounts, err := os.ReadDir(r.root)
+	if err != nil {
+		return err
+	}
+	r.located = make(map[string]string)
+	for _, account := range accounts {
+		if !account.IsDir() || !validAccountID(account.Name()) {
+			continue
+		}
+		dirs, err := maildirs(filepath.Join(r.root, account.Name()))
+		if err != nil {
+			return err
+		}
+		for _, dir := range dirs {
+			if _, _, err := r.sync(dir); err != nil {
+				return err
+			}
+		}
+	}
+	r.scanned = true
+	return nil
+}
+
+// sync reconciles the index of a folder with its files: entries of removed
+// files are dropped and files delivered by other tools get an ID and a UID.
+// It returns the index and the path of each file by basename.
+func (r *MessageRepository) sync(dir string) (*folderIndex, map[string]string, error) {
+	idx, err := readIndex(dir)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	files := make(map[string]string)
+	for _, sub := range []string{"new", "cur"} {
+		entries, err := os.ReadDir(filepath.Join(dir, sub))
+		if err != nil && !os.IsNotExist(err) {
+			return nil, nil, err
+		}
+		for _, entry := range entries {
+			if entry.IsDir() || strings.HasPrefix(entry.Name(), ".") {
+				continue
+			}
+			basename, _ := splitFilename(entry.Name())
+			files[basename] = filepath.Join(dir, sub, entry.Name())
+		}
+	}
+
+	changed := false
+	kept := idx.Entries[:0]
+	indexed := make(map[string]bool, len(idx.Entries))
+	for _, entry := range idx.Entries {
+		if _, ok := files[entry.Basename]; !ok {
+			if r.located[entry.ID] == dir {
+				delete(r.located, entry.ID)
+			}
+			changed = true
+			continue
+		}
+		kept = append(kept, entry)
+		indexed[entry.Basename] = true
+	}
+	idx.Entries = kept
+
+	unindexed := []string{}
+	for basename := range files {
+		if !indexed[basename] {
+			unindexed = append(unindexed, basename)
+		}
+	}
+	// Deliveries are named after their time, so this keeps UIDs in order
+	sort.Strings(unindexed)
+	for _, basename := range unindexed {
+		entry, err := r.adopt(dir, files[basename], basename, idx)
+		if err != nil {
+			return nil, nil, err
+		}
+		idx.add(entry)
+		changed = true
+	}
+
+	if changed {
+		if err := idx.write(dir); err != nil {
+			return nil, nil, err
+		}
+	}
+	for _, entry := range idx.Entries {
+		r.located[entry.ID] = dir
+	}
+	return idx, files, nil
+}
+
+// adopt indexes a file delivered by another tool. A file written by this
+// repository keeps its ID unless that ID is still in use elsewhere, as
+// happens when a client copies rather than moves the file.
+func (r *MessageRepository) adopt(dir, file, basename string, idx *folderIndex) (*indexEntry, error) {
+	info, err := os.Stat(file)
+	if err != nil {
+		return nil, err
+	}
+
+	id := readMessageID(file)
+	if id == "" || idx.byID(id) != nil {
+		id = uuid.New().String()
+	} else if other, ok := r.located[id]; ok && other != dir {
+		id = uuid.New().String()
+	}
+
+	return &indexEntry{
+		ID:       id,
+		Received: info.ModTime(),
+		Created:  info.ModTime(),
+		Updated:  info.ModTime(),
+		Size:     info.Size(),
+		Basename: basename,
+	}, nil
+}
+
+// folderDir returns the maildir of a folder, creating it when needed. An
+// empty folder ID and the INBOX folder are the account directory.
+func (r *MessageRepository) folderDir(ctx context.Context, accountID, folderID string) (string, error) {
+	if !validAccountID(accountID) {
+		return "", fmt.Errorf("maildir: invalid account ID %q", accountID)
+	}
+	dir := filepath.Join(r.root, accountID)
+
+	if folderID != "" {
+		folder, err := r.folders.GetByID(ctx, folderID)
+		if err != nil {
+			return "", err
+		}
+		if folder == nil || folder.AccountID != accountID {
+			return "", fmt.Errorf("maildir: folder %s not found for account %s", folderID, accountID)
+		}
+		if folder.Type != domain.FolderTypeInbox {
+			dir = filepath.Join(dir, folderDirName(folderPath(folder)))
+		}
+	}
+
+	for _, sub := range []string{"tmp", "new", "cur"} {
+		if err := os.MkdirAll(filepath.Join(dir, sub), 0o700); err != nil {
+			return "", err
+		}
+	}
+	if filepath.Dir(dir) != r.root {
+		// Maildir++ marks subfolders so they are not mistaken for accounts
+		marker := filepath.Join(dir, "maildirfolder")
+		if _, err := os.Stat(marker); os.IsNotExist(err) {
+			if err := os.WriteFile(marker, nil, 0o600); err != nil {
+				return "", err
+			}
+		}
+	}
+	return dir, nil
+}
+
+// accountFolders maps the maildirs of the account owning dir to folder IDs
+func (r *MessageRepository) accountFolders(ctx context.Context, dir string) (string, map[string]string, error) {
+	accountDir := dir
+	if filepath.Dir(dir) != r.root {
+		accountDir = filepath.Dir(dir)
+	}
+	accountID := filepath.Base(accountDir)
+
+	folders, err := r.folders.ListByAccount(ctx, accountID)
+	if err != nil {
+		return "", nil, err
+	}
+	folderIDs := make(map[string]string, len(folders))
+	for _, folder := range folders {
+		if folder.Type == domain.FolderTypeInbox {
+			folderIDs[accountDir] = folder.ID
+			continue
+		}
+		folderIDs[filepath.Join(accountDir, folderDirName(folderPath(folder)))] = folder.ID
+	}
+	return accountID, folderIDs, nil
+}
+
+// Helper functions
+
+// deliver writes a message through tmp/ as the Maildir specification
+// requires and returns its basename. Unflagged messages go to new/.
+func deliver(dir string, message *domain.Message, flags string) (string, error) {
+	flags = messageFlags(message, flags)
+	data, err := encodeMessage(message)
+	if err != nil {
+		return "", err
+	}
+	basename := newBasename(int64(len(data)))
+
+	tmp := filepath.Join(dir, "tmp", basename)
+	file, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+	if err != nil {
+		return "", err
+	}
+	if _, err := file.Write(data); err != nil {
+		file.Close()
+		os.Remove(tmp)
+		return "", err
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		os.Remove(tmp)
+		return "", err
+	}
+	if err := file.Close(); err != nil {
+		os.Remove(tmp)
+		return "", err
+	}
+
+	target := filepath.Join(dir, "new", basename)
+	if flags != "" {
+		target = filepath.Join(dir, "cur", joinFilename(basename, flags))
+	}
+	if err := os.Rename(tmp, target); err != nil {
+		os.Remove(tmp)
+		return "", err
+	}
+	return basename, nil
+}
+
+// messageFlags applies the message states to existing flags, keeping the
+// flags this repository does not manage such as P and R
+func messageFlags(message *domain.Message, flags string) string {
+	flags = setFlag(flags, flagSeen, message.IsRead)
+	flags = setFlag(flags, flagDraft, message.IsDraft)
+	flags = setFlag(flags, flagFlagged, message.IsFlagged)
+	return setFlag(flags, flagTrashed, message.IsDeleted)
+}
+
+func newIndexEntry(message *domain.Message, basename string) *indexEntry {
+	entry := &indexEntry{
+		ID:       message.ID,
+		Received: message.ReceivedAt,
+		Created:  message.CreatedAt,
+		Basename: basename,
+	}
+	updateIndexEntry(entry, message)
+	return entry
+}
+
+func updateIndexEntry(entry *indexEntry, message *domain.Message) {
+	entry.Updated = message.UpdatedAt
+	entry.SentAt = message.SentAt
+	entry.Size = message.Size
+	entry.Sent = message.IsSent
+	entry.Starred = message.IsStarred
+	entry.ThreadID = message.ThreadID
+	entry.Labels = append([]string{}, message.Labels...)
+}
+
+// readMessageID returns the ID header of a message file, if any
+func readMessageID(file string) string {
+	f, err := os.Open(file)
+	if err != nil {
+		return ""
+	}
+	defer f.Close()
+	header, err := textproto.NewReader(bufio.NewReader(f)).ReadMIMEHeader()
+	if err != nil && len(header) == 0 {
+		return ""
+	}
+	id := strings.TrimSpace(header.Get(messageIDHeader))
+	if strings.ContainsAny(id, " \t") {
+		return ""
+	}
+	return id
+}
+
+// maildirs lists the maildir of an account and its Maildir++ subfolders
+func maildirs(accountDir string) ([]string, error) {
+	entries, err := os.ReadDir(accountDir)
+	if os.IsNotExist(err) {
+		return []string{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	dirs := []string{accountDir}
+	for _, entry := range entries {
+		if entry.IsDir() && strings.HasPrefix(entry.Name(), ".") && entry.Name() != "." && entry.Name() != ".." {
+			dirs = append(dirs, filepath.Join(accountDir, entry.Name()))
+		}
+	}
+	return dirs, nil
+}
+
+func folderPath(folder *domain.Folder) string {
+	if folder.Path != "" {
+		return folder.Path
+	}
+	return folder.Name
+}
+
+func validAccountID(id string) bool {
+	return id != "" && id != "." && id != ".." && !strings.HasPrefix(id, ".") && !strings.ContainsAny(id, `/\`)
+}
+
+func matchesIndexFilter(entry *indexEntry, flags string, filter repository.MessageFilter) bool {
+	if filter.IsRead != nil && hasFlag(flags, flagSeen) != *filter.IsRead {
+		return false
+	}
+	if filter.IsDraft != nil && hasFlag(flags, flagDraft) != *filter.IsDraft {
+		return false
+	}
+	if filter.IsDeleted != nil && hasFlag(flags, flagTrashed) != *filter.IsDeleted {
+		return false
+	}
+	if filter.IsFlagged != nil && hasFlag(flags, flagFlagged) != *filter.IsFlagged {
+		return false
+	}
+	if filter.IsSent != nil && entry.Sent != *filter.IsSent {
+		return false
+	}
+	if filter.IsStarred != nil && entry.Starred != *filter.IsStarred {
+		return false
+	}
+	if filter.ThreadID != nil && entry.ThreadID != *filter.ThreadID {
+		return false
+	}
+	for _, label := range filter.Labels {
+		if !containsString(entry.Labels, label) {
+			return false
+		}
+	}
+	if filter.DateFrom != nil && entry.Received.Before(*filter.DateFrom) {
+		return false
+	}
+	if filter.DateTo != nil && entry.Received.After(*filter.DateTo) {
+		return false
+	}
+	return true
+}
+
+func matchesContentFilter(message *domain.Message, filter repository.MessageFilter) bool {
+	if filter.HasAttachments != nil && (len(message.Attachments) > 0) != *filter.HasAttachments {
+		return false
+	}
+	if filter.From != nil && !containsFold(message.From, *filter.From) {
+		return false
+	}
+	if filter.To != nil && !containsFold(strings.Join(message.To, ", "), *filter.To) {
+		return false
+	}
+	if filter.Subject != nil && !containsFold(message.Subject, *filter.Subject) {
+		return false
+	}
+	return true
+}
+
+func containsString(s []string, value string) bool {
+	for _, item := range s {
+		if item == value {
+			return true
+		}
+	}
+	return false
+}
+
+func containsFold(s, substr string) bool {
+	return strings.Contains(strings.ToLower(s), strings.ToLower(substr))
+}
+
+func page(messages []*domain.Message, offset, limit int) []*domain.Message {
+	if offset > len(messages) {
+		offset = len(messages)
+	}
+	if offset > 0 {
+		messages = messages[offset:]
+	}
+	if limit > 0 && limit < len(messages) {
+		messages = messages[:limit]
+	}
+	return messages
+}
@@ -0,0 +1,461 @@
+package maildir
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/mail"
+	"net/textproto"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/skygenesisenterprise/aether-mailer/package/golang/domain"
+)
+
+// Header carrying the message ID, so it survives a lost index and moves
+// done by other mail clients
+const messageIDHeader = "X-Aether-Message-Id"
+
+// Header carrying the attachment ID in attachment parts
+const attachmentIDHeader = "X-Aether-Attachment-Id"
+
+// Attachments kept in the blob store are written as external bodies with
+// this access type instead of being copied into the maildir
+const blobAccessType = "x-aether-blob"
+
+// Headers written from the message fields; the same names in
+// Message.Headers are ignored
+var reservedHeaders = map[string]bool{
+	"Bcc":                       true,
+	"Cc":                        true,
+	"Content-Transfer-Encoding": true,
+	"Content-Type":              true,
+	"Date":                      true,
+	"From":                      true,
+	"Mime-Version":              true,
+	"Subject":                   true,
+	"To":                        true,
+	messageIDHeader:             true,
+}
+
+// encodeMessage renders a message as an RFC 5322 document
+func encodeMessage(message *domain.Message) ([]byte, error) {
+	var buf bytes.Buffer
+
+	date := message.ReceivedAt
+	if message.SentAt != nil {
+		date = *message.SentAt
+	}
+	if date.IsZero() {
+		date = message.CreatedAt
+	}
+
+	writeHeader(&buf, messageIDHeader, message.ID)
+	writeHeader(&buf, "Date", date.Format(time.RFC1123Z))
+	writeHeader(&buf, "From", message.From)
+	writeAddressHeader(&buf, "To", message.To)
+	writeAddressHeader(&buf, "Cc", message.Cc)
+	writeAddressHeader(&buf, "Bcc", message.Bcc)
+	writeHeader(&buf, "Subject", mime.QEncoding.Encode("utf-8", message.Subject))
+
+	names := make([]string, 0, len(message.Headers))
+	for name := range message.Headers {
+		canonical := textproto.CanonicalMIMEHeaderKey(name)
+		if reservedHeaders[canonical] || !validHeaderName(name) {
+			continue
+		}
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		writeHeader(&buf, name, message.Headers[name])
+	}
+	writeHeader(&buf, "MIME-Version", "1.0")
+
+	// QP and base64 never produce "=_", so these boundaries cannot collide
+	// with part content, and stay stable across rewrites of the message
+	boundary := message.ID
+	if len(boundary) > 40 || !validBoundary(boundary) {
+		sum := sha256.Sum256([]byte(message.ID))
+		boundary = hex.EncodeToString(sum[:12])
+	}
+
+	if len(message.Attachments) == 0 {
+		if err := writeBody(&buf, message, "=_alt_"+boundary); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	}
+
+	writeHeader(&buf, "Content-Type", mime.FormatMediaType("multipart/mixed", map[string]string{"boundary": "=_mixed_" + boundary}))
+	buf.WriteString("\r\n")
+	mixed := multipart.NewWriter(&buf)
+	if err := mixed.SetBoundary("=_mixed_" + boundary); err != nil {
+		return nil, err
+	}
+
+	var body bytes.Buffer
+	if err := writeBody(&body, message, "=_alt_"+boundary); err != nil {
+		return nil, err
+	}
+	if err := copyPart(mixed, body.Bytes()); err != nil {
+		return nil, err
+	}
+	for i := range message.Attachments {
+		if err := writeAttachment(mixed, &message.Attachments[i]); err != nil {
+			return nil, err
+		}
+	}
+	if err := mixed.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// writeBody writes the text and HTML bodies, as multipart/alternative when
+// the message has both
+func writeBody(buf *bytes.Buffer, message *domain.Message, boundary string) error {
+	if message.BodyText != nil && message.BodyHTML != nil {
+		writeHeader(buf, "Content-Type", mime.FormatMediaType("multipart/alternative", map[string]string{"boundary": boundary}))
+		buf.WriteString("\r\n")
+		alternative := multipart.NewWriter(buf)
+		if err := alternative.SetBoundary(boundary); err != nil {
+			return err
+		}
+		for _, part := range []struct {
+			mediaType string
+			content   string
+		}{{"text/plain", *message.BodyText}, {"text/html", *message.BodyHTML}} {
+			var text bytes.Buffer
+			if err := writeTextPart(&text, part.mediaType, part.content); err != nil {
+				return err
+			}
+			if err := copyPart(alternative, text.Bytes()); err != nil {
+				return err
+			}
+		}
+		return alternative.Close()
+	}
+
+	if message.BodyHTML != nil {
+		return writeTextPart(buf, "text/html", *message.BodyHTML)
+	}
+	text := ""
+	if message.BodyText != nil {
+		text = *message.BodyText
+	}
+	return writeTextPart(buf, "text/plain", text)
+}
+
+// writeTextPart writes the headers and quoted-printable body of a text part
+func writeTextPart(buf *bytes.Buffer, mediaType, content string) error {
+	writeHeader(buf, "Content-Type", mime.FormatMediaType(mediaType, map[string]string{"charset": "utf-8"}))
+	writeHeader(buf, "Content-Transfer-Encoding", "quoted-printable")
+	buf.WriteString("\r\n")
+	qp := quotedprintable.NewWriter(buf)
+	if _, err := qp.Write([]byte(strings.ReplaceAll(strings.ReplaceAll(content, "\r\n", "\n"), "\n", "\r\n"))); err != nil {
+		return err
+	}
+	return qp.Close()
+}
+
+// writeAttachment writes an attachment as a base64 part, or as a reference
+// to the blob store when its content is not held in memory
+func writeAttachment(mixed *multipart.Writer, attachment *domain.Attachment) error {
+	contentType := attachment.ContentType
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	header := textproto.MIMEHeader{}
+	header.Set(attachmentIDHeader, attachment.ID)
+	header.Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": attachment.Filename}))
+
+	if len(attachment.Content) == 0 && attachment.BlobID != "" {
+		header.Set("Content-Type", mime.FormatMediaType("message/external-body", map[string]string{
+			"access-type": blobAccessType,
+			"blob-id":     attachment.BlobID,
+			"checksum":    attachment.Checksum,
+			"size":        strconv.FormatInt(attachment.Size, 10),
+		}))
+		part, err := mixed.CreatePart(header)
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintf(part, "Content-Type: %s\r\n\r\n", mime.FormatMediaType(contentType, nil))
+		return err
+	}
+
+	header.Set("Content-Type", mime.FormatMediaType(contentType, map[string]string{"name": attachment.Filename}))
+	header.Set("Content-Transfer-Encoding", "base64")
+	part, err := mixed.CreatePart(header)
+	if err != nil {
+		return err
+	}
+	encoded := base64.StdEncoding.EncodeToString(attachment.Content)
+	for len(encoded) > 76 {
+		if _, err := io.WriteString(part, encoded[:76]+"\r\n"); err != nil {
+			return err
+		}
+		encoded = encoded[76:]
+	}
+	_, err = io.WriteString(part, encoded+"\r\n")
+	return err
+}
+
+// copyPart adds a part rendered with its own headers to a multipart body
+func copyPart(writer *multipart.Writer, rendered []byte) error {
+	reader := textproto.NewReader(bufio.NewReader(bytes.NewReader(rendered)))
+	header, err := reader.ReadMIMEHeader()
+	if err != nil {
+		return err
+	}
+	part, err := writer.CreatePart(header)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(part, reader.R)
+	return err
+}
+
+// decodeMessage reads the fields of a message from its RFC 5322 document.
+// Identity, folder, flags and times come from the index and the file name.
+func decodeMessage(data []byte) (*domain.Message, error) {
+	parsed, err := mail.ReadMessage(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+
+	message := &domain.Message{
+		From:        decodeHeader(parsed.Header.Get("From")),
+		To:          decodeAddresses(parsed.Header.Get("To")),
+		Cc:          decodeAddresses(parsed.Header.Get("Cc")),
+		Bcc:         decodeAddresses(parsed.Header.Get("Bcc")),
+		Subject:     decodeHeader(parsed.Header.Get("Subject")),
+		Attachments: []domain.Attachment{},
+		Headers:     map[string]string{},
+	}
+	for name, values := range parsed.Header {
+		if reservedHeaders[name] || len(values) == 0 {
+			continue
+		}
+		message.Headers[name] = decodeHeader(values[0])
+	}
+
+	if err := decodePart(message, textproto.MIMEHeader(parsed.Header), parsed.Body, 0); err != nil {
+		return nil, err
+	}
+	return message, nil
+}
+
+// decodePart walks the MIME tree and fills the bodies and attachments
+func decodePart(message *domain.Message, header textproto.MIMEHeader, body io.Reader, depth int) error {
+	mediaType, params, err := mime.ParseMediaType(header.Get("Content-Type"))
+	if err != nil {
+		mediaType, params = "text/plain", map[string]string{}
+	}
+
+	if strings.HasPrefix(mediaType, "multipart/") && depth < 10 {
+		reader := multipart.NewReader(body, params["boundary"])
+		for {
+			part, err := reader.NextRawPart()
+			if err == io.EOF {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			if err := decodePart(message, part.Header, part, depth+1); err != nil {
+				return err
+			}
+		}
+	}
+
+	disposition, dispositionParams, _ := mime.ParseMediaType(header.Get("Content-Disposition"))
+	filename := decodeHeader(dispositionParams["filename"])
+	if filename == "" {
+		filename = decodeHeader(params["name"])
+	}
+
+	if mediaType == "message/external-body" && params["access-type"] == blobAccessType {
+		inner, _ := textproto.NewReader(bufio.NewReader(body)).ReadMIMEHeader()
+		contentType, _, _ := mime.ParseMediaType(inner.Get("Content-Type"))
+		size, _ := strconv.ParseInt(params["size"], 10, 64)
+		message.Attachments = append(message.Attachments, domain.Attachment{
+			ID:          attachmentID(header),
+			Filename:    filename,
+			ContentType: contentType,
+			Size:        size,
+			BlobID:      params["blob-id"],
+			Checksum:    params["checksum"],
+		})
+		return nil
+	}
+
+	content, err := io.ReadAll(decodeTransfer(header.Get("Content-Transfer-Encoding"), body))
+	if err != nil {
+		return err
+	}
+
+	isAttachment := disposition == "attachment" || filename != ""
+	if !isAttachment && (mediaType == "text/plain" || mediaType == "text/html") {
+		text := strings.ReplaceAll(decodeCharset(content, params["charset"]), "\r\n", "\n")
+		if mediaType == "text/plain" && message.BodyText == nil {
+			message.BodyText = &text
+			return nil
+		}
+		if mediaType == "text/html" && message.BodyHTML == nil {
+			message.BodyHTML = &text
+			return nil
+		}
+	}
+
+	if filename == "" {
+		filename = "unnamed"
+	}
+	message.Attachments = append(message.Attachments, domain.Attachment{
+		ID:          attachmentID(header),
+		Filename:    filename,
+		ContentType: mediaType,
+		Size:        int64(len(content)),
+		Content:     content,
+	})
+	return nil
+}
+
+// Helper functions
+
+func writeHeader(buf *bytes.Buffer, name, value string) {
+	value = strings.NewReplacer("\r", " ", "\n", " ").Replace(value)
+	buf.WriteString(name + ": " + value + "\r\n")
+}
+
+func writeAddressHeader(buf *bytes.Buffer, name string, addresses []string) {
+	if len(addresses) == 0 {
+		return
+	}
+	writeHeader(buf, name, strings.Join(addresses, ", "))
+}
+
+func validHeaderName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for i := 0; i < len(name); i++ {
+		if name[i] <= ' ' || name[i] >= 0x7f || name[i] == ':' {
+			return false
+		}
+	}
+	return true
+}
+
+func validBoundary(s string) bool {
+	for _, r := range s {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-') {
+			return false
+		}
+	}
+	return true
+}
+
+func decodeHeader(value string) string {
+	decoded, err := new(mime.WordDecoder).DecodeHeader(value)
+	if err != nil {
+		return value
+	}
+	return decoded
+}
+
+// decodeAddresses splits an address header, keeping bare addresses bare
+func decodeAddresses(value string) []string {
+	if strings.TrimSpace(value) == "" {
+		return []string{}
+	}
+	parser := mail.AddressParser{WordDecoder: new(mime.WordDecoder)}
+	list, err := parser.ParseList(value)
+	if err != nil {
+		addresses := []string{}
+		for _, address := range strings.Split(value, ",") {
+			if address = strings.TrimSpace(address); address != "" {
+				addresses = append(addresses, address)
+			}
+		}
+		return addresses
+	}
+	addresses := make([]string, 0, len(list))
+	for _, address := range list {
+		switch {
+		case address.Name == "":
+			addresses = append(addresses, address.Address)
+		case strings.ContainsAny(address.Name, `()<>[]:;@\,."`):
+			addresses = append(addresses, fmt.Sprintf("%q <%s>", address.Name, address.Address))
+		default:
+			addresses = append(addresses, address.Name+" <"+address.Address+">")
+		}
+	}
+	return addresses
+}
+
+func decodeTransfer(encoding string, body io.Reader) io.Reader {
+	switch strings.ToLower(strings.TrimSpace(encoding)) {
+	case "quoted-printable":
+		return quotedprintable.NewReader(body)
+	case "base64":
+		return base64.NewDecoder(base64.StdEncoding, &base64Filter{r: body})
+	default:
+		return body
+	}
+}
+
+// decodeCharset converts Latin-1 text to UTF-8; other charsets are kept as
+// stored
+func decodeCharset(content []byte, charset string) string {
+	switch strings.ToLower(charset) {
+	case "iso-8859-1", "latin1", "iso_8859-1":
+		runes := make([]rune, len(content))
+		for i, b := range content {
+			runes[i] = rune(b)
+		}
+		return string(runes)
+	default:
+		return string(content)
+	}
+}
+
+func attachmentID(header textproto.MIMEHeader) string {
+	if id := header.Get(attachmentIDHeader); id != "" {
+		return id
+	}
+	return uuid.New().String()
+}
+
+// base64Filter drops the line breaks and stray characters some mailers
+// leave in base64 bodies
+type base64Filter struct {
+	r io.Reader
+}
+
+func (f *base64Filter) Read(p []byte) (int, error) {
+	for {
+		n, err := f.r.Read(p)
+		kept := 0
+		for _, c := range p[:n] {
+			if c >= 'A' && c <= 'Z' || c >= 'a' && c <= 'z' || c >= '0' && c <= '9' || c == '+' || c == '/' || c == '=' {
+				p[kept] = c
+				kept++
+			}
+		}
+		if kept > 0 || err != nil {
+			return kept, err
+		}
+	}
+}
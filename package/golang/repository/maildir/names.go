@@ -0,0 +1,123 @@
+package maildir
+
+import (
+	"encoding/base64"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+	"unicode/utf16"
+)
+
+// Maildir flags, written in ASCII order after the ":2," info prefix
+const (
+	flagDraft   = 'D'
+	flagFlagged = 'F'
+	flagPassed  = 'P'
+	flagReplied = 'R'
+	flagSeen    = 'S'
+	flagTrashed = 'T'
+)
+
+var deliveries uint64
+
+// newBasename returns a unique file name following the Maildir convention
+// time.M<usec>P<pid>Q<delivery>.<host>, with the Dovecot size extension
+func newBasename(size int64) string {
+	now := time.Now()
+	host, err := os.Hostname()
+	if err != nil || host == "" {
+		host = "localhost"
+	}
+	host = strings.NewReplacer("/", `\057`, ":", `\072`, ",", `\054`).Replace(host)
+	return fmt.Sprintf("%d.M%dP%dQ%d.%s,S=%d", now.Unix(), now.Nanosecond()/1000, os.Getpid(),
+		atomic.AddUint64(&deliveries, 1), host, size)
+}
+
+// splitFilename separates the unique part of a file name from its flags
+func splitFilename(name string) (basename, flags string) {
+	if i := strings.Index(name, ":2,"); i >= 0 {
+		return name[:i], name[i+3:]
+	}
+	if i := strings.IndexByte(name, ':'); i >= 0 {
+		return name[:i], ""
+	}
+	return name, ""
+}
+
+// joinFilename builds the name of a file in cur/
+func joinFilename(basename, flags string) string {
+	return basename + ":2," + flags
+}
+
+func hasFlag(flags string, flag byte) bool {
+	return strings.IndexByte(flags, flag) >= 0
+}
+
+// setFlag adds or removes a flag and keeps the flags sorted, as the
+// Maildir specification requires
+func setFlag(flags string, flag byte, on bool) string {
+	set := []byte{}
+	for i := 0; i < len(flags); i++ {
+		if flags[i] != flag && !hasFlag(string(set), flags[i]) {
+			set = append(set, flags[i])
+		}
+	}
+	if on {
+		set = append(set, flag)
+	}
+	sort.Slice(set, func(i, j int) bool { return set[i] < set[j] })
+	return string(set)
+}
+
+// folderDirName returns the Maildir++ directory of a folder path such as
+// "Archive/2024": ".Archive.2024". Names are encoded in modified UTF-7 as
+// IMAP servers expect; a dot inside a name is encoded as well so it is not
+// read as a hierarchy separator.
+func folderDirName(path string) string {
+	segments := []string{}
+	for _, segment := range strings.Split(path, "/") {
+		if segment == "" {
+			continue
+		}
+		segments = append(segments, encodeMUTF7(segment))
+	}
+	return "." + strings.Join(segments, ".")
+}
+
+var mutf7 = base64.NewEncoding("ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789+,").WithPadding(base64.NoPadding)
+
+// encodeMUTF7 encodes a mailbox name in the modified UTF-7 of RFC 3501
+func encodeMUTF7(s string) string {
+	var buf strings.Builder
+	run := []rune{}
+	flush := func() {
+		if len(run) == 0 {
+			return
+		}
+		units := utf16.Encode(run)
+		raw := make([]byte, 0, len(units)*2)
+		for _, unit := range units {
+			raw = append(raw, byte(unit>>8), byte(unit))
+		}
+		buf.WriteString("&" + mutf7.EncodeToString(raw) + "-")
+		run = run[:0]
+	}
+
+	for _, r := range s {
+		switch {
+		case r == '&':
+			flush()
+			buf.WriteString("&-")
+		case r >= 0x20 && r <= 0x7e && r != '.' && r != '/':
+			flush()
+			buf.WriteRune(r)
+		default:
+			run = append(run, r)
+		}
+	}
+	flush()
+	return buf.String()
+}
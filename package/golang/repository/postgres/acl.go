@@ -0,0 +1,339 @@
+package postgres
+
+import (
+	"context"
+	"errors"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/skygenesisenterprise/aether-mailer/package/golang/domain"
+	"github.com/skygenesisenterprise/aether-mailer/package/golang/repository"
+)
+
+// GroupRepository stores the user groups of domains in Postgres
+type GroupRepository struct {
+	pool *pgxpool.Pool
+}
+
+// NewGroupRepository creates a group repository backed by the given pool
+func NewGroupRepository(pool *pgxpool.Pool) *GroupRepository {
+	return &GroupRepository{pool: pool}
+}
+
+const groupColumns = `id, domain_id, name, description, created_at, updated_at`
+
+// Create inserts a group; names are unique in a domain
+func (r *GroupRepository) Create(ctx context.Context, group *domain.Group) error {
+	_, err := querierFor(ctx, r.pool).Exec(ctx, `
+		INSERT INTO user_groups (`+groupColumns+`)
+		VALUES ($1, $2, $3, $4, $5, $6)`,
+		group.ID, group.DomainID, group.Name, group.Description, group.CreatedAt, group.UpdatedAt,
+	)
+	return err
+}
+
+// GetByID returns a group, or nil when it does not exist
+func (r *GroupRepository) GetByID(ctx context.Context, id string) (*domain.Group, error) {
+	return r.getOne(ctx, `SELECT `+groupColumns+` FROM user_groups WHERE id = $1`, id)
+}
+
+// GetByName returns the group of a domain with a name, or nil
+func (r *GroupRepository) GetByName(ctx context.Context, domainID, name string) (*domain.Group, error) {
+	return r.getOne(ctx, `SELECT `+groupColumns+` FROM user_groups WHERE domain_id = $1 AND name = $2`,
+		domainID, name)
+}
+
+// Delete removes a group; its members and folder rights are removed by
+// cascade
+func (r *GroupRepository) Delete(ctx context.Context, id string) error {
+	_, err := querierFor(ctx, r.pool).Exec(ctx, `DELETE FROM user_groups WHERE id = $1`, id)
+	return err
+}
+
+// ListByDomain returns the groups of a domain ordered by name
+func (r *GroupRepository) ListByDomain(ctx context.Context, domainID string) ([]*domain.Group, error) {
+	return r.list(ctx, `SELECT `+groupColumns+` FROM user_groups WHERE domain_id = $1 ORDER BY name`, domainID)
+}
+
+// ListByUser returns the groups a user is a member of, ordered by name
+func (r *GroupRepository) ListByUser(ctx context.Context, userID string) ([]*domain.Group, error) {
+	return r.list(ctx, `
+		SELECT g.id, g.domain_id, g.name, g.description, g.created_at, g.updated_at
+		FROM user_groups g JOIN user_group_members m ON m.group_id = g.id
+		WHERE m.user_id = $1 ORDER BY g.name, g.id`, userID)
+}
+
+// AddMember adds a user to a group; adding a member again does nothing
+func (r *GroupRepository) AddMember(ctx context.Context, member *domain.GroupMember) error {
+	_, err := querierFor(ctx, r.pool).Exec(ctx, `
+		INSERT INTO user_group_members (group_id, user_id, added_at)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (group_id, user_id) DO NOTHING`,
+		member.GroupID, member.UserID, member.AddedAt,
+	)
+	return err
+}
+
+// RemoveMember removes a user from a group
+func (r *GroupRepository) RemoveMember(ctx context.Context, groupID, userID string) error {
+	_, err := querierFor(ctx, r.pool).Exec(ctx, `
+		DELETE FROM user_group_members WHERE group_id = $1 AND user_id = $2`, groupID, userID)
+	return err
+}
+
+// ListMembers returns the members of a group in the order they were added
+func (r *GroupRepository) ListMembers(ctx context.Context, groupID string) ([]*domain.GroupMember, error) {
+	rows, err := querierFor(ctx, r.pool).Query(ctx, `
+		SELECT group_id, user_id, added_at FROM user_group_members
+		WHERE group_id = $1 ORDER BY added_at, user_id`, groupID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	members := []*domain.GroupMember{}
+	for rows.Next() {
+		member := &domain.GroupMember{}
+		if err := rows.Scan(&member.GroupID, &member.UserID, &member.AddedAt); err != nil {
+			return nil, err
+		}
+		members = append(members, member)
+	}
+	return members, rows.Err()
+}
+
+func (r *GroupRepository) getOne(ctx context.Context, query string, args ...interface{}) (*domain.Group, error) {
+	group, err := scanGroup(querierFor(ctx, r.pool).QueryRow(ctx, query, args...))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return group, nil
+}
+
+func (r *GroupRepository) list(ctx context.Context, query string, args ...interface{}) ([]*domain.Group, error) {
+	rows, err := querierFor(ctx, r.pool).Query(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	groups := []*domain.Group{}
+	for rows.Next() {
+		group, err := scanGroup(rows)
+		if err != nil {
+			return nil, err
+		}
+		groups = append(groups, group)
+	}
+	return groups, rows.Err()
+}
+
+func scanGroup(row pgx.Row) (*domain.Group, error) {
+	group := &domain.Group{}
+	err := row.Scan(&group.ID, &group.DomainID, &group.Name, &group.Description, &group.CreatedAt, &group.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return group, nil
+}
+
+// FolderACLRepository stores the access control lists of folders in
+// Postgres
+type FolderACLRepository struct {
+	pool *pgxpool.Pool
+}
+
+// NewFolderACLRepository creates a folder ACL repository backed by the
+// given pool
+func NewFolderACLRepository(pool *pgxpool.Pool) *FolderACLRepository {
+	return &FolderACLRepository{pool: pool}
+}
+
+const folderACLColumns = `id, folder_id, account_id, subject_type, COALESCE(user_id::text, group_id::text, ''),
+	rights, granted_by, created_at, updated_at`
+
+// Create inserts an entry; a folder has at most one entry per subject
+func (r *FolderACLRepository) Create(ctx context.Context, acl *domain.FolderACL) error {
+	var userID, groupID *string
+	switch acl.SubjectType {
+	case domain.ACLSubjectUser:
+		userID = &acl.SubjectID
+	case domain.ACLSubjectGroup:
+		groupID = &acl.SubjectID
+	}
+	_, err := querierFor(ctx, r.pool).Exec(ctx, `
+		INSERT INTO folder_acls (id, folder_id, account_id, subject_type, user_id, group_id, rights, granted_by,
+			created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)`,
+		acl.ID, acl.FolderID, acl.AccountID, string(acl.SubjectType), userID, groupID, string(acl.Rights),
+		acl.GrantedBy, acl.CreatedAt, acl.UpdatedAt,
+	)
+	return err
+}
+
+// Get returns the entry of a folder for a subject, or nil
+func (r *FolderACLRepository) Get(ctx context.Context, folderID string, subjectType domain.ACLSubjectType, subjectID string) (*domain.FolderACL, error) {
+	acl, err := scanFolderACL(querierFor(ctx, r.pool).QueryRow(ctx, `
+		SELECT `+folderACLColumns+` FROM folder_acls
+		WHERE folder_id = $1 AND subject_type = $2 AND COALESCE(user_id::text, group_id::text, '') = $3`,
+		folderID, string(subjectType), subjectID))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return acl, nil
+}
+
+// Update saves the rights of an entry
+func (r *FolderACLRepository) Update(ctx context.Context, acl *domain.FolderACL) error {
+	_, err := querierFor(ctx, r.pool).Exec(ctx, `
+		UPDATE folder_acls SET rights = $2, granted_by = $3, updated_at = $4
+		WHERE id = $1`,
+		acl.ID, string(acl.Rights), acl.GrantedBy, acl.UpdatedAt,
+	)
+	return err
+}
+
+// Delete removes an entry
+func (r *FolderACLRepository) Delete(ctx context.Context, id string) error {
+	_, err := querierFor(ctx, r.pool).Exec(ctx, `DELETE FROM folder_acls WHERE id = $1`, id)
+	return err
+}
+
+// ListByFolder returns the entries of a folder, oldest first
+func (r *FolderACLRepository) ListByFolder(ctx context.Context, folderID string) ([]*domain.FolderACL, error) {
+	return r.list(ctx, `
+		SELECT `+folderACLColumns+` FROM folder_acls
+		WHERE folder_id = $1 ORDER BY created_at, id`, folderID)
+}
+
+// ListByAccount returns the entries of the folders of an account, oldest
+// first
+func (r *FolderACLRepository) ListByAccount(ctx context.Context, accountID string) ([]*domain.FolderACL, error) {
+	return r.list(ctx, `
+		SELECT `+folderACLColumns+` FROM folder_acls
+		WHERE account_id = $1 ORDER BY created_at, id`, accountID)
+}
+
+// ListBySubjects returns the entries granting rights to a user, to any of
+// the given groups or to anyone, oldest first
+func (r *FolderACLRepository) ListBySubjects(ctx context.Context, userID string, groupIDs []string) ([]*domain.FolderACL, error) {
+	return r.list(ctx, `
+		SELECT `+folderACLColumns+` FROM folder_acls
+		WHERE user_id = $1 OR group_id::text = ANY($2) OR subject_type = $3
+		ORDER BY created_at, id`, userID, groupIDs, string(domain.ACLSubjectAnyone))
+}
+
+func (r *FolderACLRepository) list(ctx context.Context, query string, args ...interface{}) ([]*domain.FolderACL, error) {
+	rows, err := querierFor(ctx, r.pool).Query(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	acls := []*domain.FolderACL{}
+	for rows.Next() {
+		acl, err := scanFolderACL(rows)
+		if err != nil {
+			return nil, err
+		}
+		acls = append(acls, acl)
+	}
+	return acls, rows.Err()
+}
+
+func scanFolderACL(row pgx.Row) (*domain.FolderACL, error) {
+	acl := &domain.FolderACL{}
+	var subjectType, rights string
+	err := row.Scan(
+		&acl.ID, &acl.FolderID, &acl.AccountID, &subjectType, &acl.SubjectID, &rights, &acl.GrantedBy,
+		&acl.CreatedAt, &acl.UpdatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	acl.SubjectType = domain.ACLSubjectType(subjectType)
+	acl.Rights = domain.Rights(rights)
+	return acl, nil
+}
+
+// ACLAuditLogRepository stores the access control audit log in Postgres.
+// Entries are append-only.
+type ACLAuditLogRepository struct {
+	pool *pgxpool.Pool
+}
+
+// NewACLAuditLogRepository creates an audit log repository backed by the
+// given pool
+func NewACLAuditLogRepository(pool *pgxpool.Pool) *ACLAuditLogRepository {
+	return &ACLAuditLogRepository{pool: pool}
+}
+
+const aclAuditColumns = `id, domain_id, account_id, folder_id, group_id, action, subject_type, subject_id,
+	old_rights, new_rights, actor_id, created_at`
+
+// Record appends an entry
+func (r *ACLAuditLogRepository) Record(ctx context.Context, entry *domain.ACLAuditEntry) error {
+	_, err := querierFor(ctx, r.pool).Exec(ctx, `
+		INSERT INTO acl_audit_log (`+aclAuditColumns+`)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12)`,
+		entry.ID, entry.DomainID, entry.AccountID, entry.FolderID, entry.GroupID, string(entry.Action),
+		string(entry.SubjectType), entry.SubjectID, string(entry.OldRights), string(entry.NewRights),
+		entry.ActorID, entry.CreatedAt,
+	)
+	return err
+}
+
+// List returns the entries matching a filter, newest first
+func (r *ACLAuditLogRepository) List(ctx context.Context, filter repository.ACLAuditFilter) ([]*domain.ACLAuditEntry, error) {
+	c := aclAuditConditions(filter)
+	rows, err := querierFor(ctx, r.pool).Query(ctx, `SELECT `+aclAuditColumns+` FROM acl_audit_log`+c.where()+
+		` ORDER BY created_at DESC, id`+c.page(filter.Limit, filter.Offset), c.args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	entries := []*domain.ACLAuditEntry{}
+	for rows.Next() {
+		entry := &domain.ACLAuditEntry{}
+		var action, subjectType, oldRights, newRights string
+		err := rows.Scan(
+			&entry.ID, &entry.DomainID, &entry.AccountID, &entry.FolderID, &entry.GroupID, &action, &subjectType,
+			&entry.SubjectID, &oldRights, &newRights, &entry.ActorID, &entry.CreatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		entry.Action = domain.ACLAuditAction(action)
+		entry.SubjectType = domain.ACLSubjectType(subjectType)
+		entry.OldRights = domain.Rights(oldRights)
+		entry.NewRights = domain.Rights(newRights)
+		entries = append(entries, entry)
+	}
+	return entries, rows.Err()
+}
+
+// Count returns the number of entries matching a filter
+func (r *ACLAuditLogRepository) Count(ctx context.Context, filter repository.ACLAuditFilter) (int, error) {
+	c := aclAuditConditions(filter)
+	var count int
+	err := querierFor(ctx, r.pool).QueryRow(ctx, `SELECT COUNT(*) FROM acl_audit_log`+c.where(), c.args...).Scan(&count)
+	return count, err
+}
+
+func aclAuditConditions(filter repository.ACLAuditFilter) *conditions {
+	c := &conditions{}
+	if filter.DomainID != "" {
+		c.add("domain_id = ?", filter.DomainID)
+	}
+	if filter.AccountID != "" {
+		c.add("account_id = ?", filter.AccountID)
+	}
+	return c
+}
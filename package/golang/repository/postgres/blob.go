@@ -0,0 +1,113 @@
+package postgres
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/skygenesisenterprise/aether-mailer/package/golang/domain"
+)
+
+// BlobRepository stores blob references in Postgres. Reference changes are
+// single statements so concurrent writers and garbage collection never
+// lose an update.
+type BlobRepository struct {
+	pool *pgxpool.Pool
+}
+
+// NewBlobRepository creates a blob repository backed by the given pool
+func NewBlobRepository(pool *pgxpool.Pool) *BlobRepository {
+	return &BlobRepository{pool: pool}
+}
+
+const blobColumns = `id, sha256, tenant_id, storage_key, size, encrypted, ref_count, created_at, updated_at`
+
+// Create inserts a blob and reports false when another writer stored the
+// same content first
+func (r *BlobRepository) Create(ctx context.Context, blob *domain.Blob) (bool, error) {
+	tag, err := querierFor(ctx, r.pool).Exec(ctx, `
+		INSERT INTO blobs (`+blobColumns+`)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+		ON CONFLICT (id) DO NOTHING`,
+		blob.ID, blob.SHA256, blob.TenantID, blob.StorageKey, blob.Size, blob.Encrypted, blob.RefCount,
+		blob.CreatedAt, blob.UpdatedAt,
+	)
+	if err != nil {
+		return false, err
+	}
+	return tag.RowsAffected() == 1, nil
+}
+
+// GetByID returns a blob, or nil when it does not exist
+func (r *BlobRepository) GetByID(ctx context.Context, id string) (*domain.Blob, error) {
+	blob, err := scanBlob(querierFor(ctx, r.pool).QueryRow(ctx, `SELECT `+blobColumns+` FROM blobs WHERE id = $1`, id))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return blob, nil
+}
+
+// AddRef changes the reference count, never below zero, and reports false
+// when the blob does not exist
+func (r *BlobRepository) AddRef(ctx context.Context, id string, delta int) (bool, error) {
+	tag, err := querierFor(ctx, r.pool).Exec(ctx, `
+		UPDATE blobs SET ref_count = GREATEST(ref_count + $2, 0), updated_at = $3
+		WHERE id = $1`,
+		id, delta, time.Now(),
+	)
+	if err != nil {
+		return false, err
+	}
+	return tag.RowsAffected() == 1, nil
+}
+
+// ListCollectable returns unreferenced blobs untouched since before
+func (r *BlobRepository) ListCollectable(ctx context.Context, before time.Time, limit int) ([]*domain.Blob, error) {
+	rows, err := querierFor(ctx, r.pool).Query(ctx, `
+		SELECT `+blobColumns+` FROM blobs
+		WHERE ref_count = 0 AND updated_at < $1
+		ORDER BY updated_at LIMIT $2`,
+		before, limit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	blobs := []*domain.Blob{}
+	for rows.Next() {
+		blob, err := scanBlob(rows)
+		if err != nil {
+			return nil, err
+		}
+		blobs = append(blobs, blob)
+	}
+	return blobs, rows.Err()
+}
+
+// DeleteUnreferenced deletes a blob only if it is still unreferenced and
+// untouched since before
+func (r *BlobRepository) DeleteUnreferenced(ctx context.Context, id string, before time.Time) (bool, error) {
+	tag, err := querierFor(ctx, r.pool).Exec(ctx, `DELETE FROM blobs WHERE id = $1 AND ref_count = 0 AND updated_at < $2`, id, before)
+	if err != nil {
+		return false, err
+	}
+	return tag.RowsAffected() == 1, nil
+}
+
+func scanBlob(row pgx.Row) (*domain.Blob, error) {
+	blob := &domain.Blob{}
+	err := row.Scan(
+		&blob.ID, &blob.SHA256, &blob.TenantID, &blob.StorageKey, &blob.Size, &blob.Encrypted, &blob.RefCount,
+		&blob.CreatedAt, &blob.UpdatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return blob, nil
+}
@@ -0,0 +1,139 @@
+package postgres
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/skygenesisenterprise/aether-mailer/package/golang/domain"
+)
+
+// DeliveryJobRepository stores the outbound queue in Postgres. Delivery
+// instances share the table and lease the jobs they hold.
+type DeliveryJobRepository struct {
+	pool *pgxpool.Pool
+}
+
+// NewDeliveryJobRepository creates a delivery job repository backed by the
+// given pool
+func NewDeliveryJobRepository(pool *pgxpool.Pool) *DeliveryJobRepository {
+	return &DeliveryJobRepository{pool: pool}
+}
+
+const deliveryJobColumns = `id, message_id, account_id, domain_id, tenant_id, stream, mail_from, recipients, domain,
+	data, attempts, last_error, queued_at, next_attempt_at, locked_until`
+
+// Create queues a job and reports false when the message already has a job
+// for the same recipient domain
+func (r *DeliveryJobRepository) Create(ctx context.Context, job *domain.DeliveryJob) (bool, error) {
+	tag, err := querierFor(ctx, r.pool).Exec(ctx, `
+		INSERT INTO delivery_jobs (`+deliveryJobColumns+`)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15)
+		ON CONFLICT (message_id, domain) WHERE message_id <> '' DO NOTHING`,
+		job.ID, job.MessageID, job.AccountID, job.DomainID, job.TenantID, job.Stream, job.From, job.Recipients,
+		job.Domain, job.Data, job.Attempts, job.LastError, job.QueuedAt, job.NextAttemptAt, job.LockedUntil,
+	)
+	if err != nil {
+		return false, err
+	}
+	return tag.RowsAffected() > 0, nil
+}
+
+// Claim leases up to limit due jobs that are not leased, soonest first.
+// Jobs locked by another instance are skipped.
+func (r *DeliveryJobRepository) Claim(ctx context.Context, owner string, now time.Time, limit int, lease time.Duration) ([]*domain.DeliveryJob, error) {
+	rows, err := querierFor(ctx, r.pool).Query(ctx, `
+		WITH due AS (
+			SELECT id FROM delivery_jobs
+			WHERE next_attempt_at <= $1 AND (locked_until IS NULL OR locked_until <= $1)
+			ORDER BY next_attempt_at, queued_at, id
+			LIMIT $2
+			FOR UPDATE SKIP LOCKED
+		)
+		UPDATE delivery_jobs j SET locked_by = $3, locked_until = $4
+		FROM due
+		WHERE j.id = due.id
+		RETURNING j.id, j.message_id, j.account_id, j.domain_id, j.tenant_id, j.stream, j.mail_from, j.recipients,
+			j.domain, j.data, j.attempts, j.last_error, j.queued_at, j.next_attempt_at, j.locked_until`,
+		now, limit, owner, now.Add(lease),
+	)
+	if err != nil {
+		return nil, err
+	}
+	jobs, err := collectDeliveryJobs(rows)
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(jobs, func(i, j int) bool {
+		if !jobs[i].NextAttemptAt.Equal(jobs[j].NextAttemptAt) {
+			return jobs[i].NextAttemptAt.Before(jobs[j].NextAttemptAt)
+		}
+		if !jobs[i].QueuedAt.Equal(jobs[j].QueuedAt) {
+			return jobs[i].QueuedAt.Before(jobs[j].QueuedAt)
+		}
+		return jobs[i].ID < jobs[j].ID
+	})
+	return jobs, nil
+}
+
+// Extend renews the leases owner still holds on the given jobs
+func (r *DeliveryJobRepository) Extend(ctx context.Context, ids []string, owner string, now time.Time, lease time.Duration) error {
+	_, err := querierFor(ctx, r.pool).Exec(ctx, `
+		UPDATE delivery_jobs SET locked_until = $3 WHERE id = ANY($1) AND locked_by = $2`,
+		ids, owner, now.Add(lease),
+	)
+	return err
+}
+
+// Reschedule stores the state of a job leased by owner after an attempt
+// and releases its lease
+func (r *DeliveryJobRepository) Reschedule(ctx context.Context, job *domain.DeliveryJob, owner string) (bool, error) {
+	tag, err := querierFor(ctx, r.pool).Exec(ctx, `
+		UPDATE delivery_jobs SET recipients = $3, attempts = $4, last_error = $5, next_attempt_at = $6,
+			locked_by = NULL, locked_until = NULL
+		WHERE id = $1 AND locked_by = $2`,
+		job.ID, owner, job.Recipients, job.Attempts, job.LastError, job.NextAttemptAt,
+	)
+	if err != nil {
+		return false, err
+	}
+	return tag.RowsAffected() > 0, nil
+}
+
+// Complete removes a job leased by owner
+func (r *DeliveryJobRepository) Complete(ctx context.Context, id, owner string) (bool, error) {
+	tag, err := querierFor(ctx, r.pool).Exec(ctx, `
+		DELETE FROM delivery_jobs WHERE id = $1 AND locked_by = $2`, id, owner)
+	if err != nil {
+		return false, err
+	}
+	return tag.RowsAffected() > 0, nil
+}
+
+// Count returns the number of queued jobs
+func (r *DeliveryJobRepository) Count(ctx context.Context) (int, error) {
+	var count int
+	err := querierFor(ctx, r.pool).QueryRow(ctx, `SELECT COUNT(*) FROM delivery_jobs`).Scan(&count)
+	return count, err
+}
+
+func collectDeliveryJobs(rows pgx.Rows) ([]*domain.DeliveryJob, error) {
+	defer rows.Close()
+
+	jobs := []*domain.DeliveryJob{}
+	for rows.Next() {
+		job := &domain.DeliveryJob{}
+		err := rows.Scan(
+			&job.ID, &job.MessageID, &job.AccountID, &job.DomainID, &job.TenantID, &job.Stream, &job.From,
+			&job.Recipients, &job.Domain, &job.Data, &job.Attempts, &job.LastError, &job.QueuedAt,
+			&job.NextAttemptAt, &job.LockedUntil,
+		)
+		if err != nil {
+			return nil, err
+		}
+		jobs = append(jobs, job)
+	}
+	return jobs, rows.Err()
+}
@@ -0,0 +1,100 @@
+package postgres
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/skygenesisenterprise/aether-mailer/package/golang/domain"
+)
+
+// DestinationPolicyRepository stores outbound destination policies in
+// Postgres. Backoff durations are stored in milliseconds.
+type DestinationPolicyRepository struct {
+	pool *pgxpool.Pool
+}
+
+// NewDestinationPolicyRepository creates a destination policy repository backed by the given pool
+func NewDestinationPolicyRepository(pool *pgxpool.Pool) *DestinationPolicyRepository {
+	return &DestinationPolicyRepository{pool: pool}
+}
+
+const destinationPolicyColumns = `id, name, mx_pattern, max_connections, max_messages_per_connection,
+	max_messages_per_minute, backoff_ms, max_backoff_ms, is_active, created_at, updated_at`
+
+// Create inserts a destination policy
+func (r *DestinationPolicyRepository) Create(ctx context.Context, policy *domain.DestinationPolicy) error {
+	_, err := querierFor(ctx, r.pool).Exec(ctx, `
+		INSERT INTO destination_policies (`+destinationPolicyColumns+`)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)`,
+		policy.ID, policy.Name, policy.MXPattern, policy.MaxConnections, policy.MaxMessagesPerConnection,
+		policy.MaxMessagesPerMinute, policy.Backoff.Milliseconds(), policy.MaxBackoff.Milliseconds(),
+		policy.IsActive, policy.CreatedAt, policy.UpdatedAt,
+	)
+	return err
+}
+
+// GetByID returns a destination policy, or nil when it does not exist
+func (r *DestinationPolicyRepository) GetByID(ctx context.Context, id string) (*domain.DestinationPolicy, error) {
+	row := querierFor(ctx, r.pool).QueryRow(ctx, `SELECT `+destinationPolicyColumns+` FROM destination_policies WHERE id = $1`, id)
+	return scanDestinationPolicy(row)
+}
+
+// Update saves a destination policy
+func (r *DestinationPolicyRepository) Update(ctx context.Context, policy *domain.DestinationPolicy) error {
+	_, err := querierFor(ctx, r.pool).Exec(ctx, `
+		UPDATE destination_policies
+		SET name = $2, mx_pattern = $3, max_connections = $4, max_messages_per_connection = $5,
+			max_messages_per_minute = $6, backoff_ms = $7, max_backoff_ms = $8, is_active = $9, updated_at = $10
+		WHERE id = $1`,
+		policy.ID, policy.Name, policy.MXPattern, policy.MaxConnections, policy.MaxMessagesPerConnection,
+		policy.MaxMessagesPerMinute, policy.Backoff.Milliseconds(), policy.MaxBackoff.Milliseconds(),
+		policy.IsActive, policy.UpdatedAt,
+	)
+	return err
+}
+
+// Delete removes a destination policy
+func (r *DestinationPolicyRepository) Delete(ctx context.Context, id string) error {
+	_, err := querierFor(ctx, r.pool).Exec(ctx, `DELETE FROM destination_policies WHERE id = $1`, id)
+	return err
+}
+
+// List returns every destination policy ordered by name
+func (r *DestinationPolicyRepository) List(ctx context.Context) ([]*domain.DestinationPolicy, error) {
+	rows, err := querierFor(ctx, r.pool).Query(ctx, `SELECT `+destinationPolicyColumns+` FROM destination_policies ORDER BY name`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	policies := []*domain.DestinationPolicy{}
+	for rows.Next() {
+		policy, err := scanDestinationPolicy(rows)
+		if err != nil {
+			return nil, err
+		}
+		policies = append(policies, policy)
+	}
+	return policies, rows.Err()
+}
+
+func scanDestinationPolicy(row pgx.Row) (*domain.DestinationPolicy, error) {
+	policy := &domain.DestinationPolicy{}
+	var backoffMs, maxBackoffMs int64
+	err := row.Scan(
+		&policy.ID, &policy.Name, &policy.MXPattern, &policy.MaxConnections, &policy.MaxMessagesPerConnection,
+		&policy.MaxMessagesPerMinute, &backoffMs, &maxBackoffMs, &policy.IsActive, &policy.CreatedAt, &policy.UpdatedAt,
+	)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	policy.Backoff = time.Duration(backoffMs) * time.Millisecond
+	policy.MaxBackoff = time.Duration(maxBackoffMs) * time.Millisecond
+	return policy, nil
+}
@@ -0,0 +1,162 @@
+package postgres
+
+import (
+	"context"
+	"errors"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/skygenesisenterprise/aether-mailer/package/golang/domain"
+)
+
+// DKIMKeyRepository stores DKIM keys in Postgres. Private keys are stored
+// as sealed by the service and never decrypted here.
+type DKIMKeyRepository struct {
+	pool *pgxpool.Pool
+}
+
+// NewDKIMKeyRepository creates a DKIM key repository backed by the given pool
+func NewDKIMKeyRepository(pool *pgxpool.Pool) *DKIMKeyRepository {
+	return &DKIMKeyRepository{pool: pool}
+}
+
+const dkimKeyColumns = `id, domain, selector, algorithm, key_bits, public_key, encrypted_private_key, state,
+	created_at, published_at, verified_at, activated_at, retire_after, retired_at, updated_at`
+
+// Create inserts a key
+func (r *DKIMKeyRepository) Create(ctx context.Context, key *domain.DKIMKey) error {
+	_, err := querierFor(ctx, r.pool).Exec(ctx, `
+		INSERT INTO dkim_keys (`+dkimKeyColumns+`)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15)`,
+		key.ID, key.Domain, key.Selector, string(key.Algorithm), key.KeyBits, key.PublicKey, key.EncryptedPrivateKey,
+		string(key.State), key.CreatedAt, key.PublishedAt, key.VerifiedAt, key.ActivatedAt, key.RetireAfter,
+		key.RetiredAt, key.UpdatedAt,
+	)
+	return err
+}
+
+// GetByID returns a key, or nil when it does not exist
+func (r *DKIMKeyRepository) GetByID(ctx context.Context, id string) (*domain.DKIMKey, error) {
+	return r.getOne(ctx, `SELECT `+dkimKeyColumns+` FROM dkim_keys WHERE id = $1`, id)
+}
+
+// GetBySelector returns the key of a domain under a selector, or nil
+func (r *DKIMKeyRepository) GetBySelector(ctx context.Context, domainName, selector string) (*domain.DKIMKey, error) {
+	return r.getOne(ctx, `SELECT `+dkimKeyColumns+` FROM dkim_keys WHERE domain = $1 AND selector = $2`, domainName, selector)
+}
+
+// GetActive returns the signing key of a domain, or nil when it has none
+func (r *DKIMKeyRepository) GetActive(ctx context.Context, domainName string) (*domain.DKIMKey, error) {
+	return r.getOne(ctx, `SELECT `+dkimKeyColumns+` FROM dkim_keys WHERE domain = $1 AND state = $2`,
+		domainName, string(domain.DKIMKeyActive))
+}
+
+// Update saves the state of a key
+func (r *DKIMKeyRepository) Update(ctx context.Context, key *domain.DKIMKey) error {
+	_, err := querierFor(ctx, r.pool).Exec(ctx, `
+		UPDATE dkim_keys SET encrypted_private_key = $2, state = $3, published_at = $4, verified_at = $5,
+			activated_at = $6, retire_after = $7, retired_at = $8, updated_at = $9
+		WHERE id = $1`,
+		key.ID, key.EncryptedPrivateKey, string(key.State), key.PublishedAt, key.VerifiedAt, key.ActivatedAt,
+		key.RetireAfter, key.RetiredAt, key.UpdatedAt,
+	)
+	return err
+}
+
+// ListByDomain returns the keys of a domain, newest first
+func (r *DKIMKeyRepository) ListByDomain(ctx context.Context, domainName string) ([]*domain.DKIMKey, error) {
+	return r.list(ctx, `SELECT `+dkimKeyColumns+` FROM dkim_keys WHERE domain = $1 ORDER BY created_at DESC`, domainName)
+}
+
+// ListByState returns every key in a state, oldest first
+func (r *DKIMKeyRepository) ListByState(ctx context.Context, state domain.DKIMKeyState) ([]*domain.DKIMKey, error) {
+	return r.list(ctx, `SELECT `+dkimKeyColumns+` FROM dkim_keys WHERE state = $1 ORDER BY created_at`, string(state))
+}
+
+func (r *DKIMKeyRepository) getOne(ctx context.Context, query string, args ...interface{}) (*domain.DKIMKey, error) {
+	key, err := scanDKIMKey(querierFor(ctx, r.pool).QueryRow(ctx, query, args...))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+func (r *DKIMKeyRepository) list(ctx context.Context, query string, args ...interface{}) ([]*domain.DKIMKey, error) {
+	rows, err := querierFor(ctx, r.pool).Query(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	keys := []*domain.DKIMKey{}
+	for rows.Next() {
+		key, err := scanDKIMKey(rows)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	return keys, rows.Err()
+}
+
+func scanDKIMKey(row pgx.Row) (*domain.DKIMKey, error) {
+	key := &domain.DKIMKey{}
+	err := row.Scan(
+		&key.ID, &key.Domain, &key.Selector, &key.Algorithm, &key.KeyBits, &key.PublicKey, &key.EncryptedPrivateKey,
+		&key.State, &key.CreatedAt, &key.PublishedAt, &key.VerifiedAt, &key.ActivatedAt, &key.RetireAfter,
+		&key.RetiredAt, &key.UpdatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// DKIMRotationLogRepository stores the DKIM rotation audit log in Postgres.
+// Entries are append-only.
+type DKIMRotationLogRepository struct {
+	pool *pgxpool.Pool
+}
+
+// NewDKIMRotationLogRepository creates a rotation log repository backed by the given pool
+func NewDKIMRotationLogRepository(pool *pgxpool.Pool) *DKIMRotationLogRepository {
+	return &DKIMRotationLogRepository{pool: pool}
+}
+
+// Record appends an entry
+func (r *DKIMRotationLogRepository) Record(ctx context.Context, entry *domain.DKIMRotationEntry) error {
+	_, err := querierFor(ctx, r.pool).Exec(ctx, `
+		INSERT INTO dkim_rotation_log (id, domain, key_id, selector, action, actor, detail, at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)`,
+		entry.ID, entry.Domain, entry.KeyID, entry.Selector, string(entry.Action), entry.Actor, entry.Detail, entry.At,
+	)
+	return err
+}
+
+// ListByDomain returns the latest entries of a domain, newest first
+func (r *DKIMRotationLogRepository) ListByDomain(ctx context.Context, domainName string, limit int) ([]*domain.DKIMRotationEntry, error) {
+	rows, err := querierFor(ctx, r.pool).Query(ctx, `
+		SELECT id, domain, key_id, selector, action, actor, detail, at
+		FROM dkim_rotation_log WHERE domain = $1 ORDER BY at DESC LIMIT $2`,
+		domainName, limit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	entries := []*domain.DKIMRotationEntry{}
+	for rows.Next() {
+		entry := &domain.DKIMRotationEntry{}
+		err := rows.Scan(&entry.ID, &entry.Domain, &entry.KeyID, &entry.Selector, &entry.Action, &entry.Actor,
+			&entry.Detail, &entry.At)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+	return entries, rows.Err()
+}
@@ -0,0 +1,90 @@
+package postgres
+
+import (
+	"context"
+	"errors"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/skygenesisenterprise/aether-mailer/package/golang/domain"
+)
+
+// DNSRecordRepository stores the expected DNS records of domains in Postgres
+type DNSRecordRepository struct {
+	pool *pgxpool.Pool
+}
+
+// NewDNSRecordRepository creates a DNS record repository backed by the given pool
+func NewDNSRecordRepository(pool *pgxpool.Pool) *DNSRecordRepository {
+	return &DNSRecordRepository{pool: pool}
+}
+
+const dnsRecordColumns = `id, domain_id, type, name, value, priority, ttl`
+
+// Create inserts a record
+func (r *DNSRecordRepository) Create(ctx context.Context, record *domain.DNSRecord) error {
+	_, err := querierFor(ctx, r.pool).Exec(ctx, `
+		INSERT INTO dns_records (`+dnsRecordColumns+`)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)`,
+		record.ID, record.DomainID, record.Type, record.Name, record.Value, record.Priority, record.TTL,
+	)
+	return err
+}
+
+// GetByID returns a record, or nil when it does not exist
+func (r *DNSRecordRepository) GetByID(ctx context.Context, id string) (*domain.DNSRecord, error) {
+	record, err := scanDNSRecord(querierFor(ctx, r.pool).QueryRow(ctx, `SELECT `+dnsRecordColumns+` FROM dns_records WHERE id = $1`, id))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return record, nil
+}
+
+// GetByDomainID returns the records of a domain ordered by type and name
+func (r *DNSRecordRepository) GetByDomainID(ctx context.Context, domainID string) ([]*domain.DNSRecord, error) {
+	rows, err := querierFor(ctx, r.pool).Query(ctx, `SELECT `+dnsRecordColumns+` FROM dns_records WHERE domain_id = $1 ORDER BY type, name`,
+		domainID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	records := []*domain.DNSRecord{}
+	for rows.Next() {
+		record, err := scanDNSRecord(rows)
+		if err != nil {
+			return nil, err
+		}
+		records = append(records, record)
+	}
+	return records, rows.Err()
+}
+
+// Update saves a record
+func (r *DNSRecordRepository) Update(ctx context.Context, record *domain.DNSRecord) error {
+	_, err := querierFor(ctx, r.pool).Exec(ctx, `
+		UPDATE dns_records SET type = $2, name = $3, value = $4, priority = $5, ttl = $6
+		WHERE id = $1`,
+		record.ID, record.Type, record.Name, record.Value, record.Priority, record.TTL,
+	)
+	return err
+}
+
+// Delete removes a record
+func (r *DNSRecordRepository) Delete(ctx context.Context, id string) error {
+	_, err := querierFor(ctx, r.pool).Exec(ctx, `DELETE FROM dns_records WHERE id = $1`, id)
+	return err
+}
+
+func scanDNSRecord(row pgx.Row) (*domain.DNSRecord, error) {
+	record := &domain.DNSRecord{}
+	err := row.Scan(&record.ID, &record.DomainID, &record.Type, &record.Name, &record.Value, &record.Priority,
+		&record.TTL)
+	if err != nil {
+		return nil, err
+	}
+	return record, nil
+}
@@ -0,0 +1,229 @@
+package postgres
+
+import (
+	"context"
+	"errors"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/skygenesisenterprise/aether-mailer/package/golang/domain"
+	"github.com/skygenesisenterprise/aether-mailer/package/golang/repository"
+)
+
+// DomainRepository stores mail domains in Postgres
+type DomainRepository struct {
+	pool *pgxpool.Pool
+}
+
+// NewDomainRepository creates a domain repository backed by the given pool
+func NewDomainRepository(pool *pgxpool.Pool) *DomainRepository {
+	return &DomainRepository{pool: pool}
+}
+
+const domainColumns = `id, name, display_name, description, is_active, is_verified, max_users, max_emails_per_day,
+	max_storage_mb, dkim_selector, dkim_public_key, spf_record, dmarc_record, created_at, updated_at, verified_at,
+	owner_id`
+
+// Create inserts a domain
+func (r *DomainRepository) Create(ctx context.Context, d *domain.Domain) error {
+	_, err := querierFor(ctx, r.pool).Exec(ctx, `
+		INSERT INTO domains (`+domainColumns+`)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17)`,
+		d.ID, d.Name, d.DisplayName, d.Description, d.IsActive, d.IsVerified, d.MaxUsers, d.MaxEmailsPerDay,
+		d.MaxStorageMB, d.DKIMSelector, d.DKIMPublicKey, d.SPFRecord, d.DMARCRecord, d.CreatedAt, d.UpdatedAt,
+		d.VerifiedAt, d.OwnerID,
+	)
+	return err
+}
+
+// GetByID returns a domain, or nil when it does not exist
+func (r *DomainRepository) GetByID(ctx context.Context, id string) (*domain.Domain, error) {
+	return r.getOne(ctx, `SELECT `+domainColumns+` FROM domains WHERE id = $1`, id)
+}
+
+// GetByName returns a domain by name, or nil when it does not exist
+func (r *DomainRepository) GetByName(ctx context.Context, name string) (*domain.Domain, error) {
+	return r.getOne(ctx, `SELECT `+domainColumns+` FROM domains WHERE name = $1`, name)
+}
+
+// Update saves a domain
+func (r *DomainRepository) Update(ctx context.Context, d *domain.Domain) error {
+	_, err := querierFor(ctx, r.pool).Exec(ctx, `
+		UPDATE domains SET name = $2, display_name = $3, description = $4, is_active = $5, is_verified = $6,
+			max_users = $7, max_emails_per_day = $8, max_storage_mb = $9, dkim_selector = $10,
+			dkim_public_key = $11, spf_record = $12, dmarc_record = $13, updated_at = $14, verified_at = $15,
+			owner_id = $16
+		WHERE id = $1`,
+		d.ID, d.Name, d.DisplayName, d.Description, d.IsActive, d.IsVerified, d.MaxUsers, d.MaxEmailsPerDay,
+		d.MaxStorageMB, d.DKIMSelector, d.DKIMPublicKey, d.SPFRecord, d.DMARCRecord, d.UpdatedAt, d.VerifiedAt,
+		d.OwnerID,
+	)
+	return err
+}
+
+// Delete removes a domain; its members, accounts, aliases and DNS records
+// are removed by cascade
+func (r *DomainRepository) Delete(ctx context.Context, id string) error {
+	_, err := querierFor(ctx, r.pool).Exec(ctx, `DELETE FROM domains WHERE id = $1`, id)
+	return err
+}
+
+// List returns the domains matching a filter ordered by name
+func (r *DomainRepository) List(ctx context.Context, filter repository.DomainFilter) ([]*domain.Domain, error) {
+	c := domainConditions(filter)
+	query := `SELECT ` + domainColumns + ` FROM domains` + c.where() + ` ORDER BY name` + c.page(filter.Limit, filter.Offset)
+	rows, err := querierFor(ctx, r.pool).Query(ctx, query, c.args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	domains := []*domain.Domain{}
+	for rows.Next() {
+		d, err := scanDomain(rows)
+		if err != nil {
+			return nil, err
+		}
+		domains = append(domains, d)
+	}
+	return domains, rows.Err()
+}
+
+// Count returns the number of domains matching a filter
+func (r *DomainRepository) Count(ctx context.Context, filter repository.DomainFilter) (int, error) {
+	c := domainConditions(filter)
+	var count int
+	err := querierFor(ctx, r.pool).QueryRow(ctx, `SELECT COUNT(*) FROM domains`+c.where(), c.args...).Scan(&count)
+	return count, err
+}
+
+func (r *DomainRepository) getOne(ctx context.Context, query string, args ...interface{}) (*domain.Domain, error) {
+	d, err := scanDomain(querierFor(ctx, r.pool).QueryRow(ctx, query, args...))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+func domainConditions(filter repository.DomainFilter) *conditions {
+	c := &conditions{}
+	if filter.OwnerID != nil {
+		c.add("owner_id = ?", *filter.OwnerID)
+	}
+	if filter.IsActive != nil {
+		c.add("is_active = ?", *filter.IsActive)
+	}
+	if filter.IsVerified != nil {
+		c.add("is_verified = ?", *filter.IsVerified)
+	}
+	return c
+}
+
+func scanDomain(row pgx.Row) (*domain.Domain, error) {
+	d := &domain.Domain{}
+	err := row.Scan(
+		&d.ID, &d.Name, &d.DisplayName, &d.Description, &d.IsActive, &d.IsVerified, &d.MaxUsers,
+		&d.MaxEmailsPerDay, &d.MaxStorageMB, &d.DKIMSelector, &d.DKIMPublicKey, &d.SPFRecord, &d.DMARCRecord,
+		&d.CreatedAt, &d.UpdatedAt, &d.VerifiedAt, &d.OwnerID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+// DomainMemberRepository stores domain memberships in Postgres
+type DomainMemberRepository struct {
+	pool *pgxpool.Pool
+}
+
+// NewDomainMemberRepository creates a domain member repository backed by the given pool
+func NewDomainMemberRepository(pool *pgxpool.Pool) *DomainMemberRepository {
+	return &DomainMemberRepository{pool: pool}
+}
+
+const domainMemberColumns = `id, user_id, domain_id, role, joined_at`
+
+// Create inserts a membership
+func (r *DomainMemberRepository) Create(ctx context.Context, member *domain.DomainMember) error {
+	_, err := querierFor(ctx, r.pool).Exec(ctx, `
+		INSERT INTO domain_members (`+domainMemberColumns+`)
+		VALUES ($1, $2, $3, $4, $5)`,
+		member.ID, member.UserID, member.DomainID, string(member.Role), member.JoinedAt,
+	)
+	return err
+}
+
+// GetByID returns a membership, or nil when it does not exist
+func (r *DomainMemberRepository) GetByID(ctx context.Context, id string) (*domain.DomainMember, error) {
+	return r.getOne(ctx, `SELECT `+domainMemberColumns+` FROM domain_members WHERE id = $1`, id)
+}
+
+// GetByUserAndDomain returns the membership of a user in a domain, or nil
+func (r *DomainMemberRepository) GetByUserAndDomain(ctx context.Context, userID, domainID string) (*domain.DomainMember, error) {
+	return r.getOne(ctx, `SELECT `+domainMemberColumns+` FROM domain_members WHERE user_id = $1 AND domain_id = $2`,
+		userID, domainID)
+}
+
+// Update saves the role of a membership
+func (r *DomainMemberRepository) Update(ctx context.Context, member *domain.DomainMember) error {
+	_, err := querierFor(ctx, r.pool).Exec(ctx, `UPDATE domain_members SET role = $2 WHERE id = $1`, member.ID, string(member.Role))
+	return err
+}
+
+// Delete removes a membership
+func (r *DomainMemberRepository) Delete(ctx context.Context, id string) error {
+	_, err := querierFor(ctx, r.pool).Exec(ctx, `DELETE FROM domain_members WHERE id = $1`, id)
+	return err
+}
+
+// ListByDomain returns the members of a domain in joining order
+func (r *DomainMemberRepository) ListByDomain(ctx context.Context, domainID string) ([]*domain.DomainMember, error) {
+	return r.list(ctx, `SELECT `+domainMemberColumns+` FROM domain_members WHERE domain_id = $1 ORDER BY joined_at`, domainID)
+}
+
+// ListByUser returns the memberships of a user in joining order
+func (r *DomainMemberRepository) ListByUser(ctx context.Context, userID string) ([]*domain.DomainMember, error) {
+	return r.list(ctx, `SELECT `+domainMemberColumns+` FROM domain_members WHERE user_id = $1 ORDER BY joined_at`, userID)
+}
+
+func (r *DomainMemberRepository) getOne(ctx context.Context, query string, args ...interface{}) (*domain.DomainMember, error) {
+	member, err := scanDomainMember(querierFor(ctx, r.pool).QueryRow(ctx, query, args...))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return member, nil
+}
+
+func (r *DomainMemberRepository) list(ctx context.Context, query string, args ...interface{}) ([]*domain.DomainMember, error) {
+	rows, err := querierFor(ctx, r.pool).Query(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	members := []*domain.DomainMember{}
+	for rows.Next() {
+		member, err := scanDomainMember(rows)
+		if err != nil {
+			return nil, err
+		}
+		members = append(members, member)
+	}
+	return members, rows.Err()
+}
+
+func scanDomainMember(row pgx.Row) (*domain.DomainMember, error) {
+	member := &domain.DomainMember{}
+	err := row.Scan(&member.ID, &member.UserID, &member.DomainID, &member.Role, &member.JoinedAt)
+	if err != nil {
+		return nil, err
+	}
+	return member, nil
+}
@@ -0,0 +1,149 @@
+package postgres
+
+import (
+	"context"
+	"errors"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/skygenesisenterprise/aether-mailer/package/golang/domain"
+	"github.com/skygenesisenterprise/aether-mailer/package/golang/repository"
+)
+
+// EmailAccountRepository stores email accounts in Postgres
+type EmailAccountRepository struct {
+	pool *pgxpool.Pool
+}
+
+// NewEmailAccountRepository creates an email account repository backed by the given pool
+func NewEmailAccountRepository(pool *pgxpool.Pool) *EmailAccountRepository {
+	return &EmailAccountRepository{pool: pool}
+}
+
+const emailAccountColumns = `id, user_id, domain_id, email, display_name, password_hash, is_active, is_verified,
+	quota_mb, used_mb, created_at, updated_at, last_login_at`
+
+// emailAccountFields selects emailAccountColumns; shared mailboxes have no
+// user
+const emailAccountFields = `id, COALESCE(user_id::text, ''), domain_id, email, display_name, password_hash, is_active,
+	is_verified, quota_mb, used_mb, created_at, updated_at, last_login_at`
+
+// Create inserts an account
+func (r *EmailAccountRepository) Create(ctx context.Context, account *domain.EmailAccount) error {
+	_, err := querierFor(ctx, r.pool).Exec(ctx, `
+		INSERT INTO email_accounts (`+emailAccountColumns+`)
+		VALUES ($1, NULLIF($2, '')::uuid, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13)`,
+		account.ID, account.UserID, account.DomainID, account.Email, account.DisplayName, account.PasswordHash,
+		account.IsActive, account.IsVerified, account.QuotaMB, account.UsedMB, account.CreatedAt,
+		account.UpdatedAt, account.LastLoginAt,
+	)
+	return err
+}
+
+// GetByID returns an account, or nil when it does not exist
+func (r *EmailAccountRepository) GetByID(ctx context.Context, id string) (*domain.EmailAccount, error) {
+	return r.getOne(ctx, `SELECT `+emailAccountFields+` FROM email_accounts WHERE id = $1`, id)
+}
+
+// GetByEmail returns the account of an address, or nil
+func (r *EmailAccountRepository) GetByEmail(ctx context.Context, email string) (*domain.EmailAccount, error) {
+	return r.getOne(ctx, `SELECT `+emailAccountFields+` FROM email_accounts WHERE email = $1`, email)
+}
+
+// Update saves an account
+func (r *EmailAccountRepository) Update(ctx context.Context, account *domain.EmailAccount) error {
+	_, err := querierFor(ctx, r.pool).Exec(ctx, `
+		UPDATE email_accounts SET user_id = NULLIF($2, '')::uuid, domain_id = $3, email = $4, display_name = $5,
+			password_hash = $6, is_active = $7, is_verified = $8, quota_mb = $9, used_mb = $10,
+			updated_at = $11, last_login_at = $12
+		WHERE id = $1`,
+		account.ID, account.UserID, account.DomainID, account.Email, account.DisplayName, account.PasswordHash,
+		account.IsActive, account.IsVerified, account.QuotaMB, account.UsedMB, account.UpdatedAt,
+		account.LastLoginAt,
+	)
+	return err
+}
+
+// Delete removes an account; its folders and messages are removed by cascade
+func (r *EmailAccountRepository) Delete(ctx context.Context, id string) error {
+	_, err := querierFor(ctx, r.pool).Exec(ctx, `DELETE FROM email_accounts WHERE id = $1`, id)
+	return err
+}
+
+// List returns the accounts matching a filter ordered by address
+func (r *EmailAccountRepository) List(ctx context.Context, filter repository.EmailAccountFilter) ([]*domain.EmailAccount, error) {
+	c := emailAccountConditions(filter)
+	query := `SELECT ` + emailAccountFields + ` FROM email_accounts` + c.where() + ` ORDER BY email` +
+		c.page(filter.Limit, filter.Offset)
+	rows, err := querierFor(ctx, r.pool).Query(ctx, query, c.args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	accounts := []*domain.EmailAccount{}
+	for rows.Next() {
+		account, err := scanEmailAccount(rows)
+		if err != nil {
+			return nil, err
+		}
+		accounts = append(accounts, account)
+	}
+	return accounts, rows.Err()
+}
+
+// Count returns the number of accounts matching a filter
+func (r *EmailAccountRepository) Count(ctx context.Context, filter repository.EmailAccountFilter) (int, error) {
+	c := emailAccountConditions(filter)
+	var count int
+	err := querierFor(ctx, r.pool).QueryRow(ctx, `SELECT COUNT(*) FROM email_accounts`+c.where(), c.args...).Scan(&count)
+	return count, err
+}
+
+func (r *EmailAccountRepository) getOne(ctx context.Context, query string, args ...interface{}) (*domain.EmailAccount, error) {
+	account, err := scanEmailAccount(querierFor(ctx, r.pool).QueryRow(ctx, query, args...))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return account, nil
+}
+
+func emailAccountConditions(filter repository.EmailAccountFilter) *conditions {
+	c := &conditions{}
+	if filter.UserID != nil {
+		c.add("user_id = ?", *filter.UserID)
+	}
+	if filter.DomainID != nil {
+		c.add("domain_id = ?", *filter.DomainID)
+	}
+	if filter.IsActive != nil {
+		c.add("is_active = ?", *filter.IsActive)
+	}
+	if filter.IsVerified != nil {
+		c.add("is_verified = ?", *filter.IsVerified)
+	}
+	if filter.IsShared != nil {
+		if *filter.IsShared {
+			c.add("user_id IS NULL")
+		} else {
+			c.add("user_id IS NOT NULL")
+		}
+	}
+	return c
+}
+
+func scanEmailAccount(row pgx.Row) (*domain.EmailAccount, error) {
+	account := &domain.EmailAccount{}
+	err := row.Scan(
+		&account.ID, &account.UserID, &account.DomainID, &account.Email, &account.DisplayName,
+		&account.PasswordHash, &account.IsActive, &account.IsVerified, &account.QuotaMB, &account.UsedMB,
+		&account.CreatedAt, &account.UpdatedAt, &account.LastLoginAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return account, nil
+}
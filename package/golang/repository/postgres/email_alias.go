@@ -0,0 +1,99 @@
+package postgres
+
+import (
+	"context"
+	"errors"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/skygenesisenterprise/aether-mailer/package/golang/domain"
+)
+
+// EmailAliasRepository stores email aliases in Postgres
+type EmailAliasRepository struct {
+	pool *pgxpool.Pool
+}
+
+// NewEmailAliasRepository creates an email alias repository backed by the given pool
+func NewEmailAliasRepository(pool *pgxpool.Pool) *EmailAliasRepository {
+	return &EmailAliasRepository{pool: pool}
+}
+
+const emailAliasColumns = `id, domain_id, alias, dest_email, is_active, created_at, updated_at`
+
+// Create inserts an alias
+func (r *EmailAliasRepository) Create(ctx context.Context, alias *domain.EmailAlias) error {
+	_, err := querierFor(ctx, r.pool).Exec(ctx, `
+		INSERT INTO email_aliases (`+emailAliasColumns+`)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)`,
+		alias.ID, alias.DomainID, alias.Alias, alias.DestEmail, alias.IsActive, alias.CreatedAt, alias.UpdatedAt,
+	)
+	return err
+}
+
+// GetByID returns an alias, or nil when it does not exist
+func (r *EmailAliasRepository) GetByID(ctx context.Context, id string) (*domain.EmailAlias, error) {
+	return r.getOne(ctx, `SELECT `+emailAliasColumns+` FROM email_aliases WHERE id = $1`, id)
+}
+
+// GetByAlias returns the alias of an address, or nil
+func (r *EmailAliasRepository) GetByAlias(ctx context.Context, alias string) (*domain.EmailAlias, error) {
+	return r.getOne(ctx, `SELECT `+emailAliasColumns+` FROM email_aliases WHERE alias = $1`, alias)
+}
+
+// GetByDomainID returns the aliases of a domain ordered by address
+func (r *EmailAliasRepository) GetByDomainID(ctx context.Context, domainID string) ([]*domain.EmailAlias, error) {
+	rows, err := querierFor(ctx, r.pool).Query(ctx, `SELECT `+emailAliasColumns+` FROM email_aliases WHERE domain_id = $1 ORDER BY alias`,
+		domainID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	aliases := []*domain.EmailAlias{}
+	for rows.Next() {
+		alias, err := scanEmailAlias(rows)
+		if err != nil {
+			return nil, err
+		}
+		aliases = append(aliases, alias)
+	}
+	return aliases, rows.Err()
+}
+
+// Update saves an alias
+func (r *EmailAliasRepository) Update(ctx context.Context, alias *domain.EmailAlias) error {
+	_, err := querierFor(ctx, r.pool).Exec(ctx, `
+		UPDATE email_aliases SET alias = $2, dest_email = $3, is_active = $4, updated_at = $5
+		WHERE id = $1`,
+		alias.ID, alias.Alias, alias.DestEmail, alias.IsActive, alias.UpdatedAt,
+	)
+	return err
+}
+
+// Delete removes an alias
+func (r *EmailAliasRepository) Delete(ctx context.Context, id string) error {
+	_, err := querierFor(ctx, r.pool).Exec(ctx, `DELETE FROM email_aliases WHERE id = $1`, id)
+	return err
+}
+
+func (r *EmailAliasRepository) getOne(ctx context.Context, query string, args ...interface{}) (*domain.EmailAlias, error) {
+	alias, err := scanEmailAlias(querierFor(ctx, r.pool).QueryRow(ctx, query, args...))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return alias, nil
+}
+
+func scanEmailAlias(row pgx.Row) (*domain.EmailAlias, error) {
+	alias := &domain.EmailAlias{}
+	err := row.Scan(&alias.ID, &alias.DomainID, &alias.Alias, &alias.DestEmail, &alias.IsActive, &alias.CreatedAt,
+		&alias.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return alias, nil
+}
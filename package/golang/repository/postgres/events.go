@@ -0,0 +1,245 @@
+package postgres
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/skygenesisenterprise/aether-mailer/package/golang/domain"
+)
+
+// OutboxRepository stores the event outbox in Postgres. Entries added with
+// the context of a Transactor commit or roll back with the state change.
+type OutboxRepository struct {
+	pool *pgxpool.Pool
+}
+
+// NewOutboxRepository creates an outbox repository backed by the given pool
+func NewOutboxRepository(pool *pgxpool.Pool) *OutboxRepository {
+	return &OutboxRepository{pool: pool}
+}
+
+const outboxColumns = `e.id, e.aggregate_id, e.event_type, e.payload, e.occurred_at,
+	o.status, o.attempts, o.next_attempt_at, o.last_error, o.created_at, o.delivered_at`
+
+// Add appends the entries to the event log and queues them for delivery.
+// Entries already added are skipped.
+func (r *OutboxRepository) Add(ctx context.Context, entries []*domain.OutboxEntry) error {
+	return pgx.BeginFunc(ctx, querierFor(ctx, r.pool), func(tx pgx.Tx) error {
+		for _, entry := range entries {
+			if _, err := tx.Exec(ctx, `
+				INSERT INTO events (id, aggregate_id, event_type, payload, occurred_at)
+				VALUES ($1, $2, $3, $4, $5)
+				ON CONFLICT (id) DO NOTHING`,
+				entry.ID, entry.AggregateID, entry.EventType, entry.Payload, entry.OccurredAt,
+			); err != nil {
+				return err
+			}
+			if _, err := tx.Exec(ctx, `
+				INSERT INTO event_outbox (event_id, status, attempts, next_attempt_at, last_error, created_at, delivered_at)
+				VALUES ($1, $2, $3, $4, $5, $6, $7)
+				ON CONFLICT (event_id) DO NOTHING`,
+				entry.ID, entry.Status, entry.Attempts, entry.NextAttemptAt, entry.LastError, entry.CreatedAt, entry.DeliveredAt,
+			); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Claim leases up to limit pending entries due at now, oldest first.
+// Entries locked by another relay are skipped.
+func (r *OutboxRepository) Claim(ctx context.Context, now time.Time, limit int, lease time.Duration) ([]*domain.OutboxEntry, error) {
+	rows, err := querierFor(ctx, r.pool).Query(ctx, `
+		WITH due AS (
+			SELECT event_id FROM event_outbox
+			WHERE status = 'PENDING' AND next_attempt_at <= $1
+			ORDER BY next_attempt_at, created_at
+			LIMIT $2
+			FOR UPDATE SKIP LOCKED
+		)
+		UPDATE event_outbox o SET attempts = o.attempts + 1, next_attempt_at = $3
+		FROM due, events e
+		WHERE o.event_id = due.event_id AND e.id = o.event_id
+		RETURNING `+outboxColumns,
+		now, limit, now.Add(lease),
+	)
+	if err != nil {
+		return nil, err
+	}
+	entries, err := collectOutboxEntries(rows)
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		if !entries[i].CreatedAt.Equal(entries[j].CreatedAt) {
+			return entries[i].CreatedAt.Before(entries[j].CreatedAt)
+		}
+		return entries[i].ID < entries[j].ID
+	})
+	return entries, nil
+}
+
+// MarkDelivered records the delivery of an entry
+func (r *OutboxRepository) MarkDelivered(ctx context.Context, id string, at time.Time) error {
+	_, err := querierFor(ctx, r.pool).Exec(ctx, `
+		UPDATE event_outbox SET status = 'DELIVERED', delivered_at = $2, last_error = ''
+		WHERE event_id = $1`,
+		id, at,
+	)
+	return err
+}
+
+// MarkFailed records a failed attempt and when to try again
+func (r *OutboxRepository) MarkFailed(ctx context.Context, id string, lastError string, nextAttemptAt time.Time) error {
+	_, err := querierFor(ctx, r.pool).Exec(ctx, `
+		UPDATE event_outbox SET last_error = $2, next_attempt_at = $3
+		WHERE event_id = $1 AND status = 'PENDING'`,
+		id, lastError, nextAttemptAt,
+	)
+	return err
+}
+
+// MarkDead moves an entry to the dead letters
+func (r *OutboxRepository) MarkDead(ctx context.Context, id string, lastError string) error {
+	_, err := querierFor(ctx, r.pool).Exec(ctx, `
+		UPDATE event_outbox SET status = 'DEAD', last_error = $2
+		WHERE event_id = $1 AND status = 'PENDING'`,
+		id, lastError,
+	)
+	return err
+}
+
+// ListDead returns the dead letters, newest first
+func (r *OutboxRepository) ListDead(ctx context.Context, limit, offset int) ([]*domain.OutboxEntry, error) {
+	c := &conditions{}
+	c.add(`o.status = ?`, domain.OutboxStatusDead)
+	query := `
+		SELECT ` + outboxColumns + `
+		FROM event_outbox o JOIN events e ON e.id = o.event_id` + c.where() + `
+		ORDER BY o.created_at DESC, o.event_id` + c.page(limit, offset)
+	rows, err := querierFor(ctx, r.pool).Query(ctx, query, c.args...)
+	if err != nil {
+		return nil, err
+	}
+	return collectOutboxEntries(rows)
+}
+
+// Requeue returns a dead letter to the pending entries with a fresh attempt
+// count and reports false when no dead letter has the ID
+func (r *OutboxRepository) Requeue(ctx context.Context, id string, at time.Time) (bool, error) {
+	tag, err := querierFor(ctx, r.pool).Exec(ctx, `
+		UPDATE event_outbox SET status = 'PENDING', attempts = 0, next_attempt_at = $2
+		WHERE event_id = $1 AND status = 'DEAD'`,
+		id, at,
+	)
+	if err != nil {
+		return false, err
+	}
+	return tag.RowsAffected() == 1, nil
+}
+
+// DeleteDelivered removes the entries delivered before a time. The events
+// stay in the event log.
+func (r *OutboxRepository) DeleteDelivered(ctx context.Context, before time.Time) (int, error) {
+	tag, err := querierFor(ctx, r.pool).Exec(ctx, `
+		DELETE FROM event_outbox WHERE status = 'DELIVERED' AND delivered_at < $1`,
+		before,
+	)
+	if err != nil {
+		return 0, err
+	}
+	return int(tag.RowsAffected()), nil
+}
+
+func collectOutboxEntries(rows pgx.Rows) ([]*domain.OutboxEntry, error) {
+	defer rows.Close()
+
+	entries := []*domain.OutboxEntry{}
+	for rows.Next() {
+		entry := &domain.OutboxEntry{}
+		err := rows.Scan(
+			&entry.ID, &entry.AggregateID, &entry.EventType, &entry.Payload, &entry.OccurredAt,
+			&entry.Status, &entry.Attempts, &entry.NextAttemptAt, &entry.LastError, &entry.CreatedAt, &entry.DeliveredAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+	return entries, rows.Err()
+}
+
+// EventStore reads the event log written by the outbox. Events come back
+// with their data as a json.RawMessage.
+type EventStore struct {
+	pool *pgxpool.Pool
+}
+
+// NewEventStore creates an event store backed by the given pool
+func NewEventStore(pool *pgxpool.Pool) *EventStore {
+	return &EventStore{pool: pool}
+}
+
+// Save appends an event to the log without queueing it for delivery. An
+// event already saved is skipped.
+func (s *EventStore) Save(ctx context.Context, event domain.Event) error {
+	payload, err := json.Marshal(event.Data())
+	if err != nil {
+		return fmt.Errorf("failed to encode event %s: %w", event.ID(), err)
+	}
+	_, err = querierFor(ctx, s.pool).Exec(ctx, `
+		INSERT INTO events (id, aggregate_id, event_type, payload, occurred_at)
+		VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (id) DO NOTHING`,
+		event.ID(), event.AggregateID(), event.EventType(), payload, event.OccurredAt(),
+	)
+	return err
+}
+
+// GetByAggregateID returns the events of an aggregate, newest first. A limit
+// of zero returns them all.
+func (s *EventStore) GetByAggregateID(ctx context.Context, aggregateID string, limit int) ([]domain.Event, error) {
+	c := &conditions{}
+	c.add(`aggregate_id = ?`, aggregateID)
+	return s.list(ctx, c, limit)
+}
+
+// GetByEventType returns the events of a type, newest first. A limit of
+// zero returns them all.
+func (s *EventStore) GetByEventType(ctx context.Context, eventType string, limit int) ([]domain.Event, error) {
+	c := &conditions{}
+	c.add(`event_type = ?`, eventType)
+	return s.list(ctx, c, limit)
+}
+
+func (s *EventStore) list(ctx context.Context, c *conditions, limit int) ([]domain.Event, error) {
+	query := `
+		SELECT id, aggregate_id, event_type, payload, occurred_at
+		FROM events` + c.where() + `
+		ORDER BY occurred_at DESC, seq DESC` + c.page(limit, 0)
+	rows, err := querierFor(ctx, s.pool).Query(ctx, query, c.args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	events := []domain.Event{}
+	for rows.Next() {
+		var (
+			id, aggregateID, eventType string
+			payload                    []byte
+			occurredAt                 time.Time
+		)
+		if err := rows.Scan(&id, &aggregateID, &eventType, &payload, &occurredAt); err != nil {
+			return nil, err
+		}
+		events = append(events, domain.RestoreBaseEvent(id, aggregateID, eventType, occurredAt, json.RawMessage(payload)))
+	}
+	return events, rows.Err()
+}
@@ -0,0 +1,106 @@
+package postgres
+
+import (
+	"context"
+	"errors"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/skygenesisenterprise/aether-mailer/package/golang/domain"
+)
+
+// FolderRepository stores mailbox folders in Postgres
+type FolderRepository struct {
+	pool *pgxpool.Pool
+}
+
+// NewFolderRepository creates a folder repository backed by the given pool
+func NewFolderRepository(pool *pgxpool.Pool) *FolderRepository {
+	return &FolderRepository{pool: pool}
+}
+
+const folderColumns = `id, account_id, parent_id, name, path, type, is_subscribed, created_at, updated_at`
+
+// Create inserts a folder
+func (r *FolderRepository) Create(ctx context.Context, folder *domain.Folder) error {
+	_, err := querierFor(ctx, r.pool).Exec(ctx, `
+		INSERT INTO folders (`+folderColumns+`)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)`,
+		folder.ID, folder.AccountID, folder.ParentID, folder.Name, folder.Path, string(folder.Type),
+		folder.IsSubscribed, folder.CreatedAt, folder.UpdatedAt,
+	)
+	return err
+}
+
+// GetByID returns a folder, or nil when it does not exist
+func (r *FolderRepository) GetByID(ctx context.Context, id string) (*domain.Folder, error) {
+	return r.getOne(ctx, `SELECT `+folderColumns+` FROM folders WHERE id = $1`, id)
+}
+
+// GetByType returns the first folder of a type in an account, or nil
+func (r *FolderRepository) GetByType(ctx context.Context, accountID string, folderType domain.FolderType) (*domain.Folder, error) {
+	return r.getOne(ctx, `
+		SELECT `+folderColumns+` FROM folders WHERE account_id = $1 AND type = $2
+		ORDER BY created_at LIMIT 1`,
+		accountID, string(folderType),
+	)
+}
+
+// ListByAccount returns the folders of an account ordered by path
+func (r *FolderRepository) ListByAccount(ctx context.Context, accountID string) ([]*domain.Folder, error) {
+	rows, err := querierFor(ctx, r.pool).Query(ctx, `SELECT `+folderColumns+` FROM folders WHERE account_id = $1 ORDER BY path`, accountID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	folders := []*domain.Folder{}
+	for rows.Next() {
+		folder, err := scanFolder(rows)
+		if err != nil {
+			return nil, err
+		}
+		folders = append(folders, folder)
+	}
+	return folders, rows.Err()
+}
+
+// Update saves a folder
+func (r *FolderRepository) Update(ctx context.Context, folder *domain.Folder) error {
+	_, err := querierFor(ctx, r.pool).Exec(ctx, `
+		UPDATE folders SET parent_id = $2, name = $3, path = $4, type = $5, is_subscribed = $6, updated_at = $7
+		WHERE id = $1`,
+		folder.ID, folder.ParentID, folder.Name, folder.Path, string(folder.Type), folder.IsSubscribed,
+		folder.UpdatedAt,
+	)
+	return err
+}
+
+// Delete removes a folder; its subfolders are removed by cascade
+func (r *FolderRepository) Delete(ctx context.Context, id string) error {
+	_, err := querierFor(ctx, r.pool).Exec(ctx, `DELETE FROM folders WHERE id = $1`, id)
+	return err
+}
+
+func (r *FolderRepository) getOne(ctx context.Context, query string, args ...interface{}) (*domain.Folder, error) {
+	folder, err := scanFolder(querierFor(ctx, r.pool).QueryRow(ctx, query, args...))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return folder, nil
+}
+
+func scanFolder(row pgx.Row) (*domain.Folder, error) {
+	folder := &domain.Folder{}
+	err := row.Scan(
+		&folder.ID, &folder.AccountID, &folder.ParentID, &folder.Name, &folder.Path, &folder.Type,
+		&folder.IsSubscribed, &folder.CreatedAt, &folder.UpdatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return folder, nil
+}
@@ -0,0 +1,265 @@
+package postgres
+
+import (
+	"context"
+	"errors"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/skygenesisenterprise/aether-mailer/package/golang/domain"
+	"github.com/skygenesisenterprise/aether-mailer/package/golang/repository"
+)
+
+// IdentityRepository stores the addresses accounts send as in Postgres
+type IdentityRepository struct {
+	pool *pgxpool.Pool
+}
+
+// NewIdentityRepository creates an identity repository backed by the given
+// pool
+func NewIdentityRepository(pool *pgxpool.Pool) *IdentityRepository {
+	return &IdentityRepository{pool: pool}
+}
+
+const identityColumns = `id, account_id, domain_id, email, name, reply_to, signature_text, signature_html,
+	is_default, status, reviewed_by, reviewed_at, created_at, updated_at`
+
+// Create inserts an identity. An account has an address at most once and
+// at most one default identity.
+func (r *IdentityRepository) Create(ctx context.Context, identity *domain.Identity) error {
+	_, err := querierFor(ctx, r.pool).Exec(ctx, `
+		INSERT INTO identities (`+identityColumns+`)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14)`,
+		identity.ID, identity.AccountID, identity.DomainID, identity.Email, identity.Name, identity.ReplyTo,
+		identity.SignatureText, identity.SignatureHTML, identity.IsDefault, string(identity.Status),
+		identity.ReviewedBy, identity.ReviewedAt, identity.CreatedAt, identity.UpdatedAt,
+	)
+	return err
+}
+
+// GetByID returns an identity, or nil when it does not exist
+func (r *IdentityRepository) GetByID(ctx context.Context, id string) (*domain.Identity, error) {
+	return r.getOne(ctx, `SELECT `+identityColumns+` FROM identities WHERE id = $1`, id)
+}
+
+// GetByEmail returns the identity of an account for an address, or nil
+func (r *IdentityRepository) GetByEmail(ctx context.Context, accountID, email string) (*domain.Identity, error) {
+	return r.getOne(ctx, `SELECT `+identityColumns+` FROM identities WHERE account_id = $1 AND email = $2`,
+		accountID, email)
+}
+
+// Update saves everything but the account, address and default flag
+func (r *IdentityRepository) Update(ctx context.Context, identity *domain.Identity) error {
+	_, err := querierFor(ctx, r.pool).Exec(ctx, `
+		UPDATE identities SET domain_id = $2, name = $3, reply_to = $4, signature_text = $5, signature_html = $6,
+			status = $7, reviewed_by = $8, reviewed_at = $9, updated_at = $10
+		WHERE id = $1`,
+		identity.ID, identity.DomainID, identity.Name, identity.ReplyTo, identity.SignatureText,
+		identity.SignatureHTML, string(identity.Status), identity.ReviewedBy, identity.ReviewedAt,
+		identity.UpdatedAt,
+	)
+	return err
+}
+
+// SetDefault makes an identity the default of its account, or clears the
+// default when id is empty. The previous default is cleared first so the
+// unique index never sees two.
+func (r *IdentityRepository) SetDefault(ctx context.Context, accountID, id string) error {
+	q := querierFor(ctx, r.pool)
+	if _, err := q.Exec(ctx, `
+		UPDATE identities SET is_default = FALSE
+		WHERE account_id = $1 AND is_default AND id::text <> $2`, accountID, id); err != nil {
+		return err
+	}
+	if id == "" {
+		return nil
+	}
+	_, err := q.Exec(ctx, `UPDATE identities SET is_default = TRUE WHERE account_id = $1 AND id = $2`, accountID, id)
+	return err
+}
+
+// Delete removes an identity
+func (r *IdentityRepository) Delete(ctx context.Context, id string) error {
+	_, err := querierFor(ctx, r.pool).Exec(ctx, `DELETE FROM identities WHERE id = $1`, id)
+	return err
+}
+
+// List returns the identities matching a filter, oldest first
+func (r *IdentityRepository) List(ctx context.Context, filter repository.IdentityFilter) ([]*domain.Identity, error) {
+	c := identityConditions(filter)
+	rows, err := querierFor(ctx, r.pool).Query(ctx, `SELECT `+identityColumns+` FROM identities`+c.where()+
+		` ORDER BY created_at, id`+c.page(filter.Limit, filter.Offset), c.args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	identities := []*domain.Identity{}
+	for rows.Next() {
+		identity, err := scanIdentity(rows)
+		if err != nil {
+			return nil, err
+		}
+		identities = append(identities, identity)
+	}
+	return identities, rows.Err()
+}
+
+// Count returns the number of identities matching a filter
+func (r *IdentityRepository) Count(ctx context.Context, filter repository.IdentityFilter) (int, error) {
+	c := identityConditions(filter)
+	var count int
+	err := querierFor(ctx, r.pool).QueryRow(ctx, `SELECT COUNT(*) FROM identities`+c.where(), c.args...).Scan(&count)
+	return count, err
+}
+
+func (r *IdentityRepository) getOne(ctx context.Context, query string, args ...interface{}) (*domain.Identity, error) {
+	identity, err := scanIdentity(querierFor(ctx, r.pool).QueryRow(ctx, query, args...))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return identity, nil
+}
+
+func identityConditions(filter repository.IdentityFilter) *conditions {
+	c := &conditions{}
+	if filter.AccountID != "" {
+		c.add("account_id = ?", filter.AccountID)
+	}
+	if filter.DomainID != "" {
+		c.add("domain_id = ?", filter.DomainID)
+	}
+	if filter.Status != nil {
+		c.add("status = ?", string(*filter.Status))
+	}
+	return c
+}
+
+func scanIdentity(row pgx.Row) (*domain.Identity, error) {
+	identity := &domain.Identity{}
+	var status string
+	err := row.Scan(
+		&identity.ID, &identity.AccountID, &identity.DomainID, &identity.Email, &identity.Name, &identity.ReplyTo,
+		&identity.SignatureText, &identity.SignatureHTML, &identity.IsDefault, &status, &identity.ReviewedBy,
+		&identity.ReviewedAt, &identity.CreatedAt, &identity.UpdatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	identity.Status = domain.IdentityStatus(status)
+	return identity, nil
+}
+
+// SenderDelegationRepository stores send-as and send-on-behalf delegations
+// in Postgres
+type SenderDelegationRepository struct {
+	pool *pgxpool.Pool
+}
+
+// NewSenderDelegationRepository creates a delegation repository backed by
+// the given pool
+func NewSenderDelegationRepository(pool *pgxpool.Pool) *SenderDelegationRepository {
+	return &SenderDelegationRepository{pool: pool}
+}
+
+const senderDelegationColumns = `id, account_id, delegate_account_id, permission, granted_by, created_at, updated_at`
+
+// Create inserts a delegation. A mailbox delegates to an account at most
+// once.
+func (r *SenderDelegationRepository) Create(ctx context.Context, delegation *domain.SenderDelegation) error {
+	_, err := querierFor(ctx, r.pool).Exec(ctx, `
+		INSERT INTO sender_delegations (`+senderDelegationColumns+`)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)`,
+		delegation.ID, delegation.AccountID, delegation.DelegateAccountID, string(delegation.Permission),
+		delegation.GrantedBy, delegation.CreatedAt, delegation.UpdatedAt,
+	)
+	return err
+}
+
+// GetByID returns a delegation, or nil when it does not exist
+func (r *SenderDelegationRepository) GetByID(ctx context.Context, id string) (*domain.SenderDelegation, error) {
+	return r.getOne(ctx, `SELECT `+senderDelegationColumns+` FROM sender_delegations WHERE id = $1`, id)
+}
+
+// Get returns the delegation of a mailbox to an account, or nil
+func (r *SenderDelegationRepository) Get(ctx context.Context, accountID, delegateAccountID string) (*domain.SenderDelegation, error) {
+	return r.getOne(ctx, `
+		SELECT `+senderDelegationColumns+` FROM sender_delegations
+		WHERE account_id = $1 AND delegate_account_id = $2`, accountID, delegateAccountID)
+}
+
+// Update saves the permission of a delegation
+func (r *SenderDelegationRepository) Update(ctx context.Context, delegation *domain.SenderDelegation) error {
+	_, err := querierFor(ctx, r.pool).Exec(ctx, `
+		UPDATE sender_delegations SET permission = $2, granted_by = $3, updated_at = $4
+		WHERE id = $1`,
+		delegation.ID, string(delegation.Permission), delegation.GrantedBy, delegation.UpdatedAt,
+	)
+	return err
+}
+
+// Delete removes a delegation
+func (r *SenderDelegationRepository) Delete(ctx context.Context, id string) error {
+	_, err := querierFor(ctx, r.pool).Exec(ctx, `DELETE FROM sender_delegations WHERE id = $1`, id)
+	return err
+}
+
+// ListByAccount returns the delegations of a mailbox, oldest first
+func (r *SenderDelegationRepository) ListByAccount(ctx context.Context, accountID string) ([]*domain.SenderDelegation, error) {
+	return r.list(ctx, `
+		SELECT `+senderDelegationColumns+` FROM sender_delegations
+		WHERE account_id = $1 ORDER BY created_at, id`, accountID)
+}
+
+// ListByDelegate returns the delegations to an account, oldest first
+func (r *SenderDelegationRepository) ListByDelegate(ctx context.Context, delegateAccountID string) ([]*domain.SenderDelegation, error) {
+	return r.list(ctx, `
+		SELECT `+senderDelegationColumns+` FROM sender_delegations
+		WHERE delegate_account_id = $1 ORDER BY created_at, id`, delegateAccountID)
+}
+
+func (r *SenderDelegationRepository) getOne(ctx context.Context, query string, args ...interface{}) (*domain.SenderDelegation, error) {
+	delegation, err := scanSenderDelegation(querierFor(ctx, r.pool).QueryRow(ctx, query, args...))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return delegation, nil
+}
+
+func (r *SenderDelegationRepository) list(ctx context.Context, query string, args ...interface{}) ([]*domain.SenderDelegation, error) {
+	rows, err := querierFor(ctx, r.pool).Query(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	delegations := []*domain.SenderDelegation{}
+	for rows.Next() {
+		delegation, err := scanSenderDelegation(rows)
+		if err != nil {
+			return nil, err
+		}
+		delegations = append(delegations, delegation)
+	}
+	return delegations, rows.Err()
+}
+
+func scanSenderDelegation(row pgx.Row) (*domain.SenderDelegation, error) {
+	delegation := &domain.SenderDelegation{}
+	var permission string
+	err := row.Scan(
+		&delegation.ID, &delegation.AccountID, &delegation.DelegateAccountID, &permission,
+		&delegation.GrantedBy, &delegation.CreatedAt, &delegation.UpdatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	delegation.Permission = domain.DelegationPermission(permission)
+	return delegation, nil
+}
@@ -0,0 +1,238 @@
+package postgres
+
+import (
+	"context"
+	"errors"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/skygenesisenterprise/aether-mailer/package/golang/domain"
+)
+
+// IPPoolRepository stores IP pools and their addresses in Postgres
+type IPPoolRepository struct {
+	pool *pgxpool.Pool
+}
+
+// NewIPPoolRepository creates an IP pool repository backed by the given pool
+func NewIPPoolRepository(pool *pgxpool.Pool) *IPPoolRepository {
+	return &IPPoolRepository{pool: pool}
+}
+
+const ipPoolColumns = `id, name, description, is_default, created_at, updated_at`
+
+// Create inserts a pool with its addresses
+func (r *IPPoolRepository) Create(ctx context.Context, ipPool *domain.IPPool) error {
+	return pgx.BeginFunc(ctx, querierFor(ctx, r.pool), func(tx pgx.Tx) error {
+		_, err := tx.Exec(ctx, `
+			INSERT INTO ip_pools (`+ipPoolColumns+`)
+			VALUES ($1, $2, $3, $4, $5, $6)`,
+			ipPool.ID, ipPool.Name, ipPool.Description, ipPool.IsDefault, ipPool.CreatedAt, ipPool.UpdatedAt,
+		)
+		if err != nil {
+			return err
+		}
+		return insertPoolAddresses(ctx, tx, ipPool)
+	})
+}
+
+// GetByID returns a pool, or nil when it does not exist
+func (r *IPPoolRepository) GetByID(ctx context.Context, id string) (*domain.IPPool, error) {
+	return r.getOne(ctx, `SELECT `+ipPoolColumns+` FROM ip_pools WHERE id = $1`, id)
+}
+
+// GetByName returns a pool by name, or nil when it does not exist
+func (r *IPPoolRepository) GetByName(ctx context.Context, name string) (*domain.IPPool, error) {
+	return r.getOne(ctx, `SELECT `+ipPoolColumns+` FROM ip_pools WHERE name = $1`, name)
+}
+
+// GetDefault returns the default pool, or nil when none is marked default
+func (r *IPPoolRepository) GetDefault(ctx context.Context) (*domain.IPPool, error) {
+	return r.getOne(ctx, `SELECT `+ipPoolColumns+` FROM ip_pools WHERE is_default LIMIT 1`)
+}
+
+// Update saves a pool and replaces its addresses
+func (r *IPPoolRepository) Update(ctx context.Context, ipPool *domain.IPPool) error {
+	return pgx.BeginFunc(ctx, querierFor(ctx, r.pool), func(tx pgx.Tx) error {
+		_, err := tx.Exec(ctx, `
+			UPDATE ip_pools SET name = $2, description = $3, is_default = $4, updated_at = $5
+			WHERE id = $1`,
+			ipPool.ID, ipPool.Name, ipPool.Description, ipPool.IsDefault, ipPool.UpdatedAt,
+		)
+		if err != nil {
+			return err
+		}
+		if _, err := tx.Exec(ctx, `DELETE FROM ip_pool_addresses WHERE pool_id = $1`, ipPool.ID); err != nil {
+			return err
+		}
+		return insertPoolAddresses(ctx, tx, ipPool)
+	})
+}
+
+// Delete removes a pool; its addresses are removed by cascade
+func (r *IPPoolRepository) Delete(ctx context.Context, id string) error {
+	_, err := querierFor(ctx, r.pool).Exec(ctx, `DELETE FROM ip_pools WHERE id = $1`, id)
+	return err
+}
+
+// List returns every pool ordered by name
+func (r *IPPoolRepository) List(ctx context.Context) ([]*domain.IPPool, error) {
+	rows, err := querierFor(ctx, r.pool).Query(ctx, `SELECT `+ipPoolColumns+` FROM ip_pools ORDER BY name`)
+	if err != nil {
+		return nil, err
+	}
+	pools := []*domain.IPPool{}
+	for rows.Next() {
+		ipPool, err := scanIPPool(rows)
+		if err != nil {
+			rows.Close()
+			return nil, err
+		}
+		pools = append(pools, ipPool)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	// Addresses are loaded after the pool rows are released
+
+	for _, ipPool := range pools {
+		if err := r.loadAddresses(ctx, ipPool); err != nil {
+			return nil, err
+		}
+	}
+	return pools, nil
+}
+
+func (r *IPPoolRepository) getOne(ctx context.Context, query string, args ...interface{}) (*domain.IPPool, error) {
+	ipPool, err := scanIPPool(querierFor(ctx, r.pool).QueryRow(ctx, query, args...))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := r.loadAddresses(ctx, ipPool); err != nil {
+		return nil, err
+	}
+	return ipPool, nil
+}
+
+func (r *IPPoolRepository) loadAddresses(ctx context.Context, ipPool *domain.IPPool) error {
+	rows, err := querierFor(ctx, r.pool).Query(ctx, `
+		SELECT ip, helo_name, is_active, warmup_started_at
+		FROM ip_pool_addresses WHERE pool_id = $1 ORDER BY position`,
+		ipPool.ID,
+	)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	ipPool.Addresses = []domain.PoolAddress{}
+	for rows.Next() {
+		var addr domain.PoolAddress
+		if err := rows.Scan(&addr.IP, &addr.HeloName, &addr.IsActive, &addr.WarmupStartedAt); err != nil {
+			return err
+		}
+		ipPool.Addresses = append(ipPool.Addresses, addr)
+	}
+	return rows.Err()
+}
+
+func insertPoolAddresses(ctx context.Context, tx pgx.Tx, ipPool *domain.IPPool) error {
+	for i, addr := range ipPool.Addresses {
+		_, err := tx.Exec(ctx, `
+			INSERT INTO ip_pool_addresses (pool_id, ip, helo_name, is_active, warmup_started_at, position)
+			VALUES ($1, $2, $3, $4, $5, $6)`,
+			ipPool.ID, addr.IP, addr.HeloName, addr.IsActive, addr.WarmupStartedAt, i,
+		)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func scanIPPool(row pgx.Row) (*domain.IPPool, error) {
+	ipPool := &domain.IPPool{}
+	err := row.Scan(&ipPool.ID, &ipPool.Name, &ipPool.Description, &ipPool.IsDefault, &ipPool.CreatedAt, &ipPool.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return ipPool, nil
+}
+
+// PoolAssignmentRepository stores IP pool assignments in Postgres
+type PoolAssignmentRepository struct {
+	pool *pgxpool.Pool
+}
+
+// NewPoolAssignmentRepository creates an assignment repository backed by the given pool
+func NewPoolAssignmentRepository(pool *pgxpool.Pool) *PoolAssignmentRepository {
+	return &PoolAssignmentRepository{pool: pool}
+}
+
+// Upsert creates or replaces the assignment of a scope and key
+func (r *PoolAssignmentRepository) Upsert(ctx context.Context, assignment *domain.PoolAssignment) error {
+	_, err := querierFor(ctx, r.pool).Exec(ctx, `
+		INSERT INTO ip_pool_assignments (scope, key, pool_id, created_at)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (scope, key) DO UPDATE SET pool_id = EXCLUDED.pool_id, created_at = EXCLUDED.created_at`,
+		assignment.Scope, assignment.Key, assignment.PoolID, assignment.CreatedAt,
+	)
+	return err
+}
+
+// Get returns the assignment of a scope and key, or nil
+func (r *PoolAssignmentRepository) Get(ctx context.Context, scope domain.PoolScope, key string) (*domain.PoolAssignment, error) {
+	assignment := &domain.PoolAssignment{}
+	err := querierFor(ctx, r.pool).QueryRow(ctx, `
+		SELECT scope, key, pool_id, created_at FROM ip_pool_assignments
+		WHERE scope = $1 AND key = $2`,
+		scope, key,
+	).Scan(&assignment.Scope, &assignment.Key, &assignment.PoolID, &assignment.CreatedAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return assignment, nil
+}
+
+// Delete removes the assignment of a scope and key
+func (r *PoolAssignmentRepository) Delete(ctx context.Context, scope domain.PoolScope, key string) error {
+	_, err := querierFor(ctx, r.pool).Exec(ctx, `DELETE FROM ip_pool_assignments WHERE scope = $1 AND key = $2`, scope, key)
+	return err
+}
+
+// DeleteByPool removes every assignment to a pool
+func (r *PoolAssignmentRepository) DeleteByPool(ctx context.Context, poolID string) error {
+	_, err := querierFor(ctx, r.pool).Exec(ctx, `DELETE FROM ip_pool_assignments WHERE pool_id = $1`, poolID)
+	return err
+}
+
+// ListByPool lists the assignments to a pool
+func (r *PoolAssignmentRepository) ListByPool(ctx context.Context, poolID string) ([]*domain.PoolAssignment, error) {
+	rows, err := querierFor(ctx, r.pool).Query(ctx, `
+		SELECT scope, key, pool_id, created_at FROM ip_pool_assignments
+		WHERE pool_id = $1 ORDER BY scope, key`,
+		poolID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	assignments := []*domain.PoolAssignment{}
+	for rows.Next() {
+		assignment := &domain.PoolAssignment{}
+		if err := rows.Scan(&assignment.Scope, &assignment.Key, &assignment.PoolID, &assignment.CreatedAt); err != nil {
+			return nil, err
+		}
+		assignments = append(assignments, assignment)
+	}
+	return assignments, rows.Err()
+}
@@ -0,0 +1,356 @@
+package postgres
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/skygenesisenterprise/aether-mailer/package/golang/domain"
+	"github.com/skygenesisenterprise/aether-mailer/package/golang/repository"
+)
+
+// MessageRepository stores messages in Postgres. Substring filters are
+// served by trigram indexes and search by the documents of SearchIndex,
+// which Create writes from the text of the message.
+// Messages are returned with their attachments; listings leave out the
+// attachment content.
+type MessageRepository struct {
+	pool *pgxpool.Pool
+}
+
+// NewMessageRepository creates a message repository backed by the given pool
+func NewMessageRepository(pool *pgxpool.Pool) *MessageRepository {
+	return &MessageRepository{pool: pool}
+}
+
+const messageColumns = `id, account_id, thread_id, folder_id, from_address, to_addresses, cc_addresses, bcc_addresses, subject,
+	body_text, body_html, headers, size, is_read, is_draft, is_sent, is_deleted, is_starred, is_flagged, labels,
+	received_at, sent_at, created_at, updated_at`
+
+// Create inserts a message with the attachments not stored yet and its
+// search document
+func (r *MessageRepository) Create(ctx context.Context, message *domain.Message) error {
+	return pgx.BeginFunc(ctx, querierFor(ctx, r.pool), func(tx pgx.Tx) error {
+		_, err := tx.Exec(ctx, `
+			INSERT INTO messages (`+messageColumns+`, recipients)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17, $18, $19, $20, $21,
+				$22, $23, $24, $25)`,
+			message.ID, message.AccountID, nullString(message.ThreadID), nullString(message.FolderID), message.From, stringSlice(message.To),
+			stringSlice(message.Cc), stringSlice(message.Bcc), message.Subject, message.BodyText, message.BodyHTML,
+			headerMap(message.Headers), message.Size, message.IsRead, message.IsDraft, message.IsSent,
+			message.IsDeleted, message.IsStarred, message.IsFlagged, stringSlice(message.Labels), message.ReceivedAt,
+			message.SentAt, message.CreatedAt, message.UpdatedAt, strings.Join(message.To, ", "),
+		)
+		if err != nil {
+			return err
+		}
+		for i := range message.Attachments {
+			attachment := message.Attachments[i]
+			attachment.MessageID = message.ID
+			if err := insertAttachment(ctx, tx, &attachment, true); err != nil {
+				return err
+			}
+		}
+		return indexDocument(ctx, tx, domain.NewSearchDocument(message))
+	})
+}
+
+// GetByID returns a message with its attachments, or nil when it does not exist
+func (r *MessageRepository) GetByID(ctx context.Context, id string) (*domain.Message, error) {
+	message, err := scanMessage(querierFor(ctx, r.pool).QueryRow(ctx, `SELECT `+messageColumns+` FROM messages WHERE id = $1`, id))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	attachments, err := listAttachments(ctx, querierFor(ctx, r.pool), `
+		SELECT `+attachmentColumns+` FROM attachments WHERE message_id = $1 ORDER BY filename, id`, id)
+	if err != nil {
+		return nil, err
+	}
+	for _, attachment := range attachments {
+		message.Attachments = append(message.Attachments, *attachment)
+	}
+	return message, nil
+}
+
+// Update saves a message; its attachments are immutable and its search
+// document is refreshed through SearchIndex
+func (r *MessageRepository) Update(ctx context.Context, message *domain.Message) error {
+	_, err := querierFor(ctx, r.pool).Exec(ctx, `
+		UPDATE messages SET folder_id = $2, from_address = $3, to_addresses = $4, cc_addresses = $5,
+			bcc_addresses = $6, recipients = $7, subject = $8, body_text = $9, body_html = $10, headers = $11,
+			size = $12, is_read = $13, is_draft = $14, is_sent = $15, is_deleted = $16, is_starred = $17,
+			is_flagged = $18, labels = $19, received_at = $20, sent_at = $21, updated_at = $22, thread_id = $23
+		WHERE id = $1`,
+		message.ID, nullString(message.FolderID), message.From, stringSlice(message.To), stringSlice(message.Cc),
+		stringSlice(message.Bcc), strings.Join(message.To, ", "), message.Subject, message.BodyText,
+		message.BodyHTML, headerMap(message.Headers), message.Size, message.IsRead, message.IsDraft,
+		message.IsSent, message.IsDeleted, message.IsStarred, message.IsFlagged, stringSlice(message.Labels),
+		message.ReceivedAt, message.SentAt, message.UpdatedAt, nullString(message.ThreadID),
+	)
+	return err
+}
+
+// Delete removes a message with its attachments
+func (r *MessageRepository) Delete(ctx context.Context, id string) error {
+	return pgx.BeginFunc(ctx, querierFor(ctx, r.pool), func(tx pgx.Tx) error {
+		if _, err := tx.Exec(ctx, `DELETE FROM attachments WHERE message_id = $1`, id); err != nil {
+			return err
+		}
+		_, err := tx.Exec(ctx, `DELETE FROM messages WHERE id = $1`, id)
+		return err
+	})
+}
+
+// ListByAccount returns the messages of an account matching a filter,
+// newest first
+func (r *MessageRepository) ListByAccount(ctx context.Context, accountID string, filter repository.MessageFilter) ([]*domain.Message, error) {
+	c := messageConditions(accountID, filter)
+	query := `SELECT ` + messageColumns + ` FROM messages` + c.where() + ` ORDER BY received_at DESC, id` +
+		c.page(filter.Limit, filter.Offset)
+	return r.list(ctx, query, c.args...)
+}
+
+// CountByAccount returns the number of messages of an account matching a filter
+func (r *MessageRepository) CountByAccount(ctx context.Context, accountID string, filter repository.MessageFilter) (int, error) {
+	c := messageConditions(accountID, filter)
+	var count int
+	err := querierFor(ctx, r.pool).QueryRow(ctx, `SELECT COUNT(*) FROM messages`+c.where(), c.args...).Scan(&count)
+	return count, err
+}
+
+// Search returns the messages of an account matching a search query,
+// newest first. An empty query matches every message.
+func (r *MessageRepository) Search(ctx context.Context, query repository.MessageSearchQuery) ([]*domain.Message, error) {
+	search, err := domain.ParseSearchQuery(query.Query)
+	if err != nil {
+		return nil, err
+	}
+	c := &conditions{}
+	c.add("messages.account_id = ?", query.AccountID)
+	if query.DateFrom != nil {
+		c.add("messages.received_at >= ?", *query.DateFrom)
+	}
+	if query.DateTo != nil {
+		c.add("messages.received_at <= ?", *query.DateTo)
+	}
+	from, _ := searchConditions(c, search)
+	sql := `SELECT ` + messageColumns + ` FROM ` + from + c.where() +
+		` ORDER BY messages.received_at DESC, messages.id` + c.page(query.Limit, query.Offset)
+	return r.list(ctx, sql, c.args...)
+}
+
+// list runs a message query and loads the attachment metadata of the
+// messages with a single query
+func (r *MessageRepository) list(ctx context.Context, query string, args ...interface{}) ([]*domain.Message, error) {
+	rows, err := querierFor(ctx, r.pool).Query(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	messages := []*domain.Message{}
+	byID := make(map[string]*domain.Message)
+	ids := []string{}
+	for rows.Next() {
+		message, err := scanMessage(rows)
+		if err != nil {
+			return nil, err
+		}
+		messages = append(messages, message)
+		byID[message.ID] = message
+		ids = append(ids, message.ID)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if len(ids) == 0 {
+		return messages, nil
+	}
+
+	attachments, err := listAttachments(ctx, querierFor(ctx, r.pool), `
+		SELECT `+attachmentMetadataColumns+` FROM attachments WHERE message_id = ANY($1::uuid[])
+		ORDER BY filename, id`, ids)
+	if err != nil {
+		return nil, err
+	}
+	for _, attachment := range attachments {
+		if message := byID[attachment.MessageID]; message != nil {
+			message.Attachments = append(message.Attachments, *attachment)
+		}
+	}
+	return messages, nil
+}
+
+func messageConditions(accountID string, filter repository.MessageFilter) *conditions {
+	c := &conditions{}
+	c.add("account_id = ?", accountID)
+	if filter.FolderID != nil {
+		c.add("folder_id IS NOT DISTINCT FROM ?", nullString(*filter.FolderID))
+	}
+	if filter.ThreadID != nil {
+		c.add("thread_id IS NOT DISTINCT FROM ?", nullString(*filter.ThreadID))
+	}
+	if filter.IsRead != nil {
+		c.add("is_read = ?", *filter.IsRead)
+	}
+	if filter.IsDraft != nil {
+		c.add("is_draft = ?", *filter.IsDraft)
+	}
+	if filter.IsSent != nil {
+		c.add("is_sent = ?", *filter.IsSent)
+	}
+	if filter.IsDeleted != nil {
+		c.add("is_deleted = ?", *filter.IsDeleted)
+	}
+	if filter.IsStarred != nil {
+		c.add("is_starred = ?", *filter.IsStarred)
+	}
+	if filter.IsFlagged != nil {
+		c.add("is_flagged = ?", *filter.IsFlagged)
+	}
+	if filter.HasAttachments != nil {
+		c.add("EXISTS (SELECT 1 FROM attachments WHERE message_id = messages.id) = ?", *filter.HasAttachments)
+	}
+	if len(filter.Labels) > 0 {
+		c.add("labels @> ?", filter.Labels)
+	}
+	if filter.From != nil {
+		c.add("from_address ILIKE ?", containsPattern(*filter.From))
+	}
+	if filter.To != nil {
+		c.add("recipients ILIKE ?", containsPattern(*filter.To))
+	}
+	if filter.Subject != nil {
+		c.add("subject ILIKE ?", containsPattern(*filter.Subject))
+	}
+	if filter.DateFrom != nil {
+		c.add("received_at >= ?", *filter.DateFrom)
+	}
+	if filter.DateTo != nil {
+		c.add("received_at <= ?", *filter.DateTo)
+	}
+	return c
+}
+
+func scanMessage(row pgx.Row) (*domain.Message, error) {
+	message := &domain.Message{Attachments: []domain.Attachment{}}
+	var threadID, folderID *string
+	err := row.Scan(
+		&message.ID, &message.AccountID, &threadID, &folderID, &message.From, &message.To, &message.Cc, &message.Bcc,
+		&message.Subject, &message.BodyText, &message.BodyHTML, &message.Headers, &message.Size, &message.IsRead,
+		&message.IsDraft, &message.IsSent, &message.IsDeleted, &message.IsStarred, &message.IsFlagged,
+		&message.Labels, &message.ReceivedAt, &message.SentAt,
+		&message.CreatedAt, &message.UpdatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	message.ThreadID = stringValue(threadID)
+	message.FolderID = stringValue(folderID)
+	return message, nil
+}
+
+// headerMap stores nil headers as an empty JSON object
+func headerMap(headers map[string]string) map[string]string {
+	if headers == nil {
+		return map[string]string{}
+	}
+	return headers
+}
+
+// AttachmentRepository stores message attachments in Postgres. Content is
+// only stored for attachments kept out of the blob store.
+type AttachmentRepository struct {
+	pool *pgxpool.Pool
+}
+
+// NewAttachmentRepository creates an attachment repository backed by the given pool
+func NewAttachmentRepository(pool *pgxpool.Pool) *AttachmentRepository {
+	return &AttachmentRepository{pool: pool}
+}
+
+const attachmentColumns = `id, message_id, filename, content_type, size, content, blob_id, checksum`
+
+// attachmentMetadataColumns selects an attachment without its content
+const attachmentMetadataColumns = `id, message_id, filename, content_type, size, NULL::bytea, blob_id, checksum`
+
+// Create inserts an attachment
+func (r *AttachmentRepository) Create(ctx context.Context, attachment *domain.Attachment) error {
+	return insertAttachment(ctx, querierFor(ctx, r.pool), attachment, false)
+}
+
+// GetByID returns an attachment with its content, or nil when it does not exist
+func (r *AttachmentRepository) GetByID(ctx context.Context, id string) (*domain.Attachment, error) {
+	attachment, err := scanAttachment(querierFor(ctx, r.pool).QueryRow(ctx, `SELECT `+attachmentColumns+` FROM attachments WHERE id = $1`, id))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return attachment, nil
+}
+
+// GetByMessageID returns the attachments of a message with their content
+func (r *AttachmentRepository) GetByMessageID(ctx context.Context, messageID string) ([]*domain.Attachment, error) {
+	return listAttachments(ctx, querierFor(ctx, r.pool), `
+		SELECT `+attachmentColumns+` FROM attachments WHERE message_id = $1 ORDER BY filename, id`, messageID)
+}
+
+// Delete removes an attachment
+func (r *AttachmentRepository) Delete(ctx context.Context, id string) error {
+	_, err := querierFor(ctx, r.pool).Exec(ctx, `DELETE FROM attachments WHERE id = $1`, id)
+	return err
+}
+
+// insertAttachment inserts an attachment, skipping it when it is already
+// stored if skipExisting is set
+func insertAttachment(ctx context.Context, q querier, attachment *domain.Attachment, skipExisting bool) error {
+	query := `
+		INSERT INTO attachments (` + attachmentColumns + `)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)`
+	if skipExisting {
+		query += ` ON CONFLICT (id) DO NOTHING`
+	}
+	_, err := q.Exec(ctx, query,
+		attachment.ID, attachment.MessageID, attachment.Filename, attachment.ContentType, attachment.Size,
+		attachment.Content, attachment.BlobID, attachment.Checksum,
+	)
+	return err
+}
+
+func listAttachments(ctx context.Context, q querier, query string, args ...interface{}) ([]*domain.Attachment, error) {
+	rows, err := q.Query(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	attachments := []*domain.Attachment{}
+	for rows.Next() {
+		attachment, err := scanAttachment(rows)
+		if err != nil {
+			return nil, err
+		}
+		attachments = append(attachments, attachment)
+	}
+	return attachments, rows.Err()
+}
+
+func scanAttachment(row pgx.Row) (*domain.Attachment, error) {
+	attachment := &domain.Attachment{}
+	err := row.Scan(
+		&attachment.ID, &attachment.MessageID, &attachment.Filename, &attachment.ContentType, &attachment.Size,
+		&attachment.Content, &attachment.BlobID, &attachment.Checksum,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return attachment, nil
+}
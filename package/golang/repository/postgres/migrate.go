@@ -0,0 +1,219 @@
+package postgres
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/skygenesisenterprise/aether-mailer/package/golang/migrations"
+)
+
+// Migration is a versioned schema change with its rollback
+type Migration struct {
+	Version int64
+	Name    string
+	Up      string
+	Down    string
+}
+
+// Arbitrary key of the advisory lock serialising migrations across instances
+const migrationLockKey = 7316842905
+
+var migrationFile = regexp.MustCompile(`^(\d+)_([A-Za-z0-9_]+)\.(up|down)\.sql$`)
+
+// LoadMigrations reads the migrations of a directory, such as
+// DatabaseConfig.MigrationPath, or the migrations embedded in the SDK when
+// path is empty
+func LoadMigrations(path string) ([]Migration, error) {
+	var fsys fs.FS = migrations.FS
+	if path != "" {
+		fsys = os.DirFS(path)
+	}
+	entries, err := fs.ReadDir(fsys, ".")
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := make(map[int64]*Migration)
+	for _, entry := range entries {
+		match := migrationFile.FindStringSubmatch(entry.Name())
+		if entry.IsDir() || match == nil {
+			continue
+		}
+		version, err := strconv.ParseInt(match[1], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("postgres: invalid migration %s", entry.Name())
+		}
+		content, err := fs.ReadFile(fsys, entry.Name())
+		if err != nil {
+			return nil, err
+		}
+
+		migration := byVersion[version]
+		if migration == nil {
+			migration = &Migration{Version: version, Name: match[2]}
+			byVersion[version] = migration
+		}
+		if migration.Name != match[2] {
+			return nil, fmt.Errorf("postgres: migrations %s and %s share version %d", migration.Name, match[2], version)
+		}
+		if match[3] == "up" {
+			migration.Up = string(content)
+		} else {
+			migration.Down = string(content)
+		}
+	}
+
+	list := make([]Migration, 0, len(byVersion))
+	for _, migration := range byVersion {
+		if migration.Up == "" {
+			return nil, fmt.Errorf("postgres: migration %d_%s has no up file", migration.Version, migration.Name)
+		}
+		list = append(list, *migration)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Version < list[j].Version })
+	return list, nil
+}
+
+// Migrator applies migrations and records them in schema_migrations. Each
+// migration runs in its own transaction, and an advisory lock keeps
+// instances starting together from migrating concurrently.
+type Migrator struct {
+	pool       *pgxpool.Pool
+	migrations []Migration
+}
+
+// NewMigrator creates a migrator for the migrations found at path, the
+// embedded SDK migrations when path is empty
+func NewMigrator(pool *pgxpool.Pool, path string) (*Migrator, error) {
+	list, err := LoadMigrations(path)
+	if err != nil {
+		return nil, err
+	}
+	return &Migrator{pool: pool, migrations: list}, nil
+}
+
+// Up applies every pending migration in version order and returns the
+// versions applied
+func (m *Migrator) Up(ctx context.Context) ([]int64, error) {
+	applied := []int64{}
+	err := m.withLock(ctx, func(conn *pgxpool.Conn, done map[int64]bool) error {
+		for _, migration := range m.migrations {
+			if done[migration.Version] {
+				continue
+			}
+			err := pgx.BeginFunc(ctx, conn, func(tx pgx.Tx) error {
+				if _, err := tx.Exec(ctx, migration.Up); err != nil {
+					return err
+				}
+				_, err := tx.Exec(ctx, `
+					INSERT INTO schema_migrations (version, name, applied_at) VALUES ($1, $2, $3)`,
+					migration.Version, migration.Name, time.Now(),
+				)
+				return err
+			})
+			if err != nil {
+				return fmt.Errorf("postgres: migration %d_%s: %w", migration.Version, migration.Name, err)
+			}
+			applied = append(applied, migration.Version)
+		}
+		return nil
+	})
+	return applied, err
+}
+
+// Down reverts the given number of applied migrations, newest first, and
+// returns the versions reverted
+func (m *Migrator) Down(ctx context.Context, steps int) ([]int64, error) {
+	reverted := []int64{}
+	err := m.withLock(ctx, func(conn *pgxpool.Conn, done map[int64]bool) error {
+		for i := len(m.migrations) - 1; i >= 0 && len(reverted) < steps; i-- {
+			migration := m.migrations[i]
+			if !done[migration.Version] {
+				continue
+			}
+			if migration.Down == "" {
+				return fmt.Errorf("postgres: migration %d_%s cannot be reverted", migration.Version, migration.Name)
+			}
+			err := pgx.BeginFunc(ctx, conn, func(tx pgx.Tx) error {
+				if _, err := tx.Exec(ctx, migration.Down); err != nil {
+					return err
+				}
+				_, err := tx.Exec(ctx, `DELETE FROM schema_migrations WHERE version = $1`, migration.Version)
+				return err
+			})
+			if err != nil {
+				return fmt.Errorf("postgres: reverting %d_%s: %w", migration.Version, migration.Name, err)
+			}
+			reverted = append(reverted, migration.Version)
+		}
+		return nil
+	})
+	return reverted, err
+}
+
+// Version returns the newest applied migration, 0 when none is applied
+func (m *Migrator) Version(ctx context.Context) (int64, error) {
+	var version int64
+	err := m.withLock(ctx, func(conn *pgxpool.Conn, done map[int64]bool) error {
+		for applied := range done {
+			if applied > version {
+				version = applied
+			}
+		}
+		return nil
+	})
+	return version, err
+}
+
+// withLock runs fn on a dedicated connection holding the migration lock,
+// with the set of applied versions
+func (m *Migrator) withLock(ctx context.Context, fn func(conn *pgxpool.Conn, done map[int64]bool) error) error {
+	conn, err := m.pool.Acquire(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Release()
+
+	if _, err := conn.Exec(ctx, `SELECT pg_advisory_lock($1)`, migrationLockKey); err != nil {
+		return err
+	}
+	defer conn.Exec(context.Background(), `SELECT pg_advisory_unlock($1)`, migrationLockKey)
+
+	_, err = conn.Exec(ctx, `
+		CREATE TABLE IF NOT EXISTS schema_migrations (
+			version    BIGINT      PRIMARY KEY,
+			name       TEXT        NOT NULL,
+			applied_at TIMESTAMPTZ NOT NULL
+		)`)
+	if err != nil {
+		return err
+	}
+
+	rows, err := conn.Query(ctx, `SELECT version FROM schema_migrations`)
+	if err != nil {
+		return err
+	}
+	done := make(map[int64]bool)
+	for rows.Next() {
+		var version int64
+		if err := rows.Scan(&version); err != nil {
+			rows.Close()
+			return err
+		}
+		done[version] = true
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	return fn(conn, done)
+}
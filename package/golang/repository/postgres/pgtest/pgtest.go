@@ -0,0 +1,205 @@
+// Package pgtest provides ephemeral Postgres databases for exercising the
+// Postgres repositories. Each database is created empty, migrated with the
+// embedded SDK migrations and dropped on Close.
+//
+// The server is taken from AETHER_TEST_DATABASE_URL when it is set, which
+// must point at a role allowed to create databases. Otherwise a throwaway
+// cluster is started with the initdb and pg_ctl binaries found on PATH or
+// in the usual installation directories, listening only on a Unix socket.
+// Tests should call Shutdown from TestMain to stop that cluster.
+package pgtest
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"sync"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/skygenesisenterprise/aether-mailer/package/golang/repository/postgres"
+)
+
+// EnvURL names the environment variable holding the URL of an existing
+// server to use instead of a throwaway cluster
+const EnvURL = "AETHER_TEST_DATABASE_URL"
+
+// ErrUnavailable is returned when no server is configured and no Postgres
+// installation can be found; tests usually skip on it
+var ErrUnavailable = errors.New("pgtest: no Postgres server available")
+
+// Database is a freshly migrated database
+type Database struct {
+	Pool *pgxpool.Pool
+
+	name     string
+	adminURL string
+}
+
+// New creates and migrates a new database
+func New(ctx context.Context) (*Database, error) {
+	adminURL, err := serverURL()
+	if err != nil {
+		return nil, err
+	}
+
+	suffix := make([]byte, 8)
+	if _, err := rand.Read(suffix); err != nil {
+		return nil, err
+	}
+	name := "pgtest_" + hex.EncodeToString(suffix)
+	if err := adminExec(ctx, adminURL, `CREATE DATABASE `+name); err != nil {
+		return nil, fmt.Errorf("pgtest: creating database: %w", err)
+	}
+
+	config, err := pgxpool.ParseConfig(adminURL)
+	if err != nil {
+		return nil, err
+	}
+	config.ConnConfig.Database = name
+	db := &Database{
+		name:     name,
+		adminURL: adminURL,
+	}
+	db.Pool, err = pgxpool.NewWithConfig(ctx, config)
+	if err != nil {
+		db.drop()
+		return nil, err
+	}
+
+	migrator, err := postgres.NewMigrator(db.Pool, "")
+	if err == nil {
+		_, err = migrator.Up(ctx)
+	}
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("pgtest: migrating: %w", err)
+	}
+	return db, nil
+}
+
+// Close closes the pool and drops the database
+func (db *Database) Close() error {
+	db.Pool.Close()
+	return db.drop()
+}
+
+func (db *Database) drop() error {
+	return adminExec(context.Background(), db.adminURL, `DROP DATABASE IF EXISTS `+db.name)
+}
+
+func adminExec(ctx context.Context, url, sql string) error {
+	conn, err := pgx.Connect(ctx, url)
+	if err != nil {
+		return err
+	}
+	defer conn.Close(context.Background())
+	_, err = conn.Exec(ctx, sql)
+	return err
+}
+
+// The throwaway cluster, shared by every database of the process
+var (
+	clusterOnce sync.Once
+	clusterURL  string
+	clusterDir  string
+	clusterBin  string
+	clusterErr  error
+)
+
+func serverURL() (string, error) {
+	if url := os.Getenv(EnvURL); url != "" {
+		return url, nil
+	}
+	clusterOnce.Do(func() {
+		clusterURL, clusterErr = startCluster()
+	})
+	return clusterURL, clusterErr
+}
+
+// Shutdown stops the throwaway cluster, if one was started, and removes its
+// data directory
+func Shutdown() error {
+	if clusterDir == "" {
+		return nil
+	}
+	err := exec.Command(filepath.Join(clusterBin, "pg_ctl"), "-D", filepath.Join(clusterDir, "data"),
+		"-m", "immediate", "-w", "stop").Run()
+	if removeErr := os.RemoveAll(clusterDir); err == nil {
+		err = removeErr
+	}
+	clusterDir = ""
+	return err
+}
+
+func startCluster() (string, error) {
+	bin := findBinaries()
+	if bin == "" {
+		return "", ErrUnavailable
+	}
+	dir, err := os.MkdirTemp("", "pgtest")
+	if err != nil {
+		return "", err
+	}
+	data := filepath.Join(dir, "data")
+
+	initdb := exec.Command(filepath.Join(bin, "initdb"), "-D", data, "-U", "postgres", "-A", "trust",
+		"-E", "UTF8", "--no-sync")
+	if out, err := initdb.CombinedOutput(); err != nil {
+		os.RemoveAll(dir)
+		return "", fmt.Errorf("pgtest: initdb: %w: %s", err, out)
+	}
+
+	port, err := freePort()
+	if err != nil {
+		os.RemoveAll(dir)
+		return "", err
+	}
+	// Durability is irrelevant for throwaway data
+	options := fmt.Sprintf("-p %d -k %s -c listen_addresses='' -c fsync=off -c synchronous_commit=off "+
+		"-c full_page_writes=off", port, dir)
+	start := exec.Command(filepath.Join(bin, "pg_ctl"), "-D", data, "-l", filepath.Join(dir, "server.log"),
+		"-o", options, "-w", "start")
+	if out, err := start.CombinedOutput(); err != nil {
+		os.RemoveAll(dir)
+		return "", fmt.Errorf("pgtest: pg_ctl start: %w: %s", err, out)
+	}
+
+	clusterDir = dir
+	clusterBin = bin
+	return fmt.Sprintf("host=%s port=%d user=postgres dbname=postgres sslmode=disable", dir, port), nil
+}
+
+// findBinaries returns the directory holding initdb and pg_ctl, preferring
+// PATH and then the newest version installed by the distribution
+func findBinaries() string {
+	if path, err := exec.LookPath("initdb"); err == nil {
+		return filepath.Dir(path)
+	}
+	candidates, _ := filepath.Glob("/usr/lib/postgresql/*/bin/initdb")
+	more, _ := filepath.Glob("/usr/local/opt/postgresql*/bin/initdb")
+	candidates = append(candidates, more...)
+	if len(candidates) == 0 {
+		return ""
+	}
+	sort.Strings(candidates)
+	return filepath.Dir(candidates[len(candidates)-1])
+}
+
+// freePort returns a TCP port number nothing listens on. The server only
+// uses it to name its socket, so a race with another listener is harmless.
+func freePort() (int, error) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return 0, err
+	}
+	defer listener.Close()
+	return listener.Addr().(*net.TCPAddr).Port, nil
+}
@@ -0,0 +1,127 @@
+package postgres
+
+import (
+	"context"
+	"errors"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/skygenesisenterprise/aether-mailer/package/golang/domain"
+	"github.com/skygenesisenterprise/aether-mailer/package/golang/repository"
+)
+
+// PolicyRepository stores mail policies in Postgres
+type PolicyRepository struct {
+	pool *pgxpool.Pool
+}
+
+// NewPolicyRepository creates a policy repository backed by the given pool
+func NewPolicyRepository(pool *pgxpool.Pool) *PolicyRepository {
+	return &PolicyRepository{pool: pool}
+}
+
+const policyColumns = `id, domain_id, user_id, name, type, rule, action, is_active, priority, created_at, updated_at`
+
+// Create inserts a policy
+func (r *PolicyRepository) Create(ctx context.Context, policy *domain.Policy) error {
+	_, err := querierFor(ctx, r.pool).Exec(ctx, `
+		INSERT INTO policies (`+policyColumns+`)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)`,
+		policy.ID, policy.DomainID, policy.UserID, policy.Name, string(policy.Type), policy.Rule,
+		string(policy.Action), policy.IsActive, policy.Priority, policy.CreatedAt, policy.UpdatedAt,
+	)
+	return err
+}
+
+// GetByID returns a policy, or nil when it does not exist
+func (r *PolicyRepository) GetByID(ctx context.Context, id string) (*domain.Policy, error) {
+	policy, err := scanPolicy(querierFor(ctx, r.pool).QueryRow(ctx, `SELECT `+policyColumns+` FROM policies WHERE id = $1`, id))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return policy, nil
+}
+
+// Update saves a policy
+func (r *PolicyRepository) Update(ctx context.Context, policy *domain.Policy) error {
+	_, err := querierFor(ctx, r.pool).Exec(ctx, `
+		UPDATE policies SET domain_id = $2, user_id = $3, name = $4, type = $5, rule = $6, action = $7,
+			is_active = $8, priority = $9, updated_at = $10
+		WHERE id = $1`,
+		policy.ID, policy.DomainID, policy.UserID, policy.Name, string(policy.Type), policy.Rule,
+		string(policy.Action), policy.IsActive, policy.Priority, policy.UpdatedAt,
+	)
+	return err
+}
+
+// Delete removes a policy
+func (r *PolicyRepository) Delete(ctx context.Context, id string) error {
+	_, err := querierFor(ctx, r.pool).Exec(ctx, `DELETE FROM policies WHERE id = $1`, id)
+	return err
+}
+
+// List returns the policies matching a filter, newest first
+func (r *PolicyRepository) List(ctx context.Context, filter repository.PolicyFilter) ([]*domain.Policy, error) {
+	c := policyConditions(filter)
+	return r.list(ctx, `SELECT `+policyColumns+` FROM policies`+c.where()+` ORDER BY created_at DESC`+
+		c.page(filter.Limit, filter.Offset), c.args...)
+}
+
+// GetActivePolicies returns the active policies matching a filter, highest
+// priority first
+func (r *PolicyRepository) GetActivePolicies(ctx context.Context, filter repository.PolicyFilter) ([]*domain.Policy, error) {
+	c := policyConditions(filter)
+	c.add("is_active")
+	return r.list(ctx, `SELECT `+policyColumns+` FROM policies`+c.where()+` ORDER BY priority DESC, created_at`+
+		c.page(filter.Limit, filter.Offset), c.args...)
+}
+
+func (r *PolicyRepository) list(ctx context.Context, query string, args ...interface{}) ([]*domain.Policy, error) {
+	rows, err := querierFor(ctx, r.pool).Query(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	policies := []*domain.Policy{}
+	for rows.Next() {
+		policy, err := scanPolicy(rows)
+		if err != nil {
+			return nil, err
+		}
+		policies = append(policies, policy)
+	}
+	return policies, rows.Err()
+}
+
+func policyConditions(filter repository.PolicyFilter) *conditions {
+	c := &conditions{}
+	if filter.DomainID != nil {
+		c.add("domain_id = ?", *filter.DomainID)
+	}
+	if filter.UserID != nil {
+		c.add("user_id = ?", *filter.UserID)
+	}
+	if filter.Type != nil {
+		c.add("type = ?", string(*filter.Type))
+	}
+	if filter.IsActive != nil {
+		c.add("is_active = ?", *filter.IsActive)
+	}
+	return c
+}
+
+func scanPolicy(row pgx.Row) (*domain.Policy, error) {
+	policy := &domain.Policy{}
+	err := row.Scan(
+		&policy.ID, &policy.DomainID, &policy.UserID, &policy.Name, &policy.Type, &policy.Rule, &policy.Action,
+		&policy.IsActive, &policy.Priority, &policy.CreatedAt, &policy.UpdatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return policy, nil
+}
@@ -0,0 +1,74 @@
+package postgres_test
+
+import (
+	"context"
+	stderrors "errors"
+	"os"
+	"testing"
+
+	"github.com/skygenesisenterprise/aether-mailer/package/golang/repository/postgres"
+	"github.com/skygenesisenterprise/aether-mailer/package/golang/repository/postgres/pgtest"
+	"github.com/skygenesisenterprise/aether-mailer/package/golang/repository/repotest"
+)
+
+func TestMain(m *testing.M) {
+	code := m.Run()
+	// Log error but don't fail the operation
+	_ = pgtest.Shutdown()
+	os.Exit(code)
+}
+
+func TestConformance(t *testing.T) {
+	repotest.Run(t, func(t *testing.T) *repotest.Repositories {
+		db, err := pgtest.New(context.Background())
+		if stderrors.Is(err, pgtest.ErrUnavailable) {
+			t.Skip(err)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() {
+			if err := db.Close(); err != nil {
+				t.Error(err)
+			}
+		})
+
+		pool := db.Pool
+		return &repotest.Repositories{
+			Users:               postgres.NewUserRepository(pool),
+			Domains:             postgres.NewDomainRepository(pool),
+			DomainMembers:       postgres.NewDomainMemberRepository(pool),
+			EmailAccounts:       postgres.NewEmailAccountRepository(pool),
+			EmailAliases:        postgres.NewEmailAliasRepository(pool),
+			DNSRecords:          postgres.NewDNSRecordRepository(pool),
+			Folders:             postgres.NewFolderRepository(pool),
+			Messages:            postgres.NewMessageRepository(pool),
+			Attachments:         postgres.NewAttachmentRepository(pool),
+			Quotas:              postgres.NewQuotaRepository(pool),
+			Policies:            postgres.NewPolicyRepository(pool),
+			Spam:                postgres.NewSpamRepository(pool),
+			Quarantine:          postgres.NewQuarantineRepository(pool),
+			RateCounters:        postgres.NewRateCounterStore(pool),
+			Suspensions:         postgres.NewSendingSuspensionRepository(pool),
+			DestinationPolicies: postgres.NewDestinationPolicyRepository(pool),
+			DeliveryJobs:        postgres.NewDeliveryJobRepository(pool),
+			IPPools:             postgres.NewIPPoolRepository(pool),
+			PoolAssignments:     postgres.NewPoolAssignmentRepository(pool),
+			MTASTSPolicies:      postgres.NewMTASTSPolicyRepository(pool),
+			TLSResults:          postgres.NewTLSResultRepository(pool),
+			DKIMKeys:            postgres.NewDKIMKeyRepository(pool),
+			DKIMRotationLog:     postgres.NewDKIMRotationLogRepository(pool),
+			Blobs:               postgres.NewBlobRepository(pool),
+			SearchIndex:         postgres.NewSearchIndex(pool),
+			Threads:             postgres.NewThreadRepository(pool),
+			ScheduledSends:      postgres.NewScheduledSendRepository(pool),
+			Identities:          postgres.NewIdentityRepository(pool),
+			SenderDelegations:   postgres.NewSenderDelegationRepository(pool),
+			Groups:              postgres.NewGroupRepository(pool),
+			FolderACLs:          postgres.NewFolderACLRepository(pool),
+			ACLAuditLog:         postgres.NewACLAuditLogRepository(pool),
+			Events:              postgres.NewEventStore(pool),
+			Outbox:              postgres.NewOutboxRepository(pool),
+		}
+	})
+}
@@ -0,0 +1,164 @@
+package postgres
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/skygenesisenterprise/aether-mailer/package/golang/domain"
+	"github.com/skygenesisenterprise/aether-mailer/package/golang/repository"
+)
+
+// QuarantineRepository stores quarantined messages in Postgres. The parsed
+// message and the verdicts are kept as JSON next to the raw message.
+type QuarantineRepository struct {
+	pool *pgxpool.Pool
+}
+
+// NewQuarantineRepository creates a quarantine repository backed by the given pool
+func NewQuarantineRepository(pool *pgxpool.Pool) *QuarantineRepository {
+	return &QuarantineRepository{pool: pool}
+}
+
+const quarantineColumns = `id, message_id, account_id, domain_id, sender, recipients, subject, reason, details,
+	policy_id, policy_name, spam_verdict, virus_verdict, message, raw_message, size, status, created_at,
+	expires_at, released_at, released_by, digest_sent_at`
+
+// Create inserts an entry
+func (r *QuarantineRepository) Create(ctx context.Context, entry *domain.QuarantineEntry) error {
+	_, err := querierFor(ctx, r.pool).Exec(ctx, `
+		INSERT INTO quarantine_entries (`+quarantineColumns+`)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17, $18, $19, $20, $21, $22)`,
+		entry.ID, entry.MessageID, entry.AccountID, entry.DomainID, entry.Sender, stringSlice(entry.Recipients),
+		entry.Subject, string(entry.Reason), entry.Details, entry.PolicyID, entry.PolicyName, entry.SpamVerdict,
+		entry.VirusVerdict, entry.Message, entry.RawMessage, entry.Size, string(entry.Status), entry.CreatedAt,
+		entry.ExpiresAt, entry.ReleasedAt, entry.ReleasedBy, entry.DigestSentAt,
+	)
+	return err
+}
+
+// GetByID returns an entry, or nil when it does not exist
+func (r *QuarantineRepository) GetByID(ctx context.Context, id string) (*domain.QuarantineEntry, error) {
+	entry, err := scanQuarantineEntry(querierFor(ctx, r.pool).QueryRow(ctx, `SELECT `+quarantineColumns+` FROM quarantine_entries WHERE id = $1`, id))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return entry, nil
+}
+
+// Update saves the review state of an entry
+func (r *QuarantineRepository) Update(ctx context.Context, entry *domain.QuarantineEntry) error {
+	_, err := querierFor(ctx, r.pool).Exec(ctx, `
+		UPDATE quarantine_entries SET status = $2, expires_at = $3, released_at = $4, released_by = $5,
+			digest_sent_at = $6
+		WHERE id = $1`,
+		entry.ID, string(entry.Status), entry.ExpiresAt, entry.ReleasedAt, entry.ReleasedBy, entry.DigestSentAt,
+	)
+	return err
+}
+
+// Release marks a held entry released and reports false when it is no
+// longer held
+func (r *QuarantineRepository) Release(ctx context.Context, id, releasedBy string, at time.Time) (bool, error) {
+	tag, err := querierFor(ctx, r.pool).Exec(ctx, `
+		UPDATE quarantine_entries SET status = 'RELEASED', released_at = $3, released_by = $2
+		WHERE id = $1 AND status = 'HELD'`,
+		id, releasedBy, at,
+	)
+	if err != nil {
+		return false, err
+	}
+	return tag.RowsAffected() > 0, nil
+}
+
+// Delete removes an entry
+func (r *QuarantineRepository) Delete(ctx context.Context, id string) error {
+	_, err := querierFor(ctx, r.pool).Exec(ctx, `DELETE FROM quarantine_entries WHERE id = $1`, id)
+	return err
+}
+
+// List returns the entries matching a filter, newest first
+func (r *QuarantineRepository) List(ctx context.Context, filter repository.QuarantineFilter) ([]*domain.QuarantineEntry, error) {
+	c := quarantineConditions(filter)
+	query := `SELECT ` + quarantineColumns + ` FROM quarantine_entries` + c.where() + ` ORDER BY created_at DESC` +
+		c.page(filter.Limit, filter.Offset)
+	rows, err := querierFor(ctx, r.pool).Query(ctx, query, c.args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	entries := []*domain.QuarantineEntry{}
+	for rows.Next() {
+		entry, err := scanQuarantineEntry(rows)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+	return entries, rows.Err()
+}
+
+// Count returns the number of entries matching a filter
+func (r *QuarantineRepository) Count(ctx context.Context, filter repository.QuarantineFilter) (int, error) {
+	c := quarantineConditions(filter)
+	var count int
+	err := querierFor(ctx, r.pool).QueryRow(ctx, `SELECT COUNT(*) FROM quarantine_entries`+c.where(), c.args...).Scan(&count)
+	return count, err
+}
+
+// DeleteExpired removes the entries that expired before the given time and
+// returns how many were removed
+func (r *QuarantineRepository) DeleteExpired(ctx context.Context, before time.Time) (int, error) {
+	tag, err := querierFor(ctx, r.pool).Exec(ctx, `DELETE FROM quarantine_entries WHERE expires_at < $1`, before)
+	if err != nil {
+		return 0, err
+	}
+	return int(tag.RowsAffected()), nil
+}
+
+func quarantineConditions(filter repository.QuarantineFilter) *conditions {
+	c := &conditions{}
+	if len(filter.AccountIDs) > 0 {
+		c.add("account_id = ANY(?)", filter.AccountIDs)
+	}
+	if filter.DomainID != nil {
+		c.add("domain_id = ?", *filter.DomainID)
+	}
+	if filter.Reason != nil {
+		c.add("reason = ?", string(*filter.Reason))
+	}
+	if filter.Status != nil {
+		c.add("status = ?", string(*filter.Status))
+	}
+	if filter.CreatedAfter != nil {
+		c.add("created_at > ?", *filter.CreatedAfter)
+	}
+	if filter.DigestPending != nil {
+		if *filter.DigestPending {
+			c.add("digest_sent_at IS NULL")
+		} else {
+			c.add("digest_sent_at IS NOT NULL")
+		}
+	}
+	return c
+}
+
+func scanQuarantineEntry(row pgx.Row) (*domain.QuarantineEntry, error) {
+	entry := &domain.QuarantineEntry{}
+	err := row.Scan(
+		&entry.ID, &entry.MessageID, &entry.AccountID, &entry.DomainID, &entry.Sender, &entry.Recipients,
+		&entry.Subject, &entry.Reason, &entry.Details, &entry.PolicyID, &entry.PolicyName, &entry.SpamVerdict,
+		&entry.VirusVerdict, &entry.Message, &entry.RawMessage, &entry.Size, &entry.Status, &entry.CreatedAt,
+		&entry.ExpiresAt, &entry.ReleasedAt, &entry.ReleasedBy, &entry.DigestSentAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return entry, nil
+}
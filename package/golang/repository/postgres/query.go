@@ -0,0 +1,108 @@
+package postgres
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/skygenesisenterprise/aether-mailer/package/golang/config"
+)
+
+// Connect opens a connection pool with the database settings
+func Connect(ctx context.Context, cfg config.DatabaseConfig) (*pgxpool.Pool, error) {
+	if cfg.Driver == "" {
+		cfg.Driver = "postgres"
+	}
+	if cfg.Driver != "postgres" {
+		return nil, fmt.Errorf("postgres: unsupported driver %q", cfg.Driver)
+	}
+	poolConfig, err := pgxpool.ParseConfig(cfg.GetDSN())
+	if err != nil {
+		return nil, err
+	}
+	if cfg.MaxConnections > 0 {
+		poolConfig.MaxConns = int32(cfg.MaxConnections)
+	}
+	if cfg.MaxIdleTime > 0 {
+		poolConfig.MaxConnIdleTime = cfg.MaxIdleTime
+	}
+	if cfg.MaxLifetime > 0 {
+		poolConfig.MaxConnLifetime = cfg.MaxLifetime
+	}
+
+	pool, err := pgxpool.NewWithConfig(ctx, poolConfig)
+	if err != nil {
+		return nil, err
+	}
+	if err := pool.Ping(ctx); err != nil {
+		pool.Close()
+		return nil, err
+	}
+	return pool, nil
+}
+
+// conditions builds the WHERE clause of a filtered query. Clauses use ?
+// for their arguments, which are numbered in the order they are added.
+type conditions struct {
+	clauses []string
+	args    []interface{}
+}
+
+func (c *conditions) add(clause string, args ...interface{}) {
+	for _, arg := range args {
+		c.args = append(c.args, arg)
+		clause = strings.Replace(clause, "?", fmt.Sprintf("$%d", len(c.args)), 1)
+	}
+	c.clauses = append(c.clauses, clause)
+}
+
+func (c *conditions) where() string {
+	if len(c.clauses) == 0 {
+		return ""
+	}
+	return " WHERE " + strings.Join(c.clauses, " AND ")
+}
+
+// page returns the LIMIT and OFFSET of a list query
+func (c *conditions) page(limit, offset int) string {
+	clause := ""
+	if limit > 0 {
+		c.args = append(c.args, limit)
+		clause += fmt.Sprintf(" LIMIT $%d", len(c.args))
+	}
+	if offset > 0 {
+		c.args = append(c.args, offset)
+		clause += fmt.Sprintf(" OFFSET $%d", len(c.args))
+	}
+	return clause
+}
+
+// containsPattern returns an ILIKE pattern matching values containing s
+func containsPattern(s string) string {
+	return "%" + strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(s) + "%"
+}
+
+// nullString stores an empty string as NULL
+func nullString(s string) *string {
+	if s == "" {
+		return nil
+	}
+	return &s
+}
+
+// stringValue reads a nullable column into a string
+func stringValue(s *string) string {
+	if s == nil {
+		return ""
+	}
+	return *s
+}
+
+// stringSlice stores a nil slice as an empty array
+func stringSlice(s []string) []string {
+	if s == nil {
+		return []string{}
+	}
+	return s
+}
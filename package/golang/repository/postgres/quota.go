@@ -0,0 +1,95 @@
+package postgres
+
+import (
+	"context"
+	"errors"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/skygenesisenterprise/aether-mailer/package/golang/domain"
+)
+
+// QuotaRepository stores user and domain quotas in Postgres. A domain
+// quota is a row without a user.
+type QuotaRepository struct {
+	pool *pgxpool.Pool
+}
+
+// NewQuotaRepository creates a quota repository backed by the given pool
+func NewQuotaRepository(pool *pgxpool.Pool) *QuotaRepository {
+	return &QuotaRepository{pool: pool}
+}
+
+const quotaColumns = `user_id, domain_id, max_storage_mb, used_storage_mb, max_emails_per_day, sent_emails_today, reset_at`
+
+// Create inserts a quota
+func (r *QuotaRepository) Create(ctx context.Context, quota *domain.Quota) error {
+	_, err := querierFor(ctx, r.pool).Exec(ctx, `
+		INSERT INTO quotas (`+quotaColumns+`)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)`,
+		nullString(quota.UserID), quota.DomainID, quota.MaxStorageMB, quota.UsedStorageMB, quota.MaxEmailsPerDay,
+		quota.SentEmailsToday, quota.ResetAt,
+	)
+	return err
+}
+
+// GetByUserID returns the quota of a user, or nil when it has none
+func (r *QuotaRepository) GetByUserID(ctx context.Context, userID string) (*domain.Quota, error) {
+	return r.getOne(ctx, `SELECT `+quotaColumns+` FROM quotas WHERE user_id = $1`, userID)
+}
+
+// GetByDomainID returns the quota of a domain, or nil when it has none
+func (r *QuotaRepository) GetByDomainID(ctx context.Context, domainID string) (*domain.Quota, error) {
+	return r.getOne(ctx, `SELECT `+quotaColumns+` FROM quotas WHERE user_id IS NULL AND domain_id = $1`, domainID)
+}
+
+// Update saves a quota, found by its user or, for a domain quota, its domain
+func (r *QuotaRepository) Update(ctx context.Context, quota *domain.Quota) error {
+	if quota.UserID == "" {
+		_, err := querierFor(ctx, r.pool).Exec(ctx, `
+			UPDATE quotas SET max_storage_mb = $2, used_storage_mb = $3, max_emails_per_day = $4,
+				sent_emails_today = $5, reset_at = $6
+			WHERE user_id IS NULL AND domain_id = $1`,
+			quota.DomainID, quota.MaxStorageMB, quota.UsedStorageMB, quota.MaxEmailsPerDay, quota.SentEmailsToday,
+			quota.ResetAt,
+		)
+		return err
+	}
+	_, err := querierFor(ctx, r.pool).Exec(ctx, `
+		UPDATE quotas SET domain_id = $2, max_storage_mb = $3, used_storage_mb = $4, max_emails_per_day = $5,
+			sent_emails_today = $6, reset_at = $7
+		WHERE user_id = $1`,
+		quota.UserID, quota.DomainID, quota.MaxStorageMB, quota.UsedStorageMB, quota.MaxEmailsPerDay,
+		quota.SentEmailsToday, quota.ResetAt,
+	)
+	return err
+}
+
+// ResetDailyCounters clears the sending counter of a user, or of every
+// quota when userID is empty, and moves the reset time to the next midnight
+func (r *QuotaRepository) ResetDailyCounters(ctx context.Context, userID string) error {
+	const reset = `UPDATE quotas SET sent_emails_today = 0, reset_at = date_trunc('day', now()) + interval '1 day'`
+	if userID == "" {
+		_, err := querierFor(ctx, r.pool).Exec(ctx, reset)
+		return err
+	}
+	_, err := querierFor(ctx, r.pool).Exec(ctx, reset+` WHERE user_id = $1`, userID)
+	return err
+}
+
+func (r *QuotaRepository) getOne(ctx context.Context, query string, args ...interface{}) (*domain.Quota, error) {
+	quota := &domain.Quota{}
+	var userID *string
+	err := querierFor(ctx, r.pool).QueryRow(ctx, query, args...).Scan(
+		&userID, &quota.DomainID, &quota.MaxStorageMB, &quota.UsedStorageMB, &quota.MaxEmailsPerDay,
+		&quota.SentEmailsToday, &quota.ResetAt,
+	)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	quota.UserID = stringValue(userID)
+	return quota, nil
+}
@@ -0,0 +1,169 @@
+// Package postgres implements the SDK repositories on PostgreSQL using pgx
+package postgres
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/skygenesisenterprise/aether-mailer/package/golang/domain"
+)
+
+// RateCounterStore keeps sliding-window counters in Postgres so every
+// instance enforces the same limits. Counts are grouped in buckets of one
+// sixtieth of the window.
+type RateCounterStore struct {
+	pool *pgxpool.Pool
+}
+
+// NewRateCounterStore creates a counter store backed by the given pool
+func NewRateCounterStore(pool *pgxpool.Pool) *RateCounterStore {
+	return &RateCounterStore{pool: pool}
+}
+
+// Add records amount in the bucket containing at and returns the window total
+func (s *RateCounterStore) Add(ctx context.Context, key string, amount int64, at time.Time, window time.Duration) (int64, error) {
+	bucket := at.Truncate(bucketSize(window))
+
+	// The sub-select reads the statement snapshot, which does not include
+	// the upserted row, so the current bucket is taken from RETURNING
+	var total int64
+	err := querierFor(ctx, s.pool).QueryRow(ctx, `
+		WITH up AS (
+			INSERT INTO rate_counters (key, bucket, count)
+			VALUES ($1, $2, $3)
+			ON CONFLICT (key, bucket) DO UPDATE SET count = rate_counters.count + EXCLUDED.count
+			RETURNING count
+		)
+		SELECT (SELECT count FROM up) + COALESCE((
+			SELECT SUM(count) FROM rate_counters
+			WHERE key = $1 AND bucket > $4 AND bucket <> $2
+		), 0)`,
+		key, bucket, amount, at.Add(-window),
+	).Scan(&total)
+	if err != nil {
+		return 0, err
+	}
+	return total, nil
+}
+
+// Sum returns the window total ending at the given time
+func (s *RateCounterStore) Sum(ctx context.Context, key string, at time.Time, window time.Duration) (int64, error) {
+	var total int64
+	err := querierFor(ctx, s.pool).QueryRow(ctx, `
+		SELECT COALESCE(SUM(count), 0) FROM rate_counters
+		WHERE key = $1 AND bucket > $2 AND bucket <= $3`,
+		key, at.Add(-window), at,
+	).Scan(&total)
+	if err != nil {
+		return 0, err
+	}
+	return total, nil
+}
+
+// Prune deletes buckets older than before
+func (s *RateCounterStore) Prune(ctx context.Context, before time.Time) error {
+	_, err := querierFor(ctx, s.pool).Exec(ctx, `DELETE FROM rate_counters WHERE bucket < $1`, before)
+	return err
+}
+
+// SendingSuspensionRepository stores sending suspensions in Postgres
+type SendingSuspensionRepository struct {
+	pool *pgxpool.Pool
+}
+
+// NewSendingSuspensionRepository creates a suspension repository backed by the given pool
+func NewSendingSuspensionRepository(pool *pgxpool.Pool) *SendingSuspensionRepository {
+	return &SendingSuspensionRepository{pool: pool}
+}
+
+const suspensionColumns = `id, scope, key, reason, suspended_at, expires_at, lifted_at, lifted_by`
+
+// Create inserts a suspension
+func (r *SendingSuspensionRepository) Create(ctx context.Context, suspension *domain.SendingSuspension) error {
+	_, err := querierFor(ctx, r.pool).Exec(ctx, `
+		INSERT INTO sending_suspensions (`+suspensionColumns+`)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)`,
+		suspension.ID, suspension.Scope, suspension.Key, suspension.Reason,
+		suspension.SuspendedAt, suspension.ExpiresAt, suspension.LiftedAt, suspension.LiftedBy,
+	)
+	return err
+}
+
+// GetByID returns a suspension, or nil when it does not exist
+func (r *SendingSuspensionRepository) GetByID(ctx context.Context, id string) (*domain.SendingSuspension, error) {
+	row := querierFor(ctx, r.pool).QueryRow(ctx, `SELECT `+suspensionColumns+` FROM sending_suspensions WHERE id = $1`, id)
+	return scanSuspension(row)
+}
+
+// GetActive returns the suspension in force for a scope, or nil
+func (r *SendingSuspensionRepository) GetActive(ctx context.Context, scope domain.RateScope, key string, at time.Time) (*domain.SendingSuspension, error) {
+	row := querierFor(ctx, r.pool).QueryRow(ctx, `
+		SELECT `+suspensionColumns+` FROM sending_suspensions
+		WHERE scope = $1 AND key = $2 AND lifted_at IS NULL AND (expires_at IS NULL OR expires_at > $3)
+		ORDER BY suspended_at DESC
+		LIMIT 1`,
+		scope, key, at,
+	)
+	return scanSuspension(row)
+}
+
+// Update saves a suspension
+func (r *SendingSuspensionRepository) Update(ctx context.Context, suspension *domain.SendingSuspension) error {
+	_, err := querierFor(ctx, r.pool).Exec(ctx, `
+		UPDATE sending_suspensions
+		SET reason = $2, expires_at = $3, lifted_at = $4, lifted_by = $5
+		WHERE id = $1`,
+		suspension.ID, suspension.Reason, suspension.ExpiresAt, suspension.LiftedAt, suspension.LiftedBy,
+	)
+	return err
+}
+
+// ListActive lists the suspensions in force at the given time
+func (r *SendingSuspensionRepository) ListActive(ctx context.Context, at time.Time) ([]*domain.SendingSuspension, error) {
+	rows, err := querierFor(ctx, r.pool).Query(ctx, `
+		SELECT `+suspensionColumns+` FROM sending_suspensions
+		WHERE lifted_at IS NULL AND (expires_at IS NULL OR expires_at > $1)
+		ORDER BY suspended_at DESC`,
+		at,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	suspensions := []*domain.SendingSuspension{}
+	for rows.Next() {
+		suspension, err := scanSuspension(rows)
+		if err != nil {
+			return nil, err
+		}
+		suspensions = append(suspensions, suspension)
+	}
+	return suspensions, rows.Err()
+}
+
+func scanSuspension(row pgx.Row) (*domain.SendingSuspension, error) {
+	suspension := &domain.SendingSuspension{}
+	err := row.Scan(
+		&suspension.ID, &suspension.Scope, &suspension.Key, &suspension.Reason,
+		&suspension.SuspendedAt, &suspension.ExpiresAt, &suspension.LiftedAt, &suspension.LiftedBy,
+	)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return suspension, nil
+}
+
+func bucketSize(window time.Duration) time.Duration {
+	size := window / 60
+	if size < time.Second {
+		size = time.Second
+	}
+	return size
+}
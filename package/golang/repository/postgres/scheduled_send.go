@@ -0,0 +1,235 @@
+package postgres
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/skygenesisenterprise/aether-mailer/package/golang/domain"
+	"github.com/skygenesisenterprise/aether-mailer/package/golang/repository"
+)
+
+// ScheduledSendRepository stores the drafts held until they are due to be
+// sent in Postgres. Dispatchers on several instances share the table.
+type ScheduledSendRepository struct {
+	pool *pgxpool.Pool
+}
+
+// NewScheduledSendRepository creates a scheduled send repository backed by
+// the given pool
+func NewScheduledSendRepository(pool *pgxpool.Pool) *ScheduledSendRepository {
+	return &ScheduledSendRepository{pool: pool}
+}
+
+const scheduledSendColumns = `id, account_id, draft_id, kind, status, send_at, next_attempt_at, locked_until,
+	attempts, last_error, sent_message_id, created_at, updated_at, completed_at`
+
+// Create inserts a scheduled send. A draft has at most one pending send.
+func (r *ScheduledSendRepository) Create(ctx context.Context, send *domain.ScheduledSend) error {
+	_, err := querierFor(ctx, r.pool).Exec(ctx, `
+		INSERT INTO scheduled_sends (`+scheduledSendColumns+`)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14)`,
+		send.ID, send.AccountID, send.DraftID, string(send.Kind), string(send.Status), send.SendAt,
+		send.NextAttemptAt, send.LockedUntil, send.Attempts, send.LastError, send.SentMessageID,
+		send.CreatedAt, send.UpdatedAt, send.CompletedAt,
+	)
+	return err
+}
+
+// GetByID returns a scheduled send, or nil when it does not exist
+func (r *ScheduledSendRepository) GetByID(ctx context.Context, id string) (*domain.ScheduledSend, error) {
+	return r.getOne(ctx, `SELECT `+scheduledSendColumns+` FROM scheduled_sends WHERE id = $1`, id)
+}
+
+// GetPendingByDraftID returns the pending send of a draft, or nil
+func (r *ScheduledSendRepository) GetPendingByDraftID(ctx context.Context, draftID string) (*domain.ScheduledSend, error) {
+	return r.getOne(ctx, `
+		SELECT `+scheduledSendColumns+` FROM scheduled_sends
+		WHERE draft_id = $1 AND status = 'PENDING'`, draftID)
+}
+
+// List returns the scheduled sends matching a filter, soonest first
+func (r *ScheduledSendRepository) List(ctx context.Context, filter repository.ScheduledSendFilter) ([]*domain.ScheduledSend, error) {
+	c := scheduledSendConditions(filter)
+	rows, err := querierFor(ctx, r.pool).Query(ctx, `SELECT `+scheduledSendColumns+` FROM scheduled_sends`+c.where()+
+		` ORDER BY send_at, created_at, id`+c.page(filter.Limit, filter.Offset), c.args...)
+	if err != nil {
+		return nil, err
+	}
+	return collectScheduledSends(rows)
+}
+
+// Count returns the number of scheduled sends matching a filter
+func (r *ScheduledSendRepository) Count(ctx context.Context, filter repository.ScheduledSendFilter) (int, error) {
+	c := scheduledSendConditions(filter)
+	var count int
+	err := querierFor(ctx, r.pool).QueryRow(ctx, `SELECT COUNT(*) FROM scheduled_sends`+c.where(), c.args...).Scan(&count)
+	return count, err
+}
+
+// Claim leases up to limit pending sends due at now, soonest first. Sends
+// locked by another dispatcher are skipped.
+func (r *ScheduledSendRepository) Claim(ctx context.Context, now time.Time, limit int, lease time.Duration) ([]*domain.ScheduledSend, error) {
+	rows, err := querierFor(ctx, r.pool).Query(ctx, `
+		WITH due AS (
+			SELECT id FROM scheduled_sends
+			WHERE status = 'PENDING' AND next_attempt_at <= $1 AND (locked_until IS NULL OR locked_until <= $1)
+			ORDER BY next_attempt_at, created_at
+			LIMIT $2
+			FOR UPDATE SKIP LOCKED
+		)
+		UPDATE scheduled_sends s SET attempts = s.attempts + 1, locked_until = $3
+		FROM due
+		WHERE s.id = due.id
+		RETURNING s.id, s.account_id, s.draft_id, s.kind, s.status, s.send_at, s.next_attempt_at, s.locked_until,
+			s.attempts, s.last_error, s.sent_message_id, s.created_at, s.updated_at, s.completed_at`,
+		now, limit, now.Add(lease),
+	)
+	if err != nil {
+		return nil, err
+	}
+	sends, err := collectScheduledSends(rows)
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(sends, func(i, j int) bool {
+		if !sends[i].NextAttemptAt.Equal(sends[j].NextAttemptAt) {
+			return sends[i].NextAttemptAt.Before(sends[j].NextAttemptAt)
+		}
+		if !sends[i].CreatedAt.Equal(sends[j].CreatedAt) {
+			return sends[i].CreatedAt.Before(sends[j].CreatedAt)
+		}
+		return sends[i].ID < sends[j].ID
+	})
+	return sends, nil
+}
+
+// Lock leases a pending send that is not leased and reports false otherwise
+func (r *ScheduledSendRepository) Lock(ctx context.Context, id string, now time.Time, lease time.Duration) (bool, error) {
+	tag, err := querierFor(ctx, r.pool).Exec(ctx, `
+		UPDATE scheduled_sends SET locked_until = $3
+		WHERE id = $1 AND status = 'PENDING' AND (locked_until IS NULL OR locked_until <= $2)`,
+		id, now, now.Add(lease),
+	)
+	if err != nil {
+		return false, err
+	}
+	return tag.RowsAffected() > 0, nil
+}
+
+// Reschedule sets when a pending send is due and releases its lease
+func (r *ScheduledSendRepository) Reschedule(ctx context.Context, id string, sendAt, at time.Time) error {
+	_, err := querierFor(ctx, r.pool).Exec(ctx, `
+		UPDATE scheduled_sends SET send_at = $2, next_attempt_at = $2, locked_until = NULL, last_error = '', updated_at = $3
+		WHERE id = $1 AND status = 'PENDING'`,
+		id, sendAt, at,
+	)
+	return err
+}
+
+// MarkFailed records a failed attempt of a claimed send, releases the lease
+// and sets when to try again
+func (r *ScheduledSendRepository) MarkFailed(ctx context.Context, claim *domain.ScheduledSend, lastError string, nextAttemptAt time.Time) (bool, error) {
+	tag, err := querierFor(ctx, r.pool).Exec(ctx, `
+		UPDATE scheduled_sends SET last_error = $4, next_attempt_at = $5, locked_until = NULL
+		WHERE id = $1 AND status = 'PENDING' AND locked_until = $2 AND attempts = $3`,
+		claim.ID, claim.LockedUntil, claim.Attempts, lastError, nextAttemptAt,
+	)
+	if err != nil {
+		return false, err
+	}
+	return tag.RowsAffected() > 0, nil
+}
+
+// Complete ends a claimed send
+func (r *ScheduledSendRepository) Complete(ctx context.Context, claim *domain.ScheduledSend, status domain.ScheduledSendStatus, sentMessageID *string, lastError string, at time.Time) (bool, error) {
+	tag, err := querierFor(ctx, r.pool).Exec(ctx, `
+		UPDATE scheduled_sends SET status = $4, sent_message_id = $5, last_error = $6, locked_until = NULL,
+			updated_at = $7, completed_at = $7
+		WHERE id = $1 AND status = 'PENDING' AND locked_until = $2 AND attempts = $3`,
+		claim.ID, claim.LockedUntil, claim.Attempts, string(status), sentMessageID, lastError, at,
+	)
+	if err != nil {
+		return false, err
+	}
+	return tag.RowsAffected() > 0, nil
+}
+
+// Cancel cancels a pending send that is not leased and reports false
+// otherwise
+func (r *ScheduledSendRepository) Cancel(ctx context.Context, id string, now time.Time) (bool, error) {
+	tag, err := querierFor(ctx, r.pool).Exec(ctx, `
+		UPDATE scheduled_sends SET status = 'CANCELLED', updated_at = $2, completed_at = $2
+		WHERE id = $1 AND status = 'PENDING' AND (locked_until IS NULL OR locked_until <= $2)`,
+		id, now,
+	)
+	if err != nil {
+		return false, err
+	}
+	return tag.RowsAffected() > 0, nil
+}
+
+// DeleteCompleted removes the sends completed before a time
+func (r *ScheduledSendRepository) DeleteCompleted(ctx context.Context, before time.Time) (int, error) {
+	tag, err := querierFor(ctx, r.pool).Exec(ctx, `
+		DELETE FROM scheduled_sends WHERE status <> 'PENDING' AND completed_at < $1`, before)
+	if err != nil {
+		return 0, err
+	}
+	return int(tag.RowsAffected()), nil
+}
+
+func (r *ScheduledSendRepository) getOne(ctx context.Context, query string, args ...interface{}) (*domain.ScheduledSend, error) {
+	send, err := scanScheduledSend(querierFor(ctx, r.pool).QueryRow(ctx, query, args...))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return send, nil
+}
+
+func scheduledSendConditions(filter repository.ScheduledSendFilter) *conditions {
+	c := &conditions{}
+	if filter.AccountID != "" {
+		c.add("account_id = ?", filter.AccountID)
+	}
+	if filter.Status != nil {
+		c.add("status = ?", string(*filter.Status))
+	}
+	return c
+}
+
+func collectScheduledSends(rows pgx.Rows) ([]*domain.ScheduledSend, error) {
+	defer rows.Close()
+
+	sends := []*domain.ScheduledSend{}
+	for rows.Next() {
+		send, err := scanScheduledSend(rows)
+		if err != nil {
+			return nil, err
+		}
+		sends = append(sends, send)
+	}
+	return sends, rows.Err()
+}
+
+func scanScheduledSend(row pgx.Row) (*domain.ScheduledSend, error) {
+	send := &domain.ScheduledSend{}
+	var kind, status string
+	err := row.Scan(
+		&send.ID, &send.AccountID, &send.DraftID, &kind, &status, &send.SendAt, &send.NextAttemptAt,
+		&send.LockedUntil, &send.Attempts, &send.LastError, &send.SentMessageID, &send.CreatedAt,
+		&send.UpdatedAt, &send.CompletedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	send.Kind = domain.ScheduledSendKind(kind)
+	send.Status = domain.ScheduledSendStatus(status)
+	return send, nil
+}
@@ -0,0 +1,179 @@
+package postgres
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/skygenesisenterprise/aether-mailer/package/golang/domain"
+	"github.com/skygenesisenterprise/aether-mailer/package/golang/repository"
+)
+
+// SearchIndex is the default full-text index: a tsvector document per
+// message in the message_search table. Documents are built from the words
+// of domain.SearchWords, so Postgres and the other backends agree on what
+// a word is, and the weights of the subject, sender and recipients serve
+// the subject:, from: and to: operators.
+type SearchIndex struct {
+	pool *pgxpool.Pool
+}
+
+// NewSearchIndex creates a search index backed by the given pool
+func NewSearchIndex(pool *pgxpool.Pool) *SearchIndex {
+	return &SearchIndex{pool: pool}
+}
+
+// searchWeights are the tsvector weights of the fields of a phrase
+var searchWeights = map[domain.SearchField]string{
+	domain.SearchFieldSubject: "A",
+	domain.SearchFieldFrom:    "B",
+	domain.SearchFieldTo:      "C",
+}
+
+// Index writes the document of a message, replacing the previous one. A
+// document for a message that does not exist is ignored.
+func (x *SearchIndex) Index(ctx context.Context, document *domain.SearchDocument) error {
+	return indexDocument(ctx, querierFor(ctx, x.pool), document)
+}
+
+// Remove removes the document of a message
+func (x *SearchIndex) Remove(ctx context.Context, messageID string) error {
+	_, err := querierFor(ctx, x.pool).Exec(ctx, `DELETE FROM message_search WHERE message_id = $1`, messageID)
+	return err
+}
+
+// RemoveAccount removes the documents of every message of an account
+func (x *SearchIndex) RemoveAccount(ctx context.Context, accountID string) error {
+	_, err := querierFor(ctx, x.pool).Exec(ctx, `
+		DELETE FROM message_search WHERE message_id IN (SELECT id FROM messages WHERE account_id = $1)`, accountID)
+	return err
+}
+
+// Search returns the hits of an account, best first, and their total. The
+// score is the ts_rank of the document divided by the age of the message
+// in units of domain.SearchRecencyHalfLife, plus one.
+func (x *SearchIndex) Search(ctx context.Context, query repository.SearchIndexQuery) ([]repository.SearchHit, int, error) {
+	search := query.Query
+	if search == nil {
+		search = &domain.SearchQuery{}
+	}
+	c := &conditions{}
+	c.add("messages.account_id = ?", query.AccountID)
+	if query.FolderID != nil {
+		c.add("messages.folder_id IS NOT DISTINCT FROM ?", nullString(*query.FolderID))
+	}
+	from, rank := searchConditions(c, search)
+
+	var total int
+	err := querierFor(ctx, x.pool).QueryRow(ctx, `SELECT COUNT(*) FROM `+from+c.where(), c.args...).Scan(&total)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	c.args = append(c.args, domain.SearchRecencyHalfLife.Seconds())
+	score := fmt.Sprintf(`%s / (1 + GREATEST(EXTRACT(EPOCH FROM now() - messages.received_at), 0)::float8 / $%d::float8)`,
+		rank, len(c.args))
+	rows, err := querierFor(ctx, x.pool).Query(ctx, `
+		SELECT messages.id, `+score+` AS score FROM `+from+c.where()+`
+		ORDER BY score DESC, messages.received_at DESC, messages.id`+c.page(query.Limit, query.Offset), c.args...)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	hits := []repository.SearchHit{}
+	for rows.Next() {
+		var hit repository.SearchHit
+		if err := rows.Scan(&hit.MessageID, &hit.Score); err != nil {
+			return nil, 0, err
+		}
+		hits = append(hits, hit)
+	}
+	return hits, total, rows.Err()
+}
+
+// indexDocument writes the document of a message that exists
+func indexDocument(ctx context.Context, q querier, document *domain.SearchDocument) error {
+	text := document.Text()
+	_, err := q.Exec(ctx, `
+		INSERT INTO message_search (message_id, document, indexed_at)
+		SELECT id,
+			setweight(to_tsvector('simple', $2), 'A') || setweight(to_tsvector('simple', $3), 'B') ||
+			setweight(to_tsvector('simple', $4), 'C') || setweight(to_tsvector('simple', $5), 'D'),
+			$6
+		FROM messages WHERE id = $1
+		ON CONFLICT (message_id) DO UPDATE SET document = EXCLUDED.document, indexed_at = EXCLUDED.indexed_at`,
+		document.MessageID, strings.Join(text.Subject, " "), strings.Join(text.From, " "),
+		strings.Join(text.To, " "), strings.Join(text.Body, " "), time.Now(),
+	)
+	return err
+}
+
+// searchConditions adds the conditions of a search query on messages. It
+// returns the FROM clause, which joins the documents when the query has
+// text, and the rank of a row.
+func searchConditions(c *conditions, search *domain.SearchQuery) (from, rank string) {
+	from, rank = "messages", "1::float8"
+	if search.HasText() {
+		from = "messages JOIN message_search ON message_search.message_id = messages.id"
+		c.add("message_search.document @@ to_tsquery('simple', ?)", tsQuery(search))
+		rank = fmt.Sprintf("ts_rank(message_search.document, to_tsquery('simple', $%d))::float8", len(c.args))
+	}
+	if search.HasAttachment != nil {
+		c.add("EXISTS (SELECT 1 FROM attachments WHERE message_id = messages.id) = ?", *search.HasAttachment)
+	}
+	if search.IsRead != nil {
+		c.add("messages.is_read = ?", *search.IsRead)
+	}
+	if search.IsStarred != nil {
+		c.add("messages.is_starred = ?", *search.IsStarred)
+	}
+	if search.IsFlagged != nil {
+		c.add("messages.is_flagged = ?", *search.IsFlagged)
+	}
+	if len(search.Labels) > 0 {
+		c.add("messages.labels @> ?", search.Labels)
+	}
+	if len(search.ExcludedLabels) > 0 {
+		c.add("NOT messages.labels && ?", search.ExcludedLabels)
+	}
+	if search.After != nil {
+		c.add("messages.received_at >= ?", *search.After)
+	}
+	if search.Before != nil {
+		c.add("messages.received_at < ?", *search.Before)
+	}
+	return from, rank
+}
+
+// tsQuery writes the phrases of a search query as a tsquery. Words hold
+// only letters and digits, so they are quoted as they are; OR without
+// text to the left has already been dropped by the parser.
+func tsQuery(search *domain.SearchQuery) string {
+	parts := []string{}
+	for _, clause := range search.Clauses {
+		alternatives := make([]string, 0, len(clause))
+		for _, phrase := range clause {
+			alternatives = append(alternatives, tsPhrase(phrase))
+		}
+		parts = append(parts, "("+strings.Join(alternatives, " | ")+")")
+	}
+	for _, phrase := range search.Excluded {
+		parts = append(parts, "!("+tsPhrase(phrase)+")")
+	}
+	return strings.Join(parts, " & ")
+}
+
+func tsPhrase(phrase domain.SearchPhrase) string {
+	weight := ""
+	if w, ok := searchWeights[phrase.Field]; ok {
+		weight = ":" + w
+	}
+	words := make([]string, 0, len(phrase.Words))
+	for _, word := range phrase.Words {
+		words = append(words, "'"+word+"'"+weight)
+	}
+	return strings.Join(words, " <-> ")
+}
@@ -0,0 +1,128 @@
+package postgres
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/skygenesisenterprise/aether-mailer/package/golang/domain"
+)
+
+// SpamRepository stores per-account spam training data in Postgres.
+// Counters are changed with upserts, never dropping below zero, so
+// concurrent training never loses an update.
+type SpamRepository struct {
+	pool *pgxpool.Pool
+}
+
+// NewSpamRepository creates a spam repository backed by the given pool
+func NewSpamRepository(pool *pgxpool.Pool) *SpamRepository {
+	return &SpamRepository{pool: pool}
+}
+
+// GetTokens returns the counts of the given tokens that have been trained
+func (r *SpamRepository) GetTokens(ctx context.Context, accountID string, tokens []string) (map[string]*domain.SpamToken, error) {
+	rows, err := querierFor(ctx, r.pool).Query(ctx, `
+		SELECT account_id, token, spam_count, ham_count, updated_at
+		FROM spam_tokens WHERE account_id = $1 AND token = ANY($2)`,
+		accountID, tokens,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	result := make(map[string]*domain.SpamToken)
+	for rows.Next() {
+		token := &domain.SpamToken{}
+		if err := rows.Scan(&token.AccountID, &token.Token, &token.SpamCount, &token.HamCount, &token.UpdatedAt); err != nil {
+			return nil, err
+		}
+		result[token.Token] = token
+	}
+	return result, rows.Err()
+}
+
+// IncrementTokens adds the deltas to the counts of every token
+func (r *SpamRepository) IncrementTokens(ctx context.Context, accountID string, tokens []string, spamDelta, hamDelta int) error {
+	if len(tokens) == 0 {
+		return nil
+	}
+	_, err := querierFor(ctx, r.pool).Exec(ctx, `
+		INSERT INTO spam_tokens (account_id, token, spam_count, ham_count, updated_at)
+		SELECT $1, token, GREATEST($3, 0), GREATEST($4, 0), $5
+		FROM (SELECT DISTINCT unnest($2::text[]) AS token) t
+		ON CONFLICT (account_id, token) DO UPDATE SET
+			spam_count = GREATEST(spam_tokens.spam_count + $3, 0),
+			ham_count = GREATEST(spam_tokens.ham_count + $4, 0),
+			updated_at = EXCLUDED.updated_at`,
+		accountID, tokens, spamDelta, hamDelta, time.Now(),
+	)
+	return err
+}
+
+// GetTotals returns the training totals of an account, or nil when it has
+// not been trained
+func (r *SpamRepository) GetTotals(ctx context.Context, accountID string) (*domain.SpamTotals, error) {
+	totals := &domain.SpamTotals{}
+	err := querierFor(ctx, r.pool).QueryRow(ctx, `
+		SELECT account_id, spam_messages, ham_messages, updated_at
+		FROM spam_totals WHERE account_id = $1`,
+		accountID,
+	).Scan(&totals.AccountID, &totals.SpamMessages, &totals.HamMessages, &totals.UpdatedAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return totals, nil
+}
+
+// IncrementTotals adds the deltas to the training totals of an account
+func (r *SpamRepository) IncrementTotals(ctx context.Context, accountID string, spamDelta, hamDelta int) error {
+	_, err := querierFor(ctx, r.pool).Exec(ctx, `
+		INSERT INTO spam_totals (account_id, spam_messages, ham_messages, updated_at)
+		VALUES ($1, GREATEST($2, 0), GREATEST($3, 0), $4)
+		ON CONFLICT (account_id) DO UPDATE SET
+			spam_messages = GREATEST(spam_totals.spam_messages + $2, 0),
+			ham_messages = GREATEST(spam_totals.ham_messages + $3, 0),
+			updated_at = EXCLUDED.updated_at`,
+		accountID, spamDelta, hamDelta, time.Now(),
+	)
+	return err
+}
+
+// GetMessageClass returns the class a message was trained as, or nil
+func (r *SpamRepository) GetMessageClass(ctx context.Context, accountID, messageID string) (*domain.SpamClass, error) {
+	var class domain.SpamClass
+	err := querierFor(ctx, r.pool).QueryRow(ctx, `
+		SELECT class FROM spam_message_classes WHERE account_id = $1 AND message_id = $2`,
+		accountID, messageID,
+	).Scan(&class)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &class, nil
+}
+
+// SetMessageClass records the class a message was trained as, or forgets
+// it when class is nil
+func (r *SpamRepository) SetMessageClass(ctx context.Context, accountID, messageID string, class *domain.SpamClass) error {
+	if class == nil {
+		_, err := querierFor(ctx, r.pool).Exec(ctx, `DELETE FROM spam_message_classes WHERE account_id = $1 AND message_id = $2`,
+			accountID, messageID)
+		return err
+	}
+	_, err := querierFor(ctx, r.pool).Exec(ctx, `
+		INSERT INTO spam_message_classes (account_id, message_id, class) VALUES ($1, $2, $3)
+		ON CONFLICT (account_id, message_id) DO UPDATE SET class = EXCLUDED.class`,
+		accountID, messageID, string(*class),
+	)
+	return err
+}
@@ -0,0 +1,212 @@
+package postgres
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/skygenesisenterprise/aether-mailer/package/golang/domain"
+	"github.com/skygenesisenterprise/aether-mailer/package/golang/repository"
+)
+
+// ThreadRepository stores conversation threads in Postgres, with the
+// Message-IDs that lead to them in thread_message_ids
+type ThreadRepository struct {
+	pool *pgxpool.Pool
+}
+
+// NewThreadRepository creates a thread repository backed by the given pool
+func NewThreadRepository(pool *pgxpool.Pool) *ThreadRepository {
+	return &ThreadRepository{pool: pool}
+}
+
+const threadColumns = `id, account_id, subject, base_subject, message_count, unread_count, has_attachments, is_starred,
+	participants, last_message_at, created_at, updated_at`
+
+// Create inserts a thread
+func (r *ThreadRepository) Create(ctx context.Context, thread *domain.Thread) error {
+	_, err := querierFor(ctx, r.pool).Exec(ctx, `
+		INSERT INTO threads (`+threadColumns+`)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12)`,
+		thread.ID, thread.AccountID, thread.Subject, thread.BaseSubject, thread.MessageCount, thread.UnreadCount,
+		thread.HasAttachments, thread.IsStarred, stringSlice(thread.Participants), thread.LastMessageAt,
+		thread.CreatedAt, thread.UpdatedAt,
+	)
+	return err
+}
+
+// GetByID returns a thread, or nil when it does not exist
+func (r *ThreadRepository) GetByID(ctx context.Context, id string) (*domain.Thread, error) {
+	return r.getOne(ctx, `SELECT `+threadColumns+` FROM threads WHERE id = $1`, id)
+}
+
+// Update saves a thread
+func (r *ThreadRepository) Update(ctx context.Context, thread *domain.Thread) error {
+	_, err := querierFor(ctx, r.pool).Exec(ctx, `
+		UPDATE threads SET subject = $2, base_subject = $3, message_count = $4, unread_count = $5,
+			has_attachments = $6, is_starred = $7, participants = $8, last_message_at = $9, updated_at = $10
+		WHERE id = $1`,
+		thread.ID, thread.Subject, thread.BaseSubject, thread.MessageCount, thread.UnreadCount,
+		thread.HasAttachments, thread.IsStarred, stringSlice(thread.Participants), thread.LastMessageAt,
+		thread.UpdatedAt,
+	)
+	return err
+}
+
+// Delete removes a thread; its Message-IDs are removed by cascade
+func (r *ThreadRepository) Delete(ctx context.Context, id string) error {
+	_, err := querierFor(ctx, r.pool).Exec(ctx, `DELETE FROM threads WHERE id = $1`, id)
+	return err
+}
+
+// ListByAccount returns the threads of an account matching a filter,
+// latest message first
+func (r *ThreadRepository) ListByAccount(ctx context.Context, accountID string, filter repository.ThreadFilter) ([]*domain.Thread, error) {
+	c := threadConditions(accountID, filter)
+	return r.list(ctx, `SELECT `+threadColumns+` FROM threads`+c.where()+
+		` ORDER BY last_message_at DESC, id`+c.page(filter.Limit, filter.Offset), c.args...)
+}
+
+// CountByAccount returns the number of threads of an account matching a filter
+func (r *ThreadRepository) CountByAccount(ctx context.Context, accountID string, filter repository.ThreadFilter) (int, error) {
+	c := threadConditions(accountID, filter)
+	var count int
+	err := querierFor(ctx, r.pool).QueryRow(ctx, `SELECT COUNT(*) FROM threads`+c.where(), c.args...).Scan(&count)
+	return count, err
+}
+
+// FindByMessageIDs returns the threads any of the Message-IDs lead to,
+// oldest first
+func (r *ThreadRepository) FindByMessageIDs(ctx context.Context, accountID string, messageIDs []string) ([]*domain.Thread, error) {
+	if len(messageIDs) == 0 {
+		return []*domain.Thread{}, nil
+	}
+	return r.list(ctx, `
+		SELECT `+threadColumns+` FROM threads WHERE id IN (
+			SELECT thread_id FROM thread_message_ids WHERE account_id = $1 AND message_id = ANY($2::text[]))
+		ORDER BY created_at, id`,
+		accountID, messageIDs,
+	)
+}
+
+// FindBySubject returns the thread with a base subject that had the latest
+// message at or after since, or nil
+func (r *ThreadRepository) FindBySubject(ctx context.Context, accountID, baseSubject string, since time.Time) (*domain.Thread, error) {
+	return r.getOne(ctx, `
+		SELECT `+threadColumns+` FROM threads
+		WHERE account_id = $1 AND base_subject = $2 AND last_message_at >= $3
+		ORDER BY last_message_at DESC, id LIMIT 1`,
+		accountID, baseSubject, since,
+	)
+}
+
+// LinkMessageIDs makes Message-IDs lead to a thread, replacing the threads
+// they led to
+func (r *ThreadRepository) LinkMessageIDs(ctx context.Context, accountID, threadID string, messageIDs []string) error {
+	if len(messageIDs) == 0 {
+		return nil
+	}
+	_, err := querierFor(ctx, r.pool).Exec(ctx, `
+		INSERT INTO thread_message_ids (account_id, message_id, thread_id)
+		SELECT $1, message_id, $3 FROM (SELECT DISTINCT unnest($2::text[]) AS message_id) ids
+		ON CONFLICT (account_id, message_id) DO UPDATE SET thread_id = EXCLUDED.thread_id`,
+		accountID, messageIDs, threadID,
+	)
+	return err
+}
+
+// Merge moves the Message-IDs of a thread to another and deletes it
+func (r *ThreadRepository) Merge(ctx context.Context, fromID, intoID string) error {
+	if fromID == intoID {
+		return nil
+	}
+	return pgx.BeginFunc(ctx, querierFor(ctx, r.pool), func(tx pgx.Tx) error {
+		_, err := tx.Exec(ctx, `UPDATE thread_message_ids SET thread_id = $2 WHERE thread_id = $1`, fromID, intoID)
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec(ctx, `DELETE FROM threads WHERE id = $1`, fromID)
+		return err
+	})
+}
+
+// GetSettings returns the thread settings of an account, or nil when it
+// has none
+func (r *ThreadRepository) GetSettings(ctx context.Context, accountID string) (*domain.ThreadSettings, error) {
+	settings := &domain.ThreadSettings{}
+	var mode string
+	err := querierFor(ctx, r.pool).QueryRow(ctx, `
+		SELECT account_id, subject_mode, updated_at FROM thread_settings WHERE account_id = $1`, accountID,
+	).Scan(&settings.AccountID, &mode, &settings.UpdatedAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	settings.SubjectMode = domain.ThreadSubjectMode(mode)
+	return settings, nil
+}
+
+// SaveSettings creates or replaces the thread settings of an account
+func (r *ThreadRepository) SaveSettings(ctx context.Context, settings *domain.ThreadSettings) error {
+	_, err := querierFor(ctx, r.pool).Exec(ctx, `
+		INSERT INTO thread_settings (account_id, subject_mode, updated_at) VALUES ($1, $2, $3)
+		ON CONFLICT (account_id) DO UPDATE SET subject_mode = EXCLUDED.subject_mode, updated_at = EXCLUDED.updated_at`,
+		settings.AccountID, string(settings.SubjectMode), settings.UpdatedAt,
+	)
+	return err
+}
+
+func (r *ThreadRepository) getOne(ctx context.Context, query string, args ...interface{}) (*domain.Thread, error) {
+	thread, err := scanThread(querierFor(ctx, r.pool).QueryRow(ctx, query, args...))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return thread, nil
+}
+
+func (r *ThreadRepository) list(ctx context.Context, query string, args ...interface{}) ([]*domain.Thread, error) {
+	rows, err := querierFor(ctx, r.pool).Query(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	threads := []*domain.Thread{}
+	for rows.Next() {
+		thread, err := scanThread(rows)
+		if err != nil {
+			return nil, err
+		}
+		threads = append(threads, thread)
+	}
+	return threads, rows.Err()
+}
+
+func threadConditions(accountID string, filter repository.ThreadFilter) *conditions {
+	c := &conditions{}
+	c.add("account_id = ?", accountID)
+	if filter.IsUnread != nil {
+		c.add("(unread_count > 0) = ?", *filter.IsUnread)
+	}
+	return c
+}
+
+func scanThread(row pgx.Row) (*domain.Thread, error) {
+	thread := &domain.Thread{}
+	err := row.Scan(
+		&thread.ID, &thread.AccountID, &thread.Subject, &thread.BaseSubject, &thread.MessageCount,
+		&thread.UnreadCount, &thread.HasAttachments, &thread.IsStarred, &thread.Participants,
+		&thread.LastMessageAt, &thread.CreatedAt, &thread.UpdatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return thread, nil
+}
@@ -0,0 +1,148 @@
+package postgres
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/skygenesisenterprise/aether-mailer/package/golang/domain"
+)
+
+// MTASTSPolicyRepository stores the MTA-STS policy cache in Postgres so
+// policies survive restarts (RFC 8461 section 5.1)
+type MTASTSPolicyRepository struct {
+	pool *pgxpool.Pool
+}
+
+// NewMTASTSPolicyRepository creates an MTA-STS policy repository backed by the given pool
+func NewMTASTSPolicyRepository(pool *pgxpool.Pool) *MTASTSPolicyRepository {
+	return &MTASTSPolicyRepository{pool: pool}
+}
+
+const mtaSTSPolicyColumns = `domain, policy_id, mode, mx, max_age_seconds, policy_text, fetched_at, expires_at`
+
+// Get returns the cached policy of a domain, or nil when none is cached
+func (r *MTASTSPolicyRepository) Get(ctx context.Context, domainName string) (*domain.MTASTSPolicy, error) {
+	row := querierFor(ctx, r.pool).QueryRow(ctx, `SELECT `+mtaSTSPolicyColumns+` FROM mta_sts_policies WHERE domain = $1`, domainName)
+
+	policy := &domain.MTASTSPolicy{}
+	var maxAgeSeconds int64
+	err := row.Scan(
+		&policy.Domain, &policy.ID, &policy.Mode, &policy.MX, &maxAgeSeconds, &policy.Text,
+		&policy.FetchedAt, &policy.ExpiresAt,
+	)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	policy.MaxAge = time.Duration(maxAgeSeconds) * time.Second
+	return policy, nil
+}
+
+// Save inserts or replaces the cached policy of a domain
+func (r *MTASTSPolicyRepository) Save(ctx context.Context, policy *domain.MTASTSPolicy) error {
+	_, err := querierFor(ctx, r.pool).Exec(ctx, `
+		INSERT INTO mta_sts_policies (`+mtaSTSPolicyColumns+`)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+		ON CONFLICT (domain) DO UPDATE
+		SET policy_id = EXCLUDED.policy_id, mode = EXCLUDED.mode, mx = EXCLUDED.mx,
+			max_age_seconds = EXCLUDED.max_age_seconds, policy_text = EXCLUDED.policy_text,
+			fetched_at = EXCLUDED.fetched_at, expires_at = EXCLUDED.expires_at`,
+		policy.Domain, policy.ID, string(policy.Mode), policy.MX, int64(policy.MaxAge/time.Second), policy.Text,
+		policy.FetchedAt, policy.ExpiresAt,
+	)
+	return err
+}
+
+// DeleteExpired removes policies that expired before the given time
+func (r *MTASTSPolicyRepository) DeleteExpired(ctx context.Context, before time.Time) (int, error) {
+	tag, err := querierFor(ctx, r.pool).Exec(ctx, `DELETE FROM mta_sts_policies WHERE expires_at < $1`, before)
+	if err != nil {
+		return 0, err
+	}
+	return int(tag.RowsAffected()), nil
+}
+
+// TLSResultRepository aggregates TLS-RPT session results in Postgres, one
+// counter per UTC day, domain, policy, MX host and result type. Successful
+// sessions have an empty result type.
+type TLSResultRepository struct {
+	pool *pgxpool.Pool
+}
+
+// NewTLSResultRepository creates a TLS result repository backed by the given pool
+func NewTLSResultRepository(pool *pgxpool.Pool) *TLSResultRepository {
+	return &TLSResultRepository{pool: pool}
+}
+
+// Record counts one session result
+func (r *TLSResultRepository) Record(ctx context.Context, result *domain.TLSResult) error {
+	_, err := querierFor(ctx, r.pool).Exec(ctx, `
+		INSERT INTO tls_results (day, domain, policy_type, policy_string, mx_host, result_type, session_count)
+		VALUES ($1, $2, $3, $4, $5, $6, 1)
+		ON CONFLICT (day, domain, policy_type, policy_string, mx_host, result_type) DO UPDATE
+		SET session_count = tls_results.session_count + 1`,
+		result.At.UTC().Truncate(24*time.Hour), result.Domain, string(result.PolicyType),
+		strings.Join(result.PolicyStrings, "\n"), result.MXHost, string(result.ResultType),
+	)
+	return err
+}
+
+// Summarize returns the counters of a domain for the days overlapping
+// [from, to)
+func (r *TLSResultRepository) Summarize(ctx context.Context, domainName string, from, to time.Time) ([]*domain.TLSResultSummary, error) {
+	rows, err := querierFor(ctx, r.pool).Query(ctx, `
+		SELECT policy_type, policy_string, mx_host, result_type, SUM(session_count)
+		FROM tls_results
+		WHERE domain = $1 AND day >= $2 AND day < $3
+		GROUP BY policy_type, policy_string, mx_host, result_type
+		ORDER BY policy_type, policy_string, result_type, mx_host`,
+		domainName, from.UTC().Truncate(24*time.Hour), to.UTC(),
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	summaries := []*domain.TLSResultSummary{}
+	for rows.Next() {
+		summary := &domain.TLSResultSummary{Domain: domainName}
+		var policyString string
+		if err := rows.Scan(&summary.PolicyType, &policyString, &summary.MXHost, &summary.ResultType, &summary.Count); err != nil {
+			return nil, err
+		}
+		if policyString != "" {
+			summary.PolicyStrings = strings.Split(policyString, "\n")
+		}
+		summaries = append(summaries, summary)
+	}
+	return summaries, rows.Err()
+}
+
+// ListDomains returns the domains with results for the days overlapping
+// [from, to)
+func (r *TLSResultRepository) ListDomains(ctx context.Context, from, to time.Time) ([]string, error) {
+	rows, err := querierFor(ctx, r.pool).Query(ctx, `
+		SELECT DISTINCT domain FROM tls_results WHERE day >= $1 AND day < $2 ORDER BY domain`,
+		from.UTC().Truncate(24*time.Hour), to.UTC(),
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	domains := []string{}
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		domains = append(domains, name)
+	}
+	return domains, rows.Err()
+}
@@ -0,0 +1,47 @@
+package postgres
+
+import (
+	"context"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// txKey carries the transaction of a WithinTransaction call in a context
+type txKey struct{}
+
+// Transactor runs work in a Postgres transaction. Every repository of this
+// package called with the context handed to the work joins the transaction.
+type Transactor struct {
+	pool *pgxpool.Pool
+}
+
+// NewTransactor creates a transactor backed by the given pool
+func NewTransactor(pool *pgxpool.Pool) *Transactor {
+	return &Transactor{pool: pool}
+}
+
+// WithinTransaction runs fn in a transaction that commits when fn returns
+// nil and rolls back otherwise. Nested calls use a savepoint.
+func (t *Transactor) WithinTransaction(ctx context.Context, fn func(ctx context.Context) error) error {
+	return pgx.BeginFunc(ctx, querierFor(ctx, t.pool), func(tx pgx.Tx) error {
+		return fn(context.WithValue(ctx, txKey{}, tx))
+	})
+}
+
+// querier is implemented by both pools and transactions
+type querier interface {
+	Exec(ctx context.Context, sql string, args ...any) (pgconn.CommandTag, error)
+	Query(ctx context.Context, sql string, args ...any) (pgx.Rows, error)
+	QueryRow(ctx context.Context, sql string, args ...any) pgx.Row
+	Begin(ctx context.Context) (pgx.Tx, error)
+}
+
+// querierFor returns the transaction of ctx, or the pool outside of one
+func querierFor(ctx context.Context, pool *pgxpool.Pool) querier {
+	if tx, ok := ctx.Value(txKey{}).(pgx.Tx); ok {
+		return tx
+	}
+	return pool
+}
@@ -0,0 +1,146 @@
+package postgres
+
+import (
+	"context"
+	"errors"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/skygenesisenterprise/aether-mailer/package/golang/domain"
+	"github.com/skygenesisenterprise/aether-mailer/package/golang/repository"
+)
+
+// UserRepository stores users in Postgres
+type UserRepository struct {
+	pool *pgxpool.Pool
+}
+
+// NewUserRepository creates a user repository backed by the given pool
+func NewUserRepository(pool *pgxpool.Pool) *UserRepository {
+	return &UserRepository{pool: pool}
+}
+
+const userColumns = `id, username, email, password_hash, first_name, last_name, display_name, role, is_active,
+	is_verified, two_factor_enabled, two_factor_secret, created_at, updated_at, last_login_at, password_changed_at,
+	timezone, locale, theme, max_emails_per_day, max_storage_mb`
+
+// Create inserts a user
+func (r *UserRepository) Create(ctx context.Context, user *domain.User) error {
+	_, err := querierFor(ctx, r.pool).Exec(ctx, `
+		INSERT INTO users (`+userColumns+`)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17, $18, $19, $20, $21)`,
+		user.ID, user.Username, user.Email, user.PasswordHash, user.FirstName, user.LastName, user.DisplayName,
+		string(user.Role), user.IsActive, user.IsVerified, user.TwoFactorEnabled, user.TwoFactorSecret,
+		user.CreatedAt, user.UpdatedAt, user.LastLoginAt, user.PasswordChangedAt, user.Timezone, user.Locale,
+		user.Theme, user.MaxEmailsPerDay, user.MaxStorageMB,
+	)
+	return err
+}
+
+// GetByID returns a user, or nil when it does not exist
+func (r *UserRepository) GetByID(ctx context.Context, id string) (*domain.User, error) {
+	return r.getOne(ctx, `SELECT `+userColumns+` FROM users WHERE id = $1`, id)
+}
+
+// GetByEmail returns the user with an email address, or nil
+func (r *UserRepository) GetByEmail(ctx context.Context, email string) (*domain.User, error) {
+	return r.getOne(ctx, `SELECT `+userColumns+` FROM users WHERE email = $1`, email)
+}
+
+// GetByUsername returns the user with a username, or nil
+func (r *UserRepository) GetByUsername(ctx context.Context, username string) (*domain.User, error) {
+	return r.getOne(ctx, `SELECT `+userColumns+` FROM users WHERE username = $1`, username)
+}
+
+// Update saves a user
+func (r *UserRepository) Update(ctx context.Context, user *domain.User) error {
+	_, err := querierFor(ctx, r.pool).Exec(ctx, `
+		UPDATE users SET username = $2, email = $3, password_hash = $4, first_name = $5, last_name = $6,
+			display_name = $7, role = $8, is_active = $9, is_verified = $10, two_factor_enabled = $11,
+			two_factor_secret = $12, updated_at = $13, last_login_at = $14, password_changed_at = $15,
+			timezone = $16, locale = $17, theme = $18, max_emails_per_day = $19, max_storage_mb = $20
+		WHERE id = $1`,
+		user.ID, user.Username, user.Email, user.PasswordHash, user.FirstName, user.LastName, user.DisplayName,
+		string(user.Role), user.IsActive, user.IsVerified, user.TwoFactorEnabled, user.TwoFactorSecret,
+		user.UpdatedAt, user.LastLoginAt, user.PasswordChangedAt, user.Timezone, user.Locale, user.Theme,
+		user.MaxEmailsPerDay, user.MaxStorageMB,
+	)
+	return err
+}
+
+// Delete removes a user; memberships and accounts are removed by cascade
+func (r *UserRepository) Delete(ctx context.Context, id string) error {
+	_, err := querierFor(ctx, r.pool).Exec(ctx, `DELETE FROM users WHERE id = $1`, id)
+	return err
+}
+
+// List returns the users matching a filter, newest first
+func (r *UserRepository) List(ctx context.Context, filter repository.UserFilter) ([]*domain.User, error) {
+	c := userConditions(filter)
+	query := `SELECT ` + userColumns + ` FROM users` + c.where() + ` ORDER BY created_at DESC` + c.page(filter.Limit, filter.Offset)
+	rows, err := querierFor(ctx, r.pool).Query(ctx, query, c.args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	users := []*domain.User{}
+	for rows.Next() {
+		user, err := scanUser(rows)
+		if err != nil {
+			return nil, err
+		}
+		users = append(users, user)
+	}
+	return users, rows.Err()
+}
+
+// Count returns the number of users matching a filter
+func (r *UserRepository) Count(ctx context.Context, filter repository.UserFilter) (int, error) {
+	c := userConditions(filter)
+	var count int
+	err := querierFor(ctx, r.pool).QueryRow(ctx, `SELECT COUNT(*) FROM users`+c.where(), c.args...).Scan(&count)
+	return count, err
+}
+
+func (r *UserRepository) getOne(ctx context.Context, query string, args ...interface{}) (*domain.User, error) {
+	user, err := scanUser(querierFor(ctx, r.pool).QueryRow(ctx, query, args...))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+func userConditions(filter repository.UserFilter) *conditions {
+	c := &conditions{}
+	if filter.Role != nil {
+		c.add("role = ?", string(*filter.Role))
+	}
+	if filter.IsActive != nil {
+		c.add("is_active = ?", *filter.IsActive)
+	}
+	if filter.IsVerified != nil {
+		c.add("is_verified = ?", *filter.IsVerified)
+	}
+	if filter.DomainID != nil {
+		c.add("EXISTS (SELECT 1 FROM domain_members m WHERE m.user_id = users.id AND m.domain_id = ?)", *filter.DomainID)
+	}
+	return c
+}
+
+func scanUser(row pgx.Row) (*domain.User, error) {
+	user := &domain.User{}
+	err := row.Scan(
+		&user.ID, &user.Username, &user.Email, &user.PasswordHash, &user.FirstName, &user.LastName,
+		&user.DisplayName, &user.Role, &user.IsActive, &user.IsVerified, &user.TwoFactorEnabled,
+		&user.TwoFactorSecret, &user.CreatedAt, &user.UpdatedAt, &user.LastLoginAt, &user.PasswordChangedAt,
+		&user.Timezone, &user.Locale, &user.Theme, &user.MaxEmailsPerDay, &user.MaxStorageMB,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return user, nil
+}
@@ -0,0 +1,377 @@
+package repotest
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/skygenesisenterprise/aether-mailer/package/golang/domain"
+	"github.com/skygenesisenterprise/aether-mailer/package/golang/repository"
+)
+
+func testUsers(t *testing.T, r *Repositories) {
+	ctx := context.Background()
+	base := now().Add(-time.Hour)
+
+	missing, err := r.Users.GetByID(ctx, newID())
+	must(t, err)
+	if missing != nil {
+		t.Fatal("GetByID returned a user that does not exist")
+	}
+
+	first := newUser(t, r, base)
+	second := newUser(t, r, base.Add(time.Minute))
+	third := newUser(t, r, base.Add(2*time.Minute))
+
+	got, err := r.Users.GetByID(ctx, first.ID)
+	must(t, err)
+	if got == nil || got.Username != first.Username || got.Email != first.Email || !sameTime(got.CreatedAt, first.CreatedAt) {
+		t.Fatalf("GetByID: got %+v, want %+v", got, first)
+	}
+	byEmail, err := r.Users.GetByEmail(ctx, second.Email)
+	must(t, err)
+	if byEmail == nil || byEmail.ID != second.ID {
+		t.Fatalf("GetByEmail: got %+v", byEmail)
+	}
+	byName, err := r.Users.GetByUsername(ctx, third.Username)
+	must(t, err)
+	if byName == nil || byName.ID != third.ID {
+		t.Fatalf("GetByUsername: got %+v", byName)
+	}
+
+	duplicate := *first
+	duplicate.ID = newID()
+	duplicate.Username = "other"
+	if err := r.Users.Create(ctx, &duplicate); err == nil {
+		t.Fatal("Create accepted a duplicate email")
+	}
+
+	second.Role = domain.UserRoleAdmin
+	second.IsActive = false
+	second.DisplayName = ptr("Second")
+	second.UpdatedAt = base.Add(time.Hour)
+	must(t, r.Users.Update(ctx, second))
+	got, err = r.Users.GetByID(ctx, second.ID)
+	must(t, err)
+	if got.Role != domain.UserRoleAdmin || got.IsActive || got.DisplayName == nil || *got.DisplayName != "Second" ||
+		!sameTime(got.UpdatedAt, second.UpdatedAt) {
+		t.Fatalf("Update was not saved: %+v", got)
+	}
+
+	all, err := r.Users.List(ctx, repository.UserFilter{})
+	must(t, err)
+	id := func(u *domain.User) string { return u.ID }
+	expectIDs(t, "List", ids(all, id), []string{third.ID, second.ID, first.ID})
+
+	paged, err := r.Users.List(ctx, repository.UserFilter{Limit: 1, Offset: 1})
+	must(t, err)
+	expectIDs(t, "List with offset", ids(paged, id), []string{second.ID})
+
+	active, err := r.Users.List(ctx, repository.UserFilter{IsActive: ptr(true)})
+	must(t, err)
+	expectIDs(t, "List active", ids(active, id), []string{third.ID, first.ID})
+
+	admins, err := r.Users.Count(ctx, repository.UserFilter{Role: ptr(domain.UserRoleAdmin)})
+	must(t, err)
+	expectCount(t, "Count admins", admins, 1)
+
+	must(t, r.Users.Delete(ctx, first.ID))
+	got, err = r.Users.GetByID(ctx, first.ID)
+	must(t, err)
+	if got != nil {
+		t.Fatal("Delete left the user behind")
+	}
+	must(t, r.Users.Delete(ctx, first.ID))
+
+	count, err := r.Users.Count(ctx, repository.UserFilter{})
+	must(t, err)
+	expectCount(t, "Count", count, 2)
+}
+
+func testDomains(t *testing.T, r *Repositories) {
+	ctx := context.Background()
+
+	missing, err := r.Domains.GetByName(ctx, "missing.example")
+	must(t, err)
+	if missing != nil {
+		t.Fatal("GetByName returned a domain that does not exist")
+	}
+
+	b := newDomain(t, r, "b.example")
+	a := newDomain(t, r, "a.example")
+
+	got, err := r.Domains.GetByName(ctx, "a.example")
+	must(t, err)
+	if got == nil || got.ID != a.ID || got.OwnerID != a.OwnerID {
+		t.Fatalf("GetByName: got %+v", got)
+	}
+
+	duplicate := *a
+	duplicate.ID = newID()
+	if err := r.Domains.Create(ctx, &duplicate); err == nil {
+		t.Fatal("Create accepted a duplicate name")
+	}
+
+	b.IsVerified = true
+	b.VerifiedAt = ptr(now())
+	b.SPFRecord = ptr("v=spf1 -all")
+	must(t, r.Domains.Update(ctx, b))
+	got, err = r.Domains.GetByID(ctx, b.ID)
+	must(t, err)
+	if !got.IsVerified || !sameTimePtr(got.VerifiedAt, b.VerifiedAt) || got.SPFRecord == nil || *got.SPFRecord != "v=spf1 -all" {
+		t.Fatalf("Update was not saved: %+v", got)
+	}
+
+	id := func(d *domain.Domain) string { return d.ID }
+	all, err := r.Domains.List(ctx, repository.DomainFilter{})
+	must(t, err)
+	expectIDs(t, "List", ids(all, id), []string{a.ID, b.ID})
+
+	verified, err := r.Domains.List(ctx, repository.DomainFilter{IsVerified: ptr(true)})
+	must(t, err)
+	expectIDs(t, "List verified", ids(verified, id), []string{b.ID})
+
+	owned, err := r.Domains.Count(ctx, repository.DomainFilter{OwnerID: ptr(a.OwnerID)})
+	must(t, err)
+	expectCount(t, "Count by owner", owned, 1)
+
+	must(t, r.Domains.Delete(ctx, a.ID))
+	got, err = r.Domains.GetByID(ctx, a.ID)
+	must(t, err)
+	if got != nil {
+		t.Fatal("Delete left the domain behind")
+	}
+}
+
+func testDomainMembers(t *testing.T, r *Repositories) {
+	ctx := context.Background()
+	d := newDomain(t, r, "members.example")
+	alice := newUser(t, r, now())
+	bob := newUser(t, r, now())
+
+	first := &domain.DomainMember{ID: newID(), UserID: alice.ID, DomainID: d.ID, Role: domain.DomainRoleAdmin, JoinedAt: now().Add(-time.Minute)}
+	second := &domain.DomainMember{ID: newID(), UserID: bob.ID, DomainID: d.ID, Role: domain.DomainRoleMember, JoinedAt: now()}
+	must(t, r.DomainMembers.Create(ctx, first))
+	must(t, r.DomainMembers.Create(ctx, second))
+
+	duplicate := *first
+	duplicate.ID = newID()
+	if err := r.DomainMembers.Create(ctx, &duplicate); err == nil {
+		t.Fatal("Create accepted a user twice in one domain")
+	}
+	orphan := &domain.DomainMember{ID: newID(), UserID: alice.ID, DomainID: newID(), Role: domain.DomainRoleMember, JoinedAt: now()}
+	if err := r.DomainMembers.Create(ctx, orphan); err == nil {
+		t.Fatal("Create accepted a member of a missing domain")
+	}
+
+	got, err := r.DomainMembers.GetByUserAndDomain(ctx, bob.ID, d.ID)
+	must(t, err)
+	if got == nil || got.ID != second.ID {
+		t.Fatalf("GetByUserAndDomain: got %+v", got)
+	}
+
+	second.Role = domain.DomainRoleAdmin
+	must(t, r.DomainMembers.Update(ctx, second))
+	got, err = r.DomainMembers.GetByID(ctx, second.ID)
+	must(t, err)
+	if got.Role != domain.DomainRoleAdmin {
+		t.Fatalf("Update was not saved: %+v", got)
+	}
+
+	id := func(m *domain.DomainMember) string { return m.ID }
+	members, err := r.DomainMembers.ListByDomain(ctx, d.ID)
+	must(t, err)
+	expectIDs(t, "ListByDomain", ids(members, id), []string{first.ID, second.ID})
+
+	if r.Users != nil {
+		inDomain, err := r.Users.List(ctx, repository.UserFilter{DomainID: ptr(d.ID)})
+		must(t, err)
+		if len(inDomain) != 2 {
+			t.Fatalf("List users by domain: got %d users, want 2", len(inDomain))
+		}
+	}
+
+	must(t, r.Users.Delete(ctx, alice.ID))
+	byUser, err := r.DomainMembers.ListByUser(ctx, alice.ID)
+	must(t, err)
+	expectIDs(t, "ListByUser after deleting the user", ids(byUser, id), []string{})
+
+	must(t, r.Domains.Delete(ctx, d.ID))
+	got, err = r.DomainMembers.GetByID(ctx, second.ID)
+	must(t, err)
+	if got != nil {
+		t.Fatal("deleting the domain left its members behind")
+	}
+}
+
+func testEmailAccounts(t *testing.T, r *Repositories) {
+	ctx := context.Background()
+	d := newDomain(t, r, "accounts.example")
+	other := newDomain(t, r, "other.example")
+
+	bob := newAccount(t, r, d, "bob")
+	alice := newAccount(t, r, d, "alice")
+	carol := newAccount(t, r, other, "carol")
+
+	got, err := r.EmailAccounts.GetByEmail(ctx, "alice@accounts.example")
+	must(t, err)
+	if got == nil || got.ID != alice.ID || got.UserID != alice.UserID {
+		t.Fatalf("GetByEmail: got %+v", got)
+	}
+	missing, err := r.EmailAccounts.GetByEmail(ctx, "nobody@accounts.example")
+	must(t, err)
+	if missing != nil {
+		t.Fatal("GetByEmail returned an account that does not exist")
+	}
+
+	duplicate := *alice
+	duplicate.ID = newID()
+	if err := r.EmailAccounts.Create(ctx, &duplicate); err == nil {
+		t.Fatal("Create accepted a duplicate email")
+	}
+
+	bob.UsedMB = 42
+	bob.IsVerified = true
+	bob.LastLoginAt = ptr(now())
+	must(t, r.EmailAccounts.Update(ctx, bob))
+	got, err = r.EmailAccounts.GetByID(ctx, bob.ID)
+	must(t, err)
+	if got.UsedMB != 42 || !got.IsVerified || !sameTimePtr(got.LastLoginAt, bob.LastLoginAt) {
+		t.Fatalf("Update was not saved: %+v", got)
+	}
+
+	id := func(a *domain.EmailAccount) string { return a.ID }
+	inDomain, err := r.EmailAccounts.List(ctx, repository.EmailAccountFilter{DomainID: ptr(d.ID)})
+	must(t, err)
+	expectIDs(t, "List by domain", ids(inDomain, id), []string{alice.ID, bob.ID})
+
+	all, err := r.EmailAccounts.List(ctx, repository.EmailAccountFilter{Limit: 2, Offset: 1})
+	must(t, err)
+	expectIDs(t, "List page", ids(all, id), []string{bob.ID, carol.ID})
+
+	verified, err := r.EmailAccounts.Count(ctx, repository.EmailAccountFilter{IsVerified: ptr(true)})
+	must(t, err)
+	expectCount(t, "Count verified", verified, 1)
+
+	// Shared mailboxes belong to no user
+	shared := &domain.EmailAccount{
+		ID:        newID(),
+		DomainID:  d.ID,
+		Email:     "support@accounts.example",
+		IsActive:  true,
+		QuotaMB:   100,
+		CreatedAt: now(),
+		UpdatedAt: now(),
+	}
+	must(t, r.EmailAccounts.Create(ctx, shared))
+	got, err = r.EmailAccounts.GetByID(ctx, shared.ID)
+	must(t, err)
+	if got == nil || !got.IsShared() {
+		t.Fatalf("GetByID of a shared mailbox: got %+v", got)
+	}
+	sharedOnly, err := r.EmailAccounts.List(ctx, repository.EmailAccountFilter{IsShared: ptr(true)})
+	must(t, err)
+	expectIDs(t, "List shared", ids(sharedOnly, id), []string{shared.ID})
+	owned, err := r.EmailAccounts.Count(ctx, repository.EmailAccountFilter{IsShared: ptr(false)})
+	must(t, err)
+	expectCount(t, "Count owned", owned, 3)
+	must(t, r.EmailAccounts.Delete(ctx, shared.ID))
+
+	must(t, r.Domains.Delete(ctx, other.ID))
+	got, err = r.EmailAccounts.GetByID(ctx, carol.ID)
+	must(t, err)
+	if got != nil {
+		t.Fatal("deleting the domain left its accounts behind")
+	}
+
+	must(t, r.Users.Delete(ctx, alice.UserID))
+	got, err = r.EmailAccounts.GetByID(ctx, alice.ID)
+	must(t, err)
+	if got != nil {
+		t.Fatal("deleting the user left its accounts behind")
+	}
+
+	must(t, r.EmailAccounts.Delete(ctx, bob.ID))
+	count, err := r.EmailAccounts.Count(ctx, repository.EmailAccountFilter{})
+	must(t, err)
+	expectCount(t, "Count after delete", count, 0)
+}
+
+func testEmailAliases(t *testing.T, r *Repositories) {
+	ctx := context.Background()
+	d := newDomain(t, r, "aliases.example")
+
+	sales := &domain.EmailAlias{ID: newID(), DomainID: d.ID, Alias: "sales@aliases.example", DestEmail: "bob@aliases.example", IsActive: true, CreatedAt: now(), UpdatedAt: now()}
+	info := &domain.EmailAlias{ID: newID(), DomainID: d.ID, Alias: "info@aliases.example", DestEmail: "alice@aliases.example", IsActive: true, CreatedAt: now(), UpdatedAt: now()}
+	must(t, r.EmailAliases.Create(ctx, sales))
+	must(t, r.EmailAliases.Create(ctx, info))
+
+	duplicate := *sales
+	duplicate.ID = newID()
+	if err := r.EmailAliases.Create(ctx, &duplicate); err == nil {
+		t.Fatal("Create accepted a duplicate alias")
+	}
+
+	got, err := r.EmailAliases.GetByAlias(ctx, "sales@aliases.example")
+	must(t, err)
+	if got == nil || got.ID != sales.ID || got.DestEmail != sales.DestEmail {
+		t.Fatalf("GetByAlias: got %+v", got)
+	}
+
+	sales.DestEmail = "carol@aliases.example"
+	sales.IsActive = false
+	must(t, r.EmailAliases.Update(ctx, sales))
+	got, err = r.EmailAliases.GetByID(ctx, sales.ID)
+	must(t, err)
+	if got.DestEmail != "carol@aliases.example" || got.IsActive {
+		t.Fatalf("Update was not saved: %+v", got)
+	}
+
+	id := func(a *domain.EmailAlias) string { return a.ID }
+	aliases, err := r.EmailAliases.GetByDomainID(ctx, d.ID)
+	must(t, err)
+	expectIDs(t, "GetByDomainID", ids(aliases, id), []string{info.ID, sales.ID})
+
+	must(t, r.EmailAliases.Delete(ctx, info.ID))
+	must(t, r.Domains.Delete(ctx, d.ID))
+	aliases, err = r.EmailAliases.GetByDomainID(ctx, d.ID)
+	must(t, err)
+	expectIDs(t, "GetByDomainID after deleting the domain", ids(aliases, id), []string{})
+}
+
+func testDNSRecords(t *testing.T, r *Repositories) {
+	ctx := context.Background()
+	d := newDomain(t, r, "dns.example")
+
+	txt := &domain.DNSRecord{ID: newID(), DomainID: d.ID, Type: "TXT", Name: ptr("@"), Value: ptr("v=spf1 -all"), TTL: ptr(300)}
+	mx := &domain.DNSRecord{ID: newID(), DomainID: d.ID, Type: "MX", Name: ptr("@"), Value: ptr("mx.dns.example"), Priority: ptr(10)}
+	dkim := &domain.DNSRecord{ID: newID(), DomainID: d.ID, Type: "TXT", Name: ptr("s1._domainkey"), Value: ptr("v=DKIM1")}
+	for _, record := range []*domain.DNSRecord{txt, mx, dkim} {
+		must(t, r.DNSRecords.Create(ctx, record))
+	}
+
+	got, err := r.DNSRecords.GetByID(ctx, mx.ID)
+	must(t, err)
+	if got == nil || got.Priority == nil || *got.Priority != 10 || got.TTL != nil {
+		t.Fatalf("GetByID: got %+v", got)
+	}
+
+	txt.TTL = ptr(3600)
+	must(t, r.DNSRecords.Update(ctx, txt))
+	got, err = r.DNSRecords.GetByID(ctx, txt.ID)
+	must(t, err)
+	if got.TTL == nil || *got.TTL != 3600 {
+		t.Fatalf("Update was not saved: %+v", got)
+	}
+
+	id := func(r *domain.DNSRecord) string { return r.ID }
+	records, err := r.DNSRecords.GetByDomainID(ctx, d.ID)
+	must(t, err)
+	expectIDs(t, "GetByDomainID", ids(records, id), []string{mx.ID, txt.ID, dkim.ID})
+
+	must(t, r.DNSRecords.Delete(ctx, mx.ID))
+	must(t, r.Domains.Delete(ctx, d.ID))
+	records, err = r.DNSRecords.GetByDomainID(ctx, d.ID)
+	must(t, err)
+	expectIDs(t, "GetByDomainID after deleting the domain", ids(records, id), []string{})
+}
@@ -0,0 +1,272 @@
+package repotest
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/skygenesisenterprise/aether-mailer/package/golang/domain"
+	"github.com/skygenesisenterprise/aether-mailer/package/golang/repository"
+)
+
+func newGroup(t *testing.T, r *Repositories, d *domain.Domain, name string, createdAt time.Time) *domain.Group {
+	t.Helper()
+	group := &domain.Group{
+		ID:        newID(),
+		DomainID:  d.ID,
+		Name:      name,
+		CreatedAt: createdAt,
+		UpdatedAt: createdAt,
+	}
+	must(t, r.Groups.Create(context.Background(), group))
+	return group
+}
+
+func testGroups(t *testing.T, r *Repositories) {
+	ctx := context.Background()
+	d := newDomain(t, r, "groups.example")
+	other := newDomain(t, r, "other-groups.example")
+	start := now()
+
+	support := newGroup(t, r, d, "support", start)
+	sales := newGroup(t, r, d, "sales", start)
+	elsewhere := newGroup(t, r, other, "support", start)
+	if err := r.Groups.Create(ctx, &domain.Group{ID: newID(), DomainID: d.ID, Name: "support", CreatedAt: start, UpdatedAt: start}); err == nil {
+		t.Fatal("Create of a duplicate name succeeded")
+	}
+	if err := r.Groups.Create(ctx, &domain.Group{ID: newID(), DomainID: newID(), Name: "missing", CreatedAt: start, UpdatedAt: start}); err == nil {
+		t.Fatal("Create in a missing domain succeeded")
+	}
+
+	got, err := r.Groups.GetByID(ctx, support.ID)
+	must(t, err)
+	if got == nil || got.DomainID != d.ID || got.Name != "support" || !sameTime(got.CreatedAt, start) {
+		t.Fatalf("GetByID: got %+v", got)
+	}
+	got, err = r.Groups.GetByName(ctx, other.ID, "support")
+	must(t, err)
+	if got == nil || got.ID != elsewhere.ID {
+		t.Fatalf("GetByName: got %+v", got)
+	}
+	got, err = r.Groups.GetByName(ctx, other.ID, "sales")
+	must(t, err)
+	if got != nil {
+		t.Fatalf("GetByName of another domain's group: got %+v", got)
+	}
+
+	id := func(group *domain.Group) string { return group.ID }
+	groups, err := r.Groups.ListByDomain(ctx, d.ID)
+	must(t, err)
+	expectIDs(t, "ListByDomain", ids(groups, id), []string{sales.ID, support.ID})
+
+	// Adding a member again does nothing
+	alice := newUser(t, r, start)
+	bob := newUser(t, r, start)
+	must(t, r.Groups.AddMember(ctx, &domain.GroupMember{GroupID: support.ID, UserID: alice.ID, AddedAt: start}))
+	must(t, r.Groups.AddMember(ctx, &domain.GroupMember{GroupID: support.ID, UserID: bob.ID, AddedAt: start.Add(time.Second)}))
+	must(t, r.Groups.AddMember(ctx, &domain.GroupMember{GroupID: sales.ID, UserID: alice.ID, AddedAt: start}))
+	must(t, r.Groups.AddMember(ctx, &domain.GroupMember{GroupID: support.ID, UserID: alice.ID, AddedAt: start.Add(time.Minute)}))
+	if err := r.Groups.AddMember(ctx, &domain.GroupMember{GroupID: support.ID, UserID: newID(), AddedAt: start}); err == nil {
+		t.Fatal("AddMember of a missing user succeeded")
+	}
+	members, err := r.Groups.ListMembers(ctx, support.ID)
+	must(t, err)
+	userIDs := ids(members, func(member *domain.GroupMember) string { return member.UserID })
+	expectIDs(t, "ListMembers", userIDs, []string{alice.ID, bob.ID})
+	if !sameTime(members[0].AddedAt, start) {
+		t.Fatalf("ListMembers: added at %v, want %v", members[0].AddedAt, start)
+	}
+	groups, err = r.Groups.ListByUser(ctx, alice.ID)
+	must(t, err)
+	expectIDs(t, "ListByUser", ids(groups, id), []string{sales.ID, support.ID})
+
+	must(t, r.Groups.RemoveMember(ctx, sales.ID, alice.ID))
+	groups, err = r.Groups.ListByUser(ctx, alice.ID)
+	must(t, err)
+	expectIDs(t, "ListByUser after RemoveMember", ids(groups, id), []string{support.ID})
+
+	// Deleting a user ends its memberships, deleting a group or its domain
+	// deletes the group
+	must(t, r.Users.Delete(ctx, bob.ID))
+	members, err = r.Groups.ListMembers(ctx, support.ID)
+	must(t, err)
+	expectCount(t, "ListMembers after user deletion", len(members), 1)
+	must(t, r.Groups.Delete(ctx, support.ID))
+	groups, err = r.Groups.ListByUser(ctx, alice.ID)
+	must(t, err)
+	expectIDs(t, "ListByUser after Delete", ids(groups, id), nil)
+	must(t, r.Domains.Delete(ctx, other.ID))
+	got, err = r.Groups.GetByID(ctx, elsewhere.ID)
+	must(t, err)
+	if got != nil {
+		t.Fatal("deleting the domain left its groups behind")
+	}
+}
+
+func testFolderACLs(t *testing.T, r *Repositories) {
+	ctx := context.Background()
+	d := newDomain(t, r, "acls.example")
+	shared := &domain.EmailAccount{
+		ID:        newID(),
+		DomainID:  d.ID,
+		Email:     "support@acls.example",
+		IsActive:  true,
+		QuotaMB:   100,
+		CreatedAt: now(),
+		UpdatedAt: now(),
+	}
+	must(t, r.EmailAccounts.Create(ctx, shared))
+	personal := newAccount(t, r, d, "kim")
+	inbox := newFolder(t, r, shared.ID, nil, "INBOX", domain.FolderTypeInbox)
+	archive := newFolder(t, r, shared.ID, nil, "Archive", domain.FolderTypeArchive)
+	old := newFolder(t, r, shared.ID, &archive.ID, "Archive/2024", domain.FolderTypeCustom)
+	personalInbox := newFolder(t, r, personal.ID, nil, "INBOX", domain.FolderTypeInbox)
+	alice := newUser(t, r, now())
+	bob := newUser(t, r, now())
+	team := newGroup(t, r, d, "team", now())
+	start := now()
+
+	entry := func(folder *domain.Folder, subjectType domain.ACLSubjectType, subjectID string, rights domain.Rights, createdAt time.Time) *domain.FolderACL {
+		return &domain.FolderACL{
+			ID:          newID(),
+			FolderID:    folder.ID,
+			AccountID:   folder.AccountID,
+			SubjectType: subjectType,
+			SubjectID:   subjectID,
+			Rights:      rights,
+			GrantedBy:   personal.UserID,
+			CreatedAt:   createdAt,
+			UpdatedAt:   createdAt,
+		}
+	}
+	toAlice := entry(inbox, domain.ACLSubjectUser, alice.ID, "lrs", start)
+	toTeam := entry(inbox, domain.ACLSubjectGroup, team.ID, "lr", start.Add(time.Second))
+	toAnyone := entry(inbox, domain.ACLSubjectAnyone, "", "l", start.Add(2*time.Second))
+	archived := entry(old, domain.ACLSubjectUser, alice.ID, "lr", start.Add(3*time.Second))
+	toBob := entry(personalInbox, domain.ACLSubjectUser, bob.ID, "lrswi", start)
+	for _, acl := range []*domain.FolderACL{toAlice, toTeam, toAnyone, archived, toBob} {
+		must(t, r.FolderACLs.Create(ctx, acl))
+	}
+	if err := r.FolderACLs.Create(ctx, entry(inbox, domain.ACLSubjectUser, alice.ID, "l", start)); err == nil {
+		t.Fatal("Create of a second entry for the same user succeeded")
+	}
+	if err := r.FolderACLs.Create(ctx, entry(inbox, domain.ACLSubjectAnyone, "", "l", start)); err == nil {
+		t.Fatal("Create of a second entry for anyone succeeded")
+	}
+	if err := r.FolderACLs.Create(ctx, entry(inbox, domain.ACLSubjectGroup, newID(), "l", start)); err == nil {
+		t.Fatal("Create for a missing group succeeded")
+	}
+
+	got, err := r.FolderACLs.Get(ctx, inbox.ID, domain.ACLSubjectGroup, team.ID)
+	must(t, err)
+	if got == nil || got.ID != toTeam.ID || got.AccountID != shared.ID || got.Rights != "lr" ||
+		got.GrantedBy != personal.UserID || !sameTime(got.CreatedAt, toTeam.CreatedAt) {
+		t.Fatalf("Get: got %+v, want %+v", got, toTeam)
+	}
+	got, err = r.FolderACLs.Get(ctx, inbox.ID, domain.ACLSubjectAnyone, "")
+	must(t, err)
+	if got == nil || got.ID != toAnyone.ID {
+		t.Fatalf("Get for anyone: got %+v", got)
+	}
+	got, err = r.FolderACLs.Get(ctx, inbox.ID, domain.ACLSubjectUser, bob.ID)
+	must(t, err)
+	if got != nil {
+		t.Fatalf("Get of a missing entry: got %+v", got)
+	}
+
+	id := func(acl *domain.FolderACL) string { return acl.ID }
+	list := func(name string, acls []*domain.FolderACL, err error, want ...string) {
+		t.Helper()
+		must(t, err)
+		expectIDs(t, name, ids(acls, id), want)
+	}
+	acls, err := r.FolderACLs.ListByFolder(ctx, inbox.ID)
+	list("ListByFolder", acls, err, toAlice.ID, toTeam.ID, toAnyone.ID)
+	acls, err = r.FolderACLs.ListByAccount(ctx, shared.ID)
+	list("ListByAccount", acls, err, toAlice.ID, toTeam.ID, toAnyone.ID, archived.ID)
+	acls, err = r.FolderACLs.ListBySubjects(ctx, alice.ID, nil)
+	list("ListBySubjects of a user", acls, err, toAlice.ID, toAnyone.ID, archived.ID)
+	acls, err = r.FolderACLs.ListBySubjects(ctx, bob.ID, []string{team.ID})
+	list("ListBySubjects with groups", acls, err, toBob.ID, toTeam.ID, toAnyone.ID)
+
+	updatedAt := start.Add(time.Minute)
+	toAlice.Rights = "lrswipkxtea"
+	toAlice.GrantedBy = alice.ID
+	toAlice.UpdatedAt = updatedAt
+	must(t, r.FolderACLs.Update(ctx, toAlice))
+	got, err = r.FolderACLs.Get(ctx, inbox.ID, domain.ACLSubjectUser, alice.ID)
+	must(t, err)
+	if got.Rights != domain.AllRights || got.GrantedBy != alice.ID || !sameTime(got.UpdatedAt, updatedAt) {
+		t.Fatalf("Update: got %+v", got)
+	}
+
+	must(t, r.FolderACLs.Delete(ctx, toAnyone.ID))
+	acls, err = r.FolderACLs.ListByFolder(ctx, inbox.ID)
+	list("ListByFolder after Delete", acls, err, toAlice.ID, toTeam.ID)
+
+	// Entries go with their folder, subfolders included, their user, their
+	// group and their account
+	must(t, r.Folders.Delete(ctx, archive.ID))
+	acls, err = r.FolderACLs.ListByAccount(ctx, shared.ID)
+	list("ListByAccount after folder deletion", acls, err, toAlice.ID, toTeam.ID)
+	must(t, r.Users.Delete(ctx, alice.ID))
+	must(t, r.Groups.Delete(ctx, team.ID))
+	acls, err = r.FolderACLs.ListByAccount(ctx, shared.ID)
+	list("ListByAccount after subject deletion", acls, err)
+	must(t, r.EmailAccounts.Delete(ctx, personal.ID))
+	acls, err = r.FolderACLs.ListBySubjects(ctx, bob.ID, nil)
+	list("ListBySubjects after account deletion", acls, err)
+}
+
+func testACLAuditLog(t *testing.T, r *Repositories) {
+	ctx := context.Background()
+	base := now().Add(-time.Hour)
+	domainID, accountID := newID(), newID()
+
+	record := func(domainID, accountID string, action domain.ACLAuditAction, at time.Time) *domain.ACLAuditEntry {
+		entry := &domain.ACLAuditEntry{
+			ID:          newID(),
+			DomainID:    domainID,
+			AccountID:   accountID,
+			Action:      action,
+			SubjectType: domain.ACLSubjectUser,
+			SubjectID:   newID(),
+			ActorID:     newID(),
+			CreatedAt:   at,
+		}
+		if action == domain.ACLAuditRightsSet {
+			entry.FolderID = newID()
+			entry.OldRights = "l"
+			entry.NewRights = "lrs"
+		}
+		must(t, r.ACLAuditLog.Record(ctx, entry))
+		return entry
+	}
+	created := record(domainID, accountID, domain.ACLAuditMailboxCreated, base)
+	set := record(domainID, accountID, domain.ACLAuditRightsSet, base.Add(time.Minute))
+	deleted := record(domainID, accountID, domain.ACLAuditRightsDeleted, base.Add(2*time.Minute))
+	group := record(domainID, "", domain.ACLAuditGroupCreated, base.Add(3*time.Minute))
+	elsewhere := record(newID(), newID(), domain.ACLAuditRightsSet, base.Add(4*time.Minute))
+
+	id := func(e *domain.ACLAuditEntry) string { return e.ID }
+	list := func(name string, filter repository.ACLAuditFilter, total int, want ...string) []*domain.ACLAuditEntry {
+		t.Helper()
+		entries, err := r.ACLAuditLog.List(ctx, filter)
+		must(t, err)
+		expectIDs(t, name, ids(entries, id), want)
+		count, err := r.ACLAuditLog.Count(ctx, filter)
+		must(t, err)
+		expectCount(t, name+" count", count, total)
+		return entries
+	}
+	entries := list("List of an account", repository.ACLAuditFilter{AccountID: accountID}, 3,
+		deleted.ID, set.ID, created.ID)
+	if got := entries[1]; got.DomainID != domainID || got.FolderID != set.FolderID || got.Action != domain.ACLAuditRightsSet ||
+		got.SubjectType != domain.ACLSubjectUser || got.SubjectID != set.SubjectID || got.OldRights != "l" ||
+		got.NewRights != "lrs" || got.ActorID != set.ActorID || !sameTime(got.CreatedAt, set.CreatedAt) {
+		t.Fatalf("List: got %+v, want %+v", got, set)
+	}
+	list("List of a domain", repository.ACLAuditFilter{DomainID: domainID}, 4, group.ID, deleted.ID, set.ID, created.ID)
+	list("List page", repository.ACLAuditFilter{DomainID: domainID, Limit: 2, Offset: 1}, 4, deleted.ID, set.ID)
+	list("List of everything", repository.ACLAuditFilter{Limit: 1}, 5, elsewhere.ID)
+}
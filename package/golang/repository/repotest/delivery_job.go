@@ -0,0 +1,114 @@
+package repotest
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/skygenesisenterprise/aether-mailer/package/golang/domain"
+)
+
+func newDeliveryJob(messageID, domainName string, due time.Time) *domain.DeliveryJob {
+	return &domain.DeliveryJob{
+		ID:            newID(),
+		MessageID:     messageID,
+		AccountID:     newID(),
+		DomainID:      newID(),
+		From:          "alice@queue.example",
+		Recipients:    []string{"bob@" + domainName, "carol@" + domainName},
+		Domain:        domainName,
+		Data:          []byte("Subject: queued\r\n\r\nHello\r\n"),
+		QueuedAt:      due,
+		NextAttemptAt: due,
+	}
+}
+
+func testDeliveryJobs(t *testing.T, r *Repositories) {
+	ctx := context.Background()
+	start := now()
+	lease := time.Minute
+
+	messageID := newID()
+	first := newDeliveryJob(messageID, "one.example", start)
+	second := newDeliveryJob(messageID, "two.example", start.Add(time.Second))
+	later := newDeliveryJob(newID(), "one.example", start.Add(time.Hour))
+	for _, job := range []*domain.DeliveryJob{first, second, later} {
+		created, err := r.DeliveryJobs.Create(ctx, job)
+		must(t, err)
+		if !created {
+			t.Fatalf("Create(%s) reported an existing job", job.Domain)
+		}
+	}
+	created, err := r.DeliveryJobs.Create(ctx, newDeliveryJob(messageID, "one.example", start))
+	must(t, err)
+	if created {
+		t.Fatal("Create queued a second job for the same message and domain")
+	}
+	if count, err := r.DeliveryJobs.Count(ctx); err != nil || count != 3 {
+		t.Fatalf("Count = %d, %v; want 3", count, err)
+	}
+
+	claimed, err := r.DeliveryJobs.Claim(ctx, "a", start, 1, lease)
+	must(t, err)
+	if len(claimed) != 1 || claimed[0].ID != first.ID || claimed[0].LockedUntil == nil ||
+		len(claimed[0].Recipients) != 2 || string(claimed[0].Data) != string(first.Data) {
+		t.Fatalf("Claim(limit 1): got %+v, want the first job", claimed)
+	}
+	claimed, err = r.DeliveryJobs.Claim(ctx, "b", start.Add(time.Second), 10, lease)
+	must(t, err)
+	if len(claimed) != 1 || claimed[0].ID != second.ID {
+		t.Fatalf("Claim by a second instance: got %d jobs, want only the second job", len(claimed))
+	}
+
+	// Leases are renewed by their owner only
+	must(t, r.DeliveryJobs.Extend(ctx, []string{first.ID, second.ID}, "a", start.Add(50*time.Second), lease))
+	claimed, err = r.DeliveryJobs.Claim(ctx, "b", start.Add(90*time.Second), 10, lease)
+	must(t, err)
+	if len(claimed) != 1 || claimed[0].ID != second.ID {
+		t.Fatalf("Claim after renewing: got %d jobs, want the expired second job", len(claimed))
+	}
+
+	deferred := *first
+	deferred.Recipients = []string{"carol@one.example"}
+	deferred.Attempts = 1
+	deferred.LastError = "451 try again later"
+	deferred.NextAttemptAt = start.Add(10 * time.Minute)
+	ok, err := r.DeliveryJobs.Reschedule(ctx, &deferred, "b")
+	must(t, err)
+	if ok {
+		t.Fatal("Reschedule by an instance without the lease succeeded")
+	}
+	ok, err = r.DeliveryJobs.Reschedule(ctx, &deferred, "a")
+	must(t, err)
+	if !ok {
+		t.Fatal("Reschedule by the lease owner changed nothing")
+	}
+	if ok, err := r.DeliveryJobs.Complete(ctx, first.ID, "a"); err != nil || ok {
+		t.Fatalf("Complete after the lease was released = %v, %v; want false", ok, err)
+	}
+
+	must(t, r.DeliveryJobs.Extend(ctx, []string{second.ID}, "b", start.Add(9*time.Minute+30*time.Second), lease))
+	claimed, err = r.DeliveryJobs.Claim(ctx, "a", start.Add(10*time.Minute), 10, lease)
+	must(t, err)
+	if len(claimed) != 1 || claimed[0].ID != first.ID || claimed[0].Attempts != 1 ||
+		claimed[0].LastError != deferred.LastError || len(claimed[0].Recipients) != 1 ||
+		claimed[0].Recipients[0] != "carol@one.example" || !sameTime(claimed[0].NextAttemptAt, deferred.NextAttemptAt) {
+		t.Fatalf("Claim of the rescheduled job: got %+v", claimed)
+	}
+
+	// The second job was taken over by "b" and its first owner lost it
+	if ok, err := r.DeliveryJobs.Complete(ctx, second.ID, "a"); err != nil || ok {
+		t.Fatalf("Complete by the former owner = %v, %v; want false", ok, err)
+	}
+	for _, done := range []struct {
+		id    string
+		owner string
+	}{{first.ID, "a"}, {second.ID, "b"}} {
+		if ok, err := r.DeliveryJobs.Complete(ctx, done.id, done.owner); err != nil || !ok {
+			t.Fatalf("Complete(%s) by its owner = %v, %v; want true", done.id, ok, err)
+		}
+	}
+	if count, err := r.DeliveryJobs.Count(ctx); err != nil || count != 1 {
+		t.Fatalf("Count after completing = %d, %v; want 1", count, err)
+	}
+}
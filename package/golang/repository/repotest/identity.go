@@ -0,0 +1,234 @@
+package repotest
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/skygenesisenterprise/aether-mailer/package/golang/domain"
+	"github.com/skygenesisenterprise/aether-mailer/package/golang/repository"
+)
+
+func newIdentity(account *domain.EmailAccount, d *domain.Domain, local string, createdAt time.Time) *domain.Identity {
+	return &domain.Identity{
+		ID:        newID(),
+		AccountID: account.ID,
+		DomainID:  d.ID,
+		Email:     local + "@" + d.Name,
+		Status:    domain.IdentityStatusPending,
+		CreatedAt: createdAt,
+		UpdatedAt: createdAt,
+	}
+}
+
+func testIdentities(t *testing.T, r *Repositories) {
+	ctx := context.Background()
+	d := newDomain(t, r, "identities.example")
+	aliases := newDomain(t, r, "aliases.example")
+	account := newAccount(t, r, d, "grace")
+	other := newAccount(t, r, d, "heidi")
+	start := now()
+
+	own := newIdentity(account, d, "grace", start)
+	own.Name = "Grace"
+	own.ReplyTo = "replies@identities.example"
+	own.SignatureText = "Grace"
+	own.SignatureHTML = "<b>Grace</b>"
+	own.Status = domain.IdentityStatusApproved
+	own.IsDefault = true
+	alias := newIdentity(account, aliases, "sales", start.Add(time.Second))
+	shared := newIdentity(other, aliases, "sales", start)
+	for _, identity := range []*domain.Identity{own, alias, shared} {
+		must(t, r.Identities.Create(ctx, identity))
+	}
+	if err := r.Identities.Create(ctx, own); err == nil {
+		t.Fatal("Create with a duplicate ID succeeded")
+	}
+	again := newIdentity(account, aliases, "sales", start)
+	if err := r.Identities.Create(ctx, again); err == nil {
+		t.Fatal("Create of a duplicate address succeeded")
+	}
+	second := newIdentity(account, d, "second", start)
+	second.IsDefault = true
+	if err := r.Identities.Create(ctx, second); err == nil {
+		t.Fatal("Create of a second default identity succeeded")
+	}
+	if err := r.Identities.Create(ctx, newIdentity(&domain.EmailAccount{ID: newID()}, d, "missing", start)); err == nil {
+		t.Fatal("Create for a missing account succeeded")
+	}
+
+	got, err := r.Identities.GetByID(ctx, own.ID)
+	must(t, err)
+	if got == nil || got.AccountID != account.ID || got.DomainID != d.ID || got.Email != own.Email ||
+		got.Name != own.Name || got.ReplyTo != own.ReplyTo || got.SignatureText != own.SignatureText ||
+		got.SignatureHTML != own.SignatureHTML || !got.IsDefault || got.Status != domain.IdentityStatusApproved ||
+		got.ReviewedBy != nil || got.ReviewedAt != nil || !sameTime(got.CreatedAt, own.CreatedAt) {
+		t.Fatalf("GetByID: got %+v, want %+v", got, own)
+	}
+	got, err = r.Identities.GetByID(ctx, newID())
+	must(t, err)
+	if got != nil {
+		t.Fatalf("GetByID of a missing identity: got %+v", got)
+	}
+	got, err = r.Identities.GetByEmail(ctx, other.ID, "sales@aliases.example")
+	must(t, err)
+	if got == nil || got.ID != shared.ID {
+		t.Fatalf("GetByEmail: got %+v", got)
+	}
+	got, err = r.Identities.GetByEmail(ctx, other.ID, own.Email)
+	must(t, err)
+	if got != nil {
+		t.Fatalf("GetByEmail of another account's address: got %+v", got)
+	}
+
+	id := func(identity *domain.Identity) string { return identity.ID }
+	pending := domain.IdentityStatusPending
+	list := func(name string, filter repository.IdentityFilter, total int, want ...string) {
+		t.Helper()
+		identities, err := r.Identities.List(ctx, filter)
+		must(t, err)
+		expectIDs(t, name, ids(identities, id), want)
+		count, err := r.Identities.Count(ctx, filter)
+		must(t, err)
+		expectCount(t, name+" count", count, total)
+	}
+	list("List of an account", repository.IdentityFilter{AccountID: account.ID}, 2, own.ID, alias.ID)
+	list("List of pending identities in a domain", repository.IdentityFilter{DomainID: aliases.ID, Status: &pending},
+		2, shared.ID, alias.ID)
+	list("List page", repository.IdentityFilter{AccountID: account.ID, Limit: 1, Offset: 1}, 2, alias.ID)
+
+	// Update keeps the account, address and default flag
+	reviewedAt := start.Add(time.Minute)
+	alias.Status = domain.IdentityStatusApproved
+	alias.ReviewedBy = ptr(account.UserID)
+	alias.ReviewedAt = &reviewedAt
+	alias.Name = "Sales"
+	alias.Email = "changed@aliases.example"
+	alias.IsDefault = true
+	alias.UpdatedAt = reviewedAt
+	must(t, r.Identities.Update(ctx, alias))
+	got, err = r.Identities.GetByID(ctx, alias.ID)
+	must(t, err)
+	if got.Status != domain.IdentityStatusApproved || got.ReviewedBy == nil || *got.ReviewedBy != account.UserID ||
+		!sameTimePtr(got.ReviewedAt, &reviewedAt) || got.Name != "Sales" || got.Email != "sales@aliases.example" ||
+		got.IsDefault || !sameTime(got.UpdatedAt, reviewedAt) {
+		t.Fatalf("Update: got %+v", got)
+	}
+
+	must(t, r.Identities.SetDefault(ctx, account.ID, alias.ID))
+	for _, check := range []struct {
+		id        string
+		isDefault bool
+	}{{own.ID, false}, {alias.ID, true}, {shared.ID, false}} {
+		got, err = r.Identities.GetByID(ctx, check.id)
+		must(t, err)
+		if got.IsDefault != check.isDefault {
+			t.Fatalf("SetDefault: identity %s default %v, want %v", check.id, got.IsDefault, check.isDefault)
+		}
+	}
+	must(t, r.Identities.SetDefault(ctx, account.ID, ""))
+	got, err = r.Identities.GetByID(ctx, alias.ID)
+	must(t, err)
+	if got.IsDefault {
+		t.Fatal("SetDefault with no identity kept the default")
+	}
+
+	must(t, r.Identities.Delete(ctx, own.ID))
+	list("List after Delete", repository.IdentityFilter{AccountID: account.ID}, 1, alias.ID)
+
+	// Deleting the domain of an address deletes its identities, deleting an
+	// account deletes the rest
+	must(t, r.Domains.Delete(ctx, aliases.ID))
+	list("List after domain deletion", repository.IdentityFilter{}, 0)
+	kept := newIdentity(other, d, "heidi", start)
+	must(t, r.Identities.Create(ctx, kept))
+	must(t, r.EmailAccounts.Delete(ctx, other.ID))
+	list("List after account deletion", repository.IdentityFilter{}, 0)
+}
+
+func testSenderDelegations(t *testing.T, r *Repositories) {
+	ctx := context.Background()
+	d := newDomain(t, r, "delegations.example")
+	shared := newAccount(t, r, d, "support")
+	ivan := newAccount(t, r, d, "ivan")
+	judy := newAccount(t, r, d, "judy")
+	start := now()
+
+	delegation := func(account, delegate *domain.EmailAccount, permission domain.DelegationPermission, createdAt time.Time) *domain.SenderDelegation {
+		return &domain.SenderDelegation{
+			ID:                newID(),
+			AccountID:         account.ID,
+			DelegateAccountID: delegate.ID,
+			Permission:        permission,
+			GrantedBy:         account.UserID,
+			CreatedAt:         createdAt,
+			UpdatedAt:         createdAt,
+		}
+	}
+	toIvan := delegation(shared, ivan, domain.DelegationSendAs, start)
+	toJudy := delegation(shared, judy, domain.DelegationSendOnBehalf, start.Add(time.Second))
+	fromJudy := delegation(judy, ivan, domain.DelegationSendOnBehalf, start.Add(time.Second))
+	for _, grant := range []*domain.SenderDelegation{toIvan, toJudy, fromJudy} {
+		must(t, r.SenderDelegations.Create(ctx, grant))
+	}
+	if err := r.SenderDelegations.Create(ctx, delegation(shared, ivan, domain.DelegationSendOnBehalf, start)); err == nil {
+		t.Fatal("Create of a second delegation to the same account succeeded")
+	}
+	if err := r.SenderDelegations.Create(ctx, delegation(ivan, ivan, domain.DelegationSendAs, start)); err == nil {
+		t.Fatal("Create of a delegation to the mailbox itself succeeded")
+	}
+	if err := r.SenderDelegations.Create(ctx, delegation(shared, &domain.EmailAccount{ID: newID()}, domain.DelegationSendAs, start)); err == nil {
+		t.Fatal("Create for a missing delegate succeeded")
+	}
+
+	got, err := r.SenderDelegations.GetByID(ctx, toJudy.ID)
+	must(t, err)
+	if got == nil || got.AccountID != shared.ID || got.DelegateAccountID != judy.ID ||
+		got.Permission != domain.DelegationSendOnBehalf || got.GrantedBy != shared.UserID ||
+		!sameTime(got.CreatedAt, toJudy.CreatedAt) {
+		t.Fatalf("GetByID: got %+v, want %+v", got, toJudy)
+	}
+	got, err = r.SenderDelegations.Get(ctx, shared.ID, ivan.ID)
+	must(t, err)
+	if got == nil || got.ID != toIvan.ID {
+		t.Fatalf("Get: got %+v", got)
+	}
+	got, err = r.SenderDelegations.Get(ctx, ivan.ID, shared.ID)
+	must(t, err)
+	if got != nil {
+		t.Fatalf("Get of the reverse delegation: got %+v", got)
+	}
+
+	id := func(delegation *domain.SenderDelegation) string { return delegation.ID }
+	byAccount, err := r.SenderDelegations.ListByAccount(ctx, shared.ID)
+	must(t, err)
+	expectIDs(t, "ListByAccount", ids(byAccount, id), []string{toIvan.ID, toJudy.ID})
+	byDelegate, err := r.SenderDelegations.ListByDelegate(ctx, ivan.ID)
+	must(t, err)
+	expectIDs(t, "ListByDelegate", ids(byDelegate, id), []string{toIvan.ID, fromJudy.ID})
+
+	updatedAt := start.Add(time.Minute)
+	toIvan.Permission = domain.DelegationSendOnBehalf
+	toIvan.GrantedBy = ivan.UserID
+	toIvan.UpdatedAt = updatedAt
+	must(t, r.SenderDelegations.Update(ctx, toIvan))
+	got, err = r.SenderDelegations.GetByID(ctx, toIvan.ID)
+	must(t, err)
+	if got.Permission != domain.DelegationSendOnBehalf || got.GrantedBy != ivan.UserID || !sameTime(got.UpdatedAt, updatedAt) {
+		t.Fatalf("Update: got %+v", got)
+	}
+
+	must(t, r.SenderDelegations.Delete(ctx, toIvan.ID))
+	byDelegate, err = r.SenderDelegations.ListByDelegate(ctx, ivan.ID)
+	must(t, err)
+	expectIDs(t, "ListByDelegate after Delete", ids(byDelegate, id), []string{fromJudy.ID})
+
+	// Deleting either account deletes the delegation
+	must(t, r.EmailAccounts.Delete(ctx, judy.ID))
+	byAccount, err = r.SenderDelegations.ListByAccount(ctx, shared.ID)
+	must(t, err)
+	expectIDs(t, "ListByAccount after delegate deletion", ids(byAccount, id), nil)
+	byDelegate, err = r.SenderDelegations.ListByDelegate(ctx, ivan.ID)
+	must(t, err)
+	expectIDs(t, "ListByDelegate after mailbox deletion", ids(byDelegate, id), nil)
+}
@@ -0,0 +1,378 @@
+package repotest
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/skygenesisenterprise/aether-mailer/package/golang/domain"
+)
+
+func testMTASTSPolicies(t *testing.T, r *Repositories) {
+	ctx := context.Background()
+	fetched := now()
+
+	missing, err := r.MTASTSPolicies.Get(ctx, "missing.example")
+	must(t, err)
+	if missing != nil {
+		t.Fatal("Get returned a policy that is not cached")
+	}
+
+	policy := &domain.MTASTSPolicy{
+		Domain:    "sts.example",
+		ID:        "20240101",
+		Mode:      domain.MTASTSModeEnforce,
+		MX:        []string{"mx1.sts.example", "*.backup.sts.example"},
+		MaxAge:    7 * 24 * time.Hour,
+		Text:      "version: STSv1\nmode: enforce\n",
+		FetchedAt: fetched,
+		ExpiresAt: fetched.Add(7 * 24 * time.Hour),
+	}
+	must(t, r.MTASTSPolicies.Save(ctx, policy))
+
+	got, err := r.MTASTSPolicies.Get(ctx, "sts.example")
+	must(t, err)
+	if got == nil || got.ID != "20240101" || got.Mode != domain.MTASTSModeEnforce || !sameStrings(got.MX, policy.MX) ||
+		got.MaxAge != policy.MaxAge || got.Text != policy.Text || !sameTime(got.ExpiresAt, policy.ExpiresAt) {
+		t.Fatalf("Get: got %+v", got)
+	}
+
+	policy.ID = "20240202"
+	policy.Mode = domain.MTASTSModeTesting
+	policy.MX = []string{"mx2.sts.example"}
+	policy.ExpiresAt = fetched.Add(time.Hour)
+	must(t, r.MTASTSPolicies.Save(ctx, policy))
+	got, err = r.MTASTSPolicies.Get(ctx, "sts.example")
+	must(t, err)
+	if got.ID != "20240202" || got.Mode != domain.MTASTSModeTesting || !sameStrings(got.MX, policy.MX) {
+		t.Fatalf("Save did not replace the policy: %+v", got)
+	}
+
+	other := *policy
+	other.Domain = "later.example"
+	other.ExpiresAt = fetched.Add(48 * time.Hour)
+	must(t, r.MTASTSPolicies.Save(ctx, &other))
+
+	removed, err := r.MTASTSPolicies.DeleteExpired(ctx, fetched.Add(24*time.Hour))
+	must(t, err)
+	expectCount(t, "DeleteExpired", removed, 1)
+	got, err = r.MTASTSPolicies.Get(ctx, "sts.example")
+	must(t, err)
+	if got != nil {
+		t.Fatal("DeleteExpired left an expired policy behind")
+	}
+	got, err = r.MTASTSPolicies.Get(ctx, "later.example")
+	must(t, err)
+	if got == nil {
+		t.Fatal("DeleteExpired removed a policy that has not expired")
+	}
+}
+
+func testTLSResults(t *testing.T, r *Repositories) {
+	ctx := context.Background()
+	day := time.Date(2024, 3, 10, 0, 0, 0, 0, time.UTC)
+
+	record := func(domainName string, policyType domain.TLSPolicyType, policy []string, mx string, result domain.TLSResultType, at time.Time) {
+		must(t, r.TLSResults.Record(ctx, &domain.TLSResult{
+			Domain:        domainName,
+			PolicyType:    policyType,
+			PolicyStrings: policy,
+			MXHost:        mx,
+			ResultType:    result,
+			At:            at,
+		}))
+	}
+	sts := []string{"version: STSv1", "mode: enforce"}
+	record("a.example", domain.TLSPolicyTypeSTS, sts, "mx1.a.example", domain.TLSResultCertificateExpired, day.Add(time.Hour))
+	record("a.example", domain.TLSPolicyTypeSTS, sts, "mx1.a.example", domain.TLSResultCertificateExpired, day.Add(20*time.Hour))
+	record("a.example", domain.TLSPolicyTypeSTS, sts, "mx2.a.example", domain.TLSResultCertificateExpired, day.Add(2*time.Hour))
+	record("a.example", domain.TLSPolicyTypeNoPolicy, nil, "mx1.a.example", domain.TLSResultStartTLSNotSupported, day.Add(3*time.Hour))
+	record("a.example", domain.TLSPolicyTypeSTS, sts, "mx1.a.example", domain.TLSResultCertificateExpired, day.Add(-time.Hour))
+	record("b.example", domain.TLSPolicyTypeSTS, sts, "mx.b.example", domain.TLSResultValidationFailure, day.Add(30*time.Hour))
+
+	summaries, err := r.TLSResults.Summarize(ctx, "a.example", day, day.Add(24*time.Hour))
+	must(t, err)
+	if len(summaries) != 3 {
+		t.Fatalf("Summarize: got %d summaries, want 3", len(summaries))
+	}
+	want := []struct {
+		policyType domain.TLSPolicyType
+		policy     []string
+		mx         string
+		count      int64
+	}{
+		{domain.TLSPolicyTypeNoPolicy, nil, "mx1.a.example", 1},
+		{domain.TLSPolicyTypeSTS, sts, "mx1.a.example", 2},
+		{domain.TLSPolicyTypeSTS, sts, "mx2.a.example", 1},
+	}
+	for i, w := range want {
+		got := summaries[i]
+		if got.Domain != "a.example" || got.PolicyType != w.policyType || !sameStrings(got.PolicyStrings, w.policy) ||
+			got.MXHost != w.mx || got.Count != w.count {
+			t.Fatalf("Summarize[%d]: got %+v, want %+v", i, got, w)
+		}
+	}
+
+	// A window starting mid-day still covers that whole day
+	summaries, err = r.TLSResults.Summarize(ctx, "a.example", day.Add(-12*time.Hour), day)
+	must(t, err)
+	if len(summaries) != 1 || summaries[0].Count != 1 {
+		t.Fatalf("Summarize of the previous day: got %+v", summaries)
+	}
+
+	domains, err := r.TLSResults.ListDomains(ctx, day, day.Add(48*time.Hour))
+	must(t, err)
+	expectIDs(t, "ListDomains", domains, []string{"a.example", "b.example"})
+	domains, err = r.TLSResults.ListDomains(ctx, day.Add(24*time.Hour), day.Add(48*time.Hour))
+	must(t, err)
+	expectIDs(t, "ListDomains of one day", domains, []string{"b.example"})
+}
+
+func testDKIMKeys(t *testing.T, r *Repositories) {
+	ctx := context.Background()
+	base := now().Add(-time.Hour)
+
+	newKey := func(domainName, selector string, state domain.DKIMKeyState, createdAt time.Time) *domain.DKIMKey {
+		key := &domain.DKIMKey{
+			ID:                  newID(),
+			Domain:              domainName,
+			Selector:            selector,
+			Algorithm:           domain.DKIMAlgorithmRSA,
+			KeyBits:             2048,
+			PublicKey:           "MIIBIjANBgkq",
+			EncryptedPrivateKey: []byte{1, 2, 3},
+			State:               state,
+			CreatedAt:           createdAt,
+			UpdatedAt:           createdAt,
+		}
+		must(t, r.DKIMKeys.Create(ctx, key))
+		return key
+	}
+	active := newKey("dkim.example", "s2024", domain.DKIMKeyActive, base)
+	pending := newKey("dkim.example", "s2025", domain.DKIMKeyPending, base.Add(time.Minute))
+	otherPending := newKey("other.example", "s2025", domain.DKIMKeyPending, base.Add(2*time.Minute))
+
+	if err := r.DKIMKeys.Create(ctx, &domain.DKIMKey{ID: newID(), Domain: "dkim.example", Selector: "s2024", Algorithm: domain.DKIMAlgorithmRSA, State: domain.DKIMKeyPending, CreatedAt: base, UpdatedAt: base}); err == nil {
+		t.Fatal("Create accepted a duplicate selector")
+	}
+	if err := r.DKIMKeys.Create(ctx, &domain.DKIMKey{ID: newID(), Domain: "dkim.example", Selector: "s2026", Algorithm: domain.DKIMAlgorithmRSA, State: domain.DKIMKeyActive, CreatedAt: base, UpdatedAt: base}); err == nil {
+		t.Fatal("Create accepted a second active key")
+	}
+
+	got, err := r.DKIMKeys.GetBySelector(ctx, "dkim.example", "s2025")
+	must(t, err)
+	if got == nil || got.ID != pending.ID || got.KeyBits != 2048 || string(got.EncryptedPrivateKey) != "\x01\x02\x03" {
+		t.Fatalf("GetBySelector: got %+v", got)
+	}
+	got, err = r.DKIMKeys.GetActive(ctx, "dkim.example")
+	must(t, err)
+	if got == nil || got.ID != active.ID {
+		t.Fatalf("GetActive: got %+v", got)
+	}
+	got, err = r.DKIMKeys.GetActive(ctx, "other.example")
+	must(t, err)
+	if got != nil {
+		t.Fatal("GetActive returned a key for a domain without one")
+	}
+
+	pending.State = domain.DKIMKeyActive
+	pending.ActivatedAt = ptr(now())
+	if err := r.DKIMKeys.Update(ctx, pending); err == nil {
+		t.Fatal("Update activated a second key")
+	}
+	active.State = domain.DKIMKeyRetiring
+	active.RetireAfter = ptr(now().Add(48 * time.Hour))
+	active.UpdatedAt = now()
+	must(t, r.DKIMKeys.Update(ctx, active))
+	must(t, r.DKIMKeys.Update(ctx, pending))
+
+	got, err = r.DKIMKeys.GetActive(ctx, "dkim.example")
+	must(t, err)
+	if got == nil || got.ID != pending.ID || !sameTimePtr(got.ActivatedAt, pending.ActivatedAt) {
+		t.Fatalf("GetActive after rotation: got %+v", got)
+	}
+	got, err = r.DKIMKeys.GetByID(ctx, active.ID)
+	must(t, err)
+	if got.State != domain.DKIMKeyRetiring || !sameTimePtr(got.RetireAfter, active.RetireAfter) {
+		t.Fatalf("Update was not saved: %+v", got)
+	}
+
+	id := func(k *domain.DKIMKey) string { return k.ID }
+	keys, err := r.DKIMKeys.ListByDomain(ctx, "dkim.example")
+	must(t, err)
+	expectIDs(t, "ListByDomain", ids(keys, id), []string{pending.ID, active.ID})
+
+	newer := newKey("other.example", "s2026", domain.DKIMKeyPending, base.Add(3*time.Minute))
+	keys, err = r.DKIMKeys.ListByState(ctx, domain.DKIMKeyPending)
+	must(t, err)
+	expectIDs(t, "ListByState", ids(keys, id), []string{otherPending.ID, newer.ID})
+}
+
+func testDKIMRotationLog(t *testing.T, r *Repositories) {
+	ctx := context.Background()
+	base := now().Add(-time.Hour)
+
+	record := func(domainName string, action domain.DKIMRotationAction, at time.Time) *domain.DKIMRotationEntry {
+		entry := &domain.DKIMRotationEntry{
+			ID:       newID(),
+			Domain:   domainName,
+			KeyID:    newID(),
+			Selector: "s2025",
+			Action:   action,
+			Actor:    "system",
+			Detail:   "scheduled rotation",
+			At:       at,
+		}
+		must(t, r.DKIMRotationLog.Record(ctx, entry))
+		return entry
+	}
+	generated := record("dkim.example", domain.DKIMActionGenerated, base)
+	activated := record("dkim.example", domain.DKIMActionActivated, base.Add(2*time.Minute))
+	published := record("dkim.example", domain.DKIMActionPublished, base.Add(time.Minute))
+	record("other.example", domain.DKIMActionGenerated, base)
+
+	id := func(e *domain.DKIMRotationEntry) string { return e.ID }
+	entries, err := r.DKIMRotationLog.ListByDomain(ctx, "dkim.example", 10)
+	must(t, err)
+	expectIDs(t, "ListByDomain", ids(entries, id), []string{activated.ID, published.ID, generated.ID})
+	if entries[0].Action != domain.DKIMActionActivated || entries[0].Actor != "system" || !sameTime(entries[0].At, activated.At) {
+		t.Fatalf("ListByDomain: got %+v", entries[0])
+	}
+
+	entries, err = r.DKIMRotationLog.ListByDomain(ctx, "dkim.example", 2)
+	must(t, err)
+	expectIDs(t, "ListByDomain with limit", ids(entries, id), []string{activated.ID, published.ID})
+}
+
+func testBlobs(t *testing.T, r *Repositories) {
+	ctx := context.Background()
+	past := now().Add(-time.Hour)
+
+	newBlob := func(id string, refs int) *domain.Blob {
+		return &domain.Blob{
+			ID:         id,
+			SHA256:     id,
+			StorageKey: "blobs/" + id,
+			Size:       128,
+			RefCount:   refs,
+			CreatedAt:  past,
+			UpdatedAt:  past,
+		}
+	}
+	orphan := newBlob("a1", 0)
+	shared := newBlob("b2", 1)
+	created, err := r.Blobs.Create(ctx, orphan)
+	must(t, err)
+	if !created {
+		t.Fatal("Create reported an existing blob")
+	}
+	created, err = r.Blobs.Create(ctx, shared)
+	must(t, err)
+	if !created {
+		t.Fatal("Create reported an existing blob")
+	}
+	created, err = r.Blobs.Create(ctx, newBlob("a1", 5))
+	must(t, err)
+	if created {
+		t.Fatal("Create stored the same blob twice")
+	}
+
+	got, err := r.Blobs.GetByID(ctx, "a1")
+	must(t, err)
+	if got == nil || got.RefCount != 0 || got.StorageKey != "blobs/a1" || got.Size != 128 {
+		t.Fatalf("GetByID: got %+v", got)
+	}
+	missing, err := r.Blobs.GetByID(ctx, "zz")
+	must(t, err)
+	if missing != nil {
+		t.Fatal("GetByID returned a blob that does not exist")
+	}
+
+	id := func(b *domain.Blob) string { return b.ID }
+	collectable, err := r.Blobs.ListCollectable(ctx, now(), 10)
+	must(t, err)
+	expectIDs(t, "ListCollectable", ids(collectable, id), []string{"a1"})
+
+	ok, err := r.Blobs.AddRef(ctx, "zz", 1)
+	must(t, err)
+	if ok {
+		t.Fatal("AddRef reported a missing blob")
+	}
+	ok, err = r.Blobs.AddRef(ctx, "b2", -3)
+	must(t, err)
+	if !ok {
+		t.Fatal("AddRef did not find the blob")
+	}
+	got, err = r.Blobs.GetByID(ctx, "b2")
+	must(t, err)
+	if got.RefCount != 0 || !got.UpdatedAt.After(past) {
+		t.Fatalf("AddRef below zero: got %+v", got)
+	}
+
+	// A blob touched after the cutoff is still in use and must survive
+	deleted, err := r.Blobs.DeleteUnreferenced(ctx, "b2", past.Add(time.Minute))
+	must(t, err)
+	if deleted {
+		t.Fatal("DeleteUnreferenced removed a recently touched blob")
+	}
+	deleted, err = r.Blobs.DeleteUnreferenced(ctx, "a1", now())
+	must(t, err)
+	if !deleted {
+		t.Fatal("DeleteUnreferenced kept an unreferenced blob")
+	}
+	ok, err = r.Blobs.AddRef(ctx, "a1", 1)
+	must(t, err)
+	if ok {
+		t.Fatal("AddRef revived a collected blob")
+	}
+
+	// Concurrent references must never lose a count
+	const workers = 20
+	var wg sync.WaitGroup
+	errs := make(chan error, workers)
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := r.Blobs.AddRef(ctx, "b2", 1)
+			errs <- err
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		must(t, err)
+	}
+	got, err = r.Blobs.GetByID(ctx, "b2")
+	must(t, err)
+	if got.RefCount != workers {
+		t.Fatalf("concurrent AddRef: got %d, want %d", got.RefCount, workers)
+	}
+}
+
+func testEvents(t *testing.T, r *Repositories) {
+	ctx := context.Background()
+	aggregate := newID()
+
+	first := domain.NewBaseEvent(newID(), aggregate, domain.EventTypeUserCreated, nil)
+	second := domain.NewBaseEvent(newID(), aggregate, domain.EventTypeUserUpdated, nil)
+	other := domain.NewBaseEvent(newID(), newID(), domain.EventTypeUserCreated, nil)
+	for _, event := range []domain.Event{first, second, other} {
+		must(t, r.Events.Save(ctx, event))
+		time.Sleep(time.Millisecond)
+	}
+
+	id := func(e domain.Event) string { return e.ID() }
+	events, err := r.Events.GetByAggregateID(ctx, aggregate, 0)
+	must(t, err)
+	expectIDs(t, "GetByAggregateID", ids(events, id), []string{second.ID(), first.ID()})
+
+	events, err = r.Events.GetByAggregateID(ctx, aggregate, 1)
+	must(t, err)
+	expectIDs(t, "GetByAggregateID with limit", ids(events, id), []string{second.ID()})
+
+	events, err = r.Events.GetByEventType(ctx, domain.EventTypeUserCreated, 0)
+	must(t, err)
+	expectIDs(t, "GetByEventType", ids(events, id), []string{other.ID(), first.ID()})
+}
@@ -0,0 +1,465 @@
+package repotest
+
+import (
+	"context"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/skygenesisenterprise/aether-mailer/package/golang/domain"
+	"github.com/skygenesisenterprise/aether-mailer/package/golang/repository"
+)
+
+func testFolders(t *testing.T, r *Repositories) {
+	ctx := context.Background()
+	account := newAccount(t, r, newDomain(t, r, "folders.example"), "bob")
+
+	inbox := newFolder(t, r, account.ID, nil, "INBOX", domain.FolderTypeInbox)
+	archive := newFolder(t, r, account.ID, nil, "Archive", domain.FolderTypeArchive)
+	year := newFolder(t, r, account.ID, &archive.ID, "Archive/2024", domain.FolderTypeCustom)
+
+	duplicate := *inbox
+	duplicate.ID = newID()
+	if err := r.Folders.Create(ctx, &duplicate); err == nil {
+		t.Fatal("Create accepted a duplicate path")
+	}
+
+	got, err := r.Folders.GetByType(ctx, account.ID, domain.FolderTypeInbox)
+	must(t, err)
+	if got == nil || got.ID != inbox.ID || got.Path != "INBOX" {
+		t.Fatalf("GetByType: got %+v", got)
+	}
+	none, err := r.Folders.GetByType(ctx, account.ID, domain.FolderTypeSpam)
+	must(t, err)
+	if none != nil {
+		t.Fatal("GetByType returned a folder that does not exist")
+	}
+
+	year.Name = "2025"
+	year.Path = "Archive/2025"
+	year.IsSubscribed = false
+	must(t, r.Folders.Update(ctx, year))
+	got, err = r.Folders.GetByID(ctx, year.ID)
+	must(t, err)
+	if got.Path != "Archive/2025" || got.IsSubscribed || got.ParentID == nil || *got.ParentID != archive.ID {
+		t.Fatalf("Update was not saved: %+v", got)
+	}
+
+	id := func(f *domain.Folder) string { return f.ID }
+	folders, err := r.Folders.ListByAccount(ctx, account.ID)
+	must(t, err)
+	expectIDs(t, "ListByAccount", ids(folders, id), []string{archive.ID, year.ID, inbox.ID})
+
+	must(t, r.Folders.Delete(ctx, archive.ID))
+	folders, err = r.Folders.ListByAccount(ctx, account.ID)
+	must(t, err)
+	expectIDs(t, "ListByAccount after deleting a parent", ids(folders, id), []string{inbox.ID})
+
+	must(t, r.EmailAccounts.Delete(ctx, account.ID))
+	folders, err = r.Folders.ListByAccount(ctx, account.ID)
+	must(t, err)
+	expectIDs(t, "ListByAccount after deleting the account", ids(folders, id), []string{})
+}
+
+func testMessages(t *testing.T, r *Repositories) {
+	ctx := context.Background()
+	account := newAccount(t, r, newDomain(t, r, "messages.example"), "bob")
+	folderID := inboxID(t, r, account.ID)
+	base := now().Add(-time.Hour)
+
+	missing, err := r.Messages.GetByID(ctx, newID())
+	must(t, err)
+	if missing != nil {
+		t.Fatal("GetByID returned a message that does not exist")
+	}
+
+	oldest := newMessage(account.ID, folderID, base, "alice@example.com", "Quarterly report")
+	middle := newMessage(account.ID, folderID, base.Add(time.Minute), "carol@example.com", "Lunch on Friday")
+	newest := newMessage(account.ID, folderID, base.Add(2*time.Minute), "alice@example.com", "Re: Quarterly report")
+	middle.IsRead = true
+	middle.Cc = []string{"dave@example.com"}
+	middle.Headers = map[string]string{"X-Priority": "1"}
+	for _, message := range []*domain.Message{oldest, middle, newest} {
+		must(t, r.Messages.Create(ctx, message))
+	}
+
+	got, err := r.Messages.GetByID(ctx, middle.ID)
+	must(t, err)
+	if got == nil || got.From != middle.From || got.Subject != middle.Subject || !sameStrings(got.To, middle.To) ||
+		!sameStrings(got.Cc, middle.Cc) || got.Headers["X-Priority"] != "1" || !got.IsRead ||
+		got.BodyText == nil || *got.BodyText != *middle.BodyText || !sameTime(got.ReceivedAt, middle.ReceivedAt) {
+		t.Fatalf("GetByID: got %+v, want %+v", got, middle)
+	}
+
+	id := func(m *domain.Message) string { return m.ID }
+	all, err := r.Messages.ListByAccount(ctx, account.ID, repository.MessageFilter{})
+	must(t, err)
+	expectIDs(t, "ListByAccount", ids(all, id), []string{newest.ID, middle.ID, oldest.ID})
+
+	paged, err := r.Messages.ListByAccount(ctx, account.ID, repository.MessageFilter{Limit: 1, Offset: 1})
+	must(t, err)
+	expectIDs(t, "ListByAccount page", ids(paged, id), []string{middle.ID})
+
+	unread, err := r.Messages.ListByAccount(ctx, account.ID, repository.MessageFilter{IsRead: ptr(false)})
+	must(t, err)
+	expectIDs(t, "ListByAccount unread", ids(unread, id), []string{newest.ID, oldest.ID})
+
+	fromAlice, err := r.Messages.ListByAccount(ctx, account.ID, repository.MessageFilter{From: ptr("ALICE@")})
+	must(t, err)
+	expectIDs(t, "ListByAccount from", ids(fromAlice, id), []string{newest.ID, oldest.ID})
+
+	bySubject, err := r.Messages.CountByAccount(ctx, account.ID, repository.MessageFilter{Subject: ptr("quarterly")})
+	must(t, err)
+	expectCount(t, "CountByAccount subject", bySubject, 2)
+
+	window, err := r.Messages.ListByAccount(ctx, account.ID, repository.MessageFilter{
+		DateFrom: ptr(base.Add(30 * time.Second)),
+		DateTo:   ptr(base.Add(90 * time.Second)),
+	})
+	must(t, err)
+	expectIDs(t, "ListByAccount date range", ids(window, id), []string{middle.ID})
+
+	oldest.IsRead = true
+	oldest.IsDeleted = true
+	oldest.UpdatedAt = base.Add(time.Hour)
+	must(t, r.Messages.Update(ctx, oldest))
+	got, err = r.Messages.GetByID(ctx, oldest.ID)
+	must(t, err)
+	if !got.IsRead || !got.IsDeleted || !sameTime(got.UpdatedAt, oldest.UpdatedAt) {
+		t.Fatalf("Update was not saved: %+v", got)
+	}
+
+	must(t, r.Messages.Delete(ctx, newest.ID))
+	got, err = r.Messages.GetByID(ctx, newest.ID)
+	must(t, err)
+	if got != nil {
+		t.Fatal("Delete left the message behind")
+	}
+	count, err := r.Messages.CountByAccount(ctx, account.ID, repository.MessageFilter{})
+	must(t, err)
+	expectCount(t, "CountByAccount after delete", count, 2)
+}
+
+func testMessageFlags(t *testing.T, r *Repositories) {
+	ctx := context.Background()
+	account := newAccount(t, r, newDomain(t, r, "flags.example"), "bob")
+	inbox := inboxID(t, r, account.ID)
+	archive := ""
+	if r.Folders != nil {
+		archive = newFolder(t, r, account.ID, nil, "Archive", domain.FolderTypeArchive).ID
+	}
+	base := now().Add(-time.Hour)
+
+	starred := newMessage(account.ID, inbox, base, "alice@example.com", "Starred")
+	flagged := newMessage(account.ID, inbox, base.Add(time.Minute), "alice@example.com", "Flagged")
+	filed := newMessage(account.ID, archive, base.Add(2*time.Minute), "alice@example.com", "Filed")
+	starred.IsStarred = true
+	starred.Labels = []string{"work", "q3 report"}
+	flagged.IsFlagged = true
+	flagged.Labels = []string{"work"}
+	flagged.ThreadID = newID()
+	filed.Attachments = []domain.Attachment{
+		{ID: newID(), Filename: "notes.txt", ContentType: "text/plain", Size: 5, Content: []byte("notes")},
+	}
+	for _, message := range []*domain.Message{starred, flagged, filed} {
+		must(t, r.Messages.Create(ctx, message))
+	}
+
+	got, err := r.Messages.GetByID(ctx, starred.ID)
+	must(t, err)
+	if got == nil || !got.IsStarred || got.IsFlagged || !sameStrings(got.Labels, starred.Labels) {
+		t.Fatalf("GetByID: got %+v, want %+v", got, starred)
+	}
+	got, err = r.Messages.GetByID(ctx, filed.ID)
+	must(t, err)
+	if got == nil || got.IsStarred || len(got.Labels) != 0 {
+		t.Fatalf("GetByID of an unlabelled message: got %+v", got)
+	}
+
+	id := func(m *domain.Message) string { return m.ID }
+	list := func(name string, filter repository.MessageFilter, want ...string) {
+		t.Helper()
+		messages, err := r.Messages.ListByAccount(ctx, account.ID, filter)
+		must(t, err)
+		expectIDs(t, name, ids(messages, id), want)
+		count, err := r.Messages.CountByAccount(ctx, account.ID, filter)
+		must(t, err)
+		expectCount(t, name+" count", count, len(want))
+	}
+	list("ListByAccount starred", repository.MessageFilter{IsStarred: ptr(true)}, starred.ID)
+	list("ListByAccount flagged", repository.MessageFilter{IsFlagged: ptr(true)}, flagged.ID)
+	list("ListByAccount label", repository.MessageFilter{Labels: []string{"work"}}, flagged.ID, starred.ID)
+	list("ListByAccount labels", repository.MessageFilter{Labels: []string{"work", "q3 report"}}, starred.ID)
+	list("ListByAccount thread", repository.MessageFilter{ThreadID: &flagged.ThreadID}, flagged.ID)
+	list("ListByAccount attachments", repository.MessageFilter{HasAttachments: ptr(true)}, filed.ID)
+	list("ListByAccount no attachments", repository.MessageFilter{HasAttachments: ptr(false)}, flagged.ID, starred.ID)
+	if r.Folders != nil {
+		list("ListByAccount folder", repository.MessageFilter{FolderID: &archive}, filed.ID)
+		paged, err := r.Messages.ListByAccount(ctx, account.ID, repository.MessageFilter{FolderID: &inbox, Limit: 1})
+		must(t, err)
+		expectIDs(t, "ListByAccount folder page", ids(paged, id), []string{flagged.ID})
+	}
+
+	starred.IsStarred = false
+	starred.IsFlagged = true
+	starred.Labels = []string{"personal"}
+	starred.ThreadID = flagged.ThreadID
+	starred.UpdatedAt = base.Add(time.Hour)
+	must(t, r.Messages.Update(ctx, starred))
+	got, err = r.Messages.GetByID(ctx, starred.ID)
+	must(t, err)
+	if got.IsStarred || !got.IsFlagged || !sameStrings(got.Labels, []string{"personal"}) || got.ThreadID != flagged.ThreadID {
+		t.Fatalf("Update was not saved: %+v", got)
+	}
+	list("ListByAccount label after update", repository.MessageFilter{Labels: []string{"work"}}, flagged.ID)
+	list("ListByAccount thread after update", repository.MessageFilter{ThreadID: &flagged.ThreadID}, flagged.ID, starred.ID)
+}
+
+func testMessageSearch(t *testing.T, r *Repositories) {
+	ctx := context.Background()
+	account := newAccount(t, r, newDomain(t, r, "search.example"), "bob")
+	other := newAccount(t, r, newDomain(t, r, "elsewhere.example"), "bob")
+	folderID := inboxID(t, r, account.ID)
+	base := now().Add(-time.Hour)
+
+	invoice := newMessage(account.ID, folderID, base, "billing@vendor.example", "Invoice for March")
+	invoice.BodyText = ptr("Please find the invoice attached. Payment is due in thirty days.")
+	meeting := newMessage(account.ID, folderID, base.Add(time.Minute), "alice@example.com", "Planning meeting")
+	meeting.BodyText = ptr("Let us review the budget and the invoice backlog.")
+	party := newMessage(account.ID, folderID, base.Add(2*time.Minute), "carol@example.com", "Office party")
+	party.BodyText = ptr("Cake in the kitchen at four.")
+	foreign := newMessage(other.ID, inboxID(t, r, other.ID), base, "billing@vendor.example", "Invoice for April")
+	report := newMessage(account.ID, folderID, base.Add(3*time.Minute), "dave@example.com", "Quarterly numbers")
+	report.To = []string{"erin@example.com"}
+	report.BodyText = ptr("See the attached summary.")
+	report.IsRead = true
+	report.IsStarred = true
+	report.Labels = []string{"finance"}
+	report.Attachments = []domain.Attachment{
+		{ID: newID(), Filename: "q3-figures.csv", ContentType: "text/csv", Size: 5, Content: []byte("1,2,3")},
+	}
+	digest := newMessage(account.ID, folderID, base.Add(4*time.Minute), "news@example.com", "Weekly digest")
+	digest.BodyText = nil
+	digest.BodyHTML = ptr(`<p>Lunch <b>menu</b> &amp; events</p><script>var hidden = 1;</script>`)
+	for _, message := range []*domain.Message{invoice, meeting, party, foreign, report, digest} {
+		must(t, r.Messages.Create(ctx, message))
+	}
+
+	id := func(m *domain.Message) string { return m.ID }
+	search := func(query string) []string {
+		t.Helper()
+		found, err := r.Messages.Search(ctx, repository.MessageSearchQuery{AccountID: account.ID, Query: query})
+		must(t, err)
+		return ids(found, id)
+	}
+
+	expectIDs(t, "single word", search("invoice"), []string{meeting.ID, invoice.ID})
+	expectIDs(t, "case", search("INVOICE"), []string{meeting.ID, invoice.ID})
+	expectIDs(t, "every word", search("invoice budget"), []string{meeting.ID})
+	expectIDs(t, "phrase", search(`"invoice attached"`), []string{invoice.ID})
+	expectIDs(t, "or", search("cake or budget"), []string{party.ID, meeting.ID})
+	expectIDs(t, "exclusion", search("invoice -budget"), []string{invoice.ID})
+	expectIDs(t, "sender", search("carol@example.com"), []string{party.ID})
+	expectIDs(t, "no match", search("spaceship"), []string{})
+
+	found, err := r.Messages.Search(ctx, repository.MessageSearchQuery{
+		AccountID: account.ID,
+		Query:     "invoice",
+		DateTo:    ptr(base.Add(30 * time.Second)),
+	})
+	must(t, err)
+	expectIDs(t, "date range", ids(found, id), []string{invoice.ID})
+
+	found, err = r.Messages.Search(ctx, repository.MessageSearchQuery{AccountID: account.ID, Query: "invoice", Limit: 1, Offset: 1})
+	must(t, err)
+	expectIDs(t, "page", ids(found, id), []string{invoice.ID})
+
+	expectIDs(t, "from", search("from:carol"), []string{party.ID})
+	expectIDs(t, "from other account", search("from:billing"), []string{invoice.ID})
+	expectIDs(t, "to", search("to:erin"), []string{report.ID})
+	expectIDs(t, "subject", search("subject:invoice"), []string{invoice.ID})
+	expectIDs(t, "subject phrase", search(`subject:"planning meeting"`), []string{meeting.ID})
+	expectIDs(t, "excluded subject", search("invoice -subject:invoice"), []string{meeting.ID})
+	expectIDs(t, "attachment filename", search("figures"), []string{report.ID})
+	expectIDs(t, "html body", search("lunch menu"), []string{digest.ID})
+	expectIDs(t, "html script", search("hidden"), []string{})
+	expectIDs(t, "has attachment", search("has:attachment"), []string{report.ID})
+	expectIDs(t, "unread", search("invoice is:unread"), []string{meeting.ID, invoice.ID})
+	expectIDs(t, "read", search("is:read"), []string{report.ID})
+	expectIDs(t, "starred", search("is:starred"), []string{report.ID})
+	expectIDs(t, "label", search("label:finance"), []string{report.ID})
+	expectIDs(t, "excluded label", search("-label:finance summary"), []string{})
+	tomorrow := time.Now().UTC().Add(24 * time.Hour).Format("2006-01-02")
+	expectIDs(t, "before", search("before:"+tomorrow+" invoice"), []string{meeting.ID, invoice.ID})
+	expectIDs(t, "after", search("after:"+tomorrow), []string{})
+
+	if _, err := r.Messages.Search(ctx, repository.MessageSearchQuery{AccountID: account.ID, Query: "before:soon"}); err == nil {
+		t.Fatal("Search accepted an invalid date")
+	}
+}
+
+func testSearchIndex(t *testing.T, r *Repositories) {
+	ctx := context.Background()
+	account := newAccount(t, r, newDomain(t, r, "index.example"), "bob")
+	other := newAccount(t, r, newDomain(t, r, "index-other.example"), "bob")
+	inbox := inboxID(t, r, account.ID)
+	archive := ""
+	if r.Folders != nil {
+		archive = newFolder(t, r, account.ID, nil, "Archive", domain.FolderTypeArchive).ID
+	}
+	base := now().Add(-time.Hour)
+
+	review := newMessage(account.ID, inbox, base, "alice@example.com", "Budget review")
+	review.BodyText = ptr("The numbers are in.")
+	lunch := newMessage(account.ID, inbox, base.Add(time.Minute), "carol@example.com", "Lunch")
+	lunch.BodyText = ptr("The budget for lunch is tight.")
+	old := newMessage(account.ID, archive, base.Add(-60*24*time.Hour), "alice@example.com", "Budget archive")
+	forecast := newMessage(account.ID, inbox, base.Add(2*time.Minute), "dave@example.com", "Figures")
+	foreign := newMessage(other.ID, inboxID(t, r, other.ID), base, "alice@example.com", "Budget")
+	for _, message := range []*domain.Message{review, lunch, old, forecast, foreign} {
+		must(t, r.Messages.Create(ctx, message))
+		document := domain.NewSearchDocument(message)
+		if message == forecast {
+			document.Attachments = "forecast.txt\nquarterly revenue forecast"
+		}
+		must(t, r.SearchIndex.Index(ctx, document))
+	}
+	// Indexing a message that does not exist is not an error and finds nothing
+	must(t, r.SearchIndex.Index(ctx, &domain.SearchDocument{MessageID: newID(), AccountID: account.ID, Subject: "Budget"}))
+
+	search := func(name, text string, folderID *string, limit, offset int, want ...string) {
+		t.Helper()
+		query, err := domain.ParseSearchQuery(text)
+		must(t, err)
+		hits, total, err := r.SearchIndex.Search(ctx, repository.SearchIndexQuery{
+			AccountID: account.ID,
+			Query:     query,
+			FolderID:  folderID,
+			Limit:     limit,
+			Offset:    offset,
+		})
+		must(t, err)
+		expectIDs(t, name, ids(hits, func(h repository.SearchHit) string { return h.MessageID }), want)
+		if limit == 0 {
+			expectCount(t, name+" total", total, len(want))
+		}
+		for i := 1; i < len(hits); i++ {
+			if hits[i].Score > hits[i-1].Score {
+				t.Fatalf("%s: hits are not ordered by score: %+v", name, hits)
+			}
+		}
+	}
+
+	// A subject match outranks a body match, and recency decays the rank
+	search("ranking", "budget", nil, 0, 0, review.ID, old.ID, lunch.ID)
+	search("page", "budget", nil, 1, 1, old.ID)
+	search("attachment text", "revenue forecast", nil, 0, 0, forecast.ID)
+	search("from", "from:alice", nil, 0, 0, review.ID, old.ID)
+	search("subject", "subject:budget", nil, 0, 0, review.ID, old.ID)
+	search("operators only", "is:unread", nil, 0, 0, forecast.ID, lunch.ID, review.ID, old.ID)
+	if r.Folders != nil {
+		search("folder", "budget", &archive, 0, 0, old.ID)
+	}
+
+	// Operators are checked against the stored message, not the document
+	lunch.IsRead = true
+	must(t, r.Messages.Update(ctx, lunch))
+	search("live state", "budget is:unread", nil, 0, 0, review.ID, old.ID)
+
+	must(t, r.Messages.Delete(ctx, review.ID))
+	search("deleted message", "budget", nil, 0, 0, old.ID, lunch.ID)
+	must(t, r.SearchIndex.Remove(ctx, lunch.ID))
+	search("removed document", "budget", nil, 0, 0, old.ID)
+	must(t, r.SearchIndex.Index(ctx, domain.NewSearchDocument(lunch)))
+	search("reindexed document", "budget", nil, 0, 0, old.ID, lunch.ID)
+
+	must(t, r.SearchIndex.RemoveAccount(ctx, account.ID))
+	search("removed account", "budget", nil, 0, 0)
+	query, err := domain.ParseSearchQuery("budget")
+	must(t, err)
+	hits, total, err := r.SearchIndex.Search(ctx, repository.SearchIndexQuery{AccountID: other.ID, Query: query})
+	must(t, err)
+	if total != 1 || len(hits) != 1 || hits[0].MessageID != foreign.ID {
+		t.Fatalf("RemoveAccount removed the documents of another account: %+v", hits)
+	}
+}
+
+func testAttachments(t *testing.T, r *Repositories) {
+	ctx := context.Background()
+	messageID := newID()
+
+	report := &domain.Attachment{ID: newID(), MessageID: messageID, Filename: "report.pdf", ContentType: "application/pdf", Size: 5, Content: []byte("%PDF-"), Checksum: "abc"}
+	photo := &domain.Attachment{ID: newID(), MessageID: messageID, Filename: "photo.jpg", ContentType: "image/jpeg", Size: 3, BlobID: "blob-1"}
+	must(t, r.Attachments.Create(ctx, report))
+	must(t, r.Attachments.Create(ctx, photo))
+
+	got, err := r.Attachments.GetByID(ctx, report.ID)
+	must(t, err)
+	if got == nil || got.Filename != "report.pdf" || string(got.Content) != "%PDF-" || got.Checksum != "abc" {
+		t.Fatalf("GetByID: got %+v", got)
+	}
+	missing, err := r.Attachments.GetByID(ctx, newID())
+	must(t, err)
+	if missing != nil {
+		t.Fatal("GetByID returned an attachment that does not exist")
+	}
+
+	id := func(a *domain.Attachment) string { return a.ID }
+	attachments, err := r.Attachments.GetByMessageID(ctx, messageID)
+	must(t, err)
+	expectIDs(t, "GetByMessageID", ids(attachments, id), []string{photo.ID, report.ID})
+	if attachments[0].BlobID != "blob-1" {
+		t.Fatalf("GetByMessageID lost the blob reference: %+v", attachments[0])
+	}
+
+	must(t, r.Attachments.Delete(ctx, photo.ID))
+	attachments, err = r.Attachments.GetByMessageID(ctx, messageID)
+	must(t, err)
+	expectIDs(t, "GetByMessageID after delete", ids(attachments, id), []string{report.ID})
+}
+
+func newFolder(t *testing.T, r *Repositories, accountID string, parentID *string, folderPath string, folderType domain.FolderType) *domain.Folder {
+	t.Helper()
+	folder := &domain.Folder{
+		ID:           newID(),
+		AccountID:    accountID,
+		ParentID:     parentID,
+		Name:         path.Base(folderPath),
+		Path:         folderPath,
+		Type:         folderType,
+		IsSubscribed: true,
+		CreatedAt:    now(),
+		UpdatedAt:    now(),
+	}
+	must(t, r.Folders.Create(context.Background(), folder))
+	return folder
+}
+
+// inboxID creates the inbox of an account when folders are stored, since
+// mailbox backends file every message in a folder
+func inboxID(t *testing.T, r *Repositories, accountID string) string {
+	t.Helper()
+	if r.Folders == nil {
+		return ""
+	}
+	return newFolder(t, r, accountID, nil, "INBOX", domain.FolderTypeInbox).ID
+}
+
+func newMessage(accountID, folderID string, receivedAt time.Time, from, subject string) *domain.Message {
+	return &domain.Message{
+		ID:         newID(),
+		AccountID:  accountID,
+		From:       from,
+		To:         []string{"bob@example.com"},
+		Cc:         []string{},
+		Bcc:        []string{},
+		Subject:    subject,
+		BodyText:   ptr("Hello"),
+		Headers:    map[string]string{},
+		FolderID:   folderID,
+		Size:       1024,
+		ReceivedAt: receivedAt,
+		CreatedAt:  receivedAt,
+		UpdatedAt:  receivedAt,
+	}
+}
@@ -0,0 +1,177 @@
+package repotest
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/skygenesisenterprise/aether-mailer/package/golang/domain"
+)
+
+func newOutboxEntry(aggregateID string, createdAt, nextAttemptAt time.Time) *domain.OutboxEntry {
+	return &domain.OutboxEntry{
+		ID:            newID(),
+		AggregateID:   aggregateID,
+		EventType:     domain.EventTypeMessageSent,
+		Payload:       []byte(`{"subject":"hello","size":42}`),
+		OccurredAt:    createdAt,
+		Status:        domain.OutboxStatusPending,
+		NextAttemptAt: nextAttemptAt,
+		CreatedAt:     createdAt,
+	}
+}
+
+func samePayload(t *testing.T, got, want []byte) bool {
+	t.Helper()
+	var a, b interface{}
+	must(t, json.Unmarshal(got, &a))
+	must(t, json.Unmarshal(want, &b))
+	return reflect.DeepEqual(a, b)
+}
+
+func testOutbox(t *testing.T, r *Repositories) {
+	ctx := context.Background()
+	start := now()
+	lease := time.Minute
+	aggregate := newID()
+
+	first := newOutboxEntry(aggregate, start, start)
+	second := newOutboxEntry(aggregate, start.Add(time.Second), start)
+	later := newOutboxEntry(newID(), start, start.Add(time.Hour))
+	must(t, r.Outbox.Add(ctx, []*domain.OutboxEntry{first, second, later}))
+	must(t, r.Outbox.Add(ctx, []*domain.OutboxEntry{first}))
+
+	if r.Events != nil {
+		events, err := r.Events.GetByAggregateID(ctx, aggregate, 0)
+		must(t, err)
+		expectIDs(t, "events of added entries", ids(events, func(e domain.Event) string { return e.ID() }),
+			[]string{second.ID, first.ID})
+		data, ok := events[1].Data().(json.RawMessage)
+		if !ok || !samePayload(t, data, first.Payload) {
+			t.Fatalf("event data: got %v", events[1].Data())
+		}
+	}
+
+	id := func(e *domain.OutboxEntry) string { return e.ID }
+	claimed, err := r.Outbox.Claim(ctx, start, 10, lease)
+	must(t, err)
+	expectIDs(t, "Claim", ids(claimed, id), []string{first.ID, second.ID})
+	got := claimed[0]
+	if got.AggregateID != aggregate || got.EventType != first.EventType || !samePayload(t, got.Payload, first.Payload) ||
+		!sameTime(got.OccurredAt, first.OccurredAt) || !sameTime(got.CreatedAt, first.CreatedAt) ||
+		got.Status != domain.OutboxStatusPending || got.Attempts != 1 || !sameTime(got.NextAttemptAt, start.Add(lease)) ||
+		got.DeliveredAt != nil {
+		t.Fatalf("Claim: got %+v", got)
+	}
+
+	claimed, err = r.Outbox.Claim(ctx, start.Add(time.Second), 10, lease)
+	must(t, err)
+	expectCount(t, "Claim of leased entries", len(claimed), 0)
+
+	// An expired lease makes the entries due again
+	claimed, err = r.Outbox.Claim(ctx, start.Add(2*lease), 10, lease)
+	must(t, err)
+	expectIDs(t, "Claim after lease expiry", ids(claimed, id), []string{first.ID, second.ID})
+	expectCount(t, "attempts", claimed[1].Attempts, 2)
+
+	deliveredAt := start.Add(2 * lease)
+	must(t, r.Outbox.MarkDelivered(ctx, first.ID, deliveredAt))
+	must(t, r.Outbox.MarkFailed(ctx, second.ID, "handler failed", start.Add(10*lease)))
+	claimed, err = r.Outbox.Claim(ctx, start.Add(5*lease), 10, lease)
+	must(t, err)
+	expectCount(t, "Claim before retry", len(claimed), 0)
+
+	must(t, r.Outbox.MarkDead(ctx, second.ID, "gave up"))
+	dead, err := r.Outbox.ListDead(ctx, 0, 0)
+	must(t, err)
+	expectIDs(t, "ListDead", ids(dead, id), []string{second.ID})
+	if dead[0].Status != domain.OutboxStatusDead || dead[0].LastError != "gave up" || dead[0].Attempts != 2 {
+		t.Fatalf("ListDead: got %+v", dead[0])
+	}
+	claimed, err = r.Outbox.Claim(ctx, start.Add(20*lease), 10, lease)
+	must(t, err)
+	expectCount(t, "Claim of dead entries", len(claimed), 0)
+
+	requeued, err := r.Outbox.Requeue(ctx, second.ID, start.Add(20*lease))
+	must(t, err)
+	if !requeued {
+		t.Fatal("Requeue of a dead letter: got false")
+	}
+	for _, entryID := range []string{second.ID, first.ID, newID()} {
+		requeued, err = r.Outbox.Requeue(ctx, entryID, start)
+		must(t, err)
+		if requeued {
+			t.Fatalf("Requeue of %s that is not dead: got true", entryID)
+		}
+	}
+	claimed, err = r.Outbox.Claim(ctx, start.Add(20*lease), 1, lease)
+	must(t, err)
+	expectIDs(t, "Claim of requeued entry", ids(claimed, id), []string{second.ID})
+	expectCount(t, "attempts after requeue", claimed[0].Attempts, 1)
+
+	// Missing entries are ignored
+	must(t, r.Outbox.MarkDelivered(ctx, newID(), start))
+	must(t, r.Outbox.MarkFailed(ctx, newID(), "", start))
+	must(t, r.Outbox.MarkDead(ctx, newID(), ""))
+
+	deleted, err := r.Outbox.DeleteDelivered(ctx, deliveredAt)
+	must(t, err)
+	expectCount(t, "DeleteDelivered before delivery", deleted, 0)
+	deleted, err = r.Outbox.DeleteDelivered(ctx, deliveredAt.Add(time.Second))
+	must(t, err)
+	expectCount(t, "DeleteDelivered", deleted, 1)
+	if r.Events != nil {
+		events, err := r.Events.GetByAggregateID(ctx, aggregate, 0)
+		must(t, err)
+		expectCount(t, "events after DeleteDelivered", len(events), 2)
+	}
+
+	// Concurrent relays never claim the same entry
+	const entries, workers = 20, 4
+	batch := make([]*domain.OutboxEntry, 0, entries)
+	for i := 0; i < entries; i++ {
+		batch = append(batch, newOutboxEntry(newID(), start, start))
+	}
+	must(t, r.Outbox.Add(ctx, batch))
+
+	var (
+		mu   sync.Mutex
+		seen = map[string]int{}
+		wg   sync.WaitGroup
+	)
+	errs := make(chan error, workers)
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				claimed, err := r.Outbox.Claim(ctx, start.Add(30*lease), 3, time.Hour)
+				if err != nil {
+					errs <- err
+					return
+				}
+				if len(claimed) == 0 {
+					return
+				}
+				mu.Lock()
+				for _, entry := range claimed {
+					seen[entry.ID]++
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		must(t, err)
+	}
+	for _, entry := range batch {
+		if seen[entry.ID] != 1 {
+			t.Fatalf("concurrent Claim: entry claimed %d times", seen[entry.ID])
+		}
+	}
+}
@@ -0,0 +1,336 @@
+package repotest
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/skygenesisenterprise/aether-mailer/package/golang/domain"
+	"github.com/skygenesisenterprise/aether-mailer/package/golang/repository"
+)
+
+func testQuotas(t *testing.T, r *Repositories) {
+	ctx := context.Background()
+	userID, domainID := newID(), newID()
+	resetAt := now().Add(-time.Hour)
+
+	missing, err := r.Quotas.GetByUserID(ctx, userID)
+	must(t, err)
+	if missing != nil {
+		t.Fatal("GetByUserID returned a quota that does not exist")
+	}
+
+	userQuota := &domain.Quota{UserID: userID, DomainID: &domainID, MaxStorageMB: 100, MaxEmailsPerDay: 50, SentEmailsToday: 12, ResetAt: resetAt}
+	domainQuota := &domain.Quota{DomainID: &domainID, MaxStorageMB: 1000, MaxEmailsPerDay: 500, SentEmailsToday: 7, ResetAt: resetAt}
+	must(t, r.Quotas.Create(ctx, userQuota))
+	must(t, r.Quotas.Create(ctx, domainQuota))
+
+	duplicate := *userQuota
+	if err := r.Quotas.Create(ctx, &duplicate); err == nil {
+		t.Fatal("Create accepted a second quota for a user")
+	}
+	duplicate = *domainQuota
+	if err := r.Quotas.Create(ctx, &duplicate); err == nil {
+		t.Fatal("Create accepted a second quota for a domain")
+	}
+
+	got, err := r.Quotas.GetByUserID(ctx, userID)
+	must(t, err)
+	if got == nil || got.MaxStorageMB != 100 || got.SentEmailsToday != 12 || got.DomainID == nil || *got.DomainID != domainID {
+		t.Fatalf("GetByUserID: got %+v", got)
+	}
+	got, err = r.Quotas.GetByDomainID(ctx, domainID)
+	must(t, err)
+	if got == nil || got.UserID != "" || got.MaxStorageMB != 1000 {
+		t.Fatalf("GetByDomainID returned %+v, want the domain quota", got)
+	}
+
+	userQuota.UsedStorageMB = 30
+	must(t, r.Quotas.Update(ctx, userQuota))
+	domainQuota.UsedStorageMB = 300
+	must(t, r.Quotas.Update(ctx, domainQuota))
+	got, err = r.Quotas.GetByUserID(ctx, userID)
+	must(t, err)
+	if got.UsedStorageMB != 30 {
+		t.Fatalf("Update of the user quota was not saved: %+v", got)
+	}
+	got, err = r.Quotas.GetByDomainID(ctx, domainID)
+	must(t, err)
+	if got.UsedStorageMB != 300 {
+		t.Fatalf("Update of the domain quota was not saved: %+v", got)
+	}
+
+	must(t, r.Quotas.ResetDailyCounters(ctx, userID))
+	got, err = r.Quotas.GetByUserID(ctx, userID)
+	must(t, err)
+	if got.SentEmailsToday != 0 || !got.ResetAt.After(time.Now()) {
+		t.Fatalf("ResetDailyCounters: got %+v", got)
+	}
+	got, err = r.Quotas.GetByDomainID(ctx, domainID)
+	must(t, err)
+	if got.SentEmailsToday != 7 {
+		t.Fatal("ResetDailyCounters of a user reset another quota")
+	}
+	must(t, r.Quotas.ResetDailyCounters(ctx, ""))
+	got, err = r.Quotas.GetByDomainID(ctx, domainID)
+	must(t, err)
+	if got.SentEmailsToday != 0 {
+		t.Fatal("ResetDailyCounters without a user left a quota untouched")
+	}
+}
+
+func testPolicies(t *testing.T, r *Repositories) {
+	ctx := context.Background()
+	domainID := newID()
+	base := now().Add(-time.Hour)
+
+	newPolicy := func(name string, policyType domain.PolicyType, priority int, active bool, createdAt time.Time) *domain.Policy {
+		policy := &domain.Policy{
+			ID:        newID(),
+			DomainID:  &domainID,
+			Name:      name,
+			Type:      policyType,
+			Rule:      "size > 10MB",
+			Action:    domain.PolicyActionBlock,
+			IsActive:  active,
+			Priority:  priority,
+			CreatedAt: createdAt,
+			UpdatedAt: createdAt,
+		}
+		must(t, r.Policies.Create(ctx, policy))
+		return policy
+	}
+	low := newPolicy("low", domain.PolicyTypeContent, 1, true, base)
+	high := newPolicy("high", domain.PolicyTypeContent, 10, true, base.Add(time.Minute))
+	tie := newPolicy("tie", domain.PolicyTypeContent, 10, true, base.Add(2*time.Minute))
+	inactive := newPolicy("inactive", domain.PolicyTypeContent, 100, false, base.Add(3*time.Minute))
+	spam := newPolicy("spam", domain.PolicyTypeSpam, 5, true, base.Add(4*time.Minute))
+	global := &domain.Policy{ID: newID(), Name: "global", Type: domain.PolicyTypeContent, Action: domain.PolicyActionTag, IsActive: true, CreatedAt: base.Add(5 * time.Minute), UpdatedAt: base}
+	must(t, r.Policies.Create(ctx, global))
+
+	got, err := r.Policies.GetByID(ctx, low.ID)
+	must(t, err)
+	if got == nil || got.Name != "low" || got.DomainID == nil || *got.DomainID != domainID || got.UserID != nil {
+		t.Fatalf("GetByID: got %+v", got)
+	}
+
+	id := func(p *domain.Policy) string { return p.ID }
+	all, err := r.Policies.List(ctx, repository.PolicyFilter{DomainID: &domainID})
+	must(t, err)
+	expectIDs(t, "List", ids(all, id), []string{spam.ID, inactive.ID, tie.ID, high.ID, low.ID})
+
+	paged, err := r.Policies.List(ctx, repository.PolicyFilter{Limit: 2, Offset: 1})
+	must(t, err)
+	expectIDs(t, "List page", ids(paged, id), []string{spam.ID, inactive.ID})
+
+	active, err := r.Policies.GetActivePolicies(ctx, repository.PolicyFilter{DomainID: &domainID, Type: ptr(domain.PolicyTypeContent)})
+	must(t, err)
+	expectIDs(t, "GetActivePolicies", ids(active, id), []string{high.ID, tie.ID, low.ID})
+
+	low.IsActive = false
+	low.Rule = "size > 20MB"
+	must(t, r.Policies.Update(ctx, low))
+	got, err = r.Policies.GetByID(ctx, low.ID)
+	must(t, err)
+	if got.IsActive || got.Rule != "size > 20MB" {
+		t.Fatalf("Update was not saved: %+v", got)
+	}
+
+	must(t, r.Policies.Delete(ctx, tie.ID))
+	inactiveOnly, err := r.Policies.List(ctx, repository.PolicyFilter{DomainID: &domainID, IsActive: ptr(false)})
+	must(t, err)
+	expectIDs(t, "List inactive", ids(inactiveOnly, id), []string{inactive.ID, low.ID})
+}
+
+func testSpam(t *testing.T, r *Repositories) {
+	ctx := context.Background()
+	accountID := newID()
+
+	tokens, err := r.Spam.GetTokens(ctx, accountID, []string{"viagra"})
+	must(t, err)
+	if len(tokens) != 0 {
+		t.Fatalf("GetTokens of an untrained account: got %v", tokens)
+	}
+	totals, err := r.Spam.GetTotals(ctx, accountID)
+	must(t, err)
+	if totals != nil {
+		t.Fatal("GetTotals of an untrained account returned totals")
+	}
+
+	must(t, r.Spam.IncrementTokens(ctx, accountID, []string{"viagra", "offer", "viagra"}, 1, 0))
+	must(t, r.Spam.IncrementTokens(ctx, accountID, []string{"offer", "meeting"}, 0, 2))
+	must(t, r.Spam.IncrementTokens(ctx, accountID, []string{"meeting"}, -5, -1))
+	must(t, r.Spam.IncrementTokens(ctx, newID(), []string{"viagra"}, 1, 0))
+
+	tokens, err = r.Spam.GetTokens(ctx, accountID, []string{"viagra", "offer", "meeting", "unknown"})
+	must(t, err)
+	want := map[string][2]int{"viagra": {1, 0}, "offer": {1, 2}, "meeting": {0, 1}}
+	if len(tokens) != len(want) {
+		t.Fatalf("GetTokens: got %d tokens, want %d", len(tokens), len(want))
+	}
+	for token, counts := range want {
+		got := tokens[token]
+		if got == nil || got.SpamCount != counts[0] || got.HamCount != counts[1] || got.AccountID != accountID {
+			t.Fatalf("GetTokens %s: got %+v, want spam %d ham %d", token, got, counts[0], counts[1])
+		}
+	}
+
+	must(t, r.Spam.IncrementTotals(ctx, accountID, 3, 1))
+	must(t, r.Spam.IncrementTotals(ctx, accountID, -5, 1))
+	totals, err = r.Spam.GetTotals(ctx, accountID)
+	must(t, err)
+	if totals == nil || totals.SpamMessages != 0 || totals.HamMessages != 2 {
+		t.Fatalf("GetTotals: got %+v", totals)
+	}
+
+	messageID := newID()
+	class, err := r.Spam.GetMessageClass(ctx, accountID, messageID)
+	must(t, err)
+	if class != nil {
+		t.Fatal("GetMessageClass of an untrained message returned a class")
+	}
+	must(t, r.Spam.SetMessageClass(ctx, accountID, messageID, ptr(domain.SpamClassSpam)))
+	must(t, r.Spam.SetMessageClass(ctx, accountID, messageID, ptr(domain.SpamClassHam)))
+	class, err = r.Spam.GetMessageClass(ctx, accountID, messageID)
+	must(t, err)
+	if class == nil || *class != domain.SpamClassHam {
+		t.Fatalf("GetMessageClass: got %v", class)
+	}
+	must(t, r.Spam.SetMessageClass(ctx, accountID, messageID, nil))
+	class, err = r.Spam.GetMessageClass(ctx, accountID, messageID)
+	must(t, err)
+	if class != nil {
+		t.Fatal("SetMessageClass with nil did not forget the class")
+	}
+
+	// Concurrent training must not lose updates
+	const workers = 20
+	var wg sync.WaitGroup
+	errs := make(chan error, 2*workers)
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs <- r.Spam.IncrementTokens(ctx, accountID, []string{"concurrent"}, 1, 0)
+			errs <- r.Spam.IncrementTotals(ctx, accountID, 0, 1)
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		must(t, err)
+	}
+	tokens, err = r.Spam.GetTokens(ctx, accountID, []string{"concurrent"})
+	must(t, err)
+	if tokens["concurrent"] == nil || tokens["concurrent"].SpamCount != workers {
+		t.Fatalf("concurrent IncrementTokens: got %+v, want %d", tokens["concurrent"], workers)
+	}
+	totals, err = r.Spam.GetTotals(ctx, accountID)
+	must(t, err)
+	if totals.HamMessages != 2+workers {
+		t.Fatalf("concurrent IncrementTotals: got %d, want %d", totals.HamMessages, 2+workers)
+	}
+}
+
+func testQuarantine(t *testing.T, r *Repositories) {
+	ctx := context.Background()
+	alice, bob := newID(), newID()
+	domainID := newID()
+	base := now().Add(-time.Hour)
+
+	newEntry := func(accountID string, reason domain.QuarantineReason, createdAt time.Time) *domain.QuarantineEntry {
+		entry := &domain.QuarantineEntry{
+			ID:         newID(),
+			MessageID:  newID(),
+			AccountID:  accountID,
+			DomainID:   domainID,
+			Sender:     "spammer@example.net",
+			Recipients: []string{"alice@example.com"},
+			Subject:    "Cheap watches",
+			Reason:     reason,
+			Details:    "score 12.5",
+			Message:    domain.Message{ID: newID(), AccountID: accountID, Subject: "Cheap watches"},
+			RawMessage: []byte("Subject: Cheap watches\r\n\r\nBuy now\r\n"),
+			Size:       36,
+			Status:     domain.QuarantineStatusHeld,
+			CreatedAt:  createdAt,
+			ExpiresAt:  createdAt.Add(30 * 24 * time.Hour),
+		}
+		must(t, r.Quarantine.Create(ctx, entry))
+		return entry
+	}
+	first := newEntry(alice, domain.QuarantineReasonSpam, base)
+	second := newEntry(bob, domain.QuarantineReasonVirus, base.Add(time.Minute))
+	third := newEntry(alice, domain.QuarantineReasonSpam, base.Add(2*time.Minute))
+
+	got, err := r.Quarantine.GetByID(ctx, first.ID)
+	must(t, err)
+	if got == nil || got.Sender != first.Sender || !sameStrings(got.Recipients, first.Recipients) ||
+		string(got.RawMessage) != string(first.RawMessage) || got.Message.Subject != "Cheap watches" ||
+		!sameTime(got.ExpiresAt, first.ExpiresAt) {
+		t.Fatalf("GetByID: got %+v", got)
+	}
+
+	id := func(e *domain.QuarantineEntry) string { return e.ID }
+	all, err := r.Quarantine.List(ctx, repository.QuarantineFilter{})
+	must(t, err)
+	expectIDs(t, "List", ids(all, id), []string{third.ID, second.ID, first.ID})
+
+	byAccount, err := r.Quarantine.List(ctx, repository.QuarantineFilter{AccountIDs: []string{alice}})
+	must(t, err)
+	expectIDs(t, "List by account", ids(byAccount, id), []string{third.ID, first.ID})
+
+	viruses, err := r.Quarantine.Count(ctx, repository.QuarantineFilter{Reason: ptr(domain.QuarantineReasonVirus)})
+	must(t, err)
+	expectCount(t, "Count viruses", viruses, 1)
+
+	recent, err := r.Quarantine.List(ctx, repository.QuarantineFilter{CreatedAfter: ptr(base), Limit: 1})
+	must(t, err)
+	expectIDs(t, "List created after", ids(recent, id), []string{third.ID})
+
+	first.Status = domain.QuarantineStatusReleased
+	first.ReleasedAt = ptr(now())
+	first.ReleasedBy = ptr("admin")
+	first.DigestSentAt = ptr(now())
+	must(t, r.Quarantine.Update(ctx, first))
+	got, err = r.Quarantine.GetByID(ctx, first.ID)
+	must(t, err)
+	if got.Status != domain.QuarantineStatusReleased || !sameTimePtr(got.ReleasedAt, first.ReleasedAt) ||
+		got.ReleasedBy == nil || *got.ReleasedBy != "admin" {
+		t.Fatalf("Update was not saved: %+v", got)
+	}
+
+	pending, err := r.Quarantine.List(ctx, repository.QuarantineFilter{DigestPending: ptr(true), Status: ptr(domain.QuarantineStatusHeld)})
+	must(t, err)
+	expectIDs(t, "List digest pending", ids(pending, id), []string{third.ID, second.ID})
+
+	// Only the first of two releases changes a held entry
+	releasedAt := now()
+	for i, want := range []bool{true, false} {
+		released, err := r.Quarantine.Release(ctx, third.ID, "alice", releasedAt.Add(time.Duration(i)*time.Minute))
+		must(t, err)
+		if released != want {
+			t.Fatalf("Release #%d = %v, want %v", i+1, released, want)
+		}
+	}
+	got, err = r.Quarantine.GetByID(ctx, third.ID)
+	must(t, err)
+	if got.Status != domain.QuarantineStatusReleased || !sameTimePtr(got.ReleasedAt, &releasedAt) ||
+		got.ReleasedBy == nil || *got.ReleasedBy != "alice" {
+		t.Fatalf("Release was not saved: %+v", got)
+	}
+	released, err := r.Quarantine.Release(ctx, newID(), "alice", releasedAt)
+	must(t, err)
+	if released {
+		t.Fatal("Release of a missing entry succeeded")
+	}
+
+	must(t, r.Quarantine.Delete(ctx, second.ID))
+	removed, err := r.Quarantine.DeleteExpired(ctx, base.Add(30*24*time.Hour+time.Minute))
+	must(t, err)
+	expectCount(t, "DeleteExpired", removed, 1)
+	count, err := r.Quarantine.Count(ctx, repository.QuarantineFilter{DomainID: &domainID})
+	must(t, err)
+	expectCount(t, "Count after expiry", count, 1)
+}
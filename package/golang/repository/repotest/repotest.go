@@ -0,0 +1,274 @@
+// Package repotest is a conformance suite for repository implementations.
+// Every backend runs the same checks so the in-memory store used in tests
+// behaves like Postgres in production.
+//
+//	func TestConformance(t *testing.T) {
+//		repotest.Run(t, func(t *testing.T) *repotest.Repositories {
+//			store := inmemory.NewStore()
+//			return &repotest.Repositories{
+//				Users:   inmemory.NewUserRepository(store),
+//				Domains: inmemory.NewDomainRepository(store),
+//				// ...
+//			}
+//		})
+//	}
+package repotest
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/skygenesisenterprise/aether-mailer/package/golang/domain"
+	"github.com/skygenesisenterprise/aether-mailer/package/golang/repository"
+)
+
+// Repositories holds the implementations under test. They must share one
+// underlying store so foreign keys and cascades can be checked, and Outbox
+// must write to Events when both are set. Suites for nil fields are skipped.
+type Repositories struct {
+	Users               repository.UserRepository
+	Domains             repository.DomainRepository
+	DomainMembers       repository.DomainMemberRepository
+	EmailAccounts       repository.EmailAccountRepository
+	EmailAliases        repository.EmailAliasRepository
+	DNSRecords          repository.DNSRecordRepository
+	Folders             repository.FolderRepository
+	Messages            repository.MessageRepository
+	Attachments         repository.AttachmentRepository
+	Quotas              repository.QuotaRepository
+	Policies            repository.PolicyRepository
+	Spam                repository.SpamRepository
+	Quarantine          repository.QuarantineRepository
+	RateCounters        repository.RateCounterStore
+	Suspensions         repository.SendingSuspensionRepository
+	DestinationPolicies repository.DestinationPolicyRepository
+	DeliveryJobs        repository.DeliveryJobRepository
+	IPPools             repository.IPPoolRepository
+	PoolAssignments     repository.PoolAssignmentRepository
+	MTASTSPolicies      repository.MTASTSPolicyRepository
+	TLSResults          repository.TLSResultRepository
+	DKIMKeys            repository.DKIMKeyRepository
+	DKIMRotationLog     repository.DKIMRotationLogRepository
+	Blobs               repository.BlobRepository
+	SearchIndex         repository.SearchIndex
+	Threads             repository.ThreadRepository
+	ScheduledSends      repository.ScheduledSendRepository
+	Identities          repository.IdentityRepository
+	SenderDelegations   repository.SenderDelegationRepository
+	Groups              repository.GroupRepository
+	FolderACLs          repository.FolderACLRepository
+	ACLAuditLog         repository.ACLAuditLogRepository
+	Events              domain.EventStore
+	Outbox              repository.OutboxRepository
+}
+
+// Factory returns repositories on a fresh, empty store. It is called once
+// per suite and should register its own cleanup on t.
+type Factory func(t *testing.T) *Repositories
+
+type suite struct {
+	name  string
+	needs func(*Repositories) bool
+	run   func(*testing.T, *Repositories)
+}
+
+var suites = []suite{
+	{"Users", func(r *Repositories) bool { return r.Users != nil }, testUsers},
+	{"Domains", func(r *Repositories) bool { return r.Users != nil && r.Domains != nil }, testDomains},
+	{"DomainMembers", func(r *Repositories) bool {
+		return r.Users != nil && r.Domains != nil && r.DomainMembers != nil
+	}, testDomainMembers},
+	{"EmailAccounts", func(r *Repositories) bool {
+		return r.Users != nil && r.Domains != nil && r.EmailAccounts != nil
+	}, testEmailAccounts},
+	{"EmailAliases", func(r *Repositories) bool {
+		return r.Users != nil && r.Domains != nil && r.EmailAliases != nil
+	}, testEmailAliases},
+	{"DNSRecords", func(r *Repositories) bool {
+		return r.Users != nil && r.Domains != nil && r.DNSRecords != nil
+	}, testDNSRecords},
+	{"Folders", func(r *Repositories) bool { return r.hasAccounts() && r.Folders != nil }, testFolders},
+	{"Messages", func(r *Repositories) bool { return r.hasAccounts() && r.Messages != nil }, testMessages},
+	{"MessageSearch", func(r *Repositories) bool { return r.hasAccounts() && r.Messages != nil }, testMessageSearch},
+	{"MessageFlags", func(r *Repositories) bool { return r.hasAccounts() && r.Messages != nil }, testMessageFlags},
+	{"SearchIndex", func(r *Repositories) bool {
+		return r.hasAccounts() && r.Messages != nil && r.SearchIndex != nil
+	}, testSearchIndex},
+	{"Threads", func(r *Repositories) bool { return r.hasAccounts() && r.Threads != nil }, testThreads},
+	{"ScheduledSends", func(r *Repositories) bool { return r.hasAccounts() && r.ScheduledSends != nil }, testScheduledSends},
+	{"Identities", func(r *Repositories) bool { return r.hasAccounts() && r.Identities != nil }, testIdentities},
+	{"SenderDelegations", func(r *Repositories) bool {
+		return r.hasAccounts() && r.SenderDelegations != nil
+	}, testSenderDelegations},
+	{"Groups", func(r *Repositories) bool { return r.hasAccounts() && r.Groups != nil }, testGroups},
+	{"FolderACLs", func(r *Repositories) bool {
+		return r.hasAccounts() && r.Folders != nil && r.Groups != nil && r.FolderACLs != nil
+	}, testFolderACLs},
+	{"Attachments", func(r *Repositories) bool { return r.Attachments != nil }, testAttachments},
+	{"Quotas", func(r *Repositories) bool { return r.Quotas != nil }, testQuotas},
+	{"Policies", func(r *Repositories) bool { return r.Policies != nil }, testPolicies},
+	{"Spam", func(r *Repositories) bool { return r.Spam != nil }, testSpam},
+	{"Quarantine", func(r *Repositories) bool { return r.Quarantine != nil }, testQuarantine},
+	{"RateCounters", func(r *Repositories) bool { return r.RateCounters != nil }, testRateCounters},
+	{"Suspensions", func(r *Repositories) bool { return r.Suspensions != nil }, testSuspensions},
+	{"DestinationPolicies", func(r *Repositories) bool { return r.DestinationPolicies != nil }, testDestinationPolicies},
+	{"DeliveryJobs", func(r *Repositories) bool { return r.DeliveryJobs != nil }, testDeliveryJobs},
+	{"IPPools", func(r *Repositories) bool { return r.IPPools != nil }, testIPPools},
+	{"PoolAssignments", func(r *Repositories) bool {
+		return r.IPPools != nil && r.PoolAssignments != nil
+	}, testPoolAssignments},
+	{"MTASTSPolicies", func(r *Repositories) bool { return r.MTASTSPolicies != nil }, testMTASTSPolicies},
+	{"TLSResults", func(r *Repositories) bool { return r.TLSResults != nil }, testTLSResults},
+	{"DKIMKeys", func(r *Repositories) bool { return r.DKIMKeys != nil }, testDKIMKeys},
+	{"DKIMRotationLog", func(r *Repositories) bool { return r.DKIMRotationLog != nil }, testDKIMRotationLog},
+	{"ACLAuditLog", func(r *Repositories) bool { return r.ACLAuditLog != nil }, testACLAuditLog},
+	{"Blobs", func(r *Repositories) bool { return r.Blobs != nil }, testBlobs},
+	{"Events", func(r *Repositories) bool { return r.Events != nil }, testEvents},
+	{"Outbox", func(r *Repositories) bool { return r.Outbox != nil }, testOutbox},
+}
+
+// Run runs every suite whose repositories the factory provides
+func Run(t *testing.T, factory Factory) {
+	t.Helper()
+	for _, s := range suites {
+		t.Run(s.name, func(t *testing.T) {
+			repos := factory(t)
+			if !s.needs(repos) {
+				t.Skip("repository not provided")
+			}
+			s.run(t, repos)
+		})
+	}
+}
+
+func (r *Repositories) hasAccounts() bool {
+	return r.Users != nil && r.Domains != nil && r.EmailAccounts != nil
+}
+
+// newID returns a random UUID, the identifier format every backend accepts
+func newID() string {
+	return uuid.NewString()
+}
+
+// now returns the current time at the microsecond precision of Postgres
+func now() time.Time {
+	return time.Now().UTC().Truncate(time.Microsecond)
+}
+
+func ptr[T any](v T) *T {
+	return &v
+}
+
+func must(t *testing.T, err error) {
+	t.Helper()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func sameTime(a, b time.Time) bool {
+	return a.Equal(b)
+}
+
+func sameTimePtr(a, b *time.Time) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return a.Equal(*b)
+}
+
+func sameStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// ids returns the IDs of items in order
+func ids[T any](items []T, id func(T) string) []string {
+	out := make([]string, 0, len(items))
+	for _, item := range items {
+		out = append(out, id(item))
+	}
+	return out
+}
+
+func expectIDs(t *testing.T, what string, got, want []string) {
+	t.Helper()
+	if !sameStrings(got, want) {
+		t.Fatalf("%s: got %v, want %v", what, got, want)
+	}
+}
+
+func expectCount(t *testing.T, what string, got, want int) {
+	t.Helper()
+	if got != want {
+		t.Fatalf("%s: got %d, want %d", what, got, want)
+	}
+}
+
+// newUser creates a user with a unique username and email
+func newUser(t *testing.T, r *Repositories, createdAt time.Time) *domain.User {
+	t.Helper()
+	id := newID()
+	user := &domain.User{
+		ID:                id,
+		Username:          "user-" + id[:8],
+		Email:             "user-" + id[:8] + "@example.com",
+		PasswordHash:      "hash",
+		Role:              domain.UserRoleUser,
+		IsActive:          true,
+		CreatedAt:         createdAt,
+		UpdatedAt:         createdAt,
+		PasswordChangedAt: createdAt,
+		Timezone:          "UTC",
+		Locale:            "en",
+		Theme:             "light",
+	}
+	must(t, r.Users.Create(context.Background(), user))
+	return user
+}
+
+// newDomain creates a domain owned by a new user
+func newDomain(t *testing.T, r *Repositories, name string) *domain.Domain {
+	t.Helper()
+	owner := newUser(t, r, now())
+	d := &domain.Domain{
+		ID:        newID(),
+		Name:      name,
+		IsActive:  true,
+		MaxUsers:  10,
+		CreatedAt: now(),
+		UpdatedAt: now(),
+		OwnerID:   owner.ID,
+	}
+	must(t, r.Domains.Create(context.Background(), d))
+	return d
+}
+
+// newAccount creates an account for a new user in the given domain
+func newAccount(t *testing.T, r *Repositories, d *domain.Domain, local string) *domain.EmailAccount {
+	t.Helper()
+	user := newUser(t, r, now())
+	account := &domain.EmailAccount{
+		ID:           newID(),
+		UserID:       user.ID,
+		DomainID:     d.ID,
+		Email:        local + "@" + d.Name,
+		PasswordHash: "hash",
+		IsActive:     true,
+		QuotaMB:      100,
+		CreatedAt:    now(),
+		UpdatedAt:    now(),
+	}
+	must(t, r.EmailAccounts.Create(context.Background(), account))
+	return account
+}
@@ -0,0 +1,226 @@
+package repotest
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/skygenesisenterprise/aether-mailer/package/golang/domain"
+	"github.com/skygenesisenterprise/aether-mailer/package/golang/repository"
+)
+
+func newScheduledSend(accountID string, sendAt, createdAt time.Time) *domain.ScheduledSend {
+	return &domain.ScheduledSend{
+		ID:            newID(),
+		AccountID:     accountID,
+		DraftID:       newID(),
+		Kind:          domain.ScheduledSendKindScheduled,
+		Status:        domain.ScheduledSendStatusPending,
+		SendAt:        sendAt,
+		NextAttemptAt: sendAt,
+		CreatedAt:     createdAt,
+		UpdatedAt:     createdAt,
+	}
+}
+
+func testScheduledSends(t *testing.T, r *Repositories) {
+	ctx := context.Background()
+	d := newDomain(t, r, "scheduled.example")
+	account := newAccount(t, r, d, "erin")
+	other := newAccount(t, r, d, "frank")
+	start := now()
+	lease := time.Minute
+
+	first := newScheduledSend(account.ID, start, start)
+	second := newScheduledSend(account.ID, start, start.Add(time.Second))
+	undo := newScheduledSend(account.ID, start.Add(10*time.Second), start)
+	undo.Kind = domain.ScheduledSendKindUndo
+	later := newScheduledSend(other.ID, start.Add(time.Hour), start)
+	for _, send := range []*domain.ScheduledSend{first, second, undo, later} {
+		must(t, r.ScheduledSends.Create(ctx, send))
+	}
+	if err := r.ScheduledSends.Create(ctx, first); err == nil {
+		t.Fatal("Create with a duplicate ID succeeded")
+	}
+	if err := r.ScheduledSends.Create(ctx, newScheduledSend(newID(), start, start)); err == nil {
+		t.Fatal("Create for a missing account succeeded")
+	}
+	again := newScheduledSend(account.ID, start, start)
+	again.DraftID = first.DraftID
+	if err := r.ScheduledSends.Create(ctx, again); err == nil {
+		t.Fatal("Create of a second pending send of a draft succeeded")
+	}
+
+	got, err := r.ScheduledSends.GetByID(ctx, undo.ID)
+	must(t, err)
+	if got == nil || got.AccountID != account.ID || got.DraftID != undo.DraftID || got.Kind != domain.ScheduledSendKindUndo ||
+		got.Status != domain.ScheduledSendStatusPending || !sameTime(got.SendAt, undo.SendAt) ||
+		!sameTime(got.NextAttemptAt, undo.NextAttemptAt) || got.LockedUntil != nil || got.Attempts != 0 ||
+		got.SentMessageID != nil || got.CompletedAt != nil || !sameTime(got.CreatedAt, undo.CreatedAt) {
+		t.Fatalf("GetByID: got %+v, want %+v", got, undo)
+	}
+	got, err = r.ScheduledSends.GetByID(ctx, newID())
+	must(t, err)
+	if got != nil {
+		t.Fatalf("GetByID of a missing send: got %+v", got)
+	}
+	got, err = r.ScheduledSends.GetPendingByDraftID(ctx, second.DraftID)
+	must(t, err)
+	if got == nil || got.ID != second.ID {
+		t.Fatalf("GetPendingByDraftID: got %+v", got)
+	}
+
+	id := func(send *domain.ScheduledSend) string { return send.ID }
+	pending := domain.ScheduledSendStatusPending
+	list := func(name string, filter repository.ScheduledSendFilter, total int, want ...string) {
+		t.Helper()
+		sends, err := r.ScheduledSends.List(ctx, filter)
+		must(t, err)
+		expectIDs(t, name, ids(sends, id), want)
+		count, err := r.ScheduledSends.Count(ctx, filter)
+		must(t, err)
+		expectCount(t, name+" count", count, total)
+	}
+	list("List of an account", repository.ScheduledSendFilter{AccountID: account.ID}, 3, first.ID, second.ID, undo.ID)
+	list("List page", repository.ScheduledSendFilter{AccountID: account.ID, Limit: 1, Offset: 1}, 3, second.ID)
+	list("List of pending sends", repository.ScheduledSendFilter{Status: &pending}, 4, first.ID, second.ID, undo.ID, later.ID)
+
+	claimed, err := r.ScheduledSends.Claim(ctx, start, 10, lease)
+	must(t, err)
+	expectIDs(t, "Claim", ids(claimed, id), []string{first.ID, second.ID})
+	if claimed[0].Attempts != 1 || !sameTimePtr(claimed[0].LockedUntil, ptr(start.Add(lease))) {
+		t.Fatalf("Claim: got %+v", claimed[0])
+	}
+	staleClaim, secondClaim := claimed[0], claimed[1]
+	claimed, err = r.ScheduledSends.Claim(ctx, start.Add(time.Second), 10, lease)
+	must(t, err)
+	expectCount(t, "Claim of leased sends", len(claimed), 0)
+
+	// A leased send can be neither locked nor cancelled
+	locked, err := r.ScheduledSends.Lock(ctx, first.ID, start.Add(time.Second), lease)
+	must(t, err)
+	if locked {
+		t.Fatal("Lock of a claimed send succeeded")
+	}
+	cancelled, err := r.ScheduledSends.Cancel(ctx, first.ID, start.Add(time.Second))
+	must(t, err)
+	if cancelled {
+		t.Fatal("Cancel of a claimed send succeeded")
+	}
+
+	// An expired lease makes the send due again
+	claimed, err = r.ScheduledSends.Claim(ctx, start.Add(2*lease), 1, lease)
+	must(t, err)
+	expectIDs(t, "Claim after lease expiry", ids(claimed, id), []string{first.ID})
+	expectCount(t, "attempts", claimed[0].Attempts, 2)
+	firstClaim := claimed[0]
+
+	// The dispatcher whose lease expired can no longer finish the send
+	changed, err := r.ScheduledSends.Complete(ctx, staleClaim, domain.ScheduledSendStatusSent, ptr(newID()), "", start.Add(2*lease))
+	must(t, err)
+	if changed {
+		t.Fatal("Complete with an expired claim succeeded")
+	}
+	changed, err = r.ScheduledSends.MarkFailed(ctx, staleClaim, "stale", start.Add(3*lease))
+	must(t, err)
+	if changed {
+		t.Fatal("MarkFailed with an expired claim succeeded")
+	}
+
+	// A failed attempt releases the lease until the retry is due
+	retryAt := start.Add(3 * lease)
+	changed, err = r.ScheduledSends.MarkFailed(ctx, secondClaim, "temporary failure", retryAt)
+	must(t, err)
+	if !changed {
+		t.Fatal("MarkFailed of a claimed send changed nothing")
+	}
+	got, err = r.ScheduledSends.GetByID(ctx, second.ID)
+	must(t, err)
+	if got.LastError != "temporary failure" || got.LockedUntil != nil || !sameTime(got.NextAttemptAt, retryAt) ||
+		!sameTime(got.SendAt, second.SendAt) || got.Status != domain.ScheduledSendStatusPending {
+		t.Fatalf("MarkFailed: got %+v", got)
+	}
+
+	sentAt := start.Add(2 * lease)
+	sentID := newID()
+	changed, err = r.ScheduledSends.Complete(ctx, firstClaim, domain.ScheduledSendStatusSent, &sentID, "", sentAt)
+	must(t, err)
+	if !changed {
+		t.Fatal("Complete of a claimed send changed nothing")
+	}
+	got, err = r.ScheduledSends.GetByID(ctx, first.ID)
+	must(t, err)
+	if got.Status != domain.ScheduledSendStatusSent || got.SentMessageID == nil || *got.SentMessageID != sentID ||
+		got.LockedUntil != nil || !sameTimePtr(got.CompletedAt, &sentAt) || !sameTime(got.UpdatedAt, sentAt) {
+		t.Fatalf("Complete: got %+v", got)
+	}
+	changed, err = r.ScheduledSends.Complete(ctx, firstClaim, domain.ScheduledSendStatusFailed, nil, "late", sentAt.Add(time.Second))
+	must(t, err)
+	got, err = r.ScheduledSends.GetByID(ctx, first.ID)
+	must(t, err)
+	if changed || got.Status != domain.ScheduledSendStatusSent {
+		t.Fatalf("Complete of a completed send: got %+v", got)
+	}
+	got, err = r.ScheduledSends.GetPendingByDraftID(ctx, first.DraftID)
+	must(t, err)
+	if got != nil {
+		t.Fatalf("GetPendingByDraftID of a sent draft: got %+v", got)
+	}
+	// The draft may be scheduled again once its send completed
+	resend := newScheduledSend(account.ID, start.Add(time.Hour), start)
+	resend.DraftID = first.DraftID
+	must(t, r.ScheduledSends.Create(ctx, resend))
+
+	// An edit leases the send, then reschedules it and releases the lease
+	locked, err = r.ScheduledSends.Lock(ctx, undo.ID, start, lease)
+	must(t, err)
+	if !locked {
+		t.Fatal("Lock of a pending send failed")
+	}
+	claimed, err = r.ScheduledSends.Claim(ctx, start.Add(20*time.Second), 10, lease)
+	must(t, err)
+	expectCount(t, "Claim of a locked send", len(claimed), 0)
+	sendAt := start.Add(5 * lease)
+	must(t, r.ScheduledSends.Reschedule(ctx, undo.ID, sendAt, start.Add(time.Second)))
+	got, err = r.ScheduledSends.GetByID(ctx, undo.ID)
+	must(t, err)
+	if got.LockedUntil != nil || !sameTime(got.SendAt, sendAt) || !sameTime(got.NextAttemptAt, sendAt) ||
+		!sameTime(got.UpdatedAt, start.Add(time.Second)) {
+		t.Fatalf("Reschedule: got %+v", got)
+	}
+
+	cancelAt := start.Add(time.Minute)
+	cancelled, err = r.ScheduledSends.Cancel(ctx, undo.ID, cancelAt)
+	must(t, err)
+	if !cancelled {
+		t.Fatal("Cancel of a pending send failed")
+	}
+	got, err = r.ScheduledSends.GetByID(ctx, undo.ID)
+	must(t, err)
+	if got.Status != domain.ScheduledSendStatusCancelled || !sameTimePtr(got.CompletedAt, &cancelAt) {
+		t.Fatalf("Cancel: got %+v", got)
+	}
+	cancelled, err = r.ScheduledSends.Cancel(ctx, undo.ID, cancelAt)
+	must(t, err)
+	if cancelled {
+		t.Fatal("Cancel of a cancelled send succeeded")
+	}
+	list("List of pending sends after completion", repository.ScheduledSendFilter{AccountID: account.ID, Status: &pending},
+		2, second.ID, resend.ID)
+
+	deleted, err := r.ScheduledSends.DeleteCompleted(ctx, cancelAt)
+	must(t, err)
+	expectCount(t, "DeleteCompleted before the cancellation", deleted, 0)
+	deleted, err = r.ScheduledSends.DeleteCompleted(ctx, sentAt.Add(time.Second))
+	must(t, err)
+	expectCount(t, "DeleteCompleted", deleted, 2)
+	list("List after DeleteCompleted", repository.ScheduledSendFilter{AccountID: account.ID}, 2, second.ID, resend.ID)
+
+	// Deleting the account deletes its sends
+	must(t, r.EmailAccounts.Delete(ctx, other.ID))
+	got, err = r.ScheduledSends.GetByID(ctx, later.ID)
+	must(t, err)
+	if got != nil {
+		t.Fatalf("GetByID after account deletion: got %+v", got)
+	}
+}
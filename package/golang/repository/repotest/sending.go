@@ -0,0 +1,352 @@
+package repotest
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/skygenesisenterprise/aether-mailer/package/golang/domain"
+)
+
+func testRateCounters(t *testing.T, r *Repositories) {
+	ctx := context.Background()
+	key := "account:" + newID()
+	window := time.Hour
+	// Align to a bucket so the arithmetic below does not depend on the clock
+	start := now().Truncate(time.Hour)
+
+	total, err := r.RateCounters.Add(ctx, key, 3, start, window)
+	must(t, err)
+	if total != 3 {
+		t.Fatalf("Add: got %d, want 3", total)
+	}
+	total, err = r.RateCounters.Add(ctx, key, 2, start.Add(10*time.Minute), window)
+	must(t, err)
+	if total != 5 {
+		t.Fatalf("Add in the window: got %d, want 5", total)
+	}
+	total, err = r.RateCounters.Add(ctx, key, 4, start.Add(65*time.Minute), window)
+	must(t, err)
+	if total != 6 {
+		t.Fatalf("Add after the first bucket left the window: got %d, want 6", total)
+	}
+	_, err = r.RateCounters.Add(ctx, "account:"+newID(), 100, start, window)
+	must(t, err)
+
+	sum, err := r.RateCounters.Sum(ctx, key, start.Add(30*time.Minute), window)
+	must(t, err)
+	if sum != 5 {
+		t.Fatalf("Sum: got %d, want 5 (later buckets must not count)", sum)
+	}
+	sum, err = r.RateCounters.Sum(ctx, key, start.Add(3*time.Hour), window)
+	must(t, err)
+	if sum != 0 {
+		t.Fatalf("Sum after the window: got %d, want 0", sum)
+	}
+
+	must(t, r.RateCounters.Prune(ctx, start.Add(5*time.Minute)))
+	sum, err = r.RateCounters.Sum(ctx, key, start.Add(30*time.Minute), window)
+	must(t, err)
+	if sum != 2 {
+		t.Fatalf("Sum after Prune: got %d, want 2", sum)
+	}
+
+	// Concurrent senders must never lose a count
+	const workers = 25
+	concurrentKey := "ip:" + newID()
+	var wg sync.WaitGroup
+	errs := make(chan error, workers)
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := r.RateCounters.Add(ctx, concurrentKey, 1, start, window)
+			errs <- err
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		must(t, err)
+	}
+	sum, err = r.RateCounters.Sum(ctx, concurrentKey, start, window)
+	must(t, err)
+	if sum != workers {
+		t.Fatalf("concurrent Add: got %d, want %d", sum, workers)
+	}
+}
+
+func testSuspensions(t *testing.T, r *Repositories) {
+	ctx := context.Background()
+	at := now()
+	key := newID()
+
+	newSuspension := func(scope domain.RateScope, key string, suspendedAt time.Time, expiresAt *time.Time) *domain.SendingSuspension {
+		suspension := &domain.SendingSuspension{
+			ID:          newID(),
+			Scope:       scope,
+			Key:         key,
+			Reason:      "bounce rate above 10%",
+			SuspendedAt: suspendedAt,
+			ExpiresAt:   expiresAt,
+		}
+		must(t, r.Suspensions.Create(ctx, suspension))
+		return suspension
+	}
+	expired := newSuspension(domain.RateScopeAccount, key, at.Add(-2*time.Hour), ptr(at.Add(-time.Hour)))
+	older := newSuspension(domain.RateScopeAccount, key, at.Add(-time.Hour), nil)
+	newer := newSuspension(domain.RateScopeAccount, key, at.Add(-time.Minute), ptr(at.Add(time.Hour)))
+	ip := newSuspension(domain.RateScopeIP, "192.0.2.1", at.Add(-30*time.Minute), nil)
+
+	got, err := r.Suspensions.GetByID(ctx, expired.ID)
+	must(t, err)
+	if got == nil || got.Key != key || !sameTime(got.SuspendedAt, expired.SuspendedAt) || !sameTimePtr(got.ExpiresAt, expired.ExpiresAt) {
+		t.Fatalf("GetByID: got %+v", got)
+	}
+
+	active, err := r.Suspensions.GetActive(ctx, domain.RateScopeAccount, key, at)
+	must(t, err)
+	if active == nil || active.ID != newer.ID {
+		t.Fatalf("GetActive: got %+v, want the newest suspension", active)
+	}
+	none, err := r.Suspensions.GetActive(ctx, domain.RateScopeDomain, key, at)
+	must(t, err)
+	if none != nil {
+		t.Fatal("GetActive matched another scope")
+	}
+
+	id := func(s *domain.SendingSuspension) string { return s.ID }
+	all, err := r.Suspensions.ListActive(ctx, at)
+	must(t, err)
+	expectIDs(t, "ListActive", ids(all, id), []string{newer.ID, ip.ID, older.ID})
+
+	newer.LiftedAt = ptr(at)
+	newer.LiftedBy = ptr("admin")
+	must(t, r.Suspensions.Update(ctx, newer))
+	got, err = r.Suspensions.GetByID(ctx, newer.ID)
+	must(t, err)
+	if !sameTimePtr(got.LiftedAt, newer.LiftedAt) || got.LiftedBy == nil || *got.LiftedBy != "admin" {
+		t.Fatalf("Update was not saved: %+v", got)
+	}
+	active, err = r.Suspensions.GetActive(ctx, domain.RateScopeAccount, key, at)
+	must(t, err)
+	if active == nil || active.ID != older.ID {
+		t.Fatalf("GetActive after lifting: got %+v, want the older suspension", active)
+	}
+
+	all, err = r.Suspensions.ListActive(ctx, at.Add(2*time.Hour))
+	must(t, err)
+	expectIDs(t, "ListActive later", ids(all, id), []string{ip.ID, older.ID})
+}
+
+func testDestinationPolicies(t *testing.T, r *Repositories) {
+	ctx := context.Background()
+
+	gmail := &domain.DestinationPolicy{
+		ID:                       newID(),
+		Name:                     "gmail",
+		MXPattern:                "*.google.com",
+		MaxConnections:           10,
+		MaxMessagesPerConnection: 100,
+		MaxMessagesPerMinute:     600,
+		Backoff:                  1500 * time.Millisecond,
+		MaxBackoff:               time.Hour,
+		IsActive:                 true,
+		CreatedAt:                now(),
+		UpdatedAt:                now(),
+	}
+	outlook := &domain.DestinationPolicy{
+		ID:             newID(),
+		Name:           "microsoft",
+		MXPattern:      "*.outlook.com",
+		MaxConnections: 5,
+		Backoff:        time.Minute,
+		MaxBackoff:     time.Hour,
+		CreatedAt:      now(),
+		UpdatedAt:      now(),
+	}
+	must(t, r.DestinationPolicies.Create(ctx, outlook))
+	must(t, r.DestinationPolicies.Create(ctx, gmail))
+
+	duplicate := *gmail
+	duplicate.ID = newID()
+	duplicate.Name = "gmail again"
+	if err := r.DestinationPolicies.Create(ctx, &duplicate); err == nil {
+		t.Fatal("Create accepted a duplicate MX pattern")
+	}
+
+	got, err := r.DestinationPolicies.GetByID(ctx, gmail.ID)
+	must(t, err)
+	if got == nil || got.MXPattern != "*.google.com" || got.Backoff != 1500*time.Millisecond ||
+		got.MaxBackoff != time.Hour || got.MaxMessagesPerMinute != 600 || !got.IsActive {
+		t.Fatalf("GetByID: got %+v", got)
+	}
+
+	outlook.MaxConnections = 2
+	outlook.IsActive = true
+	outlook.Backoff = 2 * time.Minute
+	must(t, r.DestinationPolicies.Update(ctx, outlook))
+	got, err = r.DestinationPolicies.GetByID(ctx, outlook.ID)
+	must(t, err)
+	if got.MaxConnections != 2 || !got.IsActive || got.Backoff != 2*time.Minute {
+		t.Fatalf("Update was not saved: %+v", got)
+	}
+
+	id := func(p *domain.DestinationPolicy) string { return p.ID }
+	all, err := r.DestinationPolicies.List(ctx)
+	must(t, err)
+	expectIDs(t, "List", ids(all, id), []string{gmail.ID, outlook.ID})
+
+	must(t, r.DestinationPolicies.Delete(ctx, gmail.ID))
+	got, err = r.DestinationPolicies.GetByID(ctx, gmail.ID)
+	must(t, err)
+	if got != nil {
+		t.Fatal("Delete left the policy behind")
+	}
+}
+
+func testIPPools(t *testing.T, r *Repositories) {
+	ctx := context.Background()
+	warmup := now().Add(-24 * time.Hour)
+
+	transactional := &domain.IPPool{
+		ID:          newID(),
+		Name:        "transactional",
+		Description: "Receipts and password resets",
+		Addresses: []domain.PoolAddress{
+			{IP: "192.0.2.10", HeloName: "mta10.example.com", IsActive: true},
+			{IP: "192.0.2.2", HeloName: "mta2.example.com", IsActive: true, WarmupStartedAt: &warmup},
+		},
+		IsDefault: true,
+		CreatedAt: now(),
+		UpdatedAt: now(),
+	}
+	bulk := &domain.IPPool{ID: newID(), Name: "bulk", Addresses: []domain.PoolAddress{}, CreatedAt: now(), UpdatedAt: now()}
+	must(t, r.IPPools.Create(ctx, transactional))
+	must(t, r.IPPools.Create(ctx, bulk))
+
+	got, err := r.IPPools.GetByName(ctx, "transactional")
+	must(t, err)
+	if got == nil || got.ID != transactional.ID || len(got.Addresses) != 2 ||
+		got.Addresses[0].IP != "192.0.2.10" || got.Addresses[1].HeloName != "mta2.example.com" ||
+		!sameTimePtr(got.Addresses[1].WarmupStartedAt, &warmup) {
+		t.Fatalf("GetByName: got %+v", got)
+	}
+	def, err := r.IPPools.GetDefault(ctx)
+	must(t, err)
+	if def == nil || def.ID != transactional.ID {
+		t.Fatalf("GetDefault: got %+v", def)
+	}
+
+	conflicts := []*domain.IPPool{
+		{ID: newID(), Name: "bulk", CreatedAt: now(), UpdatedAt: now()},
+		{ID: newID(), Name: "second default", IsDefault: true, CreatedAt: now(), UpdatedAt: now()},
+		{ID: newID(), Name: "shared address", Addresses: []domain.PoolAddress{{IP: "192.0.2.2", HeloName: "x"}}, CreatedAt: now(), UpdatedAt: now()},
+	}
+	for _, ipPool := range conflicts {
+		if err := r.IPPools.Create(ctx, ipPool); err == nil {
+			t.Fatalf("Create accepted conflicting pool %q", ipPool.Name)
+		}
+	}
+
+	bulk.Description = "Newsletters"
+	bulk.Addresses = []domain.PoolAddress{{IP: "198.51.100.7", HeloName: "bulk.example.com", IsActive: true}}
+	must(t, r.IPPools.Update(ctx, bulk))
+	got, err = r.IPPools.GetByID(ctx, bulk.ID)
+	must(t, err)
+	if got.Description != "Newsletters" || len(got.Addresses) != 1 || got.Addresses[0].IP != "198.51.100.7" {
+		t.Fatalf("Update was not saved: %+v", got)
+	}
+
+	transactional.IsDefault = false
+	transactional.Addresses = transactional.Addresses[:1]
+	must(t, r.IPPools.Update(ctx, transactional))
+	def, err = r.IPPools.GetDefault(ctx)
+	must(t, err)
+	if def != nil {
+		t.Fatalf("GetDefault after clearing the default: got %+v", def)
+	}
+	got, err = r.IPPools.GetByID(ctx, transactional.ID)
+	must(t, err)
+	if len(got.Addresses) != 1 {
+		t.Fatalf("Update did not replace the addresses: %+v", got.Addresses)
+	}
+
+	id := func(p *domain.IPPool) string { return p.ID }
+	all, err := r.IPPools.List(ctx)
+	must(t, err)
+	expectIDs(t, "List", ids(all, id), []string{bulk.ID, transactional.ID})
+
+	must(t, r.IPPools.Delete(ctx, bulk.ID))
+	missing, err := r.IPPools.GetByID(ctx, bulk.ID)
+	must(t, err)
+	if missing != nil {
+		t.Fatal("Delete left the pool behind")
+	}
+	reuse := &domain.IPPool{ID: newID(), Name: "reuse", Addresses: []domain.PoolAddress{{IP: "198.51.100.7", HeloName: "x"}}, CreatedAt: now(), UpdatedAt: now()}
+	must(t, r.IPPools.Create(ctx, reuse))
+}
+
+func testPoolAssignments(t *testing.T, r *Repositories) {
+	ctx := context.Background()
+	newPool := func(name string) *domain.IPPool {
+		ipPool := &domain.IPPool{ID: newID(), Name: name, Addresses: []domain.PoolAddress{}, CreatedAt: now(), UpdatedAt: now()}
+		must(t, r.IPPools.Create(ctx, ipPool))
+		return ipPool
+	}
+	bulk := newPool("bulk")
+	transactional := newPool("transactional")
+
+	assign := func(scope domain.PoolScope, key, poolID string) {
+		must(t, r.PoolAssignments.Upsert(ctx, &domain.PoolAssignment{Scope: scope, Key: key, PoolID: poolID, CreatedAt: now()}))
+	}
+	assign(domain.PoolScopeTenant, "tenant-b", bulk.ID)
+	assign(domain.PoolScopeDomain, "news.example", bulk.ID)
+	assign(domain.PoolScopeTenant, "tenant-a", bulk.ID)
+	assign(domain.PoolScopeStream, "receipts", transactional.ID)
+
+	if err := r.PoolAssignments.Upsert(ctx, &domain.PoolAssignment{Scope: domain.PoolScopeTenant, Key: "x", PoolID: newID(), CreatedAt: now()}); err == nil {
+		t.Fatal("Upsert accepted a missing pool")
+	}
+
+	got, err := r.PoolAssignments.Get(ctx, domain.PoolScopeDomain, "news.example")
+	must(t, err)
+	if got == nil || got.PoolID != bulk.ID {
+		t.Fatalf("Get: got %+v", got)
+	}
+	missing, err := r.PoolAssignments.Get(ctx, domain.PoolScopeStream, "news.example")
+	must(t, err)
+	if missing != nil {
+		t.Fatal("Get matched another scope")
+	}
+
+	assign(domain.PoolScopeDomain, "news.example", transactional.ID)
+	got, err = r.PoolAssignments.Get(ctx, domain.PoolScopeDomain, "news.example")
+	must(t, err)
+	if got.PoolID != transactional.ID {
+		t.Fatalf("Upsert did not replace the pool: %+v", got)
+	}
+
+	key := func(a *domain.PoolAssignment) string { return string(a.Scope) + "/" + a.Key }
+	assignments, err := r.PoolAssignments.ListByPool(ctx, bulk.ID)
+	must(t, err)
+	expectIDs(t, "ListByPool", ids(assignments, key), []string{"TENANT/tenant-a", "TENANT/tenant-b"})
+
+	must(t, r.PoolAssignments.Delete(ctx, domain.PoolScopeTenant, "tenant-a"))
+	assignments, err = r.PoolAssignments.ListByPool(ctx, bulk.ID)
+	must(t, err)
+	expectIDs(t, "ListByPool after Delete", ids(assignments, key), []string{"TENANT/tenant-b"})
+
+	must(t, r.PoolAssignments.DeleteByPool(ctx, bulk.ID))
+	assignments, err = r.PoolAssignments.ListByPool(ctx, bulk.ID)
+	must(t, err)
+	expectIDs(t, "ListByPool after DeleteByPool", ids(assignments, key), []string{})
+
+	must(t, r.IPPools.Delete(ctx, transactional.ID))
+	got, err = r.PoolAssignments.Get(ctx, domain.PoolScopeStream, "receipts")
+	must(t, err)
+	if got != nil {
+		t.Fatal("deleting the pool left its assignments behind")
+	}
+}
@@ -0,0 +1,162 @@
+package repotest
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/skygenesisenterprise/aether-mailer/package/golang/domain"
+	"github.com/skygenesisenterprise/aether-mailer/package/golang/repository"
+)
+
+func newThread(accountID, subject string, lastMessageAt time.Time) *domain.Thread {
+	return &domain.Thread{
+		ID:            newID(),
+		AccountID:     accountID,
+		Subject:       subject,
+		BaseSubject:   domain.BaseSubject(subject),
+		MessageCount:  1,
+		Participants:  []string{"alice@example.com"},
+		LastMessageAt: lastMessageAt,
+		CreatedAt:     lastMessageAt,
+		UpdatedAt:     lastMessageAt,
+	}
+}
+
+func testThreads(t *testing.T, r *Repositories) {
+	ctx := context.Background()
+	d := newDomain(t, r, "threads.example")
+	account := newAccount(t, r, d, "carol")
+	other := newAccount(t, r, d, "dave")
+	base := now().Add(-time.Hour)
+
+	planning := newThread(account.ID, "Planning", base)
+	budget := newThread(account.ID, "Re: Budget", base.Add(time.Minute))
+	budget.UnreadCount = 2
+	elsewhere := newThread(other.ID, "Planning", base.Add(2*time.Minute))
+	for _, thread := range []*domain.Thread{planning, budget, elsewhere} {
+		must(t, r.Threads.Create(ctx, thread))
+	}
+	if err := r.Threads.Create(ctx, planning); err == nil {
+		t.Fatal("Create with a duplicate ID succeeded")
+	}
+	if err := r.Threads.Create(ctx, newThread(newID(), "Orphan", base)); err == nil {
+		t.Fatal("Create for a missing account succeeded")
+	}
+
+	got, err := r.Threads.GetByID(ctx, budget.ID)
+	must(t, err)
+	if got == nil || got.Subject != "Re: Budget" || got.BaseSubject != "budget" || got.UnreadCount != 2 ||
+		!sameStrings(got.Participants, budget.Participants) || !sameTime(got.LastMessageAt, budget.LastMessageAt) {
+		t.Fatalf("GetByID: got %+v, want %+v", got, budget)
+	}
+	got, err = r.Threads.GetByID(ctx, newID())
+	must(t, err)
+	if got != nil {
+		t.Fatalf("GetByID of a missing thread: got %+v", got)
+	}
+
+	id := func(thread *domain.Thread) string { return thread.ID }
+	list := func(name string, filter repository.ThreadFilter, want ...string) {
+		t.Helper()
+		threads, err := r.Threads.ListByAccount(ctx, account.ID, filter)
+		must(t, err)
+		expectIDs(t, name, ids(threads, id), want)
+		if filter.Limit == 0 {
+			count, err := r.Threads.CountByAccount(ctx, account.ID, filter)
+			must(t, err)
+			expectCount(t, name+" count", count, len(want))
+		}
+	}
+	list("ListByAccount", repository.ThreadFilter{}, budget.ID, planning.ID)
+	list("ListByAccount unread", repository.ThreadFilter{IsUnread: ptr(true)}, budget.ID)
+	list("ListByAccount read", repository.ThreadFilter{IsUnread: ptr(false)}, planning.ID)
+	list("ListByAccount page", repository.ThreadFilter{Limit: 1, Offset: 1}, planning.ID)
+
+	// Threads are found by the Message-IDs that lead to them
+	must(t, r.Threads.LinkMessageIDs(ctx, account.ID, planning.ID, []string{"a@example.com", "b@example.com", "a@example.com"}))
+	must(t, r.Threads.LinkMessageIDs(ctx, account.ID, budget.ID, []string{"c@example.com"}))
+	must(t, r.Threads.LinkMessageIDs(ctx, other.ID, elsewhere.ID, []string{"a@example.com"}))
+	found, err := r.Threads.FindByMessageIDs(ctx, account.ID, []string{"c@example.com", "b@example.com", "a@example.com", "x@example.com"})
+	must(t, err)
+	expectIDs(t, "FindByMessageIDs", ids(found, id), []string{planning.ID, budget.ID})
+	found, err = r.Threads.FindByMessageIDs(ctx, account.ID, nil)
+	must(t, err)
+	expectIDs(t, "FindByMessageIDs without IDs", ids(found, id), []string{})
+
+	// Linking again moves a Message-ID to the new thread
+	must(t, r.Threads.LinkMessageIDs(ctx, account.ID, budget.ID, []string{"b@example.com"}))
+	found, err = r.Threads.FindByMessageIDs(ctx, account.ID, []string{"b@example.com"})
+	must(t, err)
+	expectIDs(t, "FindByMessageIDs after relinking", ids(found, id), []string{budget.ID})
+
+	bySubject, err := r.Threads.FindBySubject(ctx, account.ID, "planning", base)
+	must(t, err)
+	if bySubject == nil || bySubject.ID != planning.ID {
+		t.Fatalf("FindBySubject: got %+v, want %s", bySubject, planning.ID)
+	}
+	bySubject, err = r.Threads.FindBySubject(ctx, account.ID, "planning", base.Add(time.Second))
+	must(t, err)
+	if bySubject != nil {
+		t.Fatalf("FindBySubject before the window: got %+v", bySubject)
+	}
+
+	budget.MessageCount = 3
+	budget.UnreadCount = 0
+	budget.IsStarred = true
+	budget.HasAttachments = true
+	budget.Participants = []string{"bob@example.com", "Alice <alice@example.com>"}
+	budget.LastMessageAt = base.Add(3 * time.Minute)
+	budget.UpdatedAt = base.Add(3 * time.Minute)
+	must(t, r.Threads.Update(ctx, budget))
+	got, err = r.Threads.GetByID(ctx, budget.ID)
+	must(t, err)
+	if got.MessageCount != 3 || got.UnreadCount != 0 || !got.IsStarred || !got.HasAttachments ||
+		!sameStrings(got.Participants, budget.Participants) || !sameTime(got.LastMessageAt, budget.LastMessageAt) {
+		t.Fatalf("Update was not saved: %+v", got)
+	}
+	list("ListByAccount unread after update", repository.ThreadFilter{IsUnread: ptr(true)})
+
+	// Merging moves the Message-IDs and deletes the merged thread
+	must(t, r.Threads.Merge(ctx, planning.ID, budget.ID))
+	got, err = r.Threads.GetByID(ctx, planning.ID)
+	must(t, err)
+	if got != nil {
+		t.Fatalf("merged thread still exists: %+v", got)
+	}
+	found, err = r.Threads.FindByMessageIDs(ctx, account.ID, []string{"a@example.com"})
+	must(t, err)
+	expectIDs(t, "FindByMessageIDs after merging", ids(found, id), []string{budget.ID})
+	found, err = r.Threads.FindByMessageIDs(ctx, other.ID, []string{"a@example.com"})
+	must(t, err)
+	expectIDs(t, "FindByMessageIDs of another account", ids(found, id), []string{elsewhere.ID})
+	must(t, r.Threads.Merge(ctx, budget.ID, budget.ID))
+
+	must(t, r.Threads.Delete(ctx, budget.ID))
+	found, err = r.Threads.FindByMessageIDs(ctx, account.ID, []string{"a@example.com", "c@example.com"})
+	must(t, err)
+	expectIDs(t, "FindByMessageIDs after deleting", ids(found, id), []string{})
+	list("ListByAccount after deleting", repository.ThreadFilter{})
+
+	settings, err := r.Threads.GetSettings(ctx, account.ID)
+	must(t, err)
+	if settings != nil {
+		t.Fatalf("GetSettings of an account without settings: got %+v", settings)
+	}
+	for _, mode := range []domain.ThreadSubjectMode{domain.ThreadSubjectAlways, domain.ThreadSubjectOff} {
+		must(t, r.Threads.SaveSettings(ctx, &domain.ThreadSettings{AccountID: account.ID, SubjectMode: mode, UpdatedAt: now()}))
+		settings, err = r.Threads.GetSettings(ctx, account.ID)
+		must(t, err)
+		if settings == nil || settings.AccountID != account.ID || settings.SubjectMode != mode {
+			t.Fatalf("GetSettings: got %+v, want mode %s", settings, mode)
+		}
+	}
+
+	// Threads and settings go with their account
+	must(t, r.EmailAccounts.Delete(ctx, other.ID))
+	got, err = r.Threads.GetByID(ctx, elsewhere.ID)
+	must(t, err)
+	if got != nil {
+		t.Fatalf("thread of a deleted account still exists: %+v", got)
+	}
+}
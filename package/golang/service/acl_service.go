@@ -0,0 +1,926 @@
+package service
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/google/uuid"
+	"github.com/skygenesisenterprise/aether-mailer/package/golang/domain"
+	"github.com/skygenesisenterprise/aether-mailer/package/golang/errors"
+	"github.com/skygenesisenterprise/aether-mailer/package/golang/repository"
+)
+
+// Limits of groups
+const maxGroupNameSize = 64
+
+// ACL identifiers of IMAP (RFC 4314); users are named by address
+const (
+	aclAnyone      = "anyone"
+	aclGroupPrefix = "group:"
+)
+
+// sharedMailboxFolders are the system folders of a new shared mailbox
+var sharedMailboxFolders = []struct {
+	name       string
+	folderType domain.FolderType
+}{
+	{"INBOX", domain.FolderTypeInbox},
+	{"Sent", domain.FolderTypeSent},
+	{"Drafts", domain.FolderTypeDrafts},
+	{"Trash", domain.FolderTypeTrash},
+	{"Spam", domain.FolderTypeSpam},
+	{"Archive", domain.FolderTypeArchive},
+}
+
+// ACLService manages shared mailboxes, the groups of domains and the access
+// control lists of folders, following the IMAP ACL extension (RFC 4314).
+// The owner of an account holds every right on its folders. Other users
+// hold the rights granted to them, to their groups and to anyone, and
+// domain administrators hold every right on the shared mailboxes of their
+// domains. Every change is recorded in an audit log.
+//
+// ACLService is the FolderAccess of MailboxService, so REST, IMAP and JMAP
+// clients get the same rights: GETACL, SETACL, DELETEACL, MYRIGHTS and
+// LISTRIGHTS map to its methods, and Rights.Mailbox gives the JMAP
+// myRights of a folder.
+type ACLService struct {
+	aclRepo     repository.FolderACLRepository
+	groupRepo   repository.GroupRepository
+	auditRepo   repository.ACLAuditLogRepository
+	accountRepo repository.EmailAccountRepository
+	folderRepo  repository.FolderRepository
+	userRepo    repository.UserRepository
+	domainRepo  repository.DomainRepository
+	memberRepo  repository.DomainMemberRepository
+	transactor  repository.Transactor
+}
+
+// SharedMailboxRequest is a new shared mailbox, such as support@
+type SharedMailboxRequest struct {
+	Email       string
+	DisplayName string
+	QuotaMB     int
+}
+
+// GroupRequest is a new group of a domain
+type GroupRequest struct {
+	DomainID    string
+	Name        string
+	Description string
+}
+
+// ACLEntry is an entry of the ACL of a folder as IMAP shows it
+type ACLEntry struct {
+	Identifier  string // "anyone", "group:<name>" or the address of a user
+	SubjectType domain.ACLSubjectType
+	SubjectID   string
+	Rights      domain.Rights
+}
+
+// RightsList answers IMAP LISTRIGHTS: the rights an identifier always holds
+// on a folder and the rights that may be granted to it, one by one
+type RightsList struct {
+	Identifier string
+	Required   domain.Rights
+	Optional   []domain.Rights
+}
+
+// NewACLService creates a new ACL service. transactor is optional; when
+// set, a change and its audit entry are saved atomically.
+func NewACLService(
+	aclRepo repository.FolderACLRepository,
+	groupRepo repository.GroupRepository,
+	auditRepo repository.ACLAuditLogRepository,
+	accountRepo repository.EmailAccountRepository,
+	folderRepo repository.FolderRepository,
+	userRepo repository.UserRepository,
+	domainRepo repository.DomainRepository,
+	memberRepo repository.DomainMemberRepository,
+	transactor repository.Transactor,
+) *ACLService {
+	return &ACLService{
+		aclRepo:     aclRepo,
+		groupRepo:   groupRepo,
+		auditRepo:   auditRepo,
+		accountRepo: accountRepo,
+		folderRepo:  folderRepo,
+		userRepo:    userRepo,
+		domainRepo:  domainRepo,
+		memberRepo:  memberRepo,
+		transactor:  transactor,
+	}
+}
+
+// CreateSharedMailbox creates a mailbox no user owns, with its system
+// folders, in a domain the user administers
+func (s *ACLService) CreateSharedMailbox(ctx context.Context, userID string, req SharedMailboxRequest) (*domain.EmailAccount, error) {
+	email, err := normalizeAddress(req.Email)
+	if err != nil {
+		return nil, err
+	}
+	d, err := s.domainRepo.GetByName(ctx, email[strings.LastIndex(email, "@")+1:])
+	if err != nil {
+		return nil, errors.InternalError(err)
+	}
+	if d == nil {
+		return nil, errors.NewError(errors.ErrCodeInvalidEmailAddress, "Address is not in a hosted domain").
+			WithDetail("email", email)
+	}
+	if err := s.requireDomainAdmin(ctx, userID, d.ID); err != nil {
+		return nil, err
+	}
+	if req.QuotaMB < 0 {
+		return nil, errors.NewError(errors.ErrCodeValidationError, "Quota cannot be negative").
+			WithDetail("quota_mb", req.QuotaMB)
+	}
+	existing, err := s.accountRepo.GetByEmail(ctx, email)
+	if err != nil {
+		return nil, errors.InternalError(err)
+	}
+	if existing != nil {
+		return nil, errors.NewError(errors.ErrCodeEmailAccountAlreadyExists, "Email account already exists").
+			WithDetail("email", email)
+	}
+
+	now := time.Now().UTC().Truncate(time.Microsecond)
+	account := &domain.EmailAccount{
+		ID:         uuid.New().String(),
+		DomainID:   d.ID,
+		Email:      email,
+		IsActive:   true,
+		IsVerified: true,
+		QuotaMB:    req.QuotaMB,
+		CreatedAt:  now,
+		UpdatedAt:  now,
+	}
+	if name := strings.TrimSpace(req.DisplayName); name != "" {
+		account.DisplayName = &name
+	}
+	err = withinTransaction(ctx, s.transactor, func(ctx context.Context) error {
+		if err := s.accountRepo.Create(ctx, account); err != nil {
+			return errors.InternalError(err)
+		}
+		for _, system := range sharedMailboxFolders {
+			folder := &domain.Folder{
+				ID:           uuid.New().String(),
+				AccountID:    account.ID,
+				Name:         system.name,
+				Path:         system.name,
+				Type:         system.folderType,
+				IsSubscribed: true,
+				CreatedAt:    now,
+				UpdatedAt:    now,
+			}
+			if err := s.folderRepo.Create(ctx, folder); err != nil {
+				return errors.InternalError(err)
+			}
+		}
+		return s.record(ctx, &domain.ACLAuditEntry{
+			DomainID:  d.ID,
+			AccountID: account.ID,
+			Action:    domain.ACLAuditMailboxCreated,
+			ActorID:   userID,
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return account, nil
+}
+
+// ListSharedMailboxes lists the accounts of other users and the shared
+// mailboxes the user can see a folder of, or administers, ordered by
+// address
+func (s *ACLService) ListSharedMailboxes(ctx context.Context, userID string) ([]*domain.EmailAccount, error) {
+	acls, err := s.userACLs(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	reachable := make(map[string]bool)
+	for _, acl := range acls {
+		if acl.Rights.Has(domain.RightLookup) {
+			reachable[acl.AccountID] = true
+		}
+	}
+
+	shared := true
+	accounts, err := s.accountRepo.List(ctx, repository.EmailAccountFilter{IsShared: &shared})
+	if err != nil {
+		return nil, errors.InternalError(err)
+	}
+	admin := make(map[string]bool)
+	mailboxes := []*domain.EmailAccount{}
+	for _, account := range accounts {
+		if !reachable[account.ID] {
+			isAdmin, ok := admin[account.DomainID]
+			if !ok {
+				d, err := s.domainRepo.GetByID(ctx, account.DomainID)
+				if err != nil {
+					return nil, errors.InternalError(err)
+				}
+				if d != nil {
+					if isAdmin, err = isDomainAdmin(ctx, s.userRepo, s.memberRepo, userID, d); err != nil {
+						return nil, err
+					}
+				}
+				admin[account.DomainID] = isAdmin
+			}
+			if !isAdmin {
+				continue
+			}
+		}
+		delete(reachable, account.ID)
+		mailboxes = append(mailboxes, account)
+	}
+
+	// Accounts of other users shared through their folders
+	for accountID := range reachable {
+		account, err := s.accountRepo.GetByID(ctx, accountID)
+		if err != nil {
+			return nil, errors.InternalError(err)
+		}
+		if account != nil && account.UserID != userID {
+			mailboxes = append(mailboxes, account)
+		}
+	}
+	sort.Slice(mailboxes, func(i, j int) bool { return mailboxes[i].Email < mailboxes[j].Email })
+	return mailboxes, nil
+}
+
+// CreateGroup creates a group in a domain the user administers
+func (s *ACLService) CreateGroup(ctx context.Context, userID string, req GroupRequest) (*domain.Group, error) {
+	if err := s.requireDomainAdmin(ctx, userID, req.DomainID); err != nil {
+		return nil, err
+	}
+	name, err := normalizeGroupName(req.Name)
+	if err != nil {
+		return nil, err
+	}
+	existing, err := s.groupRepo.GetByName(ctx, req.DomainID, name)
+	if err != nil {
+		return nil, errors.InternalError(err)
+	}
+	if existing != nil {
+		return nil, errors.GroupAlreadyExists(name)
+	}
+
+	now := time.Now().UTC().Truncate(time.Microsecond)
+	group := &domain.Group{
+		ID:          uuid.New().String(),
+		DomainID:    req.DomainID,
+		Name:        name,
+		Description: strings.TrimSpace(req.Description),
+		CreatedAt:   now,
+		UpdatedAt:   now,
+	}
+	err = withinTransaction(ctx, s.transactor, func(ctx context.Context) error {
+		if err := s.groupRepo.Create(ctx, group); err != nil {
+			return errors.InternalError(err)
+		}
+		return s.record(ctx, &domain.ACLAuditEntry{
+			DomainID:    group.DomainID,
+			GroupID:     group.ID,
+			Action:      domain.ACLAuditGroupCreated,
+			SubjectType: domain.ACLSubjectGroup,
+			SubjectID:   group.ID,
+			ActorID:     userID,
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return group, nil
+}
+
+// DeleteGroup deletes a group; the rights granted to it go with it
+func (s *ACLService) DeleteGroup(ctx context.Context, userID, id string) error {
+	group, err := s.managedGroup(ctx, userID, id)
+	if err != nil {
+		return err
+	}
+	return withinTransaction(ctx, s.transactor, func(ctx context.Context) error {
+		if err := s.groupRepo.Delete(ctx, group.ID); err != nil {
+			return errors.InternalError(err)
+		}
+		return s.record(ctx, &domain.ACLAuditEntry{
+			DomainID:    group.DomainID,
+			GroupID:     group.ID,
+			Action:      domain.ACLAuditGroupDeleted,
+			SubjectType: domain.ACLSubjectGroup,
+			SubjectID:   group.ID,
+			ActorID:     userID,
+		})
+	})
+}
+
+// ListGroups lists the groups of a domain the user administers
+func (s *ACLService) ListGroups(ctx context.Context, userID, domainID string) ([]*domain.Group, error) {
+	if err := s.requireDomainAdmin(ctx, userID, domainID); err != nil {
+		return nil, err
+	}
+	groups, err := s.groupRepo.ListByDomain(ctx, domainID)
+	if err != nil {
+		return nil, errors.InternalError(err)
+	}
+	return groups, nil
+}
+
+// ListGroupMembers lists the members of a group of a domain the user
+// administers
+func (s *ACLService) ListGroupMembers(ctx context.Context, userID, groupID string) ([]*domain.GroupMember, error) {
+	if _, err := s.managedGroup(ctx, userID, groupID); err != nil {
+		return nil, err
+	}
+	members, err := s.groupRepo.ListMembers(ctx, groupID)
+	if err != nil {
+		return nil, errors.InternalError(err)
+	}
+	return members, nil
+}
+
+// AddGroupMember adds a user, given by address or username, to a group of
+// a domain the user administers
+func (s *ACLService) AddGroupMember(ctx context.Context, userID, groupID, member string) (*domain.GroupMember, error) {
+	group, err := s.managedGroup(ctx, userID, groupID)
+	if err != nil {
+		return nil, err
+	}
+	user, err := s.findUser(ctx, member)
+	if err != nil {
+		return nil, err
+	}
+
+	added := &domain.GroupMember{
+		GroupID: group.ID,
+		UserID:  user.ID,
+		AddedAt: time.Now().UTC().Truncate(time.Microsecond),
+	}
+	err = withinTransaction(ctx, s.transactor, func(ctx context.Context) error {
+		if err := s.groupRepo.AddMember(ctx, added); err != nil {
+			return errors.InternalError(err)
+		}
+		return s.record(ctx, &domain.ACLAuditEntry{
+			DomainID:    group.DomainID,
+			GroupID:     group.ID,
+			Action:      domain.ACLAuditMemberAdded,
+			SubjectType: domain.ACLSubjectUser,
+			SubjectID:   user.ID,
+			ActorID:     userID,
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return added, nil
+}
+
+// RemoveGroupMember removes a user from a group of a domain the user
+// administers
+func (s *ACLService) RemoveGroupMember(ctx context.Context, userID, groupID, memberID string) error {
+	group, err := s.managedGroup(ctx, userID, groupID)
+	if err != nil {
+		return err
+	}
+	return withinTransaction(ctx, s.transactor, func(ctx context.Context) error {
+		if err := s.groupRepo.RemoveMember(ctx, group.ID, memberID); err != nil {
+			return errors.InternalError(err)
+		}
+		return s.record(ctx, &domain.ACLAuditEntry{
+			DomainID:    group.DomainID,
+			GroupID:     group.ID,
+			Action:      domain.ACLAuditMemberRemoved,
+			SubjectType: domain.ACLSubjectUser,
+			SubjectID:   memberID,
+			ActorID:     userID,
+		})
+	})
+}
+
+// MyRights returns the rights of the user on a folder, as IMAP MYRIGHTS
+func (s *ACLService) MyRights(ctx context.Context, userID, folderID string) (domain.Rights, error) {
+	_, _, rights, err := s.visibleFolder(ctx, userID, folderID)
+	return rights, err
+}
+
+// GetACL returns the ACL of a folder, as IMAP GETACL. The owner of a
+// personal account comes first with every right. The user needs the admin
+// right on the folder.
+func (s *ACLService) GetACL(ctx context.Context, userID, folderID string) ([]*ACLEntry, error) {
+	folder, account, err := s.administeredFolder(ctx, userID, folderID)
+	if err != nil {
+		return nil, err
+	}
+	acls, err := s.aclRepo.ListByFolder(ctx, folder.ID)
+	if err != nil {
+		return nil, errors.InternalError(err)
+	}
+
+	entries := make([]*ACLEntry, 0, len(acls)+1)
+	if !account.IsShared() {
+		owner, err := s.userRepo.GetByID(ctx, account.UserID)
+		if err != nil {
+			return nil, errors.InternalError(err)
+		}
+		if owner != nil {
+			entries = append(entries, &ACLEntry{
+				Identifier:  owner.Email,
+				SubjectType: domain.ACLSubjectUser,
+				SubjectID:   owner.ID,
+				Rights:      domain.AllRights,
+			})
+		}
+	}
+	for _, acl := range acls {
+		identifier, err := s.identifier(ctx, acl.SubjectType, acl.SubjectID)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, &ACLEntry{
+			Identifier:  identifier,
+			SubjectType: acl.SubjectType,
+			SubjectID:   acl.SubjectID,
+			Rights:      acl.Rights,
+		})
+	}
+	return entries, nil
+}
+
+// SetACL sets the rights of an identifier on a folder, as IMAP SETACL.
+// Rights starting with "+" are added to the current ones and rights
+// starting with "-" removed; an entry left without rights is deleted. The
+// user needs the admin right on the folder.
+func (s *ACLService) SetACL(ctx context.Context, userID, folderID, identifier, rights string) (*ACLEntry, error) {
+	modifier := byte(0)
+	if rights != "" && (rights[0] == '+' || rights[0] == '-') {
+		modifier, rights = rights[0], rights[1:]
+	}
+	parsed, ok := domain.ParseRights(rights)
+	if !ok {
+		return nil, errors.InvalidRights(rights)
+	}
+	folder, account, err := s.administeredFolder(ctx, userID, folderID)
+	if err != nil {
+		return nil, err
+	}
+	subjectType, subjectID, name, err := s.resolveIdentifier(ctx, account, identifier)
+	if err != nil {
+		return nil, err
+	}
+
+	existing, err := s.aclRepo.Get(ctx, folder.ID, subjectType, subjectID)
+	if err != nil {
+		return nil, errors.InternalError(err)
+	}
+	var old domain.Rights
+	if existing != nil {
+		old = existing.Rights
+	}
+	switch modifier {
+	case '+':
+		parsed = old.Union(parsed)
+	case '-':
+		parsed = old.Without(parsed)
+	}
+	entry := &ACLEntry{Identifier: name, SubjectType: subjectType, SubjectID: subjectID, Rights: parsed}
+	if parsed == old {
+		return entry, nil
+	}
+
+	now := time.Now().UTC().Truncate(time.Microsecond)
+	err = withinTransaction(ctx, s.transactor, func(ctx context.Context) error {
+		action := domain.ACLAuditRightsSet
+		switch {
+		case parsed == "":
+			action = domain.ACLAuditRightsDeleted
+			if err := s.aclRepo.Delete(ctx, existing.ID); err != nil {
+				return errors.InternalError(err)
+			}
+		case existing != nil:
+			existing.Rights = parsed
+			existing.GrantedBy = userID
+			existing.UpdatedAt = now
+			if err := s.aclRepo.Update(ctx, existing); err != nil {
+				return errors.InternalError(err)
+			}
+		default:
+			acl := &domain.FolderACL{
+				ID:          uuid.New().String(),
+				FolderID:    folder.ID,
+				AccountID:   account.ID,
+				SubjectType: subjectType,
+				SubjectID:   subjectID,
+				Rights:      parsed,
+				GrantedBy:   userID,
+				CreatedAt:   now,
+				UpdatedAt:   now,
+			}
+			if err := s.aclRepo.Create(ctx, acl); err != nil {
+				return errors.InternalError(err)
+			}
+		}
+		return s.record(ctx, &domain.ACLAuditEntry{
+			DomainID:    account.DomainID,
+			AccountID:   account.ID,
+			FolderID:    folder.ID,
+			Action:      action,
+			SubjectType: subjectType,
+			SubjectID:   subjectID,
+			OldRights:   old,
+			NewRights:   parsed,
+			ActorID:     userID,
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return entry, nil
+}
+
+// DeleteACL removes the entry of an identifier from the ACL of a folder,
+// as IMAP DELETEACL. The user needs the admin right on the folder.
+func (s *ACLService) DeleteACL(ctx context.Context, userID, folderID, identifier string) error {
+	_, err := s.SetACL(ctx, userID, folderID, identifier, "")
+	return err
+}
+
+// ListRights returns the rights that may be granted to an identifier on a
+// folder, as IMAP LISTRIGHTS. The owner of the account always holds every
+// right; others may be granted each right on its own.
+func (s *ACLService) ListRights(ctx context.Context, userID, folderID, identifier string) (*RightsList, error) {
+	_, account, err := s.administeredFolder(ctx, userID, folderID)
+	if err != nil {
+		return nil, err
+	}
+	list := &RightsList{Identifier: identifier, Optional: []domain.Rights{}}
+	if owner, err := s.ownerIdentifier(ctx, account, identifier); err != nil {
+		return nil, err
+	} else if owner {
+		list.Required = domain.AllRights
+		return list, nil
+	}
+	if _, _, _, err := s.resolveIdentifier(ctx, account, identifier); err != nil {
+		return nil, err
+	}
+	for i := 0; i < len(domain.AllRights); i++ {
+		list.Optional = append(list.Optional, domain.NewRights(domain.Right(domain.AllRights[i])))
+	}
+	return list, nil
+}
+
+// ListAuditLog lists the ACL changes of a domain the user administers,
+// optionally of one of its accounts, newest first, with the number of
+// matching entries
+func (s *ACLService) ListAuditLog(ctx context.Context, userID, domainID, accountID string, limit, offset int) ([]*domain.ACLAuditEntry, int, error) {
+	if err := s.requireDomainAdmin(ctx, userID, domainID); err != nil {
+		return nil, 0, err
+	}
+	filter := repository.ACLAuditFilter{DomainID: domainID, AccountID: accountID, Limit: limit, Offset: offset}
+	entries, err := s.auditRepo.List(ctx, filter)
+	if err != nil {
+		return nil, 0, errors.InternalError(err)
+	}
+	total, err := s.auditRepo.Count(ctx, filter)
+	if err != nil {
+		return nil, 0, errors.InternalError(err)
+	}
+	return entries, total, nil
+}
+
+// FolderRights returns the rights of a user on folders of an account, by
+// folder ID. Folders the user holds no right on map to no rights.
+func (s *ACLService) FolderRights(ctx context.Context, userID string, account *domain.EmailAccount, folders []*domain.Folder) (map[string]domain.Rights, error) {
+	rights := make(map[string]domain.Rights, len(folders))
+	full, err := s.holdsAllRights(ctx, userID, account)
+	if err != nil {
+		return nil, err
+	}
+	if full {
+		for _, folder := range folders {
+			rights[folder.ID] = domain.AllRights
+		}
+		return rights, nil
+	}
+
+	acls, err := s.userACLs(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	for _, folder := range folders {
+		rights[folder.ID] = ""
+	}
+	for _, acl := range acls {
+		if current, ok := rights[acl.FolderID]; ok && acl.AccountID == account.ID {
+			rights[acl.FolderID] = current.Union(acl.Rights)
+		}
+	}
+	return rights, nil
+}
+
+// InheritACL gives a new folder the ACL of its parent
+func (s *ACLService) InheritACL(ctx context.Context, userID string, parent, folder *domain.Folder) error {
+	acls, err := s.aclRepo.ListByFolder(ctx, parent.ID)
+	if err != nil {
+		return errors.InternalError(err)
+	}
+	if len(acls) == 0 {
+		return nil
+	}
+	account, err := s.accountRepo.GetByID(ctx, folder.AccountID)
+	if err != nil {
+		return errors.InternalError(err)
+	}
+	if account == nil {
+		return errors.EmailAccountNotFound(folder.AccountID)
+	}
+
+	now := time.Now().UTC().Truncate(time.Microsecond)
+	return withinTransaction(ctx, s.transactor, func(ctx context.Context) error {
+		for _, inherited := range acls {
+			acl := &domain.FolderACL{
+				ID:          uuid.New().String(),
+				FolderID:    folder.ID,
+				AccountID:   folder.AccountID,
+				SubjectType: inherited.SubjectType,
+				SubjectID:   inherited.SubjectID,
+				Rights:      inherited.Rights,
+				GrantedBy:   userID,
+				CreatedAt:   now,
+				UpdatedAt:   now,
+			}
+			if err := s.aclRepo.Create(ctx, acl); err != nil {
+				return errors.InternalError(err)
+			}
+			err := s.record(ctx, &domain.ACLAuditEntry{
+				DomainID:    account.DomainID,
+				AccountID:   account.ID,
+				FolderID:    folder.ID,
+				Action:      domain.ACLAuditRightsSet,
+				SubjectType: acl.SubjectType,
+				SubjectID:   acl.SubjectID,
+				NewRights:   acl.Rights,
+				ActorID:     userID,
+			})
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// holdsAllRights reports whether a user holds every right on the folders
+// of an account: its owner, or an administrator of the domain of a shared
+// mailbox
+func (s *ACLService) holdsAllRights(ctx context.Context, userID string, account *domain.EmailAccount) (bool, error) {
+	if account.UserID == userID {
+		return true, nil
+	}
+	if !account.IsShared() {
+		return false, nil
+	}
+	d, err := s.domainRepo.GetByID(ctx, account.DomainID)
+	if err != nil {
+		return false, errors.InternalError(err)
+	}
+	if d == nil {
+		return false, nil
+	}
+	return isDomainAdmin(ctx, s.userRepo, s.memberRepo, userID, d)
+}
+
+// userACLs returns the entries granting rights to a user, directly, through
+// its groups or to anyone
+func (s *ACLService) userACLs(ctx context.Context, userID string) ([]*domain.FolderACL, error) {
+	groups, err := s.groupRepo.ListByUser(ctx, userID)
+	if err != nil {
+		return nil, errors.InternalError(err)
+	}
+	groupIDs := make([]string, 0, len(groups))
+	for _, group := range groups {
+		groupIDs = append(groupIDs, group.ID)
+	}
+	acls, err := s.aclRepo.ListBySubjects(ctx, userID, groupIDs)
+	if err != nil {
+		return nil, errors.InternalError(err)
+	}
+	return acls, nil
+}
+
+// visibleFolder returns a folder the user holds a right on with its
+// account and the user's rights
+func (s *ACLService) visibleFolder(ctx context.Context, userID, folderID string) (*domain.Folder, *domain.EmailAccount, domain.Rights, error) {
+	folder, err := s.folderRepo.GetByID(ctx, folderID)
+	if err != nil {
+		return nil, nil, "", errors.InternalError(err)
+	}
+	if folder == nil {
+		return nil, nil, "", errors.FolderNotFound(folderID)
+	}
+	account, err := s.accountRepo.GetByID(ctx, folder.AccountID)
+	if err != nil {
+		return nil, nil, "", errors.InternalError(err)
+	}
+	if account == nil {
+		return nil, nil, "", errors.FolderNotFound(folderID)
+	}
+	rights, err := s.FolderRights(ctx, userID, account, []*domain.Folder{folder})
+	if err != nil {
+		return nil, nil, "", err
+	}
+	if rights[folder.ID] == "" {
+		// Do not reveal folders not shared with the user
+		return nil, nil, "", errors.FolderNotFound(folderID)
+	}
+	return folder, account, rights[folder.ID], nil
+}
+
+// administeredFolder returns a folder the user holds the admin right on
+func (s *ACLService) administeredFolder(ctx context.Context, userID, folderID string) (*domain.Folder, *domain.EmailAccount, error) {
+	folder, account, rights, err := s.visibleFolder(ctx, userID, folderID)
+	if err != nil {
+		return nil, nil, err
+	}
+	if !rights.Has(domain.RightAdmin) {
+		return nil, nil, errors.MissingRights(folderID, string(domain.RightAdmin))
+	}
+	return folder, account, nil
+}
+
+// resolveIdentifier returns who an ACL identifier names and how IMAP shows
+// it: anyone, a group of the account's domain, or a user given by the
+// address of one of its accounts, its own address or its username
+func (s *ACLService) resolveIdentifier(ctx context.Context, account *domain.EmailAccount, identifier string) (domain.ACLSubjectType, string, string, error) {
+	identifier = strings.TrimSpace(identifier)
+	if strings.EqualFold(identifier, aclAnyone) {
+		return domain.ACLSubjectAnyone, "", aclAnyone, nil
+	}
+	if len(identifier) > len(aclGroupPrefix) && strings.EqualFold(identifier[:len(aclGroupPrefix)], aclGroupPrefix) {
+		name := strings.ToLower(identifier[len(aclGroupPrefix):])
+		group, err := s.groupRepo.GetByName(ctx, account.DomainID, name)
+		if err != nil {
+			return "", "", "", errors.InternalError(err)
+		}
+		if group == nil {
+			return "", "", "", errors.GroupNotFound(name)
+		}
+		return domain.ACLSubjectGroup, group.ID, aclGroupPrefix + group.Name, nil
+	}
+
+	user, err := s.findUser(ctx, identifier)
+	if err != nil {
+		return "", "", "", err
+	}
+	if user.ID == account.UserID {
+		return "", "", "", errors.NewError(errors.ErrCodeValidationError, "The owner of a mailbox always holds every right").
+			WithDetail("identifier", identifier)
+	}
+	return domain.ACLSubjectUser, user.ID, user.Email, nil
+}
+
+// ownerIdentifier reports whether an identifier names the owner of an
+// account
+func (s *ACLService) ownerIdentifier(ctx context.Context, account *domain.EmailAccount, identifier string) (bool, error) {
+	if account.IsShared() || identifier == aclAnyone || strings.HasPrefix(strings.ToLower(identifier), aclGroupPrefix) {
+		return false, nil
+	}
+	user, err := s.findUser(ctx, identifier)
+	if err != nil {
+		return false, err
+	}
+	return user.ID == account.UserID, nil
+}
+
+// identifier returns how IMAP shows the subject of an entry
+func (s *ACLService) identifier(ctx context.Context, subjectType domain.ACLSubjectType, subjectID string) (string, error) {
+	switch subjectType {
+	case domain.ACLSubjectGroup:
+		group, err := s.groupRepo.GetByID(ctx, subjectID)
+		if err != nil {
+			return "", errors.InternalError(err)
+		}
+		if group != nil {
+			return aclGroupPrefix + group.Name, nil
+		}
+	case domain.ACLSubjectUser:
+		user, err := s.userRepo.GetByID(ctx, subjectID)
+		if err != nil {
+			return "", errors.InternalError(err)
+		}
+		if user != nil {
+			return user.Email, nil
+		}
+	default:
+		return aclAnyone, nil
+	}
+	return subjectID, nil
+}
+
+// findUser returns the user an address or username names; the address of
+// an email account names the user owning it
+func (s *ACLService) findUser(ctx context.Context, value string) (*domain.User, error) {
+	value = strings.TrimSpace(value)
+	user, err := s.lookupUser(ctx, value)
+	if err != nil {
+		return nil, errors.InternalError(err)
+	}
+	if user == nil {
+		return nil, errors.UserNotFound(value)
+	}
+	return user, nil
+}
+
+func (s *ACLService) lookupUser(ctx context.Context, value string) (*domain.User, error) {
+	if !strings.Contains(value, "@") {
+		if value == "" {
+			return nil, nil
+		}
+		return s.userRepo.GetByUsername(ctx, value)
+	}
+	email := strings.ToLower(value)
+	account, err := s.accountRepo.GetByEmail(ctx, email)
+	if err != nil {
+		return nil, err
+	}
+	if account != nil && !account.IsShared() {
+		return s.userRepo.GetByID(ctx, account.UserID)
+	}
+	return s.userRepo.GetByEmail(ctx, email)
+}
+
+// managedGroup returns a group of a domain the user administers
+func (s *ACLService) managedGroup(ctx context.Context, userID, id string) (*domain.Group, error) {
+	group, err := s.groupRepo.GetByID(ctx, id)
+	if err != nil {
+		return nil, errors.InternalError(err)
+	}
+	if group == nil {
+		return nil, errors.GroupNotFound(id)
+	}
+	d, err := s.domainRepo.GetByID(ctx, group.DomainID)
+	if err != nil {
+		return nil, errors.InternalError(err)
+	}
+	if d != nil {
+		admin, err := isDomainAdmin(ctx, s.userRepo, s.memberRepo, userID, d)
+		if err != nil {
+			return nil, err
+		}
+		if admin {
+			return group, nil
+		}
+	}
+	// Do not reveal groups of other domains
+	return nil, errors.GroupNotFound(id)
+}
+
+func (s *ACLService) requireDomainAdmin(ctx context.Context, userID, domainID string) error {
+	d, err := s.domainRepo.GetByID(ctx, domainID)
+	if err != nil {
+		return errors.InternalError(err)
+	}
+	if d == nil {
+		return errors.DomainNotFound(domainID)
+	}
+	admin, err := isDomainAdmin(ctx, s.userRepo, s.memberRepo, userID, d)
+	if err != nil {
+		return err
+	}
+	if !admin {
+		return errors.NewError(errors.ErrCodeForbidden, "Only domain administrators can manage shared mailboxes and groups").
+			WithDetail("domain_id", domainID)
+	}
+	return nil
+}
+
+// record appends an entry to the audit log
+func (s *ACLService) record(ctx context.Context, entry *domain.ACLAuditEntry) error {
+	entry.ID = uuid.New().String()
+	entry.CreatedAt = time.Now().UTC().Truncate(time.Microsecond)
+	if err := s.auditRepo.Record(ctx, entry); err != nil {
+		return errors.InternalError(err)
+	}
+	return nil
+}
+
+// normalizeGroupName returns the lower-case name of a group
+func normalizeGroupName(value string) (string, error) {
+	name := strings.ToLower(strings.TrimSpace(value))
+	if name == "" {
+		return "", errors.NewError(errors.ErrCodeValidationError, "Group name is required")
+	}
+	if utf8.RuneCountInString(name) > maxGroupNameSize {
+		return "", errors.NewError(errors.ErrCodeValidationError, "Group name is too long").
+			WithDetail("max", maxGroupNameSize)
+	}
+	for _, r := range name {
+		if unicode.IsSpace(r) || unicode.IsControl(r) || r == '@' {
+			return "", errors.NewError(errors.ErrCodeValidationError, "Group name contains an invalid character").
+				WithDetail("name", value)
+		}
+	}
+	return name, nil
+}
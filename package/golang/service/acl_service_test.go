@@ -0,0 +1,280 @@
+package service
+
+import (
+	"context"
+	stderrors "errors"
+	"testing"
+
+	"github.com/skygenesisenterprise/aether-mailer/package/golang/domain"
+	"github.com/skygenesisenterprise/aether-mailer/package/golang/errors"
+	"github.com/skygenesisenterprise/aether-mailer/package/golang/repository/inmemory"
+)
+
+func newTestACL(m *testMail) *ACLService {
+	return NewACLService(inmemory.NewFolderACLRepository(m.store), inmemory.NewGroupRepository(m.store),
+		inmemory.NewACLAuditLogRepository(m.store), m.accounts, m.folders, m.users, m.domains, m.members, nil)
+}
+
+// rights parses rights written the IMAP way
+func rights(t *testing.T, value string) domain.Rights {
+	t.Helper()
+	parsed, ok := domain.ParseRights(value)
+	if !ok {
+		t.Fatalf("invalid rights %q", value)
+	}
+	return parsed
+}
+
+// errorCode returns the code of a service error, or "" for another error
+func errorCode(err error) errors.ErrorCode {
+	var serviceErr *errors.Error
+	if stderrors.As(err, &serviceErr) {
+		return serviceErr.Code
+	}
+	return ""
+}
+
+func TestACLServiceGrantAndRevoke(t *testing.T) {
+	ctx := context.Background()
+	m := newTestMail(t)
+	alice, bob := m.newUser(t, "alice"), m.newUser(t, "bob")
+	account := m.newAccount(t, alice, "alice")
+	inbox := m.folder(t, account, domain.FolderTypeInbox)
+	acl := newTestACL(m)
+
+	steps := []struct {
+		rights string // given to bob by alice, as SETACL
+		want   string // bob's rights afterwards, "" when the folder is hidden
+		action domain.ACLAuditAction
+	}{
+		{"lr", "lr", domain.ACLAuditRightsSet},
+		{"+si", "lrsi", domain.ACLAuditRightsSet},
+		{"-ri", "ls", domain.ACLAuditRightsSet},
+		{"lrw", "lrw", domain.ACLAuditRightsSet},
+		{"-lrw", "", domain.ACLAuditRightsDeleted},
+	}
+	for _, step := range steps {
+		entry, err := acl.SetACL(ctx, alice.ID, inbox.ID, "bob", step.rights)
+		if err != nil {
+			t.Fatalf("SetACL %q: %v", step.rights, err)
+		}
+		if entry.Identifier != bob.Email || entry.Rights != rights(t, step.want) {
+			t.Errorf("SetACL %q = %+v, want %s holding %q", step.rights, entry, bob.Email, step.want)
+		}
+
+		got, err := acl.MyRights(ctx, bob.ID, inbox.ID)
+		switch {
+		case step.want == "":
+			if errorCode(err) != errors.ErrCodeFolderNotFound {
+				t.Errorf("MyRights after %q = %q, %v; want the folder hidden", step.rights, got, err)
+			}
+		case err != nil || got != rights(t, step.want):
+			t.Errorf("MyRights after %q = %q, %v; want %q", step.rights, got, err, step.want)
+		}
+	}
+
+	// Removing rights the user no longer holds is not audited
+	if _, err := acl.SetACL(ctx, alice.ID, inbox.ID, bob.Email, ""); err != nil {
+		t.Fatalf("SetACL without rights: %v", err)
+	}
+
+	entries, total, err := acl.ListAuditLog(ctx, m.domain.OwnerID, m.domain.ID, account.ID, 0, 0)
+	if err != nil {
+		t.Fatalf("ListAuditLog: %v", err)
+	}
+	if total != len(steps) {
+		t.Fatalf("%d audit entries, want %d", total, len(steps))
+	}
+	for i, step := range steps {
+		// Newest first
+		entry := entries[len(entries)-1-i]
+		if entry.Action != step.action || entry.SubjectID != bob.ID || entry.ActorID != alice.ID ||
+			entry.NewRights != rights(t, step.want) {
+			t.Errorf("audit entry %d = %+v, want %s to %q", i, entry, step.action, step.want)
+		}
+	}
+
+	// The owner comes first with every right
+	if _, err := acl.SetACL(ctx, alice.ID, inbox.ID, "anyone", "l"); err != nil {
+		t.Fatalf("SetACL anyone: %v", err)
+	}
+	list, err := acl.GetACL(ctx, alice.ID, inbox.ID)
+	if err != nil {
+		t.Fatalf("GetACL: %v", err)
+	}
+	if len(list) != 2 || list[0].Identifier != alice.Email || list[0].Rights != domain.AllRights ||
+		list[1].Identifier != "anyone" || list[1].Rights != rights(t, "l") {
+		t.Errorf("GetACL = %+v, want the owner and anyone", list)
+	}
+}
+
+func TestACLServiceInheritedRights(t *testing.T) {
+	ctx := context.Background()
+	m := newTestMail(t)
+	admin := m.domain.OwnerID
+	alice, bob, carol := m.newUser(t, "alice"), m.newUser(t, "bob"), m.newUser(t, "carol")
+	account := m.newAccount(t, alice, "alice")
+	inbox := m.folder(t, account, domain.FolderTypeInbox)
+	sent := m.folder(t, account, domain.FolderTypeSent)
+	acl := newTestACL(m)
+
+	sales, err := acl.CreateGroup(ctx, admin, GroupRequest{DomainID: m.domain.ID, Name: "Sales"})
+	if err != nil {
+		t.Fatalf("CreateGroup: %v", err)
+	}
+	if _, err := acl.AddGroupMember(ctx, admin, sales.ID, "bob"); err != nil {
+		t.Fatalf("AddGroupMember: %v", err)
+	}
+	grant := func(folder *domain.Folder, identifier, value string) {
+		t.Helper()
+		if _, err := acl.SetACL(ctx, alice.ID, folder.ID, identifier, value); err != nil {
+			t.Fatalf("SetACL %s %q: %v", identifier, value, err)
+		}
+	}
+	grant(inbox, "group:sales", "lr")
+	grant(inbox, bob.Email, "w")
+	grant(sent, "anyone", "l")
+
+	// A subfolder takes the ACL of its parent when it is created
+	projects := m.newFolder(t, account, inbox, "Projects", domain.FolderTypeCustom)
+	if err := acl.InheritACL(ctx, alice.ID, inbox, projects); err != nil {
+		t.Fatalf("InheritACL: %v", err)
+	}
+
+	check := func(name string, user *domain.User, folder *domain.Folder, want domain.Rights) {
+		t.Helper()
+		got, err := acl.MyRights(ctx, user.ID, folder.ID)
+		if want == "" {
+			if errorCode(err) != errors.ErrCodeFolderNotFound {
+				t.Errorf("%s: MyRights = %q, %v; want the folder hidden", name, got, err)
+			}
+			return
+		}
+		if err != nil || got != want {
+			t.Errorf("%s: MyRights = %q, %v; want %q", name, got, err, want)
+		}
+	}
+	check("group and user rights add up", bob, inbox, rights(t, "lrw"))
+	check("rights of anyone", carol, sent, rights(t, "l"))
+	check("rights of anyone reach group members", bob, sent, rights(t, "l"))
+	check("no rights", carol, inbox, "")
+	check("inherited by a subfolder", bob, projects, rights(t, "lrw"))
+	check("owner", alice, projects, domain.AllRights)
+
+	// Leaving the group takes its rights away
+	if err := acl.RemoveGroupMember(ctx, admin, sales.ID, bob.ID); err != nil {
+		t.Fatalf("RemoveGroupMember: %v", err)
+	}
+	check("after leaving the group", bob, inbox, rights(t, "w"))
+
+	// Deleting the group takes its rights away from every member
+	if _, err := acl.AddGroupMember(ctx, admin, sales.ID, carol.Email); err != nil {
+		t.Fatalf("AddGroupMember: %v", err)
+	}
+	check("new group member", carol, inbox, rights(t, "lr"))
+	if err := acl.DeleteGroup(ctx, admin, sales.ID); err != nil {
+		t.Fatalf("DeleteGroup: %v", err)
+	}
+	check("after the group is deleted", carol, inbox, "")
+
+	// Domain administrators hold every right on shared mailboxes
+	support, err := acl.CreateSharedMailbox(ctx, admin, SharedMailboxRequest{Email: "support@" + m.domain.Name})
+	if err != nil {
+		t.Fatalf("CreateSharedMailbox: %v", err)
+	}
+	supportInbox := m.folder(t, support, domain.FolderTypeInbox)
+	admins, err := m.users.GetByID(ctx, admin)
+	if err != nil {
+		t.Fatal(err)
+	}
+	check("domain administrator on a shared mailbox", admins, supportInbox, domain.AllRights)
+	check("user on a shared mailbox", bob, supportInbox, "")
+}
+
+func TestACLServiceDenials(t *testing.T) {
+	ctx := context.Background()
+	m := newTestMail(t)
+	alice, bob, carol := m.newUser(t, "alice"), m.newUser(t, "bob"), m.newUser(t, "carol")
+	account := m.newAccount(t, alice, "alice")
+	inbox := m.folder(t, account, domain.FolderTypeInbox)
+	acl := newTestACL(m)
+	if _, err := acl.SetACL(ctx, alice.ID, inbox.ID, bob.Email, "lr"); err != nil {
+		t.Fatalf("SetACL: %v", err)
+	}
+
+	tests := []struct {
+		name string
+		call func() error
+		code errors.ErrorCode
+	}{
+		{"reader sets rights", func() error {
+			_, err := acl.SetACL(ctx, bob.ID, inbox.ID, carol.Email, "lr")
+			return err
+		}, errors.ErrCodeMissingRights},
+		{"reader reads the ACL", func() error {
+			_, err := acl.GetACL(ctx, bob.ID, inbox.ID)
+			return err
+		}, errors.ErrCodeMissingRights},
+		{"reader lists rights", func() error {
+			_, err := acl.ListRights(ctx, bob.ID, inbox.ID, carol.Email)
+			return err
+		}, errors.ErrCodeMissingRights},
+		{"stranger reads rights", func() error {
+			_, err := acl.MyRights(ctx, carol.ID, inbox.ID)
+			return err
+		}, errors.ErrCodeFolderNotFound},
+		{"stranger sets rights", func() error {
+			_, err := acl.SetACL(ctx, carol.ID, inbox.ID, carol.Email, "lra")
+			return err
+		}, errors.ErrCodeFolderNotFound},
+		{"unknown right", func() error {
+			_, err := acl.SetACL(ctx, alice.ID, inbox.ID, bob.Email, "lrz")
+			return err
+		}, errors.ErrCodeInvalidRights},
+		{"rights of the owner", func() error {
+			_, err := acl.SetACL(ctx, alice.ID, inbox.ID, alice.Email, "l")
+			return err
+		}, errors.ErrCodeValidationError},
+		{"unknown user", func() error {
+			_, err := acl.SetACL(ctx, alice.ID, inbox.ID, "nobody@users.example", "l")
+			return err
+		}, errors.ErrCodeUserNotFound},
+		{"unknown group", func() error {
+			_, err := acl.SetACL(ctx, alice.ID, inbox.ID, "group:nobody", "l")
+			return err
+		}, errors.ErrCodeGroupNotFound},
+		{"user creates a group", func() error {
+			_, err := acl.CreateGroup(ctx, bob.ID, GroupRequest{DomainID: m.domain.ID, Name: "staff"})
+			return err
+		}, errors.ErrCodeForbidden},
+		{"user creates a shared mailbox", func() error {
+			_, err := acl.CreateSharedMailbox(ctx, bob.ID, SharedMailboxRequest{Email: "sales@" + m.domain.Name})
+			return err
+		}, errors.ErrCodeForbidden},
+		{"user reads the audit log", func() error {
+			_, _, err := acl.ListAuditLog(ctx, alice.ID, m.domain.ID, "", 0, 0)
+			return err
+		}, errors.ErrCodeForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if code := errorCode(tt.call()); code != tt.code {
+				t.Errorf("error code = %q, want %q", code, tt.code)
+			}
+		})
+	}
+
+	// Denied calls leave the ACL as it was
+	got, err := acl.MyRights(ctx, bob.ID, inbox.ID)
+	if err != nil || got != rights(t, "lr") {
+		t.Errorf("MyRights = %q, %v; want lr", got, err)
+	}
+
+	// The admin right lets a user other than the owner manage the ACL
+	if _, err := acl.SetACL(ctx, alice.ID, inbox.ID, bob.Email, "+a"); err != nil {
+		t.Fatalf("SetACL: %v", err)
+	}
+	if _, err := acl.SetACL(ctx, bob.ID, inbox.ID, carol.Email, "lr"); err != nil {
+		t.Errorf("SetACL by a user with the admin right: %v", err)
+	}
+}
@@ -0,0 +1,356 @@
+package service
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/skygenesisenterprise/aether-mailer/package/golang/domain"
+	"github.com/skygenesisenterprise/aether-mailer/package/golang/errors"
+	"github.com/skygenesisenterprise/aether-mailer/package/golang/repository"
+)
+
+// VirusScanner defines the contract for malware scanning engines
+type VirusScanner interface {
+	Scan(ctx context.Context, r io.Reader) (*domain.ScanResult, error)
+	Ping(ctx context.Context) error
+}
+
+// AntivirusService scans messages and applies the matching virus policy
+type AntivirusService struct {
+	scanner     VirusScanner
+	blobs       BlobReader
+	accountRepo repository.EmailAccountRepository
+	policyRepo  repository.PolicyRepository
+	eventPub    domain.EventPublisher
+	config      *AntivirusConfig
+}
+
+// AntivirusConfig defines antivirus service configuration
+type AntivirusConfig struct {
+	EnableVirusScan bool
+	MaxScanSize     int64 // parts larger than this are not scanned
+	FailOpen        bool  // deliver unscanned mail when the scanner is unavailable
+}
+
+// NewAntivirusService creates a new antivirus service. blobs streams the
+// attachments whose content has moved to the blob store; it may be nil when
+// attachments are only held in memory.
+func NewAntivirusService(
+	scanner VirusScanner,
+	blobs BlobReader,
+	accountRepo repository.EmailAccountRepository,
+	policyRepo repository.PolicyRepository,
+	eventPub domain.EventPublisher,
+	config *AntivirusConfig,
+) *AntivirusService {
+	return &AntivirusService{
+		scanner:     scanner,
+		blobs:       blobs,
+		accountRepo: accountRepo,
+		policyRepo:  policyRepo,
+		eventPub:    eventPub,
+		config:      config,
+	}
+}
+
+// ScanMessage scans the raw message (when provided) and every attachment.
+// Infected messages get the action of the matching virus policy, defaulting
+// to quarantine. Scanner failures fail open or closed depending on config.
+func (s *AntivirusService) ScanMessage(ctx context.Context, message *domain.Message, raw io.Reader) (*domain.VirusVerdict, error) {
+	verdict := &domain.VirusVerdict{
+		MessageID: message.ID,
+		AccountID: message.AccountID,
+		Findings:  []domain.VirusFinding{},
+		Action:    domain.PolicyActionAllow,
+		ScannedAt: time.Now(),
+	}
+
+	if !s.config.EnableVirusScan {
+		verdict.Skipped = true
+		return verdict, nil
+	}
+
+	targets := 0
+	if raw != nil && !s.exceedsScanSize(message.Size) {
+		targets++
+		if err := s.scanTarget(ctx, verdict, "message", raw); err != nil {
+			return s.handleScanError(verdict, err)
+		}
+	}
+
+	for _, att := range message.Attachments {
+		if s.exceedsScanSize(att.Size) {
+			continue
+		}
+		content, err := s.openAttachment(ctx, att)
+		if err != nil {
+			return s.handleScanError(verdict, err)
+		}
+		if content == nil {
+			continue
+		}
+		targets++
+		err = s.scanTarget(ctx, verdict, att.Filename, content)
+		content.Close()
+		if err != nil {
+			return s.handleScanError(verdict, err)
+		}
+	}
+
+	if targets == 0 {
+		verdict.Skipped = true
+		return verdict, nil
+	}
+
+	if !verdict.Infected {
+		return verdict, nil
+	}
+
+	verdict.Action = domain.PolicyActionQuarantine
+	policy, err := s.matchingPolicy(ctx, message.AccountID)
+	if err != nil {
+		return nil, err
+	}
+	if policy != nil {
+		verdict.PolicyID = &policy.ID
+		verdict.Action = policy.Action
+	}
+
+	event := domain.NewBaseEvent(uuid.New().String(), message.ID, domain.EventTypePolicyTriggered, map[string]interface{}{
+		"type":      domain.PolicyTypeVirus,
+		"accountID": message.AccountID,
+		"action":    verdict.Action,
+		"policyID":  verdict.PolicyID,
+		"findings":  verdict.Findings,
+	})
+	if err := s.eventPub.Publish(ctx, event); err != nil {
+		// Log error but don't fail the operation
+	}
+
+	if verdict.Action == domain.PolicyActionBlock {
+		return verdict, errors.VirusDetected(verdict.Findings[0].Signature).WithDetail("message_id", message.ID)
+	}
+
+	return verdict, nil
+}
+
+// Helper functions
+
+func (s *AntivirusService) scanTarget(ctx context.Context, verdict *domain.VirusVerdict, target string, r io.Reader) error {
+	result, err := s.scanner.Scan(ctx, r)
+	if err != nil {
+		return err
+	}
+	if result.Infected {
+		verdict.Infected = true
+		verdict.Findings = append(verdict.Findings, domain.VirusFinding{
+			Target:    target,
+			Signature: result.Signature,
+		})
+	}
+	return nil
+}
+
+// openAttachment returns the content of an attachment, streamed from the
+// blob store once it has moved there, or nil when it has no content
+func (s *AntivirusService) openAttachment(ctx context.Context, att domain.Attachment) (io.ReadCloser, error) {
+	if len(att.Content) > 0 {
+		return io.NopCloser(bytes.NewReader(att.Content)), nil
+	}
+	if att.BlobID == "" {
+		return nil, nil
+	}
+	if s.blobs == nil {
+		return nil, fmt.Errorf("attachment %s is stored as blob %s but no blob reader is configured", att.Filename, att.BlobID)
+	}
+	content, _, err := s.blobs.Open(ctx, att.BlobID, 0, -1)
+	if err != nil {
+		return nil, fmt.Errorf("open attachment %s: %w", att.Filename, err)
+	}
+	return content, nil
+}
+
+func (s *AntivirusService) handleScanError(verdict *domain.VirusVerdict, err error) (*domain.VirusVerdict, error) {
+	reason := err.Error()
+	verdict.Error = &reason
+	if s.config.FailOpen {
+		verdict.Skipped = true
+		return verdict, nil
+	}
+	return verdict, errors.ScanFailed(err)
+}
+
+func (s *AntivirusService) exceedsScanSize(size int64) bool {
+	return s.config.MaxScanSize > 0 && size > s.config.MaxScanSize
+}
+
+// matchingPolicy returns the highest priority active virus policy that
+// applies to the account's owner or domain
+func (s *AntivirusService) matchingPolicy(ctx context.Context, accountID string) (*domain.Policy, error) {
+	account, err := s.accountRepo.GetByID(ctx, accountID)
+	if err != nil {
+		return nil, errors.InternalError(err)
+	}
+	if account == nil {
+		return nil, nil
+	}
+
+	virusType := domain.PolicyTypeVirus
+	active := true
+	candidates := []*domain.Policy{}
+
+	for _, filter := range []repository.PolicyFilter{
+		{UserID: &account.UserID, Type: &virusType, IsActive: &active},
+		{DomainID: &account.DomainID, Type: &virusType, IsActive: &active},
+	} {
+		policies, err := s.policyRepo.GetActivePolicies(ctx, filter)
+		if err != nil {
+			return nil, errors.InternalError(err)
+		}
+		candidates = append(candidates, policies...)
+	}
+	if len(candidates) == 0 {
+		return nil, nil
+	}
+
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return candidates[i].Priority > candidates[j].Priority
+	})
+	return candidates[0], nil
+}
+
+// ClamdScanner streams content to a clamd-compatible daemon using INSTREAM
+type ClamdScanner struct {
+	Network   string // tcp or unix
+	Address   string
+	Timeout   time.Duration
+	ChunkSize int
+}
+
+// NewClamdScanner creates a scanner for the clamd daemon at the given address
+func NewClamdScanner(network, address string, timeout time.Duration, chunkSize int) *ClamdScanner {
+	if chunkSize <= 0 {
+		chunkSize = 64 * 1024
+	}
+	return &ClamdScanner{
+		Network:   network,
+		Address:   address,
+		Timeout:   timeout,
+		ChunkSize: chunkSize,
+	}
+}
+
+// Scan streams r to clamd and parses the verdict
+func (c *ClamdScanner) Scan(ctx context.Context, r io.Reader) (*domain.ScanResult, error) {
+	conn, err := c.dial(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	if _, err := conn.Write([]byte("zINSTREAM\x00")); err != nil {
+		return nil, fmt.Errorf("clamd: write command: %w", err)
+	}
+
+	buf := make([]byte, c.ChunkSize)
+	size := make([]byte, 4)
+	for {
+		n, readErr := r.Read(buf)
+		if n > 0 {
+			binary.BigEndian.PutUint32(size, uint32(n))
+			if _, err := conn.Write(size); err != nil {
+				return nil, fmt.Errorf("clamd: write chunk: %w", err)
+			}
+			if _, err := conn.Write(buf[:n]); err != nil {
+				return nil, fmt.Errorf("clamd: write chunk: %w", err)
+			}
+		}
+		if readErr == io.EOF {
+			break
+		}
+		if readErr != nil {
+			return nil, fmt.Errorf("clamd: read content: %w", readErr)
+		}
+	}
+
+	binary.BigEndian.PutUint32(size, 0)
+	if _, err := conn.Write(size); err != nil {
+		return nil, fmt.Errorf("clamd: write terminator: %w", err)
+	}
+
+	reply, err := readClamdReply(conn)
+	if err != nil {
+		return nil, err
+	}
+	return parseClamdReply(reply)
+}
+
+// Ping checks that the daemon is reachable
+func (c *ClamdScanner) Ping(ctx context.Context) error {
+	conn, err := c.dial(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if _, err := conn.Write([]byte("zPING\x00")); err != nil {
+		return fmt.Errorf("clamd: write command: %w", err)
+	}
+	reply, err := readClamdReply(conn)
+	if err != nil {
+		return err
+	}
+	if reply != "PONG" {
+		return fmt.Errorf("clamd: unexpected ping reply %q", reply)
+	}
+	return nil
+}
+
+func (c *ClamdScanner) dial(ctx context.Context) (net.Conn, error) {
+	dialer := net.Dialer{Timeout: c.Timeout}
+	conn, err := dialer.DialContext(ctx, c.Network, c.Address)
+	if err != nil {
+		return nil, fmt.Errorf("clamd: dial %s %s: %w", c.Network, c.Address, err)
+	}
+	if c.Timeout > 0 {
+		conn.SetDeadline(time.Now().Add(c.Timeout))
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	return conn, nil
+}
+
+func readClamdReply(conn net.Conn) (string, error) {
+	reply, err := bufio.NewReader(conn).ReadString(0)
+	if err != nil && err != io.EOF {
+		return "", fmt.Errorf("clamd: read reply: %w", err)
+	}
+	return strings.TrimSpace(strings.TrimRight(reply, "\x00")), nil
+}
+
+// parseClamdReply parses replies such as "stream: OK" and
+// "stream: Eicar-Signature FOUND"
+func parseClamdReply(reply string) (*domain.ScanResult, error) {
+	switch {
+	case strings.HasSuffix(reply, " FOUND"):
+		signature := strings.TrimSuffix(reply, " FOUND")
+		if idx := strings.Index(signature, ": "); idx >= 0 {
+			signature = signature[idx+2:]
+		}
+		return &domain.ScanResult{Infected: true, Signature: signature}, nil
+	case strings.HasSuffix(reply, " OK"):
+		return &domain.ScanResult{Infected: false}, nil
+	default:
+		return nil, fmt.Errorf("clamd: %s", reply)
+	}
+}
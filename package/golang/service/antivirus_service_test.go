@@ -0,0 +1,203 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	stderrors "errors"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/skygenesisenterprise/aether-mailer/package/golang/domain"
+	"github.com/skygenesisenterprise/aether-mailer/package/golang/errors"
+	"github.com/skygenesisenterprise/aether-mailer/package/golang/repository/inmemory"
+	"github.com/skygenesisenterprise/aether-mailer/package/golang/service/clamdtest"
+)
+
+func TestClamdScannerInstream(t *testing.T) {
+	tests := []struct {
+		name          string
+		content       string
+		failWith      string
+		wantInfected  bool
+		wantSignature string
+		wantErr       string
+	}{
+		{name: "clean", content: "hello world"},
+		{name: "infected", content: "prefix " + clamdtest.EICAR + " suffix", wantInfected: true, wantSignature: "Win.Test.EICAR_HDB-1"},
+		{name: "error", content: "hello world", failWith: "Can't allocate memory ERROR", wantErr: "allocate memory"},
+		{name: "oversize", content: strings.Repeat("x", 1000), failWith: "INSTREAM size limit exceeded. ERROR", wantErr: "size limit exceeded"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			daemon := clamdtest.NewServer()
+			defer daemon.Close()
+			daemon.FailWith(tt.failWith)
+
+			// A small chunk size streams the content in several chunks
+			scanner := NewClamdScanner(daemon.Network, daemon.Addr, 5*time.Second, 16)
+			result, err := scanner.Scan(context.Background(), strings.NewReader(tt.content))
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("Scan() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Scan() error = %v", err)
+			}
+			if result.Infected != tt.wantInfected || result.Signature != tt.wantSignature {
+				t.Errorf("Scan() = %+v, want infected %v signature %q", result, tt.wantInfected, tt.wantSignature)
+			}
+			if daemon.Scans() != 1 {
+				t.Errorf("daemon served %d scans, want 1", daemon.Scans())
+			}
+		})
+	}
+}
+
+func TestClamdScannerPing(t *testing.T) {
+	daemon := clamdtest.NewServer()
+	scanner := NewClamdScanner(daemon.Network, daemon.Addr, 5*time.Second, 0)
+	if err := scanner.Ping(context.Background()); err != nil {
+		t.Fatalf("Ping() error = %v", err)
+	}
+
+	daemon.Close()
+	if err := scanner.Ping(context.Background()); err == nil {
+		t.Fatal("Ping() of a stopped daemon succeeded")
+	}
+}
+
+func TestAntivirusServiceScanMessage(t *testing.T) {
+	blobs := fakeBlobReader{
+		"blob-clean":    []byte("quarterly report"),
+		"blob-infected": []byte(clamdtest.EICAR),
+	}
+
+	tests := []struct {
+		name         string
+		attachments  []domain.Attachment
+		blobs        BlobReader
+		maxScanSize  int64
+		failWith     string
+		failOpen     bool
+		wantInfected bool
+		wantSkipped  bool
+		wantScans    int
+		wantErrCode  errors.ErrorCode
+	}{
+		{
+			name:        "clean blob attachment",
+			attachments: []domain.Attachment{{Filename: "report.pdf", Size: 16, BlobID: "blob-clean"}},
+			blobs:       blobs,
+			wantScans:   1,
+		},
+		{
+			name:         "infected blob attachment",
+			attachments:  []domain.Attachment{{Filename: "invoice.exe", Size: 68, BlobID: "blob-infected"}},
+			blobs:        blobs,
+			wantInfected: true,
+			wantScans:    1,
+		},
+		{
+			name:         "infected inline attachment",
+			attachments:  []domain.Attachment{{Filename: "eicar.com", Size: 68, Content: []byte(clamdtest.EICAR)}},
+			wantInfected: true,
+			wantScans:    1,
+		},
+		{
+			name:        "oversize attachment skipped",
+			attachments: []domain.Attachment{{Filename: "huge.iso", Size: 1 << 30, BlobID: "blob-infected"}},
+			blobs:       blobs,
+			maxScanSize: 1 << 20,
+			wantSkipped: true,
+		},
+		{
+			name:        "blob attachment without blob reader",
+			attachments: []domain.Attachment{{Filename: "invoice.exe", Size: 68, BlobID: "blob-infected"}},
+			wantErrCode: errors.ErrCodeScanFailed,
+		},
+		{
+			name:        "missing blob",
+			attachments: []domain.Attachment{{Filename: "gone.pdf", Size: 10, BlobID: "blob-missing"}},
+			blobs:       blobs,
+			wantErrCode: errors.ErrCodeScanFailed,
+		},
+		{
+			name:        "scanner error fails closed",
+			attachments: []domain.Attachment{{Filename: "report.pdf", Size: 16, BlobID: "blob-clean"}},
+			blobs:       blobs,
+			failWith:    "INSTREAM size limit exceeded. ERROR",
+			wantErrCode: errors.ErrCodeScanFailed,
+			wantScans:   1,
+		},
+		{
+			name:        "scanner error fails open",
+			attachments: []domain.Attachment{{Filename: "report.pdf", Size: 16, BlobID: "blob-clean"}},
+			blobs:       blobs,
+			failWith:    "INSTREAM size limit exceeded. ERROR",
+			failOpen:    true,
+			wantSkipped: true,
+			wantScans:   1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			daemon := clamdtest.NewServer()
+			defer daemon.Close()
+			daemon.FailWith(tt.failWith)
+
+			store := inmemory.NewStore()
+			antivirus := NewAntivirusService(
+				NewClamdScanner(daemon.Network, daemon.Addr, 5*time.Second, 0),
+				tt.blobs,
+				inmemory.NewEmailAccountRepository(store),
+				inmemory.NewPolicyRepository(store),
+				inmemory.NewEventPublisher(inmemory.NewEventStore()),
+				&AntivirusConfig{EnableVirusScan: true, MaxScanSize: tt.maxScanSize, FailOpen: tt.failOpen},
+			)
+
+			message := &domain.Message{ID: "message-1", AccountID: "account-1", Attachments: tt.attachments}
+			verdict, err := antivirus.ScanMessage(context.Background(), message, nil)
+			if tt.wantErrCode != "" {
+				var mailErr *errors.Error
+				if !stderrors.As(err, &mailErr) || mailErr.Code != tt.wantErrCode {
+					t.Fatalf("ScanMessage() error = %v, want %s", err, tt.wantErrCode)
+				}
+			} else if err != nil {
+				t.Fatalf("ScanMessage() error = %v", err)
+			} else {
+				if verdict.Infected != tt.wantInfected || verdict.Skipped != tt.wantSkipped {
+					t.Errorf("ScanMessage() infected %v skipped %v, want %v %v",
+						verdict.Infected, verdict.Skipped, tt.wantInfected, tt.wantSkipped)
+				}
+				if tt.wantInfected {
+					if verdict.Action != domain.PolicyActionQuarantine {
+						t.Errorf("action = %s, want %s", verdict.Action, domain.PolicyActionQuarantine)
+					}
+					if len(verdict.Findings) != 1 || verdict.Findings[0].Target != tt.attachments[0].Filename {
+						t.Errorf("findings = %+v, want one for %s", verdict.Findings, tt.attachments[0].Filename)
+					}
+				}
+			}
+			if daemon.Scans() != tt.wantScans {
+				t.Errorf("daemon served %d scans, want %d", daemon.Scans(), tt.wantScans)
+			}
+		})
+	}
+}
+
+// fakeBlobReader serves blobs from memory by ID
+type fakeBlobReader map[string][]byte
+
+func (f fakeBlobReader) Open(ctx context.Context, id string, offset, length int64) (io.ReadCloser, *domain.Blob, error) {
+	content, ok := f[id]
+	if !ok {
+		return nil, nil, errors.BlobNotFound(id)
+	}
+	return io.NopCloser(bytes.NewReader(content)), &domain.Blob{ID: id, Size: int64(len(content))}, nil
+}
@@ -0,0 +1,409 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/skygenesisenterprise/aether-mailer/package/golang/domain"
+	"github.com/skygenesisenterprise/aether-mailer/package/golang/errors"
+)
+
+// ARC header field names (RFC 8617)
+const (
+	headerARCSeal                  = "ARC-Seal"
+	headerARCMessageSignature      = "ARC-Message-Signature"
+	headerARCAuthenticationResults = "ARC-Authentication-Results"
+)
+
+// defaultARCSignedHeaders are the fields covered by ARC-Message-Signature
+// when the configuration does not list its own
+var defaultARCSignedHeaders = []string{
+	"from", "to", "cc", "subject", "date", "message-id", "reply-to", "in-reply-to", "references",
+	"mime-version", "content-type", "content-transfer-encoding", "dkim-signature",
+	"list-id", "list-post", "list-unsubscribe", "list-unsubscribe-post",
+}
+
+// ARCService adds ARC sets (RFC 8617) to forwarded and list traffic with the
+// domain's active DKIM key, and validates the ARC chain of inbound messages
+// so a DMARC failure caused by a trusted intermediary can be overridden.
+type ARCService struct {
+	keys     ARCKeyProvider
+	resolver TXTResolver
+	config   *ARCConfig
+}
+
+// ARCConfig defines ARC sealing and validation settings
+type ARCConfig struct {
+	AuthServID     string   // authserv-id written in ARC-Authentication-Results, the sealing domain when empty
+	TrustedSealers []string // sealing domains whose chains may override a DMARC failure; subdomains match
+	SignedHeaders  []string // fields covered by ARC-Message-Signature, a common set when empty
+}
+
+// ARCKeyProvider returns the signing key of a domain. *DKIMService satisfies it.
+type ARCKeyProvider interface {
+	SigningKey(ctx context.Context, domainName string) (*domain.DKIMSigningKey, error)
+}
+
+// NewARCService creates a new ARC service
+func NewARCService(keys ARCKeyProvider, resolver TXTResolver, config *ARCConfig) *ARCService {
+	return &ARCService{
+		keys:     keys,
+		resolver: resolver,
+		config:   config,
+	}
+}
+
+// arcSet groups the three header fields of one ARC instance
+type arcSet struct {
+	instance  int
+	results   *rawHeader
+	signature *rawHeader
+	seal      *rawHeader
+}
+
+// Seal adds an ARC set for sealingDomain on top of a forwarded or expanded
+// message. authResults is the Authentication-Results payload of the inbound
+// hop, such as "spf=pass smtp.mailfrom=example.com; dkim=pass header.d=example.com".
+// The message is returned unchanged when the domain has no active DKIM key,
+// when the existing chain already failed or when it reached the instance limit.
+func (s *ARCService) Seal(ctx context.Context, data []byte, sealingDomain, authResults string) ([]byte, error) {
+	sealingDomain = strings.ToLower(strings.TrimSuffix(sealingDomain, "."))
+	key, err := s.keys.SigningKey(ctx, sealingDomain)
+	if err != nil {
+		return nil, err
+	}
+	if key == nil {
+		return data, nil
+	}
+
+	headers, body := splitMessage(data)
+	sets, err := collectARCSets(headers)
+	if err != nil {
+		// A malformed chain cannot be extended
+		return data, nil
+	}
+	if len(sets) > 0 && strings.EqualFold(parseTagList(sets[len(sets)-1].seal.value())["cv"], string(domain.ARCChainFail)) {
+		// A chain that already ended in cv=fail must not be extended
+		return data, nil
+	}
+	instance := len(sets) + 1
+	if instance > domain.ARCMaxInstances {
+		return data, nil
+	}
+
+	// The new seal records how the chain validated on arrival
+	cv := domain.ARCChainNone
+	if len(sets) > 0 {
+		cv = s.validate(ctx, headers, body).Status
+	}
+
+	algorithm := signatureAlgorithm(key.Algorithm)
+	timestamp := time.Now().Unix()
+
+	authServID := s.config.AuthServID
+	if authServID == "" {
+		authServID = sealingDomain
+	}
+	if strings.TrimSpace(authResults) == "" {
+		authResults = "none"
+	}
+	results := rawHeader{
+		name: headerARCAuthenticationResults,
+		raw:  fmt.Sprintf("%s: i=%d; %s; %s", headerARCAuthenticationResults, instance, authServID, authResults),
+	}
+
+	// The message signature covers the message as forwarded, never the ARC
+	// fields themselves
+	signedNames := s.signedHeaderNames(headers)
+	signature := rawHeader{
+		name: headerARCMessageSignature,
+		raw: fmt.Sprintf("%s: i=%d; a=%s; c=relaxed/relaxed; d=%s; s=%s;\r\n\tt=%d; h=%s;\r\n\tbh=%s;\r\n\tb=",
+			headerARCMessageSignature, instance, algorithm, key.Domain, key.Selector,
+			timestamp, strings.Join(signedNames, ":"), bodyHash(body)),
+	}
+	b, err := signDKIM(key.PrivateKey, selectSignedHeaders(headers, signedNames, canonicalizeHeaderRelaxed)+canonicalizeHeaderRelaxed(signature.raw))
+	if err != nil {
+		return nil, errors.InternalError(fmt.Errorf("signing ARC-Message-Signature: %w", err))
+	}
+	signature.raw += foldBase64(b)
+
+	// The seal covers every ARC set in instance order, ending with itself
+	seal := rawHeader{
+		name: headerARCSeal,
+		raw: fmt.Sprintf("%s: i=%d; a=%s; t=%d; cv=%s;\r\n\td=%s; s=%s;\r\n\tb=",
+			headerARCSeal, instance, algorithm, timestamp, cv, key.Domain, key.Selector),
+	}
+	sets = append(sets, &arcSet{instance: instance, results: &results, signature: &signature, seal: &seal})
+	b, err = signDKIM(key.PrivateKey, sealInput(sets))
+	if err != nil {
+		return nil, errors.InternalError(fmt.Errorf("signing ARC-Seal: %w", err))
+	}
+	seal.raw += foldBase64(b)
+
+	sealed := append([]rawHeader{seal, signature, results}, headers...)
+	return joinMessage(sealed, body), nil
+}
+
+// Validate validates the ARC chain of a raw message
+func (s *ARCService) Validate(ctx context.Context, data []byte) *domain.ARCValidation {
+	headers, body := splitMessage(data)
+	return s.validate(ctx, headers, body)
+}
+
+// Evaluate validates the ARC chain of an inbound message and records the
+// outcome in auth. When DMARC failed, the chain passes and a trusted sealer
+// reported dmarc=pass in its ARC-Authentication-Results, the sealer is
+// recorded in auth.DMARCOverride and spam scoring replaces the DMARC
+// failure with the lighter override rule.
+func (s *ARCService) Evaluate(ctx context.Context, data []byte, auth *domain.AuthenticationResults) *domain.ARCValidation {
+	validation := s.Validate(ctx, data)
+	if auth == nil {
+		return validation
+	}
+
+	switch validation.Status {
+	case domain.ARCChainPass:
+		auth.ARC = domain.AuthResultPass
+	case domain.ARCChainFail:
+		auth.ARC = domain.AuthResultFail
+	default:
+		auth.ARC = domain.AuthResultNone
+	}
+
+	if auth.DMARC != domain.AuthResultFail || validation.Status != domain.ARCChainPass {
+		return validation
+	}
+	for i := len(validation.Hops) - 1; i >= 0; i-- {
+		hop := validation.Hops[i]
+		if !s.IsTrustedSealer(hop.Sealer) {
+			continue
+		}
+		reported := ParseAuthenticationResults(hop.AuthenticationResults)
+		if reported != nil && reported.DMARC == domain.AuthResultPass {
+			auth.DMARCOverride = hop.Sealer
+			break
+		}
+	}
+	return validation
+}
+
+// IsTrustedSealer reports whether a sealing domain, or one of its parent
+// domains, is in the trusted sealers list
+func (s *ARCService) IsTrustedSealer(sealer string) bool {
+	sealer = strings.ToLower(strings.TrimSuffix(sealer, "."))
+	if sealer == "" {
+		return false
+	}
+	for _, trusted := range s.config.TrustedSealers {
+		trusted = strings.ToLower(strings.TrimSuffix(strings.TrimSpace(trusted), "."))
+		if trusted != "" && (sealer == trusted || strings.HasSuffix(sealer, "."+trusted)) {
+			return true
+		}
+	}
+	return false
+}
+
+// validate runs the chain validation of RFC 8617 section 5.2
+func (s *ARCService) validate(ctx context.Context, headers []rawHeader, body []byte) *domain.ARCValidation {
+	sets, err := collectARCSets(headers)
+	if err != nil {
+		return &domain.ARCValidation{Status: domain.ARCChainFail, Reason: err.Error()}
+	}
+	if len(sets) == 0 {
+		return &domain.ARCValidation{Status: domain.ARCChainNone, Hops: []domain.ARCHop{}}
+	}
+
+	validation := &domain.ARCValidation{Status: domain.ARCChainFail, Hops: make([]domain.ARCHop, 0, len(sets))}
+	seals := make([]map[string]string, len(sets))
+	for i, set := range sets {
+		seals[i] = parseTagList(set.seal.value())
+		_, payload, _ := strings.Cut(set.results.value(), ";")
+		validation.Hops = append(validation.Hops, domain.ARCHop{
+			Instance:              set.instance,
+			Sealer:                strings.ToLower(seals[i]["d"]),
+			Selector:              seals[i]["s"],
+			AuthenticationResults: strings.TrimSpace(payload),
+		})
+	}
+
+	latest := seals[len(seals)-1]
+	if strings.EqualFold(latest["cv"], string(domain.ARCChainFail)) {
+		validation.Reason = fmt.Sprintf("instance %d sealed with cv=fail", len(sets))
+		return validation
+	}
+	for i, tags := range seals {
+		expected := domain.ARCChainPass
+		if i == 0 {
+			expected = domain.ARCChainNone
+		}
+		if !strings.EqualFold(tags["cv"], string(expected)) {
+			validation.Reason = fmt.Sprintf("instance %d has cv=%s, expected %s", i+1, tags["cv"], expected)
+			return validation
+		}
+	}
+
+	// Only the most recent message signature has to match the message as
+	// received; earlier ones were broken by the intermediaries by design
+	newest := sets[len(sets)-1]
+	signatureTags := parseTagList(newest.signature.value())
+	canon, err := parseCanonicalization(signatureTags["c"])
+	if err != nil {
+		validation.Reason = fmt.Sprintf("instance %d message signature: %v", newest.instance, err)
+		return validation
+	}
+	canonicalBody := canon.canonicalizeBody(body)
+	if limit, ok := signatureTags["l"]; ok {
+		// Content appended below a body length limit would pass unsigned,
+		// so a limit is only accepted when it covers the whole body
+		length, err := strconv.ParseInt(limit, 10, 64)
+		if err != nil || length != int64(len(canonicalBody)) {
+			validation.Reason = fmt.Sprintf("instance %d body length l=%s does not cover the %d byte body", newest.instance, limit, len(canonicalBody))
+			return validation
+		}
+	}
+	if signatureTags["bh"] != hashCanonicalBody(canonicalBody) {
+		validation.Reason = fmt.Sprintf("instance %d body hash mismatch", newest.instance)
+		return validation
+	}
+	signed := selectSignedHeaders(withoutARC(headers), strings.Split(signatureTags["h"], ":"), canon.canonicalizeHeader) +
+		canon.canonicalizeHeader(stripSignature(newest.signature.raw))
+	if err := verifyDKIM(ctx, s.resolver, signatureTags["d"], signatureTags["s"], signatureTags["a"], signed, signatureTags["b"]); err != nil {
+		validation.Reason = fmt.Sprintf("instance %d message signature: %v", newest.instance, err)
+		return validation
+	}
+
+	for i := len(sets) - 1; i >= 0; i-- {
+		tags := seals[i]
+		if err := verifyDKIM(ctx, s.resolver, tags["d"], tags["s"], tags["a"], sealInput(sets[:i+1]), tags["b"]); err != nil {
+			validation.Reason = fmt.Sprintf("instance %d seal: %v", i+1, err)
+			return validation
+		}
+	}
+
+	validation.Status = domain.ARCChainPass
+	return validation
+}
+
+// signedHeaderNames returns the configured fields present in the message
+func (s *ARCService) signedHeaderNames(headers []rawHeader) []string {
+	names := s.config.SignedHeaders
+	if len(names) == 0 {
+		names = defaultARCSignedHeaders
+	}
+
+	present := make(map[string]bool)
+	for _, header := range headers {
+		present[strings.ToLower(header.name)] = true
+	}
+	signed := []string{}
+	for _, name := range names {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if present[name] && !isARCHeader(name) {
+			signed = append(signed, name)
+		}
+	}
+	return signed
+}
+
+// Helper functions
+
+func isARCHeader(name string) bool {
+	return strings.EqualFold(name, headerARCSeal) ||
+		strings.EqualFold(name, headerARCMessageSignature) ||
+		strings.EqualFold(name, headerARCAuthenticationResults)
+}
+
+func withoutARC(headers []rawHeader) []rawHeader {
+	filtered := make([]rawHeader, 0, len(headers))
+	for _, header := range headers {
+		if !isARCHeader(header.name) {
+			filtered = append(filtered, header)
+		}
+	}
+	return filtered
+}
+
+// collectARCSets groups the ARC fields of a message by instance and checks
+// that instances run from 1 without gaps, each with exactly one field of
+// every kind
+func collectARCSets(headers []rawHeader) ([]*arcSet, error) {
+	byInstance := make(map[int]*arcSet)
+	for i := range headers {
+		header := &headers[i]
+		if !isARCHeader(header.name) {
+			continue
+		}
+		instance, err := arcInstance(header.value())
+		if err != nil || instance < 1 || instance > domain.ARCMaxInstances {
+			return nil, fmt.Errorf("%s has an invalid instance", header.name)
+		}
+		set, ok := byInstance[instance]
+		if !ok {
+			set = &arcSet{instance: instance}
+			byInstance[instance] = set
+		}
+
+		var slot **rawHeader
+		switch {
+		case strings.EqualFold(header.name, headerARCSeal):
+			slot = &set.seal
+		case strings.EqualFold(header.name, headerARCMessageSignature):
+			slot = &set.signature
+		default:
+			slot = &set.results
+		}
+		if *slot != nil {
+			return nil, fmt.Errorf("instance %d has more than one %s", instance, header.name)
+		}
+		*slot = header
+	}
+
+	sets := make([]*arcSet, 0, len(byInstance))
+	for _, set := range byInstance {
+		sets = append(sets, set)
+	}
+	sort.Slice(sets, func(i, j int) bool { return sets[i].instance < sets[j].instance })
+	for i, set := range sets {
+		if set.instance != i+1 {
+			return nil, fmt.Errorf("instance %d is missing", i+1)
+		}
+		if set.seal == nil || set.signature == nil || set.results == nil {
+			return nil, fmt.Errorf("instance %d is incomplete", set.instance)
+		}
+	}
+	return sets, nil
+}
+
+// arcInstance reads the leading i= tag of an ARC field
+func arcInstance(value string) (int, error) {
+	first, _, _ := strings.Cut(value, ";")
+	name, instance, ok := strings.Cut(first, "=")
+	if !ok || strings.TrimSpace(name) != "i" {
+		return 0, fmt.Errorf("missing instance tag")
+	}
+	return strconv.Atoi(strings.TrimSpace(instance))
+}
+
+// sealInput builds the data signed by the ARC-Seal of the last set: every
+// set in instance order as results, message signature and seal, with the
+// b= value of the last seal emptied
+func sealInput(sets []*arcSet) string {
+	var buf strings.Builder
+	for i, set := range sets {
+		buf.WriteString(canonicalizeHeaderRelaxed(set.results.raw))
+		buf.WriteString("\r\n")
+		buf.WriteString(canonicalizeHeaderRelaxed(set.signature.raw))
+		buf.WriteString("\r\n")
+		if i == len(sets)-1 {
+			buf.WriteString(canonicalizeHeaderRelaxed(stripSignature(set.seal.raw)))
+		} else {
+			buf.WriteString(canonicalizeHeaderRelaxed(set.seal.raw))
+			buf.WriteString("\r\n")
+		}
+	}
+	return buf.String()
+}
@@ -0,0 +1,158 @@
+package service
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/skygenesisenterprise/aether-mailer/package/golang/domain"
+)
+
+const testARCMessage = "From: Alice <alice@example.com>\r\n" +
+	"Subject: Quarterly   report\r\n" +
+	"\r\n" +
+	"Figures attached.  \r\n" +
+	"\r\n"
+
+func TestARCServiceValidateCanonicalization(t *testing.T) {
+	publicKey, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	arc := NewARCService(nil, newTestARCResolver(publicKey), &ARCConfig{})
+
+	tests := []struct {
+		name       string
+		c          string // c= tag, omitted when empty
+		limit      int    // l= tag, omitted when negative
+		modify     func(string) string
+		wantStatus domain.ARCChainStatus
+		wantReason string
+	}{
+		{name: "relaxed", c: "relaxed/relaxed", limit: -1, wantStatus: domain.ARCChainPass},
+		{name: "relaxed tolerates whitespace", c: "relaxed/relaxed", limit: -1, modify: func(m string) string {
+			return strings.Replace(strings.Replace(m, "Quarterly   report", "Quarterly report", 1), "attached.  ", "attached.", 1)
+		}, wantStatus: domain.ARCChainPass},
+		{name: "simple", c: "simple/simple", limit: -1, wantStatus: domain.ARCChainPass},
+		{name: "simple ignores trailing empty lines", c: "simple/simple", limit: -1, modify: func(m string) string {
+			return m + "\r\n\r\n"
+		}, wantStatus: domain.ARCChainPass},
+		{name: "simple header rejects whitespace change", c: "simple/relaxed", limit: -1, modify: func(m string) string {
+			return strings.Replace(m, "Quarterly   report", "Quarterly report", 1)
+		}, wantStatus: domain.ARCChainFail, wantReason: "message signature"},
+		{name: "simple body rejects whitespace change", c: "relaxed/simple", limit: -1, modify: func(m string) string {
+			return strings.Replace(m, "attached.  ", "attached.", 1)
+		}, wantStatus: domain.ARCChainFail, wantReason: "body hash mismatch"},
+		{name: "defaults to simple", limit: -1, wantStatus: domain.ARCChainPass},
+		{name: "unknown algorithm", c: "relaxed/strict", limit: -1, wantStatus: domain.ARCChainFail, wantReason: "unsupported canonicalization"},
+		{name: "length covering the body", c: "relaxed/relaxed", limit: len(canonicalizeBodyRelaxed([]byte("Figures attached.  \r\n\r\n"))), wantStatus: domain.ARCChainPass},
+		{name: "length leaving content unsigned", c: "relaxed/relaxed", limit: 8, wantStatus: domain.ARCChainFail, wantReason: "body length"},
+		{name: "content appended after the length", c: "relaxed/relaxed", limit: len(canonicalizeBodyRelaxed([]byte("Figures attached.  \r\n\r\n"))), modify: func(m string) string {
+			return m + "Click https://evil.example\r\n"
+		}, wantStatus: domain.ARCChainFail, wantReason: "body length"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			message := sealTestARCMessage(t, privateKey, testARCMessage, tt.c, tt.limit)
+			if tt.modify != nil {
+				message = tt.modify(message)
+			}
+
+			validation := arc.Validate(context.Background(), []byte(message))
+			if validation.Status != tt.wantStatus {
+				t.Fatalf("Validate() = %s (%s), want %s", validation.Status, validation.Reason, tt.wantStatus)
+			}
+			if !strings.Contains(validation.Reason, tt.wantReason) {
+				t.Errorf("Validate() reason = %q, want %q", validation.Reason, tt.wantReason)
+			}
+		})
+	}
+}
+
+func TestARCServiceSealValidates(t *testing.T) {
+	publicKey, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys := fakeARCKeys{&domain.DKIMSigningKey{Domain: "example.org", Selector: "arc", Algorithm: domain.DKIMAlgorithmEd25519, PrivateKey: privateKey}}
+	arc := NewARCService(keys, newTestARCResolver(publicKey), &ARCConfig{})
+
+	sealed, err := arc.Seal(context.Background(), []byte(testARCMessage), "example.org", "dmarc=pass header.from=example.com")
+	if err != nil {
+		t.Fatalf("Seal() error = %v", err)
+	}
+	sealed, err = arc.Seal(context.Background(), sealed, "example.org", "arc=pass")
+	if err != nil {
+		t.Fatalf("Seal() error = %v", err)
+	}
+
+	validation := arc.Validate(context.Background(), sealed)
+	if validation.Status != domain.ARCChainPass || len(validation.Hops) != 2 {
+		t.Fatalf("Validate() = %s with %d hops (%s), want pass with 2", validation.Status, len(validation.Hops), validation.Reason)
+	}
+}
+
+// sealTestARCMessage adds a first ARC set signed with the given c= tag and,
+// when limit is not negative, an l= tag
+func sealTestARCMessage(t *testing.T, privateKey ed25519.PrivateKey, message, c string, limit int) string {
+	t.Helper()
+	headers, body := splitMessage([]byte(message))
+
+	canon, err := parseCanonicalization(c)
+	if err != nil {
+		// Sign invalid tags relaxed, the validator must refuse them anyway
+		canon = canonicalization{header: canonicalizationRelaxed, body: canonicalizationRelaxed}
+	}
+	canonicalBody := canon.canonicalizeBody(body)
+	tags := ""
+	if c != "" {
+		tags += " c=" + c + ";"
+	}
+	if limit >= 0 {
+		tags += fmt.Sprintf(" l=%d;", limit)
+		if limit < len(canonicalBody) {
+			canonicalBody = canonicalBody[:limit]
+		}
+	}
+
+	signature := rawHeader{
+		name: headerARCMessageSignature,
+		raw: fmt.Sprintf("%s: i=1; a=ed25519-sha256;%s d=example.org; s=arc;\r\n\th=from:subject; bh=%s; b=",
+			headerARCMessageSignature, tags, hashCanonicalBody(canonicalBody)),
+	}
+	b, err := signDKIM(privateKey, selectSignedHeaders(headers, []string{"from", "subject"}, canon.canonicalizeHeader)+canon.canonicalizeHeader(signature.raw))
+	if err != nil {
+		t.Fatal(err)
+	}
+	signature.raw += b
+
+	results := rawHeader{name: headerARCAuthenticationResults, raw: headerARCAuthenticationResults + ": i=1; example.org; dmarc=pass"}
+	seal := rawHeader{name: headerARCSeal, raw: headerARCSeal + ": i=1; a=ed25519-sha256; cv=none; d=example.org; s=arc; t=1; b="}
+	b, err = signDKIM(privateKey, sealInput([]*arcSet{{instance: 1, results: &results, signature: &signature, seal: &seal}}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	seal.raw += b
+
+	return string(joinMessage(append([]rawHeader{seal, signature, results}, headers...), body))
+}
+
+func newTestARCResolver(publicKey ed25519.PublicKey) *fakeTLSResolver {
+	return &fakeTLSResolver{txt: map[string][]string{
+		"arc._domainkey.example.org": {"v=DKIM1; k=ed25519; p=" + base64.StdEncoding.EncodeToString(publicKey)},
+	}}
+}
+
+// fakeARCKeys returns the same signing key for every domain
+type fakeARCKeys struct {
+	key *domain.DKIMSigningKey
+}
+
+func (f fakeARCKeys) SigningKey(ctx context.Context, domainName string) (*domain.DKIMSigningKey, error) {
+	return f.key, nil
+}
@@ -0,0 +1,597 @@
+package service
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/skygenesisenterprise/aether-mailer/package/golang/domain"
+	"github.com/skygenesisenterprise/aether-mailer/package/golang/errors"
+)
+
+// Attachment policy rule names reported in policy hits
+const (
+	RuleExtensionNotAllowed = "EXTENSION_NOT_ALLOWED"
+	RuleMimeTypeNotAllowed  = "MIME_TYPE_NOT_ALLOWED"
+	RuleBlockedExtension    = "BLOCKED_EXTENSION"
+	RuleDoubleExtension     = "DOUBLE_EXTENSION"
+	RuleDeceptiveFilename   = "DECEPTIVE_FILENAME"
+	RuleExecutableContent   = "EXECUTABLE_CONTENT"
+	RuleMimeTypeMismatch    = "MIME_TYPE_MISMATCH"
+	RuleExtensionMismatch   = "EXTENSION_MISMATCH"
+	RuleEncryptedContent    = "ENCRYPTED_CONTENT"
+	RuleMacroDocument       = "MACRO_DOCUMENT"
+	RuleArchiveTooDeep      = "ARCHIVE_TOO_DEEP"
+	RuleArchiveTooLarge     = "ARCHIVE_TOO_LARGE"
+	RuleArchiveTooMany      = "ARCHIVE_TOO_MANY_ENTRIES"
+	RuleArchiveUnreadable   = "ARCHIVE_UNREADABLE"
+)
+
+// DefaultBlockedExtensions lists extensions that can execute code when opened
+var DefaultBlockedExtensions = []string{
+	".ade", ".adp", ".app", ".appx", ".bat", ".cab", ".chm", ".cmd", ".com", ".cpl",
+	".dll", ".exe", ".gadget", ".hta", ".inf", ".ins", ".iso", ".img", ".isp", ".jar",
+	".js", ".jse", ".lib", ".lnk", ".mde", ".msc", ".msi", ".msix", ".msp", ".mst",
+	".nsh", ".pif", ".ps1", ".reg", ".scr", ".sct", ".shb", ".sys", ".vb", ".vbe",
+	".vbs", ".vhd", ".vhdx", ".vxd", ".wsc", ".wsf", ".wsh", ".xll",
+}
+
+// macroExtensions lists macro-enabled Office formats
+var macroExtensions = map[string]bool{
+	".docm": true, ".dotm": true, ".xlsm": true, ".xltm": true, ".xlam": true,
+	".pptm": true, ".potm": true, ".ppsm": true, ".ppam": true, ".sldm": true,
+}
+
+// AttachmentPolicyService applies attachment policies to inbound and outbound mail
+type AttachmentPolicyService struct {
+	blobs    BlobReader
+	eventPub domain.EventPublisher
+	config   *AttachmentPolicyConfig
+}
+
+// AttachmentPolicyConfig defines attachment policy configuration
+type AttachmentPolicyConfig struct {
+	EnableAttachmentPolicy bool
+	AllowedExtensions      []string // top-level extensions allowed; empty allows all that are not blocked
+	AllowedMimeTypes       []string // detected types allowed, "image/*" wildcards; empty allows all
+	BlockedExtensions      []string
+	MaxArchiveDepth        int
+	MaxArchiveSize         int64 // uncompressed bytes inspected per attachment
+	MaxArchiveEntries      int
+	BlockedAction          domain.PolicyAction // disallowed or blocked files and executables
+	MismatchAction         domain.PolicyAction // declared or implied type differs from content
+	EncryptedAction        domain.PolicyAction // encrypted archives and documents
+	MacroAction            domain.PolicyAction // macro-enabled Office documents
+	ArchiveLimitAction     domain.PolicyAction // archives that exceed limits or cannot be read
+}
+
+// NewAttachmentPolicyService creates a new attachment policy service. blobs
+// is optional; without it, attachments kept in the blob store are only
+// checked by name and declared type.
+func NewAttachmentPolicyService(
+	blobs BlobReader,
+	eventPub domain.EventPublisher,
+	config *AttachmentPolicyConfig,
+) *AttachmentPolicyService {
+	return &AttachmentPolicyService{
+		blobs:    blobs,
+		eventPub: eventPub,
+		config:   config,
+	}
+}
+
+// CheckAttachments inspects every attachment of a message. Blocked messages
+// return a policy violation error together with the verdict; other actions
+// are left to the caller.
+func (s *AttachmentPolicyService) CheckAttachments(ctx context.Context, message *domain.Message, direction domain.MessageDirection) (*domain.AttachmentVerdict, error) {
+	verdict := &domain.AttachmentVerdict{
+		MessageID: message.ID,
+		AccountID: message.AccountID,
+		Direction: direction,
+		Hits:      []domain.PolicyHit{},
+		Action:    domain.PolicyActionAllow,
+		CheckedAt: time.Now(),
+	}
+
+	if !s.config.EnableAttachmentPolicy {
+		return verdict, nil
+	}
+
+	for _, att := range message.Attachments {
+		hits, err := s.inspectStored(ctx, att)
+		if err != nil {
+			return nil, err
+		}
+		verdict.Hits = append(verdict.Hits, hits...)
+	}
+	if len(verdict.Hits) == 0 {
+		return verdict, nil
+	}
+
+	for _, hit := range verdict.Hits {
+		if actionSeverity(hit.Action) > actionSeverity(verdict.Action) {
+			verdict.Action = hit.Action
+		}
+	}
+
+	// Publish event
+	event := domain.NewBaseEvent(uuid.New().String(), message.ID, domain.EventTypePolicyTriggered, map[string]interface{}{
+		"type":      domain.PolicyTypeContent,
+		"accountID": message.AccountID,
+		"direction": direction,
+		"action":    verdict.Action,
+		"hits":      verdict.Hits,
+	})
+	if err := s.eventPub.Publish(ctx, event); err != nil {
+		// Log error but don't fail the operation
+	}
+
+	if verdict.Action == domain.PolicyActionBlock {
+		hit := strongestHit(verdict.Hits)
+		return verdict, errors.PolicyViolation(hit.Rule, hit.Description).
+			WithDetail("message_id", message.ID).
+			WithDetail("target", hit.Target)
+	}
+
+	return verdict, nil
+}
+
+// InspectAttachment applies the attachment policies to a single file
+func (s *AttachmentPolicyService) InspectAttachment(filename, declaredType string, content []byte) []domain.PolicyHit {
+	return s.inspect(filename, declaredType, bytes.NewReader(content), int64(len(content)))
+}
+
+// inspect applies the attachment policies to content read at random, so
+// stored blobs are inspected without loading them whole
+func (s *AttachmentPolicyService) inspect(filename, declaredType string, content io.ReaderAt, size int64) []domain.PolicyHit {
+	inspection := &attachmentInspection{
+		config: s.config,
+		hits:   []domain.PolicyHit{},
+		budget: s.config.MaxArchiveSize,
+	}
+
+	ext := fileExtension(filename)
+	detected := sniffContent(content, size)
+
+	if len(s.config.AllowedExtensions) > 0 && !containsFold(s.config.AllowedExtensions, ext) {
+		inspection.hit(RuleExtensionNotAllowed, filename, s.config.BlockedAction,
+			fmt.Sprintf("Extension %q is not allowed", ext))
+	}
+
+	allowedType := detected
+	if allowedType == mimeOctet && declaredType != "" {
+		allowedType = normalizeMimeType(declaredType)
+	}
+	if len(s.config.AllowedMimeTypes) > 0 && !mimeTypeAllowed(s.config.AllowedMimeTypes, allowedType) {
+		inspection.hit(RuleMimeTypeNotAllowed, filename, s.config.BlockedAction,
+			fmt.Sprintf("Content type %q is not allowed", allowedType))
+	}
+
+	if !typesCompatible(declaredType, detected) {
+		inspection.hit(RuleMimeTypeMismatch, filename, s.config.MismatchAction,
+			fmt.Sprintf("Declared type %q does not match detected type %q", normalizeMimeType(declaredType), detected))
+	}
+
+	inspection.inspectFile(filename, filename, content, size, detected, 0)
+	return inspection.hits
+}
+
+// Helper functions
+
+// inspectStored inspects an attachment, reading it by ranges from the blob
+// store when it was moved there
+func (s *AttachmentPolicyService) inspectStored(ctx context.Context, att domain.Attachment) ([]domain.PolicyHit, error) {
+	if len(att.Content) > 0 || att.BlobID == "" || s.blobs == nil {
+		return s.InspectAttachment(att.Filename, att.ContentType, att.Content), nil
+	}
+	content := &blobReaderAt{ctx: ctx, blobs: s.blobs, id: att.BlobID, size: att.Size}
+	hits := s.inspect(att.Filename, att.ContentType, content, att.Size)
+	if content.err != nil {
+		return nil, errors.InternalError(fmt.Errorf("reading attachment %s: %w", att.Filename, content.err))
+	}
+	return hits, nil
+}
+
+// blobWindowSize is the number of bytes read from the blob store at a time
+const blobWindowSize = 64 * 1024
+
+// blobReaderAt reads a stored blob by ranges through a window of
+// blobWindowSize bytes. The first read error is kept in err, since the
+// archive readers report it as a corrupt archive.
+type blobReaderAt struct {
+	ctx    context.Context
+	blobs  BlobReader
+	id     string
+	size   int64
+	window []byte
+	offset int64
+	err    error
+}
+
+func (r *blobReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	n := 0
+	for n < len(p) && off < r.size {
+		if off < r.offset || off >= r.offset+int64(len(r.window)) {
+			if err := r.fill(off); err != nil {
+				return n, err
+			}
+		}
+		copied := copy(p[n:], r.window[off-r.offset:])
+		n += copied
+		off += int64(copied)
+	}
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+func (r *blobReaderAt) fill(off int64) error {
+	if r.err != nil {
+		return r.err
+	}
+	length := minInt64(blobWindowSize, r.size-off)
+	reader, _, err := r.blobs.Open(r.ctx, r.id, off, length)
+	if err != nil {
+		r.err = err
+		return err
+	}
+	defer reader.Close()
+	window := make([]byte, length)
+	if _, err := io.ReadFull(reader, window); err != nil {
+		r.err = err
+		return err
+	}
+	r.window, r.offset = window, off
+	return nil
+}
+
+type attachmentInspection struct {
+	config *AttachmentPolicyConfig
+	hits   []domain.PolicyHit
+	budget int64
+}
+
+func (i *attachmentInspection) hit(rule, target string, action domain.PolicyAction, description string) {
+	i.hits = append(i.hits, domain.PolicyHit{
+		Type:        domain.PolicyTypeContent,
+		Rule:        rule,
+		Target:      target,
+		Description: description,
+		Action:      action,
+	})
+}
+
+// inspectFile applies the checks shared by attachments and archive members
+// and descends into archives
+func (i *attachmentInspection) inspectFile(target, filename string, content io.ReaderAt, size int64, detected string, depth int) {
+	ext := fileExtension(filename)
+
+	if strings.ContainsAny(filename, "\u202a\u202b\u202d\u202e\u2066\u2067\u2068") || strings.TrimRight(filename, ". \t") != filename {
+		i.hit(RuleDeceptiveFilename, target, i.config.BlockedAction,
+			"Filename contains direction overrides or trailing dots or spaces")
+	}
+
+	if containsFold(i.blockedExtensions(), ext) {
+		i.hit(RuleBlockedExtension, target, i.config.BlockedAction,
+			fmt.Sprintf("Extension %q is blocked", ext))
+		if hasDoubleExtension(filename) {
+			i.hit(RuleDoubleExtension, target, i.config.BlockedAction,
+				"Executable extension hidden behind a document extension")
+		}
+	}
+
+	if isExecutableType(detected) {
+		i.hit(RuleExecutableContent, target, i.config.BlockedAction,
+			fmt.Sprintf("File contains an executable (%s)", detected))
+	} else if implied := typeForExtension(ext); implied != "" && !typesCompatible(implied, detected) {
+		i.hit(RuleExtensionMismatch, target, i.config.MismatchAction,
+			fmt.Sprintf("Extension %q does not match detected type %q", ext, detected))
+	}
+
+	switch {
+	case detected == mimeOLEStorage:
+		i.inspectOLE(target, io.NewSectionReader(content, 0, size))
+	case isOOXMLType(detected):
+		i.inspectOOXML(target, ext, content, size)
+	case macroExtensions[ext]:
+		i.hit(RuleMacroDocument, target, i.config.MacroAction, "Macro-enabled Office document")
+	case isArchiveType(detected):
+		if i.config.MaxArchiveDepth > 0 && depth >= i.config.MaxArchiveDepth {
+			i.hit(RuleArchiveTooDeep, target, i.config.ArchiveLimitAction,
+				fmt.Sprintf("Archive nesting exceeds %d levels", i.config.MaxArchiveDepth))
+			return
+		}
+		i.inspectArchive(target, filename, content, size, detected, depth+1)
+	}
+}
+
+func (i *attachmentInspection) inspectOLE(target string, content io.Reader) {
+	found := readerContains(content, utf16LE("EncryptedPackage"), utf16LE("_VBA_PROJECT"), utf16LE("Macros"))
+	if found[0] {
+		i.hit(RuleEncryptedContent, target, i.config.EncryptedAction, "Password-protected Office document")
+	}
+	if found[1] || found[2] {
+		i.hit(RuleMacroDocument, target, i.config.MacroAction, "Office document contains VBA macros")
+	}
+}
+
+func (i *attachmentInspection) inspectOOXML(target, ext string, content io.ReaderAt, size int64) {
+	reader, err := zip.NewReader(content, size)
+	if err != nil {
+		return
+	}
+	for _, f := range reader.File {
+		if strings.HasSuffix(strings.ToLower(f.Name), "vbaproject.bin") {
+			i.hit(RuleMacroDocument, target, i.config.MacroAction, "Office document contains VBA macros")
+			return
+		}
+	}
+	if macroExtensions[ext] {
+		i.hit(RuleMacroDocument, target, i.config.MacroAction, "Macro-enabled Office document")
+	}
+}
+
+func (i *attachmentInspection) inspectArchive(target, filename string, content io.ReaderAt, size int64, detected string, depth int) {
+	switch detected {
+	case mimeZip, "application/java-archive":
+		i.inspectZip(target, content, size, depth)
+	case mimeTar:
+		i.inspectTar(target, io.NewSectionReader(content, 0, size), depth)
+	case mimeGzip:
+		i.inspectGzip(target, filename, io.NewSectionReader(content, 0, size), depth)
+	default:
+		// Formats such as rar and 7z cannot be inspected with the standard library
+		i.hit(RuleArchiveUnreadable, target, i.config.ArchiveLimitAction,
+			fmt.Sprintf("Archive format %s cannot be inspected", detected))
+	}
+}
+
+func (i *attachmentInspection) inspectZip(target string, content io.ReaderAt, size int64, depth int) {
+	reader, err := zip.NewReader(content, size)
+	if err != nil {
+		i.hit(RuleArchiveUnreadable, target, i.config.ArchiveLimitAction, "Zip archive is corrupt")
+		return
+	}
+	if i.config.MaxArchiveEntries > 0 && len(reader.File) > i.config.MaxArchiveEntries {
+		i.hit(RuleArchiveTooMany, target, i.config.ArchiveLimitAction,
+			fmt.Sprintf("Archive has more than %d entries", i.config.MaxArchiveEntries))
+		return
+	}
+
+	encrypted := false
+	for _, f := range reader.File {
+		if f.FileInfo().IsDir() {
+			continue
+		}
+		member := target + "/" + f.Name
+		if f.Flags&0x1 != 0 {
+			// Names are still visible in encrypted archives
+			encrypted = true
+			i.inspectName(member, f.Name)
+			continue
+		}
+
+		rc, err := f.Open()
+		if err != nil {
+			i.hit(RuleArchiveUnreadable, member, i.config.ArchiveLimitAction, "Archive entry cannot be read")
+			continue
+		}
+		data, ok := i.readWithinBudget(rc, member)
+		rc.Close()
+		if !ok {
+			return
+		}
+		i.inspectMember(member, f.Name, data, depth)
+	}
+
+	if encrypted {
+		i.hit(RuleEncryptedContent, target, i.config.EncryptedAction, "Password-protected archive")
+	}
+}
+
+func (i *attachmentInspection) inspectTar(target string, content io.Reader, depth int) {
+	reader := tar.NewReader(content)
+	entries := 0
+	for {
+		header, err := reader.Next()
+		if err == io.EOF {
+			return
+		}
+		if err != nil {
+			i.hit(RuleArchiveUnreadable, target, i.config.ArchiveLimitAction, "Tar archive is corrupt")
+			return
+		}
+		if header.Typeflag != tar.TypeReg {
+			continue
+		}
+
+		entries++
+		if i.config.MaxArchiveEntries > 0 && entries > i.config.MaxArchiveEntries {
+			i.hit(RuleArchiveTooMany, target, i.config.ArchiveLimitAction,
+				fmt.Sprintf("Archive has more than %d entries", i.config.MaxArchiveEntries))
+			return
+		}
+
+		member := target + "/" + header.Name
+		data, ok := i.readWithinBudget(reader, member)
+		if !ok {
+			return
+		}
+		i.inspectMember(member, header.Name, data, depth)
+	}
+}
+
+func (i *attachmentInspection) inspectGzip(target, filename string, content io.Reader, depth int) {
+	reader, err := gzip.NewReader(content)
+	if err != nil {
+		i.hit(RuleArchiveUnreadable, target, i.config.ArchiveLimitAction, "Gzip stream is corrupt")
+		return
+	}
+	defer reader.Close()
+
+	inner := reader.Name
+	if inner == "" {
+		inner = strings.TrimSuffix(cleanFilename(filename), ".gz")
+		if strings.HasSuffix(strings.ToLower(filename), ".tgz") {
+			inner = strings.TrimSuffix(cleanFilename(filename), ".tgz") + ".tar"
+		}
+	}
+
+	member := target + "/" + inner
+	data, ok := i.readWithinBudget(reader, member)
+	if !ok {
+		return
+	}
+	i.inspectMember(member, inner, data, depth)
+}
+
+// inspectMember inspects an archive member read within the size budget
+func (i *attachmentInspection) inspectMember(target, filename string, data []byte, depth int) {
+	i.inspectFile(target, filename, bytes.NewReader(data), int64(len(data)), sniffContentType(data), depth)
+}
+
+// inspectName applies the filename checks to members that cannot be read
+func (i *attachmentInspection) inspectName(target, filename string) {
+	ext := fileExtension(filename)
+	if containsFold(i.blockedExtensions(), ext) {
+		i.hit(RuleBlockedExtension, target, i.config.BlockedAction,
+			fmt.Sprintf("Extension %q is blocked", ext))
+	}
+}
+
+// readWithinBudget reads an archive member while enforcing the total
+// uncompressed size limit, which also defeats decompression bombs
+func (i *attachmentInspection) readWithinBudget(r io.Reader, target string) ([]byte, bool) {
+	if i.config.MaxArchiveSize <= 0 {
+		data, err := io.ReadAll(r)
+		if err != nil {
+			i.hit(RuleArchiveUnreadable, target, i.config.ArchiveLimitAction, "Archive entry cannot be read")
+			return nil, false
+		}
+		return data, true
+	}
+
+	data, err := io.ReadAll(io.LimitReader(r, i.budget+1))
+	if err != nil {
+		i.hit(RuleArchiveUnreadable, target, i.config.ArchiveLimitAction, "Archive entry cannot be read")
+		return nil, false
+	}
+	if int64(len(data)) > i.budget {
+		i.hit(RuleArchiveTooLarge, target, i.config.ArchiveLimitAction,
+			fmt.Sprintf("Archive expands beyond %d bytes", i.config.MaxArchiveSize))
+		return nil, false
+	}
+	i.budget -= int64(len(data))
+	return data, true
+}
+
+func (i *attachmentInspection) blockedExtensions() []string {
+	if i.config.BlockedExtensions != nil {
+		return i.config.BlockedExtensions
+	}
+	return DefaultBlockedExtensions
+}
+
+// hasDoubleExtension reports names such as "invoice.pdf.exe" where a
+// document extension precedes the real one
+// readerContains reports which patterns occur in the content of r, scanning
+// it in chunks that overlap by the longest pattern
+func readerContains(r io.Reader, patterns ...[]byte) []bool {
+	found := make([]bool, len(patterns))
+	overlap := 0
+	for _, pattern := range patterns {
+		if len(pattern)-1 > overlap {
+			overlap = len(pattern) - 1
+		}
+	}
+
+	buf := make([]byte, 32*1024+overlap)
+	kept := 0
+	for {
+		n, err := r.Read(buf[kept:])
+		chunk := buf[:kept+n]
+		for idx, pattern := range patterns {
+			if !found[idx] && bytes.Contains(chunk, pattern) {
+				found[idx] = true
+			}
+		}
+		if err != nil {
+			return found
+		}
+		if len(chunk) > overlap {
+			kept = copy(buf, chunk[len(chunk)-overlap:])
+		} else {
+			kept = len(chunk)
+		}
+	}
+}
+
+func minInt64(a, b int64) int64 {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func hasDoubleExtension(filename string) bool {
+	parts := strings.Split(cleanFilename(filename), ".")
+	if len(parts) < 3 {
+		return false
+	}
+	previous := "." + strings.ToLower(strings.TrimSpace(parts[len(parts)-2]))
+	return typeForExtension(previous) != ""
+}
+
+func mimeTypeAllowed(allowed []string, mimeType string) bool {
+	for _, pattern := range allowed {
+		pattern = normalizeMimeType(pattern)
+		if pattern == mimeType {
+			return true
+		}
+		if strings.HasSuffix(pattern, "/*") && strings.HasPrefix(mimeType, strings.TrimSuffix(pattern, "*")) {
+			return true
+		}
+	}
+	return false
+}
+
+func containsFold(values []string, value string) bool {
+	for _, v := range values {
+		if strings.EqualFold(v, value) {
+			return true
+		}
+	}
+	return false
+}
+
+func actionSeverity(action domain.PolicyAction) int {
+	switch action {
+	case domain.PolicyActionBlock:
+		return 4
+	case domain.PolicyActionQuarantine:
+		return 3
+	case domain.PolicyActionRedirect:
+		return 2
+	case domain.PolicyActionTag:
+		return 1
+	}
+	return 0
+}
+
+func strongestHit(hits []domain.PolicyHit) domain.PolicyHit {
+	strongest := hits[0]
+	for _, hit := range hits[1:] {
+		if actionSeverity(hit.Action) > actionSeverity(strongest.Action) {
+			strongest = hit
+		}
+	}
+	return strongest
+}
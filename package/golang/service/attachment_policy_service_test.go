@@ -0,0 +1,131 @@
+package service
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/skygenesisenterprise/aether-mailer/package/golang/domain"
+	"github.com/skygenesisenterprise/aether-mailer/package/golang/repository/inmemory"
+	"github.com/skygenesisenterprise/aether-mailer/package/golang/storage"
+)
+
+// rangeRecorder records the largest range read from a blob store; an
+// unbounded read counts as whole
+type rangeRecorder struct {
+	BlobReader
+	largest int64
+	whole   bool
+}
+
+func (r *rangeRecorder) Open(ctx context.Context, id string, offset, length int64) (io.ReadCloser, *domain.Blob, error) {
+	if length < 0 {
+		r.whole = true
+	} else if length > r.largest {
+		r.largest = length
+	}
+	return r.BlobReader.Open(ctx, id, offset, length)
+}
+
+func TestAttachmentPolicyServiceStreamsBlobs(t *testing.T) {
+	ctx := context.Background()
+	backend, err := storage.NewFilesystem(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys, err := NewDerivedBlobKeys("secret", []string{"sealed"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	blobs := NewBlobService(inmemory.NewBlobRepository(inmemory.NewStore()), backend, keys, &BlobConfig{SpoolDir: t.TempDir()})
+
+	// The macro marker straddles the first read window
+	ole := append([]byte{0xD0, 0xCF, 0x11, 0xE0, 0xA1, 0xB1, 0x1A, 0xE1}, make([]byte, blobWindowSize-16)...)
+	ole = append(ole, utf16LE("_VBA_PROJECT")...)
+	ole = append(ole, make([]byte, 1024)...)
+
+	// The executable and the central directory lie beyond the first window
+	var archive bytes.Buffer
+	writer := zip.NewWriter(&archive)
+	for _, member := range []struct {
+		name    string
+		content []byte
+	}{
+		{"padding.txt", []byte(strings.Repeat("x", 3*blobWindowSize))},
+		{"setup.exe", append([]byte("MZ"), make([]byte, 256)...)},
+	} {
+		w, err := writer.CreateHeader(&zip.FileHeader{Name: member.name, Method: zip.Store})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.Write(member.content); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name        string
+		tenantID    string
+		filename    string
+		contentType string
+		content     []byte
+		wantRule    string
+	}{
+		{name: "macro document", tenantID: "clear", filename: "report.doc", contentType: "application/msword", content: ole, wantRule: RuleMacroDocument},
+		{name: "encrypted macro document", tenantID: "sealed", filename: "report.doc", contentType: "application/msword", content: ole, wantRule: RuleMacroDocument},
+		{name: "executable in a zip", tenantID: "clear", filename: "bundle.zip", contentType: "application/zip", content: archive.Bytes(), wantRule: RuleBlockedExtension},
+		{name: "executable in an encrypted zip", tenantID: "sealed", filename: "bundle.zip", contentType: "application/zip", content: archive.Bytes(), wantRule: RuleBlockedExtension},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			blob, err := blobs.Put(ctx, tt.tenantID, bytes.NewReader(tt.content))
+			if err != nil {
+				t.Fatalf("Put: %v", err)
+			}
+			reader := &rangeRecorder{BlobReader: blobs}
+			policies := NewAttachmentPolicyService(reader, inmemory.NewEventPublisher(nil), &AttachmentPolicyConfig{
+				EnableAttachmentPolicy: true,
+				MaxArchiveDepth:        3,
+				MaxArchiveSize:         int64(len(tt.content)),
+				MaxArchiveEntries:      10,
+				BlockedAction:          domain.PolicyActionBlock,
+				MismatchAction:         domain.PolicyActionTag,
+				EncryptedAction:        domain.PolicyActionQuarantine,
+				MacroAction:            domain.PolicyActionQuarantine,
+				ArchiveLimitAction:     domain.PolicyActionQuarantine,
+			})
+
+			verdict, _ := policies.CheckAttachments(ctx, &domain.Message{
+				ID: uuid.NewString(),
+				Attachments: []domain.Attachment{
+					{Filename: tt.filename, ContentType: tt.contentType, Size: blob.Size, BlobID: blob.ID},
+				},
+			}, domain.DirectionInbound)
+			if verdict == nil {
+				t.Fatal("CheckAttachments returned no verdict")
+			}
+
+			found := false
+			for _, hit := range verdict.Hits {
+				found = found || hit.Rule == tt.wantRule
+			}
+			if !found {
+				t.Errorf("hits = %+v, want a %s hit", verdict.Hits, tt.wantRule)
+			}
+			if want := policies.InspectAttachment(tt.filename, tt.contentType, tt.content); len(want) != len(verdict.Hits) {
+				t.Errorf("blob inspection found %d hits, in-memory inspection %d", len(verdict.Hits), len(want))
+			}
+			if reader.whole || reader.largest > blobWindowSize {
+				t.Errorf("largest blob read = %d bytes, want at most %d", reader.largest, blobWindowSize)
+			}
+		})
+	}
+}
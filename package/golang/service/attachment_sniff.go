@@ -0,0 +1,261 @@
+package service
+
+import (
+	"archive/zip"
+	"bytes"
+	"io"
+	"mime"
+	"net/http"
+	"path"
+	"strings"
+	"unicode/utf16"
+)
+
+// Detected content types that have no registered MIME type
+const (
+	mimeOLEStorage = "application/x-ole-storage"
+	mimeWindowsExe = "application/x-msdownload"
+	mimeELF        = "application/x-executable"
+	mimeMachO      = "application/x-mach-binary"
+	mimeZip        = "application/zip"
+	mimeGzip       = "application/gzip"
+	mimeTar        = "application/x-tar"
+	mimeRar        = "application/vnd.rar"
+	mime7z         = "application/x-7z-compressed"
+	mimeOctet      = "application/octet-stream"
+)
+
+var magicSignatures = []struct {
+	offset    int
+	signature []byte
+	mimeType  string
+}{
+	{0, []byte("MZ"), mimeWindowsExe},
+	{0, []byte("\x7fELF"), mimeELF},
+	{0, []byte("\xcf\xfa\xed\xfe"), mimeMachO},
+	{0, []byte("\xce\xfa\xed\xfe"), mimeMachO},
+	{0, []byte("\xca\xfe\xba\xbe"), mimeMachO},
+	{0, []byte("PK\x03\x04"), mimeZip},
+	{0, []byte("PK\x05\x06"), mimeZip},
+	{0, []byte("\xd0\xcf\x11\xe0\xa1\xb1\x1a\xe1"), mimeOLEStorage},
+	{0, []byte("Rar!\x1a\x07"), mimeRar},
+	{0, []byte("7z\xbc\xaf\x27\x1c"), mime7z},
+	{0, []byte("\x1f\x8b"), mimeGzip},
+	{257, []byte("ustar"), mimeTar},
+	{0, []byte("%PDF-"), "application/pdf"},
+	{0, []byte("{\\rtf"), "application/rtf"},
+	{0, []byte("#!"), "text/x-shellscript"},
+}
+
+// extensionTypes maps extensions to the type their content is expected to
+// have. Extensions missing here fall back to the mime package.
+var extensionTypes = map[string]string{
+	".doc":  mimeOLEStorage,
+	".xls":  mimeOLEStorage,
+	".ppt":  mimeOLEStorage,
+	".msg":  mimeOLEStorage,
+	".msi":  mimeOLEStorage,
+	".docx": "application/vnd.openxmlformats-officedocument.wordprocessingml.document",
+	".docm": "application/vnd.openxmlformats-officedocument.wordprocessingml.document",
+	".xlsx": "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet",
+	".xlsm": "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet",
+	".pptx": "application/vnd.openxmlformats-officedocument.presentationml.presentation",
+	".pptm": "application/vnd.openxmlformats-officedocument.presentationml.presentation",
+	".odt":  "application/vnd.oasis.opendocument.text",
+	".ods":  "application/vnd.oasis.opendocument.spreadsheet",
+	".odp":  "application/vnd.oasis.opendocument.presentation",
+	".zip":  mimeZip,
+	".jar":  "application/java-archive",
+	".gz":   mimeGzip,
+	".tgz":  mimeGzip,
+	".tar":  mimeTar,
+	".rar":  mimeRar,
+	".7z":   mime7z,
+	".exe":  mimeWindowsExe,
+	".dll":  mimeWindowsExe,
+	".pdf":  "application/pdf",
+	".rtf":  "application/rtf",
+	".txt":  "text/plain",
+	".csv":  "text/csv",
+	".htm":  "text/html",
+	".html": "text/html",
+	".jpg":  "image/jpeg",
+	".jpeg": "image/jpeg",
+	".png":  "image/png",
+	".gif":  "image/gif",
+}
+
+// compatibleTypes lists declared types that share a container format with
+// the detected type
+var compatibleTypes = map[string][]string{
+	mimeOLEStorage: {"application/msword", "application/vnd.ms-excel", "application/vnd.ms-powerpoint",
+		"application/vnd.ms-outlook", "application/x-msi", "application/x-ole-storage"},
+	mimeZip:                    {"application/x-zip-compressed", "application/x-zip"},
+	mimeGzip:                   {"application/x-gzip", "application/x-compressed"},
+	mimeRar:                    {"application/x-rar-compressed", "application/x-rar"},
+	mimeWindowsExe:             {"application/x-dosexec", "application/vnd.microsoft.portable-executable", "application/exe"},
+	"image/jpeg":               {"image/jpg", "image/pjpeg"},
+	"application/java-archive": {"application/x-java-archive", mimeZip},
+	"application/rtf":          {"text/rtf"},
+}
+
+// sniffLength is the number of leading bytes the magic signatures and
+// http.DetectContentType look at
+const sniffLength = 512
+
+// sniffContentType detects a content type from magic bytes. Zip containers
+// are refined into Office Open XML, OpenDocument and Java archive types.
+func sniffContentType(content []byte) string {
+	return sniffContent(bytes.NewReader(content), int64(len(content)))
+}
+
+// sniffContent detects the type of content read at random, reading only
+// its first bytes and, for zip containers, their central directory
+func sniffContent(content io.ReaderAt, size int64) string {
+	head := make([]byte, minInt64(size, sniffLength))
+	n, _ := content.ReadAt(head, 0)
+	head = head[:n]
+
+	for _, magic := range magicSignatures {
+		end := magic.offset + len(magic.signature)
+		if len(head) >= end && bytes.Equal(head[magic.offset:end], magic.signature) {
+			if magic.mimeType == mimeZip {
+				return refineZipType(content, size)
+			}
+			return magic.mimeType
+		}
+	}
+	return normalizeMimeType(http.DetectContentType(head))
+}
+
+func refineZipType(content io.ReaderAt, size int64) string {
+	reader, err := zip.NewReader(content, size)
+	if err != nil {
+		return mimeZip
+	}
+
+	hasContentTypes := false
+	for _, f := range reader.File {
+		switch {
+		case f.Name == "[Content_Types].xml":
+			hasContentTypes = true
+		case f.Name == "META-INF/MANIFEST.MF":
+			return "application/java-archive"
+		case f.Name == "mimetype" && f.Method == zip.Store && !f.FileInfo().IsDir():
+			rc, err := f.Open()
+			if err != nil {
+				continue
+			}
+			buf := make([]byte, 128)
+			n, _ := rc.Read(buf)
+			rc.Close()
+			if declared := strings.TrimSpace(string(buf[:n])); strings.HasPrefix(declared, "application/vnd.oasis.opendocument.") {
+				return declared
+			}
+		}
+	}
+	if !hasContentTypes {
+		return mimeZip
+	}
+
+	for _, f := range reader.File {
+		switch {
+		case strings.HasPrefix(f.Name, "word/"):
+			return extensionTypes[".docx"]
+		case strings.HasPrefix(f.Name, "xl/"):
+			return extensionTypes[".xlsx"]
+		case strings.HasPrefix(f.Name, "ppt/"):
+			return extensionTypes[".pptx"]
+		}
+	}
+	return mimeZip
+}
+
+// typeForExtension returns the type implied by a file extension, or an
+// empty string when the extension is unknown
+func typeForExtension(ext string) string {
+	if mimeType, ok := extensionTypes[ext]; ok {
+		return mimeType
+	}
+	return normalizeMimeType(mime.TypeByExtension(ext))
+}
+
+// typesCompatible reports whether a claimed type (declared by the sender or
+// implied by the extension) is consistent with the detected type. Generic
+// detections never conflict with a claim.
+func typesCompatible(claimed, detected string) bool {
+	claimed = normalizeMimeType(claimed)
+	detected = normalizeMimeType(detected)
+
+	if claimed == "" || claimed == mimeOctet || detected == "" || detected == mimeOctet {
+		return true
+	}
+	if claimed == detected {
+		return true
+	}
+	for _, alias := range compatibleTypes[detected] {
+		if alias == claimed {
+			return true
+		}
+	}
+	// Text detection says little about the intended text format
+	return strings.HasPrefix(detected, "text/") && (strings.HasPrefix(claimed, "text/") || isTextualType(claimed))
+}
+
+func isTextualType(mimeType string) bool {
+	switch mimeType {
+	case "application/json", "application/xml", "application/javascript", "application/x-sh",
+		"application/pgp-signature", "application/pgp-keys", "application/ics":
+		return true
+	}
+	return strings.HasSuffix(mimeType, "+xml") || strings.HasSuffix(mimeType, "+json")
+}
+
+func isExecutableType(mimeType string) bool {
+	switch mimeType {
+	case mimeWindowsExe, mimeELF, mimeMachO:
+		return true
+	}
+	return false
+}
+
+func isArchiveType(mimeType string) bool {
+	switch mimeType {
+	case mimeZip, mimeGzip, mimeTar, mimeRar, mime7z, "application/java-archive":
+		return true
+	}
+	return false
+}
+
+func isOOXMLType(mimeType string) bool {
+	return strings.HasPrefix(mimeType, "application/vnd.openxmlformats-officedocument.")
+}
+
+func normalizeMimeType(mimeType string) string {
+	if idx := strings.Index(mimeType, ";"); idx >= 0 {
+		mimeType = mimeType[:idx]
+	}
+	return strings.ToLower(strings.TrimSpace(mimeType))
+}
+
+// fileExtension returns the lower-cased final extension of a filename,
+// ignoring trailing dots and spaces that Windows strips on save
+func fileExtension(filename string) string {
+	return strings.ToLower(path.Ext(cleanFilename(filename)))
+}
+
+func cleanFilename(filename string) string {
+	filename = strings.ReplaceAll(filename, "\\", "/")
+	filename = path.Base(filename)
+	return strings.TrimRight(filename, ". \t")
+}
+
+// utf16LE encodes s the way OLE2 directory entries store stream names
+func utf16LE(s string) []byte {
+	encoded := utf16.Encode([]rune(s))
+	out := make([]byte, 0, len(encoded)*2)
+	for _, r := range encoded {
+		out = append(out, byte(r), byte(r>>8))
+	}
+	return out
+}
@@ -0,0 +1,376 @@
+package service
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/skygenesisenterprise/aether-mailer/package/golang/domain"
+	"github.com/skygenesisenterprise/aether-mailer/package/golang/errors"
+	"github.com/skygenesisenterprise/aether-mailer/package/golang/repository"
+	"github.com/skygenesisenterprise/aether-mailer/package/golang/storage"
+)
+
+// BlobService stores raw messages and attachments by the SHA-256 of their
+// content. Identical content is stored once and shared through a reference
+// count; unreferenced blobs are deleted by garbage collection after a grace
+// period. Tenants can be encrypted at rest, in which case their blobs are
+// only deduplicated within the tenant.
+type BlobService struct {
+	repo    repository.BlobRepository
+	backend storage.Backend
+	keys    BlobKeyProvider
+	config  *BlobConfig
+}
+
+// BlobConfig defines blob storage settings
+type BlobConfig struct {
+	SpoolDir    string        // temporary files used while hashing uploads, the system default when empty
+	GCInterval  time.Duration // garbage collection period, 1 hour when zero
+	GracePeriod time.Duration // how long an unreferenced blob is kept, 24 hours when zero
+	GCBatchSize int           // blobs collected per query, 500 when zero
+}
+
+// NewBlobService creates a new blob service. keys may be nil to store every
+// blob in clear.
+func NewBlobService(
+	repo repository.BlobRepository,
+	backend storage.Backend,
+	keys BlobKeyProvider,
+	config *BlobConfig,
+) *BlobService {
+	return &BlobService{
+		repo:    repo,
+		backend: backend,
+		keys:    keys,
+		config:  config,
+	}
+}
+
+// Put stores content for a tenant and returns its blob with one more
+// reference. The content is spooled to disk while it is hashed, so it is
+// never held in memory.
+func (s *BlobService) Put(ctx context.Context, tenantID string, content io.Reader) (*domain.Blob, error) {
+	spool, err := os.CreateTemp(s.config.SpoolDir, "blob-*")
+	if err != nil {
+		return nil, errors.InternalError(err)
+	}
+	defer os.Remove(spool.Name())
+	defer spool.Close()
+
+	hash := sha256.New()
+	size, err := io.Copy(io.MultiWriter(spool, hash), content)
+	if err != nil {
+		return nil, errors.InternalError(fmt.Errorf("spooling blob: %w", err))
+	}
+	digest := hex.EncodeToString(hash.Sum(nil))
+
+	key, err := s.tenantKey(tenantID)
+	if err != nil {
+		return nil, err
+	}
+	id := digest
+	if key != nil {
+		scoped := sha256.Sum256([]byte(tenantID + "\x00" + digest))
+		id = hex.EncodeToString(scoped[:])
+	} else {
+		tenantID = ""
+	}
+
+	// Identical content only gains a reference
+	if blob, err := s.retain(ctx, id); err != nil || blob != nil {
+		return blob, err
+	}
+
+	if _, err := spool.Seek(0, io.SeekStart); err != nil {
+		return nil, errors.InternalError(err)
+	}
+	var body io.Reader = spool
+	storedSize := size
+	if key != nil {
+		encrypter, err := newBlobEncrypter(spool, key, size)
+		if err != nil {
+			return nil, errors.InternalError(err)
+		}
+		body = encrypter
+		storedSize = encryptedBlobSize(size)
+	}
+
+	// Each stored copy gets its own object key, so collecting an old copy
+	// never deletes one uploaded concurrently for the same content
+	storageKey := fmt.Sprintf("%s/%s/%s.%s", id[:2], id[2:4], id, uuid.New().String())
+	if err := s.backend.Put(ctx, storageKey, body, storedSize); err != nil {
+		return nil, errors.InternalError(fmt.Errorf("storing blob: %w", err))
+	}
+
+	now := time.Now()
+	blob := &domain.Blob{
+		ID:         id,
+		SHA256:     digest,
+		TenantID:   tenantID,
+		StorageKey: storageKey,
+		Size:       size,
+		Encrypted:  key != nil,
+		RefCount:   1,
+		CreatedAt:  now,
+		UpdatedAt:  now,
+	}
+	created, err := s.repo.Create(ctx, blob)
+	if err != nil {
+		s.backend.Delete(ctx, storageKey)
+		return nil, errors.InternalError(err)
+	}
+	if !created {
+		// Another writer stored the same content first
+		if err := s.backend.Delete(ctx, storageKey); err != nil {
+			// Log error but don't fail the operation
+		}
+		existing, err := s.retain(ctx, id)
+		if err != nil {
+			return nil, err
+		}
+		if existing == nil {
+			return nil, errors.InternalError(fmt.Errorf("blob %s vanished while storing", id))
+		}
+		return existing, nil
+	}
+
+	return blob, nil
+}
+
+// Get returns a blob
+func (s *BlobService) Get(ctx context.Context, id string) (*domain.Blob, error) {
+	blob, err := s.repo.GetByID(ctx, id)
+	if err != nil {
+		return nil, errors.InternalError(err)
+	}
+	if blob == nil {
+		return nil, errors.BlobNotFound(id)
+	}
+	return blob, nil
+}
+
+// Open reads length bytes of a blob starting at offset. A negative length
+// reads to the end; ranges past the end are shortened.
+func (s *BlobService) Open(ctx context.Context, id string, offset, length int64) (io.ReadCloser, *domain.Blob, error) {
+	blob, err := s.Get(ctx, id)
+	if err != nil {
+		return nil, nil, err
+	}
+	if offset < 0 || offset > blob.Size || (offset == blob.Size && blob.Size > 0) {
+		return nil, nil, errors.InvalidRange(offset, blob.Size)
+	}
+	if length < 0 || offset+length > blob.Size {
+		length = blob.Size - offset
+	}
+
+	if !blob.Encrypted {
+		reader, err := s.backend.Get(ctx, blob.StorageKey, offset, length)
+		if err != nil {
+			return nil, nil, errors.InternalError(fmt.Errorf("reading blob %s: %w", id, err))
+		}
+		return reader, blob, nil
+	}
+
+	reader, err := s.openEncrypted(ctx, blob, offset, length)
+	if err != nil {
+		return nil, nil, err
+	}
+	return reader, blob, nil
+}
+
+// Retain adds a reference to an existing blob of a tenant, such as an
+// attachment shared by a forward. A tenant may reference its own blobs and,
+// when it stores in clear, the shared ones its own uploads would produce;
+// any other blob is reported as not found.
+func (s *BlobService) Retain(ctx context.Context, tenantID, id string) (*domain.Blob, error) {
+	existing, err := s.Get(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if existing.TenantID != tenantID {
+		key, err := s.tenantKey(tenantID)
+		if err != nil {
+			return nil, err
+		}
+		if existing.TenantID != "" || key != nil {
+			return nil, errors.BlobNotFound(id)
+		}
+	}
+
+	blob, err := s.retain(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if blob == nil {
+		return nil, errors.BlobNotFound(id)
+	}
+	return blob, nil
+}
+
+// Release drops a reference. The blob is deleted by garbage collection once
+// it has been unreferenced for the grace period.
+func (s *BlobService) Release(ctx context.Context, id string) error {
+	found, err := s.repo.AddRef(ctx, id, -1)
+	if err != nil {
+		return errors.InternalError(err)
+	}
+	if !found {
+		return errors.BlobNotFound(id)
+	}
+	return nil
+}
+
+// CollectGarbage deletes the blobs unreferenced for longer than the grace
+// period and returns how many were deleted
+func (s *BlobService) CollectGarbage(ctx context.Context) (int, error) {
+	grace := s.config.GracePeriod
+	if grace <= 0 {
+		grace = 24 * time.Hour
+	}
+	batch := s.config.GCBatchSize
+	if batch <= 0 {
+		batch = 500
+	}
+	cutoff := time.Now().Add(-grace)
+
+	deleted := 0
+	for {
+		blobs, err := s.repo.ListCollectable(ctx, cutoff, batch)
+		if err != nil {
+			return deleted, errors.InternalError(err)
+		}
+
+		removed := 0
+		for _, blob := range blobs {
+			// The row goes first: a blob referenced again in the meantime is kept
+			ok, err := s.repo.DeleteUnreferenced(ctx, blob.ID, cutoff)
+			if err != nil {
+				return deleted, errors.InternalError(err)
+			}
+			if !ok {
+				continue
+			}
+			if err := s.backend.Delete(ctx, blob.StorageKey); err != nil {
+				// Log error but keep collecting; the object is orphaned
+			}
+			removed++
+		}
+		deleted += removed
+
+		if len(blobs) < batch || removed == 0 {
+			return deleted, nil
+		}
+	}
+}
+
+// Run collects garbage at the configured interval until the context is
+// cancelled
+func (s *BlobService) Run(ctx context.Context) {
+	interval := s.config.GCInterval
+	if interval <= 0 {
+		interval = time.Hour
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if _, err := s.CollectGarbage(ctx); err != nil {
+				// Log error but keep the scheduler running
+			}
+		}
+	}
+}
+
+// retain adds a reference and returns the blob, or nil when it does not
+// exist or was collected meanwhile
+func (s *BlobService) retain(ctx context.Context, id string) (*domain.Blob, error) {
+	found, err := s.repo.AddRef(ctx, id, 1)
+	if err != nil {
+		return nil, errors.InternalError(err)
+	}
+	if !found {
+		return nil, nil
+	}
+	blob, err := s.repo.GetByID(ctx, id)
+	if err != nil {
+		return nil, errors.InternalError(err)
+	}
+	return blob, nil
+}
+
+// openEncrypted reads only the chunks covering the requested range
+func (s *BlobService) openEncrypted(ctx context.Context, blob *domain.Blob, offset, length int64) (io.ReadCloser, error) {
+	key, err := s.tenantKey(blob.TenantID)
+	if err != nil {
+		return nil, err
+	}
+	if key == nil {
+		return nil, errors.InternalError(fmt.Errorf("no key for encrypted blob %s", blob.ID))
+	}
+	aead, err := newBlobAEAD(key)
+	if err != nil {
+		return nil, errors.InternalError(err)
+	}
+
+	header, err := s.backend.Get(ctx, blob.StorageKey, 0, blobHeaderSize)
+	if err != nil {
+		return nil, errors.InternalError(fmt.Errorf("reading blob %s: %w", blob.ID, err))
+	}
+	prefix := make([]byte, blobHeaderSize)
+	_, err = io.ReadFull(header, prefix)
+	header.Close()
+	if err != nil || prefix[0] != blobCryptoVersion {
+		return nil, errors.InternalError(fmt.Errorf("blob %s has an invalid header", blob.ID))
+	}
+
+	first := offset / blobChunkSize
+	last := first
+	if length > 0 {
+		last = (offset + length - 1) / blobChunkSize
+	}
+	chunks := blobChunkCount(blob.Size)
+	start := blobHeaderSize + first*blobSealedChunkSize
+	end := blobHeaderSize + (last+1)*blobSealedChunkSize
+	if stored := encryptedBlobSize(blob.Size); end > stored {
+		end = stored
+	}
+
+	src, err := s.backend.Get(ctx, blob.StorageKey, start, end-start)
+	if err != nil {
+		return nil, errors.InternalError(fmt.Errorf("reading blob %s: %w", blob.ID, err))
+	}
+	return &blobDecrypter{
+		src:    src,
+		aead:   aead,
+		prefix: prefix[1:],
+		chunks: chunks,
+		index:  first,
+		skip:   offset - first*blobChunkSize,
+		remain: length,
+		sealed: make([]byte, blobSealedChunkSize),
+	}, nil
+}
+
+// tenantKey returns the encryption key of a tenant, nil when blobs are
+// stored in clear
+func (s *BlobService) tenantKey(tenantID string) ([]byte, error) {
+	if s.keys == nil {
+		return nil, nil
+	}
+	key, err := s.keys.BlobKey(tenantID)
+	if err != nil {
+		return nil, errors.InternalError(err)
+	}
+	return key, nil
+}
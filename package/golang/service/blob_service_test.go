@@ -0,0 +1,113 @@
+package service
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	stderrors "errors"
+	"strings"
+	"testing"
+
+	"github.com/skygenesisenterprise/aether-mailer/package/golang/errors"
+	"github.com/skygenesisenterprise/aether-mailer/package/golang/repository/inmemory"
+	"github.com/skygenesisenterprise/aether-mailer/package/golang/storage"
+)
+
+func TestBlobServiceRetainChecksTenant(t *testing.T) {
+	backend, err := storage.NewFilesystem(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Only the "sealed" tenant is encrypted at rest
+	keys, err := NewDerivedBlobKeys("secret", []string{"sealed"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	blobs := NewBlobService(inmemory.NewBlobRepository(inmemory.NewStore()), backend, keys, &BlobConfig{SpoolDir: t.TempDir()})
+	ctx := context.Background()
+
+	shared, err := blobs.Put(ctx, "clear", strings.NewReader("shared report"))
+	if err != nil {
+		t.Fatalf("Put() error = %v", err)
+	}
+	sealed, err := blobs.Put(ctx, "sealed", strings.NewReader("sealed report"))
+	if err != nil {
+		t.Fatalf("Put() error = %v", err)
+	}
+
+	tests := []struct {
+		name     string
+		tenantID string
+		blobID   string
+		wantErr  bool
+	}{
+		{name: "own encrypted blob", tenantID: "sealed", blobID: sealed.ID},
+		{name: "shared blob from a clear tenant", tenantID: "clear", blobID: shared.ID},
+		{name: "shared blob from another clear tenant", tenantID: "other", blobID: shared.ID},
+		{name: "encrypted blob of another tenant", tenantID: "clear", blobID: sealed.ID, wantErr: true},
+		{name: "shared blob from an encrypted tenant", tenantID: "sealed", blobID: shared.ID, wantErr: true},
+		{name: "missing blob", tenantID: "clear", blobID: "missing", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			before, err := blobs.Get(ctx, tt.blobID)
+			if err != nil && !tt.wantErr {
+				t.Fatalf("Get() error = %v", err)
+			}
+
+			blob, err := blobs.Retain(ctx, tt.tenantID, tt.blobID)
+			if tt.wantErr {
+				var mailErr *errors.Error
+				if !stderrors.As(err, &mailErr) || mailErr.Code != errors.ErrCodeBlobNotFound {
+					t.Fatalf("Retain() error = %v, want blob not found", err)
+				}
+				if before != nil {
+					if after, _ := blobs.Get(ctx, tt.blobID); after.RefCount != before.RefCount {
+						t.Errorf("refused Retain() changed the reference count to %d", after.RefCount)
+					}
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Retain() error = %v", err)
+			}
+			if blob.RefCount != before.RefCount+1 {
+				t.Errorf("reference count = %d, want %d", blob.RefCount, before.RefCount+1)
+			}
+		})
+	}
+}
+
+func TestPutAttachmentLimitsStreamedContent(t *testing.T) {
+	backend, err := storage.NewFilesystem(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	blobs := NewBlobService(inmemory.NewBlobRepository(inmemory.NewStore()), backend, nil, &BlobConfig{SpoolDir: t.TempDir()})
+	ctx := context.Background()
+
+	blob, err := putAttachment(ctx, blobs, "tenant", AttachmentRequest{Filename: "a.txt", Size: 5, Reader: strings.NewReader("hello")}, 1024)
+	if err != nil {
+		t.Fatalf("putAttachment() error = %v", err)
+	}
+	if blob.Size != 5 {
+		t.Errorf("stored %d bytes, want 5", blob.Size)
+	}
+
+	// Content larger than declared is refused and its blob released
+	_, err = putAttachment(ctx, blobs, "tenant", AttachmentRequest{Filename: "b.txt", Size: 5, Reader: strings.NewReader("hello world")}, 1024)
+	var mailErr *errors.Error
+	if !stderrors.As(err, &mailErr) || mailErr.Code != errors.ErrCodeValidationError {
+		t.Fatalf("putAttachment() error = %v, want a validation error", err)
+	}
+	// The store only received the declared size and one byte more
+	sum := sha256.Sum256([]byte("hello "))
+	cut, err := blobs.Get(ctx, hex.EncodeToString(sum[:]))
+	if err != nil {
+		t.Fatalf("Get() error = %v", err)
+	}
+	if cut.RefCount != 0 {
+		t.Errorf("refused upload keeps %d references", cut.RefCount)
+	}
+}
@@ -0,0 +1,169 @@
+// Package clamdtest provides a fake clamd daemon for exercising virus
+// scanning code without a real ClamAV installation.
+package clamdtest
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net"
+	"strings"
+	"sync"
+)
+
+// EICAR is the standard antivirus test string
+const EICAR = `X5O!P%@AP[4\PZX54(P^)7CC)7}$EICAR-STANDARD-ANTIVIRUS-TEST-FILE!$H+H*`
+
+// Server is a fake clamd daemon that understands PING and INSTREAM
+type Server struct {
+	Addr     string
+	Network  string
+	listener net.Listener
+
+	mu         sync.Mutex
+	signatures map[string]string
+	failWith   string
+	scans      int
+	wg         sync.WaitGroup
+}
+
+// NewServer starts a fake daemon on a random local TCP port. Content
+// containing the EICAR test string is reported as infected.
+func NewServer() *Server {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		panic("clamdtest: failed to listen: " + err.Error())
+	}
+	return start("tcp", listener)
+}
+
+// NewUnixServer starts a fake daemon listening on the given Unix socket path
+func NewUnixServer(path string) *Server {
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		panic("clamdtest: failed to listen: " + err.Error())
+	}
+	return start("unix", listener)
+}
+
+func start(network string, listener net.Listener) *Server {
+	s := &Server{
+		Addr:       listener.Addr().String(),
+		Network:    network,
+		listener:   listener,
+		signatures: map[string]string{EICAR: "Win.Test.EICAR_HDB-1"},
+	}
+	s.wg.Add(1)
+	go s.serve()
+	return s
+}
+
+// AddSignature reports content containing pattern as infected with name
+func (s *Server) AddSignature(pattern, name string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.signatures[pattern] = name
+}
+
+// FailWith makes every scan reply with the given error, e.g.
+// "INSTREAM size limit exceeded. ERROR". An empty message restores scanning.
+func (s *Server) FailWith(message string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.failWith = message
+}
+
+// Scans returns the number of INSTREAM requests served
+func (s *Server) Scans() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.scans
+}
+
+// Close stops the daemon and waits for open connections to finish
+func (s *Server) Close() {
+	s.listener.Close()
+	s.wg.Wait()
+}
+
+func (s *Server) serve() {
+	defer s.wg.Done()
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			defer conn.Close()
+			s.handle(conn)
+		}()
+	}
+}
+
+func (s *Server) handle(conn net.Conn) {
+	reader := bufio.NewReader(conn)
+
+	// Commands are prefixed with 'z' (NUL terminated) or 'n' (newline terminated)
+	prefix, err := reader.ReadByte()
+	if err != nil {
+		return
+	}
+	delim := byte('\n')
+	if prefix == 'z' {
+		delim = 0
+	}
+	command, err := reader.ReadString(delim)
+	if err != nil {
+		return
+	}
+	command = strings.TrimRight(command, "\x00\n")
+
+	switch command {
+	case "PING":
+		conn.Write(append([]byte("PONG"), delim))
+	case "INSTREAM":
+		content, err := readStream(reader)
+		if err != nil {
+			return
+		}
+		conn.Write(append([]byte(s.verdict(content)), delim))
+	default:
+		conn.Write(append([]byte("UNKNOWN COMMAND"), delim))
+	}
+}
+
+func (s *Server) verdict(content []byte) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.scans++
+
+	if s.failWith != "" {
+		return s.failWith
+	}
+	for pattern, name := range s.signatures {
+		if bytes.Contains(content, []byte(pattern)) {
+			return "stream: " + name + " FOUND"
+		}
+	}
+	return "stream: OK"
+}
+
+func readStream(r io.Reader) ([]byte, error) {
+	var content bytes.Buffer
+	size := make([]byte, 4)
+	for {
+		if _, err := io.ReadFull(r, size); err != nil {
+			return nil, err
+		}
+		n := binary.BigEndian.Uint32(size)
+		if n == 0 {
+			return content.Bytes(), nil
+		}
+		if _, err := io.CopyN(&content, r, int64(n)); err != nil {
+			return nil, err
+		}
+	}
+}
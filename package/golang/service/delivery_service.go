@@ -0,0 +1,1212 @@
+package service
+
+import (
+	"context"
+	stderrors "errors"
+	"fmt"
+	"net"
+	"net/mail"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/skygenesisenterprise/aether-mailer/package/golang/domain"
+	"github.com/skygenesisenterprise/aether-mailer/package/golang/errors"
+	"github.com/skygenesisenterprise/aether-mailer/package/golang/repository"
+)
+
+// DeliveryService delivers queued messages to remote mail servers. Jobs are
+// grouped per destination: the most specific destination policy matching
+// the recipient domain's MX hosts, or the recipient domain itself when no
+// policy matches. Each destination has its own connection limit, message
+// rate and backoff, and keeps idle connections open for reuse. When a TLS
+// policy provider is set, MX hosts are filtered and protected according to
+// the recipient domain's MTA-STS and DANE policies. Jobs are stored in
+// the job repository; each instance leases the due jobs it dispatches, so
+// several instances can share the queue and jobs outlive a restart.
+type DeliveryService struct {
+	policyRepo repository.DestinationPolicyRepository
+	jobRepo    repository.DeliveryJobRepository
+	resolver   MXResolver
+	dialer     SMTPDialer
+	tls        TLSPolicyProvider
+	sources    SourceSelector
+	outcomes   OutcomeRecorder
+	sealer     MessageSealer
+	eventPub   domain.EventPublisher
+	config     *DeliveryConfig
+	owner      string // identifies this instance's leases
+
+	mu           sync.Mutex
+	held         map[string]bool // leased jobs queued or in flight
+	extendedAt   time.Time
+	policies     []*domain.DestinationPolicy
+	destinations map[string]*destination
+	mxCache      map[string]mxCacheEntry
+	wake         chan struct{}
+	wg           sync.WaitGroup
+}
+
+// DeliveryConfig defines delivery service configuration
+type DeliveryConfig struct {
+	HeloName           string
+	Port               int
+	CommandTimeout     time.Duration // deadline for one SMTP transaction
+	IdleTimeout        time.Duration // idle connections are closed after this
+	PollInterval       time.Duration
+	MXCacheTTL         time.Duration
+	RetryDelay         time.Duration // first retry delay, doubled on every attempt
+	MaxRetryDelay      time.Duration
+	MaxAttempts        int           // attempts before deferred recipients bounce, 0 retries forever
+	MaxDeferralReasons int           // distinct deferral reasons kept per destination
+	Lease              time.Duration // how long a claimed job is reserved for this instance
+	MaxHeld            int           // jobs leased at once by this instance
+	DefaultPolicy      domain.DestinationPolicy
+}
+
+// MXResolver looks up the mail exchangers of a domain. *net.Resolver
+// satisfies it.
+type MXResolver interface {
+	LookupMX(ctx context.Context, name string) ([]*net.MX, error)
+}
+
+// TLSPolicyProvider decides which MX hosts may receive a domain's mail and
+// how their sessions must be protected, and collects TLS-RPT results.
+// *TLSPolicyService satisfies it.
+type TLSPolicyProvider interface {
+	Targets(ctx context.Context, domainName string, hosts []string) ([]DeliveryTarget, error)
+	RecordResult(ctx context.Context, result domain.TLSResult)
+}
+
+// SourceSelector picks the source address and EHLO name of a delivery.
+// *IPPoolService satisfies it.
+type SourceSelector interface {
+	SelectSource(ctx context.Context, req SourceRequest) (*domain.SourceAddress, error)
+}
+
+// OutcomeRecorder receives the final delivery result for each recipient.
+// *RateLimitService satisfies it.
+type OutcomeRecorder interface {
+	RecordOutcome(ctx context.Context, attempt SendAttempt, outcome domain.DeliveryOutcome) error
+}
+
+// MessageSealer adds an ARC set to forwarded and list traffic.
+// *ARCService satisfies it.
+type MessageSealer interface {
+	Seal(ctx context.Context, data []byte, sealingDomain, authResults string) ([]byte, error)
+}
+
+// EnqueueRequest represents a rendered message handed to the outbound queue.
+// Forwarded and list traffic sets SealingDomain so the message leaves with
+// an ARC set vouching for the authentication results it arrived with.
+type EnqueueRequest struct {
+	MessageID     string
+	AccountID     string
+	DomainID      string
+	TenantID      string
+	Stream        string // message stream used for IP pool assignment, such as "transactional"
+	From          string
+	Recipients    []string
+	Data          []byte
+	SealingDomain string // forwarding or list domain, empty for original mail
+	AuthResults   string // Authentication-Results payload of the inbound hop
+}
+
+// CreateDestinationPolicyRequest represents a request to create a destination policy
+type CreateDestinationPolicyRequest struct {
+	Name                     string
+	MXPattern                string
+	MaxConnections           int
+	MaxMessagesPerConnection int
+	MaxMessagesPerMinute     int
+	Backoff                  time.Duration
+	MaxBackoff               time.Duration
+}
+
+// UpdateDestinationPolicyRequest represents a request to update a destination policy
+type UpdateDestinationPolicyRequest struct {
+	Name                     *string
+	MXPattern                *string
+	MaxConnections           *int
+	MaxMessagesPerConnection *int
+	MaxMessagesPerMinute     *int
+	Backoff                  *time.Duration
+	MaxBackoff               *time.Duration
+	IsActive                 *bool
+}
+
+// destination holds the queue and connection state of one destination
+type destination struct {
+	key          string
+	policy       domain.DestinationPolicy
+	queue        []*domain.DeliveryJob
+	active       int
+	idle         []*pooledSession
+	open         int
+	sent         []time.Time
+	failures     int
+	backoffUntil time.Time
+	reasons      []*domain.DeferralReason
+}
+
+type pooledSession struct {
+	session  SMTPSession
+	host     string
+	sourceIP string
+	tlsLevel TLSLevel
+	messages int
+	lastUsed time.Time
+}
+
+type mxCacheEntry struct {
+	hosts     []string
+	expiresAt time.Time
+}
+
+// deliveryResult sorts the recipients of one attempt by outcome. Code and
+// message describe the deferral, bounceReason the permanent failure.
+type deliveryResult struct {
+	delivered    []string
+	deferred     []string
+	bounced      []string
+	unknown      []string
+	code         int
+	message      string
+	bounceReason string
+	throttled    bool // the destination asked us to slow down
+}
+
+// NewDeliveryService creates a new delivery service
+func NewDeliveryService(
+	policyRepo repository.DestinationPolicyRepository,
+	jobRepo repository.DeliveryJobRepository,
+	resolver MXResolver,
+	dialer SMTPDialer,
+	tls TLSPolicyProvider,
+	sources SourceSelector,
+	outcomes OutcomeRecorder,
+	sealer MessageSealer,
+	eventPub domain.EventPublisher,
+	config *DeliveryConfig,
+) *DeliveryService {
+	return &DeliveryService{
+		policyRepo:   policyRepo,
+		jobRepo:      jobRepo,
+		resolver:     resolver,
+		dialer:       dialer,
+		tls:          tls,
+		sources:      sources,
+		outcomes:     outcomes,
+		sealer:       sealer,
+		eventPub:     eventPub,
+		config:       config,
+		owner:        uuid.New().String(),
+		held:         make(map[string]bool),
+		destinations: make(map[string]*destination),
+		mxCache:      make(map[string]mxCacheEntry),
+		wake:         make(chan struct{}, 1),
+	}
+}
+
+// Enqueue splits a message into one job per recipient domain and stores the
+// jobs. A message is queued once per domain: jobs already stored for the
+// same message are skipped and not returned.
+func (s *DeliveryService) Enqueue(ctx context.Context, req EnqueueRequest) ([]*domain.DeliveryJob, error) {
+	if len(req.Recipients) == 0 {
+		return nil, errors.NewError(errors.ErrCodeInvalidRecipients, "At least one recipient is required")
+	}
+
+	byDomain := make(map[string][]string)
+	domains := []string{}
+	for _, recipient := range req.Recipients {
+		addr, err := mail.ParseAddress(recipient)
+		if err != nil {
+			return nil, errors.NewError(errors.ErrCodeInvalidRecipients, "Invalid recipient address").WithDetail("email", recipient)
+		}
+		at := strings.LastIndex(addr.Address, "@")
+		if at < 0 {
+			return nil, errors.NewError(errors.ErrCodeInvalidRecipients, "Invalid recipient address").WithDetail("email", recipient)
+		}
+		domainName := strings.ToLower(addr.Address[at+1:])
+		if _, ok := byDomain[domainName]; !ok {
+			domains = append(domains, domainName)
+		}
+		byDomain[domainName] = append(byDomain[domainName], addr.Address)
+	}
+
+	data := req.Data
+	if req.SealingDomain != "" && s.sealer != nil {
+		sealed, err := s.sealer.Seal(ctx, data, req.SealingDomain, req.AuthResults)
+		if err != nil {
+			return nil, err
+		}
+		data = sealed
+	}
+
+	now := time.Now()
+	jobs := make([]*domain.DeliveryJob, 0, len(domains))
+	for _, domainName := range domains {
+		job := &domain.DeliveryJob{
+			ID:            uuid.New().String(),
+			MessageID:     req.MessageID,
+			AccountID:     req.AccountID,
+			DomainID:      req.DomainID,
+			TenantID:      req.TenantID,
+			Stream:        req.Stream,
+			From:          req.From,
+			Recipients:    byDomain[domainName],
+			Domain:        domainName,
+			Data:          data,
+			QueuedAt:      now,
+			NextAttemptAt: now,
+		}
+		created, err := s.jobRepo.Create(ctx, job)
+		if err != nil {
+			return nil, errors.InternalError(err)
+		}
+		if created {
+			jobs = append(jobs, job)
+		}
+	}
+
+	s.notify()
+	return jobs, nil
+}
+
+// Run dispatches queued jobs until the context is cancelled, then waits for
+// in-flight deliveries and closes all connections
+func (s *DeliveryService) Run(ctx context.Context) {
+	if err := s.ReloadPolicies(ctx); err != nil {
+		// Log error but start with the default policy
+	}
+
+	interval := s.config.PollInterval
+	if interval <= 0 {
+		interval = time.Second
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		// A failed claim is retried on the next tick
+		_ = s.claim(ctx)
+		s.dispatch(ctx)
+
+		select {
+		case <-ctx.Done():
+			s.wg.Wait()
+			s.closeAll()
+			return
+		case <-ticker.C:
+		case <-s.wake:
+		}
+	}
+}
+
+// QueueStatus returns a snapshot of every destination with queued or
+// in-flight messages, open connections or recent deferrals
+func (s *DeliveryService) QueueStatus() []domain.DestinationQueueStatus {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	statuses := make([]domain.DestinationQueueStatus, 0, len(s.destinations))
+	for _, dest := range s.destinations {
+		statuses = append(statuses, dest.status(now))
+	}
+	sort.Slice(statuses, func(i, j int) bool {
+		if statuses[i].Queued != statuses[j].Queued {
+			return statuses[i].Queued > statuses[j].Queued
+		}
+		return statuses[i].Destination < statuses[j].Destination
+	})
+	return statuses
+}
+
+// DestinationStatus returns a snapshot of one destination
+func (s *DeliveryService) DestinationStatus(key string) (*domain.DestinationQueueStatus, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	dest, ok := s.destinations[strings.ToLower(key)]
+	if !ok {
+		return nil, errors.DestinationNotFound(key)
+	}
+	status := dest.status(time.Now())
+	return &status, nil
+}
+
+// ListPolicies lists destination policies
+func (s *DeliveryService) ListPolicies(ctx context.Context) ([]*domain.DestinationPolicy, error) {
+	policies, err := s.policyRepo.List(ctx)
+	if err != nil {
+		return nil, errors.InternalError(err)
+	}
+	return policies, nil
+}
+
+// GetPolicy retrieves a destination policy by ID
+func (s *DeliveryService) GetPolicy(ctx context.Context, id string) (*domain.DestinationPolicy, error) {
+	policy, err := s.policyRepo.GetByID(ctx, id)
+	if err != nil {
+		return nil, errors.InternalError(err)
+	}
+	if policy == nil {
+		return nil, errors.DestinationPolicyNotFound(id)
+	}
+	return policy, nil
+}
+
+// CreatePolicy creates a destination policy and applies it to new jobs
+func (s *DeliveryService) CreatePolicy(ctx context.Context, req CreateDestinationPolicyRequest) (*domain.DestinationPolicy, error) {
+	now := time.Now()
+	policy := &domain.DestinationPolicy{
+		ID:                       uuid.New().String(),
+		Name:                     req.Name,
+		MXPattern:                strings.ToLower(strings.TrimSpace(req.MXPattern)),
+		MaxConnections:           req.MaxConnections,
+		MaxMessagesPerConnection: req.MaxMessagesPerConnection,
+		MaxMessagesPerMinute:     req.MaxMessagesPerMinute,
+		Backoff:                  req.Backoff,
+		MaxBackoff:               req.MaxBackoff,
+		IsActive:                 true,
+		CreatedAt:                now,
+		UpdatedAt:                now,
+	}
+	if err := s.validatePolicy(ctx, policy); err != nil {
+		return nil, err
+	}
+
+	if err := s.policyRepo.Create(ctx, policy); err != nil {
+		return nil, errors.InternalError(err)
+	}
+	if err := s.ReloadPolicies(ctx); err != nil {
+		return nil, err
+	}
+
+	return policy, nil
+}
+
+// UpdatePolicy updates a destination policy. Limits apply immediately to the
+// destination already using the policy.
+func (s *DeliveryService) UpdatePolicy(ctx context.Context, id string, req UpdateDestinationPolicyRequest) (*domain.DestinationPolicy, error) {
+	policy, err := s.GetPolicy(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	if req.Name != nil {
+		policy.Name = *req.Name
+	}
+	if req.MXPattern != nil {
+		policy.MXPattern = strings.ToLower(strings.TrimSpace(*req.MXPattern))
+	}
+	if req.MaxConnections != nil {
+		policy.MaxConnections = *req.MaxConnections
+	}
+	if req.MaxMessagesPerConnection != nil {
+		policy.MaxMessagesPerConnection = *req.MaxMessagesPerConnection
+	}
+	if req.MaxMessagesPerMinute != nil {
+		policy.MaxMessagesPerMinute = *req.MaxMessagesPerMinute
+	}
+	if req.Backoff != nil {
+		policy.Backoff = *req.Backoff
+	}
+	if req.MaxBackoff != nil {
+		policy.MaxBackoff = *req.MaxBackoff
+	}
+	if req.IsActive != nil {
+		policy.IsActive = *req.IsActive
+	}
+	policy.UpdatedAt = time.Now()
+
+	if err := s.validatePolicy(ctx, policy); err != nil {
+		return nil, err
+	}
+	if err := s.policyRepo.Update(ctx, policy); err != nil {
+		return nil, errors.InternalError(err)
+	}
+	if err := s.ReloadPolicies(ctx); err != nil {
+		return nil, err
+	}
+
+	return policy, nil
+}
+
+// DeletePolicy deletes a destination policy. Jobs already queued under it
+// fall back to the default limits.
+func (s *DeliveryService) DeletePolicy(ctx context.Context, id string) error {
+	if _, err := s.GetPolicy(ctx, id); err != nil {
+		return err
+	}
+	if err := s.policyRepo.Delete(ctx, id); err != nil {
+		return errors.InternalError(err)
+	}
+	return s.ReloadPolicies(ctx)
+}
+
+// ReloadPolicies reads the active destination policies and applies changed
+// limits to existing destinations
+func (s *DeliveryService) ReloadPolicies(ctx context.Context) error {
+	policies, err := s.policyRepo.List(ctx)
+	if err != nil {
+		return errors.InternalError(err)
+	}
+
+	active := make([]*domain.DestinationPolicy, 0, len(policies))
+	byPattern := make(map[string]*domain.DestinationPolicy)
+	for _, policy := range policies {
+		if policy.IsActive {
+			active = append(active, policy)
+			byPattern[policy.MXPattern] = policy
+		}
+	}
+	// Most specific pattern first: exact hosts, then longer wildcards
+	sort.SliceStable(active, func(i, j int) bool {
+		wi, wj := strings.HasPrefix(active[i].MXPattern, "*."), strings.HasPrefix(active[j].MXPattern, "*.")
+		if wi != wj {
+			return !wi
+		}
+		return len(active[i].MXPattern) > len(active[j].MXPattern)
+	})
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.policies = active
+	for key, dest := range s.destinations {
+		if policy, ok := byPattern[key]; ok {
+			dest.policy = *policy
+		} else if dest.policy.ID != "" {
+			dest.policy = s.config.DefaultPolicy
+		}
+	}
+	return nil
+}
+
+// claim renews the leases of the jobs this instance holds and leases due
+// jobs up to MaxHeld, queueing them on their destinations
+func (s *DeliveryService) claim(ctx context.Context) error {
+	now := time.Now()
+	lease := s.config.Lease
+	if lease <= 0 {
+		lease = 10 * time.Minute
+	}
+	limit := s.config.MaxHeld
+	if limit <= 0 {
+		limit = 1000
+	}
+
+	s.mu.Lock()
+	held := make([]string, 0, len(s.held))
+	for id := range s.held {
+		held = append(held, id)
+	}
+	extend := len(held) > 0 && now.Sub(s.extendedAt) >= lease/2
+	s.mu.Unlock()
+
+	if extend || len(held) == 0 {
+		if extend {
+			if err := s.jobRepo.Extend(ctx, held, s.owner, now, lease); err != nil {
+				return errors.InternalError(err)
+			}
+		}
+		// Jobs claimed below get the same lease, so one renewal covers all
+		s.mu.Lock()
+		s.extendedAt = now
+		s.mu.Unlock()
+	}
+	if len(held) >= limit {
+		return nil
+	}
+
+	jobs, err := s.jobRepo.Claim(ctx, s.owner, now, limit-len(held), lease)
+	if err != nil {
+		return errors.InternalError(err)
+	}
+	for _, job := range jobs {
+		// Destinations are keyed by policy, so a failed MX lookup leaves the
+		// job on a per-domain destination until the next attempt resolves it
+		hosts, _ := s.lookupMX(ctx, job.Domain)
+
+		s.mu.Lock()
+		if !s.held[job.ID] {
+			s.held[job.ID] = true
+			dest := s.destinationLocked(job.Domain, hosts)
+			dest.queue = append(dest.queue, job)
+		}
+		s.mu.Unlock()
+	}
+	return nil
+}
+
+// dispatch starts deliveries on every destination that has spare
+// connections, rate budget and due jobs
+func (s *DeliveryService) dispatch(ctx context.Context) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	for key, dest := range s.destinations {
+		dest.closeIdle(now, s.config.IdleTimeout)
+		dest.pruneSent(now)
+
+		if now.Before(dest.backoffUntil) {
+			continue
+		}
+		for dest.active < maxInt(dest.policy.MaxConnections, 1) {
+			if dest.policy.MaxMessagesPerMinute > 0 && len(dest.sent) >= dest.policy.MaxMessagesPerMinute {
+				break
+			}
+			job := dest.nextDue(now)
+			if job == nil {
+				break
+			}
+			job.Attempts++
+			dest.active++
+			dest.sent = append(dest.sent, now)
+
+			s.wg.Add(1)
+			go s.deliver(ctx, dest, job)
+		}
+
+		if dest.idleAndEmpty(now) {
+			delete(s.destinations, key)
+		}
+	}
+}
+
+// deliver performs one delivery attempt of a job
+func (s *DeliveryService) deliver(ctx context.Context, dest *destination, job *domain.DeliveryJob) {
+	defer s.wg.Done()
+
+	var result deliveryResult
+	hosts, err := s.lookupMX(ctx, job.Domain)
+	switch {
+	case err != nil:
+		result = deliveryResult{deferred: job.Recipients, message: "MX lookup failed: " + err.Error()}
+	case len(hosts) == 1 && hosts[0] == ".":
+		// Null MX (RFC 7505): the domain does not accept mail
+		result = deliveryResult{bounced: job.Recipients, bounceReason: "Domain does not accept mail"}
+	default:
+		targets, err := s.targets(ctx, job.Domain, hosts)
+		if err != nil {
+			// No MX host satisfies the TLS policy; retry once it may have changed
+			result = deliveryResult{deferred: job.Recipients, message: err.Error()}
+			break
+		}
+		result = s.attempt(ctx, dest, job, targets)
+	}
+
+	s.finish(ctx, dest, job, result)
+}
+
+// targets returns the MX hosts with their TLS requirements, or the hosts
+// unchanged under opportunistic TLS when no TLS policy provider is set
+func (s *DeliveryService) targets(ctx context.Context, domainName string, hosts []string) ([]DeliveryTarget, error) {
+	if s.tls != nil {
+		return s.tls.Targets(ctx, domainName, hosts)
+	}
+	targets := make([]DeliveryTarget, 0, len(hosts))
+	for _, host := range hosts {
+		targets = append(targets, DeliveryTarget{Host: host})
+	}
+	return targets, nil
+}
+
+// attempt sends a job over a pooled or new session to one of the MX hosts.
+// Replies of 421, and 4xx replies outside RCPT, throttle the destination;
+// 4xx RCPT replies only defer that recipient.
+func (s *DeliveryService) attempt(ctx context.Context, dest *destination, job *domain.DeliveryJob, targets []DeliveryTarget) deliveryResult {
+	source := &domain.SourceAddress{HeloName: s.config.HeloName}
+	if s.sources != nil {
+		selected, err := s.sources.SelectSource(ctx, SourceRequest{
+			TenantID:    job.TenantID,
+			DomainID:    job.DomainID,
+			Stream:      job.Stream,
+			Destination: dest.key,
+		})
+		if err != nil {
+			// No source address is not the destination's fault, so it is not throttled
+			return deliveryResult{deferred: job.Recipients, message: err.Error()}
+		}
+		if selected != nil {
+			source = selected
+		}
+	}
+
+	pooled, err := s.acquire(ctx, dest, targets, source)
+	if err != nil {
+		var failure *TLSFailure
+		if stderrors.As(err, &failure) {
+			// A TLS policy failure is not a sign of load, so it is not throttled
+			return deliveryResult{deferred: job.Recipients, message: failure.Error()}
+		}
+		return sessionFailure(job.Recipients, err)
+	}
+
+	session := pooled.session
+	if s.config.CommandTimeout > 0 {
+		session.SetDeadline(time.Now().Add(s.config.CommandTimeout))
+	}
+
+	if err := session.Mail(job.From); err != nil {
+		s.release(dest, pooled, err)
+		return sessionFailure(job.Recipients, err)
+	}
+
+	result := deliveryResult{}
+	accepted := []string{}
+	for i, recipient := range job.Recipients {
+		err := session.Rcpt(recipient)
+		if err == nil {
+			accepted = append(accepted, recipient)
+			continue
+		}
+
+		code := replyCode(err)
+		if code == 0 || code == 421 {
+			// The session is gone, so nothing in this transaction was sent
+			s.release(dest, pooled, err)
+			pending := append(append(accepted, result.deferred...), job.Recipients[i:]...)
+			failure := sessionFailure(pending, err)
+			failure.bounced, failure.unknown, failure.bounceReason = result.bounced, result.unknown, result.bounceReason
+			return failure
+		}
+
+		switch {
+		case code >= 500 && isUnknownRecipientReply(err):
+			result.unknown = append(result.unknown, recipient)
+			result.bounceReason = formatReply(code, replyMessage(err))
+		case code >= 500:
+			result.bounced = append(result.bounced, recipient)
+			result.bounceReason = formatReply(code, replyMessage(err))
+		default:
+			result.deferred = append(result.deferred, recipient)
+			result.code, result.message = code, replyMessage(err)
+		}
+	}
+
+	if len(accepted) == 0 {
+		s.release(dest, pooled, nil)
+		return result
+	}
+
+	if err := s.sendData(session, job.Data); err != nil {
+		s.release(dest, pooled, err)
+		failure := sessionFailure(accepted, err)
+		failure.deferred = append(failure.deferred, result.deferred...)
+		failure.bounced = append(failure.bounced, result.bounced...)
+		failure.unknown = append(failure.unknown, result.unknown...)
+		if failure.bounceReason == "" {
+			failure.bounceReason = result.bounceReason
+		}
+		return failure
+	}
+
+	s.release(dest, pooled, nil)
+	result.delivered = accepted
+	return result
+}
+
+func (s *DeliveryService) sendData(session SMTPSession, data []byte) error {
+	w, err := session.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(data); err != nil {
+		w.Close()
+		return err
+	}
+	return w.Close()
+}
+
+// acquire returns an idle session from the source address to one of the
+// targets or dials a new one, closing other idle sessions when the
+// destination is at its connection limit. Idle sessions are only reused
+// when they were opened under the TLS level the target now requires.
+func (s *DeliveryService) acquire(ctx context.Context, dest *destination, targets []DeliveryTarget, source *domain.SourceAddress) (*pooledSession, error) {
+	s.mu.Lock()
+	for i, pooled := range dest.idle {
+		if pooled.sourceIP == source.IP && matchesTarget(targets, pooled) {
+			dest.idle = append(dest.idle[:i], dest.idle[i+1:]...)
+			s.mu.Unlock()
+			return pooled, nil
+		}
+	}
+	for dest.open >= maxInt(dest.policy.MaxConnections, 1) && len(dest.idle) > 0 {
+		oldest := dest.idle[0]
+		dest.idle = dest.idle[1:]
+		dest.open--
+		go closeSession(oldest.session)
+	}
+	dest.open++
+	s.mu.Unlock()
+
+	var lastErr error
+	for _, target := range targets {
+		target := target
+		req := DialRequest{
+			Host:      strings.TrimSuffix(target.Host, "."),
+			Port:      s.config.Port,
+			LocalAddr: source.IP,
+			HeloName:  source.HeloName,
+			TLS:       target.TLS,
+		}
+		if s.tls != nil {
+			req.ReportTLS = func(failure *TLSFailure) {
+				result := target.Report
+				if failure != nil {
+					result.ResultType = failure.Result
+				}
+				s.tls.RecordResult(ctx, result)
+			}
+		}
+		session, err := s.dialer.Dial(ctx, req)
+		if err == nil {
+			return &pooledSession{session: session, host: target.Host, sourceIP: source.IP, tlsLevel: target.level()}, nil
+		}
+		lastErr = err
+		// A 5xx greeting is final for this host only; try the next MX
+		if ctx.Err() != nil {
+			break
+		}
+	}
+
+	s.mu.Lock()
+	dest.open--
+	s.mu.Unlock()
+	if lastErr == nil {
+		lastErr = fmt.Errorf("no MX hosts")
+	}
+	return nil, lastErr
+}
+
+// release returns a session to the idle pool when it is still usable and
+// below the per-connection message limit, and closes it otherwise
+func (s *DeliveryService) release(dest *destination, pooled *pooledSession, err error) {
+	pooled.messages++
+	reusable := err == nil || (replyCode(err) != 0 && replyCode(err) != 421)
+	if limit := dest.policy.MaxMessagesPerConnection; limit > 0 && pooled.messages >= limit {
+		reusable = false
+	}
+	if reusable {
+		pooled.session.SetDeadline(time.Time{})
+		reusable = pooled.session.Reset() == nil
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !reusable {
+		dest.open--
+		go closeSession(pooled.session)
+		return
+	}
+	pooled.lastUsed = time.Now()
+	dest.idle = append(dest.idle, pooled)
+}
+
+// finish records the result of an attempt, stores deferred recipients for a
+// later attempt or removes the job, and reports final outcomes
+func (s *DeliveryService) finish(ctx context.Context, dest *destination, job *domain.DeliveryJob, result deliveryResult) {
+	now := time.Now()
+	reschedule := false
+
+	s.mu.Lock()
+	dest.active--
+	if result.throttled {
+		dest.failures++
+		dest.backoffUntil = now.Add(backoffDelay(dest.policy, dest.failures))
+	} else if len(result.delivered) > 0 {
+		dest.failures = 0
+	}
+	if len(result.deferred) > 0 {
+		dest.recordDeferral(result.code, result.message, now, s.config.MaxDeferralReasons)
+		job.LastError = formatReply(result.code, result.message)
+		if s.config.MaxAttempts > 0 && job.Attempts >= s.config.MaxAttempts {
+			result.bounced = append(result.bounced, result.deferred...)
+			result.bounceReason = "Delivery attempts exhausted: " + job.LastError
+			result.deferred = nil
+		} else {
+			job.NextAttemptAt = now.Add(s.retryDelay(job.Attempts))
+			if job.NextAttemptAt.Before(dest.backoffUntil) {
+				job.NextAttemptAt = dest.backoffUntil
+			}
+			reschedule = true
+		}
+	}
+	delete(s.held, job.ID)
+	destinationKey := dest.key
+	s.mu.Unlock()
+
+	// The attempt has happened even when the service is stopping. A job that
+	// cannot be updated is retried once its lease expires.
+	store := context.WithoutCancel(ctx)
+	if reschedule {
+		retry := *job
+		retry.Recipients = result.deferred
+		_, _ = s.jobRepo.Reschedule(store, &retry, s.owner)
+	} else {
+		_, _ = s.jobRepo.Complete(store, job.ID, s.owner)
+	}
+
+	s.recordOutcomes(ctx, job, result.delivered, domain.DeliveryOutcomeDelivered)
+	s.recordOutcomes(ctx, job, result.bounced, domain.DeliveryOutcomeBounced)
+	s.recordOutcomes(ctx, job, result.unknown, domain.DeliveryOutcomeUnknownRecipient)
+
+	if len(result.delivered) > 0 {
+		s.publish(ctx, job, domain.EventTypeMessageDelivered, map[string]interface{}{
+			"job_id":      job.ID,
+			"destination": destinationKey,
+			"recipients":  result.delivered,
+			"attempts":    job.Attempts,
+		})
+	}
+	if len(result.deferred) > 0 {
+		s.publish(ctx, job, domain.EventTypeMessageDeferred, map[string]interface{}{
+			"job_id":          job.ID,
+			"destination":     destinationKey,
+			"recipients":      result.deferred,
+			"reason":          job.LastError,
+			"next_attempt_at": job.NextAttemptAt,
+		})
+	}
+	if failed := append(append([]string{}, result.bounced...), result.unknown...); len(failed) > 0 {
+		s.publish(ctx, job, domain.EventTypeMessageBounced, map[string]interface{}{
+			"job_id":      job.ID,
+			"destination": destinationKey,
+			"recipients":  failed,
+			"reason":      result.bounceReason,
+		})
+	}
+
+	s.notify()
+}
+
+func (s *DeliveryService) recordOutcomes(ctx context.Context, job *domain.DeliveryJob, recipients []string, outcome domain.DeliveryOutcome) {
+	if s.outcomes == nil {
+		return
+	}
+	attempt := SendAttempt{AccountID: job.AccountID, DomainID: job.DomainID, TenantID: job.TenantID, Recipients: 1}
+	for range recipients {
+		if err := s.outcomes.RecordOutcome(ctx, attempt, outcome); err != nil {
+			// Log error but don't fail the delivery
+		}
+	}
+}
+
+func (s *DeliveryService) publish(ctx context.Context, job *domain.DeliveryJob, eventType string, data map[string]interface{}) {
+	aggregateID := job.MessageID
+	if aggregateID == "" {
+		aggregateID = job.ID
+	}
+	event := domain.NewBaseEvent(uuid.New().String(), aggregateID, eventType, data)
+	if err := s.eventPub.Publish(ctx, event); err != nil {
+		// Log error but don't fail the operation
+	}
+}
+
+// lookupMX returns the MX hosts of a domain in preference order, falling
+// back to the domain itself when it publishes no MX records
+func (s *DeliveryService) lookupMX(ctx context.Context, domainName string) ([]string, error) {
+	now := time.Now()
+	s.mu.Lock()
+	entry, ok := s.mxCache[domainName]
+	s.mu.Unlock()
+	if ok && now.Before(entry.expiresAt) {
+		return entry.hosts, nil
+	}
+
+	records, err := s.resolver.LookupMX(ctx, domainName)
+	if err != nil {
+		if dnsErr, ok := err.(*net.DNSError); !ok || !dnsErr.IsNotFound {
+			return nil, err
+		}
+		records = nil
+	}
+
+	sort.SliceStable(records, func(i, j int) bool { return records[i].Pref < records[j].Pref })
+	hosts := make([]string, 0, len(records))
+	for _, record := range records {
+		hosts = append(hosts, strings.ToLower(record.Host))
+	}
+	if len(hosts) == 0 {
+		hosts = []string{domainName}
+	}
+	if len(hosts) == 1 && strings.TrimSuffix(hosts[0], ".") == "" {
+		hosts = []string{"."}
+	}
+
+	if s.config.MXCacheTTL > 0 {
+		s.mu.Lock()
+		s.mxCache[domainName] = mxCacheEntry{hosts: hosts, expiresAt: now.Add(s.config.MXCacheTTL)}
+		s.mu.Unlock()
+	}
+	return hosts, nil
+}
+
+// destinationLocked returns the destination for a recipient domain, creating
+// it when needed. The caller must hold s.mu.
+func (s *DeliveryService) destinationLocked(domainName string, hosts []string) *destination {
+	key, policy := domainName, s.config.DefaultPolicy
+	if matched := s.matchPolicyLocked(hosts); matched != nil {
+		key, policy = matched.MXPattern, *matched
+	}
+
+	dest, ok := s.destinations[key]
+	if !ok {
+		dest = &destination{key: key, policy: policy}
+		s.destinations[key] = dest
+	}
+	return dest
+}
+
+// matchPolicyLocked returns the most specific policy matching any of the
+// hosts. The caller must hold s.mu.
+func (s *DeliveryService) matchPolicyLocked(hosts []string) *domain.DestinationPolicy {
+	for _, policy := range s.policies {
+		for _, host := range hosts {
+			if policy.MatchesHost(host) {
+				return policy
+			}
+		}
+	}
+	return nil
+}
+
+func (s *DeliveryService) validatePolicy(ctx context.Context, policy *domain.DestinationPolicy) error {
+	pattern := strings.TrimPrefix(policy.MXPattern, "*.")
+	if policy.Name == "" {
+		return errors.NewError(errors.ErrCodeValidationError, "Policy name is required")
+	}
+	if pattern == "" || strings.ContainsAny(pattern, "*/@ ") {
+		return errors.NewError(errors.ErrCodeValidationError, "Invalid MX pattern").WithDetail("mx_pattern", policy.MXPattern)
+	}
+	if policy.MaxConnections < 1 {
+		return errors.NewError(errors.ErrCodeValidationError, "Max connections must be at least 1")
+	}
+	if policy.MaxMessagesPerConnection < 0 || policy.MaxMessagesPerMinute < 0 || policy.Backoff < 0 || policy.MaxBackoff < 0 {
+		return errors.NewError(errors.ErrCodeValidationError, "Limits must not be negative")
+	}
+
+	policies, err := s.policyRepo.List(ctx)
+	if err != nil {
+		return errors.InternalError(err)
+	}
+	for _, existing := range policies {
+		if existing.ID != policy.ID && existing.MXPattern == policy.MXPattern {
+			return errors.NewError(errors.ErrCodeValidationError, "A policy already exists for this MX pattern").
+				WithDetail("mx_pattern", policy.MXPattern)
+		}
+	}
+	return nil
+}
+
+func (s *DeliveryService) retryDelay(attempts int) time.Duration {
+	delay := s.config.RetryDelay
+	if delay <= 0 {
+		delay = time.Minute
+	}
+	for i := 1; i < attempts; i++ {
+		delay *= 2
+		if s.config.MaxRetryDelay > 0 && delay >= s.config.MaxRetryDelay {
+			return s.config.MaxRetryDelay
+		}
+	}
+	return delay
+}
+
+func (s *DeliveryService) notify() {
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (s *DeliveryService) closeAll() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, dest := range s.destinations {
+		for _, pooled := range dest.idle {
+			closeSession(pooled.session)
+		}
+		dest.open -= len(dest.idle)
+		dest.idle = nil
+	}
+}
+
+// Helper functions
+
+// nextDue removes and returns the first job whose retry time has come
+func (d *destination) nextDue(now time.Time) *domain.DeliveryJob {
+	for i, job := range d.queue {
+		if !job.NextAttemptAt.After(now) {
+			d.queue = append(d.queue[:i], d.queue[i+1:]...)
+			return job
+		}
+	}
+	return nil
+}
+
+func (d *destination) pruneSent(now time.Time) {
+	cutoff := now.Add(-time.Minute)
+	i := 0
+	for i < len(d.sent) && !d.sent[i].After(cutoff) {
+		i++
+	}
+	d.sent = d.sent[i:]
+}
+
+func (d *destination) closeIdle(now time.Time, timeout time.Duration) {
+	if timeout <= 0 {
+		return
+	}
+	kept := d.idle[:0]
+	for _, pooled := range d.idle {
+		if now.Sub(pooled.lastUsed) >= timeout {
+			d.open--
+			go closeSession(pooled.session)
+			continue
+		}
+		kept = append(kept, pooled)
+	}
+	d.idle = kept
+}
+
+// idleAndEmpty reports whether the destination holds no state worth keeping
+func (d *destination) idleAndEmpty(now time.Time) bool {
+	return len(d.queue) == 0 && d.active == 0 && d.open == 0 && len(d.sent) == 0 && !now.Before(d.backoffUntil)
+}
+
+func (d *destination) recordDeferral(code int, message string, at time.Time, limit int) {
+	for _, reason := range d.reasons {
+		if reason.Code == code && reason.Message == message {
+			reason.Count++
+			reason.LastSeen = at
+			return
+		}
+	}
+
+	d.reasons = append(d.reasons, &domain.DeferralReason{Code: code, Message: message, Count: 1, LastSeen: at})
+	if limit <= 0 {
+		limit = 10
+	}
+	if len(d.reasons) > limit {
+		// Drop the reason seen least recently
+		oldest := 0
+		for i, reason := range d.reasons {
+			if reason.LastSeen.Before(d.reasons[oldest].LastSeen) {
+				oldest = i
+			}
+		}
+		d.reasons = append(d.reasons[:oldest], d.reasons[oldest+1:]...)
+	}
+}
+
+func (d *destination) status(now time.Time) domain.DestinationQueueStatus {
+	status := domain.DestinationQueueStatus{
+		Destination:     d.key,
+		PolicyID:        d.policy.ID,
+		PolicyName:      d.policy.Name,
+		Active:          d.active,
+		OpenConnections: d.open,
+	}
+	for _, job := range d.queue {
+		status.Queued++
+		if job.Attempts > 0 {
+			status.Deferred++
+		}
+	}
+	cutoff := now.Add(-time.Minute)
+	for _, sent := range d.sent {
+		if sent.After(cutoff) {
+			status.SentLastMinute++
+		}
+	}
+	if now.Before(d.backoffUntil) {
+		until := d.backoffUntil
+		status.BackoffUntil = &until
+	}
+	for _, reason := range d.reasons {
+		status.DeferralReasons = append(status.DeferralReasons, *reason)
+	}
+	sort.Slice(status.DeferralReasons, func(i, j int) bool {
+		return status.DeferralReasons[i].LastSeen.After(status.DeferralReasons[j].LastSeen)
+	})
+	return status
+}
+
+// sessionFailure defers or bounces all recipients after a session-level
+// error. Temporary failures throttle the destination.
+func sessionFailure(recipients []string, err error) deliveryResult {
+	code := replyCode(err)
+	if code >= 500 {
+		return deliveryResult{bounced: append([]string{}, recipients...), bounceReason: formatReply(code, replyMessage(err))}
+	}
+	return deliveryResult{
+		deferred:  append([]string{}, recipients...),
+		code:      code,
+		message:   replyMessage(err),
+		throttled: true,
+	}
+}
+
+func backoffDelay(policy domain.DestinationPolicy, failures int) time.Duration {
+	delay := policy.Backoff
+	if delay <= 0 {
+		return 0
+	}
+	for i := 1; i < failures; i++ {
+		delay *= 2
+		if policy.MaxBackoff > 0 && delay >= policy.MaxBackoff {
+			return policy.MaxBackoff
+		}
+	}
+	if policy.MaxBackoff > 0 && delay > policy.MaxBackoff {
+		return policy.MaxBackoff
+	}
+	return delay
+}
+
+func formatReply(code int, message string) string {
+	if code == 0 {
+		return message
+	}
+	return fmt.Sprintf("%d %s", code, message)
+}
+
+// matchesTarget reports whether a pooled session goes to one of the targets
+// at the TLS level it requires
+func matchesTarget(targets []DeliveryTarget, pooled *pooledSession) bool {
+	for _, target := range targets {
+		if target.Host == pooled.host && target.level() == pooled.tlsLevel {
+			return true
+		}
+	}
+	return false
+}
+
+func (t DeliveryTarget) level() TLSLevel {
+	if t.TLS == nil {
+		return TLSLevelOpportunistic
+	}
+	return t.TLS.Level
+}
+
+func closeSession(session SMTPSession) {
+	if err := session.Quit(); err != nil {
+		session.Close()
+	}
+}
+
+func maxInt(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,172 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net"
+	"net/textproto"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/skygenesisenterprise/aether-mailer/package/golang/domain"
+	"github.com/skygenesisenterprise/aether-mailer/package/golang/repository/inmemory"
+)
+
+// fakeMX resolves every domain to mx.<domain>
+type fakeMX struct{}
+
+func (fakeMX) LookupMX(ctx context.Context, name string) ([]*net.MX, error) {
+	return []*net.MX{{Host: "mx." + name + ".", Pref: 10}}, nil
+}
+
+// fakeSMTP accepts every recipient except the deferred ones
+type fakeSMTP struct {
+	mu        sync.Mutex
+	deferred  map[string]bool
+	delivered map[string][]string // recipient to delivered data
+}
+
+func (f *fakeSMTP) Dial(ctx context.Context, req DialRequest) (SMTPSession, error) {
+	return &fakeSMTPSession{server: f}, nil
+}
+
+type fakeSMTPSession struct {
+	server     *fakeSMTP
+	recipients []string
+	data       bytes.Buffer
+}
+
+func (s *fakeSMTPSession) Mail(from string) error { return nil }
+
+func (s *fakeSMTPSession) Rcpt(to string) error {
+	if s.server.deferred[to] {
+		return &textproto.Error{Code: 451, Msg: "try again later"}
+	}
+	s.recipients = append(s.recipients, to)
+	return nil
+}
+
+func (s *fakeSMTPSession) Data() (io.WriteCloser, error) { return s, nil }
+
+func (s *fakeSMTPSession) Write(p []byte) (int, error) { return s.data.Write(p) }
+
+func (s *fakeSMTPSession) Close() error {
+	s.server.mu.Lock()
+	defer s.server.mu.Unlock()
+	for _, recipient := range s.recipients {
+		s.server.delivered[recipient] = append(s.server.delivered[recipient], s.data.String())
+	}
+	s.recipients = nil
+	s.data.Reset()
+	return nil
+}
+
+func (s *fakeSMTPSession) Reset() error                  { return nil }
+func (s *fakeSMTPSession) Quit() error                   { return nil }
+func (s *fakeSMTPSession) SetDeadline(t time.Time) error { return nil }
+
+func TestDeliveryServiceQueuesSentMessages(t *testing.T) {
+	ctx := context.Background()
+	m := newTestMail(t)
+	alice := m.newAccount(t, m.newUser(t, "alice"), "alice")
+	messages := NewMessageService(m.messageDeps())
+
+	message, err := messages.SendMessage(ctx, SendMessageRequest{
+		AccountID: alice.ID,
+		From:      alice.Email,
+		To:        []string{"bob@one.example"},
+		Cc:        []string{"carol@two.example"},
+		Bcc:       []string{"dave@one.example"},
+		Subject:   "Quarterly report",
+		BodyText:  stringPtr("See you tomorrow"),
+	})
+	if err != nil {
+		t.Fatalf("SendMessage: %v", err)
+	}
+
+	jobs := inmemory.NewDeliveryJobRepository(m.store)
+	smtp := &fakeSMTP{deferred: map[string]bool{"carol@two.example": true}, delivered: map[string][]string{}}
+	newDelivery := func() *DeliveryService {
+		return NewDeliveryService(inmemory.NewDestinationPolicyRepository(m.store), jobs, fakeMX{}, smtp,
+			nil, nil, nil, nil, m.events, &DeliveryConfig{
+				RetryDelay:    time.Hour,
+				Lease:         time.Minute,
+				DefaultPolicy: domain.DestinationPolicy{MaxConnections: 2},
+			})
+	}
+	first, second := newDelivery(), newDelivery()
+
+	// The relay may deliver the event again after a failure
+	outbound := NewOutboundQueue(m.messages, m.accounts, m.domains, nil, first)
+	sent := m.events.EventsOfType(domain.EventTypeMessageSent)
+	if len(sent) != 1 || sent[0].AggregateID() != message.ID {
+		t.Fatalf("MESSAGE_SENT events = %d, want 1 for the message", len(sent))
+	}
+	for i := 0; i < 2; i++ {
+		if err := outbound.Handle(ctx, sent[0]); err != nil {
+			t.Fatalf("Handle: %v", err)
+		}
+	}
+	if count, _ := jobs.Count(ctx); count != 2 {
+		t.Fatalf("queued jobs = %d, want one per recipient domain", count)
+	}
+
+	// Jobs leased by one instance are not dispatched by another
+	if err := first.claim(ctx); err != nil {
+		t.Fatalf("claim: %v", err)
+	}
+	if err := second.claim(ctx); err != nil {
+		t.Fatalf("claim: %v", err)
+	}
+	if queued := queuedJobs(first); queued != 2 {
+		t.Errorf("first instance holds %d jobs, want 2", queued)
+	}
+	if queued := queuedJobs(second); queued != 0 {
+		t.Errorf("second instance holds %d jobs, want 0", queued)
+	}
+
+	first.dispatch(ctx)
+	first.wg.Wait()
+
+	for _, recipient := range []string{"bob@one.example", "dave@one.example"} {
+		data := smtp.delivered[recipient]
+		if len(data) != 1 {
+			t.Fatalf("%s received %d messages, want 1", recipient, len(data))
+		}
+		if !strings.Contains(data[0], "Subject: Quarterly report\r\n") || !strings.Contains(data[0], "See you tomorrow") {
+			t.Errorf("%s received %q", recipient, data[0])
+		}
+		if strings.Contains(data[0], "dave@one.example") {
+			t.Errorf("the Bcc recipient is visible in %q", data[0])
+		}
+	}
+
+	// The deferred job is stored for a later attempt and its lease released
+	if count, _ := jobs.Count(ctx); count != 1 {
+		t.Fatalf("queued jobs after delivery = %d, want the deferred job", count)
+	}
+	claimed, err := jobs.Claim(ctx, "other", time.Now().Add(2*time.Hour), 10, time.Minute)
+	if err != nil {
+		t.Fatalf("Claim: %v", err)
+	}
+	if len(claimed) != 1 || claimed[0].Domain != "two.example" || claimed[0].Attempts != 1 ||
+		claimed[0].LastError != "451 try again later" || !claimed[0].NextAttemptAt.After(time.Now()) {
+		t.Fatalf("deferred job = %+v", claimed)
+	}
+	if len(first.held) != 0 {
+		t.Errorf("first instance still holds %d jobs", len(first.held))
+	}
+}
+
+// queuedJobs counts the jobs waiting on the destinations of a delivery
+// service
+func queuedJobs(s *DeliveryService) int {
+	queued := 0
+	for _, status := range s.QueueStatus() {
+		queued += status.Queued
+	}
+	return queued
+}
@@ -0,0 +1,366 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/tls"
+	"crypto/x509"
+	stderrors "errors"
+	"fmt"
+	"io"
+	"net"
+	"net/smtp"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/skygenesisenterprise/aether-mailer/package/golang/domain"
+)
+
+// SMTPSession is an open SMTP connection that can carry several
+// transactions
+type SMTPSession interface {
+	Mail(from string) error
+	Rcpt(to string) error
+	Data() (io.WriteCloser, error)
+	Reset() error
+	Quit() error
+	Close() error
+	SetDeadline(t time.Time) error
+}
+
+// SMTPDialer opens SMTP sessions to remote mail servers
+type SMTPDialer interface {
+	Dial(ctx context.Context, req DialRequest) (SMTPSession, error)
+}
+
+// DialRequest describes the SMTP session to open
+type DialRequest struct {
+	Host      string
+	Port      int
+	LocalAddr string // source IP, empty lets the system choose
+	HeloName  string
+	TLS       *TLSRequirement // nil negotiates opportunistic TLS
+	// ReportTLS, when set, receives the outcome of TLS negotiation: nil for
+	// a validated session, or the failure
+	ReportTLS func(failure *TLSFailure)
+}
+
+// TLSLevel is the protection required from an SMTP session
+type TLSLevel int
+
+const (
+	// TLSLevelOpportunistic encrypts when the server offers STARTTLS
+	TLSLevelOpportunistic TLSLevel = iota
+	// TLSLevelEncrypt requires TLS without authenticating the server, used
+	// when a host publishes only unusable TLSA records (RFC 7672 section 2.2)
+	TLSLevelEncrypt
+	// TLSLevelWebPKI requires a certificate chaining to a trusted root and
+	// valid for the MX host name (MTA-STS)
+	TLSLevelWebPKI
+	// TLSLevelDANE requires a certificate matching the host's TLSA records
+	TLSLevelDANE
+)
+
+// TLSRequirement is the TLS policy applied to one MX host
+type TLSRequirement struct {
+	Level TLSLevel
+	// Enforce refuses the session on failure. Policies in testing mode
+	// only report failures.
+	Enforce bool
+	TLSA    []domain.TLSARecord // usable records for TLSLevelDANE
+}
+
+// TLSFailure is a STARTTLS or certificate validation failure, classified by
+// its TLS-RPT result type
+type TLSFailure struct {
+	Result domain.TLSResultType
+	Err    error
+}
+
+func (f *TLSFailure) Error() string {
+	return fmt.Sprintf("%s: %v", f.Result, f.Err)
+}
+
+func (f *TLSFailure) Unwrap() error {
+	return f.Err
+}
+
+// NetSMTPDialer opens SMTP sessions over TCP and upgrades them with STARTTLS
+// when the server offers it
+type NetSMTPDialer struct {
+	Timeout   time.Duration
+	TLSConfig *tls.Config // nil negotiates TLS without verifying the certificate
+}
+
+// NewNetSMTPDialer creates a dialer with the given connect and greeting timeout
+func NewNetSMTPDialer(timeout time.Duration) *NetSMTPDialer {
+	return &NetSMTPDialer{Timeout: timeout}
+}
+
+// Dial connects to the host, reads the greeting, sends EHLO and starts TLS
+func (d *NetSMTPDialer) Dial(ctx context.Context, req DialRequest) (SMTPSession, error) {
+	dialer := &net.Dialer{Timeout: d.Timeout}
+	if req.LocalAddr != "" {
+		ip := net.ParseIP(req.LocalAddr)
+		if ip == nil {
+			return nil, fmt.Errorf("invalid source address %q", req.LocalAddr)
+		}
+		dialer.LocalAddr = &net.TCPAddr{IP: ip}
+	}
+	conn, err := dialer.DialContext(ctx, "tcp", net.JoinHostPort(req.Host, strconv.Itoa(req.Port)))
+	if err != nil {
+		return nil, err
+	}
+	if d.Timeout > 0 {
+		conn.SetDeadline(time.Now().Add(d.Timeout))
+	}
+
+	client, err := smtp.NewClient(conn, req.Host)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if req.HeloName != "" {
+		if err := client.Hello(req.HeloName); err != nil {
+			client.Close()
+			return nil, err
+		}
+	}
+
+	ok, _ := client.Extension("STARTTLS")
+	if !ok {
+		if req.TLS != nil {
+			failure := &TLSFailure{Result: domain.TLSResultStartTLSNotSupported, Err: fmt.Errorf("%s does not offer STARTTLS", req.Host)}
+			req.reportTLS(failure)
+			if req.TLS.Enforce && req.TLS.Level > TLSLevelOpportunistic {
+				client.Close()
+				return nil, failure
+			}
+		}
+		conn.SetDeadline(time.Time{})
+		return &netSMTPSession{Client: client, conn: conn}, nil
+	}
+
+	var verifyFailure *TLSFailure
+	config := d.tlsConfig(req, &verifyFailure)
+	if err := client.StartTLS(config); err != nil {
+		client.Close()
+		if req.TLS == nil {
+			return nil, err
+		}
+		failure := verifyFailure
+		if failure == nil {
+			failure = &TLSFailure{Result: domain.TLSResultValidationFailure, Err: err}
+		}
+		req.reportTLS(failure)
+		return nil, failure
+	}
+	req.reportTLS(verifyFailure)
+
+	conn.SetDeadline(time.Time{})
+	return &netSMTPSession{Client: client, conn: conn}, nil
+}
+
+// tlsConfig builds the STARTTLS configuration for a request. Certificates
+// required by a TLS policy are checked in VerifyConnection so failures can be
+// classified, and in testing mode recorded without failing the handshake.
+func (d *NetSMTPDialer) tlsConfig(req DialRequest, verifyFailure **TLSFailure) *tls.Config {
+	host := strings.TrimSuffix(req.Host, ".")
+	config := d.TLSConfig
+	if config == nil {
+		// Opportunistic TLS (RFC 3207): MX certificates are commonly not
+		// valid for the MX host name, so they are not verified here
+		config = &tls.Config{InsecureSkipVerify: true}
+	}
+	config = config.Clone()
+	if config.ServerName == "" {
+		config.ServerName = host
+	}
+	if req.TLS == nil || req.TLS.Level < TLSLevelWebPKI {
+		if req.TLS != nil && req.TLS.Level == TLSLevelEncrypt {
+			config.InsecureSkipVerify = true
+		}
+		return config
+	}
+
+	requirement := *req.TLS
+	roots := config.RootCAs
+	config.ServerName = host
+	config.InsecureSkipVerify = true
+	config.VerifyConnection = func(state tls.ConnectionState) error {
+		var failure *TLSFailure
+		if requirement.Level == TLSLevelDANE {
+			failure = verifyDANE(state.PeerCertificates, requirement.TLSA, host)
+		} else {
+			failure = verifyWebPKI(state.PeerCertificates, host, roots)
+		}
+		if failure == nil {
+			return nil
+		}
+		*verifyFailure = failure
+		if requirement.Enforce {
+			return failure
+		}
+		return nil
+	}
+	return config
+}
+
+func (r DialRequest) reportTLS(failure *TLSFailure) {
+	if r.ReportTLS != nil {
+		r.ReportTLS(failure)
+	}
+}
+
+// netSMTPSession exposes the connection deadline of an smtp.Client
+type netSMTPSession struct {
+	*smtp.Client
+	conn net.Conn
+}
+
+func (s *netSMTPSession) SetDeadline(t time.Time) error {
+	return s.conn.SetDeadline(t)
+}
+
+// replyCode returns the SMTP reply code carried by err, or 0 for network
+// and other errors
+func replyCode(err error) int {
+	var protoErr *textproto.Error
+	if stderrors.As(err, &protoErr) {
+		return protoErr.Code
+	}
+	return 0
+}
+
+// replyMessage returns the server text of an SMTP reply error
+func replyMessage(err error) string {
+	var protoErr *textproto.Error
+	if stderrors.As(err, &protoErr) {
+		return protoErr.Msg
+	}
+	return err.Error()
+}
+
+// isUnknownRecipientReply reports whether a permanent RCPT reply says the
+// mailbox does not exist (enhanced status 5.1.x, or 550/551/553 without one)
+func isUnknownRecipientReply(err error) bool {
+	code := replyCode(err)
+	if code < 500 {
+		return false
+	}
+	msg := strings.TrimSpace(replyMessage(err))
+	if strings.HasPrefix(msg, "5.1.") {
+		return true
+	}
+	if len(msg) > 1 && msg[0] == '5' && msg[1] == '.' {
+		return false
+	}
+	return code == 550 || code == 551 || code == 553
+}
+
+// verifyWebPKI checks that the certificate chains to a trusted root and is
+// valid for host. A nil roots pool uses the system roots.
+func verifyWebPKI(certs []*x509.Certificate, host string, roots *x509.CertPool) *TLSFailure {
+	if len(certs) == 0 {
+		return &TLSFailure{Result: domain.TLSResultValidationFailure, Err: fmt.Errorf("no server certificate")}
+	}
+	intermediates := x509.NewCertPool()
+	for _, cert := range certs[1:] {
+		intermediates.AddCert(cert)
+	}
+	_, err := certs[0].Verify(x509.VerifyOptions{DNSName: host, Roots: roots, Intermediates: intermediates})
+	if err == nil {
+		return nil
+	}
+	return &TLSFailure{Result: certificateResult(err), Err: err}
+}
+
+// verifyDANE checks the certificate chain against DANE-EE and DANE-TA TLSA
+// records (RFC 7672 section 3). DANE-EE matches the leaf certificate alone,
+// without name or expiry checks; DANE-TA matches a chain certificate that
+// must then issue a leaf valid for host.
+func verifyDANE(certs []*x509.Certificate, records []domain.TLSARecord, host string) *TLSFailure {
+	if len(certs) == 0 {
+		return &TLSFailure{Result: domain.TLSResultValidationFailure, Err: fmt.Errorf("no server certificate")}
+	}
+	var lastErr error
+	for _, record := range records {
+		switch record.Usage {
+		case 3:
+			if matchTLSA(certs[0], record) {
+				return nil
+			}
+		case 2:
+			for i, anchor := range certs {
+				if !matchTLSA(anchor, record) {
+					continue
+				}
+				if i == 0 {
+					// The server sent only the trust anchor
+					return nil
+				}
+				roots := x509.NewCertPool()
+				roots.AddCert(anchor)
+				intermediates := x509.NewCertPool()
+				for _, cert := range certs[1:i] {
+					intermediates.AddCert(cert)
+				}
+				_, err := certs[0].Verify(x509.VerifyOptions{DNSName: host, Roots: roots, Intermediates: intermediates})
+				if err == nil {
+					return nil
+				}
+				lastErr = err
+			}
+		}
+	}
+	if lastErr != nil {
+		return &TLSFailure{Result: certificateResult(lastErr), Err: lastErr}
+	}
+	return &TLSFailure{Result: domain.TLSResultValidationFailure, Err: fmt.Errorf("no TLSA record matches the certificate of %s", host)}
+}
+
+// matchTLSA reports whether a certificate matches a TLSA record's selector
+// and matching type
+func matchTLSA(cert *x509.Certificate, record domain.TLSARecord) bool {
+	var data []byte
+	switch record.Selector {
+	case 0:
+		data = cert.Raw
+	case 1:
+		data = cert.RawSubjectPublicKeyInfo
+	default:
+		return false
+	}
+	switch record.MatchingType {
+	case 0:
+		return bytes.Equal(data, record.Data)
+	case 1:
+		sum := sha256.Sum256(data)
+		return bytes.Equal(sum[:], record.Data)
+	case 2:
+		sum := sha512.Sum512(data)
+		return bytes.Equal(sum[:], record.Data)
+	}
+	return false
+}
+
+// certificateResult maps a certificate verification error to its TLS-RPT
+// result type
+func certificateResult(err error) domain.TLSResultType {
+	var hostErr x509.HostnameError
+	var invalidErr x509.CertificateInvalidError
+	var authorityErr x509.UnknownAuthorityError
+	switch {
+	case stderrors.As(err, &hostErr):
+		return domain.TLSResultCertificateHostMismatch
+	case stderrors.As(err, &invalidErr) && invalidErr.Reason == x509.Expired:
+		return domain.TLSResultCertificateExpired
+	case stderrors.As(err, &authorityErr):
+		return domain.TLSResultCertificateNotTrusted
+	}
+	return domain.TLSResultValidationFailure
+}
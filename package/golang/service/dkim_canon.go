@@ -0,0 +1,315 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/skygenesisenterprise/aether-mailer/package/golang/domain"
+)
+
+// Shared DKIM primitives (RFC 6376) used by the ARC sealer and validator:
+// relaxed canonicalization, tag lists, and signing or verifying with the
+// keys published under <selector>._domainkey.<domain>.
+
+// rawHeader is a header field as it appears in a message, folding included
+type rawHeader struct {
+	name string
+	raw  string // "Name: value" with its folding, without the final CRLF
+}
+
+// value returns the field body after the colon
+func (h rawHeader) value() string {
+	_, value, _ := strings.Cut(h.raw, ":")
+	return value
+}
+
+var (
+	wspRun      = regexp.MustCompile(`[ \t]+`)
+	signatureB  = regexp.MustCompile(`(^|;)(\s*b\s*=)[^;]*`)
+	foldedBreak = regexp.MustCompile(`\r\n([ \t])`)
+)
+
+// splitMessage normalizes line endings to CRLF and splits a message into
+// its header fields and body
+func splitMessage(data []byte) ([]rawHeader, []byte) {
+	normalized := bytes.ReplaceAll(data, []byte("\r\n"), []byte("\n"))
+	normalized = bytes.ReplaceAll(normalized, []byte("\n"), []byte("\r\n"))
+
+	head, body, found := bytes.Cut(normalized, []byte("\r\n\r\n"))
+	if !found {
+		head = bytes.TrimSuffix(normalized, []byte("\r\n"))
+		body = nil
+	}
+
+	headers := []rawHeader{}
+	for _, line := range strings.Split(string(head), "\r\n") {
+		if line == "" {
+			continue
+		}
+		if (line[0] == ' ' || line[0] == '\t') && len(headers) > 0 {
+			headers[len(headers)-1].raw += "\r\n" + line
+			continue
+		}
+		name, _, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		headers = append(headers, rawHeader{name: strings.TrimSpace(name), raw: line})
+	}
+	return headers, body
+}
+
+// joinMessage rebuilds a message from header fields and a body
+func joinMessage(headers []rawHeader, body []byte) []byte {
+	var buf bytes.Buffer
+	for _, header := range headers {
+		buf.WriteString(header.raw)
+		buf.WriteString("\r\n")
+	}
+	buf.WriteString("\r\n")
+	buf.Write(body)
+	return buf.Bytes()
+}
+
+// Canonicalization algorithms of the c= tag
+const (
+	canonicalizationSimple  = "simple"
+	canonicalizationRelaxed = "relaxed"
+)
+
+// canonicalization holds the header and body algorithms of a c= tag
+type canonicalization struct {
+	header string
+	body   string
+}
+
+// parseCanonicalization parses a c= value. A missing value or body
+// algorithm defaults to simple (RFC 6376 section 3.5).
+func parseCanonicalization(value string) (canonicalization, error) {
+	header, body, _ := strings.Cut(strings.ToLower(strings.TrimSpace(value)), "/")
+	c := canonicalization{header: header, body: body}
+	if c.header == "" {
+		c.header = canonicalizationSimple
+	}
+	if c.body == "" {
+		c.body = canonicalizationSimple
+	}
+	for _, algorithm := range []string{c.header, c.body} {
+		if algorithm != canonicalizationSimple && algorithm != canonicalizationRelaxed {
+			return c, fmt.Errorf("unsupported canonicalization %q", value)
+		}
+	}
+	return c, nil
+}
+
+// canonicalizeHeader applies the header algorithm to a raw field and
+// returns it without a trailing CRLF
+func (c canonicalization) canonicalizeHeader(raw string) string {
+	if c.header == canonicalizationRelaxed {
+		return canonicalizeHeaderRelaxed(raw)
+	}
+	return raw
+}
+
+// canonicalizeBody applies the body algorithm
+func (c canonicalization) canonicalizeBody(body []byte) []byte {
+	if c.body == canonicalizationRelaxed {
+		return canonicalizeBodyRelaxed(body)
+	}
+	return canonicalizeBodySimple(body)
+}
+
+// canonicalizeHeaderRelaxed applies the relaxed header canonicalization
+// and returns "name:value" without a trailing CRLF
+func canonicalizeHeaderRelaxed(raw string) string {
+	name, value, _ := strings.Cut(raw, ":")
+	value = foldedBreak.ReplaceAllString(value, "$1")
+	value = strings.ReplaceAll(value, "\r\n", "")
+	value = wspRun.ReplaceAllString(value, " ")
+	return strings.ToLower(strings.TrimSpace(name)) + ":" + strings.TrimSpace(value)
+}
+
+// canonicalizeBodyRelaxed applies the relaxed body canonicalization
+func canonicalizeBodyRelaxed(body []byte) []byte {
+	lines := strings.Split(string(body), "\r\n")
+	for i, line := range lines {
+		lines[i] = strings.TrimRight(wspRun.ReplaceAllString(line, " "), " ")
+	}
+	for len(lines) > 0 && lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	if len(lines) == 0 {
+		return nil
+	}
+	return []byte(strings.Join(lines, "\r\n") + "\r\n")
+}
+
+// canonicalizeBodySimple applies the simple body canonicalization: trailing
+// empty lines are removed and an empty body becomes a single CRLF
+func canonicalizeBodySimple(body []byte) []byte {
+	for bytes.HasSuffix(body, []byte("\r\n")) {
+		body = body[:len(body)-2]
+	}
+	return append(append([]byte{}, body...), '\r', '\n')
+}
+
+// bodyHash returns the bh= value of a body under relaxed canonicalization
+func bodyHash(body []byte) string {
+	return hashCanonicalBody(canonicalizeBodyRelaxed(body))
+}
+
+// hashCanonicalBody returns the bh= value of a canonicalized body
+func hashCanonicalBody(canonical []byte) string {
+	sum := sha256.Sum256(canonical)
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
+
+// parseTagList parses a tag=value list. Tag names are lowercased; values
+// keep their inner whitespace except for b= and bh=, which are base64.
+func parseTagList(value string) map[string]string {
+	tags := make(map[string]string)
+	for _, part := range strings.Split(value, ";") {
+		name, tagValue, ok := strings.Cut(part, "=")
+		if !ok {
+			continue
+		}
+		name = strings.ToLower(strings.TrimSpace(name))
+		tagValue = strings.TrimSpace(foldedBreak.ReplaceAllString(tagValue, "$1"))
+		if name == "b" || name == "bh" {
+			tagValue = strings.Join(strings.Fields(tagValue), "")
+		}
+		if _, exists := tags[name]; !exists {
+			tags[name] = tagValue
+		}
+	}
+	return tags
+}
+
+// stripSignature empties the b= tag of a signature header field
+func stripSignature(raw string) string {
+	name, value, _ := strings.Cut(raw, ":")
+	return name + ":" + signatureB.ReplaceAllString(value, "$1$2")
+}
+
+// selectSignedHeaders returns the fields named in an h= list, each
+// canonicalized by canonicalize. Repeated names consume instances from the
+// bottom of the header up, and names without a remaining instance
+// contribute nothing.
+func selectSignedHeaders(headers []rawHeader, names []string, canonicalize func(string) string) string {
+	used := make(map[int]bool)
+	var buf strings.Builder
+	for _, name := range names {
+		for i := len(headers) - 1; i >= 0; i-- {
+			if used[i] || !strings.EqualFold(headers[i].name, name) {
+				continue
+			}
+			used[i] = true
+			buf.WriteString(canonicalize(headers[i].raw))
+			buf.WriteString("\r\n")
+			break
+		}
+	}
+	return buf.String()
+}
+
+// signatureAlgorithm returns the a= value for a signing key
+func signatureAlgorithm(algorithm domain.DKIMAlgorithm) string {
+	if algorithm == domain.DKIMAlgorithmEd25519 {
+		return "ed25519-sha256"
+	}
+	return "rsa-sha256"
+}
+
+// signDKIM signs canonicalized data and returns the base64 b= value. Both
+// algorithms sign the SHA-256 digest of the data (RFC 8463).
+func signDKIM(privateKey interface{}, data string) (string, error) {
+	digest := sha256.Sum256([]byte(data))
+	var signature []byte
+	var err error
+	switch key := privateKey.(type) {
+	case *rsa.PrivateKey:
+		signature, err = rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+	case ed25519.PrivateKey:
+		signature = ed25519.Sign(key, digest[:])
+	default:
+		err = fmt.Errorf("unsupported key type %T", privateKey)
+	}
+	if err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(signature), nil
+}
+
+// verifyDKIM checks a b= value against the key published for a selector
+func verifyDKIM(ctx context.Context, resolver TXTResolver, signer, selector, algorithm, data, b string) error {
+	if signer == "" || selector == "" {
+		return fmt.Errorf("missing d= or s= tag")
+	}
+	signature, err := base64.StdEncoding.DecodeString(b)
+	if err != nil || len(signature) == 0 {
+		return fmt.Errorf("malformed signature")
+	}
+
+	records, err := resolver.LookupTXT(ctx, selector+"._domainkey."+strings.TrimSuffix(signer, "."))
+	if err != nil {
+		return fmt.Errorf("key lookup for %s: %w", selector, err)
+	}
+	keyTags := parseTagList(strings.Join(records, ""))
+	publicKey := strings.Join(strings.Fields(keyTags["p"]), "")
+	if publicKey == "" {
+		return fmt.Errorf("no key published for %s", selector)
+	}
+	keyData, err := base64.StdEncoding.DecodeString(publicKey)
+	if err != nil {
+		return fmt.Errorf("malformed key for %s", selector)
+	}
+
+	digest := sha256.Sum256([]byte(data))
+	switch strings.ToLower(algorithm) {
+	case "rsa-sha256":
+		rsaKey, err := x509.ParsePKCS1PublicKey(keyData)
+		if err != nil {
+			parsed, err := x509.ParsePKIXPublicKey(keyData)
+			if err != nil {
+				return fmt.Errorf("malformed key for %s", selector)
+			}
+			var ok bool
+			if rsaKey, ok = parsed.(*rsa.PublicKey); !ok {
+				return fmt.Errorf("key for %s is not an RSA key", selector)
+			}
+		}
+		return rsa.VerifyPKCS1v15(rsaKey, crypto.SHA256, digest[:], signature)
+	case "ed25519-sha256":
+		if len(keyData) != ed25519.PublicKeySize {
+			return fmt.Errorf("malformed key for %s", selector)
+		}
+		if !ed25519.Verify(ed25519.PublicKey(keyData), digest[:], signature) {
+			return fmt.Errorf("signature verification failed")
+		}
+		return nil
+	}
+	return fmt.Errorf("unsupported algorithm %q", algorithm)
+}
+
+// foldBase64 folds a long base64 value so header lines stay short
+func foldBase64(value string) string {
+	const width = 72
+	var buf strings.Builder
+	for len(value) > width {
+		buf.WriteString(value[:width])
+		buf.WriteString("\r\n\t ")
+		value = value[width:]
+	}
+	buf.WriteString(value)
+	return buf.String()
+}
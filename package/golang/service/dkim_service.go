@@ -0,0 +1,610 @@
+package service
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/skygenesisenterprise/aether-mailer/package/golang/domain"
+	"github.com/skygenesisenterprise/aether-mailer/package/golang/errors"
+	"github.com/skygenesisenterprise/aether-mailer/package/golang/repository"
+)
+
+// DKIMService manages per-domain DKIM keys and rotates them with a
+// dual-selector overlap: a new key is generated under a fresh selector and
+// published, signing switches to it only once its record is visible in DNS,
+// and the previous key stays published for a grace period so messages
+// signed before the switch still verify. Private keys are stored encrypted
+// and every step is recorded in the rotation log.
+type DKIMService struct {
+	keyRepo    repository.DKIMKeyRepository
+	logRepo    repository.DKIMRotationLogRepository
+	domainRepo repository.DomainRepository
+	resolver   TXTResolver
+	publisher  DKIMRecordPublisher
+	encrypter  KeyEncrypter
+	eventPub   domain.EventPublisher
+	config     *DKIMConfig
+}
+
+// DKIMConfig defines DKIM key and rotation settings
+type DKIMConfig struct {
+	Algorithm          domain.DKIMAlgorithm
+	KeyBits            int           // RSA modulus size, 2048 when zero
+	SelectorPrefix     string        // selectors are <prefix><YYYYMM>
+	RotationInterval   time.Duration // age of the active key that triggers a rotation, 0 disables automatic rotation
+	GracePeriod        time.Duration // how long a superseded key stays published
+	PropagationTimeout time.Duration // pending keys not seen in DNS by then fail
+	CheckInterval      time.Duration // scheduler period, 15 minutes when zero
+}
+
+// TXTResolver looks up TXT records. *DNSResolver and *net.Resolver satisfy it.
+type TXTResolver interface {
+	LookupTXT(ctx context.Context, name string) ([]string, error)
+}
+
+// DKIMRecordPublisher publishes DKIM records through a DNS provider. When
+// none is configured, administrators publish the records shown for pending
+// keys themselves.
+type DKIMRecordPublisher interface {
+	PublishTXT(ctx context.Context, name, value string) error
+	RemoveTXT(ctx context.Context, name string) error
+}
+
+// DKIMActorSystem identifies steps taken by the rotation scheduler
+const DKIMActorSystem = "system"
+
+// NewDKIMService creates a new DKIM service. domainRepo and publisher are
+// optional; when domainRepo is set the domain's DKIMSelector and
+// DKIMPublicKey follow the active key.
+func NewDKIMService(
+	keyRepo repository.DKIMKeyRepository,
+	logRepo repository.DKIMRotationLogRepository,
+	domainRepo repository.DomainRepository,
+	resolver TXTResolver,
+	publisher DKIMRecordPublisher,
+	encrypter KeyEncrypter,
+	eventPub domain.EventPublisher,
+	config *DKIMConfig,
+) *DKIMService {
+	return &DKIMService{
+		keyRepo:    keyRepo,
+		logRepo:    logRepo,
+		domainRepo: domainRepo,
+		resolver:   resolver,
+		publisher:  publisher,
+		encrypter:  encrypter,
+		eventPub:   eventPub,
+		config:     config,
+	}
+}
+
+// StartRotation generates a key under a new selector and publishes it when a
+// publisher is configured. The key stays pending until CheckPropagation
+// sees it in DNS. The first key of a domain goes through the same steps.
+func (s *DKIMService) StartRotation(ctx context.Context, domainName, actor string) (*domain.DKIMKey, error) {
+	domainName = strings.ToLower(strings.TrimSuffix(domainName, "."))
+	if domainName == "" {
+		return nil, errors.NewError(errors.ErrCodeValidationError, "Domain is required")
+	}
+
+	keys, err := s.keyRepo.ListByDomain(ctx, domainName)
+	if err != nil {
+		return nil, errors.InternalError(err)
+	}
+	for _, key := range keys {
+		if key.State == domain.DKIMKeyPending {
+			return nil, errors.DKIMRotationInProgress(domainName, key.Selector)
+		}
+	}
+
+	now := time.Now()
+	selector := s.nextSelector(keys, now)
+	publicKey, privateDER, err := s.generateKey()
+	if err != nil {
+		return nil, errors.InternalError(err)
+	}
+	sealed, err := s.encrypter.Encrypt(privateDER, dkimKeyAAD(domainName, selector))
+	if err != nil {
+		return nil, errors.InternalError(err)
+	}
+
+	key := &domain.DKIMKey{
+		ID:                  uuid.New().String(),
+		Domain:              domainName,
+		Selector:            selector,
+		Algorithm:           s.algorithm(),
+		PublicKey:           publicKey,
+		EncryptedPrivateKey: sealed,
+		State:               domain.DKIMKeyPending,
+		CreatedAt:           now,
+		UpdatedAt:           now,
+	}
+	if key.Algorithm == domain.DKIMAlgorithmRSA {
+		key.KeyBits = s.keyBits()
+	}
+
+	if err := s.keyRepo.Create(ctx, key); err != nil {
+		return nil, errors.InternalError(err)
+	}
+	s.record(ctx, key, domain.DKIMActionGenerated, actor, fmt.Sprintf("%s key generated", key.Algorithm))
+
+	if s.publisher != nil {
+		if err := s.publisher.PublishTXT(ctx, key.RecordName(), key.RecordValue()); err != nil {
+			s.record(ctx, key, domain.DKIMActionFailed, actor, "publishing failed: "+err.Error())
+		} else {
+			key.PublishedAt = &now
+			key.UpdatedAt = now
+			if err := s.keyRepo.Update(ctx, key); err != nil {
+				return nil, errors.InternalError(err)
+			}
+			s.record(ctx, key, domain.DKIMActionPublished, actor, key.RecordName())
+		}
+	}
+
+	return key, nil
+}
+
+// ImportKey stores an existing PEM private key as the active key of a
+// domain, for domains whose key was configured before rotation was managed.
+// The record is assumed to be published already.
+func (s *DKIMService) ImportKey(ctx context.Context, domainName, selector, privateKeyPEM, actor string) (*domain.DKIMKey, error) {
+	domainName = strings.ToLower(strings.TrimSuffix(domainName, "."))
+	if domainName == "" || selector == "" {
+		return nil, errors.NewError(errors.ErrCodeValidationError, "Domain and selector are required")
+	}
+	existing, err := s.keyRepo.GetBySelector(ctx, domainName, selector)
+	if err != nil {
+		return nil, errors.InternalError(err)
+	}
+	if existing != nil {
+		return nil, errors.NewError(errors.ErrCodeValidationError, "Selector already exists").WithDetail("selector", selector)
+	}
+
+	block, _ := pem.Decode([]byte(privateKeyPEM))
+	if block == nil {
+		return nil, errors.NewError(errors.ErrCodeValidationError, "Private key must be PEM encoded")
+	}
+	signer, err := parseDKIMPrivateKey(block.Bytes)
+	if err != nil {
+		return nil, errors.NewError(errors.ErrCodeValidationError, "Unsupported private key").WithDetail("reason", err.Error())
+	}
+	publicKey, algorithm, keyBits, err := dkimPublicKey(signer)
+	if err != nil {
+		return nil, errors.NewError(errors.ErrCodeValidationError, "Unsupported private key").WithDetail("reason", err.Error())
+	}
+	privateDER, err := x509.MarshalPKCS8PrivateKey(signer)
+	if err != nil {
+		return nil, errors.InternalError(err)
+	}
+	sealed, err := s.encrypter.Encrypt(privateDER, dkimKeyAAD(domainName, selector))
+	if err != nil {
+		return nil, errors.InternalError(err)
+	}
+
+	now := time.Now()
+	key := &domain.DKIMKey{
+		ID:                  uuid.New().String(),
+		Domain:              domainName,
+		Selector:            selector,
+		Algorithm:           algorithm,
+		KeyBits:             keyBits,
+		PublicKey:           publicKey,
+		EncryptedPrivateKey: sealed,
+		State:               domain.DKIMKeyPending,
+		CreatedAt:           now,
+		PublishedAt:         &now,
+		VerifiedAt:          &now,
+		UpdatedAt:           now,
+	}
+	if err := s.keyRepo.Create(ctx, key); err != nil {
+		return nil, errors.InternalError(err)
+	}
+	s.record(ctx, key, domain.DKIMActionGenerated, actor, "imported existing key")
+
+	if err := s.activate(ctx, key, actor); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// CheckPropagation looks up the TXT record of a pending key. Once the
+// published value matches, the key becomes active and the previous active
+// key starts its grace period. A key still missing after the propagation
+// timeout fails and is unpublished. The returned key reflects the outcome.
+func (s *DKIMService) CheckPropagation(ctx context.Context, keyID, actor string) (*domain.DKIMKey, error) {
+	key, err := s.keyRepo.GetByID(ctx, keyID)
+	if err != nil {
+		return nil, errors.InternalError(err)
+	}
+	if key == nil {
+		return nil, errors.DKIMKeyNotFound(keyID)
+	}
+	if key.State != domain.DKIMKeyPending {
+		return key, nil
+	}
+
+	records, lookupErr := s.resolver.LookupTXT(ctx, key.RecordName())
+	if lookupErr == nil && dkimRecordMatches(records, key.PublicKey) {
+		now := time.Now()
+		key.VerifiedAt = &now
+		key.UpdatedAt = now
+		s.record(ctx, key, domain.DKIMActionVerified, actor, key.RecordName())
+		if err := s.activate(ctx, key, actor); err != nil {
+			return nil, err
+		}
+		return key, nil
+	}
+
+	if s.config.PropagationTimeout > 0 && time.Since(key.CreatedAt) > s.config.PropagationTimeout {
+		now := time.Now()
+		key.State = domain.DKIMKeyFailed
+		key.EncryptedPrivateKey = nil
+		key.UpdatedAt = now
+		if err := s.keyRepo.Update(ctx, key); err != nil {
+			return nil, errors.InternalError(err)
+		}
+		s.record(ctx, key, domain.DKIMActionFailed, actor, "record not visible in DNS before the propagation timeout")
+		s.unpublish(ctx, key, actor)
+	}
+	return key, nil
+}
+
+// RetireExpired retires superseded keys whose grace period has ended,
+// erasing their private key and unpublishing their record
+func (s *DKIMService) RetireExpired(ctx context.Context) (int, error) {
+	keys, err := s.keyRepo.ListByState(ctx, domain.DKIMKeyRetiring)
+	if err != nil {
+		return 0, errors.InternalError(err)
+	}
+
+	now := time.Now()
+	retired := 0
+	for _, key := range keys {
+		if key.RetireAfter == nil || key.RetireAfter.After(now) {
+			continue
+		}
+		key.State = domain.DKIMKeyRetired
+		key.RetiredAt = &now
+		key.EncryptedPrivateKey = nil
+		key.UpdatedAt = now
+		if err := s.keyRepo.Update(ctx, key); err != nil {
+			return retired, errors.InternalError(err)
+		}
+		s.record(ctx, key, domain.DKIMActionRetired, DKIMActorSystem, "grace period ended")
+		s.unpublish(ctx, key, DKIMActorSystem)
+
+		event := domain.NewBaseEvent(uuid.New().String(), key.ID, domain.EventTypeDKIMKeyRetired, key)
+		if err := s.eventPub.Publish(ctx, event); err != nil {
+			// Log error but don't fail the operation
+		}
+		retired++
+	}
+	return retired, nil
+}
+
+// ProcessRotations runs one scheduler pass: pending keys are checked in DNS,
+// active keys older than the rotation interval are rotated and expired
+// keys are retired
+func (s *DKIMService) ProcessRotations(ctx context.Context) error {
+	pending, err := s.keyRepo.ListByState(ctx, domain.DKIMKeyPending)
+	if err != nil {
+		return errors.InternalError(err)
+	}
+	rotating := make(map[string]bool)
+	for _, key := range pending {
+		checked, err := s.CheckPropagation(ctx, key.ID, DKIMActorSystem)
+		if err != nil {
+			return err
+		}
+		if checked.State == domain.DKIMKeyPending {
+			rotating[key.Domain] = true
+		}
+	}
+
+	if s.config.RotationInterval > 0 {
+		active, err := s.keyRepo.ListByState(ctx, domain.DKIMKeyActive)
+		if err != nil {
+			return errors.InternalError(err)
+		}
+		for _, key := range active {
+			if rotating[key.Domain] || key.ActivatedAt == nil || time.Since(*key.ActivatedAt) < s.config.RotationInterval {
+				continue
+			}
+			if _, err := s.StartRotation(ctx, key.Domain, DKIMActorSystem); err != nil && !errors.IsErrorCode(err, errors.ErrCodeDKIMRotationInProgress) {
+				return err
+			}
+		}
+	}
+
+	_, err = s.RetireExpired(ctx)
+	return err
+}
+
+// Run processes rotations at the configured interval until the context is
+// cancelled
+func (s *DKIMService) Run(ctx context.Context) {
+	interval := s.config.CheckInterval
+	if interval <= 0 {
+		interval = 15 * time.Minute
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := s.ProcessRotations(ctx); err != nil {
+				// Log error but keep the scheduler running
+			}
+		}
+	}
+}
+
+// SigningKey returns the decrypted active key of a domain, or nil when the
+// domain has no active key and messages must go out unsigned
+func (s *DKIMService) SigningKey(ctx context.Context, domainName string) (*domain.DKIMSigningKey, error) {
+	key, err := s.keyRepo.GetActive(ctx, strings.ToLower(strings.TrimSuffix(domainName, ".")))
+	if err != nil {
+		return nil, errors.InternalError(err)
+	}
+	if key == nil {
+		return nil, nil
+	}
+
+	privateDER, err := s.encrypter.Decrypt(key.EncryptedPrivateKey, dkimKeyAAD(key.Domain, key.Selector))
+	if err != nil {
+		return nil, errors.InternalError(fmt.Errorf("decrypting DKIM key %s: %w", key.Selector, err))
+	}
+	privateKey, err := x509.ParsePKCS8PrivateKey(privateDER)
+	if err != nil {
+		return nil, errors.InternalError(err)
+	}
+
+	return &domain.DKIMSigningKey{
+		Domain:     key.Domain,
+		Selector:   key.Selector,
+		Algorithm:  key.Algorithm,
+		PrivateKey: privateKey,
+	}, nil
+}
+
+// ListKeys returns every key of a domain, newest first
+func (s *DKIMService) ListKeys(ctx context.Context, domainName string) ([]*domain.DKIMKey, error) {
+	keys, err := s.keyRepo.ListByDomain(ctx, strings.ToLower(domainName))
+	if err != nil {
+		return nil, errors.InternalError(err)
+	}
+	return keys, nil
+}
+
+// PublishedKeys returns the keys whose record must be present in DNS:
+// the pending, active and retiring keys of a domain
+func (s *DKIMService) PublishedKeys(ctx context.Context, domainName string) ([]*domain.DKIMKey, error) {
+	keys, err := s.ListKeys(ctx, domainName)
+	if err != nil {
+		return nil, err
+	}
+	published := []*domain.DKIMKey{}
+	for _, key := range keys {
+		if key.IsPublished() {
+			published = append(published, key)
+		}
+	}
+	return published, nil
+}
+
+// GetRotationHistory returns the rotation log of a domain, newest first
+func (s *DKIMService) GetRotationHistory(ctx context.Context, domainName string, limit int) ([]*domain.DKIMRotationEntry, error) {
+	entries, err := s.logRepo.ListByDomain(ctx, strings.ToLower(domainName), limit)
+	if err != nil {
+		return nil, errors.InternalError(err)
+	}
+	return entries, nil
+}
+
+// activate makes a verified key the signing key and starts the grace period
+// of the key it supersedes
+func (s *DKIMService) activate(ctx context.Context, key *domain.DKIMKey, actor string) error {
+	previous, err := s.keyRepo.GetActive(ctx, key.Domain)
+	if err != nil {
+		return errors.InternalError(err)
+	}
+
+	now := time.Now()
+	if previous != nil && previous.ID != key.ID {
+		retireAfter := now.Add(s.config.GracePeriod)
+		previous.State = domain.DKIMKeyRetiring
+		previous.RetireAfter = &retireAfter
+		previous.UpdatedAt = now
+		if err := s.keyRepo.Update(ctx, previous); err != nil {
+			return errors.InternalError(err)
+		}
+		s.record(ctx, previous, domain.DKIMActionSuperseded, actor,
+			fmt.Sprintf("superseded by %s, retiring after %s", key.Selector, retireAfter.UTC().Format(time.RFC3339)))
+	}
+
+	key.State = domain.DKIMKeyActive
+	key.ActivatedAt = &now
+	key.UpdatedAt = now
+	if err := s.keyRepo.Update(ctx, key); err != nil {
+		return errors.InternalError(err)
+	}
+	s.record(ctx, key, domain.DKIMActionActivated, actor, "signing switched to "+key.Selector)
+
+	if s.domainRepo != nil {
+		domainEntity, err := s.domainRepo.GetByName(ctx, key.Domain)
+		if err == nil && domainEntity != nil {
+			domainEntity.DKIMSelector = &key.Selector
+			domainEntity.DKIMPublicKey = &key.PublicKey
+			domainEntity.UpdatedAt = now
+			if err := s.domainRepo.Update(ctx, domainEntity); err != nil {
+				return errors.InternalError(err)
+			}
+		}
+	}
+
+	event := domain.NewBaseEvent(uuid.New().String(), key.ID, domain.EventTypeDKIMKeyActivated, key)
+	if err := s.eventPub.Publish(ctx, event); err != nil {
+		// Log error but don't fail the operation
+	}
+	return nil
+}
+
+// unpublish removes the record of a key through the publisher, if any
+func (s *DKIMService) unpublish(ctx context.Context, key *domain.DKIMKey, actor string) {
+	if s.publisher == nil {
+		return
+	}
+	if err := s.publisher.RemoveTXT(ctx, key.RecordName()); err != nil {
+		s.record(ctx, key, domain.DKIMActionFailed, actor, "unpublishing failed: "+err.Error())
+		return
+	}
+	s.record(ctx, key, domain.DKIMActionUnpublished, actor, key.RecordName())
+}
+
+// record appends an entry to the rotation log
+func (s *DKIMService) record(ctx context.Context, key *domain.DKIMKey, action domain.DKIMRotationAction, actor, detail string) {
+	if actor == "" {
+		actor = DKIMActorSystem
+	}
+	entry := &domain.DKIMRotationEntry{
+		ID:       uuid.New().String(),
+		Domain:   key.Domain,
+		KeyID:    key.ID,
+		Selector: key.Selector,
+		Action:   action,
+		Actor:    actor,
+		Detail:   detail,
+		At:       time.Now(),
+	}
+	if err := s.logRepo.Record(ctx, entry); err != nil {
+		// Log error but don't fail the operation
+	}
+}
+
+// nextSelector returns <prefix><YYYYMM>, suffixed with a letter when that
+// selector was already used by the domain
+func (s *DKIMService) nextSelector(keys []*domain.DKIMKey, now time.Time) string {
+	prefix := s.config.SelectorPrefix
+	if prefix == "" {
+		prefix = "dkim"
+	}
+	base := prefix + now.UTC().Format("200601")
+
+	used := make(map[string]bool, len(keys))
+	for _, key := range keys {
+		used[key.Selector] = true
+	}
+	selector := base
+	for suffix := 'b'; used[selector] && suffix <= 'z'; suffix++ {
+		selector = base + string(suffix)
+	}
+	if used[selector] {
+		selector = base + "-" + uuid.New().String()[:8]
+	}
+	return selector
+}
+
+// generateKey creates a key pair and returns the p= value and the PKCS#8 private key
+func (s *DKIMService) generateKey() (string, []byte, error) {
+	var signer interface{}
+	switch s.algorithm() {
+	case domain.DKIMAlgorithmEd25519:
+		_, privateKey, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			return "", nil, err
+		}
+		signer = privateKey
+	default:
+		privateKey, err := rsa.GenerateKey(rand.Reader, s.keyBits())
+		if err != nil {
+			return "", nil, err
+		}
+		signer = privateKey
+	}
+
+	publicKey, _, _, err := dkimPublicKey(signer)
+	if err != nil {
+		return "", nil, err
+	}
+	privateDER, err := x509.MarshalPKCS8PrivateKey(signer)
+	if err != nil {
+		return "", nil, err
+	}
+	return publicKey, privateDER, nil
+}
+
+func (s *DKIMService) algorithm() domain.DKIMAlgorithm {
+	if s.config.Algorithm == domain.DKIMAlgorithmEd25519 {
+		return domain.DKIMAlgorithmEd25519
+	}
+	return domain.DKIMAlgorithmRSA
+}
+
+func (s *DKIMService) keyBits() int {
+	if s.config.KeyBits < 1024 {
+		return 2048
+	}
+	return s.config.KeyBits
+}
+
+// Helper functions
+
+// dkimKeyAAD binds a sealed private key to its domain and selector, so a
+// sealed key copied onto another key record cannot be opened
+func dkimKeyAAD(domainName, selector string) []byte {
+	return []byte(domainName + "|" + selector)
+}
+
+// parseDKIMPrivateKey parses a PKCS#8 or PKCS#1 private key
+func parseDKIMPrivateKey(der []byte) (interface{}, error) {
+	if key, err := x509.ParsePKCS8PrivateKey(der); err == nil {
+		return key, nil
+	}
+	return x509.ParsePKCS1PrivateKey(der)
+}
+
+// dkimPublicKey returns the p= value of a private key: the DER
+// SubjectPublicKeyInfo for RSA and the raw key for Ed25519 (RFC 8463)
+func dkimPublicKey(privateKey interface{}) (string, domain.DKIMAlgorithm, int, error) {
+	switch key := privateKey.(type) {
+	case *rsa.PrivateKey:
+		der, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+		if err != nil {
+			return "", "", 0, err
+		}
+		return base64.StdEncoding.EncodeToString(der), domain.DKIMAlgorithmRSA, key.N.BitLen(), nil
+	case ed25519.PrivateKey:
+		return base64.StdEncoding.EncodeToString(key.Public().(ed25519.PublicKey)), domain.DKIMAlgorithmEd25519, 0, nil
+	}
+	return "", "", 0, fmt.Errorf("unsupported key type %T", privateKey)
+}
+
+// dkimRecordMatches reports whether one of the TXT records publishes the
+// given p= value
+func dkimRecordMatches(records []string, publicKey string) bool {
+	for _, record := range records {
+		for _, tag := range strings.Split(record, ";") {
+			name, value, ok := strings.Cut(strings.TrimSpace(tag), "=")
+			if !ok || strings.TrimSpace(name) != "p" {
+				continue
+			}
+			if strings.Join(strings.Fields(value), "") == publicKey {
+				return true
+			}
+		}
+	}
+	return false
+}
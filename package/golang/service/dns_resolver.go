@@ -0,0 +1,139 @@
+package service
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/skygenesisenterprise/aether-mailer/package/golang/domain"
+)
+
+// DNSResolver queries recursive resolvers directly and reports whether their
+// answers were DNSSEC-validated. The AD bit is only as trustworthy as the
+// path to the resolver, so point it at a validating resolver on the local
+// host or a trusted network. It satisfies MXResolver and TLSResolver.
+type DNSResolver struct {
+	Servers []string // host:port of validating resolvers, tried in order
+	Timeout time.Duration
+}
+
+// NewDNSResolver creates a resolver for the given servers. Servers without a
+// port use port 53.
+func NewDNSResolver(servers []string, timeout time.Duration) *DNSResolver {
+	normalized := make([]string, 0, len(servers))
+	for _, server := range servers {
+		if _, _, err := net.SplitHostPort(server); err != nil {
+			server = net.JoinHostPort(server, "53")
+		}
+		normalized = append(normalized, server)
+	}
+	return &DNSResolver{Servers: normalized, Timeout: timeout}
+}
+
+// LookupMX returns the MX records of name. A missing domain is reported as a
+// not-found *net.DNSError, like *net.Resolver.
+func (r *DNSResolver) LookupMX(ctx context.Context, name string) ([]*net.MX, error) {
+	resp, err := r.query(ctx, name, dns.TypeMX)
+	if err != nil {
+		return nil, err
+	}
+	if resp.Rcode == dns.RcodeNameError {
+		return nil, &net.DNSError{Err: "no such host", Name: name, IsNotFound: true}
+	}
+
+	records := []*net.MX{}
+	for _, rr := range resp.Answer {
+		if mx, ok := rr.(*dns.MX); ok {
+			records = append(records, &net.MX{Host: mx.Mx, Pref: mx.Preference})
+		}
+	}
+	return records, nil
+}
+
+// LookupTXT returns the TXT strings of name, each record's strings joined
+func (r *DNSResolver) LookupTXT(ctx context.Context, name string) ([]string, error) {
+	resp, err := r.query(ctx, name, dns.TypeTXT)
+	if err != nil {
+		return nil, err
+	}
+	if resp.Rcode == dns.RcodeNameError {
+		return nil, &net.DNSError{Err: "no such host", Name: name, IsNotFound: true}
+	}
+
+	records := []string{}
+	for _, rr := range resp.Answer {
+		if txt, ok := rr.(*dns.TXT); ok {
+			records = append(records, strings.Join(txt.Txt, ""))
+		}
+	}
+	return records, nil
+}
+
+// LookupTLSA returns the TLSA records of name and whether the answer was
+// DNSSEC-validated. A validated denial returns no records and true.
+func (r *DNSResolver) LookupTLSA(ctx context.Context, name string) ([]domain.TLSARecord, bool, error) {
+	resp, err := r.query(ctx, name, dns.TypeTLSA)
+	if err != nil {
+		return nil, false, err
+	}
+	if resp.Rcode == dns.RcodeNameError {
+		return nil, resp.AuthenticatedData, nil
+	}
+
+	records := []domain.TLSARecord{}
+	for _, rr := range resp.Answer {
+		tlsa, ok := rr.(*dns.TLSA)
+		if !ok {
+			continue
+		}
+		data, err := hex.DecodeString(tlsa.Certificate)
+		if err != nil {
+			continue
+		}
+		records = append(records, domain.TLSARecord{
+			Usage:        tlsa.Usage,
+			Selector:     tlsa.Selector,
+			MatchingType: tlsa.MatchingType,
+			Data:         data,
+		})
+	}
+	return records, resp.AuthenticatedData, nil
+}
+
+// query sends a recursive query with the DO and AD bits set, retrying over
+// TCP when the answer is truncated. SERVFAIL, which validating resolvers
+// return for bogus answers, is an error.
+func (r *DNSResolver) query(ctx context.Context, name string, qtype uint16) (*dns.Msg, error) {
+	if len(r.Servers) == 0 {
+		return nil, fmt.Errorf("no DNS servers configured")
+	}
+
+	msg := new(dns.Msg)
+	msg.SetQuestion(dns.Fqdn(name), qtype)
+	msg.SetEdns0(4096, true)
+	msg.AuthenticatedData = true
+
+	var lastErr error
+	for _, server := range r.Servers {
+		client := &dns.Client{Timeout: r.Timeout}
+		resp, _, err := client.ExchangeContext(ctx, msg, server)
+		if err == nil && resp.Truncated {
+			client.Net = "tcp"
+			resp, _, err = client.ExchangeContext(ctx, msg, server)
+		}
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		if resp.Rcode != dns.RcodeSuccess && resp.Rcode != dns.RcodeNameError {
+			lastErr = fmt.Errorf("%s lookup for %s failed: %s", dns.TypeToString[qtype], name, dns.RcodeToString[resp.Rcode])
+			continue
+		}
+		return resp, nil
+	}
+	return nil, lastErr
+}
@@ -133,15 +133,6 @@ func (s *DomainService) UpdateDomain(ctx context.Context, req UpdateDomainReques
 	if req.MaxStorageMB != nil {
 		domainEntity.MaxStorageMB = *req.MaxStorageMB
 	}
-	if req.DKIMSelector != nil {
-		domainEntity.DKIMSelector = req.DKIMSelector
-	}
-	if req.DKIMPublicKey != nil {
-		domainEntity.DKIMPublicKey = req.DKIMPublicKey
-	}
-	if req.DKIMPrivateKey != nil {
-		domainEntity.DKIMPrivateKey = req.DKIMPrivateKey
-	}
 	if req.SPFRecord != nil {
 		domainEntity.SPFRecord = req.SPFRecord
 	}
@@ -238,9 +229,6 @@ type UpdateDomainRequest struct {
 	MaxUsers        *int
 	MaxEmailsPerDay *int
 	MaxStorageMB    *int
-	DKIMSelector    *string
-	DKIMPublicKey   *string
-	DKIMPrivateKey  *string
 	SPFRecord       *string
 	DMARCRecord     *string
 }
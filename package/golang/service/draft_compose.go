@@ -0,0 +1,259 @@
+package service
+
+import (
+	"html"
+	"net/mail"
+	"strings"
+
+	"github.com/skygenesisenterprise/aether-mailer/package/golang/domain"
+	"github.com/skygenesisenterprise/aether-mailer/package/golang/errors"
+)
+
+// quoteDateLayout is the date of the quoted message in replies and forwards
+const quoteDateLayout = "Mon, 2 Jan 2006 at 15:04 MST"
+
+// signBody appends the signature of the sender to a body. The text
+// signature follows the "-- " separator; a sender without a text signature
+// gets the text of the HTML one.
+func signBody(bodyText, bodyHTML *string, profile *SenderProfile) (*string, *string) {
+	signatureText := profile.SignatureText
+	if signatureText == "" && profile.SignatureHTML != "" {
+		signatureText = domain.HTMLText(profile.SignatureHTML)
+	}
+	if signatureText != "" {
+		text := stringValue(bodyText) + "\n\n-- \n" + signatureText
+		bodyText = &text
+	}
+	if bodyHTML != nil && profile.SignatureHTML != "" {
+		signed := *bodyHTML + `<div class="signature">` + cleanQuotedHTML(profile.SignatureHTML) + `</div>`
+		bodyHTML = &signed
+	}
+	return bodyText, bodyHTML
+}
+
+// quoteReply adds the quoted original below the body of a reply. An HTML
+// part is written when the reply or the original has one.
+func quoteReply(original *domain.Message, bodyText, bodyHTML *string) (*string, *string) {
+	attribution := "On " + domain.ThreadDate(original).Format(quoteDateLayout) + ", " + original.From + " wrote:"
+
+	lines := strings.Split(strings.TrimRight(originalText(original), "\n"), "\n")
+	for i, line := range lines {
+		if strings.HasPrefix(line, ">") {
+			lines[i] = ">" + line
+		} else {
+			lines[i] = "> " + line
+		}
+	}
+	text := stringValue(bodyText) + "\n\n" + attribution + "\n" + strings.Join(lines, "\n") + "\n"
+
+	if bodyHTML == nil && original.BodyHTML == nil {
+		return &text, nil
+	}
+	quoted := htmlBody(bodyText, bodyHTML) +
+		`<div class="quote"><p>` + html.EscapeString(attribution) + `</p>` +
+		`<blockquote type="cite">` + originalHTML(original) + `</blockquote></div>`
+	return &text, &quoted
+}
+
+// quoteForward adds the forwarded original with its headers below the body
+// of a forward
+func quoteForward(original *domain.Message, bodyText, bodyHTML *string) (*string, *string) {
+	headers := [][2]string{
+		{"From", original.From},
+		{"Date", domain.ThreadDate(original).Format(quoteDateLayout)},
+		{"Subject", original.Subject},
+		{"To", strings.Join(original.To, ", ")},
+	}
+	if len(original.Cc) > 0 {
+		headers = append(headers, [2]string{"Cc", strings.Join(original.Cc, ", ")})
+	}
+
+	var b strings.Builder
+	b.WriteString(stringValue(bodyText))
+	b.WriteString("\n\n---------- Forwarded message ----------\n")
+	for _, header := range headers {
+		b.WriteString(header[0] + ": " + header[1] + "\n")
+	}
+	b.WriteString("\n" + originalText(original))
+	text := b.String()
+
+	if bodyHTML == nil && original.BodyHTML == nil {
+		return &text, nil
+	}
+	var h strings.Builder
+	h.WriteString(htmlBody(bodyText, bodyHTML))
+	h.WriteString(`<div class="forward"><p>---------- Forwarded message ----------<br>`)
+	for _, header := range headers {
+		h.WriteString(header[0] + ": " + html.EscapeString(header[1]) + "<br>")
+	}
+	h.WriteString(`</p>` + originalHTML(original) + `</div>`)
+	quoted := h.String()
+	return &text, &quoted
+}
+
+// originalText returns the text of a quoted message
+func originalText(original *domain.Message) string {
+	if original.BodyText != nil && strings.TrimSpace(*original.BodyText) != "" {
+		return *original.BodyText
+	}
+	if original.BodyHTML != nil {
+		return domain.HTMLText(*original.BodyHTML)
+	}
+	return ""
+}
+
+// originalHTML returns the HTML of a quoted message, the escaped text when
+// it has no HTML part
+func originalHTML(original *domain.Message) string {
+	if original.BodyHTML != nil {
+		return cleanQuotedHTML(*original.BodyHTML)
+	}
+	return textToHTML(originalText(original))
+}
+
+// htmlBody returns the HTML of a body, the escaped text when it has no HTML
+// part
+func htmlBody(bodyText, bodyHTML *string) string {
+	if bodyHTML != nil {
+		return *bodyHTML
+	}
+	return textToHTML(stringValue(bodyText))
+}
+
+func textToHTML(text string) string {
+	return strings.ReplaceAll(html.EscapeString(text), "\n", "<br>")
+}
+
+// cleanQuotedHTML strips active content from quoted HTML. Unlike
+// SanitizeHTML it keeps links and images, which the sender may want to
+// pass on.
+func cleanQuotedHTML(body string) string {
+	return quotedHTMLPolicy.sanitize(body)
+}
+
+// replyRecipients addresses a reply: to the Reply-To or From of the
+// original, or to its recipients when the account sent it. A reply to all
+// copies the other recipients. The addresses of the account are left out.
+func replyRecipients(original *domain.Message, own []string, all bool) ([]string, []string) {
+	ownKeys := make(map[string]bool, len(own))
+	for _, address := range own {
+		if key := addressKey(address); key != "" {
+			ownKeys[key] = true
+		}
+	}
+
+	var to, cc []string
+	switch {
+	case ownKeys[addressKey(original.From)]:
+		to = original.To
+		if all {
+			cc = original.Cc
+		}
+	default:
+		to = []string{original.From}
+		if replyTo := original.Header("Reply-To"); replyTo != "" {
+			if list, err := mail.ParseAddressList(replyTo); err == nil && len(list) > 0 {
+				to = to[:0]
+				for _, address := range list {
+					to = append(to, address.String())
+				}
+			}
+		}
+		if all {
+			cc = append(append([]string{}, original.To...), original.Cc...)
+		}
+	}
+
+	seen := make(map[string]bool)
+	keep := func(addresses []string, dropOwn bool) []string {
+		kept := []string{}
+		for _, address := range addresses {
+			key := addressKey(address)
+			if key == "" || seen[key] || (dropOwn && ownKeys[key]) {
+				continue
+			}
+			seen[key] = true
+			kept = append(kept, address)
+		}
+		return kept
+	}
+	// Replying to oneself still goes to oneself
+	to = keep(to, len(to) > 1)
+	return to, keep(cc, true)
+}
+
+// addressKey identifies an address regardless of its display name
+func addressKey(address string) string {
+	if parsed, err := mail.ParseAddress(address); err == nil {
+		return strings.ToLower(parsed.Address)
+	}
+	return normalizeEmail(extractAddress(address))
+}
+
+// prefixSubject marks a subject as a reply or forward, unless it already is
+func prefixSubject(prefix, subject string) string {
+	if strings.HasPrefix(strings.ToLower(strings.TrimSpace(subject)), strings.ToLower(prefix)+":") {
+		return subject
+	}
+	return prefix + ": " + subject
+}
+
+// draftHeaders returns the headers a draft keeps until it is sent
+func draftHeaders(replyTo, inReplyTo string, references []string) map[string]string {
+	headers := map[string]string{}
+	if replyTo != "" {
+		headers["Reply-To"] = replyTo
+	}
+	if ids := domain.ParseMessageIDs(inReplyTo); len(ids) > 0 {
+		headers["In-Reply-To"] = "<" + ids[0] + ">"
+	}
+	ids := []string{}
+	for _, reference := range references {
+		ids = append(ids, domain.ParseMessageIDs(reference)...)
+	}
+	if len(ids) > 0 {
+		headers["References"] = "<" + strings.Join(ids, "> <") + ">"
+	}
+	return headers
+}
+
+// draftSize approximates the size of a draft like calculateMessageSize
+func draftSize(draft *domain.Message) int64 {
+	size := int64(len(draft.From) + len(draft.Subject))
+	for _, address := range append(append(append([]string{}, draft.To...), draft.Cc...), draft.Bcc...) {
+		size += int64(len(address))
+	}
+	size += int64(len(stringValue(draft.BodyText)) + len(stringValue(draft.BodyHTML)))
+	for _, attachment := range draft.Attachments {
+		size += attachment.Size
+	}
+	return size
+}
+
+// validateDraftAddresses checks the recipients of a draft; a draft may have
+// none yet
+func validateDraftAddresses(lists ...[]string) error {
+	for _, list := range lists {
+		for _, address := range list {
+			if _, err := mail.ParseAddress(address); err != nil {
+				return errors.NewError(errors.ErrCodeInvalidEmailAddress, "Invalid recipient address").
+					WithDetail("address", address)
+			}
+		}
+	}
+	return nil
+}
+
+func stringValue(s *string) string {
+	if s == nil {
+		return ""
+	}
+	return *s
+}
+
+func emptyToNil(s string) *string {
+	if s == "" {
+		return nil
+	}
+	return &s
+}
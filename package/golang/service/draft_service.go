@@ -0,0 +1,648 @@
+package service
+
+import (
+	"context"
+	"net/mail"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/skygenesisenterprise/aether-mailer/package/golang/domain"
+	"github.com/skygenesisenterprise/aether-mailer/package/golang/errors"
+	"github.com/skygenesisenterprise/aether-mailer/package/golang/repository"
+)
+
+// DraftService composes messages in the Drafts folder of the email accounts
+// a user owns: new messages, replies and forwards. Drafts are saved as
+// messages flagged IsDraft and are sent through MessageService, which files
+// the sent copy in the Sent folder.
+type DraftService struct {
+	accountRepo repository.EmailAccountRepository
+	folderRepo  repository.FolderRepository
+	messageRepo repository.MessageRepository
+	messages    *MessageService
+	blobs       BlobStore
+	transactor  repository.Transactor
+	profiles    SenderProfiles
+	indexer     MessageIndexer
+}
+
+// SenderProfile is who composed messages are sent as
+type SenderProfile struct {
+	From          string // address with an optional display name
+	Sender        string // set when sending on behalf of another mailbox
+	ReplyTo       string
+	SignatureText string
+	SignatureHTML string
+}
+
+// SenderProfiles chooses the sender profile of messages composed for an
+// account, such as IdentityService. from is the address chosen for the
+// message, empty for the default identity; an address the account may not
+// send as fails with SENDER_NOT_ALLOWED. reply is set for replies and
+// forwards.
+type SenderProfiles interface {
+	SenderProfile(ctx context.Context, account *domain.EmailAccount, from string, reply bool) (*SenderProfile, error)
+}
+
+// MessageIndexer keeps the search document of a message up to date, such
+// as SearchService
+type MessageIndexer interface {
+	IndexMessage(ctx context.Context, message *domain.Message) error
+}
+
+// NewDraftService creates a new draft service. blobs, transactor, profiles
+// and indexer are optional: without blobs, attachments stay in the message
+// records; without profiles, messages are sent as the account address only,
+// with no signature; indexer makes drafts searchable.
+func NewDraftService(
+	accountRepo repository.EmailAccountRepository,
+	folderRepo repository.FolderRepository,
+	messageRepo repository.MessageRepository,
+	messages *MessageService,
+	blobs BlobStore,
+	transactor repository.Transactor,
+	profiles SenderProfiles,
+	indexer MessageIndexer,
+) *DraftService {
+	return &DraftService{
+		accountRepo: accountRepo,
+		folderRepo:  folderRepo,
+		messageRepo: messageRepo,
+		messages:    messages,
+		blobs:       blobs,
+		transactor:  transactor,
+		profiles:    profiles,
+		indexer:     indexer,
+	}
+}
+
+// DraftRequest is the content of a new draft
+type DraftRequest struct {
+	AccountID   string
+	From        string // address to send as, the default identity when empty
+	To          []string
+	Cc          []string
+	Bcc         []string
+	Subject     string
+	BodyText    *string
+	BodyHTML    *string
+	Attachments []AttachmentRequest
+	InReplyTo   string   // Message-ID of the message replied to, if any
+	References  []string // Message-IDs of the conversation, oldest first
+}
+
+// DraftUpdate changes a draft. Nil fields are kept, so an autosave can send
+// only what changed.
+type DraftUpdate struct {
+	From              *string // address to send as; the signature is kept
+	To                *[]string
+	Cc                *[]string
+	Bcc               *[]string
+	Subject           *string
+	BodyText          *string
+	BodyHTML          *string
+	AddAttachments    []AttachmentRequest
+	RemoveAttachments []string // attachment IDs
+	// IfUnmodifiedSince rejects the update with DRAFT_CONFLICT when the
+	// draft was saved after it, such as from another device
+	IfUnmodifiedSince *time.Time
+}
+
+// ReplyRequest starts a reply; the body comes before the quoted message
+type ReplyRequest struct {
+	All      bool // also reply to the other recipients
+	BodyText *string
+	BodyHTML *string
+}
+
+// ForwardRequest starts a forward; the body comes before the forwarded
+// message
+type ForwardRequest struct {
+	To       []string
+	Cc       []string
+	Bcc      []string
+	BodyText *string
+	BodyHTML *string
+}
+
+// CreateDraft saves a new draft in the Drafts folder with the signature of
+// the sender
+func (s *DraftService) CreateDraft(ctx context.Context, userID string, req DraftRequest) (*domain.Message, error) {
+	account, err := s.ownedAccount(ctx, userID, req.AccountID)
+	if err != nil {
+		return nil, err
+	}
+	profile, err := s.senderProfile(ctx, account, req.From, req.InReplyTo != "")
+	if err != nil {
+		return nil, err
+	}
+
+	bodyText, bodyHTML := signBody(req.BodyText, req.BodyHTML, profile)
+	return s.create(ctx, account, profile, composition{
+		To:          req.To,
+		Cc:          req.Cc,
+		Bcc:         req.Bcc,
+		Subject:     req.Subject,
+		BodyText:    bodyText,
+		BodyHTML:    bodyHTML,
+		Attachments: req.Attachments,
+		InReplyTo:   req.InReplyTo,
+		References:  req.References,
+	})
+}
+
+// Reply starts a draft replying to one of the user's messages, quoting it.
+// A reply to a message the account sent goes to the same recipients.
+func (s *DraftService) Reply(ctx context.Context, userID, messageID string, req ReplyRequest) (*domain.Message, error) {
+	original, account, err := s.ownedMessage(ctx, userID, messageID)
+	if err != nil {
+		return nil, err
+	}
+	profile, err := s.senderProfile(ctx, account, "", true)
+	if err != nil {
+		return nil, err
+	}
+
+	id, references := domain.ThreadHeaders(original)
+	if id != "" {
+		references = append(references, id)
+	}
+	to, cc := replyRecipients(original, []string{account.Email, profile.From}, req.All)
+	bodyText, bodyHTML := signBody(req.BodyText, req.BodyHTML, profile)
+	bodyText, bodyHTML = quoteReply(original, bodyText, bodyHTML)
+	return s.create(ctx, account, profile, composition{
+		To:         to,
+		Cc:         cc,
+		Subject:    prefixSubject("Re", original.Subject),
+		BodyText:   bodyText,
+		BodyHTML:   bodyHTML,
+		InReplyTo:  id,
+		References: references,
+	})
+}
+
+// Forward starts a draft forwarding one of the user's messages with its
+// attachments, which share the stored content of the original
+func (s *DraftService) Forward(ctx context.Context, userID, messageID string, req ForwardRequest) (*domain.Message, error) {
+	original, account, err := s.ownedMessage(ctx, userID, messageID)
+	if err != nil {
+		return nil, err
+	}
+	profile, err := s.senderProfile(ctx, account, "", true)
+	if err != nil {
+		return nil, err
+	}
+
+	// A forward stays in the conversation without replying to it
+	id, references := domain.ThreadHeaders(original)
+	if id != "" {
+		references = append(references, id)
+	}
+	attachments := make([]AttachmentRequest, 0, len(original.Attachments))
+	for _, attachment := range original.Attachments {
+		attachments = append(attachments, AttachmentRequest{
+			Filename:    attachment.Filename,
+			ContentType: attachment.ContentType,
+			Size:        attachment.Size,
+			Content:     attachment.Content,
+			BlobID:      attachment.BlobID,
+		})
+	}
+	bodyText, bodyHTML := signBody(req.BodyText, req.BodyHTML, profile)
+	bodyText, bodyHTML = quoteForward(original, bodyText, bodyHTML)
+	return s.create(ctx, account, profile, composition{
+		To:          req.To,
+		Cc:          req.Cc,
+		Bcc:         req.Bcc,
+		Subject:     prefixSubject("Fwd", original.Subject),
+		BodyText:    bodyText,
+		BodyHTML:    bodyHTML,
+		Attachments: attachments,
+		References:  references,
+	})
+}
+
+// GetDraft returns one of the user's drafts
+func (s *DraftService) GetDraft(ctx context.Context, userID, draftID string) (*domain.Message, error) {
+	draft, _, err := s.ownedDraft(ctx, userID, draftID)
+	return draft, err
+}
+
+// ListDrafts lists the drafts of one of the user's accounts, latest first
+func (s *DraftService) ListDrafts(ctx context.Context, userID, accountID string, limit, offset int) ([]*domain.Message, int, error) {
+	if _, err := s.ownedAccount(ctx, userID, accountID); err != nil {
+		return nil, 0, err
+	}
+
+	isDraft := true
+	filter := repository.MessageFilter{IsDraft: &isDraft, Limit: limit, Offset: offset}
+	drafts, err := s.messageRepo.ListByAccount(ctx, accountID, filter)
+	if err != nil {
+		return nil, 0, errors.InternalError(err)
+	}
+	total, err := s.messageRepo.CountByAccount(ctx, accountID, filter)
+	if err != nil {
+		return nil, 0, errors.InternalError(err)
+	}
+	return drafts, total, nil
+}
+
+// UpdateDraft saves changes to one of the user's drafts, such as an
+// autosave. The draft keeps its ID.
+func (s *DraftService) UpdateDraft(ctx context.Context, userID, draftID string, update DraftUpdate) (*domain.Message, error) {
+	draft, account, err := s.ownedDraft(ctx, userID, draftID)
+	if err != nil {
+		return nil, err
+	}
+	if update.IfUnmodifiedSince != nil && draft.UpdatedAt.Truncate(time.Microsecond).After(update.IfUnmodifiedSince.Truncate(time.Microsecond)) {
+		return nil, errors.DraftConflict(draftID)
+	}
+
+	if update.From != nil {
+		profile, err := s.senderProfile(ctx, account, *update.From, false)
+		if err != nil {
+			return nil, err
+		}
+		draft.From = profile.From
+		delete(draft.Headers, "Reply-To")
+		if profile.ReplyTo != "" {
+			if draft.Headers == nil {
+				draft.Headers = map[string]string{}
+			}
+			draft.Headers["Reply-To"] = profile.ReplyTo
+		}
+	}
+	if update.To != nil {
+		draft.To = *update.To
+	}
+	if update.Cc != nil {
+		draft.Cc = *update.Cc
+	}
+	if update.Bcc != nil {
+		draft.Bcc = *update.Bcc
+	}
+	if update.Subject != nil {
+		draft.Subject = *update.Subject
+	}
+	if update.BodyText != nil {
+		draft.BodyText = emptyToNil(*update.BodyText)
+	}
+	if update.BodyHTML != nil {
+		draft.BodyHTML = emptyToNil(*update.BodyHTML)
+	}
+	if err := validateDraftAddresses(draft.To, draft.Cc, draft.Bcc); err != nil {
+		return nil, err
+	}
+	draft.UpdatedAt = time.Now().UTC().Truncate(time.Microsecond)
+
+	if len(update.AddAttachments) == 0 && len(update.RemoveAttachments) == 0 {
+		draft.Size = draftSize(draft)
+		if err := s.messageRepo.Update(ctx, draft); err != nil {
+			return nil, errors.InternalError(err)
+		}
+		s.index(ctx, draft)
+		return draft, nil
+	}
+	return s.replaceAttachments(ctx, account, draft, update.AddAttachments, update.RemoveAttachments)
+}
+
+// DeleteDraft discards one of the user's drafts
+func (s *DraftService) DeleteDraft(ctx context.Context, userID, draftID string) error {
+	draft, _, err := s.ownedDraft(ctx, userID, draftID)
+	if err != nil {
+		return err
+	}
+	if err := s.messageRepo.Delete(ctx, draft.ID); err != nil {
+		return errors.InternalError(err)
+	}
+	s.releaseBlobs(ctx, draft.Attachments)
+	return nil
+}
+
+// SendDraft sends one of the user's drafts and removes it from Drafts. The
+// sent copy is a new message in the Sent folder.
+func (s *DraftService) SendDraft(ctx context.Context, userID, draftID string) (*domain.Message, error) {
+	draft, _, err := s.ownedDraft(ctx, userID, draftID)
+	if err != nil {
+		return nil, err
+	}
+	return s.send(ctx, draft)
+}
+
+// send sends a draft and removes it from Drafts
+func (s *DraftService) send(ctx context.Context, draft *domain.Message) (*domain.Message, error) {
+	req := SendMessageRequest{
+		AccountID:   draft.AccountID,
+		From:        draft.From,
+		To:          draft.To,
+		Cc:          draft.Cc,
+		Bcc:         draft.Bcc,
+		Subject:     draft.Subject,
+		BodyText:    draft.BodyText,
+		BodyHTML:    draft.BodyHTML,
+		Attachments: make([]AttachmentRequest, 0, len(draft.Attachments)),
+		ReplyTo:     draft.Header("Reply-To"),
+		References:  domain.ParseMessageIDs(draft.Header("References")),
+	}
+	if replyTo := domain.ParseMessageIDs(draft.Header("In-Reply-To")); len(replyTo) > 0 {
+		req.InReplyTo = replyTo[0]
+	}
+	for _, attachment := range draft.Attachments {
+		req.Attachments = append(req.Attachments, AttachmentRequest{
+			Filename:    attachment.Filename,
+			ContentType: attachment.ContentType,
+			Size:        attachment.Size,
+			Content:     attachment.Content,
+			BlobID:      attachment.BlobID,
+		})
+	}
+
+	sent, err := s.messages.SendMessage(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.messageRepo.Delete(ctx, draft.ID); err != nil {
+		// Log error but don't fail the operation: the message is sent and
+		// the draft keeps its attachments
+		return sent, nil
+	}
+	s.releaseBlobs(ctx, draft.Attachments)
+	return sent, nil
+}
+
+// composition is the content of a draft being created
+type composition struct {
+	To          []string
+	Cc          []string
+	Bcc         []string
+	Subject     string
+	BodyText    *string
+	BodyHTML    *string
+	Attachments []AttachmentRequest
+	InReplyTo   string
+	References  []string
+}
+
+// create saves a new draft in the Drafts folder of an account
+func (s *DraftService) create(ctx context.Context, account *domain.EmailAccount, profile *SenderProfile, c composition) (*domain.Message, error) {
+	if err := validateDraftAddresses(c.To, c.Cc, c.Bcc); err != nil {
+		return nil, err
+	}
+	drafts, err := s.draftsFolder(ctx, account.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now().UTC().Truncate(time.Microsecond)
+	draft := &domain.Message{
+		ID:          uuid.New().String(),
+		AccountID:   account.ID,
+		FolderID:    drafts.ID,
+		From:        profile.From,
+		To:          c.To,
+		Cc:          c.Cc,
+		Bcc:         c.Bcc,
+		Subject:     c.Subject,
+		BodyText:    c.BodyText,
+		BodyHTML:    c.BodyHTML,
+		Headers:     draftHeaders(profile.ReplyTo, c.InReplyTo, c.References),
+		Attachments: []domain.Attachment{},
+		IsRead:      true,
+		IsDraft:     true,
+		ReceivedAt:  now,
+		CreatedAt:   now,
+		UpdatedAt:   now,
+	}
+	if err := s.addAttachments(ctx, account, draft, c.Attachments); err != nil {
+		return nil, err
+	}
+	draft.Size = draftSize(draft)
+
+	if err := s.messageRepo.Create(ctx, draft); err != nil {
+		s.releaseBlobs(ctx, draft.Attachments)
+		return nil, errors.InternalError(err)
+	}
+	s.index(ctx, draft)
+	return draft, nil
+}
+
+// replaceAttachments saves a draft whose attachments changed. Attachments
+// cannot be updated in place, so the draft is stored again under its ID.
+func (s *DraftService) replaceAttachments(ctx context.Context, account *domain.EmailAccount, draft *domain.Message, add []AttachmentRequest, remove []string) (*domain.Message, error) {
+	removed := make(map[string]bool, len(remove))
+	for _, id := range remove {
+		removed[id] = true
+	}
+	kept := []domain.Attachment{}
+	released := []domain.Attachment{}
+	for _, attachment := range draft.Attachments {
+		if removed[attachment.ID] {
+			released = append(released, attachment)
+			continue
+		}
+		kept = append(kept, attachment)
+	}
+	draft.Attachments = kept
+	if err := s.addAttachments(ctx, account, draft, add); err != nil {
+		return nil, err
+	}
+	added := draft.Attachments[len(kept):]
+	draft.Size = draftSize(draft)
+
+	err := withinTransaction(ctx, s.transactor, func(ctx context.Context) error {
+		if err := s.messageRepo.Delete(ctx, draft.ID); err != nil {
+			return errors.InternalError(err)
+		}
+		if err := s.messageRepo.Create(ctx, draft); err != nil {
+			return errors.InternalError(err)
+		}
+		return nil
+	})
+	if err != nil {
+		s.releaseBlobs(ctx, added)
+		return nil, err
+	}
+	s.releaseBlobs(ctx, released)
+	s.index(ctx, draft)
+	return draft, nil
+}
+
+// addAttachments appends attachments to a draft, storing new content as
+// blobs and sharing the blobs of attachments that reference one
+func (s *DraftService) addAttachments(ctx context.Context, account *domain.EmailAccount, draft *domain.Message, requests []AttachmentRequest) error {
+	config := s.messages.config
+	if len(draft.Attachments)+len(requests) > config.MaxAttachments {
+		return errors.NewError(errors.ErrCodeValidationError, "Too many attachments").
+			WithDetail("max_attachments", config.MaxAttachments).
+			WithDetail("actual_attachments", len(draft.Attachments)+len(requests))
+	}
+
+	start := len(draft.Attachments)
+	for _, req := range requests {
+		attachment := domain.Attachment{
+			ID:          uuid.New().String(),
+			MessageID:   draft.ID,
+			Filename:    req.Filename,
+			ContentType: req.ContentType,
+			Size:        req.Size,
+			Content:     req.Content,
+			BlobID:      req.BlobID,
+		}
+		if req.BlobID == "" && req.Reader == nil {
+			attachment.Size = int64(len(req.Content))
+		}
+
+		var err error
+		switch {
+		case attachment.Size > config.MaxAttachmentSize:
+			err = errors.NewError(errors.ErrCodeMessageTooLarge, "Attachment too large").
+				WithDetail("max_size", config.MaxAttachmentSize).
+				WithDetail("actual_size", attachment.Size)
+		case s.blobs == nil && req.BlobID != "":
+			err = errors.NewError(errors.ErrCodeValidationError, "Attachments cannot reference stored content").
+				WithDetail("filename", req.Filename)
+		case s.blobs == nil && req.Reader != nil:
+			// Without a blob store the content is kept with the draft
+			attachment.Content, err = readAttachment(req)
+		case s.blobs != nil:
+			var blob *domain.Blob
+			blob, err = putAttachment(ctx, s.blobs, account.DomainID, req, config.MaxAttachmentSize)
+			if err == nil {
+				attachment.BlobID = blob.ID
+				attachment.Checksum = blob.SHA256
+				attachment.Size = blob.Size
+				attachment.Content = nil
+			}
+		}
+		if err != nil {
+			// Release the blobs already stored so they can be collected
+			s.releaseBlobs(ctx, draft.Attachments[start:])
+			draft.Attachments = draft.Attachments[:start]
+			return err
+		}
+		draft.Attachments = append(draft.Attachments, attachment)
+	}
+
+	// Uploads are scanned as they are attached, not only when the draft is sent
+	added := &domain.Message{ID: draft.ID, AccountID: draft.AccountID, Attachments: draft.Attachments[start:]}
+	if err := s.messages.scanAttachments(ctx, added); err != nil {
+		s.releaseBlobs(ctx, draft.Attachments[start:])
+		draft.Attachments = draft.Attachments[:start]
+		return err
+	}
+	return nil
+}
+
+// releaseBlobs drops the references of attachments to their blobs
+func (s *DraftService) releaseBlobs(ctx context.Context, attachments []domain.Attachment) {
+	if s.blobs == nil {
+		return
+	}
+	for _, attachment := range attachments {
+		if attachment.BlobID == "" {
+			continue
+		}
+		if err := s.blobs.Release(ctx, attachment.BlobID); err != nil {
+			// Log error but don't fail the operation
+		}
+	}
+}
+
+// index refreshes the search document of a draft
+func (s *DraftService) index(ctx context.Context, draft *domain.Message) {
+	if s.indexer == nil {
+		return
+	}
+	if err := s.indexer.IndexMessage(ctx, draft); err != nil {
+		// Log error but don't fail the operation
+	}
+}
+
+// senderProfile returns who an account sends composed messages as. Without
+// profiles, only the account address is allowed.
+func (s *DraftService) senderProfile(ctx context.Context, account *domain.EmailAccount, from string, reply bool) (*SenderProfile, error) {
+	if s.profiles != nil {
+		profile, err := s.profiles.SenderProfile(ctx, account, from, reply)
+		if err != nil {
+			return nil, err
+		}
+		if profile != nil {
+			return profile, nil
+		}
+	}
+
+	if from != "" {
+		address, err := mail.ParseAddress(from)
+		if err != nil || !strings.EqualFold(address.Address, account.Email) {
+			return nil, errors.SenderNotAllowed(from)
+		}
+	}
+	return accountSender(account), nil
+}
+
+// draftsFolder returns the Drafts folder of an account
+func (s *DraftService) draftsFolder(ctx context.Context, accountID string) (*domain.Folder, error) {
+	folder, err := s.folderRepo.GetByType(ctx, accountID, domain.FolderTypeDrafts)
+	if err != nil {
+		return nil, errors.InternalError(err)
+	}
+	if folder == nil {
+		return nil, errors.FolderNotFound(string(domain.FolderTypeDrafts))
+	}
+	return folder, nil
+}
+
+// ownedDraft returns a draft of one of the user's accounts
+func (s *DraftService) ownedDraft(ctx context.Context, userID, draftID string) (*domain.Message, *domain.EmailAccount, error) {
+	draft, err := s.messageRepo.GetByID(ctx, draftID)
+	if err != nil {
+		return nil, nil, errors.InternalError(err)
+	}
+	if draft == nil || !draft.IsDraft {
+		return nil, nil, errors.DraftNotFound(draftID)
+	}
+	account, err := s.accountRepo.GetByID(ctx, draft.AccountID)
+	if err != nil {
+		return nil, nil, errors.InternalError(err)
+	}
+	if account == nil || account.UserID != userID {
+		// Do not reveal drafts owned by other users
+		return nil, nil, errors.DraftNotFound(draftID)
+	}
+	return draft, account, nil
+}
+
+// ownedMessage returns a message of one of the user's accounts
+func (s *DraftService) ownedMessage(ctx context.Context, userID, messageID string) (*domain.Message, *domain.EmailAccount, error) {
+	message, err := s.messageRepo.GetByID(ctx, messageID)
+	if err != nil {
+		return nil, nil, errors.InternalError(err)
+	}
+	if message == nil {
+		return nil, nil, errors.MessageNotFound(messageID)
+	}
+	account, err := s.accountRepo.GetByID(ctx, message.AccountID)
+	if err != nil {
+		return nil, nil, errors.InternalError(err)
+	}
+	if account == nil || account.UserID != userID {
+		// Do not reveal messages owned by other users
+		return nil, nil, errors.MessageNotFound(messageID)
+	}
+	return message, account, nil
+}
+
+// ownedAccount returns an email account of the user
+func (s *DraftService) ownedAccount(ctx context.Context, userID, accountID string) (*domain.EmailAccount, error) {
+	account, err := s.accountRepo.GetByID(ctx, accountID)
+	if err != nil {
+		return nil, errors.InternalError(err)
+	}
+	if account == nil || account.UserID != userID {
+		// Do not reveal accounts owned by other users
+		return nil, errors.EmailAccountNotFound(accountID)
+	}
+	return account, nil
+}
@@ -0,0 +1,176 @@
+package service
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/skygenesisenterprise/aether-mailer/package/golang/domain"
+	"github.com/skygenesisenterprise/aether-mailer/package/golang/repository/inmemory"
+)
+
+// testMail holds the in-memory repositories shared by the service tests
+type testMail struct {
+	store       *inmemory.Store
+	users       *inmemory.UserRepository
+	domains     *inmemory.DomainRepository
+	members     *inmemory.DomainMemberRepository
+	accounts    *inmemory.EmailAccountRepository
+	folders     *inmemory.FolderRepository
+	messages    *inmemory.MessageRepository
+	attachments *inmemory.AttachmentRepository
+	quotas      *inmemory.QuotaRepository
+	policies    *inmemory.PolicyRepository
+	events      *inmemory.EventPublisher
+	domain      *domain.Domain
+}
+
+func newTestMail(t *testing.T) *testMail {
+	t.Helper()
+	store := inmemory.NewStore()
+	m := &testMail{
+		store:       store,
+		users:       inmemory.NewUserRepository(store),
+		domains:     inmemory.NewDomainRepository(store),
+		members:     inmemory.NewDomainMemberRepository(store),
+		accounts:    inmemory.NewEmailAccountRepository(store),
+		folders:     inmemory.NewFolderRepository(store),
+		messages:    inmemory.NewMessageRepository(store),
+		attachments: inmemory.NewAttachmentRepository(store),
+		quotas:      inmemory.NewQuotaRepository(store),
+		policies:    inmemory.NewPolicyRepository(store),
+		events:      inmemory.NewEventPublisher(nil),
+	}
+
+	owner := m.newUser(t, "postmaster")
+	m.domain = &domain.Domain{
+		ID:        uuid.NewString(),
+		Name:      "example.com",
+		IsActive:  true,
+		MaxUsers:  10,
+		OwnerID:   owner.ID,
+		CreatedAt: time.Now(),
+		UpdatedAt: time.Now(),
+	}
+	if err := m.domains.Create(context.Background(), m.domain); err != nil {
+		t.Fatal(err)
+	}
+	return m
+}
+
+// newUser creates an active user
+func (m *testMail) newUser(t *testing.T, name string) *domain.User {
+	t.Helper()
+	now := time.Now()
+	user := &domain.User{
+		ID:                uuid.NewString(),
+		Username:          name,
+		Email:             name + "@users.example",
+		PasswordHash:      "hash",
+		Role:              domain.UserRoleUser,
+		IsActive:          true,
+		CreatedAt:         now,
+		UpdatedAt:         now,
+		PasswordChangedAt: now,
+		Timezone:          "UTC",
+		Locale:            "en",
+		Theme:             "light",
+	}
+	if err := m.users.Create(context.Background(), user); err != nil {
+		t.Fatal(err)
+	}
+	return user
+}
+
+// newAccount creates an account of the test domain with its INBOX, Sent,
+// Drafts, Trash and Spam folders. A nil owner creates a shared mailbox.
+func (m *testMail) newAccount(t *testing.T, owner *domain.User, local string) *domain.EmailAccount {
+	t.Helper()
+	ctx := context.Background()
+	account := &domain.EmailAccount{
+		ID:        uuid.NewString(),
+		DomainID:  m.domain.ID,
+		Email:     local + "@" + m.domain.Name,
+		IsActive:  true,
+		QuotaMB:   100,
+		CreatedAt: time.Now(),
+		UpdatedAt: time.Now(),
+	}
+	if owner != nil {
+		account.UserID = owner.ID
+	}
+	if err := m.accounts.Create(ctx, account); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, folder := range []struct {
+		name string
+		kind domain.FolderType
+	}{
+		{"INBOX", domain.FolderTypeInbox},
+		{"Sent", domain.FolderTypeSent},
+		{"Drafts", domain.FolderTypeDrafts},
+		{"Trash", domain.FolderTypeTrash},
+		{"Spam", domain.FolderTypeSpam},
+	} {
+		m.newFolder(t, account, nil, folder.name, folder.kind)
+	}
+	return account
+}
+
+// newFolder creates a folder of an account, below parent when it is set
+func (m *testMail) newFolder(t *testing.T, account *domain.EmailAccount, parent *domain.Folder, name string, kind domain.FolderType) *domain.Folder {
+	t.Helper()
+	folder := &domain.Folder{
+		ID:           uuid.NewString(),
+		AccountID:    account.ID,
+		Name:         name,
+		Path:         name,
+		Type:         kind,
+		IsSubscribed: true,
+		CreatedAt:    time.Now(),
+		UpdatedAt:    time.Now(),
+	}
+	if parent != nil {
+		folder.ParentID = &parent.ID
+		folder.Path = parent.Path + "/" + name
+	}
+	if err := m.folders.Create(context.Background(), folder); err != nil {
+		t.Fatal(err)
+	}
+	return folder
+}
+
+// folder returns the folder of an account with the given type
+func (m *testMail) folder(t *testing.T, account *domain.EmailAccount, kind domain.FolderType) *domain.Folder {
+	t.Helper()
+	folder, err := m.folders.GetByType(context.Background(), account.ID, kind)
+	if err != nil || folder == nil {
+		t.Fatalf("GetByType(%s) = %v, %v", kind, folder, err)
+	}
+	return folder
+}
+
+// messageDeps returns the dependencies of a message service on the test
+// repositories, without any of the optional services
+func (m *testMail) messageDeps() MessageServiceDeps {
+	return MessageServiceDeps{
+		Messages:    m.messages,
+		Accounts:    m.accounts,
+		Attachments: m.attachments,
+		Quotas:      m.quotas,
+		Policies:    m.policies,
+		Folders:     m.folders,
+		EventPub:    m.events,
+		Config: &MessageConfig{
+			MaxMessageSize:    10 * 1024 * 1024,
+			MaxAttachments:    10,
+			MaxAttachmentSize: 5 * 1024 * 1024,
+		},
+	}
+}
+
+func stringPtr(s string) *string {
+	return &s
+}
@@ -0,0 +1,225 @@
+package service
+
+import (
+	"net/url"
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+// htmlPolicy is an allow-list of the elements, attributes and URLs an HTML
+// body keeps when it is sanitized. Bodies are tokenized rather than matched
+// with patterns, so split, nested or malformed tags cannot smuggle markup
+// through: whatever the policy does not know is dropped and text is always
+// re-escaped.
+type htmlPolicy struct {
+	liveLinks    bool // keep href targets, otherwise they move to data-href
+	remoteImages bool // keep http(s) images and backgrounds
+	newWindow    bool // open links in a new window without a referrer
+}
+
+// previewHTMLPolicy renders suspect mail, such as quarantined messages:
+// links are disarmed and remote resources are blocked
+var previewHTMLPolicy = htmlPolicy{}
+
+// messageHTMLPolicy renders delivered mail to its reader: links stay live
+// but open apart from the client, and remote resources are blocked
+var messageHTMLPolicy = htmlPolicy{liveLinks: true, newWindow: true}
+
+// quotedHTMLPolicy cleans HTML passed on in replies, forwards and
+// signatures, keeping the links and images the sender may want to pass on
+var quotedHTMLPolicy = htmlPolicy{liveLinks: true, remoteImages: true}
+
+// allowedHTMLElements are the elements kept by every policy. Other elements
+// are dropped but their text is kept.
+var allowedHTMLElements = stringSet(
+	"a", "abbr", "acronym", "address", "article", "aside", "b", "bdi", "bdo", "big",
+	"blockquote", "br", "caption", "center", "cite", "code", "col", "colgroup", "dd",
+	"del", "details", "dfn", "div", "dl", "dt", "em", "figcaption", "figure", "font",
+	"footer", "h1", "h2", "h3", "h4", "h5", "h6", "header", "hr", "i", "img", "ins",
+	"kbd", "li", "main", "mark", "nav", "ol", "p", "pre", "q", "rp", "rt", "ruby", "s",
+	"samp", "section", "small", "span", "strike", "strong", "sub", "summary", "sup",
+	"table", "tbody", "td", "tfoot", "th", "thead", "time", "tr", "tt", "u", "ul",
+	"var", "wbr",
+)
+
+// droppedHTMLElements are dropped together with everything inside them
+var droppedHTMLElements = stringSet(
+	"applet", "audio", "canvas", "embed", "frame", "frameset", "iframe", "math",
+	"noembed", "noframes", "noscript", "object", "plaintext", "script", "select",
+	"style", "svg", "template", "textarea", "title", "video", "xmp",
+)
+
+var voidHTMLElements = stringSet("br", "col", "hr", "img", "wbr")
+
+// allowedHTMLAttributes are the attributes kept on any allowed element
+var allowedHTMLAttributes = stringSet(
+	"align", "bgcolor", "border", "class", "color", "dir", "height", "lang", "style",
+	"title", "valign", "width",
+)
+
+var allowedHTMLElementAttributes = map[string]map[string]bool{
+	"a":        stringSet("href"),
+	"img":      stringSet("src", "alt"),
+	"table":    stringSet("background", "cellpadding", "cellspacing"),
+	"td":       stringSet("background", "colspan", "rowspan", "nowrap"),
+	"th":       stringSet("background", "colspan", "rowspan", "nowrap", "scope"),
+	"col":      stringSet("span"),
+	"colgroup": stringSet("span"),
+	"font":     stringSet("face", "size"),
+	"ol":       stringSet("start", "type"),
+	"ul":       stringSet("type"),
+	"li":       stringSet("value"),
+	"time":     stringSet("datetime"),
+}
+
+// unsafeStyleFragments disqualify a style attribute: they load resources,
+// run script in old engines or hide either behind escapes and comments
+var unsafeStyleFragments = []string{
+	"\\", "/*", "url(", "image-set(", "@import", "expression", "behavior", "binding",
+	"javascript:", "vbscript:", "<",
+}
+
+// SanitizeHTML strips active content, remote resources and live links from
+// an HTML body so it can be previewed safely
+func SanitizeHTML(body string) string {
+	return previewHTMLPolicy.sanitize(body)
+}
+
+// SanitizeMessageHTML strips active content and remote resources from the
+// HTML body of a message shown to its reader. Unlike SanitizeHTML it keeps
+// links, which open in a new window.
+func SanitizeMessageHTML(body string) string {
+	return messageHTMLPolicy.sanitize(body)
+}
+
+func (p htmlPolicy) sanitize(body string) string {
+	var out strings.Builder
+	tokenizer := html.NewTokenizer(strings.NewReader(body))
+	skipping, depth := "", 0
+	for {
+		tokenType := tokenizer.Next()
+		if tokenType == html.ErrorToken {
+			// End of the body
+			return out.String()
+		}
+		token := tokenizer.Token()
+
+		if skipping != "" {
+			switch {
+			case tokenType == html.StartTagToken && token.Data == skipping:
+				depth++
+			case tokenType == html.EndTagToken && token.Data == skipping:
+				if depth--; depth == 0 {
+					skipping = ""
+				}
+			}
+			continue
+		}
+
+		switch tokenType {
+		case html.TextToken:
+			out.WriteString(html.EscapeString(token.Data))
+		case html.StartTagToken, html.SelfClosingTagToken:
+			if droppedHTMLElements[token.Data] {
+				// Outside SVG and MathML a trailing slash does not close an
+				// element, so <script/> still opens a script
+				if tokenType == html.StartTagToken || (token.Data != "svg" && token.Data != "math") {
+					skipping, depth = token.Data, 1
+				}
+				continue
+			}
+			if allowedHTMLElements[token.Data] {
+				p.writeStartTag(&out, token)
+			}
+		case html.EndTagToken:
+			if allowedHTMLElements[token.Data] && !voidHTMLElements[token.Data] {
+				out.WriteString("</" + token.Data + ">")
+			}
+		}
+		// Comments and doctypes are dropped
+	}
+}
+
+func (p htmlPolicy) writeStartTag(out *strings.Builder, token html.Token) {
+	out.WriteString("<" + token.Data)
+	seen := make(map[string]bool, len(token.Attr))
+	for _, attr := range token.Attr {
+		// Browsers honour the first of repeated attributes
+		if attr.Namespace != "" || seen[attr.Key] {
+			continue
+		}
+		seen[attr.Key] = true
+		if name, value, ok := p.attribute(token.Data, attr.Key, attr.Val); ok {
+			out.WriteString(" " + name + `="` + html.EscapeString(value) + `"`)
+		}
+	}
+	if token.Data == "a" && p.newWindow {
+		out.WriteString(` target="_blank" rel="noopener noreferrer"`)
+	}
+	out.WriteString(">")
+}
+
+// attribute returns the name and value an attribute is written with, or
+// false when it is dropped
+func (p htmlPolicy) attribute(element, name, value string) (string, string, bool) {
+	if !allowedHTMLAttributes[name] && !allowedHTMLElementAttributes[element][name] {
+		return "", "", false
+	}
+
+	switch name {
+	case "href":
+		value = strings.TrimSpace(value)
+		if !strings.HasPrefix(value, "#") {
+			if _, ok := urlScheme(value, "http", "https", "mailto", "tel"); !ok {
+				return "", "", false
+			}
+		}
+		if !p.liveLinks {
+			return "data-href", value, true
+		}
+	case "src", "background":
+		value = strings.TrimSpace(value)
+		scheme, ok := urlScheme(value, "http", "https", "cid")
+		if !ok {
+			return "", "", false
+		}
+		if scheme != "cid" && !p.remoteImages {
+			return "data-blocked", "remote-content", true
+		}
+	case "style":
+		lower := strings.ToLower(value)
+		for _, fragment := range unsafeStyleFragments {
+			if strings.Contains(lower, fragment) {
+				return "", "", false
+			}
+		}
+	}
+	return name, value, true
+}
+
+// urlScheme returns the lower-cased scheme of an absolute URL and whether it
+// is one of the allowed schemes. Entities are already decoded by the
+// tokenizer and control characters fail to parse, so obfuscated schemes such
+// as "java&#9;script:" are refused.
+func urlScheme(value string, allowed ...string) (string, bool) {
+	parsed, err := url.Parse(value)
+	if err != nil {
+		return "", false
+	}
+	scheme := strings.ToLower(parsed.Scheme)
+	for _, candidate := range allowed {
+		if scheme == candidate {
+			return scheme, true
+		}
+	}
+	return "", false
+}
+
+func stringSet(values ...string) map[string]bool {
+	set := make(map[string]bool, len(values))
+	for _, value := range values {
+		set[value] = true
+	}
+	return set
+}
@@ -0,0 +1,130 @@
+package service
+
+import (
+	"strings"
+	"testing"
+
+	"golang.org/x/net/html"
+)
+
+func TestSanitizeHTML(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		want string
+	}{
+		{"split script tag", `<scr<script>ipt>alert(1)</script>`, `ipt&gt;alert(1)`},
+		{"svg event handler", `<svg/onload=alert(1)>`, ``},
+		{"svg with content", `<svg><script>alert(1)</script></svg>after`, `after`},
+		{"self-closed script", `<script/>alert(1)</script>ok`, `ok`},
+		{"unclosed script", `ok<script>alert(1)`, `ok`},
+		{"upper-case script", `<SCRIPT>alert(1)</SCRIPT>ok`, `ok`},
+		{"img onerror", `<img src=x onerror=alert(1)>`, `<img>`},
+		{"event handler", `<p onclick="alert(1)" class=note>hi</p>`, `<p class="note">hi</p>`},
+		{"javascript link", `<a href="javascript:alert(1)">x</a>`, `<a>x</a>`},
+		{"entity encoded scheme", `<a href="&#106;avascript:alert(1)">x</a>`, `<a>x</a>`},
+		{"tab in scheme", `<a href="java&#9;script:alert(1)">x</a>`, `<a>x</a>`},
+		{"data URL image", `<img src="data:text/html;base64,PHNjcmlwdD4=">`, `<img>`},
+		{"noscript breakout", `<noscript><p title="</noscript><img src=x onerror=alert(1)>">`, `<img>&#34;&gt;`},
+		{"conditional comment", `<!--[if IE]><script>alert(1)</script><![endif]-->hi`, `hi`},
+		{"iframe", `<iframe src="https://evil.example"></iframe>ok`, `ok`},
+		{"form controls", `<form action="https://evil.example"><input name=a>Name<button>Go</button></form>`, `NameGo`},
+		{"meta refresh", `<meta http-equiv="refresh" content="0;url=https://evil.example">ok`, `ok`},
+		{"style element", `<style>body{background:url(https://t.example)}</style>ok`, `ok`},
+		{"style expression", `<div style="width:expression(alert(1))">x</div>`, `<div>x</div>`},
+		{"style escape", `<div style="background:u\72l(https://t.example)">x</div>`, `<div>x</div>`},
+		{"safe style", `<p style="color:red">x</p>`, `<p style="color:red">x</p>`},
+		{"remote image blocked", `<IMG SRC="https://t.example/p.gif" ALT=x>`, `<img data-blocked="remote-content" alt="x">`},
+		{"remote background blocked", `<td background="http://t.example/bg.png">x</td>`, `<td data-blocked="remote-content">x</td>`},
+		{"inline image kept", `<img src="cid:logo@example.com">`, `<img src="cid:logo@example.com">`},
+		{"link disarmed", `<a href="https://example.com/?a=1&amp;b=2">go</a>`, `<a data-href="https://example.com/?a=1&amp;b=2">go</a>`},
+		{"forged data attribute", `<a data-href="javascript:alert(1)">go</a>`, `<a>go</a>`},
+		{"text escaped", `1 < 2 &amp; 3 > 0`, `1 &lt; 2 &amp; 3 &gt; 0`},
+		{"attribute quotes escaped", `<p title='a"><script>alert(1)</script>'>x</p>`, `<p title="a&#34;&gt;&lt;script&gt;alert(1)&lt;/script&gt;">x</p>`},
+		{"document wrapper", `<!DOCTYPE html><html><head><title>T</title></head><body><p>Hi</p></body></html>`, `<p>Hi</p>`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := SanitizeHTML(tt.in)
+			if got != tt.want {
+				t.Errorf("SanitizeHTML(%q) = %q, want %q", tt.in, got, tt.want)
+			}
+			assertInertHTML(t, got)
+		})
+	}
+}
+
+// assertInertHTML parses sanitized output as a browser would and fails on
+// anything able to run script
+func assertInertHTML(t *testing.T, body string) {
+	t.Helper()
+	tokenizer := html.NewTokenizer(strings.NewReader(body))
+	for {
+		tokenType := tokenizer.Next()
+		if tokenType == html.ErrorToken {
+			return
+		}
+		if tokenType != html.StartTagToken && tokenType != html.SelfClosingTagToken {
+			continue
+		}
+		token := tokenizer.Token()
+		if !allowedHTMLElements[token.Data] {
+			t.Errorf("output %q keeps element <%s>", body, token.Data)
+		}
+		for _, attr := range token.Attr {
+			value := strings.ToLower(strings.TrimSpace(attr.Val))
+			if strings.HasPrefix(attr.Key, "on") || strings.HasPrefix(value, "javascript:") {
+				t.Errorf("output %q keeps attribute %s=%q", body, attr.Key, attr.Val)
+			}
+		}
+	}
+}
+
+func TestCleanQuotedHTML(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		want string
+	}{
+		{"link kept", `<a href="https://example.com">go</a>`, `<a href="https://example.com">go</a>`},
+		{"remote image kept", `<img src="https://example.com/logo.png" alt="Logo">`, `<img src="https://example.com/logo.png" alt="Logo">`},
+		{"split script tag", `<scr<script>ipt>alert(1)</script>`, `ipt&gt;alert(1)`},
+		{"svg event handler", `<svg/onload=alert(1)>`, ``},
+		{"javascript link", `<a href=" javascript:alert(1)">x</a>`, `<a>x</a>`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := cleanQuotedHTML(tt.in)
+			if got != tt.want {
+				t.Errorf("cleanQuotedHTML(%q) = %q, want %q", tt.in, got, tt.want)
+			}
+			assertInertHTML(t, got)
+		})
+	}
+}
+
+func TestSanitizeMessageHTML(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		want string
+	}{
+		{"link opens apart", `<a href="https://example.com" target="_self">go</a>`, `<a href="https://example.com" target="_blank" rel="noopener noreferrer">go</a>`},
+		{"remote image blocked", `<img src="https://t.example/p.gif">`, `<img data-blocked="remote-content">`},
+		{"split script tag", `<scr<script>ipt>alert(1)</script>`, `ipt&gt;alert(1)`},
+		{"svg event handler", `<svg/onload=alert(1)>`, ``},
+		{"javascript link", `<a href="JavaScript:alert(1)">x</a>`, `<a target="_blank" rel="noopener noreferrer">x</a>`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := SanitizeMessageHTML(tt.in)
+			if got != tt.want {
+				t.Errorf("SanitizeMessageHTML(%q) = %q, want %q", tt.in, got, tt.want)
+			}
+			assertInertHTML(t, got)
+		})
+	}
+}
@@ -0,0 +1,699 @@
+package service
+
+import (
+	"context"
+	"net/mail"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/skygenesisenterprise/aether-mailer/package/golang/domain"
+	"github.com/skygenesisenterprise/aether-mailer/package/golang/errors"
+	"github.com/skygenesisenterprise/aether-mailer/package/golang/repository"
+)
+
+// IdentityService manages the addresses the email accounts of a user send
+// as, with their display name, reply-to address and signature. Addresses
+// other than an account's own, such as aliases, wait for an administrator
+// of their domain to approve them. Delegations let an account send as, or
+// on behalf of, another mailbox, such as a shared mailbox.
+//
+// IdentityService is the SenderProfiles of DraftService and the
+// SenderAuthorizer of MessageService, so drafts and sent messages can only
+// use an allowed From address.
+type IdentityService struct {
+	identityRepo   repository.IdentityRepository
+	delegationRepo repository.SenderDelegationRepository
+	accountRepo    repository.EmailAccountRepository
+	domainRepo     repository.DomainRepository
+	memberRepo     repository.DomainMemberRepository
+	userRepo       repository.UserRepository
+	transactor     repository.Transactor
+}
+
+// IdentityRequest is a new identity of an account
+type IdentityRequest struct {
+	AccountID     string
+	Email         string
+	Name          string
+	ReplyTo       string
+	SignatureText string
+	SignatureHTML string
+	IsDefault     bool
+}
+
+// IdentityUpdate changes an identity. Nil fields are kept; the address
+// cannot change.
+type IdentityUpdate struct {
+	Name          *string
+	ReplyTo       *string
+	SignatureText *string
+	SignatureHTML *string
+	IsDefault     *bool
+}
+
+// DelegationRequest lets another account send for a mailbox. Granting a
+// delegation again changes its permission.
+type DelegationRequest struct {
+	AccountID     string // mailbox sent for
+	DelegateEmail string // address of the delegate account
+	Permission    domain.DelegationPermission
+}
+
+// NewIdentityService creates a new identity service. transactor is
+// optional; when set, an identity and the default of its account change
+// atomically.
+func NewIdentityService(
+	identityRepo repository.IdentityRepository,
+	delegationRepo repository.SenderDelegationRepository,
+	accountRepo repository.EmailAccountRepository,
+	domainRepo repository.DomainRepository,
+	memberRepo repository.DomainMemberRepository,
+	userRepo repository.UserRepository,
+	transactor repository.Transactor,
+) *IdentityService {
+	return &IdentityService{
+		identityRepo:   identityRepo,
+		delegationRepo: delegationRepo,
+		accountRepo:    accountRepo,
+		domainRepo:     domainRepo,
+		memberRepo:     memberRepo,
+		userRepo:       userRepo,
+		transactor:     transactor,
+	}
+}
+
+// ListIdentities lists the identities of one of the user's accounts
+func (s *IdentityService) ListIdentities(ctx context.Context, userID, accountID string) ([]*domain.Identity, error) {
+	if _, err := s.ownedAccount(ctx, userID, accountID); err != nil {
+		return nil, err
+	}
+	identities, err := s.identityRepo.List(ctx, repository.IdentityFilter{AccountID: accountID})
+	if err != nil {
+		return nil, errors.InternalError(err)
+	}
+	return identities, nil
+}
+
+// GetIdentity returns an identity of one of the user's accounts
+func (s *IdentityService) GetIdentity(ctx context.Context, userID, id string) (*domain.Identity, error) {
+	identity, _, err := s.ownedIdentity(ctx, userID, id)
+	return identity, err
+}
+
+// CreateIdentity adds an address in a hosted domain to one of the user's
+// accounts. The account's own address is approved at once, and so are
+// addresses in domains the user administers; others wait for review.
+func (s *IdentityService) CreateIdentity(ctx context.Context, userID string, req IdentityRequest) (*domain.Identity, error) {
+	account, err := s.ownedAccount(ctx, userID, req.AccountID)
+	if err != nil {
+		return nil, err
+	}
+	email, err := normalizeAddress(req.Email)
+	if err != nil {
+		return nil, err
+	}
+	replyTo, err := formatReplyTo(req.ReplyTo)
+	if err != nil {
+		return nil, err
+	}
+	d, err := s.hostedDomain(ctx, email)
+	if err != nil {
+		return nil, err
+	}
+
+	existing, err := s.identityRepo.GetByEmail(ctx, account.ID, email)
+	if err != nil {
+		return nil, errors.InternalError(err)
+	}
+	if existing != nil {
+		return nil, errors.IdentityAlreadyExists(email)
+	}
+
+	now := time.Now().UTC().Truncate(time.Microsecond)
+	identity := &domain.Identity{
+		ID:            uuid.New().String(),
+		AccountID:     account.ID,
+		DomainID:      d.ID,
+		Email:         email,
+		Name:          strings.TrimSpace(req.Name),
+		ReplyTo:       replyTo,
+		SignatureText: req.SignatureText,
+		SignatureHTML: req.SignatureHTML,
+		Status:        domain.IdentityStatusPending,
+		CreatedAt:     now,
+		UpdatedAt:     now,
+	}
+	if strings.EqualFold(email, account.Email) {
+		identity.Status = domain.IdentityStatusApproved
+	} else {
+		admin, err := isDomainAdmin(ctx, s.userRepo, s.memberRepo, userID, d)
+		if err != nil {
+			return nil, err
+		}
+		if admin {
+			identity.Status = domain.IdentityStatusApproved
+			identity.ReviewedBy = &userID
+			identity.ReviewedAt = &now
+		}
+	}
+
+	err = withinTransaction(ctx, s.transactor, func(ctx context.Context) error {
+		if err := s.identityRepo.Create(ctx, identity); err != nil {
+			return errors.InternalError(err)
+		}
+		if req.IsDefault {
+			if err := s.identityRepo.SetDefault(ctx, account.ID, identity.ID); err != nil {
+				return errors.InternalError(err)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	identity.IsDefault = req.IsDefault
+	return identity, nil
+}
+
+// UpdateIdentity changes the display name, reply-to address, signature or
+// default flag of an identity of one of the user's accounts
+func (s *IdentityService) UpdateIdentity(ctx context.Context, userID, id string, update IdentityUpdate) (*domain.Identity, error) {
+	identity, account, err := s.ownedIdentity(ctx, userID, id)
+	if err != nil {
+		return nil, err
+	}
+
+	if update.Name != nil {
+		identity.Name = strings.TrimSpace(*update.Name)
+	}
+	if update.ReplyTo != nil {
+		replyTo, err := formatReplyTo(*update.ReplyTo)
+		if err != nil {
+			return nil, err
+		}
+		identity.ReplyTo = replyTo
+	}
+	if update.SignatureText != nil {
+		identity.SignatureText = *update.SignatureText
+	}
+	if update.SignatureHTML != nil {
+		identity.SignatureHTML = *update.SignatureHTML
+	}
+	identity.UpdatedAt = time.Now().UTC().Truncate(time.Microsecond)
+
+	err = withinTransaction(ctx, s.transactor, func(ctx context.Context) error {
+		if err := s.identityRepo.Update(ctx, identity); err != nil {
+			return errors.InternalError(err)
+		}
+		if update.IsDefault == nil || *update.IsDefault == identity.IsDefault {
+			return nil
+		}
+		defaultID := ""
+		if *update.IsDefault {
+			defaultID = identity.ID
+		}
+		if err := s.identityRepo.SetDefault(ctx, account.ID, defaultID); err != nil {
+			return errors.InternalError(err)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if update.IsDefault != nil {
+		identity.IsDefault = *update.IsDefault
+	}
+	return identity, nil
+}
+
+// DeleteIdentity removes an identity of one of the user's accounts. Its
+// address can no longer be sent as, unless it is the account's own.
+func (s *IdentityService) DeleteIdentity(ctx context.Context, userID, id string) error {
+	identity, _, err := s.ownedIdentity(ctx, userID, id)
+	if err != nil {
+		return err
+	}
+	if err := s.identityRepo.Delete(ctx, identity.ID); err != nil {
+		return errors.InternalError(err)
+	}
+	return nil
+}
+
+// ListPendingIdentities lists the identities in a domain the user
+// administers that wait for review, oldest first
+func (s *IdentityService) ListPendingIdentities(ctx context.Context, userID, domainID string, limit, offset int) ([]*domain.Identity, int, error) {
+	if err := s.requireDomainAdmin(ctx, userID, domainID); err != nil {
+		return nil, 0, err
+	}
+
+	pending := domain.IdentityStatusPending
+	filter := repository.IdentityFilter{DomainID: domainID, Status: &pending, Limit: limit, Offset: offset}
+	identities, err := s.identityRepo.List(ctx, filter)
+	if err != nil {
+		return nil, 0, errors.InternalError(err)
+	}
+	total, err := s.identityRepo.Count(ctx, filter)
+	if err != nil {
+		return nil, 0, errors.InternalError(err)
+	}
+	return identities, total, nil
+}
+
+// ReviewIdentity approves or rejects an identity in a domain the user
+// administers. A rejected identity is kept so it is not requested again
+// unnoticed, and can be approved later.
+func (s *IdentityService) ReviewIdentity(ctx context.Context, userID, id string, approve bool) (*domain.Identity, error) {
+	identity, err := s.identityRepo.GetByID(ctx, id)
+	if err != nil {
+		return nil, errors.InternalError(err)
+	}
+	if identity == nil {
+		return nil, errors.IdentityNotFound(id)
+	}
+	if err := s.requireDomainAdmin(ctx, userID, identity.DomainID); err != nil {
+		return nil, err
+	}
+
+	now := time.Now().UTC().Truncate(time.Microsecond)
+	identity.Status = domain.IdentityStatusRejected
+	if approve {
+		identity.Status = domain.IdentityStatusApproved
+	}
+	identity.ReviewedBy = &userID
+	identity.ReviewedAt = &now
+	identity.UpdatedAt = now
+	if err := s.identityRepo.Update(ctx, identity); err != nil {
+		return nil, errors.InternalError(err)
+	}
+	return identity, nil
+}
+
+// GrantDelegation lets another account send as, or on behalf of, a mailbox
+// the user owns or administers, such as a shared mailbox
+func (s *IdentityService) GrantDelegation(ctx context.Context, userID string, req DelegationRequest) (*domain.SenderDelegation, error) {
+	mailbox, err := s.managedAccount(ctx, userID, req.AccountID)
+	if err != nil {
+		return nil, err
+	}
+	if req.Permission != domain.DelegationSendAs && req.Permission != domain.DelegationSendOnBehalf {
+		return nil, errors.NewError(errors.ErrCodeValidationError, "Invalid delegation permission").
+			WithDetail("permission", req.Permission)
+	}
+	address, err := mail.ParseAddress(req.DelegateEmail)
+	if err != nil {
+		return nil, errors.NewError(errors.ErrCodeInvalidEmailAddress, "Invalid email address").
+			WithDetail("email", req.DelegateEmail)
+	}
+	delegate, err := s.accountRepo.GetByEmail(ctx, address.Address)
+	if err != nil {
+		return nil, errors.InternalError(err)
+	}
+	if delegate == nil {
+		return nil, errors.EmailAccountNotFound(address.Address)
+	}
+	if delegate.ID == mailbox.ID {
+		return nil, errors.NewError(errors.ErrCodeValidationError, "A mailbox cannot delegate to itself").
+			WithDetail("account_id", mailbox.ID)
+	}
+
+	now := time.Now().UTC().Truncate(time.Microsecond)
+	delegation, err := s.delegationRepo.Get(ctx, mailbox.ID, delegate.ID)
+	if err != nil {
+		return nil, errors.InternalError(err)
+	}
+	if delegation != nil {
+		delegation.Permission = req.Permission
+		delegation.GrantedBy = userID
+		delegation.UpdatedAt = now
+		if err := s.delegationRepo.Update(ctx, delegation); err != nil {
+			return nil, errors.InternalError(err)
+		}
+		return delegation, nil
+	}
+
+	delegation = &domain.SenderDelegation{
+		ID:                uuid.New().String(),
+		AccountID:         mailbox.ID,
+		DelegateAccountID: delegate.ID,
+		Permission:        req.Permission,
+		GrantedBy:         userID,
+		CreatedAt:         now,
+		UpdatedAt:         now,
+	}
+	if err := s.delegationRepo.Create(ctx, delegation); err != nil {
+		return nil, errors.InternalError(err)
+	}
+	return delegation, nil
+}
+
+// ListDelegations lists the delegations of a mailbox the user owns or
+// administers
+func (s *IdentityService) ListDelegations(ctx context.Context, userID, accountID string) ([]*domain.SenderDelegation, error) {
+	if _, err := s.managedAccount(ctx, userID, accountID); err != nil {
+		return nil, err
+	}
+	delegations, err := s.delegationRepo.ListByAccount(ctx, accountID)
+	if err != nil {
+		return nil, errors.InternalError(err)
+	}
+	return delegations, nil
+}
+
+// RevokeDelegation removes a delegation of a mailbox the user owns or
+// administers; delegates may also give up their own delegations
+func (s *IdentityService) RevokeDelegation(ctx context.Context, userID, id string) error {
+	delegation, err := s.delegationRepo.GetByID(ctx, id)
+	if err != nil {
+		return errors.InternalError(err)
+	}
+	if delegation == nil {
+		return errors.DelegationNotFound(id)
+	}
+	if _, err := s.managedAccount(ctx, userID, delegation.AccountID); err != nil {
+		if _, err := s.ownedAccount(ctx, userID, delegation.DelegateAccountID); err != nil {
+			// Do not reveal delegations of other users
+			return errors.DelegationNotFound(id)
+		}
+	}
+	if err := s.delegationRepo.Delete(ctx, delegation.ID); err != nil {
+		return errors.InternalError(err)
+	}
+	return nil
+}
+
+// ListSenders lists who one of the user's accounts may send as: its default
+// address first, its approved identities, then the addresses of the
+// mailboxes delegated to it
+func (s *IdentityService) ListSenders(ctx context.Context, userID, accountID string) ([]*SenderProfile, error) {
+	account, err := s.ownedAccount(ctx, userID, accountID)
+	if err != nil {
+		return nil, err
+	}
+
+	def, err := s.defaultProfile(ctx, account)
+	if err != nil {
+		return nil, err
+	}
+	senders := []*SenderProfile{def}
+	own, err := s.mailboxProfiles(ctx, account)
+	if err != nil {
+		return nil, err
+	}
+	for _, profile := range own {
+		if profile.From != def.From {
+			senders = append(senders, profile)
+		}
+	}
+
+	delegations, err := s.delegationRepo.ListByDelegate(ctx, account.ID)
+	if err != nil {
+		return nil, errors.InternalError(err)
+	}
+	for _, delegation := range delegations {
+		mailbox, err := s.accountRepo.GetByID(ctx, delegation.AccountID)
+		if err != nil {
+			return nil, errors.InternalError(err)
+		}
+		if mailbox == nil || !mailbox.IsActive {
+			continue
+		}
+		profiles, err := s.mailboxProfiles(ctx, mailbox)
+		if err != nil {
+			return nil, err
+		}
+		for _, profile := range profiles {
+			if delegation.Permission == domain.DelegationSendOnBehalf {
+				profile.Sender = def.From
+			}
+			senders = append(senders, profile)
+		}
+	}
+	return senders, nil
+}
+
+// SenderProfile returns who a message composed for an account is sent as.
+// Replies and forwards are signed like new messages.
+func (s *IdentityService) SenderProfile(ctx context.Context, account *domain.EmailAccount, from string, reply bool) (*SenderProfile, error) {
+	return s.AuthorizeSender(ctx, account, from)
+}
+
+// AuthorizeSender returns who a message of an account is sent as. An empty
+// from is the default identity. Otherwise from must be the account's own
+// address, one of its approved identities, or an address of a mailbox
+// delegated to the account; sending on behalf of a mailbox sets Sender to
+// the account's default address.
+func (s *IdentityService) AuthorizeSender(ctx context.Context, account *domain.EmailAccount, from string) (*SenderProfile, error) {
+	if from == "" {
+		return s.defaultProfile(ctx, account)
+	}
+	address, err := mail.ParseAddress(from)
+	if err != nil {
+		return nil, errors.SenderNotAllowed(from)
+	}
+	email := strings.ToLower(address.Address)
+
+	profile, err := s.ownProfile(ctx, account, email)
+	if err != nil || profile != nil {
+		return profile, err
+	}
+
+	delegations, err := s.delegationRepo.ListByDelegate(ctx, account.ID)
+	if err != nil {
+		return nil, errors.InternalError(err)
+	}
+	for _, delegation := range delegations {
+		mailbox, err := s.accountRepo.GetByID(ctx, delegation.AccountID)
+		if err != nil {
+			return nil, errors.InternalError(err)
+		}
+		if mailbox == nil || !mailbox.IsActive {
+			continue
+		}
+		profile, err := s.ownProfile(ctx, mailbox, email)
+		if err != nil {
+			return nil, err
+		}
+		if profile == nil {
+			continue
+		}
+		if delegation.Permission == domain.DelegationSendOnBehalf {
+			sender, err := s.defaultProfile(ctx, account)
+			if err != nil {
+				return nil, err
+			}
+			profile.Sender = sender.From
+		}
+		return profile, nil
+	}
+	return nil, errors.SenderNotAllowed(email)
+}
+
+// defaultProfile returns the approved default identity of an account, or
+// its own address
+func (s *IdentityService) defaultProfile(ctx context.Context, account *domain.EmailAccount) (*SenderProfile, error) {
+	identities, err := s.identityRepo.List(ctx, repository.IdentityFilter{AccountID: account.ID})
+	if err != nil {
+		return nil, errors.InternalError(err)
+	}
+	for _, identity := range identities {
+		if identity.IsDefault && identity.IsApproved() {
+			return identityProfile(account, identity), nil
+		}
+	}
+	return s.ownProfile(ctx, account, strings.ToLower(account.Email))
+}
+
+// ownProfile returns the profile of an address of the account: its own
+// address or an approved identity. It returns nil for other addresses.
+func (s *IdentityService) ownProfile(ctx context.Context, account *domain.EmailAccount, email string) (*SenderProfile, error) {
+	identity, err := s.identityRepo.GetByEmail(ctx, account.ID, email)
+	if err != nil {
+		return nil, errors.InternalError(err)
+	}
+	if identity != nil && identity.IsApproved() {
+		return identityProfile(account, identity), nil
+	}
+	if strings.EqualFold(email, account.Email) {
+		return accountSender(account), nil
+	}
+	return nil, nil
+}
+
+// mailboxProfiles returns the profiles of every address of an account: its
+// own address and its approved identities
+func (s *IdentityService) mailboxProfiles(ctx context.Context, account *domain.EmailAccount) ([]*SenderProfile, error) {
+	identities, err := s.identityRepo.List(ctx, repository.IdentityFilter{AccountID: account.ID})
+	if err != nil {
+		return nil, errors.InternalError(err)
+	}
+	profiles := []*SenderProfile{}
+	hasOwn := false
+	for _, identity := range identities {
+		if !identity.IsApproved() {
+			continue
+		}
+		hasOwn = hasOwn || strings.EqualFold(identity.Email, account.Email)
+		profiles = append(profiles, identityProfile(account, identity))
+	}
+	if !hasOwn {
+		profiles = append([]*SenderProfile{accountSender(account)}, profiles...)
+	}
+	return profiles, nil
+}
+
+// identityProfile returns the profile of an identity. The account's own
+// address falls back to the account display name.
+func identityProfile(account *domain.EmailAccount, identity *domain.Identity) *SenderProfile {
+	profile := &SenderProfile{
+		From:          identity.Address(),
+		ReplyTo:       identity.ReplyTo,
+		SignatureText: identity.SignatureText,
+		SignatureHTML: identity.SignatureHTML,
+	}
+	if identity.Name == "" && strings.EqualFold(identity.Email, account.Email) {
+		profile.From = accountSender(account).From
+	}
+	return profile
+}
+
+// hostedDomain returns the domain of an address, which must be hosted here
+func (s *IdentityService) hostedDomain(ctx context.Context, email string) (*domain.Domain, error) {
+	name := email[strings.LastIndex(email, "@")+1:]
+	d, err := s.domainRepo.GetByName(ctx, name)
+	if err != nil {
+		return nil, errors.InternalError(err)
+	}
+	if d == nil {
+		return nil, errors.NewError(errors.ErrCodeInvalidEmailAddress, "Address is not in a hosted domain").
+			WithDetail("email", email)
+	}
+	return d, nil
+}
+
+// isDomainAdmin reports whether a user administers a domain: platform
+// administrators, the domain owner, and its owner and admin members
+func isDomainAdmin(ctx context.Context, userRepo repository.UserRepository, memberRepo repository.DomainMemberRepository, userID string, d *domain.Domain) (bool, error) {
+	if d.OwnerID == userID {
+		return true, nil
+	}
+	user, err := userRepo.GetByID(ctx, userID)
+	if err != nil {
+		return false, errors.InternalError(err)
+	}
+	if user == nil || !user.IsActive {
+		return false, nil
+	}
+	if user.Role == domain.UserRoleSuperAdmin || user.Role == domain.UserRoleAdmin {
+		return true, nil
+	}
+	member, err := memberRepo.GetByUserAndDomain(ctx, userID, d.ID)
+	if err != nil {
+		return false, errors.InternalError(err)
+	}
+	if member == nil {
+		return false, nil
+	}
+	return member.Role == domain.DomainRoleOwner || member.Role == domain.DomainRoleAdmin ||
+		user.Role == domain.UserRoleDomainAdmin, nil
+}
+
+func (s *IdentityService) requireDomainAdmin(ctx context.Context, userID, domainID string) error {
+	d, err := s.domainRepo.GetByID(ctx, domainID)
+	if err != nil {
+		return errors.InternalError(err)
+	}
+	if d == nil {
+		return errors.DomainNotFound(domainID)
+	}
+	admin, err := isDomainAdmin(ctx, s.userRepo, s.memberRepo, userID, d)
+	if err != nil {
+		return err
+	}
+	if !admin {
+		return errors.NewError(errors.ErrCodeForbidden, "Only domain administrators can review identities").
+			WithDetail("domain_id", domainID)
+	}
+	return nil
+}
+
+func (s *IdentityService) ownedAccount(ctx context.Context, userID, accountID string) (*domain.EmailAccount, error) {
+	account, err := s.accountRepo.GetByID(ctx, accountID)
+	if err != nil {
+		return nil, errors.InternalError(err)
+	}
+	if account == nil || account.UserID != userID {
+		// Do not reveal accounts owned by other users
+		return nil, errors.EmailAccountNotFound(accountID)
+	}
+	return account, nil
+}
+
+// managedAccount returns an account the user owns, or one in a domain the
+// user administers, such as a shared mailbox
+func (s *IdentityService) managedAccount(ctx context.Context, userID, accountID string) (*domain.EmailAccount, error) {
+	account, err := s.accountRepo.GetByID(ctx, accountID)
+	if err != nil {
+		return nil, errors.InternalError(err)
+	}
+	if account != nil && account.UserID == userID {
+		return account, nil
+	}
+	if account != nil {
+		d, err := s.domainRepo.GetByID(ctx, account.DomainID)
+		if err != nil {
+			return nil, errors.InternalError(err)
+		}
+		if d != nil {
+			admin, err := isDomainAdmin(ctx, s.userRepo, s.memberRepo, userID, d)
+			if err != nil {
+				return nil, err
+			}
+			if admin {
+				return account, nil
+			}
+		}
+	}
+	// Do not reveal accounts of other users
+	return nil, errors.EmailAccountNotFound(accountID)
+}
+
+func (s *IdentityService) ownedIdentity(ctx context.Context, userID, id string) (*domain.Identity, *domain.EmailAccount, error) {
+	identity, err := s.identityRepo.GetByID(ctx, id)
+	if err != nil {
+		return nil, nil, errors.InternalError(err)
+	}
+	if identity == nil {
+		return nil, nil, errors.IdentityNotFound(id)
+	}
+	account, err := s.accountRepo.GetByID(ctx, identity.AccountID)
+	if err != nil {
+		return nil, nil, errors.InternalError(err)
+	}
+	if account == nil || account.UserID != userID {
+		// Do not reveal identities of other users
+		return nil, nil, errors.IdentityNotFound(id)
+	}
+	return identity, account, nil
+}
+
+// normalizeAddress returns the lower-case address of an identity
+func normalizeAddress(value string) (string, error) {
+	address, err := mail.ParseAddress(value)
+	if err != nil || !strings.Contains(address.Address, "@") {
+		return "", errors.NewError(errors.ErrCodeInvalidEmailAddress, "Invalid email address").WithDetail("email", value)
+	}
+	return strings.ToLower(address.Address), nil
+}
+
+// formatReplyTo validates a reply-to address, which may be empty
+func formatReplyTo(value string) (string, error) {
+	if strings.TrimSpace(value) == "" {
+		return "", nil
+	}
+	address, err := mail.ParseAddress(value)
+	if err != nil {
+		return "", errors.NewError(errors.ErrCodeInvalidEmailAddress, "Invalid reply-to address").WithDetail("email", value)
+	}
+	return address.String(), nil
+}
@@ -0,0 +1,556 @@
+package service
+
+import (
+	"context"
+	"hash/fnv"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/skygenesisenterprise/aether-mailer/package/golang/domain"
+	"github.com/skygenesisenterprise/aether-mailer/package/golang/errors"
+	"github.com/skygenesisenterprise/aether-mailer/package/golang/repository"
+)
+
+// IPPoolService manages outbound IP pools and picks the source address and
+// EHLO name for each delivery. Daily volume per address is counted in the
+// shared rate counters so warm-up caps hold across instances.
+type IPPoolService struct {
+	poolRepo       repository.IPPoolRepository
+	assignmentRepo repository.PoolAssignmentRepository
+	counters       repository.RateCounterStore
+	eventPub       domain.EventPublisher
+	config         *IPPoolConfig
+}
+
+// IPPoolConfig defines IP pool service configuration
+type IPPoolConfig struct {
+	WarmupSchedule []domain.WarmupStep
+}
+
+// CreateIPPoolRequest represents a request to create an IP pool
+type CreateIPPoolRequest struct {
+	Name        string
+	Description string
+	IsDefault   bool
+}
+
+// UpdateIPPoolRequest represents a request to update an IP pool
+type UpdateIPPoolRequest struct {
+	Name        *string
+	Description *string
+	IsDefault   *bool
+}
+
+// AddPoolAddressRequest represents a request to add a source address to a pool
+type AddPoolAddressRequest struct {
+	IP       string
+	HeloName string
+	Warmup   bool // start the warm-up schedule today
+}
+
+// SourceRequest identifies the sender and destination of a delivery
+type SourceRequest struct {
+	TenantID    string
+	DomainID    string
+	Stream      string
+	Destination string
+}
+
+// AddressUsage reports today's volume of a pool address against its cap
+type AddressUsage struct {
+	IP         string
+	HeloName   string
+	IsActive   bool
+	WarmupDay  int
+	DailyLimit int64 // 0 means unlimited
+	SentToday  int64
+}
+
+// DefaultWarmupSchedule returns a three-week ramp from 50 to 100,000
+// messages per day
+func DefaultWarmupSchedule() []domain.WarmupStep {
+	return []domain.WarmupStep{
+		{Day: 1, DailyLimit: 50},
+		{Day: 2, DailyLimit: 100},
+		{Day: 3, DailyLimit: 300},
+		{Day: 4, DailyLimit: 600},
+		{Day: 5, DailyLimit: 1000},
+		{Day: 6, DailyLimit: 2000},
+		{Day: 7, DailyLimit: 4000},
+		{Day: 8, DailyLimit: 8000},
+		{Day: 10, DailyLimit: 15000},
+		{Day: 12, DailyLimit: 30000},
+		{Day: 14, DailyLimit: 60000},
+		{Day: 17, DailyLimit: 100000},
+		{Day: 21, DailyLimit: 0},
+	}
+}
+
+const warmupWindow = 24 * time.Hour
+
+// NewIPPoolService creates a new IP pool service
+func NewIPPoolService(
+	poolRepo repository.IPPoolRepository,
+	assignmentRepo repository.PoolAssignmentRepository,
+	counters repository.RateCounterStore,
+	eventPub domain.EventPublisher,
+	config *IPPoolConfig,
+) *IPPoolService {
+	return &IPPoolService{
+		poolRepo:       poolRepo,
+		assignmentRepo: assignmentRepo,
+		counters:       counters,
+		eventPub:       eventPub,
+		config:         config,
+	}
+}
+
+// CreatePool creates an empty IP pool
+func (s *IPPoolService) CreatePool(ctx context.Context, req CreateIPPoolRequest) (*domain.IPPool, error) {
+	name := strings.TrimSpace(req.Name)
+	if name == "" {
+		return nil, errors.NewError(errors.ErrCodeValidationError, "Pool name is required")
+	}
+	existing, err := s.poolRepo.GetByName(ctx, name)
+	if err != nil {
+		return nil, errors.InternalError(err)
+	}
+	if existing != nil {
+		return nil, errors.NewError(errors.ErrCodeValidationError, "A pool with this name already exists").WithDetail("name", name)
+	}
+
+	now := time.Now()
+	pool := &domain.IPPool{
+		ID:          uuid.New().String(),
+		Name:        name,
+		Description: req.Description,
+		Addresses:   []domain.PoolAddress{},
+		IsDefault:   req.IsDefault,
+		CreatedAt:   now,
+		UpdatedAt:   now,
+	}
+	if pool.IsDefault {
+		if err := s.clearDefault(ctx, pool.ID); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := s.poolRepo.Create(ctx, pool); err != nil {
+		return nil, errors.InternalError(err)
+	}
+
+	return pool, nil
+}
+
+// GetPool retrieves an IP pool by ID
+func (s *IPPoolService) GetPool(ctx context.Context, id string) (*domain.IPPool, error) {
+	pool, err := s.poolRepo.GetByID(ctx, id)
+	if err != nil {
+		return nil, errors.InternalError(err)
+	}
+	if pool == nil {
+		return nil, errors.IPPoolNotFound(id)
+	}
+	return pool, nil
+}
+
+// ListPools lists IP pools
+func (s *IPPoolService) ListPools(ctx context.Context) ([]*domain.IPPool, error) {
+	pools, err := s.poolRepo.List(ctx)
+	if err != nil {
+		return nil, errors.InternalError(err)
+	}
+	return pools, nil
+}
+
+// UpdatePool updates the name, description or default flag of a pool
+func (s *IPPoolService) UpdatePool(ctx context.Context, id string, req UpdateIPPoolRequest) (*domain.IPPool, error) {
+	pool, err := s.GetPool(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	if req.Name != nil {
+		name := strings.TrimSpace(*req.Name)
+		if name == "" {
+			return nil, errors.NewError(errors.ErrCodeValidationError, "Pool name is required")
+		}
+		existing, err := s.poolRepo.GetByName(ctx, name)
+		if err != nil {
+			return nil, errors.InternalError(err)
+		}
+		if existing != nil && existing.ID != pool.ID {
+			return nil, errors.NewError(errors.ErrCodeValidationError, "A pool with this name already exists").WithDetail("name", name)
+		}
+		pool.Name = name
+	}
+	if req.Description != nil {
+		pool.Description = *req.Description
+	}
+	if req.IsDefault != nil {
+		if *req.IsDefault && !pool.IsDefault {
+			if err := s.clearDefault(ctx, pool.ID); err != nil {
+				return nil, err
+			}
+		}
+		pool.IsDefault = *req.IsDefault
+	}
+	pool.UpdatedAt = time.Now()
+
+	if err := s.poolRepo.Update(ctx, pool); err != nil {
+		return nil, errors.InternalError(err)
+	}
+
+	return pool, nil
+}
+
+// DeletePool deletes a pool and its assignments. Senders assigned to it
+// fall back to the default pool.
+func (s *IPPoolService) DeletePool(ctx context.Context, id string) error {
+	if _, err := s.GetPool(ctx, id); err != nil {
+		return err
+	}
+	if err := s.assignmentRepo.DeleteByPool(ctx, id); err != nil {
+		return errors.InternalError(err)
+	}
+	if err := s.poolRepo.Delete(ctx, id); err != nil {
+		return errors.InternalError(err)
+	}
+	return nil
+}
+
+// AddAddress adds a source address to a pool. An address can belong to
+// one pool only.
+func (s *IPPoolService) AddAddress(ctx context.Context, poolID string, req AddPoolAddressRequest) (*domain.IPPool, error) {
+	ip := net.ParseIP(strings.TrimSpace(req.IP))
+	if ip == nil {
+		return nil, errors.NewError(errors.ErrCodeValidationError, "Invalid IP address").WithDetail("ip", req.IP)
+	}
+	heloName := strings.ToLower(strings.TrimSuffix(strings.TrimSpace(req.HeloName), "."))
+	if !isHostname(heloName) {
+		return nil, errors.NewError(errors.ErrCodeValidationError, "Invalid HELO name").WithDetail("helo_name", req.HeloName)
+	}
+
+	pool, err := s.GetPool(ctx, poolID)
+	if err != nil {
+		return nil, err
+	}
+
+	pools, err := s.poolRepo.List(ctx)
+	if err != nil {
+		return nil, errors.InternalError(err)
+	}
+	for _, other := range pools {
+		for _, addr := range other.Addresses {
+			if net.ParseIP(addr.IP).Equal(ip) {
+				return nil, errors.NewError(errors.ErrCodeValidationError, "Address already belongs to a pool").
+					WithDetail("ip", ip.String()).
+					WithDetail("pool", other.Name)
+			}
+		}
+	}
+
+	address := domain.PoolAddress{IP: ip.String(), HeloName: heloName, IsActive: true}
+	if req.Warmup {
+		now := time.Now()
+		address.WarmupStartedAt = &now
+	}
+	pool.Addresses = append(pool.Addresses, address)
+	pool.UpdatedAt = time.Now()
+
+	if err := s.poolRepo.Update(ctx, pool); err != nil {
+		return nil, errors.InternalError(err)
+	}
+
+	return pool, nil
+}
+
+// SetAddressActive enables or disables a pool address without removing it
+func (s *IPPoolService) SetAddressActive(ctx context.Context, poolID, ip string, active bool) (*domain.IPPool, error) {
+	return s.updateAddress(ctx, poolID, ip, func(addr *domain.PoolAddress) {
+		addr.IsActive = active
+	})
+}
+
+// RestartWarmup restarts the warm-up schedule of an address from day one
+func (s *IPPoolService) RestartWarmup(ctx context.Context, poolID, ip string) (*domain.IPPool, error) {
+	return s.updateAddress(ctx, poolID, ip, func(addr *domain.PoolAddress) {
+		now := time.Now()
+		addr.WarmupStartedAt = &now
+	})
+}
+
+// RemoveAddress removes a source address from a pool
+func (s *IPPoolService) RemoveAddress(ctx context.Context, poolID, ip string) (*domain.IPPool, error) {
+	pool, err := s.GetPool(ctx, poolID)
+	if err != nil {
+		return nil, err
+	}
+
+	index := findPoolAddress(pool, ip)
+	if index < 0 {
+		return nil, errors.NewError(errors.ErrCodeValidationError, "Address is not in this pool").WithDetail("ip", ip)
+	}
+	pool.Addresses = append(pool.Addresses[:index], pool.Addresses[index+1:]...)
+	pool.UpdatedAt = time.Now()
+
+	if err := s.poolRepo.Update(ctx, pool); err != nil {
+		return nil, errors.InternalError(err)
+	}
+
+	return pool, nil
+}
+
+// Assign binds a tenant, sender domain or message stream to a pool,
+// replacing any previous assignment
+func (s *IPPoolService) Assign(ctx context.Context, scope domain.PoolScope, key, poolID string) (*domain.PoolAssignment, error) {
+	if err := validatePoolScope(scope); err != nil {
+		return nil, err
+	}
+	if strings.TrimSpace(key) == "" {
+		return nil, errors.NewError(errors.ErrCodeValidationError, "Assignment key is required")
+	}
+	if _, err := s.GetPool(ctx, poolID); err != nil {
+		return nil, err
+	}
+
+	assignment := &domain.PoolAssignment{
+		Scope:     scope,
+		Key:       poolAssignmentKey(scope, key),
+		PoolID:    poolID,
+		CreatedAt: time.Now(),
+	}
+	if err := s.assignmentRepo.Upsert(ctx, assignment); err != nil {
+		return nil, errors.InternalError(err)
+	}
+
+	return assignment, nil
+}
+
+// Unassign removes the pool assignment of a tenant, sender domain or stream
+func (s *IPPoolService) Unassign(ctx context.Context, scope domain.PoolScope, key string) error {
+	if err := validatePoolScope(scope); err != nil {
+		return err
+	}
+	if err := s.assignmentRepo.Delete(ctx, scope, poolAssignmentKey(scope, key)); err != nil {
+		return errors.InternalError(err)
+	}
+	return nil
+}
+
+// ListAssignments lists the assignments of a pool
+func (s *IPPoolService) ListAssignments(ctx context.Context, poolID string) ([]*domain.PoolAssignment, error) {
+	if _, err := s.GetPool(ctx, poolID); err != nil {
+		return nil, err
+	}
+	assignments, err := s.assignmentRepo.ListByPool(ctx, poolID)
+	if err != nil {
+		return nil, errors.InternalError(err)
+	}
+	return assignments, nil
+}
+
+// GetUsage reports today's volume and warm-up cap of every address in a pool
+func (s *IPPoolService) GetUsage(ctx context.Context, poolID string) ([]AddressUsage, error) {
+	pool, err := s.GetPool(ctx, poolID)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	usage := make([]AddressUsage, 0, len(pool.Addresses))
+	for i := range pool.Addresses {
+		addr := &pool.Addresses[i]
+		sent, err := s.counters.Sum(ctx, warmupCounterKey(addr.IP), now, warmupWindow)
+		if err != nil {
+			return nil, errors.InternalError(err)
+		}
+		usage = append(usage, AddressUsage{
+			IP:         addr.IP,
+			HeloName:   addr.HeloName,
+			IsActive:   addr.IsActive,
+			WarmupDay:  addr.WarmupDay(now),
+			DailyLimit: addr.DailyLimit(s.config.WarmupSchedule, now),
+			SentToday:  sent,
+		})
+	}
+	return usage, nil
+}
+
+// SelectSource picks the source address for a delivery from the pool
+// assigned to the message stream, sender domain or tenant, in that order,
+// or from the default pool. The same destination keeps the same address
+// while it has capacity, which helps connection reuse and keeps reputation
+// consistent. It returns nil when no pool applies.
+func (s *IPPoolService) SelectSource(ctx context.Context, req SourceRequest) (*domain.SourceAddress, error) {
+	pool, err := s.poolFor(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	if pool == nil {
+		return nil, nil
+	}
+
+	candidates := make([]domain.PoolAddress, 0, len(pool.Addresses))
+	for _, addr := range pool.Addresses {
+		if addr.IsActive {
+			candidates = append(candidates, addr)
+		}
+	}
+	if len(candidates) == 0 {
+		return nil, errors.IPPoolExhausted(pool.Name)
+	}
+
+	// Rotate the candidates by a hash of the destination
+	hash := fnv.New32a()
+	hash.Write([]byte(req.Destination))
+	offset := int(hash.Sum32() % uint32(len(candidates)))
+
+	now := time.Now()
+	for i := range candidates {
+		addr := candidates[(offset+i)%len(candidates)]
+		key := warmupCounterKey(addr.IP)
+		limit := addr.DailyLimit(s.config.WarmupSchedule, now)
+
+		sent, err := s.counters.Add(ctx, key, 1, now, warmupWindow)
+		if err != nil {
+			return nil, errors.InternalError(err)
+		}
+		if limit > 0 && sent > limit {
+			if _, err := s.counters.Add(ctx, key, -1, now, warmupWindow); err != nil {
+				// Log error but keep looking for an address
+			}
+			continue
+		}
+
+		return &domain.SourceAddress{PoolID: pool.ID, IP: addr.IP, HeloName: addr.HeloName}, nil
+	}
+
+	return nil, errors.IPPoolExhausted(pool.Name)
+}
+
+// Helper functions
+
+func (s *IPPoolService) poolFor(ctx context.Context, req SourceRequest) (*domain.IPPool, error) {
+	lookups := []struct {
+		scope domain.PoolScope
+		key   string
+	}{
+		{domain.PoolScopeStream, req.Stream},
+		{domain.PoolScopeDomain, req.DomainID},
+		{domain.PoolScopeTenant, req.TenantID},
+	}
+	for _, lookup := range lookups {
+		if lookup.key == "" {
+			continue
+		}
+		assignment, err := s.assignmentRepo.Get(ctx, lookup.scope, poolAssignmentKey(lookup.scope, lookup.key))
+		if err != nil {
+			return nil, errors.InternalError(err)
+		}
+		if assignment == nil {
+			continue
+		}
+		pool, err := s.poolRepo.GetByID(ctx, assignment.PoolID)
+		if err != nil {
+			return nil, errors.InternalError(err)
+		}
+		if pool != nil {
+			return pool, nil
+		}
+	}
+
+	pool, err := s.poolRepo.GetDefault(ctx)
+	if err != nil {
+		return nil, errors.InternalError(err)
+	}
+	return pool, nil
+}
+
+func (s *IPPoolService) updateAddress(ctx context.Context, poolID, ip string, update func(addr *domain.PoolAddress)) (*domain.IPPool, error) {
+	pool, err := s.GetPool(ctx, poolID)
+	if err != nil {
+		return nil, err
+	}
+
+	index := findPoolAddress(pool, ip)
+	if index < 0 {
+		return nil, errors.NewError(errors.ErrCodeValidationError, "Address is not in this pool").WithDetail("ip", ip)
+	}
+	update(&pool.Addresses[index])
+	pool.UpdatedAt = time.Now()
+
+	if err := s.poolRepo.Update(ctx, pool); err != nil {
+		return nil, errors.InternalError(err)
+	}
+
+	return pool, nil
+}
+
+func (s *IPPoolService) clearDefault(ctx context.Context, keepID string) error {
+	pools, err := s.poolRepo.List(ctx)
+	if err != nil {
+		return errors.InternalError(err)
+	}
+	for _, pool := range pools {
+		if pool.IsDefault && pool.ID != keepID {
+			pool.IsDefault = false
+			pool.UpdatedAt = time.Now()
+			if err := s.poolRepo.Update(ctx, pool); err != nil {
+				return errors.InternalError(err)
+			}
+		}
+	}
+	return nil
+}
+
+func findPoolAddress(pool *domain.IPPool, ip string) int {
+	parsed := net.ParseIP(ip)
+	for i, addr := range pool.Addresses {
+		if parsed != nil && net.ParseIP(addr.IP).Equal(parsed) {
+			return i
+		}
+	}
+	return -1
+}
+
+func validatePoolScope(scope domain.PoolScope) error {
+	switch scope {
+	case domain.PoolScopeTenant, domain.PoolScopeDomain, domain.PoolScopeStream:
+		return nil
+	}
+	return errors.NewError(errors.ErrCodeValidationError, "Invalid pool scope").WithDetail("scope", string(scope))
+}
+
+// poolAssignmentKey normalises stream names, which are free-form labels
+func poolAssignmentKey(scope domain.PoolScope, key string) string {
+	key = strings.TrimSpace(key)
+	if scope == domain.PoolScopeStream {
+		return strings.ToLower(key)
+	}
+	return key
+}
+
+func warmupCounterKey(ip string) string {
+	return "ipwarm:" + ip
+}
+
+// isHostname reports whether name is a fully qualified host name
+func isHostname(name string) bool {
+	if len(name) == 0 || len(name) > 253 || !strings.Contains(name, ".") {
+		return false
+	}
+	for _, label := range strings.Split(name, ".") {
+		if len(label) == 0 || len(label) > 63 || label[0] == '-' || label[len(label)-1] == '-' {
+			return false
+		}
+		for _, r := range label {
+			if !(r >= 'a' && r <= 'z' || r >= '0' && r <= '9' || r == '-') {
+				return false
+			}
+		}
+	}
+	return true
+}
@@ -0,0 +1,67 @@
+package service
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"fmt"
+	"io"
+)
+
+// KeyEncrypter seals private key material stored by the SDK. The
+// additional data binds a sealed value to its owner: Decrypt fails unless it
+// is given the additional data the value was encrypted with.
+type KeyEncrypter interface {
+	Encrypt(plaintext, additionalData []byte) ([]byte, error)
+	Decrypt(ciphertext, additionalData []byte) ([]byte, error)
+}
+
+// aesGCMVersion prefixes sealed values so the format can evolve
+const aesGCMVersion byte = 1
+
+// AESGCMEncrypter seals values with AES-256-GCM. The layout is a version
+// byte, the nonce and the ciphertext with its tag.
+type AESGCMEncrypter struct {
+	aead cipher.AEAD
+}
+
+// NewAESGCMEncrypter creates an encrypter from a secret such as
+// SecurityConfig.EncryptionKey. The AES key is the SHA-256 of the secret.
+func NewAESGCMEncrypter(secret string) (*AESGCMEncrypter, error) {
+	if secret == "" {
+		return nil, fmt.Errorf("encryption key is required")
+	}
+	key := sha256.Sum256([]byte(secret))
+	block, err := aes.NewCipher(key[:])
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return &AESGCMEncrypter{aead: aead}, nil
+}
+
+// Encrypt seals plaintext under a random nonce, authenticating additionalData
+func (e *AESGCMEncrypter) Encrypt(plaintext, additionalData []byte) ([]byte, error) {
+	nonce := make([]byte, e.aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+	sealed := make([]byte, 0, 1+len(nonce)+len(plaintext)+e.aead.Overhead())
+	sealed = append(sealed, aesGCMVersion)
+	sealed = append(sealed, nonce...)
+	return e.aead.Seal(sealed, nonce, plaintext, additionalData), nil
+}
+
+// Decrypt opens a value sealed by Encrypt with the same additionalData
+func (e *AESGCMEncrypter) Decrypt(ciphertext, additionalData []byte) ([]byte, error) {
+	nonceSize := e.aead.NonceSize()
+	if len(ciphertext) < 1+nonceSize+e.aead.Overhead() || ciphertext[0] != aesGCMVersion {
+		return nil, fmt.Errorf("invalid sealed value")
+	}
+	nonce := ciphertext[1 : 1+nonceSize]
+	return e.aead.Open(nil, nonce, ciphertext[1+nonceSize:], additionalData)
+}
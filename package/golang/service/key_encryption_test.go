@@ -0,0 +1,44 @@
+package service
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestAESGCMEncrypterBindsAdditionalData(t *testing.T) {
+	encrypter, err := NewAESGCMEncrypter("secret")
+	if err != nil {
+		t.Fatalf("NewAESGCMEncrypter() error = %v", err)
+	}
+	plaintext := []byte("private key")
+	sealed, err := encrypter.Encrypt(plaintext, dkimKeyAAD("example.com", "s1"))
+	if err != nil {
+		t.Fatalf("Encrypt() error = %v", err)
+	}
+
+	opened, err := encrypter.Decrypt(sealed, dkimKeyAAD("example.com", "s1"))
+	if err != nil || !bytes.Equal(opened, plaintext) {
+		t.Fatalf("Decrypt() = %q, %v, want %q", opened, err, plaintext)
+	}
+
+	tests := []struct {
+		name       string
+		domainName string
+		selector   string
+	}{
+		{"other domain", "example.net", "s1"},
+		{"other selector", "example.com", "s2"},
+		{"shifted separator", "example.com|s1", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := encrypter.Decrypt(sealed, dkimKeyAAD(tt.domainName, tt.selector)); err == nil {
+				t.Error("Decrypt() opened a key sealed for another record")
+			}
+		})
+	}
+
+	if _, err := encrypter.Decrypt(sealed, nil); err == nil {
+		t.Error("Decrypt() without additional data opened the key")
+	}
+}
@@ -0,0 +1,125 @@
+package service
+
+import (
+	"context"
+
+	"github.com/skygenesisenterprise/aether-mailer/package/golang/errors"
+	"github.com/skygenesisenterprise/aether-mailer/package/golang/repository"
+)
+
+// MailboxMigrator copies mailboxes between two message storage backends,
+// such as from Postgres to Maildir when an operator switches backends.
+// Messages keep their IDs, so an interrupted migration can simply be run
+// again: messages already in the target are skipped.
+type MailboxMigrator struct {
+	source repository.MessageRepository
+	target repository.MessageRepository
+	config *MailboxMigrationConfig
+}
+
+// MailboxMigrationConfig defines mailbox migration settings
+type MailboxMigrationConfig struct {
+	BatchSize    int  // messages read from the source per query, 200 when zero
+	DeleteSource bool // delete the source messages once an account is copied without failures
+}
+
+// MailboxMigrationReport summarises the migration of one account
+type MailboxMigrationReport struct {
+	AccountID string
+	Copied    int
+	Skipped   int // already in the target
+	Failed    int
+	Failures  map[string]string // message ID to error
+	Deleted   int               // removed from the source
+}
+
+// NewMailboxMigrator creates a new mailbox migrator
+func NewMailboxMigrator(
+	source repository.MessageRepository,
+	target repository.MessageRepository,
+	config *MailboxMigrationConfig,
+) *MailboxMigrator {
+	return &MailboxMigrator{
+		source: source,
+		target: target,
+		config: config,
+	}
+}
+
+// MigrateAccount copies every message of an account, including deleted
+// ones, to the target backend. A message that cannot be copied is recorded
+// in the report and does not stop the migration.
+func (m *MailboxMigrator) MigrateAccount(ctx context.Context, accountID string) (*MailboxMigrationReport, error) {
+	batch := m.config.BatchSize
+	if batch <= 0 {
+		batch = 200
+	}
+	report := &MailboxMigrationReport{
+		AccountID: accountID,
+		Failures:  make(map[string]string),
+	}
+
+	migrated := []string{}
+	for offset := 0; ; offset += batch {
+		if err := ctx.Err(); err != nil {
+			return report, err
+		}
+		messages, err := m.source.ListByAccount(ctx, accountID, repository.MessageFilter{
+			Limit:  batch,
+			Offset: offset,
+		})
+		if err != nil {
+			return report, errors.InternalError(err)
+		}
+
+		for _, message := range messages {
+			existing, err := m.target.GetByID(ctx, message.ID)
+			if err != nil {
+				return report, errors.InternalError(err)
+			}
+			if existing != nil {
+				report.Skipped++
+				migrated = append(migrated, message.ID)
+				continue
+			}
+			if err := m.target.Create(ctx, message); err != nil {
+				report.Failed++
+				report.Failures[message.ID] = err.Error()
+				continue
+			}
+			report.Copied++
+			migrated = append(migrated, message.ID)
+		}
+
+		if len(messages) < batch {
+			break
+		}
+	}
+
+	// Deleting while paging would shift the offsets, so the source is only
+	// cleaned up once the whole account has been read
+	if m.config.DeleteSource && report.Failed == 0 {
+		for _, id := range migrated {
+			if err := m.source.Delete(ctx, id); err != nil {
+				return report, errors.InternalError(err)
+			}
+			report.Deleted++
+		}
+	}
+
+	return report, nil
+}
+
+// MigrateAccounts migrates several accounts in turn and stops at the first
+// account that cannot be read
+func (m *MailboxMigrator) MigrateAccounts(ctx context.Context, accountIDs []string) ([]*MailboxMigrationReport, error) {
+	reports := []*MailboxMigrationReport{}
+	for _, accountID := range accountIDs {
+		report, err := m.MigrateAccount(ctx, accountID)
+		reports = append(reports, report)
+		if err != nil {
+			return reports, err
+		}
+	}
+	return reports, nil
+}
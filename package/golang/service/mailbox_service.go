@@ -0,0 +1,860 @@
+package service
+
+import (
+	"context"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/google/uuid"
+	"github.com/skygenesisenterprise/aether-mailer/package/golang/domain"
+	"github.com/skygenesisenterprise/aether-mailer/package/golang/errors"
+	"github.com/skygenesisenterprise/aether-mailer/package/golang/repository"
+)
+
+// Limits of the mailbox operations
+const (
+	maxBulkMessages   = 1000
+	maxMessageLabels  = 50
+	maxLabelLength    = 100
+	maxFolderNameSize = 255
+)
+
+// MailboxService manages the folders and messages of the email accounts a
+// user owns, and of the accounts shared with the user, such as shared
+// mailboxes, within the rights the user holds on their folders. Accounts,
+// folders and messages the user holds no right on are reported as not
+// found.
+type MailboxService struct {
+	accountRepo repository.EmailAccountRepository
+	folderRepo  repository.FolderRepository
+	messageRepo repository.MessageRepository
+	spamTrainer SpamTrainer
+	blobs       BlobStore
+	transactor  repository.Transactor
+	threads     ThreadRefresher
+	access      FolderAccess
+}
+
+// ThreadRefresher keeps the aggregates of threads up to date after their
+// messages change, such as ThreadService
+type ThreadRefresher interface {
+	RefreshThreads(ctx context.Context, threadIDs ...string) error
+}
+
+// FolderAccess decides the rights of users on the folders of accounts they
+// do not own, such as ACLService
+type FolderAccess interface {
+	FolderRights(ctx context.Context, userID string, account *domain.EmailAccount, folders []*domain.Folder) (map[string]domain.Rights, error)
+	InheritACL(ctx context.Context, userID string, parent, folder *domain.Folder) error
+}
+
+// FolderRenamer is implemented by message repositories that file messages
+// by folder path, such as the maildir repository. It is called before the
+// renamed folders are saved.
+type FolderRenamer interface {
+	RenameFolder(ctx context.Context, accountID, oldPath, newPath string) error
+}
+
+// NewMailboxService creates a new mailbox service. spamTrainer, blobs,
+// transactor, threads and access are optional; blobs releases the
+// attachment content of permanently deleted messages. Without access, users
+// only reach the accounts they own.
+func NewMailboxService(
+	accountRepo repository.EmailAccountRepository,
+	folderRepo repository.FolderRepository,
+	messageRepo repository.MessageRepository,
+	spamTrainer SpamTrainer,
+	blobs BlobStore,
+	transactor repository.Transactor,
+	threads ThreadRefresher,
+	access FolderAccess,
+) *MailboxService {
+	return &MailboxService{
+		accountRepo: accountRepo,
+		folderRepo:  folderRepo,
+		messageRepo: messageRepo,
+		spamTrainer: spamTrainer,
+		blobs:       blobs,
+		transactor:  transactor,
+		threads:     threads,
+		access:      access,
+	}
+}
+
+// FolderStatus is a folder with its message counts and the rights of the
+// user on it
+type FolderStatus struct {
+	Folder         *domain.Folder
+	TotalMessages  int
+	UnreadMessages int
+	HasChildren    bool
+	Rights         domain.Rights
+}
+
+// CreateFolderRequest represents the request to create a folder
+type CreateFolderRequest struct {
+	AccountID string
+	Name      string
+	ParentID  string
+	Type      domain.FolderType // CUSTOM when empty
+	Subscribe bool
+}
+
+// MessageAction is a change applied to messages in bulk
+type MessageAction string
+
+const (
+	MessageActionMarkRead   MessageAction = "markRead"
+	MessageActionMarkUnread MessageAction = "markUnread"
+	MessageActionStar       MessageAction = "markStarred"
+	MessageActionUnstar     MessageAction = "unstar"
+	MessageActionFlag       MessageAction = "flag"
+	MessageActionUnflag     MessageAction = "unflag"
+	MessageActionMove       MessageAction = "move"
+	MessageActionDelete     MessageAction = "delete"
+	MessageActionArchive    MessageAction = "archive"
+)
+
+// MessageActionResult lists the messages a bulk operation changed and the
+// IDs that match no message of the account
+type MessageActionResult struct {
+	Updated  []string
+	NotFound []string
+}
+
+// ListFolders lists the folders of an account the user may look up, with
+// their message counts when the user may read them
+func (s *MailboxService) ListFolders(ctx context.Context, userID, accountID string) ([]*FolderStatus, error) {
+	_, rights, err := s.openAccount(ctx, userID, accountID)
+	if err != nil {
+		return nil, err
+	}
+
+	all, err := s.folderRepo.ListByAccount(ctx, accountID)
+	if err != nil {
+		return nil, errors.InternalError(err)
+	}
+	folders := make([]*domain.Folder, 0, len(all))
+	for _, folder := range all {
+		if rights.of(folder.ID).Has(domain.RightLookup) {
+			folders = append(folders, folder)
+		}
+	}
+	parents := make(map[string]bool)
+	for _, folder := range folders {
+		if folder.ParentID != nil {
+			parents[*folder.ParentID] = true
+		}
+	}
+
+	unread := false
+	statuses := make([]*FolderStatus, 0, len(folders))
+	for _, folder := range folders {
+		status := &FolderStatus{
+			Folder:      folder,
+			HasChildren: parents[folder.ID],
+			Rights:      rights.of(folder.ID),
+		}
+		statuses = append(statuses, status)
+		if !status.Rights.Has(domain.RightRead) {
+			continue
+		}
+		folderID := folder.ID
+		total, err := s.messageRepo.CountByAccount(ctx, accountID, repository.MessageFilter{FolderID: &folderID})
+		if err != nil {
+			return nil, errors.InternalError(err)
+		}
+		unreadCount, err := s.messageRepo.CountByAccount(ctx, accountID, repository.MessageFilter{FolderID: &folderID, IsRead: &unread})
+		if err != nil {
+			return nil, errors.InternalError(err)
+		}
+		status.TotalMessages = total
+		status.UnreadMessages = unreadCount
+	}
+	return statuses, nil
+}
+
+// CreateFolder creates a folder, optionally under a parent folder. An
+// account has at most one folder of each system type. In accounts of other
+// users the user needs the create right on the parent, or on the Inbox for
+// a top-level folder, and the new folder inherits its ACL.
+func (s *MailboxService) CreateFolder(ctx context.Context, userID string, req CreateFolderRequest) (*domain.Folder, error) {
+	_, rights, err := s.openAccount(ctx, userID, req.AccountID)
+	if err != nil {
+		return nil, err
+	}
+
+	name := strings.TrimSpace(req.Name)
+	if err := validateFolderName(name); err != nil {
+		return nil, err
+	}
+	folderType := req.Type
+	if folderType == "" {
+		folderType = domain.FolderTypeCustom
+	}
+	if !validFolderType(folderType) {
+		return nil, errors.NewError(errors.ErrCodeValidationError, "Invalid folder type").WithDetail("type", folderType)
+	}
+
+	path := name
+	var parentID *string
+	var parent *domain.Folder
+	if req.ParentID != "" {
+		if parent, err = s.accountFolder(ctx, req.AccountID, req.ParentID); err != nil {
+			return nil, err
+		}
+		path = parent.Path + "/" + name
+		parentID = &parent.ID
+	} else if rights != nil {
+		if parent, err = s.folderOfType(ctx, req.AccountID, domain.FolderTypeInbox); err != nil {
+			return nil, err
+		}
+	}
+	if parent != nil {
+		if err := rights.require(parent, domain.RightCreate); err != nil {
+			return nil, err
+		}
+	}
+
+	folders, err := s.folderRepo.ListByAccount(ctx, req.AccountID)
+	if err != nil {
+		return nil, errors.InternalError(err)
+	}
+	for _, folder := range folders {
+		if folder.Path == path || (folderType != domain.FolderTypeCustom && folder.Type == folderType) {
+			return nil, errors.FolderAlreadyExists(path)
+		}
+	}
+
+	now := time.Now()
+	folder := &domain.Folder{
+		ID:           uuid.New().String(),
+		AccountID:    req.AccountID,
+		ParentID:     parentID,
+		Name:         name,
+		Path:         path,
+		Type:         folderType,
+		IsSubscribed: req.Subscribe,
+		CreatedAt:    now,
+		UpdatedAt:    now,
+	}
+	if err := s.folderRepo.Create(ctx, folder); err != nil {
+		return nil, errors.InternalError(err)
+	}
+	if s.access != nil && parent != nil {
+		if err := s.access.InheritACL(ctx, userID, parent, folder); err != nil {
+			// Log error but don't fail the operation
+		}
+	}
+	return folder, nil
+}
+
+// RenameFolder renames a custom folder; its subfolders follow it
+func (s *MailboxService) RenameFolder(ctx context.Context, userID, accountID, folderID, name string) (*domain.Folder, error) {
+	_, rights, err := s.openAccount(ctx, userID, accountID)
+	if err != nil {
+		return nil, err
+	}
+	folder, err := s.accountFolder(ctx, accountID, folderID)
+	if err != nil {
+		return nil, err
+	}
+	if err := rights.require(folder, domain.RightDeleteFolder); err != nil {
+		return nil, err
+	}
+	if folder.Type != domain.FolderTypeCustom {
+		return nil, errors.NewError(errors.ErrCodeValidationError, "System folders cannot be renamed").
+			WithDetail("folder_id", folderID)
+	}
+	name = strings.TrimSpace(name)
+	if err := validateFolderName(name); err != nil {
+		return nil, err
+	}
+
+	oldPath := folder.Path
+	newPath := name
+	if i := strings.LastIndex(oldPath, "/"); i >= 0 {
+		newPath = oldPath[:i+1] + name
+	}
+	if newPath == oldPath {
+		return folder, nil
+	}
+
+	folders, err := s.folderRepo.ListByAccount(ctx, accountID)
+	if err != nil {
+		return nil, errors.InternalError(err)
+	}
+	renamed := []*domain.Folder{}
+	for _, other := range folders {
+		if other.Path == newPath || strings.HasPrefix(other.Path, newPath+"/") {
+			return nil, errors.FolderAlreadyExists(newPath)
+		}
+		if other.ID == folder.ID || strings.HasPrefix(other.Path, oldPath+"/") {
+			renamed = append(renamed, other)
+		}
+	}
+
+	renamer, _ := s.messageRepo.(FolderRenamer)
+	if renamer != nil {
+		if err := renamer.RenameFolder(ctx, accountID, oldPath, newPath); err != nil {
+			return nil, errors.InternalError(err)
+		}
+	}
+
+	now := time.Now()
+	err = withinTransaction(ctx, s.transactor, func(ctx context.Context) error {
+		for _, other := range renamed {
+			if other.ID == folder.ID {
+				other.Name = name
+			}
+			other.Path = newPath + strings.TrimPrefix(other.Path, oldPath)
+			other.UpdatedAt = now
+			if err := s.folderRepo.Update(ctx, other); err != nil {
+				return errors.InternalError(err)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		if renamer != nil {
+			if err := renamer.RenameFolder(ctx, accountID, newPath, oldPath); err != nil {
+				// Log error but don't fail the operation
+			}
+		}
+		return nil, err
+	}
+	for _, other := range renamed {
+		if other.ID == folder.ID {
+			return other, nil
+		}
+	}
+	return folder, nil
+}
+
+// SetSubscribed subscribes to a folder or unsubscribes from it
+func (s *MailboxService) SetSubscribed(ctx context.Context, userID, accountID, folderID string, subscribed bool) (*domain.Folder, error) {
+	_, rights, err := s.openAccount(ctx, userID, accountID)
+	if err != nil {
+		return nil, err
+	}
+	folder, err := s.accountFolder(ctx, accountID, folderID)
+	if err != nil {
+		return nil, err
+	}
+	if err := rights.require(folder, domain.RightLookup); err != nil {
+		return nil, err
+	}
+	if folder.IsSubscribed == subscribed {
+		return folder, nil
+	}
+
+	folder.IsSubscribed = subscribed
+	folder.UpdatedAt = time.Now()
+	if err := s.folderRepo.Update(ctx, folder); err != nil {
+		return nil, errors.InternalError(err)
+	}
+	return folder, nil
+}
+
+// EmptyFolder removes every message of a folder and returns how many were
+// removed. Messages in Trash and Spam are deleted permanently; others are
+// moved to Trash, or deleted when the account has no Trash folder.
+func (s *MailboxService) EmptyFolder(ctx context.Context, userID, accountID, folderID string) (int, error) {
+	_, rights, err := s.openAccount(ctx, userID, accountID)
+	if err != nil {
+		return 0, err
+	}
+	folder, err := s.accountFolder(ctx, accountID, folderID)
+	if err != nil {
+		return 0, err
+	}
+	if err := rights.require(folder, domain.RightDeleteMessages, domain.RightExpunge); err != nil {
+		return 0, err
+	}
+
+	var trash *domain.Folder
+	if folder.Type != domain.FolderTypeTrash && folder.Type != domain.FolderTypeSpam {
+		if trash, err = s.folderRepo.GetByType(ctx, accountID, domain.FolderTypeTrash); err != nil {
+			return 0, errors.InternalError(err)
+		}
+	}
+	if trash != nil {
+		if err := rights.require(trash, domain.RightInsert); err != nil {
+			return 0, err
+		}
+	}
+	return s.clearFolder(ctx, folder, trash)
+}
+
+// DeleteFolder deletes a custom folder with its subfolders. Their messages
+// are moved to Trash when moveToTrash is set and the account has a Trash
+// folder, and deleted permanently otherwise.
+func (s *MailboxService) DeleteFolder(ctx context.Context, userID, accountID, folderID string, moveToTrash bool) error {
+	_, rights, err := s.openAccount(ctx, userID, accountID)
+	if err != nil {
+		return err
+	}
+	folder, err := s.accountFolder(ctx, accountID, folderID)
+	if err != nil {
+		return err
+	}
+	if folder.Type != domain.FolderTypeCustom {
+		return errors.NewError(errors.ErrCodeValidationError, "System folders cannot be deleted").
+			WithDetail("folder_id", folderID)
+	}
+
+	var trash *domain.Folder
+	if moveToTrash {
+		if trash, err = s.folderRepo.GetByType(ctx, accountID, domain.FolderTypeTrash); err != nil {
+			return errors.InternalError(err)
+		}
+	}
+	if trash != nil {
+		if err := rights.require(trash, domain.RightInsert); err != nil {
+			return err
+		}
+	}
+	folders, err := s.folderRepo.ListByAccount(ctx, accountID)
+	if err != nil {
+		return errors.InternalError(err)
+	}
+	deleted := []*domain.Folder{}
+	for _, other := range folders {
+		if other.ID == folder.ID || strings.HasPrefix(other.Path, folder.Path+"/") {
+			if err := rights.require(other, domain.RightDeleteFolder); err != nil {
+				return err
+			}
+			deleted = append(deleted, other)
+		}
+	}
+	for _, other := range deleted {
+		if _, err := s.clearFolder(ctx, other, trash); err != nil {
+			return err
+		}
+	}
+
+	if err := s.folderRepo.Delete(ctx, folder.ID); err != nil {
+		return errors.InternalError(err)
+	}
+	return nil
+}
+
+// ListMessages lists the messages of an account matching a filter, newest
+// first, with the number of matching messages. The user needs the read
+// right on the folder, or on every folder when no folder is given.
+func (s *MailboxService) ListMessages(ctx context.Context, userID, accountID string, filter repository.MessageFilter) ([]*domain.Message, int, error) {
+	_, rights, err := s.openAccount(ctx, userID, accountID)
+	if err != nil {
+		return nil, 0, err
+	}
+	if filter.FolderID != nil {
+		folder, err := s.accountFolder(ctx, accountID, *filter.FolderID)
+		if err != nil {
+			return nil, 0, err
+		}
+		if err := rights.require(folder, domain.RightRead); err != nil {
+			return nil, 0, err
+		}
+	} else if !rights.all(domain.RightRead) {
+		return nil, 0, errors.NewError(errors.ErrCodeValidationError, "A folder is required to list messages of a shared account").
+			WithDetail("account_id", accountID)
+	}
+
+	messages, err := s.messageRepo.ListByAccount(ctx, accountID, filter)
+	if err != nil {
+		return nil, 0, errors.InternalError(err)
+	}
+	total, err := s.messageRepo.CountByAccount(ctx, accountID, filter)
+	if err != nil {
+		return nil, 0, errors.InternalError(err)
+	}
+	return messages, total, nil
+}
+
+// GetMessage retrieves a message of one of the user's accounts, or of a
+// folder the user may read, with its body and attachments
+func (s *MailboxService) GetMessage(ctx context.Context, userID, id string) (*domain.Message, error) {
+	message, err := s.messageRepo.GetByID(ctx, id)
+	if err != nil {
+		return nil, errors.InternalError(err)
+	}
+	if message == nil {
+		return nil, errors.MessageNotFound(id)
+	}
+
+	_, rights, err := s.openAccount(ctx, userID, message.AccountID)
+	if errors.IsErrorCode(err, errors.ErrCodeEmailAccountNotFound) || (err == nil && rights.of(message.FolderID) == "") {
+		// Do not reveal messages of other users
+		return nil, errors.MessageNotFound(id)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := rights.requireID(message.FolderID, domain.RightRead); err != nil {
+		return nil, err
+	}
+	return message, nil
+}
+
+// ApplyAction applies an action to messages of an account. folderID is the
+// destination of a move. Deleted messages go to Trash, and are removed
+// permanently when they already are in Trash or the account has none.
+// Marking messages read needs the seen right on their folders, starring and
+// flagging the write right, and moving them the delete and expunge rights
+// with the insert right on the destination.
+func (s *MailboxService) ApplyAction(ctx context.Context, userID, accountID string, ids []string, action MessageAction, folderID string) (*MessageActionResult, error) {
+	_, rights, err := s.openAccount(ctx, userID, accountID)
+	if err != nil {
+		return nil, err
+	}
+
+	var dest *domain.Folder
+	needed := []domain.Right{domain.RightDeleteMessages, domain.RightExpunge}
+	switch action {
+	case MessageActionMarkRead, MessageActionMarkUnread:
+		needed = []domain.Right{domain.RightSeen}
+	case MessageActionStar, MessageActionUnstar, MessageActionFlag, MessageActionUnflag:
+		needed = []domain.Right{domain.RightWrite}
+	case MessageActionMove:
+		if folderID == "" {
+			return nil, errors.NewError(errors.ErrCodeValidationError, "A destination folder is required")
+		}
+		if dest, err = s.accountFolder(ctx, accountID, folderID); err != nil {
+			return nil, err
+		}
+	case MessageActionArchive:
+		if dest, err = s.folderOfType(ctx, accountID, domain.FolderTypeArchive); err != nil {
+			return nil, err
+		}
+	case MessageActionDelete:
+		if dest, err = s.folderRepo.GetByType(ctx, accountID, domain.FolderTypeTrash); err != nil {
+			return nil, errors.InternalError(err)
+		}
+	default:
+		return nil, errors.NewError(errors.ErrCodeValidationError, "Unknown message action").WithDetail("action", action)
+	}
+	if dest != nil {
+		if err := rights.require(dest, domain.RightInsert); err != nil {
+			return nil, err
+		}
+	}
+
+	return s.eachMessage(ctx, accountID, ids, rights, needed, func(message *domain.Message) error {
+		switch action {
+		case MessageActionMarkRead, MessageActionMarkUnread:
+			message.IsRead = action == MessageActionMarkRead
+		case MessageActionStar, MessageActionUnstar:
+			message.IsStarred = action == MessageActionStar
+		case MessageActionFlag, MessageActionUnflag:
+			message.IsFlagged = action == MessageActionFlag
+		case MessageActionDelete:
+			if dest == nil || message.FolderID == dest.ID {
+				return s.purge(ctx, message)
+			}
+			return s.move(ctx, message, dest)
+		default:
+			return s.move(ctx, message, dest)
+		}
+		message.UpdatedAt = time.Now()
+		if err := s.messageRepo.Update(ctx, message); err != nil {
+			return errors.InternalError(err)
+		}
+		return nil
+	})
+}
+
+// SetLabels replaces the labels of messages of an account. Labels are
+// trimmed and duplicates dropped.
+func (s *MailboxService) SetLabels(ctx context.Context, userID, accountID string, ids []string, labels []string) (*MessageActionResult, error) {
+	_, rights, err := s.openAccount(ctx, userID, accountID)
+	if err != nil {
+		return nil, err
+	}
+	normalized, err := normalizeLabels(labels)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.eachMessage(ctx, accountID, ids, rights, []domain.Right{domain.RightWrite}, func(message *domain.Message) error {
+		message.Labels = append([]string{}, normalized...)
+		message.UpdatedAt = time.Now()
+		if err := s.messageRepo.Update(ctx, message); err != nil {
+			return errors.InternalError(err)
+		}
+		return nil
+	})
+}
+
+// openAccount returns an account the user owns, or one with a folder
+// shared with the user, with the user's rights on its folders
+func (s *MailboxService) openAccount(ctx context.Context, userID, accountID string) (*domain.EmailAccount, folderRights, error) {
+	account, err := s.accountRepo.GetByID(ctx, accountID)
+	if err != nil {
+		return nil, nil, errors.InternalError(err)
+	}
+	if account != nil && account.UserID == userID {
+		return account, nil, nil
+	}
+	if account != nil && s.access != nil {
+		folders, err := s.folderRepo.ListByAccount(ctx, accountID)
+		if err != nil {
+			return nil, nil, errors.InternalError(err)
+		}
+		rights, err := s.access.FolderRights(ctx, userID, account, folders)
+		if err != nil {
+			return nil, nil, err
+		}
+		for _, folderRights := range rights {
+			if folderRights != "" {
+				return account, rights, nil
+			}
+		}
+	}
+	// Do not reveal accounts not shared with the user
+	return nil, nil, errors.EmailAccountNotFound(accountID)
+}
+
+// folderRights holds the rights of a user on the folders of an account by
+// folder ID. It is nil for the owner, who holds every right.
+type folderRights map[string]domain.Rights
+
+// of returns the rights on a folder
+func (r folderRights) of(folderID string) domain.Rights {
+	if r == nil {
+		return domain.AllRights
+	}
+	return r[folderID]
+}
+
+// all reports whether the rights are held on every folder
+func (r folderRights) all(rights ...domain.Right) bool {
+	for _, held := range r {
+		if !held.Has(rights...) {
+			return false
+		}
+	}
+	return true
+}
+
+// require checks that the rights are held on a folder. Folders the user
+// holds no right on are reported as not found.
+func (r folderRights) require(folder *domain.Folder, rights ...domain.Right) error {
+	if r.of(folder.ID) == "" {
+		// Do not reveal folders not shared with the user
+		return errors.FolderNotFound(folder.ID)
+	}
+	return r.requireID(folder.ID, rights...)
+}
+
+// requireID checks that the rights are held on a folder
+func (r folderRights) requireID(folderID string, rights ...domain.Right) error {
+	if !r.of(folderID).Has(rights...) {
+		return errors.MissingRights(folderID, string(domain.NewRights(rights...)))
+	}
+	return nil
+}
+
+// accountFolder returns a folder of an account
+func (s *MailboxService) accountFolder(ctx context.Context, accountID, folderID string) (*domain.Folder, error) {
+	folder, err := s.folderRepo.GetByID(ctx, folderID)
+	if err != nil {
+		return nil, errors.InternalError(err)
+	}
+	if folder == nil || folder.AccountID != accountID {
+		return nil, errors.FolderNotFound(folderID)
+	}
+	return folder, nil
+}
+
+// folderOfType returns the system folder of a type, which must exist
+func (s *MailboxService) folderOfType(ctx context.Context, accountID string, folderType domain.FolderType) (*domain.Folder, error) {
+	folder, err := s.folderRepo.GetByType(ctx, accountID, folderType)
+	if err != nil {
+		return nil, errors.InternalError(err)
+	}
+	if folder == nil {
+		return nil, errors.FolderNotFound(string(folderType))
+	}
+	return folder, nil
+}
+
+// eachMessage runs fn on every distinct message of the account among ids,
+// which needs the rights on the folder of the message; IDs of other
+// messages, and of messages in folders the user holds no right on, are
+// reported as not found
+func (s *MailboxService) eachMessage(ctx context.Context, accountID string, ids []string, rights folderRights, needed []domain.Right, fn func(*domain.Message) error) (*MessageActionResult, error) {
+	if len(ids) == 0 {
+		return nil, errors.NewError(errors.ErrCodeValidationError, "No messages given")
+	}
+	if len(ids) > maxBulkMessages {
+		return nil, errors.NewError(errors.ErrCodeValidationError, "Too many messages in one request").
+			WithDetail("max", maxBulkMessages)
+	}
+
+	result := &MessageActionResult{Updated: []string{}, NotFound: []string{}}
+	threadIDs := []string{}
+	defer func() { s.refreshThreads(ctx, threadIDs) }()
+	seen := make(map[string]bool, len(ids))
+	for _, id := range ids {
+		if seen[id] {
+			continue
+		}
+		seen[id] = true
+
+		message, err := s.messageRepo.GetByID(ctx, id)
+		if err != nil {
+			return nil, errors.InternalError(err)
+		}
+		if message == nil || message.AccountID != accountID || rights.of(message.FolderID) == "" {
+			result.NotFound = append(result.NotFound, id)
+			continue
+		}
+		if err := rights.requireID(message.FolderID, needed...); err != nil {
+			return nil, err
+		}
+		threadIDs = append(threadIDs, message.ThreadID)
+		if err := fn(message); err != nil {
+			return nil, err
+		}
+		result.Updated = append(result.Updated, id)
+	}
+	return result, nil
+}
+
+// move files a message in another folder and trains the spam filter on it
+func (s *MailboxService) move(ctx context.Context, message *domain.Message, dest *domain.Folder) error {
+	if message.FolderID == dest.ID {
+		return nil
+	}
+
+	var source *domain.Folder
+	if message.FolderID != "" {
+		var err error
+		if source, err = s.folderRepo.GetByID(ctx, message.FolderID); err != nil {
+			return errors.InternalError(err)
+		}
+	}
+
+	message.FolderID = dest.ID
+	message.UpdatedAt = time.Now()
+	if err := s.messageRepo.Update(ctx, message); err != nil {
+		return errors.InternalError(err)
+	}
+
+	if s.spamTrainer != nil {
+		if err := s.spamTrainer.TrainFromMove(ctx, message, source, dest); err != nil {
+			// Log error but don't fail the operation
+		}
+	}
+	return nil
+}
+
+// purge deletes a message permanently and releases its attachment blobs
+func (s *MailboxService) purge(ctx context.Context, message *domain.Message) error {
+	if err := s.messageRepo.Delete(ctx, message.ID); err != nil {
+		return errors.InternalError(err)
+	}
+	if s.blobs != nil {
+		for _, attachment := range message.Attachments {
+			if attachment.BlobID == "" {
+				continue
+			}
+			if err := s.blobs.Release(ctx, attachment.BlobID); err != nil {
+				// Log error but don't fail the operation
+			}
+		}
+	}
+	return nil
+}
+
+// clearFolder moves the messages of a folder to trash, or deletes them
+// when trash is nil, and returns how many there were
+func (s *MailboxService) clearFolder(ctx context.Context, folder, trash *domain.Folder) (int, error) {
+	folderID := folder.ID
+	messages, err := s.messageRepo.ListByAccount(ctx, folder.AccountID, repository.MessageFilter{FolderID: &folderID})
+	if err != nil {
+		return 0, errors.InternalError(err)
+	}
+	threadIDs := make([]string, 0, len(messages))
+	defer func() { s.refreshThreads(ctx, threadIDs) }()
+	for _, message := range messages {
+		threadIDs = append(threadIDs, message.ThreadID)
+		if trash == nil {
+			err = s.purge(ctx, message)
+		} else {
+			err = s.move(ctx, message, trash)
+		}
+		if err != nil {
+			return 0, err
+		}
+	}
+	return len(messages), nil
+}
+
+// refreshThreads updates the threads of messages that were changed
+func (s *MailboxService) refreshThreads(ctx context.Context, threadIDs []string) {
+	if s.threads == nil || len(threadIDs) == 0 {
+		return
+	}
+	if err := s.threads.RefreshThreads(ctx, threadIDs...); err != nil {
+		// Log error but don't fail the operation
+	}
+}
+
+// validateFolderName checks a folder name; "/" separates folder levels
+func validateFolderName(name string) error {
+	if name == "" {
+		return errors.NewError(errors.ErrCodeValidationError, "Folder name is required")
+	}
+	if utf8.RuneCountInString(name) > maxFolderNameSize {
+		return errors.NewError(errors.ErrCodeValidationError, "Folder name is too long").
+			WithDetail("max", maxFolderNameSize)
+	}
+	for _, r := range name {
+		if r == '/' || unicode.IsControl(r) {
+			return errors.NewError(errors.ErrCodeValidationError, "Folder name contains an invalid character").
+				WithDetail("name", name)
+		}
+	}
+	return nil
+}
+
+func validFolderType(folderType domain.FolderType) bool {
+	switch folderType {
+	case domain.FolderTypeInbox, domain.FolderTypeSent, domain.FolderTypeDrafts, domain.FolderTypeTrash,
+		domain.FolderTypeSpam, domain.FolderTypeArchive, domain.FolderTypeCustom:
+		return true
+	}
+	return false
+}
+
+// normalizeLabels trims labels and drops empty and duplicate ones
+func normalizeLabels(labels []string) ([]string, error) {
+	normalized := []string{}
+	seen := make(map[string]bool, len(labels))
+	for _, label := range labels {
+		label = strings.TrimSpace(label)
+		if label == "" || seen[label] {
+			continue
+		}
+		if utf8.RuneCountInString(label) > maxLabelLength {
+			return nil, errors.NewError(errors.ErrCodeValidationError, "Label is too long").
+				WithDetail("max", maxLabelLength)
+		}
+		for _, r := range label {
+			if unicode.IsControl(r) {
+				return nil, errors.NewError(errors.ErrCodeValidationError, "Label contains an invalid character").
+					WithDetail("label", label)
+			}
+		}
+		seen[label] = true
+		normalized = append(normalized, label)
+	}
+	if len(normalized) > maxMessageLabels {
+		return nil, errors.NewError(errors.ErrCodeValidationError, "Too many labels").
+			WithDetail("max", maxMessageLabels)
+	}
+	return normalized, nil
+}
@@ -0,0 +1,240 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/textproto"
+	"sort"
+	"strings"
+
+	"github.com/skygenesisenterprise/aether-mailer/package/golang/domain"
+)
+
+// renderedHeaders are written from the message fields rather than copied
+// from its headers. Bcc is never rendered.
+var renderedHeaders = map[string]bool{
+	"Bcc":                       true,
+	"Cc":                        true,
+	"Content-Transfer-Encoding": true,
+	"Content-Type":              true,
+	"Date":                      true,
+	"From":                      true,
+	"Mime-Version":              true,
+	"Subject":                   true,
+	"To":                        true,
+}
+
+// renderMessage renders a stored message as RFC 5322 data for delivery.
+// Attachments kept in the blob store are read from blobs.
+func renderMessage(ctx context.Context, message *domain.Message, blobs BlobReader) ([]byte, error) {
+	var buf bytes.Buffer
+	writeHeader(&buf, "Date", message.CreatedAt.Format("Mon, 02 Jan 2006 15:04:05 -0700"))
+	writeHeader(&buf, "From", message.From)
+	if len(message.To) > 0 {
+		writeHeader(&buf, "To", strings.Join(message.To, ", "))
+	}
+	if len(message.Cc) > 0 {
+		writeHeader(&buf, "Cc", strings.Join(message.Cc, ", "))
+	}
+	writeHeader(&buf, "Subject", mime.QEncoding.Encode("utf-8", message.Subject))
+
+	names := make([]string, 0, len(message.Headers))
+	for name := range message.Headers {
+		if !renderedHeaders[textproto.CanonicalMIMEHeaderKey(name)] {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		writeHeader(&buf, name, message.Headers[name])
+	}
+	writeHeader(&buf, "MIME-Version", "1.0")
+
+	header, body, err := renderBody(message)
+	if err != nil {
+		return nil, err
+	}
+	if len(message.Attachments) == 0 {
+		for _, name := range []string{"Content-Type", "Content-Transfer-Encoding"} {
+			if value := header.Get(name); value != "" {
+				writeHeader(&buf, name, value)
+			}
+		}
+		buf.WriteString("\r\n")
+		buf.Write(body)
+		return buf.Bytes(), nil
+	}
+
+	mixed := multipart.NewWriter(&buf)
+	writeHeader(&buf, "Content-Type", `multipart/mixed; boundary="`+mixed.Boundary()+`"`)
+	buf.WriteString("\r\n")
+
+	part, err := mixed.CreatePart(header)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := part.Write(body); err != nil {
+		return nil, err
+	}
+	for i := range message.Attachments {
+		if err := writeAttachment(ctx, mixed, &message.Attachments[i], blobs); err != nil {
+			return nil, err
+		}
+	}
+	if err := mixed.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// renderBody returns the MIME header and the encoded content of the text
+// and HTML bodies, alternatives of each other when the message has both
+func renderBody(message *domain.Message) (textproto.MIMEHeader, []byte, error) {
+	text, html := message.BodyText, message.BodyHTML
+	if text == nil && html == nil {
+		empty := ""
+		text = &empty
+	}
+	if text == nil || html == nil {
+		contentType, content := "text/plain", text
+		if html != nil {
+			contentType, content = "text/html", html
+		}
+		var encoded bytes.Buffer
+		if err := writeQuotedPrintable(&encoded, *content); err != nil {
+			return nil, nil, err
+		}
+		return textproto.MIMEHeader{
+			"Content-Type":              {contentType + "; charset=utf-8"},
+			"Content-Transfer-Encoding": {"quoted-printable"},
+		}, encoded.Bytes(), nil
+	}
+
+	var buf bytes.Buffer
+	alternative := multipart.NewWriter(&buf)
+	for _, body := range []struct {
+		contentType string
+		content     string
+	}{
+		{"text/plain", *text},
+		{"text/html", *html},
+	} {
+		part, err := alternative.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {body.contentType + "; charset=utf-8"},
+			"Content-Transfer-Encoding": {"quoted-printable"},
+		})
+		if err != nil {
+			return nil, nil, err
+		}
+		var encoded bytes.Buffer
+		if err := writeQuotedPrintable(&encoded, body.content); err != nil {
+			return nil, nil, err
+		}
+		if _, err := part.Write(encoded.Bytes()); err != nil {
+			return nil, nil, err
+		}
+	}
+	if err := alternative.Close(); err != nil {
+		return nil, nil, err
+	}
+	return textproto.MIMEHeader{
+		"Content-Type": {`multipart/alternative; boundary="` + alternative.Boundary() + `"`},
+	}, buf.Bytes(), nil
+}
+
+// writeAttachment writes an attachment as a base64 part
+func writeAttachment(ctx context.Context, w *multipart.Writer, att *domain.Attachment, blobs BlobReader) error {
+	contentType := att.ContentType
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	part, err := w.CreatePart(textproto.MIMEHeader{
+		"Content-Type":              {mime.FormatMediaType(contentType, map[string]string{"name": att.Filename})},
+		"Content-Disposition":       {mime.FormatMediaType("attachment", map[string]string{"filename": att.Filename})},
+		"Content-Transfer-Encoding": {"base64"},
+	})
+	if err != nil {
+		return err
+	}
+
+	var content io.Reader = bytes.NewReader(att.Content)
+	if len(att.Content) == 0 && att.BlobID != "" {
+		if blobs == nil {
+			return fmt.Errorf("attachment %s is stored in the blob store", att.Filename)
+		}
+		reader, _, err := blobs.Open(ctx, att.BlobID, 0, -1)
+		if err != nil {
+			return err
+		}
+		defer reader.Close()
+		content = reader
+	}
+
+	lines := &lineBreaker{w: part}
+	encoder := base64.NewEncoder(base64.StdEncoding, lines)
+	if _, err := io.Copy(encoder, content); err != nil {
+		return err
+	}
+	if err := encoder.Close(); err != nil {
+		return err
+	}
+	return lines.close()
+}
+
+func writeQuotedPrintable(w *bytes.Buffer, content string) error {
+	encoder := quotedprintable.NewWriter(w)
+	if _, err := encoder.Write([]byte(strings.ReplaceAll(content, "\r\n", "\n"))); err != nil {
+		return err
+	}
+	return encoder.Close()
+}
+
+// writeHeader writes a header field, dropping line breaks a value could use
+// to inject further fields
+func writeHeader(w *bytes.Buffer, name, value string) {
+	value = strings.NewReplacer("\r", "", "\n", " ").Replace(value)
+	fmt.Fprintf(w, "%s: %s\r\n", name, value)
+}
+
+// lineBreaker splits base64 output into lines of 76 characters
+type lineBreaker struct {
+	w    io.Writer
+	used int
+}
+
+func (l *lineBreaker) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		n := 76 - l.used
+		if n > len(p) {
+			n = len(p)
+		}
+		if _, err := l.w.Write(p[:n]); err != nil {
+			return written, err
+		}
+		written += n
+		l.used += n
+		p = p[n:]
+		if l.used == 76 {
+			if _, err := l.w.Write([]byte("\r\n")); err != nil {
+				return written, err
+			}
+			l.used = 0
+		}
+	}
+	return written, nil
+}
+
+func (l *lineBreaker) close() error {
+	if l.used == 0 {
+		return nil
+	}
+	_, err := l.w.Write([]byte("\r\n"))
+	return err
+}
@@ -1,7 +1,12 @@
 package service
 
 import (
+	"bytes"
 	"context"
+	"fmt"
+	"io"
+	"net/mail"
+	"strings"
 	"time"
 
 	"github.com/google/uuid"
@@ -17,10 +22,71 @@ type MessageService struct {
 	attachmentRepo repository.AttachmentRepository
 	quotaRepo      repository.QuotaRepository
 	policyRepo     repository.PolicyRepository
+	folderRepo     repository.FolderRepository
+	domainRepo     repository.DomainRepository
+	spamTrainer    SpamTrainer
+	spamFilter     SpamFilter
+	antivirus      VirusChecker
+	quarantine     Quarantiner
+	attachments    AttachmentChecker
+	rateLimiter    RateLimiter
+	senders        SenderAuthorizer
+	blobs          BlobStore
+	transactor     repository.Transactor
 	eventPub       domain.EventPublisher
 	config         *MessageConfig
 }
 
+// SpamTrainer learns from messages users move into or out of the spam folder
+type SpamTrainer interface {
+	TrainFromMove(ctx context.Context, message *domain.Message, from, to *domain.Folder) error
+}
+
+// SpamFilter classifies inbound messages before they are stored.
+// *SpamService satisfies it.
+type SpamFilter interface {
+	FilterInbound(ctx context.Context, message *domain.Message, auth *domain.AuthenticationResults) (*domain.SpamVerdict, error)
+}
+
+// VirusChecker scans messages and their attachments for malware and picks
+// the action of the matching virus policy. *AntivirusService satisfies it.
+type VirusChecker interface {
+	ScanMessage(ctx context.Context, message *domain.Message, raw io.Reader) (*domain.VirusVerdict, error)
+}
+
+// Quarantiner holds inbound messages back for review.
+// *QuarantineService satisfies it.
+type Quarantiner interface {
+	Quarantine(ctx context.Context, req QuarantineRequest) (*domain.QuarantineEntry, error)
+}
+
+// SenderAuthorizer decides which From addresses an account may send as,
+// such as IdentityService. from is empty for the default address. The
+// profile returned sets the From, Sender and default Reply-To headers.
+type SenderAuthorizer interface {
+	AuthorizeSender(ctx context.Context, account *domain.EmailAccount, from string) (*SenderProfile, error)
+}
+
+// AttachmentChecker applies attachment policies to a message
+type AttachmentChecker interface {
+	CheckAttachments(ctx context.Context, message *domain.Message, direction domain.MessageDirection) (*domain.AttachmentVerdict, error)
+}
+
+// RateLimiter enforces outbound sending limits. ReleaseSend gives back the
+// capacity of an attempt that passed CheckSend but was not sent.
+type RateLimiter interface {
+	CheckSend(ctx context.Context, attempt SendAttempt) error
+	ReleaseSend(ctx context.Context, attempt SendAttempt) error
+}
+
+// BlobStore keeps attachment content out of the message records. Retain
+// only finds the blobs a tenant may reference.
+type BlobStore interface {
+	Put(ctx context.Context, tenantID string, content io.Reader) (*domain.Blob, error)
+	Retain(ctx context.Context, tenantID, id string) (*domain.Blob, error)
+	Release(ctx context.Context, id string) error
+}
+
 // MessageConfig defines message service configuration
 type MessageConfig struct {
 	MaxMessageSize    int64
@@ -29,24 +95,58 @@ type MessageConfig struct {
 	AllowedMimeTypes  []string
 }
 
+// MessageServiceDeps holds the dependencies of a message service. The
+// repositories other than Folders, EventPub and Config are required; the
+// rest are optional: without Folders, sent copies are not filed and
+// messages cannot be moved; without Domains, sends are not counted against
+// the tenant that owns the domain; without Senders, messages are sent as the
+// account address only; without SpamFilter, inbound messages are filed
+// into INBOX unclassified; without Antivirus, nothing is scanned for
+// malware; without Quarantine, inbound messages a policy would hold are
+// rejected instead; with Transactor, a stored message, its quota
+// usage and its event are written atomically.
+type MessageServiceDeps struct {
+	Messages    repository.MessageRepository
+	Accounts    repository.EmailAccountRepository
+	Attachments repository.AttachmentRepository
+	Quotas      repository.QuotaRepository
+	Policies    repository.PolicyRepository
+	Folders     repository.FolderRepository
+	Domains     repository.DomainRepository
+	SpamTrainer SpamTrainer
+	SpamFilter  SpamFilter
+	Antivirus   VirusChecker
+	Quarantine  Quarantiner
+	Checker     AttachmentChecker
+	RateLimiter RateLimiter
+	Senders     SenderAuthorizer
+	Blobs       BlobStore
+	Transactor  repository.Transactor
+	EventPub    domain.EventPublisher
+	Config      *MessageConfig
+}
+
 // NewMessageService creates a new message service
-func NewMessageService(
-	messageRepo repository.MessageRepository,
-	accountRepo repository.EmailAccountRepository,
-	attachmentRepo repository.AttachmentRepository,
-	quotaRepo repository.QuotaRepository,
-	policyRepo repository.PolicyRepository,
-	eventPub domain.EventPublisher,
-	config *MessageConfig,
-) *MessageService {
+func NewMessageService(deps MessageServiceDeps) *MessageService {
 	return &MessageService{
-		messageRepo:    messageRepo,
-		accountRepo:    accountRepo,
-		attachmentRepo: attachmentRepo,
-		quotaRepo:      quotaRepo,
-		policyRepo:     policyRepo,
-		eventPub:       eventPub,
-		config:         config,
+		messageRepo:    deps.Messages,
+		accountRepo:    deps.Accounts,
+		attachmentRepo: deps.Attachments,
+		quotaRepo:      deps.Quotas,
+		policyRepo:     deps.Policies,
+		folderRepo:     deps.Folders,
+		domainRepo:     deps.Domains,
+		spamTrainer:    deps.SpamTrainer,
+		spamFilter:     deps.SpamFilter,
+		antivirus:      deps.Antivirus,
+		quarantine:     deps.Quarantine,
+		attachments:    deps.Checker,
+		rateLimiter:    deps.RateLimiter,
+		senders:        deps.Senders,
+		blobs:          deps.Blobs,
+		transactor:     deps.Transactor,
+		eventPub:       deps.EventPub,
+		config:         deps.Config,
 	}
 }
 
@@ -64,6 +164,16 @@ func (s *MessageService) SendMessage(ctx context.Context, req SendMessageRequest
 		return nil, errors.NewError(errors.ErrCodeEmailAccountInactive, "Email account is not active")
 	}
 
+	// The From address must be one the account may send as
+	sender, err := s.authorizeSender(ctx, account, req.From)
+	if err != nil {
+		return nil, err
+	}
+	req.From = sender.From
+	if req.ReplyTo == "" {
+		req.ReplyTo = sender.ReplyTo
+	}
+
 	// Check quotas
 	if err := s.checkQuotas(ctx, account.UserID, account.DomainID); err != nil {
 		return nil, err
@@ -82,6 +192,25 @@ func (s *MessageService) SendMessage(ctx context.Context, req SendMessageRequest
 			WithDetail("actual_size", messageSize)
 	}
 
+	// Check sending rate limits. Capacity reserved for a message that is
+	// not sent after all is given back.
+	sent := false
+	if s.rateLimiter != nil {
+		attempt, err := s.sendAttempt(ctx, account, len(req.To)+len(req.Cc)+len(req.Bcc))
+		if err != nil {
+			return nil, err
+		}
+		if err := s.rateLimiter.CheckSend(ctx, attempt); err != nil {
+			return nil, err
+		}
+		defer func() {
+			if !sent {
+				// A reservation that cannot be released expires with its window
+				_ = s.rateLimiter.ReleaseSend(ctx, attempt)
+			}
+		}()
+	}
+
 	// Create message
 	message := &domain.Message{
 		ID:          uuid.New().String(),
@@ -93,9 +222,10 @@ func (s *MessageService) SendMessage(ctx context.Context, req SendMessageRequest
 		Subject:     req.Subject,
 		BodyText:    req.BodyText,
 		BodyHTML:    req.BodyHTML,
+		Headers:     outgoingHeaders(account.Email, req, sender.Sender),
 		Attachments: []domain.Attachment{},
 		Size:        messageSize,
-		IsRead:      false,
+		IsRead:      true,
 		IsDraft:     false,
 		IsSent:      false,
 		IsDeleted:   false,
@@ -103,55 +233,289 @@ func (s *MessageService) SendMessage(ctx context.Context, req SendMessageRequest
 		UpdatedAt:   time.Now(),
 	}
 
+	// The sent copy is filed in the Sent folder when the account has one
+	if s.folderRepo != nil {
+		sent, err := s.folderRepo.GetByType(ctx, account.ID, domain.FolderTypeSent)
+		if err != nil {
+			return nil, errors.InternalError(err)
+		}
+		if sent != nil {
+			message.FolderID = sent.ID
+		}
+	}
+
 	// Process attachments
 	if len(req.Attachments) > 0 {
-		if len(req.Attachments) > s.config.MaxAttachments {
-			return nil, errors.NewError(errors.ErrCodeValidationError, "Too many attachments").
-				WithDetail("max_attachments", s.config.MaxAttachments).
-				WithDetail("actual_attachments", len(req.Attachments))
+		if err := s.addAttachments(ctx, account, message, req.Attachments); err != nil {
+			return nil, err
+		}
+		if err := s.scanAttachments(ctx, message); err != nil {
+			releaseAttachmentBlobs(ctx, s.blobs, message.Attachments)
+			return nil, err
 		}
 
-		for _, att := range req.Attachments {
-			if att.Size > s.config.MaxAttachmentSize {
-				return nil, errors.NewError(errors.ErrCodeMessageTooLarge, "Attachment too large").
-					WithDetail("max_size", s.config.MaxAttachmentSize).
-					WithDetail("actual_size", att.Size)
+		// Outbound mail cannot be quarantined, so anything stronger than a tag is rejected
+		if s.attachments != nil {
+			verdict, err := s.attachments.CheckAttachments(ctx, message, domain.DirectionOutbound)
+			if err == nil && verdict.Action == domain.PolicyActionQuarantine {
+				err = errors.PolicyViolation(string(domain.PolicyTypeContent), "Attachment requires review").
+					WithDetail("hits", verdict.Hits)
 			}
-
-			attachment := &domain.Attachment{
-				ID:          uuid.New().String(),
-				MessageID:   message.ID,
-				Filename:    att.Filename,
-				ContentType: att.ContentType,
-				Size:        att.Size,
-				Content:     att.Content,
+			if err != nil {
+				releaseAttachmentBlobs(ctx, s.blobs, message.Attachments)
+				return nil, err
 			}
+		}
+	}
 
-			if err := s.attachmentRepo.Create(ctx, attachment); err != nil {
-				return nil, errors.InternalError(err)
-			}
+	if err := s.store(ctx, account, message, domain.EventTypeMessageSent); err != nil {
+		return nil, err
+	}
+
+	sent = true
+	return message, nil
+}
 
-			message.Attachments = append(message.Attachments, *attachment)
+// sendAttempt identifies the sender of a message for the rate limits. The
+// tenant is the owner of the account's domain.
+func (s *MessageService) sendAttempt(ctx context.Context, account *domain.EmailAccount, recipients int) (SendAttempt, error) {
+	attempt := SendAttempt{
+		AccountID:  account.ID,
+		DomainID:   account.DomainID,
+		Recipients: recipients,
+		At:         time.Now(),
+	}
+	if s.domainRepo != nil {
+		d, err := s.domainRepo.GetByID(ctx, account.DomainID)
+		if err != nil {
+			return SendAttempt{}, errors.InternalError(err)
+		}
+		if d != nil {
+			attempt.TenantID = d.OwnerID
 		}
 	}
+	return attempt, nil
+}
 
-	// Save message
-	if err := s.messageRepo.Create(ctx, message); err != nil {
+// ReceiveMessage stores an inbound message in the account's INBOX. Infected
+// messages are quarantined or rejected as the matching virus policy says,
+// and messages whose attachments break the attachment policies as it says.
+// When a spam filter is set, the message is classified before it is stored,
+// gets the X-Spam headers and is filed into the Spam folder above the
+// threshold.
+func (s *MessageService) ReceiveMessage(ctx context.Context, req ReceiveMessageRequest) (*ReceiveResult, error) {
+	account, err := s.accountRepo.GetByID(ctx, req.AccountID)
+	if err != nil {
 		return nil, errors.InternalError(err)
 	}
+	if account == nil {
+		return nil, errors.EmailAccountNotFound(req.AccountID)
+	}
+	if !account.IsActive {
+		return nil, errors.NewError(errors.ErrCodeEmailAccountInactive, "Email account is not active")
+	}
 
-	// Update quotas
-	if err := s.updateQuotas(ctx, account.UserID, account.DomainID, messageSize); err != nil {
-		// Log error but don't fail the operation
+	if err := s.checkQuotas(ctx, account.UserID, account.DomainID); err != nil {
+		return nil, err
 	}
 
-	// Publish event
-	event := domain.NewBaseEvent(uuid.New().String(), message.ID, domain.EventTypeMessageSent, message)
-	if err := s.eventPub.Publish(ctx, event); err != nil {
-		// Log error but don't fail the operation
+	messageSize := int64(len(req.Raw))
+	if messageSize == 0 {
+		messageSize = s.calculateMessageSize(SendMessageRequest{
+			From:        req.From,
+			To:          req.To,
+			Cc:          req.Cc,
+			Subject:     req.Subject,
+			BodyText:    req.BodyText,
+			BodyHTML:    req.BodyHTML,
+			Attachments: req.Attachments,
+		})
+	}
+	if messageSize > s.config.MaxMessageSize {
+		return nil, errors.NewError(errors.ErrCodeMessageTooLarge, "Message size exceeds limit").
+			WithDetail("max_size", s.config.MaxMessageSize).
+			WithDetail("actual_size", messageSize)
 	}
 
-	return message, nil
+	headers := make(map[string]string, len(req.Headers))
+	for name, value := range req.Headers {
+		headers[name] = value
+	}
+
+	now := time.Now()
+	message := &domain.Message{
+		ID:          uuid.New().String(),
+		AccountID:   account.ID,
+		From:        req.From,
+		To:          req.To,
+		Cc:          req.Cc,
+		Subject:     req.Subject,
+		BodyText:    req.BodyText,
+		BodyHTML:    req.BodyHTML,
+		Headers:     headers,
+		Attachments: []domain.Attachment{},
+		Size:        messageSize,
+		ReceivedAt:  now,
+		CreatedAt:   now,
+		UpdatedAt:   now,
+	}
+
+	if s.folderRepo != nil {
+		inbox, err := s.folderRepo.GetByType(ctx, account.ID, domain.FolderTypeInbox)
+		if err != nil {
+			return nil, errors.InternalError(err)
+		}
+		if inbox != nil {
+			message.FolderID = inbox.ID
+		}
+	}
+
+	if len(req.Attachments) > 0 {
+		if err := s.addAttachments(ctx, account, message, req.Attachments); err != nil {
+			return nil, err
+		}
+	}
+
+	if s.antivirus != nil {
+		var raw io.Reader
+		if len(req.Raw) > 0 {
+			raw = bytes.NewReader(req.Raw)
+		}
+		verdict, err := s.antivirus.ScanMessage(ctx, message, raw)
+		if err != nil {
+			releaseAttachmentBlobs(ctx, s.blobs, message.Attachments)
+			return nil, err
+		}
+		if verdict.Infected && verdict.Action == domain.PolicyActionQuarantine {
+			return s.hold(ctx, QuarantineRequest{
+				Message:      message,
+				RawMessage:   req.Raw,
+				Reason:       domain.QuarantineReasonVirus,
+				Details:      verdict.Findings[0].Signature,
+				VirusVerdict: verdict,
+			}, verdict.PolicyID, errors.VirusDetected(verdict.Findings[0].Signature))
+		}
+	}
+
+	if s.attachments != nil && len(message.Attachments) > 0 {
+		verdict, err := s.attachments.CheckAttachments(ctx, message, domain.DirectionInbound)
+		if err != nil {
+			releaseAttachmentBlobs(ctx, s.blobs, message.Attachments)
+			return nil, err
+		}
+		if verdict.Action == domain.PolicyActionQuarantine {
+			hit := strongestHit(verdict.Hits)
+			return s.hold(ctx, QuarantineRequest{
+				Message:    message,
+				RawMessage: req.Raw,
+				Reason:     domain.QuarantineReasonAttachment,
+				Details:    hit.Description,
+			}, nil, errors.PolicyViolation(hit.Rule, hit.Description).WithDetail("target", hit.Target))
+		}
+	}
+
+	if s.spamFilter != nil {
+		if _, err := s.spamFilter.FilterInbound(ctx, message, nil); err != nil {
+			releaseAttachmentBlobs(ctx, s.blobs, message.Attachments)
+			return nil, err
+		}
+	}
+
+	if err := s.store(ctx, account, message, domain.EventTypeMessageReceived); err != nil {
+		return nil, err
+	}
+
+	return &ReceiveResult{Message: message}, nil
+}
+
+// hold quarantines an inbound message instead of storing it. The entry
+// keeps the attachment blobs. Without a quarantine the message is rejected
+// with reject.
+func (s *MessageService) hold(ctx context.Context, req QuarantineRequest, policyID *string, reject error) (*ReceiveResult, error) {
+	if s.quarantine == nil {
+		releaseAttachmentBlobs(ctx, s.blobs, req.Message.Attachments)
+		return nil, reject
+	}
+
+	if policyID != nil {
+		policy, err := s.policyRepo.GetByID(ctx, *policyID)
+		if err != nil {
+			releaseAttachmentBlobs(ctx, s.blobs, req.Message.Attachments)
+			return nil, errors.InternalError(err)
+		}
+		req.Policy = policy
+	}
+
+	entry, err := s.quarantine.Quarantine(ctx, req)
+	if err != nil {
+		releaseAttachmentBlobs(ctx, s.blobs, req.Message.Attachments)
+		return nil, err
+	}
+	return &ReceiveResult{Quarantine: entry}, nil
+}
+
+// scanAttachments rejects uploaded attachments the virus scanner finds
+// infected, whatever the policy: outbound mail cannot be quarantined
+func (s *MessageService) scanAttachments(ctx context.Context, message *domain.Message) error {
+	if s.antivirus == nil || len(message.Attachments) == 0 {
+		return nil
+	}
+	verdict, err := s.antivirus.ScanMessage(ctx, message, nil)
+	if err != nil {
+		return err
+	}
+	if verdict.Infected && verdict.Action != domain.PolicyActionAllow {
+		return errors.VirusDetected(verdict.Findings[0].Signature).WithDetail("message_id", message.ID)
+	}
+	return nil
+}
+
+// LookupRecipient returns the active local account an inbound message
+// addressed to address is delivered to
+func (s *MessageService) LookupRecipient(ctx context.Context, address string) (*domain.EmailAccount, error) {
+	account, err := s.accountRepo.GetByEmail(ctx, normalizeEmail(extractAddress(address)))
+	if err != nil {
+		return nil, errors.InternalError(err)
+	}
+	if account == nil || !account.IsActive {
+		return nil, errors.EmailAccountNotFound(address)
+	}
+	return account, nil
+}
+
+// store saves a message with its attachments, charges its size to the quotas
+// and publishes eventType. On failure the attachment blobs are released.
+func (s *MessageService) store(ctx context.Context, account *domain.EmailAccount, message *domain.Message, eventType string) error {
+	err := withinTransaction(ctx, s.transactor, func(ctx context.Context) error {
+		for i := range message.Attachments {
+			if err := s.attachmentRepo.Create(ctx, &message.Attachments[i]); err != nil {
+				return errors.InternalError(err)
+			}
+		}
+
+		// Save message
+		if err := s.messageRepo.Create(ctx, message); err != nil {
+			return errors.InternalError(err)
+		}
+
+		// Update quotas
+		if err := s.updateQuotas(ctx, account.UserID, account.DomainID, message.Size); err != nil {
+			return err
+		}
+
+		// Publish event
+		event := domain.NewBaseEvent(uuid.New().String(), message.ID, eventType, message)
+		if err := s.eventPub.Publish(ctx, event); err != nil {
+			return errors.InternalError(err)
+		}
+		return nil
+	})
+	if err != nil {
+		// Release the stored blobs so they can be collected
+		releaseAttachmentBlobs(ctx, s.blobs, message.Attachments)
+		return err
+	}
+	return nil
 }
 
 // GetMessage retrieves a message by ID
@@ -222,6 +586,55 @@ func (s *MessageService) MarkAsRead(ctx context.Context, id string) error {
 	return nil
 }
 
+// MoveMessage moves a message to another folder of the same account
+func (s *MessageService) MoveMessage(ctx context.Context, id, folderID string) (*domain.Message, error) {
+	if s.folderRepo == nil {
+		return nil, errors.InternalError(fmt.Errorf("message service has no folder repository"))
+	}
+
+	message, err := s.messageRepo.GetByID(ctx, id)
+	if err != nil {
+		return nil, errors.InternalError(err)
+	}
+	if message == nil {
+		return nil, errors.MessageNotFound(id)
+	}
+
+	dest, err := s.folderRepo.GetByID(ctx, folderID)
+	if err != nil {
+		return nil, errors.InternalError(err)
+	}
+	if dest == nil || dest.AccountID != message.AccountID {
+		return nil, errors.FolderNotFound(folderID)
+	}
+	if message.FolderID == dest.ID {
+		return message, nil
+	}
+
+	var source *domain.Folder
+	if message.FolderID != "" {
+		source, err = s.folderRepo.GetByID(ctx, message.FolderID)
+		if err != nil {
+			return nil, errors.InternalError(err)
+		}
+	}
+
+	message.FolderID = dest.ID
+	message.UpdatedAt = time.Now()
+
+	if err := s.messageRepo.Update(ctx, message); err != nil {
+		return nil, errors.InternalError(err)
+	}
+
+	if s.spamTrainer != nil {
+		if err := s.spamTrainer.TrainFromMove(ctx, message, source, dest); err != nil {
+			// Log error but don't fail the operation
+		}
+	}
+
+	return message, nil
+}
+
 // DeleteMessage deletes a message
 func (s *MessageService) DeleteMessage(ctx context.Context, id string) error {
 	message, err := s.messageRepo.GetByID(ctx, id)
@@ -299,6 +712,136 @@ func (s *MessageService) updateQuotas(ctx context.Context, userID, domainID stri
 	return nil
 }
 
+// addAttachments checks the requested attachments against the limits and
+// adds them to a message, storing their content in the blob store when one
+// is configured
+func (s *MessageService) addAttachments(ctx context.Context, account *domain.EmailAccount, message *domain.Message, requests []AttachmentRequest) error {
+	if len(requests) > s.config.MaxAttachments {
+		return errors.NewError(errors.ErrCodeValidationError, "Too many attachments").
+			WithDetail("max_attachments", s.config.MaxAttachments).
+			WithDetail("actual_attachments", len(requests))
+	}
+
+	for _, att := range requests {
+		if att.Size > s.config.MaxAttachmentSize {
+			return errors.NewError(errors.ErrCodeMessageTooLarge, "Attachment too large").
+				WithDetail("max_size", s.config.MaxAttachmentSize).
+				WithDetail("actual_size", att.Size)
+		}
+		if att.BlobID != "" && s.blobs == nil {
+			return errors.NewError(errors.ErrCodeValidationError, "Attachments cannot reference stored content").
+				WithDetail("filename", att.Filename)
+		}
+
+		content := att.Content
+		if att.Reader != nil && s.blobs == nil {
+			// Without a blob store the content is kept with the message
+			var err error
+			if content, err = readAttachment(att); err != nil {
+				return err
+			}
+		}
+		message.Attachments = append(message.Attachments, domain.Attachment{
+			ID:          uuid.New().String(),
+			MessageID:   message.ID,
+			Filename:    att.Filename,
+			ContentType: att.ContentType,
+			Size:        att.Size,
+			Content:     content,
+			BlobID:      att.BlobID,
+		})
+	}
+
+	// Identical attachments sent by many messages are stored once.
+	// Uploads are streamed to the store before they are inspected.
+	if s.blobs != nil {
+		return s.storeAttachments(ctx, account.DomainID, message, requests)
+	}
+	return nil
+}
+
+// storeAttachments moves the content of the attachments requested by
+// requests to the blob store; the attachments then only reference their
+// blob. Attachments that already reference a blob, such as those of a
+// forwarded message, share it.
+func (s *MessageService) storeAttachments(ctx context.Context, tenantID string, message *domain.Message, requests []AttachmentRequest) error {
+	for i := range message.Attachments {
+		att := &message.Attachments[i]
+		blob, err := putAttachment(ctx, s.blobs, tenantID, requests[i], s.config.MaxAttachmentSize)
+		if err != nil {
+			// Release the blobs already stored so they can be collected
+			releaseAttachmentBlobs(ctx, s.blobs, message.Attachments[:i])
+			return err
+		}
+		att.BlobID = blob.ID
+		att.Checksum = blob.SHA256
+		att.Size = blob.Size
+		att.Content = nil
+	}
+	return nil
+}
+
+// putAttachment streams the content of an attachment into the blob store of
+// a tenant, or references the stored blob it names. Streamed content is cut
+// after its declared size so an upload cannot grow past the size the
+// message was checked with.
+func putAttachment(ctx context.Context, blobs BlobStore, tenantID string, req AttachmentRequest, maxSize int64) (*domain.Blob, error) {
+	if req.BlobID != "" {
+		return blobs.Retain(ctx, tenantID, req.BlobID)
+	}
+
+	var content io.Reader = bytes.NewReader(req.Content)
+	limit := maxSize
+	if req.Reader != nil {
+		content, limit = req.Reader, req.Size
+	}
+	blob, err := blobs.Put(ctx, tenantID, io.LimitReader(content, limit+1))
+	if err != nil {
+		return nil, err
+	}
+	if blob.Size > limit {
+		if err := blobs.Release(ctx, blob.ID); err != nil {
+			// Log error but don't fail the operation
+		}
+		return nil, attachmentSizeMismatch(req)
+	}
+	return blob, nil
+}
+
+// readAttachment reads streamed content when it cannot go to a blob store
+func readAttachment(req AttachmentRequest) ([]byte, error) {
+	content, err := io.ReadAll(io.LimitReader(req.Reader, req.Size+1))
+	if err != nil {
+		return nil, errors.InternalError(err)
+	}
+	if int64(len(content)) > req.Size {
+		return nil, attachmentSizeMismatch(req)
+	}
+	return content, nil
+}
+
+func attachmentSizeMismatch(req AttachmentRequest) error {
+	return errors.NewError(errors.ErrCodeValidationError, "Attachment is larger than its declared size").
+		WithDetail("filename", req.Filename).
+		WithDetail("declared_size", req.Size)
+}
+
+// releaseAttachmentBlobs drops the references held by attachments so their
+// blobs can be collected
+func releaseAttachmentBlobs(ctx context.Context, blobs BlobStore, attachments []domain.Attachment) {
+	if blobs == nil {
+		return
+	}
+	for _, attachment := range attachments {
+		if attachment.BlobID == "" {
+			continue
+		}
+		if err := blobs.Release(ctx, attachment.BlobID); err != nil {
+			// Log error but don't fail the operation
+		}
+	}
+}
+
 // calculateMessageSize calculates the total message size
 func (s *MessageService) calculateMessageSize(req SendMessageRequest) int64 {
 	size := int64(0)
@@ -331,6 +874,65 @@ func (s *MessageService) calculateMessageSize(req SendMessageRequest) int64 {
 	return size
 }
 
+// outgoingHeaders returns the Message-ID of a new message, at the domain
+// of the sending account, its Reply-To, its Sender when it is sent on behalf
+// of another mailbox, and the headers that link it to its conversation
+func outgoingHeaders(accountEmail string, req SendMessageRequest, sender string) map[string]string {
+	host := "localhost"
+	if at := strings.LastIndex(accountEmail, "@"); at >= 0 && at < len(accountEmail)-1 {
+		host = accountEmail[at+1:]
+	}
+	headers := map[string]string{
+		"Message-ID": "<" + uuid.New().String() + "@" + host + ">",
+	}
+	if req.ReplyTo != "" {
+		headers["Reply-To"] = req.ReplyTo
+	}
+	if sender != "" {
+		headers["Sender"] = sender
+	}
+
+	references := []string{}
+	for _, id := range req.References {
+		references = append(references, domain.ParseMessageIDs(id)...)
+	}
+	if replyTo := domain.ParseMessageIDs(req.InReplyTo); len(replyTo) > 0 {
+		headers["In-Reply-To"] = "<" + replyTo[0] + ">"
+		if len(references) == 0 || references[len(references)-1] != replyTo[0] {
+			references = append(references, replyTo[0])
+		}
+	}
+	if len(references) > 0 {
+		headers["References"] = "<" + strings.Join(references, "> <") + ">"
+	}
+	return headers
+}
+
+// authorizeSender returns who a message of the account is sent as. Without
+// an authorizer, only the account address is allowed.
+func (s *MessageService) authorizeSender(ctx context.Context, account *domain.EmailAccount, from string) (*SenderProfile, error) {
+	if s.senders != nil {
+		return s.senders.AuthorizeSender(ctx, account, from)
+	}
+	if from == "" {
+		return accountSender(account), nil
+	}
+	address, err := mail.ParseAddress(from)
+	if err != nil || !strings.EqualFold(address.Address, account.Email) {
+		return nil, errors.SenderNotAllowed(from)
+	}
+	return &SenderProfile{From: address.String()}, nil
+}
+
+// accountSender returns the account address with its display name
+func accountSender(account *domain.EmailAccount) *SenderProfile {
+	address := mail.Address{Address: account.Email}
+	if account.DisplayName != nil {
+		address.Name = *account.DisplayName
+	}
+	return &SenderProfile{From: address.String()}
+}
+
 // SendMessageRequest represents the request to send a message
 type SendMessageRequest struct {
 	AccountID   string
@@ -342,6 +944,32 @@ type SendMessageRequest struct {
 	BodyText    *string
 	BodyHTML    *string
 	Attachments []AttachmentRequest
+	ReplyTo     string   // address replies go to, if not From
+	InReplyTo   string   // Message-ID of the message replied to, if any
+	References  []string // Message-IDs of the conversation, oldest first
+}
+
+// ReceiveMessageRequest represents an inbound message delivered to one
+// local account. Attachments may reference blobs already stored for the
+// account's domain.
+type ReceiveMessageRequest struct {
+	AccountID   string
+	From        string
+	To          []string
+	Cc          []string
+	Subject     string
+	BodyText    *string
+	BodyHTML    *string
+	Headers     map[string]string // headers as received, including Authentication-Results
+	Attachments []AttachmentRequest
+	Raw         []byte // message as received, used for its size when set
+}
+
+// ReceiveResult is the outcome of message intake: the stored message, or
+// the quarantine entry holding it back
+type ReceiveResult struct {
+	Message    *domain.Message
+	Quarantine *domain.QuarantineEntry
 }
 
 // AttachmentRequest represents an attachment request
@@ -350,4 +978,6 @@ type AttachmentRequest struct {
 	ContentType string
 	Size        int64
 	Content     []byte
+	Reader      io.Reader // content streamed to the blob store instead of Content; Size is required
+	BlobID      string    // stored content to attach instead of Content
 }
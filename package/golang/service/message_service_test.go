@@ -0,0 +1,418 @@
+package service
+
+import (
+	"context"
+	stderrors "errors"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/skygenesisenterprise/aether-mailer/package/golang/domain"
+	"github.com/skygenesisenterprise/aether-mailer/package/golang/errors"
+	"github.com/skygenesisenterprise/aether-mailer/package/golang/repository"
+	"github.com/skygenesisenterprise/aether-mailer/package/golang/repository/inmemory"
+	"github.com/skygenesisenterprise/aether-mailer/package/golang/service/clamdtest"
+)
+
+func TestMessageServiceSendMessage(t *testing.T) {
+	ctx := context.Background()
+	mail := newTestMail(t)
+	account := mail.newAccount(t, mail.newUser(t, "alice"), "alice")
+	messages := NewMessageService(mail.messageDeps())
+
+	message, err := messages.SendMessage(ctx, SendMessageRequest{
+		AccountID: account.ID,
+		From:      account.Email,
+		To:        []string{"bob@example.net"},
+		Subject:   "Hello",
+		BodyText:  stringPtr("Hello Bob"),
+	})
+	if err != nil {
+		t.Fatalf("SendMessage: %v", err)
+	}
+	if sent := mail.folder(t, account, domain.FolderTypeSent); message.FolderID != sent.ID {
+		t.Errorf("FolderID = %q, want the Sent folder %q", message.FolderID, sent.ID)
+	}
+	if events := mail.events.EventsOfType(domain.EventTypeMessageSent); len(events) != 1 {
+		t.Errorf("published %d %s events, want 1", len(events), domain.EventTypeMessageSent)
+	}
+}
+
+func TestMessageServiceMoveMessage(t *testing.T) {
+	ctx := context.Background()
+	mail := newTestMail(t)
+	account := mail.newAccount(t, mail.newUser(t, "alice"), "alice")
+	other := mail.newAccount(t, mail.newUser(t, "carol"), "carol")
+
+	message, err := NewMessageService(mail.messageDeps()).SendMessage(ctx, SendMessageRequest{
+		AccountID: account.ID,
+		From:      account.Email,
+		To:        []string{"bob@example.net"},
+		Subject:   "Hello",
+		BodyText:  stringPtr("Hello Bob"),
+	})
+	if err != nil {
+		t.Fatalf("SendMessage: %v", err)
+	}
+
+	withoutFolders := mail.messageDeps()
+	withoutFolders.Folders = nil
+
+	tests := []struct {
+		name     string
+		deps     MessageServiceDeps
+		folderID string
+		wantErr  bool
+	}{
+		{name: "to trash", deps: mail.messageDeps(), folderID: mail.folder(t, account, domain.FolderTypeTrash).ID},
+		{name: "folder of another account", deps: mail.messageDeps(), folderID: mail.folder(t, other, domain.FolderTypeInbox).ID, wantErr: true},
+		{name: "unknown folder", deps: mail.messageDeps(), folderID: "missing", wantErr: true},
+		{name: "no folder repository", deps: withoutFolders, folderID: mail.folder(t, account, domain.FolderTypeInbox).ID, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			moved, err := NewMessageService(tt.deps).MoveMessage(ctx, message.ID, tt.folderID)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("MoveMessage succeeded, want an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("MoveMessage: %v", err)
+			}
+			if moved.FolderID != tt.folderID {
+				t.Errorf("FolderID = %q, want %q", moved.FolderID, tt.folderID)
+			}
+		})
+	}
+}
+
+func TestMessageServiceReceiveMessage(t *testing.T) {
+	ctx := context.Background()
+	mail := newTestMail(t)
+	account := mail.newAccount(t, mail.newUser(t, "alice"), "alice")
+
+	spam := NewSpamService(inmemory.NewSpamRepository(mail.store), mail.messages, mail.folders, mail.accounts, mail.policies,
+		mail.events, &SpamConfig{EnableSpamFilter: true, SpamThreshold: 0.5, HeuristicMidpoint: 3})
+	deps := mail.messageDeps()
+	deps.SpamFilter = spam
+	messages := NewMessageService(deps)
+
+	tests := []struct {
+		name       string
+		req        ReceiveMessageRequest
+		wantFolder domain.FolderType
+		wantFlag   bool
+	}{
+		{
+			name: "ham",
+			req: ReceiveMessageRequest{
+				From:    "Bob <bob@example.net>",
+				To:      []string{account.Email},
+				Subject: "Lunch tomorrow",
+				Headers: map[string]string{
+					"Date":                   "Mon, 12 Oct 2026 09:00:00 +0000",
+					"Message-ID":             "<lunch@example.net>",
+					"Authentication-Results": "mx.example.com; spf=pass dkim=pass dmarc=pass",
+				},
+				BodyText: stringPtr("Shall we meet at noon?"),
+			},
+			wantFolder: domain.FolderTypeInbox,
+		},
+		{
+			name: "spam",
+			req: ReceiveMessageRequest{
+				From:    "Prize <prize@example.top>",
+				To:      []string{account.Email},
+				Subject: "WINNER!!! ACT NOW",
+				Headers: map[string]string{
+					"Authentication-Results": "mx.example.com; spf=fail dkim=fail dmarc=fail",
+				},
+				BodyText: stringPtr("Click here: http://192.0.2.1/claim"),
+			},
+			wantFolder: domain.FolderTypeSpam,
+			wantFlag:   true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.req.AccountID = account.ID
+			result, err := messages.ReceiveMessage(ctx, tt.req)
+			if err != nil {
+				t.Fatalf("ReceiveMessage: %v", err)
+			}
+
+			stored, err := mail.messages.GetByID(ctx, result.Message.ID)
+			if err != nil || stored == nil {
+				t.Fatalf("message not stored: %v", err)
+			}
+			if want := mail.folder(t, account, tt.wantFolder); stored.FolderID != want.ID {
+				t.Errorf("FolderID = %q, want the %s folder", stored.FolderID, tt.wantFolder)
+			}
+			if stored.Headers[HeaderSpamScore] == "" {
+				t.Errorf("missing %s header", HeaderSpamScore)
+			}
+			if _, flagged := stored.Headers[HeaderSpamFlag]; flagged != tt.wantFlag {
+				t.Errorf("%s set = %v, want %v", HeaderSpamFlag, flagged, tt.wantFlag)
+			}
+		})
+	}
+
+	if events := mail.events.EventsOfType(domain.EventTypeMessageReceived); len(events) != len(tests) {
+		t.Errorf("published %d %s events, want %d", len(events), domain.EventTypeMessageReceived, len(tests))
+	}
+}
+
+func TestMessageServiceReceiveMessageVirus(t *testing.T) {
+	ctx := context.Background()
+	daemon := clamdtest.NewServer()
+	defer daemon.Close()
+
+	tests := []struct {
+		name           string
+		content        string
+		blockPolicy    bool
+		noQuarantine   bool
+		wantStored     bool
+		wantQuarantine bool
+		wantErrCode    errors.ErrorCode
+	}{
+		{name: "clean", content: "quarterly figures", wantStored: true},
+		{name: "infected", content: clamdtest.EICAR, wantQuarantine: true},
+		{name: "infected under a block policy", content: clamdtest.EICAR, blockPolicy: true, wantErrCode: errors.ErrCodeVirusDetected},
+		{name: "infected without quarantine", content: clamdtest.EICAR, noQuarantine: true, wantErrCode: errors.ErrCodeVirusDetected},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mail := newTestMail(t)
+			account := mail.newAccount(t, mail.newUser(t, "alice"), "alice")
+			if tt.blockPolicy {
+				if err := mail.policies.Create(ctx, &domain.Policy{
+					ID:       uuid.NewString(),
+					DomainID: &mail.domain.ID,
+					Name:     "Reject malware",
+					Type:     domain.PolicyTypeVirus,
+					Action:   domain.PolicyActionBlock,
+					IsActive: true,
+				}); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			quarantineRepo := inmemory.NewQuarantineRepository(mail.store)
+			deps := mail.messageDeps()
+			deps.Antivirus = NewAntivirusService(NewClamdScanner(daemon.Network, daemon.Addr, 5*time.Second, 0), nil,
+				mail.accounts, mail.policies, mail.events, &AntivirusConfig{EnableVirusScan: true})
+			if !tt.noQuarantine {
+				deps.Quarantine = NewQuarantineService(quarantineRepo, mail.messages, mail.accounts, mail.folders, mail.policies,
+					nil, nil, nil, mail.events, &QuarantineConfig{Retention: time.Hour})
+			}
+
+			result, err := NewMessageService(deps).ReceiveMessage(ctx, ReceiveMessageRequest{
+				AccountID: account.ID,
+				From:      "bob@example.net",
+				To:        []string{account.Email},
+				Subject:   "Report",
+				BodyText:  stringPtr("See attached"),
+				Attachments: []AttachmentRequest{
+					{Filename: "report.txt", ContentType: "text/plain", Size: int64(len(tt.content)), Content: []byte(tt.content)},
+				},
+			})
+			if tt.wantErrCode != "" {
+				var mailErr *errors.Error
+				if !stderrors.As(err, &mailErr) || mailErr.Code != tt.wantErrCode {
+					t.Fatalf("ReceiveMessage error = %v, want %s", err, tt.wantErrCode)
+				}
+			} else if err != nil {
+				t.Fatalf("ReceiveMessage: %v", err)
+			} else if (result.Message != nil) != tt.wantStored || (result.Quarantine != nil) != tt.wantQuarantine {
+				t.Fatalf("ReceiveMessage stored %v quarantined %v, want %v %v",
+					result.Message != nil, result.Quarantine != nil, tt.wantStored, tt.wantQuarantine)
+			}
+
+			stored, err := mail.messages.CountByAccount(ctx, account.ID, repository.MessageFilter{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if (stored == 1) != tt.wantStored {
+				t.Errorf("account holds %d messages, want stored %v", stored, tt.wantStored)
+			}
+			held, err := quarantineRepo.Count(ctx, repository.QuarantineFilter{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if (held == 1) != tt.wantQuarantine {
+				t.Errorf("quarantine holds %d entries, want quarantined %v", held, tt.wantQuarantine)
+			}
+		})
+	}
+}
+
+func TestMessageServiceSendMessageVirus(t *testing.T) {
+	ctx := context.Background()
+	daemon := clamdtest.NewServer()
+	defer daemon.Close()
+
+	mail := newTestMail(t)
+	account := mail.newAccount(t, mail.newUser(t, "alice"), "alice")
+	deps := mail.messageDeps()
+	deps.Antivirus = NewAntivirusService(NewClamdScanner(daemon.Network, daemon.Addr, 5*time.Second, 0), nil,
+		mail.accounts, mail.policies, mail.events, &AntivirusConfig{EnableVirusScan: true})
+
+	_, err := NewMessageService(deps).SendMessage(ctx, SendMessageRequest{
+		AccountID: account.ID,
+		From:      account.Email,
+		To:        []string{"bob@example.net"},
+		Subject:   "Tool",
+		Attachments: []AttachmentRequest{
+			{Filename: "tool.txt", ContentType: "text/plain", Size: int64(len(clamdtest.EICAR)), Content: []byte(clamdtest.EICAR)},
+		},
+	})
+	var mailErr *errors.Error
+	if !stderrors.As(err, &mailErr) || mailErr.Code != errors.ErrCodeVirusDetected {
+		t.Fatalf("SendMessage error = %v, want %s", err, errors.ErrCodeVirusDetected)
+	}
+	if daemon.Scans() != 1 {
+		t.Errorf("daemon served %d scans, want 1", daemon.Scans())
+	}
+}
+
+func TestMessageServiceReceiveMessageAttachmentPolicy(t *testing.T) {
+	ctx := context.Background()
+
+	tests := []struct {
+		name           string
+		filename       string
+		content        string
+		blockedAction  domain.PolicyAction
+		wantStored     bool
+		wantQuarantine bool
+		wantErr        bool
+	}{
+		{name: "document", filename: "notes.txt", content: "quarterly figures", blockedAction: domain.PolicyActionBlock, wantStored: true},
+		{name: "blocked executable", filename: "setup.exe", content: "MZ\x90\x00", blockedAction: domain.PolicyActionBlock, wantErr: true},
+		{name: "quarantined executable", filename: "setup.exe", content: "MZ\x90\x00", blockedAction: domain.PolicyActionQuarantine, wantQuarantine: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mail := newTestMail(t)
+			account := mail.newAccount(t, mail.newUser(t, "alice"), "alice")
+
+			quarantineRepo := inmemory.NewQuarantineRepository(mail.store)
+			deps := mail.messageDeps()
+			deps.Checker = NewAttachmentPolicyService(nil, mail.events, &AttachmentPolicyConfig{
+				EnableAttachmentPolicy: true,
+				BlockedAction:          tt.blockedAction,
+				MismatchAction:         domain.PolicyActionTag,
+			})
+			deps.Quarantine = NewQuarantineService(quarantineRepo, mail.messages, mail.accounts, mail.folders, mail.policies,
+				nil, nil, nil, mail.events, &QuarantineConfig{Retention: time.Hour})
+
+			result, err := NewMessageService(deps).ReceiveMessage(ctx, ReceiveMessageRequest{
+				AccountID: account.ID,
+				From:      "bob@example.net",
+				To:        []string{account.Email},
+				Subject:   "Files",
+				Attachments: []AttachmentRequest{
+					{Filename: tt.filename, ContentType: "application/octet-stream", Size: int64(len(tt.content)), Content: []byte(tt.content)},
+				},
+			})
+			if tt.wantErr {
+				var mailErr *errors.Error
+				if !stderrors.As(err, &mailErr) || mailErr.Code != errors.ErrCodePolicyViolation {
+					t.Fatalf("ReceiveMessage error = %v, want %s", err, errors.ErrCodePolicyViolation)
+				}
+			} else if err != nil {
+				t.Fatalf("ReceiveMessage: %v", err)
+			} else if (result.Message != nil) != tt.wantStored || (result.Quarantine != nil) != tt.wantQuarantine {
+				t.Fatalf("ReceiveMessage stored %v quarantined %v, want %v %v",
+					result.Message != nil, result.Quarantine != nil, tt.wantStored, tt.wantQuarantine)
+			}
+			if tt.wantQuarantine && result.Quarantine.Reason != domain.QuarantineReasonAttachment {
+				t.Errorf("quarantine reason = %s, want %s", result.Quarantine.Reason, domain.QuarantineReasonAttachment)
+			}
+
+			held, err := quarantineRepo.Count(ctx, repository.QuarantineFilter{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if (held == 1) != tt.wantQuarantine {
+				t.Errorf("quarantine holds %d entries, want quarantined %v", held, tt.wantQuarantine)
+			}
+		})
+	}
+}
+
+func TestMessageServiceSendMessageRateLimits(t *testing.T) {
+	ctx := context.Background()
+
+	tests := []struct {
+		name     string
+		filename string
+		wantSent bool
+	}{
+		{name: "sent", filename: "notes.txt", wantSent: true},
+		{name: "rejected after the check", filename: "setup.exe"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mail := newTestMail(t)
+			account := mail.newAccount(t, mail.newUser(t, "alice"), "alice")
+
+			counters := inmemory.NewRateCounterStore(mail.store)
+			deps := mail.messageDeps()
+			deps.Domains = mail.domains
+			deps.RateLimiter = NewRateLimitService(counters, inmemory.NewSendingSuspensionRepository(mail.store), nil, mail.events,
+				&RateLimitConfig{EnableRateLimits: true, Limits: []domain.RateLimit{
+					{Scope: domain.RateScopeAccount, Window: time.Minute, MaxMessages: 10, MaxRecipients: 10},
+					{Scope: domain.RateScopeTenant, Window: time.Minute, MaxMessages: 10, MaxRecipients: 10},
+				}})
+			deps.Checker = NewAttachmentPolicyService(nil, mail.events, &AttachmentPolicyConfig{
+				EnableAttachmentPolicy: true,
+				BlockedAction:          domain.PolicyActionBlock,
+				MismatchAction:         domain.PolicyActionTag,
+			})
+
+			_, err := NewMessageService(deps).SendMessage(ctx, SendMessageRequest{
+				AccountID: account.ID,
+				From:      account.Email,
+				To:        []string{"bob@example.net", "carol@example.net"},
+				Subject:   "Files",
+				Attachments: []AttachmentRequest{
+					{Filename: tt.filename, ContentType: "text/plain", Size: 5, Content: []byte("hello")},
+				},
+			})
+			if (err == nil) != tt.wantSent {
+				t.Fatalf("SendMessage error = %v, want sent %v", err, tt.wantSent)
+			}
+
+			want := map[string]int64{"msgs": 0, "rcpts": 0}
+			if tt.wantSent {
+				want = map[string]int64{"msgs": 1, "rcpts": 2}
+			}
+			for _, scope := range []struct {
+				scope domain.RateScope
+				key   string
+			}{
+				{domain.RateScopeAccount, account.ID},
+				{domain.RateScopeTenant, mail.domain.OwnerID},
+			} {
+				for kind, count := range want {
+					got, err := counters.Sum(ctx, counterKey(kind, scope.scope, scope.key, time.Minute), time.Now(), time.Minute)
+					if err != nil {
+						t.Fatal(err)
+					}
+					if got != count {
+						t.Errorf("%s %s counter = %d, want %d", scope.scope, kind, got, count)
+					}
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,63 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"mime"
+	"net"
+	"net/smtp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SMTPAdminNotifier emails operational alerts to a fixed list of administrators
+type SMTPAdminNotifier struct {
+	Host       string
+	Port       int
+	Username   string
+	Password   string
+	From       string
+	Recipients []string
+}
+
+// NewSMTPAdminNotifier creates a notifier that sends through the given relay
+func NewSMTPAdminNotifier(host string, port int, username, password, from string, recipients []string) *SMTPAdminNotifier {
+	return &SMTPAdminNotifier{
+		Host:       host,
+		Port:       port,
+		Username:   username,
+		Password:   password,
+		From:       from,
+		Recipients: recipients,
+	}
+}
+
+// NotifyAdmins sends a plain text alert to every configured administrator
+func (n *SMTPAdminNotifier) NotifyAdmins(ctx context.Context, subject, body string) error {
+	if len(n.Recipients) == 0 {
+		return nil
+	}
+
+	var msg bytes.Buffer
+	fmt.Fprintf(&msg, "From: %s\r\n", n.From)
+	fmt.Fprintf(&msg, "To: %s\r\n", strings.Join(n.Recipients, ", "))
+	fmt.Fprintf(&msg, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", subject))
+	fmt.Fprintf(&msg, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	fmt.Fprintf(&msg, "MIME-Version: 1.0\r\n")
+	fmt.Fprintf(&msg, "Auto-Submitted: auto-generated\r\n")
+	fmt.Fprintf(&msg, "Content-Type: text/plain; charset=utf-8\r\n\r\n")
+	msg.WriteString(strings.ReplaceAll(body, "\n", "\r\n"))
+
+	var auth smtp.Auth
+	if n.Username != "" {
+		auth = smtp.PlainAuth("", n.Username, n.Password, n.Host)
+	}
+
+	addr := net.JoinHostPort(n.Host, strconv.Itoa(n.Port))
+	if err := smtp.SendMail(addr, auth, n.From, n.Recipients, msg.Bytes()); err != nil {
+		return fmt.Errorf("notifier: send alert: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,104 @@
+package service
+
+import (
+	"context"
+
+	"github.com/skygenesisenterprise/aether-mailer/package/golang/domain"
+	"github.com/skygenesisenterprise/aether-mailer/package/golang/errors"
+	"github.com/skygenesisenterprise/aether-mailer/package/golang/repository"
+)
+
+// MessageQueue accepts rendered messages for delivery. *DeliveryService
+// satisfies it.
+type MessageQueue interface {
+	Enqueue(ctx context.Context, req EnqueueRequest) ([]*domain.DeliveryJob, error)
+}
+
+// OutboundQueue hands sent messages to the delivery queue. It subscribes to
+// the outbox, so a message is queued once the transaction storing it
+// commits.
+type OutboundQueue struct {
+	messageRepo repository.MessageRepository
+	accountRepo repository.EmailAccountRepository
+	domainRepo  repository.DomainRepository
+	blobs       BlobReader
+	queue       MessageQueue
+}
+
+// NewOutboundQueue creates a new outbound queue subscriber
+func NewOutboundQueue(
+	messageRepo repository.MessageRepository,
+	accountRepo repository.EmailAccountRepository,
+	domainRepo repository.DomainRepository,
+	blobs BlobReader,
+	queue MessageQueue,
+) *OutboundQueue {
+	return &OutboundQueue{
+		messageRepo: messageRepo,
+		accountRepo: accountRepo,
+		domainRepo:  domainRepo,
+		blobs:       blobs,
+		queue:       queue,
+	}
+}
+
+// CanHandle reports whether an event sends a message
+func (q *OutboundQueue) CanHandle(eventType string) bool {
+	return eventType == domain.EventTypeMessageSent
+}
+
+// Handle renders the message of an event and queues it for its To, Cc and
+// Bcc recipients. The queue holds one job per message and recipient domain,
+// so an event delivered twice is harmless.
+func (q *OutboundQueue) Handle(ctx context.Context, event domain.Event) error {
+	messageID, err := eventMessageID(event)
+	if err != nil {
+		return errors.InternalError(err)
+	}
+
+	message, err := q.messageRepo.GetByID(ctx, messageID)
+	if err != nil {
+		return errors.InternalError(err)
+	}
+	if message == nil {
+		// Deleted before it was queued
+		return nil
+	}
+	account, err := q.accountRepo.GetByID(ctx, message.AccountID)
+	if err != nil {
+		return errors.InternalError(err)
+	}
+	if account == nil {
+		return nil
+	}
+
+	tenantID := ""
+	d, err := q.domainRepo.GetByID(ctx, account.DomainID)
+	if err != nil {
+		return errors.InternalError(err)
+	}
+	if d != nil {
+		tenantID = d.OwnerID
+	}
+
+	data, err := renderMessage(ctx, message, q.blobs)
+	if err != nil {
+		return errors.InternalError(err)
+	}
+
+	recipients := make([]string, 0, len(message.To)+len(message.Cc)+len(message.Bcc))
+	recipients = append(recipients, message.To...)
+	recipients = append(recipients, message.Cc...)
+	recipients = append(recipients, message.Bcc...)
+
+	_, err = q.queue.Enqueue(ctx, EnqueueRequest{
+		MessageID:  message.ID,
+		AccountID:  account.ID,
+		DomainID:   account.DomainID,
+		TenantID:   tenantID,
+		From:       extractAddress(message.From),
+		Recipients: recipients,
+		Data:       data,
+	})
+	return err
+}
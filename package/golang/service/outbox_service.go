@@ -0,0 +1,250 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	stderrors "errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/skygenesisenterprise/aether-mailer/package/golang/domain"
+	"github.com/skygenesisenterprise/aether-mailer/package/golang/errors"
+	"github.com/skygenesisenterprise/aether-mailer/package/golang/repository"
+)
+
+// OutboxPublisher publishes events by writing them to the outbox. Called
+// with the context of a repository.Transactor, the events commit or roll
+// back with the state change that raised them; an OutboxRelay delivers
+// them to the handlers afterwards.
+type OutboxPublisher struct {
+	outboxRepo repository.OutboxRepository
+}
+
+// NewOutboxPublisher creates a publisher writing to the given outbox
+func NewOutboxPublisher(outboxRepo repository.OutboxRepository) *OutboxPublisher {
+	return &OutboxPublisher{outboxRepo: outboxRepo}
+}
+
+// Publish writes an event to the outbox
+func (p *OutboxPublisher) Publish(ctx context.Context, event domain.Event) error {
+	return p.PublishBatch(ctx, []domain.Event{event})
+}
+
+// PublishBatch writes events to the outbox. Their data is stored as JSON.
+func (p *OutboxPublisher) PublishBatch(ctx context.Context, events []domain.Event) error {
+	now := time.Now()
+	entries := make([]*domain.OutboxEntry, 0, len(events))
+	for _, event := range events {
+		payload, err := json.Marshal(event.Data())
+		if err != nil {
+			return errors.InternalError(fmt.Errorf("failed to encode event %s: %w", event.ID(), err))
+		}
+		entries = append(entries, &domain.OutboxEntry{
+			ID:            event.ID(),
+			AggregateID:   event.AggregateID(),
+			EventType:     event.EventType(),
+			Payload:       payload,
+			OccurredAt:    event.OccurredAt(),
+			Status:        domain.OutboxStatusPending,
+			NextAttemptAt: now,
+			CreatedAt:     now,
+		})
+	}
+	if err := p.outboxRepo.Add(ctx, entries); err != nil {
+		return errors.InternalError(err)
+	}
+	return nil
+}
+
+// OutboxConfig defines how the relay delivers outbox entries
+type OutboxConfig struct {
+	PollInterval  time.Duration // 1 second when zero
+	BatchSize     int           // entries claimed per poll, 100 when zero
+	MaxAttempts   int           // attempts before an entry is dead-lettered, 10 when zero
+	RetryDelay    time.Duration // first retry delay, doubled on every attempt
+	MaxRetryDelay time.Duration
+	Lease         time.Duration // how long a claimed entry is reserved for one relay, 1 minute when zero
+	Retention     time.Duration // delivered entries are removed after this, 0 keeps them
+}
+
+// OutboxRelay delivers outbox entries to the subscribed handlers at least
+// once. A failed delivery is retried with exponential backoff and the
+// entry is dead-lettered after MaxAttempts; handlers must therefore be
+// idempotent. Several relays may share one outbox.
+type OutboxRelay struct {
+	outboxRepo repository.OutboxRepository
+	config     OutboxConfig
+
+	mu       sync.RWMutex
+	handlers []domain.EventHandler
+}
+
+// NewOutboxRelay creates a relay for the given outbox
+func NewOutboxRelay(outboxRepo repository.OutboxRepository, config OutboxConfig) *OutboxRelay {
+	if config.PollInterval <= 0 {
+		config.PollInterval = time.Second
+	}
+	if config.BatchSize <= 0 {
+		config.BatchSize = 100
+	}
+	if config.MaxAttempts <= 0 {
+		config.MaxAttempts = 10
+	}
+	if config.Lease <= 0 {
+		config.Lease = time.Minute
+	}
+	return &OutboxRelay{outboxRepo: outboxRepo, config: config}
+}
+
+// Subscribe registers a handler for the event types it can handle
+func (r *OutboxRelay) Subscribe(handler domain.EventHandler) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.handlers = append(r.handlers, handler)
+}
+
+// ProcessBatch claims the due entries and delivers them. An entry whose
+// outcome cannot be stored does not hold up the rest of the batch. It
+// returns the number of entries claimed and the errors of the failed ones.
+func (r *OutboxRelay) ProcessBatch(ctx context.Context) (int, error) {
+	entries, err := r.outboxRepo.Claim(ctx, time.Now(), r.config.BatchSize, r.config.Lease)
+	if err != nil {
+		return 0, errors.InternalError(err)
+	}
+
+	var errs []error
+	for _, entry := range entries {
+		deliverErr := r.deliver(ctx, entry)
+		now := time.Now()
+		switch {
+		case deliverErr == nil:
+			err = r.outboxRepo.MarkDelivered(ctx, entry.ID, now)
+		case entry.Attempts >= r.config.MaxAttempts:
+			err = r.outboxRepo.MarkDead(ctx, entry.ID, deliverErr.Error())
+		default:
+			err = r.outboxRepo.MarkFailed(ctx, entry.ID, deliverErr.Error(), now.Add(r.retryDelay(entry.Attempts)))
+		}
+		if err != nil {
+			// The lease expires and the entry is delivered again
+			errs = append(errs, err)
+		}
+	}
+	if len(errs) > 0 {
+		return len(entries), errors.InternalError(stderrors.Join(errs...))
+	}
+	return len(entries), nil
+}
+
+// deliver hands an entry to every handler of its type and stops at the
+// first error
+func (r *OutboxRelay) deliver(ctx context.Context, entry *domain.OutboxEntry) error {
+	r.mu.RLock()
+	handlers := make([]domain.EventHandler, len(r.handlers))
+	copy(handlers, r.handlers)
+	r.mu.RUnlock()
+
+	event := entry.Event()
+	for _, handler := range handlers {
+		if !handler.CanHandle(event.EventType()) {
+			continue
+		}
+		if err := handler.Handle(ctx, event); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Prune removes delivered entries older than the retention period
+func (r *OutboxRelay) Prune(ctx context.Context) (int, error) {
+	if r.config.Retention <= 0 {
+		return 0, nil
+	}
+	deleted, err := r.outboxRepo.DeleteDelivered(ctx, time.Now().Add(-r.config.Retention))
+	if err != nil {
+		return 0, errors.InternalError(err)
+	}
+	return deleted, nil
+}
+
+// Run polls the outbox until ctx is cancelled, draining full batches
+// without waiting for the next tick
+func (r *OutboxRelay) Run(ctx context.Context) {
+	ticker := time.NewTicker(r.config.PollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			for ctx.Err() == nil {
+				// Failed entries are delivered again once their lease expires
+				n, err := r.ProcessBatch(ctx)
+				if err != nil || n < r.config.BatchSize {
+					break
+				}
+			}
+			// Entries left by a failed prune are removed on the next tick
+			_, _ = r.Prune(ctx)
+		}
+	}
+}
+
+// ListDeadLetters returns the entries the relay gave up on, newest first
+func (r *OutboxRelay) ListDeadLetters(ctx context.Context, limit, offset int) ([]*domain.OutboxEntry, error) {
+	entries, err := r.outboxRepo.ListDead(ctx, limit, offset)
+	if err != nil {
+		return nil, errors.InternalError(err)
+	}
+	return entries, nil
+}
+
+// Requeue schedules a dead letter for immediate delivery with a fresh
+// attempt count
+func (r *OutboxRelay) Requeue(ctx context.Context, id string) error {
+	requeued, err := r.outboxRepo.Requeue(ctx, id, time.Now())
+	if err != nil {
+		return errors.InternalError(err)
+	}
+	if !requeued {
+		return errors.DeadLetterNotFound(id)
+	}
+	return nil
+}
+
+func (r *OutboxRelay) retryDelay(attempts int) time.Duration {
+	return retryBackoff(r.config.RetryDelay, r.config.MaxRetryDelay, attempts)
+}
+
+// retryBackoff returns the delay before retrying after a number of
+// attempts: delay, 1 second when zero, doubled on every attempt up to max
+func retryBackoff(delay, max time.Duration, attempts int) time.Duration {
+	if delay <= 0 {
+		delay = time.Second
+	}
+	for i := 1; i < attempts; i++ {
+		delay *= 2
+		if max > 0 && delay >= max {
+			return max
+		}
+	}
+	return delay
+}
+
+// withinTransaction runs fn in a transaction when a transactor is set.
+// Errors that are not service errors, such as a failed commit, are
+// reported as internal errors.
+func withinTransaction(ctx context.Context, transactor repository.Transactor, fn func(ctx context.Context) error) error {
+	if transactor == nil {
+		return fn(ctx)
+	}
+	err := transactor.WithinTransaction(ctx, fn)
+	var serviceErr *errors.Error
+	if err != nil && !stderrors.As(err, &serviceErr) {
+		return errors.InternalError(err)
+	}
+	return err
+}
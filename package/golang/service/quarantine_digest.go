@@ -0,0 +1,122 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"html/template"
+	"mime/multipart"
+	"net"
+	"net/smtp"
+	"net/textproto"
+	"strconv"
+	texttemplate "text/template"
+	"time"
+
+	"github.com/skygenesisenterprise/aether-mailer/package/golang/domain"
+)
+
+// SMTPDigestSender delivers quarantine digests through an SMTP relay
+type SMTPDigestSender struct {
+	Host     string
+	Port     int
+	Username string
+	Password string
+	From     string
+}
+
+// NewSMTPDigestSender creates a digest sender for the given relay
+func NewSMTPDigestSender(host string, port int, username, password, from string) *SMTPDigestSender {
+	return &SMTPDigestSender{
+		Host:     host,
+		Port:     port,
+		Username: username,
+		Password: password,
+		From:     from,
+	}
+}
+
+// SendDigest renders the digest as text and HTML and submits it
+func (d *SMTPDigestSender) SendDigest(ctx context.Context, digest *domain.QuarantineDigest) error {
+	body, err := RenderDigest(d.From, digest)
+	if err != nil {
+		return err
+	}
+
+	var auth smtp.Auth
+	if d.Username != "" {
+		auth = smtp.PlainAuth("", d.Username, d.Password, d.Host)
+	}
+
+	addr := net.JoinHostPort(d.Host, strconv.Itoa(d.Port))
+	if err := smtp.SendMail(addr, auth, d.From, []string{digest.Recipient}, body); err != nil {
+		return fmt.Errorf("digest: send to %s: %w", digest.Recipient, err)
+	}
+	return nil
+}
+
+var digestTextTemplate = texttemplate.Must(texttemplate.New("digest").Parse(
+	`{{len .Items}} message(s) were held in quarantine for {{.Recipient}}.
+
+{{range .Items}}From:     {{.Sender}}
+Subject:  {{.Subject}}
+Reason:   {{.Reason}}
+Received: {{.ReceivedAt.Format "2006-01-02 15:04 MST"}}
+Release:  {{.ReleaseURL}}
+
+{{end}}Messages not released are deleted automatically.
+`))
+
+var digestHTMLTemplate = template.Must(template.New("digest").Parse(
+	`<html><body>
+<p>{{len .Items}} message(s) were held in quarantine for {{.Recipient}}.</p>
+<table cellpadding="4">
+<tr><th align="left">From</th><th align="left">Subject</th><th align="left">Reason</th><th align="left">Received</th><th></th></tr>
+{{range .Items}}<tr><td>{{.Sender}}</td><td>{{.Subject}}</td><td>{{.Reason}}</td><td>{{.ReceivedAt.Format "2006-01-02 15:04 MST"}}</td><td><a href="{{.ReleaseURL}}">Release</a></td></tr>
+{{end}}</table>
+<p>Messages not released are deleted automatically.</p>
+</body></html>
+`))
+
+// RenderDigest builds the multipart/alternative digest message
+func RenderDigest(from string, digest *domain.QuarantineDigest) ([]byte, error) {
+	var text, html bytes.Buffer
+	if err := digestTextTemplate.Execute(&text, digest); err != nil {
+		return nil, fmt.Errorf("digest: render text: %w", err)
+	}
+	if err := digestHTMLTemplate.Execute(&html, digest); err != nil {
+		return nil, fmt.Errorf("digest: render html: %w", err)
+	}
+
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+
+	var msg bytes.Buffer
+	fmt.Fprintf(&msg, "From: %s\r\n", from)
+	fmt.Fprintf(&msg, "To: %s\r\n", digest.Recipient)
+	fmt.Fprintf(&msg, "Subject: Quarantine digest: %d message(s) held\r\n", len(digest.Items))
+	fmt.Fprintf(&msg, "Date: %s\r\n", digest.GeneratedAt.Format(time.RFC1123Z))
+	fmt.Fprintf(&msg, "MIME-Version: 1.0\r\n")
+	fmt.Fprintf(&msg, "Auto-Submitted: auto-generated\r\n")
+	fmt.Fprintf(&msg, "Content-Type: multipart/alternative; boundary=%q\r\n\r\n", writer.Boundary())
+
+	for _, part := range []struct {
+		contentType string
+		content     []byte
+	}{
+		{"text/plain; charset=utf-8", text.Bytes()},
+		{"text/html; charset=utf-8", html.Bytes()},
+	} {
+		w, err := writer.CreatePart(textproto.MIMEHeader{"Content-Type": {part.contentType}})
+		if err != nil {
+			return nil, fmt.Errorf("digest: build message: %w", err)
+		}
+		w.Write(part.content)
+	}
+	if err := writer.Close(); err != nil {
+		return nil, fmt.Errorf("digest: build message: %w", err)
+	}
+
+	msg.Write(body.Bytes())
+	return msg.Bytes(), nil
+}
@@ -0,0 +1,588 @@
+package service
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/skygenesisenterprise/aether-mailer/package/golang/domain"
+	"github.com/skygenesisenterprise/aether-mailer/package/golang/errors"
+	"github.com/skygenesisenterprise/aether-mailer/package/golang/repository"
+)
+
+// QuarantineService stores held messages and handles their review and release
+type QuarantineService struct {
+	quarantineRepo repository.QuarantineRepository
+	messageRepo    repository.MessageRepository
+	accountRepo    repository.EmailAccountRepository
+	folderRepo     repository.FolderRepository
+	policyRepo     repository.PolicyRepository
+	transactor     repository.Transactor
+	access         FolderAccess
+	digestSender   DigestSender
+	eventPub       domain.EventPublisher
+	config         *QuarantineConfig
+}
+
+// QuarantineConfig defines quarantine service configuration
+type QuarantineConfig struct {
+	Retention      time.Duration
+	TokenSecret    []byte
+	TokenTTL       time.Duration
+	ReleaseURL     string // base URL of the one-click release endpoint
+	DigestInterval time.Duration
+}
+
+// DigestSender delivers quarantine digests to end users
+type DigestSender interface {
+	SendDigest(ctx context.Context, digest *domain.QuarantineDigest) error
+}
+
+// NewQuarantineService creates a new quarantine service. transactor and
+// access are optional; without access, users only reach the quarantine of
+// the accounts they own.
+func NewQuarantineService(
+	quarantineRepo repository.QuarantineRepository,
+	messageRepo repository.MessageRepository,
+	accountRepo repository.EmailAccountRepository,
+	folderRepo repository.FolderRepository,
+	policyRepo repository.PolicyRepository,
+	transactor repository.Transactor,
+	access FolderAccess,
+	digestSender DigestSender,
+	eventPub domain.EventPublisher,
+	config *QuarantineConfig,
+) *QuarantineService {
+	return &QuarantineService{
+		quarantineRepo: quarantineRepo,
+		messageRepo:    messageRepo,
+		accountRepo:    accountRepo,
+		folderRepo:     folderRepo,
+		policyRepo:     policyRepo,
+		transactor:     transactor,
+		access:         access,
+		digestSender:   digestSender,
+		eventPub:       eventPub,
+		config:         config,
+	}
+}
+
+// QuarantineRequest represents the request to quarantine a message
+type QuarantineRequest struct {
+	Message      *domain.Message
+	RawMessage   []byte
+	Reason       domain.QuarantineReason
+	Details      string
+	Policy       *domain.Policy
+	SpamVerdict  *domain.SpamVerdict
+	VirusVerdict *domain.VirusVerdict
+}
+
+// ReleaseRequest represents the request to release a quarantined message
+type ReleaseRequest struct {
+	EntryID     string
+	ActorID     string
+	IsAdmin     bool
+	AllowSender bool
+}
+
+// Quarantine holds a message back from delivery
+func (s *QuarantineService) Quarantine(ctx context.Context, req QuarantineRequest) (*domain.QuarantineEntry, error) {
+	message := req.Message
+
+	account, err := s.accountRepo.GetByID(ctx, message.AccountID)
+	if err != nil {
+		return nil, errors.InternalError(err)
+	}
+	if account == nil {
+		return nil, errors.EmailAccountNotFound(message.AccountID)
+	}
+
+	now := time.Now()
+	entry := &domain.QuarantineEntry{
+		ID:           uuid.New().String(),
+		MessageID:    message.ID,
+		AccountID:    message.AccountID,
+		DomainID:     account.DomainID,
+		Sender:       message.From,
+		Recipients:   append(append([]string{}, message.To...), message.Cc...),
+		Subject:      message.Subject,
+		Reason:       req.Reason,
+		Details:      req.Details,
+		SpamVerdict:  req.SpamVerdict,
+		VirusVerdict: req.VirusVerdict,
+		Message:      *message,
+		RawMessage:   req.RawMessage,
+		Size:         message.Size,
+		Status:       domain.QuarantineStatusHeld,
+		CreatedAt:    now,
+		ExpiresAt:    now.Add(s.config.Retention),
+	}
+	if req.Policy != nil {
+		entry.PolicyID = &req.Policy.ID
+		entry.PolicyName = &req.Policy.Name
+	}
+
+	if err := s.quarantineRepo.Create(ctx, entry); err != nil {
+		return nil, errors.InternalError(err)
+	}
+
+	// Publish event
+	event := domain.NewBaseEvent(uuid.New().String(), entry.ID, domain.EventTypeMessageQuarantined, map[string]interface{}{
+		"messageID": entry.MessageID,
+		"accountID": entry.AccountID,
+		"reason":    entry.Reason,
+		"policyID":  entry.PolicyID,
+	})
+	if err := s.eventPub.Publish(ctx, event); err != nil {
+		// Log error but don't fail the operation
+	}
+
+	return entry, nil
+}
+
+// GetEntry retrieves a quarantined message by ID
+func (s *QuarantineService) GetEntry(ctx context.Context, id string) (*domain.QuarantineEntry, error) {
+	entry, err := s.quarantineRepo.GetByID(ctx, id)
+	if err != nil {
+		return nil, errors.InternalError(err)
+	}
+	if entry == nil || entry.Status == domain.QuarantineStatusDeleted {
+		return nil, errors.QuarantineNotFound(id)
+	}
+	return entry, nil
+}
+
+// GetEntryForUser retrieves a quarantined message held for one of the
+// user's accounts, or for a mailbox whose inbox the user may read
+func (s *QuarantineService) GetEntryForUser(ctx context.Context, id, userID string) (*domain.QuarantineEntry, error) {
+	entry, err := s.GetEntry(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	account, err := s.accountRepo.GetByID(ctx, entry.AccountID)
+	if err != nil {
+		return nil, errors.InternalError(err)
+	}
+	if account == nil {
+		return nil, errors.QuarantineNotFound(id)
+	}
+	allowed, err := s.canReadInbox(ctx, userID, account)
+	if err != nil {
+		return nil, err
+	}
+	if !allowed {
+		// Do not reveal entries held for other users
+		return nil, errors.QuarantineNotFound(id)
+	}
+	return entry, nil
+}
+
+// ListEntries lists quarantined messages with filtering
+func (s *QuarantineService) ListEntries(ctx context.Context, filter repository.QuarantineFilter) ([]*domain.QuarantineEntry, int, error) {
+	entries, err := s.quarantineRepo.List(ctx, filter)
+	if err != nil {
+		return nil, 0, errors.InternalError(err)
+	}
+	total, err := s.quarantineRepo.Count(ctx, filter)
+	if err != nil {
+		return nil, 0, errors.InternalError(err)
+	}
+	return entries, total, nil
+}
+
+// ListEntriesForUser lists quarantined messages across all of the user's accounts
+func (s *QuarantineService) ListEntriesForUser(ctx context.Context, userID string, filter repository.QuarantineFilter) ([]*domain.QuarantineEntry, int, error) {
+	accounts, err := s.accountRepo.List(ctx, repository.EmailAccountFilter{UserID: &userID})
+	if err != nil {
+		return nil, 0, errors.InternalError(err)
+	}
+	if len(accounts) == 0 {
+		return []*domain.QuarantineEntry{}, 0, nil
+	}
+
+	filter.AccountIDs = make([]string, 0, len(accounts))
+	for _, account := range accounts {
+		filter.AccountIDs = append(filter.AccountIDs, account.ID)
+	}
+	return s.ListEntries(ctx, filter)
+}
+
+// Preview returns a sanitised view of a quarantined message. Active content
+// and remote resources are removed from the HTML body.
+func (s *QuarantineService) Preview(entry *domain.QuarantineEntry) *domain.QuarantinePreview {
+	preview := &domain.QuarantinePreview{
+		Entry:       entry,
+		Headers:     make(map[string]string),
+		Attachments: []domain.AttachmentInfo{},
+	}
+
+	for key, value := range entry.Message.Headers {
+		preview.Headers[key] = value
+	}
+	if entry.Message.BodyText != nil {
+		preview.BodyText = *entry.Message.BodyText
+	}
+	if entry.Message.BodyHTML != nil {
+		preview.BodyHTML = SanitizeHTML(*entry.Message.BodyHTML)
+	}
+	for _, att := range entry.Message.Attachments {
+		preview.Attachments = append(preview.Attachments, domain.AttachmentInfo{
+			Filename:    att.Filename,
+			ContentType: att.ContentType,
+			Size:        att.Size,
+		})
+	}
+
+	return preview
+}
+
+// Release delivers a quarantined message to the account's inbox. End users
+// cannot release messages held for malware.
+func (s *QuarantineService) Release(ctx context.Context, req ReleaseRequest) (*domain.QuarantineEntry, error) {
+	entry, err := s.GetEntry(ctx, req.EntryID)
+	if err != nil {
+		return nil, err
+	}
+	if entry.Status != domain.QuarantineStatusHeld {
+		return nil, errors.NewError(errors.ErrCodeValidationError, "Message has already been released").
+			WithDetail("quarantine_id", entry.ID)
+	}
+	if entry.Reason == domain.QuarantineReasonVirus && !req.IsAdmin {
+		return nil, errors.NewError(errors.ErrCodeForbidden, "Only administrators can release infected messages").
+			WithDetail("quarantine_id", entry.ID)
+	}
+
+	message := entry.Message
+	inbox, err := s.folderRepo.GetByType(ctx, message.AccountID, domain.FolderTypeInbox)
+	if err != nil {
+		return nil, errors.InternalError(err)
+	}
+	if inbox != nil {
+		message.FolderID = inbox.ID
+	}
+	now := time.Now()
+	message.UpdatedAt = now
+
+	// The entry is released before the message is delivered, so of two
+	// concurrent releases only one delivers it
+	err = withinTransaction(ctx, s.transactor, func(ctx context.Context) error {
+		released, err := s.quarantineRepo.Release(ctx, entry.ID, req.ActorID, now)
+		if err != nil {
+			return errors.InternalError(err)
+		}
+		if !released {
+			return errors.NewError(errors.ErrCodeValidationError, "Message has already been released").
+				WithDetail("quarantine_id", entry.ID)
+		}
+
+		if err := s.messageRepo.Create(ctx, &message); err != nil {
+			return errors.InternalError(err)
+		}
+		if req.AllowSender {
+			if err := s.allowSender(ctx, entry); err != nil {
+				return err
+			}
+		}
+
+		event := domain.NewBaseEvent(uuid.New().String(), entry.ID, domain.EventTypeMessageReleased, map[string]interface{}{
+			"messageID":   entry.MessageID,
+			"accountID":   entry.AccountID,
+			"releasedBy":  req.ActorID,
+			"allowSender": req.AllowSender,
+		})
+		if err := s.eventPub.Publish(ctx, event); err != nil {
+			return errors.InternalError(err)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	entry.Status = domain.QuarantineStatusReleased
+	entry.ReleasedAt = &now
+	entry.ReleasedBy = &req.ActorID
+	return entry, nil
+}
+
+// GetEntryForToken returns the entry a signed digest link releases without
+// releasing it, so the link can ask for confirmation first
+func (s *QuarantineService) GetEntryForToken(ctx context.Context, token string) (*domain.QuarantineEntry, error) {
+	entryID, accountID, err := s.VerifyReleaseToken(token)
+	if err != nil {
+		return nil, err
+	}
+
+	entry, err := s.GetEntry(ctx, entryID)
+	if err != nil {
+		return nil, err
+	}
+	if entry.AccountID != accountID {
+		return nil, errors.InvalidToken("account mismatch")
+	}
+	return entry, nil
+}
+
+// ReleaseWithToken releases a message from a signed digest link
+func (s *QuarantineService) ReleaseWithToken(ctx context.Context, token string) (*domain.QuarantineEntry, error) {
+	entry, err := s.GetEntryForToken(ctx, token)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.Release(ctx, ReleaseRequest{EntryID: entry.ID, ActorID: "token:" + entry.AccountID})
+}
+
+// DeleteEntry permanently discards a quarantined message
+func (s *QuarantineService) DeleteEntry(ctx context.Context, id string) error {
+	if _, err := s.GetEntry(ctx, id); err != nil {
+		return err
+	}
+	if err := s.quarantineRepo.Delete(ctx, id); err != nil {
+		return errors.InternalError(err)
+	}
+	return nil
+}
+
+// PurgeExpired removes entries that are past their retention period
+func (s *QuarantineService) PurgeExpired(ctx context.Context) (int, error) {
+	count, err := s.quarantineRepo.DeleteExpired(ctx, time.Now())
+	if err != nil {
+		return 0, errors.InternalError(err)
+	}
+	return count, nil
+}
+
+// SendDigests sends one digest per account listing messages quarantined
+// since the previous digest
+func (s *QuarantineService) SendDigests(ctx context.Context) (int, error) {
+	held := domain.QuarantineStatusHeld
+	pending := true
+	entries, err := s.quarantineRepo.List(ctx, repository.QuarantineFilter{
+		Status:        &held,
+		DigestPending: &pending,
+	})
+	if err != nil {
+		return 0, errors.InternalError(err)
+	}
+
+	byAccount := make(map[string][]*domain.QuarantineEntry)
+	order := []string{}
+	for _, entry := range entries {
+		// Infected messages are never offered for one-click release
+		if entry.Reason == domain.QuarantineReasonVirus {
+			continue
+		}
+		if _, ok := byAccount[entry.AccountID]; !ok {
+			order = append(order, entry.AccountID)
+		}
+		byAccount[entry.AccountID] = append(byAccount[entry.AccountID], entry)
+	}
+
+	sent := 0
+	for _, accountID := range order {
+		account, err := s.accountRepo.GetByID(ctx, accountID)
+		if err != nil {
+			return sent, errors.InternalError(err)
+		}
+		if account == nil || !account.IsActive {
+			continue
+		}
+
+		digest := &domain.QuarantineDigest{
+			AccountID:   accountID,
+			Recipient:   account.Email,
+			Items:       []domain.QuarantineDigestItem{},
+			GeneratedAt: time.Now(),
+		}
+		for _, entry := range byAccount[accountID] {
+			digest.Items = append(digest.Items, domain.QuarantineDigestItem{
+				EntryID:    entry.ID,
+				Sender:     entry.Sender,
+				Subject:    entry.Subject,
+				Reason:     entry.Reason,
+				ReceivedAt: entry.CreatedAt,
+				ReleaseURL: s.releaseURL(entry),
+			})
+		}
+
+		if err := s.digestSender.SendDigest(ctx, digest); err != nil {
+			return sent, errors.InternalError(err)
+		}
+
+		now := time.Now()
+		for _, entry := range byAccount[accountID] {
+			entry.DigestSentAt = &now
+			if err := s.quarantineRepo.Update(ctx, entry); err != nil {
+				return sent, errors.InternalError(err)
+			}
+		}
+		sent++
+	}
+
+	return sent, nil
+}
+
+// Run purges expired entries and sends digests at the configured interval
+// until the context is cancelled
+func (s *QuarantineService) Run(ctx context.Context) {
+	interval := s.config.DigestInterval
+	if interval <= 0 {
+		interval = 24 * time.Hour
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if _, err := s.PurgeExpired(ctx); err != nil {
+				// Log error but keep the scheduler running
+			}
+			if _, err := s.SendDigests(ctx); err != nil {
+				// Log error but keep the scheduler running
+			}
+		}
+	}
+}
+
+// GenerateReleaseToken signs a one-click release token for an entry
+func (s *QuarantineService) GenerateReleaseToken(entryID, accountID string, expiresAt time.Time) string {
+	payload := strings.Join([]string{entryID, accountID, strconv.FormatInt(expiresAt.Unix(), 10)}, "|")
+	encoded := base64.RawURLEncoding.EncodeToString([]byte(payload))
+	return encoded + "." + s.sign(encoded)
+}
+
+// VerifyReleaseToken checks a release token's signature and expiry and
+// returns the entry and account it was issued for
+func (s *QuarantineService) VerifyReleaseToken(token string) (string, string, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 2 {
+		return "", "", errors.InvalidToken("malformed token")
+	}
+	if !hmac.Equal([]byte(parts[1]), []byte(s.sign(parts[0]))) {
+		return "", "", errors.InvalidToken("bad signature")
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return "", "", errors.InvalidToken("malformed payload")
+	}
+	fields := strings.Split(string(payload), "|")
+	if len(fields) != 3 {
+		return "", "", errors.InvalidToken("malformed payload")
+	}
+	expiresAt, err := strconv.ParseInt(fields[2], 10, 64)
+	if err != nil {
+		return "", "", errors.InvalidToken("malformed expiry")
+	}
+	if time.Now().Unix() > expiresAt {
+		return "", "", errors.InvalidToken("token expired")
+	}
+
+	return fields[0], fields[1], nil
+}
+
+// Helper functions
+
+func (s *QuarantineService) sign(payload string) string {
+	mac := hmac.New(sha256.New, s.config.TokenSecret)
+	mac.Write([]byte(payload))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func (s *QuarantineService) releaseURL(entry *domain.QuarantineEntry) string {
+	expiresAt := time.Now().Add(s.config.TokenTTL)
+	if entry.ExpiresAt.Before(expiresAt) {
+		expiresAt = entry.ExpiresAt
+	}
+	token := s.GenerateReleaseToken(entry.ID, entry.AccountID, expiresAt)
+
+	separator := "?"
+	if strings.Contains(s.config.ReleaseURL, "?") {
+		separator = "&"
+	}
+	return s.config.ReleaseURL + separator + "token=" + url.QueryEscape(token)
+}
+
+// allowSender adds the entry's sender to the account owner's allow-list
+func (s *QuarantineService) allowSender(ctx context.Context, entry *domain.QuarantineEntry) error {
+	account, err := s.accountRepo.GetByID(ctx, entry.AccountID)
+	if err != nil {
+		return errors.InternalError(err)
+	}
+	if account == nil {
+		return errors.EmailAccountNotFound(entry.AccountID)
+	}
+
+	sender := normalizeEmail(extractAddress(entry.Sender))
+	if sender == "" {
+		return nil
+	}
+
+	now := time.Now()
+	policy := &domain.Policy{
+		ID:        uuid.New().String(),
+		UserID:    &account.UserID,
+		Name:      "Allow " + sender,
+		Type:      domain.PolicyTypeSpam,
+		Rule:      AllowListRule(sender),
+		Action:    domain.PolicyActionAllow,
+		IsActive:  true,
+		Priority:  100,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+	if err := s.policyRepo.Create(ctx, policy); err != nil {
+		return errors.InternalError(err)
+	}
+	return nil
+}
+
+// canReadInbox reports whether a user may review the quarantine of an
+// account: its owner, or a user who may read its inbox
+func (s *QuarantineService) canReadInbox(ctx context.Context, userID string, account *domain.EmailAccount) (bool, error) {
+	if account.UserID == userID {
+		return true, nil
+	}
+	if s.access == nil {
+		return false, nil
+	}
+	inbox, err := s.folderRepo.GetByType(ctx, account.ID, domain.FolderTypeInbox)
+	if err != nil {
+		return false, errors.InternalError(err)
+	}
+	if inbox == nil {
+		return false, nil
+	}
+	rights, err := s.access.FolderRights(ctx, userID, account, []*domain.Folder{inbox})
+	if err != nil {
+		return false, err
+	}
+	return rights[inbox.ID].Has(domain.RightRead), nil
+}
+
+// AllowListRule returns the policy rule that allow-lists a sender address
+func AllowListRule(sender string) string {
+	return "sender:" + normalizeEmail(sender)
+}
+
+func extractAddress(address string) string {
+	if start := strings.LastIndex(address, "<"); start >= 0 {
+		if end := strings.Index(address[start:], ">"); end > 0 {
+			return address[start+1 : start+end]
+		}
+	}
+	return address
+}
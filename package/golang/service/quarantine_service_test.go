@@ -0,0 +1,159 @@
+package service
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/skygenesisenterprise/aether-mailer/package/golang/domain"
+	"github.com/skygenesisenterprise/aether-mailer/package/golang/errors"
+	"github.com/skygenesisenterprise/aether-mailer/package/golang/repository"
+	"github.com/skygenesisenterprise/aether-mailer/package/golang/repository/inmemory"
+)
+
+// newTestQuarantine returns a quarantine service on the test repositories
+// that resolves shared mailbox rights through an ACL service
+func newTestQuarantine(m *testMail) (*QuarantineService, *inmemory.FolderACLRepository) {
+	acls := inmemory.NewFolderACLRepository(m.store)
+	access := NewACLService(acls, inmemory.NewGroupRepository(m.store), inmemory.NewACLAuditLogRepository(m.store),
+		m.accounts, m.folders, m.users, m.domains, m.members, nil)
+	quarantine := NewQuarantineService(inmemory.NewQuarantineRepository(m.store), m.messages, m.accounts, m.folders,
+		m.policies, nil, access, nil, m.events, &QuarantineConfig{Retention: time.Hour})
+	return quarantine, acls
+}
+
+// holdMessage quarantines a spam message for an account
+func holdMessage(t *testing.T, quarantine *QuarantineService, account *domain.EmailAccount) *domain.QuarantineEntry {
+	t.Helper()
+	now := time.Now()
+	entry, err := quarantine.Quarantine(context.Background(), QuarantineRequest{
+		Message: &domain.Message{
+			ID:         uuid.NewString(),
+			AccountID:  account.ID,
+			From:       "offers@example.net",
+			To:         []string{account.Email},
+			Subject:    "Cheap watches",
+			BodyText:   stringPtr("Buy now"),
+			ReceivedAt: now,
+			CreatedAt:  now,
+			UpdatedAt:  now,
+		},
+		Reason: domain.QuarantineReasonSpam,
+	})
+	if err != nil {
+		t.Fatalf("Quarantine: %v", err)
+	}
+	return entry
+}
+
+func TestQuarantineServiceReleaseOnce(t *testing.T) {
+	ctx := context.Background()
+	m := newTestMail(t)
+	alice := m.newUser(t, "alice")
+	account := m.newAccount(t, alice, "alice")
+	quarantine, _ := newTestQuarantine(m)
+	entry := holdMessage(t, quarantine, account)
+
+	var wg sync.WaitGroup
+	results := make([]error, 8)
+	for i := range results {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			_, results[i] = quarantine.Release(ctx, ReleaseRequest{EntryID: entry.ID, ActorID: alice.ID})
+		}(i)
+	}
+	wg.Wait()
+
+	released := 0
+	for _, err := range results {
+		if err == nil {
+			released++
+		} else if appErr, ok := err.(*errors.Error); !ok || appErr.Code != errors.ErrCodeValidationError {
+			t.Errorf("Release: %v, want already released", err)
+		}
+	}
+	if released != 1 {
+		t.Fatalf("%d of %d concurrent releases succeeded, want 1", released, len(results))
+	}
+
+	inbox := m.folder(t, account, domain.FolderTypeInbox)
+	delivered, err := m.messages.ListByAccount(ctx, account.ID, repository.MessageFilter{FolderID: &inbox.ID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(delivered) != 1 || delivered[0].ID != entry.MessageID {
+		t.Errorf("inbox holds %d messages, want the released message once", len(delivered))
+	}
+	if events := m.events.EventsOfType(domain.EventTypeMessageReleased); len(events) != 1 {
+		t.Errorf("%d MESSAGE_RELEASED events, want 1", len(events))
+	}
+	got, err := quarantine.GetEntry(ctx, entry.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Status != domain.QuarantineStatusReleased || got.ReleasedBy == nil || *got.ReleasedBy != alice.ID {
+		t.Errorf("entry after release = %+v", got)
+	}
+}
+
+func TestQuarantineServiceGetEntryForUser(t *testing.T) {
+	ctx := context.Background()
+	m := newTestMail(t)
+	alice, bob, carol := m.newUser(t, "alice"), m.newUser(t, "bob"), m.newUser(t, "carol")
+	personal := m.newAccount(t, alice, "alice")
+	support := m.newAccount(t, nil, "support")
+	quarantine, acls := newTestQuarantine(m)
+
+	grant := func(account *domain.EmailAccount, kind domain.FolderType, user *domain.User, rights domain.Rights) {
+		t.Helper()
+		err := acls.Create(ctx, &domain.FolderACL{
+			ID:          uuid.NewString(),
+			FolderID:    m.folder(t, account, kind).ID,
+			AccountID:   account.ID,
+			SubjectType: domain.ACLSubjectUser,
+			SubjectID:   user.ID,
+			Rights:      rights,
+			GrantedBy:   alice.ID,
+			CreatedAt:   time.Now(),
+			UpdatedAt:   time.Now(),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	grant(support, domain.FolderTypeInbox, bob, "lrs")
+	grant(support, domain.FolderTypeSent, carol, "lrs")
+
+	held := holdMessage(t, quarantine, personal)
+	shared := holdMessage(t, quarantine, support)
+
+	tests := []struct {
+		name    string
+		entry   *domain.QuarantineEntry
+		user    *domain.User
+		allowed bool
+	}{
+		{"owner", held, alice, true},
+		{"other user", held, bob, false},
+		{"reader of the shared inbox", shared, bob, true},
+		{"reader of another shared folder", shared, carol, false},
+		{"no rights on the shared mailbox", shared, alice, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			entry, err := quarantine.GetEntryForUser(ctx, tt.entry.ID, tt.user.ID)
+			if tt.allowed {
+				if err != nil || entry.ID != tt.entry.ID {
+					t.Fatalf("GetEntryForUser = %v, %v; want the entry", entry, err)
+				}
+				return
+			}
+			if appErr, ok := err.(*errors.Error); !ok || appErr.Code != errors.ErrCodeQuarantineNotFound {
+				t.Fatalf("GetEntryForUser = %v, %v; want not found", entry, err)
+			}
+		})
+	}
+}
@@ -15,6 +15,7 @@ type QuotaService struct {
 	quotaRepo  repository.QuotaRepository
 	userRepo   repository.UserRepository
 	domainRepo repository.DomainRepository
+	transactor repository.Transactor
 	eventPub   domain.EventPublisher
 	config     *QuotaConfig
 }
@@ -44,11 +45,13 @@ type QuotaStatus struct {
 	Warnings            []string
 }
 
-// NewQuotaService creates a new quota service
+// NewQuotaService creates a new quota service. transactor is optional;
+// when set, quota updates and their events are written atomically.
 func NewQuotaService(
 	quotaRepo repository.QuotaRepository,
 	userRepo repository.UserRepository,
 	domainRepo repository.DomainRepository,
+	transactor repository.Transactor,
 	eventPub domain.EventPublisher,
 	config *QuotaConfig,
 ) *QuotaService {
@@ -56,6 +59,7 @@ func NewQuotaService(
 		quotaRepo:  quotaRepo,
 		userRepo:   userRepo,
 		domainRepo: domainRepo,
+		transactor: transactor,
 		eventPub:   eventPub,
 		config:     config,
 	}
@@ -151,97 +155,101 @@ func (s *QuotaService) GetQuotaStatus(ctx context.Context, userID string) (*Quot
 
 // UpdateStorageUsage updates storage usage for a user
 func (s *QuotaService) UpdateStorageUsage(ctx context.Context, userID string, sizeDelta int64) error {
-	userQuota, err := s.getUserQuota(ctx, userID)
-	if err != nil {
-		return errors.InternalError(err)
-	}
+	return withinTransaction(ctx, s.transactor, func(ctx context.Context) error {
+		userQuota, err := s.getUserQuota(ctx, userID)
+		if err != nil {
+			return errors.InternalError(err)
+		}
 
-	if userQuota == nil {
-		return errors.UserNotFound(userID)
-	}
+		if userQuota == nil {
+			return errors.UserNotFound(userID)
+		}
 
-	// Update storage usage
-	userQuota.UsedStorageMB += int(sizeDelta / (1024 * 1024))
-	if userQuota.UsedStorageMB < 0 {
-		userQuota.UsedStorageMB = 0
-	}
+		// Update storage usage
+		userQuota.UsedStorageMB += int(sizeDelta / (1024 * 1024))
+		if userQuota.UsedStorageMB < 0 {
+			userQuota.UsedStorageMB = 0
+		}
 
-	if err := s.quotaRepo.Update(ctx, userQuota); err != nil {
-		return errors.InternalError(err)
-	}
+		if err := s.quotaRepo.Update(ctx, userQuota); err != nil {
+			return errors.InternalError(err)
+		}
 
-	// Check if quota exceeded after update
-	if userQuota.UsedStorageMB >= userQuota.MaxStorageMB {
-		// Publish quota exceeded event
-		event := domain.NewBaseEvent(
-			uuid.New().String(),
-			userQuota.UserID,
-			domain.EventTypeQuotaExceeded,
-			map[string]interface{}{
-				"type":     "storage",
-				"used":     userQuota.UsedStorageMB,
-				"max":      userQuota.MaxStorageMB,
-				"domainID": userQuota.DomainID,
-			},
-		)
-		if err := s.eventPub.Publish(ctx, event); err != nil {
-			// Log error but don't fail the operation
+		// Check if quota exceeded after update
+		if userQuota.UsedStorageMB >= userQuota.MaxStorageMB {
+			// Publish quota exceeded event
+			event := domain.NewBaseEvent(
+				uuid.New().String(),
+				userQuota.UserID,
+				domain.EventTypeQuotaExceeded,
+				map[string]interface{}{
+					"type":     "storage",
+					"used":     userQuota.UsedStorageMB,
+					"max":      userQuota.MaxStorageMB,
+					"domainID": userQuota.DomainID,
+				},
+			)
+			if err := s.eventPub.Publish(ctx, event); err != nil {
+				return errors.InternalError(err)
+			}
 		}
-	}
 
-	return nil
+		return nil
+	})
 }
 
 // IncrementEmailCount increments the daily email count for a user
 func (s *QuotaService) IncrementEmailCount(ctx context.Context, userID string) error {
-	userQuota, err := s.getUserQuota(ctx, userID)
-	if err != nil {
-		return errors.InternalError(err)
-	}
-
-	if userQuota == nil {
-		return errors.UserNotFound(userID)
-	}
-
-	// Check if we need to reset daily counters
-	if time.Now().After(userQuota.ResetAt) {
-		if err := s.resetDailyCounters(ctx, userID); err != nil {
-			return errors.InternalError(err)
-		}
-		// Reload quota
-		userQuota, err = s.quotaRepo.GetByUserID(ctx, userID)
+	return withinTransaction(ctx, s.transactor, func(ctx context.Context) error {
+		userQuota, err := s.getUserQuota(ctx, userID)
 		if err != nil {
 			return errors.InternalError(err)
 		}
-	}
 
-	// Increment email count
-	userQuota.SentEmailsToday++
+		if userQuota == nil {
+			return errors.UserNotFound(userID)
+		}
 
-	if err := s.quotaRepo.Update(ctx, userQuota); err != nil {
-		return errors.InternalError(err)
-	}
+		// Check if we need to reset daily counters
+		if time.Now().After(userQuota.ResetAt) {
+			if err := s.resetDailyCounters(ctx, userID); err != nil {
+				return errors.InternalError(err)
+			}
+			// Reload quota
+			userQuota, err = s.quotaRepo.GetByUserID(ctx, userID)
+			if err != nil {
+				return errors.InternalError(err)
+			}
+		}
 
-	// Check if quota exceeded after increment
-	if userQuota.SentEmailsToday >= userQuota.MaxEmailsPerDay {
-		// Publish quota exceeded event
-		event := domain.NewBaseEvent(
-			uuid.New().String(),
-			userQuota.UserID,
-			domain.EventTypeQuotaExceeded,
-			map[string]interface{}{
-				"type":     "daily_emails",
-				"used":     userQuota.SentEmailsToday,
-				"max":      userQuota.MaxEmailsPerDay,
-				"domainID": userQuota.DomainID,
-			},
-		)
-		if err := s.eventPub.Publish(ctx, event); err != nil {
-			// Log error but don't fail the operation
+		// Increment email count
+		userQuota.SentEmailsToday++
+
+		if err := s.quotaRepo.Update(ctx, userQuota); err != nil {
+			return errors.InternalError(err)
 		}
-	}
 
-	return nil
+		// Check if quota exceeded after increment
+		if userQuota.SentEmailsToday >= userQuota.MaxEmailsPerDay {
+			// Publish quota exceeded event
+			event := domain.NewBaseEvent(
+				uuid.New().String(),
+				userQuota.UserID,
+				domain.EventTypeQuotaExceeded,
+				map[string]interface{}{
+					"type":     "daily_emails",
+					"used":     userQuota.SentEmailsToday,
+					"max":      userQuota.MaxEmailsPerDay,
+					"domainID": userQuota.DomainID,
+				},
+			)
+			if err := s.eventPub.Publish(ctx, event); err != nil {
+				return errors.InternalError(err)
+			}
+		}
+
+		return nil
+	})
 }
 
 // ResetDailyCounters resets daily email counters for all users
@@ -0,0 +1,451 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/skygenesisenterprise/aether-mailer/package/golang/domain"
+	"github.com/skygenesisenterprise/aether-mailer/package/golang/errors"
+	"github.com/skygenesisenterprise/aether-mailer/package/golang/repository"
+)
+
+// RateLimitService enforces outbound sending limits and suspends senders
+// whose bounce or unknown-recipient rates indicate abuse
+type RateLimitService struct {
+	counters       repository.RateCounterStore
+	suspensionRepo repository.SendingSuspensionRepository
+	notifier       AdminNotifier
+	eventPub       domain.EventPublisher
+	config         *RateLimitConfig
+}
+
+// RateLimitConfig defines rate limit service configuration
+type RateLimitConfig struct {
+	EnableRateLimits        bool
+	Limits                  []domain.RateLimit
+	ReputationWindow        time.Duration
+	MinReputationSample     int64   // deliveries required before rates are evaluated
+	MaxBounceRate           float64 // 0.0 to 1.0
+	MaxUnknownRecipientRate float64 // 0.0 to 1.0
+	SuspendAfterViolations  int64   // limit violations within ViolationWindow that suspend an account, 0 disables
+	ViolationWindow         time.Duration
+	SuspensionDuration      time.Duration // 0 keeps suspensions until lifted
+	PruneInterval           time.Duration // 0 prunes hourly
+}
+
+// AdminNotifier alerts administrators about sending incidents
+type AdminNotifier interface {
+	NotifyAdmins(ctx context.Context, subject, body string) error
+}
+
+// SendAttempt identifies the sender of an outbound message. Empty keys skip
+// the corresponding scope.
+type SendAttempt struct {
+	AccountID  string
+	DomainID   string
+	TenantID   string
+	SourceIP   string
+	Recipients int
+	At         time.Time // when capacity is reserved; zero is now
+}
+
+// DefaultRateLimits returns per-minute and per-hour limits for every scope
+func DefaultRateLimits() []domain.RateLimit {
+	return []domain.RateLimit{
+		{Scope: domain.RateScopeAccount, Window: time.Minute, MaxMessages: 30, MaxRecipients: 100},
+		{Scope: domain.RateScopeAccount, Window: time.Hour, MaxMessages: 300, MaxRecipients: 1000},
+		{Scope: domain.RateScopeDomain, Window: time.Minute, MaxMessages: 300, MaxRecipients: 1000},
+		{Scope: domain.RateScopeDomain, Window: time.Hour, MaxMessages: 5000, MaxRecipients: 20000},
+		{Scope: domain.RateScopeTenant, Window: time.Minute, MaxMessages: 1000, MaxRecipients: 5000},
+		{Scope: domain.RateScopeTenant, Window: time.Hour, MaxMessages: 20000, MaxRecipients: 100000},
+		{Scope: domain.RateScopeIP, Window: time.Minute, MaxMessages: 600, MaxRecipients: 3000},
+		{Scope: domain.RateScopeIP, Window: time.Hour, MaxMessages: 10000, MaxRecipients: 50000},
+	}
+}
+
+// NewRateLimitService creates a new rate limit service
+func NewRateLimitService(
+	counters repository.RateCounterStore,
+	suspensionRepo repository.SendingSuspensionRepository,
+	notifier AdminNotifier,
+	eventPub domain.EventPublisher,
+	config *RateLimitConfig,
+) *RateLimitService {
+	return &RateLimitService{
+		counters:       counters,
+		suspensionRepo: suspensionRepo,
+		notifier:       notifier,
+		eventPub:       eventPub,
+		config:         config,
+	}
+}
+
+// CheckSend rejects suspended senders and reserves capacity in every
+// applicable limit. Reservations are released again when any limit would be
+// exceeded, so rejected attempts do not consume capacity.
+func (s *RateLimitService) CheckSend(ctx context.Context, attempt SendAttempt) error {
+	if !s.config.EnableRateLimits {
+		return nil
+	}
+
+	now := attempt.at()
+	for _, scope := range []domain.RateScope{domain.RateScopeAccount, domain.RateScopeDomain, domain.RateScopeTenant, domain.RateScopeIP} {
+		key := attempt.key(scope)
+		if key == "" {
+			continue
+		}
+		suspension, err := s.suspensionRepo.GetActive(ctx, scope, key, now)
+		if err != nil {
+			return errors.InternalError(err)
+		}
+		if suspension != nil {
+			return errors.SendingSuspended(string(scope), suspension.Reason).WithDetail("suspension_id", suspension.ID)
+		}
+	}
+
+	type reservation struct {
+		key    string
+		amount int64
+		window time.Duration
+	}
+	reserved := []reservation{}
+	release := func() {
+		for _, r := range reserved {
+			if _, err := s.counters.Add(ctx, r.key, -r.amount, now, r.window); err != nil {
+				// Log error but keep releasing the remaining reservations
+			}
+		}
+	}
+
+	recipients := int64(attempt.Recipients)
+	for _, limit := range s.config.Limits {
+		key := attempt.key(limit.Scope)
+		if key == "" {
+			continue
+		}
+
+		messages, err := s.counters.Add(ctx, counterKey("msgs", limit.Scope, key, limit.Window), 1, now, limit.Window)
+		if err != nil {
+			release()
+			return errors.InternalError(err)
+		}
+		reserved = append(reserved, reservation{counterKey("msgs", limit.Scope, key, limit.Window), 1, limit.Window})
+
+		total, err := s.counters.Add(ctx, counterKey("rcpts", limit.Scope, key, limit.Window), recipients, now, limit.Window)
+		if err != nil {
+			release()
+			return errors.InternalError(err)
+		}
+		reserved = append(reserved, reservation{counterKey("rcpts", limit.Scope, key, limit.Window), recipients, limit.Window})
+
+		exceeded := ""
+		var max int64
+		switch {
+		case limit.MaxMessages > 0 && messages > limit.MaxMessages:
+			exceeded, max = "messages", limit.MaxMessages
+		case limit.MaxRecipients > 0 && total > limit.MaxRecipients:
+			exceeded, max = "recipients", limit.MaxRecipients
+		}
+		if exceeded != "" {
+			release()
+			s.recordViolation(ctx, limit, key, exceeded, max)
+			return errors.RateLimitExceeded(string(limit.Scope), limit.Window.String(), max).
+				WithDetail("dimension", exceeded)
+		}
+	}
+
+	return nil
+}
+
+// ReleaseSend gives back the capacity CheckSend reserved for an attempt
+// that was not sent. The attempt must carry the time of the reservation.
+func (s *RateLimitService) ReleaseSend(ctx context.Context, attempt SendAttempt) error {
+	if !s.config.EnableRateLimits {
+		return nil
+	}
+
+	var firstErr error
+	for _, limit := range s.config.Limits {
+		key := attempt.key(limit.Scope)
+		if key == "" {
+			continue
+		}
+		for kind, amount := range map[string]int64{"msgs": 1, "rcpts": int64(attempt.Recipients)} {
+			if _, err := s.counters.Add(ctx, counterKey(kind, limit.Scope, key, limit.Window), -amount, attempt.at(), limit.Window); err != nil && firstErr == nil {
+				firstErr = errors.InternalError(err)
+			}
+		}
+	}
+	return firstErr
+}
+
+// RecordOutcome records the delivery result for one recipient and suspends
+// the sending account when its bounce or unknown-recipient rate is too high
+func (s *RateLimitService) RecordOutcome(ctx context.Context, attempt SendAttempt, outcome domain.DeliveryOutcome) error {
+	if !s.config.EnableRateLimits {
+		return nil
+	}
+
+	now := time.Now()
+	for _, scope := range []domain.RateScope{domain.RateScopeAccount, domain.RateScopeDomain, domain.RateScopeTenant, domain.RateScopeIP} {
+		key := attempt.key(scope)
+		if key == "" {
+			continue
+		}
+		if _, err := s.counters.Add(ctx, counterKey(string(outcome), scope, key, s.config.ReputationWindow), 1, now, s.config.ReputationWindow); err != nil {
+			return errors.InternalError(err)
+		}
+	}
+
+	if attempt.AccountID == "" || outcome == domain.DeliveryOutcomeDelivered {
+		return nil
+	}
+
+	stats, err := s.GetSendingStats(ctx, domain.RateScopeAccount, attempt.AccountID)
+	if err != nil {
+		return err
+	}
+	if stats.Delivered+stats.Bounced+stats.UnknownRecipients < s.config.MinReputationSample {
+		return nil
+	}
+
+	reason := ""
+	switch {
+	case s.config.MaxBounceRate > 0 && stats.BounceRate > s.config.MaxBounceRate:
+		reason = fmt.Sprintf("bounce rate %.1f%% exceeds %.1f%%", stats.BounceRate*100, s.config.MaxBounceRate*100)
+	case s.config.MaxUnknownRecipientRate > 0 && stats.UnknownRecipientRate > s.config.MaxUnknownRecipientRate:
+		reason = fmt.Sprintf("unknown recipient rate %.1f%% exceeds %.1f%%", stats.UnknownRecipientRate*100, s.config.MaxUnknownRecipientRate*100)
+	}
+	if reason == "" {
+		return nil
+	}
+
+	_, err = s.Suspend(ctx, domain.RateScopeAccount, attempt.AccountID, reason)
+	return err
+}
+
+// GetSendingStats returns the delivery reputation of a scope over the reputation window
+func (s *RateLimitService) GetSendingStats(ctx context.Context, scope domain.RateScope, key string) (*domain.SendingStats, error) {
+	now := time.Now()
+	window := s.config.ReputationWindow
+	stats := &domain.SendingStats{Scope: scope, Key: key, Window: window}
+
+	for outcome, target := range map[domain.DeliveryOutcome]*int64{
+		domain.DeliveryOutcomeDelivered:        &stats.Delivered,
+		domain.DeliveryOutcomeBounced:          &stats.Bounced,
+		domain.DeliveryOutcomeUnknownRecipient: &stats.UnknownRecipients,
+	} {
+		count, err := s.counters.Sum(ctx, counterKey(string(outcome), scope, key, window), now, window)
+		if err != nil {
+			return nil, errors.InternalError(err)
+		}
+		*target = count
+	}
+
+	if total := stats.Delivered + stats.Bounced + stats.UnknownRecipients; total > 0 {
+		stats.BounceRate = float64(stats.Bounced) / float64(total)
+		stats.UnknownRecipientRate = float64(stats.UnknownRecipients) / float64(total)
+	}
+	return stats, nil
+}
+
+// GetUsage returns the current usage of every limit configured for a scope
+func (s *RateLimitService) GetUsage(ctx context.Context, scope domain.RateScope, key string) ([]domain.RateUsage, error) {
+	now := time.Now()
+	usage := []domain.RateUsage{}
+	for _, limit := range s.config.Limits {
+		if limit.Scope != scope {
+			continue
+		}
+		messages, err := s.counters.Sum(ctx, counterKey("msgs", scope, key, limit.Window), now, limit.Window)
+		if err != nil {
+			return nil, errors.InternalError(err)
+		}
+		recipients, err := s.counters.Sum(ctx, counterKey("rcpts", scope, key, limit.Window), now, limit.Window)
+		if err != nil {
+			return nil, errors.InternalError(err)
+		}
+		usage = append(usage, domain.RateUsage{Limit: limit, Key: key, Messages: messages, Recipients: recipients})
+	}
+	return usage, nil
+}
+
+// Suspend blocks a scope from sending, notifies administrators and emits a
+// quota exceeded event. Suspending an already suspended scope is a no-op.
+func (s *RateLimitService) Suspend(ctx context.Context, scope domain.RateScope, key, reason string) (*domain.SendingSuspension, error) {
+	now := time.Now()
+	existing, err := s.suspensionRepo.GetActive(ctx, scope, key, now)
+	if err != nil {
+		return nil, errors.InternalError(err)
+	}
+	if existing != nil {
+		return existing, nil
+	}
+
+	suspension := &domain.SendingSuspension{
+		ID:          uuid.New().String(),
+		Scope:       scope,
+		Key:         key,
+		Reason:      reason,
+		SuspendedAt: now,
+	}
+	if s.config.SuspensionDuration > 0 {
+		expiresAt := now.Add(s.config.SuspensionDuration)
+		suspension.ExpiresAt = &expiresAt
+	}
+
+	if err := s.suspensionRepo.Create(ctx, suspension); err != nil {
+		return nil, errors.InternalError(err)
+	}
+
+	// Publish event
+	event := domain.NewBaseEvent(uuid.New().String(), key, domain.EventTypeQuotaExceeded, map[string]interface{}{
+		"scope":        scope,
+		"key":          key,
+		"reason":       reason,
+		"suspended":    true,
+		"suspensionID": suspension.ID,
+	})
+	if err := s.eventPub.Publish(ctx, event); err != nil {
+		// Log error but don't fail the operation
+	}
+
+	if s.notifier != nil {
+		subject := fmt.Sprintf("Sending suspended for %s %s", scope, key)
+		body := fmt.Sprintf("Outbound sending for %s %s was suspended at %s.\n\nReason: %s\n",
+			scope, key, now.Format(time.RFC1123Z), reason)
+		if err := s.notifier.NotifyAdmins(ctx, subject, body); err != nil {
+			// Log error but don't fail the operation
+		}
+	}
+
+	return suspension, nil
+}
+
+// LiftSuspension re-enables sending for a suspended scope
+func (s *RateLimitService) LiftSuspension(ctx context.Context, id, actorID string) (*domain.SendingSuspension, error) {
+	suspension, err := s.suspensionRepo.GetByID(ctx, id)
+	if err != nil {
+		return nil, errors.InternalError(err)
+	}
+	if suspension == nil {
+		return nil, errors.SuspensionNotFound(id)
+	}
+	if !suspension.IsActive(time.Now()) {
+		return suspension, nil
+	}
+
+	now := time.Now()
+	suspension.LiftedAt = &now
+	suspension.LiftedBy = &actorID
+	if err := s.suspensionRepo.Update(ctx, suspension); err != nil {
+		return nil, errors.InternalError(err)
+	}
+	return suspension, nil
+}
+
+// ListSuspensions lists the suspensions currently in force
+func (s *RateLimitService) ListSuspensions(ctx context.Context) ([]*domain.SendingSuspension, error) {
+	suspensions, err := s.suspensionRepo.ListActive(ctx, time.Now())
+	if err != nil {
+		return nil, errors.InternalError(err)
+	}
+	return suspensions, nil
+}
+
+// Prune removes counter data older than the longest configured window
+func (s *RateLimitService) Prune(ctx context.Context) error {
+	longest := s.config.ReputationWindow
+	if s.config.ViolationWindow > longest {
+		longest = s.config.ViolationWindow
+	}
+	for _, limit := range s.config.Limits {
+		if limit.Window > longest {
+			longest = limit.Window
+		}
+	}
+
+	if err := s.counters.Prune(ctx, time.Now().Add(-longest)); err != nil {
+		return errors.InternalError(err)
+	}
+	return nil
+}
+
+// Run prunes expired counters every PruneInterval until ctx is cancelled
+func (s *RateLimitService) Run(ctx context.Context) {
+	interval := s.config.PruneInterval
+	if interval <= 0 {
+		interval = time.Hour
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			// Counters left by a failed prune are removed on the next tick
+			_ = s.Prune(ctx)
+		}
+	}
+}
+
+// Helper functions
+
+func (s *RateLimitService) recordViolation(ctx context.Context, limit domain.RateLimit, key, dimension string, max int64) {
+	// Publish event
+	event := domain.NewBaseEvent(uuid.New().String(), key, domain.EventTypeQuotaExceeded, map[string]interface{}{
+		"scope":     limit.Scope,
+		"key":       key,
+		"window":    limit.Window.String(),
+		"dimension": dimension,
+		"limit":     max,
+	})
+	if err := s.eventPub.Publish(ctx, event); err != nil {
+		// Log error but don't fail the operation
+	}
+
+	if limit.Scope != domain.RateScopeAccount || s.config.SuspendAfterViolations <= 0 {
+		return
+	}
+
+	violations, err := s.counters.Add(ctx, counterKey("violations", limit.Scope, key, s.config.ViolationWindow), 1, time.Now(), s.config.ViolationWindow)
+	if err != nil {
+		return
+	}
+	if violations >= s.config.SuspendAfterViolations {
+		reason := fmt.Sprintf("%d rate limit violations within %s", violations, s.config.ViolationWindow)
+		if _, err := s.Suspend(ctx, limit.Scope, key, reason); err != nil {
+			// Log error but don't fail the operation
+		}
+	}
+}
+
+func (a SendAttempt) at() time.Time {
+	if a.At.IsZero() {
+		return time.Now()
+	}
+	return a.At
+}
+
+func (a SendAttempt) key(scope domain.RateScope) string {
+	switch scope {
+	case domain.RateScopeAccount:
+		return a.AccountID
+	case domain.RateScopeDomain:
+		return a.DomainID
+	case domain.RateScopeTenant:
+		return a.TenantID
+	case domain.RateScopeIP:
+		return a.SourceIP
+	}
+	return ""
+}
+
+func counterKey(kind string, scope domain.RateScope, key string, window time.Duration) string {
+	return fmt.Sprintf("%s:%s:%s:%d", kind, scope, key, int64(window/time.Second))
+}
@@ -0,0 +1,516 @@
+package service
+
+import (
+	"context"
+	stderrors "errors"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/skygenesisenterprise/aether-mailer/package/golang/domain"
+	"github.com/skygenesisenterprise/aether-mailer/package/golang/errors"
+	"github.com/skygenesisenterprise/aether-mailer/package/golang/repository"
+)
+
+// ScheduledSendConfig defines how drafts are held and dispatched
+type ScheduledSendConfig struct {
+	PollInterval  time.Duration // 1 second when zero
+	BatchSize     int           // sends claimed per poll, 100 when zero
+	MaxAttempts   int           // attempts before a send fails, 5 when zero
+	RetryDelay    time.Duration // first retry delay, doubled on every attempt
+	MaxRetryDelay time.Duration
+	Lease         time.Duration // how long a send is reserved for a dispatcher or an edit, 1 minute when zero
+	MaxUndoWindow time.Duration // 30 seconds when zero
+	MaxDelay      time.Duration // how far ahead a send may be scheduled, 0 for no limit
+	Retention     time.Duration // completed sends are removed after this, 0 keeps them
+}
+
+// ScheduledSendService holds drafts of the accounts a user owns until they
+// are due to be sent: at a chosen time, or when the undo window after
+// sending closes. Held sends survive restarts and can be edited or
+// cancelled until they are dispatched. Dispatchers on several instances
+// may share one repository: a due send is leased to one of them and sent in
+// the same transaction that marks it sent.
+type ScheduledSendService struct {
+	scheduledRepo repository.ScheduledSendRepository
+	accountRepo   repository.EmailAccountRepository
+	messageRepo   repository.MessageRepository
+	drafts        *DraftService
+	transactor    repository.Transactor
+	config        ScheduledSendConfig
+}
+
+// ScheduledMessage is a scheduled send with its draft. Draft is nil when
+// the draft was deleted; the send is then cancelled when it is due.
+type ScheduledMessage struct {
+	Send  *domain.ScheduledSend
+	Draft *domain.Message
+}
+
+// SendRequest composes a message and chooses when it is sent. Without
+// SendAt or UndoWindow it is sent at once.
+type SendRequest struct {
+	Draft      DraftRequest
+	SendAt     *time.Time
+	UndoWindow time.Duration
+}
+
+// SendResult is a message sent at once, or held until it is due
+type SendResult struct {
+	Sent      *domain.Message
+	Scheduled *ScheduledMessage
+}
+
+// ScheduledSendUpdate changes a held send. Nil fields are kept.
+type ScheduledSendUpdate struct {
+	SendAt *time.Time
+	Draft  *DraftUpdate
+}
+
+// NewScheduledSendService creates a new scheduled send service. transactor
+// is optional; without it a dispatcher that stops between sending and
+// marking the send sent sends it again once the lease expires.
+func NewScheduledSendService(
+	scheduledRepo repository.ScheduledSendRepository,
+	accountRepo repository.EmailAccountRepository,
+	messageRepo repository.MessageRepository,
+	drafts *DraftService,
+	transactor repository.Transactor,
+	config ScheduledSendConfig,
+) *ScheduledSendService {
+	if config.PollInterval <= 0 {
+		config.PollInterval = time.Second
+	}
+	if config.BatchSize <= 0 {
+		config.BatchSize = 100
+	}
+	if config.MaxAttempts <= 0 {
+		config.MaxAttempts = 5
+	}
+	if config.Lease <= 0 {
+		config.Lease = time.Minute
+	}
+	if config.MaxUndoWindow <= 0 {
+		config.MaxUndoWindow = 30 * time.Second
+	}
+	return &ScheduledSendService{
+		scheduledRepo: scheduledRepo,
+		accountRepo:   accountRepo,
+		messageRepo:   messageRepo,
+		drafts:        drafts,
+		transactor:    transactor,
+		config:        config,
+	}
+}
+
+// MaxAttempts returns how many times a due send is tried before it fails
+func (s *ScheduledSendService) MaxAttempts() int {
+	return s.config.MaxAttempts
+}
+
+// Send composes a message in the Drafts folder and sends it at SendAt, when
+// the undo window closes, or at once
+func (s *ScheduledSendService) Send(ctx context.Context, userID string, req SendRequest) (*SendResult, error) {
+	if req.SendAt == nil && req.UndoWindow == 0 {
+		draft, err := s.drafts.CreateDraft(ctx, userID, req.Draft)
+		if err != nil {
+			return nil, err
+		}
+		sent, err := s.drafts.send(ctx, draft)
+		if err != nil {
+			// A draft left behind stays visible to the user in Drafts
+			_ = s.drafts.DeleteDraft(ctx, userID, draft.ID)
+			return nil, err
+		}
+		return &SendResult{Sent: sent}, nil
+	}
+
+	kind, sendAt, err := s.sendTime(req.SendAt, req.UndoWindow)
+	if err != nil {
+		return nil, err
+	}
+	if err := requireRecipients(req.Draft.To, req.Draft.Cc, req.Draft.Bcc); err != nil {
+		return nil, err
+	}
+	draft, err := s.drafts.CreateDraft(ctx, userID, req.Draft)
+	if err != nil {
+		return nil, err
+	}
+	send, err := s.schedule(ctx, draft, kind, sendAt)
+	if err != nil {
+		// A draft left behind stays visible to the user in Drafts
+		_ = s.drafts.DeleteDraft(ctx, userID, draft.ID)
+		return nil, err
+	}
+	return &SendResult{Scheduled: &ScheduledMessage{Send: send, Draft: draft}}, nil
+}
+
+// Schedule holds one of the user's drafts until sendAt, or for the undo
+// window when sendAt is nil
+func (s *ScheduledSendService) Schedule(ctx context.Context, userID, draftID string, sendAt *time.Time, undoWindow time.Duration) (*ScheduledMessage, error) {
+	if sendAt == nil && undoWindow == 0 {
+		return nil, errors.NewError(errors.ErrCodeValidationError, "A send time or an undo window is required")
+	}
+	kind, at, err := s.sendTime(sendAt, undoWindow)
+	if err != nil {
+		return nil, err
+	}
+	draft, _, err := s.drafts.ownedDraft(ctx, userID, draftID)
+	if err != nil {
+		return nil, err
+	}
+	if err := requireRecipients(draft.To, draft.Cc, draft.Bcc); err != nil {
+		return nil, err
+	}
+
+	existing, err := s.scheduledRepo.GetPendingByDraftID(ctx, draft.ID)
+	if err != nil {
+		return nil, errors.InternalError(err)
+	}
+	if existing != nil {
+		return nil, errors.NewError(errors.ErrCodeValidationError, "Draft is already scheduled").
+			WithDetail("scheduled_send_id", existing.ID)
+	}
+
+	send, err := s.schedule(ctx, draft, kind, at)
+	if err != nil {
+		return nil, err
+	}
+	return &ScheduledMessage{Send: send, Draft: draft}, nil
+}
+
+// ListScheduled lists the pending sends of one of the user's accounts,
+// soonest first
+func (s *ScheduledSendService) ListScheduled(ctx context.Context, userID, accountID string, limit, offset int) ([]*ScheduledMessage, int, error) {
+	if _, err := s.drafts.ownedAccount(ctx, userID, accountID); err != nil {
+		return nil, 0, err
+	}
+
+	pending := domain.ScheduledSendStatusPending
+	filter := repository.ScheduledSendFilter{AccountID: accountID, Status: &pending, Limit: limit, Offset: offset}
+	sends, err := s.scheduledRepo.List(ctx, filter)
+	if err != nil {
+		return nil, 0, errors.InternalError(err)
+	}
+	total, err := s.scheduledRepo.Count(ctx, filter)
+	if err != nil {
+		return nil, 0, errors.InternalError(err)
+	}
+
+	scheduled := make([]*ScheduledMessage, 0, len(sends))
+	for _, send := range sends {
+		draft, err := s.draftOf(ctx, send)
+		if err != nil {
+			return nil, 0, err
+		}
+		scheduled = append(scheduled, &ScheduledMessage{Send: send, Draft: draft})
+	}
+	return scheduled, total, nil
+}
+
+// GetScheduled returns one of the user's scheduled sends with its draft
+func (s *ScheduledSendService) GetScheduled(ctx context.Context, userID, id string) (*ScheduledMessage, error) {
+	send, err := s.ownedSend(ctx, userID, id)
+	if err != nil {
+		return nil, err
+	}
+	draft, err := s.draftOf(ctx, send)
+	if err != nil {
+		return nil, err
+	}
+	return &ScheduledMessage{Send: send, Draft: draft}, nil
+}
+
+// UpdateScheduled changes when one of the user's pending sends is due or
+// edits its draft. The send is leased while it is edited, so it cannot be
+// dispatched half-edited.
+func (s *ScheduledSendService) UpdateScheduled(ctx context.Context, userID, id string, update ScheduledSendUpdate) (*ScheduledMessage, error) {
+	send, err := s.ownedSend(ctx, userID, id)
+	if err != nil {
+		return nil, err
+	}
+	sendAt := send.SendAt
+	if update.SendAt != nil {
+		if _, sendAt, err = s.sendTime(update.SendAt, 0); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := s.lock(ctx, send); err != nil {
+		return nil, err
+	}
+
+	var draft *domain.Message
+	if update.Draft != nil {
+		draft, err = s.drafts.UpdateDraft(ctx, userID, send.DraftID, *update.Draft)
+	} else {
+		draft, err = s.draftOf(ctx, send)
+	}
+	if err != nil {
+		sendAt = send.SendAt
+	}
+
+	// Releases the lease
+	if rescheduleErr := s.scheduledRepo.Reschedule(ctx, send.ID, sendAt, time.Now()); rescheduleErr != nil && err == nil {
+		err = errors.InternalError(rescheduleErr)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	send, err = s.scheduledRepo.GetByID(ctx, send.ID)
+	if err != nil {
+		return nil, errors.InternalError(err)
+	}
+	return &ScheduledMessage{Send: send, Draft: draft}, nil
+}
+
+// CancelScheduled cancels one of the user's pending sends, which undoes a
+// send within its undo window. The draft stays in the Drafts folder and is
+// returned so that it can be edited again.
+func (s *ScheduledSendService) CancelScheduled(ctx context.Context, userID, id string) (*domain.Message, error) {
+	send, err := s.ownedSend(ctx, userID, id)
+	if err != nil {
+		return nil, err
+	}
+	cancelled, err := s.scheduledRepo.Cancel(ctx, send.ID, time.Now())
+	if err != nil {
+		return nil, errors.InternalError(err)
+	}
+	if !cancelled {
+		return nil, s.unavailable(ctx, send.ID)
+	}
+	return s.draftOf(ctx, send)
+}
+
+// ProcessBatch claims the due sends and sends their drafts. A failed send
+// does not hold up the rest of the batch. It returns the number of sends
+// claimed and the errors of the failed ones.
+func (s *ScheduledSendService) ProcessBatch(ctx context.Context) (int, error) {
+	sends, err := s.scheduledRepo.Claim(ctx, time.Now(), s.config.BatchSize, s.config.Lease)
+	if err != nil {
+		return 0, errors.InternalError(err)
+	}
+
+	var errs []error
+	for _, send := range sends {
+		if err := s.dispatch(ctx, send); err != nil {
+			// The lease expires and the send is claimed again
+			errs = append(errs, err)
+		}
+	}
+	if len(errs) > 0 {
+		return len(sends), errors.InternalError(stderrors.Join(errs...))
+	}
+	return len(sends), nil
+}
+
+// Prune removes the sends completed before the retention period
+func (s *ScheduledSendService) Prune(ctx context.Context) (int, error) {
+	if s.config.Retention <= 0 {
+		return 0, nil
+	}
+	deleted, err := s.scheduledRepo.DeleteCompleted(ctx, time.Now().Add(-s.config.Retention))
+	if err != nil {
+		return 0, errors.InternalError(err)
+	}
+	return deleted, nil
+}
+
+// Run dispatches due sends until ctx is cancelled, draining full batches
+// without waiting for the next tick
+func (s *ScheduledSendService) Run(ctx context.Context) {
+	ticker := time.NewTicker(s.config.PollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			for ctx.Err() == nil {
+				// Failed sends are retried once their lease expires
+				n, err := s.ProcessBatch(ctx)
+				if err != nil || n < s.config.BatchSize {
+					break
+				}
+			}
+			// Sends left by a failed prune are removed on the next tick
+			_, _ = s.Prune(ctx)
+		}
+	}
+}
+
+// dispatch sends the draft of a claimed send. The send is marked sent in
+// the transaction that files the sent copy, so a dispatcher that stops
+// halfway leaves the draft to be sent again rather than sent twice, and a
+// dispatcher that lost its claim rolls the sent copy back. Rejected
+// messages fail at once; other errors are retried.
+func (s *ScheduledSendService) dispatch(ctx context.Context, send *domain.ScheduledSend) error {
+	draft, err := s.messageRepo.GetByID(ctx, send.DraftID)
+	if err != nil {
+		_, err = s.scheduledRepo.MarkFailed(ctx, send, err.Error(), time.Now().Add(s.retryDelay(send.Attempts)))
+		return err
+	}
+	if draft == nil || !draft.IsDraft {
+		_, err = s.scheduledRepo.Complete(ctx, send, domain.ScheduledSendStatusCancelled, nil, "draft was deleted", time.Now())
+		return err
+	}
+
+	err = withinTransaction(ctx, s.transactor, func(ctx context.Context) error {
+		sent, err := s.drafts.send(ctx, draft)
+		if err != nil {
+			return err
+		}
+		completed, err := s.scheduledRepo.Complete(ctx, send, domain.ScheduledSendStatusSent, &sent.ID, "", time.Now())
+		if err != nil {
+			return errors.InternalError(err)
+		}
+		if !completed {
+			// Another dispatcher claimed the send after the lease expired
+			return errors.ScheduledSendLocked(send.ID)
+		}
+		return nil
+	})
+	var serviceErr *errors.Error
+	if err == nil || stderrors.As(err, &serviceErr) && serviceErr.Code == errors.ErrCodeScheduledSendLocked {
+		return err
+	}
+
+	if !retryableSendError(err) || send.Attempts >= s.config.MaxAttempts {
+		_, err = s.scheduledRepo.Complete(ctx, send, domain.ScheduledSendStatusFailed, nil, err.Error(), time.Now())
+		return err
+	}
+	_, err = s.scheduledRepo.MarkFailed(ctx, send, err.Error(), time.Now().Add(s.retryDelay(send.Attempts)))
+	return err
+}
+
+// schedule holds a draft until sendAt
+func (s *ScheduledSendService) schedule(ctx context.Context, draft *domain.Message, kind domain.ScheduledSendKind, sendAt time.Time) (*domain.ScheduledSend, error) {
+	now := time.Now().UTC().Truncate(time.Microsecond)
+	send := &domain.ScheduledSend{
+		ID:            uuid.New().String(),
+		AccountID:     draft.AccountID,
+		DraftID:       draft.ID,
+		Kind:          kind,
+		Status:        domain.ScheduledSendStatusPending,
+		SendAt:        sendAt,
+		NextAttemptAt: sendAt,
+		CreatedAt:     now,
+		UpdatedAt:     now,
+	}
+	if err := s.scheduledRepo.Create(ctx, send); err != nil {
+		return nil, errors.InternalError(err)
+	}
+	return send, nil
+}
+
+// sendTime returns when a send is due: at sendAt, or when the undo window
+// closes. A time in the past is due at once.
+func (s *ScheduledSendService) sendTime(sendAt *time.Time, undoWindow time.Duration) (domain.ScheduledSendKind, time.Time, error) {
+	now := time.Now().UTC().Truncate(time.Microsecond)
+	if sendAt == nil {
+		if undoWindow < 0 || undoWindow > s.config.MaxUndoWindow {
+			return "", time.Time{}, errors.NewError(errors.ErrCodeValidationError, "Undo window is out of range").
+				WithDetail("max_undo_window", s.config.MaxUndoWindow.String())
+		}
+		return domain.ScheduledSendKindUndo, now.Add(undoWindow), nil
+	}
+
+	at := sendAt.UTC().Truncate(time.Microsecond)
+	if s.config.MaxDelay > 0 && at.After(now.Add(s.config.MaxDelay)) {
+		return "", time.Time{}, errors.NewError(errors.ErrCodeValidationError, "Send time is too far ahead").
+			WithDetail("max_delay", s.config.MaxDelay.String())
+	}
+	if at.Before(now) {
+		at = now
+	}
+	return domain.ScheduledSendKindScheduled, at, nil
+}
+
+// lock leases a pending send for an edit
+func (s *ScheduledSendService) lock(ctx context.Context, send *domain.ScheduledSend) error {
+	locked, err := s.scheduledRepo.Lock(ctx, send.ID, time.Now(), s.config.Lease)
+	if err != nil {
+		return errors.InternalError(err)
+	}
+	if !locked {
+		return s.unavailable(ctx, send.ID)
+	}
+	return nil
+}
+
+// unavailable explains why a send could not be leased or cancelled
+func (s *ScheduledSendService) unavailable(ctx context.Context, id string) error {
+	send, err := s.scheduledRepo.GetByID(ctx, id)
+	if err != nil {
+		return errors.InternalError(err)
+	}
+	if send == nil {
+		return errors.ScheduledSendNotFound(id)
+	}
+	if send.Status != domain.ScheduledSendStatusPending {
+		return errors.ScheduledSendClosed(id, string(send.Status))
+	}
+	return errors.ScheduledSendLocked(id)
+}
+
+// draftOf returns the draft of a send, or nil when it was deleted
+func (s *ScheduledSendService) draftOf(ctx context.Context, send *domain.ScheduledSend) (*domain.Message, error) {
+	draft, err := s.messageRepo.GetByID(ctx, send.DraftID)
+	if err != nil {
+		return nil, errors.InternalError(err)
+	}
+	if draft == nil || !draft.IsDraft {
+		return nil, nil
+	}
+	return draft, nil
+}
+
+// ownedSend returns a scheduled send of one of the user's accounts
+func (s *ScheduledSendService) ownedSend(ctx context.Context, userID, id string) (*domain.ScheduledSend, error) {
+	send, err := s.scheduledRepo.GetByID(ctx, id)
+	if err != nil {
+		return nil, errors.InternalError(err)
+	}
+	if send == nil {
+		return nil, errors.ScheduledSendNotFound(id)
+	}
+	account, err := s.accountRepo.GetByID(ctx, send.AccountID)
+	if err != nil {
+		return nil, errors.InternalError(err)
+	}
+	if account == nil || account.UserID != userID {
+		// Do not reveal sends owned by other users
+		return nil, errors.ScheduledSendNotFound(id)
+	}
+	return send, nil
+}
+
+func (s *ScheduledSendService) retryDelay(attempts int) time.Duration {
+	return retryBackoff(s.config.RetryDelay, s.config.MaxRetryDelay, attempts)
+}
+
+// requireRecipients checks that a message to be sent has a recipient
+func requireRecipients(lists ...[]string) error {
+	for _, list := range lists {
+		if len(list) > 0 {
+			return nil
+		}
+	}
+	return errors.NewError(errors.ErrCodeInvalidRecipients, "At least one recipient is required")
+}
+
+// retryableSendError reports whether sending may succeed later: internal
+// failures, rate limits and failed scans, as opposed to rejected messages
+func retryableSendError(err error) bool {
+	var serviceErr *errors.Error
+	if !stderrors.As(err, &serviceErr) {
+		return true
+	}
+	switch serviceErr.Code {
+	case errors.ErrCodeInternalError, errors.ErrCodeDatabaseError, errors.ErrCodeNetworkError,
+		errors.ErrCodeTimeout, errors.ErrCodeRateLimitExceeded, errors.ErrCodeScanFailed:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,204 @@
+package service
+
+import (
+	"context"
+	stderrors "errors"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/skygenesisenterprise/aether-mailer/package/golang/domain"
+	"github.com/skygenesisenterprise/aether-mailer/package/golang/errors"
+	"github.com/skygenesisenterprise/aether-mailer/package/golang/repository"
+	"github.com/skygenesisenterprise/aether-mailer/package/golang/repository/inmemory"
+)
+
+// recordingTransactor runs work directly and records how each transaction
+// ended, since the in-memory store cannot roll back
+type recordingTransactor struct {
+	results []error
+}
+
+func (t *recordingTransactor) WithinTransaction(ctx context.Context, fn func(ctx context.Context) error) error {
+	err := fn(ctx)
+	t.results = append(t.results, err)
+	return err
+}
+
+func TestScheduledSendServiceDispatchWithExpiredLease(t *testing.T) {
+	ctx := context.Background()
+	m := newTestMail(t)
+	alice := m.newAccount(t, m.newUser(t, "alice"), "alice")
+	messages := NewMessageService(m.messageDeps())
+	drafts := NewDraftService(m.accounts, m.folders, m.messages, messages, nil, nil, nil, nil)
+	sends := inmemory.NewScheduledSendRepository(m.store)
+
+	now := time.Now().UTC().Truncate(time.Microsecond)
+	draft := &domain.Message{
+		ID:        uuid.NewString(),
+		AccountID: alice.ID,
+		FolderID:  m.folder(t, alice, domain.FolderTypeDrafts).ID,
+		From:      alice.Email,
+		To:        []string{"bob@one.example"},
+		Subject:   "Later",
+		BodyText:  stringPtr("Sent by one dispatcher only"),
+		IsDraft:   true,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+	if err := m.messages.Create(ctx, draft); err != nil {
+		t.Fatal(err)
+	}
+	send := &domain.ScheduledSend{
+		ID:            uuid.NewString(),
+		AccountID:     alice.ID,
+		DraftID:       draft.ID,
+		Kind:          domain.ScheduledSendKindScheduled,
+		Status:        domain.ScheduledSendStatusPending,
+		SendAt:        now,
+		NextAttemptAt: now,
+		CreatedAt:     now,
+		UpdatedAt:     now,
+	}
+	if err := sends.Create(ctx, send); err != nil {
+		t.Fatal(err)
+	}
+
+	slow, fast := &recordingTransactor{}, &recordingTransactor{}
+	config := ScheduledSendConfig{Lease: time.Minute, MaxAttempts: 3}
+	slowDispatcher := NewScheduledSendService(sends, m.accounts, m.messages, drafts, slow, config)
+	fastDispatcher := NewScheduledSendService(sends, m.accounts, m.messages, drafts, fast, config)
+
+	// The first dispatcher stalls until its lease expires and the second
+	// dispatcher claims the send
+	first, err := sends.Claim(ctx, now, 10, time.Minute)
+	if err != nil || len(first) != 1 {
+		t.Fatalf("Claim = %d sends, %v", len(first), err)
+	}
+	second, err := sends.Claim(ctx, now.Add(2*time.Minute), 10, time.Minute)
+	if err != nil || len(second) != 1 {
+		t.Fatalf("Claim after the lease expired = %d sends, %v", len(second), err)
+	}
+
+	err = slowDispatcher.dispatch(ctx, first[0])
+	var serviceErr *errors.Error
+	if !stderrors.As(err, &serviceErr) || serviceErr.Code != errors.ErrCodeScheduledSendLocked {
+		t.Fatalf("dispatch with an expired claim = %v, want %s", err, errors.ErrCodeScheduledSendLocked)
+	}
+	if len(slow.results) != 1 || slow.results[0] == nil {
+		t.Fatalf("the sending transaction ended with %v, want an error so it rolls back", slow.results)
+	}
+	got, err := sends.GetByID(ctx, send.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Status != domain.ScheduledSendStatusPending || got.Attempts != 2 || got.LastError != "" ||
+		!sameInstant(got.LockedUntil, second[0].LockedUntil) {
+		t.Fatalf("send after the stale dispatch = %+v, want it pending under the second claim", got)
+	}
+
+	// The second dispatcher still holds its claim. The in-memory store kept
+	// the first dispatcher's writes, so the draft is gone and the send ends
+	// as cancelled rather than sent twice.
+	if err := fastDispatcher.dispatch(ctx, second[0]); err != nil {
+		t.Fatalf("dispatch with the current claim: %v", err)
+	}
+	got, err = sends.GetByID(ctx, send.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Status == domain.ScheduledSendStatusPending || got.LockedUntil != nil {
+		t.Fatalf("send after the current dispatch = %+v, want it completed", got)
+	}
+	if len(fast.results) != 0 {
+		t.Errorf("the second dispatcher sent the draft again")
+	}
+}
+
+// failingCompletion fails to complete one send
+type failingCompletion struct {
+	repository.ScheduledSendRepository
+	sendID string
+}
+
+func (r *failingCompletion) Complete(ctx context.Context, claim *domain.ScheduledSend, status domain.ScheduledSendStatus,
+	sentMessageID *string, lastError string, at time.Time) (bool, error) {
+	if claim.ID == r.sendID {
+		return false, stderrors.New("connection reset")
+	}
+	return r.ScheduledSendRepository.Complete(ctx, claim, status, sentMessageID, lastError, at)
+}
+
+func TestScheduledSendServiceProcessBatchContinuesAfterFailure(t *testing.T) {
+	ctx := context.Background()
+	m := newTestMail(t)
+	alice := m.newAccount(t, m.newUser(t, "alice"), "alice")
+	messages := NewMessageService(m.messageDeps())
+	drafts := NewDraftService(m.accounts, m.folders, m.messages, messages, nil, nil, nil, nil)
+	store := inmemory.NewScheduledSendRepository(m.store)
+
+	now := time.Now().UTC().Truncate(time.Microsecond).Add(-time.Minute)
+	schedule := func(draftID string) *domain.ScheduledSend {
+		t.Helper()
+		send := &domain.ScheduledSend{
+			ID:            uuid.NewString(),
+			AccountID:     alice.ID,
+			DraftID:       draftID,
+			Kind:          domain.ScheduledSendKindScheduled,
+			Status:        domain.ScheduledSendStatusPending,
+			SendAt:        now,
+			NextAttemptAt: now,
+			CreatedAt:     now,
+			UpdatedAt:     now,
+		}
+		if err := store.Create(ctx, send); err != nil {
+			t.Fatal(err)
+		}
+		return send
+	}
+	draft := &domain.Message{
+		ID:        uuid.NewString(),
+		AccountID: alice.ID,
+		FolderID:  m.folder(t, alice, domain.FolderTypeDrafts).ID,
+		From:      alice.Email,
+		To:        []string{"bob@one.example"},
+		Subject:   "Later",
+		BodyText:  stringPtr("Sent despite the other send failing"),
+		IsDraft:   true,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+	if err := m.messages.Create(ctx, draft); err != nil {
+		t.Fatal(err)
+	}
+	// The draft of the failing send is gone, so completing it as cancelled
+	// fails
+	failing := schedule(uuid.NewString())
+	healthy := schedule(draft.ID)
+
+	sends := &failingCompletion{ScheduledSendRepository: store, sendID: failing.ID}
+	dispatcher := NewScheduledSendService(sends, m.accounts, m.messages, drafts, nil, ScheduledSendConfig{})
+	n, err := dispatcher.ProcessBatch(ctx)
+	if n != 2 || err == nil {
+		t.Fatalf("ProcessBatch = %d, %v; want 2 sends claimed and an error", n, err)
+	}
+
+	got, err := store.GetByID(ctx, healthy.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Status != domain.ScheduledSendStatusSent {
+		t.Errorf("send after the batch = %s, want %s", got.Status, domain.ScheduledSendStatusSent)
+	}
+	got, err = store.GetByID(ctx, failing.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Status != domain.ScheduledSendStatusPending || got.LockedUntil == nil {
+		t.Errorf("failed send = %+v, want it pending until its lease expires", got)
+	}
+}
+
+func sameInstant(a, b *time.Time) bool {
+	return a != nil && b != nil && a.Equal(*b)
+}
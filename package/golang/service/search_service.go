@@ -0,0 +1,333 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"mime"
+	"strings"
+
+	"github.com/skygenesisenterprise/aether-mailer/package/golang/domain"
+	"github.com/skygenesisenterprise/aether-mailer/package/golang/errors"
+	"github.com/skygenesisenterprise/aether-mailer/package/golang/repository"
+)
+
+const (
+	maxSearchResults     = 200
+	reindexBatchSize     = 500
+	maxAttachmentTextLen = 1 << 20 // bytes read from one attachment
+)
+
+// BlobReader reads stored blobs
+type BlobReader interface {
+	Open(ctx context.Context, id string, offset, length int64) (io.ReadCloser, *domain.Blob, error)
+}
+
+// TextExtractor extracts the text of attachments the search service cannot
+// read itself, such as PDF or office documents. It returns an empty string
+// for content types it does not support.
+type TextExtractor interface {
+	ExtractText(ctx context.Context, contentType string, content io.Reader) (string, error)
+}
+
+// SearchService indexes messages for full-text search and answers ranked
+// searches. Messages are indexed when they are sent, received or released
+// from quarantine by subscribing the service to the outbox relay, and the
+// index of an account can be rebuilt with ReindexAccount, or of every
+// account with ReindexAll.
+type SearchService struct {
+	index          repository.SearchIndex
+	accountRepo    repository.EmailAccountRepository
+	messageRepo    repository.MessageRepository
+	attachmentRepo repository.AttachmentRepository
+	blobs          BlobReader
+	extractor      TextExtractor
+}
+
+// NewSearchService creates a new search service. attachmentRepo, blobs and
+// extractor are optional; without them only the filenames of attachments
+// stored outside the message, or not in a text format, are indexed.
+func NewSearchService(
+	index repository.SearchIndex,
+	accountRepo repository.EmailAccountRepository,
+	messageRepo repository.MessageRepository,
+	attachmentRepo repository.AttachmentRepository,
+	blobs BlobReader,
+	extractor TextExtractor,
+) *SearchService {
+	return &SearchService{
+		index:          index,
+		accountRepo:    accountRepo,
+		messageRepo:    messageRepo,
+		attachmentRepo: attachmentRepo,
+		blobs:          blobs,
+		extractor:      extractor,
+	}
+}
+
+// SearchRequest is a ranked search of one of the user's accounts
+type SearchRequest struct {
+	AccountID string
+	Query     string // syntax of domain.ParseSearchQuery
+	FolderID  string // optional
+	Limit     int
+	Offset    int
+}
+
+// SearchResult is a page of messages, best first, and the number of hits
+type SearchResult struct {
+	Messages []*domain.Message
+	Scores   map[string]float64 // by message ID
+	Total    int
+}
+
+// Search returns the messages of one of the user's accounts matching a
+// query, ranked by relevance and recency
+func (s *SearchService) Search(ctx context.Context, userID string, req SearchRequest) (*SearchResult, error) {
+	account, err := s.accountRepo.GetByID(ctx, req.AccountID)
+	if err != nil {
+		return nil, errors.InternalError(err)
+	}
+	if account == nil || account.UserID != userID {
+		// Do not reveal accounts owned by other users
+		return nil, errors.EmailAccountNotFound(req.AccountID)
+	}
+
+	query, err := domain.ParseSearchQuery(req.Query)
+	if err != nil {
+		return nil, errors.NewError(errors.ErrCodeValidationError, err.Error()).
+			WithDetail("query", req.Query)
+	}
+	if req.Limit <= 0 || req.Limit > maxSearchResults {
+		req.Limit = maxSearchResults
+	}
+	indexQuery := repository.SearchIndexQuery{
+		AccountID: account.ID,
+		Query:     query,
+		Limit:     req.Limit,
+		Offset:    req.Offset,
+	}
+	if req.FolderID != "" {
+		indexQuery.FolderID = &req.FolderID
+	}
+
+	hits, total, err := s.index.Search(ctx, indexQuery)
+	if err != nil {
+		return nil, errors.InternalError(err)
+	}
+	result := &SearchResult{Messages: []*domain.Message{}, Scores: make(map[string]float64), Total: total}
+	for _, hit := range hits {
+		message, err := s.messageRepo.GetByID(ctx, hit.MessageID)
+		if err != nil {
+			return nil, errors.InternalError(err)
+		}
+		if message == nil {
+			// Deleted since the search
+			result.Total--
+			continue
+		}
+		result.Messages = append(result.Messages, message)
+		result.Scores[message.ID] = hit.Score
+	}
+	return result, nil
+}
+
+// IndexMessage writes the search document of a message with the text of
+// its attachments, replacing the previous one
+func (s *SearchService) IndexMessage(ctx context.Context, message *domain.Message) error {
+	document := domain.NewSearchDocument(message)
+	texts := []string{document.Attachments}
+	for i := range message.Attachments {
+		text, err := s.attachmentText(ctx, &message.Attachments[i])
+		if err != nil {
+			// Index the rest of the message
+			continue
+		}
+		if text != "" {
+			texts = append(texts, text)
+		}
+	}
+	document.Attachments = strings.Join(texts, "\n")
+
+	if err := s.index.Index(ctx, document); err != nil {
+		return errors.InternalError(err)
+	}
+	return nil
+}
+
+// ReindexAccount rebuilds the search documents of every message of an
+// account. Documents are replaced one by one, so searches keep answering
+// while it runs. It returns the number of messages indexed.
+func (s *SearchService) ReindexAccount(ctx context.Context, accountID string) (int, error) {
+	account, err := s.accountRepo.GetByID(ctx, accountID)
+	if err != nil {
+		return 0, errors.InternalError(err)
+	}
+	if account == nil {
+		return 0, errors.EmailAccountNotFound(accountID)
+	}
+
+	indexed := 0
+	for {
+		messages, err := s.messageRepo.ListByAccount(ctx, accountID, repository.MessageFilter{
+			Limit:  reindexBatchSize,
+			Offset: indexed,
+		})
+		if err != nil {
+			return indexed, errors.InternalError(err)
+		}
+		for _, message := range messages {
+			if err := ctx.Err(); err != nil {
+				return indexed, err
+			}
+			if err := s.IndexMessage(ctx, message); err != nil {
+				return indexed, err
+			}
+			indexed++
+		}
+		if len(messages) < reindexBatchSize {
+			return indexed, nil
+		}
+	}
+}
+
+// ReindexAll rebuilds the search documents of every account, for indexes
+// that do not outlive the process. It returns the number of messages
+// indexed.
+func (s *SearchService) ReindexAll(ctx context.Context) (int, error) {
+	indexed, offset := 0, 0
+	for {
+		accounts, err := s.accountRepo.List(ctx, repository.EmailAccountFilter{
+			Limit:  reindexBatchSize,
+			Offset: offset,
+		})
+		if err != nil {
+			return indexed, errors.InternalError(err)
+		}
+		for _, account := range accounts {
+			n, err := s.ReindexAccount(ctx, account.ID)
+			indexed += n
+			if err != nil {
+				return indexed, err
+			}
+		}
+		if len(accounts) < reindexBatchSize {
+			return indexed, nil
+		}
+		offset += len(accounts)
+	}
+}
+
+// CanHandle reports whether an event adds a message to a mailbox
+func (s *SearchService) CanHandle(eventType string) bool {
+	switch eventType {
+	case domain.EventTypeMessageReceived, domain.EventTypeMessageSent, domain.EventTypeMessageReleased:
+		return true
+	}
+	return false
+}
+
+// Handle indexes the message of an event. Indexing replaces the document,
+// so an event delivered twice is harmless.
+func (s *SearchService) Handle(ctx context.Context, event domain.Event) error {
+	messageID, err := eventMessageID(event)
+	if err != nil {
+		return errors.InternalError(err)
+	}
+
+	message, err := s.messageRepo.GetByID(ctx, messageID)
+	if err != nil {
+		return errors.InternalError(err)
+	}
+	if message == nil {
+		// Deleted before it was indexed
+		return nil
+	}
+	return s.IndexMessage(ctx, message)
+}
+
+// attachmentText returns the text of an attachment, or an empty string when
+// it cannot be read as text
+func (s *SearchService) attachmentText(ctx context.Context, attachment *domain.Attachment) (string, error) {
+	contentType, _, err := mime.ParseMediaType(attachment.ContentType)
+	if err != nil {
+		contentType = strings.ToLower(attachment.ContentType)
+	}
+	plain := strings.HasPrefix(contentType, "text/") || contentType == "application/json" ||
+		contentType == "application/xml"
+	if !plain && s.extractor == nil {
+		return "", nil
+	}
+
+	content, err := s.attachmentContent(ctx, attachment)
+	if err != nil || content == nil {
+		return "", err
+	}
+	defer content.Close()
+
+	limited := io.LimitReader(content, maxAttachmentTextLen)
+	if !plain {
+		return s.extractor.ExtractText(ctx, contentType, limited)
+	}
+	data, err := io.ReadAll(limited)
+	if err != nil {
+		return "", err
+	}
+	text := strings.ToValidUTF8(string(data), " ")
+	if contentType == "text/html" {
+		text = domain.HTMLText(text)
+	}
+	return text, nil
+}
+
+// attachmentContent opens the content of an attachment, which listings and
+// some repositories leave out, or returns nil when it is not available
+func (s *SearchService) attachmentContent(ctx context.Context, attachment *domain.Attachment) (io.ReadCloser, error) {
+	if len(attachment.Content) > 0 {
+		return io.NopCloser(bytes.NewReader(attachment.Content)), nil
+	}
+	if attachment.BlobID != "" && s.blobs != nil {
+		reader, _, err := s.blobs.Open(ctx, attachment.BlobID, 0, -1)
+		return reader, err
+	}
+	if s.attachmentRepo != nil {
+		stored, err := s.attachmentRepo.GetByID(ctx, attachment.ID)
+		if err != nil {
+			return nil, err
+		}
+		if stored != nil && len(stored.Content) > 0 {
+			return io.NopCloser(bytes.NewReader(stored.Content)), nil
+		}
+	}
+	return nil, nil
+}
+
+// eventMessageID returns the message an event adds to a mailbox
+func eventMessageID(event domain.Event) (string, error) {
+	if event.EventType() != domain.EventTypeMessageReleased {
+		return event.AggregateID(), nil
+	}
+	// The aggregate is the quarantine entry
+	var data struct {
+		MessageID string `json:"messageID"`
+	}
+	if err := decodeEventData(event, &data); err != nil {
+		return "", err
+	}
+	return data.MessageID, nil
+}
+
+// decodeEventData decodes the data of an event, which is a json.RawMessage
+// when it comes from the outbox
+func decodeEventData(event domain.Event, target interface{}) error {
+	data, ok := event.Data().(json.RawMessage)
+	if !ok {
+		encoded, err := json.Marshal(event.Data())
+		if err != nil {
+			return err
+		}
+		data = encoded
+	}
+	return json.Unmarshal(data, target)
+}
@@ -0,0 +1,51 @@
+package service
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/skygenesisenterprise/aether-mailer/package/golang/domain"
+	"github.com/skygenesisenterprise/aether-mailer/package/golang/repository/inmemory"
+)
+
+func TestSearchServiceReindexAll(t *testing.T) {
+	ctx := context.Background()
+	m := newTestMail(t)
+	alice := m.newUser(t, "alice")
+	accounts := []*domain.EmailAccount{m.newAccount(t, alice, "alice"), m.newAccount(t, nil, "support")}
+
+	// Messages stored before a restart, while the index starts empty
+	for _, account := range accounts {
+		now := time.Now()
+		message := &domain.Message{
+			ID:         uuid.NewString(),
+			AccountID:  account.ID,
+			FolderID:   m.folder(t, account, domain.FolderTypeInbox).ID,
+			From:       "bob@one.example",
+			To:         []string{account.Email},
+			Subject:    "Invoice for " + account.Email,
+			BodyText:   stringPtr("Payment is due"),
+			ReceivedAt: now,
+			CreatedAt:  now,
+			UpdatedAt:  now,
+		}
+		if err := m.messages.Create(ctx, message); err != nil {
+			t.Fatal(err)
+		}
+	}
+	search := NewSearchService(inmemory.NewSearchIndex(m.messages), m.accounts, m.messages, nil, nil, nil)
+
+	indexed, err := search.ReindexAll(ctx)
+	if err != nil || indexed != len(accounts) {
+		t.Fatalf("ReindexAll = %d, %v; want %d messages", indexed, err, len(accounts))
+	}
+	result, err := search.Search(ctx, alice.ID, SearchRequest{AccountID: accounts[0].ID, Query: "invoice"})
+	if err != nil {
+		t.Fatalf("Search: %v", err)
+	}
+	if result.Total != 1 || result.Messages[0].AccountID != accounts[0].ID {
+		t.Errorf("Search found %d messages, want the account's message", result.Total)
+	}
+}
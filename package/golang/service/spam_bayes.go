@@ -0,0 +1,157 @@
+package service
+
+import (
+	"math"
+	"net/url"
+	"sort"
+	"strings"
+	"unicode"
+
+	"github.com/skygenesisenterprise/aether-mailer/package/golang/domain"
+)
+
+const (
+	// bayesStrength and bayesAssumedProbability implement Robinson's
+	// smoothing for tokens that have been seen only a few times
+	bayesStrength           = 1.0
+	bayesAssumedProbability = 0.5
+	minTokenLength          = 3
+	maxTokenLength          = 40
+)
+
+// tokenizeMessage extracts the distinct tokens used for Bayesian classification
+func tokenizeMessage(message *domain.Message) []string {
+	seen := make(map[string]struct{})
+	add := func(token string) {
+		if token != "" {
+			seen[token] = struct{}{}
+		}
+	}
+
+	for _, word := range splitWords(message.Subject) {
+		add("subject:" + word)
+	}
+
+	if senderDomain := addressDomain(message.From); senderDomain != "" {
+		add("from:" + senderDomain)
+	}
+
+	body := ""
+	if message.BodyText != nil {
+		body = *message.BodyText
+	}
+	if message.BodyHTML != nil {
+		for _, link := range extractURLs(*message.BodyHTML) {
+			if u, err := url.Parse(link); err == nil && u.Hostname() != "" {
+				add("url:" + strings.ToLower(u.Hostname()))
+			}
+		}
+		if body == "" {
+			body = stripTags(*message.BodyHTML)
+		}
+	}
+	for _, link := range extractURLs(body) {
+		if u, err := url.Parse(link); err == nil && u.Hostname() != "" {
+			add("url:" + strings.ToLower(u.Hostname()))
+		}
+	}
+	for _, word := range splitWords(body) {
+		add(word)
+	}
+
+	for _, att := range message.Attachments {
+		add("attachment:" + strings.ToLower(att.ContentType))
+	}
+
+	tokens := make([]string, 0, len(seen))
+	for token := range seen {
+		tokens = append(tokens, token)
+	}
+	sort.Strings(tokens)
+	return tokens
+}
+
+// splitWords lower-cases text and splits it into classification words
+func splitWords(text string) []string {
+	fields := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != '\'' && r != '$'
+	})
+
+	words := make([]string, 0, len(fields))
+	for _, field := range fields {
+		field = strings.Trim(field, "'")
+		if len(field) < minTokenLength || len(field) > maxTokenLength {
+			continue
+		}
+		if isAllDigits(field) {
+			continue
+		}
+		words = append(words, field)
+	}
+	return words
+}
+
+func isAllDigits(s string) bool {
+	for _, r := range s {
+		if !unicode.IsDigit(r) {
+			return false
+		}
+	}
+	return true
+}
+
+// tokenProbability returns the smoothed spam probability of a single token
+func tokenProbability(token *domain.SpamToken, totals *domain.SpamTotals) float64 {
+	if token == nil || totals.SpamMessages == 0 || totals.HamMessages == 0 {
+		return bayesAssumedProbability
+	}
+
+	spamFreq := float64(token.SpamCount) / float64(totals.SpamMessages)
+	hamFreq := float64(token.HamCount) / float64(totals.HamMessages)
+	if spamFreq+hamFreq == 0 {
+		return bayesAssumedProbability
+	}
+
+	p := spamFreq / (spamFreq + hamFreq)
+	n := float64(token.SpamCount + token.HamCount)
+	return (bayesStrength*bayesAssumedProbability + n*p) / (bayesStrength + n)
+}
+
+// combineProbabilities combines the most significant token probabilities
+// using Fisher's method, returning a value between 0 (ham) and 1 (spam)
+func combineProbabilities(probabilities []float64, maxTokens int) float64 {
+	sort.Slice(probabilities, func(i, j int) bool {
+		return math.Abs(probabilities[i]-0.5) > math.Abs(probabilities[j]-0.5)
+	})
+	if maxTokens > 0 && len(probabilities) > maxTokens {
+		probabilities = probabilities[:maxTokens]
+	}
+	if len(probabilities) == 0 {
+		return bayesAssumedProbability
+	}
+
+	var spamLog, hamLog float64
+	for _, p := range probabilities {
+		p = math.Min(math.Max(p, 0.0001), 0.9999)
+		spamLog += math.Log(1 - p)
+		hamLog += math.Log(p)
+	}
+
+	n := len(probabilities)
+	spamness := 1 - chiSquareProbability(-2*spamLog, 2*n)
+	hamness := 1 - chiSquareProbability(-2*hamLog, 2*n)
+	return (1 + spamness - hamness) / 2
+}
+
+// chiSquareProbability returns the probability that a chi-square distributed
+// value with the given (even) degrees of freedom is at least chi
+func chiSquareProbability(chi float64, degrees int) float64 {
+	m := chi / 2
+	term := math.Exp(-m)
+	sum := term
+	for i := 1; i < degrees/2; i++ {
+		term *= m / float64(i)
+		sum += term
+	}
+	return math.Min(sum, 1.0)
+}
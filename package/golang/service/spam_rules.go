@@ -0,0 +1,352 @@
+package service
+
+import (
+	"net"
+	"net/mail"
+	"net/url"
+	"regexp"
+	"strings"
+	"unicode"
+
+	"github.com/skygenesisenterprise/aether-mailer/package/golang/domain"
+)
+
+var (
+	urlPattern     = regexp.MustCompile(`(?i)\bhttps?://[^\s"'<>)]+`)
+	anchorPattern  = regexp.MustCompile(`(?is)<a\s[^>]*href\s*=\s*["']([^"']+)["'][^>]*>(.*?)</a>`)
+	tagPattern     = regexp.MustCompile(`(?s)<[^>]*>`)
+	hiddenPattern  = regexp.MustCompile(`(?i)(display\s*:\s*none|visibility\s*:\s*hidden|font-size\s*:\s*0(px|pt|em)?\s*[;"'])`)
+	imagePattern   = regexp.MustCompile(`(?i)<img\s`)
+	formPattern    = regexp.MustCompile(`(?i)<form[\s>]`)
+	scriptPattern  = regexp.MustCompile(`(?i)<script[\s>]`)
+	moneyPattern   = regexp.MustCompile(`(?i)(\$\$\$|100% free|act now|click here|limited time|winner|risk[- ]free|no cost)`)
+	authResPattern = regexp.MustCompile(`(?i)\b(spf|dkim|dmarc|arc)\s*=\s*([a-z]+)`)
+)
+
+// urlShorteners lists hosts whose links hide the real destination
+var urlShorteners = map[string]bool{
+	"bit.ly":      true,
+	"tinyurl.com": true,
+	"goo.gl":      true,
+	"t.co":        true,
+	"ow.ly":       true,
+	"is.gd":       true,
+	"buff.ly":     true,
+	"cutt.ly":     true,
+	"rebrand.ly":  true,
+}
+
+// suspiciousTLDs lists top-level domains that are over-represented in spam
+var suspiciousTLDs = map[string]bool{
+	"zip":   true,
+	"mov":   true,
+	"top":   true,
+	"xyz":   true,
+	"click": true,
+	"work":  true,
+	"loan":  true,
+	"gq":    true,
+	"tk":    true,
+}
+
+// spamRule describes a single weighted heuristic
+type spamRule struct {
+	name        string
+	score       float64
+	description string
+}
+
+var (
+	ruleMissingDate        = spamRule{"MISSING_DATE", 0.8, "Message has no Date header"}
+	ruleMissingMessageID   = spamRule{"MISSING_MID", 0.8, "Message has no Message-ID header"}
+	ruleFromNameHasAddress = spamRule{"FROM_NAME_ADDR_MISMATCH", 1.5, "From display name contains a different address"}
+	ruleReplyToMismatch    = spamRule{"REPLYTO_DOMAIN_MISMATCH", 0.7, "Reply-To domain differs from From domain"}
+	ruleSubjectAllCaps     = spamRule{"SUBJ_ALL_CAPS", 1.0, "Subject is written in capitals"}
+	ruleSubjectExclamation = spamRule{"SUBJ_EXCLAMATION", 0.6, "Subject contains repeated exclamation marks"}
+	ruleSubjectMoney       = spamRule{"SUBJ_MONEY", 1.2, "Subject uses common spam phrases"}
+	ruleNoRecipients       = spamRule{"UNDISCLOSED_RECIPIENTS", 0.6, "Message has no visible recipients"}
+	ruleSPFPass            = spamRule{"SPF_PASS", -0.5, "SPF check passed"}
+	ruleSPFFail            = spamRule{"SPF_FAIL", 2.0, "SPF check failed"}
+	ruleSPFSoftFail        = spamRule{"SPF_SOFTFAIL", 0.8, "SPF check soft-failed"}
+	ruleDKIMPass           = spamRule{"DKIM_VALID", -0.5, "DKIM signature is valid"}
+	ruleDKIMFail           = spamRule{"DKIM_INVALID", 1.5, "DKIM signature is invalid"}
+	ruleDMARCPass          = spamRule{"DMARC_PASS", -1.0, "DMARC check passed"}
+	ruleDMARCFail          = spamRule{"DMARC_FAIL", 3.0, "DMARC check failed"}
+	ruleDMARCARCOverride   = spamRule{"DMARC_ARC_OVERRIDE", 0.5, "DMARC failure overridden by a trusted ARC sealer"}
+	ruleARCFail            = spamRule{"ARC_INVALID", 0.5, "ARC chain is invalid"}
+	ruleURLIPLiteral       = spamRule{"URI_IP_LITERAL", 2.0, "Body links to a bare IP address"}
+	ruleURLShortener       = spamRule{"URI_SHORTENER", 0.8, "Body uses a URL shortener"}
+	ruleURLSuspiciousTLD   = spamRule{"URI_SUSPICIOUS_TLD", 1.0, "Body links to a suspicious top-level domain"}
+	ruleURLMany            = spamRule{"URI_MANY", 0.5, "Body contains an unusually high number of links"}
+	ruleURLAnchorMismatch  = spamRule{"URI_ANCHOR_MISMATCH", 2.0, "Link text shows a different domain than its target"}
+	ruleHTMLOnly           = spamRule{"HTML_ONLY", 0.7, "Message has an HTML part but no text part"}
+	ruleHTMLHiddenText     = spamRule{"HTML_HIDDEN_TEXT", 1.5, "HTML contains hidden text"}
+	ruleHTMLImageOnly      = spamRule{"HTML_IMAGE_ONLY", 1.5, "HTML is mostly images with very little text"}
+	ruleHTMLForm           = spamRule{"HTML_FORM", 1.2, "HTML contains a form"}
+	ruleHTMLScript         = spamRule{"HTML_SCRIPT", 1.5, "HTML contains a script"}
+	ruleBodyMoney          = spamRule{"BODY_MONEY", 0.8, "Body uses common spam phrases"}
+)
+
+func (r spamRule) hit() domain.SpamRuleHit {
+	return domain.SpamRuleHit{Name: r.name, Score: r.score, Description: r.description}
+}
+
+// evaluateHeaderRules applies header based heuristics
+func evaluateHeaderRules(message *domain.Message) []domain.SpamRuleHit {
+	hits := []domain.SpamRuleHit{}
+
+	if headerValue(message, "Date") == "" {
+		hits = append(hits, ruleMissingDate.hit())
+	}
+	if headerValue(message, "Message-ID") == "" {
+		hits = append(hits, ruleMissingMessageID.hit())
+	}
+
+	fromDomain := addressDomain(message.From)
+	if from, err := mail.ParseAddress(message.From); err == nil && strings.Contains(from.Name, "@") {
+		if !strings.Contains(strings.ToLower(from.Name), strings.ToLower(from.Address)) {
+			hits = append(hits, ruleFromNameHasAddress.hit())
+		}
+	}
+	if replyTo := headerValue(message, "Reply-To"); replyTo != "" && fromDomain != "" {
+		if replyDomain := addressDomain(replyTo); replyDomain != "" && replyDomain != fromDomain {
+			hits = append(hits, ruleReplyToMismatch.hit())
+		}
+	}
+
+	if isShouting(message.Subject) {
+		hits = append(hits, ruleSubjectAllCaps.hit())
+	}
+	if strings.Contains(message.Subject, "!!") {
+		hits = append(hits, ruleSubjectExclamation.hit())
+	}
+	if moneyPattern.MatchString(message.Subject) {
+		hits = append(hits, ruleSubjectMoney.hit())
+	}
+	if len(message.To) == 0 && len(message.Cc) == 0 {
+		hits = append(hits, ruleNoRecipients.hit())
+	}
+
+	return hits
+}
+
+// evaluateAuthenticationRules scores SPF, DKIM, DMARC and ARC outcomes. A
+// DMARC failure vouched for by a trusted ARC sealer only scores the override.
+func evaluateAuthenticationRules(auth *domain.AuthenticationResults) []domain.SpamRuleHit {
+	hits := []domain.SpamRuleHit{}
+	if auth == nil {
+		return hits
+	}
+
+	switch auth.SPF {
+	case domain.AuthResultPass:
+		hits = append(hits, ruleSPFPass.hit())
+	case domain.AuthResultFail:
+		hits = append(hits, ruleSPFFail.hit())
+	case domain.AuthResultSoftFail:
+		hits = append(hits, ruleSPFSoftFail.hit())
+	}
+
+	switch auth.DKIM {
+	case domain.AuthResultPass:
+		hits = append(hits, ruleDKIMPass.hit())
+	case domain.AuthResultFail:
+		hits = append(hits, ruleDKIMFail.hit())
+	}
+
+	switch auth.DMARC {
+	case domain.AuthResultPass:
+		hits = append(hits, ruleDMARCPass.hit())
+	case domain.AuthResultFail:
+		if auth.DMARCOverride != "" {
+			hits = append(hits, ruleDMARCARCOverride.hit())
+		} else {
+			hits = append(hits, ruleDMARCFail.hit())
+		}
+	}
+
+	if auth.ARC == domain.AuthResultFail {
+		hits = append(hits, ruleARCFail.hit())
+	}
+
+	return hits
+}
+
+// evaluateURLRules applies heuristics to the links found in the body
+func evaluateURLRules(message *domain.Message) []domain.SpamRuleHit {
+	hits := []domain.SpamRuleHit{}
+
+	content := ""
+	if message.BodyText != nil {
+		content += *message.BodyText + "\n"
+	}
+	if message.BodyHTML != nil {
+		content += *message.BodyHTML
+	}
+
+	links := extractURLs(content)
+	var ipLiteral, shortener, suspicious bool
+	for _, link := range links {
+		u, err := url.Parse(link)
+		if err != nil {
+			continue
+		}
+		host := strings.ToLower(u.Hostname())
+		if net.ParseIP(host) != nil {
+			ipLiteral = true
+		}
+		if urlShorteners[host] {
+			shortener = true
+		}
+		if idx := strings.LastIndex(host, "."); idx >= 0 && suspiciousTLDs[host[idx+1:]] {
+			suspicious = true
+		}
+	}
+
+	if ipLiteral {
+		hits = append(hits, ruleURLIPLiteral.hit())
+	}
+	if shortener {
+		hits = append(hits, ruleURLShortener.hit())
+	}
+	if suspicious {
+		hits = append(hits, ruleURLSuspiciousTLD.hit())
+	}
+	if len(links) > 20 {
+		hits = append(hits, ruleURLMany.hit())
+	}
+
+	if message.BodyHTML != nil {
+		for _, match := range anchorPattern.FindAllStringSubmatch(*message.BodyHTML, -1) {
+			target, err := url.Parse(match[1])
+			if err != nil || target.Hostname() == "" {
+				continue
+			}
+			shown := urlPattern.FindString(stripTags(match[2]))
+			if shown == "" {
+				continue
+			}
+			shownURL, err := url.Parse(shown)
+			if err != nil {
+				continue
+			}
+			if !strings.EqualFold(shownURL.Hostname(), target.Hostname()) {
+				hits = append(hits, ruleURLAnchorMismatch.hit())
+				break
+			}
+		}
+	}
+
+	return hits
+}
+
+// evaluateHTMLRules applies heuristics to the HTML body
+func evaluateHTMLRules(message *domain.Message) []domain.SpamRuleHit {
+	hits := []domain.SpamRuleHit{}
+	if message.BodyHTML == nil || *message.BodyHTML == "" {
+		if message.BodyText != nil && moneyPattern.MatchString(*message.BodyText) {
+			hits = append(hits, ruleBodyMoney.hit())
+		}
+		return hits
+	}
+
+	html := *message.BodyHTML
+	text := stripTags(html)
+
+	if message.BodyText == nil || strings.TrimSpace(*message.BodyText) == "" {
+		hits = append(hits, ruleHTMLOnly.hit())
+	}
+	if hiddenPattern.MatchString(html) {
+		hits = append(hits, ruleHTMLHiddenText.hit())
+	}
+	if images := len(imagePattern.FindAllString(html, -1)); images > 0 && len(strings.Fields(text)) < 20*images {
+		hits = append(hits, ruleHTMLImageOnly.hit())
+	}
+	if formPattern.MatchString(html) {
+		hits = append(hits, ruleHTMLForm.hit())
+	}
+	if scriptPattern.MatchString(html) {
+		hits = append(hits, ruleHTMLScript.hit())
+	}
+	if moneyPattern.MatchString(text) || (message.BodyText != nil && moneyPattern.MatchString(*message.BodyText)) {
+		hits = append(hits, ruleBodyMoney.hit())
+	}
+
+	return hits
+}
+
+// ParseAuthenticationResults extracts SPF, DKIM, DMARC and ARC outcomes from an
+// Authentication-Results header value
+func ParseAuthenticationResults(header string) *domain.AuthenticationResults {
+	if header == "" {
+		return nil
+	}
+
+	results := &domain.AuthenticationResults{
+		SPF:   domain.AuthResultNone,
+		DKIM:  domain.AuthResultNone,
+		DMARC: domain.AuthResultNone,
+		ARC:   domain.AuthResultNone,
+	}
+	for _, match := range authResPattern.FindAllStringSubmatch(header, -1) {
+		value := domain.AuthResult(strings.ToLower(match[2]))
+		switch strings.ToLower(match[1]) {
+		case "spf":
+			results.SPF = value
+		case "dkim":
+			// Keep the first passing signature when several are reported
+			if results.DKIM != domain.AuthResultPass {
+				results.DKIM = value
+			}
+		case "dmarc":
+			results.DMARC = value
+		case "arc":
+			results.ARC = value
+		}
+	}
+	return results
+}
+
+// Helper functions
+
+func headerValue(message *domain.Message, name string) string {
+	for key, value := range message.Headers {
+		if strings.EqualFold(key, name) {
+			return value
+		}
+	}
+	return ""
+}
+
+func addressDomain(address string) string {
+	if parsed, err := mail.ParseAddress(address); err == nil {
+		address = parsed.Address
+	}
+	if idx := strings.LastIndex(address, "@"); idx >= 0 {
+		return strings.ToLower(strings.Trim(address[idx+1:], "> "))
+	}
+	return ""
+}
+
+func extractURLs(content string) []string {
+	return urlPattern.FindAllString(content, -1)
+}
+
+func stripTags(html string) string {
+	text := tagPattern.ReplaceAllString(html, " ")
+	replacer := strings.NewReplacer("&nbsp;", " ", "&amp;", "&", "&lt;", "<", "&gt;", ">", "&quot;", "\"", "&#39;", "'")
+	return strings.TrimSpace(replacer.Replace(text))
+}
+
+func isShouting(subject string) bool {
+	letters, upper := 0, 0
+	for _, r := range subject {
+		if unicode.IsLetter(r) {
+			letters++
+			if unicode.IsUpper(r) {
+				upper++
+			}
+		}
+	}
+	return letters >= 10 && float64(upper)/float64(letters) > 0.8
+}
@@ -0,0 +1,340 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/skygenesisenterprise/aether-mailer/package/golang/domain"
+	"github.com/skygenesisenterprise/aether-mailer/package/golang/errors"
+	"github.com/skygenesisenterprise/aether-mailer/package/golang/repository"
+)
+
+// Spam headers added to classified messages
+const (
+	HeaderSpamScore  = "X-Spam-Score"
+	HeaderSpamStatus = "X-Spam-Status"
+	HeaderSpamFlag   = "X-Spam-Flag"
+	HeaderSpamBayes  = "X-Spam-Bayes"
+)
+
+// SpamService classifies messages and trains the per-account Bayesian model
+type SpamService struct {
+	spamRepo    repository.SpamRepository
+	messageRepo repository.MessageRepository
+	folderRepo  repository.FolderRepository
+	accountRepo repository.EmailAccountRepository
+	policyRepo  repository.PolicyRepository
+	eventPub    domain.EventPublisher
+	config      *SpamConfig
+}
+
+// SpamConfig defines spam service configuration
+type SpamConfig struct {
+	EnableSpamFilter  bool
+	SpamThreshold     float64 // 0.0 to 1.0
+	BayesWeight       float64 // share of the final score taken from the Bayesian model
+	BayesMinTraining  int     // messages required per class before the model is used
+	BayesMaxTokens    int     // most significant tokens combined per message
+	HeuristicMidpoint float64 // heuristic points that map to a 0.5 score
+}
+
+// NewSpamService creates a new spam service
+func NewSpamService(
+	spamRepo repository.SpamRepository,
+	messageRepo repository.MessageRepository,
+	folderRepo repository.FolderRepository,
+	accountRepo repository.EmailAccountRepository,
+	policyRepo repository.PolicyRepository,
+	eventPub domain.EventPublisher,
+	config *SpamConfig,
+) *SpamService {
+	return &SpamService{
+		spamRepo:    spamRepo,
+		messageRepo: messageRepo,
+		folderRepo:  folderRepo,
+		accountRepo: accountRepo,
+		policyRepo:  policyRepo,
+		eventPub:    eventPub,
+		config:      config,
+	}
+}
+
+// Classify scores a message without modifying it
+func (s *SpamService) Classify(ctx context.Context, message *domain.Message, auth *domain.AuthenticationResults) (*domain.SpamVerdict, error) {
+	if auth == nil {
+		auth = ParseAuthenticationResults(headerValue(message, "Authentication-Results"))
+	}
+
+	verdict := &domain.SpamVerdict{
+		MessageID:    message.ID,
+		AccountID:    message.AccountID,
+		Threshold:    s.config.SpamThreshold,
+		Rules:        []domain.SpamRuleHit{},
+		ClassifiedAt: time.Now(),
+	}
+
+	verdict.Rules = append(verdict.Rules, evaluateHeaderRules(message)...)
+	verdict.Rules = append(verdict.Rules, evaluateAuthenticationRules(auth)...)
+	verdict.Rules = append(verdict.Rules, evaluateURLRules(message)...)
+	verdict.Rules = append(verdict.Rules, evaluateHTMLRules(message)...)
+
+	for _, rule := range verdict.Rules {
+		verdict.HeuristicScore += rule.Score
+	}
+	heuristic := 1 / (1 + math.Exp(-(verdict.HeuristicScore - s.config.HeuristicMidpoint)))
+
+	bayes, err := s.bayesProbability(ctx, message)
+	if err != nil {
+		return nil, errors.InternalError(err)
+	}
+	verdict.BayesProbability = bayes
+
+	verdict.Score = heuristic
+	if bayes != nil {
+		verdict.Score = s.config.BayesWeight*(*bayes) + (1-s.config.BayesWeight)*heuristic
+	}
+	verdict.Score = math.Round(verdict.Score*1000) / 1000
+	verdict.IsSpam = verdict.Score > s.config.SpamThreshold
+
+	return verdict, nil
+}
+
+// FilterInbound classifies an inbound message, stamps the X-Spam headers and
+// files it into the account's spam folder when it scores above the threshold.
+// Senders on the account owner's allow-list are never filed as spam.
+func (s *SpamService) FilterInbound(ctx context.Context, message *domain.Message, auth *domain.AuthenticationResults) (*domain.SpamVerdict, error) {
+	if !s.config.EnableSpamFilter {
+		return nil, nil
+	}
+
+	verdict, err := s.Classify(ctx, message, auth)
+	if err != nil {
+		return nil, err
+	}
+
+	if verdict.IsSpam {
+		allowed, err := s.isAllowListed(ctx, message)
+		if err != nil {
+			return nil, err
+		}
+		if allowed {
+			verdict.IsSpam = false
+			verdict.Rules = append(verdict.Rules, domain.SpamRuleHit{
+				Name:        "SENDER_ALLOW_LISTED",
+				Description: "Sender is on the recipient's allow-list",
+			})
+		}
+	}
+
+	applySpamHeaders(message, verdict)
+
+	if verdict.IsSpam {
+		spamFolder, err := s.folderRepo.GetByType(ctx, message.AccountID, domain.FolderTypeSpam)
+		if err != nil {
+			return nil, errors.InternalError(err)
+		}
+		if spamFolder != nil {
+			message.FolderID = spamFolder.ID
+		}
+
+		event := domain.NewBaseEvent(uuid.New().String(), message.ID, domain.EventTypePolicyTriggered, map[string]interface{}{
+			"type":      domain.PolicyTypeSpam,
+			"accountID": message.AccountID,
+			"score":     verdict.Score,
+			"threshold": verdict.Threshold,
+			"rules":     ruleNames(verdict.Rules),
+		})
+		if err := s.eventPub.Publish(ctx, event); err != nil {
+			// Log error but don't fail the operation
+		}
+	}
+
+	return verdict, nil
+}
+
+// Train records a message as spam or ham for its account. Retraining a message
+// with the opposite class first removes its previous contribution.
+func (s *SpamService) Train(ctx context.Context, message *domain.Message, class domain.SpamClass) error {
+	previous, err := s.spamRepo.GetMessageClass(ctx, message.AccountID, message.ID)
+	if err != nil {
+		return errors.InternalError(err)
+	}
+	if previous != nil && *previous == class {
+		return nil
+	}
+
+	tokens := tokenizeMessage(message)
+	if previous != nil {
+		if err := s.applyTraining(ctx, message.AccountID, tokens, *previous, -1); err != nil {
+			return err
+		}
+	}
+	if err := s.applyTraining(ctx, message.AccountID, tokens, class, 1); err != nil {
+		return err
+	}
+
+	if err := s.spamRepo.SetMessageClass(ctx, message.AccountID, message.ID, &class); err != nil {
+		return errors.InternalError(err)
+	}
+	return nil
+}
+
+// Untrain removes a message's contribution from its account's model
+func (s *SpamService) Untrain(ctx context.Context, message *domain.Message) error {
+	previous, err := s.spamRepo.GetMessageClass(ctx, message.AccountID, message.ID)
+	if err != nil {
+		return errors.InternalError(err)
+	}
+	if previous == nil {
+		return nil
+	}
+
+	if err := s.applyTraining(ctx, message.AccountID, tokenizeMessage(message), *previous, -1); err != nil {
+		return err
+	}
+	if err := s.spamRepo.SetMessageClass(ctx, message.AccountID, message.ID, nil); err != nil {
+		return errors.InternalError(err)
+	}
+	return nil
+}
+
+// TrainFromMove trains the model when a user moves a message into or out of
+// the spam folder, whether through IMAP or the API
+func (s *SpamService) TrainFromMove(ctx context.Context, message *domain.Message, from, to *domain.Folder) error {
+	fromSpam := from != nil && from.Type == domain.FolderTypeSpam
+	toSpam := to != nil && to.Type == domain.FolderTypeSpam
+
+	switch {
+	case toSpam && !fromSpam:
+		return s.Train(ctx, message, domain.SpamClassSpam)
+	case fromSpam && !toSpam:
+		// Messages moved to the trash are not a statement about their content
+		if to != nil && to.Type == domain.FolderTypeTrash {
+			return nil
+		}
+		return s.Train(ctx, message, domain.SpamClassHam)
+	}
+	return nil
+}
+
+// GetTrainingStatus returns the training totals for an account
+func (s *SpamService) GetTrainingStatus(ctx context.Context, accountID string) (*domain.SpamTotals, error) {
+	totals, err := s.spamRepo.GetTotals(ctx, accountID)
+	if err != nil {
+		return nil, errors.InternalError(err)
+	}
+	if totals == nil {
+		totals = &domain.SpamTotals{AccountID: accountID}
+	}
+	return totals, nil
+}
+
+// Helper functions
+
+func (s *SpamService) applyTraining(ctx context.Context, accountID string, tokens []string, class domain.SpamClass, delta int) error {
+	spamDelta, hamDelta := 0, 0
+	if class == domain.SpamClassSpam {
+		spamDelta = delta
+	} else {
+		hamDelta = delta
+	}
+
+	if err := s.spamRepo.IncrementTokens(ctx, accountID, tokens, spamDelta, hamDelta); err != nil {
+		return errors.InternalError(err)
+	}
+	if err := s.spamRepo.IncrementTotals(ctx, accountID, spamDelta, hamDelta); err != nil {
+		return errors.InternalError(err)
+	}
+	return nil
+}
+
+func (s *SpamService) bayesProbability(ctx context.Context, message *domain.Message) (*float64, error) {
+	totals, err := s.spamRepo.GetTotals(ctx, message.AccountID)
+	if err != nil {
+		return nil, err
+	}
+	if totals == nil || totals.SpamMessages < s.config.BayesMinTraining || totals.HamMessages < s.config.BayesMinTraining {
+		return nil, nil
+	}
+
+	tokens := tokenizeMessage(message)
+	stats, err := s.spamRepo.GetTokens(ctx, message.AccountID, tokens)
+	if err != nil {
+		return nil, err
+	}
+
+	probabilities := make([]float64, 0, len(stats))
+	for _, token := range tokens {
+		if stat, ok := stats[token]; ok {
+			probabilities = append(probabilities, tokenProbability(stat, totals))
+		}
+	}
+	if len(probabilities) == 0 {
+		return nil, nil
+	}
+
+	probability := combineProbabilities(probabilities, s.config.BayesMaxTokens)
+	return &probability, nil
+}
+
+func applySpamHeaders(message *domain.Message, verdict *domain.SpamVerdict) {
+	if message.Headers == nil {
+		message.Headers = make(map[string]string)
+	}
+
+	status := "No"
+	if verdict.IsSpam {
+		status = "Yes"
+		message.Headers[HeaderSpamFlag] = "YES"
+	}
+
+	message.Headers[HeaderSpamScore] = fmt.Sprintf("%.3f", verdict.Score)
+	message.Headers[HeaderSpamStatus] = fmt.Sprintf("%s, score=%.3f threshold=%.3f tests=%s",
+		status, verdict.Score, verdict.Threshold, strings.Join(ruleNames(verdict.Rules), ","))
+	if verdict.BayesProbability != nil {
+		message.Headers[HeaderSpamBayes] = fmt.Sprintf("%.3f", *verdict.BayesProbability)
+	}
+}
+
+// isAllowListed reports whether the sender matches an allow policy of the
+// account owner
+func (s *SpamService) isAllowListed(ctx context.Context, message *domain.Message) (bool, error) {
+	account, err := s.accountRepo.GetByID(ctx, message.AccountID)
+	if err != nil {
+		return false, errors.InternalError(err)
+	}
+	if account == nil {
+		return false, nil
+	}
+
+	spamType := domain.PolicyTypeSpam
+	active := true
+	policies, err := s.policyRepo.GetActivePolicies(ctx, repository.PolicyFilter{
+		UserID:   &account.UserID,
+		Type:     &spamType,
+		IsActive: &active,
+	})
+	if err != nil {
+		return false, errors.InternalError(err)
+	}
+
+	rule := AllowListRule(extractAddress(message.From))
+	for _, policy := range policies {
+		if policy.Action == domain.PolicyActionAllow && policy.Rule == rule {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func ruleNames(rules []domain.SpamRuleHit) []string {
+	names := make([]string, 0, len(rules))
+	for _, rule := range rules {
+		names = append(names, rule.Name)
+	}
+	return names
+}
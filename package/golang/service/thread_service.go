@@ -0,0 +1,436 @@
+package service
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/skygenesisenterprise/aether-mailer/package/golang/domain"
+	"github.com/skygenesisenterprise/aether-mailer/package/golang/errors"
+	"github.com/skygenesisenterprise/aether-mailer/package/golang/repository"
+)
+
+const (
+	maxThreadsPerGet  = 100
+	rethreadBatchSize = 500
+)
+
+// ThreadService files messages in conversation threads the way JWZ
+// threading does: a message joins the thread of any message it refers to
+// by In-Reply-To or References, or that refers to it, so a parent that
+// arrives after its replies merges their threads. Messages that refer to
+// no known message can join a recent thread with the same base subject,
+// as the thread settings of the account allow.
+//
+// Messages are threaded when they are sent, received or released from
+// quarantine by subscribing the service to the outbox relay, and the
+// threads of an account can be rebuilt with RethreadAccount.
+type ThreadService struct {
+	threadRepo  repository.ThreadRepository
+	accountRepo repository.EmailAccountRepository
+	messageRepo repository.MessageRepository
+}
+
+// NewThreadService creates a new thread service
+func NewThreadService(
+	threadRepo repository.ThreadRepository,
+	accountRepo repository.EmailAccountRepository,
+	messageRepo repository.MessageRepository,
+) *ThreadService {
+	return &ThreadService{
+		threadRepo:  threadRepo,
+		accountRepo: accountRepo,
+		messageRepo: messageRepo,
+	}
+}
+
+// ThreadView is a thread with its messages, oldest first
+type ThreadView struct {
+	Thread   *domain.Thread
+	Messages []*domain.Message
+}
+
+// ListThreads lists the threads of one of the user's accounts, latest
+// message first, with the number of matching threads
+func (s *ThreadService) ListThreads(ctx context.Context, userID, accountID string, filter repository.ThreadFilter) ([]*domain.Thread, int, error) {
+	if _, err := s.ownedAccount(ctx, userID, accountID); err != nil {
+		return nil, 0, err
+	}
+
+	threads, err := s.threadRepo.ListByAccount(ctx, accountID, filter)
+	if err != nil {
+		return nil, 0, errors.InternalError(err)
+	}
+	total, err := s.threadRepo.CountByAccount(ctx, accountID, filter)
+	if err != nil {
+		return nil, 0, errors.InternalError(err)
+	}
+	return threads, total, nil
+}
+
+// GetThread returns a thread of one of the user's accounts with its messages
+func (s *ThreadService) GetThread(ctx context.Context, userID, threadID string) (*ThreadView, error) {
+	thread, err := s.threadRepo.GetByID(ctx, threadID)
+	if err != nil {
+		return nil, errors.InternalError(err)
+	}
+	if thread == nil {
+		return nil, errors.ThreadNotFound(threadID)
+	}
+	account, err := s.accountRepo.GetByID(ctx, thread.AccountID)
+	if err != nil {
+		return nil, errors.InternalError(err)
+	}
+	if account == nil || account.UserID != userID {
+		// Do not reveal threads owned by other users
+		return nil, errors.ThreadNotFound(threadID)
+	}
+	return s.view(ctx, thread)
+}
+
+// GetThreads returns threads of one of the user's accounts with their
+// messages, in the order of ids, and the IDs that match no thread of the
+// account
+func (s *ThreadService) GetThreads(ctx context.Context, userID, accountID string, ids []string) ([]*ThreadView, []string, error) {
+	if _, err := s.ownedAccount(ctx, userID, accountID); err != nil {
+		return nil, nil, err
+	}
+	if len(ids) > maxThreadsPerGet {
+		return nil, nil, errors.NewError(errors.ErrCodeValidationError, "Too many threads in one request").
+			WithDetail("max", maxThreadsPerGet)
+	}
+
+	views := []*ThreadView{}
+	notFound := []string{}
+	seen := make(map[string]bool, len(ids))
+	for _, id := range ids {
+		if seen[id] {
+			continue
+		}
+		seen[id] = true
+
+		thread, err := s.threadRepo.GetByID(ctx, id)
+		if err != nil {
+			return nil, nil, errors.InternalError(err)
+		}
+		if thread == nil || thread.AccountID != accountID {
+			notFound = append(notFound, id)
+			continue
+		}
+		view, err := s.view(ctx, thread)
+		if err != nil {
+			return nil, nil, err
+		}
+		views = append(views, view)
+	}
+	return views, notFound, nil
+}
+
+// GetSettings returns the thread settings of one of the user's accounts,
+// the defaults when it has none
+func (s *ThreadService) GetSettings(ctx context.Context, userID, accountID string) (*domain.ThreadSettings, error) {
+	if _, err := s.ownedAccount(ctx, userID, accountID); err != nil {
+		return nil, err
+	}
+	return s.settings(ctx, accountID)
+}
+
+// UpdateSettings sets when messages of one of the user's accounts join a
+// thread by subject. It applies to the messages threaded from then on.
+func (s *ThreadService) UpdateSettings(ctx context.Context, userID, accountID string, mode domain.ThreadSubjectMode) (*domain.ThreadSettings, error) {
+	if _, err := s.ownedAccount(ctx, userID, accountID); err != nil {
+		return nil, err
+	}
+	if !mode.Valid() {
+		return nil, errors.NewError(errors.ErrCodeValidationError, "Unknown subject threading mode").
+			WithDetail("subject_mode", mode)
+	}
+
+	settings := &domain.ThreadSettings{AccountID: accountID, SubjectMode: mode, UpdatedAt: time.Now()}
+	if err := s.threadRepo.SaveSettings(ctx, settings); err != nil {
+		return nil, errors.InternalError(err)
+	}
+	return settings, nil
+}
+
+// AssignThread files a message in its thread, creating the thread or
+// merging the threads it joins, and saves the message with its ThreadID.
+// Threading a message again is harmless.
+func (s *ThreadService) AssignThread(ctx context.Context, message *domain.Message) (*domain.Thread, error) {
+	id, references := domain.ThreadHeaders(message)
+	messageIDs := references
+	if id != "" {
+		messageIDs = append(messageIDs, id)
+	}
+
+	threads, err := s.threadRepo.FindByMessageIDs(ctx, message.AccountID, messageIDs)
+	if err != nil {
+		return nil, errors.InternalError(err)
+	}
+	if message.ThreadID != "" && !containsThread(threads, message.ThreadID) {
+		current, err := s.threadRepo.GetByID(ctx, message.ThreadID)
+		if err != nil {
+			return nil, errors.InternalError(err)
+		}
+		if current != nil {
+			threads = append(threads, current)
+		}
+	}
+	if len(threads) == 0 {
+		bySubject, err := s.subjectThread(ctx, message)
+		if err != nil {
+			return nil, err
+		}
+		if bySubject != nil {
+			threads = append(threads, bySubject)
+		}
+	}
+
+	var thread *domain.Thread
+	if len(threads) == 0 {
+		now := time.Now()
+		thread = &domain.Thread{ID: uuid.New().String(), AccountID: message.AccountID, CreatedAt: now, UpdatedAt: now}
+		thread.Summarize([]*domain.Message{message})
+		if err := s.threadRepo.Create(ctx, thread); err != nil {
+			return nil, errors.InternalError(err)
+		}
+	} else {
+		// The oldest thread absorbs the others
+		sort.SliceStable(threads, func(i, j int) bool { return threads[i].CreatedAt.Before(threads[j].CreatedAt) })
+		thread = threads[0]
+		for _, other := range threads[1:] {
+			if err := s.merge(ctx, other, thread); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if err := s.threadRepo.LinkMessageIDs(ctx, message.AccountID, thread.ID, messageIDs); err != nil {
+		return nil, errors.InternalError(err)
+	}
+	if message.ThreadID != thread.ID {
+		message.ThreadID = thread.ID
+		if err := s.messageRepo.Update(ctx, message); err != nil {
+			return nil, errors.InternalError(err)
+		}
+	}
+	if err := s.refresh(ctx, thread); err != nil {
+		return nil, err
+	}
+	return thread, nil
+}
+
+// RefreshThreads recomputes the aggregates of threads after their messages
+// changed, and deletes the threads left without messages
+func (s *ThreadService) RefreshThreads(ctx context.Context, threadIDs ...string) error {
+	seen := make(map[string]bool, len(threadIDs))
+	for _, id := range threadIDs {
+		if id == "" || seen[id] {
+			continue
+		}
+		seen[id] = true
+
+		thread, err := s.threadRepo.GetByID(ctx, id)
+		if err != nil {
+			return errors.InternalError(err)
+		}
+		if thread == nil {
+			continue
+		}
+		if err := s.refresh(ctx, thread); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// RethreadAccount threads every message of an account, such as the
+// messages stored before threading was enabled. It returns the number of
+// messages threaded.
+func (s *ThreadService) RethreadAccount(ctx context.Context, accountID string) (int, error) {
+	account, err := s.accountRepo.GetByID(ctx, accountID)
+	if err != nil {
+		return 0, errors.InternalError(err)
+	}
+	if account == nil {
+		return 0, errors.EmailAccountNotFound(accountID)
+	}
+
+	threaded := 0
+	for {
+		messages, err := s.messageRepo.ListByAccount(ctx, accountID, repository.MessageFilter{
+			Limit:  rethreadBatchSize,
+			Offset: threaded,
+		})
+		if err != nil {
+			return threaded, errors.InternalError(err)
+		}
+		for _, message := range messages {
+			if err := ctx.Err(); err != nil {
+				return threaded, err
+			}
+			if _, err := s.AssignThread(ctx, message); err != nil {
+				return threaded, err
+			}
+			threaded++
+		}
+		if len(messages) < rethreadBatchSize {
+			return threaded, nil
+		}
+	}
+}
+
+// CanHandle reports whether an event adds a message to a mailbox
+func (s *ThreadService) CanHandle(eventType string) bool {
+	switch eventType {
+	case domain.EventTypeMessageReceived, domain.EventTypeMessageSent, domain.EventTypeMessageReleased:
+		return true
+	}
+	return false
+}
+
+// Handle threads the message of an event
+func (s *ThreadService) Handle(ctx context.Context, event domain.Event) error {
+	messageID, err := eventMessageID(event)
+	if err != nil {
+		return errors.InternalError(err)
+	}
+
+	message, err := s.messageRepo.GetByID(ctx, messageID)
+	if err != nil {
+		return errors.InternalError(err)
+	}
+	if message == nil {
+		// Deleted before it was threaded
+		return nil
+	}
+	_, err = s.AssignThread(ctx, message)
+	return err
+}
+
+// subjectThread returns the recent thread a message joins by subject, if
+// the settings of its account allow it
+func (s *ThreadService) subjectThread(ctx context.Context, message *domain.Message) (*domain.Thread, error) {
+	baseSubject := domain.BaseSubject(message.Subject)
+	if baseSubject == "" {
+		return nil, nil
+	}
+	settings, err := s.settings(ctx, message.AccountID)
+	if err != nil {
+		return nil, err
+	}
+	switch settings.SubjectMode {
+	case domain.ThreadSubjectAlways:
+	case domain.ThreadSubjectReplies:
+		if !domain.IsReplySubject(message.Subject) {
+			return nil, nil
+		}
+	default:
+		return nil, nil
+	}
+
+	thread, err := s.threadRepo.FindBySubject(ctx, message.AccountID, baseSubject,
+		message.ReceivedAt.Add(-domain.ThreadSubjectWindow))
+	if err != nil {
+		return nil, errors.InternalError(err)
+	}
+	return thread, nil
+}
+
+// merge moves the messages of a thread to another and deletes it
+func (s *ThreadService) merge(ctx context.Context, from, into *domain.Thread) error {
+	messages, err := s.threadMessages(ctx, from)
+	if err != nil {
+		return err
+	}
+	for _, message := range messages {
+		message.ThreadID = into.ID
+		if err := s.messageRepo.Update(ctx, message); err != nil {
+			return errors.InternalError(err)
+		}
+	}
+	if err := s.threadRepo.Merge(ctx, from.ID, into.ID); err != nil {
+		return errors.InternalError(err)
+	}
+	return nil
+}
+
+// refresh recomputes the aggregates of a thread, or deletes it when it has
+// no messages left
+func (s *ThreadService) refresh(ctx context.Context, thread *domain.Thread) error {
+	messages, err := s.threadMessages(ctx, thread)
+	if err != nil {
+		return err
+	}
+	if len(messages) == 0 {
+		if err := s.threadRepo.Delete(ctx, thread.ID); err != nil {
+			return errors.InternalError(err)
+		}
+		return nil
+	}
+
+	thread.Summarize(messages)
+	thread.UpdatedAt = time.Now()
+	if err := s.threadRepo.Update(ctx, thread); err != nil {
+		return errors.InternalError(err)
+	}
+	return nil
+}
+
+// view returns a thread with its messages, oldest first
+func (s *ThreadService) view(ctx context.Context, thread *domain.Thread) (*ThreadView, error) {
+	messages, err := s.threadMessages(ctx, thread)
+	if err != nil {
+		return nil, err
+	}
+	for i, j := 0, len(messages)-1; i < j; i, j = i+1, j-1 {
+		messages[i], messages[j] = messages[j], messages[i]
+	}
+	return &ThreadView{Thread: thread, Messages: messages}, nil
+}
+
+// threadMessages returns the messages of a thread, newest first
+func (s *ThreadService) threadMessages(ctx context.Context, thread *domain.Thread) ([]*domain.Message, error) {
+	threadID := thread.ID
+	messages, err := s.messageRepo.ListByAccount(ctx, thread.AccountID, repository.MessageFilter{ThreadID: &threadID})
+	if err != nil {
+		return nil, errors.InternalError(err)
+	}
+	return messages, nil
+}
+
+// settings returns the thread settings of an account, the defaults when it
+// has none
+func (s *ThreadService) settings(ctx context.Context, accountID string) (*domain.ThreadSettings, error) {
+	settings, err := s.threadRepo.GetSettings(ctx, accountID)
+	if err != nil {
+		return nil, errors.InternalError(err)
+	}
+	if settings == nil {
+		settings = &domain.ThreadSettings{AccountID: accountID, SubjectMode: domain.DefaultThreadSubjectMode}
+	}
+	return settings, nil
+}
+
+// ownedAccount returns an email account of the user
+func (s *ThreadService) ownedAccount(ctx context.Context, userID, accountID string) (*domain.EmailAccount, error) {
+	account, err := s.accountRepo.GetByID(ctx, accountID)
+	if err != nil {
+		return nil, errors.InternalError(err)
+	}
+	if account == nil || account.UserID != userID {
+		// Do not reveal accounts owned by other users
+		return nil, errors.EmailAccountNotFound(accountID)
+	}
+	return account, nil
+}
+
+func containsThread(threads []*domain.Thread, id string) bool {
+	for _, thread := range threads {
+		if thread.ID == id {
+			return true
+		}
+	}
+	return false
+}
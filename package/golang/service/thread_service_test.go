@@ -0,0 +1,352 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/skygenesisenterprise/aether-mailer/package/golang/domain"
+	"github.com/skygenesisenterprise/aether-mailer/package/golang/repository"
+	"github.com/skygenesisenterprise/aether-mailer/package/golang/repository/inmemory"
+)
+
+// threadMessage describes a message filed by a threading test
+type threadMessage struct {
+	id         string // Message-ID without brackets
+	inReplyTo  string
+	references []string
+	subject    string
+	age        time.Duration // received this long before the test started
+}
+
+// threadAll stores and threads messages in order and returns their thread
+// IDs by Message-ID
+func threadAll(t *testing.T, m *testMail, threads *ThreadService, account *domain.EmailAccount, messages []threadMessage) map[string]string {
+	t.Helper()
+	ctx := context.Background()
+	start := time.Now()
+	for i, spec := range messages {
+		headers := map[string]string{"Message-ID": "<" + spec.id + ">"}
+		if spec.inReplyTo != "" {
+			headers["In-Reply-To"] = "<" + spec.inReplyTo + ">"
+		}
+		if len(spec.references) > 0 {
+			headers["References"] = "<" + strings.Join(spec.references, "> <") + ">"
+		}
+		subject := spec.subject
+		if subject == "" {
+			subject = fmt.Sprintf("Message %d", i)
+		}
+		receivedAt := start.Add(-spec.age).Add(time.Duration(i) * time.Second)
+		message := &domain.Message{
+			ID:         uuid.NewString(),
+			AccountID:  account.ID,
+			FolderID:   m.folder(t, account, domain.FolderTypeInbox).ID,
+			From:       "bob@one.example",
+			To:         []string{account.Email},
+			Subject:    subject,
+			Headers:    headers,
+			ReceivedAt: receivedAt,
+			CreatedAt:  receivedAt,
+			UpdatedAt:  receivedAt,
+		}
+		if err := m.messages.Create(ctx, message); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := threads.AssignThread(ctx, message); err != nil {
+			t.Fatalf("AssignThread %s: %v", spec.id, err)
+		}
+	}
+
+	// Merged threads move their messages, so read the final threads back
+	stored, err := m.messages.ListByAccount(ctx, account.ID, repository.MessageFilter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	threadOf := make(map[string]string, len(stored))
+	for _, message := range stored {
+		threadOf[strings.Trim(message.Header("Message-ID"), "<>")] = message.ThreadID
+	}
+	return threadOf
+}
+
+// groups returns the Message-IDs sharing each thread, as "a b|c"
+func groups(ids []string, threadOf map[string]string) string {
+	order := []string{}
+	members := map[string][]string{}
+	for _, id := range ids {
+		thread := threadOf[id]
+		if _, ok := members[thread]; !ok {
+			order = append(order, thread)
+		}
+		members[thread] = append(members[thread], id)
+	}
+	parts := make([]string, 0, len(order))
+	for _, thread := range order {
+		parts = append(parts, strings.Join(members[thread], " "))
+	}
+	return strings.Join(parts, "|")
+}
+
+func newTestThreads(m *testMail) *ThreadService {
+	return NewThreadService(inmemory.NewThreadRepository(m.store), m.accounts, m.messages)
+}
+
+func TestThreadServiceLinksByHeaders(t *testing.T) {
+	tests := []struct {
+		name     string
+		messages []threadMessage
+		want     string
+	}{
+		{
+			name: "reply by In-Reply-To",
+			messages: []threadMessage{
+				{id: "a"},
+				{id: "b", inReplyTo: "a"},
+				{id: "c"},
+			},
+			want: "a b|c",
+		},
+		{
+			name: "reply by References only",
+			messages: []threadMessage{
+				{id: "a"},
+				{id: "b", references: []string{"a"}},
+				{id: "c", references: []string{"a", "b"}},
+			},
+			want: "a b c",
+		},
+		{
+			name: "parent after its replies",
+			messages: []threadMessage{
+				{id: "b", inReplyTo: "a"},
+				{id: "c", references: []string{"a"}},
+				{id: "a"},
+			},
+			want: "b c a",
+		},
+		{
+			name: "reply joins two threads",
+			messages: []threadMessage{
+				{id: "a"},
+				{id: "b"},
+				{id: "c", inReplyTo: "b", references: []string{"a"}},
+				{id: "d", inReplyTo: "b"},
+			},
+			want: "a b c d",
+		},
+		{
+			name: "unknown references start a thread",
+			messages: []threadMessage{
+				{id: "a"},
+				{id: "b", inReplyTo: "x", references: []string{"y"}},
+			},
+			want: "a|b",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := newTestMail(t)
+			account := m.newAccount(t, m.newUser(t, "alice"), "alice")
+			threads := newTestThreads(m)
+
+			threadOf := threadAll(t, m, threads, account, tt.messages)
+			ids := make([]string, 0, len(tt.messages))
+			for _, message := range tt.messages {
+				ids = append(ids, message.id)
+			}
+			if got := groups(ids, threadOf); got != tt.want {
+				t.Errorf("threads = %q, want %q", got, tt.want)
+			}
+
+			// Merged threads are deleted and the others count their messages
+			list, _, err := threads.ListThreads(context.Background(), account.UserID, account.ID, repository.ThreadFilter{})
+			if err != nil {
+				t.Fatalf("ListThreads: %v", err)
+			}
+			total := 0
+			for _, thread := range list {
+				total += thread.MessageCount
+			}
+			if len(list) != len(strings.Split(tt.want, "|")) || total != len(tt.messages) {
+				t.Errorf("%d threads holding %d messages, want %d threads holding %d", len(list), total,
+					len(strings.Split(tt.want, "|")), len(tt.messages))
+			}
+		})
+	}
+}
+
+func TestThreadServiceSubjectFallback(t *testing.T) {
+	old := domain.ThreadSubjectWindow + 24*time.Hour
+	tests := []struct {
+		name     string
+		mode     domain.ThreadSubjectMode // empty keeps the default
+		messages []threadMessage
+		want     string
+	}{
+		{
+			name: "reply subject by default",
+			messages: []threadMessage{
+				{id: "a", subject: "Budget"},
+				{id: "b", subject: "RE: [team] Budget"},
+			},
+			want: "a b",
+		},
+		{
+			name: "same subject without a reply marker by default",
+			messages: []threadMessage{
+				{id: "a", subject: "Budget"},
+				{id: "b", subject: "Budget"},
+			},
+			want: "a|b",
+		},
+		{
+			name: "same subject when always",
+			mode: domain.ThreadSubjectAlways,
+			messages: []threadMessage{
+				{id: "a", subject: "Budget"},
+				{id: "b", subject: "budget"},
+			},
+			want: "a b",
+		},
+		{
+			name: "reply subject when off",
+			mode: domain.ThreadSubjectOff,
+			messages: []threadMessage{
+				{id: "a", subject: "Budget"},
+				{id: "b", subject: "Re: Budget"},
+			},
+			want: "a|b",
+		},
+		{
+			name: "thread older than the window",
+			mode: domain.ThreadSubjectAlways,
+			messages: []threadMessage{
+				{id: "a", subject: "Budget", age: old},
+				{id: "b", subject: "Re: Budget"},
+			},
+			want: "a|b",
+		},
+		{
+			name: "different base subject",
+			messages: []threadMessage{
+				{id: "a", subject: "Budget"},
+				{id: "b", subject: "Re: Budget 2027"},
+			},
+			want: "a|b",
+		},
+		{
+			name: "headers take precedence over the subject",
+			messages: []threadMessage{
+				{id: "a", subject: "Budget"},
+				{id: "b", subject: "Planning"},
+				{id: "c", subject: "Re: Budget", inReplyTo: "b"},
+			},
+			want: "a|b c",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := newTestMail(t)
+			alice := m.newUser(t, "alice")
+			account := m.newAccount(t, alice, "alice")
+			threads := newTestThreads(m)
+			if tt.mode != "" {
+				if _, err := threads.UpdateSettings(context.Background(), alice.ID, account.ID, tt.mode); err != nil {
+					t.Fatalf("UpdateSettings: %v", err)
+				}
+			}
+
+			threadOf := threadAll(t, m, threads, account, tt.messages)
+			ids := make([]string, 0, len(tt.messages))
+			for _, message := range tt.messages {
+				ids = append(ids, message.id)
+			}
+			if got := groups(ids, threadOf); got != tt.want {
+				t.Errorf("threads = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestThreadServiceReferencesOrder(t *testing.T) {
+	// unknown returns n Message-IDs that lead to no message
+	unknown := func(prefix string, n int) []string {
+		ids := make([]string, n)
+		for i := range ids {
+			ids[i] = fmt.Sprintf("%s%d", prefix, i)
+		}
+		return ids
+	}
+	const kept = 100 // References kept per message
+
+	tests := []struct {
+		name     string
+		messages []threadMessage
+		want     string
+	}{
+		{
+			name: "newest reference kept past the limit",
+			messages: []threadMessage{
+				{id: "a"},
+				{id: "b", references: append(unknown("x", kept+10), "a")},
+			},
+			want: "a b",
+		},
+		{
+			name: "oldest reference dropped past the limit",
+			messages: []threadMessage{
+				{id: "a"},
+				{id: "b", references: append([]string{"a"}, unknown("x", kept)...)},
+			},
+			want: "a|b",
+		},
+		{
+			name: "In-Reply-To follows References",
+			messages: []threadMessage{
+				{id: "a"},
+				{id: "b", inReplyTo: "a", references: unknown("x", kept)},
+			},
+			want: "a b",
+		},
+		{
+			name: "repeated In-Reply-To keeps its References position",
+			messages: []threadMessage{
+				{id: "a"},
+				{id: "b", inReplyTo: "a", references: append([]string{"a"}, unknown("x", kept)...)},
+			},
+			want: "a|b",
+		},
+		{
+			name: "kept references lead to a later reply",
+			messages: []threadMessage{
+				{id: "a"},
+				{id: "b", references: append(append([]string{"y"}, unknown("x", kept-1)...), "a")},
+				{id: "c", inReplyTo: "x0"},
+			},
+			want: "a b c",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := newTestMail(t)
+			account := m.newAccount(t, m.newUser(t, "alice"), "alice")
+			threads := newTestThreads(m)
+
+			threadOf := threadAll(t, m, threads, account, tt.messages)
+			ids := make([]string, 0, len(tt.messages))
+			for _, message := range tt.messages {
+				ids = append(ids, message.id)
+			}
+			if got := groups(ids, threadOf); got != tt.want {
+				t.Errorf("threads = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,563 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/skygenesisenterprise/aether-mailer/package/golang/domain"
+	"github.com/skygenesisenterprise/aether-mailer/package/golang/errors"
+	"github.com/skygenesisenterprise/aether-mailer/package/golang/repository"
+)
+
+// TLSPolicyService decides how outbound sessions to a recipient domain must
+// be protected. MTA-STS policies (RFC 8461) are fetched from
+// mta-sts.<domain> and cached for their max_age; DANE TLSA records (RFC 7672)
+// are used when the resolver returns DNSSEC-validated answers and take
+// precedence over MTA-STS for that host. Every session outcome is recorded
+// for TLS-RPT (RFC 8460).
+type TLSPolicyService struct {
+	policyRepo repository.MTASTSPolicyRepository
+	resultRepo repository.TLSResultRepository
+	resolver   TLSResolver
+	fetcher    HTTPFetcher
+	config     *TLSPolicyConfig
+
+	mu    sync.Mutex
+	cache map[string]*domain.MTASTSPolicy
+}
+
+// TLSPolicyConfig defines TLS policy service configuration
+type TLSPolicyConfig struct {
+	EnableMTASTS       bool
+	EnableDANE         bool
+	FetchTimeout       time.Duration
+	MaxPolicySize      int64         // bytes, 64KB when zero
+	MaxPolicyAge       time.Duration // upper bound on max_age, one year when zero
+	ReportOrganization string        // organization-name of TLS-RPT reports
+	ReportContact      string        // contact-info of TLS-RPT reports
+}
+
+// TLSResolver looks up the DNS records used by MTA-STS and DANE.
+// *DNSResolver satisfies it.
+type TLSResolver interface {
+	LookupTXT(ctx context.Context, name string) ([]string, error)
+	// LookupTLSA returns the TLSA records of name and whether the answer
+	// was DNSSEC-validated
+	LookupTLSA(ctx context.Context, name string) ([]domain.TLSARecord, bool, error)
+}
+
+// HTTPFetcher performs the HTTPS request for an MTA-STS policy. *http.Client
+// satisfies it; it must not follow redirects (RFC 8461 section 3.3).
+type HTTPFetcher interface {
+	Do(req *http.Request) (*http.Response, error)
+}
+
+// DeliveryTarget is an MX host with the TLS requirement that applies to it
+// and the policy its sessions are reported under
+type DeliveryTarget struct {
+	Host   string
+	TLS    *TLSRequirement
+	Report domain.TLSResult
+}
+
+// mtaSTSDefaultMaxAge is the max_age upper bound of RFC 8461 section 3.2
+const mtaSTSDefaultMaxAge = 31557600 * time.Second
+
+// NewTLSPolicyService creates a new TLS policy service
+func NewTLSPolicyService(
+	policyRepo repository.MTASTSPolicyRepository,
+	resultRepo repository.TLSResultRepository,
+	resolver TLSResolver,
+	fetcher HTTPFetcher,
+	config *TLSPolicyConfig,
+) *TLSPolicyService {
+	return &TLSPolicyService{
+		policyRepo: policyRepo,
+		resultRepo: resultRepo,
+		resolver:   resolver,
+		fetcher:    fetcher,
+		config:     config,
+		cache:      make(map[string]*domain.MTASTSPolicy),
+	}
+}
+
+// NewMTASTSHTTPClient returns an HTTP client suitable for fetching MTA-STS
+// policies: certificates are verified and redirects are not followed
+func NewMTASTSHTTPClient(timeout time.Duration) *http.Client {
+	return &http.Client{
+		Timeout: timeout,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
+
+// Targets returns the MX hosts that may receive mail for a domain, in
+// preference order, with their TLS requirements. Hosts not listed in an
+// enforced MTA-STS policy are dropped, as are hosts whose TLSA lookup
+// failed; when no host remains a *TLSFailure is returned and delivery must
+// be deferred.
+func (s *TLSPolicyService) Targets(ctx context.Context, domainName string, hosts []string) ([]DeliveryTarget, error) {
+	domainName = strings.ToLower(strings.TrimSuffix(domainName, "."))
+	policy, fetchFailure := s.stsPolicy(ctx, domainName)
+	if fetchFailure != nil {
+		s.RecordResult(ctx, domain.TLSResult{Domain: domainName, PolicyType: domain.TLSPolicyTypeSTS, ResultType: fetchFailure.Result})
+	}
+	if policy != nil && policy.Mode == domain.MTASTSModeNone {
+		policy = nil
+	}
+
+	targets := make([]DeliveryTarget, 0, len(hosts))
+	var lastFailure *TLSFailure
+	for _, host := range hosts {
+		host = strings.ToLower(strings.TrimSuffix(host, "."))
+
+		if s.config.EnableDANE && s.resolver != nil {
+			target, failure := s.daneTarget(ctx, domainName, host)
+			if failure != nil {
+				// A signed zone that cannot be validated is treated as an
+				// attack on that host (RFC 7672 section 2.2)
+				lastFailure = failure
+				s.RecordResult(ctx, domain.TLSResult{Domain: domainName, PolicyType: domain.TLSPolicyTypeTLSA, MXHost: host, ResultType: failure.Result})
+				continue
+			}
+			if target != nil {
+				targets = append(targets, *target)
+				continue
+			}
+		}
+
+		if policy != nil {
+			enforce := policy.Mode == domain.MTASTSModeEnforce
+			if enforce && !policy.MatchesMX(host) {
+				lastFailure = &TLSFailure{
+					Result: domain.TLSResultValidationFailure,
+					Err:    fmt.Errorf("MX host %s is not listed in the MTA-STS policy of %s", host, domainName),
+				}
+				continue
+			}
+			targets = append(targets, DeliveryTarget{
+				Host: host,
+				TLS:  &TLSRequirement{Level: TLSLevelWebPKI, Enforce: enforce},
+				Report: domain.TLSResult{
+					Domain:        domainName,
+					PolicyType:    domain.TLSPolicyTypeSTS,
+					PolicyStrings: policy.PolicyStrings(),
+					MXHost:        host,
+				},
+			})
+			continue
+		}
+
+		targets = append(targets, DeliveryTarget{
+			Host:   host,
+			TLS:    &TLSRequirement{Level: TLSLevelOpportunistic},
+			Report: domain.TLSResult{Domain: domainName, PolicyType: domain.TLSPolicyTypeNoPolicy, MXHost: host},
+		})
+	}
+
+	if len(targets) == 0 && lastFailure != nil {
+		if lastFailure.Result == domain.TLSResultValidationFailure && policy != nil {
+			s.RecordResult(ctx, domain.TLSResult{
+				Domain:        domainName,
+				PolicyType:    domain.TLSPolicyTypeSTS,
+				PolicyStrings: policy.PolicyStrings(),
+				ResultType:    domain.TLSResultValidationFailure,
+			})
+		}
+		return nil, lastFailure
+	}
+	return targets, nil
+}
+
+// GetMTASTSPolicy returns the MTA-STS policy in effect for a domain, fetching
+// it when the cache holds none. A nil policy means the domain publishes none.
+func (s *TLSPolicyService) GetMTASTSPolicy(ctx context.Context, domainName string) (*domain.MTASTSPolicy, error) {
+	domainName = strings.ToLower(strings.TrimSuffix(domainName, "."))
+	policy, failure := s.stsPolicy(ctx, domainName)
+	if policy == nil && failure != nil {
+		return nil, errors.NewErrorWithCause(errors.ErrCodeNetworkError, "MTA-STS policy fetch failed", failure).
+			WithDetail("domain", domainName)
+	}
+	return policy, nil
+}
+
+// RecordResult stores the outcome of one TLS session attempt
+func (s *TLSPolicyService) RecordResult(ctx context.Context, result domain.TLSResult) {
+	if s.resultRepo == nil {
+		return
+	}
+	if result.At.IsZero() {
+		result.At = time.Now()
+	}
+	if err := s.resultRepo.Record(ctx, &result); err != nil {
+		// Log error but don't fail the delivery
+	}
+}
+
+// GetResultSummary returns the aggregated TLS results of a domain
+func (s *TLSPolicyService) GetResultSummary(ctx context.Context, domainName string, from, to time.Time) ([]*domain.TLSResultSummary, error) {
+	summaries, err := s.resultRepo.Summarize(ctx, strings.ToLower(domainName), from, to)
+	if err != nil {
+		return nil, errors.InternalError(err)
+	}
+	return summaries, nil
+}
+
+// ListReportDomains lists the recipient domains with TLS results in a period
+func (s *TLSPolicyService) ListReportDomains(ctx context.Context, from, to time.Time) ([]string, error) {
+	domains, err := s.resultRepo.ListDomains(ctx, from, to)
+	if err != nil {
+		return nil, errors.InternalError(err)
+	}
+	return domains, nil
+}
+
+// BuildReport assembles the RFC 8460 aggregate report for a domain
+func (s *TLSPolicyService) BuildReport(ctx context.Context, domainName string, from, to time.Time) (*domain.TLSReport, error) {
+	summaries, err := s.GetResultSummary(ctx, domainName, from, to)
+	if err != nil {
+		return nil, err
+	}
+
+	report := &domain.TLSReport{
+		OrganizationName: s.config.ReportOrganization,
+		DateRange:        domain.TLSReportRange{StartDatetime: from.UTC(), EndDatetime: to.UTC()},
+		ContactInfo:      s.config.ReportContact,
+		ReportID:         uuid.New().String(),
+		Policies:         []domain.TLSReportPolicy{},
+	}
+
+	index := make(map[string]int)
+	for _, summary := range summaries {
+		key := string(summary.PolicyType) + "\n" + strings.Join(summary.PolicyStrings, "\n")
+		if summary.PolicyType == domain.TLSPolicyTypeTLSA {
+			// TLSA policies are per MX host
+			key += "\n" + summary.MXHost
+		}
+		i, ok := index[key]
+		if !ok {
+			info := domain.TLSReportPolicyInfo{
+				PolicyType:   summary.PolicyType,
+				PolicyString: summary.PolicyStrings,
+				PolicyDomain: domainName,
+			}
+			if summary.PolicyType == domain.TLSPolicyTypeTLSA {
+				info.MXHost = summary.MXHost
+			}
+			report.Policies = append(report.Policies, domain.TLSReportPolicy{Policy: info})
+			i = len(report.Policies) - 1
+			index[key] = i
+		}
+
+		policy := &report.Policies[i]
+		if summary.ResultType == "" {
+			policy.Summary.TotalSuccessfulSessionCount += summary.Count
+			continue
+		}
+		policy.Summary.TotalFailureSessionCount += summary.Count
+		policy.FailureDetails = append(policy.FailureDetails, domain.TLSReportFailureDetail{
+			ResultType:          summary.ResultType,
+			ReceivingMXHostname: summary.MXHost,
+			FailedSessionCount:  summary.Count,
+		})
+	}
+
+	return report, nil
+}
+
+// PurgeExpiredPolicies removes cached MTA-STS policies past their max_age
+func (s *TLSPolicyService) PurgeExpiredPolicies(ctx context.Context) (int, error) {
+	now := time.Now()
+	s.mu.Lock()
+	for name, policy := range s.cache {
+		if !now.Before(policy.ExpiresAt) {
+			delete(s.cache, name)
+		}
+	}
+	s.mu.Unlock()
+
+	removed, err := s.policyRepo.DeleteExpired(ctx, now)
+	if err != nil {
+		return 0, errors.InternalError(err)
+	}
+	return removed, nil
+}
+
+// daneTarget returns a DANE target for host when its TLSA RRset is
+// DNSSEC-validated, nil when DANE does not apply, or a failure when the
+// lookup failed
+func (s *TLSPolicyService) daneTarget(ctx context.Context, domainName, host string) (*DeliveryTarget, *TLSFailure) {
+	records, secure, err := s.resolver.LookupTLSA(ctx, "_25._tcp."+host)
+	if err != nil {
+		return nil, &TLSFailure{Result: domain.TLSResultDNSSECInvalid, Err: err}
+	}
+	if !secure || len(records) == 0 {
+		return nil, nil
+	}
+
+	policyStrings := make([]string, 0, len(records))
+	usable := []domain.TLSARecord{}
+	for _, record := range records {
+		policyStrings = append(policyStrings, record.String())
+		if isUsableTLSA(record) {
+			usable = append(usable, record)
+		}
+	}
+
+	// Only unusable records: TLS is mandatory but unauthenticated
+	requirement := &TLSRequirement{Level: TLSLevelEncrypt, Enforce: true}
+	if len(usable) > 0 {
+		requirement = &TLSRequirement{Level: TLSLevelDANE, Enforce: true, TLSA: usable}
+	}
+
+	return &DeliveryTarget{
+		Host: host,
+		TLS:  requirement,
+		Report: domain.TLSResult{
+			Domain:        domainName,
+			PolicyType:    domain.TLSPolicyTypeTLSA,
+			PolicyStrings: policyStrings,
+			MXHost:        host,
+		},
+	}, nil
+}
+
+// stsPolicy returns the MTA-STS policy in effect for a domain following
+// RFC 8461 section 5.1: a cached policy is used while it is unexpired and
+// its id matches the TXT record, or while no new policy can be fetched. The
+// failure, if any, describes a fetch that did not produce a new policy.
+func (s *TLSPolicyService) stsPolicy(ctx context.Context, domainName string) (*domain.MTASTSPolicy, *TLSFailure) {
+	if !s.config.EnableMTASTS || s.resolver == nil {
+		return nil, nil
+	}
+
+	now := time.Now()
+	cached := s.cachedPolicy(ctx, domainName, now)
+
+	id, err := s.lookupPolicyID(ctx, domainName)
+	if err != nil || id == "" {
+		return cached, nil
+	}
+	if cached != nil && cached.ID == id {
+		return cached, nil
+	}
+
+	policy, failure := s.fetchPolicy(ctx, domainName, id, now)
+	if failure != nil {
+		return cached, failure
+	}
+
+	s.mu.Lock()
+	s.cache[domainName] = policy
+	s.mu.Unlock()
+	if s.policyRepo != nil {
+		if err := s.policyRepo.Save(ctx, policy); err != nil {
+			// Log error but keep the policy in memory
+		}
+	}
+	return policy, nil
+}
+
+func (s *TLSPolicyService) cachedPolicy(ctx context.Context, domainName string, now time.Time) *domain.MTASTSPolicy {
+	s.mu.Lock()
+	policy, ok := s.cache[domainName]
+	s.mu.Unlock()
+
+	if !ok && s.policyRepo != nil {
+		stored, err := s.policyRepo.Get(ctx, domainName)
+		if err == nil && stored != nil {
+			policy = stored
+			s.mu.Lock()
+			s.cache[domainName] = stored
+			s.mu.Unlock()
+		}
+	}
+	if policy == nil || !now.Before(policy.ExpiresAt) {
+		return nil
+	}
+	return policy
+}
+
+// lookupPolicyID returns the id of the domain's _mta-sts TXT record. Zero or
+// several STSv1 records mean no policy (RFC 8461 section 3.1).
+func (s *TLSPolicyService) lookupPolicyID(ctx context.Context, domainName string) (string, error) {
+	records, err := s.resolver.LookupTXT(ctx, "_mta-sts."+domainName)
+	if err != nil {
+		return "", err
+	}
+
+	id := ""
+	found := 0
+	for _, record := range records {
+		fields := strings.Split(record, ";")
+		if strings.TrimSpace(fields[0]) != "v=STSv1" {
+			continue
+		}
+		found++
+		for _, field := range fields[1:] {
+			key, value, ok := strings.Cut(strings.TrimSpace(field), "=")
+			if ok && key == "id" {
+				id = value
+			}
+		}
+	}
+	if found != 1 || !isPolicyID(id) {
+		return "", nil
+	}
+	return id, nil
+}
+
+// fetchPolicy retrieves and parses the policy file over HTTPS
+func (s *TLSPolicyService) fetchPolicy(ctx context.Context, domainName, id string, now time.Time) (*domain.MTASTSPolicy, *TLSFailure) {
+	if s.fetcher == nil {
+		return nil, &TLSFailure{Result: domain.TLSResultSTSPolicyFetchError, Err: fmt.Errorf("no policy fetcher configured")}
+	}
+	if s.config.FetchTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, s.config.FetchTimeout)
+		defer cancel()
+	}
+
+	url := "https://mta-sts." + domainName + "/.well-known/mta-sts.txt"
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, &TLSFailure{Result: domain.TLSResultSTSPolicyFetchError, Err: err}
+	}
+	resp, err := s.fetcher.Do(req)
+	if err != nil {
+		result := domain.TLSResultSTSPolicyFetchError
+		if certificateResult(err) != domain.TLSResultValidationFailure {
+			result = domain.TLSResultSTSWebPKIInvalid
+		}
+		return nil, &TLSFailure{Result: result, Err: err}
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, &TLSFailure{Result: domain.TLSResultSTSPolicyFetchError, Err: fmt.Errorf("policy fetch returned HTTP %d", resp.StatusCode)}
+	}
+	if mediaType, _, err := mime.ParseMediaType(resp.Header.Get("Content-Type")); err != nil || mediaType != "text/plain" {
+		return nil, &TLSFailure{Result: domain.TLSResultSTSPolicyInvalid, Err: fmt.Errorf("policy has content type %q", resp.Header.Get("Content-Type"))}
+	}
+
+	limit := s.config.MaxPolicySize
+	if limit <= 0 {
+		limit = 64 * 1024
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, limit+1))
+	if err != nil {
+		return nil, &TLSFailure{Result: domain.TLSResultSTSPolicyFetchError, Err: err}
+	}
+	if int64(len(body)) > limit {
+		return nil, &TLSFailure{Result: domain.TLSResultSTSPolicyInvalid, Err: fmt.Errorf("policy exceeds %d bytes", limit)}
+	}
+
+	policy, err := parseMTASTSPolicy(string(body))
+	if err != nil {
+		return nil, &TLSFailure{Result: domain.TLSResultSTSPolicyInvalid, Err: err}
+	}
+
+	maxAge := s.config.MaxPolicyAge
+	if maxAge <= 0 {
+		maxAge = mtaSTSDefaultMaxAge
+	}
+	if policy.MaxAge > maxAge {
+		policy.MaxAge = maxAge
+	}
+	policy.Domain = domainName
+	policy.ID = id
+	policy.FetchedAt = now
+	policy.ExpiresAt = now.Add(policy.MaxAge)
+	return policy, nil
+}
+
+// Helper functions
+
+// parseMTASTSPolicy parses a policy file (RFC 8461 section 3.2). Unknown
+// fields are ignored.
+func parseMTASTSPolicy(text string) (*domain.MTASTSPolicy, error) {
+	policy := &domain.MTASTSPolicy{Text: text}
+	version, maxAge := "", ""
+	for _, line := range strings.Split(text, "\n") {
+		line = strings.TrimSuffix(line, "\r")
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		key, value, ok := strings.Cut(line, ":")
+		if !ok {
+			return nil, fmt.Errorf("malformed policy line %q", line)
+		}
+		key, value = strings.TrimSpace(key), strings.TrimSpace(value)
+		switch key {
+		case "version":
+			version = value
+		case "mode":
+			policy.Mode = domain.MTASTSMode(value)
+		case "mx":
+			policy.MX = append(policy.MX, strings.ToLower(value))
+		case "max_age":
+			maxAge = value
+		}
+	}
+
+	if version != "STSv1" {
+		return nil, fmt.Errorf("unsupported policy version %q", version)
+	}
+	switch policy.Mode {
+	case domain.MTASTSModeEnforce, domain.MTASTSModeTesting:
+		if len(policy.MX) == 0 {
+			return nil, fmt.Errorf("policy lists no mx patterns")
+		}
+	case domain.MTASTSModeNone:
+	default:
+		return nil, fmt.Errorf("invalid policy mode %q", policy.Mode)
+	}
+	seconds, err := strconv.ParseInt(maxAge, 10, 64)
+	if err != nil || seconds < 0 || len(maxAge) > 10 {
+		return nil, fmt.Errorf("invalid max_age %q", maxAge)
+	}
+	policy.MaxAge = time.Duration(seconds) * time.Second
+	return policy, nil
+}
+
+// isPolicyID reports whether id is 1 to 32 alphanumeric characters
+func isPolicyID(id string) bool {
+	if len(id) == 0 || len(id) > 32 {
+		return false
+	}
+	for _, r := range id {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9') {
+			return false
+		}
+	}
+	return true
+}
+
+// isUsableTLSA reports whether a TLSA record can authenticate an SMTP
+// server: PKIX usages are unusable for SMTP (RFC 7672 section 3.1.3)
+func isUsableTLSA(record domain.TLSARecord) bool {
+	if record.Usage != 2 && record.Usage != 3 {
+		return false
+	}
+	if record.Selector > 1 {
+		return false
+	}
+	switch record.MatchingType {
+	case 0:
+		return len(record.Data) > 0
+	case 1:
+		return len(record.Data) == 32
+	case 2:
+		return len(record.Data) == 64
+	}
+	return false
+}
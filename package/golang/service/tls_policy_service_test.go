@@ -0,0 +1,454 @@
+package service
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/skygenesisenterprise/aether-mailer/package/golang/domain"
+	"github.com/skygenesisenterprise/aether-mailer/package/golang/repository/inmemory"
+)
+
+const testSTSPolicy = "version: STSv1\nmode: %s\nmx: *.mail.example.com\nmax_age: 86400\n"
+
+func TestTLSPolicyServiceFetchesAndCachesPolicy(t *testing.T) {
+	resolver := &fakeTLSResolver{txt: map[string][]string{"_mta-sts.example.com": {"v=STSv1; id=20240101"}}}
+	fetcher := &fakeHTTPFetcher{status: http.StatusOK, contentType: "text/plain", body: fmt.Sprintf(testSTSPolicy, "enforce")}
+	store := inmemory.NewStore()
+	policies := inmemory.NewMTASTSPolicyRepository(store)
+	tlsPolicy := newTestTLSPolicyService(policies, store, resolver, fetcher)
+	ctx := context.Background()
+
+	policy, err := tlsPolicy.GetMTASTSPolicy(ctx, "example.com")
+	if err != nil {
+		t.Fatalf("GetMTASTSPolicy() error = %v", err)
+	}
+	if policy == nil || policy.Mode != domain.MTASTSModeEnforce || policy.ID != "20240101" || policy.MaxAge != 24*time.Hour {
+		t.Fatalf("GetMTASTSPolicy() = %+v", policy)
+	}
+	if fetcher.url != "https://mta-sts.example.com/.well-known/mta-sts.txt" {
+		t.Errorf("fetched %s", fetcher.url)
+	}
+
+	// The same id is served from the cache
+	if _, err := tlsPolicy.Targets(ctx, "example.com", []string{"mx1.mail.example.com"}); err != nil {
+		t.Fatalf("Targets() error = %v", err)
+	}
+	if fetcher.requests != 1 {
+		t.Errorf("policy fetched %d times, want 1", fetcher.requests)
+	}
+
+	// A restarted service reads the stored policy instead of fetching it
+	restarted := newTestTLSPolicyService(policies, store, resolver, fetcher)
+	if _, err := restarted.Targets(ctx, "example.com", []string{"mx1.mail.example.com"}); err != nil {
+		t.Fatalf("Targets() error = %v", err)
+	}
+	if fetcher.requests != 1 {
+		t.Errorf("policy fetched %d times after restart, want 1", fetcher.requests)
+	}
+
+	// A new id in the TXT record fetches the policy again
+	resolver.txt["_mta-sts.example.com"] = []string{"v=STSv1; id=20240202"}
+	fetcher.body = fmt.Sprintf(testSTSPolicy, "testing")
+	policy, err = tlsPolicy.GetMTASTSPolicy(ctx, "example.com")
+	if err != nil {
+		t.Fatalf("GetMTASTSPolicy() error = %v", err)
+	}
+	if fetcher.requests != 2 || policy.ID != "20240202" || policy.Mode != domain.MTASTSModeTesting {
+		t.Errorf("after id change fetched %d times, policy %+v", fetcher.requests, policy)
+	}
+}
+
+func TestTLSPolicyServiceKeepsCachedPolicyWhenFetchFails(t *testing.T) {
+	resolver := &fakeTLSResolver{txt: map[string][]string{"_mta-sts.example.com": {"v=STSv1; id=one"}}}
+	fetcher := &fakeHTTPFetcher{status: http.StatusOK, contentType: "text/plain", body: fmt.Sprintf(testSTSPolicy, "enforce")}
+	store := inmemory.NewStore()
+	tlsPolicy := newTestTLSPolicyService(inmemory.NewMTASTSPolicyRepository(store), store, resolver, fetcher)
+	ctx := context.Background()
+
+	if _, err := tlsPolicy.GetMTASTSPolicy(ctx, "example.com"); err != nil {
+		t.Fatalf("GetMTASTSPolicy() error = %v", err)
+	}
+
+	resolver.txt["_mta-sts.example.com"] = []string{"v=STSv1; id=two"}
+	fetcher.status = http.StatusNotFound
+	targets, err := tlsPolicy.Targets(ctx, "example.com", []string{"mx1.mail.example.com", "evil.example.net"})
+	if err != nil {
+		t.Fatalf("Targets() error = %v", err)
+	}
+	if len(targets) != 1 || targets[0].Host != "mx1.mail.example.com" || !targets[0].TLS.Enforce {
+		t.Errorf("Targets() = %+v, want the cached enforced policy applied", targets)
+	}
+	assertTLSResult(t, store, "example.com", domain.TLSResultSTSPolicyFetchError)
+}
+
+func TestTLSPolicyServiceRejectsInvalidPolicies(t *testing.T) {
+	tests := []struct {
+		name        string
+		fetcher     *fakeHTTPFetcher
+		wantFailure domain.TLSResultType
+	}{
+		{"wrong content type", &fakeHTTPFetcher{status: http.StatusOK, contentType: "text/html", body: fmt.Sprintf(testSTSPolicy, "enforce")}, domain.TLSResultSTSPolicyInvalid},
+		{"bad mode", &fakeHTTPFetcher{status: http.StatusOK, contentType: "text/plain", body: fmt.Sprintf(testSTSPolicy, "strict")}, domain.TLSResultSTSPolicyInvalid},
+		{"redirect", &fakeHTTPFetcher{status: http.StatusFound, contentType: "text/plain"}, domain.TLSResultSTSPolicyFetchError},
+		{"certificate", &fakeHTTPFetcher{err: x509.UnknownAuthorityError{}}, domain.TLSResultSTSWebPKIInvalid},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resolver := &fakeTLSResolver{txt: map[string][]string{"_mta-sts.example.com": {"v=STSv1; id=one"}}}
+			store := inmemory.NewStore()
+			tlsPolicy := newTestTLSPolicyService(inmemory.NewMTASTSPolicyRepository(store), store, resolver, tt.fetcher)
+
+			targets, err := tlsPolicy.Targets(context.Background(), "example.com", []string{"evil.example.net"})
+			if err != nil {
+				t.Fatalf("Targets() error = %v", err)
+			}
+			// Without a valid policy delivery falls back to opportunistic TLS
+			if len(targets) != 1 || targets[0].TLS.Level != TLSLevelOpportunistic {
+				t.Errorf("Targets() = %+v, want opportunistic TLS", targets)
+			}
+			assertTLSResult(t, store, "example.com", tt.wantFailure)
+		})
+	}
+}
+
+func TestTLSPolicyServiceEnforceAndTestingModes(t *testing.T) {
+	hosts := []string{"mx1.mail.example.com", "evil.example.net"}
+
+	tests := []struct {
+		name        string
+		mode        string
+		hosts       []string
+		wantHosts   []string
+		wantEnforce bool
+		wantErr     domain.TLSResultType
+	}{
+		{name: "enforce drops unlisted hosts", mode: "enforce", hosts: hosts, wantHosts: []string{"mx1.mail.example.com"}, wantEnforce: true},
+		{name: "enforce without listed hosts defers", mode: "enforce", hosts: []string{"evil.example.net"}, wantErr: domain.TLSResultValidationFailure},
+		{name: "testing keeps every host", mode: "testing", hosts: hosts, wantHosts: hosts},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resolver := &fakeTLSResolver{txt: map[string][]string{"_mta-sts.example.com": {"v=STSv1; id=one"}}}
+			fetcher := &fakeHTTPFetcher{status: http.StatusOK, contentType: "text/plain", body: fmt.Sprintf(testSTSPolicy, tt.mode)}
+			store := inmemory.NewStore()
+			tlsPolicy := newTestTLSPolicyService(inmemory.NewMTASTSPolicyRepository(store), store, resolver, fetcher)
+
+			targets, err := tlsPolicy.Targets(context.Background(), "example.com", tt.hosts)
+			if tt.wantErr != "" {
+				failure, ok := err.(*TLSFailure)
+				if !ok || failure.Result != tt.wantErr {
+					t.Fatalf("Targets() error = %v, want %s", err, tt.wantErr)
+				}
+				assertTLSResult(t, store, "example.com", tt.wantErr)
+				return
+			}
+			if err != nil {
+				t.Fatalf("Targets() error = %v", err)
+			}
+			if len(targets) != len(tt.wantHosts) {
+				t.Fatalf("Targets() = %+v, want hosts %v", targets, tt.wantHosts)
+			}
+			for i, target := range targets {
+				if target.Host != tt.wantHosts[i] || target.TLS.Level != TLSLevelWebPKI || target.TLS.Enforce != tt.wantEnforce {
+					t.Errorf("target %d = %s %+v, want %s enforce %v", i, target.Host, target.TLS, tt.wantHosts[i], tt.wantEnforce)
+				}
+				if target.Report.PolicyType != domain.TLSPolicyTypeSTS {
+					t.Errorf("target %d reported under %s", i, target.Report.PolicyType)
+				}
+			}
+		})
+	}
+}
+
+func TestTLSPolicyServiceDANE(t *testing.T) {
+	usable := domain.TLSARecord{Usage: 3, Selector: 1, MatchingType: 1, Data: make([]byte, 32)}
+	unusable := domain.TLSARecord{Usage: 1, Selector: 1, MatchingType: 1, Data: make([]byte, 32)}
+
+	tests := []struct {
+		name      string
+		tlsa      fakeTLSA
+		wantLevel TLSLevel
+		wantType  domain.TLSPolicyType
+		wantErr   domain.TLSResultType
+	}{
+		{name: "validated records take precedence", tlsa: fakeTLSA{records: []domain.TLSARecord{usable}, secure: true}, wantLevel: TLSLevelDANE, wantType: domain.TLSPolicyTypeTLSA},
+		{name: "unvalidated records are ignored", tlsa: fakeTLSA{records: []domain.TLSARecord{usable}}, wantLevel: TLSLevelWebPKI, wantType: domain.TLSPolicyTypeSTS},
+		{name: "unusable records require encryption", tlsa: fakeTLSA{records: []domain.TLSARecord{unusable}, secure: true}, wantLevel: TLSLevelEncrypt, wantType: domain.TLSPolicyTypeTLSA},
+		{name: "failed lookup defers", tlsa: fakeTLSA{err: fmt.Errorf("SERVFAIL")}, wantErr: domain.TLSResultDNSSECInvalid},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resolver := &fakeTLSResolver{
+				txt:  map[string][]string{"_mta-sts.example.com": {"v=STSv1; id=one"}},
+				tlsa: map[string]fakeTLSA{"_25._tcp.mx1.mail.example.com": tt.tlsa},
+			}
+			fetcher := &fakeHTTPFetcher{status: http.StatusOK, contentType: "text/plain", body: fmt.Sprintf(testSTSPolicy, "enforce")}
+			store := inmemory.NewStore()
+			tlsPolicy := newTestTLSPolicyService(inmemory.NewMTASTSPolicyRepository(store), store, resolver, fetcher)
+
+			targets, err := tlsPolicy.Targets(context.Background(), "example.com", []string{"mx1.mail.example.com"})
+			if tt.wantErr != "" {
+				failure, ok := err.(*TLSFailure)
+				if !ok || failure.Result != tt.wantErr {
+					t.Fatalf("Targets() error = %v, want %s", err, tt.wantErr)
+				}
+				assertTLSResult(t, store, "example.com", tt.wantErr)
+				return
+			}
+			if err != nil {
+				t.Fatalf("Targets() error = %v", err)
+			}
+			if len(targets) != 1 || targets[0].TLS.Level != tt.wantLevel || targets[0].Report.PolicyType != tt.wantType {
+				t.Fatalf("Targets() = %+v, want level %d under %s", targets, tt.wantLevel, tt.wantType)
+			}
+			if tt.wantLevel > TLSLevelOpportunistic && !targets[0].TLS.Enforce {
+				t.Errorf("target is not enforced")
+			}
+		})
+	}
+}
+
+func TestParseMTASTSPolicy(t *testing.T) {
+	tests := []struct {
+		name    string
+		text    string
+		wantErr bool
+	}{
+		{name: "enforce", text: "version: STSv1\r\nmode: enforce\r\nmx: mx.example.com\r\nmx: *.example.net\r\nmax_age: 604800\r\n"},
+		{name: "none without mx", text: "version: STSv1\nmode: none\nmax_age: 0\n"},
+		{name: "unknown fields ignored", text: "version: STSv1\nmode: testing\nmx: mx.example.com\nmax_age: 60\nextension: yes\n"},
+		{name: "wrong version", text: "version: STSv2\nmode: enforce\nmx: mx.example.com\nmax_age: 60\n", wantErr: true},
+		{name: "enforce without mx", text: "version: STSv1\nmode: enforce\nmax_age: 60\n", wantErr: true},
+		{name: "negative max_age", text: "version: STSv1\nmode: enforce\nmx: mx.example.com\nmax_age: -1\n", wantErr: true},
+		{name: "malformed line", text: "version: STSv1\nmode enforce\n", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := parseMTASTSPolicy(tt.text)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("parseMTASTSPolicy() error = %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestVerifyDANE(t *testing.T) {
+	caKey, caCert := newTestCertificate(t, "Test CA", "", nil, nil)
+	_, leaf := newTestCertificate(t, "mx.example.com", "mx.example.com", caCert, caKey)
+	_, other := newTestCertificate(t, "other.example.com", "other.example.com", nil, nil)
+	chain := []*x509.Certificate{leaf, caCert}
+
+	spkiSHA256 := func(cert *x509.Certificate) []byte {
+		sum := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+		return sum[:]
+	}
+	certSHA512 := func(cert *x509.Certificate) []byte {
+		sum := sha512.Sum512(cert.Raw)
+		return sum[:]
+	}
+
+	tests := []struct {
+		name        string
+		records     []domain.TLSARecord
+		host        string
+		wantFailure domain.TLSResultType
+	}{
+		{name: "DANE-EE public key", records: []domain.TLSARecord{{Usage: 3, Selector: 1, MatchingType: 1, Data: spkiSHA256(leaf)}}, host: "mx.example.com"},
+		{name: "DANE-EE full certificate", records: []domain.TLSARecord{{Usage: 3, Selector: 0, MatchingType: 2, Data: certSHA512(leaf)}}, host: "mx.example.com"},
+		{name: "DANE-EE exact match", records: []domain.TLSARecord{{Usage: 3, Selector: 0, MatchingType: 0, Data: leaf.Raw}}, host: "mx.example.com"},
+		// DANE-EE ignores the certificate names (RFC 7672 section 3.1.1)
+		{name: "DANE-EE ignores host name", records: []domain.TLSARecord{{Usage: 3, Selector: 1, MatchingType: 1, Data: spkiSHA256(leaf)}}, host: "elsewhere.example.com"},
+		{name: "DANE-TA issuer", records: []domain.TLSARecord{{Usage: 2, Selector: 1, MatchingType: 1, Data: spkiSHA256(caCert)}}, host: "mx.example.com"},
+		{name: "DANE-TA wrong host", records: []domain.TLSARecord{{Usage: 2, Selector: 1, MatchingType: 1, Data: spkiSHA256(caCert)}}, host: "elsewhere.example.com", wantFailure: domain.TLSResultCertificateHostMismatch},
+		{name: "no matching record", records: []domain.TLSARecord{{Usage: 3, Selector: 1, MatchingType: 1, Data: spkiSHA256(other)}}, host: "mx.example.com", wantFailure: domain.TLSResultValidationFailure},
+		{name: "one of several records", records: []domain.TLSARecord{
+			{Usage: 3, Selector: 1, MatchingType: 1, Data: spkiSHA256(other)},
+			{Usage: 3, Selector: 1, MatchingType: 1, Data: spkiSHA256(leaf)},
+		}, host: "mx.example.com"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			failure := verifyDANE(chain, tt.records, tt.host)
+			if tt.wantFailure == "" {
+				if failure != nil {
+					t.Fatalf("verifyDANE() = %v, want a match", failure)
+				}
+				return
+			}
+			if failure == nil || failure.Result != tt.wantFailure {
+				t.Fatalf("verifyDANE() = %v, want %s", failure, tt.wantFailure)
+			}
+		})
+	}
+}
+
+func TestNetSMTPDialerTLSVerification(t *testing.T) {
+	_, leaf := newTestCertificate(t, "mx.example.com", "mx.example.com", nil, nil)
+	_, other := newTestCertificate(t, "other.example.com", "other.example.com", nil, nil)
+	sum := sha256.Sum256(leaf.RawSubjectPublicKeyInfo)
+	records := []domain.TLSARecord{{Usage: 3, Selector: 1, MatchingType: 1, Data: sum[:]}}
+
+	tests := []struct {
+		name        string
+		requirement TLSRequirement
+		peer        *x509.Certificate
+		wantReject  bool
+		wantFailure domain.TLSResultType
+	}{
+		{name: "DANE match", requirement: TLSRequirement{Level: TLSLevelDANE, Enforce: true, TLSA: records}, peer: leaf},
+		{name: "DANE mismatch enforced", requirement: TLSRequirement{Level: TLSLevelDANE, Enforce: true, TLSA: records}, peer: other, wantReject: true, wantFailure: domain.TLSResultValidationFailure},
+		{name: "untrusted certificate enforced", requirement: TLSRequirement{Level: TLSLevelWebPKI, Enforce: true}, peer: leaf, wantReject: true, wantFailure: domain.TLSResultCertificateNotTrusted},
+		// Testing mode records the failure but lets the session continue
+		{name: "untrusted certificate in testing mode", requirement: TLSRequirement{Level: TLSLevelWebPKI}, peer: leaf, wantFailure: domain.TLSResultCertificateNotTrusted},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dialer := &NetSMTPDialer{TLSConfig: &tls.Config{RootCAs: x509.NewCertPool()}}
+			requirement := tt.requirement
+			var failure *TLSFailure
+			config := dialer.tlsConfig(DialRequest{Host: "mx.example.com", TLS: &requirement}, &failure)
+
+			err := config.VerifyConnection(tls.ConnectionState{PeerCertificates: []*x509.Certificate{tt.peer}})
+			if (err != nil) != tt.wantReject {
+				t.Errorf("VerifyConnection() error = %v, want rejection %v", err, tt.wantReject)
+			}
+			switch {
+			case tt.wantFailure == "" && failure != nil:
+				t.Errorf("recorded failure %v", failure)
+			case tt.wantFailure != "" && (failure == nil || failure.Result != tt.wantFailure):
+				t.Errorf("recorded failure %v, want %s", failure, tt.wantFailure)
+			}
+		})
+	}
+}
+
+func newTestTLSPolicyService(policies *inmemory.MTASTSPolicyRepository, store *inmemory.Store, resolver TLSResolver, fetcher HTTPFetcher) *TLSPolicyService {
+	return NewTLSPolicyService(policies, inmemory.NewTLSResultRepository(store), resolver, fetcher, &TLSPolicyConfig{
+		EnableMTASTS: true,
+		EnableDANE:   true,
+		FetchTimeout: time.Second,
+	})
+}
+
+// assertTLSResult fails unless a result of the given type was recorded for
+// the domain
+func assertTLSResult(t *testing.T, store *inmemory.Store, domainName string, result domain.TLSResultType) {
+	t.Helper()
+	now := time.Now()
+	summaries, err := inmemory.NewTLSResultRepository(store).Summarize(context.Background(), domainName, now.Add(-time.Hour), now.Add(time.Hour))
+	if err != nil {
+		t.Fatalf("Summarize() error = %v", err)
+	}
+	for _, summary := range summaries {
+		if summary.ResultType == result {
+			return
+		}
+	}
+	t.Errorf("no %s result recorded for %s in %+v", result, domainName, summaries)
+}
+
+// newTestCertificate issues a certificate for host, self-signed when parent
+// is nil. An empty host makes a CA certificate.
+func newTestCertificate(t *testing.T, commonName, host string, parent *x509.Certificate, parentKey *ecdsa.PrivateKey) (*ecdsa.PrivateKey, *x509.Certificate) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	serial, err := rand.Int(rand.Reader, big.NewInt(1<<62))
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: commonName},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	if host == "" {
+		template.IsCA = true
+		template.BasicConstraintsValid = true
+		template.KeyUsage |= x509.KeyUsageCertSign
+	} else {
+		template.DNSNames = []string{host}
+	}
+	if parent == nil {
+		parent, parentKey = template, key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, parentKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key, cert
+}
+
+type fakeTLSA struct {
+	records []domain.TLSARecord
+	secure  bool
+	err     error
+}
+
+// fakeTLSResolver answers TXT and TLSA lookups from maps; missing names have
+// no records
+type fakeTLSResolver struct {
+	txt  map[string][]string
+	tlsa map[string]fakeTLSA
+}
+
+func (r *fakeTLSResolver) LookupTXT(ctx context.Context, name string) ([]string, error) {
+	return r.txt[name], nil
+}
+
+func (r *fakeTLSResolver) LookupTLSA(ctx context.Context, name string) ([]domain.TLSARecord, bool, error) {
+	answer := r.tlsa[name]
+	return answer.records, answer.secure, answer.err
+}
+
+// fakeHTTPFetcher serves one canned response and counts requests
+type fakeHTTPFetcher struct {
+	status      int
+	contentType string
+	body        string
+	err         error
+	requests    int
+	url         string
+}
+
+func (f *fakeHTTPFetcher) Do(req *http.Request) (*http.Response, error) {
+	f.requests++
+	f.url = req.URL.String()
+	if f.err != nil {
+		return nil, f.err
+	}
+	return &http.Response{
+		StatusCode: f.status,
+		Header:     http.Header{"Content-Type": []string{f.contentType}},
+		Body:       io.NopCloser(strings.NewReader(f.body)),
+	}, nil
+}
@@ -0,0 +1,26 @@
+// Package storage provides the object storage backends used by the blob
+// service: a local filesystem backend and an S3-compatible backend.
+package storage
+
+import (
+	"context"
+	"errors"
+	"io"
+)
+
+// ErrNotFound is returned when an object does not exist
+var ErrNotFound = errors.New("storage: object not found")
+
+// Backend stores opaque objects under keys such as "ab/cd/abcd...". Keys
+// are generated by the blob service and only use [0-9a-z./-].
+type Backend interface {
+	// Put stores size bytes read from r under key, replacing any object
+	Put(ctx context.Context, key string, r io.Reader, size int64) error
+	// Get reads length bytes starting at offset; a negative length reads
+	// to the end of the object
+	Get(ctx context.Context, key string, offset, length int64) (io.ReadCloser, error)
+	// Delete removes an object; deleting a missing object is not an error
+	Delete(ctx context.Context, key string) error
+	// Exists reports whether an object is stored under key
+	Exists(ctx context.Context, key string) (bool, error)
+}
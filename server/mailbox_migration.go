@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/skygenesisenterprise/aether-mailer/package/golang/repository"
+	"github.com/skygenesisenterprise/aether-mailer/package/golang/service"
+	"github.com/skygenesisenterprise/aether-mailer/server/src/config"
+	"github.com/skygenesisenterprise/aether-mailer/server/src/services"
+)
+
+// runMailboxMigration copie les boîtes aux lettres vers le stockage
+// configuré (MAILER_MAILBOX_BACKEND), depuis l'autre stockage par défaut.
+// Relancer la commande après une interruption ignore les messages déjà copiés.
+//
+//	server migrate-mailboxes [-from postgres|maildir] [-delete-source] [-batch-size N] [account-id...]
+func runMailboxMigration(cfg *config.Config, args []string) error {
+	mailerCfg, err := config.LoadMailerConfig(cfg)
+	if err != nil {
+		return err
+	}
+
+	flags := flag.NewFlagSet("migrate-mailboxes", flag.ContinueOnError)
+	target := mailerCfg.Storage.Mailbox
+	from := flags.String("from", otherMailboxBackend(target), "mailbox backend to copy from: postgres or maildir")
+	deleteSource := flags.Bool("delete-source", false, "delete the source messages of accounts copied without failures")
+	batchSize := flags.Int("batch-size", 0, "messages read from the source per query, 200 when zero")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *from == target {
+		return fmt.Errorf("mailboxes are already stored in %s", target)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	repos, err := services.OpenMailerRepositories(ctx, mailerCfg)
+	if err != nil {
+		return err
+	}
+	defer repos.Close()
+	source, err := repos.MailboxStore(*from)
+	if err != nil {
+		return err
+	}
+
+	accountIDs := flags.Args()
+	if len(accountIDs) == 0 {
+		if accountIDs, err = listAccountIDs(ctx, repos.EmailAccounts); err != nil {
+			return err
+		}
+	}
+
+	fmt.Printf("\033[1;34m[info] Migrating %d mailboxes from %s to %s...\033[0m\n", len(accountIDs), *from, target)
+	migrator := service.NewMailboxMigrator(source, repos.Messages, &service.MailboxMigrationConfig{
+		BatchSize:    *batchSize,
+		DeleteSource: *deleteSource,
+	})
+	reports, err := migrator.MigrateAccounts(ctx, accountIDs)
+
+	failed := 0
+	for _, report := range reports {
+		fmt.Printf("\033[1;34m[info] %s: %d copied, %d skipped, %d failed, %d deleted\033[0m\n",
+			report.AccountID, report.Copied, report.Skipped, report.Failed, report.Deleted)
+		for id, reason := range report.Failures {
+			fmt.Printf("\033[1;33m[warn]   message %s: %s\033[0m\n", id, reason)
+		}
+		failed += report.Failed
+	}
+	if err != nil {
+		return err
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d messages could not be copied, run the migration again once they are fixed", failed)
+	}
+	fmt.Printf("\033[1;32m[success] Mailboxes migrated to %s\033[0m\n", target)
+	return nil
+}
+
+func otherMailboxBackend(backend string) string {
+	if backend == "maildir" {
+		return "postgres"
+	}
+	return "maildir"
+}
+
+// listAccountIDs retourne les identifiants de tous les comptes de messagerie
+func listAccountIDs(ctx context.Context, accounts repository.EmailAccountRepository) ([]string, error) {
+	const batch = 500
+	ids := []string{}
+	for offset := 0; ; offset += batch {
+		page, err := accounts.List(ctx, repository.EmailAccountFilter{Limit: batch, Offset: offset})
+		if err != nil {
+			return nil, err
+		}
+		for _, account := range page {
+			ids = append(ids, account.ID)
+		}
+		if len(page) < batch {
+			return ids, nil
+		}
+	}
+}
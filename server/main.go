@@ -1,12 +1,17 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"io"
 	"log"
+	"net"
 	"net/http"
 	"os"
+	"os/signal"
 	"runtime"
+	"sync"
+	"syscall"
 	"time"
 
 	"github.com/gin-contrib/sessions"
@@ -44,6 +49,15 @@ func displayBanner() {
 }
 
 func main() {
+	// Commande de migration des boîtes aux lettres entre stockages
+	if len(os.Args) > 1 && os.Args[1] == "migrate-mailboxes" {
+		if err := runMailboxMigration(config.LoadConfig(), os.Args[2:]); err != nil {
+			fmt.Printf("\033[1;31m[error] Mailbox migration failed: %v\033[0m\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
 	displayBanner()
 
 	fmt.Printf("\033[1;34m[info] Initializing identity management system...\033[0m\n")
@@ -52,8 +66,13 @@ func main() {
 	// Charger la configuration
 	fmt.Printf("\033[1;34m[info] Loading configuration...\033[0m\n")
 	cfg := config.LoadConfig()
+	services.DNSDefaults = services.DNSRecordOptionsFromConfig(cfg)
 	time.Sleep(200 * time.Millisecond)
 
+	// Arrêt propre des tâches de fond et du serveur HTTP sur SIGINT ou SIGTERM
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
 	// Initialiser Gin
 	fmt.Printf("\033[1;34m[info] Setting up Gin router...\033[0m\n")
 	gin.SetMode(gin.ReleaseMode)
@@ -121,6 +140,50 @@ func main() {
 		time.Sleep(200 * time.Millisecond)
 	}
 
+	// Démarrer la revérification périodique de la propriété des domaines
+	if dbInitialized && dbService != nil {
+		fmt.Printf("\033[1;34m[info] Starting domain verification scheduler...\033[0m\n")
+		verificationOptions := services.DomainVerificationOptionsFromConfig(cfg)
+		verificationService := services.NewDomainVerificationService(
+			dbService.GetDB(),
+			net.DefaultResolver,
+			services.NewDomainVerificationHTTPClient(10*time.Second),
+			verificationOptions,
+		)
+		go verificationService.RunScheduler(ctx, verificationOptions.RetryInterval)
+	}
+
+	// Initialiser les services du mailer (les endpoints de messagerie répondent 503 sans eux)
+	fmt.Printf("\033[1;34m[info] Initializing mailer services...\033[0m\n")
+	var mailerRepos *services.MailerRepositories
+	var mailerWorkers sync.WaitGroup
+	mailerCfg, err := config.LoadMailerConfig(cfg)
+	if err == nil {
+		mailerRepos, err = services.OpenMailerRepositories(ctx, mailerCfg)
+	}
+	if err == nil {
+		services.Mailer, err = services.NewMailerServices(mailerCfg, mailerRepos)
+		if err != nil {
+			mailerRepos.Close()
+		}
+	}
+	if err != nil {
+		fmt.Printf("\033[1;33m[warn] Failed to initialize mailer services: %v\033[0m\n", err)
+		fmt.Printf("\033[1;33m[warn] Mail endpoints disabled\033[0m\n")
+	} else {
+		// Les dépôts sont fermés une fois les tâches de fond arrêtées
+		defer mailerRepos.Close()
+		defer mailerWorkers.Wait()
+		mailerWorkers.Add(1)
+		go func() {
+			defer mailerWorkers.Done()
+			services.Mailer.Run(ctx)
+		}()
+		fmt.Printf("\033[1;32m[success] Mailer services started (%s repositories, %s mailboxes)\033[0m\n",
+			mailerCfg.Database.Driver, mailerCfg.Storage.Mailbox)
+	}
+	time.Sleep(200 * time.Millisecond)
+
 	// Initialiser le ServiceKeyService
 	var serviceKeyService *services.ServiceKeyService
 	if dbInitialized && dbService != nil {
@@ -154,8 +217,25 @@ func main() {
 
 	// Démarrer le serveur
 	fmt.Printf("\033[1;34m[info] Starting HTTP server...\033[0m\n")
-	if err := router.Run(":" + cfg.Port); err != nil {
+	server := &http.Server{Addr: ":" + cfg.Port, Handler: router}
+	serverErr := make(chan error, 1)
+	go func() {
+		serverErr <- server.ListenAndServe()
+	}()
+
+	select {
+	case err := <-serverErr:
 		fmt.Printf("\033[1;31m[error] Failed to start server: %v\033[0m\n", err)
+		stop()
+		mailerWorkers.Wait()
 		log.Fatal(err)
+	case <-ctx.Done():
+	}
+
+	fmt.Printf("\033[1;34m[info] Shutting down...\033[0m\n")
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		fmt.Printf("\033[1;33m[warn] Error stopping HTTP server: %v\033[0m\n", err)
 	}
 }
\ No newline at end of file
@@ -17,6 +17,21 @@ type Config struct {
 	CORSAllowedOrigins    []string // Origines CORS autorisées
 	DefaultPostLoginPath  string   // Chemin par défaut après login
 	DefaultPostLogoutPath string   // Chemin par défaut après logout
+	MailHostname          string   // Nom d'hôte public du serveur de messagerie (SMTP, IMAP, MTA-STS)
+	MailMXHosts           []string // Hôtes MX publiés pour les domaines gérés, par ordre de préférence
+	MailSPFIncludes       []string // Domaines inclus dans l'enregistrement SPF
+	MailSPFAddresses      []string // Adresses IP d'envoi ajoutées à l'enregistrement SPF
+	MTASTSMode            string   // Mode par défaut de la politique MTA-STS publiée : enforce, testing ou none
+	MTASTSMaxAge          int      // Durée de validité de la politique MTA-STS en secondes
+	DKIMSelector          string   // Sélecteur DKIM publié
+	DKIMPublicKey         string   // Clé publique DKIM en base64, vide si DKIM n'est pas configuré
+	DMARCPolicy           string   // Politique DMARC publiée : none, quarantine ou reject
+	DNSRecordTTL          int      // TTL des enregistrements DNS générés en secondes
+	DomainVerifyInterval  int      // Intervalle de revérification des domaines vérifiés en secondes
+	DomainVerifyRetry     int      // Intervalle entre deux vérifications d'un domaine en attente en secondes
+	DomainVerifyMaxFails  int      // Échecs consécutifs avant qu'un domaine vérifié perde son statut
+	DomainVerifyMaxAge    int      // Durée maximale en attente avant l'abandon d'une vérification en secondes
+	MailerConfigPath      string   // Fichier JSON de configuration du SDK mailer, valeurs par défaut du SDK si vide
 }
 
 // LoadConfig charge la configuration depuis les variables d'environnement
@@ -31,6 +46,21 @@ func LoadConfig() *Config {
 		CORSAllowedOrigins:    parseEnvList(getEnv("CORS_ALLOWED_ORIGINS", "http://localhost:3000,http://localhost:8080")),
 		DefaultPostLoginPath:  getEnv("DEFAULT_POST_LOGIN_PATH", "/"),
 		DefaultPostLogoutPath: getEnv("DEFAULT_POST_LOGOUT_PATH", "/"),
+		MailHostname:          getEnv("MAIL_HOSTNAME", "localhost"),
+		MailMXHosts:           parseEnvList(getEnv("MAIL_MX_HOSTS", getEnv("MAIL_HOSTNAME", "localhost"))),
+		MailSPFIncludes:       parseEnvList(getEnv("MAIL_SPF_INCLUDES", "")),
+		MailSPFAddresses:      parseEnvList(getEnv("MAIL_SPF_ADDRESSES", "")),
+		MTASTSMode:            getEnv("MTA_STS_MODE", "testing"),
+		MTASTSMaxAge:          getEnvAsInt("MTA_STS_MAX_AGE", 604800),
+		DKIMSelector:          getEnv("DKIM_SELECTOR", "mail"),
+		DKIMPublicKey:         getEnv("DKIM_PUBLIC_KEY", ""),
+		DMARCPolicy:           getEnv("DMARC_POLICY", "quarantine"),
+		DNSRecordTTL:          getEnvAsInt("DNS_RECORD_TTL", 3600),
+		DomainVerifyInterval:  getEnvAsInt("DOMAIN_VERIFY_INTERVAL", 86400),
+		DomainVerifyRetry:     getEnvAsInt("DOMAIN_VERIFY_RETRY", 900),
+		DomainVerifyMaxFails:  getEnvAsInt("DOMAIN_VERIFY_MAX_FAILURES", 3),
+		DomainVerifyMaxAge:    getEnvAsInt("DOMAIN_VERIFY_MAX_PENDING_AGE", 604800),
+		MailerConfigPath:      getEnv("MAILER_CONFIG", ""),
 	}
 }
 
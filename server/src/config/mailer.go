@@ -0,0 +1,38 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	sdkconfig "github.com/skygenesisenterprise/aether-mailer/package/golang/config"
+)
+
+// LoadMailerConfig charge la configuration du SDK mailer : les valeurs par
+// défaut du SDK, surchargées par le fichier JSON MailerConfigPath puis par
+// les variables d'environnement MAILER_*
+func LoadMailerConfig(cfg *Config) (*sdkconfig.Config, error) {
+	mailerCfg := sdkconfig.DefaultConfig()
+	if cfg.MailerConfigPath != "" {
+		content, err := os.ReadFile(cfg.MailerConfigPath)
+		if err != nil {
+			return nil, fmt.Errorf("reading mailer configuration: %w", err)
+		}
+		if err := json.Unmarshal(content, mailerCfg); err != nil {
+			return nil, fmt.Errorf("parsing mailer configuration %s: %w", cfg.MailerConfigPath, err)
+		}
+	}
+
+	mailerCfg.Database.Driver = getEnv("MAILER_DATABASE_DRIVER", mailerCfg.Database.Driver)
+	mailerCfg.Database.MigrationPath = getEnv("MAILER_MIGRATION_PATH", mailerCfg.Database.MigrationPath)
+	mailerCfg.Storage.Mailbox = getEnv("MAILER_MAILBOX_BACKEND", mailerCfg.Storage.Mailbox)
+	mailerCfg.Storage.MaildirPath = getEnv("MAILER_MAILDIR_PATH", mailerCfg.Storage.MaildirPath)
+	if mailerCfg.Security.JWTSecret == "" {
+		mailerCfg.Security.JWTSecret = cfg.JWTSecret
+	}
+
+	if err := mailerCfg.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid mailer configuration: %w", err)
+	}
+	return mailerCfg, nil
+}
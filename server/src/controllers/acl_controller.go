@@ -0,0 +1,483 @@
+package controllers
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/skygenesisenterprise/aether-mailer/package/golang/domain"
+	mailerrors "github.com/skygenesisenterprise/aether-mailer/package/golang/errors"
+	"github.com/skygenesisenterprise/aether-mailer/package/golang/service"
+	"github.com/skygenesisenterprise/aether-mailer/server/src/models"
+	"github.com/skygenesisenterprise/aether-mailer/server/src/services"
+)
+
+// ListMailSharedMailboxes lists the shared mailboxes and the accounts of other
+// users the user can open
+func ListMailSharedMailboxes(c *gin.Context) {
+	if !requireMailer(c) {
+		return
+	}
+	userID, ok := currentUserID(c)
+	if !ok {
+		return
+	}
+
+	accounts, err := services.Mailer.ACLs.ListSharedMailboxes(c.Request.Context(), userID)
+	if err != nil {
+		respondMailerError(c, err)
+		return
+	}
+	mailboxes := make([]*models.SharedMailbox, 0, len(accounts))
+	for _, account := range accounts {
+		mailboxes = append(mailboxes, toSharedMailboxModel(account))
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data": gin.H{
+			"mailboxes": mailboxes,
+			"total":     len(mailboxes),
+		},
+	})
+}
+
+// CreateMailSharedMailbox creates a shared mailbox in a domain the user
+// administers
+func CreateMailSharedMailbox(c *gin.Context) {
+	if !requireMailer(c) {
+		return
+	}
+	userID, ok := currentUserID(c)
+	if !ok {
+		return
+	}
+	var req models.CreateSharedMailboxRequest
+	if !bindMailerJSON(c, &req) {
+		return
+	}
+
+	account, err := services.Mailer.ACLs.CreateSharedMailbox(c.Request.Context(), userID, service.SharedMailboxRequest{
+		Email:       req.Email,
+		DisplayName: req.DisplayName,
+		QuotaMB:     req.QuotaMB,
+	})
+	if err != nil {
+		respondMailerError(c, err)
+		return
+	}
+	c.JSON(http.StatusCreated, gin.H{
+		"success": true,
+		"data":    toSharedMailboxModel(account),
+	})
+}
+
+// ListMailGroups lists the groups of a domain the user administers
+func ListMailGroups(c *gin.Context) {
+	if !requireMailer(c) {
+		return
+	}
+	userID, ok := currentUserID(c)
+	if !ok {
+		return
+	}
+	domainID := c.Query("domain_id")
+	if domainID == "" {
+		respondInvalidMailQuery(c, "domain_id is required")
+		return
+	}
+
+	groups, err := services.Mailer.ACLs.ListGroups(c.Request.Context(), userID, domainID)
+	if err != nil {
+		respondMailerError(c, err)
+		return
+	}
+	result := make([]*models.MailGroup, 0, len(groups))
+	for _, group := range groups {
+		result = append(result, toMailGroupModel(group))
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data": gin.H{
+			"domain_id": domainID,
+			"groups":    result,
+		},
+	})
+}
+
+// CreateMailGroup creates a group in a domain the user administers
+func CreateMailGroup(c *gin.Context) {
+	if !requireMailer(c) {
+		return
+	}
+	userID, ok := currentUserID(c)
+	if !ok {
+		return
+	}
+	var req models.CreateMailGroupRequest
+	if !bindMailerJSON(c, &req) {
+		return
+	}
+
+	group, err := services.Mailer.ACLs.CreateGroup(c.Request.Context(), userID, service.GroupRequest{
+		DomainID:    req.DomainID,
+		Name:        req.Name,
+		Description: req.Description,
+	})
+	if err != nil {
+		respondMailerError(c, err)
+		return
+	}
+	c.JSON(http.StatusCreated, gin.H{
+		"success": true,
+		"data":    toMailGroupModel(group),
+	})
+}
+
+// DeleteMailGroup deletes a group with the rights granted to it
+func DeleteMailGroup(c *gin.Context) {
+	if !requireMailer(c) {
+		return
+	}
+	userID, ok := currentUserID(c)
+	if !ok {
+		return
+	}
+
+	if err := services.Mailer.ACLs.DeleteGroup(c.Request.Context(), userID, c.Param("id")); err != nil {
+		respondMailerError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "Group deleted",
+	})
+}
+
+// ListMailGroupMembers lists the members of a group
+func ListMailGroupMembers(c *gin.Context) {
+	if !requireMailer(c) {
+		return
+	}
+	userID, ok := currentUserID(c)
+	if !ok {
+		return
+	}
+
+	members, err := services.Mailer.ACLs.ListGroupMembers(c.Request.Context(), userID, c.Param("id"))
+	if err != nil {
+		respondMailerError(c, err)
+		return
+	}
+	result := make([]*models.MailGroupMember, 0, len(members))
+	for _, member := range members {
+		result = append(result, toMailGroupMemberModel(member))
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data": gin.H{
+			"group_id": c.Param("id"),
+			"members":  result,
+		},
+	})
+}
+
+// AddMailGroupMember adds a user to a group
+func AddMailGroupMember(c *gin.Context) {
+	if !requireMailer(c) {
+		return
+	}
+	userID, ok := currentUserID(c)
+	if !ok {
+		return
+	}
+	var req models.AddMailGroupMemberRequest
+	if !bindMailerJSON(c, &req) {
+		return
+	}
+
+	member, err := services.Mailer.ACLs.AddGroupMember(c.Request.Context(), userID, c.Param("id"), req.Member)
+	if err != nil {
+		respondMailerError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    toMailGroupMemberModel(member),
+	})
+}
+
+// RemoveMailGroupMember removes a user from a group
+func RemoveMailGroupMember(c *gin.Context) {
+	if !requireMailer(c) {
+		return
+	}
+	userID, ok := currentUserID(c)
+	if !ok {
+		return
+	}
+
+	err := services.Mailer.ACLs.RemoveGroupMember(c.Request.Context(), userID, c.Param("id"), c.Param("userId"))
+	if err != nil {
+		respondMailerError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "Member removed",
+	})
+}
+
+// GetMailFolderACL returns the access control list of a folder the user
+// administers, as IMAP GETACL
+func GetMailFolderACL(c *gin.Context) {
+	if !requireMailer(c) {
+		return
+	}
+	userID, ok := currentUserID(c)
+	if !ok {
+		return
+	}
+
+	entries, err := services.Mailer.ACLs.GetACL(c.Request.Context(), userID, c.Param("id"))
+	if err != nil {
+		respondMailerError(c, err)
+		return
+	}
+	result := make([]*models.FolderACLEntry, 0, len(entries))
+	for _, entry := range entries {
+		result = append(result, toFolderACLModel(entry))
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data": gin.H{
+			"folder_id": c.Param("id"),
+			"acl":       result,
+		},
+	})
+}
+
+// SetMailFolderACL sets the rights of an identifier on a folder the user
+// administers, as IMAP SETACL
+func SetMailFolderACL(c *gin.Context) {
+	if !requireMailer(c) {
+		return
+	}
+	userID, ok := currentUserID(c)
+	if !ok {
+		return
+	}
+	var req models.SetFolderACLRequest
+	if !bindMailerJSON(c, &req) {
+		return
+	}
+	rights := req.Rights
+	if len(req.RightNames) > 0 {
+		names := make([]string, 0, len(req.RightNames))
+		for _, name := range req.RightNames {
+			names = append(names, strings.ToLower(strings.TrimSpace(name)))
+		}
+		parsed, ok := domain.ParseRightNames(names)
+		if !ok {
+			respondMailerError(c, mailerrors.InvalidRights(strings.Join(req.RightNames, ",")))
+			return
+		}
+		rights = string(parsed)
+	}
+
+	entry, err := services.Mailer.ACLs.SetACL(c.Request.Context(), userID, c.Param("id"), req.Identifier, rights)
+	if err != nil {
+		respondMailerError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    toFolderACLModel(entry),
+	})
+}
+
+// DeleteMailFolderACL removes the entry of an identifier from the access
+// control list of a folder, as IMAP DELETEACL
+func DeleteMailFolderACL(c *gin.Context) {
+	if !requireMailer(c) {
+		return
+	}
+	userID, ok := currentUserID(c)
+	if !ok {
+		return
+	}
+
+	err := services.Mailer.ACLs.DeleteACL(c.Request.Context(), userID, c.Param("id"), c.Param("identifier"))
+	if err != nil {
+		respondMailerError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "Rights removed",
+	})
+}
+
+// GetMailFolderMyRights returns the rights of the user on a folder, as IMAP
+// MYRIGHTS and as the JMAP myRights of the mailbox
+func GetMailFolderMyRights(c *gin.Context) {
+	if !requireMailer(c) {
+		return
+	}
+	userID, ok := currentUserID(c)
+	if !ok {
+		return
+	}
+
+	rights, err := services.Mailer.ACLs.MyRights(c.Request.Context(), userID, c.Param("id"))
+	if err != nil {
+		respondMailerError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data": gin.H{
+			"folder_id":   c.Param("id"),
+			"rights":      string(rights),
+			"right_names": rights.Names(),
+			"my_rights":   rights.Mailbox(),
+		},
+	})
+}
+
+// ListMailFolderRights lists the rights that may be granted to an
+// identifier on a folder, as IMAP LISTRIGHTS
+func ListMailFolderRights(c *gin.Context) {
+	if !requireMailer(c) {
+		return
+	}
+	userID, ok := currentUserID(c)
+	if !ok {
+		return
+	}
+	identifier := c.Query("identifier")
+	if identifier == "" {
+		respondInvalidMailQuery(c, "identifier is required")
+		return
+	}
+
+	list, err := services.Mailer.ACLs.ListRights(c.Request.Context(), userID, c.Param("id"), identifier)
+	if err != nil {
+		respondMailerError(c, err)
+		return
+	}
+	optional := make([]string, 0, len(list.Optional))
+	for _, rights := range list.Optional {
+		optional = append(optional, string(rights))
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data": gin.H{
+			"folder_id":  c.Param("id"),
+			"identifier": list.Identifier,
+			"required":   string(list.Required),
+			"optional":   optional,
+		},
+	})
+}
+
+// ListMailACLAudit lists the changes to folder rights, groups and shared
+// mailboxes of a domain the user administers, newest first
+func ListMailACLAudit(c *gin.Context) {
+	if !requireMailer(c) {
+		return
+	}
+	userID, ok := currentUserID(c)
+	if !ok {
+		return
+	}
+	domainID := c.Query("domain_id")
+	if domainID == "" {
+		respondInvalidMailQuery(c, "domain_id is required")
+		return
+	}
+
+	limit, offset := mailPage(queryInt(c, "limit", 50), queryInt(c, "offset", 0))
+	entries, total, err := services.Mailer.ACLs.ListAuditLog(c.Request.Context(), userID, domainID,
+		c.Query("account_id"), limit, offset)
+	if err != nil {
+		respondMailerError(c, err)
+		return
+	}
+	result := make([]*models.ACLAuditEntry, 0, len(entries))
+	for _, entry := range entries {
+		result = append(result, toACLAuditModel(entry))
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data": gin.H{
+			"domain_id": domainID,
+			"total":     total,
+			"position":  offset,
+			"per_page":  limit,
+			"entries":   result,
+			"has_more":  offset+len(entries) < total,
+		},
+	})
+}
+
+func toSharedMailboxModel(account *domain.EmailAccount) *models.SharedMailbox {
+	model := &models.SharedMailbox{
+		ID:        account.ID,
+		DomainID:  account.DomainID,
+		Email:     account.Email,
+		IsShared:  account.IsShared(),
+		QuotaMB:   account.QuotaMB,
+		UsedMB:    account.UsedMB,
+		CreatedAt: account.CreatedAt,
+	}
+	if account.DisplayName != nil {
+		model.DisplayName = *account.DisplayName
+	}
+	return model
+}
+
+func toMailGroupModel(group *domain.Group) *models.MailGroup {
+	return &models.MailGroup{
+		ID:          group.ID,
+		DomainID:    group.DomainID,
+		Name:        group.Name,
+		Description: group.Description,
+		CreatedAt:   group.CreatedAt,
+		UpdatedAt:   group.UpdatedAt,
+	}
+}
+
+func toMailGroupMemberModel(member *domain.GroupMember) *models.MailGroupMember {
+	return &models.MailGroupMember{
+		GroupID: member.GroupID,
+		UserID:  member.UserID,
+		AddedAt: member.AddedAt,
+	}
+}
+
+func toFolderACLModel(entry *service.ACLEntry) *models.FolderACLEntry {
+	return &models.FolderACLEntry{
+		Identifier:  entry.Identifier,
+		SubjectType: strings.ToLower(string(entry.SubjectType)),
+		SubjectID:   entry.SubjectID,
+		Rights:      string(entry.Rights),
+		RightNames:  entry.Rights.Names(),
+	}
+}
+
+func toACLAuditModel(entry *domain.ACLAuditEntry) *models.ACLAuditEntry {
+	return &models.ACLAuditEntry{
+		ID:          entry.ID,
+		DomainID:    entry.DomainID,
+		AccountID:   entry.AccountID,
+		FolderID:    entry.FolderID,
+		GroupID:     entry.GroupID,
+		Action:      strings.ToLower(string(entry.Action)),
+		SubjectType: strings.ToLower(string(entry.SubjectType)),
+		SubjectID:   entry.SubjectID,
+		OldRights:   string(entry.OldRights),
+		NewRights:   string(entry.NewRights),
+		ActorID:     entry.ActorID,
+		CreatedAt:   entry.CreatedAt,
+	}
+}
@@ -165,6 +165,7 @@ func (dc *DatabaseController) Migrate(c *gin.Context) {
 		&models.Domain{},
 		&models.UserDomain{},
 		&models.DomainVerification{},
+		&models.DomainVerificationAttempt{},
 		&models.DomainSettings{},
 		&models.ExternalAccount{},
 		&models.OAuthState{},
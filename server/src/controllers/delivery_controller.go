@@ -0,0 +1,270 @@
+package controllers
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/skygenesisenterprise/aether-mailer/package/golang/domain"
+	"github.com/skygenesisenterprise/aether-mailer/package/golang/service"
+	"github.com/skygenesisenterprise/aether-mailer/server/src/services"
+)
+
+// DestinationPolicyRequest represents a request to create a destination policy
+type DestinationPolicyRequest struct {
+	Name                     string `json:"name" binding:"required"`
+	MXPattern                string `json:"mx_pattern" binding:"required"`
+	MaxConnections           int    `json:"max_connections" binding:"required,min=1"`
+	MaxMessagesPerConnection int    `json:"max_messages_per_connection" binding:"min=0"`
+	MaxMessagesPerMinute     int    `json:"max_messages_per_minute" binding:"min=0"`
+	BackoffSeconds           int64  `json:"backoff_seconds" binding:"min=0"`
+	MaxBackoffSeconds        int64  `json:"max_backoff_seconds" binding:"min=0"`
+}
+
+// UpdateDestinationPolicyRequest represents a request to update a destination policy
+type UpdateDestinationPolicyRequest struct {
+	Name                     *string `json:"name"`
+	MXPattern                *string `json:"mx_pattern"`
+	MaxConnections           *int    `json:"max_connections"`
+	MaxMessagesPerConnection *int    `json:"max_messages_per_connection"`
+	MaxMessagesPerMinute     *int    `json:"max_messages_per_minute"`
+	BackoffSeconds           *int64  `json:"backoff_seconds"`
+	MaxBackoffSeconds        *int64  `json:"max_backoff_seconds"`
+	IsActive                 *bool   `json:"is_active"`
+}
+
+// DestinationPolicyResponse represents a destination policy
+type DestinationPolicyResponse struct {
+	ID                       string    `json:"id"`
+	Name                     string    `json:"name"`
+	MXPattern                string    `json:"mx_pattern"`
+	MaxConnections           int       `json:"max_connections"`
+	MaxMessagesPerConnection int       `json:"max_messages_per_connection"`
+	MaxMessagesPerMinute     int       `json:"max_messages_per_minute"`
+	BackoffSeconds           int64     `json:"backoff_seconds"`
+	MaxBackoffSeconds        int64     `json:"max_backoff_seconds"`
+	IsActive                 bool      `json:"is_active"`
+	CreatedAt                time.Time `json:"created_at"`
+	UpdatedAt                time.Time `json:"updated_at"`
+}
+
+// DestinationQueueResponse represents the live outbound queue of a destination
+type DestinationQueueResponse struct {
+	Destination     string                   `json:"destination"`
+	PolicyID        string                   `json:"policy_id,omitempty"`
+	PolicyName      string                   `json:"policy_name"`
+	Queued          int                      `json:"queued"`
+	Deferred        int                      `json:"deferred"`
+	Active          int                      `json:"active"`
+	OpenConnections int                      `json:"open_connections"`
+	SentLastMinute  int                      `json:"sent_last_minute"`
+	BackoffUntil    *time.Time               `json:"backoff_until"`
+	DeferralReasons []DeferralReasonResponse `json:"deferral_reasons"`
+}
+
+// DeferralReasonResponse represents an aggregated deferral reason
+type DeferralReasonResponse struct {
+	Code     int       `json:"code"`
+	Message  string    `json:"message"`
+	Count    int       `json:"count"`
+	LastSeen time.Time `json:"last_seen"`
+}
+
+// GetDeliveryQueue returns the live queue depth and deferral reasons of every destination
+func GetDeliveryQueue(c *gin.Context) {
+	if !requireMailer(c) {
+		return
+	}
+
+	statuses := services.Mailer.Delivery.QueueStatus()
+	data := make([]DestinationQueueResponse, 0, len(statuses))
+	for _, status := range statuses {
+		data = append(data, toDestinationQueueResponse(status))
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    data,
+	})
+}
+
+// GetDestinationQueue returns the live queue of one destination. The
+// destination is a recipient domain or the MX pattern of a policy.
+func GetDestinationQueue(c *gin.Context) {
+	if !requireMailer(c) {
+		return
+	}
+
+	status, err := services.Mailer.Delivery.DestinationStatus(c.Param("destination"))
+	if err != nil {
+		respondMailerError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    toDestinationQueueResponse(*status),
+	})
+}
+
+// ListDestinationPolicies lists the outbound destination policies
+func ListDestinationPolicies(c *gin.Context) {
+	if !requireMailer(c) {
+		return
+	}
+
+	policies, err := services.Mailer.Delivery.ListPolicies(c.Request.Context())
+	if err != nil {
+		respondMailerError(c, err)
+		return
+	}
+
+	data := make([]DestinationPolicyResponse, 0, len(policies))
+	for _, policy := range policies {
+		data = append(data, toDestinationPolicyResponse(policy))
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    data,
+	})
+}
+
+// CreateDestinationPolicy creates an outbound destination policy
+func CreateDestinationPolicy(c *gin.Context) {
+	if !requireMailer(c) {
+		return
+	}
+
+	var req DestinationPolicyRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"error":   "Invalid request body",
+			"message": err.Error(),
+		})
+		return
+	}
+
+	policy, err := services.Mailer.Delivery.CreatePolicy(c.Request.Context(), service.CreateDestinationPolicyRequest{
+		Name:                     req.Name,
+		MXPattern:                req.MXPattern,
+		MaxConnections:           req.MaxConnections,
+		MaxMessagesPerConnection: req.MaxMessagesPerConnection,
+		MaxMessagesPerMinute:     req.MaxMessagesPerMinute,
+		Backoff:                  time.Duration(req.BackoffSeconds) * time.Second,
+		MaxBackoff:               time.Duration(req.MaxBackoffSeconds) * time.Second,
+	})
+	if err != nil {
+		respondMailerError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"success": true,
+		"data":    toDestinationPolicyResponse(policy),
+	})
+}
+
+// UpdateDestinationPolicy updates an outbound destination policy
+func UpdateDestinationPolicy(c *gin.Context) {
+	if !requireMailer(c) {
+		return
+	}
+
+	var req UpdateDestinationPolicyRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"error":   "Invalid request body",
+			"message": err.Error(),
+		})
+		return
+	}
+
+	update := service.UpdateDestinationPolicyRequest{
+		Name:                     req.Name,
+		MXPattern:                req.MXPattern,
+		MaxConnections:           req.MaxConnections,
+		MaxMessagesPerConnection: req.MaxMessagesPerConnection,
+		MaxMessagesPerMinute:     req.MaxMessagesPerMinute,
+		IsActive:                 req.IsActive,
+	}
+	if req.BackoffSeconds != nil {
+		backoff := time.Duration(*req.BackoffSeconds) * time.Second
+		update.Backoff = &backoff
+	}
+	if req.MaxBackoffSeconds != nil {
+		maxBackoff := time.Duration(*req.MaxBackoffSeconds) * time.Second
+		update.MaxBackoff = &maxBackoff
+	}
+
+	policy, err := services.Mailer.Delivery.UpdatePolicy(c.Request.Context(), c.Param("id"), update)
+	if err != nil {
+		respondMailerError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    toDestinationPolicyResponse(policy),
+	})
+}
+
+// DeleteDestinationPolicy deletes an outbound destination policy
+func DeleteDestinationPolicy(c *gin.Context) {
+	if !requireMailer(c) {
+		return
+	}
+
+	if err := services.Mailer.Delivery.DeletePolicy(c.Request.Context(), c.Param("id")); err != nil {
+		respondMailerError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "Destination policy deleted",
+	})
+}
+
+func toDestinationPolicyResponse(policy *domain.DestinationPolicy) DestinationPolicyResponse {
+	return DestinationPolicyResponse{
+		ID:                       policy.ID,
+		Name:                     policy.Name,
+		MXPattern:                policy.MXPattern,
+		MaxConnections:           policy.MaxConnections,
+		MaxMessagesPerConnection: policy.MaxMessagesPerConnection,
+		MaxMessagesPerMinute:     policy.MaxMessagesPerMinute,
+		BackoffSeconds:           int64(policy.Backoff / time.Second),
+		MaxBackoffSeconds:        int64(policy.MaxBackoff / time.Second),
+		IsActive:                 policy.IsActive,
+		CreatedAt:                policy.CreatedAt,
+		UpdatedAt:                policy.UpdatedAt,
+	}
+}
+
+func toDestinationQueueResponse(status domain.DestinationQueueStatus) DestinationQueueResponse {
+	reasons := make([]DeferralReasonResponse, 0, len(status.DeferralReasons))
+	for _, reason := range status.DeferralReasons {
+		reasons = append(reasons, DeferralReasonResponse{
+			Code:     reason.Code,
+			Message:  reason.Message,
+			Count:    reason.Count,
+			LastSeen: reason.LastSeen,
+		})
+	}
+
+	return DestinationQueueResponse{
+		Destination:     status.Destination,
+		PolicyID:        status.PolicyID,
+		PolicyName:      status.PolicyName,
+		Queued:          status.Queued,
+		Deferred:        status.Deferred,
+		Active:          status.Active,
+		OpenConnections: status.OpenConnections,
+		SentLastMinute:  status.SentLastMinute,
+		BackoffUntil:    status.BackoffUntil,
+		DeferralReasons: reasons,
+	}
+}
@@ -0,0 +1,212 @@
+package controllers
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/skygenesisenterprise/aether-mailer/package/golang/domain"
+	"github.com/skygenesisenterprise/aether-mailer/server/src/models"
+	"github.com/skygenesisenterprise/aether-mailer/server/src/services"
+)
+
+// DKIMKeyResponse represents a DKIM key without its private part
+type DKIMKeyResponse struct {
+	ID          string     `json:"id"`
+	Selector    string     `json:"selector"`
+	Algorithm   string     `json:"algorithm"`
+	KeyBits     int        `json:"key_bits,omitempty"`
+	State       string     `json:"state"`
+	RecordName  string     `json:"record_name"`
+	RecordValue string     `json:"record_value"`
+	CreatedAt   time.Time  `json:"created_at"`
+	PublishedAt *time.Time `json:"published_at,omitempty"`
+	VerifiedAt  *time.Time `json:"verified_at,omitempty"`
+	ActivatedAt *time.Time `json:"activated_at,omitempty"`
+	RetireAfter *time.Time `json:"retire_after,omitempty"`
+	RetiredAt   *time.Time `json:"retired_at,omitempty"`
+}
+
+// ImportDKIMKeyRequest represents the request to import an existing DKIM key
+type ImportDKIMKeyRequest struct {
+	Selector   string `json:"selector" binding:"required"`
+	PrivateKey string `json:"private_key" binding:"required"` // PEM, PKCS#1 or PKCS#8
+}
+
+// ListDKIMKeys lists the DKIM keys of a managed domain
+func ListDKIMKeys(c *gin.Context) {
+	domainEntity, ok := requireDKIMDomain(c)
+	if !ok {
+		return
+	}
+
+	keys, err := services.Mailer.DKIM.ListKeys(c.Request.Context(), domainEntity.Name)
+	if err != nil {
+		respondMailerError(c, err)
+		return
+	}
+
+	data := make([]DKIMKeyResponse, 0, len(keys))
+	for _, key := range keys {
+		data = append(data, toDKIMKeyResponse(key))
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    data,
+	})
+}
+
+// GetDKIMRotationHistory returns the DKIM rotation audit log of a managed domain
+func GetDKIMRotationHistory(c *gin.Context) {
+	domainEntity, ok := requireDKIMDomain(c)
+	if !ok {
+		return
+	}
+
+	entries, err := services.Mailer.DKIM.GetRotationHistory(c.Request.Context(), domainEntity.Name, queryInt(c, "limit", 100))
+	if err != nil {
+		respondMailerError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    entries,
+	})
+}
+
+// RotateDKIMKey starts a DKIM rotation; the new selector must be published before signing switches
+func RotateDKIMKey(c *gin.Context) {
+	domainEntity, ok := requireDKIMDomain(c)
+	if !ok {
+		return
+	}
+	userID, ok := currentUserID(c)
+	if !ok {
+		return
+	}
+
+	key, err := services.Mailer.DKIM.StartRotation(c.Request.Context(), domainEntity.Name, userID)
+	if err != nil {
+		respondMailerError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusAccepted, gin.H{
+		"success": true,
+		"data":    toDKIMKeyResponse(key),
+		"message": "Publish the TXT record, signing switches once it is visible in DNS",
+	})
+}
+
+// CheckDKIMKey checks whether a pending DKIM key is visible in DNS and activates it
+func CheckDKIMKey(c *gin.Context) {
+	domainEntity, ok := requireDKIMDomain(c)
+	if !ok {
+		return
+	}
+	userID, ok := currentUserID(c)
+	if !ok {
+		return
+	}
+
+	keys, err := services.Mailer.DKIM.ListKeys(c.Request.Context(), domainEntity.Name)
+	if err != nil {
+		respondMailerError(c, err)
+		return
+	}
+	found := false
+	for _, key := range keys {
+		found = found || key.ID == c.Param("keyId")
+	}
+	if !found {
+		c.JSON(http.StatusNotFound, gin.H{
+			"success": false,
+			"error":   "DKIM key not found",
+		})
+		return
+	}
+
+	key, err := services.Mailer.DKIM.CheckPropagation(c.Request.Context(), c.Param("keyId"), userID)
+	if err != nil {
+		respondMailerError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    toDKIMKeyResponse(key),
+	})
+}
+
+// ImportDKIMKey imports an already published key as the active DKIM key of a domain
+func ImportDKIMKey(c *gin.Context) {
+	domainEntity, ok := requireDKIMDomain(c)
+	if !ok {
+		return
+	}
+	userID, ok := currentUserID(c)
+	if !ok {
+		return
+	}
+
+	var req ImportDKIMKeyRequest
+	if !bindMailerJSON(c, &req) {
+		return
+	}
+
+	key, err := services.Mailer.DKIM.ImportKey(c.Request.Context(), domainEntity.Name, req.Selector, req.PrivateKey, userID)
+	if err != nil {
+		respondMailerError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"success": true,
+		"data":    toDKIMKeyResponse(key),
+	})
+}
+
+// requireDKIMDomain checks that DKIM is wired and loads the managed domain
+func requireDKIMDomain(c *gin.Context) (*models.Domain, bool) {
+	if !requireMailer(c) {
+		return nil, false
+	}
+	if services.Mailer.DKIM == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{
+			"success": false,
+			"error":   "Service unavailable",
+			"message": "DKIM key management is not configured",
+		})
+		return nil, false
+	}
+
+	domainEntity, err := services.NewDomainService(services.DB).GetDomainByID(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"success": false,
+			"error":   "Domain not found",
+		})
+		return nil, false
+	}
+	return domainEntity, true
+}
+
+func toDKIMKeyResponse(key *domain.DKIMKey) DKIMKeyResponse {
+	return DKIMKeyResponse{
+		ID:          key.ID,
+		Selector:    key.Selector,
+		Algorithm:   string(key.Algorithm),
+		KeyBits:     key.KeyBits,
+		State:       string(key.State),
+		RecordName:  key.RecordName(),
+		RecordValue: key.RecordValue(),
+		CreatedAt:   key.CreatedAt,
+		PublishedAt: key.PublishedAt,
+		VerifiedAt:  key.VerifiedAt,
+		ActivatedAt: key.ActivatedAt,
+		RetireAfter: key.RetireAfter,
+		RetiredAt:   key.RetiredAt,
+	}
+}
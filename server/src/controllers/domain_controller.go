@@ -240,35 +240,25 @@ func DeleteDomain(c *gin.Context) {
 	c.Status(http.StatusNoContent)
 }
 
-// VerifyDomain vérifie un domaine
+// VerifyDomain contrôle immédiatement la publication du jeton de vérification d'un domaine
 func VerifyDomain(c *gin.Context) {
-	domainID := c.Param("domainId")
-	var req struct {
-		Method string `json:"method" binding:"required"`
-		Value  string `json:"value" binding:"required"`
-	}
+	verificationService := newDomainVerificationService()
 
-	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{
-			"error": "Invalid request body",
-		})
+	verification, err := verificationService.CheckVerification(c.Request.Context(), c.Param("id"))
+	if err != nil {
+		respondDomainVerificationError(c, err)
 		return
 	}
 
-	domainService := services.NewDomainService(services.DB)
-
-	id := 0
-	fmt.Sscanf(domainID, "%d", &id)
-
-	if err := domainService.VerifyDomain(domainID, req.Method, req.Value); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"error": "Failed to verify domain",
-		})
-		return
+	message := "Domain verified successfully"
+	if !verification.IsVerified {
+		message = "Domain verification failed"
 	}
 
 	c.JSON(http.StatusOK, gin.H{
-		"message": "Domain verified successfully",
+		"success": verification.IsVerified,
+		"data":    verification,
+		"message": message,
 	})
 }
 
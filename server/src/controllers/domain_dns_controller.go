@@ -0,0 +1,209 @@
+package controllers
+
+import (
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/skygenesisenterprise/aether-mailer/server/src/models"
+	"github.com/skygenesisenterprise/aether-mailer/server/src/services"
+)
+
+// GetDomainDNSRecords retourne les enregistrements DNS recommandés pour un domaine géré
+func GetDomainDNSRecords(c *gin.Context) {
+	domainService := services.NewDomainService(services.DB)
+
+	domain, err := domainService.GetDomainByID(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"success": false,
+			"error":   "Domain not found",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    domainService.GenerateDNSRecords(domain, domainDNSRecordOptions(c, domainService, domain)),
+	})
+}
+
+// GetDomainDNSZone retourne les enregistrements recommandés sous forme d'extrait de zone BIND
+func GetDomainDNSZone(c *gin.Context) {
+	domainService := services.NewDomainService(services.DB)
+
+	domain, err := domainService.GetDomainByID(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"success": false,
+			"error":   "Domain not found",
+		})
+		return
+	}
+
+	records := domainService.GenerateDNSRecords(domain, domainDNSRecordOptions(c, domainService, domain))
+	c.Data(http.StatusOK, "text/plain; charset=utf-8", []byte(services.FormatBINDZone(domain, records)))
+}
+
+// CheckDomainDNSRecords compare le DNS publié d'un domaine aux enregistrements recommandés
+func CheckDomainDNSRecords(c *gin.Context) {
+	domainService := services.NewDomainService(services.DB)
+
+	domain, err := domainService.GetDomainByID(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"success": false,
+			"error":   "Domain not found",
+		})
+		return
+	}
+
+	records := domainService.GenerateDNSRecords(domain, domainDNSRecordOptions(c, domainService, domain))
+	checks := domainService.CheckDNSRecords(c.Request.Context(), records, net.DefaultResolver)
+
+	drift := 0
+	for _, check := range checks {
+		if check.Status != services.DNSRecordOK {
+			drift++
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data": gin.H{
+			"domain":  domain.Name,
+			"inSync":  drift == 0,
+			"drift":   drift,
+			"records": checks,
+		},
+	})
+}
+
+// ServeMTASTSPolicy sert /.well-known/mta-sts.txt pour l'hôte mta-sts.<domaine> d'un domaine géré actif
+func ServeMTASTSPolicy(c *gin.Context) {
+	host := strings.ToLower(c.Request.Host)
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	domainName := strings.TrimPrefix(strings.TrimSuffix(host, "."), "mta-sts.")
+	if domainName == host || domainName == "" {
+		c.String(http.StatusNotFound, "Not found")
+		return
+	}
+
+	domainService := services.NewDomainService(services.DB)
+	domain, err := domainService.GetActiveDomainByName(domainName)
+	if err != nil {
+		c.String(http.StatusNotFound, "Not found")
+		return
+	}
+
+	opts := services.DNSDefaults.ForDomain(domain.Settings)
+	c.Data(http.StatusOK, "text/plain; charset=utf-8", []byte(services.MTASTSPolicyText(opts)))
+}
+
+// UpdateDomainMTASTSModeRequest représente le mode MTA-STS demandé pour un domaine
+type UpdateDomainMTASTSModeRequest struct {
+	Mode string `json:"mode"` // enforce, testing ou none ; vide pour le mode de la configuration
+}
+
+// UpdateDomainMTASTSMode fixe le mode de la politique MTA-STS publiée pour un domaine géré
+func UpdateDomainMTASTSMode(c *gin.Context) {
+	var req UpdateDomainMTASTSModeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"error":   "Invalid request body",
+		})
+		return
+	}
+	if req.Mode != "" && !services.IsValidMTASTSMode(req.Mode) {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"error":   "Mode must be enforce, testing or none",
+		})
+		return
+	}
+
+	domainService := services.NewDomainService(services.DB)
+	domain, err := domainService.GetDomainByID(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"success": false,
+			"error":   "Domain not found",
+		})
+		return
+	}
+
+	settings, err := domainService.SetMTASTSMode(domain.ID, req.Mode)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"success": false,
+			"error":   "Failed to update MTA-STS mode",
+		})
+		return
+	}
+
+	// Le _mta-sts publié change avec la politique
+	opts := domainDNSRecordOptions(c, domainService, domain)
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data": gin.H{
+			"domain":   domain.Name,
+			"mode":     services.DNSDefaults.ForDomain(settings).MTASTSMode,
+			"policyId": services.MTASTSPolicyID(opts),
+		},
+	})
+}
+
+// dnsRecordOptions part des options lues dans la configuration au démarrage et ajoute
+// au SPF les adresses actives des pools d'IP sortants
+func dnsRecordOptions(c *gin.Context) services.DNSRecordOptions {
+	opts := services.DNSDefaults
+	if services.Mailer == nil || services.Mailer.IPPools == nil {
+		return opts
+	}
+
+	pools, err := services.Mailer.IPPools.ListPools(c.Request.Context())
+	if err != nil {
+		return opts
+	}
+	seen := make(map[string]bool)
+	addresses := []string{}
+	for _, addr := range opts.SPFAddresses {
+		seen[addr] = true
+		addresses = append(addresses, addr)
+	}
+	for _, pool := range pools {
+		for _, addr := range pool.Addresses {
+			if addr.IsActive && !seen[addr.IP] {
+				seen[addr.IP] = true
+				addresses = append(addresses, addr.IP)
+			}
+		}
+	}
+	opts.SPFAddresses = addresses
+	return opts
+}
+
+// domainDNSRecordOptions complète les options avec les paramètres et les clés DKIM
+// publiées du domaine
+func domainDNSRecordOptions(c *gin.Context, domainService *services.DomainService, domain *models.Domain) services.DNSRecordOptions {
+	opts := dnsRecordOptions(c)
+	if settings, err := domainService.GetDomainSettings(domain.ID); err == nil {
+		opts = opts.ForDomain(settings)
+	}
+	if services.Mailer == nil || services.Mailer.DKIM == nil {
+		return opts
+	}
+
+	keys, err := services.Mailer.DKIM.PublishedKeys(c.Request.Context(), domain.Name)
+	if err != nil {
+		return opts
+	}
+	for _, key := range keys {
+		opts.DKIMKeys = append(opts.DKIMKeys, services.DKIMRecordKey{Selector: key.Selector, Value: key.RecordValue()})
+	}
+	return opts
+}
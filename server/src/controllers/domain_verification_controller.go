@@ -0,0 +1,129 @@
+package controllers
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/skygenesisenterprise/aether-mailer/server/src/config"
+	"github.com/skygenesisenterprise/aether-mailer/server/src/models"
+	"github.com/skygenesisenterprise/aether-mailer/server/src/services"
+	"gorm.io/gorm"
+)
+
+// StartDomainVerificationRequest représente une demande de vérification de domaine
+type StartDomainVerificationRequest struct {
+	Method string `json:"method" binding:"required"` // dns, cname ou file
+}
+
+// StartDomainVerification émet un jeton et retourne l'enregistrement ou le fichier à publier
+func StartDomainVerification(c *gin.Context) {
+	var req StartDomainVerificationRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"error":   "Invalid request body",
+		})
+		return
+	}
+
+	verificationService := newDomainVerificationService()
+
+	verification, err := verificationService.StartVerification(c.Param("id"), req.Method)
+	if err != nil {
+		respondDomainVerificationError(c, err)
+		return
+	}
+
+	domain, err := services.NewDomainService(services.DB).GetDomainByID(verification.DomainID)
+	if err != nil {
+		respondDomainVerificationError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"success": true,
+		"data": gin.H{
+			"verification": verification,
+			"instructions": verificationService.Instructions(domain, verification),
+		},
+	})
+}
+
+// GetDomainVerification retourne l'état de vérification d'un domaine et l'historique des tentatives
+func GetDomainVerification(c *gin.Context) {
+	verificationService := newDomainVerificationService()
+
+	domain, err := services.NewDomainService(services.DB).GetDomainByID(c.Param("id"))
+	if err != nil {
+		respondDomainVerificationError(c, err)
+		return
+	}
+
+	verification, err := verificationService.GetVerification(domain.ID)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			err = services.ErrVerificationNotStarted
+		}
+		respondDomainVerificationError(c, err)
+		return
+	}
+
+	attempts, err := verificationService.GetAttempts(verification.ID, queryInt(c, "limit", 50))
+	if err != nil {
+		respondDomainVerificationError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data": gin.H{
+			"domain":       domain.Name,
+			"isVerified":   domain.IsVerified,
+			"verifiedAt":   domain.VerifiedAt,
+			"verification": verification,
+			"instructions": verificationService.Instructions(domain, verification),
+			"attempts":     attempts,
+		},
+	})
+}
+
+// newDomainVerificationService crée le service de vérification avec le résolveur système
+func newDomainVerificationService() *services.DomainVerificationService {
+	return services.NewDomainVerificationService(
+		services.DB,
+		net.DefaultResolver,
+		services.NewDomainVerificationHTTPClient(10*time.Second),
+		services.DomainVerificationOptionsFromConfig(config.LoadConfig()),
+	)
+}
+
+// respondDomainVerificationError traduit les erreurs de vérification en réponses HTTP
+func respondDomainVerificationError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		c.JSON(http.StatusNotFound, gin.H{
+			"success": false,
+			"error":   "Domain not found",
+		})
+	case errors.Is(err, services.ErrVerificationNotStarted):
+		c.JSON(http.StatusNotFound, gin.H{
+			"success": false,
+			"error":   "Verification not started",
+			"message": "Request a verification token first",
+		})
+	case errors.Is(err, services.ErrUnsupportedVerificationMethod):
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"error":   "Unsupported verification method",
+			"message": "Supported methods are " + models.DomainVerificationDNS + ", " + models.DomainVerificationCNAME + " and " + models.DomainVerificationFile,
+		})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"success": false,
+			"error":   "Domain verification failed",
+		})
+	}
+}
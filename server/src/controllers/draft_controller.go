@@ -0,0 +1,383 @@
+package controllers
+
+import (
+	"encoding/base64"
+	"net/http"
+	"net/mail"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/skygenesisenterprise/aether-mailer/package/golang/domain"
+	"github.com/skygenesisenterprise/aether-mailer/package/golang/service"
+	"github.com/skygenesisenterprise/aether-mailer/server/src/models"
+	"github.com/skygenesisenterprise/aether-mailer/server/src/services"
+)
+
+// ListMailDrafts lists the drafts of one of the user's accounts
+func ListMailDrafts(c *gin.Context) {
+	if !requireMailer(c) {
+		return
+	}
+	userID, ok := currentUserID(c)
+	if !ok {
+		return
+	}
+	accountID, ok := requireAccountQuery(c)
+	if !ok {
+		return
+	}
+
+	limit, offset := mailPage(queryInt(c, "limit", 50), queryInt(c, "offset", 0))
+	drafts, total, err := services.Mailer.Drafts.ListDrafts(c.Request.Context(), userID, accountID, limit, offset)
+	if err != nil {
+		respondMailerError(c, err)
+		return
+	}
+
+	list := models.EmailList{
+		AccountID:     accountID,
+		TotalEmails:   int64(total),
+		Position:      offset,
+		EmailsPerPage: limit,
+		Emails:        make([]*models.Email, 0, len(drafts)),
+		HasMore:       offset+len(drafts) < total,
+	}
+	for _, draft := range drafts {
+		list.Emails = append(list.Emails, toEmailModel(draft, false))
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    list,
+	})
+}
+
+// CreateMailDraft saves a new draft in the Drafts folder
+func CreateMailDraft(c *gin.Context) {
+	if !requireMailer(c) {
+		return
+	}
+	userID, ok := currentUserID(c)
+	if !ok {
+		return
+	}
+	var req models.CreateDraftRequest
+	if !bindMailerJSON(c, &req) {
+		return
+	}
+	attachments, ok := decodeSendAttachments(c, req.Attachments)
+	if !ok {
+		return
+	}
+
+	draft, err := services.Mailer.Drafts.CreateDraft(c.Request.Context(), userID, service.DraftRequest{
+		AccountID:   req.AccountID,
+		From:        fromEmailAddress(req.From),
+		To:          fromEmailAddresses(req.To),
+		Cc:          fromEmailAddresses(req.Cc),
+		Bcc:         fromEmailAddresses(req.Bcc),
+		Subject:     req.Subject,
+		BodyText:    optionalString(req.Body),
+		BodyHTML:    optionalString(req.BodyHTML),
+		Attachments: attachments,
+		InReplyTo:   req.InReplyTo,
+		References:  req.References,
+	})
+	respondDraft(c, http.StatusCreated, draft, err)
+}
+
+// GetMailDraft returns one of the user's drafts
+func GetMailDraft(c *gin.Context) {
+	if !requireMailer(c) {
+		return
+	}
+	userID, ok := currentUserID(c)
+	if !ok {
+		return
+	}
+
+	draft, err := services.Mailer.Drafts.GetDraft(c.Request.Context(), userID, c.Param("id"))
+	respondDraft(c, http.StatusOK, draft, err)
+}
+
+// UpdateMailDraft replaces the content of one of the user's drafts
+func UpdateMailDraft(c *gin.Context) {
+	if !requireMailer(c) {
+		return
+	}
+	userID, ok := currentUserID(c)
+	if !ok {
+		return
+	}
+	var req models.UpdateDraftRequest
+	if !bindMailerJSON(c, &req) {
+		return
+	}
+	attachments, ok := decodeSendAttachments(c, req.AddAttachments)
+	if !ok {
+		return
+	}
+
+	from := fromEmailAddress(req.From)
+	to, cc, bcc := fromEmailAddresses(req.To), fromEmailAddresses(req.Cc), fromEmailAddresses(req.Bcc)
+	draft, err := services.Mailer.Drafts.UpdateDraft(c.Request.Context(), userID, c.Param("id"), service.DraftUpdate{
+		From:              &from,
+		To:                &to,
+		Cc:                &cc,
+		Bcc:               &bcc,
+		Subject:           &req.Subject,
+		BodyText:          &req.Body,
+		BodyHTML:          &req.BodyHTML,
+		AddAttachments:    attachments,
+		RemoveAttachments: req.RemoveAttachmentIDs,
+		IfUnmodifiedSince: req.IfUnmodifiedSince,
+	})
+	respondDraft(c, http.StatusOK, draft, err)
+}
+
+// AutosaveMailDraft saves the fields of one of the user's drafts that
+// changed since the last save
+func AutosaveMailDraft(c *gin.Context) {
+	if !requireMailer(c) {
+		return
+	}
+	userID, ok := currentUserID(c)
+	if !ok {
+		return
+	}
+	var req models.AutosaveDraftRequest
+	if !bindMailerJSON(c, &req) {
+		return
+	}
+	update, ok := toDraftUpdate(c, &req)
+	if !ok {
+		return
+	}
+
+	draft, err := services.Mailer.Drafts.UpdateDraft(c.Request.Context(), userID, c.Param("id"), update)
+	respondDraft(c, http.StatusOK, draft, err)
+}
+
+// DeleteMailDraft discards one of the user's drafts
+func DeleteMailDraft(c *gin.Context) {
+	if !requireMailer(c) {
+		return
+	}
+	userID, ok := currentUserID(c)
+	if !ok {
+		return
+	}
+
+	if err := services.Mailer.Drafts.DeleteDraft(c.Request.Context(), userID, c.Param("id")); err != nil {
+		respondMailerError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "Draft deleted",
+	})
+}
+
+// SendMailDraft sends one of the user's drafts; the sent copy is filed in
+// the Sent folder
+func SendMailDraft(c *gin.Context) {
+	if !requireMailer(c) {
+		return
+	}
+	userID, ok := currentUserID(c)
+	if !ok {
+		return
+	}
+
+	sent, err := services.Mailer.Drafts.SendDraft(c.Request.Context(), userID, c.Param("id"))
+	if err != nil {
+		respondMailerError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, models.EmailResponse{
+		Success: true,
+		Data:    toEmailModel(sent, true),
+	})
+}
+
+// ReplyMailMessage starts a draft replying to the sender of a message
+func ReplyMailMessage(c *gin.Context) {
+	replyMailMessage(c, false)
+}
+
+// ReplyAllMailMessage starts a draft replying to the sender and the other
+// recipients of a message
+func ReplyAllMailMessage(c *gin.Context) {
+	replyMailMessage(c, true)
+}
+
+// ForwardMailMessage starts a draft forwarding a message with its attachments
+func ForwardMailMessage(c *gin.Context) {
+	if !requireMailer(c) {
+		return
+	}
+	userID, ok := currentUserID(c)
+	if !ok {
+		return
+	}
+	var req models.ForwardEmailRequest
+	if !bindMailerJSON(c, &req) {
+		return
+	}
+
+	draft, err := services.Mailer.Drafts.Forward(c.Request.Context(), userID, c.Param("id"), service.ForwardRequest{
+		To:       fromEmailAddresses(req.To),
+		Cc:       fromEmailAddresses(req.Cc),
+		Bcc:      fromEmailAddresses(req.Bcc),
+		BodyText: optionalString(req.Body),
+		BodyHTML: optionalString(req.BodyHTML),
+	})
+	respondDraft(c, http.StatusCreated, draft, err)
+}
+
+func replyMailMessage(c *gin.Context, all bool) {
+	if !requireMailer(c) {
+		return
+	}
+	userID, ok := currentUserID(c)
+	if !ok {
+		return
+	}
+	var req models.ReplyEmailRequest
+	if !bindMailerJSON(c, &req) {
+		return
+	}
+
+	draft, err := services.Mailer.Drafts.Reply(c.Request.Context(), userID, c.Param("id"), service.ReplyRequest{
+		All:      all,
+		BodyText: optionalString(req.Body),
+		BodyHTML: optionalString(req.BodyHTML),
+	})
+	respondDraft(c, http.StatusCreated, draft, err)
+}
+
+func respondDraft(c *gin.Context, status int, draft *domain.Message, err error) {
+	if err != nil {
+		respondMailerError(c, err)
+		return
+	}
+	c.JSON(status, models.EmailResponse{
+		Success: true,
+		Data:    toEmailModel(draft, true),
+	})
+}
+
+// toDraftUpdate keeps the draft fields an autosave left out
+func toDraftUpdate(c *gin.Context, req *models.AutosaveDraftRequest) (service.DraftUpdate, bool) {
+	attachments, ok := decodeSendAttachments(c, req.AddAttachments)
+	if !ok {
+		return service.DraftUpdate{}, false
+	}
+
+	update := service.DraftUpdate{
+		Subject:           req.Subject,
+		BodyText:          req.Body,
+		BodyHTML:          req.BodyHTML,
+		AddAttachments:    attachments,
+		RemoveAttachments: req.RemoveAttachmentIDs,
+		IfUnmodifiedSince: req.IfUnmodifiedSince,
+	}
+	for _, field := range []struct {
+		addresses *[]*models.EmailAddress
+		target    **[]string
+	}{
+		{req.To, &update.To},
+		{req.Cc, &update.Cc},
+		{req.Bcc, &update.Bcc},
+	} {
+		if field.addresses != nil {
+			addresses := fromEmailAddresses(*field.addresses)
+			*field.target = &addresses
+		}
+	}
+	if req.From != nil {
+		from := fromEmailAddress(req.From)
+		update.From = &from
+	}
+	return update, true
+}
+
+// decodeSendAttachments checks the base64 content of uploaded attachments.
+// The content is decoded while the service streams it to storage, so no
+// decoded copy is held in memory.
+func decodeSendAttachments(c *gin.Context, attachments []models.SendAttachment) ([]service.AttachmentRequest, bool) {
+	requests := make([]service.AttachmentRequest, 0, len(attachments))
+	for _, attachment := range attachments {
+		size, ok := base64DecodedSize(attachment.Content)
+		if !ok {
+			respondInvalidMailQuery(c, "attachment "+attachment.Filename+" is not valid base64")
+			return nil, false
+		}
+		contentType := attachment.MimeType
+		if contentType == "" {
+			contentType = "application/octet-stream"
+		}
+		requests = append(requests, service.AttachmentRequest{
+			Filename:    attachment.Filename,
+			ContentType: contentType,
+			Size:        size,
+			Reader:      base64.NewDecoder(base64.StdEncoding, strings.NewReader(attachment.Content)),
+		})
+	}
+	return requests, true
+}
+
+// base64DecodedSize validates padded standard base64, ignoring line breaks
+// as the decoder does, and returns the decoded size
+func base64DecodedSize(content string) (int64, bool) {
+	var length, padding int64
+	for i := 0; i < len(content); i++ {
+		switch ch := content[i]; {
+		case ch == '\r' || ch == '\n':
+			continue
+		case ch == '=':
+			padding++
+		case padding > 0:
+			// Padding only ends the content
+			return 0, false
+		case ch >= 'A' && ch <= 'Z', ch >= 'a' && ch <= 'z', ch >= '0' && ch <= '9', ch == '+', ch == '/':
+		default:
+			return 0, false
+		}
+		length++
+	}
+	if length%4 != 0 || padding > 2 {
+		return 0, false
+	}
+	return length/4*3 - padding, true
+}
+
+// fromEmailAddresses formats addresses with their display names
+func fromEmailAddresses(addresses []*models.EmailAddress) []string {
+	result := make([]string, 0, len(addresses))
+	for _, address := range addresses {
+		if address == nil || address.Email == "" {
+			continue
+		}
+		if address.Name == "" {
+			result = append(result, address.Email)
+			continue
+		}
+		result = append(result, (&mail.Address{Name: address.Name, Address: address.Email}).String())
+	}
+	return result
+}
+
+// fromEmailAddress formats a single address, or returns "" when it is unset
+func fromEmailAddress(address *models.EmailAddress) string {
+	if addresses := fromEmailAddresses([]*models.EmailAddress{address}); len(addresses) > 0 {
+		return addresses[0]
+	}
+	return ""
+}
+
+func optionalString(value string) *string {
+	if value == "" {
+		return nil
+	}
+	return &value
+}
@@ -0,0 +1,356 @@
+package controllers
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/skygenesisenterprise/aether-mailer/package/golang/domain"
+	"github.com/skygenesisenterprise/aether-mailer/package/golang/service"
+	"github.com/skygenesisenterprise/aether-mailer/server/src/models"
+	"github.com/skygenesisenterprise/aether-mailer/server/src/services"
+)
+
+// ListMailIdentities lists the identities of one of the user's accounts
+func ListMailIdentities(c *gin.Context) {
+	if !requireMailer(c) {
+		return
+	}
+	userID, ok := currentUserID(c)
+	if !ok {
+		return
+	}
+	accountID, ok := requireAccountQuery(c)
+	if !ok {
+		return
+	}
+
+	identities, err := services.Mailer.Identities.ListIdentities(c.Request.Context(), userID, accountID)
+	if err != nil {
+		respondMailerError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data": gin.H{
+			"account_id": accountID,
+			"identities": toIdentityModels(identities),
+		},
+	})
+}
+
+// CreateMailIdentity adds an address to one of the user's accounts. Aliases
+// outside the domains the user administers wait for review.
+func CreateMailIdentity(c *gin.Context) {
+	if !requireMailer(c) {
+		return
+	}
+	userID, ok := currentUserID(c)
+	if !ok {
+		return
+	}
+	var req models.CreateIdentityRequest
+	if !bindMailerJSON(c, &req) {
+		return
+	}
+
+	identity, err := services.Mailer.Identities.CreateIdentity(c.Request.Context(), userID, service.IdentityRequest{
+		AccountID:     req.AccountID,
+		Email:         req.Email,
+		Name:          req.Name,
+		ReplyTo:       req.ReplyTo,
+		SignatureText: req.SignatureText,
+		SignatureHTML: req.SignatureHTML,
+		IsDefault:     req.IsDefault,
+	})
+	respondIdentity(c, http.StatusCreated, identity, err)
+}
+
+// GetMailIdentity returns an identity of one of the user's accounts
+func GetMailIdentity(c *gin.Context) {
+	if !requireMailer(c) {
+		return
+	}
+	userID, ok := currentUserID(c)
+	if !ok {
+		return
+	}
+
+	identity, err := services.Mailer.Identities.GetIdentity(c.Request.Context(), userID, c.Param("id"))
+	respondIdentity(c, http.StatusOK, identity, err)
+}
+
+// UpdateMailIdentity changes the name, reply-to address, signature or
+// default flag of an identity of one of the user's accounts
+func UpdateMailIdentity(c *gin.Context) {
+	if !requireMailer(c) {
+		return
+	}
+	userID, ok := currentUserID(c)
+	if !ok {
+		return
+	}
+	var req models.UpdateIdentityRequest
+	if !bindMailerJSON(c, &req) {
+		return
+	}
+
+	identity, err := services.Mailer.Identities.UpdateIdentity(c.Request.Context(), userID, c.Param("id"), service.IdentityUpdate{
+		Name:          req.Name,
+		ReplyTo:       req.ReplyTo,
+		SignatureText: req.SignatureText,
+		SignatureHTML: req.SignatureHTML,
+		IsDefault:     req.IsDefault,
+	})
+	respondIdentity(c, http.StatusOK, identity, err)
+}
+
+// DeleteMailIdentity removes an identity of one of the user's accounts
+func DeleteMailIdentity(c *gin.Context) {
+	if !requireMailer(c) {
+		return
+	}
+	userID, ok := currentUserID(c)
+	if !ok {
+		return
+	}
+
+	if err := services.Mailer.Identities.DeleteIdentity(c.Request.Context(), userID, c.Param("id")); err != nil {
+		respondMailerError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "Identity deleted",
+	})
+}
+
+// ListPendingMailIdentities lists the identities waiting for review in a
+// domain the user administers
+func ListPendingMailIdentities(c *gin.Context) {
+	if !requireMailer(c) {
+		return
+	}
+	userID, ok := currentUserID(c)
+	if !ok {
+		return
+	}
+	domainID := c.Query("domain_id")
+	if domainID == "" {
+		respondInvalidMailQuery(c, "domain_id is required")
+		return
+	}
+
+	limit, offset := mailPage(queryInt(c, "limit", 50), queryInt(c, "offset", 0))
+	identities, total, err := services.Mailer.Identities.ListPendingIdentities(c.Request.Context(), userID, domainID, limit, offset)
+	if err != nil {
+		respondMailerError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data": gin.H{
+			"domain_id":  domainID,
+			"total":      total,
+			"position":   offset,
+			"per_page":   limit,
+			"identities": toIdentityModels(identities),
+			"has_more":   offset+len(identities) < total,
+		},
+	})
+}
+
+// ReviewMailIdentity approves or rejects an identity in a domain the user
+// administers
+func ReviewMailIdentity(c *gin.Context) {
+	if !requireMailer(c) {
+		return
+	}
+	userID, ok := currentUserID(c)
+	if !ok {
+		return
+	}
+	var req models.ReviewIdentityRequest
+	if !bindMailerJSON(c, &req) {
+		return
+	}
+
+	identity, err := services.Mailer.Identities.ReviewIdentity(c.Request.Context(), userID, c.Param("id"), *req.Approve)
+	respondIdentity(c, http.StatusOK, identity, err)
+}
+
+// ListMailDelegations lists who may send for a mailbox the user owns or
+// administers
+func ListMailDelegations(c *gin.Context) {
+	if !requireMailer(c) {
+		return
+	}
+	userID, ok := currentUserID(c)
+	if !ok {
+		return
+	}
+	accountID, ok := requireAccountQuery(c)
+	if !ok {
+		return
+	}
+
+	delegations, err := services.Mailer.Identities.ListDelegations(c.Request.Context(), userID, accountID)
+	if err != nil {
+		respondMailerError(c, err)
+		return
+	}
+	result := make([]*models.SenderDelegation, 0, len(delegations))
+	for _, delegation := range delegations {
+		result = append(result, toSenderDelegationModel(delegation))
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data": gin.H{
+			"account_id":  accountID,
+			"delegations": result,
+		},
+	})
+}
+
+// GrantMailDelegation lets another account send as, or on behalf of, a
+// mailbox the user owns or administers
+func GrantMailDelegation(c *gin.Context) {
+	if !requireMailer(c) {
+		return
+	}
+	userID, ok := currentUserID(c)
+	if !ok {
+		return
+	}
+	var req models.GrantDelegationRequest
+	if !bindMailerJSON(c, &req) {
+		return
+	}
+
+	delegation, err := services.Mailer.Identities.GrantDelegation(c.Request.Context(), userID, service.DelegationRequest{
+		AccountID:     req.AccountID,
+		DelegateEmail: req.DelegateEmail,
+		Permission:    domain.DelegationPermission(strings.ToUpper(req.Permission)),
+	})
+	if err != nil {
+		respondMailerError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    toSenderDelegationModel(delegation),
+	})
+}
+
+// RevokeMailDelegation removes a delegation of a mailbox the user owns or
+// administers, or one made to the user
+func RevokeMailDelegation(c *gin.Context) {
+	if !requireMailer(c) {
+		return
+	}
+	userID, ok := currentUserID(c)
+	if !ok {
+		return
+	}
+
+	if err := services.Mailer.Identities.RevokeDelegation(c.Request.Context(), userID, c.Param("id")); err != nil {
+		respondMailerError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "Delegation revoked",
+	})
+}
+
+// ListMailSenders lists the From addresses one of the user's accounts may
+// send as, its default first
+func ListMailSenders(c *gin.Context) {
+	if !requireMailer(c) {
+		return
+	}
+	userID, ok := currentUserID(c)
+	if !ok {
+		return
+	}
+	accountID, ok := requireAccountQuery(c)
+	if !ok {
+		return
+	}
+
+	profiles, err := services.Mailer.Identities.ListSenders(c.Request.Context(), userID, accountID)
+	if err != nil {
+		respondMailerError(c, err)
+		return
+	}
+	senders := make([]*models.Sender, 0, len(profiles))
+	for _, profile := range profiles {
+		sender := &models.Sender{
+			From:          toEmailAddress(profile.From),
+			ReplyTo:       profile.ReplyTo,
+			SignatureText: profile.SignatureText,
+			SignatureHTML: profile.SignatureHTML,
+		}
+		if profile.Sender != "" {
+			sender.Sender = toEmailAddress(profile.Sender)
+		}
+		senders = append(senders, sender)
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data": gin.H{
+			"account_id": accountID,
+			"senders":    senders,
+		},
+	})
+}
+
+func respondIdentity(c *gin.Context, status int, identity *domain.Identity, err error) {
+	if err != nil {
+		respondMailerError(c, err)
+		return
+	}
+	c.JSON(status, gin.H{
+		"success": true,
+		"data":    toIdentityModel(identity),
+	})
+}
+
+func toIdentityModels(identities []*domain.Identity) []*models.Identity {
+	result := make([]*models.Identity, 0, len(identities))
+	for _, identity := range identities {
+		result = append(result, toIdentityModel(identity))
+	}
+	return result
+}
+
+func toIdentityModel(identity *domain.Identity) *models.Identity {
+	return &models.Identity{
+		ID:            identity.ID,
+		AccountID:     identity.AccountID,
+		DomainID:      identity.DomainID,
+		Email:         identity.Email,
+		Name:          identity.Name,
+		ReplyTo:       identity.ReplyTo,
+		SignatureText: identity.SignatureText,
+		SignatureHTML: identity.SignatureHTML,
+		IsDefault:     identity.IsDefault,
+		Status:        strings.ToLower(string(identity.Status)),
+		ReviewedBy:    identity.ReviewedBy,
+		ReviewedAt:    identity.ReviewedAt,
+		CreatedAt:     identity.CreatedAt,
+		UpdatedAt:     identity.UpdatedAt,
+	}
+}
+
+func toSenderDelegationModel(delegation *domain.SenderDelegation) *models.SenderDelegation {
+	return &models.SenderDelegation{
+		ID:                delegation.ID,
+		AccountID:         delegation.AccountID,
+		DelegateAccountID: delegation.DelegateAccountID,
+		Permission:        strings.ToLower(string(delegation.Permission)),
+		GrantedBy:         delegation.GrantedBy,
+		CreatedAt:         delegation.CreatedAt,
+		UpdatedAt:         delegation.UpdatedAt,
+	}
+}
@@ -0,0 +1,115 @@
+package controllers
+
+import (
+	"bytes"
+	"io"
+	"log"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/skygenesisenterprise/aether-mailer/package/golang/service"
+	"github.com/skygenesisenterprise/aether-mailer/server/src/models"
+	"github.com/skygenesisenterprise/aether-mailer/server/src/services"
+	"github.com/skygenesisenterprise/aether-mailer/server/src/utils"
+)
+
+// maxInboundMessageSize bounds the raw message read from the request; the
+// message service applies the configured SMTP size limit
+const maxInboundMessageSize = 64 << 20
+
+// AdminReceiveMailMessage hands a raw RFC 5322 message received by the MTA
+// to message intake for the local account of the recipient query parameter.
+// A message held for review answers 202 with its quarantine entry.
+func AdminReceiveMailMessage(c *gin.Context) {
+	if !requireMailer(c) {
+		return
+	}
+	recipient := c.Query("recipient")
+	if recipient == "" {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"error":   "Invalid request",
+			"message": "recipient is required",
+		})
+		return
+	}
+
+	ctx := c.Request.Context()
+	account, err := services.Mailer.Messages.LookupRecipient(ctx, recipient)
+	if err != nil {
+		respondMailerError(c, err)
+		return
+	}
+
+	raw, err := io.ReadAll(io.LimitReader(c.Request.Body, maxInboundMessageSize+1))
+	if err == nil && len(raw) > maxInboundMessageSize {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{
+			"success": false,
+			"error":   "Message too large",
+			"message": "Message size exceeds limit",
+		})
+		return
+	}
+	var email *models.Email
+	if err == nil {
+		email, err = utils.ParseEmailToBlobs(ctx, bytes.NewReader(raw), services.Mailer.Blobs, account.DomainID)
+	}
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"error":   "Invalid message",
+			"message": err.Error(),
+		})
+		return
+	}
+
+	req := service.ReceiveMessageRequest{
+		AccountID:   account.ID,
+		From:        fromEmailAddress(email.From),
+		To:          fromEmailAddresses(email.To),
+		Cc:          fromEmailAddresses(email.Cc),
+		Subject:     email.Subject,
+		BodyText:    optionalString(email.Body),
+		BodyHTML:    optionalString(email.BodyHTML),
+		Headers:     email.Headers,
+		Attachments: make([]service.AttachmentRequest, 0, len(email.Attachments)),
+		Raw:         raw,
+	}
+	for _, attachment := range email.Attachments {
+		req.Attachments = append(req.Attachments, service.AttachmentRequest{
+			Filename:    attachment.Filename,
+			ContentType: attachment.MimeType,
+			Size:        attachment.Size,
+			BlobID:      attachment.BlobID,
+		})
+	}
+
+	result, err := services.Mailer.Messages.ReceiveMessage(ctx, req)
+
+	// The stored message holds its own references; drop those of the parse
+	for _, attachment := range email.Attachments {
+		if err := services.Mailer.Blobs.Release(ctx, attachment.BlobID); err != nil {
+			log.Printf("inbound message: releasing blob %s: %v", attachment.BlobID, err)
+		}
+	}
+
+	if err != nil {
+		respondMailerError(c, err)
+		return
+	}
+
+	if result.Quarantine != nil {
+		c.JSON(http.StatusAccepted, gin.H{
+			"success": true,
+			"data": gin.H{
+				"quarantine_id": result.Quarantine.ID,
+				"reason":        result.Quarantine.Reason,
+			},
+		})
+		return
+	}
+	c.JSON(http.StatusCreated, gin.H{
+		"success": true,
+		"data":    toEmailModel(result.Message, false),
+	})
+}
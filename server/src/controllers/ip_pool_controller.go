@@ -0,0 +1,399 @@
+package controllers
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/skygenesisenterprise/aether-mailer/package/golang/domain"
+	"github.com/skygenesisenterprise/aether-mailer/package/golang/service"
+	"github.com/skygenesisenterprise/aether-mailer/server/src/services"
+)
+
+// IPPoolRequest represents a request to create an IP pool
+type IPPoolRequest struct {
+	Name        string `json:"name" binding:"required"`
+	Description string `json:"description"`
+	IsDefault   bool   `json:"is_default"`
+}
+
+// UpdateIPPoolRequest represents a request to update an IP pool
+type UpdateIPPoolRequest struct {
+	Name        *string `json:"name"`
+	Description *string `json:"description"`
+	IsDefault   *bool   `json:"is_default"`
+}
+
+// PoolAddressRequest represents a request to add a source address to a pool
+type PoolAddressRequest struct {
+	IP       string `json:"ip" binding:"required"`
+	HeloName string `json:"helo_name" binding:"required"`
+	Warmup   bool   `json:"warmup"`
+}
+
+// UpdatePoolAddressRequest represents a request to update a pool address
+type UpdatePoolAddressRequest struct {
+	IsActive      *bool `json:"is_active"`
+	RestartWarmup bool  `json:"restart_warmup"`
+}
+
+// PoolAssignmentRequest represents a request to assign a sender to a pool
+type PoolAssignmentRequest struct {
+	Scope string `json:"scope" binding:"required"`
+	Key   string `json:"key" binding:"required"`
+}
+
+// IPPoolResponse represents an IP pool
+type IPPoolResponse struct {
+	ID          string                `json:"id"`
+	Name        string                `json:"name"`
+	Description string                `json:"description"`
+	IsDefault   bool                  `json:"is_default"`
+	Addresses   []PoolAddressResponse `json:"addresses"`
+	CreatedAt   time.Time             `json:"created_at"`
+	UpdatedAt   time.Time             `json:"updated_at"`
+}
+
+// PoolAddressResponse represents a pool source address
+type PoolAddressResponse struct {
+	IP              string     `json:"ip"`
+	HeloName        string     `json:"helo_name"`
+	IsActive        bool       `json:"is_active"`
+	WarmupStartedAt *time.Time `json:"warmup_started_at"`
+}
+
+// PoolAssignmentResponse represents a pool assignment
+type PoolAssignmentResponse struct {
+	Scope     string    `json:"scope"`
+	Key       string    `json:"key"`
+	PoolID    string    `json:"pool_id"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// PoolAddressUsageResponse represents today's volume of a pool address
+type PoolAddressUsageResponse struct {
+	IP         string `json:"ip"`
+	HeloName   string `json:"helo_name"`
+	IsActive   bool   `json:"is_active"`
+	WarmupDay  int    `json:"warmup_day"`
+	DailyLimit int64  `json:"daily_limit"`
+	SentToday  int64  `json:"sent_today"`
+}
+
+// ListIPPools lists the outbound IP pools
+func ListIPPools(c *gin.Context) {
+	if !requireMailer(c) {
+		return
+	}
+
+	pools, err := services.Mailer.IPPools.ListPools(c.Request.Context())
+	if err != nil {
+		respondMailerError(c, err)
+		return
+	}
+
+	data := make([]IPPoolResponse, 0, len(pools))
+	for _, pool := range pools {
+		data = append(data, toIPPoolResponse(pool))
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    data,
+	})
+}
+
+// GetIPPool returns an IP pool with its addresses
+func GetIPPool(c *gin.Context) {
+	if !requireMailer(c) {
+		return
+	}
+
+	pool, err := services.Mailer.IPPools.GetPool(c.Request.Context(), c.Param("id"))
+	if err != nil {
+		respondMailerError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    toIPPoolResponse(pool),
+	})
+}
+
+// CreateIPPool creates an empty IP pool
+func CreateIPPool(c *gin.Context) {
+	if !requireMailer(c) {
+		return
+	}
+
+	var req IPPoolRequest
+	if !bindMailerJSON(c, &req) {
+		return
+	}
+
+	pool, err := services.Mailer.IPPools.CreatePool(c.Request.Context(), service.CreateIPPoolRequest{
+		Name:        req.Name,
+		Description: req.Description,
+		IsDefault:   req.IsDefault,
+	})
+	if err != nil {
+		respondMailerError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"success": true,
+		"data":    toIPPoolResponse(pool),
+	})
+}
+
+// UpdateIPPool updates an IP pool
+func UpdateIPPool(c *gin.Context) {
+	if !requireMailer(c) {
+		return
+	}
+
+	var req UpdateIPPoolRequest
+	if !bindMailerJSON(c, &req) {
+		return
+	}
+
+	pool, err := services.Mailer.IPPools.UpdatePool(c.Request.Context(), c.Param("id"), service.UpdateIPPoolRequest{
+		Name:        req.Name,
+		Description: req.Description,
+		IsDefault:   req.IsDefault,
+	})
+	if err != nil {
+		respondMailerError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    toIPPoolResponse(pool),
+	})
+}
+
+// DeleteIPPool deletes an IP pool and its assignments
+func DeleteIPPool(c *gin.Context) {
+	if !requireMailer(c) {
+		return
+	}
+
+	if err := services.Mailer.IPPools.DeletePool(c.Request.Context(), c.Param("id")); err != nil {
+		respondMailerError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "IP pool deleted",
+	})
+}
+
+// GetIPPoolUsage returns today's volume and warm-up cap of every pool address
+func GetIPPoolUsage(c *gin.Context) {
+	if !requireMailer(c) {
+		return
+	}
+
+	usage, err := services.Mailer.IPPools.GetUsage(c.Request.Context(), c.Param("id"))
+	if err != nil {
+		respondMailerError(c, err)
+		return
+	}
+
+	data := make([]PoolAddressUsageResponse, 0, len(usage))
+	for _, u := range usage {
+		data = append(data, PoolAddressUsageResponse{
+			IP:         u.IP,
+			HeloName:   u.HeloName,
+			IsActive:   u.IsActive,
+			WarmupDay:  u.WarmupDay,
+			DailyLimit: u.DailyLimit,
+			SentToday:  u.SentToday,
+		})
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    data,
+	})
+}
+
+// AddIPPoolAddress adds a source address to an IP pool
+func AddIPPoolAddress(c *gin.Context) {
+	if !requireMailer(c) {
+		return
+	}
+
+	var req PoolAddressRequest
+	if !bindMailerJSON(c, &req) {
+		return
+	}
+
+	pool, err := services.Mailer.IPPools.AddAddress(c.Request.Context(), c.Param("id"), service.AddPoolAddressRequest{
+		IP:       req.IP,
+		HeloName: req.HeloName,
+		Warmup:   req.Warmup,
+	})
+	if err != nil {
+		respondMailerError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"success": true,
+		"data":    toIPPoolResponse(pool),
+	})
+}
+
+// UpdateIPPoolAddress enables or disables a pool address or restarts its warm-up
+func UpdateIPPoolAddress(c *gin.Context) {
+	if !requireMailer(c) {
+		return
+	}
+
+	var req UpdatePoolAddressRequest
+	if !bindMailerJSON(c, &req) {
+		return
+	}
+
+	ctx := c.Request.Context()
+	poolID, ip := c.Param("id"), c.Param("ip")
+
+	pool, err := services.Mailer.IPPools.GetPool(ctx, poolID)
+	if req.IsActive != nil && err == nil {
+		pool, err = services.Mailer.IPPools.SetAddressActive(ctx, poolID, ip, *req.IsActive)
+	}
+	if req.RestartWarmup && err == nil {
+		pool, err = services.Mailer.IPPools.RestartWarmup(ctx, poolID, ip)
+	}
+	if err != nil {
+		respondMailerError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    toIPPoolResponse(pool),
+	})
+}
+
+// RemoveIPPoolAddress removes a source address from an IP pool
+func RemoveIPPoolAddress(c *gin.Context) {
+	if !requireMailer(c) {
+		return
+	}
+
+	pool, err := services.Mailer.IPPools.RemoveAddress(c.Request.Context(), c.Param("id"), c.Param("ip"))
+	if err != nil {
+		respondMailerError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    toIPPoolResponse(pool),
+	})
+}
+
+// ListIPPoolAssignments lists the tenants, domains and streams assigned to a pool
+func ListIPPoolAssignments(c *gin.Context) {
+	if !requireMailer(c) {
+		return
+	}
+
+	assignments, err := services.Mailer.IPPools.ListAssignments(c.Request.Context(), c.Param("id"))
+	if err != nil {
+		respondMailerError(c, err)
+		return
+	}
+
+	data := make([]PoolAssignmentResponse, 0, len(assignments))
+	for _, assignment := range assignments {
+		data = append(data, toPoolAssignmentResponse(assignment))
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    data,
+	})
+}
+
+// AssignIPPool assigns a tenant, sender domain or message stream to a pool
+func AssignIPPool(c *gin.Context) {
+	if !requireMailer(c) {
+		return
+	}
+
+	var req PoolAssignmentRequest
+	if !bindMailerJSON(c, &req) {
+		return
+	}
+
+	assignment, err := services.Mailer.IPPools.Assign(c.Request.Context(), poolScope(req.Scope), req.Key, c.Param("id"))
+	if err != nil {
+		respondMailerError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    toPoolAssignmentResponse(assignment),
+	})
+}
+
+// UnassignIPPool removes a pool assignment so the sender uses the default pool
+func UnassignIPPool(c *gin.Context) {
+	if !requireMailer(c) {
+		return
+	}
+
+	if err := services.Mailer.IPPools.Unassign(c.Request.Context(), poolScope(c.Param("scope")), c.Param("key")); err != nil {
+		respondMailerError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "IP pool assignment removed",
+	})
+}
+
+func poolScope(value string) domain.PoolScope {
+	return domain.PoolScope(strings.ToUpper(value))
+}
+
+func toIPPoolResponse(pool *domain.IPPool) IPPoolResponse {
+	addresses := make([]PoolAddressResponse, 0, len(pool.Addresses))
+	for _, addr := range pool.Addresses {
+		addresses = append(addresses, PoolAddressResponse{
+			IP:              addr.IP,
+			HeloName:        addr.HeloName,
+			IsActive:        addr.IsActive,
+			WarmupStartedAt: addr.WarmupStartedAt,
+		})
+	}
+
+	return IPPoolResponse{
+		ID:          pool.ID,
+		Name:        pool.Name,
+		Description: pool.Description,
+		IsDefault:   pool.IsDefault,
+		Addresses:   addresses,
+		CreatedAt:   pool.CreatedAt,
+		UpdatedAt:   pool.UpdatedAt,
+	}
+}
+
+func toPoolAssignmentResponse(assignment *domain.PoolAssignment) PoolAssignmentResponse {
+	return PoolAssignmentResponse{
+		Scope:     string(assignment.Scope),
+		Key:       assignment.Key,
+		PoolID:    assignment.PoolID,
+		CreatedAt: assignment.CreatedAt,
+	}
+}
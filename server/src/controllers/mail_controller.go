@@ -0,0 +1,621 @@
+package controllers
+
+import (
+	"net/http"
+	"net/mail"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gin-gonic/gin"
+	"github.com/skygenesisenterprise/aether-mailer/package/golang/domain"
+	"github.com/skygenesisenterprise/aether-mailer/package/golang/repository"
+	"github.com/skygenesisenterprise/aether-mailer/package/golang/service"
+	"github.com/skygenesisenterprise/aether-mailer/server/src/models"
+	"github.com/skygenesisenterprise/aether-mailer/server/src/services"
+)
+
+// previewLength is the number of characters of the text body in listings
+const previewLength = 200
+
+// ListMailFolders lists the folders of one of the user's accounts with their counts
+func ListMailFolders(c *gin.Context) {
+	if !requireMailer(c) {
+		return
+	}
+	userID, ok := currentUserID(c)
+	if !ok {
+		return
+	}
+	accountID, ok := requireAccountQuery(c)
+	if !ok {
+		return
+	}
+
+	statuses, err := services.Mailer.Mailbox.ListFolders(c.Request.Context(), userID, accountID)
+	if err != nil {
+		respondMailerError(c, err)
+		return
+	}
+
+	folders := make([]*models.Folder, 0, len(statuses))
+	for _, status := range statuses {
+		folders = append(folders, toFolderModel(status))
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data": models.FolderList{
+			AccountID: accountID,
+			Folders:   folders,
+			Total:     len(folders),
+		},
+	})
+}
+
+// CreateMailFolder creates a folder in one of the user's accounts
+func CreateMailFolder(c *gin.Context) {
+	if !requireMailer(c) {
+		return
+	}
+	userID, ok := currentUserID(c)
+	if !ok {
+		return
+	}
+	var req models.CreateFolderRequest
+	if !bindMailerJSON(c, &req) {
+		return
+	}
+
+	folder, err := services.Mailer.Mailbox.CreateFolder(c.Request.Context(), userID, service.CreateFolderRequest{
+		AccountID: req.AccountID,
+		Name:      req.Name,
+		ParentID:  req.ParentID,
+		Type:      domain.FolderType(strings.ToUpper(req.FolderType)),
+		Subscribe: req.Subscribe,
+	})
+	if err != nil {
+		respondMailerError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"success": true,
+		"data":    toFolderModel(&service.FolderStatus{Folder: folder}),
+	})
+}
+
+// RenameMailFolder renames a custom folder and moves its subfolders with it
+func RenameMailFolder(c *gin.Context) {
+	if !requireMailer(c) {
+		return
+	}
+	userID, ok := currentUserID(c)
+	if !ok {
+		return
+	}
+	var req models.RenameFolderRequest
+	if !bindMailerJSON(c, &req) {
+		return
+	}
+
+	folder, err := services.Mailer.Mailbox.RenameFolder(c.Request.Context(), userID, req.AccountID, req.MailboxID, req.Name)
+	if err != nil {
+		respondMailerError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    toFolderModel(&service.FolderStatus{Folder: folder}),
+	})
+}
+
+// SubscribeMailFolder subscribes to a folder or unsubscribes from it
+func SubscribeMailFolder(c *gin.Context) {
+	if !requireMailer(c) {
+		return
+	}
+	userID, ok := currentUserID(c)
+	if !ok {
+		return
+	}
+	var req models.SubscribeFolderRequest
+	if !bindMailerJSON(c, &req) {
+		return
+	}
+
+	folder, err := services.Mailer.Mailbox.SetSubscribed(c.Request.Context(), userID, req.AccountID, req.MailboxID, req.Subscribe)
+	if err != nil {
+		respondMailerError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    toFolderModel(&service.FolderStatus{Folder: folder}),
+	})
+}
+
+// EmptyMailFolder removes every message of a folder
+func EmptyMailFolder(c *gin.Context) {
+	if !requireMailer(c) {
+		return
+	}
+	userID, ok := currentUserID(c)
+	if !ok {
+		return
+	}
+	var req models.EmptyFolderRequest
+	if !bindMailerJSON(c, &req) {
+		return
+	}
+
+	removed, err := services.Mailer.Mailbox.EmptyFolder(c.Request.Context(), userID, req.AccountID, req.MailboxID)
+	if err != nil {
+		respondMailerError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "Folder emptied",
+		"data":    gin.H{"removed": removed},
+	})
+}
+
+// DeleteMailFolder deletes a custom folder with its subfolders
+func DeleteMailFolder(c *gin.Context) {
+	if !requireMailer(c) {
+		return
+	}
+	userID, ok := currentUserID(c)
+	if !ok {
+		return
+	}
+	var req models.DeleteFolderRequest
+	if !bindMailerJSON(c, &req) {
+		return
+	}
+
+	err := services.Mailer.Mailbox.DeleteFolder(c.Request.Context(), userID, req.AccountID, req.MailboxID, req.MoveToTrash)
+	if err != nil {
+		respondMailerError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "Folder deleted",
+	})
+}
+
+// ListMailMessages lists the messages of one of the user's accounts, filtered
+// by query parameters
+func ListMailMessages(c *gin.Context) {
+	if !requireMailer(c) {
+		return
+	}
+	userID, ok := currentUserID(c)
+	if !ok {
+		return
+	}
+	accountID, ok := requireAccountQuery(c)
+	if !ok {
+		return
+	}
+
+	filter, err := messageFilterFromQuery(c)
+	if err != nil {
+		respondInvalidMailQuery(c, err.Error())
+		return
+	}
+	listMailMessages(c, userID, accountID, filter)
+}
+
+// QueryMailMessages lists the messages of one of the user's accounts matching
+// an EmailQuery
+func QueryMailMessages(c *gin.Context) {
+	if !requireMailer(c) {
+		return
+	}
+	userID, ok := currentUserID(c)
+	if !ok {
+		return
+	}
+	var query models.EmailQuery
+	if !bindMailerJSON(c, &query) {
+		return
+	}
+	if query.AccountID == "" {
+		respondInvalidMailQuery(c, "account_id is required")
+		return
+	}
+
+	filter, message := messageFilterFromEmailQuery(&query)
+	if message != "" {
+		respondInvalidMailQuery(c, message)
+		return
+	}
+	listMailMessages(c, userID, query.AccountID, filter)
+}
+
+// GetMailMessage returns one of the user's messages with its body and attachments
+func GetMailMessage(c *gin.Context) {
+	if !requireMailer(c) {
+		return
+	}
+	userID, ok := currentUserID(c)
+	if !ok {
+		return
+	}
+
+	message, err := services.Mailer.Mailbox.GetMessage(c.Request.Context(), userID, c.Param("id"))
+	if err != nil {
+		respondMailerError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    toEmailModel(message, true),
+	})
+}
+
+// ApplyMailAction marks, moves, archives or deletes messages in bulk
+func ApplyMailAction(c *gin.Context) {
+	if !requireMailer(c) {
+		return
+	}
+	userID, ok := currentUserID(c)
+	if !ok {
+		return
+	}
+	var req models.EmailActionRequest
+	if !bindMailerJSON(c, &req) {
+		return
+	}
+
+	result, err := services.Mailer.Mailbox.ApplyAction(c.Request.Context(), userID, req.AccountID, req.EmailIDs,
+		service.MessageAction(req.Operation), req.MailboxID)
+	if err != nil {
+		respondMailerError(c, err)
+		return
+	}
+	respondMailActionResult(c, result)
+}
+
+// MoveMailMessages moves messages to another folder of the same account
+func MoveMailMessages(c *gin.Context) {
+	if !requireMailer(c) {
+		return
+	}
+	userID, ok := currentUserID(c)
+	if !ok {
+		return
+	}
+	var req models.MoveEmailsRequest
+	if !bindMailerJSON(c, &req) {
+		return
+	}
+
+	result, err := services.Mailer.Mailbox.ApplyAction(c.Request.Context(), userID, req.AccountID, req.EmailIDs,
+		service.MessageActionMove, req.DestMailboxID)
+	if err != nil {
+		respondMailerError(c, err)
+		return
+	}
+	respondMailActionResult(c, result)
+}
+
+// SetMailLabels replaces the labels of messages
+func SetMailLabels(c *gin.Context) {
+	if !requireMailer(c) {
+		return
+	}
+	userID, ok := currentUserID(c)
+	if !ok {
+		return
+	}
+	var req models.SetLabelsRequest
+	if !bindMailerJSON(c, &req) {
+		return
+	}
+
+	result, err := services.Mailer.Mailbox.SetLabels(c.Request.Context(), userID, req.AccountID, req.EmailIDs, req.Labels)
+	if err != nil {
+		respondMailerError(c, err)
+		return
+	}
+	respondMailActionResult(c, result)
+}
+
+func listMailMessages(c *gin.Context, userID, accountID string, filter repository.MessageFilter) {
+	messages, total, err := services.Mailer.Mailbox.ListMessages(c.Request.Context(), userID, accountID, filter)
+	if err != nil {
+		respondMailerError(c, err)
+		return
+	}
+
+	list := models.EmailList{
+		AccountID:     accountID,
+		TotalEmails:   int64(total),
+		Position:      filter.Offset,
+		EmailsPerPage: filter.Limit,
+		Emails:        make([]*models.Email, 0, len(messages)),
+		HasMore:       filter.Offset+len(messages) < total,
+	}
+	if filter.FolderID != nil {
+		list.MailboxID = *filter.FolderID
+	}
+	for _, message := range messages {
+		list.Emails = append(list.Emails, toEmailModel(message, false))
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    list,
+	})
+}
+
+func respondMailActionResult(c *gin.Context, result *service.MessageActionResult) {
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data": gin.H{
+			"updated":   result.Updated,
+			"not_found": result.NotFound,
+		},
+	})
+}
+
+func respondInvalidMailQuery(c *gin.Context, message string) {
+	c.JSON(http.StatusBadRequest, gin.H{
+		"success": false,
+		"error":   "Invalid request",
+		"message": message,
+	})
+}
+
+// requireAccountQuery reads the account_id query parameter
+func requireAccountQuery(c *gin.Context) (string, bool) {
+	accountID := c.Query("account_id")
+	if accountID == "" {
+		respondInvalidMailQuery(c, "account_id is required")
+		return "", false
+	}
+	return accountID, true
+}
+
+// mailPage bounds the page size of message listings
+func mailPage(limit, offset int) (int, int) {
+	if limit <= 0 {
+		limit = 50
+	}
+	if limit > 200 {
+		limit = 200
+	}
+	if offset < 0 {
+		offset = 0
+	}
+	return limit, offset
+}
+
+func messageFilterFromQuery(c *gin.Context) (repository.MessageFilter, error) {
+	filter := repository.MessageFilter{}
+	filter.Limit, filter.Offset = mailPage(queryInt(c, "limit", 50), queryInt(c, "offset", 0))
+
+	if value := c.Query("mailbox_id"); value != "" {
+		filter.FolderID = &value
+	}
+	if value := c.Query("thread_id"); value != "" {
+		filter.ThreadID = &value
+	}
+	for name, target := range map[string]**bool{
+		"is_read":        &filter.IsRead,
+		"is_starred":     &filter.IsStarred,
+		"is_flagged":     &filter.IsFlagged,
+		"is_draft":       &filter.IsDraft,
+		"has_attachment": &filter.HasAttachments,
+	} {
+		value := c.Query(name)
+		if value == "" {
+			continue
+		}
+		parsed, err := strconv.ParseBool(value)
+		if err != nil {
+			return filter, err
+		}
+		*target = &parsed
+	}
+	for name, target := range map[string]**string{
+		"from":    &filter.From,
+		"to":      &filter.To,
+		"subject": &filter.Subject,
+	} {
+		if value := c.Query(name); value != "" {
+			*target = &value
+		}
+	}
+	for name, target := range map[string]**time.Time{
+		"after":  &filter.DateFrom,
+		"before": &filter.DateTo,
+	} {
+		value := c.Query(name)
+		if value == "" {
+			continue
+		}
+		parsed, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			return filter, err
+		}
+		*target = &parsed
+	}
+	filter.Labels = c.QueryArray("label")
+	return filter, nil
+}
+
+// messageFilterFromEmailQuery maps an EmailQuery to a message filter. It
+// returns a message naming the first criterion the mail storage cannot
+// filter on.
+func messageFilterFromEmailQuery(query *models.EmailQuery) (repository.MessageFilter, string) {
+	filter := repository.MessageFilter{
+		IsRead:         query.IsRead,
+		IsStarred:      query.IsStarred,
+		IsFlagged:      query.IsFlagged,
+		IsDraft:        query.IsDraft,
+		HasAttachments: query.HasAttachment,
+		DateFrom:       query.DateAfter,
+		DateTo:         query.DateBefore,
+		Labels:         query.Labels,
+	}
+	filter.Limit, filter.Offset = mailPage(query.Limit, query.Offset)
+
+	mailboxes := append(append([]string{}, query.MailboxIDs...), query.InMailbox...)
+	if len(mailboxes) > 1 {
+		return filter, "only one mailbox can be queried at a time"
+	}
+	if len(mailboxes) == 1 {
+		filter.FolderID = &mailboxes[0]
+	}
+	if query.From != "" {
+		filter.From = &query.From
+	}
+	if query.To != "" {
+		filter.To = &query.To
+	}
+	if query.Subject != "" {
+		filter.Subject = &query.Subject
+	}
+	if query.ThreadID != "" {
+		filter.ThreadID = &query.ThreadID
+	}
+
+	for _, criterion := range []struct {
+		name string
+		set  bool
+	}{
+		{"not_in_mailbox", len(query.NotInMailbox) > 0},
+		{"cc", query.CC != ""},
+		{"bcc", query.BCC != ""},
+		{"body", query.Body != ""},
+		{"has_keyword", len(query.HasKeyword) > 0},
+		{"not_keyword", len(query.NotKeyword) > 0},
+		{"size_before", query.SizeBefore != nil},
+		{"size_after", query.SizeAfter != nil},
+		{"sort", len(query.Sort) > 0},
+	} {
+		if criterion.set {
+			return filter, criterion.name + " is not supported"
+		}
+	}
+	return filter, ""
+}
+
+func toFolderModel(status *service.FolderStatus) *models.Folder {
+	folder := status.Folder
+	model := &models.Folder{
+		ID:           folder.ID,
+		AccountID:    folder.AccountID,
+		Name:         folder.Name,
+		Path:         folder.Path,
+		TotalEmails:  int64(status.TotalMessages),
+		UnreadEmails: int64(status.UnreadMessages),
+		IsSubscribed: folder.IsSubscribed,
+		IsSelectable: true,
+		IsSystem:     folder.Type != domain.FolderTypeCustom,
+		Type:         strings.ToLower(string(folder.Type)),
+		UnreadCount:  status.UnreadMessages,
+		HasChildren:  status.HasChildren,
+		Rights:       status.Rights.Names(),
+	}
+	if folder.ParentID != nil {
+		model.ParentID = *folder.ParentID
+	}
+	return model
+}
+
+// toEmailModel converts a message; listings leave out the bodies and
+// headers. HTML bodies are sanitised before they reach the client.
+func toEmailModel(message *domain.Message, full bool) *models.Email {
+	email := &models.Email{
+		ID:             message.ID,
+		AccountID:      message.AccountID,
+		ThreadID:       message.ThreadID,
+		MailboxID:      message.FolderID,
+		Subject:        message.Subject,
+		From:           toEmailAddress(message.From),
+		To:             toEmailAddresses(message.To),
+		Cc:             toEmailAddresses(message.Cc),
+		Date:           message.ReceivedAt,
+		UpdatedAt:      message.UpdatedAt,
+		Size:           message.Size,
+		IsRead:         message.IsRead,
+		IsStarred:      message.IsStarred,
+		IsDraft:        message.IsDraft,
+		IsFlagged:      message.IsFlagged,
+		IsDeleted:      message.IsDeleted,
+		HasAttachments: len(message.Attachments) > 0,
+		Labels:         message.Labels,
+		Attachments:    make([]*models.Attachment, 0, len(message.Attachments)),
+	}
+	if message.BodyText != nil {
+		email.Preview = previewText(*message.BodyText)
+	}
+	for _, attachment := range message.Attachments {
+		email.Attachments = append(email.Attachments, &models.Attachment{
+			ID:          attachment.ID,
+			EmailID:     message.ID,
+			Filename:    attachment.Filename,
+			MimeType:    attachment.ContentType,
+			Size:        attachment.Size,
+			Disposition: "attachment",
+			BlobID:      attachment.BlobID,
+			Checksum:    attachment.Checksum,
+		})
+	}
+
+	if full {
+		email.Bcc = toEmailAddresses(message.Bcc)
+		email.Headers = message.Headers
+		if replyTo := message.Header("Reply-To"); replyTo != "" {
+			email.ReplyTo = toEmailAddress(replyTo)
+		}
+		if message.BodyText != nil {
+			email.Body = *message.BodyText
+		}
+		if message.BodyHTML != nil {
+			email.BodyHTML = service.SanitizeMessageHTML(*message.BodyHTML)
+		}
+	}
+	return email
+}
+
+func toEmailAddresses(addresses []string) []*models.EmailAddress {
+	result := make([]*models.EmailAddress, 0, len(addresses))
+	for _, address := range addresses {
+		result = append(result, toEmailAddress(address))
+	}
+	return result
+}
+
+// toEmailAddress splits a display name from its address when it has one
+func toEmailAddress(value string) *models.EmailAddress {
+	address := &models.EmailAddress{}
+	if parsed, err := mail.ParseAddress(value); err == nil {
+		address.Parse(parsed.Address)
+		address.Name = parsed.Name
+		return address
+	}
+	address.Parse(value)
+	return address
+}
+
+// previewText collapses the whitespace of a text body and cuts it to
+// previewLength characters
+func previewText(body string) string {
+	preview := strings.Join(strings.Fields(body), " ")
+	if utf8.RuneCountInString(preview) <= previewLength {
+		return preview
+	}
+	return string([]rune(preview)[:previewLength]) + "…"
+}
@@ -0,0 +1,137 @@
+package controllers
+
+import (
+	stderrors "errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	mailerrors "github.com/skygenesisenterprise/aether-mailer/package/golang/errors"
+	"github.com/skygenesisenterprise/aether-mailer/server/src/services"
+)
+
+// requireMailer aborts with 503 when the mail services are not wired
+func requireMailer(c *gin.Context) bool {
+	if services.Mailer == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{
+			"success": false,
+			"error":   "Service unavailable",
+			"message": "Mail storage is not configured",
+		})
+		return false
+	}
+	return true
+}
+
+// currentUserID returns the authenticated user ID set by AuthMiddleware
+func currentUserID(c *gin.Context) (string, bool) {
+	userID, exists := c.Get("userId")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"success": false,
+			"error":   "Unauthorized",
+			"message": "User not authenticated",
+		})
+		return "", false
+	}
+	id, ok := userID.(string)
+	if !ok || id == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"success": false,
+			"error":   "Unauthorized",
+			"message": "Invalid user ID",
+		})
+		return "", false
+	}
+	return id, true
+}
+
+// bindMailerJSON binds the request body and answers 400 when it is invalid
+func bindMailerJSON(c *gin.Context, req interface{}) bool {
+	if err := c.ShouldBindJSON(req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"error":   "Invalid request body",
+			"message": err.Error(),
+		})
+		return false
+	}
+	return true
+}
+
+// respondMailerError maps SDK business errors to HTTP responses
+func respondMailerError(c *gin.Context, err error) {
+	var mailErr *mailerrors.Error
+	if !stderrors.As(err, &mailErr) {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"success": false,
+			"error":   "Internal server error",
+			"message": err.Error(),
+		})
+		return
+	}
+
+	status := mailerErrorStatus(mailErr)
+	body := gin.H{
+		"success": false,
+		"error":   mailErr.Code,
+		"message": mailErr.Message,
+	}
+	if len(mailErr.Details) > 0 && status != http.StatusInternalServerError {
+		body["details"] = mailErr.Details
+	}
+	c.JSON(status, body)
+}
+
+// mailerErrorStatus returns the HTTP status of an SDK business error
+func mailerErrorStatus(mailErr *mailerrors.Error) int {
+	switch mailErr.Code {
+	case mailerrors.ErrCodeDomainNotFound, mailerrors.ErrCodeUserNotFound,
+		mailerrors.ErrCodeEmailAccountNotFound, mailerrors.ErrCodeMessageNotFound,
+		mailerrors.ErrCodeFolderNotFound, mailerrors.ErrCodeThreadNotFound, mailerrors.ErrCodeDraftNotFound,
+		mailerrors.ErrCodeScheduledSendNotFound,
+		mailerrors.ErrCodeIdentityNotFound, mailerrors.ErrCodeDelegationNotFound,
+		mailerrors.ErrCodeGroupNotFound,
+		mailerrors.ErrCodePolicyNotFound,
+		mailerrors.ErrCodeQuarantineNotFound, mailerrors.ErrCodeSuspensionNotFound,
+		mailerrors.ErrCodeDestinationPolicyNotFound, mailerrors.ErrCodeDestinationNotFound,
+		mailerrors.ErrCodeIPPoolNotFound, mailerrors.ErrCodeDKIMKeyNotFound,
+		mailerrors.ErrCodeBlobNotFound:
+		return http.StatusNotFound
+	case mailerrors.ErrCodeDomainAlreadyExists, mailerrors.ErrCodeUserAlreadyExists,
+		mailerrors.ErrCodeEmailAccountAlreadyExists, mailerrors.ErrCodeDKIMRotationInProgress,
+		mailerrors.ErrCodeFolderAlreadyExists, mailerrors.ErrCodeDraftConflict,
+		mailerrors.ErrCodeScheduledSendLocked, mailerrors.ErrCodeScheduledSendClosed,
+		mailerrors.ErrCodeIdentityAlreadyExists, mailerrors.ErrCodeGroupAlreadyExists:
+		return http.StatusConflict
+	case mailerrors.ErrCodeUnauthorized, mailerrors.ErrCodeInvalidCredentials,
+		mailerrors.ErrCodeInvalidToken:
+		return http.StatusUnauthorized
+	case mailerrors.ErrCodeForbidden, mailerrors.ErrCodeRelayDenied, mailerrors.ErrCodeSendingSuspended,
+		mailerrors.ErrCodeSenderNotAllowed, mailerrors.ErrCodeMissingRights:
+		return http.StatusForbidden
+	case mailerrors.ErrCodeQuotaExceeded, mailerrors.ErrCodeStorageQuotaExceeded,
+		mailerrors.ErrCodeDailyQuotaExceeded, mailerrors.ErrCodeRateLimitExceeded:
+		return http.StatusTooManyRequests
+	case mailerrors.ErrCodeMessageTooLarge:
+		return http.StatusRequestEntityTooLarge
+	case mailerrors.ErrCodeInvalidRange:
+		return http.StatusRequestedRangeNotSatisfiable
+	case mailerrors.ErrCodeScanFailed, mailerrors.ErrCodeNetworkError:
+		return http.StatusServiceUnavailable
+	case mailerrors.ErrCodeTimeout:
+		return http.StatusGatewayTimeout
+	case mailerrors.ErrCodeInternalError, mailerrors.ErrCodeDatabaseError:
+		return http.StatusInternalServerError
+	}
+	return http.StatusBadRequest
+}
+
+// queryInt reads a non-negative integer query parameter
+func queryInt(c *gin.Context, name string, fallback int) int {
+	value, err := strconv.Atoi(c.Query(name))
+	if err != nil || value < 0 {
+		return fallback
+	}
+	return value
+}
@@ -0,0 +1,492 @@
+package controllers
+
+import (
+	"bytes"
+	stderrors "errors"
+	"html/template"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/skygenesisenterprise/aether-mailer/package/golang/domain"
+	mailerrors "github.com/skygenesisenterprise/aether-mailer/package/golang/errors"
+	"github.com/skygenesisenterprise/aether-mailer/package/golang/repository"
+	"github.com/skygenesisenterprise/aether-mailer/package/golang/service"
+	"github.com/skygenesisenterprise/aether-mailer/server/src/services"
+)
+
+// QuarantineEntryResponse represents a quarantined message without its raw content
+type QuarantineEntryResponse struct {
+	ID           string                  `json:"id"`
+	MessageID    string                  `json:"message_id"`
+	AccountID    string                  `json:"account_id"`
+	DomainID     string                  `json:"domain_id"`
+	Sender       string                  `json:"sender"`
+	Recipients   []string                `json:"recipients"`
+	Subject      string                  `json:"subject"`
+	Reason       domain.QuarantineReason `json:"reason"`
+	Details      string                  `json:"details"`
+	PolicyID     *string                 `json:"policy_id"`
+	PolicyName   *string                 `json:"policy_name"`
+	SpamScore    *float64                `json:"spam_score,omitempty"`
+	Signatures   []string                `json:"signatures,omitempty"`
+	Size         int64                   `json:"size"`
+	Status       domain.QuarantineStatus `json:"status"`
+	CreatedAt    time.Time               `json:"created_at"`
+	ExpiresAt    time.Time               `json:"expires_at"`
+	ReleasedAt   *time.Time              `json:"released_at"`
+	ReleasedBy   *string                 `json:"released_by"`
+	DigestSentAt *time.Time              `json:"digest_sent_at"`
+}
+
+// QuarantinePreviewResponse represents the sanitised preview of a quarantined message
+type QuarantinePreviewResponse struct {
+	Entry       QuarantineEntryResponse `json:"entry"`
+	Headers     map[string]string       `json:"headers"`
+	BodyText    string                  `json:"body_text"`
+	BodyHTML    string                  `json:"body_html"`
+	Attachments []AttachmentInfo        `json:"attachments"`
+}
+
+// AttachmentInfo represents attachment metadata
+type AttachmentInfo struct {
+	Filename    string `json:"filename"`
+	ContentType string `json:"content_type"`
+	Size        int64  `json:"size"`
+}
+
+// ListQuarantine lists the messages quarantined for the current user's accounts
+func ListQuarantine(c *gin.Context) {
+	if !requireMailer(c) {
+		return
+	}
+	userID, ok := currentUserID(c)
+	if !ok {
+		return
+	}
+
+	filter := quarantineFilterFromQuery(c)
+	entries, total, err := services.Mailer.Quarantine.ListEntriesForUser(c.Request.Context(), userID, filter)
+	if err != nil {
+		respondMailerError(c, err)
+		return
+	}
+
+	respondQuarantineList(c, entries, total, filter)
+}
+
+// GetQuarantinePreview returns a sanitised preview of one of the user's quarantined messages
+func GetQuarantinePreview(c *gin.Context) {
+	if !requireMailer(c) {
+		return
+	}
+	userID, ok := currentUserID(c)
+	if !ok {
+		return
+	}
+
+	entry, err := services.Mailer.Quarantine.GetEntryForUser(c.Request.Context(), c.Param("id"), userID)
+	if err != nil {
+		respondMailerError(c, err)
+		return
+	}
+
+	respondQuarantinePreview(c, entry)
+}
+
+// ReleaseQuarantine delivers one of the user's quarantined messages to the inbox
+func ReleaseQuarantine(c *gin.Context) {
+	releaseQuarantineForUser(c, false)
+}
+
+// ReleaseAndAllowQuarantine releases a message and allow-lists its sender
+func ReleaseAndAllowQuarantine(c *gin.Context) {
+	releaseQuarantineForUser(c, true)
+}
+
+// DeleteQuarantine discards one of the user's quarantined messages
+func DeleteQuarantine(c *gin.Context) {
+	if !requireMailer(c) {
+		return
+	}
+	userID, ok := currentUserID(c)
+	if !ok {
+		return
+	}
+
+	ctx := c.Request.Context()
+	entry, err := services.Mailer.Quarantine.GetEntryForUser(ctx, c.Param("id"), userID)
+	if err != nil {
+		respondMailerError(c, err)
+		return
+	}
+	if err := services.Mailer.Quarantine.DeleteEntry(ctx, entry.ID); err != nil {
+		respondMailerError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "Message deleted",
+	})
+}
+
+// ConfirmQuarantineRelease shows the message a signed digest link releases
+// and asks for confirmation. Fetching the link changes nothing, so link
+// previews and mail scanners cannot release messages.
+func ConfirmQuarantineRelease(c *gin.Context) {
+	if !requireMailer(c) {
+		return
+	}
+
+	token := c.Query("token")
+	if token == "" {
+		renderQuarantineRelease(c, http.StatusBadRequest, quarantineReleaseView{
+			Title:   "Invalid link",
+			Message: "This release link is incomplete.",
+		})
+		return
+	}
+
+	entry, err := services.Mailer.Quarantine.GetEntryForToken(c.Request.Context(), token)
+	if err != nil {
+		renderQuarantineReleaseError(c, err)
+		return
+	}
+	if entry.Status != domain.QuarantineStatusHeld {
+		renderQuarantineRelease(c, http.StatusOK, quarantineReleaseView{
+			Title:   "Message already handled",
+			Message: "This message is no longer held in quarantine.",
+			Entry:   entry,
+		})
+		return
+	}
+
+	renderQuarantineRelease(c, http.StatusOK, quarantineReleaseView{
+		Title:   "Release this message?",
+		Message: "The message will be delivered to your inbox. Only release messages you trust.",
+		Entry:   entry,
+		Action:  c.Request.URL.Path,
+		Token:   token,
+	})
+}
+
+// ReleaseQuarantineWithToken releases a message once the digest link is
+// confirmed. The token, posted by the confirmation form, authenticates the
+// request, so no session is required.
+func ReleaseQuarantineWithToken(c *gin.Context) {
+	if !requireMailer(c) {
+		return
+	}
+
+	token := c.PostForm("token")
+	if token == "" {
+		renderQuarantineRelease(c, http.StatusBadRequest, quarantineReleaseView{
+			Title:   "Invalid request",
+			Message: "The release token is missing.",
+		})
+		return
+	}
+
+	entry, err := services.Mailer.Quarantine.ReleaseWithToken(c.Request.Context(), token)
+	if err != nil {
+		renderQuarantineReleaseError(c, err)
+		return
+	}
+
+	renderQuarantineRelease(c, http.StatusOK, quarantineReleaseView{
+		Title:   "Message released",
+		Message: "The message was released to your inbox.",
+		Entry:   entry,
+	})
+}
+
+// AdminListQuarantine lists quarantined messages across all accounts
+func AdminListQuarantine(c *gin.Context) {
+	if !requireMailer(c) {
+		return
+	}
+
+	filter := quarantineFilterFromQuery(c)
+	if accountID := c.Query("account_id"); accountID != "" {
+		filter.AccountIDs = []string{accountID}
+	}
+	if domainID := c.Query("domain_id"); domainID != "" {
+		filter.DomainID = &domainID
+	}
+
+	entries, total, err := services.Mailer.Quarantine.ListEntries(c.Request.Context(), filter)
+	if err != nil {
+		respondMailerError(c, err)
+		return
+	}
+
+	respondQuarantineList(c, entries, total, filter)
+}
+
+// AdminGetQuarantinePreview returns a sanitised preview of any quarantined message
+func AdminGetQuarantinePreview(c *gin.Context) {
+	if !requireMailer(c) {
+		return
+	}
+
+	entry, err := services.Mailer.Quarantine.GetEntry(c.Request.Context(), c.Param("id"))
+	if err != nil {
+		respondMailerError(c, err)
+		return
+	}
+
+	respondQuarantinePreview(c, entry)
+}
+
+// AdminReleaseQuarantine releases any quarantined message, including infected ones
+func AdminReleaseQuarantine(c *gin.Context) {
+	releaseQuarantineAsAdmin(c, false)
+}
+
+// AdminReleaseAndAllowQuarantine releases a message and allow-lists its sender
+// for the recipient
+func AdminReleaseAndAllowQuarantine(c *gin.Context) {
+	releaseQuarantineAsAdmin(c, true)
+}
+
+// AdminDeleteQuarantine discards any quarantined message
+func AdminDeleteQuarantine(c *gin.Context) {
+	if !requireMailer(c) {
+		return
+	}
+
+	if err := services.Mailer.Quarantine.DeleteEntry(c.Request.Context(), c.Param("id")); err != nil {
+		respondMailerError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "Message deleted",
+	})
+}
+
+func releaseQuarantineForUser(c *gin.Context, allowSender bool) {
+	if !requireMailer(c) {
+		return
+	}
+	userID, ok := currentUserID(c)
+	if !ok {
+		return
+	}
+
+	ctx := c.Request.Context()
+	entry, err := services.Mailer.Quarantine.GetEntryForUser(ctx, c.Param("id"), userID)
+	if err != nil {
+		respondMailerError(c, err)
+		return
+	}
+
+	entry, err = services.Mailer.Quarantine.Release(ctx, service.ReleaseRequest{
+		EntryID:     entry.ID,
+		ActorID:     userID,
+		AllowSender: allowSender,
+	})
+	if err != nil {
+		respondMailerError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "Message released",
+		"data":    toQuarantineEntryResponse(entry),
+	})
+}
+
+func releaseQuarantineAsAdmin(c *gin.Context, allowSender bool) {
+	if !requireMailer(c) {
+		return
+	}
+	userID, ok := currentUserID(c)
+	if !ok {
+		return
+	}
+
+	entry, err := services.Mailer.Quarantine.Release(c.Request.Context(), service.ReleaseRequest{
+		EntryID:     c.Param("id"),
+		ActorID:     userID,
+		IsAdmin:     true,
+		AllowSender: allowSender,
+	})
+	if err != nil {
+		respondMailerError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "Message released",
+		"data":    toQuarantineEntryResponse(entry),
+	})
+}
+
+func quarantineFilterFromQuery(c *gin.Context) repository.QuarantineFilter {
+	filter := repository.QuarantineFilter{
+		Limit:  queryInt(c, "limit", 50),
+		Offset: queryInt(c, "offset", 0),
+	}
+	if filter.Limit > 200 {
+		filter.Limit = 200
+	}
+
+	status := domain.QuarantineStatusHeld
+	if value := c.Query("status"); value != "" {
+		status = domain.QuarantineStatus(value)
+	}
+	filter.Status = &status
+
+	if value := c.Query("reason"); value != "" {
+		reason := domain.QuarantineReason(value)
+		filter.Reason = &reason
+	}
+	return filter
+}
+
+func respondQuarantineList(c *gin.Context, entries []*domain.QuarantineEntry, total int, filter repository.QuarantineFilter) {
+	data := make([]QuarantineEntryResponse, 0, len(entries))
+	for _, entry := range entries {
+		data = append(data, toQuarantineEntryResponse(entry))
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    data,
+		"total":   total,
+		"limit":   filter.Limit,
+		"offset":  filter.Offset,
+	})
+}
+
+func respondQuarantinePreview(c *gin.Context, entry *domain.QuarantineEntry) {
+	preview := services.Mailer.Quarantine.Preview(entry)
+
+	attachments := make([]AttachmentInfo, 0, len(preview.Attachments))
+	for _, att := range preview.Attachments {
+		attachments = append(attachments, AttachmentInfo{
+			Filename:    att.Filename,
+			ContentType: att.ContentType,
+			Size:        att.Size,
+		})
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data": QuarantinePreviewResponse{
+			Entry:       toQuarantineEntryResponse(entry),
+			Headers:     preview.Headers,
+			BodyText:    preview.BodyText,
+			BodyHTML:    preview.BodyHTML,
+			Attachments: attachments,
+		},
+	})
+}
+
+func toQuarantineEntryResponse(entry *domain.QuarantineEntry) QuarantineEntryResponse {
+	response := QuarantineEntryResponse{
+		ID:           entry.ID,
+		MessageID:    entry.MessageID,
+		AccountID:    entry.AccountID,
+		DomainID:     entry.DomainID,
+		Sender:       entry.Sender,
+		Recipients:   entry.Recipients,
+		Subject:      entry.Subject,
+		Reason:       entry.Reason,
+		Details:      entry.Details,
+		PolicyID:     entry.PolicyID,
+		PolicyName:   entry.PolicyName,
+		Size:         entry.Size,
+		Status:       entry.Status,
+		CreatedAt:    entry.CreatedAt,
+		ExpiresAt:    entry.ExpiresAt,
+		ReleasedAt:   entry.ReleasedAt,
+		ReleasedBy:   entry.ReleasedBy,
+		DigestSentAt: entry.DigestSentAt,
+	}
+	if entry.SpamVerdict != nil {
+		response.SpamScore = &entry.SpamVerdict.Score
+	}
+	if entry.VirusVerdict != nil {
+		for _, finding := range entry.VirusVerdict.Findings {
+			response.Signatures = append(response.Signatures, finding.Signature)
+		}
+	}
+	return response
+}
+
+// quarantineReleaseView is the page a digest release link renders
+type quarantineReleaseView struct {
+	Title   string
+	Message string
+	Entry   *domain.QuarantineEntry
+	Action  string // where the confirmation form posts, empty for no form
+	Token   string
+}
+
+var quarantineReleaseTemplate = template.Must(template.New("release").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<meta name="robots" content="noindex, nofollow">
+<title>{{.Title}}</title>
+</head>
+<body>
+<h1>{{.Title}}</h1>
+{{with .Entry}}<table>
+<tr><th>From</th><td>{{.Sender}}</td></tr>
+<tr><th>Subject</th><td>{{.Subject}}</td></tr>
+<tr><th>Reason</th><td>{{.Reason}}</td></tr>
+<tr><th>Received</th><td>{{.CreatedAt.Format "2006-01-02 15:04 MST"}}</td></tr>
+</table>{{end}}
+<p>{{.Message}}</p>
+{{if .Action}}<form method="post" action="{{.Action}}">
+<input type="hidden" name="token" value="{{.Token}}">
+<button type="submit">Release to inbox</button>
+</form>{{end}}
+</body>
+</html>
+`))
+
+func renderQuarantineRelease(c *gin.Context, status int, view quarantineReleaseView) {
+	// The token is in the URL: keep it out of caches, referrers and frames
+	c.Header("Cache-Control", "no-store")
+	c.Header("Referrer-Policy", "no-referrer")
+	c.Header("X-Frame-Options", "DENY")
+	c.Header("Content-Security-Policy", "default-src 'none'; form-action 'self'; frame-ancestors 'none'")
+
+	var page bytes.Buffer
+	if err := quarantineReleaseTemplate.Execute(&page, view); err != nil {
+		c.String(http.StatusInternalServerError, "Internal server error")
+		return
+	}
+	c.Data(status, "text/html; charset=utf-8", page.Bytes())
+}
+
+func renderQuarantineReleaseError(c *gin.Context, err error) {
+	status := http.StatusInternalServerError
+	var mailErr *mailerrors.Error
+	if stderrors.As(err, &mailErr) {
+		status = mailerErrorStatus(mailErr)
+	}
+
+	view := quarantineReleaseView{
+		Title:   "Release failed",
+		Message: "The message could not be released. Please try again later.",
+	}
+	switch status {
+	case http.StatusUnauthorized:
+		view.Title, view.Message = "Invalid link", "This release link is invalid or has expired."
+	case http.StatusNotFound:
+		view.Title, view.Message = "Message not found", "This message is no longer in quarantine."
+	case http.StatusBadRequest, http.StatusConflict:
+		view.Message = mailErr.Message
+	}
+	renderQuarantineRelease(c, status, view)
+}
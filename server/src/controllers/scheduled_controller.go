@@ -0,0 +1,258 @@
+package controllers
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/skygenesisenterprise/aether-mailer/package/golang/domain"
+	"github.com/skygenesisenterprise/aether-mailer/package/golang/service"
+	"github.com/skygenesisenterprise/aether-mailer/server/src/models"
+	"github.com/skygenesisenterprise/aether-mailer/server/src/services"
+)
+
+// SendMail composes a message and sends it at once, at send_at, or when
+// its undo window closes. Held messages are answered with 202 and their
+// queue entry; is_draft only saves the draft.
+func SendMail(c *gin.Context) {
+	if !requireMailer(c) {
+		return
+	}
+	userID, ok := currentUserID(c)
+	if !ok {
+		return
+	}
+	var req models.SendEmailRequest
+	if !bindMailerJSON(c, &req) {
+		return
+	}
+	attachments, ok := decodeSendAttachments(c, req.Attachments)
+	if !ok {
+		return
+	}
+
+	draft := service.DraftRequest{
+		AccountID:   req.AccountID,
+		From:        fromEmailAddress(req.From),
+		To:          fromEmailAddresses(req.To),
+		Cc:          fromEmailAddresses(req.Cc),
+		Bcc:         fromEmailAddresses(req.Bcc),
+		Subject:     req.Subject,
+		BodyText:    optionalString(req.Body),
+		BodyHTML:    optionalString(req.BodyHTML),
+		Attachments: attachments,
+	}
+	if req.IsDraft {
+		created, err := services.Mailer.Drafts.CreateDraft(c.Request.Context(), userID, draft)
+		respondDraft(c, http.StatusCreated, created, err)
+		return
+	}
+
+	result, err := services.Mailer.Scheduled.Send(c.Request.Context(), userID, service.SendRequest{
+		Draft:      draft,
+		SendAt:     req.SendAt,
+		UndoWindow: time.Duration(req.UndoSeconds) * time.Second,
+	})
+	if err != nil {
+		respondMailerError(c, err)
+		return
+	}
+	if result.Scheduled != nil {
+		respondScheduled(c, http.StatusAccepted, result.Scheduled)
+		return
+	}
+	c.JSON(http.StatusOK, models.EmailResponse{
+		Success: true,
+		Data:    toEmailModel(result.Sent, true),
+	})
+}
+
+// ScheduleMailDraft holds one of the user's drafts until send_at, or for
+// an undo window
+func ScheduleMailDraft(c *gin.Context) {
+	if !requireMailer(c) {
+		return
+	}
+	userID, ok := currentUserID(c)
+	if !ok {
+		return
+	}
+	var req models.ScheduleDraftRequest
+	if !bindMailerJSON(c, &req) {
+		return
+	}
+
+	scheduled, err := services.Mailer.Scheduled.Schedule(c.Request.Context(), userID, c.Param("id"),
+		req.SendAt, time.Duration(req.UndoSeconds)*time.Second)
+	if err != nil {
+		respondMailerError(c, err)
+		return
+	}
+	respondScheduled(c, http.StatusAccepted, scheduled)
+}
+
+// ListMailScheduled lists the pending sends of one of the user's accounts,
+// soonest first
+func ListMailScheduled(c *gin.Context) {
+	if !requireMailer(c) {
+		return
+	}
+	userID, ok := currentUserID(c)
+	if !ok {
+		return
+	}
+	accountID, ok := requireAccountQuery(c)
+	if !ok {
+		return
+	}
+
+	limit, offset := mailPage(queryInt(c, "limit", 50), queryInt(c, "offset", 0))
+	scheduled, total, err := services.Mailer.Scheduled.ListScheduled(c.Request.Context(), userID, accountID, limit, offset)
+	if err != nil {
+		respondMailerError(c, err)
+		return
+	}
+
+	queue := make([]*models.MessageQueue, 0, len(scheduled))
+	for _, message := range scheduled {
+		queue = append(queue, toMessageQueueModel(message))
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data": gin.H{
+			"account_id": accountID,
+			"total":      total,
+			"position":   offset,
+			"per_page":   limit,
+			"scheduled":  queue,
+			"has_more":   offset+len(scheduled) < total,
+		},
+	})
+}
+
+// GetMailScheduled returns one of the user's scheduled sends
+func GetMailScheduled(c *gin.Context) {
+	if !requireMailer(c) {
+		return
+	}
+	userID, ok := currentUserID(c)
+	if !ok {
+		return
+	}
+
+	scheduled, err := services.Mailer.Scheduled.GetScheduled(c.Request.Context(), userID, c.Param("id"))
+	if err != nil {
+		respondMailerError(c, err)
+		return
+	}
+	respondScheduled(c, http.StatusOK, scheduled)
+}
+
+// UpdateMailScheduled reschedules one of the user's pending sends or edits
+// the fields of its draft that are set
+func UpdateMailScheduled(c *gin.Context) {
+	if !requireMailer(c) {
+		return
+	}
+	userID, ok := currentUserID(c)
+	if !ok {
+		return
+	}
+	var req models.UpdateScheduledSendRequest
+	if !bindMailerJSON(c, &req) {
+		return
+	}
+
+	update := service.ScheduledSendUpdate{SendAt: req.SendAt}
+	if req.Draft != nil {
+		draft, ok := toDraftUpdate(c, req.Draft)
+		if !ok {
+			return
+		}
+		update.Draft = &draft
+	}
+
+	scheduled, err := services.Mailer.Scheduled.UpdateScheduled(c.Request.Context(), userID, c.Param("id"), update)
+	if err != nil {
+		respondMailerError(c, err)
+		return
+	}
+	respondScheduled(c, http.StatusOK, scheduled)
+}
+
+// CancelMailScheduled cancels one of the user's pending sends, which also
+// undoes a send within its undo window. The draft is returned and stays in
+// the Drafts folder.
+func CancelMailScheduled(c *gin.Context) {
+	if !requireMailer(c) {
+		return
+	}
+	userID, ok := currentUserID(c)
+	if !ok {
+		return
+	}
+
+	draft, err := services.Mailer.Scheduled.CancelScheduled(c.Request.Context(), userID, c.Param("id"))
+	respondDraft(c, http.StatusOK, draft, err)
+}
+
+func respondScheduled(c *gin.Context, status int, scheduled *service.ScheduledMessage) {
+	c.JSON(status, gin.H{
+		"success": true,
+		"data":    toMessageQueueModel(scheduled),
+	})
+}
+
+// toMessageQueueModel converts a scheduled send to its queue entry
+func toMessageQueueModel(scheduled *service.ScheduledMessage) *models.MessageQueue {
+	send := scheduled.Send
+	sendAt := send.SendAt
+	payload := models.ScheduledSendPayload{
+		Kind:      strings.ToLower(string(send.Kind)),
+		AccountID: send.AccountID,
+		DraftID:   send.DraftID,
+	}
+	if send.SentMessageID != nil {
+		payload.SentID = *send.SentMessageID
+	}
+	if scheduled.Draft != nil {
+		payload.Email = toEmailModel(scheduled.Draft, false)
+	}
+
+	queue := &models.MessageQueue{
+		ID:          send.ID,
+		Type:        "message",
+		Status:      messageQueueStatus(send),
+		Payload:     payload,
+		Attempts:    send.Attempts,
+		MaxAttempts: services.Mailer.Scheduled.MaxAttempts(),
+		ScheduledAt: &sendAt,
+		CompletedAt: send.CompletedAt,
+		CreatedAt:   send.CreatedAt,
+		UpdatedAt:   send.UpdatedAt,
+	}
+	if send.LastError != "" {
+		lastError := send.LastError
+		queue.Error = &lastError
+	}
+	return queue
+}
+
+func messageQueueStatus(send *domain.ScheduledSend) string {
+	switch send.Status {
+	case domain.ScheduledSendStatusSent:
+		return "completed"
+	case domain.ScheduledSendStatusCancelled:
+		return "cancelled"
+	case domain.ScheduledSendStatusFailed:
+		return "failed"
+	}
+	if send.IsLocked(time.Now()) {
+		return "processing"
+	}
+	if send.LastError != "" {
+		return "retry"
+	}
+	return "pending"
+}
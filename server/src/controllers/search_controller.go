@@ -0,0 +1,166 @@
+package controllers
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/skygenesisenterprise/aether-mailer/package/golang/service"
+	"github.com/skygenesisenterprise/aether-mailer/server/src/models"
+	"github.com/skygenesisenterprise/aether-mailer/server/src/services"
+)
+
+// SearchMail runs a ranked full-text search in one of the user's accounts.
+// The query accepts from:, to:, subject:, has:attachment, is:unread,
+// label:, before:, after: and quoted phrases; the other criteria of the
+// request are added to it.
+func SearchMail(c *gin.Context) {
+	if !requireMailer(c) {
+		return
+	}
+	userID, ok := currentUserID(c)
+	if !ok {
+		return
+	}
+	var query models.SearchQuery
+	if !bindMailerJSON(c, &query) {
+		return
+	}
+	if query.AccountID == "" {
+		respondInvalidMailQuery(c, "account_id is required")
+		return
+	}
+
+	req, message := searchRequestFromQuery(&query)
+	if message != "" {
+		respondInvalidMailQuery(c, message)
+		return
+	}
+
+	started := time.Now()
+	result, err := services.Mailer.Search.Search(c.Request.Context(), userID, req)
+	if err != nil {
+		respondMailerError(c, err)
+		return
+	}
+
+	emails := make([]*models.Email, 0, len(result.Messages))
+	for _, message := range result.Messages {
+		emails = append(emails, toEmailModel(message, false))
+	}
+	c.JSON(http.StatusOK, models.SearchResponse{
+		Success: true,
+		Data: &models.SearchResult{
+			Emails:       emails,
+			TotalResults: int64(result.Total),
+			QueryTime:    time.Since(started).Milliseconds(),
+		},
+	})
+}
+
+// AdminReindexMailAccount rebuilds the search index of an account
+func AdminReindexMailAccount(c *gin.Context) {
+	if !requireMailer(c) {
+		return
+	}
+
+	indexed, err := services.Mailer.Search.ReindexAccount(c.Request.Context(), c.Param("id"))
+	if err != nil {
+		respondMailerError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data": gin.H{
+			"account_id": c.Param("id"),
+			"indexed":    indexed,
+		},
+	})
+}
+
+// searchRequestFromQuery writes the criteria of a SearchQuery as search
+// operators. Dates are matched by day. It returns a message naming the
+// first criterion the search index cannot serve.
+func searchRequestFromQuery(query *models.SearchQuery) (service.SearchRequest, string) {
+	req := service.SearchRequest{AccountID: query.AccountID}
+	req.Limit, req.Offset = mailPage(query.Limit, query.Offset)
+
+	if len(query.MailboxIDs) > 1 {
+		return req, "only one mailbox can be searched at a time"
+	}
+	if len(query.MailboxIDs) == 1 {
+		req.FolderID = query.MailboxIDs[0]
+	}
+	for _, criterion := range []struct {
+		name string
+		set  bool
+	}{
+		{"bcc", query.BCC != ""},
+		{"size_before", query.SizeBefore != nil},
+		{"size_after", query.SizeAfter != nil},
+		{"sort", len(query.Sort) > 0},
+	} {
+		if criterion.set {
+			return req, criterion.name + " is not supported"
+		}
+	}
+
+	terms := []string{query.Query}
+	for _, operand := range []struct {
+		operator string
+		value    string
+	}{
+		{"from:", query.From},
+		{"to:", query.To},
+		{"to:", query.CC}, // to: also matches Cc recipients
+		{"subject:", query.Subject},
+		{"", query.Body},
+	} {
+		if operand.value != "" {
+			terms = append(terms, operand.operator+searchOperand(operand.value))
+		}
+	}
+	for _, flag := range []struct {
+		value   *bool
+		yes, no string
+	}{
+		{query.HasAttachment, "has:attachment", "-has:attachment"},
+		{query.IsRead, "is:read", "is:unread"},
+		{query.IsStarred, "is:starred", "-is:starred"},
+	} {
+		switch {
+		case flag.value == nil:
+		case *flag.value:
+			terms = append(terms, flag.yes)
+		default:
+			terms = append(terms, flag.no)
+		}
+	}
+	for _, label := range query.HasKeywords {
+		terms = append(terms, "label:"+searchOperand(label))
+	}
+	for _, label := range query.ExcludeKeywords {
+		terms = append(terms, "-label:"+searchOperand(label))
+	}
+	if query.DateAfter != nil {
+		terms = append(terms, "after:"+query.DateAfter.UTC().Format("2006-01-02"))
+	}
+	if query.DateBefore != nil {
+		// before: excludes its day, so a time within a day keeps that day
+		before := query.DateBefore.UTC()
+		if !before.Equal(before.Truncate(24 * time.Hour)) {
+			before = before.Truncate(24 * time.Hour).Add(24 * time.Hour)
+		}
+		terms = append(terms, "before:"+before.Format("2006-01-02"))
+	}
+
+	req.Query = strings.TrimSpace(strings.Join(terms, " "))
+	return req, ""
+}
+
+// searchOperand quotes a value for the search syntax
+func searchOperand(value string) string {
+	return `"` + strings.ReplaceAll(value, `"`, " ") + `"`
+}
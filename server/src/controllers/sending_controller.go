@@ -0,0 +1,203 @@
+package controllers
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/skygenesisenterprise/aether-mailer/package/golang/domain"
+	"github.com/skygenesisenterprise/aether-mailer/server/src/services"
+)
+
+// SuspendSendingRequest represents a request to suspend outbound sending
+type SuspendSendingRequest struct {
+	Scope  string `json:"scope" binding:"required"`
+	Key    string `json:"key" binding:"required"`
+	Reason string `json:"reason" binding:"required"`
+}
+
+// SendingSuspensionResponse represents a sending suspension
+type SendingSuspensionResponse struct {
+	ID          string     `json:"id"`
+	Scope       string     `json:"scope"`
+	Key         string     `json:"key"`
+	Reason      string     `json:"reason"`
+	SuspendedAt time.Time  `json:"suspended_at"`
+	ExpiresAt   *time.Time `json:"expires_at"`
+	LiftedAt    *time.Time `json:"lifted_at"`
+	LiftedBy    *string    `json:"lifted_by"`
+}
+
+// RateUsageResponse represents the usage of one sending limit
+type RateUsageResponse struct {
+	Window        string `json:"window"`
+	Messages      int64  `json:"messages"`
+	MaxMessages   int64  `json:"max_messages"`
+	Recipients    int64  `json:"recipients"`
+	MaxRecipients int64  `json:"max_recipients"`
+}
+
+// ListSendingSuspensions lists the sending suspensions currently in force
+func ListSendingSuspensions(c *gin.Context) {
+	if !requireMailer(c) {
+		return
+	}
+
+	suspensions, err := services.Mailer.RateLimit.ListSuspensions(c.Request.Context())
+	if err != nil {
+		respondMailerError(c, err)
+		return
+	}
+
+	data := make([]SendingSuspensionResponse, 0, len(suspensions))
+	for _, suspension := range suspensions {
+		data = append(data, toSendingSuspensionResponse(suspension))
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    data,
+	})
+}
+
+// SuspendSending manually suspends outbound sending for an account, domain, tenant or IP
+func SuspendSending(c *gin.Context) {
+	if !requireMailer(c) {
+		return
+	}
+
+	var req SuspendSendingRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"error":   "Invalid request body",
+			"message": err.Error(),
+		})
+		return
+	}
+
+	scope, ok := parseRateScope(req.Scope)
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"error":   "Invalid request body",
+			"message": "scope must be one of account, domain, tenant or ip",
+		})
+		return
+	}
+
+	suspension, err := services.Mailer.RateLimit.Suspend(c.Request.Context(), scope, req.Key, req.Reason)
+	if err != nil {
+		respondMailerError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"success": true,
+		"data":    toSendingSuspensionResponse(suspension),
+	})
+}
+
+// LiftSendingSuspension re-enables outbound sending
+func LiftSendingSuspension(c *gin.Context) {
+	if !requireMailer(c) {
+		return
+	}
+	userID, ok := currentUserID(c)
+	if !ok {
+		return
+	}
+
+	suspension, err := services.Mailer.RateLimit.LiftSuspension(c.Request.Context(), c.Param("id"), userID)
+	if err != nil {
+		respondMailerError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    toSendingSuspensionResponse(suspension),
+	})
+}
+
+// GetSendingStats returns the rate limit usage and delivery reputation of a scope
+func GetSendingStats(c *gin.Context) {
+	if !requireMailer(c) {
+		return
+	}
+
+	scope, ok := parseRateScope(c.Param("scope"))
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"error":   "Invalid scope",
+			"message": "scope must be one of account, domain, tenant or ip",
+		})
+		return
+	}
+
+	ctx := c.Request.Context()
+	key := c.Param("key")
+
+	usage, err := services.Mailer.RateLimit.GetUsage(ctx, scope, key)
+	if err != nil {
+		respondMailerError(c, err)
+		return
+	}
+	stats, err := services.Mailer.RateLimit.GetSendingStats(ctx, scope, key)
+	if err != nil {
+		respondMailerError(c, err)
+		return
+	}
+
+	limits := make([]RateUsageResponse, 0, len(usage))
+	for _, u := range usage {
+		limits = append(limits, RateUsageResponse{
+			Window:        u.Limit.Window.String(),
+			Messages:      u.Messages,
+			MaxMessages:   u.Limit.MaxMessages,
+			Recipients:    u.Recipients,
+			MaxRecipients: u.Limit.MaxRecipients,
+		})
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data": gin.H{
+			"scope":  scope,
+			"key":    key,
+			"limits": limits,
+			"reputation": gin.H{
+				"window":                 stats.Window.String(),
+				"delivered":              stats.Delivered,
+				"bounced":                stats.Bounced,
+				"unknown_recipients":     stats.UnknownRecipients,
+				"bounce_rate":            stats.BounceRate,
+				"unknown_recipient_rate": stats.UnknownRecipientRate,
+			},
+		},
+	})
+}
+
+func parseRateScope(value string) (domain.RateScope, bool) {
+	scope := domain.RateScope(strings.ToUpper(value))
+	switch scope {
+	case domain.RateScopeAccount, domain.RateScopeDomain, domain.RateScopeTenant, domain.RateScopeIP:
+		return scope, true
+	}
+	return "", false
+}
+
+func toSendingSuspensionResponse(suspension *domain.SendingSuspension) SendingSuspensionResponse {
+	return SendingSuspensionResponse{
+		ID:          suspension.ID,
+		Scope:       string(suspension.Scope),
+		Key:         suspension.Key,
+		Reason:      suspension.Reason,
+		SuspendedAt: suspension.SuspendedAt,
+		ExpiresAt:   suspension.ExpiresAt,
+		LiftedAt:    suspension.LiftedAt,
+		LiftedBy:    suspension.LiftedBy,
+	}
+}
@@ -0,0 +1,228 @@
+package controllers
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/skygenesisenterprise/aether-mailer/package/golang/domain"
+	"github.com/skygenesisenterprise/aether-mailer/package/golang/repository"
+	"github.com/skygenesisenterprise/aether-mailer/server/src/models"
+	"github.com/skygenesisenterprise/aether-mailer/server/src/services"
+)
+
+// ListMailThreads lists the conversations of one of the user's accounts,
+// latest message first. is_unread=true keeps those with unread messages.
+func ListMailThreads(c *gin.Context) {
+	if !requireMailer(c) {
+		return
+	}
+	userID, ok := currentUserID(c)
+	if !ok {
+		return
+	}
+	accountID, ok := requireAccountQuery(c)
+	if !ok {
+		return
+	}
+
+	filter := repository.ThreadFilter{}
+	filter.Limit, filter.Offset = mailPage(queryInt(c, "limit", 50), queryInt(c, "offset", 0))
+	if value := c.Query("is_unread"); value != "" {
+		unread, err := strconv.ParseBool(value)
+		if err != nil {
+			respondInvalidMailQuery(c, err.Error())
+			return
+		}
+		filter.IsUnread = &unread
+	}
+
+	threads, total, err := services.Mailer.Threads.ListThreads(c.Request.Context(), userID, accountID, filter)
+	if err != nil {
+		respondMailerError(c, err)
+		return
+	}
+
+	list := models.EmailList{
+		AccountID:     accountID,
+		TotalThreads:  int64(total),
+		Position:      filter.Offset,
+		EmailsPerPage: filter.Limit,
+		Emails:        []*models.Email{},
+		Threads:       make([]*models.Thread, 0, len(threads)),
+		HasMore:       filter.Offset+len(threads) < total,
+	}
+	for _, thread := range threads {
+		list.Threads = append(list.Threads, toThreadModel(thread, nil))
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    list,
+	})
+}
+
+// GetMailThreads returns conversations of one of the user's accounts with
+// their messages, and the IDs that were not found
+func GetMailThreads(c *gin.Context) {
+	if !requireMailer(c) {
+		return
+	}
+	userID, ok := currentUserID(c)
+	if !ok {
+		return
+	}
+	var req models.ThreadGetRequest
+	if !bindMailerJSON(c, &req) {
+		return
+	}
+
+	views, notFound, err := services.Mailer.Threads.GetThreads(c.Request.Context(), userID, req.AccountID, req.IDs)
+	if err != nil {
+		respondMailerError(c, err)
+		return
+	}
+
+	threads := make([]*models.Thread, 0, len(views))
+	for _, view := range views {
+		threads = append(threads, toThreadModel(view.Thread, view.Messages))
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data": gin.H{
+			"account_id": req.AccountID,
+			"threads":    threads,
+			"not_found":  notFound,
+		},
+	})
+}
+
+// GetMailThread returns one of the user's conversations with its messages,
+// oldest first
+func GetMailThread(c *gin.Context) {
+	if !requireMailer(c) {
+		return
+	}
+	userID, ok := currentUserID(c)
+	if !ok {
+		return
+	}
+
+	view, err := services.Mailer.Threads.GetThread(c.Request.Context(), userID, c.Param("id"))
+	if err != nil {
+		respondMailerError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, models.ThreadResponse{
+		Success: true,
+		Data:    toThreadModel(view.Thread, view.Messages),
+	})
+}
+
+// GetMailThreadSettings returns the threading settings of one of the user's accounts
+func GetMailThreadSettings(c *gin.Context) {
+	if !requireMailer(c) {
+		return
+	}
+	userID, ok := currentUserID(c)
+	if !ok {
+		return
+	}
+	accountID, ok := requireAccountQuery(c)
+	if !ok {
+		return
+	}
+
+	settings, err := services.Mailer.Threads.GetSettings(c.Request.Context(), userID, accountID)
+	if err != nil {
+		respondMailerError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    toThreadSettingsModel(settings),
+	})
+}
+
+// UpdateMailThreadSettings sets when messages of one of the user's accounts
+// join a conversation by subject
+func UpdateMailThreadSettings(c *gin.Context) {
+	if !requireMailer(c) {
+		return
+	}
+	userID, ok := currentUserID(c)
+	if !ok {
+		return
+	}
+	var req models.UpdateThreadSettingsRequest
+	if !bindMailerJSON(c, &req) {
+		return
+	}
+
+	settings, err := services.Mailer.Threads.UpdateSettings(c.Request.Context(), userID, req.AccountID,
+		domain.ThreadSubjectMode(req.SubjectMode))
+	if err != nil {
+		respondMailerError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    toThreadSettingsModel(settings),
+	})
+}
+
+// AdminRethreadMailAccount rebuilds the conversations of an account
+func AdminRethreadMailAccount(c *gin.Context) {
+	if !requireMailer(c) {
+		return
+	}
+
+	threaded, err := services.Mailer.Threads.RethreadAccount(c.Request.Context(), c.Param("id"))
+	if err != nil {
+		respondMailerError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data": gin.H{
+			"account_id": c.Param("id"),
+			"threaded":   threaded,
+		},
+	})
+}
+
+// toThreadModel converts a thread; listings pass no messages
+func toThreadModel(thread *domain.Thread, messages []*domain.Message) *models.Thread {
+	model := &models.Thread{
+		ID:              thread.ID,
+		AccountID:       thread.AccountID,
+		Subject:         thread.Subject,
+		Emails:          make([]*models.Email, 0, len(messages)),
+		TotalEmails:     thread.MessageCount,
+		UnreadEmails:    thread.UnreadCount,
+		HasAttachments:  thread.HasAttachments,
+		IsRead:          thread.UnreadCount == 0,
+		IsStarred:       thread.IsStarred,
+		LastMessageDate: thread.LastMessageAt,
+		Participants:    toEmailAddresses(thread.Participants),
+	}
+	for _, message := range messages {
+		model.Emails = append(model.Emails, toEmailModel(message, true))
+	}
+	return model
+}
+
+func toThreadSettingsModel(settings *domain.ThreadSettings) *models.ThreadSettings {
+	model := &models.ThreadSettings{
+		AccountID:   settings.AccountID,
+		SubjectMode: string(settings.SubjectMode),
+	}
+	if !settings.UpdatedAt.IsZero() {
+		model.UpdatedAt = &settings.UpdatedAt
+	}
+	return model
+}
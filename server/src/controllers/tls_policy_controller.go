@@ -0,0 +1,198 @@
+package controllers
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/skygenesisenterprise/aether-mailer/package/golang/domain"
+	"github.com/skygenesisenterprise/aether-mailer/server/src/services"
+)
+
+// MTASTSPolicyResponse represents the MTA-STS policy in effect for a domain
+type MTASTSPolicyResponse struct {
+	Domain        string    `json:"domain"`
+	ID            string    `json:"id"`
+	Mode          string    `json:"mode"`
+	MX            []string  `json:"mx"`
+	MaxAgeSeconds int64     `json:"max_age_seconds"`
+	FetchedAt     time.Time `json:"fetched_at"`
+	ExpiresAt     time.Time `json:"expires_at"`
+}
+
+// TLSResultResponse represents aggregated outbound TLS session results
+type TLSResultResponse struct {
+	PolicyType    string   `json:"policy_type"`
+	PolicyStrings []string `json:"policy_strings"`
+	MXHost        string   `json:"mx_host"`
+	ResultType    string   `json:"result_type"` // empty for successful sessions
+	Count         int64    `json:"count"`
+}
+
+// GetMTASTSPolicy returns the cached or freshly fetched MTA-STS policy of a recipient domain
+func GetMTASTSPolicy(c *gin.Context) {
+	if !requireMailer(c) || !requireTLSPolicies(c) {
+		return
+	}
+
+	policy, err := services.Mailer.TLSPolicies.GetMTASTSPolicy(c.Request.Context(), c.Param("domain"))
+	if err != nil {
+		respondMailerError(c, err)
+		return
+	}
+	if policy == nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": true,
+			"data":    nil,
+			"message": "Domain publishes no MTA-STS policy",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data": MTASTSPolicyResponse{
+			Domain:        policy.Domain,
+			ID:            policy.ID,
+			Mode:          string(policy.Mode),
+			MX:            policy.MX,
+			MaxAgeSeconds: int64(policy.MaxAge / time.Second),
+			FetchedAt:     policy.FetchedAt,
+			ExpiresAt:     policy.ExpiresAt,
+		},
+	})
+}
+
+// ListTLSResultDomains lists the recipient domains with TLS results in a period
+func ListTLSResultDomains(c *gin.Context) {
+	if !requireMailer(c) || !requireTLSPolicies(c) {
+		return
+	}
+	from, to, ok := queryTimeRange(c)
+	if !ok {
+		return
+	}
+
+	domains, err := services.Mailer.TLSPolicies.ListReportDomains(c.Request.Context(), from, to)
+	if err != nil {
+		respondMailerError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    domains,
+	})
+}
+
+// GetTLSResults returns the outbound TLS session statistics of a recipient domain
+func GetTLSResults(c *gin.Context) {
+	if !requireMailer(c) || !requireTLSPolicies(c) {
+		return
+	}
+	from, to, ok := queryTimeRange(c)
+	if !ok {
+		return
+	}
+
+	summaries, err := services.Mailer.TLSPolicies.GetResultSummary(c.Request.Context(), c.Param("domain"), from, to)
+	if err != nil {
+		respondMailerError(c, err)
+		return
+	}
+
+	data := make([]TLSResultResponse, 0, len(summaries))
+	for _, summary := range summaries {
+		data = append(data, toTLSResultResponse(summary))
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    data,
+	})
+}
+
+// GetTLSReport returns the RFC 8460 aggregate TLS report of a recipient domain
+func GetTLSReport(c *gin.Context) {
+	if !requireMailer(c) || !requireTLSPolicies(c) {
+		return
+	}
+	from, to, ok := queryTimeRange(c)
+	if !ok {
+		return
+	}
+
+	report, err := services.Mailer.TLSPolicies.BuildReport(c.Request.Context(), c.Param("domain"), from, to)
+	if err != nil {
+		respondMailerError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    report,
+	})
+}
+
+// requireTLSPolicies aborts with 503 when MTA-STS and DANE are not wired
+func requireTLSPolicies(c *gin.Context) bool {
+	if services.Mailer.TLSPolicies == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{
+			"success": false,
+			"error":   "Service unavailable",
+			"message": "Outbound TLS policies are not configured",
+		})
+		return false
+	}
+	return true
+}
+
+// queryTimeRange reads the RFC 3339 from and to query parameters, defaulting
+// to the last 24 hours, and answers 400 when they are invalid
+func queryTimeRange(c *gin.Context) (time.Time, time.Time, bool) {
+	to := time.Now()
+	if value := c.Query("to"); value != "" {
+		parsed, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			respondInvalidTimeRange(c, "Invalid to parameter")
+			return time.Time{}, time.Time{}, false
+		}
+		to = parsed
+	}
+	from := to.Add(-24 * time.Hour)
+	if value := c.Query("from"); value != "" {
+		parsed, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			respondInvalidTimeRange(c, "Invalid from parameter")
+			return time.Time{}, time.Time{}, false
+		}
+		from = parsed
+	}
+	if !from.Before(to) {
+		respondInvalidTimeRange(c, "from must be before to")
+		return time.Time{}, time.Time{}, false
+	}
+	return from, to, true
+}
+
+func respondInvalidTimeRange(c *gin.Context, message string) {
+	c.JSON(http.StatusBadRequest, gin.H{
+		"success": false,
+		"error":   "Invalid time range",
+		"message": message,
+	})
+}
+
+func toTLSResultResponse(summary *domain.TLSResultSummary) TLSResultResponse {
+	policyStrings := summary.PolicyStrings
+	if policyStrings == nil {
+		policyStrings = []string{}
+	}
+	return TLSResultResponse{
+		PolicyType:    string(summary.PolicyType),
+		PolicyStrings: policyStrings,
+		MXHost:        summary.MXHost,
+		ResultType:    string(summary.ResultType),
+		Count:         summary.Count,
+	}
+}
@@ -12,6 +12,10 @@ func AdminMiddleware() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		// Extraire l'utilisateur de la requête
 		userID, exists := c.Get("user_id")
+		if !exists {
+			// AuthMiddleware stores the ID under "userId"
+			userID, exists = c.Get("userId")
+		}
 		if !exists {
 			c.JSON(http.StatusUnauthorized, gin.H{
 				"error": "Unauthorized",
@@ -0,0 +1,85 @@
+package models
+
+import "time"
+
+// SharedMailbox is an email account no single user owns, such as support@,
+// which users reach through the rights granted on its folders
+type SharedMailbox struct {
+	ID          string    `json:"id"`
+	DomainID    string    `json:"domain_id"`
+	Email       string    `json:"email"`
+	DisplayName string    `json:"display_name,omitempty"`
+	IsShared    bool      `json:"is_shared"` // false for accounts of other users shared with the user
+	QuotaMB     int       `json:"quota_mb"`
+	UsedMB      int       `json:"used_mb"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+type CreateSharedMailboxRequest struct {
+	Email       string `json:"email" binding:"required"`
+	DisplayName string `json:"display_name,omitempty"`
+	QuotaMB     int    `json:"quota_mb"`
+}
+
+// MailGroup is a named set of users of a domain that folder rights can be
+// granted to, as "group:<name>"
+type MailGroup struct {
+	ID          string    `json:"id"`
+	DomainID    string    `json:"domain_id"`
+	Name        string    `json:"name"`
+	Description string    `json:"description,omitempty"`
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
+type CreateMailGroupRequest struct {
+	DomainID    string `json:"domain_id" binding:"required"`
+	Name        string `json:"name" binding:"required"`
+	Description string `json:"description,omitempty"`
+}
+
+type MailGroupMember struct {
+	GroupID string    `json:"group_id"`
+	UserID  string    `json:"user_id"`
+	AddedAt time.Time `json:"added_at"`
+}
+
+type AddMailGroupMemberRequest struct {
+	Member string `json:"member" binding:"required"` // address or username
+}
+
+// FolderACLEntry is an entry of the access control list of a folder.
+// Rights use the letters of IMAP (RFC 4314), such as "lrs".
+type FolderACLEntry struct {
+	Identifier  string   `json:"identifier"`   // anyone, group:<name> or a user's address
+	SubjectType string   `json:"subject_type"` // user, group, anyone
+	SubjectID   string   `json:"subject_id,omitempty"`
+	Rights      string   `json:"rights"`
+	RightNames  []string `json:"right_names"`
+}
+
+// SetFolderACLRequest sets the rights of an identifier on a folder. Rights
+// starting with "+" or "-" are added or removed; right_names, such as
+// "read", may be given instead of letters. No rights removes the entry.
+type SetFolderACLRequest struct {
+	Identifier string   `json:"identifier" binding:"required"`
+	Rights     string   `json:"rights,omitempty"`
+	RightNames []string `json:"right_names,omitempty"`
+}
+
+// ACLAuditEntry records a change to folder rights, groups or shared
+// mailboxes
+type ACLAuditEntry struct {
+	ID          string    `json:"id"`
+	DomainID    string    `json:"domain_id"`
+	AccountID   string    `json:"account_id,omitempty"`
+	FolderID    string    `json:"folder_id,omitempty"`
+	GroupID     string    `json:"group_id,omitempty"`
+	Action      string    `json:"action"` // rights_set, rights_deleted, member_added, member_removed, group_created, group_deleted, shared_mailbox_created
+	SubjectType string    `json:"subject_type,omitempty"`
+	SubjectID   string    `json:"subject_id,omitempty"`
+	OldRights   string    `json:"old_rights,omitempty"`
+	NewRights   string    `json:"new_rights,omitempty"`
+	ActorID     string    `json:"actor_id"`
+	CreatedAt   time.Time `json:"created_at"`
+}
@@ -57,25 +57,46 @@ func (UserDomain) TableName() string {
 
 // DomainVerification représente les informations de vérification d'un domaine
 type DomainVerification struct {
-	ID            string     `gorm:"type:uuid;primaryKey;default:gen_random_uuid()" json:"id"`
-	DomainID      string     `gorm:"type:uuid;column:domain_id;not null;index" json:"domainId"`
-	Method        string     `gorm:"size:20;not null" json:"method"` // dns, file, mx, meta
-	Token         string     `gorm:"size:255;not null" json:"token"`
-	Value         string     `gorm:"size:500;not null" json:"value"`
-	IsVerified    bool       `gorm:"default:false;column:is_verified" json:"isVerified"`
-	VerifiedAt    *time.Time `gorm:"column:verified_at" json:"verifiedAt,omitempty"`
-	Attempts      int        `gorm:"default:0" json:"attempts"`
-	LastAttemptAt *time.Time `gorm:"column:last_attempt_at" json:"lastAttemptAt,omitempty"`
-	CreatedAt     time.Time  `gorm:"column:created_at" json:"createdAt"`
-	UpdatedAt     time.Time  `gorm:"column:updated_at" json:"updatedAt"`
-
-	Domain Domain `gorm:"foreignKey:DomainID"`
+	ID                  string     `gorm:"type:uuid;primaryKey;default:gen_random_uuid()" json:"id"`
+	DomainID            string     `gorm:"type:uuid;column:domain_id;not null;index" json:"domainId"`
+	Method              string     `gorm:"size:20;not null" json:"method"` // dns, cname, file, mx, meta
+	Token               string     `gorm:"size:255;not null" json:"token"`
+	Value               string     `gorm:"size:500;not null" json:"value"`
+	IsVerified          bool       `gorm:"default:false;column:is_verified" json:"isVerified"`
+	VerifiedAt          *time.Time `gorm:"column:verified_at" json:"verifiedAt,omitempty"`
+	Attempts            int        `gorm:"default:0" json:"attempts"`
+	LastAttemptAt       *time.Time `gorm:"column:last_attempt_at" json:"lastAttemptAt,omitempty"`
+	ConsecutiveFailures int        `gorm:"default:0;column:consecutive_failures" json:"consecutiveFailures"` // remis à zéro à chaque succès
+	LastError           *string    `gorm:"size:500;column:last_error" json:"lastError,omitempty"`
+	NextCheckAt         *time.Time `gorm:"column:next_check_at;index" json:"nextCheckAt,omitempty"`
+	PendingSince        *time.Time `gorm:"column:pending_since" json:"pendingSince,omitempty"` // début de l'attente, nul une fois vérifié
+	CreatedAt           time.Time  `gorm:"column:created_at" json:"createdAt"`
+	UpdatedAt           time.Time  `gorm:"column:updated_at" json:"updatedAt"`
+
+	Domain  Domain                      `gorm:"foreignKey:DomainID"`
+	History []DomainVerificationAttempt `gorm:"foreignKey:VerificationID" json:"history,omitempty"`
 }
 
 func (DomainVerification) TableName() string {
 	return "domain_verifications"
 }
 
+// DomainVerificationAttempt représente une tentative de vérification d'un domaine
+type DomainVerificationAttempt struct {
+	ID             string    `gorm:"type:uuid;primaryKey;default:gen_random_uuid()" json:"id"`
+	VerificationID string    `gorm:"type:uuid;column:verification_id;not null;index" json:"verificationId"`
+	DomainID       string    `gorm:"type:uuid;column:domain_id;not null;index" json:"domainId"`
+	Method         string    `gorm:"size:20;not null" json:"method"`
+	Success        bool      `gorm:"default:false" json:"success"`
+	Found          string    `gorm:"type:text" json:"found"` // valeurs observées, une par ligne
+	Error          *string   `gorm:"size:500" json:"error,omitempty"`
+	CheckedAt      time.Time `gorm:"column:checked_at;index" json:"checkedAt"`
+}
+
+func (DomainVerificationAttempt) TableName() string {
+	return "domain_verification_attempts"
+}
+
 // DomainSettings représente les paramètres spécifiques à un domaine
 type DomainSettings struct {
 	ID            string    `gorm:"type:uuid;primaryKey;default:gen_random_uuid()" json:"id"`
@@ -86,6 +107,7 @@ type DomainSettings struct {
 	CustomJS      *string   `gorm:"type:text;column:custom_js" json:"customJs,omitempty"`
 	BrandingLogo  *string   `gorm:"size:500;column:branding_logo" json:"brandingLogo,omitempty"`
 	BrandingColor *string   `gorm:"size:7;column:branding_color" json:"brandingColor,omitempty"`
+	MTASTSMode    *string   `gorm:"size:10;column:mta_sts_mode" json:"mtaStsMode,omitempty"` // enforce, testing ou none ; mode de la configuration si nil
 	CreatedAt     time.Time `gorm:"column:created_at" json:"createdAt"`
 	UpdatedAt     time.Time `gorm:"column:updated_at" json:"updatedAt"`
 
@@ -98,10 +120,11 @@ func (DomainSettings) TableName() string {
 
 // Constantes pour les méthodes de vérification
 const (
-	DomainVerificationDNS  = "dns"
-	DomainVerificationFile = "file"
-	DomainVerificationMX   = "mx"
-	DomainVerificationMeta = "meta"
+	DomainVerificationDNS   = "dns" // enregistrement TXT à la racine du domaine
+	DomainVerificationCNAME = "cname"
+	DomainVerificationFile  = "file" // fichier servi en HTTPS sous /.well-known
+	DomainVerificationMX    = "mx"
+	DomainVerificationMeta  = "meta"
 )
 
 // DomainWithDetails représente un domaine avec ses informations détaillées
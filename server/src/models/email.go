@@ -17,8 +17,10 @@ type Email struct {
 	Bcc            []*EmailAddress   `json:"bcc,omitempty"`
 	ReplyTo        *EmailAddress     `json:"reply_to,omitempty"`
 	Date           time.Time         `json:"date"`
+	UpdatedAt      time.Time         `json:"updated_at"`
 	Size           int64             `json:"size"`
 	Attachments    []*Attachment     `json:"attachments,omitempty"`
+	BodyStructure  *BodyPart         `json:"body_structure,omitempty"`
 	Headers        map[string]string `json:"headers,omitempty"`
 	IsRead         bool              `json:"is_read"`
 	IsStarred      bool              `json:"is_starred"`
@@ -73,6 +75,23 @@ type Attachment struct {
 	Checksum    string `json:"checksum,omitempty"`
 }
 
+type BodyPart struct {
+	PartID      string            `json:"part_id"`
+	MimeType    string            `json:"mime_type"`
+	Params      map[string]string `json:"params,omitempty"`
+	Charset     string            `json:"charset,omitempty"`
+	Encoding    string            `json:"encoding,omitempty"`
+	Disposition string            `json:"disposition,omitempty"`
+	Filename    string            `json:"filename,omitempty"`
+	CID         string            `json:"cid,omitempty"`
+	Description string            `json:"description,omitempty"`
+	Size        int64             `json:"size"`
+	Checksum    string            `json:"checksum,omitempty"`
+	BlobID      string            `json:"blob_id,omitempty"`
+	Headers     map[string]string `json:"headers,omitempty"`
+	Parts       []*BodyPart       `json:"parts,omitempty"`
+}
+
 type EmailList struct {
 	AccountID     string    `json:"account_id"`
 	MailboxID     string    `json:"mailbox_id,omitempty"`
@@ -92,6 +111,7 @@ type Thread struct {
 	Subject         string          `json:"subject"`
 	Emails          []*Email        `json:"emails"`
 	TotalEmails     int             `json:"total_emails"`
+	UnreadEmails    int             `json:"unread_emails"`
 	HasAttachments  bool            `json:"has_attachments"`
 	IsRead          bool            `json:"is_read"`
 	IsStarred       bool            `json:"is_starred"`
@@ -153,6 +173,22 @@ type ThreadResponse struct {
 	Error   string  `json:"error,omitempty"`
 }
 
+type ThreadGetRequest struct {
+	AccountID string   `json:"account_id" binding:"required"`
+	IDs       []string `json:"ids" binding:"required"`
+}
+
+type ThreadSettings struct {
+	AccountID   string     `json:"account_id"`
+	SubjectMode string     `json:"subject_mode"`         // off, replies or always
+	UpdatedAt   *time.Time `json:"updated_at,omitempty"` // unset for the defaults
+}
+
+type UpdateThreadSettingsRequest struct {
+	AccountID   string `json:"account_id" binding:"required"`
+	SubjectMode string `json:"subject_mode" binding:"required"`
+}
+
 type SendEmailRequest struct {
 	From        *EmailAddress     `json:"from"`
 	To          []*EmailAddress   `json:"to" binding:"required"`
@@ -166,6 +202,77 @@ type SendEmailRequest struct {
 	Attachments []SendAttachment  `json:"attachments,omitempty"`
 	Keywords    []string          `json:"keywords,omitempty"`
 	Headers     map[string]string `json:"headers,omitempty"`
+	AccountID   string            `json:"account_id" binding:"required"`
+	SendAt      *time.Time        `json:"send_at,omitempty"`      // send later
+	UndoSeconds int               `json:"undo_seconds,omitempty"` // hold the message this long after sending
+}
+
+// ScheduleDraftRequest sends a draft later, or after an undo window
+type ScheduleDraftRequest struct {
+	SendAt      *time.Time `json:"send_at,omitempty"`
+	UndoSeconds int        `json:"undo_seconds,omitempty"`
+}
+
+// UpdateScheduledSendRequest reschedules a pending send or edits its draft
+type UpdateScheduledSendRequest struct {
+	SendAt *time.Time            `json:"send_at,omitempty"`
+	Draft  *AutosaveDraftRequest `json:"draft,omitempty"`
+}
+
+type CreateDraftRequest struct {
+	AccountID   string           `json:"account_id" binding:"required"`
+	From        *EmailAddress    `json:"from,omitempty"` // default identity when absent
+	To          []*EmailAddress  `json:"to,omitempty"`
+	Cc          []*EmailAddress  `json:"cc,omitempty"`
+	Bcc         []*EmailAddress  `json:"bcc,omitempty"`
+	Subject     string           `json:"subject"`
+	Body        string           `json:"body,omitempty"`
+	BodyHTML    string           `json:"body_html,omitempty"`
+	Attachments []SendAttachment `json:"attachments,omitempty"`
+	InReplyTo   string           `json:"in_reply_to,omitempty"`
+	References  []string         `json:"references,omitempty"`
+}
+
+// UpdateDraftRequest replaces the content of a draft; absent fields are
+// cleared. Attachments are added and removed by ID.
+type UpdateDraftRequest struct {
+	From                *EmailAddress    `json:"from"`
+	To                  []*EmailAddress  `json:"to"`
+	Cc                  []*EmailAddress  `json:"cc"`
+	Bcc                 []*EmailAddress  `json:"bcc"`
+	Subject             string           `json:"subject"`
+	Body                string           `json:"body"`
+	BodyHTML            string           `json:"body_html"`
+	AddAttachments      []SendAttachment `json:"add_attachments,omitempty"`
+	RemoveAttachmentIDs []string         `json:"remove_attachment_ids,omitempty"`
+	IfUnmodifiedSince   *time.Time       `json:"if_unmodified_since,omitempty"`
+}
+
+// AutosaveDraftRequest changes only the fields it sets
+type AutosaveDraftRequest struct {
+	From                *EmailAddress    `json:"from,omitempty"`
+	To                  *[]*EmailAddress `json:"to,omitempty"`
+	Cc                  *[]*EmailAddress `json:"cc,omitempty"`
+	Bcc                 *[]*EmailAddress `json:"bcc,omitempty"`
+	Subject             *string          `json:"subject,omitempty"`
+	Body                *string          `json:"body,omitempty"`
+	BodyHTML            *string          `json:"body_html,omitempty"`
+	AddAttachments      []SendAttachment `json:"add_attachments,omitempty"`
+	RemoveAttachmentIDs []string         `json:"remove_attachment_ids,omitempty"`
+	IfUnmodifiedSince   *time.Time       `json:"if_unmodified_since,omitempty"`
+}
+
+type ReplyEmailRequest struct {
+	Body     string `json:"body,omitempty"`
+	BodyHTML string `json:"body_html,omitempty"`
+}
+
+type ForwardEmailRequest struct {
+	To       []*EmailAddress `json:"to,omitempty"`
+	Cc       []*EmailAddress `json:"cc,omitempty"`
+	Bcc      []*EmailAddress `json:"bcc,omitempty"`
+	Body     string          `json:"body,omitempty"`
+	BodyHTML string          `json:"body_html,omitempty"`
 }
 
 type SendAttachment struct {
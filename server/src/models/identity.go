@@ -0,0 +1,72 @@
+package models
+
+import "time"
+
+// Identity is an address an email account sends as, with the display name,
+// reply-to address and signature used for it
+type Identity struct {
+	ID            string     `json:"id"`
+	AccountID     string     `json:"account_id"`
+	DomainID      string     `json:"domain_id"`
+	Email         string     `json:"email"`
+	Name          string     `json:"name,omitempty"`
+	ReplyTo       string     `json:"reply_to,omitempty"`
+	SignatureText string     `json:"signature_text,omitempty"`
+	SignatureHTML string     `json:"signature_html,omitempty"`
+	IsDefault     bool       `json:"is_default"`
+	Status        string     `json:"status"` // pending, approved, rejected
+	ReviewedBy    *string    `json:"reviewed_by,omitempty"`
+	ReviewedAt    *time.Time `json:"reviewed_at,omitempty"`
+	CreatedAt     time.Time  `json:"created_at"`
+	UpdatedAt     time.Time  `json:"updated_at"`
+}
+
+type CreateIdentityRequest struct {
+	AccountID     string `json:"account_id" binding:"required"`
+	Email         string `json:"email" binding:"required"`
+	Name          string `json:"name,omitempty"`
+	ReplyTo       string `json:"reply_to,omitempty"`
+	SignatureText string `json:"signature_text,omitempty"`
+	SignatureHTML string `json:"signature_html,omitempty"`
+	IsDefault     bool   `json:"is_default"`
+}
+
+// UpdateIdentityRequest changes only the fields it sets
+type UpdateIdentityRequest struct {
+	Name          *string `json:"name,omitempty"`
+	ReplyTo       *string `json:"reply_to,omitempty"`
+	SignatureText *string `json:"signature_text,omitempty"`
+	SignatureHTML *string `json:"signature_html,omitempty"`
+	IsDefault     *bool   `json:"is_default,omitempty"`
+}
+
+type ReviewIdentityRequest struct {
+	Approve *bool `json:"approve" binding:"required"`
+}
+
+// SenderDelegation lets another account send as, or on behalf of, a mailbox
+type SenderDelegation struct {
+	ID                string    `json:"id"`
+	AccountID         string    `json:"account_id"`
+	DelegateAccountID string    `json:"delegate_account_id"`
+	Permission        string    `json:"permission"` // send_as, send_on_behalf
+	GrantedBy         string    `json:"granted_by"`
+	CreatedAt         time.Time `json:"created_at"`
+	UpdatedAt         time.Time `json:"updated_at"`
+}
+
+type GrantDelegationRequest struct {
+	AccountID     string `json:"account_id" binding:"required"`
+	DelegateEmail string `json:"delegate_email" binding:"required"`
+	Permission    string `json:"permission" binding:"required"` // send_as, send_on_behalf
+}
+
+// Sender is an address an account may put in From. Sender is set when the
+// message is sent on behalf of another mailbox.
+type Sender struct {
+	From          *EmailAddress `json:"from"`
+	Sender        *EmailAddress `json:"sender,omitempty"`
+	ReplyTo       string        `json:"reply_to,omitempty"`
+	SignatureText string        `json:"signature_text,omitempty"`
+	SignatureHTML string        `json:"signature_html,omitempty"`
+}
@@ -20,4 +20,13 @@ type MessageQueue struct {
 	CreatedAt   time.Time      `gorm:"column:created_at" json:"createdAt"`
 	UpdatedAt   time.Time      `gorm:"column:updated_at" json:"updatedAt"`
 	DeletedAt   gorm.DeletedAt `gorm:"index" json:"-"`
-}
\ No newline at end of file
+}
+
+// ScheduledSendPayload is the payload of a message held until it is sent
+type ScheduledSendPayload struct {
+	Kind      string `json:"kind"` // scheduled, undo
+	AccountID string `json:"account_id"`
+	DraftID   string `json:"draft_id"`
+	SentID    string `json:"sent_id,omitempty"`
+	Email     *Email `json:"email,omitempty"`
+}
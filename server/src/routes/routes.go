@@ -180,6 +180,162 @@ func SetupRoutes(r *gin.Engine) {
 				adminFooterLinks.PUT("/:id", controllers.UpdateFooterLink)
 				adminFooterLinks.DELETE("/:id", controllers.DeleteFooterLink)
 			}
+
+			adminQuarantine := admin.Group("/quarantine", middleware.AuthMiddleware(), middleware.AdminMiddleware())
+			{
+				adminQuarantine.GET("", controllers.AdminListQuarantine)
+				adminQuarantine.GET("/:id/preview", controllers.AdminGetQuarantinePreview)
+				adminQuarantine.POST("/:id/release", controllers.AdminReleaseQuarantine)
+				adminQuarantine.POST("/:id/release-allow", controllers.AdminReleaseAndAllowQuarantine)
+				adminQuarantine.DELETE("/:id", controllers.AdminDeleteQuarantine)
+			}
+
+			adminSending := admin.Group("/sending", middleware.AuthMiddleware(), middleware.AdminMiddleware())
+			{
+				adminSending.GET("/suspensions", controllers.ListSendingSuspensions)
+				adminSending.POST("/suspensions", controllers.SuspendSending)
+				adminSending.POST("/suspensions/:id/lift", controllers.LiftSendingSuspension)
+				adminSending.GET("/stats/:scope/:key", controllers.GetSendingStats)
+			}
+
+			adminDelivery := admin.Group("/delivery", middleware.AuthMiddleware(), middleware.AdminMiddleware())
+			{
+				adminDelivery.GET("/queue", controllers.GetDeliveryQueue)
+				adminDelivery.GET("/queue/:destination", controllers.GetDestinationQueue)
+				adminDelivery.GET("/policies", controllers.ListDestinationPolicies)
+				adminDelivery.POST("/policies", controllers.CreateDestinationPolicy)
+				adminDelivery.PUT("/policies/:id", controllers.UpdateDestinationPolicy)
+				adminDelivery.DELETE("/policies/:id", controllers.DeleteDestinationPolicy)
+				adminDelivery.GET("/tls/policies/:domain", controllers.GetMTASTSPolicy)
+				adminDelivery.GET("/tls/results", controllers.ListTLSResultDomains)
+				adminDelivery.GET("/tls/results/:domain", controllers.GetTLSResults)
+				adminDelivery.GET("/tls/reports/:domain", controllers.GetTLSReport)
+			}
+
+			adminDomains := admin.Group("/domains", middleware.AuthMiddleware(), middleware.AdminMiddleware())
+			{
+				adminDomains.GET("/:id/dns", controllers.GetDomainDNSRecords)
+				adminDomains.GET("/:id/dns/zone", controllers.GetDomainDNSZone)
+				adminDomains.GET("/:id/dns/check", controllers.CheckDomainDNSRecords)
+				adminDomains.PUT("/:id/dns/mta-sts", controllers.UpdateDomainMTASTSMode)
+				adminDomains.GET("/:id/verification", controllers.GetDomainVerification)
+				adminDomains.POST("/:id/verification", controllers.StartDomainVerification)
+				adminDomains.POST("/:id/verification/check", controllers.VerifyDomain)
+				adminDomains.GET("/:id/dkim", controllers.ListDKIMKeys)
+				adminDomains.GET("/:id/dkim/history", controllers.GetDKIMRotationHistory)
+				adminDomains.POST("/:id/dkim/rotate", controllers.RotateDKIMKey)
+				adminDomains.POST("/:id/dkim/import", controllers.ImportDKIMKey)
+				adminDomains.POST("/:id/dkim/keys/:keyId/check", controllers.CheckDKIMKey)
+			}
+
+			adminIPPools := admin.Group("/ip-pools", middleware.AuthMiddleware(), middleware.AdminMiddleware())
+			{
+				adminIPPools.GET("", controllers.ListIPPools)
+				adminIPPools.POST("", controllers.CreateIPPool)
+				adminIPPools.GET("/:id", controllers.GetIPPool)
+				adminIPPools.PUT("/:id", controllers.UpdateIPPool)
+				adminIPPools.DELETE("/:id", controllers.DeleteIPPool)
+				adminIPPools.GET("/:id/usage", controllers.GetIPPoolUsage)
+				adminIPPools.POST("/:id/addresses", controllers.AddIPPoolAddress)
+				adminIPPools.PUT("/:id/addresses/:ip", controllers.UpdateIPPoolAddress)
+				adminIPPools.DELETE("/:id/addresses/:ip", controllers.RemoveIPPoolAddress)
+				adminIPPools.GET("/:id/assignments", controllers.ListIPPoolAssignments)
+				adminIPPools.POST("/:id/assignments", controllers.AssignIPPool)
+				adminIPPools.DELETE("/assignments/:scope/:key", controllers.UnassignIPPool)
+			}
+
+			adminSearch := admin.Group("/search", middleware.AuthMiddleware(), middleware.AdminMiddleware())
+			{
+				adminSearch.POST("/accounts/:id/reindex", controllers.AdminReindexMailAccount)
+			}
+
+			// The MTA hands received messages to message intake
+			adminMail := admin.Group("/mail", middleware.AuthMiddleware(), middleware.AdminMiddleware())
+			{
+				adminMail.POST("/inbound", controllers.AdminReceiveMailMessage)
+			}
+
+			adminThreads := admin.Group("/threads", middleware.AuthMiddleware(), middleware.AdminMiddleware())
+			{
+				adminThreads.POST("/accounts/:id/rethread", controllers.AdminRethreadMailAccount)
+			}
+		}
+
+		// Digest release links are authenticated by their signed token. GET only
+		// asks for confirmation, the release itself needs a POST.
+		api.GET("/quarantine/release", controllers.ConfirmQuarantineRelease)
+		api.POST("/quarantine/release", controllers.ReleaseQuarantineWithToken)
+
+		quarantine := api.Group("/quarantine", middleware.AuthMiddleware())
+		{
+			quarantine.GET("", controllers.ListQuarantine)
+			quarantine.GET("/:id/preview", controllers.GetQuarantinePreview)
+			quarantine.POST("/:id/release", controllers.ReleaseQuarantine)
+			quarantine.POST("/:id/release-allow", controllers.ReleaseAndAllowQuarantine)
+			quarantine.DELETE("/:id", controllers.DeleteQuarantine)
+		}
+
+		mail := api.Group("/mail", middleware.AuthMiddleware())
+		{
+			mail.GET("/folders", controllers.ListMailFolders)
+			mail.POST("/folders", controllers.CreateMailFolder)
+			mail.POST("/folders/rename", controllers.RenameMailFolder)
+			mail.POST("/folders/subscribe", controllers.SubscribeMailFolder)
+			mail.POST("/folders/empty", controllers.EmptyMailFolder)
+			mail.POST("/folders/delete", controllers.DeleteMailFolder)
+			mail.GET("/messages", controllers.ListMailMessages)
+			mail.POST("/messages/query", controllers.QueryMailMessages)
+			mail.GET("/messages/:id", controllers.GetMailMessage)
+			mail.POST("/messages/actions", controllers.ApplyMailAction)
+			mail.POST("/messages/move", controllers.MoveMailMessages)
+			mail.PUT("/messages/labels", controllers.SetMailLabels)
+			mail.POST("/search", controllers.SearchMail)
+			mail.GET("/threads", controllers.ListMailThreads)
+			mail.POST("/threads/get", controllers.GetMailThreads)
+			mail.GET("/threads/settings", controllers.GetMailThreadSettings)
+			mail.PUT("/threads/settings", controllers.UpdateMailThreadSettings)
+			mail.GET("/threads/:id", controllers.GetMailThread)
+			mail.POST("/messages/:id/reply", controllers.ReplyMailMessage)
+			mail.POST("/messages/:id/reply-all", controllers.ReplyAllMailMessage)
+			mail.POST("/messages/:id/forward", controllers.ForwardMailMessage)
+			mail.GET("/drafts", controllers.ListMailDrafts)
+			mail.POST("/drafts", controllers.CreateMailDraft)
+			mail.GET("/drafts/:id", controllers.GetMailDraft)
+			mail.PUT("/drafts/:id", controllers.UpdateMailDraft)
+			mail.PATCH("/drafts/:id", controllers.AutosaveMailDraft)
+			mail.DELETE("/drafts/:id", controllers.DeleteMailDraft)
+			mail.POST("/drafts/:id/send", controllers.SendMailDraft)
+			mail.POST("/drafts/:id/schedule", controllers.ScheduleMailDraft)
+			mail.POST("/send", controllers.SendMail)
+			mail.GET("/scheduled", controllers.ListMailScheduled)
+			mail.GET("/scheduled/:id", controllers.GetMailScheduled)
+			mail.PATCH("/scheduled/:id", controllers.UpdateMailScheduled)
+			mail.POST("/scheduled/:id/cancel", controllers.CancelMailScheduled)
+			mail.GET("/identities", controllers.ListMailIdentities)
+			mail.POST("/identities", controllers.CreateMailIdentity)
+			mail.GET("/identities/pending", controllers.ListPendingMailIdentities)
+			mail.GET("/identities/:id", controllers.GetMailIdentity)
+			mail.PATCH("/identities/:id", controllers.UpdateMailIdentity)
+			mail.DELETE("/identities/:id", controllers.DeleteMailIdentity)
+			mail.POST("/identities/:id/review", controllers.ReviewMailIdentity)
+			mail.GET("/delegations", controllers.ListMailDelegations)
+			mail.POST("/delegations", controllers.GrantMailDelegation)
+			mail.DELETE("/delegations/:id", controllers.RevokeMailDelegation)
+			mail.GET("/senders", controllers.ListMailSenders)
+			mail.GET("/shared-mailboxes", controllers.ListMailSharedMailboxes)
+			mail.POST("/shared-mailboxes", controllers.CreateMailSharedMailbox)
+			mail.GET("/groups", controllers.ListMailGroups)
+			mail.POST("/groups", controllers.CreateMailGroup)
+			mail.DELETE("/groups/:id", controllers.DeleteMailGroup)
+			mail.GET("/groups/:id/members", controllers.ListMailGroupMembers)
+			mail.POST("/groups/:id/members", controllers.AddMailGroupMember)
+			mail.DELETE("/groups/:id/members/:userId", controllers.RemoveMailGroupMember)
+			mail.GET("/folders/:id/acl", controllers.GetMailFolderACL)
+			mail.PUT("/folders/:id/acl", controllers.SetMailFolderACL)
+			mail.DELETE("/folders/:id/acl/:identifier", controllers.DeleteMailFolderACL)
+			mail.GET("/folders/:id/myrights", controllers.GetMailFolderMyRights)
+			mail.GET("/folders/:id/rights", controllers.ListMailFolderRights)
+			mail.GET("/acl/audit", controllers.ListMailACLAudit)
 		}
 
 		applications := api.Group("/applications")
@@ -445,6 +601,7 @@ func SetupRoutes(r *gin.Engine) {
 		}
 	}
 
+	r.GET("/.well-known/mta-sts.txt", controllers.ServeMTASTSPolicy)
 	r.GET("/health", controllers.HealthCheck)
 	r.GET("/ready", controllers.ReadyCheck)
 }
\ No newline at end of file
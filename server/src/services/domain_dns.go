@@ -0,0 +1,421 @@
+package services
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+
+	"github.com/skygenesisenterprise/aether-mailer/server/src/config"
+	"github.com/skygenesisenterprise/aether-mailer/server/src/models"
+)
+
+// DNSRecord représente un enregistrement DNS recommandé pour un domaine géré
+type DNSRecord struct {
+	Type     string `json:"type"` // MX, TXT, CNAME ou SRV
+	Name     string `json:"name"` // nom absolu, terminé par un point
+	Value    string `json:"value"`
+	Priority int    `json:"priority,omitempty"` // MX et SRV
+	Weight   int    `json:"weight,omitempty"`   // SRV
+	Port     int    `json:"port,omitempty"`     // SRV
+	TTL      int    `json:"ttl"`
+	Purpose  string `json:"purpose"` // mx, spf, dkim, dmarc, mta-sts, tls-rpt, autoconfig, srv
+}
+
+// DNSRecordCheck représente l'écart entre un enregistrement attendu et le DNS publié
+type DNSRecordCheck struct {
+	Record DNSRecord `json:"record"`
+	Status string    `json:"status"` // ok, missing ou mismatch
+	Found  []string  `json:"found"`
+}
+
+// Statuts de vérification des enregistrements DNS
+const (
+	DNSRecordOK       = "ok"
+	DNSRecordMissing  = "missing"
+	DNSRecordMismatch = "mismatch"
+)
+
+// DNSRecordOptions regroupe les paramètres de génération des enregistrements DNS
+type DNSRecordOptions struct {
+	MailHostname  string
+	MXHosts       []string
+	SPFIncludes   []string
+	SPFAddresses  []string
+	MTASTSMode    string
+	MTASTSMaxAge  int
+	DKIMSelector  string
+	DKIMPublicKey string
+	DKIMKeys      []DKIMRecordKey // clés gérées du domaine, prioritaires sur DKIMSelector
+	DMARCPolicy   string
+	TTL           int
+}
+
+// DKIMRecordKey représente une clé DKIM gérée à publier sous son sélecteur
+type DKIMRecordKey struct {
+	Selector string
+	Value    string // valeur complète de l'enregistrement TXT
+}
+
+// DNSDefaults regroupe les options de génération lues dans la configuration ;
+// elles sont fixées une fois au démarrage
+var DNSDefaults DNSRecordOptions
+
+// Modes de politique MTA-STS
+const (
+	MTASTSModeEnforce = "enforce"
+	MTASTSModeTesting = "testing"
+	MTASTSModeNone    = "none"
+)
+
+// IsValidMTASTSMode indique si un mode de politique MTA-STS est reconnu
+func IsValidMTASTSMode(mode string) bool {
+	return mode == MTASTSModeEnforce || mode == MTASTSModeTesting || mode == MTASTSModeNone
+}
+
+// DNSLookup résout les enregistrements publiés. *net.Resolver la satisfait.
+type DNSLookup interface {
+	LookupMX(ctx context.Context, name string) ([]*net.MX, error)
+	LookupTXT(ctx context.Context, name string) ([]string, error)
+	LookupCNAME(ctx context.Context, host string) (string, error)
+	LookupSRV(ctx context.Context, service, proto, name string) (string, []*net.SRV, error)
+}
+
+// DNSRecordOptionsFromConfig construit les options de génération depuis la configuration
+func DNSRecordOptionsFromConfig(cfg *config.Config) DNSRecordOptions {
+	return DNSRecordOptions{
+		MailHostname:  cfg.MailHostname,
+		MXHosts:       cfg.MailMXHosts,
+		SPFIncludes:   cfg.MailSPFIncludes,
+		SPFAddresses:  cfg.MailSPFAddresses,
+		MTASTSMode:    cfg.MTASTSMode,
+		MTASTSMaxAge:  cfg.MTASTSMaxAge,
+		DKIMSelector:  cfg.DKIMSelector,
+		DKIMPublicKey: cfg.DKIMPublicKey,
+		DMARCPolicy:   cfg.DMARCPolicy,
+		TTL:           cfg.DNSRecordTTL,
+	}
+}
+
+// ForDomain applique aux options les paramètres propres à un domaine
+func (opts DNSRecordOptions) ForDomain(settings *models.DomainSettings) DNSRecordOptions {
+	if settings != nil && settings.MTASTSMode != nil {
+		opts.MTASTSMode = *settings.MTASTSMode
+	}
+	return opts
+}
+
+// GenerateDNSRecords génère l'ensemble des enregistrements DNS recommandés pour un domaine
+func (s *DomainService) GenerateDNSRecords(domain *models.Domain, opts DNSRecordOptions) []DNSRecord {
+	name := strings.ToLower(strings.TrimSuffix(domain.Name, "."))
+	zone := fqdn(name)
+	host := fqdn(opts.MailHostname)
+	ttl := opts.TTL
+	if ttl <= 0 {
+		ttl = 3600
+	}
+
+	records := []DNSRecord{}
+	for i, mx := range opts.MXHosts {
+		records = append(records, DNSRecord{Type: "MX", Name: zone, Value: fqdn(mx), Priority: (i + 1) * 10, TTL: ttl, Purpose: "mx"})
+	}
+
+	// SPF : les MX du domaine, les adresses d'envoi puis les inclusions
+	spf := []string{"v=spf1", "mx"}
+	for _, addr := range opts.SPFAddresses {
+		ip := net.ParseIP(addr)
+		switch {
+		case ip == nil:
+			continue
+		case ip.To4() != nil:
+			spf = append(spf, "ip4:"+ip.String())
+		default:
+			spf = append(spf, "ip6:"+ip.String())
+		}
+	}
+	for _, include := range opts.SPFIncludes {
+		spf = append(spf, "include:"+strings.TrimSuffix(include, "."))
+	}
+	spf = append(spf, "~all")
+	records = append(records, DNSRecord{Type: "TXT", Name: zone, Value: strings.Join(spf, " "), TTL: ttl, Purpose: "spf"})
+
+	// Pendant une rotation, l'ancien et le nouveau sélecteur sont publiés ensemble
+	for _, key := range opts.DKIMKeys {
+		records = append(records, DNSRecord{
+			Type:    "TXT",
+			Name:    fqdn(key.Selector + "._domainkey." + name),
+			Value:   key.Value,
+			TTL:     ttl,
+			Purpose: "dkim",
+		})
+	}
+	if len(opts.DKIMKeys) == 0 && opts.DKIMSelector != "" && opts.DKIMPublicKey != "" {
+		records = append(records, DNSRecord{
+			Type:    "TXT",
+			Name:    fqdn(opts.DKIMSelector + "._domainkey." + name),
+			Value:   "v=DKIM1; k=rsa; p=" + opts.DKIMPublicKey,
+			TTL:     ttl,
+			Purpose: "dkim",
+		})
+	}
+
+	dmarcPolicy := opts.DMARCPolicy
+	if dmarcPolicy == "" {
+		dmarcPolicy = "quarantine"
+	}
+	records = append(records, DNSRecord{
+		Type:    "TXT",
+		Name:    fqdn("_dmarc." + name),
+		Value:   fmt.Sprintf("v=DMARC1; p=%s; rua=mailto:dmarc-reports@%s; adkim=r; aspf=r", dmarcPolicy, name),
+		TTL:     ttl,
+		Purpose: "dmarc",
+	})
+
+	// MTA-STS (RFC 8461) : l'identifiant change avec le contenu de la politique
+	records = append(records,
+		DNSRecord{Type: "TXT", Name: fqdn("_mta-sts." + name), Value: "v=STSv1; id=" + MTASTSPolicyID(opts), TTL: ttl, Purpose: "mta-sts"},
+		DNSRecord{Type: "CNAME", Name: fqdn("mta-sts." + name), Value: host, TTL: ttl, Purpose: "mta-sts"},
+		DNSRecord{Type: "TXT", Name: fqdn("_smtp._tls." + name), Value: "v=TLSRPTv1; rua=mailto:tls-reports@" + name, TTL: ttl, Purpose: "tls-rpt"},
+	)
+
+	// Configuration automatique des clients (Thunderbird, Outlook)
+	records = append(records,
+		DNSRecord{Type: "CNAME", Name: fqdn("autoconfig." + name), Value: host, TTL: ttl, Purpose: "autoconfig"},
+		DNSRecord{Type: "CNAME", Name: fqdn("autodiscover." + name), Value: host, TTL: ttl, Purpose: "autoconfig"},
+	)
+
+	// Enregistrements SRV (RFC 6186, RFC 8314)
+	srv := []struct {
+		service string
+		port    int
+	}{
+		{"_submissions._tcp", 465},
+		{"_submission._tcp", 587},
+		{"_imaps._tcp", 993},
+		{"_pop3s._tcp", 995},
+		{"_autodiscover._tcp", 443},
+	}
+	for _, entry := range srv {
+		records = append(records, DNSRecord{
+			Type:     "SRV",
+			Name:     fqdn(entry.service + "." + name),
+			Value:    host,
+			Priority: 0,
+			Weight:   1,
+			Port:     entry.port,
+			TTL:      ttl,
+			Purpose:  "srv",
+		})
+	}
+
+	return records
+}
+
+// FormatBINDZone formate les enregistrements sous forme d'extrait de zone BIND
+func FormatBINDZone(domain *models.Domain, records []DNSRecord) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "; Enregistrements recommandés pour %s\n", domain.Name)
+	for _, record := range records {
+		var value string
+		switch record.Type {
+		case "MX":
+			value = fmt.Sprintf("%d %s", record.Priority, record.Value)
+		case "SRV":
+			value = fmt.Sprintf("%d %d %d %s", record.Priority, record.Weight, record.Port, record.Value)
+		case "TXT":
+			value = quoteTXT(record.Value)
+		default:
+			value = record.Value
+		}
+		fmt.Fprintf(&b, "%s\t%d\tIN\t%s\t%s\n", record.Name, record.TTL, record.Type, value)
+	}
+	return b.String()
+}
+
+// MTASTSPolicyText retourne la politique MTA-STS servie sur mta-sts.<domaine>
+func MTASTSPolicyText(opts DNSRecordOptions) string {
+	mode := opts.MTASTSMode
+	if !IsValidMTASTSMode(mode) {
+		mode = MTASTSModeTesting
+	}
+	maxAge := opts.MTASTSMaxAge
+	if maxAge <= 0 {
+		maxAge = 604800
+	}
+
+	var b strings.Builder
+	b.WriteString("version: STSv1\r\n")
+	fmt.Fprintf(&b, "mode: %s\r\n", mode)
+	for _, mx := range opts.MXHosts {
+		fmt.Fprintf(&b, "mx: %s\r\n", strings.TrimSuffix(strings.ToLower(mx), "."))
+	}
+	fmt.Fprintf(&b, "max_age: %d\r\n", maxAge)
+	return b.String()
+}
+
+// MTASTSPolicyID dérive l'identifiant de politique publié dans _mta-sts du contenu de la politique
+func MTASTSPolicyID(opts DNSRecordOptions) string {
+	sum := sha256.Sum256([]byte(MTASTSPolicyText(opts)))
+	return hex.EncodeToString(sum[:10])
+}
+
+// CheckDNSRecords compare le DNS publié aux enregistrements attendus et signale les écarts
+func (s *DomainService) CheckDNSRecords(ctx context.Context, records []DNSRecord, resolver DNSLookup) []DNSRecordCheck {
+	checks := make([]DNSRecordCheck, 0, len(records))
+	for _, record := range records {
+		var check DNSRecordCheck
+		switch record.Type {
+		case "MX":
+			check = checkMXRecord(ctx, record, resolver)
+		case "TXT":
+			check = checkTXTRecord(ctx, record, resolver)
+		case "CNAME":
+			check = checkCNAMERecord(ctx, record, resolver)
+		case "SRV":
+			check = checkSRVRecord(ctx, record, resolver)
+		}
+		if check.Found == nil {
+			check.Found = []string{}
+		}
+		checks = append(checks, check)
+	}
+	return checks
+}
+
+func checkMXRecord(ctx context.Context, record DNSRecord, resolver DNSLookup) DNSRecordCheck {
+	check := DNSRecordCheck{Record: record, Status: DNSRecordMissing}
+	mxs, _ := resolver.LookupMX(ctx, record.Name)
+	for _, mx := range mxs {
+		check.Found = append(check.Found, fmt.Sprintf("%d %s", mx.Pref, strings.ToLower(fqdn(mx.Host))))
+		if strings.EqualFold(fqdn(mx.Host), record.Value) {
+			check.Status = DNSRecordOK
+		}
+	}
+	if check.Status != DNSRecordOK && len(check.Found) > 0 {
+		check.Status = DNSRecordMismatch
+	}
+	return check
+}
+
+// checkTXTRecord compare les enregistrements TXT de même type (v=spf1, v=DMARC1...).
+// Pour DKIM, seule la clé publique est comparée.
+func checkTXTRecord(ctx context.Context, record DNSRecord, resolver DNSLookup) DNSRecordCheck {
+	check := DNSRecordCheck{Record: record, Status: DNSRecordMissing}
+	values, _ := resolver.LookupTXT(ctx, record.Name)
+	version := txtVersion(record.Value)
+	for _, value := range values {
+		if !strings.EqualFold(txtVersion(value), version) {
+			continue
+		}
+		check.Found = append(check.Found, value)
+		match := normalizeTXT(value) == normalizeTXT(record.Value)
+		if record.Purpose == "dkim" {
+			match = txtTag(value, "p") == txtTag(record.Value, "p")
+		}
+		if match {
+			check.Status = DNSRecordOK
+		}
+	}
+	if check.Status != DNSRecordOK && len(check.Found) > 0 {
+		check.Status = DNSRecordMismatch
+	}
+	// SPF et MTA-STS exigent un enregistrement unique
+	if check.Status == DNSRecordOK && len(check.Found) > 1 && (record.Purpose == "spf" || record.Purpose == "mta-sts") {
+		check.Status = DNSRecordMismatch
+	}
+	return check
+}
+
+func checkCNAMERecord(ctx context.Context, record DNSRecord, resolver DNSLookup) DNSRecordCheck {
+	check := DNSRecordCheck{Record: record, Status: DNSRecordMissing}
+	target, err := resolver.LookupCNAME(ctx, record.Name)
+	if err != nil || strings.EqualFold(fqdn(target), record.Name) {
+		return check
+	}
+	check.Found = []string{strings.ToLower(fqdn(target))}
+	check.Status = DNSRecordMismatch
+	if strings.EqualFold(fqdn(target), record.Value) {
+		check.Status = DNSRecordOK
+	}
+	return check
+}
+
+func checkSRVRecord(ctx context.Context, record DNSRecord, resolver DNSLookup) DNSRecordCheck {
+	check := DNSRecordCheck{Record: record, Status: DNSRecordMissing}
+	_, srvs, _ := resolver.LookupSRV(ctx, "", "", record.Name)
+	for _, srv := range srvs {
+		check.Found = append(check.Found, fmt.Sprintf("%d %d %d %s", srv.Priority, srv.Weight, srv.Port, strings.ToLower(fqdn(srv.Target))))
+		if strings.EqualFold(fqdn(srv.Target), record.Value) && int(srv.Port) == record.Port {
+			check.Status = DNSRecordOK
+		}
+	}
+	if check.Status != DNSRecordOK && len(check.Found) > 0 {
+		check.Status = DNSRecordMismatch
+	}
+	return check
+}
+
+// fqdn retourne le nom en minuscules terminé par un point
+func fqdn(name string) string {
+	return strings.ToLower(strings.TrimSuffix(name, ".")) + "."
+}
+
+// txtVersion retourne la première balise d'un enregistrement TXT (v=spf1, v=DMARC1...)
+func txtVersion(value string) string {
+	value = strings.TrimSpace(value)
+	if end := strings.IndexAny(value, "; "); end >= 0 {
+		return value[:end]
+	}
+	return value
+}
+
+// txtTag retourne la valeur d'une balise tag=valeur d'un enregistrement TXT
+func txtTag(value, tag string) string {
+	for _, field := range strings.Split(value, ";") {
+		key, val, ok := strings.Cut(strings.TrimSpace(field), "=")
+		if ok && strings.EqualFold(strings.TrimSpace(key), tag) {
+			return strings.Join(strings.Fields(val), "")
+		}
+	}
+	return ""
+}
+
+// normalizeTXT supprime les espaces non significatifs d'un enregistrement TXT.
+// Les mécanismes SPF sont comparés sans tenir compte de leur ordre.
+func normalizeTXT(value string) string {
+	if strings.HasPrefix(strings.ToLower(strings.TrimSpace(value)), "v=spf1") {
+		terms := strings.Fields(strings.ToLower(value))
+		sort.Strings(terms[1:])
+		return strings.Join(terms, " ")
+	}
+	fields := []string{}
+	for _, field := range strings.Split(value, ";") {
+		if field = strings.TrimSpace(field); field != "" {
+			fields = append(fields, field)
+		}
+	}
+	return strings.Join(fields, "; ")
+}
+
+// quoteTXT découpe une valeur TXT en chaînes de 255 octets au plus
+func quoteTXT(value string) string {
+	parts := []string{}
+	for {
+		chunk := value
+		if len(chunk) > 255 {
+			chunk = value[:255]
+		}
+		value = value[len(chunk):]
+		parts = append(parts, `"`+strings.ReplaceAll(strings.ReplaceAll(chunk, `\`, `\\`), `"`, `\"`)+`"`)
+		if value == "" {
+			break
+		}
+	}
+	if len(parts) == 1 {
+		return parts[0]
+	}
+	return "( " + strings.Join(parts, " ") + " )"
+}
@@ -86,27 +86,6 @@ func (s *DomainService) DeleteDomain(id string) error {
 	return s.DB.Delete(&models.Domain{}, "id = ?", id).Error
 }
 
-// VerifyDomain vérifie un domaine
-func (s *DomainService) VerifyDomain(domainID string, method string, value string) error {
-	// Créer ou mettre à jour la vérification
-	token, err := GenerateRandomString(32)
-	if err != nil {
-		return err
-	}
-
-	now := time.Now()
-	verification := &models.DomainVerification{
-		DomainID:   domainID,
-		Method:     method,
-		Value:      value,
-		Token:      token,
-		IsVerified: true,
-		VerifiedAt: &now,
-	}
-
-	return s.DB.Save(verification).Error
-}
-
 // AddUserToDomain ajoute un utilisateur à un domaine
 func (s *DomainService) AddUserToDomain(domainID string, userID string, isAdmin bool, isOwner bool) error {
 	domainUser := &models.UserDomain{
@@ -198,16 +177,53 @@ func (s *DomainService) GetDomainSettings(domainID string) (*models.DomainSettin
 	return &settings, nil
 }
 
-// IsDomainActive vérifie si un domaine est actif
+// SetMTASTSMode fixe le mode de la politique MTA-STS publiée pour un domaine ;
+// un mode vide rétablit celui de la configuration
+func (s *DomainService) SetMTASTSMode(domainID, mode string) (*models.DomainSettings, error) {
+	var settings models.DomainSettings
+	err := s.DB.Where("domain_id = ?", domainID).First(&settings).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		settings = models.DomainSettings{DomainID: domainID}
+	} else if err != nil {
+		return nil, err
+	}
+
+	settings.MTASTSMode = nil
+	if mode != "" {
+		settings.MTASTSMode = &mode
+	}
+	if err := s.DB.Save(&settings).Error; err != nil {
+		return nil, err
+	}
+	return &settings, nil
+}
+
+// RoutableDomains restreint une requête aux domaines pouvant recevoir du courrier :
+// actifs et dont la propriété est vérifiée, ou internes
+func RoutableDomains(db *gorm.DB) *gorm.DB {
+	return db.Where("is_active = true AND (is_verified = true OR is_internal = true)")
+}
+
+// IsDomainActive vérifie si un domaine est actif et vérifié
 func (s *DomainService) IsDomainActive(domainName string) (bool, error) {
 	var domain models.Domain
-	err := s.DB.Where("name = ? AND is_active = true", domainName).First(&domain).Error
+	err := s.DB.Scopes(RoutableDomains).Where("name = ?", domainName).First(&domain).Error
 	if err != nil {
 		return false, err
 	}
 	return true, nil
 }
 
+// GetActiveDomainByName récupère un domaine actif et vérifié par son nom
+func (s *DomainService) GetActiveDomainByName(domainName string) (*models.Domain, error) {
+	var domain models.Domain
+	err := s.DB.Scopes(RoutableDomains).Preload("Settings").Where("name = ?", domainName).First(&domain).Error
+	if err != nil {
+		return nil, err
+	}
+	return &domain, nil
+}
+
 // IsEmailFromManagedDomain vérifie si une adresse email appartient à un domaine géré
 func (s *DomainService) IsEmailFromManagedDomain(email string) (bool, *models.Domain, error) {
 	// Extraire le domaine de l'email
@@ -218,7 +234,7 @@ func (s *DomainService) IsEmailFromManagedDomain(email string) (bool, *models.Do
 	domainName := parts[1]
 
 	var domain models.Domain
-	err := s.DB.Where("name = ? AND is_active = true", domainName).First(&domain).Error
+	err := s.DB.Scopes(RoutableDomains).Where("name = ?", domainName).First(&domain).Error
 	if err != nil {
 		return false, nil, err
 	}
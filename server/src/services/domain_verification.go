@@ -0,0 +1,463 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/skygenesisenterprise/aether-mailer/server/src/config"
+	"github.com/skygenesisenterprise/aether-mailer/server/src/models"
+	"gorm.io/gorm"
+)
+
+// Préfixes et chemins publiés pour la vérification de propriété
+const (
+	DomainVerificationTXTPrefix   = "aether-mailer-verification="
+	DomainVerificationCNAMEPrefix = "am-"
+	DomainVerificationFilePath    = "/.well-known/aether-mailer-verification.txt"
+)
+
+// Taille maximale lue du fichier de vérification
+const maxVerificationFileSize = 4096
+
+// ErrUnsupportedVerificationMethod est retournée pour une méthode de vérification inconnue
+var ErrUnsupportedVerificationMethod = errors.New("unsupported verification method")
+
+// ErrVerificationNotStarted est retournée quand aucune vérification n'a été demandée pour le domaine
+var ErrVerificationNotStarted = errors.New("domain verification not started")
+
+// ErrNonPublicAddress est retournée quand le fichier de vérification est servi par une adresse non publique
+var ErrNonPublicAddress = errors.New("verification host resolves to a non-public address")
+
+// HTTPDoer exécute les requêtes HTTP de vérification. *http.Client la satisfait.
+type HTTPDoer interface {
+	Do(req *http.Request) (*http.Response, error)
+}
+
+// DomainVerificationOptions regroupe les paramètres de vérification des domaines
+type DomainVerificationOptions struct {
+	MailHostname     string        // cible des enregistrements CNAME de vérification
+	ReverifyInterval time.Duration // délai avant la revérification d'un domaine vérifié
+	RetryInterval    time.Duration // délai entre deux vérifications d'un domaine en attente
+	MaxFailures      int           // échecs consécutifs avant la perte du statut vérifié
+	MaxPendingAge    time.Duration // durée en attente après laquelle un domaine n'est plus revérifié, 0 sans limite
+}
+
+// DomainVerificationInstructions décrit ce que le propriétaire doit publier
+type DomainVerificationInstructions struct {
+	Method     string `json:"method"`
+	RecordType string `json:"recordType,omitempty"` // TXT ou CNAME
+	Name       string `json:"name,omitempty"`
+	Value      string `json:"value,omitempty"`
+	URL        string `json:"url,omitempty"`
+	Content    string `json:"content,omitempty"`
+}
+
+// DomainVerificationService vérifie la propriété des domaines par DNS ou HTTP
+type DomainVerificationService struct {
+	DB       *gorm.DB
+	Resolver DNSLookup
+	Client   HTTPDoer
+	Options  DomainVerificationOptions
+}
+
+// NewDomainVerificationService crée une nouvelle instance de DomainVerificationService
+func NewDomainVerificationService(db *gorm.DB, resolver DNSLookup, client HTTPDoer, opts DomainVerificationOptions) *DomainVerificationService {
+	return &DomainVerificationService{
+		DB:       db,
+		Resolver: resolver,
+		Client:   client,
+		Options:  opts,
+	}
+}
+
+// DomainVerificationOptionsFromConfig construit les options de vérification depuis la configuration
+func DomainVerificationOptionsFromConfig(cfg *config.Config) DomainVerificationOptions {
+	return DomainVerificationOptions{
+		MailHostname:     cfg.MailHostname,
+		ReverifyInterval: time.Duration(cfg.DomainVerifyInterval) * time.Second,
+		RetryInterval:    time.Duration(cfg.DomainVerifyRetry) * time.Second,
+		MaxFailures:      cfg.DomainVerifyMaxFails,
+		MaxPendingAge:    time.Duration(cfg.DomainVerifyMaxAge) * time.Second,
+	}
+}
+
+// NewDomainVerificationHTTPClient crée un client HTTP qui ne suit que les
+// redirections restant sur le domaine vérifié ou l'un de ses sous-domaines.
+// Le client ne passe par aucun proxy et refuse de se connecter à une adresse
+// non publique, pour qu'un domaine ne puisse pas faire sonder le réseau interne.
+func NewDomainVerificationHTTPClient(timeout time.Duration) *http.Client {
+	dialer := &net.Dialer{
+		Timeout: timeout,
+		// Control reçoit l'adresse résolue : le contrôle couvre aussi les
+		// noms qui changent de résolution entre deux requêtes
+		Control: func(network, address string, _ syscall.RawConn) error {
+			addrPort, err := netip.ParseAddrPort(address)
+			if err != nil {
+				return err
+			}
+			if !isPublicAddress(addrPort.Addr()) {
+				return fmt.Errorf("%w: %s", ErrNonPublicAddress, addrPort.Addr())
+			}
+			return nil
+		},
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = nil
+	transport.DialContext = dialer.DialContext
+
+	return &http.Client{
+		Timeout:   timeout,
+		Transport: transport,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) >= 5 {
+				return errors.New("too many redirects")
+			}
+			origin := strings.ToLower(via[0].URL.Hostname())
+			host := strings.ToLower(req.URL.Hostname())
+			if req.URL.Scheme != "https" || (host != origin && !strings.HasSuffix(host, "."+origin)) {
+				return fmt.Errorf("redirect to %s leaves the verified domain", req.URL.Host)
+			}
+			return nil
+		},
+	}
+}
+
+// StartVerification émet un nouveau jeton de vérification pour un domaine.
+// Le statut vérifié du domaine est conservé jusqu'au prochain contrôle.
+func (s *DomainVerificationService) StartVerification(domainID string, method string) (*models.DomainVerification, error) {
+	if !isSupportedVerificationMethod(method) {
+		return nil, ErrUnsupportedVerificationMethod
+	}
+
+	var domain models.Domain
+	if err := s.DB.First(&domain, "id = ?", domainID).Error; err != nil {
+		return nil, err
+	}
+
+	token, err := GenerateRandomString(16)
+	if err != nil {
+		return nil, err
+	}
+
+	verification, err := s.GetVerification(domainID)
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, err
+	}
+	if verification == nil {
+		verification = &models.DomainVerification{DomainID: domainID}
+	}
+
+	now := time.Now()
+	verification.Method = method
+	verification.Token = token
+	verification.Value = s.Instructions(&domain, verification).Value
+	verification.IsVerified = domain.IsVerified
+	verification.ConsecutiveFailures = 0
+	verification.LastError = nil
+	verification.NextCheckAt = &now
+	verification.PendingSince = nil
+	if !domain.IsVerified {
+		verification.PendingSince = &now
+	}
+
+	if err := s.DB.Save(verification).Error; err != nil {
+		return nil, err
+	}
+	return verification, nil
+}
+
+// GetVerification récupère la vérification en cours d'un domaine
+func (s *DomainVerificationService) GetVerification(domainID string) (*models.DomainVerification, error) {
+	var verification models.DomainVerification
+	err := s.DB.Where("domain_id = ?", domainID).Order("created_at DESC").First(&verification).Error
+	if err != nil {
+		return nil, err
+	}
+	return &verification, nil
+}
+
+// GetAttempts récupère l'historique des tentatives d'une vérification, les plus récentes d'abord
+func (s *DomainVerificationService) GetAttempts(verificationID string, limit int) ([]models.DomainVerificationAttempt, error) {
+	var attempts []models.DomainVerificationAttempt
+	err := s.DB.Where("verification_id = ?", verificationID).
+		Order("checked_at DESC").Limit(limit).Find(&attempts).Error
+	if err != nil {
+		return nil, err
+	}
+	return attempts, nil
+}
+
+// Instructions retourne l'enregistrement ou le fichier à publier pour une vérification
+func (s *DomainVerificationService) Instructions(domain *models.Domain, verification *models.DomainVerification) DomainVerificationInstructions {
+	instructions := DomainVerificationInstructions{Method: verification.Method}
+	switch verification.Method {
+	case models.DomainVerificationDNS:
+		instructions.RecordType = "TXT"
+		instructions.Name = fqdn(domain.Name)
+		instructions.Value = DomainVerificationTXTPrefix + verification.Token
+	case models.DomainVerificationCNAME:
+		instructions.RecordType = "CNAME"
+		instructions.Name = fqdn(DomainVerificationCNAMEPrefix + verification.Token + "." + domain.Name)
+		instructions.Value = fqdn("verify." + s.Options.MailHostname)
+	case models.DomainVerificationFile:
+		instructions.URL = "https://" + domain.Name + DomainVerificationFilePath
+		instructions.Content = verification.Token
+		instructions.Value = verification.Token
+	}
+	return instructions
+}
+
+// CheckVerification contrôle la publication du jeton, enregistre la tentative et
+// met à jour le statut du domaine. Un domaine vérifié ne perd son statut
+// qu'après MaxFailures échecs consécutifs, pour tolérer une panne DNS passagère.
+func (s *DomainVerificationService) CheckVerification(ctx context.Context, domainID string) (*models.DomainVerification, error) {
+	var domain models.Domain
+	if err := s.DB.First(&domain, "id = ?", domainID).Error; err != nil {
+		return nil, err
+	}
+
+	verification, err := s.GetVerification(domainID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrVerificationNotStarted
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	found, checkErr := s.check(ctx, &domain, verification)
+
+	now := time.Now()
+	attempt := &models.DomainVerificationAttempt{
+		VerificationID: verification.ID,
+		DomainID:       domain.ID,
+		Method:         verification.Method,
+		Success:        checkErr == nil,
+		Found:          strings.Join(found, "\n"),
+		CheckedAt:      now,
+	}
+
+	verification.Attempts++
+	verification.LastAttemptAt = &now
+	if checkErr == nil {
+		next := now.Add(s.Options.ReverifyInterval)
+		verification.IsVerified = true
+		verification.VerifiedAt = &now
+		verification.ConsecutiveFailures = 0
+		verification.LastError = nil
+		verification.NextCheckAt = &next
+		verification.PendingSince = nil
+
+		domain.IsVerified = true
+		domain.VerifiedAt = &now
+		domain.VerificationToken = &verification.Token
+	} else {
+		message := truncate(checkErr.Error(), 500)
+		attempt.Error = &message
+		verification.ConsecutiveFailures++
+		verification.LastError = &message
+
+		next := now.Add(s.Options.RetryInterval)
+		if verification.IsVerified && verification.ConsecutiveFailures < s.Options.MaxFailures {
+			// Revérifier plus tôt sans retirer le statut tant que le seuil n'est pas atteint
+			verification.NextCheckAt = &next
+		} else {
+			if verification.IsVerified || verification.PendingSince == nil {
+				// Le domaine vient de perdre son statut : l'attente commence maintenant
+				verification.PendingSince = &now
+			}
+			verification.IsVerified = false
+			verification.NextCheckAt = &next
+			domain.IsVerified = false
+			domain.VerifiedAt = nil
+
+			if s.pendingExpired(verification, now) {
+				// Ne plus revérifier : le propriétaire doit démarrer une nouvelle vérification
+				expired := truncate(message+"; verification abandoned after "+s.Options.MaxPendingAge.String()+" pending, start a new one", 500)
+				verification.LastError = &expired
+				verification.NextCheckAt = nil
+			}
+		}
+	}
+
+	err = s.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(attempt).Error; err != nil {
+			return err
+		}
+		if err := tx.Save(verification).Error; err != nil {
+			return err
+		}
+		return tx.Model(&domain).Select("is_verified", "verified_at", "verification_token").Updates(&domain).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return verification, nil
+}
+
+// CheckDueVerifications contrôle toutes les vérifications arrivées à échéance
+// et retourne le nombre de domaines contrôlés
+func (s *DomainVerificationService) CheckDueVerifications(ctx context.Context) (int, error) {
+	var due []models.DomainVerification
+	err := s.DB.Where("next_check_at IS NOT NULL AND next_check_at <= ?", time.Now()).
+		Order("next_check_at").Find(&due).Error
+	if err != nil {
+		return 0, err
+	}
+
+	checked := 0
+	for _, verification := range due {
+		if ctx.Err() != nil {
+			return checked, ctx.Err()
+		}
+		if _, err := s.CheckVerification(ctx, verification.DomainID); err != nil {
+			log.Printf("domain verification check failed for %s: %v", verification.DomainID, err)
+			continue
+		}
+		checked++
+	}
+	return checked, nil
+}
+
+// RunScheduler contrôle périodiquement les vérifications arrivées à échéance
+// jusqu'à l'annulation du contexte
+func (s *DomainVerificationService) RunScheduler(ctx context.Context, interval time.Duration) {
+	if interval <= 0 {
+		interval = time.Minute
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if _, err := s.CheckDueVerifications(ctx); err != nil && ctx.Err() == nil {
+			log.Printf("domain verification scheduler: %v", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// pendingExpired indique si une vérification en attente a dépassé MaxPendingAge
+func (s *DomainVerificationService) pendingExpired(verification *models.DomainVerification, now time.Time) bool {
+	if s.Options.MaxPendingAge <= 0 || verification.PendingSince == nil {
+		return false
+	}
+	return now.Sub(*verification.PendingSince) >= s.Options.MaxPendingAge
+}
+
+// check recherche le jeton publié et retourne les valeurs observées
+func (s *DomainVerificationService) check(ctx context.Context, domain *models.Domain, verification *models.DomainVerification) ([]string, error) {
+	expected := s.Instructions(domain, verification)
+	switch verification.Method {
+	case models.DomainVerificationDNS:
+		records, err := s.Resolver.LookupTXT(ctx, domain.Name)
+		if err != nil {
+			return nil, fmt.Errorf("TXT lookup failed: %w", err)
+		}
+		for _, record := range records {
+			if strings.TrimSpace(record) == expected.Value {
+				return records, nil
+			}
+		}
+		return records, errors.New("verification TXT record not found")
+
+	case models.DomainVerificationCNAME:
+		target, err := s.Resolver.LookupCNAME(ctx, expected.Name)
+		if err != nil {
+			return nil, fmt.Errorf("CNAME lookup failed: %w", err)
+		}
+		if !strings.EqualFold(fqdn(target), expected.Value) {
+			return []string{target}, fmt.Errorf("CNAME points to %s instead of %s", target, expected.Value)
+		}
+		return []string{target}, nil
+
+	case models.DomainVerificationFile:
+		content, err := s.fetchVerificationFile(ctx, expected.URL)
+		if err != nil {
+			return nil, err
+		}
+		if content != expected.Content {
+			return []string{content}, errors.New("verification file content does not match the token")
+		}
+		return []string{content}, nil
+	}
+	return nil, ErrUnsupportedVerificationMethod
+}
+
+// fetchVerificationFile télécharge le fichier de vérification publié par le domaine
+func (s *DomainVerificationService) fetchVerificationFile(ctx context.Context, url string) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return "", err
+	}
+	resp, err := s.Client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("fetching %s failed: %w", url, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("fetching %s returned HTTP %d", url, resp.StatusCode)
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxVerificationFileSize))
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(body)), nil
+}
+
+func isSupportedVerificationMethod(method string) bool {
+	switch method {
+	case models.DomainVerificationDNS, models.DomainVerificationCNAME, models.DomainVerificationFile:
+		return true
+	}
+	return false
+}
+
+// nonPublicPrefixes sont les plages réservées, partagées ou de documentation
+// que netip.Addr ne classe pas elle-même
+var nonPublicPrefixes = []netip.Prefix{
+	netip.MustParsePrefix("0.0.0.0/8"),
+	netip.MustParsePrefix("100.64.0.0/10"),
+	netip.MustParsePrefix("192.0.0.0/24"),
+	netip.MustParsePrefix("192.0.2.0/24"),
+	netip.MustParsePrefix("198.18.0.0/15"),
+	netip.MustParsePrefix("198.51.100.0/24"),
+	netip.MustParsePrefix("203.0.113.0/24"),
+	netip.MustParsePrefix("240.0.0.0/4"),
+	netip.MustParsePrefix("64:ff9b::/96"),
+	netip.MustParsePrefix("100::/64"),
+	netip.MustParsePrefix("2001:db8::/32"),
+}
+
+// isPublicAddress indique si une adresse est joignable sur Internet
+func isPublicAddress(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	if !addr.IsGlobalUnicast() || addr.IsPrivate() {
+		return false
+	}
+	for _, prefix := range nonPublicPrefixes {
+		if prefix.Contains(addr) {
+			return false
+		}
+	}
+	return true
+}
+
+func truncate(value string, max int) string {
+	if len(value) <= max {
+		return value
+	}
+	return value[:max]
+}
@@ -0,0 +1,32 @@
+package services
+
+import (
+	"github.com/skygenesisenterprise/aether-mailer/package/golang/service"
+)
+
+// MailerServices groups the mail services provided by the Go SDK
+type MailerServices struct {
+	Messages    *service.MessageService
+	Blobs       *service.BlobService
+	Quarantine  *service.QuarantineService
+	RateLimit   *service.RateLimitService
+	Delivery    *service.DeliveryService
+	IPPools     *service.IPPoolService
+	TLSPolicies *service.TLSPolicyService
+	DKIM        *service.DKIMService
+	Mailbox     *service.MailboxService
+	Search      *service.SearchService
+	Threads     *service.ThreadService
+	Drafts      *service.DraftService
+	Scheduled   *service.ScheduledSendService
+	Identities  *service.IdentityService
+	ACLs        *service.ACLService
+
+	relay   *service.OutboxRelay
+	reindex bool // the search index is rebuilt at startup
+}
+
+// Mailer holds the SDK services used by the mail endpoints. It is set at
+// startup by NewMailerServices and stays nil when the mailer repositories
+// cannot be opened, in which case the mail endpoints answer 503.
+var Mailer *MailerServices
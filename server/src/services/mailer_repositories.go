@@ -0,0 +1,235 @@
+package services
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+	sdkconfig "github.com/skygenesisenterprise/aether-mailer/package/golang/config"
+	"github.com/skygenesisenterprise/aether-mailer/package/golang/domain"
+	"github.com/skygenesisenterprise/aether-mailer/package/golang/repository"
+	"github.com/skygenesisenterprise/aether-mailer/package/golang/repository/inmemory"
+	"github.com/skygenesisenterprise/aether-mailer/package/golang/repository/maildir"
+	"github.com/skygenesisenterprise/aether-mailer/package/golang/repository/postgres"
+)
+
+// MailerRepositories holds the SDK repositories behind the mail services
+type MailerRepositories struct {
+	Users               repository.UserRepository
+	Domains             repository.DomainRepository
+	DomainMembers       repository.DomainMemberRepository
+	EmailAccounts       repository.EmailAccountRepository
+	EmailAliases        repository.EmailAliasRepository
+	DNSRecords          repository.DNSRecordRepository
+	Folders             repository.FolderRepository
+	Messages            repository.MessageRepository
+	Attachments         repository.AttachmentRepository
+	Quotas              repository.QuotaRepository
+	Policies            repository.PolicyRepository
+	Spam                repository.SpamRepository
+	Quarantine          repository.QuarantineRepository
+	RateCounters        repository.RateCounterStore
+	Suspensions         repository.SendingSuspensionRepository
+	DestinationPolicies repository.DestinationPolicyRepository
+	DeliveryJobs        repository.DeliveryJobRepository
+	IPPools             repository.IPPoolRepository
+	PoolAssignments     repository.PoolAssignmentRepository
+	MTASTSPolicies      repository.MTASTSPolicyRepository
+	TLSResults          repository.TLSResultRepository
+	DKIMKeys            repository.DKIMKeyRepository
+	DKIMRotationLog     repository.DKIMRotationLogRepository
+	Blobs               repository.BlobRepository
+	SearchIndex         repository.SearchIndex
+	Threads             repository.ThreadRepository
+	ScheduledSends      repository.ScheduledSendRepository
+	Identities          repository.IdentityRepository
+	SenderDelegations   repository.SenderDelegationRepository
+	Groups              repository.GroupRepository
+	FolderACLs          repository.FolderACLRepository
+	ACLAuditLog         repository.ACLAuditLogRepository
+	Events              domain.EventStore
+	Outbox              repository.OutboxRepository
+	Transactor          repository.Transactor
+
+	pool             *pgxpool.Pool
+	mailbox          string // backend of Messages
+	databaseMessages repository.MessageRepository
+	maildirPath      string
+}
+
+// OpenMailerRepositories creates the repositories selected by the database
+// driver: postgres connects to the configured database and applies the
+// pending migrations of MigrationPath, or of the SDK when it is empty;
+// memory keeps everything in the process and loses it on shutdown.
+// Messages are then kept in the mailbox backend of the storage settings.
+func OpenMailerRepositories(ctx context.Context, cfg *sdkconfig.Config) (*MailerRepositories, error) {
+	var repos *MailerRepositories
+	switch cfg.Database.Driver {
+	case "postgres":
+		var err error
+		if repos, err = openPostgresRepositories(ctx, &cfg.Database); err != nil {
+			return nil, err
+		}
+	case "memory":
+		repos = newInMemoryRepositories()
+	default:
+		return nil, fmt.Errorf("unsupported mailer database driver %q", cfg.Database.Driver)
+	}
+
+	repos.mailbox = "postgres"
+	repos.databaseMessages = repos.Messages
+	repos.maildirPath = cfg.Storage.MaildirPath
+	messages, err := repos.MailboxStore(cfg.Storage.Mailbox)
+	if err != nil {
+		repos.Close()
+		return nil, err
+	}
+	if cfg.Storage.Mailbox == "maildir" {
+		// The database index only covers messages stored in the database;
+		// maildir mailboxes are kept in a process index that the mail
+		// services rebuild at startup
+		repos.SearchIndex = inmemory.NewSearchIndex(messages)
+	}
+	repos.Messages = messages
+	repos.mailbox = cfg.Storage.Mailbox
+	return repos, nil
+}
+
+// MailboxStore returns the message store of a mailbox backend: the
+// database for postgres, Maildir++ directories under MaildirPath for
+// maildir. Only one maildir store may be open on the same directory.
+func (r *MailerRepositories) MailboxStore(backend string) (repository.MessageRepository, error) {
+	if backend == r.mailbox {
+		return r.Messages, nil
+	}
+	switch backend {
+	case "postgres":
+		return r.databaseMessages, nil
+	case "maildir":
+		messages, err := maildir.NewMessageRepository(r.maildirPath, r.Folders)
+		if err != nil {
+			return nil, fmt.Errorf("opening maildir mailboxes: %w", err)
+		}
+		return messages, nil
+	default:
+		return nil, fmt.Errorf("unsupported mailbox backend %q", backend)
+	}
+}
+
+// Close releases the database connections
+func (r *MailerRepositories) Close() {
+	if r.pool != nil {
+		r.pool.Close()
+	}
+}
+
+func openPostgresRepositories(ctx context.Context, cfg *sdkconfig.DatabaseConfig) (*MailerRepositories, error) {
+	poolConfig, err := pgxpool.ParseConfig(cfg.GetDSN())
+	if err != nil {
+		return nil, fmt.Errorf("invalid mailer database configuration: %w", err)
+	}
+	if cfg.MaxConnections > 0 {
+		poolConfig.MaxConns = int32(cfg.MaxConnections)
+	}
+	if cfg.MaxIdleTime > 0 {
+		poolConfig.MaxConnIdleTime = cfg.MaxIdleTime
+	}
+	if cfg.MaxLifetime > 0 {
+		poolConfig.MaxConnLifetime = cfg.MaxLifetime
+	}
+	pool, err := pgxpool.NewWithConfig(ctx, poolConfig)
+	if err != nil {
+		return nil, fmt.Errorf("connecting to the mailer database: %w", err)
+	}
+
+	migrator, err := postgres.NewMigrator(pool, cfg.MigrationPath)
+	if err == nil {
+		_, err = migrator.Up(ctx)
+	}
+	if err != nil {
+		pool.Close()
+		return nil, fmt.Errorf("migrating the mailer database: %w", err)
+	}
+
+	return &MailerRepositories{
+		Users:               postgres.NewUserRepository(pool),
+		Domains:             postgres.NewDomainRepository(pool),
+		DomainMembers:       postgres.NewDomainMemberRepository(pool),
+		EmailAccounts:       postgres.NewEmailAccountRepository(pool),
+		EmailAliases:        postgres.NewEmailAliasRepository(pool),
+		DNSRecords:          postgres.NewDNSRecordRepository(pool),
+		Folders:             postgres.NewFolderRepository(pool),
+		Messages:            postgres.NewMessageRepository(pool),
+		Attachments:         postgres.NewAttachmentRepository(pool),
+		Quotas:              postgres.NewQuotaRepository(pool),
+		Policies:            postgres.NewPolicyRepository(pool),
+		Spam:                postgres.NewSpamRepository(pool),
+		Quarantine:          postgres.NewQuarantineRepository(pool),
+		RateCounters:        postgres.NewRateCounterStore(pool),
+		Suspensions:         postgres.NewSendingSuspensionRepository(pool),
+		DestinationPolicies: postgres.NewDestinationPolicyRepository(pool),
+		DeliveryJobs:        postgres.NewDeliveryJobRepository(pool),
+		IPPools:             postgres.NewIPPoolRepository(pool),
+		PoolAssignments:     postgres.NewPoolAssignmentRepository(pool),
+		MTASTSPolicies:      postgres.NewMTASTSPolicyRepository(pool),
+		TLSResults:          postgres.NewTLSResultRepository(pool),
+		DKIMKeys:            postgres.NewDKIMKeyRepository(pool),
+		DKIMRotationLog:     postgres.NewDKIMRotationLogRepository(pool),
+		Blobs:               postgres.NewBlobRepository(pool),
+		SearchIndex:         postgres.NewSearchIndex(pool),
+		Threads:             postgres.NewThreadRepository(pool),
+		ScheduledSends:      postgres.NewScheduledSendRepository(pool),
+		Identities:          postgres.NewIdentityRepository(pool),
+		SenderDelegations:   postgres.NewSenderDelegationRepository(pool),
+		Groups:              postgres.NewGroupRepository(pool),
+		FolderACLs:          postgres.NewFolderACLRepository(pool),
+		ACLAuditLog:         postgres.NewACLAuditLogRepository(pool),
+		Events:              postgres.NewEventStore(pool),
+		Outbox:              postgres.NewOutboxRepository(pool),
+		Transactor:          postgres.NewTransactor(pool),
+		pool:                pool,
+	}, nil
+}
+
+func newInMemoryRepositories() *MailerRepositories {
+	store := inmemory.NewStore()
+	events := inmemory.NewEventStore()
+	messages := inmemory.NewMessageRepository(store)
+	return &MailerRepositories{
+		Users:               inmemory.NewUserRepository(store),
+		Domains:             inmemory.NewDomainRepository(store),
+		DomainMembers:       inmemory.NewDomainMemberRepository(store),
+		EmailAccounts:       inmemory.NewEmailAccountRepository(store),
+		EmailAliases:        inmemory.NewEmailAliasRepository(store),
+		DNSRecords:          inmemory.NewDNSRecordRepository(store),
+		Folders:             inmemory.NewFolderRepository(store),
+		Messages:            messages,
+		Attachments:         inmemory.NewAttachmentRepository(store),
+		Quotas:              inmemory.NewQuotaRepository(store),
+		Policies:            inmemory.NewPolicyRepository(store),
+		Spam:                inmemory.NewSpamRepository(store),
+		Quarantine:          inmemory.NewQuarantineRepository(store),
+		RateCounters:        inmemory.NewRateCounterStore(store),
+		Suspensions:         inmemory.NewSendingSuspensionRepository(store),
+		DestinationPolicies: inmemory.NewDestinationPolicyRepository(store),
+		DeliveryJobs:        inmemory.NewDeliveryJobRepository(store),
+		IPPools:             inmemory.NewIPPoolRepository(store),
+		PoolAssignments:     inmemory.NewPoolAssignmentRepository(store),
+		MTASTSPolicies:      inmemory.NewMTASTSPolicyRepository(store),
+		TLSResults:          inmemory.NewTLSResultRepository(store),
+		DKIMKeys:            inmemory.NewDKIMKeyRepository(store),
+		DKIMRotationLog:     inmemory.NewDKIMRotationLogRepository(store),
+		Blobs:               inmemory.NewBlobRepository(store),
+		SearchIndex:         inmemory.NewSearchIndex(messages),
+		Threads:             inmemory.NewThreadRepository(store),
+		ScheduledSends:      inmemory.NewScheduledSendRepository(store),
+		Identities:          inmemory.NewIdentityRepository(store),
+		SenderDelegations:   inmemory.NewSenderDelegationRepository(store),
+		Groups:              inmemory.NewGroupRepository(store),
+		FolderACLs:          inmemory.NewFolderACLRepository(store),
+		ACLAuditLog:         inmemory.NewACLAuditLogRepository(store),
+		Events:              events,
+		Outbox:              inmemory.NewOutboxRepository(store, events),
+		Transactor:          inmemory.NewTransactor(),
+	}
+}
@@ -0,0 +1,344 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+
+	sdkconfig "github.com/skygenesisenterprise/aether-mailer/package/golang/config"
+	"github.com/skygenesisenterprise/aether-mailer/package/golang/domain"
+	"github.com/skygenesisenterprise/aether-mailer/package/golang/service"
+	"github.com/skygenesisenterprise/aether-mailer/package/golang/storage"
+)
+
+// mailerMaxAttachments caps the attachments of one message; the SDK
+// configuration only limits their size
+const mailerMaxAttachments = 50
+
+// NewMailerServices builds the SDK mail services on the given repositories.
+// DKIM private keys are sealed with Security.EncryptionKey and quarantine
+// release links signed with Policies.Quarantine.TokenSecret, so both are
+// required.
+func NewMailerServices(cfg *sdkconfig.Config, repos *MailerRepositories) (*MailerServices, error) {
+	encrypter, err := service.NewAESGCMEncrypter(cfg.Security.EncryptionKey)
+	if err != nil {
+		return nil, fmt.Errorf("sealing DKIM keys: %w", err)
+	}
+	if cfg.Policies.Quarantine.TokenSecret == "" {
+		return nil, fmt.Errorf("quarantine token secret is required")
+	}
+	blobs, err := newBlobService(&cfg.Storage, &cfg.Security, repos)
+	if err != nil {
+		return nil, err
+	}
+
+	eventPub := service.NewOutboxPublisher(repos.Outbox)
+	relay := service.NewOutboxRelay(repos.Outbox, service.OutboxConfig{
+		PollInterval:  cfg.Events.PollInterval,
+		BatchSize:     cfg.Events.BatchSize,
+		MaxAttempts:   cfg.Events.MaxAttempts,
+		RetryDelay:    cfg.Events.RetryDelay,
+		MaxRetryDelay: cfg.Events.MaxRetryDelay,
+		Lease:         cfg.Events.Lease,
+		Retention:     cfg.Events.Retention,
+	})
+
+	smtp := &cfg.SMTP
+	outbound := &cfg.Routing.Outbound
+	resolver := service.NewDNSResolver(outbound.TLS.DNSServers, outbound.TLS.DNSTimeout)
+
+	spam := service.NewSpamService(repos.Spam, repos.Messages, repos.Folders, repos.EmailAccounts, repos.Policies, eventPub,
+		&service.SpamConfig{
+			EnableSpamFilter: cfg.Policies.EnableSpamFilter,
+			SpamThreshold:    cfg.Policies.SpamThreshold,
+		})
+	messageConfig := &service.MessageConfig{
+		MaxMessageSize:    smtp.MaxSize,
+		MaxAttachments:    mailerMaxAttachments,
+		MaxAttachmentSize: cfg.Policies.MaxAttachmentSize,
+		AllowedMimeTypes:  cfg.Policies.AllowedMimeTypes,
+	}
+	attachments := service.NewAttachmentPolicyService(blobs, eventPub, attachmentPolicyConfig(&cfg.Policies, messageConfig))
+	clamd := &cfg.Policies.Antivirus
+	antivirus := service.NewAntivirusService(service.NewClamdScanner(clamd.Network, clamd.Address, clamd.Timeout, clamd.ChunkSize),
+		blobs, repos.EmailAccounts, repos.Policies, eventPub, &service.AntivirusConfig{
+			EnableVirusScan: cfg.Policies.EnableVirusScan,
+			MaxScanSize:     clamd.MaxScanSize,
+			FailOpen:        clamd.FailOpen,
+		})
+
+	sending := &cfg.Quotas.Sending
+	rateLimits := service.NewRateLimitService(repos.RateCounters, repos.Suspensions,
+		service.NewSMTPAdminNotifier(smtp.Host, smtp.Port, smtp.Username, smtp.Password, sending.AlertFrom, sending.AdminRecipients),
+		eventPub, rateLimitConfig(sending))
+	ipPools := service.NewIPPoolService(repos.IPPools, repos.PoolAssignments, repos.RateCounters, eventPub,
+		&service.IPPoolConfig{WarmupSchedule: warmupSchedule(outbound.WarmupSchedule)})
+	tlsPolicies := service.NewTLSPolicyService(repos.MTASTSPolicies, repos.TLSResults, resolver,
+		service.NewMTASTSHTTPClient(outbound.TLS.PolicyFetchTimeout), &service.TLSPolicyConfig{
+			EnableMTASTS:       outbound.TLS.EnableMTASTS,
+			EnableDANE:         outbound.TLS.EnableDANE,
+			FetchTimeout:       outbound.TLS.PolicyFetchTimeout,
+			ReportOrganization: outbound.TLS.ReportOrganization,
+			ReportContact:      outbound.TLS.ReportContact,
+		})
+
+	dkimCfg := &cfg.Routing.DKIM
+	dkim := service.NewDKIMService(repos.DKIMKeys, repos.DKIMRotationLog, repos.Domains, resolver, nil, encrypter, eventPub,
+		&service.DKIMConfig{
+			Algorithm:          domain.DKIMAlgorithm(dkimCfg.Algorithm),
+			KeyBits:            dkimCfg.KeyBits,
+			SelectorPrefix:     dkimCfg.SelectorPrefix,
+			RotationInterval:   dkimCfg.RotationInterval,
+			GracePeriod:        dkimCfg.GracePeriod,
+			PropagationTimeout: dkimCfg.PropagationTimeout,
+			CheckInterval:      dkimCfg.CheckInterval,
+		})
+	arc := service.NewARCService(dkim, resolver, &service.ARCConfig{
+		AuthServID:     cfg.Routing.ARC.AuthServID,
+		TrustedSealers: cfg.Routing.ARC.TrustedSealers,
+		SignedHeaders:  cfg.Routing.ARC.SignedHeaders,
+	})
+	delivery := service.NewDeliveryService(repos.DestinationPolicies, repos.DeliveryJobs, resolver,
+		service.NewNetSMTPDialer(outbound.CommandTimeout),
+		tlsPolicies, ipPools, rateLimits, arc, eventPub, &service.DeliveryConfig{
+			HeloName:       outbound.HeloName,
+			Port:           outbound.Port,
+			CommandTimeout: outbound.CommandTimeout,
+			IdleTimeout:    outbound.IdleTimeout,
+			MXCacheTTL:     outbound.MXCacheTTL,
+			RetryDelay:     cfg.Routing.RetryDelay,
+			MaxRetryDelay:  outbound.MaxRetryDelay,
+			MaxAttempts:    cfg.Routing.RetryAttempts,
+			Lease:          outbound.Lease,
+			MaxHeld:        outbound.MaxHeld,
+			DefaultPolicy: domain.DestinationPolicy{
+				MaxConnections:           outbound.DefaultMaxConnections,
+				MaxMessagesPerConnection: outbound.DefaultMaxMessagesPerConnection,
+				MaxMessagesPerMinute:     outbound.DefaultMaxMessagesPerMinute,
+				Backoff:                  outbound.DefaultBackoff,
+				MaxBackoff:               outbound.DefaultMaxBackoff,
+				IsActive:                 true,
+			},
+		})
+
+	acls := service.NewACLService(repos.FolderACLs, repos.Groups, repos.ACLAuditLog, repos.EmailAccounts, repos.Folders,
+		repos.Users, repos.Domains, repos.DomainMembers, repos.Transactor)
+
+	quarantineCfg := &cfg.Policies.Quarantine
+	quarantine := service.NewQuarantineService(repos.Quarantine, repos.Messages, repos.EmailAccounts, repos.Folders, repos.Policies,
+		repos.Transactor, acls,
+		service.NewSMTPDigestSender(smtp.Host, smtp.Port, smtp.Username, smtp.Password, quarantineCfg.DigestFrom),
+		eventPub, &service.QuarantineConfig{
+			Retention:      quarantineCfg.Retention,
+			TokenSecret:    []byte(quarantineCfg.TokenSecret),
+			TokenTTL:       quarantineCfg.TokenTTL,
+			ReleaseURL:     quarantineCfg.ReleaseURL,
+			DigestInterval: quarantineCfg.DigestInterval,
+		})
+
+	identities := service.NewIdentityService(repos.Identities, repos.SenderDelegations, repos.EmailAccounts, repos.Domains,
+		repos.DomainMembers, repos.Users, repos.Transactor)
+	threads := service.NewThreadService(repos.Threads, repos.EmailAccounts, repos.Messages)
+	search := service.NewSearchService(repos.SearchIndex, repos.EmailAccounts, repos.Messages, repos.Attachments, blobs, nil)
+	mailbox := service.NewMailboxService(repos.EmailAccounts, repos.Folders, repos.Messages, spam, blobs, repos.Transactor,
+		threads, acls)
+
+	messages := service.NewMessageService(service.MessageServiceDeps{
+		Messages:    repos.Messages,
+		Accounts:    repos.EmailAccounts,
+		Attachments: repos.Attachments,
+		Quotas:      repos.Quotas,
+		Policies:    repos.Policies,
+		Folders:     repos.Folders,
+		Domains:     repos.Domains,
+		SpamTrainer: spam,
+		SpamFilter:  spam,
+		Antivirus:   antivirus,
+		Quarantine:  quarantine,
+		Checker:     attachments,
+		RateLimiter: rateLimits,
+		Senders:     identities,
+		Blobs:       blobs,
+		Transactor:  repos.Transactor,
+		EventPub:    eventPub,
+		Config:      messageConfig,
+	})
+	drafts := service.NewDraftService(repos.EmailAccounts, repos.Folders, repos.Messages, messages, blobs, repos.Transactor,
+		identities, search)
+	scheduled := service.NewScheduledSendService(repos.ScheduledSends, repos.EmailAccounts, repos.Messages, drafts,
+		repos.Transactor, service.ScheduledSendConfig{
+			PollInterval:  cfg.Scheduling.PollInterval,
+			BatchSize:     cfg.Scheduling.BatchSize,
+			MaxAttempts:   cfg.Scheduling.MaxAttempts,
+			RetryDelay:    cfg.Scheduling.RetryDelay,
+			MaxRetryDelay: cfg.Scheduling.MaxRetryDelay,
+			Lease:         cfg.Scheduling.Lease,
+			MaxUndoWindow: cfg.Scheduling.MaxUndoWindow,
+			MaxDelay:      cfg.Scheduling.MaxDelay,
+			Retention:     cfg.Scheduling.Retention,
+		})
+
+	// Sent, received and released messages are indexed and threaded from
+	// their events, and sent messages are queued for delivery
+	relay.Subscribe(search)
+	relay.Subscribe(threads)
+	relay.Subscribe(service.NewOutboundQueue(repos.Messages, repos.EmailAccounts, repos.Domains, blobs, delivery))
+
+	return &MailerServices{
+		Messages:    messages,
+		Blobs:       blobs,
+		Quarantine:  quarantine,
+		RateLimit:   rateLimits,
+		Delivery:    delivery,
+		IPPools:     ipPools,
+		TLSPolicies: tlsPolicies,
+		DKIM:        dkim,
+		Mailbox:     mailbox,
+		Search:      search,
+		Threads:     threads,
+		Drafts:      drafts,
+		Scheduled:   scheduled,
+		Identities:  identities,
+		ACLs:        acls,
+		relay:       relay,
+		reindex:     repos.mailbox == "maildir",
+	}, nil
+}
+
+// Run runs the background workers of the mail services until ctx is
+// cancelled, then waits for them to stop
+func (m *MailerServices) Run(ctx context.Context) {
+	workers := []func(context.Context){
+		m.relay.Run,
+		m.Blobs.Run,
+		m.Quarantine.Run,
+		m.DKIM.Run,
+		m.Delivery.Run,
+		m.Scheduled.Run,
+		m.RateLimit.Run,
+	}
+
+	if m.reindex {
+		workers = append(workers, m.reindexSearch)
+	}
+
+	var wg sync.WaitGroup
+	for _, run := range workers {
+		wg.Add(1)
+		go func(run func(context.Context)) {
+			defer wg.Done()
+			run(ctx)
+		}(run)
+	}
+	wg.Wait()
+}
+
+// reindexSearch rebuilds the search index of every account. Searches
+// return partial results until it finishes.
+func (m *MailerServices) reindexSearch(ctx context.Context) {
+	indexed, err := m.Search.ReindexAll(ctx)
+	if err != nil && ctx.Err() == nil {
+		log.Printf("search reindex stopped after %d messages: %v", indexed, err)
+	}
+}
+
+// newBlobService stores attachment content in the configured backend,
+// encrypted with keys derived from the encryption key when enabled
+func newBlobService(cfg *sdkconfig.StorageConfig, security *sdkconfig.SecurityConfig, repos *MailerRepositories) (*service.BlobService, error) {
+	var backend storage.Backend
+	var err error
+	switch cfg.Backend {
+	case "s3":
+		backend, err = storage.NewS3(storage.S3Config{
+			Endpoint:  cfg.S3.Endpoint,
+			Region:    cfg.S3.Region,
+			Bucket:    cfg.S3.Bucket,
+			AccessKey: cfg.S3.AccessKey,
+			SecretKey: cfg.S3.SecretKey,
+			Prefix:    cfg.S3.Prefix,
+			PathStyle: cfg.S3.PathStyle,
+			Timeout:   cfg.S3.Timeout,
+		})
+	default:
+		backend, err = storage.NewFilesystem(cfg.Path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("opening blob storage: %w", err)
+	}
+
+	var keys service.BlobKeyProvider
+	if cfg.EncryptAtRest {
+		derived, err := service.NewDerivedBlobKeys(security.EncryptionKey, cfg.EncryptedTenants)
+		if err != nil {
+			return nil, fmt.Errorf("deriving blob keys: %w", err)
+		}
+		keys = derived
+	}
+
+	return service.NewBlobService(repos.Blobs, backend, keys, &service.BlobConfig{
+		SpoolDir:    cfg.SpoolDir,
+		GCInterval:  cfg.GCInterval,
+		GracePeriod: cfg.GCGracePeriod,
+	}), nil
+}
+
+func attachmentPolicyConfig(cfg *sdkconfig.PolicyConfig, messages *service.MessageConfig) *service.AttachmentPolicyConfig {
+	attachments := &cfg.Attachments
+	config := &service.AttachmentPolicyConfig{
+		EnableAttachmentPolicy: cfg.EnableContentFilter,
+		AllowedExtensions:      cfg.AllowedFileTypes,
+		AllowedMimeTypes:       messages.AllowedMimeTypes,
+		MaxArchiveDepth:        attachments.MaxArchiveDepth,
+		MaxArchiveSize:         attachments.MaxArchiveSize,
+		MaxArchiveEntries:      attachments.MaxArchiveEntries,
+		BlockedAction:          domain.PolicyAction(attachments.BlockedAction),
+		MismatchAction:         domain.PolicyAction(attachments.MismatchAction),
+		EncryptedAction:        domain.PolicyAction(attachments.EncryptedAction),
+		MacroAction:            domain.PolicyAction(attachments.MacroAction),
+		ArchiveLimitAction:     domain.PolicyAction(attachments.ArchiveLimitAction),
+	}
+	// An empty list keeps the SDK defaults
+	if len(attachments.BlockedExtensions) > 0 {
+		config.BlockedExtensions = attachments.BlockedExtensions
+	}
+	return config
+}
+
+func rateLimitConfig(cfg *sdkconfig.SendingConfig) *service.RateLimitConfig {
+	limits := service.DefaultRateLimits()
+	if len(cfg.Limits) > 0 {
+		limits = make([]domain.RateLimit, 0, len(cfg.Limits))
+		for _, limit := range cfg.Limits {
+			limits = append(limits, domain.RateLimit{
+				Scope:         domain.RateScope(limit.Scope),
+				Window:        limit.Window,
+				MaxMessages:   limit.MaxMessages,
+				MaxRecipients: limit.MaxRecipients,
+			})
+		}
+	}
+	return &service.RateLimitConfig{
+		EnableRateLimits:        cfg.EnableRateLimits,
+		Limits:                  limits,
+		ReputationWindow:        cfg.ReputationWindow,
+		MinReputationSample:     cfg.MinReputationSample,
+		MaxBounceRate:           cfg.MaxBounceRate,
+		MaxUnknownRecipientRate: cfg.MaxUnknownRecipientRate,
+		SuspendAfterViolations:  cfg.SuspendAfterViolations,
+		ViolationWindow:         cfg.ViolationWindow,
+		SuspensionDuration:      cfg.SuspensionDuration,
+		PruneInterval:           cfg.PruneInterval,
+	}
+}
+
+func warmupSchedule(steps []sdkconfig.WarmupStep) []domain.WarmupStep {
+	if len(steps) == 0 {
+		return service.DefaultWarmupSchedule()
+	}
+	schedule := make([]domain.WarmupStep, 0, len(steps))
+	for _, step := range steps {
+		schedule = append(schedule, domain.WarmupStep{Day: step.Day, DailyLimit: step.DailyLimit})
+	}
+	return schedule
+}
@@ -0,0 +1,69 @@
+package utils
+
+import (
+	"context"
+	"io"
+
+	"github.com/skygenesisenterprise/aether-mailer/package/golang/service"
+	"github.com/skygenesisenterprise/aether-mailer/server/src/models"
+)
+
+// BlobAttachmentWriter stocke les pièces jointes lues par
+// ParseEmailReader dans le magasin de blobs du SDK. Chaque pièce jointe
+// stockée détient une référence sur son blob, que l'appelant libère s'il
+// abandonne le message.
+type BlobAttachmentWriter struct {
+	ctx      context.Context
+	blobs    service.BlobStore
+	tenantID string
+}
+
+var _ AttachmentWriter = (*BlobAttachmentWriter)(nil)
+
+// NewBlobAttachmentWriter crée un writer qui stocke les pièces jointes pour un tenant
+func NewBlobAttachmentWriter(ctx context.Context, blobs service.BlobStore, tenantID string) *BlobAttachmentWriter {
+	return &BlobAttachmentWriter{
+		ctx:      ctx,
+		blobs:    blobs,
+		tenantID: tenantID,
+	}
+}
+
+// WriteAttachment transmet le contenu en flux au magasin de blobs et retourne l'identifiant du blob
+func (w *BlobAttachmentWriter) WriteAttachment(attachment *models.Attachment, content io.Reader) (string, error) {
+	blob, err := w.blobs.Put(w.ctx, w.tenantID, content)
+	if err != nil {
+		return "", err
+	}
+	return blob.ID, nil
+}
+
+// ParseEmailToBlobs analyse un message en stockant ses pièces jointes dans le
+// magasin de blobs du tenant. En cas d'échec, les blobs déjà stockés sont libérés.
+func ParseEmailToBlobs(ctx context.Context, r io.Reader, blobs service.BlobStore, tenantID string) (*models.Email, error) {
+	writer := &releasingAttachmentWriter{BlobAttachmentWriter: NewBlobAttachmentWriter(ctx, blobs, tenantID)}
+	email, err := ParseEmailReader(r, writer)
+	if err != nil {
+		for _, blobID := range writer.stored {
+			if err := blobs.Release(ctx, blobID); err != nil {
+				// Log error but don't fail the operation
+			}
+		}
+		return nil, err
+	}
+	return email, nil
+}
+
+// releasingAttachmentWriter garde la liste des blobs stockés pour les libérer en cas d'échec
+type releasingAttachmentWriter struct {
+	*BlobAttachmentWriter
+	stored []string
+}
+
+func (w *releasingAttachmentWriter) WriteAttachment(attachment *models.Attachment, content io.Reader) (string, error) {
+	blobID, err := w.BlobAttachmentWriter.WriteAttachment(attachment, content)
+	if err == nil {
+		w.stored = append(w.stored, blobID)
+	}
+	return blobID, err
+}
@@ -1,16 +1,12 @@
 package utils
 
 import (
-	"bufio"
 	"crypto/md5"
 	"crypto/rand"
 	"crypto/sha1"
 	"encoding/base64"
 	"fmt"
 	"io"
-	"mime"
-	"mime/multipart"
-	"mime/quotedprintable"
 	"net/mail"
 	"regexp"
 	"strconv"
@@ -21,83 +17,17 @@ import (
 	"github.com/skygenesisenterprise/aether-mailer/server/src/models"
 )
 
+// ParseEmail analyse un message complet. Les pièces jointes sont seulement
+// mesurées ; ParseEmailToBlobs les stocke dans le magasin de blobs.
 func ParseEmail(rawEmail string) (*models.Email, error) {
-	msg, err := mail.ReadMessage(strings.NewReader(rawEmail))
-	if err != nil {
-		return nil, fmt.Errorf("failed to parse email: %w", err)
-	}
-
-	email := &models.Email{
-		Headers: make(map[string]string),
-	}
-
-	headers := msg.Header
-	for key := range headers {
-		email.Headers[key] = headers.Get(key)
-	}
-
-	email.Subject = decodeHeader(headers.Get("Subject"))
-
-	from, err := mail.ParseAddress(headers.Get("From"))
-	if err == nil {
-		email.From = &models.EmailAddress{
-			Name:  from.Name,
-			Email: from.Address,
-		}
-	}
-
-	to, err := mail.ParseAddressList(headers.Get("To"))
-	if err == nil {
-		for _, addr := range to {
-			email.To = append(email.To, &models.EmailAddress{
-				Name:  addr.Name,
-				Email: addr.Address,
-			})
-		}
-	}
-
-	cc, err := mail.ParseAddressList(headers.Get("Cc"))
-	if err == nil {
-		for _, addr := range cc {
-			email.Cc = append(email.Cc, &models.EmailAddress{
-				Name:  addr.Name,
-				Email: addr.Address,
-			})
-		}
-	}
-
-	dateStr := headers.Get("Date")
-	email.Date, _ = parseDate(dateStr)
-
-	email.HasAttachments = strings.Contains(strings.ToLower(headers.Get("Content-Type")), "multipart")
-
-	contentType := headers.Get("Content-Type")
-	mediaType, boundaryParams, err := mime.ParseMediaType(contentType)
-	if err != nil {
-		mediaType = "text/plain"
-	}
-
-	if strings.HasPrefix(mediaType, "text/") {
-		body, _ := readBody(msg.Body)
-		if mediaType == "text/html" {
-			email.BodyHTML = body
-		} else {
-			email.Body = body
-		}
-	} else if mediaType == "multipart/alternative" || mediaType == "multipart/mixed" {
-		mr := multipart.NewReader(msg.Body, boundaryParams["boundary"])
-		email.Body, email.BodyHTML, email.Attachments = parseMultipart(mr)
-	}
-
-	email.Preview = generatePreview(email.Body, email.BodyHTML)
-
-	return email, nil
+	return ParseEmailReader(strings.NewReader(rawEmail), nil)
 }
 
 func ParseEmailAddresses(header string) []*models.EmailAddress {
 	var addresses []*models.EmailAddress
 
-	list, err := mail.ParseAddressList(header)
+	parser := mail.AddressParser{WordDecoder: wordDecoder()}
+	list, err := parser.ParseList(header)
 	if err != nil {
 		return addresses
 	}
@@ -112,112 +42,12 @@ func ParseEmailAddresses(header string) []*models.EmailAddress {
 	return addresses
 }
 
-func readBody(r io.Reader) (string, error) {
-	var body strings.Builder
-	scanner := bufio.NewScanner(r)
-
-	buf := make([]byte, 0, 64*1024)
-	scanner.Buffer(buf, 1024*1024)
-
-	for scanner.Scan() {
-		body.WriteString(scanner.Text())
-		body.WriteString("\n")
-	}
-
-	if err := scanner.Err(); err != nil {
-		return "", err
-	}
-
-	content := body.String()
-
-	ct := ""
-	if idx := strings.Index(content, "\n"); idx > 0 && strings.HasPrefix(content[:idx], "Content-Transfer-Encoding:") {
-		ct = strings.TrimSpace(content[len("Content-Transfer-Encoding:"):idx])
-		content = content[idx+1:]
-	}
-
-	content = strings.TrimLeft(content, "\n")
-
-	switch strings.ToLower(ct) {
-	case "quoted-printable":
-		reader := quotedprintable.NewReader(strings.NewReader(content))
-		result, err := io.ReadAll(reader)
-		if err != nil {
-			return content, nil
-		}
-		return string(result), nil
-	case "base64":
-		reader := base64.NewDecoder(base64.StdEncoding, strings.NewReader(content))
-		result, err := io.ReadAll(reader)
-		if err != nil {
-			return content, nil
-		}
-		return string(result), nil
-	}
-
-	return content, nil
-}
-
-func parseMultipart(mr *multipart.Reader) (string, string, []*models.Attachment) {
-	var body, bodyHTML string
-	var attachments []*models.Attachment
-
-	for part, err := mr.NextPart(); err == nil; part, err = mr.NextPart() {
-		if err != nil {
-			break
-		}
-
-		partType := part.Header.Get("Content-Type")
-		mediaType, _, _ := mime.ParseMediaType(partType)
-
-		disposition := part.Header.Get("Content-Disposition")
-		isAttachment := strings.Contains(strings.ToLower(disposition), "attachment")
-		isInline := strings.Contains(strings.ToLower(disposition), "inline")
-
-		if isAttachment || isInline {
-			filename := part.FileName()
-			if filename == "" {
-				filename = "unnamed"
-			}
-
-			content, _ := io.ReadAll(part)
-			att := &models.Attachment{
-				Filename:    filename,
-				MimeType:    partType,
-				Size:        int64(len(content)),
-				Inline:      isInline,
-				Disposition: disposition,
-			}
-
-			if isInline {
-				att.CID = part.Header.Get("Content-ID")
-			}
-
-			attachments = append(attachments, att)
-		} else if strings.HasPrefix(mediaType, "text/") {
-			content, _ := io.ReadAll(part)
-			if mediaType == "text/html" {
-				bodyHTML += string(content)
-			} else {
-				body += string(content)
-			}
-		}
-	}
-
-	return body, bodyHTML, attachments
-}
-
 func decodeHeader(header string) string {
 	if !strings.Contains(header, "=?") {
 		return header
 	}
 
-	dec := new(mime.WordDecoder)
-	dec.CharsetReader = func(charset string, input io.Reader) (io.Reader, error) {
-		return input, nil
-	}
-
-	decoded, err := dec.DecodeHeader(header)
+	decoded, err := wordDecoder().DecodeHeader(header)
 	if err != nil {
 		return header
 	}
@@ -461,10 +291,11 @@ func IsUTF8(s string) bool {
 }
 
 func ToUTF8(s, charset string) string {
-	if IsUTF8(s) {
+	decoded, err := io.ReadAll(charsetReader(charset, strings.NewReader(s)))
+	if err != nil {
 		return s
 	}
-	return s
+	return string(decoded)
 }
 
 func SanitizeFilename(filename string) string {
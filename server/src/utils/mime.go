@@ -0,0 +1,654 @@
+package utils
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/mail"
+	"net/textproto"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/skygenesisenterprise/aether-mailer/server/src/models"
+	"golang.org/x/text/encoding/htmlindex"
+)
+
+// AttachmentWriter reçoit le contenu décodé de chaque pièce jointe pendant
+// l'analyse et retourne l'identifiant du blob stocké. Le contenu n'est lu
+// qu'une fois et n'est jamais conservé en mémoire par l'analyseur.
+type AttachmentWriter interface {
+	WriteAttachment(attachment *models.Attachment, content io.Reader) (string, error)
+}
+
+const (
+	// maxMIMEDepth limite l'imbrication des parties multipart et message/rfc822
+	maxMIMEDepth = 32
+	// maxTextPartSize limite la taille décodée d'une partie texte conservée en corps
+	maxTextPartSize = 16 << 20
+)
+
+// mimeContext décrit la position d'une partie dans l'arborescence MIME
+type mimeContext struct {
+	depth       int
+	nested      bool // partie d'un message/rfc822 joint
+	related     bool // enfant d'un multipart/related
+	relatedRoot bool // partie racine du multipart/related
+	digest      bool // enfant d'un multipart/digest, message/rfc822 par défaut
+}
+
+type mimeParser struct {
+	store AttachmentWriter
+	email *models.Email
+}
+
+// ParseEmailReader analyse un message en flux : l'arborescence MIME complète
+// est construite dans BodyStructure, les corps texte sont décodés en UTF-8 et
+// le contenu des pièces jointes est transmis à store avec sa somme SHA-256.
+// store peut être nil, les pièces jointes sont alors seulement mesurées.
+func ParseEmailReader(r io.Reader, store AttachmentWriter) (*models.Email, error) {
+	counter := &countingReader{r: r}
+	msg, err := mail.ReadMessage(counter)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse email: %w", err)
+	}
+
+	email := &models.Email{
+		Headers: make(map[string]string),
+	}
+
+	headers := msg.Header
+	for key := range headers {
+		email.Headers[key] = headers.Get(key)
+	}
+
+	email.Subject = decodeHeader(headers.Get("Subject"))
+	email.From = firstAddress(headers.Get("From"))
+	email.ReplyTo = firstAddress(headers.Get("Reply-To"))
+	email.To = ParseEmailAddresses(headers.Get("To"))
+	email.Cc = ParseEmailAddresses(headers.Get("Cc"))
+	email.Date, _ = parseDate(headers.Get("Date"))
+
+	parser := &mimeParser{store: store, email: email}
+	root, err := parser.parseEntity(textproto.MIMEHeader(headers), msg.Body, "", mimeContext{})
+	if err != nil {
+		return nil, err
+	}
+	if _, err := io.Copy(io.Discard, msg.Body); err != nil {
+		return nil, fmt.Errorf("failed to read email: %w", err)
+	}
+
+	email.BodyStructure = root
+	email.HasAttachments = len(email.Attachments) > 0
+	email.Size = counter.n
+	email.Preview = generatePreview(email.Body, email.BodyHTML)
+
+	return email, nil
+}
+
+// parseEntity analyse une entité MIME. id est le numéro de section IMAP de
+// l'entité, vide pour un multipart racine.
+func (p *mimeParser) parseEntity(header textproto.MIMEHeader, body io.Reader, id string, ctx mimeContext) (*models.BodyPart, error) {
+	defaultType := "text/plain"
+	if ctx.digest {
+		defaultType = "message/rfc822"
+	}
+	mediaType, params := parseHeaderParams(header.Get("Content-Type"))
+	if mediaType == "" || !strings.Contains(mediaType, "/") {
+		mediaType, params = defaultType, map[string]string{}
+	}
+
+	// Une partie unique porte le numéro 1, un multipart racine n'en a pas.
+	// Un multipart sans délimiteur est lu comme une partie unique.
+	isMultipart := strings.HasPrefix(mediaType, "multipart/") && params["boundary"] != "" && ctx.depth < maxMIMEDepth
+	if id == "" && !isMultipart {
+		id = "1"
+	}
+
+	disposition, dispParams := parseHeaderParams(header.Get("Content-Disposition"))
+	filename := dispParams["filename"]
+	if filename == "" {
+		filename = params["name"]
+	}
+	if filename != "" {
+		filename = decodeFilename(filename)
+	}
+
+	part := &models.BodyPart{
+		PartID:      id,
+		MimeType:    mediaType,
+		Params:      params,
+		Charset:     strings.ToLower(params["charset"]),
+		Encoding:    strings.ToLower(strings.TrimSpace(header.Get("Content-Transfer-Encoding"))),
+		Disposition: disposition,
+		Filename:    filename,
+		CID:         strings.Trim(strings.TrimSpace(header.Get("Content-Id")), "<>"),
+		Description: decodeHeader(header.Get("Content-Description")),
+	}
+	// Les ressources d'un multipart/related sont affichées dans le corps
+	if ctx.related && !ctx.relatedRoot && part.Disposition == "" {
+		part.Disposition = "inline"
+	}
+
+	switch {
+	case isMultipart:
+		return part, p.parseMultipart(part, body, ctx)
+	case (mediaType == "message/rfc822" || mediaType == "message/global") && ctx.depth < maxMIMEDepth:
+		return part, p.parseMessage(part, body, ctx)
+	}
+
+	content := decodeTransferEncoding(body, part.Encoding)
+	if ctx.nested {
+		_, err := p.measure(part, content, nil, nil)
+		return part, err
+	}
+
+	isText := mediaType == "text/plain" || mediaType == "text/html"
+	if isText && disposition != "attachment" && filename == "" && (!ctx.related || ctx.relatedRoot) {
+		text, err := p.readText(part, content)
+		if err != nil {
+			return nil, err
+		}
+		if mediaType == "text/html" {
+			p.email.BodyHTML = appendBody(p.email.BodyHTML, text)
+		} else {
+			p.email.Body = appendBody(p.email.Body, text)
+		}
+		return part, nil
+	}
+
+	return part, p.attach(part, content, nil)
+}
+
+// parseMultipart analyse les enfants d'un multipart
+func (p *mimeParser) parseMultipart(part *models.BodyPart, body io.Reader, ctx mimeContext) error {
+	reader := multipart.NewReader(body, part.Params["boundary"])
+	rootCID := strings.Trim(part.Params["start"], "<>")
+
+	for index := 1; ; index++ {
+		child, err := reader.NextRawPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			// Un multipart mal formé garde les parties déjà lues
+			break
+		}
+
+		childID := strconv.Itoa(index)
+		if part.PartID != "" {
+			childID = part.PartID + "." + childID
+		}
+		childCtx := mimeContext{
+			depth:  ctx.depth + 1,
+			nested: ctx.nested,
+			digest: part.MimeType == "multipart/digest",
+		}
+		if part.MimeType == "multipart/related" {
+			childCtx.related = true
+			cid := strings.Trim(strings.TrimSpace(child.Header.Get("Content-Id")), "<>")
+			childCtx.relatedRoot = (rootCID == "" && index == 1) || (rootCID != "" && cid == rootCID)
+		}
+
+		// Une dernière partie sans délimiteur de fin garde ce qui a été lu
+		childPart, err := p.parseEntity(child.Header, &truncatedPartReader{r: child}, childID, childCtx)
+		if err != nil {
+			return err
+		}
+		part.Size += childPart.Size
+		part.Parts = append(part.Parts, childPart)
+	}
+	return nil
+}
+
+// parseMessage analyse un message joint : il est stocké comme pièce jointe
+// et sa structure est décrite sans extraire ses propres pièces jointes
+func (p *mimeParser) parseMessage(part *models.BodyPart, body io.Reader, ctx mimeContext) error {
+	inspect := func(r io.Reader) error {
+		msg, err := mail.ReadMessage(r)
+		if err != nil {
+			// Un message joint illisible reste une pièce jointe opaque
+			return nil
+		}
+		part.Headers = map[string]string{}
+		for _, name := range []string{"Subject", "From", "To", "Cc", "Date", "Message-Id"} {
+			if value := msg.Header.Get(name); value != "" {
+				part.Headers[name] = decodeHeader(value)
+			}
+		}
+
+		embeddedType, _ := parseHeaderParams(msg.Header.Get("Content-Type"))
+		embeddedID := part.PartID
+		if embeddedID == "" {
+			embeddedID = "1"
+		}
+		if !strings.HasPrefix(embeddedType, "multipart/") {
+			embeddedID += ".1"
+		}
+
+		embedded, err := p.parseEntity(textproto.MIMEHeader(msg.Header), msg.Body, embeddedID,
+			mimeContext{depth: ctx.depth + 1, nested: true})
+		if err != nil {
+			return err
+		}
+		part.Parts = []*models.BodyPart{embedded}
+		return nil
+	}
+
+	content := decodeTransferEncoding(body, part.Encoding)
+	if ctx.nested {
+		_, err := p.measure(part, content, nil, inspect)
+		return err
+	}
+	return p.attach(part, content, inspect)
+}
+
+// attach transmet une partie au stockage et l'ajoute aux pièces jointes
+func (p *mimeParser) attach(part *models.BodyPart, content io.Reader, inspect func(io.Reader) error) error {
+	isMessage := part.MimeType == "message/rfc822" || part.MimeType == "message/global"
+	filename := part.Filename
+	if filename == "" {
+		filename = "unnamed"
+		if isMessage {
+			filename = "message.eml"
+		}
+	}
+
+	disposition := part.Disposition
+	if disposition == "" {
+		disposition = "attachment"
+	}
+	attachment := &models.Attachment{
+		PartID:      part.PartID,
+		Filename:    filename,
+		MimeType:    part.MimeType,
+		Disposition: disposition,
+		Inline:      disposition == "inline",
+		CID:         part.CID,
+	}
+
+	blobID, err := p.measure(part, content, attachment, inspect)
+	if err != nil {
+		return err
+	}
+
+	// Un message joint sans nom prend le sujet du message
+	if isMessage && part.Filename == "" && part.Headers["Subject"] != "" {
+		attachment.Filename = SanitizeFilename(part.Headers["Subject"]) + ".eml"
+	}
+	attachment.Size = part.Size
+	attachment.Checksum = part.Checksum
+	attachment.BlobID = blobID
+	part.BlobID = blobID
+
+	p.email.Attachments = append(p.email.Attachments, attachment)
+	return nil
+}
+
+// measure lit une partie jusqu'au bout en calculant sa taille et sa somme
+// SHA-256. Le contenu est transmis au stockage quand attachment est fourni
+// et à inspect pendant la lecture.
+func (p *mimeParser) measure(part *models.BodyPart, content io.Reader, attachment *models.Attachment, inspect func(io.Reader) error) (string, error) {
+	hash := sha256.New()
+	size := &countingWriter{}
+	writers := []io.Writer{hash, size}
+
+	var pipe *io.PipeWriter
+	var stored chan storeResult
+	if attachment != nil && p.store != nil {
+		reader, writer := io.Pipe()
+		pipe = writer
+		stored = make(chan storeResult, 1)
+		writers = append(writers, writer)
+		go func() {
+			blobID, err := p.store.WriteAttachment(attachment, reader)
+			if err == nil {
+				err = errAttachmentWriterDone
+			}
+			reader.CloseWithError(err)
+			stored <- storeResult{blobID: blobID, err: err}
+		}()
+	}
+
+	tee := io.TeeReader(content, io.MultiWriter(writers...))
+	var err error
+	if inspect != nil {
+		err = inspect(tee)
+	}
+	if err == nil {
+		_, err = io.Copy(io.Discard, tee)
+	}
+
+	var blobID string
+	if pipe != nil {
+		pipe.CloseWithError(err)
+		result := <-stored
+		if result.err != errAttachmentWriterDone {
+			return "", fmt.Errorf("failed to store attachment %q: %w", attachment.Filename, result.err)
+		}
+		blobID = result.blobID
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to read part %s: %w", part.PartID, err)
+	}
+
+	part.Size = size.n
+	part.Checksum = hex.EncodeToString(hash.Sum(nil))
+	return blobID, nil
+}
+
+// readText décode une partie texte en UTF-8
+func (p *mimeParser) readText(part *models.BodyPart, content io.Reader) (string, error) {
+	hash := sha256.New()
+	size := &countingWriter{}
+	tee := io.TeeReader(content, io.MultiWriter(hash, size))
+
+	data, err := io.ReadAll(io.LimitReader(tee, maxTextPartSize))
+	if err != nil {
+		return "", fmt.Errorf("failed to read part %s: %w", part.PartID, err)
+	}
+	if _, err := io.Copy(io.Discard, tee); err != nil {
+		return "", fmt.Errorf("failed to read part %s: %w", part.PartID, err)
+	}
+	part.Size = size.n
+	part.Checksum = hex.EncodeToString(hash.Sum(nil))
+
+	text := ToUTF8(string(data), part.Charset)
+	return strings.ReplaceAll(text, "\r\n", "\n"), nil
+}
+
+// errAttachmentWriterDone ferme le flux quand le stockage a terminé sans erreur
+var errAttachmentWriterDone = errors.New("attachment writer returned")
+
+type storeResult struct {
+	blobID string
+	err    error
+}
+
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(b []byte) (int, error) {
+	n, err := c.r.Read(b)
+	c.n += int64(n)
+	return n, err
+}
+
+type countingWriter struct {
+	n int64
+}
+
+func (c *countingWriter) Write(b []byte) (int, error) {
+	c.n += int64(len(b))
+	return len(b), nil
+}
+
+// truncatedPartReader termine normalement une partie coupée avant son
+// délimiteur, à la fin d'un message tronqué
+type truncatedPartReader struct {
+	r io.Reader
+}
+
+func (t *truncatedPartReader) Read(b []byte) (int, error) {
+	n, err := t.r.Read(b)
+	if err == io.ErrUnexpectedEOF {
+		err = io.EOF
+	}
+	return n, err
+}
+
+// base64Filter ignore les caractères étrangers à l'alphabet base64, que
+// certains clients laissent dans les parties encodées
+type base64Filter struct {
+	r io.Reader
+}
+
+func (f *base64Filter) Read(b []byte) (int, error) {
+	for {
+		n, err := f.r.Read(b)
+		kept := 0
+		for _, c := range b[:n] {
+			if (c >= 'A' && c <= 'Z') || (c >= 'a' && c <= 'z') || (c >= '0' && c <= '9') || c == '+' || c == '/' || c == '=' {
+				b[kept] = c
+				kept++
+			}
+		}
+		if kept > 0 || err != nil {
+			return kept, err
+		}
+	}
+}
+
+func decodeTransferEncoding(r io.Reader, encoding string) io.Reader {
+	switch encoding {
+	case "base64":
+		return base64.NewDecoder(base64.StdEncoding, &base64Filter{r: r})
+	case "quoted-printable":
+		return quotedprintable.NewReader(r)
+	}
+	return r
+}
+
+// charsetReader convertit un flux vers UTF-8. Les jeux de caractères
+// inconnus sont transmis tels quels.
+func charsetReader(charset string, input io.Reader) io.Reader {
+	charset = strings.ToLower(strings.Trim(strings.TrimSpace(charset), `"`))
+	switch charset {
+	case "", "utf-8", "utf8", "us-ascii", "ascii":
+		return input
+	}
+	enc, err := htmlindex.Get(charset)
+	if err != nil {
+		return input
+	}
+	return enc.NewDecoder().Reader(input)
+}
+
+func wordDecoder() *mime.WordDecoder {
+	return &mime.WordDecoder{
+		CharsetReader: func(charset string, input io.Reader) (io.Reader, error) {
+			return charsetReader(charset, input), nil
+		},
+	}
+}
+
+func firstAddress(header string) *models.EmailAddress {
+	if header == "" {
+		return nil
+	}
+	parser := mail.AddressParser{WordDecoder: wordDecoder()}
+	addr, err := parser.Parse(header)
+	if err != nil {
+		return nil
+	}
+	return &models.EmailAddress{
+		Name:  addr.Name,
+		Email: addr.Address,
+	}
+}
+
+// decodeFilename décode un nom de fichier encodé en RFC 2047, ce que font
+// de nombreux clients malgré la RFC 2231, ou transmis en 8 bits bruts
+func decodeFilename(name string) string {
+	name = decodeHeader(name)
+	if !utf8.ValidString(name) {
+		name = ToUTF8(name, "windows-1252")
+	}
+	return SanitizeFilename(name)
+}
+
+// parseHeaderParams analyse un en-tête Content-Type ou Content-Disposition.
+// Les paramètres étendus RFC 2231 (continuations et jeux de caractères)
+// sont décodés, y compris les jeux de caractères ignorés par mime.ParseMediaType.
+func parseHeaderParams(value string) (string, map[string]string) {
+	if strings.TrimSpace(value) == "" {
+		return "", map[string]string{}
+	}
+
+	mediaType, params, err := mime.ParseMediaType(value)
+	if err != nil {
+		// Analyse tolérante des en-têtes mal formés
+		mediaType, params = "", map[string]string{}
+	}
+
+	tokens := splitParams(value)
+	if mediaType == "" {
+		mediaType = strings.ToLower(strings.TrimSpace(tokens[0]))
+	}
+
+	type section struct {
+		value   string
+		encoded bool
+	}
+	plain := map[string]string{}
+	sections := map[string]map[int]section{}
+	for _, token := range tokens[1:] {
+		key, val, ok := strings.Cut(token, "=")
+		if !ok {
+			continue
+		}
+		key = strings.ToLower(strings.TrimSpace(key))
+		val = unquoteParam(strings.TrimSpace(val))
+
+		encoded := strings.HasSuffix(key, "*")
+		key = strings.TrimSuffix(key, "*")
+		index := -1
+		if star := strings.LastIndex(key, "*"); star > 0 {
+			if n, err := strconv.Atoi(key[star+1:]); err == nil {
+				index = n
+				key = key[:star]
+			}
+		}
+
+		if index < 0 && !encoded {
+			if _, exists := plain[key]; !exists {
+				plain[key] = val
+			}
+			continue
+		}
+		if index < 0 {
+			index = 0
+		}
+		if sections[key] == nil {
+			sections[key] = map[int]section{}
+		}
+		sections[key][index] = section{value: val, encoded: encoded}
+	}
+
+	for key, value := range plain {
+		if _, exists := params[key]; !exists {
+			params[key] = value
+		}
+	}
+	for key, parts := range sections {
+		indexes := make([]int, 0, len(parts))
+		for index := range parts {
+			indexes = append(indexes, index)
+		}
+		sort.Ints(indexes)
+
+		charset := ""
+		var raw []byte
+		for i, index := range indexes {
+			if index != i {
+				break
+			}
+			sec := parts[index]
+			if !sec.encoded {
+				raw = append(raw, sec.value...)
+				continue
+			}
+			val := sec.value
+			if i == 0 {
+				// charset'langue'valeur
+				if first := strings.Index(val, "'"); first >= 0 {
+					if second := strings.Index(val[first+1:], "'"); second >= 0 {
+						charset = val[:first]
+						val = val[first+second+2:]
+					}
+				}
+			}
+			raw = append(raw, percentDecode(val)...)
+		}
+		// Le paramètre étendu prime sur sa version simple
+		params[key] = ToUTF8(string(raw), charset)
+	}
+
+	return mediaType, params
+}
+
+// splitParams découpe une valeur d'en-tête sur les points-virgules hors
+// des chaînes entre guillemets
+func splitParams(value string) []string {
+	tokens := []string{}
+	var current strings.Builder
+	quoted, escaped := false, false
+	for _, c := range value {
+		switch {
+		case escaped:
+			escaped = false
+		case c == '\\' && quoted:
+			escaped = true
+		case c == '"':
+			quoted = !quoted
+		case c == ';' && !quoted:
+			tokens = append(tokens, current.String())
+			current.Reset()
+			continue
+		}
+		current.WriteRune(c)
+	}
+	return append(tokens, current.String())
+}
+
+func unquoteParam(value string) string {
+	if len(value) < 2 || value[0] != '"' || value[len(value)-1] != '"' {
+		return value
+	}
+	value = value[1 : len(value)-1]
+	var buf strings.Builder
+	escaped := false
+	for _, c := range value {
+		if c == '\\' && !escaped {
+			escaped = true
+			continue
+		}
+		escaped = false
+		buf.WriteRune(c)
+	}
+	return buf.String()
+}
+
+func percentDecode(value string) []byte {
+	out := make([]byte, 0, len(value))
+	for i := 0; i < len(value); i++ {
+		if value[i] == '%' && i+2 < len(value) {
+			if b, err := hex.DecodeString(value[i+1 : i+3]); err == nil {
+				out = append(out, b[0])
+				i += 2
+				continue
+			}
+		}
+		out = append(out, value[i])
+	}
+	return out
+}
+
+func appendBody(existing, text string) string {
+	if existing == "" {
+		return text
+	}
+	if !strings.HasSuffix(existing, "\n") {
+		existing += "\n"
+	}
+	return existing + text
+}
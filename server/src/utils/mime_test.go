@@ -0,0 +1,348 @@
+package utils
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/skygenesisenterprise/aether-mailer/server/src/models"
+)
+
+// crlf remplace les fins de ligne d'un message écrit dans le test par CRLF
+func crlf(s string) string {
+	return strings.ReplaceAll(strings.TrimPrefix(s, "\n"), "\n", "\r\n")
+}
+
+// partTree aplatit l'arborescence MIME en « section:type »
+func partTree(part *models.BodyPart) []string {
+	if part == nil {
+		return nil
+	}
+	tree := []string{part.PartID + ":" + part.MimeType}
+	for _, child := range part.Parts {
+		tree = append(tree, partTree(child)...)
+	}
+	return tree
+}
+
+func attachmentNames(email *models.Email) []string {
+	names := []string{}
+	for _, attachment := range email.Attachments {
+		names = append(names, attachment.Filename)
+	}
+	return names
+}
+
+func TestParseEmailReader(t *testing.T) {
+	tests := []struct {
+		name        string
+		raw         string
+		subject     string
+		body        string
+		html        string
+		tree        []string
+		attachments []string
+	}{
+		{
+			name: "nested multipart",
+			raw: `
+From: Alice <alice@example.com>
+To: bob@example.com
+Subject: Report
+Content-Type: multipart/mixed; boundary="outer"
+
+--outer
+Content-Type: multipart/alternative; boundary="inner"
+
+--inner
+Content-Type: text/plain; charset=utf-8
+
+Plain body
+--inner
+Content-Type: multipart/related; boundary="related"
+
+--related
+Content-Type: text/html; charset=utf-8
+
+<p>HTML body</p>
+--related
+Content-Type: image/png
+Content-Id: <logo>
+Content-Transfer-Encoding: base64
+
+iVBORw0KGgo=
+--related--
+--inner--
+--outer
+Content-Type: application/pdf; name="report.pdf"
+Content-Disposition: attachment; filename="report.pdf"
+Content-Transfer-Encoding: base64
+
+JVBERi0xLjQK
+--outer--
+`,
+			subject: "Report",
+			body:    "Plain body",
+			html:    "<p>HTML body</p>",
+			tree: []string{
+				":multipart/mixed",
+				"1:multipart/alternative",
+				"1.1:text/plain",
+				"1.2:multipart/related",
+				"1.2.1:text/html",
+				"1.2.2:image/png",
+				"2:application/pdf",
+			},
+			attachments: []string{"unnamed", "report.pdf"},
+		},
+		{
+			name: "attached message",
+			raw: `
+From: alice@example.com
+Subject: Fwd
+Content-Type: multipart/mixed; boundary="b"
+
+--b
+Content-Type: text/plain
+
+See below
+--b
+Content-Type: message/rfc822
+
+From: carol@example.com
+Subject: Original
+Content-Type: multipart/mixed; boundary="c"
+
+--c
+Content-Type: text/plain
+
+Original body
+--c
+Content-Type: application/zip; name="inner.zip"
+
+UEsDBA==
+--c--
+--b--
+`,
+			subject: "Fwd",
+			body:    "See below",
+			tree: []string{
+				":multipart/mixed",
+				"1:text/plain",
+				"2:message/rfc822",
+				"2:multipart/mixed",
+				"2.1:text/plain",
+				"2.2:application/zip",
+			},
+			// Les pièces jointes du message joint restent dans son blob
+			attachments: []string{"Original.eml"},
+		},
+		{
+			name: "latin-1 quoted-printable body and encoded headers",
+			raw: `
+From: =?ISO-8859-1?Q?Andr=E9?= <andre@example.com>
+Subject: =?ISO-8859-1?Q?Caf=E9_cr=E8me?=
+Content-Type: multipart/mixed; boundary="b"
+
+--b
+Content-Type: text/plain; charset="ISO-8859-1"
+Content-Transfer-Encoding: quoted-printable
+
+Un caf=E9, s'il vous pla=EEt
+--b
+Content-Type: application/pdf
+Content-Disposition: attachment; filename*=utf-8''r%C3%A9sum%C3%A9.pdf
+
+%PDF
+--b--
+`,
+			subject: "Café crème",
+			body:    "Un café, s'il vous plaît",
+			tree: []string{
+				":multipart/mixed",
+				"1:text/plain",
+				"2:application/pdf",
+			},
+			attachments: []string{"résumé.pdf"},
+		},
+		{
+			name: "windows-1252 base64 body",
+			raw: `
+Subject: Euro
+Content-Type: text/plain; charset=windows-1252
+Content-Transfer-Encoding: base64
+
+gCAxMA==
+`,
+			subject: "Euro",
+			body:    "€ 10",
+			tree:    []string{"1:text/plain"},
+		},
+		{
+			name: "missing closing boundary",
+			raw: `
+Subject: Truncated
+Content-Type: multipart/mixed; boundary="b"
+
+--b
+Content-Type: text/plain
+
+First part
+--b
+Content-Type: text/html
+
+<p>Second part</p>
+`,
+			subject: "Truncated",
+			body:    "First part",
+			html:    "<p>Second part</p>",
+			tree: []string{
+				":multipart/mixed",
+				"1:text/plain",
+				"2:text/html",
+			},
+		},
+		{
+			name: "boundary that never appears",
+			raw: `
+Subject: Mismatch
+Content-Type: multipart/mixed; boundary="expected"
+
+--other
+Content-Type: text/plain
+
+Lost body
+--other--
+`,
+			subject: "Mismatch",
+			tree:    []string{":multipart/mixed"},
+		},
+		{
+			name: "multipart without a boundary",
+			raw: `
+Subject: No boundary
+Content-Type: multipart/mixed
+
+--b
+Content-Type: text/plain
+
+Body
+--b--
+`,
+			subject:     "No boundary",
+			tree:        []string{"1:multipart/mixed"},
+			attachments: []string{"unnamed"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			email, err := ParseEmailReader(strings.NewReader(crlf(tt.raw)), nil)
+			if err != nil {
+				t.Fatalf("ParseEmailReader: %v", err)
+			}
+			if email.Subject != tt.subject {
+				t.Errorf("Subject = %q, want %q", email.Subject, tt.subject)
+			}
+			if got := strings.TrimRight(email.Body, "\n"); got != tt.body {
+				t.Errorf("Body = %q, want %q", got, tt.body)
+			}
+			if got := strings.TrimRight(email.BodyHTML, "\n"); got != tt.html {
+				t.Errorf("BodyHTML = %q, want %q", got, tt.html)
+			}
+			if got := partTree(email.BodyStructure); strings.Join(got, " ") != strings.Join(tt.tree, " ") {
+				t.Errorf("BodyStructure = %v, want %v", got, tt.tree)
+			}
+			if tt.attachments == nil {
+				tt.attachments = []string{}
+			}
+			if got := attachmentNames(email); strings.Join(got, ",") != strings.Join(tt.attachments, ",") {
+				t.Errorf("Attachments = %v, want %v", got, tt.attachments)
+			}
+			if email.HasAttachments != (len(tt.attachments) > 0) {
+				t.Errorf("HasAttachments = %v", email.HasAttachments)
+			}
+		})
+	}
+}
+
+// recordingWriter garde le contenu reçu pour chaque pièce jointe
+type recordingWriter struct {
+	contents map[string][]byte
+	fail     string // nom de fichier dont le stockage échoue
+}
+
+func (w *recordingWriter) WriteAttachment(attachment *models.Attachment, content io.Reader) (string, error) {
+	data, err := io.ReadAll(content)
+	if err != nil {
+		return "", err
+	}
+	if attachment.Filename == w.fail {
+		return "", fmt.Errorf("disk full")
+	}
+	blobID := fmt.Sprintf("blob-%d", len(w.contents)+1)
+	w.contents[blobID] = data
+	return blobID, nil
+}
+
+func TestParseEmailReaderAttachmentChecksums(t *testing.T) {
+	raw := crlf(`
+Subject: Files
+Content-Type: multipart/mixed; boundary="b"
+
+--b
+Content-Type: text/plain
+
+Two files
+--b
+Content-Type: text/csv; name="data.csv"
+Content-Disposition: attachment; filename="data.csv"
+Content-Transfer-Encoding: quoted-printable
+
+prix,devise
+10,=E2=82=AC
+--b
+Content-Type: application/octet-stream
+Content-Disposition: attachment; filename="data.bin"
+Content-Transfer-Encoding: base64
+
+AAEC
+A/8=
+--b--
+`)
+	want := map[string][]byte{
+		"data.csv": []byte("prix,devise\r\n10,€"),
+		"data.bin": {0x00, 0x01, 0x02, 0x03, 0xff},
+	}
+
+	store := &recordingWriter{contents: map[string][]byte{}}
+	email, err := ParseEmailReader(strings.NewReader(raw), store)
+	if err != nil {
+		t.Fatalf("ParseEmailReader: %v", err)
+	}
+	if len(email.Attachments) != len(want) {
+		t.Fatalf("%d attachments, want %d", len(email.Attachments), len(want))
+	}
+	for _, attachment := range email.Attachments {
+		content := want[attachment.Filename]
+		sum := sha256.Sum256(content)
+		if attachment.Checksum != hex.EncodeToString(sum[:]) {
+			t.Errorf("%s checksum = %s, want the SHA-256 of the decoded content", attachment.Filename, attachment.Checksum)
+		}
+		if attachment.Size != int64(len(content)) {
+			t.Errorf("%s size = %d, want %d", attachment.Filename, attachment.Size, len(content))
+		}
+		if stored := store.contents[attachment.BlobID]; string(stored) != string(content) {
+			t.Errorf("%s stored as %q, want %q", attachment.Filename, stored, content)
+		}
+	}
+
+	// Un stockage en échec fait échouer l'analyse
+	store = &recordingWriter{contents: map[string][]byte{}, fail: "data.bin"}
+	if _, err := ParseEmailReader(strings.NewReader(raw), store); err == nil || !strings.Contains(err.Error(), "disk full") {
+		t.Errorf("ParseEmailReader with a failing store = %v, want the store error", err)
+	}
+}